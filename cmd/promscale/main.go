@@ -17,6 +17,19 @@ import (
 func main() {
 	log.InitDefault()
 	args := os.Args[1:]
+	if len(args) > 0 && args[0] == runner.RulesCommand {
+		os.Exit(runner.RunRulesCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == runner.ConfigCommand {
+		os.Exit(runner.RunConfigCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == runner.BenchCommand {
+		os.Exit(runner.RunBenchCommand(args[1:]))
+	}
+	isBackfill := len(args) > 0 && args[0] == runner.BackfillCommand
+	if isBackfill {
+		args = args[1:]
+	}
 	if shouldProceed := runner.ParseArgs(args); !shouldProceed {
 		os.Exit(0)
 	}
@@ -31,6 +44,12 @@ func main() {
 		fmt.Println(version.Info())
 		log.Fatal("msg", "cannot start logger", "err", err)
 	}
+	if isBackfill {
+		if err = runner.Backfill(cfg); err != nil {
+			log.Fatal("msg", "backfill failed", "err", err)
+		}
+		os.Exit(0)
+	}
 	err = runner.Run(cfg)
 	if err != nil {
 		os.Exit(1)
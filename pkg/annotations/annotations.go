@@ -0,0 +1,227 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package annotations stores annotations, like deploy markers or incidents,
+// in the database so that they can be queried next to the metrics. The model
+// follows the Grafana annotations HTTP API.
+package annotations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// DefaultLimit is the number of annotations returned when the query sets no
+// limit, like Grafana.
+const DefaultLimit = 100
+
+const (
+	insertSQL = `INSERT INTO _ps_catalog.annotations (time, time_end, dashboard_uid, dashboard_id, panel_id, text, tags)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	deleteSQL  = "DELETE FROM _ps_catalog.annotations WHERE id = $1"
+	selectSQL  = "SELECT id, time, time_end, dashboard_uid, dashboard_id, panel_id, text, tags, created, updated FROM _ps_catalog.annotations"
+	orderLimit = " ORDER BY time DESC, id DESC LIMIT "
+)
+
+// ErrNotFound is returned when the annotation to change does not exist.
+var ErrNotFound = errors.New("annotation not found")
+
+// Annotation is an event over a time range, or at a point in time when Time
+// and TimeEnd are equal. Times are Unix timestamps in milliseconds.
+type Annotation struct {
+	ID           int64    `json:"id"`
+	DashboardID  int64    `json:"dashboardId"`
+	DashboardUID string   `json:"dashboardUID"`
+	PanelID      int64    `json:"panelId"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags"`
+	Created      int64    `json:"created"`
+	Updated      int64    `json:"updated"`
+}
+
+// Patch holds the fields of an annotation to change. Nil fields are left
+// unchanged.
+type Patch struct {
+	Time    *int64
+	TimeEnd *int64
+	Text    *string
+	Tags    *[]string
+}
+
+// Query selects annotations. Zero values do not filter.
+type Query struct {
+	// From and To are Unix timestamps in milliseconds. The annotations
+	// overlapping the range are returned.
+	From         int64
+	To           int64
+	DashboardID  int64
+	DashboardUID string
+	PanelID      int64
+	Tags         []string
+	// MatchAny returns the annotations with any of the tags instead of all
+	// of them.
+	MatchAny bool
+	Limit    int
+}
+
+// Store reads and writes annotations.
+type Store struct {
+	conn pgxconn.PgxConn
+}
+
+// NewStore returns a Store using the connection.
+func NewStore(conn pgxconn.PgxConn) *Store {
+	return &Store{conn: conn}
+}
+
+// Create stores the annotation and returns its id.
+func (s *Store) Create(ctx context.Context, a Annotation) (int64, error) {
+	tags := a.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	var id int64
+	err := s.conn.QueryRow(ctx, insertSQL, fromMillis(a.Time), fromMillis(a.TimeEnd),
+		a.DashboardUID, a.DashboardID, a.PanelID, a.Text, tags).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("inserting annotation: %w", err)
+	}
+	return id, nil
+}
+
+// Update changes the fields set in the patch of the annotation.
+func (s *Store) Update(ctx context.Context, id int64, p Patch) error {
+	sql, args := buildUpdate(id, p)
+	res, err := s.conn.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("updating annotation: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes the annotation.
+func (s *Store) Delete(ctx context.Context, id int64) error {
+	res, err := s.conn.Exec(ctx, deleteSQL, id)
+	if err != nil {
+		return fmt.Errorf("deleting annotation: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Find returns the annotations selected by the query, most recent first.
+func (s *Store) Find(ctx context.Context, q Query) ([]Annotation, error) {
+	sql, args := buildFind(q)
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying annotations: %w", err)
+	}
+	defer rows.Close()
+	res := []Annotation{}
+	for rows.Next() {
+		var (
+			a                            Annotation
+			start, end, created, updated time.Time
+		)
+		if err := rows.Scan(&a.ID, &start, &end, &a.DashboardUID, &a.DashboardID, &a.PanelID,
+			&a.Text, &a.Tags, &created, &updated); err != nil {
+			return nil, fmt.Errorf("reading annotation: %w", err)
+		}
+		a.Time, a.TimeEnd = toMillis(start), toMillis(end)
+		a.Created, a.Updated = toMillis(created), toMillis(updated)
+		res = append(res, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading annotations: %w", err)
+	}
+	return res, nil
+}
+
+func buildUpdate(id int64, p Patch) (string, []interface{}) {
+	args := []interface{}{id}
+	set := []string{"updated = now()"}
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if p.Time != nil {
+		add("time", fromMillis(*p.Time))
+	}
+	if p.TimeEnd != nil {
+		add("time_end", fromMillis(*p.TimeEnd))
+	}
+	if p.Text != nil {
+		add("text", *p.Text)
+	}
+	if p.Tags != nil {
+		tags := *p.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		add("tags", tags)
+	}
+	return "UPDATE _ps_catalog.annotations SET " + strings.Join(set, ", ") + " WHERE id = $1", args
+}
+
+func buildFind(q Query) (string, []interface{}) {
+	var (
+		args  []interface{}
+		where []string
+	)
+	add := func(cond string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if q.From != 0 {
+		add("time_end >= $%d", fromMillis(q.From))
+	}
+	if q.To != 0 {
+		add("time <= $%d", fromMillis(q.To))
+	}
+	if q.DashboardUID != "" {
+		add("dashboard_uid = $%d", q.DashboardUID)
+	}
+	if q.DashboardID != 0 {
+		add("dashboard_id = $%d", q.DashboardID)
+	}
+	if q.PanelID != 0 {
+		add("panel_id = $%d", q.PanelID)
+	}
+	if len(q.Tags) > 0 {
+		if q.MatchAny {
+			add("tags && $%d::text[]", q.Tags)
+		} else {
+			add("tags @> $%d::text[]", q.Tags)
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	sql := selectSQL
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	return sql + orderLimit + fmt.Sprint(limit), args
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,100 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package annotations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFind(t *testing.T) {
+	from, to := int64(1000), int64(2000)
+	cases := []struct {
+		name string
+		q    Query
+		sql  string
+		args []interface{}
+	}{
+		{
+			name: "no filter",
+			sql:  selectSQL + orderLimit + "100",
+		},
+		{
+			name: "time range and limit",
+			q:    Query{From: from, To: to, Limit: 10},
+			sql:  selectSQL + " WHERE time_end >= $1 AND time <= $2" + orderLimit + "10",
+			args: []interface{}{fromMillis(from), fromMillis(to)},
+		},
+		{
+			name: "dashboard and panel",
+			q:    Query{DashboardUID: "abc", DashboardID: 2, PanelID: 3},
+			sql:  selectSQL + " WHERE dashboard_uid = $1 AND dashboard_id = $2 AND panel_id = $3" + orderLimit + "100",
+			args: []interface{}{"abc", int64(2), int64(3)},
+		},
+		{
+			name: "all tags",
+			q:    Query{Tags: []string{"deploy", "api"}},
+			sql:  selectSQL + " WHERE tags @> $1::text[]" + orderLimit + "100",
+			args: []interface{}{[]string{"deploy", "api"}},
+		},
+		{
+			name: "any tag",
+			q:    Query{To: to, Tags: []string{"deploy"}, MatchAny: true},
+			sql:  selectSQL + " WHERE time <= $1 AND tags && $2::text[]" + orderLimit + "100",
+			args: []interface{}{fromMillis(to), []string{"deploy"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args := buildFind(c.q)
+			require.Equal(t, c.sql, sql)
+			require.Equal(t, c.args, args)
+		})
+	}
+}
+
+func TestBuildUpdate(t *testing.T) {
+	ts, text, tags := int64(1000), "rollback", []string{"deploy"}
+	var noTags []string
+	cases := []struct {
+		name string
+		p    Patch
+		sql  string
+		args []interface{}
+	}{
+		{
+			name: "empty patch",
+			sql:  "UPDATE _ps_catalog.annotations SET updated = now() WHERE id = $1",
+			args: []interface{}{int64(7)},
+		},
+		{
+			name: "all fields",
+			p:    Patch{Time: &ts, TimeEnd: &ts, Text: &text, Tags: &tags},
+			sql:  "UPDATE _ps_catalog.annotations SET updated = now(), time = $2, time_end = $3, text = $4, tags = $5 WHERE id = $1",
+			args: []interface{}{int64(7), fromMillis(ts), fromMillis(ts), text, tags},
+		},
+		{
+			name: "clear tags",
+			p:    Patch{Tags: &noTags},
+			sql:  "UPDATE _ps_catalog.annotations SET updated = now(), tags = $2 WHERE id = $1",
+			args: []interface{}{int64(7), []string{}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args := buildUpdate(7, c.p)
+			require.Equal(t, c.sql, sql)
+			require.Equal(t, c.args, args)
+		})
+	}
+}
+
+func TestMillis(t *testing.T) {
+	ts := time.Date(2022, 9, 1, 12, 30, 0, 123000000, time.UTC)
+	require.Equal(t, ts, fromMillis(toMillis(ts)))
+	require.Equal(t, int64(1662035400123), toMillis(ts))
+}
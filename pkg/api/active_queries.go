@@ -0,0 +1,148 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+)
+
+// activeQueries tracks the queries being served so that they can be listed
+// and canceled.
+type activeQueries struct {
+	mu      sync.Mutex
+	nextID  uint64
+	queries map[uint64]*activeQuery
+}
+
+type activeQuery struct {
+	id       uint64
+	endpoint string
+	expr     string
+	tenant   string
+	started  time.Time
+	cancel   context.CancelFunc
+	memory   *querier.QueryMemory
+}
+
+type activeQueryResult struct {
+	ID              string    `json:"id"`
+	Endpoint        string    `json:"endpoint"`
+	Expr            string    `json:"expr,omitempty"`
+	Tenant          string    `json:"tenant,omitempty"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	MemoryBytes     int64     `json:"peakMemoryBytes"`
+}
+
+func newActiveQueries() *activeQueries {
+	return &activeQueries{queries: make(map[uint64]*activeQuery)}
+}
+
+// track registers a query until the returned function is called.
+func (a *activeQueries) track(q *activeQuery) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextID++
+	q.id = a.nextID
+	a.queries[q.id] = q
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.queries, q.id)
+	}
+}
+
+func (a *activeQueries) list() []activeQueryResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	res := make([]activeQueryResult, 0, len(a.queries))
+	for _, q := range a.queries {
+		res = append(res, activeQueryResult{
+			ID:              strconv.FormatUint(q.id, 10),
+			Endpoint:        q.endpoint,
+			Expr:            q.expr,
+			Tenant:          q.tenant,
+			Started:         q.started.UTC(),
+			DurationSeconds: now.Sub(q.started).Seconds(),
+			MemoryBytes:     q.memory.Peak(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+	return res
+}
+
+// cancel cancels a query and returns false if it is not running.
+func (a *activeQueries) cancel(id uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	q, ok := a.queries[id]
+	if ok {
+		q.cancel()
+	}
+	return ok
+}
+
+// withActiveQueries registers the queries served by the handler while they
+// run.
+func withActiveQueries(queries *activeQueries, endpoint string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		q := &activeQuery{
+			endpoint: endpoint,
+			expr:     r.FormValue("query"),
+			tenant:   getLimitedTenant(r),
+			started:  time.Now(),
+			cancel:   cancel,
+			memory:   querier.QueryMemoryFromContext(ctx),
+		}
+		defer queries.track(q)()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ActiveQueries lists the PromQL and remote read queries being served. It
+// requires the admin API or the admin UI.
+func ActiveQueries(conf *Config, queries *activeQueries) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminStatus(w, conf) {
+			return
+		}
+		respond(w, http.StatusOK, queries.list())
+	})
+	return gziphandler.GzipHandler(hf)
+}
+
+// CancelQuery cancels a query being served. It requires the admin API.
+func CancelQuery(conf *Config, queries *activeQueries) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("canceling queries requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid query id: %w", err), "bad_data")
+			return
+		}
+		if !queries.cancel(id) {
+			respondError(w, http.StatusNotFound, fmt.Errorf("query %d is not running", id), "not_found")
+			return
+		}
+		respond(w, http.StatusOK, nil)
+	})
+	return gziphandler.GzipHandler(hf)
+}
@@ -0,0 +1,70 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveQueries(t *testing.T) {
+	queries := newActiveQueries()
+	router := mux.NewRouter()
+	router.Path("/api/v1/status/queries").Handler(ActiveQueries(&Config{AdminUIEnabled: true}, queries))
+	router.Path("/api/v1/admin/queries/{id}").Handler(CancelQuery(&Config{AdminAPIEnabled: true}, queries))
+
+	started, canceled := make(chan struct{}), make(chan struct{})
+	handler := withActiveQueries(queries, "query", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	<-started
+
+	list := queries.list()
+	require.Len(t, list, 1)
+	require.Equal(t, "query", list[0].Endpoint)
+	require.Equal(t, "up", list[0].Expr)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/queries", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"expr":"up"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/admin/queries/"+list[0].ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	<-canceled
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/admin/queries/42", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	ActiveQueries(&Config{}, queries).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/queries", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	CancelQuery(&Config{}, queries).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/admin/queries/1", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminUI(t *testing.T) {
+	w := httptest.NewRecorder()
+	AdminUI(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	AdminUI(&Config{AdminUIEnabled: true}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	require.Contains(t, w.Body.String(), "/api/v1/status/caches")
+}
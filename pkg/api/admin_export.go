@@ -0,0 +1,89 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/export"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// AdminExport exports the series matching the match[] selectors between start
+// and end as Prometheus TSDB blocks or OpenMetrics text, on local disk or in
+// S3.
+func AdminExport(conf *Config, queryable promql.Queryable) http.Handler {
+	hf := corsWrapper(conf, adminExportHandler(conf, queryable))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminExportHandler(conf *Config, queryable promql.Queryable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("exporting data requires admin permissions. Use -web.enable-admin-api flag to allow exports"), "operation_not_permitted")
+			return
+		}
+		req, err := parseExportRequest(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if queryable == nil {
+			respond(w, http.StatusOK, export.Result{Format: req.Format, Destination: req.Destination})
+			return
+		}
+		res, err := export.NewExporter(conf.ExportCfg, queryable).Export(r.Context(), req)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "export")
+			return
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
+
+func parseExportRequest(r *http.Request) (export.Request, error) {
+	var req export.Request
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	if len(r.Form["match[]"]) == 0 {
+		return req, fmt.Errorf("no match[] parameter provided")
+	}
+	// Unlike deletions, exports need a bounded time range since the data is
+	// read and written out.
+	if r.FormValue("start") == "" || r.FormValue("end") == "" {
+		return req, fmt.Errorf("start and end parameters are required")
+	}
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return req, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return req, fmt.Errorf("invalid end: %w", err)
+	}
+	if end.Before(start) {
+		return req, fmt.Errorf("end timestamp must not be before start time")
+	}
+	req.Start, req.End = timestamp.FromTime(start), timestamp.FromTime(end)
+	if req.Format, err = export.ParseFormat(r.FormValue("format")); err != nil {
+		return req, err
+	}
+	if req.Destination, err = export.ParseDestination(r.FormValue("destination")); err != nil {
+		return req, err
+	}
+	for _, s := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return req, err
+		}
+		req.MatcherSets = append(req.MatcherSets, matchers)
+	}
+	return req, nil
+}
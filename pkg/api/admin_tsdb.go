@@ -0,0 +1,171 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	deletePkg "github.com/timescale/promscale/pkg/pgmodel/delete"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+type deleteSeriesResult struct {
+	DryRun      bool     `json:"dryRun"`
+	Metrics     []string `json:"metrics"`
+	NumSeries   int      `json:"numSeries"`
+	RowsDeleted int      `json:"rowsDeleted"`
+}
+
+type cleanTombstonesResult struct {
+	Removed   int64 `json:"removed"`
+	Remaining int64 `json:"remaining"`
+}
+
+// AdminDeleteSeries deletes the data of the series matching the match[]
+// selectors between start and end, like the Prometheus admin API. With
+// dry_run=true it only returns the metrics and number of series that would be
+// deleted.
+func AdminDeleteSeries(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminDeleteSeriesHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminDeleteSeriesHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminAPI(w, conf) {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if len(r.Form["match[]"]) == 0 {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("no match[] parameter provided"), "bad_data")
+			return
+		}
+		start, err := parseTimeParam(r, "start", model.MinTime)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		end, err := parseTimeParam(r, "end", model.MaxTime)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if end.Before(start) {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("end timestamp must not be before start time"), "bad_data")
+			return
+		}
+		dryRun := false
+		if v := r.FormValue("dry_run"); v != "" {
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run: %w", err), "bad_data")
+				return
+			}
+		}
+		matcherSets := make([][]*labels.Matcher, 0, len(r.Form["match[]"]))
+		for _, s := range r.Form["match[]"] {
+			matchers, err := parser.ParseMetricSelector(s)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err, "bad_data")
+				return
+			}
+			matcherSets = append(matcherSets, matchers)
+		}
+		if client == nil {
+			respond(w, http.StatusOK, deleteSeriesResult{DryRun: dryRun, Metrics: []string{}})
+			return
+		}
+
+		var (
+			res            = deleteSeriesResult{DryRun: dryRun}
+			metricsTouched []string
+			seriesTouched  []model.SeriesID
+			pgDelete       = deletePkg.PgDelete{Conn: client.ReadOnlyConnection()}
+		)
+		for _, matchers := range matcherSets {
+			var (
+				metrics   []string
+				seriesIDs []model.SeriesID
+				rows      int
+			)
+			if dryRun {
+				metrics, seriesIDs, err = pgDelete.CountSeries(r.Context(), matchers)
+			} else {
+				metrics, seriesIDs, rows, err = pgDelete.DeleteSeries(r.Context(), matchers, start, end)
+			}
+			metricsTouched = append(metricsTouched, metrics...)
+			seriesTouched = append(seriesTouched, seriesIDs...)
+			if rows > 0 {
+				res.RowsDeleted += rows
+			}
+			if err != nil {
+				respondErrorWithMessage(w, http.StatusInternalServerError, err, "deleting_series",
+					fmt.Sprintf("partial delete: deleted %d series from %v metrics, affecting %d rows in total.",
+						len(distinctValues(seriesTouched)),
+						distinctValues(metricsTouched),
+						res.RowsDeleted,
+					),
+				)
+				return
+			}
+		}
+		res.Metrics = distinctValues(metricsTouched)
+		res.NumSeries = len(distinctValues(seriesTouched))
+		if !dryRun {
+			log.Info("msg", "Deleted series", "matchers", fmt.Sprint(r.Form["match[]"]), "start", start, "end", end,
+				"metrics", len(res.Metrics), "series", res.NumSeries, "rows", res.RowsDeleted)
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
+
+// CleanTombstones removes the tombstoned series from the catalog, like the
+// Prometheus admin API.
+func CleanTombstones(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, cleanTombstonesHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func cleanTombstonesHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminAPI(w, conf) {
+			return
+		}
+		if client == nil {
+			respond(w, http.StatusOK, cleanTombstonesResult{})
+			return
+		}
+		pgDelete := deletePkg.PgDelete{Conn: client.ReadOnlyConnection()}
+		before, after, err := pgDelete.CleanTombstones(r.Context())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "clean_tombstones")
+			return
+		}
+		respond(w, http.StatusOK, cleanTombstonesResult{Removed: before - after, Remaining: after})
+	}
+}
+
+// checkAdminAPI responds with an error and returns false if the admin API
+// cannot be used.
+func checkAdminAPI(w http.ResponseWriter, conf *Config) bool {
+	if conf.ReadOnly {
+		respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot perform deletion"), "operation_not_permitted")
+		return false
+	}
+	if !conf.AdminAPIEnabled {
+		respondError(w, http.StatusForbidden, fmt.Errorf("deletion of series requires admin permissions. Use -web.enable-admin-api flag to allow deletion operations"), "operation_not_permitted")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminDeleteSeries(t *testing.T) {
+	cases := []struct {
+		name         string
+		config       Config
+		matchers     []string
+		start        string
+		end          string
+		dryRun       string
+		expectedCode int
+		message      string
+	}{
+		{
+			name:         "admin api disabled",
+			config:       Config{},
+			matchers:     []string{`{__name__="up"}`},
+			expectedCode: http.StatusForbidden,
+			message:      "deletion of series requires admin permissions. Use -web.enable-admin-api flag to allow deletion operations",
+		},
+		{
+			name:         "read only",
+			config:       Config{ReadOnly: true, AdminAPIEnabled: true},
+			matchers:     []string{`{__name__="up"}`},
+			expectedCode: http.StatusForbidden,
+			message:      "read-only connector cannot perform deletion",
+		},
+		{
+			name:         "no matchers",
+			config:       Config{AdminAPIEnabled: true},
+			expectedCode: http.StatusBadRequest,
+			message:      "no match[] parameter provided",
+		},
+		{
+			name:         "invalid matcher",
+			config:       Config{AdminAPIEnabled: true},
+			matchers:     []string{`{__name__=}`},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "end before start",
+			config:       Config{AdminAPIEnabled: true},
+			matchers:     []string{`{__name__="up"}`},
+			start:        "1604311719",
+			end:          "1604311711",
+			expectedCode: http.StatusBadRequest,
+			message:      "end timestamp must not be before start time",
+		},
+		{
+			name:         "invalid dry run",
+			config:       Config{AdminAPIEnabled: true},
+			matchers:     []string{`{__name__="up"}`},
+			dryRun:       "maybe",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "time range",
+			config:       Config{AdminAPIEnabled: true},
+			matchers:     []string{`{__name__="up"}`, `{job="prometheus"}`},
+			start:        "1604311711",
+			end:          "1604311719",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "dry run",
+			config:       Config{AdminAPIEnabled: true},
+			matchers:     []string{`{__name__="up"}`},
+			dryRun:       "true",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := tc.config
+			handler := adminDeleteSeriesHandler(&config, nil)
+			vals := constructRequestValues(tc.start, tc.end, tc.matchers)
+			if tc.dryRun != "" {
+				vals.Add("dry_run", tc.dryRun)
+			}
+			resp := doPostDeleteRequest(t, handler, vals)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedCode, resp.StatusCode)
+			if tc.message == "" {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			var errMessage errResponse
+			require.NoError(t, json.Unmarshal(body, &errMessage))
+			require.Equal(t, tc.message, errMessage.Error)
+		})
+	}
+}
+
+func TestCleanTombstones(t *testing.T) {
+	handler := cleanTombstonesHandler(&Config{}, nil)
+	resp := doPostDeleteRequest(t, handler, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	handler = cleanTombstonesHandler(&Config{AdminAPIEnabled: true}, nil)
+	resp = doPostDeleteRequest(t, handler, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
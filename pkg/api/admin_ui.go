@@ -0,0 +1,31 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+)
+
+// adminUI is a single page showing the state of the connector, with buttons
+// calling the admin API, for operators without Grafana.
+//
+//go:embed ui/index.html
+var adminUI []byte
+
+// AdminUI serves the admin UI.
+func AdminUI(conf *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conf.AdminUIEnabled {
+			err := fmt.Errorf("admin UI is disabled. To enable, start Promscale with '-web.enable-admin-ui' flag")
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(adminUI)
+	}
+}
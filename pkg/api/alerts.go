@@ -5,10 +5,19 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	prom_rules "github.com/prometheus/prometheus/rules"
 )
 
 func Alerts(conf *Config, updateMetrics func(handler, code string, duration float64)) http.Handler {
@@ -44,3 +53,126 @@ func alertsHandler(apiConf *Config, updateMetrics func(handler, code string, dur
 		respond(w, http.StatusOK, res)
 	}
 }
+
+// alertmanagerAlert is an alert in the format of the Alertmanager API v2,
+// so that the tools reading the alerts from Alertmanager can read the alerts
+// of the Promscale rules.
+type alertmanagerAlert struct {
+	Labels       model.LabelSet          `json:"labels"`
+	Annotations  model.LabelSet          `json:"annotations"`
+	StartsAt     time.Time               `json:"startsAt"`
+	EndsAt       time.Time               `json:"endsAt"`
+	UpdatedAt    time.Time               `json:"updatedAt"`
+	GeneratorURL string                  `json:"generatorURL"`
+	Fingerprint  string                  `json:"fingerprint"`
+	Receivers    []alertmanagerReceiver  `json:"receivers"`
+	Status       alertmanagerAlertStatus `json:"status"`
+}
+
+type alertmanagerReceiver struct {
+	Name string `json:"name"`
+}
+
+type alertmanagerAlertStatus struct {
+	State       string   `json:"state"`
+	SilencedBy  []string `json:"silencedBy"`
+	InhibitedBy []string `json:"inhibitedBy"`
+}
+
+func AlertmanagerAlerts(conf *Config) http.Handler {
+	hf := corsWrapper(conf, alertmanagerAlertsHandler(conf))
+	return gziphandler.GzipHandler(hf)
+}
+
+// alertmanagerAlertsHandler serves GET /api/v2/alerts of Alertmanager. Only
+// the firing alerts are returned, as only they are sent to Alertmanager.
+// Promscale has no silences, inhibitions nor receivers, so every alert is
+// active.
+func alertmanagerAlertsHandler(apiConf *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		matchers, err := parseAlertmanagerFilter(query["filter"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		active := true
+		if v := query.Get("active"); v != "" {
+			if active, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid active parameter: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		res := []alertmanagerAlert{}
+		if apiConf.Rules != nil && active && query.Get("receiver") == "" {
+			for _, rule := range apiConf.Rules.AlertingRules() {
+				updatedAt := rule.GetEvaluationTimestamp()
+				for _, a := range rule.ActiveAlerts() {
+					if a.State == prom_rules.StateFiring && matchesAll(matchers, a.Labels) {
+						res = append(res, toAlertmanagerAlert(a, updatedAt))
+					}
+				}
+			}
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Fingerprint < res[j].Fingerprint })
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// parseAlertmanagerFilter parses the matchers of the filter parameters,
+// e.g. alertname="Down" or {severity=~"critical|page"}.
+func parseAlertmanagerFilter(filters []string) ([]*labels.Matcher, error) {
+	var matchers []*labels.Matcher
+	for _, f := range filters {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(f, "{") {
+			f = "{" + f + "}"
+		}
+		ms, err := parser.ParseMetricSelector(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", f, err)
+		}
+		matchers = append(matchers, ms...)
+	}
+	return matchers, nil
+}
+
+func matchesAll(matchers []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func toAlertmanagerAlert(a *prom_rules.Alert, updatedAt time.Time) alertmanagerAlert {
+	lset := labelsToLabelSet(a.Labels)
+	return alertmanagerAlert{
+		Labels:       lset,
+		Annotations:  labelsToLabelSet(a.Annotations),
+		StartsAt:     a.FiredAt,
+		EndsAt:       a.ValidUntil,
+		UpdatedAt:    updatedAt,
+		GeneratorURL: "",
+		Fingerprint:  lset.Fingerprint().String(),
+		Receivers:    []alertmanagerReceiver{},
+		Status: alertmanagerAlertStatus{
+			State:       "active",
+			SilencedBy:  []string{},
+			InhibitedBy: []string{},
+		},
+	}
+}
+
+func labelsToLabelSet(lset labels.Labels) model.LabelSet {
+	res := make(model.LabelSet, len(lset))
+	for _, l := range lset {
+		res[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return res
+}
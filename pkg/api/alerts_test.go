@@ -0,0 +1,76 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	prom_rules "github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanagerAlerts(t *testing.T) {
+	matchers, err := parseAlertmanagerFilter([]string{`alertname="Down"`, `{severity=~"critical|page"}`})
+	require.NoError(t, err)
+	require.Len(t, matchers, 2)
+	require.True(t, matchesAll(matchers, labels.FromStrings("alertname", "Down", "severity", "page")))
+	require.False(t, matchesAll(matchers, labels.FromStrings("alertname", "Down", "severity", "info")))
+	_, err = parseAlertmanagerFilter([]string{`alertname=`})
+	require.Error(t, err)
+
+	firedAt := time.Unix(100, 0).UTC()
+	a := toAlertmanagerAlert(&prom_rules.Alert{
+		State:       prom_rules.StateFiring,
+		Labels:      labels.FromStrings("alertname", "Down"),
+		Annotations: labels.FromStrings("summary", "down"),
+		FiredAt:     firedAt,
+		ValidUntil:  firedAt.Add(time.Minute),
+	}, firedAt)
+	res, err := json.Marshal(a)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"labels": {"alertname": "Down"},
+		"annotations": {"summary": "down"},
+		"startsAt": "1970-01-01T00:01:40Z",
+		"endsAt": "1970-01-01T00:02:40Z",
+		"updatedAt": "1970-01-01T00:01:40Z",
+		"generatorURL": "",
+		"fingerprint": "`+a.Fingerprint+`",
+		"receivers": [],
+		"status": {"state": "active", "silencedBy": [], "inhibitedBy": []}
+	}`, string(res))
+	require.Len(t, a.Fingerprint, 16)
+
+	// Without rules, there is no alert.
+	w := httptest.NewRecorder()
+	alertmanagerAlertsHandler(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/alerts", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `[]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	alertmanagerAlertsHandler(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/alerts?active=maybe", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRulesFilter(t *testing.T) {
+	f := newRulesFilter(url.Values{})
+	require.True(t, f.matchesGroup("group", "file.yml"))
+	require.True(t, f.matchesRule("rule"))
+
+	f = newRulesFilter(url.Values{"rule_group[]": {"a", "b"}, "rule_name[]": {"up"}})
+	require.True(t, f.matchesGroup("a", "file.yml"))
+	require.False(t, f.matchesGroup("c", "file.yml"))
+	require.True(t, f.matchesRule("up"))
+	require.False(t, f.matchesRule("down"))
+
+	f = newRulesFilter(url.Values{"file[]": {"rules.yml"}})
+	require.False(t, f.matchesGroup("a", "file.yml"))
+}
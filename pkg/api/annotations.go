@@ -0,0 +1,271 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/annotations"
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// annotationStore is implemented by annotations.Store.
+type annotationStore interface {
+	Create(ctx context.Context, a annotations.Annotation) (int64, error)
+	Update(ctx context.Context, id int64, p annotations.Patch) error
+	Delete(ctx context.Context, id int64) error
+	Find(ctx context.Context, q annotations.Query) ([]annotations.Annotation, error)
+}
+
+// annotationRequest is the body of the requests creating or changing an
+// annotation, as sent to the Grafana annotations API.
+type annotationRequest struct {
+	DashboardUID string    `json:"dashboardUID"`
+	DashboardID  int64     `json:"dashboardId"`
+	PanelID      int64     `json:"panelId"`
+	Time         *int64    `json:"time"`
+	TimeEnd      *int64    `json:"timeEnd"`
+	Text         *string   `json:"text"`
+	Tags         *[]string `json:"tags"`
+}
+
+type annotationMessage struct {
+	Message string `json:"message"`
+	ID      int64  `json:"id,omitempty"`
+}
+
+// Annotations lists and creates annotations. It follows the Grafana
+// annotations HTTP API, so that the tools sending deploy markers or incidents
+// to Grafana can send them to Promscale.
+func Annotations(conf *Config, store annotationStore) http.Handler {
+	hf := corsWrapper(conf, annotationsHandler(conf, store))
+	return gziphandler.GzipHandler(hf)
+}
+
+// Annotation updates, patches or deletes the annotation with the id of the
+// path.
+func Annotation(conf *Config, store annotationStore) http.Handler {
+	hf := corsWrapper(conf, annotationHandler(conf, store))
+	return gziphandler.GzipHandler(hf)
+}
+
+func annotationsHandler(conf *Config, store annotationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			findAnnotations(w, r, store)
+			return
+		}
+		if !checkAnnotationWrite(w, conf) {
+			return
+		}
+		req, err := decodeAnnotationRequest(r)
+		if err != nil {
+			respondAnnotation(w, http.StatusBadRequest, annotationMessage{Message: err.Error()})
+			return
+		}
+		a, err := req.annotation(time.Now())
+		if err != nil {
+			respondAnnotation(w, http.StatusBadRequest, annotationMessage{Message: err.Error()})
+			return
+		}
+		id, err := store.Create(r.Context(), a)
+		if err != nil {
+			log.Error("msg", "failed to create annotation", "err", err)
+			respondAnnotation(w, http.StatusInternalServerError, annotationMessage{Message: "Failed to save annotation"})
+			return
+		}
+		respondAnnotation(w, http.StatusOK, annotationMessage{Message: "Annotation added", ID: id})
+	}
+}
+
+func annotationHandler(conf *Config, store annotationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAnnotationWrite(w, conf) {
+			return
+		}
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			respondAnnotation(w, http.StatusBadRequest, annotationMessage{Message: "invalid annotation id"})
+			return
+		}
+
+		var message string
+		switch r.Method {
+		case http.MethodDelete:
+			err = store.Delete(r.Context(), id)
+			message = "Annotation deleted"
+		case http.MethodPut:
+			var req annotationRequest
+			if req, err = decodeAnnotationRequest(r); err != nil {
+				break
+			}
+			var a annotations.Annotation
+			if a, err = req.annotation(time.Now()); err != nil {
+				break
+			}
+			err = store.Update(r.Context(), id, annotations.Patch{Time: &a.Time, TimeEnd: &a.TimeEnd, Text: &a.Text, Tags: &a.Tags})
+			message = "Annotation updated"
+		default:
+			var req annotationRequest
+			if req, err = decodeAnnotationRequest(r); err != nil {
+				break
+			}
+			if req.Time != nil && req.TimeEnd != nil && *req.TimeEnd < *req.Time {
+				err = badAnnotationError("timeEnd must not be before time")
+				break
+			}
+			err = store.Update(r.Context(), id, annotations.Patch{Time: req.Time, TimeEnd: req.TimeEnd, Text: req.Text, Tags: req.Tags})
+			message = "Annotation patched"
+		}
+
+		var badRequest badAnnotationError
+		switch {
+		case err == nil:
+			respondAnnotation(w, http.StatusOK, annotationMessage{Message: message})
+		case errors.As(err, &badRequest):
+			respondAnnotation(w, http.StatusBadRequest, annotationMessage{Message: err.Error()})
+		case errors.Is(err, annotations.ErrNotFound):
+			respondAnnotation(w, http.StatusNotFound, annotationMessage{Message: "Annotation not found"})
+		default:
+			log.Error("msg", "failed to change annotation", "id", id, "err", err)
+			respondAnnotation(w, http.StatusInternalServerError, annotationMessage{Message: "Failed to update annotation"})
+		}
+	}
+}
+
+func findAnnotations(w http.ResponseWriter, r *http.Request, store annotationStore) {
+	q, err := parseAnnotationQuery(r)
+	if err != nil {
+		respondAnnotation(w, http.StatusBadRequest, annotationMessage{Message: err.Error()})
+		return
+	}
+	// Alerts are not stored as annotations.
+	if r.FormValue("type") == "alert" {
+		respondAnnotation(w, http.StatusOK, []annotations.Annotation{})
+		return
+	}
+	res, err := store.Find(r.Context(), q)
+	if err != nil {
+		log.Error("msg", "failed to find annotations", "err", err)
+		respondAnnotation(w, http.StatusInternalServerError, annotationMessage{Message: "Failed to get annotations"})
+		return
+	}
+	respondAnnotation(w, http.StatusOK, res)
+}
+
+func parseAnnotationQuery(r *http.Request) (annotations.Query, error) {
+	var q annotations.Query
+	if err := r.ParseForm(); err != nil {
+		return q, err
+	}
+	ints := []struct {
+		name  string
+		value *int64
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+		{"dashboardId", &q.DashboardID},
+		{"panelId", &q.PanelID},
+	}
+	for _, p := range ints {
+		v := r.FormValue(p.name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %w", p.name, err)
+		}
+		*p.value = i
+	}
+	if v := r.FormValue("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+		q.Limit = limit
+	}
+	if v := r.FormValue("matchAny"); v != "" {
+		matchAny, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid matchAny: %w", err)
+		}
+		q.MatchAny = matchAny
+	}
+	q.DashboardUID = r.FormValue("dashboardUID")
+	q.Tags = r.Form["tags"]
+	return q, nil
+}
+
+type badAnnotationError string
+
+func (e badAnnotationError) Error() string { return string(e) }
+
+func decodeAnnotationRequest(r *http.Request) (annotationRequest, error) {
+	var req annotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, badAnnotationError(fmt.Sprintf("invalid annotation: %s", err))
+	}
+	return req, nil
+}
+
+// annotation returns the annotation to store. Like Grafana, the time defaults
+// to now and the end time to the time.
+func (req annotationRequest) annotation(now time.Time) (annotations.Annotation, error) {
+	a := annotations.Annotation{
+		DashboardUID: req.DashboardUID,
+		DashboardID:  req.DashboardID,
+		PanelID:      req.PanelID,
+		Time:         now.UnixNano() / int64(time.Millisecond),
+		Tags:         []string{},
+	}
+	if req.Text == nil || *req.Text == "" {
+		return a, badAnnotationError("text field should not be empty")
+	}
+	a.Text = *req.Text
+	if req.Time != nil && *req.Time != 0 {
+		a.Time = *req.Time
+	}
+	a.TimeEnd = a.Time
+	if req.TimeEnd != nil && *req.TimeEnd != 0 {
+		a.TimeEnd = *req.TimeEnd
+	}
+	if a.TimeEnd < a.Time {
+		return a, badAnnotationError("timeEnd must not be before time")
+	}
+	if req.Tags != nil && *req.Tags != nil {
+		a.Tags = *req.Tags
+	}
+	return a, nil
+}
+
+// checkAnnotationWrite responds with an error and returns false if the
+// annotations cannot be changed.
+func checkAnnotationWrite(w http.ResponseWriter, conf *Config) bool {
+	if conf.ReadOnly {
+		respondAnnotation(w, http.StatusForbidden, annotationMessage{Message: "read-only connector cannot change annotations"})
+		return false
+	}
+	return true
+}
+
+// respondAnnotation writes the response without the Prometheus API envelope,
+// like Grafana.
+func respondAnnotation(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("msg", "error writing annotations response", "err", err)
+	}
+}
@@ -0,0 +1,203 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/annotations"
+)
+
+type mockAnnotationStore struct {
+	created []annotations.Annotation
+	patches map[int64]annotations.Patch
+	query   annotations.Query
+}
+
+func (m *mockAnnotationStore) Create(_ context.Context, a annotations.Annotation) (int64, error) {
+	m.created = append(m.created, a)
+	return int64(len(m.created)), nil
+}
+
+func (m *mockAnnotationStore) Update(_ context.Context, id int64, p annotations.Patch) error {
+	if id > int64(len(m.created)) {
+		return annotations.ErrNotFound
+	}
+	m.patches[id] = p
+	return nil
+}
+
+func (m *mockAnnotationStore) Delete(_ context.Context, id int64) error {
+	if id > int64(len(m.created)) {
+		return annotations.ErrNotFound
+	}
+	return nil
+}
+
+func (m *mockAnnotationStore) Find(_ context.Context, q annotations.Query) ([]annotations.Annotation, error) {
+	m.query = q
+	return m.created, nil
+}
+
+func TestAnnotations(t *testing.T) {
+	cases := []struct {
+		name         string
+		config       Config
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expected     string
+	}{
+		{
+			name:         "create",
+			method:       http.MethodPost,
+			path:         "/api/annotations",
+			body:         `{"dashboardUID":"abc","panelId":2,"time":1000,"timeEnd":2000,"tags":["deploy"],"text":"v1.2.3"}`,
+			expectedCode: http.StatusOK,
+			expected:     `{"message":"Annotation added","id":2}`,
+		},
+		{
+			name:         "create without text",
+			method:       http.MethodPost,
+			path:         "/api/annotations",
+			body:         `{"time":1000}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     `{"message":"text field should not be empty"}`,
+		},
+		{
+			name:         "create ending before start",
+			method:       http.MethodPost,
+			path:         "/api/annotations",
+			body:         `{"time":2000,"timeEnd":1000,"text":"incident"}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     `{"message":"timeEnd must not be before time"}`,
+		},
+		{
+			name:         "create in read-only mode",
+			config:       Config{ReadOnly: true},
+			method:       http.MethodPost,
+			path:         "/api/annotations",
+			body:         `{"text":"incident"}`,
+			expectedCode: http.StatusForbidden,
+			expected:     `{"message":"read-only connector cannot change annotations"}`,
+		},
+		{
+			name:         "find",
+			method:       http.MethodGet,
+			path:         "/api/annotations?from=1000&to=2000&tags=deploy",
+			expectedCode: http.StatusOK,
+			expected:     `[{"id":0,"dashboardId":0,"dashboardUID":"","panelId":0,"time":1000,"timeEnd":1000,"text":"deploy","tags":[],"created":0,"updated":0}]`,
+		},
+		{
+			name:         "find alerts",
+			method:       http.MethodGet,
+			path:         "/api/annotations?type=alert",
+			expectedCode: http.StatusOK,
+			expected:     `[]`,
+		},
+		{
+			name:         "find with invalid limit",
+			method:       http.MethodGet,
+			path:         "/api/annotations?limit=a",
+			expectedCode: http.StatusBadRequest,
+			expected:     `{"message":"invalid limit: strconv.Atoi: parsing \"a\": invalid syntax"}`,
+		},
+		{
+			name:         "patch",
+			method:       http.MethodPatch,
+			path:         "/api/annotations/1",
+			body:         `{"text":"rollback"}`,
+			expectedCode: http.StatusOK,
+			expected:     `{"message":"Annotation patched"}`,
+		},
+		{
+			name:         "update",
+			method:       http.MethodPut,
+			path:         "/api/annotations/1",
+			body:         `{"time":1000,"text":"rollback"}`,
+			expectedCode: http.StatusOK,
+			expected:     `{"message":"Annotation updated"}`,
+		},
+		{
+			name:         "update without text",
+			method:       http.MethodPut,
+			path:         "/api/annotations/1",
+			body:         `{"time":1000}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     `{"message":"text field should not be empty"}`,
+		},
+		{
+			name:         "delete",
+			method:       http.MethodDelete,
+			path:         "/api/annotations/1",
+			expectedCode: http.StatusOK,
+			expected:     `{"message":"Annotation deleted"}`,
+		},
+		{
+			name:         "delete unknown",
+			method:       http.MethodDelete,
+			path:         "/api/annotations/5",
+			expectedCode: http.StatusNotFound,
+			expected:     `{"message":"Annotation not found"}`,
+		},
+		{
+			name:         "delete in read-only mode",
+			config:       Config{ReadOnly: true},
+			method:       http.MethodDelete,
+			path:         "/api/annotations/1",
+			expectedCode: http.StatusForbidden,
+			expected:     `{"message":"read-only connector cannot change annotations"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := &mockAnnotationStore{
+				created: []annotations.Annotation{{Time: 1000, TimeEnd: 1000, Text: "deploy", Tags: []string{}}},
+				patches: map[int64]annotations.Patch{},
+			}
+			router := mux.NewRouter()
+			router.Path("/api/annotations").Methods(http.MethodGet, http.MethodPost).Handler(Annotations(&c.config, store))
+			router.Path("/api/annotations/{id}").Methods(http.MethodPut, http.MethodPatch, http.MethodDelete).Handler(Annotation(&c.config, store))
+
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, c.expectedCode, w.Code)
+			require.JSONEq(t, c.expected, w.Body.String())
+		})
+	}
+}
+
+func TestAnnotationsQuery(t *testing.T) {
+	store := &mockAnnotationStore{patches: map[int64]annotations.Patch{}}
+	req := httptest.NewRequest(http.MethodGet, "/api/annotations?from=1000&to=2000&limit=5&dashboardUID=abc&dashboardId=3&panelId=4&tags=a&tags=b&matchAny=true", nil)
+	w := httptest.NewRecorder()
+	Annotations(&Config{}, store).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, annotations.Query{
+		From:         1000,
+		To:           2000,
+		DashboardID:  3,
+		DashboardUID: "abc",
+		PanelID:      4,
+		Tags:         []string{"a", "b"},
+		MatchAny:     true,
+		Limit:        5,
+	}, store.query)
+
+	var res []annotations.Annotation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Empty(t, res)
+}
@@ -0,0 +1,133 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/audit"
+	"github.com/timescale/promscale/pkg/auth"
+	"github.com/timescale/promscale/pkg/querylog"
+)
+
+// auditedQueryEndpoints are the paths of the endpoints reading data. The
+// endpoints under /api/v1/admin/ and the reload and drain endpoints are
+// audited as admin endpoints, except for the series deletion.
+var auditedQueryEndpoints = map[string]bool{
+	"/read":                            true,
+	"/federate":                        true,
+	"/api/v1/query":                    true,
+	"/api/v1/query_range":              true,
+	"/api/v1/query_exemplars":          true,
+	"/api/v1/series":                   true,
+	"/api/v1/labels":                   true,
+	"/api/v1/label/{name}/values":      true,
+	"/api/v1/sql":                      true,
+	"/api/v1/forecast/{method}":        true,
+	"/api/v1/logs":                     true,
+	"/loki/api/v1/query_range":         true,
+	"/loki/api/v1/labels":              true,
+	"/loki/api/v1/label/{name}/values": true,
+}
+
+// auditCategory returns the audit category of the endpoint with the path
+// template, empty if the endpoint is not audited.
+func auditCategory(path string) string {
+	switch {
+	case path == "/delete_series" || path == "/api/v1/admin/tsdb/delete_series":
+		return audit.CategoryDelete
+	case auditedQueryEndpoints[path]:
+		return audit.CategoryQuery
+	case strings.HasPrefix(path, "/api/v1/admin/") || path == "/-/reload" || path == "/-/drain":
+		return audit.CategoryAdmin
+	default:
+		return ""
+	}
+}
+
+// withAudit returns a middleware logging an audit record of every request to
+// the audited endpoints. It runs before the authentication, so that the
+// denied requests are audited too.
+func withAudit(logger *audit.Logger) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		if logger == nil {
+			return handler
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var endpoint string
+			if route := mux.CurrentRoute(r); route != nil {
+				endpoint, _ = route.GetPathTemplate()
+			}
+			category := auditCategory(endpoint)
+			if category == "" || !logger.Audits(endpoint, category) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			// The form is parsed before the handler reads the body.
+			_ = r.ParseForm()
+			ctx := auth.WithIdentity(r.Context())
+			ctx, stats := querylog.WithStats(ctx)
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			handler.ServeHTTP(rec, r.WithContext(ctx))
+
+			record := audit.Record{
+				Time:            start.UTC(),
+				Identity:        auth.IdentityFromContext(ctx),
+				RemoteAddr:      r.RemoteAddr,
+				Tenant:          getLimitedTenant(r),
+				Endpoint:        endpoint,
+				Category:        category,
+				Method:          r.Method,
+				Expr:            r.Form.Get("query"),
+				Rows:            stats.Rows(),
+				DurationSeconds: time.Since(start).Seconds(),
+				Status:          rec.status,
+				Outcome:         audit.Outcome(rec.status),
+			}
+			record.Matchers, record.Metrics = auditedSelectors(r.Form["match[]"], stats)
+			logger.Log(record)
+		})
+	}
+}
+
+// auditedSelectors returns the series selectors of the match[] parameters
+// and of the selects of the query, and the names of the metrics they select.
+func auditedSelectors(match []string, stats *querylog.Stats) (matchers, metrics []string) {
+	matchers = append(matchers, match...)
+	metrics = stats.Metrics()
+	for _, s := range match {
+		ms, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			continue
+		}
+		for _, m := range ms {
+			if m.Name == labels.MetricName && m.Type == labels.MatchEqual && !containsString(metrics, m.Value) {
+				metrics = append(metrics, m.Value)
+			}
+		}
+	}
+	for _, m := range stats.Matchers() {
+		if !containsString(matchers, m) {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers, metrics
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/audit"
+	"github.com/timescale/promscale/pkg/auth"
+	"github.com/timescale/promscale/pkg/querylog"
+)
+
+func TestWithAudit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.New(audit.Config{File: file, Endpoints: "query,delete"})
+	require.NoError(t, err)
+
+	authCfg := &auth.Config{BasicAuthUsername: "grafana", BasicAuthPassword: "secret"}
+	router := mux.NewRouter()
+	router.Use(withAudit(logger))
+	router.Use(authCfg.AuthHandler)
+	router.Path("/api/v1/query").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := querylog.FromContext(r.Context())
+		stats.AddSelect(0, 1000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")})
+		stats.AddRows(3, 30)
+	})
+	router.Path("/api/v1/admin/tsdb/delete_series").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Path("/api/v1/admin/vacuum").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Path("/write").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(method, path string, form url.Values, authorized bool) {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authorized {
+			r.SetBasicAuth("grafana", "secret")
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	serve(http.MethodPost, "/api/v1/query", url.Values{"query": {"up"}}, true)
+	serve(http.MethodGet, "/api/v1/query?query=secret_metric", nil, false)
+	serve(http.MethodPost, "/api/v1/admin/tsdb/delete_series", url.Values{"match[]": {`node_cpu{job="node"}`}}, true)
+	// Admin endpoints are filtered out and writes are never audited.
+	serve(http.MethodPost, "/api/v1/admin/vacuum", nil, true)
+	serve(http.MethodPost, "/write", nil, true)
+	require.NoError(t, logger.Close())
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []audit.Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record audit.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+
+	require.Equal(t, "grafana", records[0].Identity)
+	require.Equal(t, "/api/v1/query", records[0].Endpoint)
+	require.Equal(t, audit.CategoryQuery, records[0].Category)
+	require.Equal(t, "up", records[0].Expr)
+	require.Equal(t, []string{`{__name__="up"}`}, records[0].Matchers)
+	require.Equal(t, []string{"up"}, records[0].Metrics)
+	require.Equal(t, int64(3), records[0].Rows)
+	require.Equal(t, audit.OutcomeSuccess, records[0].Outcome)
+
+	require.Equal(t, "", records[1].Identity)
+	require.Equal(t, "secret_metric", records[1].Expr)
+	require.Equal(t, http.StatusUnauthorized, records[1].Status)
+	require.Equal(t, audit.OutcomeDenied, records[1].Outcome)
+
+	require.Equal(t, audit.CategoryDelete, records[2].Category)
+	require.Equal(t, []string{`node_cpu{job="node"}`}, records[2].Matchers)
+	require.Equal(t, []string{"node_cpu"}, records[2].Metrics)
+	require.Equal(t, http.StatusNoContent, records[2].Status)
+}
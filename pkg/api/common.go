@@ -12,6 +12,7 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/regexp"
@@ -20,11 +21,21 @@ import (
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/util/httputil"
+	"github.com/timescale/promscale/pkg/audit"
+	"github.com/timescale/promscale/pkg/export"
+	"github.com/timescale/promscale/pkg/federation"
+	"github.com/timescale/promscale/pkg/indexadvisor"
+	"github.com/timescale/promscale/pkg/integrity"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/promql"
+	"github.com/timescale/promscale/pkg/querylog"
+	"github.com/timescale/promscale/pkg/ratelimit"
 	"github.com/timescale/promscale/pkg/rules"
+	"github.com/timescale/promscale/pkg/sqlapi"
 	"github.com/timescale/promscale/pkg/tenancy"
+	"github.com/timescale/promscale/pkg/vacuum"
 )
 
 var (
@@ -32,28 +43,116 @@ var (
 	maxTimeFormatted = pgmodel.MaxTime.Format(time.RFC3339Nano)
 )
 
+// DefaultReadMaxBytesInFrame is the default size of a streamed remote read frame,
+// the same as the default of Prometheus.
+const DefaultReadMaxBytesInFrame = 1048576
+
 type Config struct {
 	AllowedOrigin    *regexp.Regexp
 	ReadOnly         bool
 	HighAvailability bool
 	AdminAPIEnabled  bool
+	AdminUIEnabled   bool
 	TelemetryPath    string
 
-	MultiTenancy tenancy.Authorizer
-	Rules        *rules.Manager
+	ReadMaxBytesInFrame int
+	// CreatedTimestampZeroIngestion adds a sample of value 0 at the created
+	// timestamp of the series of remote write 2.0 requests.
+	CreatedTimestampZeroIngestion bool
+	ExportCfg                     export.Config
+	FederationCfg                 federation.Config
+	SQLCfg                        sqlapi.Config
+
+	tenantAckModesStr string
+	TenantAckModes    map[string]ingestor.AckMode
+
+	MultiTenancy  tenancy.Authorizer
+	Rules         *rules.Manager
+	TenantLimiter *ratelimit.Limiter
+	SpanLimiter   *ratelimit.SpanLimiter
+	IndexAdvisor  *indexadvisor.Advisor
+	// IntegrityVerifier is nil if the integrity verifier is disabled.
+	IntegrityVerifier *integrity.Verifier
+	// Vacuum is nil if the vacuum engine is disabled.
+	Vacuum *vacuum.Engine
+	// QueryLog is nil if the query log is disabled.
+	QueryLog *querylog.Logger
+	// AuditLog is nil if the audit log is disabled.
+	AuditLog *audit.Logger
+	// StorageUsageTenantLabel is the label the storage usage report is
+	// split by if the request does not set one.
+	StorageUsageTenantLabel string
+	// Drainer rejects the writes once the connector starts draining.
+	Drainer *Drainer
+	// Flags holds the values of the flags of the connector, with the
+	// secrets redacted.
+	Flags map[string]string
+	// ConfigStatus returns whether the last reload of the configuration
+	// succeeded and when the configuration was last loaded.
+	ConfigStatus func() (bool, time.Time)
+
+	// write is set by the first of the HTTP and gRPC write endpoints to be
+	// created, see writeParser.
+	write *writeComponents
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.BoolVar(&cfg.ReadOnly, "db.read-only", false, "Read-only mode for the connector. Operations related to writing or updating the database are disallowed. It is used when pointing the connector to a TimescaleDB read replica.")
 	fs.BoolVar(&cfg.HighAvailability, "metrics.high-availability", false, "Enable external_labels based HA.")
-	fs.BoolVar(&cfg.AdminAPIEnabled, "web.enable-admin-api", false, "Allow operations via API that are for advanced users. Currently, these operations are limited to deletion of series.")
+	fs.BoolVar(&cfg.AdminAPIEnabled, "web.enable-admin-api", false, "Allow operations via API that are for advanced users. Currently, these operations are limited to deletion and exports of series.")
+	fs.BoolVar(&cfg.AdminUIEnabled, "web.enable-admin-ui", false, "Serve a web UI on /ui showing the health, caches, active queries, HA leases, retention and top metrics by cardinality of the connector. "+
+		"Its actions, like canceling a query or changing a retention period, also require -web.enable-admin-api.")
 	fs.StringVar(&cfg.TelemetryPath, "web.telemetry-path", "/metrics", "Web endpoint for exposing Promscale's Prometheus metrics.")
+	fs.IntVar(&cfg.ReadMaxBytesInFrame, "metrics.remote-read.max-bytes-in-frame", DefaultReadMaxBytesInFrame, "Maximum number of bytes in a single frame of a streamed remote read response. "+
+		"Frames hold at most one series, but a series with a lot of samples is split across several frames. Used only if the client accepts STREAMED_XOR_CHUNKS responses.")
+	fs.BoolVar(&cfg.CreatedTimestampZeroIngestion, "metrics.remote-write.created-timestamp-zero-ingestion", false, "Ingest a sample of value 0 at the created timestamp of the counters and histograms of remote write 2.0 requests, "+
+		"so that rate() and increase() account for the increase before the first sample of a series.")
+	fs.StringVar(&cfg.tenantAckModesStr, "metrics.ack-mode.tenants", "", "Comma separated list of tenant=mode pairs that set when the write requests of a tenant are acknowledged, e.g. 'tenant-a=async,tenant-b=sync'. "+
+		"'sync' acknowledges after the data is committed to the database, 'async' as soon as the data is queued in memory for insertion. "+
+		"The queue is not persisted, so the data acknowledged with 'async' is lost if Promscale stops or the insert fails. "+
+		"Tenants not listed use -metrics.async-acks. The tenant is read from the TENANT header, and the ACK-MODE header of a request takes precedence over this setting.")
+	export.ParseFlags(fs, &cfg.ExportCfg)
+	federation.ParseFlags(fs, &cfg.FederationCfg)
+	sqlapi.ParseFlags(fs, &cfg.SQLCfg)
 
 	return cfg
 }
 
 func Validate(cfg *Config) error {
-	return nil
+	if cfg.ReadMaxBytesInFrame <= 0 {
+		return fmt.Errorf("metrics.remote-read.max-bytes-in-frame must be positive, got %d", cfg.ReadMaxBytesInFrame)
+	}
+	ackModes, err := parseTenantAckModes(cfg.tenantAckModesStr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics.ack-mode.tenants: %w", err)
+	}
+	cfg.TenantAckModes = ackModes
+	if err = federation.Validate(&cfg.FederationCfg); err != nil {
+		return err
+	}
+	if err = sqlapi.Validate(&cfg.SQLCfg); err != nil {
+		return err
+	}
+	return export.Validate(&cfg.ExportCfg)
+}
+
+func parseTenantAckModes(s string) (map[string]ingestor.AckMode, error) {
+	modes := make(map[string]ingestor.AckMode)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%q is not of the form tenant=mode", pair)
+		}
+		mode, err := ingestor.ParseAckMode(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		modes[strings.TrimSpace(kv[0])] = mode
+	}
+	return modes, nil
 }
 
 func corsWrapper(conf *Config, f http.HandlerFunc) http.HandlerFunc {
@@ -172,11 +271,30 @@ func respondErrorWithMessage(w http.ResponseWriter, status int, err error, errTy
 	}
 }
 
+func respondErrorWithData(w http.ResponseWriter, status int, err error, errType string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	b, err := json.Marshal(&errResponse{
+		Status:    "error",
+		ErrorType: errType,
+		Error:     err.Error(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Error("msg", "error marshalling json error", "err", err)
+	}
+	if n, err := w.Write(b); err != nil {
+		log.Error("msg", "error writing response", "bytesWritten", n, "err", err)
+	}
+}
+
 type errResponse struct {
-	Status    string `json:"status"`
-	ErrorType string `json:"errorType"`
-	Error     string `json:"error"`
-	Message   string `json:"message,omitempty"`
+	Status    string      `json:"status"`
+	ErrorType string      `json:"errorType"`
+	Error     string      `json:"error"`
+	Message   string      `json:"message,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 type response struct {
@@ -0,0 +1,220 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/timescale/promscale/pkg/log"
+)
+
+var errDraining = fmt.Errorf("the connector is draining before shutting down")
+
+// Drainer stops the writes before the connector shuts down. Once the drain
+// starts, the connector is not ready anymore and the new writes are rejected
+// with 503 Service Unavailable and a Retry-After header, so that the clients
+// send them to another connector, while the writes in flight complete. A nil
+// Drainer never drains.
+type Drainer struct {
+	retryAfter time.Duration
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	started  chan struct{}
+	idle     chan struct{}
+}
+
+// NewDrainer returns a Drainer telling the clients to retry the rejected
+// writes after retryAfter.
+func NewDrainer(retryAfter time.Duration) *Drainer {
+	return &Drainer{
+		retryAfter: retryAfter,
+		started:    make(chan struct{}),
+		idle:       make(chan struct{}),
+	}
+}
+
+// Start starts the drain. Starting it again does nothing.
+func (d *Drainer) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	close(d.started)
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// Started returns a channel closed when the drain starts.
+func (d *Drainer) Started() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.started
+}
+
+// Draining tells if the drain started.
+func (d *Drainer) Draining() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Wait waits for the writes in flight when the drain started to complete, or
+// for the context to be done.
+func (d *Drainer) Wait(ctx context.Context) error {
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return fmt.Errorf("%d writes still in flight: %w", d.inFlight, ctx.Err())
+	}
+}
+
+// begin registers a write in flight, and returns false if the drain started.
+func (d *Drainer) begin() bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// end unregisters a write in flight.
+func (d *Drainer) end() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *Drainer) retryAfterSeconds() string {
+	seconds := int64(math.Ceil(d.retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// withDrain rejects the writes of the handler once the drain started.
+func withDrain(d *Drainer, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.begin() {
+			w.Header().Set("Retry-After", d.retryAfterSeconds())
+			http.Error(w, errDraining.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer d.end()
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// Drain starts the drain of the connector, which shuts down once the writes
+// in flight complete.
+func Drain(d *Drainer, webAdmin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !webAdmin {
+			err := fmt.Errorf("drain received but web admin is disabled. To enable, start Promscale with '-web.enable-admin-api' flag")
+			log.Error("msg", err.Error())
+			http.Error(w, fmt.Errorf("failed to drain: %w", err).Error(), http.StatusUnauthorized)
+			return
+		}
+		if d == nil {
+			http.Error(w, "drain is not supported", http.StatusNotImplemented)
+			return
+		}
+		log.Info("msg", "Drain requested through the API")
+		d.Start()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// DrainUnaryInterceptor rejects the unary calls of the given gRPC services
+// with Unavailable once the drain started, with the Retry-After trailer.
+func DrainUnaryInterceptor(d *Drainer, services ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !hasServicePrefix(info.FullMethod, services) {
+			return handler(ctx, req)
+		}
+		if !d.begin() {
+			_ = grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterTrailer, d.retryAfterSeconds()))
+			return nil, status.Error(codes.Unavailable, errDraining.Error())
+		}
+		defer d.end()
+		return handler(ctx, req)
+	}
+}
+
+// DrainStreamInterceptor rejects the new streams of the given gRPC services
+// once the drain started, and ends the streams in flight before their next
+// message.
+func DrainStreamInterceptor(d *Drainer, services ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !hasServicePrefix(info.FullMethod, services) {
+			return handler(srv, ss)
+		}
+		if !d.begin() {
+			ss.SetTrailer(metadata.Pairs(RetryAfterTrailer, d.retryAfterSeconds()))
+			return status.Error(codes.Unavailable, errDraining.Error())
+		}
+		defer d.end()
+		return handler(srv, &drainedStream{ServerStream: ss, d: d})
+	}
+}
+
+// drainedStream ends a stream before its next message once the drain
+// started.
+type drainedStream struct {
+	grpc.ServerStream
+	d *Drainer
+}
+
+func (s *drainedStream) RecvMsg(m interface{}) error {
+	if s.d.Draining() {
+		s.SetTrailer(metadata.Pairs(RetryAfterTrailer, s.d.retryAfterSeconds()))
+		return status.Error(codes.Unavailable, errDraining.Error())
+	}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func hasServicePrefix(fullMethod string, services []string) bool {
+	for _, service := range services {
+		if strings.HasPrefix(fullMethod, "/"+service+"/") {
+			return true
+		}
+	}
+	return false
+}
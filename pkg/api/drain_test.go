@@ -0,0 +1,81 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDrainer(t *testing.T) {
+	d := NewDrainer(2500 * time.Millisecond)
+
+	inFlight, release := make(chan struct{}), make(chan struct{})
+	handler := withDrain(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/write", nil))
+	<-inFlight
+
+	require.False(t, d.Draining())
+	d.Start()
+	d.Start()
+	require.True(t, d.Draining())
+	<-d.Started()
+
+	// The new writes are rejected.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/write", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "3", w.Header().Get("Retry-After"))
+
+	interceptor := DrainUnaryInterceptor(d, WriteService)
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/" + WriteService + "/Write"}, func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	// The calls of the other services are served.
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/" + OTLPTraceService + "/Export"}, func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	// The write in flight is waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.Wait(ctx), context.DeadlineExceeded)
+	close(release)
+	require.NoError(t, d.Wait(context.Background()))
+}
+
+func TestDrainEndpoint(t *testing.T) {
+	d := NewDrainer(time.Second)
+
+	w := httptest.NewRecorder()
+	Drain(d, false).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/drain", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.False(t, d.Draining())
+
+	w = httptest.NewRecorder()
+	Drain(d, true).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/drain", nil))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.True(t, d.Draining())
+	// Without writes in flight, the drain completes right away.
+	require.NoError(t, d.Wait(context.Background()))
+
+	// A nil Drainer never drains.
+	var nilDrainer *Drainer
+	require.False(t, nilDrainer.Draining())
+	require.True(t, nilDrainer.begin())
+}
@@ -0,0 +1,182 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/timescale/promscale/pkg/log"
+	pgQuerier "github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/promql"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+// Federate serves the latest sample of the series matching the match[]
+// selectors in the Prometheus exposition format, for Prometheus servers to
+// scrape as they scrape the /federate endpoint of Prometheus.
+func Federate(conf *Config, promqlConf *query.Config, queryable promql.Queryable) http.Handler {
+	hf := corsWrapper(conf, federate(promqlConf, queryable))
+	return gziphandler.GzipHandler(hf)
+}
+
+// federatedSample is the latest sample of a series.
+type federatedSample struct {
+	name   string
+	labels labels.Labels
+	t      int64
+	v      float64
+}
+
+func federate(promqlConf *query.Config, queryable promql.Queryable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("error parsing form values: %w", err), "bad_data")
+			return
+		}
+		if len(r.Form["match[]"]) == 0 {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("no match[] parameter provided"), "bad_data")
+			return
+		}
+		var matcherSets [][]*labels.Matcher
+		for _, s := range r.Form["match[]"] {
+			matchers, err := parser.ParseMetricSelector(s)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err, "bad_data")
+				return
+			}
+			matcherSets = append(matcherSets, matchers)
+		}
+
+		// As in Prometheus, the latest sample of a series is federated if it
+		// is within the lookback delta.
+		maxt := timestamp.FromTime(time.Now())
+		mint := maxt - promqlConf.LookBackDelta.Milliseconds()
+		q, err := queryable.SamplesQuerier(r.Context(), mint, maxt)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err, "execution")
+			return
+		}
+		defer q.Close()
+
+		samples, err := latestSamples(q, mint, maxt, matcherSets)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err, "execution")
+			return
+		}
+
+		format := expfmt.Negotiate(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format)
+		for _, mf := range metricFamilies(samples) {
+			if err := enc.Encode(mf); err != nil {
+				log.Error("msg", "error encoding federated metric family", "family", mf.GetName(), "err", err)
+				return
+			}
+		}
+	}
+}
+
+// latestSamples returns the latest sample of each series matching a matcher
+// set, sorted by metric name and labels. Series whose latest sample is a
+// stale marker are left out.
+func latestSamples(q promql.SamplesQuerier, mint, maxt int64, matcherSets [][]*labels.Matcher) ([]federatedSample, error) {
+	// The database only returns the latest sample of each series with this
+	// hint, the other queryables all the samples in the time range.
+	hints := &storage.SelectHints{Start: mint, End: maxt, Func: pgQuerier.LatestFunc}
+	latest := make(map[string]federatedSample)
+	for _, ms := range matcherSets {
+		set, _ := q.Select(false, hints, nil, nil, ms...)
+		for set.Next() {
+			s := set.At()
+			var (
+				sample federatedSample
+				found  bool
+			)
+			it := s.Iterator()
+			for it.Next() {
+				sample.t, sample.v = it.At()
+				found = true
+			}
+			if err := it.Err(); err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			sample.labels = s.Labels()
+			sample.name = sample.labels.Get(labels.MetricName)
+			// A series can match several matcher sets.
+			key := sample.labels.String()
+			if prev, ok := latest[key]; !ok || prev.t < sample.t {
+				latest[key] = sample
+			}
+		}
+		if err := set.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([]federatedSample, 0, len(latest))
+	for _, s := range latest {
+		if value.IsStaleNaN(s.v) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return labels.Compare(samples[i].labels, samples[j].labels) < 0
+	})
+	return samples, nil
+}
+
+// metricFamilies groups the sorted samples by metric name. The type of the
+// metrics is not stored, so every family is untyped as in Prometheus.
+func metricFamilies(samples []federatedSample) []*dto.MetricFamily {
+	var (
+		families []*dto.MetricFamily
+		mf       *dto.MetricFamily
+	)
+	for _, s := range samples {
+		if mf == nil || mf.GetName() != s.name {
+			mf = &dto.MetricFamily{
+				Name: stringPtr(s.name),
+				Type: dto.MetricType_UNTYPED.Enum(),
+			}
+			families = append(families, mf)
+		}
+		m := &dto.Metric{
+			Label:       make([]*dto.LabelPair, 0, len(s.labels)-1),
+			Untyped:     &dto.Untyped{Value: floatPtr(s.v)},
+			TimestampMs: int64Ptr(s.t),
+		}
+		for _, l := range s.labels {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{Name: stringPtr(l.Name), Value: stringPtr(l.Value)})
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	return families
+}
+
+func stringPtr(s string) *string  { return &s }
+func floatPtr(f float64) *float64 { return &f }
+func int64Ptr(i int64) *int64     { return &i }
@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/stretchr/testify/require"
+
+	pgQuerier "github.com/timescale/promscale/pkg/pgmodel/querier"
+)
+
+type federateSample struct {
+	t int64
+	v float64
+}
+
+func (s federateSample) T() int64   { return s.t }
+func (s federateSample) V() float64 { return s.v }
+
+// federateQuerier returns its series for every select.
+type federateQuerier struct {
+	series []storage.Series
+	hints  *storage.SelectHints
+}
+
+func (q *federateQuerier) LabelValues(string, ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *federateQuerier) LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *federateQuerier) Close() {}
+
+func (q *federateQuerier) Select(_ bool, hints *storage.SelectHints, _ *pgQuerier.QueryHints, _ []parser.Node, _ ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
+	q.hints = hints
+	return &federateSeriesSet{series: q.series, i: -1}, nil
+}
+
+type federateSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *federateSeriesSet) Next() bool                 { s.i++; return s.i < len(s.series) }
+func (s *federateSeriesSet) At() storage.Series         { return s.series[s.i] }
+func (s *federateSeriesSet) Err() error                 { return nil }
+func (s *federateSeriesSet) Warnings() storage.Warnings { return nil }
+
+func TestFederate(t *testing.T) {
+	q := &federateQuerier{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "b"), []tsdbutil.Sample{federateSample{1000, 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "a"), []tsdbutil.Sample{federateSample{1000, 0}, federateSample{2000, 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "cpu", "Zone", "eu"), []tsdbutil.Sample{federateSample{1500, 0.5}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "gone", "job", "a"), []tsdbutil.Sample{federateSample{1000, 1}, federateSample{2000, math.Float64frombits(value.StaleNaN)}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "empty"), nil),
+	}}
+
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "job", ".+")}
+	// A series matched twice is only federated once.
+	samples, err := latestSamples(q, 0, 3000, [][]*labels.Matcher{matchers, matchers})
+	require.NoError(t, err)
+	require.Equal(t, pgQuerier.LatestFunc, q.hints.Func)
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, mf := range metricFamilies(samples) {
+		require.NoError(t, enc.Encode(mf))
+	}
+	require.Equal(t, `# TYPE cpu untyped
+cpu{Zone="eu"} 0.5 1500
+# TYPE up untyped
+up{job="a"} 1 2000
+up{job="b"} 1 1000
+`, buf.String())
+}
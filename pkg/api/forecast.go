@@ -0,0 +1,107 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/forecast"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// Forecast extrapolates the series matching a selector with the forecasting
+// method of the path, and returns them as the matrix of a range query.
+func Forecast(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, forecastHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func forecastHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		settings, err := parseForecastSettings(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		selector := r.FormValue("selector")
+		if selector == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("no selector parameter provided"), "bad_data")
+			return
+		}
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if conf.MultiTenancy != nil {
+			matchers = conf.MultiTenancy.ReadAuthorizer().AppendTenantMatcher(matchers)
+		}
+
+		matrix, err := forecast.Forecast(r.Context(), client.QueryConnection(), matchers, settings)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respondQuery(w, &promql.Result{Value: matrix}, nil)
+	}
+}
+
+func parseForecastSettings(r *http.Request) (forecast.Settings, error) {
+	var err error
+	s := forecast.DefaultSettings()
+	s.Method = forecast.Method(mux.Vars(r)["method"])
+
+	if r.FormValue("start") == "" {
+		return s, fmt.Errorf("no start parameter provided")
+	}
+	if s.Start, err = parseTimeParam(r, "start", time.Time{}); err != nil {
+		return s, err
+	}
+	if s.End, err = parseTimeParam(r, "end", time.Now()); err != nil {
+		return s, err
+	}
+	durations := []struct {
+		param string
+		value *time.Duration
+	}{
+		{"step", &s.Step},
+		{"horizon", &s.Horizon},
+		{"season", &s.Season},
+	}
+	for _, d := range durations {
+		if v := r.FormValue(d.param); v != "" {
+			if *d.value, err = parseDuration(v); err != nil {
+				return s, fmt.Errorf("invalid %s: %w", d.param, err)
+			}
+		}
+	}
+	factors := []struct {
+		param string
+		value *float64
+	}{
+		{"alpha", &s.Alpha},
+		{"beta", &s.Beta},
+		{"gamma", &s.Gamma},
+	}
+	for _, f := range factors {
+		if v := r.FormValue(f.param); v != "" {
+			if *f.value, err = strconv.ParseFloat(v, 64); err != nil {
+				return s, fmt.Errorf("invalid %s: %w", f.param, err)
+			}
+		}
+	}
+	return s, s.Validate()
+}
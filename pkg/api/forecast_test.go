@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/forecast"
+)
+
+func TestParseForecastSettings(t *testing.T) {
+	parse := func(method, query string) (forecast.Settings, error) {
+		r := httptest.NewRequest("GET", "/api/v1/forecast/"+method+"?"+query, nil)
+		r = mux.SetURLVars(r, map[string]string{"method": method})
+		require.NoError(t, r.ParseForm())
+		return parseForecastSettings(r)
+	}
+
+	s, err := parse("holt_winters", "start=0&end=172800&step=1h&horizon=1d&season=1d&alpha=0.3")
+	require.NoError(t, err)
+	require.Equal(t, forecast.HoltWinters, s.Method)
+	require.Equal(t, time.Unix(0, 0).UTC(), s.Start)
+	require.Equal(t, time.Unix(172800, 0).UTC(), s.End)
+	require.Equal(t, time.Hour, s.Step)
+	require.Equal(t, 24*time.Hour, s.Horizon)
+	require.Equal(t, 24*time.Hour, s.Season)
+	require.Equal(t, 0.3, s.Alpha)
+	require.Equal(t, 0.1, s.Beta)
+
+	s, err = parse("linear", "start=0&step=60&horizon=3600")
+	require.NoError(t, err)
+	require.Equal(t, forecast.Linear, s.Method)
+	require.WithinDuration(t, time.Now(), s.End, time.Minute)
+
+	for _, tc := range []struct{ method, query string }{
+		{"linear", "step=60&horizon=3600"},
+		{"linear", "start=0&step=forever&horizon=3600"},
+		{"linear", "start=0&step=60"},
+		{"arima", "start=0&step=60&horizon=3600"},
+		{"holt_winters", "start=0&step=60&horizon=3600&gamma=high"},
+	} {
+		_, err := parse(tc.method, tc.query)
+		require.Error(t, err, tc.query)
+	}
+}
@@ -0,0 +1,46 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OTLPTraceService and OTLPLogsService are the gRPC services of the OTLP
+	// trace and logs receivers.
+	OTLPTraceService = "opentelemetry.proto.collector.trace.v1.TraceService"
+	OTLPLogsService  = "opentelemetry.proto.collector.logs.v1.LogsService"
+	// WriteService is the gRPC write service.
+	WriteService = writeServiceName
+)
+
+// AuthUnaryInterceptor authorizes the unary calls of the given gRPC services
+// with authorize, which gets the metadata of the call as the headers of the
+// request. The calls of the other services are not authorized.
+func AuthUnaryInterceptor(authorize func(*http.Request) error, services ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for _, service := range services {
+			if !strings.HasPrefix(info.FullMethod, "/"+service+"/") {
+				continue
+			}
+			r, err := requestFromMetadata(ctx, info.FullMethod)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if err = authorize(r); err != nil {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			break
+		}
+		return handler(ctx, req)
+	}
+}
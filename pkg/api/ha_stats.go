@@ -0,0 +1,31 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/ha"
+)
+
+// HAStats returns the number of samples received and dropped per HA cluster
+// and replica, with the duplicate ratio of each cluster.
+func HAStats(conf *Config, filter *ha.Filter) http.Handler {
+	hf := corsWrapper(conf, haStatsHandler(filter))
+	return gziphandler.GzipHandler(hf)
+}
+
+func haStatsHandler(filter *ha.Filter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if filter == nil {
+			err := fmt.Errorf("high availability is disabled. To enable, start Promscale with '-metrics.high-availability' flag")
+			respondError(w, http.StatusNotFound, err, "not_found")
+			return
+		}
+		respond(w, http.StatusOK, filter.Stats())
+	}
+}
@@ -5,6 +5,8 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/timescale/promscale/pkg/log"
@@ -22,3 +24,47 @@ func Health(hc health.HealthCheckerFn) http.HandlerFunc {
 		w.Header().Set("Content-Length", "0")
 	}
 }
+
+// HealthReport responds with the JSON report of the health checks: 200 OK if
+// Promscale passes them or is only degraded by non-critical checks, and 500
+// Internal Server Error if a critical check fails.
+func HealthReport(run func(ctx context.Context) health.Report) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := run(r.Context())
+		status := http.StatusOK
+		switch report.Status {
+		case health.Fail:
+			log.Warn("msg", "Healthcheck failed", "checks", failedChecks(report))
+			status = http.StatusInternalServerError
+		case health.Degraded:
+			log.Debug("msg", "Healthcheck degraded", "checks", failedChecks(report))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// failedChecks returns the errors of the failed checks of a report.
+func failedChecks(report health.Report) map[string]string {
+	failed := make(map[string]string)
+	for name, c := range report.Checks {
+		if c.Status == health.Fail {
+			failed[name] = c.Error
+		}
+	}
+	return failed
+}
+
+// Ready responds with 503 Service Unavailable until Promscale is ready to serve
+// requests, i.e. it is connected to the database and done warming up its caches.
+func Ready(rc health.HealthCheckerFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rc(); err != nil {
+			log.Debug("msg", "Readiness check failed", "err", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Length", "0")
+	}
+}
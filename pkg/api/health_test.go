@@ -5,6 +5,8 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,7 +15,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/health"
 )
 
 var (
@@ -72,6 +76,68 @@ func TestHealth(t *testing.T) {
 	}
 }
 
+func TestHealthReport(t *testing.T) {
+	testCases := []struct {
+		name       string
+		status     health.Status
+		httpStatus int
+	}{
+		{name: "pass", status: health.Pass, httpStatus: http.StatusOK},
+		{name: "degraded", status: health.Degraded, httpStatus: http.StatusOK},
+		{name: "fail", status: health.Fail, httpStatus: http.StatusInternalServerError},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			report := health.Report{
+				Status: c.status,
+				Checks: map[string]health.CheckResult{
+					"database":         {Status: health.Pass, Critical: true},
+					"maintenance_jobs": {Status: health.Fail, Error: "job 1000 never succeeded"},
+				},
+			}
+			run := func(context.Context) health.Report { return report }
+			test := GenerateHealthHandleTester(t, HealthReport(run))
+			w := test("GET", strings.NewReader(""))
+			require.Equal(t, c.httpStatus, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var got health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			require.Equal(t, report, got)
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	testCases := []struct {
+		name       string
+		httpStatus int
+		readyErr   error
+	}{
+		{
+			name:       "ready",
+			httpStatus: http.StatusOK,
+		},
+		{
+			name:       "warming up",
+			httpStatus: http.StatusServiceUnavailable,
+			readyErr:   fmt.Errorf("series cache warm-up in progress"),
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			test := GenerateHealthHandleTester(t, Ready(func() error { return c.readyErr }))
+			w := test("GET", strings.NewReader(""))
+			require.Equal(t, c.httpStatus, w.Code)
+			if c.readyErr != nil {
+				require.Equal(t, c.readyErr.Error(), strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
 func GenerateHealthHandleTester(t *testing.T, handleFunc http.Handler) HandleTester {
 	return func(method string, body io.Reader) *httptest.ResponseRecorder {
 		req, err := http.NewRequest(method, "", body)
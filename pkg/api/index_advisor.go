@@ -0,0 +1,36 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+)
+
+// IndexAdvisor returns the indexes suggested by the index advisor and the
+// slow queries they are based on.
+func IndexAdvisor(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, indexAdvisorHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func indexAdvisorHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.IndexAdvisor == nil {
+			err := fmt.Errorf("index advisor is disabled. To enable, start Promscale with '-metrics.index-advisor.enabled' flag")
+			respondError(w, http.StatusNotFound, err, "not_found")
+			return
+		}
+		report, err := conf.IndexAdvisor.Report(r.Context(), client.ReadOnlyConnection())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,30 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+)
+
+// Integrity returns the state of the integrity verifier and the most recent
+// discrepancies it found in the compressed chunks.
+func Integrity(conf *Config) http.Handler {
+	hf := corsWrapper(conf, integrityHandler(conf))
+	return gziphandler.GzipHandler(hf)
+}
+
+func integrityHandler(conf *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.IntegrityVerifier == nil {
+			err := fmt.Errorf("integrity verifier is disabled. To enable, start Promscale with '-integrity.enabled' flag")
+			respondError(w, http.StatusNotFound, err, "not_found")
+			return
+		}
+		respond(w, http.StatusOK, conf.IntegrityVerifier.Report())
+	}
+}
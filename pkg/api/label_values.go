@@ -5,44 +5,125 @@
 package api
 
 import (
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
+// defaultSearchLimit is the number of values returned by the label values
+// search endpoint without a limit parameter.
+const defaultSearchLimit = 100
+
+var errValuesTruncated = errors.New("results truncated due to limit")
+
+// labelValuesSearcher is implemented by the queriers that search the values
+// of a label by prefix in the database.
+type labelValuesSearcher interface {
+	SearchLabelValues(name, prefix string, limit int, matchers ...*labels.Matcher) ([]string, storage.Warnings, error)
+}
+
 func LabelValues(conf *Config, queryable promql.Queryable) http.Handler {
-	hf := corsWrapper(conf, labelValues(queryable))
+	hf := corsWrapper(conf, labelValues(queryable, 0))
+	return gziphandler.GzipHandler(hf)
+}
+
+// LabelValuesSearch returns the values of a label like LabelValues, limited
+// to defaultSearchLimit values unless the request sets a limit.
+func LabelValuesSearch(conf *Config, queryable promql.Queryable) http.Handler {
+	hf := corsWrapper(conf, labelValues(queryable, defaultSearchLimit))
 	return gziphandler.GzipHandler(hf)
 }
 
-func labelValues(queryable promql.Queryable) http.HandlerFunc {
+// labelValues returns the values of a label, starting with the prefix
+// parameter and at most the limit parameter if set. A limit of 0 returns all
+// the values.
+func labelValues(queryable promql.Queryable, defaultLimit int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := mux.Vars(r)["name"]
 		if !model.LabelNameRE.MatchString(name) {
 			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid label name: %s", name), "bad_data")
 			return
 		}
-		querier, err := queryable.SamplesQuerier(r.Context(), math.MinInt64, math.MaxInt64)
+		params, err := parseLabelsParams(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		prefix := r.FormValue("prefix")
+		limit, err := parseLimitParam(r, defaultLimit)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		querier, err := queryable.SamplesQuerier(r.Context(), params.mint, params.maxt)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
 		defer querier.Close()
 
+		get := func(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+			return querier.LabelValues(name, matchers...)
+		}
+		if prefix != "" || limit > 0 {
+			get = searchLabelValues(querier, name, prefix, limit)
+		}
 		var values labelsValue
-		values, warnings, err := querier.LabelValues(name)
+		values, warnings, err := params.collect(get)
 		if err != nil {
+			if respondLimitError(w, err) {
+				return
+			}
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
+		if limit > 0 && len(values) > limit {
+			values = values[:limit]
+			warnings = append(warnings, errValuesTruncated)
+		}
 
 		respondLabels(w, &promql.Result{
 			Value: values,
 		}, warnings)
 	}
 }
+
+// searchLabelValues returns a function searching the values of a label with
+// the querier. One more value than the limit is searched, to tell whether the
+// values are truncated.
+func searchLabelValues(querier promql.SamplesQuerier, name, prefix string, limit int) func(...*labels.Matcher) ([]string, storage.Warnings, error) {
+	if limit > 0 {
+		limit++
+	}
+	return func(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+		if s, ok := querier.(labelValuesSearcher); ok {
+			return s.SearchLabelValues(name, prefix, limit, matchers...)
+		}
+		values, warnings, err := querier.LabelValues(name, matchers...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lreader.SearchSorted(values, prefix, limit), warnings, nil
+	}
+}
+
+func parseLimitParam(r *http.Request, defaultLimit int) (int, error) {
+	v := r.FormValue("limit")
+	if v == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit: %q", v)
+	}
+	return limit, nil
+}
@@ -6,13 +6,17 @@ package api
 
 import (
 	"encoding/json"
-	"math"
+	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -33,15 +37,23 @@ func Labels(conf *Config, queryable promql.Queryable) http.Handler {
 
 func labelsHandler(queryable promql.Queryable) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		querier, err := queryable.SamplesQuerier(r.Context(), math.MinInt64, math.MaxInt64)
+		params, err := parseLabelsParams(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		querier, err := queryable.SamplesQuerier(r.Context(), params.mint, params.maxt)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
 		defer querier.Close()
 		var names labelsValue
-		names, warnings, err := querier.LabelNames()
+		names, warnings, err := params.collect(querier.LabelNames)
 		if err != nil {
+			if respondLimitError(w, err) {
+				return
+			}
 			respondError(w, http.StatusInternalServerError, err, "internal")
 			return
 		}
@@ -51,11 +63,77 @@ func labelsHandler(queryable promql.Queryable) http.HandlerFunc {
 	}
 }
 
+// labelsParams are the parameters of the label names and values endpoints.
+// Without matchers, the labels of all the series are returned regardless of
+// the time range.
+type labelsParams struct {
+	mint, maxt  int64
+	matcherSets [][]*labels.Matcher
+}
+
+func parseLabelsParams(r *http.Request) (labelsParams, error) {
+	var params labelsParams
+	if err := r.ParseForm(); err != nil {
+		return params, fmt.Errorf("error parsing form values: %w", err)
+	}
+	start, err := parseTimeParam(r, "start", pgmodel.MinTime)
+	if err != nil {
+		return params, err
+	}
+	end, err := parseTimeParam(r, "end", pgmodel.MaxTime)
+	if err != nil {
+		return params, err
+	}
+	if end.Before(start) {
+		return params, fmt.Errorf("end timestamp must not be before start time")
+	}
+	params.mint, params.maxt = timestamp.FromTime(start), timestamp.FromTime(end)
+	for _, s := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return params, err
+		}
+		params.matcherSets = append(params.matcherSets, matchers)
+	}
+	return params, nil
+}
+
+// collect returns the sorted union of the strings returned by get for each
+// set of matchers.
+func (p labelsParams) collect(get func(...*labels.Matcher) ([]string, storage.Warnings, error)) ([]string, storage.Warnings, error) {
+	if len(p.matcherSets) == 0 {
+		return get()
+	}
+	var (
+		set      = make(map[string]struct{})
+		warnings storage.Warnings
+	)
+	for _, matchers := range p.matcherSets {
+		res, w, err := get(matchers...)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, w...)
+		for _, s := range res {
+			set[s] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for s := range set {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res, warnings, nil
+}
+
 func respondLabels(w http.ResponseWriter, res *promql.Result, warnings storage.Warnings) {
 	setResponseHeaders(w, res, false, warnings)
 	resp := &response{
 		Status: "success",
 		Data:   res.Value,
 	}
+	for _, warn := range warnings {
+		resp.Warnings = append(resp.Warnings, warn.Error())
+	}
 	_ = json.NewEncoder(w).Encode(resp)
 }
@@ -14,6 +14,10 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/query"
 )
@@ -77,6 +81,70 @@ func TestLabels(t *testing.T) {
 
 }
 
+func TestLabelsParams(t *testing.T) {
+	req := httptest.NewRequest("GET", `http://localhost:9090/labels?start=1&end=2&match[]=up&match[]={job="a"}`, nil)
+	params, err := parseLabelsParams(req)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), params.mint)
+	require.Equal(t, int64(2000), params.maxt)
+	require.Len(t, params.matcherSets, 2)
+
+	res, _, err := params.collect(func(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+		require.Len(t, matchers, 1)
+		if matchers[0].Name == "job" {
+			return []string{"job", "instance"}, nil, nil
+		}
+		return []string{"__name__", "job"}, nil, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"__name__", "instance", "job"}, res)
+
+	req = httptest.NewRequest("GET", "http://localhost:9090/labels?start=2&end=1", nil)
+	_, err = parseLabelsParams(req)
+	require.Error(t, err)
+}
+
+func TestLabelValuesSearch(t *testing.T) {
+	queryable := query.NewQueryable(nil, &mockLabelsReader{labelValues: []string{"api", "api-1", "api-2", "api-3", "web"}})
+	get := func(handler http.Handler, target string) (int, response) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, mux.SetURLVars(httptest.NewRequest("GET", target, nil), map[string]string{"name": "job"}))
+		var res response
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return w.Code, res
+	}
+	values := func(res response) []interface{} {
+		require.IsType(t, []interface{}{}, res.Data)
+		return res.Data.([]interface{})
+	}
+
+	// Without prefix or limit, all the values are returned.
+	code, res := get(labelValues(queryable, 0), "http://localhost:9090/api/v1/label/job/values")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, values(res), 5)
+
+	code, res = get(labelValues(queryable, 0), "http://localhost:9090/api/v1/label/job/values?prefix=api-&limit=2")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []interface{}{"api-1", "api-2"}, values(res))
+	require.Equal(t, []string{errValuesTruncated.Error()}, res.Warnings)
+
+	code, res = get(labelValues(queryable, 0), "http://localhost:9090/api/v1/label/job/values?prefix=api-&limit=3")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []interface{}{"api-1", "api-2", "api-3"}, values(res))
+	require.Empty(t, res.Warnings)
+
+	// The search endpoint has a default limit.
+	code, res = get(labelValues(queryable, 1), "http://localhost:9090/api/v1/label/job/search?prefix=w")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []interface{}{"web"}, values(res))
+	code, res = get(labelValues(queryable, 1), "http://localhost:9090/api/v1/label/job/search?limit=0")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, values(res), 5)
+
+	code, _ = get(labelValues(queryable, 0), "http://localhost:9090/api/v1/label/job/values?limit=-1")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
 func doLabels(t *testing.T, queryHandler http.Handler) *httptest.ResponseRecorder {
 	req, err := http.NewRequestWithContext(context.Background(), "GET", "http://localhost:9090/labels", nil)
 	if err != nil {
@@ -0,0 +1,64 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/ratelimit"
+)
+
+// limitsResponse holds all the limits applied to the requests of a tenant.
+type limitsResponse struct {
+	// Tenant is nil if no tenant limits are configured.
+	Tenant *ratelimit.TenantStatus `json:"tenant"`
+	Query  queryLimits             `json:"query"`
+}
+
+// queryLimits are the limits applied to every PromQL query.
+type queryLimits struct {
+	QueryTimeout   string `json:"queryTimeout"`
+	LookbackDelta  string `json:"lookbackDelta"`
+	MaxSamples     int    `json:"maxSamples"`
+	MaxPointsPerTs int64  `json:"maxPointsPerTs"`
+	// The limits of the data read from the database, 0 if unlimited.
+	MaxScannedSamples int64 `json:"maxScannedSamples"`
+	MaxSeries         int64 `json:"maxSeries"`
+	MaxBytes          int64 `json:"maxBytes"`
+	MaxQueryMemory    int64 `json:"maxQueryMemory"`
+}
+
+// Limits returns the limits applied to a tenant and its current utilization,
+// so that the clients rejected with 429 can see which limit they hit. The
+// tenant is the one of the 'tenant' parameter, or else the one of the request.
+func Limits(conf *Config, promqlConf *query.Config) http.Handler {
+	hf := corsWrapper(conf, limitsHandler(conf.TenantLimiter, promqlConf))
+	return gziphandler.GzipHandler(hf)
+}
+
+func limitsHandler(limiter *ratelimit.Limiter, promqlConf *query.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.FormValue("tenant")
+		if tenant == "" {
+			tenant = getLimitedTenant(r)
+		}
+		respond(w, http.StatusOK, limitsResponse{
+			Tenant: limiter.Status(tenant),
+			Query: queryLimits{
+				QueryTimeout:   promqlConf.MaxQueryTimeout.String(),
+				LookbackDelta:  promqlConf.LookBackDelta.String(),
+				MaxSamples:     promqlConf.MaxSamples,
+				MaxPointsPerTs: promqlConf.MaxPointsPerTs,
+
+				MaxScannedSamples: promqlConf.MaxScannedSamples,
+				MaxSeries:         promqlConf.MaxSeries,
+				MaxBytes:          promqlConf.MaxBytes,
+				MaxQueryMemory:    promqlConf.MaxQueryMemory,
+			},
+		})
+	}
+}
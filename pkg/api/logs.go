@@ -0,0 +1,152 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	hexenc "encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/logs"
+)
+
+// logsInserter is implemented by pgclient.Client.
+type logsInserter interface {
+	IngestLogs(ctx context.Context, l plog.Logs) error
+}
+
+// logStore is implemented by logs.Store.
+type logStore interface {
+	Find(ctx context.Context, q logs.Query) ([]logs.Record, error)
+}
+
+// NewLogsServer returns the OTLP logs receiver.
+func NewLogsServer(i logsInserter) plogotlp.Server {
+	return &logsServer{ingestor: i}
+}
+
+type logsServer struct {
+	ingestor logsInserter
+}
+
+func (l *logsServer) Export(ctx context.Context, req plogotlp.Request) (plogotlp.Response, error) {
+	return plogotlp.NewResponse(), l.ingestor.IngestLogs(ctx, req.Logs())
+}
+
+// Logs returns the stored log records of a time range, most recent first.
+// The records can be selected by resource attributes, minimum severity and
+// trace or span ID.
+func Logs(conf *Config, store logStore) http.Handler {
+	hf := corsWrapper(conf, logsHandler(store))
+	return gziphandler.GzipHandler(hf)
+}
+
+func logsHandler(store logStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseLogsQuery(r, time.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		records, err := store.Find(r.Context(), q)
+		if err != nil {
+			log.Error("msg", "failed to find log records", "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, records)
+	}
+}
+
+// parseLogsQuery reads the parameters of a logs request. The time range is
+// the last hour by default, and the resource attributes are matched with
+// resource[name]=value parameters.
+func parseLogsQuery(r *http.Request, now time.Time) (logs.Query, error) {
+	var q logs.Query
+	if err := r.ParseForm(); err != nil {
+		return q, err
+	}
+	var err error
+	if q.End, err = parseTimeParam(r, "end", now); err != nil {
+		return q, err
+	}
+	if q.Start, err = parseTimeParam(r, "start", q.End.Add(-time.Hour)); err != nil {
+		return q, err
+	}
+	if q.End.Before(q.Start) {
+		return q, fmt.Errorf("end timestamp must not be before start time")
+	}
+
+	for name, values := range r.Form {
+		if !strings.HasPrefix(name, "resource[") || !strings.HasSuffix(name, "]") {
+			continue
+		}
+		if q.Resource == nil {
+			q.Resource = make(map[string]string)
+		}
+		q.Resource[name[len("resource["):len(name)-1]] = values[len(values)-1]
+	}
+
+	if v := r.FormValue("severity"); v != "" {
+		if q.MinSeverity, err = parseSeverity(v); err != nil {
+			return q, err
+		}
+	}
+	if v := r.FormValue("trace_id"); v != "" {
+		var id [16]byte
+		if err = decodeID(v, id[:]); err != nil {
+			return q, fmt.Errorf("invalid trace_id: %w", err)
+		}
+		q.TraceID = &id
+	}
+	if v := r.FormValue("span_id"); v != "" {
+		var id [8]byte
+		if err = decodeID(v, id[:]); err != nil {
+			return q, fmt.Errorf("invalid span_id: %w", err)
+		}
+		q.SpanID = &id
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q, must be a positive integer", v)
+		}
+	}
+	return q, nil
+}
+
+// parseSeverity returns the lowest severity number of a severity, given as a
+// number or as a level name like WARN.
+func parseSeverity(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= int(plog.SeverityNumberFATAL4) {
+		return n, nil
+	}
+	for n := plog.SeverityNumberTRACE; n <= plog.SeverityNumberFATAL4; n++ {
+		if strings.EqualFold(n.String(), "SEVERITY_NUMBER_"+s) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid severity %q, must be a severity number or one of TRACE, DEBUG, INFO, WARN, ERROR, FATAL", s)
+}
+
+func decodeID(s string, id []byte) error {
+	b, err := hexenc.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(id) {
+		return fmt.Errorf("must be %d hex encoded bytes", len(id))
+	}
+	copy(id, b)
+	return nil
+}
@@ -0,0 +1,122 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+
+	"github.com/timescale/promscale/pkg/logs"
+)
+
+type mockLogStore struct {
+	query logs.Query
+	err   error
+}
+
+func (m *mockLogStore) Find(_ context.Context, q logs.Query) ([]logs.Record, error) {
+	m.query = q
+	return []logs.Record{{Time: q.End, SeverityText: "ERROR"}}, m.err
+}
+
+type mockLogsInserter struct {
+	records int
+}
+
+func (m *mockLogsInserter) IngestLogs(_ context.Context, l plog.Logs) error {
+	m.records += l.LogRecordCount()
+	return nil
+}
+
+func TestParseLogsQuery(t *testing.T) {
+	now := time.Unix(7200, 0).UTC()
+	traceID := [16]byte{0xab, 1}
+	spanID := [8]byte{0xcd, 2}
+	cases := []struct {
+		name     string
+		params   string
+		expected logs.Query
+		err      bool
+	}{
+		{
+			name:     "defaults to the last hour",
+			expected: logs.Query{Start: time.Unix(3600, 0).UTC(), End: now},
+		},
+		{
+			name:   "all filters",
+			params: "start=10&end=20&resource[service.name]=checkout&severity=warn&trace_id=ab010000000000000000000000000000&span_id=cd02000000000000&limit=5",
+			expected: logs.Query{
+				Start:       time.Unix(10, 0).UTC(),
+				End:         time.Unix(20, 0).UTC(),
+				Resource:    map[string]string{"service.name": "checkout"},
+				MinSeverity: int(plog.SeverityNumberWARN),
+				TraceID:     &traceID,
+				SpanID:      &spanID,
+				Limit:       5,
+			},
+		},
+		{
+			name:     "severity number",
+			params:   "start=10&end=20&severity=17",
+			expected: logs.Query{Start: time.Unix(10, 0).UTC(), End: time.Unix(20, 0).UTC(), MinSeverity: 17},
+		},
+		{name: "end before start", params: "start=20&end=10", err: true},
+		{name: "invalid severity", params: "severity=loud", err: true},
+		{name: "short trace id", params: "trace_id=ab01", err: true},
+		{name: "invalid span id", params: "span_id=xyz", err: true},
+		{name: "invalid limit", params: "limit=0", err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/logs?"+c.params, nil)
+			q, err := parseLogsQuery(r, now)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, q)
+		})
+	}
+}
+
+func TestLogsHandler(t *testing.T) {
+	store := &mockLogStore{}
+	h := Logs(&Config{}, store)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?start=0&end=60", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"success","data":[{"time":"1970-01-01T00:01:00Z","severity_number":0,"severity_text":"ERROR","attributes":null,"resource_attributes":null}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs?severity=loud", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	store.err = fmt.Errorf("connection refused")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/logs", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLogsServer(t *testing.T) {
+	inserter := &mockLogsInserter{}
+	l := plog.NewLogs()
+	records := l.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	records.AppendEmpty()
+	records.AppendEmpty()
+
+	_, err := NewLogsServer(inserter).Export(context.Background(), plogotlp.NewRequestFromLogs(l))
+	require.NoError(t, err)
+	require.Equal(t, 2, inserter.records)
+}
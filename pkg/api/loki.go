@@ -0,0 +1,212 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/logs"
+)
+
+// lokiStore is implemented by logs.Store.
+type lokiStore interface {
+	logStore
+	Labels(ctx context.Context, start, end time.Time) ([]string, error)
+	LabelValues(ctx context.Context, name string, start, end time.Time) ([]string, error)
+}
+
+// LokiPush writes the streams of a Loki push request to the log store.
+func LokiPush(conf *Config, i logsInserter) http.Handler {
+	return corsWrapper(conf, lokiPushHandler(i))
+}
+
+func lokiPushHandler(i logsInserter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err, "bad_data")
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		protobuf := !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+		l, err := logs.DecodeLokiPush(b, protobuf)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if err = i.IngestLogs(r.Context(), l); err != nil {
+			log.Error("msg", "failed to write Loki streams", "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LokiQueryRange runs a LogQL log query over the log store, like the Loki
+// query_range endpoint.
+func LokiQueryRange(conf *Config, store lokiStore) http.Handler {
+	hf := corsWrapper(conf, lokiQueryRangeHandler(store))
+	return gziphandler.GzipHandler(hf)
+}
+
+// lokiStream is a stream of the result of a Loki query, with the timestamp
+// in nanoseconds and the line of its entries.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiStreams struct {
+	ResultType string       `json:"resultType"`
+	Result     []lokiStream `json:"result"`
+	Stats      struct{}     `json:"stats"`
+}
+
+func lokiQueryRangeHandler(store lokiStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseLokiQuery(r, time.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		records, err := store.Find(r.Context(), q)
+		if err != nil {
+			log.Error("msg", "failed to run Loki query", "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, lokiStreams{ResultType: "streams", Result: toLokiStreams(records)})
+	}
+}
+
+func parseLokiQuery(r *http.Request, now time.Time) (logs.Query, error) {
+	var q logs.Query
+	if err := r.ParseForm(); err != nil {
+		return q, err
+	}
+	var err error
+	if q.Matchers, q.LineFilters, err = logs.ParseLogQL(r.FormValue("query")); err != nil {
+		return q, err
+	}
+	if q.Start, q.End, err = parseLokiRange(r, now); err != nil {
+		return q, err
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q, must be a positive integer", v)
+		}
+	}
+	switch d := r.FormValue("direction"); d {
+	case "", "backward":
+	case "forward":
+		q.Forward = true
+	default:
+		return q, fmt.Errorf("invalid direction %q, must be forward or backward", d)
+	}
+	return q, nil
+}
+
+// parseLokiRange returns the start and end parameters, the last hour by
+// default.
+func parseLokiRange(r *http.Request, now time.Time) (start, end time.Time, err error) {
+	end = now
+	if v := r.FormValue("end"); v != "" {
+		if end, err = parseLokiTime(v); err != nil {
+			return start, end, fmt.Errorf("invalid end %q: %w", v, err)
+		}
+	}
+	start = end.Add(-time.Hour)
+	if v := r.FormValue("start"); v != "" {
+		if start, err = parseLokiTime(v); err != nil {
+			return start, end, fmt.Errorf("invalid start %q: %w", v, err)
+		}
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("end timestamp must not be before start time")
+	}
+	return start, end, nil
+}
+
+// parseLokiTime parses a timestamp like Loki: integers of more than 10 digits
+// are nanoseconds, the other numbers seconds.
+func parseLokiTime(s string) (time.Time, error) {
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil && len(s) > 10 {
+		return time.Unix(0, ns).UTC(), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC(), nil
+	}
+	return parseTime(s)
+}
+
+// toLokiStreams groups the records by stream, in the order of their first
+// record.
+func toLokiStreams(records []logs.Record) []lokiStream {
+	streams := []lokiStream{}
+	index := make(map[string]int)
+	for _, r := range records {
+		stream := make(map[string]string, len(r.ResourceAttributes))
+		for name, value := range r.ResourceAttributes {
+			stream[name] = fmt.Sprint(value)
+		}
+		key := fmt.Sprint(stream)
+		i, ok := index[key]
+		if !ok {
+			i = len(streams)
+			index[key] = i
+			streams = append(streams, lokiStream{Stream: stream})
+		}
+		streams[i].Values = append(streams[i].Values, [2]string{strconv.FormatInt(r.Time.UnixNano(), 10), r.Line()})
+	}
+	return streams
+}
+
+// LokiLabels returns the stream labels of the log store, like the Loki labels
+// endpoint.
+func LokiLabels(conf *Config, store lokiStore) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		start, end, err := parseLokiRange(r, time.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		var names []string
+		if name, ok := mux.Vars(r)["name"]; ok {
+			names, err = store.LabelValues(r.Context(), name, start, end)
+		} else {
+			names, err = store.Labels(r.Context(), start, end)
+		}
+		if err != nil {
+			log.Error("msg", "failed to get Loki labels", "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, names)
+	})
+	return gziphandler.GzipHandler(hf)
+}
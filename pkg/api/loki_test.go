@@ -0,0 +1,136 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/logs"
+)
+
+type mockLokiStore struct {
+	mockLogStore
+	label string
+}
+
+func (m *mockLokiStore) Labels(_ context.Context, _, _ time.Time) ([]string, error) {
+	return []string{"env", "job"}, nil
+}
+
+func (m *mockLokiStore) LabelValues(_ context.Context, name string, _, _ time.Time) ([]string, error) {
+	m.label = name
+	return []string{"api"}, nil
+}
+
+func TestParseLokiQuery(t *testing.T) {
+	now := time.Unix(7200, 0).UTC()
+	cases := []struct {
+		name     string
+		params   string
+		expected logs.Query
+		err      bool
+	}{
+		{
+			name:   "defaults to the last hour",
+			params: `query={job="api"}`,
+			expected: logs.Query{
+				Start:    time.Unix(3600, 0).UTC(),
+				End:      now,
+				Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "api")},
+			},
+		},
+		{
+			name:   "nanosecond range, limit and direction",
+			params: `query={job="api"} |= "error"&start=1000000000000&end=2000000000000&limit=10&direction=forward`,
+			expected: logs.Query{
+				Start:       time.Unix(1000, 0).UTC(),
+				End:         time.Unix(2000, 0).UTC(),
+				Matchers:    []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "api")},
+				LineFilters: []logs.LineFilter{{Type: logs.LineContains, Match: "error"}},
+				Limit:       10,
+				Forward:     true,
+			},
+		},
+		{
+			name:   "seconds range",
+			params: `query={job="api"}&start=1000&end=2000.5`,
+			expected: logs.Query{
+				Start:    time.Unix(1000, 0).UTC(),
+				End:      time.Unix(2000, 5e8).UTC(),
+				Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "api")},
+			},
+		},
+		{name: "metric query", params: `query=rate({job="api"}[5m])`, err: true},
+		{name: "end before start", params: `query={job="api"}&start=2000&end=1000`, err: true},
+		{name: "invalid direction", params: `query={job="api"}&direction=up`, err: true},
+		{name: "invalid limit", params: `query={job="api"}&limit=-1`, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/loki/api/v1/query_range", strings.NewReader(c.params))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			q, err := parseLokiQuery(r, now)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, q)
+		})
+	}
+}
+
+func TestToLokiStreams(t *testing.T) {
+	records := []logs.Record{
+		{Time: time.Unix(3, 0), Body: []byte(`"stopped"`), ResourceAttributes: map[string]interface{}{"job": "api", "pid": 42.0}},
+		{Time: time.Unix(2, 0), Body: []byte(`"GET /"`), ResourceAttributes: map[string]interface{}{"job": "web"}},
+		{Time: time.Unix(1, 0), Body: []byte(`{"msg":"started"}`), ResourceAttributes: map[string]interface{}{"pid": 42.0, "job": "api"}},
+	}
+	require.Equal(t, []lokiStream{
+		{Stream: map[string]string{"job": "api", "pid": "42"}, Values: [][2]string{{"3000000000", "stopped"}, {"1000000000", `{"msg":"started"}`}}},
+		{Stream: map[string]string{"job": "web"}, Values: [][2]string{{"2000000000", "GET /"}}},
+	}, toLokiStreams(records))
+	require.Equal(t, []lokiStream{}, toLokiStreams(nil))
+}
+
+func TestLokiHandlers(t *testing.T) {
+	inserter := &mockLogsInserter{}
+	push := LokiPush(&Config{}, inserter)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader(`{"streams":[{"stream":{"job":"api"},"values":[["1","a"],["2","b"]]}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	push.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, 2, inserter.records)
+
+	w = httptest.NewRecorder()
+	push.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader("not snappy")))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	store := &mockLokiStore{}
+	w = httptest.NewRecorder()
+	LokiQueryRange(&Config{}, store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/loki/api/v1/query_range?query={job="api"}&end=60`, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"success","data":{"resultType":"streams","result":[{"stream":{},"values":[["60000000000",""]]}],"stats":{}}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	LokiLabels(&Config{}, store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/loki/api/v1/labels", nil))
+	require.JSONEq(t, `{"status":"success","data":["env","job"]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/loki/api/v1/label/job/values", nil), map[string]string{"name": "job"})
+	LokiLabels(&Config{}, store).ServeHTTP(w, r)
+	require.JSONEq(t, `{"status":"success","data":["api"]}`, w.Body.String())
+	require.Equal(t, "job", store.label)
+}
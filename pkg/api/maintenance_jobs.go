@@ -0,0 +1,152 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/maintenance"
+	"github.com/timescale/promscale/pkg/pgclient"
+)
+
+// MaintenanceJobs returns the TimescaleDB jobs running the Promscale
+// maintenance and their statistics.
+func MaintenanceJobs(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, maintenanceJobsHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func maintenanceJobsHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := maintenance.Jobs(r.Context(), client.MetadataConnection())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, jobs)
+	}
+}
+
+// AdminMaintenanceJobs sets the number, the schedule interval, the jitter and
+// the statement timeout of the maintenance jobs.
+func AdminMaintenanceJobs(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminMaintenanceJobsHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminMaintenanceJobsHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkMaintenanceJobsAdmin(w, conf) {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		cfg, err := parseMaintenanceConfig(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if err = maintenance.Configure(r.Context(), client.MaintenanceConnection(), cfg); err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, nil)
+	}
+}
+
+// AdminScheduleMaintenanceJobs pauses or resumes the maintenance jobs, or only
+// the one with the job_id parameter.
+func AdminScheduleMaintenanceJobs(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminScheduleMaintenanceJobsHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminScheduleMaintenanceJobsHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkMaintenanceJobsAdmin(w, conf) {
+			return
+		}
+		var scheduled bool
+		switch action := mux.Vars(r)["action"]; action {
+		case "pause":
+		case "resume":
+			scheduled = true
+		default:
+			respondError(w, http.StatusNotFound, fmt.Errorf("unknown action %q, must be pause or resume", action), "not_found")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		var id int64
+		if s := r.FormValue("job_id"); s != "" {
+			var err error
+			if id, err = strconv.ParseInt(s, 10, 32); err != nil || id <= 0 {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid job_id %q", s), "bad_data")
+				return
+			}
+		}
+		count, err := maintenance.SetScheduled(r.Context(), client.MaintenanceConnection(), id, scheduled)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		if id != 0 && count == 0 {
+			respondError(w, http.StatusNotFound, fmt.Errorf("no maintenance job with id %d", id), "not_found")
+			return
+		}
+		respond(w, http.StatusOK, map[string]int{"jobs": count})
+	}
+}
+
+func checkMaintenanceJobsAdmin(w http.ResponseWriter, conf *Config) bool {
+	if conf.ReadOnly {
+		respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot change the maintenance jobs"), "operation_not_permitted")
+		return false
+	}
+	if !conf.AdminAPIEnabled {
+		respondError(w, http.StatusForbidden, fmt.Errorf("changing the maintenance jobs requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+		return false
+	}
+	return true
+}
+
+func parseMaintenanceConfig(r *http.Request) (maintenance.Config, error) {
+	var cfg maintenance.Config
+	jobs, err := strconv.Atoi(r.FormValue("jobs"))
+	if err != nil || jobs < 1 {
+		return cfg, fmt.Errorf("invalid jobs %q, must be a positive integer", r.FormValue("jobs"))
+	}
+	cfg.Jobs = jobs
+	durations := []struct {
+		name     string
+		d        *time.Duration
+		required bool
+	}{
+		{"schedule_interval", &cfg.ScheduleInterval, true},
+		{"jitter", &cfg.Jitter, false},
+		{"statement_timeout", &cfg.StatementTimeout, false},
+	}
+	for _, p := range durations {
+		s := r.FormValue(p.name)
+		if s == "" && !p.required {
+			continue
+		}
+		d, err := parseDuration(s)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", p.name, s, err)
+		}
+		*p.d = d
+	}
+	return cfg, maintenance.Validate(&cfg)
+}
@@ -0,0 +1,62 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/maintenance"
+)
+
+func TestParseMaintenanceConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected maintenance.Config
+		err      bool
+	}{
+		{
+			name:     "jobs and schedule interval",
+			query:    "jobs=2&schedule_interval=30m",
+			expected: maintenance.Config{Jobs: 2, ScheduleInterval: 30 * time.Minute},
+		},
+		{
+			name:     "all parameters",
+			query:    "jobs=4&schedule_interval=1h&jitter=300&statement_timeout=2h",
+			expected: maintenance.Config{Jobs: 4, ScheduleInterval: time.Hour, Jitter: 5 * time.Minute, StatementTimeout: 2 * time.Hour},
+		},
+		{name: "no jobs", query: "schedule_interval=30m", err: true},
+		{name: "zero jobs", query: "jobs=0&schedule_interval=30m", err: true},
+		{name: "no schedule interval", query: "jobs=2", err: true},
+		{name: "invalid jitter", query: "jobs=2&schedule_interval=30m&jitter=soon", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/maintenance_jobs?"+c.query, nil)
+			require.NoError(t, req.ParseForm())
+			cfg, err := parseMaintenanceConfig(req)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, cfg)
+		})
+	}
+}
+
+func TestAdminMaintenanceJobsPermissions(t *testing.T) {
+	for _, conf := range []*Config{{}, {AdminAPIEnabled: true, ReadOnly: true}} {
+		for _, handler := range []http.Handler{adminMaintenanceJobsHandler(conf, nil), adminScheduleMaintenanceJobsHandler(conf, nil)} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/maintenance_jobs", nil))
+			require.Equal(t, http.StatusForbidden, w.Code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"math"
 	"strconv"
+	"time"
 	"unicode/utf8"
 
 	"github.com/prometheus/prometheus/model/labels"
@@ -112,6 +113,9 @@ func writeExemplarData(out *errorWrapper, data []model.ExemplarData) {
 		out.writeFloat(d.Value)
 		out.WriteStrings(`","timestamp":`)
 		out.WriteStrings(fmt.Sprintf("%.3f", float64(d.Ts)/1000))
+		if d.Trace != nil {
+			writeExemplarTrace(out, d.Trace)
+		}
 		out.WriteStrings(`}`)
 		if i != len(data)-1 {
 			out.WriteStrings(`,`)
@@ -120,6 +124,17 @@ func writeExemplarData(out *errorWrapper, data []model.ExemplarData) {
 	out.WriteStrings(`]`)
 }
 
+func writeExemplarTrace(out *errorWrapper, trace *model.ExemplarTrace) {
+	out.WriteStrings(`,"trace":{"traceID":"`, trace.TraceID, `","spanID":"`, trace.SpanID, `","serviceName":"`)
+	out.WriteEscapedString(trace.ServiceName, true)
+	out.WriteStrings(`","spanName":"`)
+	out.WriteEscapedString(trace.SpanName, true)
+	out.WriteStrings(`","startTime":"`, trace.StartTime.UTC().Format(time.RFC3339Nano),
+		`","endTime":"`, trace.EndTime.UTC().Format(time.RFC3339Nano), `","statusCode":"`)
+	out.WriteEscapedString(trace.StatusCode, true)
+	out.WriteStrings(`"}`)
+}
+
 func writeExemplarSeriesLabels(out *errorWrapper, seriesLbls labels.Labels) {
 	out.WriteStrings(`"seriesLabels":{`)
 	marshalLabels(out, seriesLbls)
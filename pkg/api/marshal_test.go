@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
@@ -343,3 +345,56 @@ func builtinMarshal(val parser.Value, warnings []string) string {
 	_ = json.NewEncoder(builder).Encode(resp)
 	return builder.String()
 }
+
+func TestMarshalExemplarTrace(t *testing.T) {
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	data := []model.ExemplarQueryResult{{
+		SeriesLabels: labels.FromStrings("__name__", "latency"),
+		Exemplars: []model.ExemplarData{
+			{
+				Labels: labels.FromStrings("trace_id", "0102030405060708090a0b0c0d0e0f10"),
+				Value:  0.15,
+				Ts:     1000,
+				Trace: &model.ExemplarTrace{
+					TraceID:     "0102030405060708090a0b0c0d0e0f10",
+					SpanID:      "0000000000000001",
+					ServiceName: "api",
+					SpanName:    `GET "/users"`,
+					StartTime:   start,
+					EndTime:     start.Add(150 * time.Millisecond),
+					StatusCode:  "ok",
+				},
+			},
+			{Labels: labels.FromStrings("user", "a"), Value: 1, Ts: 2000},
+		},
+	}}
+
+	var out strings.Builder
+	if err := marshalExemplarResponse(&out, data); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Data []struct {
+			Exemplars []struct {
+				Trace *struct {
+					TraceID   string `json:"traceID"`
+					SpanID    string `json:"spanID"`
+					SpanName  string `json:"spanName"`
+					StartTime string `json:"startTime"`
+					EndTime   string `json:"endTime"`
+				} `json:"trace"`
+			} `json:"exemplars"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &resp); err != nil {
+		t.Fatalf("invalid JSON %s: %v", out.String(), err)
+	}
+	trace := resp.Data[0].Exemplars[0].Trace
+	if trace == nil || trace.SpanName != `GET "/users"` || trace.SpanID != "0000000000000001" ||
+		trace.StartTime != "2022-09-01T12:00:00Z" || trace.EndTime != "2022-09-01T12:00:00.15Z" {
+		t.Fatalf("unexpected trace %+v in %s", trace, out.String())
+	}
+	if resp.Data[0].Exemplars[1].Trace != nil {
+		t.Fatalf("unexpected trace of an exemplar without trace ID in %s", out.String())
+	}
+}
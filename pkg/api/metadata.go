@@ -5,12 +5,17 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/timescale/promscale/pkg/pgclient"
 	"github.com/timescale/promscale/pkg/pgmodel/metadata"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
 )
 
 func MetricMetadata(conf *Config, client *pgclient.Client) http.Handler {
@@ -24,25 +29,110 @@ func metricMetadataHandler(client *pgclient.Client) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, err, "bad_data")
 			return
 		}
-		var (
-			limit int64
-			err   error
+		metric := r.FormValue("metric")
+		limit, err := parseMetadataLimit(r, "limit")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "converting string to integer")
+			return
+		}
+		limitPerMetric, err := parseMetadataLimit(r, "limit_per_metric")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "converting string to integer")
+			return
+		}
+		data, err := metadata.MetricQuery(r.Context(), client.MetadataConnection(), metric, limit, limitPerMetric)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "fetching metric metadata")
+			return
+		}
+		respond(w, http.StatusOK, data)
+	}
+}
+
+// targetMetadata is an entry of the targets metadata API. Remote write
+// does not send the targets the metadata was scraped from, so the target
+// labels are always empty.
+type targetMetadata struct {
+	Target labels.Labels `json:"target"`
+	model.Metadata
+}
 
-			metric   = r.FormValue("metric")
-			limitStr = r.FormValue("limit")
-		)
-		if limitStr != "" {
-			limit, err = strconv.ParseInt(limitStr, 10, 32)
+func TargetMetadata(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, targetMetadataHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func targetMetadataHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		metric := r.FormValue("metric")
+		limit, err := parseMetadataLimit(r, "limit")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "converting string to integer")
+			return
+		}
+		var matchers []*labels.Matcher
+		if matchTarget := r.FormValue("match_target"); matchTarget != "" {
+			matchers, err = parser.ParseMetricSelector(matchTarget)
 			if err != nil {
-				respondError(w, http.StatusBadRequest, err, "converting string to integer")
+				respondError(w, http.StatusBadRequest, err, "bad_data")
 				return
 			}
 		}
-		data, err := metadata.MetricQuery(r.Context(), client.ReadOnlyConnection(), metric, int(limit))
+
+		res := []targetMetadata{}
+		// All the metadata belongs to the empty target.
+		for _, m := range matchers {
+			if !m.Matches("") {
+				respond(w, http.StatusOK, res)
+				return
+			}
+		}
+		data, err := metadata.MetricQuery(r.Context(), client.MetadataConnection(), metric, 0, 0)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err, "fetching metric metadata")
 			return
 		}
-		respond(w, http.StatusOK, data)
+		respond(w, http.StatusOK, flattenTargetMetadata(data, limit))
+	}
+}
+
+// flattenTargetMetadata returns the entries of data sorted by metric, up to
+// limit entries if it is not 0.
+func flattenTargetMetadata(data map[string][]model.Metadata, limit int) []targetMetadata {
+	metrics := make([]string, 0, len(data))
+	for metric := range data {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	res := []targetMetadata{}
+	for _, metric := range metrics {
+		for _, md := range data[metric] {
+			if limit != 0 && len(res) >= limit {
+				return res
+			}
+			md.MetricFamily = metric
+			res = append(res, targetMetadata{Target: labels.Labels{}, Metadata: md})
+		}
+	}
+	return res
+}
+
+func parseMetadataLimit(r *http.Request, name string) (int, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
 	}
+	return int(limit), nil
 }
@@ -0,0 +1,34 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestFlattenTargetMetadata(t *testing.T) {
+	data := map[string][]model.Metadata{
+		"up": {{Type: "gauge", Help: "Whether the target is up."}},
+		"http_requests_total": {
+			{Type: "counter", Unit: "requests", Help: "Total requests."},
+			{Type: "gauge", Help: "Requests in flight."},
+		},
+	}
+
+	res, err := json.Marshal(flattenTargetMetadata(data, 0))
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"target": {}, "metric": "http_requests_total", "type": "counter", "unit": "requests", "help": "Total requests."},
+		{"target": {}, "metric": "http_requests_total", "type": "gauge", "unit": "", "help": "Requests in flight."},
+		{"target": {}, "metric": "up", "type": "gauge", "unit": "", "help": "Whether the target is up."}
+	]`, string(res))
+
+	require.Len(t, flattenTargetMetadata(data, 2), 2)
+	require.Empty(t, flattenTargetMetadata(nil, 0))
+}
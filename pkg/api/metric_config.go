@@ -0,0 +1,137 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/metricconfig"
+	"github.com/timescale/promscale/pkg/pgclient"
+)
+
+// resetSetting is the value of a metric setting parameter resetting it to
+// the default one.
+const resetSetting = "default"
+
+// AdminMetricConfig returns the chunk interval, compression and retention of
+// the metric of the path with GET, and changes them with PUT. Changing them
+// requires the admin API to be enabled.
+func AdminMetricConfig(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminMetricConfigHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminMetricConfigHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metric := mux.Vars(r)["name"]
+		if r.Method == http.MethodGet {
+			settings, err := metricconfig.Get(r.Context(), client.MetadataConnection(), metric)
+			switch {
+			case err == nil:
+				respond(w, http.StatusOK, settings)
+			case errors.Is(err, metricconfig.ErrNotFound):
+				respondError(w, http.StatusNotFound, fmt.Errorf("metric %q not found", metric), "not_found")
+			default:
+				respondError(w, http.StatusInternalServerError, err, "internal")
+			}
+			return
+		}
+
+		if conf.ReadOnly {
+			respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot change the metric configuration"), "operation_not_permitted")
+			return
+		}
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("changing the metric configuration requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		update, err := parseMetricConfigUpdate(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		conn := client.MaintenanceConnection()
+		previous, err := metricconfig.Get(r.Context(), conn, metric)
+		switch {
+		case errors.Is(err, metricconfig.ErrNotFound):
+			// Settings can be set before the metric is ingested, but
+			// there is nothing to reset.
+			if update.ResetChunkInterval || update.ResetCompression || update.ResetRetention {
+				respondError(w, http.StatusNotFound, fmt.Errorf("metric %q not found", metric), "not_found")
+				return
+			}
+		case err != nil:
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		if err = metricconfig.Apply(r.Context(), conn, metric, update); err != nil {
+			log.Error("msg", "Failed to change metric configuration", "metric", metric, "changes", update.String(),
+				"remote_addr", r.RemoteAddr, "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		settings, err := metricconfig.Get(r.Context(), conn, metric)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		// The changes of the storage settings are kept in the logs, as
+		// the database does not record who made them.
+		log.Info("msg", "Changed metric configuration", "metric", metric, "changes", update.String(),
+			"previous", fmt.Sprintf("%+v", previous), "current", fmt.Sprintf("%+v", settings), "remote_addr", r.RemoteAddr)
+		respond(w, http.StatusOK, settings)
+	}
+}
+
+// parseMetricConfigUpdate parses the chunk_interval, compression and
+// retention parameters. A parameter set to "default" resets the setting to
+// the default one of the metrics.
+func parseMetricConfigUpdate(r *http.Request) (metricconfig.Update, error) {
+	var u metricconfig.Update
+	durations := []struct {
+		name  string
+		d     **time.Duration
+		reset *bool
+	}{
+		{"chunk_interval", &u.ChunkInterval, &u.ResetChunkInterval},
+		{"retention", &u.Retention, &u.ResetRetention},
+	}
+	for _, p := range durations {
+		switch s := r.FormValue(p.name); s {
+		case "":
+		case resetSetting:
+			*p.reset = true
+		default:
+			d, err := parseDuration(s)
+			if err != nil {
+				return u, fmt.Errorf("invalid %s %q: %w", p.name, s, err)
+			}
+			*p.d = &d
+		}
+	}
+	switch s := r.FormValue("compression"); s {
+	case "":
+	case resetSetting:
+		u.ResetCompression = true
+	default:
+		compression, err := strconv.ParseBool(s)
+		if err != nil {
+			return u, fmt.Errorf("invalid compression %q, must be true, false or %s", s, resetSetting)
+		}
+		u.Compression = &compression
+	}
+	return u, metricconfig.Validate(u)
+}
@@ -0,0 +1,68 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/metricconfig"
+)
+
+func TestParseMetricConfigUpdate(t *testing.T) {
+	day := 24 * time.Hour
+	retention := 90 * day
+	enabled := true
+	testCases := []struct {
+		name     string
+		query    string
+		expected metricconfig.Update
+		err      bool
+	}{
+		{
+			name:     "set all",
+			query:    "chunk_interval=1d&compression=true&retention=90d",
+			expected: metricconfig.Update{ChunkInterval: &day, Compression: &enabled, Retention: &retention},
+		},
+		{
+			name:     "reset",
+			query:    "chunk_interval=default&compression=default",
+			expected: metricconfig.Update{ResetChunkInterval: true, ResetCompression: true},
+		},
+		{
+			name:     "seconds",
+			query:    "retention=86400",
+			expected: metricconfig.Update{Retention: &day},
+		},
+		{name: "nothing to change", query: "", err: true},
+		{name: "invalid chunk interval", query: "chunk_interval=often", err: true},
+		{name: "invalid compression", query: "compression=maybe", err: true},
+		{name: "retention shorter than chunk interval", query: "chunk_interval=1d&retention=1h", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/metric/up/config?"+c.query, nil)
+			require.NoError(t, req.ParseForm())
+			u, err := parseMetricConfigUpdate(req)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, u)
+		})
+	}
+}
+
+func TestAdminMetricConfigPermissions(t *testing.T) {
+	for _, conf := range []*Config{{}, {AdminAPIEnabled: true, ReadOnly: true}} {
+		w := httptest.NewRecorder()
+		adminMetricConfigHandler(conf, nil).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/admin/metric/up/config?retention=1d", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	}
+}
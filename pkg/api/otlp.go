@@ -6,21 +6,158 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	semconv "go.opentelemetry.io/collector/semconv/v1.6.1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/ratelimit"
+)
+
+// Trailers describing the spans dropped from an accepted export request. The
+// OTLP version we implement has no partial success field in the response.
+const (
+	RejectedSpansTrailer  = "promscale-rejected-spans"
+	RejectedReasonTrailer = "promscale-rejected-reason"
 )
 
-func NewTraceServer(i ingestor.DBInserter) ptraceotlp.Server {
+// NewTraceServer returns the OTLP trace receiver. The spans of the services
+// exceeding their limit in limiter are dropped, a nil limiter allows all spans.
+func NewTraceServer(i ingestor.DBInserter, limiter *ratelimit.SpanLimiter) ptraceotlp.Server {
 	return &tracesServer{
 		ingestor: i,
+		limiter:  limiter,
 	}
 }
 
 type tracesServer struct {
 	ingestor ingestor.DBInserter
+	limiter  *ratelimit.SpanLimiter
 }
 
 func (t *tracesServer) Export(ctx context.Context, tr ptraceotlp.Request) (ptraceotlp.Response, error) {
-	return ptraceotlp.NewResponse(), t.ingestor.IngestTraces(ctx, tr.Traces())
+	traces := tr.Traces()
+	total := traces.SpanCount()
+	rejected := t.limit(traces)
+	if len(rejected) == 0 {
+		return ptraceotlp.NewResponse(), t.ingestor.IngestTraces(ctx, traces)
+	}
+
+	reason := rejectedReason(rejected, t.limiter)
+	if traces.SpanCount() == 0 {
+		return ptraceotlp.NewResponse(), status.Error(codes.ResourceExhausted, reason)
+	}
+	if err := t.ingestor.IngestTraces(ctx, traces); err != nil {
+		return ptraceotlp.NewResponse(), err
+	}
+	dropped := total - traces.SpanCount()
+	trailer := metadata.Pairs(RejectedSpansTrailer, strconv.Itoa(dropped), RejectedReasonTrailer, reason)
+	if err := grpc.SetTrailer(ctx, trailer); err != nil {
+		log.Debug("msg", "error setting rejected spans trailer", "err", err)
+	}
+	return ptraceotlp.NewResponse(), nil
+}
+
+// rejectedSpans are the spans of a service dropped by the span limiter.
+type rejectedSpans struct {
+	count      int
+	retryAfter time.Duration
+}
+
+// limit removes the spans exceeding the limit of their service from traces,
+// keeping the first spans of each service. It returns the rejected spans by
+// service.
+func (t *tracesServer) limit(traces ptrace.Traces) map[string]rejectedSpans {
+	if t.limiter == nil {
+		return nil
+	}
+	resourceSpans := traces.ResourceSpans()
+
+	counts := make(map[string]int)
+	for i := 0; i < resourceSpans.Len(); i++ {
+		rs := resourceSpans.At(i)
+		counts[serviceName(rs)] += resourceSpanCount(rs)
+	}
+
+	var rejected map[string]rejectedSpans
+	allowed := make(map[string]int, len(counts))
+	for service, n := range counts {
+		a, retryAfter := t.limiter.AllowSpans(service, n)
+		allowed[service] = a
+		if a < n {
+			if rejected == nil {
+				rejected = make(map[string]rejectedSpans)
+			}
+			rejected[service] = rejectedSpans{count: n - a, retryAfter: retryAfter}
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	resourceSpans.RemoveIf(func(rs ptrace.ResourceSpans) bool {
+		service := serviceName(rs)
+		if _, ok := rejected[service]; !ok {
+			return false
+		}
+		scopeSpans := rs.ScopeSpans()
+		scopeSpans.RemoveIf(func(ss ptrace.ScopeSpans) bool {
+			ss.Spans().RemoveIf(func(ptrace.Span) bool {
+				if allowed[service] > 0 {
+					allowed[service]--
+					return false
+				}
+				return true
+			})
+			return ss.Spans().Len() == 0
+		})
+		return scopeSpans.Len() == 0
+	})
+	return rejected
+}
+
+// rejectedReason describes the rejected spans, e.g.
+//
+//	120 spans of service "checkout" exceeded its limit of 100 spans/s, retry after 1.2s
+func rejectedReason(rejected map[string]rejectedSpans, limiter *ratelimit.SpanLimiter) string {
+	services := make([]string, 0, len(rejected))
+	for service := range rejected {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	reasons := make([]string, 0, len(services))
+	for _, service := range services {
+		r := rejected[service]
+		reasons = append(reasons, fmt.Sprintf("%d spans of service %q exceeded its limit of %v spans/s, retry after %s",
+			r.count, service, limiter.Limit(service), r.retryAfter.Round(time.Millisecond)))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func serviceName(rs ptrace.ResourceSpans) string {
+	if v, ok := rs.Resource().Attributes().Get(semconv.AttributeServiceName); ok {
+		return v.AsString()
+	}
+	return ""
+}
+
+func resourceSpanCount(rs ptrace.ResourceSpans) int {
+	n := 0
+	scopeSpans := rs.ScopeSpans()
+	for i := 0; i < scopeSpans.Len(); i++ {
+		n += scopeSpans.At(i).Spans().Len()
+	}
+	return n
 }
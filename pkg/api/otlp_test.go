@@ -0,0 +1,67 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/timescale/promscale/pkg/ratelimit"
+)
+
+func newTestTraces(spans map[string]int) ptrace.Traces {
+	traces := ptrace.NewTraces()
+	for service, n := range spans {
+		rs := traces.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().InsertString("service.name", service)
+		ss := rs.ScopeSpans().AppendEmpty()
+		for i := 0; i < n; i++ {
+			ss.Spans().AppendEmpty().SetName(service)
+		}
+	}
+	return traces
+}
+
+func TestTraceServerSpanLimits(t *testing.T) {
+	limitsFile := filepath.Join(t.TempDir(), "span_limits.yml")
+	require.NoError(t, os.WriteFile(limitsFile, []byte(`
+default:
+  span_rate: 10
+services:
+  unlimited:
+    span_rate: 0
+`), 0600))
+	limiter, err := ratelimit.NewSpanLimiter(&ratelimit.Config{SpanLimitsFile: limitsFile})
+	require.NoError(t, err)
+
+	inserter := &mockInserter{}
+	server := NewTraceServer(inserter, limiter)
+
+	// The spans over the limit are dropped, the others are ingested.
+	_, err = server.Export(context.Background(), ptraceotlp.NewRequestFromTraces(newTestTraces(map[string]int{"a": 15, "unlimited": 100})))
+	require.NoError(t, err)
+	require.Equal(t, 110, inserter.traces.SpanCount())
+
+	// Requests with only rejected spans fail.
+	inserter.traces = ptrace.NewTraces()
+	_, err = server.Export(context.Background(), ptraceotlp.NewRequestFromTraces(newTestTraces(map[string]int{"a": 5})))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), `5 spans of service "a" exceeded its limit of 10 spans/s`)
+	require.Equal(t, 0, inserter.traces.SpanCount())
+
+	// A nil limiter allows everything.
+	server = NewTraceServer(inserter, nil)
+	_, err = server.Export(context.Background(), ptraceotlp.NewRequestFromTraces(newTestTraces(map[string]int{"a": 50})))
+	require.NoError(t, err)
+	require.Equal(t, 50, inserter.traces.SpanCount())
+}
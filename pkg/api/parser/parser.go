@@ -8,6 +8,7 @@ import (
 	"github.com/timescale/promscale/pkg/api/parser/json"
 	"github.com/timescale/promscale/pkg/api/parser/protobuf"
 	"github.com/timescale/promscale/pkg/api/parser/text"
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/prompb"
 )
 
@@ -24,6 +25,7 @@ type Preprocessor interface {
 type DefaultParser struct {
 	preprocessors []Preprocessor
 	formatParsers map[string]formatParser
+	v2            protobuf.V2Parser
 }
 
 // NewParser returns a parser with the correct mapping of format and format parser.
@@ -46,10 +48,16 @@ func (p *DefaultParser) AddPreprocessor(pre Preprocessor) {
 	p.preprocessors = append(p.preprocessors, pre)
 }
 
+// SetCreatedTimestampZeroIngestion sets whether a sample of value 0 is added at
+// the created timestamp of the series of remote write 2.0 requests.
+func (p *DefaultParser) SetCreatedTimestampZeroIngestion(enabled bool) {
+	p.v2.CreatedTimestampZeroIngestion = enabled
+}
+
 // ParseRequest runs the correct parser on the format of the request and runs the
 // preprocessors on the payload afterwards.
 func (d DefaultParser) ParseRequest(r *http.Request, req *prompb.WriteRequest) error {
-	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		return fmt.Errorf("parser error: unable to parse format: %w", err)
 	}
@@ -57,6 +65,18 @@ func (d DefaultParser) ParseRequest(r *http.Request, req *prompb.WriteRequest) e
 	if !ok {
 		return fmt.Errorf("parser error: unsupported format")
 	}
+	// The labels of remote write 2.0 requests are already interned, with
+	// the symbol table of the request.
+	interned := false
+	if mediaType == "application/x-protobuf" {
+		switch proto := params["proto"]; proto {
+		case "", protobuf.ProtoV1:
+		case protobuf.ProtoV2:
+			parser, interned = d.v2.ParseRequest, true
+		default:
+			return fmt.Errorf("parser error: unsupported protobuf message %s", proto)
+		}
+	}
 
 	if err := parser(r, req); err != nil {
 		return fmt.Errorf("parser error: %w", err)
@@ -66,6 +86,26 @@ func (d DefaultParser) ParseRequest(r *http.Request, req *prompb.WriteRequest) e
 		return nil
 	}
 
+	// Parsed label strings end up in the series and label caches, share them
+	// with the ones already in memory instead of keeping a copy per request.
+	if !interned {
+		intern.WriteRequest(req)
+	}
+	return d.preprocess(r, req)
+}
+
+// Preprocess runs the preprocessors on a write request that was decoded
+// elsewhere, like the requests of the gRPC write service. The headers of r
+// are the ones of the request, its body is not read.
+func (d DefaultParser) Preprocess(r *http.Request, req *prompb.WriteRequest) error {
+	if len(req.Timeseries) == 0 {
+		return nil
+	}
+	intern.WriteRequest(req)
+	return d.preprocess(r, req)
+}
+
+func (d DefaultParser) preprocess(r *http.Request, req *prompb.WriteRequest) error {
 	// run preprocessors
 	for _, p := range d.preprocessors {
 		err := p.Process(r, req)
@@ -0,0 +1,318 @@
+package protobuf
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/value"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/timescale/promscale/pkg/intern"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// customBucketsSchema is the schema of the histograms with custom bucket
+// boundaries, like the classic histograms.
+const customBucketsSchema = -53
+
+// Promscale stores float samples only, the native histograms of remote write
+// 2.0 are converted to the _count, _sum and _bucket series of the classic
+// histograms, which histogram_quantile() can query.
+
+// histogram is a native histogram sample of a remote write 2.0 request.
+type histogram struct {
+	timestamp     int64
+	count         float64
+	sum           float64
+	schema        int32
+	zeroThreshold float64
+	zeroCount     float64
+	// The counts of the integer histograms are deltas to the previous
+	// bucket, they are accumulated after decoding.
+	integer        bool
+	negativeSpans  []bucketSpan
+	negativeDeltas []int64
+	negativeCounts []float64
+	positiveSpans  []bucketSpan
+	positiveDeltas []int64
+	positiveCounts []float64
+	customValues   []float64
+}
+
+type bucketSpan struct {
+	offset int32
+	length uint32
+}
+
+func decodeHistogram(b []byte) (histogram, error) {
+	var h histogram
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		var v uint64
+		switch num {
+		case 1:
+			v, n, err = consumeVarint(typ, b)
+			h.count, h.integer = float64(v), true
+		case 2:
+			h.count, n, err = consumeDouble(typ, b)
+		case 3:
+			h.sum, n, err = consumeDouble(typ, b)
+		case 4:
+			v, n, err = consumeVarint(typ, b)
+			h.schema = int32(protowire.DecodeZigZag(v & math.MaxUint32))
+		case 5:
+			h.zeroThreshold, n, err = consumeDouble(typ, b)
+		case 6:
+			v, n, err = consumeVarint(typ, b)
+			h.zeroCount = float64(v)
+		case 7:
+			h.zeroCount, n, err = consumeDouble(typ, b)
+		case 8:
+			h.negativeSpans, n, err = consumeSpan(h.negativeSpans, typ, b)
+		case 9:
+			h.negativeDeltas, n, err = consumeDeltas(h.negativeDeltas, typ, b)
+		case 10:
+			h.negativeCounts, n, err = consumePackedDoubles(h.negativeCounts, typ, b)
+		case 11:
+			h.positiveSpans, n, err = consumeSpan(h.positiveSpans, typ, b)
+		case 12:
+			h.positiveDeltas, n, err = consumeDeltas(h.positiveDeltas, typ, b)
+		case 13:
+			h.positiveCounts, n, err = consumePackedDoubles(h.positiveCounts, typ, b)
+		case 15:
+			v, n, err = consumeVarint(typ, b)
+			h.timestamp = int64(v)
+		case 16:
+			h.customValues, n, err = consumePackedDoubles(h.customValues, typ, b)
+		}
+		return n, err
+	})
+	if err != nil {
+		return h, fmt.Errorf("histogram: %w", err)
+	}
+	if h.integer {
+		h.negativeCounts = accumulate(h.negativeDeltas)
+		h.positiveCounts = accumulate(h.positiveDeltas)
+	}
+	return h, nil
+}
+
+func consumeSpan(dst []bucketSpan, typ protowire.Type, b []byte) ([]bucketSpan, int, error) {
+	msg, n, err := consumeBytes(typ, b)
+	if err != nil {
+		return dst, 0, err
+	}
+	var s bucketSpan
+	err = forEachField(msg, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		var v uint64
+		switch num {
+		case 1:
+			v, n, err = consumeVarint(typ, b)
+			s.offset = int32(protowire.DecodeZigZag(v & math.MaxUint32))
+		case 2:
+			v, n, err = consumeVarint(typ, b)
+			s.length = uint32(v)
+		}
+		return n, err
+	})
+	return append(dst, s), n, err
+}
+
+func consumeDeltas(dst []int64, typ protowire.Type, b []byte) ([]int64, int, error) {
+	values, n, err := consumePackedVarints(nil, typ, b)
+	for _, v := range values {
+		dst = append(dst, protowire.DecodeZigZag(v))
+	}
+	return dst, n, err
+}
+
+func accumulate(deltas []int64) []float64 {
+	counts := make([]float64, len(deltas))
+	var count int64
+	for i, d := range deltas {
+		count += d
+		counts[i] = float64(count)
+	}
+	return counts
+}
+
+// bucket is a bucket of a histogram and its upper bound.
+type bucket struct {
+	upper float64
+	count float64
+}
+
+// buckets returns the buckets of h sorted by upper bound, the last one is
+// the +Inf bucket.
+func (h histogram) buckets() ([]bucket, error) {
+	var buckets []bucket
+	if h.schema == customBucketsSchema {
+		err := forEachBucket(h.positiveSpans, h.positiveCounts, func(index int32, count float64) error {
+			switch {
+			case index < 0 || int(index) > len(h.customValues):
+				return fmt.Errorf("bucket index %d out of the %d custom values", index, len(h.customValues))
+			case int(index) == len(h.customValues):
+				buckets = append(buckets, bucket{upper: math.Inf(1), count: count})
+			default:
+				buckets = append(buckets, bucket{upper: h.customValues[index], count: count})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if h.schema < -4 || h.schema > 8 {
+			return nil, fmt.Errorf("unsupported histogram schema %d", h.schema)
+		}
+		// The negative bucket of index i ends at -base^(i-1).
+		err := forEachBucket(h.negativeSpans, h.negativeCounts, func(index int32, count float64) error {
+			buckets = append(buckets, bucket{upper: -exponentialBound(h.schema, index-1), count: count})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if h.zeroCount > 0 || h.zeroThreshold > 0 {
+			buckets = append(buckets, bucket{upper: h.zeroThreshold, count: h.zeroCount})
+		}
+		err = forEachBucket(h.positiveSpans, h.positiveCounts, func(index int32, count float64) error {
+			buckets = append(buckets, bucket{upper: exponentialBound(h.schema, index), count: count})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(buckets, func(i, j int) bool { return buckets[i].upper < buckets[j].upper })
+
+	var cumulative float64
+	for i := range buckets {
+		cumulative += buckets[i].count
+		buckets[i].count = cumulative
+	}
+	if n := len(buckets); n == 0 || !math.IsInf(buckets[n-1].upper, 1) {
+		buckets = append(buckets, bucket{upper: math.Inf(1), count: h.count})
+	}
+	return buckets, nil
+}
+
+// forEachBucket calls f with the index and count of each bucket of spans.
+func forEachBucket(spans []bucketSpan, counts []float64, f func(index int32, count float64) error) error {
+	var (
+		index int32
+		i     int
+	)
+	for _, span := range spans {
+		index += span.offset
+		for j := uint32(0); j < span.length; j++ {
+			if i >= len(counts) {
+				return fmt.Errorf("histogram spans of more buckets than the %d counts", len(counts))
+			}
+			if err := f(index, counts[i]); err != nil {
+				return err
+			}
+			index++
+			i++
+		}
+	}
+	if i != len(counts) {
+		return fmt.Errorf("histogram spans of %d buckets for %d counts", i, len(counts))
+	}
+	return nil
+}
+
+// exponentialBound returns the upper bound of the bucket index of the
+// exponential schema, base^index with base 2^(2^-schema).
+func exponentialBound(schema int32, index int32) float64 {
+	return math.Pow(2, math.Ldexp(float64(index), -int(schema)))
+}
+
+// convertHistograms returns the classic histogram series of the histograms
+// of the series of labels and metric name.
+func convertHistograms(labels []prompb.Label, name string, histograms []histogram, createdTS int64) ([]prompb.TimeSeries, error) {
+	var (
+		count   = prompb.TimeSeries{Labels: withName(labels, name+"_count")}
+		sum     = prompb.TimeSeries{Labels: withName(labels, name+"_sum")}
+		buckets = make(map[float64]*prompb.TimeSeries)
+		bounds  []float64
+	)
+	add := func(upper float64, s prompb.Sample) {
+		series, found := buckets[upper]
+		if !found {
+			series = &prompb.TimeSeries{Labels: withLe(withName(labels, name+"_bucket"), upper)}
+			buckets[upper] = series
+			bounds = append(bounds, upper)
+		}
+		series.Samples = append(series.Samples, s)
+	}
+
+	for _, h := range histograms {
+		if value.IsStaleNaN(h.sum) {
+			stale := prompb.Sample{Timestamp: h.timestamp, Value: math.Float64frombits(value.StaleNaN)}
+			count.Samples = append(count.Samples, stale)
+			sum.Samples = append(sum.Samples, stale)
+			add(math.Inf(1), stale)
+			continue
+		}
+		hb, err := h.buckets()
+		if err != nil {
+			return nil, err
+		}
+		count.Samples = append(count.Samples, prompb.Sample{Timestamp: h.timestamp, Value: h.count})
+		sum.Samples = append(sum.Samples, prompb.Sample{Timestamp: h.timestamp, Value: h.sum})
+		for _, b := range hb {
+			add(b.upper, prompb.Sample{Timestamp: h.timestamp, Value: b.count})
+		}
+	}
+
+	sort.Float64s(bounds)
+	converted := make([]prompb.TimeSeries, 0, len(bounds)+2)
+	converted = append(converted, count, sum)
+	for _, upper := range bounds {
+		converted = append(converted, *buckets[upper])
+	}
+	if createdTS != 0 {
+		for i := range converted {
+			if createdTS < converted[i].Samples[0].Timestamp {
+				converted[i].Samples = append([]prompb.Sample{{Timestamp: createdTS}}, converted[i].Samples...)
+			}
+		}
+	}
+	return converted, nil
+}
+
+// withName returns a copy of labels with the metric name name.
+func withName(labels []prompb.Label, name string) []prompb.Label {
+	named := make([]prompb.Label, len(labels), len(labels)+1)
+	copy(named, labels)
+	for i := range named {
+		if named[i].Name == "__name__" {
+			named[i].Value = intern.String(name)
+		}
+	}
+	return named
+}
+
+// withLe adds the le label of the bucket upper bound to labels, keeping them
+// sorted.
+func withLe(labels []prompb.Label, upper float64) []prompb.Label {
+	le := prompb.Label{Name: "le", Value: intern.String(formatBound(upper))}
+	i := sort.Search(len(labels), func(i int) bool { return labels[i].Name >= le.Name })
+	if i < len(labels) && labels[i].Name == le.Name {
+		labels[i] = le
+		return labels
+	}
+	labels = append(labels, prompb.Label{})
+	copy(labels[i+1:], labels[i:])
+	labels[i] = le
+	return labels
+}
+
+func formatBound(upper float64) string {
+	if math.IsInf(upper, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(upper, 'g', -1, 64)
+}
@@ -0,0 +1,338 @@
+package protobuf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/timescale/promscale/pkg/intern"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	// ProtoV1 and ProtoV2 are the proto parameters of the protobuf content
+	// types of the remote write 1.0 and 2.0 requests. The requests without
+	// proto parameter are remote write 1.0 requests.
+	ProtoV1 = "prometheus.WriteRequest"
+	ProtoV2 = "io.prometheus.write.v2.Request"
+)
+
+// Proto returns the proto parameter of the content type of r.
+func Proto(r *http.Request) string {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["proto"]
+}
+
+// Stats counts the data of a remote write 2.0 request, reported in the
+// X-Prometheus-Remote-Write-*-Written headers of the response.
+type Stats struct {
+	Samples    int
+	Histograms int
+	Exemplars  int
+}
+
+type statsKey struct{}
+
+// WithStats returns a context in which the remote write 2.0 parser counts the
+// data of the request in stats.
+func WithStats(ctx context.Context, stats *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, stats)
+}
+
+// V2Parser populates write requests from remote write 2.0 requests.
+type V2Parser struct {
+	// CreatedTimestampZeroIngestion adds a sample of value 0 at the created
+	// timestamp of the series, before their first sample.
+	CreatedTimestampZeroIngestion bool
+}
+
+// ParseRequest is responsible for populating the write request from the
+// data in the request in the remote write 2.0 format.
+func (p V2Parser) ParseRequest(r *http.Request, wr *prompb.WriteRequest) error {
+	b := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(b)
+	b.Reset()
+
+	if _, err := b.ReadFrom(r.Body); err != nil {
+		return fmt.Errorf("request body read error: %w", err)
+	}
+	stats, _ := r.Context().Value(statsKey{}).(*Stats)
+	if stats == nil {
+		stats = &Stats{}
+	}
+	d := v2Decoder{wr: wr, stats: stats, zeroCT: p.CreatedTimestampZeroIngestion, metadata: make(map[string]struct{})}
+	if err := d.decodeRequest(b.Bytes()); err != nil {
+		return fmt.Errorf("protobuf unmarshal error: %w", err)
+	}
+	return r.Body.Close()
+}
+
+type v2Decoder struct {
+	wr     *prompb.WriteRequest
+	stats  *Stats
+	zeroCT bool
+	// symbols are the interned strings of the symbol table of the request,
+	// the labels of all the series share them.
+	symbols []string
+	// metadata are the metric families whose metadata was already added.
+	metadata map[string]struct{}
+
+	// Buffers reused for every series.
+	refs []uint64
+}
+
+// decodeRequest decodes a io.prometheus.write.v2.Request. The symbol table
+// is decoded first since it may come after the series referring to it.
+func (d *v2Decoder) decodeRequest(b []byte) error {
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 4 {
+			return 0, nil
+		}
+		symbol, n, err := consumeBytes(typ, b)
+		if err != nil {
+			return 0, err
+		}
+		d.symbols = append(d.symbols, intern.String(string(symbol)))
+		return n, nil
+	})
+	if err != nil {
+		return fmt.Errorf("symbols: %w", err)
+	}
+	return forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 5 {
+			return 0, nil
+		}
+		ts, n, err := consumeBytes(typ, b)
+		if err != nil {
+			return 0, err
+		}
+		if err := d.decodeSeries(ts); err != nil {
+			return 0, fmt.Errorf("timeseries: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// v2Metadata is the metadata of a series, as symbol references.
+type v2Metadata struct {
+	typ              prompb.MetricMetadata_MetricType
+	helpRef, unitRef uint64
+}
+
+func (d *v2Decoder) decodeSeries(b []byte) error {
+	var (
+		series     = prompb.TimeSeries{}
+		histograms []histogram
+		metadata   v2Metadata
+		createdTS  int64
+	)
+	d.refs = d.refs[:0]
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		switch num {
+		case 1:
+			d.refs, n, err = consumePackedVarints(d.refs, typ, b)
+		case 2:
+			var sample []byte
+			if sample, n, err = consumeBytes(typ, b); err == nil {
+				var s prompb.Sample
+				s, err = decodeSample(sample)
+				series.Samples = append(series.Samples, s)
+			}
+		case 3:
+			var h []byte
+			if h, n, err = consumeBytes(typ, b); err == nil {
+				var hist histogram
+				hist, err = decodeHistogram(h)
+				histograms = append(histograms, hist)
+			}
+		case 4:
+			var e []byte
+			if e, n, err = consumeBytes(typ, b); err == nil {
+				var exemplar prompb.Exemplar
+				exemplar, err = d.decodeExemplar(e)
+				series.Exemplars = append(series.Exemplars, exemplar)
+			}
+		case 5:
+			var m []byte
+			if m, n, err = consumeBytes(typ, b); err == nil {
+				metadata, err = decodeMetadata(m)
+			}
+		case 6:
+			var v uint64
+			v, n, err = consumeVarint(typ, b)
+			createdTS = int64(v)
+		}
+		return n, err
+	})
+	if err != nil {
+		return err
+	}
+	if series.Labels, err = d.labels(d.refs); err != nil {
+		return err
+	}
+	name := metricName(series.Labels)
+	if name == "" {
+		return fmt.Errorf("series without metric name")
+	}
+	if err = d.addMetadata(name, metadata); err != nil {
+		return err
+	}
+
+	d.stats.Samples += len(series.Samples)
+	d.stats.Exemplars += len(series.Exemplars)
+	if len(series.Samples) > 0 && d.zeroCT && createdTS != 0 && createdTS < series.Samples[0].Timestamp {
+		series.Samples = append([]prompb.Sample{{Timestamp: createdTS}}, series.Samples...)
+	}
+	if len(series.Samples) > 0 || len(series.Exemplars) > 0 {
+		d.wr.Timeseries = append(d.wr.Timeseries, series)
+	}
+	if len(histograms) > 0 {
+		d.stats.Histograms += len(histograms)
+		if !d.zeroCT {
+			createdTS = 0
+		}
+		converted, err := convertHistograms(series.Labels, name, histograms, createdTS)
+		if err != nil {
+			return err
+		}
+		d.wr.Timeseries = append(d.wr.Timeseries, converted...)
+	}
+	return nil
+}
+
+func (d *v2Decoder) symbol(ref uint64) (string, error) {
+	if ref >= uint64(len(d.symbols)) {
+		return "", fmt.Errorf("symbol reference %d out of the %d symbols", ref, len(d.symbols))
+	}
+	return d.symbols[ref], nil
+}
+
+// labels returns the labels of the name and value references refs.
+func (d *v2Decoder) labels(refs []uint64) ([]prompb.Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label references: %d", len(refs))
+	}
+	labels := make([]prompb.Label, len(refs)/2)
+	for i := range labels {
+		name, err := d.symbol(refs[2*i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.symbol(refs[2*i+1])
+		if err != nil {
+			return nil, err
+		}
+		labels[i] = prompb.Label{Name: name, Value: value}
+	}
+	return labels, nil
+}
+
+// addMetadata adds the metadata of the metric family name to the request,
+// once per request.
+func (d *v2Decoder) addMetadata(name string, m v2Metadata) error {
+	if m.typ == prompb.MetricMetadata_UNKNOWN && m.helpRef == 0 && m.unitRef == 0 {
+		return nil
+	}
+	if _, found := d.metadata[name]; found {
+		return nil
+	}
+	help, err := d.symbol(m.helpRef)
+	if err != nil {
+		return err
+	}
+	unit, err := d.symbol(m.unitRef)
+	if err != nil {
+		return err
+	}
+	d.metadata[name] = struct{}{}
+	d.wr.Metadata = append(d.wr.Metadata, prompb.MetricMetadata{
+		Type:             m.typ,
+		MetricFamilyName: name,
+		Help:             help,
+		Unit:             unit,
+	})
+	return nil
+}
+
+func (d *v2Decoder) decodeExemplar(b []byte) (prompb.Exemplar, error) {
+	var (
+		e    prompb.Exemplar
+		refs []uint64
+	)
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		switch num {
+		case 1:
+			refs, n, err = consumePackedVarints(refs, typ, b)
+		case 2:
+			e.Value, n, err = consumeDouble(typ, b)
+		case 3:
+			var v uint64
+			v, n, err = consumeVarint(typ, b)
+			e.Timestamp = int64(v)
+		}
+		return n, err
+	})
+	if err != nil {
+		return e, fmt.Errorf("exemplar: %w", err)
+	}
+	e.Labels, err = d.labels(refs)
+	return e, err
+}
+
+func decodeSample(b []byte) (prompb.Sample, error) {
+	var s prompb.Sample
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		switch num {
+		case 1:
+			s.Value, n, err = consumeDouble(typ, b)
+		case 2:
+			var v uint64
+			v, n, err = consumeVarint(typ, b)
+			s.Timestamp = int64(v)
+		}
+		return n, err
+	})
+	if err != nil {
+		return s, fmt.Errorf("sample: %w", err)
+	}
+	return s, nil
+}
+
+func decodeMetadata(b []byte) (v2Metadata, error) {
+	var m v2Metadata
+	err := forEachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (n int, err error) {
+		var v uint64
+		switch num {
+		case 1:
+			v, n, err = consumeVarint(typ, b)
+			// The metric types of remote write 2.0 have the same values as
+			// the ones of remote write 1.0.
+			m.typ = prompb.MetricMetadata_MetricType(v)
+		case 3:
+			m.helpRef, n, err = consumeVarint(typ, b)
+		case 4:
+			m.unitRef, n, err = consumeVarint(typ, b)
+		}
+		return n, err
+	})
+	if err != nil {
+		return m, fmt.Errorf("metadata: %w", err)
+	}
+	return m, nil
+}
+
+func metricName(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,232 @@
+package protobuf
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// message encodes the remote write 2.0 messages of the tests.
+type message []byte
+
+func (m message) bytes(num protowire.Number, b []byte) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, b)
+}
+
+func (m message) varint(num protowire.Number, v uint64) message {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, v)
+}
+
+func (m message) double(num protowire.Number, v float64) message {
+	m = protowire.AppendTag(m, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(m, math.Float64bits(v))
+}
+
+func (m message) packed(num protowire.Number, values ...uint64) message {
+	var packed []byte
+	for _, v := range values {
+		packed = protowire.AppendVarint(packed, v)
+	}
+	return m.bytes(num, packed)
+}
+
+func sample(v float64, ts int64) message {
+	return message{}.double(1, v).varint(2, uint64(ts))
+}
+
+func zigzag(values ...int64) []uint64 {
+	encoded := make([]uint64, len(values))
+	for i, v := range values {
+		encoded[i] = protowire.EncodeZigZag(v)
+	}
+	return encoded
+}
+
+func request(symbols []string, series ...message) []byte {
+	var m message
+	for _, s := range symbols {
+		m = m.bytes(4, []byte(s))
+	}
+	for _, s := range series {
+		m = m.bytes(5, s)
+	}
+	return m
+}
+
+func parseV2(t *testing.T, p V2Parser, body []byte) (*prompb.WriteRequest, *Stats, error) {
+	stats := new(Stats)
+	r, err := http.NewRequest(http.MethodPost, "/write", bytes.NewReader(body))
+	require.NoError(t, err)
+	r = r.WithContext(WithStats(r.Context(), stats))
+	wr := new(prompb.WriteRequest)
+	err = p.ParseRequest(r, wr)
+	return wr, stats, err
+}
+
+func TestV2ParseRequest(t *testing.T) {
+	symbols := []string{"", "__name__", "http_requests_total", "job", "api", "trace_id", "abc", "Number of requests.", "requests"}
+	series := message{}.
+		packed(1, 1, 2, 3, 4).
+		bytes(2, sample(1, 1000)).
+		bytes(2, sample(3, 2000)).
+		bytes(4, message{}.packed(1, 5, 6).double(2, 2).varint(3, 1500)).
+		bytes(5, message{}.varint(1, uint64(prompb.MetricMetadata_COUNTER)).varint(3, 7).varint(4, 8)).
+		varint(6, 500)
+	// The symbols may follow the series.
+	body := append(request(nil, series), request(symbols)...)
+
+	labels := []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}}
+	expected := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:    labels,
+			Samples:   []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 3, Timestamp: 2000}},
+			Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 2, Timestamp: 1500}},
+		}},
+		Metadata: []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_COUNTER,
+			MetricFamilyName: "http_requests_total",
+			Help:             "Number of requests.",
+			Unit:             "requests",
+		}},
+	}
+	wr, stats, err := parseV2(t, V2Parser{}, body)
+	require.NoError(t, err)
+	require.Equal(t, expected, wr)
+	require.Equal(t, &Stats{Samples: 2, Exemplars: 1}, stats)
+
+	wr, _, err = parseV2(t, V2Parser{CreatedTimestampZeroIngestion: true}, body)
+	require.NoError(t, err)
+	require.Equal(t, []prompb.Sample{{Timestamp: 500}, {Value: 1, Timestamp: 1000}, {Value: 3, Timestamp: 2000}}, wr.Timeseries[0].Samples)
+}
+
+func TestV2ParseRequestErrors(t *testing.T) {
+	symbols := []string{"", "__name__", "up", "job"}
+	testCases := []struct {
+		name   string
+		series message
+	}{
+		{
+			name:   "symbol out of range",
+			series: message{}.packed(1, 1, 9).bytes(2, sample(1, 1000)),
+		},
+		{
+			name:   "odd number of references",
+			series: message{}.packed(1, 1, 2, 3).bytes(2, sample(1, 1000)),
+		},
+		{
+			name:   "no metric name",
+			series: message{}.packed(1, 3, 2).bytes(2, sample(1, 1000)),
+		},
+		{
+			name:   "wrong wire type",
+			series: message{}.packed(1, 1, 2).varint(2, 1),
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := parseV2(t, V2Parser{}, request(symbols, c.series))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestV2ParseHistograms(t *testing.T) {
+	symbols := []string{"", "__name__", "latency_seconds", "job", "api"}
+	labels := func(name, le string) []prompb.Label {
+		ls := []prompb.Label{{Name: "__name__", Value: name}, {Name: "job", Value: "api"}}
+		if le != "" {
+			ls = append(ls, prompb.Label{Name: "le", Value: le})
+		}
+		return ls
+	}
+	series := func(name, le string, samples ...prompb.Sample) prompb.TimeSeries {
+		return prompb.TimeSeries{Labels: labels(name, le), Samples: samples}
+	}
+	span := func(offset int64, length uint64) message {
+		return message{}.varint(1, protowire.EncodeZigZag(offset)).varint(2, length)
+	}
+
+	testCases := []struct {
+		name      string
+		histogram message
+		expected  []prompb.TimeSeries
+	}{
+		{
+			name: "exponential integer histogram",
+			// Schema 0, buckets (0.5, 1], (1, 2] and (4, 8], and the
+			// negative bucket [-1, -0.5).
+			histogram: message{}.
+				varint(1, 10).
+				double(3, 12.5).
+				varint(4, protowire.EncodeZigZag(0)).
+				double(5, 0.001).
+				varint(6, 1).
+				bytes(8, span(0, 1)).
+				packed(9, zigzag(1)...).
+				bytes(11, span(0, 2)).
+				bytes(11, span(1, 1)).
+				packed(12, zigzag(2, 1, 0)...).
+				varint(15, 1000),
+			expected: []prompb.TimeSeries{
+				series("latency_seconds_count", "", prompb.Sample{Value: 10, Timestamp: 1000}),
+				series("latency_seconds_sum", "", prompb.Sample{Value: 12.5, Timestamp: 1000}),
+				series("latency_seconds_bucket", "-0.5", prompb.Sample{Value: 1, Timestamp: 1000}),
+				series("latency_seconds_bucket", "0.001", prompb.Sample{Value: 2, Timestamp: 1000}),
+				series("latency_seconds_bucket", "1", prompb.Sample{Value: 4, Timestamp: 1000}),
+				series("latency_seconds_bucket", "2", prompb.Sample{Value: 7, Timestamp: 1000}),
+				series("latency_seconds_bucket", "8", prompb.Sample{Value: 10, Timestamp: 1000}),
+				series("latency_seconds_bucket", "+Inf", prompb.Sample{Value: 10, Timestamp: 1000}),
+			},
+		},
+		{
+			name: "custom buckets float histogram",
+			histogram: message{}.
+				double(2, 6).
+				double(3, 3).
+				varint(4, protowire.EncodeZigZag(customBucketsSchema)).
+				bytes(11, span(0, 3)).
+				double(13, 1).
+				double(13, 2).
+				double(13, 3).
+				double(16, 0.1).
+				double(16, 1).
+				varint(15, 1000),
+			expected: []prompb.TimeSeries{
+				series("latency_seconds_count", "", prompb.Sample{Value: 6, Timestamp: 1000}),
+				series("latency_seconds_sum", "", prompb.Sample{Value: 3, Timestamp: 1000}),
+				series("latency_seconds_bucket", "0.1", prompb.Sample{Value: 1, Timestamp: 1000}),
+				series("latency_seconds_bucket", "1", prompb.Sample{Value: 3, Timestamp: 1000}),
+				series("latency_seconds_bucket", "+Inf", prompb.Sample{Value: 6, Timestamp: 1000}),
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			body := request(symbols, message{}.packed(1, 1, 2, 3, 4).bytes(3, c.histogram))
+			wr, stats, err := parseV2(t, V2Parser{}, body)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, wr.Timeseries)
+			require.Equal(t, &Stats{Histograms: 1}, stats)
+		})
+	}
+
+	t.Run("stale histogram", func(t *testing.T) {
+		stale := message{}.varint(1, 0).double(3, math.Float64frombits(value.StaleNaN)).varint(15, 2000)
+		body := request(symbols, message{}.packed(1, 1, 2, 3, 4).bytes(3, stale))
+		wr, _, err := parseV2(t, V2Parser{}, body)
+		require.NoError(t, err)
+		require.Len(t, wr.Timeseries, 3)
+		for _, ts := range wr.Timeseries {
+			require.True(t, value.IsStaleNaN(ts.Samples[0].Value))
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package protobuf
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The remote write 2.0 messages are decoded field by field instead of being
+// unmarshalled into generated structs, so that the labels refer to the
+// strings of the symbol table instead of being allocated for every series.
+
+// forEachField calls f with each field of the message b. f returns the
+// number of bytes of the field value it consumed, the fields it does not
+// consume are skipped.
+func forEachField(b []byte, f func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := f(num, typ, b)
+		if err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeBytes(typ protowire.Type, b []byte) ([]byte, int, error) {
+	if typ != protowire.BytesType {
+		return nil, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, 0, protowire.ParseError(n)
+	}
+	return v, n, nil
+}
+
+func consumeVarint(typ protowire.Type, b []byte) (uint64, int, error) {
+	if typ != protowire.VarintType {
+		return 0, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, 0, protowire.ParseError(n)
+	}
+	return v, n, nil
+}
+
+func consumeDouble(typ protowire.Type, b []byte) (float64, int, error) {
+	if typ != protowire.Fixed64Type {
+		return 0, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeFixed64(b)
+	if n < 0 {
+		return 0, 0, protowire.ParseError(n)
+	}
+	return math.Float64frombits(v), n, nil
+}
+
+// consumePackedVarints appends the values of a repeated varint field, packed
+// or not, to dst.
+func consumePackedVarints(dst []uint64, typ protowire.Type, b []byte) ([]uint64, int, error) {
+	if typ == protowire.VarintType {
+		v, n, err := consumeVarint(typ, b)
+		return append(dst, v), n, err
+	}
+	packed, n, err := consumeBytes(typ, b)
+	if err != nil {
+		return dst, 0, err
+	}
+	for len(packed) > 0 {
+		v, m := protowire.ConsumeVarint(packed)
+		if m < 0 {
+			return dst, 0, protowire.ParseError(m)
+		}
+		dst = append(dst, v)
+		packed = packed[m:]
+	}
+	return dst, n, nil
+}
+
+// consumePackedDoubles appends the values of a repeated double field, packed
+// or not, to dst.
+func consumePackedDoubles(dst []float64, typ protowire.Type, b []byte) ([]float64, int, error) {
+	if typ == protowire.Fixed64Type {
+		v, n, err := consumeDouble(typ, b)
+		return append(dst, v), n, err
+	}
+	packed, n, err := consumeBytes(typ, b)
+	if err != nil {
+		return dst, 0, err
+	}
+	if len(packed)%8 != 0 {
+		return dst, 0, fmt.Errorf("packed doubles of invalid length %d", len(packed))
+	}
+	for ; len(packed) > 0; packed = packed[8:] {
+		v, _ := protowire.ConsumeFixed64(packed)
+		dst = append(dst, math.Float64frombits(v))
+	}
+	return dst, n, nil
+}
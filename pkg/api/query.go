@@ -60,6 +60,10 @@ func queryHandler(queryEngine *promql.Engine, queryable promql.Queryable, update
 		res := qry.Exec(ctx)
 		if res.Err != nil {
 			log.Error("msg", res.Err, "endpoint", "query")
+			if respondMemoryLimitError(w, res.Err) {
+				statusCode = "422"
+				return
+			}
 			switch res.Err.(type) {
 			case promql.ErrQueryCanceled:
 				statusCode = "503"
@@ -6,7 +6,9 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
@@ -14,15 +16,19 @@ import (
 
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/exemplar"
+	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
-func QueryExemplar(conf *Config, queryable promql.Queryable, updateMetrics func(handler, code string, duration float64)) http.Handler {
-	hf := corsWrapper(conf, queryExemplar(queryable, updateMetrics))
+// QueryExemplar returns the handler of the exemplar queries. With
+// with_traces=true, the spans referenced by the exemplars are read from the
+// trace tables through conn and returned with them.
+func QueryExemplar(conf *Config, queryable promql.Queryable, conn pgxconn.PgxConn, updateMetrics func(handler, code string, duration float64)) http.Handler {
+	hf := corsWrapper(conf, queryExemplar(queryable, conn, updateMetrics))
 	return gziphandler.GzipHandler(hf)
 }
 
-func queryExemplar(queryable promql.Queryable, updateMetrics func(handler, code string, duration float64)) http.HandlerFunc {
+func queryExemplar(queryable promql.Queryable, conn pgxconn.PgxConn, updateMetrics func(handler, code string, duration float64)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		statusCode := "400"
 		begin := time.Now()
@@ -48,6 +54,16 @@ func queryExemplar(queryable promql.Queryable, updateMetrics func(handler, code
 			return
 		}
 
+		withTraces := false
+		if v := r.FormValue("with_traces"); v != "" {
+			if withTraces, err = strconv.ParseBool(v); err != nil {
+				err = fmt.Errorf("invalid with_traces %q: %w", v, err)
+				log.Info("msg", "Exemplar query bad request:", "error", err)
+				respondError(w, http.StatusBadRequest, err, "bad_data")
+				return
+			}
+		}
+
 		ctx := r.Context()
 		if timeout := r.FormValue("timeout"); timeout != "" {
 			// Note: Prometheus does not implement timeout for querying exemplars.
@@ -71,6 +87,14 @@ func queryExemplar(queryable promql.Queryable, updateMetrics func(handler, code
 			respondError(w, http.StatusInternalServerError, err, "bad_data")
 			return
 		}
+		if withTraces {
+			if err = exemplar.LinkTraces(ctx, conn, results); err != nil {
+				statusCode = "500"
+				log.Error("msg", err, "endpoint", "query_exemplars")
+				respondError(w, http.StatusInternalServerError, err, "execution")
+				return
+			}
+		}
 		statusCode = "2xx"
 		respondExemplar(w, results)
 	}
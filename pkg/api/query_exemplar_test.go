@@ -22,6 +22,7 @@ func TestQueryExemplar(t *testing.T) {
 		end        string
 		timeout    string
 		query      string
+		withTraces string
 		statusCode int
 		shouldErr  bool
 		err        string
@@ -90,12 +91,22 @@ func TestQueryExemplar(t *testing.T) {
 			shouldErr:  true,
 			err:        `{"status":"error","errorType":"bad_data","error":"1:16: parse error: unexpected character inside braces: '~'"}`,
 		},
+		{
+			name:       "invalid_with_traces",
+			start:      "1617694947",
+			end:        "1625557347",
+			query:      "metric_name",
+			withTraces: "maybe",
+			statusCode: http.StatusBadRequest,
+			shouldErr:  true,
+			err:        `{"status":"error","errorType":"bad_data","error":"invalid with_traces \"maybe\": strconv.ParseBool: parsing \"maybe\": invalid syntax"}`,
+		},
 	}
 
 	queryable := query.NewQueryable(mockQuerier{}, nil)
 	for _, tc := range tcs {
-		handler := queryExemplar(queryable, mockUpdaterForQuery(&mockMetric{}, nil))
-		preparedURL := constructQueryExemplarRequest(tc.query, tc.start, tc.end, tc.timeout)
+		handler := queryExemplar(queryable, nil, mockUpdaterForQuery(&mockMetric{}, nil))
+		preparedURL := constructQueryExemplarRequest(tc.query, tc.start, tc.end, tc.timeout) + "&with_traces=" + tc.withTraces
 		r := doExemplarQuery(t, "GET", preparedURL, handler)
 		require.Equal(t, tc.statusCode, r.Code, fmt.Sprintf("received code %d, expected %d", r.Code, tc.statusCode), tc.name)
 		if tc.shouldErr {
@@ -0,0 +1,128 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+// The headers lowering the resource limits of a query below the configured
+// ones. They cannot raise them.
+const (
+	maxScannedSamplesHeader = "X-Promscale-Max-Scanned-Samples"
+	maxSeriesHeader         = "X-Promscale-Max-Series"
+	maxBytesHeader          = "X-Promscale-Max-Bytes"
+	maxMemoryHeader         = "X-Promscale-Max-Memory"
+	queryTimeoutHeader      = "X-Promscale-Query-Timeout"
+)
+
+// withQueryResourceLimits limits the data read by a query and the memory it
+// uses to the configured limits, lowered by the limit headers of the request.
+// The timeout header is applied like the 'timeout' parameter.
+func withQueryResourceLimits(promqlConf *query.Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits, maxMemory, err := parseQueryResourceLimits(r, promqlConf)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		if err = applyTimeoutHeader(r); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		ctx, memory := querier.WithQueryMemory(querier.WithLimits(r.Context(), limits), maxMemory)
+		defer memory.Release()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func parseQueryResourceLimits(r *http.Request, promqlConf *query.Config) (querier.Limits, int64, error) {
+	limits := querier.Limits{
+		MaxSamples: promqlConf.MaxScannedSamples,
+		MaxSeries:  promqlConf.MaxSeries,
+		MaxBytes:   promqlConf.MaxBytes,
+	}
+	maxMemory := promqlConf.MaxQueryMemory
+	headers := []struct {
+		name  string
+		value *int64
+	}{
+		{maxScannedSamplesHeader, &limits.MaxSamples},
+		{maxSeriesHeader, &limits.MaxSeries},
+		{maxBytesHeader, &limits.MaxBytes},
+		{maxMemoryHeader, &maxMemory},
+	}
+	for _, h := range headers {
+		v := r.Header.Get(h.name)
+		if v == "" {
+			continue
+		}
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 {
+			return limits, maxMemory, fmt.Errorf("invalid %s header %q, must be a positive integer", h.name, v)
+		}
+		if *h.value == 0 || limit < *h.value {
+			*h.value = limit
+		}
+	}
+	return limits, maxMemory, nil
+}
+
+// memoryLimitData details the memory limit exceeded by a query.
+type memoryLimitData struct {
+	LimitBytes int64 `json:"limitBytes"`
+	UsedBytes  int64 `json:"usedBytes"`
+}
+
+// respondMemoryLimitError responds with 422 if err is caused by a query
+// exceeding its memory limit and returns false otherwise.
+func respondMemoryLimitError(w http.ResponseWriter, err error) bool {
+	var memErr *querier.MemoryLimitError
+	if !errors.As(err, &memErr) {
+		return false
+	}
+	respondErrorWithData(w, http.StatusUnprocessableEntity, err, "memory_limit", memoryLimitData{LimitBytes: memErr.Limit, UsedBytes: memErr.Used})
+	return true
+}
+
+// respondLimitError responds with 422 if err is caused by a query exceeding
+// one of its resource limits and returns false otherwise.
+func respondLimitError(w http.ResponseWriter, err error) bool {
+	var limitErr *querier.LimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	respondError(w, http.StatusUnprocessableEntity, err, "execution")
+	return true
+}
+
+// applyTimeoutHeader sets the 'timeout' parameter of the request to the
+// timeout header, unless the parameter is shorter.
+func applyTimeoutHeader(r *http.Request) error {
+	v := r.Header.Get(queryTimeoutHeader)
+	if v == "" {
+		return nil
+	}
+	timeout, err := parseDuration(v)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid %s header %q, must be a positive duration", queryTimeoutHeader, v)
+	}
+	if err = r.ParseForm(); err != nil {
+		return err
+	}
+	if param := r.Form.Get("timeout"); param != "" {
+		if d, err := parseDuration(param); err == nil && d <= timeout {
+			return nil
+		}
+	}
+	r.Form.Set("timeout", v)
+	return nil
+}
@@ -0,0 +1,113 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+func TestParseQueryResourceLimits(t *testing.T) {
+	conf := &query.Config{MaxScannedSamples: 1000, MaxSeries: 10, MaxQueryMemory: 1 << 20}
+	testCases := []struct {
+		name      string
+		headers   map[string]string
+		expected  querier.Limits
+		maxMemory int64
+		err       bool
+	}{
+		{
+			name:      "configured",
+			expected:  querier.Limits{MaxSamples: 1000, MaxSeries: 10},
+			maxMemory: 1 << 20,
+		},
+		{
+			name:      "lowered",
+			headers:   map[string]string{maxScannedSamplesHeader: "100", maxBytesHeader: "4096", maxMemoryHeader: "65536"},
+			expected:  querier.Limits{MaxSamples: 100, MaxSeries: 10, MaxBytes: 4096},
+			maxMemory: 65536,
+		},
+		{
+			name:      "not raised",
+			headers:   map[string]string{maxSeriesHeader: "100", maxMemoryHeader: "4194304"},
+			expected:  querier.Limits{MaxSamples: 1000, MaxSeries: 10},
+			maxMemory: 1 << 20,
+		},
+		{
+			name:    "invalid",
+			headers: map[string]string{maxSeriesHeader: "-1"},
+			err:     true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			limits, maxMemory, err := parseQueryResourceLimits(r, conf)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, limits)
+			require.Equal(t, c.maxMemory, maxMemory)
+		})
+	}
+}
+
+func TestApplyTimeoutHeader(t *testing.T) {
+	testCases := []struct {
+		name     string
+		url      string
+		header   string
+		expected string
+		err      bool
+	}{
+		{name: "no header", url: "/api/v1/query?timeout=10s", expected: "10s"},
+		{name: "header", url: "/api/v1/query", header: "5s", expected: "5s"},
+		{name: "shorter header", url: "/api/v1/query?timeout=10s", header: "5s", expected: "5s"},
+		{name: "shorter parameter", url: "/api/v1/query?timeout=1s", header: "5s", expected: "1s"},
+		{name: "invalid", url: "/api/v1/query", header: "soon", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, c.url, nil)
+			if c.header != "" {
+				r.Header.Set(queryTimeoutHeader, c.header)
+			}
+			err := applyTimeoutHeader(r)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, r.FormValue("timeout"))
+		})
+	}
+}
+
+func TestRespondMemoryLimitError(t *testing.T) {
+	w := httptest.NewRecorder()
+	require.False(t, respondMemoryLimitError(w, fmt.Errorf("other error")))
+
+	w = httptest.NewRecorder()
+	require.True(t, respondMemoryLimitError(w, fmt.Errorf("expanding series: %w", &querier.MemoryLimitError{Limit: 1024, Used: 2048})))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.JSONEq(t, `{
+		"status": "error",
+		"errorType": "memory_limit",
+		"error": "expanding series: query exceeded the memory limit of 1024 bytes, using about 2048 bytes",
+		"data": {"limitBytes": 1024, "usedBytes": 2048}
+	}`, w.Body.String())
+}
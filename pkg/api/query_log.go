@@ -0,0 +1,95 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/querylog"
+)
+
+// withQueryLog logs a record of every query served by the handler, with the
+// stats collected by the querier while running it.
+func withQueryLog(logger *querylog.Logger, endpoint string, handler http.Handler) http.Handler {
+	if logger == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// The stats may already be collected for the audit log.
+		ctx, stats := r.Context(), querylog.FromContext(r.Context())
+		if stats == nil {
+			ctx, stats = querylog.WithStats(ctx)
+		}
+		memory := querier.QueryMemoryFromContext(ctx)
+		if memory == nil {
+			// Remote read has no memory limit, the memory is only
+			// accounted for the log.
+			ctx, memory = querier.WithQueryMemory(ctx, 0)
+			defer memory.Release()
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r.WithContext(ctx))
+
+		record := querylog.Record{
+			Time:            start.UTC(),
+			Endpoint:        endpoint,
+			Tenant:          getLimitedTenant(r),
+			Status:          rec.status,
+			DurationSeconds: time.Since(start).Seconds(),
+			PeakMemoryBytes: memory.Peak(),
+		}
+		setQueryParams(&record, r, start)
+		logger.Log(record, stats)
+	})
+}
+
+// setQueryParams sets the expression, time range and step of a PromQL query
+// to the record. Remote read requests have none of them.
+func setQueryParams(record *querylog.Record, r *http.Request, now time.Time) {
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	record.Expr = r.Form.Get("query")
+	if record.Expr == "" {
+		return
+	}
+	if t := r.Form.Get("time"); t != "" || r.Form.Get("start") == "" {
+		ts, err := parseTimeParam(r, "time", now)
+		if err == nil {
+			record.Start, record.End = ts.UTC(), ts.UTC()
+		}
+		return
+	}
+	if start, err := parseTime(r.Form.Get("start")); err == nil {
+		record.Start = start.UTC()
+	}
+	if end, err := parseTime(r.Form.Get("end")); err == nil {
+		record.End = end.UTC()
+	}
+	if step, err := parseDuration(r.Form.Get("step")); err == nil {
+		record.StepSeconds = step.Seconds()
+	}
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher, which streamed remote read needs.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,52 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/querylog"
+)
+
+func TestWithQueryLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query.log")
+	logger, err := querylog.New(querylog.Config{File: file})
+	require.NoError(t, err)
+
+	handler := withQueryLog(logger, "query_range", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotNil(t, querylog.FromContext(r.Context()))
+		require.NoError(t, querier.QueryMemoryFromContext(r.Context()).AddDecoded(4096))
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?query=up&start=60&end=120&step=15s", nil)
+	r.Header.Set("TENANT", "tenant-a")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var record querylog.Record
+	require.NoError(t, json.Unmarshal(data, &record))
+	require.Equal(t, "query_range", record.Endpoint)
+	require.Equal(t, "up", record.Expr)
+	require.Equal(t, time.Unix(60, 0).UTC(), record.Start)
+	require.Equal(t, time.Unix(120, 0).UTC(), record.End)
+	require.Equal(t, 15.0, record.StepSeconds)
+	require.Equal(t, "tenant-a", record.Tenant)
+	require.Equal(t, http.StatusUnprocessableEntity, record.Status)
+	require.Equal(t, int64(4096), record.PeakMemoryBytes)
+
+	// A disabled query log leaves the handler as is.
+	require.Nil(t, withQueryLog(nil, "query", nil))
+}
@@ -8,22 +8,25 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/pkg/errors"
 
 	"github.com/timescale/promscale/pkg/log"
+	pgMetrics "github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/promql"
 	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/rules"
 )
 
 func QueryRange(conf *Config, promqlConf *query.Config, queryEngine *promql.Engine, queryable promql.Queryable, updateMetrics func(handler, code string, duration float64)) http.Handler {
-	hf := corsWrapper(conf, queryRange(promqlConf, queryEngine, queryable, updateMetrics))
+	hf := corsWrapper(conf, queryRange(conf, promqlConf, queryEngine, queryable, updateMetrics))
 	return gziphandler.GzipHandler(hf)
 }
 
-func queryRange(promqlConf *query.Config, queryEngine *promql.Engine, queryable promql.Queryable, updateMetrics func(handler, code string, duration float64)) http.HandlerFunc {
+func queryRange(conf *Config, promqlConf *query.Config, queryEngine *promql.Engine, queryable promql.Queryable, updateMetrics func(handler, code string, duration float64)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		statusCode := "400"
 		begin := time.Now()
@@ -74,9 +77,10 @@ func queryRange(promqlConf *query.Config, queryEngine *promql.Engine, queryable
 		}
 
 		ctx := r.Context()
+		var timeout time.Duration
 		if to := r.FormValue("timeout"); to != "" {
 			var cancel context.CancelFunc
-			timeout, err := parseDuration(to)
+			timeout, err = parseDuration(to)
 			if err != nil {
 				log.Info("msg", "Query bad request"+err.Error())
 				respondError(w, http.StatusBadRequest, err, "bad_data")
@@ -104,8 +108,19 @@ func queryRange(promqlConf *query.Config, queryEngine *promql.Engine, queryable
 
 		res := qry.Exec(ctx)
 
+		downsampled := false
+		if res.Err != nil && promqlConf.SpilloverToRollups && isQueryTimeout(res.Err) && conf.Rules != nil {
+			if spilled := spillover(r, conf.Rules.Rollups(), queryEngine, queryable, start, end, step, timeout); spilled != nil {
+				res, downsampled = spilled, true
+			}
+		}
+
 		if res.Err != nil {
 			log.Error("msg", res.Err, "endpoint", "query_range")
+			if respondMemoryLimitError(w, res.Err) {
+				statusCode = "422"
+				return
+			}
 			switch res.Err.(type) {
 			case promql.ErrQueryCanceled:
 				statusCode = "503"
@@ -125,6 +140,61 @@ func queryRange(promqlConf *query.Config, queryEngine *promql.Engine, queryable
 			return
 		}
 		statusCode = "2xx"
+		if downsampled {
+			w.Header().Set(downsampledHeader, "true")
+		}
 		respondQuery(w, res, res.Warnings)
 	}
 }
+
+// downsampledHeader flags the responses computed from the rollups instead of
+// the raw series.
+const downsampledHeader = "X-Promscale-Downsampled"
+
+func isQueryTimeout(err error) bool {
+	switch e := err.(type) {
+	case promql.ErrQueryTimeout:
+		return true
+	case promql.ErrStorage:
+		return errors.Is(e.Err, context.DeadlineExceeded)
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// spillover retries a range query that timed out with the subexpressions
+// recorded by a rollup replaced by the recorded metric. The step is raised to
+// the resolution of the rollups. It returns nil if the query reads no rollup
+// or if the retry fails too, in which case the original error is returned.
+func spillover(r *http.Request, rollups *rules.Rollups, queryEngine *promql.Engine, queryable promql.Queryable, start, end time.Time, step, timeout time.Duration) *promql.Result {
+	rewritten, metrics, interval, err := rollups.Rewrite(r.FormValue("query"))
+	if err != nil || len(metrics) == 0 {
+		pgMetrics.QuerySpillovers.WithLabelValues("no_rollup").Inc()
+		return nil
+	}
+	if interval > step {
+		step = interval
+	}
+	// The deadline of the request has passed, the retry gets a new one.
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	qry, err := queryEngine.NewRangeQuery(queryable, &promql.QueryOpts{EnablePerStepStats: true}, rewritten, start, end, step)
+	if err != nil {
+		log.Error("msg", "Failed to rewrite query against rollups", "query", r.FormValue("query"), "rewritten", rewritten, "err", err)
+		pgMetrics.QuerySpillovers.WithLabelValues("failed").Inc()
+		return nil
+	}
+	res := qry.Exec(ctx)
+	if res.Err != nil {
+		log.Warn("msg", "Query against rollups failed", "query", rewritten, "err", res.Err)
+		pgMetrics.QuerySpillovers.WithLabelValues("failed").Inc()
+		return nil
+	}
+	log.Debug("msg", "Query timed out, answered from rollups", "query", r.FormValue("query"), "rewritten", rewritten)
+	pgMetrics.QuerySpillovers.WithLabelValues("success").Inc()
+	res.Warnings = append(res.Warnings, fmt.Errorf("query timed out on raw data, results were computed from the downsampled series %s at a %s resolution", strings.Join(metrics, ", "), step))
+	return res
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/prompb"
@@ -88,6 +89,7 @@ func (me mockExemplarQuerier) Select(_, _ time.Time, _ ...[]*labels.Matcher) ([]
 type mockLabelsReader struct {
 	labelNames    []string
 	labelNamesErr error
+	labelValues   []string
 }
 
 func (m mockLabelsReader) LabelNames() ([]string, error) {
@@ -95,7 +97,11 @@ func (m mockLabelsReader) LabelNames() ([]string, error) {
 }
 
 func (m mockLabelsReader) LabelValues(string) ([]string, error) {
-	return nil, nil
+	return m.labelValues, nil
+}
+
+func (m mockLabelsReader) SearchLabelValues(_, prefix string, limit int) ([]string, error) {
+	return lreader.SearchSorted(m.labelValues, prefix, limit), nil
 }
 
 func (m mockLabelsReader) LabelsForIdMap(idMap map[int64]labels.Label) (err error) {
@@ -5,6 +5,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/ha"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/querier"
@@ -66,36 +68,174 @@ func Read(config *Config, reader querier.Reader, metrics *Metrics, updateMetrics
 			}
 		}
 
-		var resp *prompb.ReadResponse
-		resp, err = reader.Read(r.Context(), &req)
+		responseType, err := negotiateReadResponseType(req.AcceptedResponseTypes)
 		if err != nil {
-			statusCode = "500"
-			log.Warn("msg", "Error executing query", "query", req, "storage", "PostgreSQL", "err", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Error("msg", "Read response type error", "err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		data, err := proto.Marshal(resp)
-		if err != nil {
-			statusCode = "500"
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		switch responseType {
+		case prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+			statusCode = readStreamedXORChunks(w, r, &req, reader, config.ReadMaxBytesInFrame)
+		default:
+			statusCode = readSamples(w, r, &req, reader)
 		}
+	})
+}
 
-		w.Header().Set("Content-Type", "application/x-protobuf")
-		w.Header().Set("Content-Encoding", "snappy")
-
-		compressed = snappy.Encode(nil, data)
-		if _, err := w.Write(compressed); err != nil {
-			// Most likely the request was cancelled from client side.
-			// We use a non-standard code so we can distinguish from actual
-			// internal server errors.
-			statusCode = "499"
-			log.Warn("msg", "Error writing HTTP response", "err", err)
-			return
+// negotiateReadResponseType returns the first accepted response type that is supported.
+// An empty list means the client only supports SAMPLES, which was the only response
+// type before streaming was added to the protocol.
+func negotiateReadResponseType(accepted []prompb.ReadRequest_ResponseType) (prompb.ReadRequest_ResponseType, error) {
+	if len(accepted) == 0 {
+		return prompb.ReadRequest_SAMPLES, nil
+	}
+	for _, responseType := range accepted {
+		switch responseType {
+		case prompb.ReadRequest_SAMPLES, prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+			return responseType, nil
 		}
-		statusCode = "2xx"
+	}
+	return 0, fmt.Errorf("none of the requested response types are supported: %v", accepted)
+}
+
+// readSamples responds with all the samples of the read request in a single
+// snappy compressed ReadResponse.
+func readSamples(w http.ResponseWriter, r *http.Request, req *prompb.ReadRequest, reader querier.Reader) (statusCode string) {
+	resp, err := reader.Read(r.Context(), req)
+	if err != nil {
+		log.Warn("msg", "Error executing query", "query", req, "storage", "PostgreSQL", "err", err)
+		return respondReadError(w, err)
+	}
+
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "500"
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+
+	compressed := snappy.Encode(nil, data)
+	if _, err := w.Write(compressed); err != nil {
+		// Most likely the request was cancelled from client side.
+		// We use a non-standard code so we can distinguish from actual
+		// internal server errors.
+		log.Warn("msg", "Error writing HTTP response", "err", err)
+		return "499"
+	}
+	return "2xx"
+}
+
+// readStreamedXORChunks streams the series of the read request as a sequence of
+// ChunkedReadResponse frames, so neither side has to hold the whole response in
+// memory. Each frame holds at most one series and series with a lot of samples
+// are split across frames of about maxBytesInFrame.
+func readStreamedXORChunks(w http.ResponseWriter, r *http.Request, req *prompb.ReadRequest, reader querier.Reader, maxBytesInFrame int) (statusCode string) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "internal http.ResponseWriter does not implement http.Flusher interface", http.StatusInternalServerError)
+		return "500"
+	}
+	w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+
+	stream := newChunkedWriter(w, f)
+	err := reader.ReadChunks(r.Context(), req, func(queryIndex int, ss querier.ChunkSeriesSet) error {
+		return streamChunkedReadResponses(stream, int64(queryIndex), ss, maxBytesInFrame)
 	})
+	if errors.As(err, &streamWriteError{}) {
+		// Most likely the request was cancelled from client side.
+		log.Warn("msg", "Error writing HTTP response", "err", err)
+		return "499"
+	}
+	if err != nil {
+		log.Warn("msg", "Error executing query", "query", req, "storage", "PostgreSQL", "err", err)
+		return respondReadError(w, err)
+	}
+	return "2xx"
+}
+
+// respondReadError responds with 422 if the read request exceeded one of the
+// query resource limits, and with 500 otherwise.
+func respondReadError(w http.ResponseWriter, err error) (statusCode string) {
+	var (
+		limitErr  *querier.LimitError
+		memoryErr *querier.MemoryLimitError
+	)
+	if errors.As(err, &limitErr) || errors.As(err, &memoryErr) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return "422"
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+	return "500"
+}
+
+// streamWriteError is returned when a frame cannot be written to the response.
+type streamWriteError struct {
+	err error
+}
+
+func (e streamWriteError) Error() string {
+	return fmt.Sprintf("write to stream: %s", e.err)
+}
+
+func (e streamWriteError) Unwrap() error {
+	return e.err
+}
+
+func streamChunkedReadResponses(stream io.Writer, queryIndex int64, ss storage.ChunkSeriesSet, maxBytesInFrame int) error {
+	var chks []prompb.Chunk
+	for ss.Next() {
+		series := ss.At()
+		lbls := make([]prompb.Label, 0, len(series.Labels()))
+		labelsSize := 0
+		for _, l := range series.Labels() {
+			lbls = append(lbls, prompb.Label{Name: l.Name, Value: l.Value})
+			labelsSize += lbls[len(lbls)-1].Size()
+		}
+		frameBytesLeft := maxBytesInFrame - labelsSize
+
+		iter := series.Iterator()
+		isNext := iter.Next()
+		for isNext {
+			chk := iter.At()
+			if chk.Chunk == nil {
+				return fmt.Errorf("found not populated chunk returned by series set at ref: %v", chk.Ref)
+			}
+			chks = append(chks, prompb.Chunk{
+				MinTimeMs: chk.MinTime,
+				MaxTimeMs: chk.MaxTime,
+				Type:      prompb.Chunk_Encoding(chk.Chunk.Encoding()),
+				Data:      chk.Chunk.Bytes(),
+			})
+			frameBytesLeft -= chks[len(chks)-1].Size()
+
+			// The frame can exceed maxBytesInFrame by at most one chunk.
+			isNext = iter.Next()
+			if frameBytesLeft > 0 && isNext {
+				continue
+			}
+
+			b, err := proto.Marshal(&prompb.ChunkedReadResponse{
+				ChunkedSeries: []*prompb.ChunkedSeries{{Labels: lbls, Chunks: chks}},
+				QueryIndex:    queryIndex,
+			})
+			if err != nil {
+				return fmt.Errorf("marshal ChunkedReadResponse: %w", err)
+			}
+			if _, err := stream.Write(b); err != nil {
+				return streamWriteError{err}
+			}
+			chks = chks[:0]
+			frameBytesLeft = maxBytesInFrame - labelsSize
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return ss.Err()
 }
 
 func validateReadHeaders(w http.ResponseWriter, r *http.Request) bool {
@@ -0,0 +1,52 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+)
+
+// castagnoliTable is the CRC-32 table used to checksum the frames of a
+// streamed remote read response.
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkedWriter writes the frames of a streamed remote read response and
+// flushes each of them. It implements the framing of the Prometheus remote
+// read protocol, which is not imported from Prometheus because its remote
+// package registers the Prometheus protobufs, conflicting with ours.
+type chunkedWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func newChunkedWriter(w io.Writer, f http.Flusher) *chunkedWriter {
+	return &chunkedWriter{writer: w, flusher: f}
+}
+
+// Write writes b as a single frame made of the uvarint size of b, the
+// big-endian CRC-32 Castagnoli checksum of b and b itself. It returns the
+// number of bytes of b written.
+func (w *chunkedWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var header [binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(header[:], uint64(len(b)))
+	binary.BigEndian.PutUint32(header[n:], crc32.Checksum(b, castagnoliTable))
+	if _, err := w.writer.Write(header[:n+4]); err != nil {
+		return 0, err
+	}
+
+	n, err := w.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	w.flusher.Flush()
+	return n, nil
+}
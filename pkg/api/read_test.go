@@ -5,15 +5,23 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/prompb"
 )
 
@@ -119,6 +127,7 @@ func readRequestToString(r *prompb.ReadRequest) string {
 type mockReader struct {
 	request  *prompb.ReadRequest
 	response *prompb.ReadResponse
+	series   []storage.ChunkSeries
 	err      error
 }
 
@@ -127,6 +136,172 @@ func (m *mockReader) Read(_ context.Context, r *prompb.ReadRequest) (*prompb.Rea
 	return m.response, m.err
 }
 
+func (m *mockReader) ReadChunks(_ context.Context, r *prompb.ReadRequest, streamFn func(int, querier.ChunkSeriesSet) error) error {
+	m.request = r
+	if m.err != nil {
+		return m.err
+	}
+	for i := range r.Queries {
+		if err := streamFn(i, &mockChunkSeriesSet{series: m.series, cur: -1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mockChunkSeriesSet struct {
+	series []storage.ChunkSeries
+	cur    int
+}
+
+func (m *mockChunkSeriesSet) Next() bool {
+	m.cur++
+	return m.cur < len(m.series)
+}
+
+func (m *mockChunkSeriesSet) At() storage.ChunkSeries    { return m.series[m.cur] }
+func (m *mockChunkSeriesSet) Err() error                 { return nil }
+func (m *mockChunkSeriesSet) Warnings() storage.Warnings { return nil }
+func (m *mockChunkSeriesSet) Close()                     {}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestReadStreamedXORChunks(t *testing.T) {
+	samples := make([]tsdbutil.Sample, 300)
+	for i := range samples {
+		samples[i] = sample{t: int64(i), v: float64(i)}
+	}
+	mockReader := &mockReader{
+		series: []storage.ChunkSeries{
+			storage.NewListChunkSeriesFromSamples(labels.FromStrings("__name__", "a"), samples[:10]),
+			// Three chunks, which do not fit into a single frame.
+			storage.NewListChunkSeriesFromSamples(labels.FromStrings("__name__", "b"), samples[:120], samples[120:240], samples[240:]),
+		},
+	}
+	metrics = &Metrics{
+		RemoteReadReceivedQueries: &mockMetric{},
+	}
+	handler := Read(&Config{ReadMaxBytesInFrame: 300}, mockReader, metrics, mockUpdaterForQuery(&mockMetric{}, &mockMetric{}))
+
+	test := GenerateReadHandleTester(t, handler, false)
+	w := test("POST", getReader(readRequestToString(&prompb.ReadRequest{
+		Queries:               []*prompb.Query{{}, {}},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS, prompb.ReadRequest_SAMPLES},
+	})))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status code received: got %d wanted %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse" {
+		t.Fatalf("Unexpected content type: %s", ct)
+	}
+
+	type frame struct {
+		queryIndex int64
+		metric     string
+		chunks     int
+	}
+	var got []frame
+	reader := bufio.NewReader(w.Body)
+	for {
+		b, err := readChunkedFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading frame: %s", err)
+		}
+		var resp prompb.ChunkedReadResponse
+		if err = proto.Unmarshal(b, &resp); err != nil {
+			t.Fatalf("unexpected error decoding frame: %s", err)
+		}
+		for _, s := range resp.ChunkedSeries {
+			for _, c := range s.Chunks {
+				if c.Type != prompb.Chunk_XOR {
+					t.Fatalf("unexpected chunk encoding: %s", c.Type)
+				}
+			}
+			got = append(got, frame{queryIndex: resp.QueryIndex, metric: s.Labels[0].Value, chunks: len(s.Chunks)})
+		}
+	}
+
+	expected := []frame{
+		{0, "a", 1}, {0, "b", 2}, {0, "b", 1},
+		{1, "a", 1}, {1, "b", 2}, {1, "b", 1},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected frames:\ngot    %v\nwanted %v", got, expected)
+	}
+}
+
+// readChunkedFrame reads a frame written by chunkedWriter and checks its
+// checksum.
+func readChunkedFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var checksum uint32
+	if err = binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(b, castagnoliTable) != checksum {
+		return nil, fmt.Errorf("frame checksum mismatch")
+	}
+	return b, nil
+}
+
+func TestNegotiateReadResponseType(t *testing.T) {
+	testCases := []struct {
+		name     string
+		accepted []prompb.ReadRequest_ResponseType
+		expected prompb.ReadRequest_ResponseType
+		err      bool
+	}{
+		{
+			name:     "no accepted types",
+			expected: prompb.ReadRequest_SAMPLES,
+		},
+		{
+			name:     "streamed preferred",
+			accepted: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS, prompb.ReadRequest_SAMPLES},
+			expected: prompb.ReadRequest_STREAMED_XOR_CHUNKS,
+		},
+		{
+			name:     "samples preferred",
+			accepted: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES, prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+			expected: prompb.ReadRequest_SAMPLES,
+		},
+		{
+			name:     "unsupported type",
+			accepted: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_ResponseType(42)},
+			err:      true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := negotiateReadResponseType(c.accepted)
+			if (err != nil) != c.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && got != c.expected {
+				t.Fatalf("unexpected response type: got %s wanted %s", got, c.expected)
+			}
+		})
+	}
+}
+
 func GenerateReadHandleTester(t *testing.T, handleFunc http.Handler, badHeader bool) HandleTester {
 	return func(method string, body io.Reader) *httptest.ResponseRecorder {
 		req, err := http.NewRequest(method, "", body)
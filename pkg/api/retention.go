@@ -0,0 +1,116 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+)
+
+const (
+	defaultRetentionSQL    = "SELECT extract(epoch FROM _prom_catalog.get_default_retention_period())::float8"
+	metricRetentionSQL     = "SELECT metric_name, extract(epoch FROM retention_period)::float8 FROM _prom_catalog.metric WHERE retention_period IS NOT NULL ORDER BY metric_name"
+	setDefaultRetentionSQL = "SELECT prom_api.set_default_retention_period($1)"
+	setMetricRetentionSQL  = "SELECT prom_api.set_metric_retention_period($1, $2)"
+	resetRetentionSQL      = "SELECT prom_api.reset_metric_retention_period($1)"
+)
+
+type retentionResult struct {
+	DefaultSeconds float64            `json:"defaultSeconds"`
+	Metrics        map[string]float64 `json:"metrics"`
+}
+
+// Retention returns the default retention period of the metrics and the
+// metrics overriding it, in seconds.
+func Retention(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, retentionHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func retentionHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn := client.ReadOnlyConnection()
+		res := retentionResult{Metrics: make(map[string]float64)}
+		if err := conn.QueryRow(r.Context(), defaultRetentionSQL).Scan(&res.DefaultSeconds); err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		rows, err := conn.Query(r.Context(), metricRetentionSQL)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				metric  string
+				seconds float64
+			)
+			if err = rows.Scan(&metric, &seconds); err != nil {
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			res.Metrics[metric] = seconds
+		}
+		if err = rows.Err(); err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
+
+// AdminRetention sets the retention period of a metric, or the default one
+// if no metric is given, with PUT, and resets the retention period of a
+// metric to the default one with DELETE.
+func AdminRetention(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminRetentionHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminRetentionHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.ReadOnly {
+			respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot change the retention period"), "operation_not_permitted")
+			return
+		}
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("changing the retention period requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		metric := r.FormValue("metric")
+		conn := client.MaintenanceConnection()
+		if r.Method == http.MethodDelete {
+			if metric == "" {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("no metric parameter provided"), "bad_data")
+				return
+			}
+			if _, err := conn.Exec(r.Context(), resetRetentionSQL, metric); err != nil {
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			respond(w, http.StatusOK, nil)
+			return
+		}
+		retention, err := parseDuration(r.FormValue("retention"))
+		if err != nil || retention <= 0 {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid retention %q, must be a positive duration", r.FormValue("retention")), "bad_data")
+			return
+		}
+		if metric == "" {
+			_, err = conn.Exec(r.Context(), setDefaultRetentionSQL, retention)
+		} else {
+			_, err = conn.Exec(r.Context(), setMetricRetentionSQL, metric, retention)
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, nil)
+	}
+}
@@ -16,35 +16,63 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
+	"github.com/timescale/promscale/pkg/annotations"
 	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/federation"
 	"github.com/timescale/promscale/pkg/ha"
 	haClient "github.com/timescale/promscale/pkg/ha/client"
 	"github.com/timescale/promscale/pkg/jaeger"
+	"github.com/timescale/promscale/pkg/jaeger/sampling"
 	jaegerStore "github.com/timescale/promscale/pkg/jaeger/store"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/logs"
 	"github.com/timescale/promscale/pkg/pgclient"
 	pgMetrics "github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/query"
 	"github.com/timescale/promscale/pkg/telemetry"
 )
 
-// TODO: Refactor this function to reduce number of paramaters.
-func GenerateRouter(apiConf *Config, promqlConf *query.Config, client *pgclient.Client, store *jaegerStore.Store, authWrapper mux.MiddlewareFunc, reload func() error) (*mux.Router, error) {
-	var writePreprocessors []parser.Preprocessor
-	if apiConf.HighAvailability {
-		service := ha.NewService(haClient.NewLeaseClient(client.ReadOnlyConnection()))
-		writePreprocessors = append(writePreprocessors, ha.NewFilter(service))
+// writeComponents are the parts of the metric write path shared by the HTTP
+// and gRPC write endpoints.
+type writeComponents struct {
+	parser   *parser.DefaultParser
+	haFilter *ha.Filter
+}
+
+// writeParser returns the parser of the metric write requests and the HA
+// filter it runs, nil if HA is disabled. They are created on the first call,
+// so that the HTTP and gRPC write endpoints share the HA leases.
+func writeParser(apiConf *Config, client *pgclient.Client) (*parser.DefaultParser, *ha.Filter) {
+	if w := apiConf.write; w != nil {
+		return w.parser, w.haFilter
 	}
+	var writePreprocessors []parser.Preprocessor
+	// Multi-tenancy has to be applied before HA, since the tenant
+	// label it sets is used to namespace the HA leases.
 	if apiConf.MultiTenancy != nil {
 		writePreprocessors = append(writePreprocessors, apiConf.MultiTenancy.WriteAuthorizer())
 	}
+	var haFilter *ha.Filter
+	if apiConf.HighAvailability {
+		service := ha.NewService(haClient.NewLeaseClient(client.MetadataConnection()))
+		haFilter = ha.NewFilter(service)
+		writePreprocessors = append(writePreprocessors, haFilter)
+	}
 
 	dataParser := parser.NewParser()
+	dataParser.SetCreatedTimestampZeroIngestion(apiConf.CreatedTimestampZeroIngestion)
 	for _, preproc := range writePreprocessors {
 		dataParser.AddPreprocessor(preproc)
 	}
+	apiConf.write = &writeComponents{parser: dataParser, haFilter: haFilter}
+	return dataParser, haFilter
+}
+
+// TODO: Refactor this function to reduce number of paramaters.
+func GenerateRouter(apiConf *Config, promqlConf *query.Config, client *pgclient.Client, store *jaegerStore.Store, authWrapper mux.MiddlewareFunc, reload func() error) (*mux.Router, error) {
+	dataParser, haFilter := writeParser(apiConf, client)
 
-	writeHandler := timeHandler(metrics.HTTPRequestDuration, "write", otelhttp.NewHandler(Write(client, dataParser, updateIngestMetrics), "write-metrics"))
+	writeHandler := timeHandler(metrics.HTTPRequestDuration, "write", withDrain(apiConf.Drainer, otelhttp.NewHandler(Write(client, dataParser, apiConf.TenantAckModes, updateIngestMetrics), "write-metrics")))
 
 	// If we are running in read-only mode, log and send NotFound status.
 	if apiConf.ReadOnly {
@@ -52,55 +80,205 @@ func GenerateRouter(apiConf *Config, promqlConf *query.Config, client *pgclient.
 	}
 
 	router := mux.NewRouter().UseEncodedPath()
+	// The audit log runs first to audit the requests denied by the
+	// authentication.
+	router.Use(withAudit(apiConf.AuditLog))
 	if authWrapper != nil {
 		router.Use(authWrapper)
 	}
 
 	router.Path("/write").Methods(http.MethodPost).HandlerFunc(writeHandler)
 
-	readHandler := timeHandler(metrics.HTTPRequestDuration, "read", Read(apiConf, client, metrics, updateQueryMetrics))
+	runningQueries := newActiveQueries()
+
+	readHandler := timeHandler(metrics.HTTPRequestDuration, "read", withQueryLog(apiConf.QueryLog, "read", withActiveQueries(runningQueries, "read", withQueryResourceLimits(promqlConf, Read(apiConf, client, metrics, updateQueryMetrics)))))
 	router.Path("/read").Methods(http.MethodGet, http.MethodPost).HandlerFunc(readHandler)
 
 	deleteHandler := timeHandler(metrics.HTTPRequestDuration, "delete_series", Delete(apiConf, client))
 	router.Path("/delete_series").Methods(http.MethodPut, http.MethodPost).HandlerFunc(deleteHandler)
 
-	queryable := client.Queryable()
+	queryable, err := federation.NewQueryable(client.Queryable(), apiConf.FederationCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating federated queryable: %w", err)
+	}
+	if apiConf.FederationCfg.Enabled() {
+		router.Use(federation.Middleware)
+	}
 	queryEngine := client.QueryEngine()
 
+	federateHandler := timeHandler(metrics.HTTPRequestDuration, "federate", withQueryResourceLimits(promqlConf, Federate(apiConf, promqlConf, queryable)))
+	router.Path("/federate").Methods(http.MethodGet).HandlerFunc(federateHandler)
+
 	apiV1 := router.PathPrefix("/api/v1").Subrouter()
-	queryHandler := timeHandler(metrics.HTTPRequestDuration, "query", Query(apiConf, queryEngine, queryable, updateQueryMetrics))
+	queryHandler := timeHandler(metrics.HTTPRequestDuration, "query", withQueryLimits(apiConf.TenantLimiter, withQueryResourceLimits(promqlConf, withQueryLog(apiConf.QueryLog, "query", withActiveQueries(runningQueries, "query", Query(apiConf, queryEngine, queryable, updateQueryMetrics))))))
 	apiV1.Path("/query").Methods(http.MethodGet, http.MethodPost).HandlerFunc(queryHandler)
 
-	queryRangeHandler := timeHandler(metrics.HTTPRequestDuration, "query_range", QueryRange(apiConf, promqlConf, queryEngine, queryable, updateQueryMetrics))
+	queryRangeHandler := timeHandler(metrics.HTTPRequestDuration, "query_range", withQueryLimits(apiConf.TenantLimiter, withQueryResourceLimits(promqlConf, withQueryLog(apiConf.QueryLog, "query_range", withActiveQueries(runningQueries, "query_range", QueryRange(apiConf, promqlConf, queryEngine, queryable, updateQueryMetrics))))))
 	apiV1.Path("/query_range").Methods(http.MethodGet, http.MethodPost).HandlerFunc(queryRangeHandler)
 
-	exemplarQueryHandler := timeHandler(metrics.HTTPRequestDuration, "query_exemplar", QueryExemplar(apiConf, queryable, updateQueryMetrics))
+	exemplarQueryHandler := timeHandler(metrics.HTTPRequestDuration, "query_exemplar", QueryExemplar(apiConf, queryable, client.QueryConnection(), updateQueryMetrics))
 	apiV1.Path("/query_exemplars").Methods(http.MethodGet, http.MethodPost).HandlerFunc(exemplarQueryHandler)
 
-	seriesHandler := timeHandler(metrics.HTTPRequestDuration, "series", Series(apiConf, queryable))
+	seriesHandler := timeHandler(metrics.HTTPRequestDuration, "series", withQueryResourceLimits(promqlConf, Series(apiConf, queryable)))
 	apiV1.Path("/series").Methods(http.MethodGet, http.MethodPost).HandlerFunc(seriesHandler)
 
-	labelsHandler := timeHandler(metrics.HTTPRequestDuration, "labels", Labels(apiConf, queryable))
+	labelsHandler := timeHandler(metrics.HTTPRequestDuration, "labels", withQueryResourceLimits(promqlConf, Labels(apiConf, queryable)))
 	apiV1.Path("/labels").Methods(http.MethodGet, http.MethodPost).HandlerFunc(labelsHandler)
 
 	metadataHandler := timeHandler(metrics.HTTPRequestDuration, "metadata", MetricMetadata(apiConf, client))
 	apiV1.Path("/metadata").Methods(http.MethodGet, http.MethodPost).HandlerFunc(metadataHandler)
 
+	targetMetadataHandler := timeHandler(metrics.HTTPRequestDuration, "targets/metadata", TargetMetadata(apiConf, client))
+	apiV1.Path("/targets/metadata").Methods(http.MethodGet).HandlerFunc(targetMetadataHandler)
+
 	rulesHandler := timeHandler(metrics.HTTPRequestDuration, "rules", Rules(apiConf, updateQueryMetrics))
 	apiV1.Path("/rules").Methods(http.MethodGet).HandlerFunc(rulesHandler)
 
 	alertsHandler := timeHandler(metrics.HTTPRequestDuration, "alerts", Alerts(apiConf, updateQueryMetrics))
 	apiV1.Path("/alerts").Methods(http.MethodGet).HandlerFunc(alertsHandler)
 
-	labelValuesHandler := timeHandler(metrics.HTTPRequestDuration, "label/:name/values", LabelValues(apiConf, queryable))
+	alertmanagerAlertsHandler := timeHandler(metrics.HTTPRequestDuration, "v2/alerts", AlertmanagerAlerts(apiConf))
+	router.Path("/api/v2/alerts").Methods(http.MethodGet).HandlerFunc(alertmanagerAlertsHandler)
+
+	indexAdvisorHandler := timeHandler(metrics.HTTPRequestDuration, "index_advisor", IndexAdvisor(apiConf, client))
+	apiV1.Path("/index_advisor").Methods(http.MethodGet).HandlerFunc(indexAdvisorHandler)
+
+	storageSimulationHandler := timeHandler(metrics.HTTPRequestDuration, "storage/simulate", StorageSimulation(apiConf, client))
+	apiV1.Path("/storage/simulate").Methods(http.MethodGet, http.MethodPost).HandlerFunc(storageSimulationHandler)
+
+	forecastHandler := timeHandler(metrics.HTTPRequestDuration, "forecast/:method", Forecast(apiConf, client))
+	apiV1.Path("/forecast/{method}").Methods(http.MethodGet, http.MethodPost).HandlerFunc(forecastHandler)
+
+	sqlHandler := timeHandler(metrics.HTTPRequestDuration, "sql", SQL(apiConf, client.QueryConnection()))
+	apiV1.Path("/sql").Methods(http.MethodGet, http.MethodPost).HandlerFunc(sqlHandler)
+
+	adminDeleteHandler := timeHandler(metrics.HTTPRequestDuration, "admin/tsdb/delete_series", AdminDeleteSeries(apiConf, client))
+	apiV1.Path("/admin/tsdb/delete_series").Methods(http.MethodPut, http.MethodPost).HandlerFunc(adminDeleteHandler)
+
+	cleanTombstonesHandler := timeHandler(metrics.HTTPRequestDuration, "admin/tsdb/clean_tombstones", CleanTombstones(apiConf, client))
+	apiV1.Path("/admin/tsdb/clean_tombstones").Methods(http.MethodPut, http.MethodPost).HandlerFunc(cleanTombstonesHandler)
+
+	adminStorageUsageHandler := timeHandler(metrics.HTTPRequestDuration, "admin/storage/usage", AdminStorageUsage(apiConf, client))
+	apiV1.Path("/admin/storage/usage").Methods(http.MethodGet).HandlerFunc(adminStorageUsageHandler)
+
+	integrityHandler := timeHandler(metrics.HTTPRequestDuration, "integrity", Integrity(apiConf))
+	apiV1.Path("/integrity").Methods(http.MethodGet).HandlerFunc(integrityHandler)
+
+	adminExportHandler := timeHandler(metrics.HTTPRequestDuration, "admin/tsdb/export", AdminExport(apiConf, queryable))
+	apiV1.Path("/admin/tsdb/export").Methods(http.MethodPut, http.MethodPost).HandlerFunc(adminExportHandler)
+
+	haStatsHandler := timeHandler(metrics.HTTPRequestDuration, "ha/stats", HAStats(apiConf, haFilter))
+	apiV1.Path("/ha/stats").Methods(http.MethodGet).HandlerFunc(haStatsHandler)
+
+	limitsHandler := timeHandler(metrics.HTTPRequestDuration, "limits", Limits(apiConf, promqlConf))
+	apiV1.Path("/limits").Methods(http.MethodGet).HandlerFunc(limitsHandler)
+
+	// The status endpoints of Prometheus.
+	tsdbStatusHandler := timeHandler(metrics.HTTPRequestDuration, "status/tsdb", TSDBStatus(apiConf, client))
+	apiV1.Path("/status/tsdb").Methods(http.MethodGet).HandlerFunc(tsdbStatusHandler)
+	buildInfoHandler := timeHandler(metrics.HTTPRequestDuration, "status/buildinfo", BuildInfo(apiConf))
+	apiV1.Path("/status/buildinfo").Methods(http.MethodGet).HandlerFunc(buildInfoHandler)
+	runtimeInfoHandler := timeHandler(metrics.HTTPRequestDuration, "status/runtimeinfo", RuntimeInfo(apiConf, client))
+	apiV1.Path("/status/runtimeinfo").Methods(http.MethodGet).HandlerFunc(runtimeInfoHandler)
+	flagsHandler := timeHandler(metrics.HTTPRequestDuration, "status/flags", Flags(apiConf))
+	apiV1.Path("/status/flags").Methods(http.MethodGet).HandlerFunc(flagsHandler)
+
+	cacheStatsHandler := timeHandler(metrics.HTTPRequestDuration, "status/caches", CacheStats(apiConf, client))
+	apiV1.Path("/status/caches").Methods(http.MethodGet).HandlerFunc(cacheStatsHandler)
+
+	cardinalityHandler := timeHandler(metrics.HTTPRequestDuration, "status/cardinality", Cardinality(apiConf, client))
+	apiV1.Path("/status/cardinality").Methods(http.MethodGet).HandlerFunc(cardinalityHandler)
+
+	haLeasesHandler := timeHandler(metrics.HTTPRequestDuration, "status/ha_leases", HALeases(apiConf, client))
+	apiV1.Path("/status/ha_leases").Methods(http.MethodGet).HandlerFunc(haLeasesHandler)
+
+	activeQueriesHandler := timeHandler(metrics.HTTPRequestDuration, "status/queries", ActiveQueries(apiConf, runningQueries))
+	apiV1.Path("/status/queries").Methods(http.MethodGet).HandlerFunc(activeQueriesHandler)
+
+	cancelQueryHandler := timeHandler(metrics.HTTPRequestDuration, "admin/queries/:id", CancelQuery(apiConf, runningQueries))
+	apiV1.Path("/admin/queries/{id}").Methods(http.MethodDelete).HandlerFunc(cancelQueryHandler)
+
+	retentionHandler := timeHandler(metrics.HTTPRequestDuration, "status/retention", Retention(apiConf, client))
+	apiV1.Path("/status/retention").Methods(http.MethodGet).HandlerFunc(retentionHandler)
+
+	adminRetentionHandler := timeHandler(metrics.HTTPRequestDuration, "admin/retention", AdminRetention(apiConf, client))
+	apiV1.Path("/admin/retention").Methods(http.MethodPut, http.MethodPost, http.MethodDelete).HandlerFunc(adminRetentionHandler)
+
+	adminMetricConfigHandler := timeHandler(metrics.HTTPRequestDuration, "admin/metric/:name/config", AdminMetricConfig(apiConf, client))
+	apiV1.Path("/admin/metric/{name}/config").Methods(http.MethodGet, http.MethodPut).HandlerFunc(adminMetricConfigHandler)
+
+	traceServiceConfigsHandler := timeHandler(metrics.HTTPRequestDuration, "admin/trace/services", TraceServiceConfigs(apiConf, client))
+	apiV1.Path("/admin/trace/services").Methods(http.MethodGet).HandlerFunc(traceServiceConfigsHandler)
+	adminTraceServiceConfigHandler := timeHandler(metrics.HTTPRequestDuration, "admin/trace/services/:service", AdminTraceServiceConfig(apiConf, client))
+	apiV1.Path("/admin/trace/services/{service}").Methods(http.MethodGet, http.MethodPut).HandlerFunc(adminTraceServiceConfigHandler)
+
+	adminVacuumHandler := timeHandler(metrics.HTTPRequestDuration, "admin/vacuum", AdminVacuum(apiConf))
+	apiV1.Path("/admin/vacuum").Methods(http.MethodPost).HandlerFunc(adminVacuumHandler)
+
+	maintenanceJobsHandler := timeHandler(metrics.HTTPRequestDuration, "status/maintenance_jobs", MaintenanceJobs(apiConf, client))
+	apiV1.Path("/status/maintenance_jobs").Methods(http.MethodGet).HandlerFunc(maintenanceJobsHandler)
+	adminMaintenanceJobsHandler := timeHandler(metrics.HTTPRequestDuration, "admin/maintenance_jobs", AdminMaintenanceJobs(apiConf, client))
+	apiV1.Path("/admin/maintenance_jobs").Methods(http.MethodPut, http.MethodPost).HandlerFunc(adminMaintenanceJobsHandler)
+	adminScheduleMaintenanceJobsHandler := timeHandler(metrics.HTTPRequestDuration, "admin/maintenance_jobs/:action", AdminScheduleMaintenanceJobs(apiConf, client))
+	apiV1.Path("/admin/maintenance_jobs/{action}").Methods(http.MethodPost).HandlerFunc(adminScheduleMaintenanceJobsHandler)
+
+	labelValuesHandler := timeHandler(metrics.HTTPRequestDuration, "label/:name/values", withQueryResourceLimits(promqlConf, LabelValues(apiConf, queryable)))
 	apiV1.Path("/label/{name}/values").Methods(http.MethodGet).HandlerFunc(labelValuesHandler)
+	labelValuesSearchHandler := timeHandler(metrics.HTTPRequestDuration, "label/:name/search", withQueryResourceLimits(promqlConf, LabelValuesSearch(apiConf, queryable)))
+	apiV1.Path("/label/{name}/search").Methods(http.MethodGet).HandlerFunc(labelValuesSearchHandler)
+
+	// The annotations API follows the Grafana API paths.
+	annotationsStore := annotations.NewStore(client.ReadOnlyConnection())
+	annotationsHandler := timeHandler(metrics.HTTPRequestDuration, "annotations", Annotations(apiConf, annotationsStore))
+	router.Path("/api/annotations").Methods(http.MethodGet, http.MethodPost).HandlerFunc(annotationsHandler)
+	annotationHandler := timeHandler(metrics.HTTPRequestDuration, "annotations/:id", Annotation(apiConf, annotationsStore))
+	router.Path("/api/annotations/{id}").Methods(http.MethodPut, http.MethodPatch, http.MethodDelete).HandlerFunc(annotationHandler)
+
+	logStore := logs.NewStore(client.ReadOnlyConnection())
+	logsHandler := timeHandler(metrics.HTTPRequestDuration, "logs", Logs(apiConf, logStore))
+	apiV1.Path("/logs").Methods(http.MethodGet).HandlerFunc(logsHandler)
 
-	healthChecker := func() error { return client.HealthCheck() }
-	router.Path("/healthz").Methods(http.MethodGet, http.MethodOptions, http.MethodHead).HandlerFunc(Health(healthChecker))
+	// The Loki push and query APIs serve the log store to Promtail and the
+	// Grafana Loki data source.
+	lokiPushHandler := timeHandler(metrics.HTTPRequestDuration, "loki/push", withDrain(apiConf.Drainer, LokiPush(apiConf, client)))
+	if apiConf.ReadOnly {
+		lokiPushHandler = withWarnLog("trying to send logs to Loki push API while connector is in read-only mode", http.NotFoundHandler())
+	}
+	router.Path("/loki/api/v1/push").Methods(http.MethodPost).HandlerFunc(lokiPushHandler)
+	lokiQueryRangeHandler := timeHandler(metrics.HTTPRequestDuration, "loki/query_range", LokiQueryRange(apiConf, logStore))
+	router.Path("/loki/api/v1/query_range").Methods(http.MethodGet, http.MethodPost).HandlerFunc(lokiQueryRangeHandler)
+	lokiLabelsHandler := timeHandler(metrics.HTTPRequestDuration, "loki/labels", LokiLabels(apiConf, logStore))
+	router.Path("/loki/api/v1/labels").Methods(http.MethodGet, http.MethodPost).HandlerFunc(lokiLabelsHandler)
+	lokiLabelValuesHandler := timeHandler(metrics.HTTPRequestDuration, "loki/label/:name/values", LokiLabels(apiConf, logStore))
+	router.Path("/loki/api/v1/label/{name}/values").Methods(http.MethodGet).HandlerFunc(lokiLabelValuesHandler)
+
+	// The Jaeger remote sampling protocol is served on the paths of the
+	// Jaeger agent and collector.
+	samplingStore := sampling.NewStore(client.ReadOnlyConnection())
+	samplingHandler := timeHandler(metrics.HTTPRequestDuration, "sampling", SamplingStrategy(apiConf, samplingStore))
+	router.Path("/sampling").Methods(http.MethodGet).HandlerFunc(samplingHandler)
+	router.Path("/api/sampling").Methods(http.MethodGet).HandlerFunc(samplingHandler)
+	samplingStrategiesHandler := timeHandler(metrics.HTTPRequestDuration, "admin/sampling/strategies", SamplingStrategies(apiConf, samplingStore))
+	apiV1.Path("/admin/sampling/strategies").Methods(http.MethodGet).HandlerFunc(samplingStrategiesHandler)
+	samplingStrategyHandler := timeHandler(metrics.HTTPRequestDuration, "admin/sampling/strategies/:service", AdminSamplingStrategy(apiConf, samplingStore))
+	apiV1.Path("/admin/sampling/strategies/{service}").Methods(http.MethodPut, http.MethodDelete).HandlerFunc(samplingStrategyHandler)
+
+	router.Path("/ui").Methods(http.MethodGet).HandlerFunc(AdminUI(apiConf))
+
+	router.Path("/healthz").Methods(http.MethodGet, http.MethodOptions, http.MethodHead).HandlerFunc(HealthReport(client.HealthReport))
+	readyChecker := func() error {
+		if apiConf.Drainer.Draining() {
+			return errDraining
+		}
+		return client.Ready()
+	}
+	router.Path("/-/ready").Methods(http.MethodGet, http.MethodHead).HandlerFunc(Ready(readyChecker))
 	router.Path(apiConf.TelemetryPath).Methods(http.MethodGet).HandlerFunc(promhttp.Handler().ServeHTTP)
 
 	reloadHandler := timeHandler(metrics.HTTPRequestDuration, "/-/reload", Reload(reload, apiConf.AdminAPIEnabled))
 	router.Path("/-/reload").Methods(http.MethodPost).HandlerFunc(reloadHandler)
+	drainHandler := timeHandler(metrics.HTTPRequestDuration, "/-/drain", Drain(apiConf.Drainer, apiConf.AdminAPIEnabled))
+	router.Path("/-/drain").Methods(http.MethodPost).HandlerFunc(drainHandler)
 
 	if store != nil {
 		jaeger.ExtendQueryAPIs(router, client.ReadOnlyConnection(), store)
@@ -7,6 +7,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -119,11 +120,15 @@ func rulesHandler(apiConf *Config, updateMetrics func(handler, code string, dura
 
 		returnAlerts := queryType == "" || queryType == "alert"
 		returnRecording := queryType == "" || queryType == "record"
+		filter := newRulesFilter(r.URL.Query())
 
 		ruleGroups := apiConf.Rules.RuleGroups()
-		res := &RuleDiscovery{RuleGroups: make([]*RuleGroup, len(ruleGroups))}
+		res := &RuleDiscovery{RuleGroups: make([]*RuleGroup, 0, len(ruleGroups))}
 
-		for i, grp := range ruleGroups {
+		for _, grp := range ruleGroups {
+			if !filter.matchesGroup(grp.Name(), grp.File()) {
+				continue
+			}
 			apiRuleGroup := &RuleGroup{
 				Name:           grp.Name(),
 				File:           grp.File(),
@@ -134,6 +139,9 @@ func rulesHandler(apiConf *Config, updateMetrics func(handler, code string, dura
 				LastEvaluation: grp.GetLastEvaluation(),
 			}
 			for _, r := range grp.Rules() {
+				if !filter.matchesRule(r.Name()) {
+					continue
+				}
 				var enrichedRule Rule
 
 				lastError := ""
@@ -184,13 +192,60 @@ func rulesHandler(apiConf *Config, updateMetrics func(handler, code string, dura
 					apiRuleGroup.Rules = append(apiRuleGroup.Rules, enrichedRule)
 				}
 			}
-			res.RuleGroups[i] = apiRuleGroup
+			// As in Prometheus, the groups without matching rules are left
+			// out when filtering by rule name.
+			if filter.ruleNames != nil && len(apiRuleGroup.Rules) == 0 {
+				continue
+			}
+			res.RuleGroups = append(res.RuleGroups, apiRuleGroup)
 		}
 		statusCode = "200"
 		respond(w, http.StatusOK, res)
 	}
 }
 
+// rulesFilter selects the rules returned by the rules API with the
+// rule_name[], rule_group[] and file[] parameters. A nil set selects
+// everything.
+type rulesFilter struct {
+	ruleNames, ruleGroups, files map[string]struct{}
+}
+
+func newRulesFilter(values url.Values) rulesFilter {
+	return rulesFilter{
+		ruleNames:  stringSet(values["rule_name[]"]),
+		ruleGroups: stringSet(values["rule_group[]"]),
+		files:      stringSet(values["file[]"]),
+	}
+}
+
+func (f rulesFilter) matchesGroup(name, file string) bool {
+	return inSet(f.ruleGroups, name) && inSet(f.files, file)
+}
+
+func (f rulesFilter) matchesRule(name string) bool {
+	return inSet(f.ruleNames, name)
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func inSet(set map[string]struct{}, v string) bool {
+	if set == nil {
+		return true
+	}
+	_, found := set[v]
+	return found
+}
+
 func rulesAlertsToAPIAlerts(rulesAlerts []*prom_rules.Alert) []*Alert {
 	apiAlerts := make([]*Alert, len(rulesAlerts))
 	for i, ruleAlert := range rulesAlerts {
@@ -0,0 +1,123 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/jaeger/sampling"
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// samplingStore is implemented by sampling.Store.
+type samplingStore interface {
+	Get(ctx context.Context, service string) (sampling.Strategy, error)
+	List(ctx context.Context) (map[string]sampling.Strategy, error)
+	Put(ctx context.Context, service string, st sampling.Strategy) error
+	Delete(ctx context.Context, service string) error
+}
+
+// SamplingStrategy returns the sampling strategy of the service parameter,
+// following the Jaeger remote sampling protocol. It is polled by the Jaeger
+// clients and the OpenTelemetry jaegerremotesampler.
+func SamplingStrategy(conf *Config, store samplingStore) http.Handler {
+	hf := corsWrapper(conf, samplingStrategyHandler(store))
+	return gziphandler.GzipHandler(hf)
+}
+
+// SamplingStrategies lists the sampling strategies stored by service.
+func SamplingStrategies(conf *Config, store samplingStore) http.Handler {
+	hf := corsWrapper(conf, samplingStrategiesHandler(store))
+	return gziphandler.GzipHandler(hf)
+}
+
+// AdminSamplingStrategy sets or deletes the sampling strategy of the service
+// of the path. It requires the admin API to be enabled.
+func AdminSamplingStrategy(conf *Config, store samplingStore) http.Handler {
+	hf := corsWrapper(conf, adminSamplingStrategyHandler(conf, store))
+	return gziphandler.GzipHandler(hf)
+}
+
+func samplingStrategyHandler(store samplingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service := r.FormValue("service")
+		if service == "" {
+			http.Error(w, "'service' parameter must be provided", http.StatusBadRequest)
+			return
+		}
+		st, err := store.Get(r.Context(), service)
+		if err != nil {
+			log.Error("msg", "failed to get sampling strategy", "service", service, "err", err)
+			http.Error(w, "failed to get sampling strategy", http.StatusInternalServerError)
+			return
+		}
+		// The Jaeger protocol has no envelope.
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(st); err != nil {
+			log.Error("msg", "error writing sampling strategy response", "err", err)
+		}
+	}
+}
+
+func samplingStrategiesHandler(store samplingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		strategies, err := store.List(r.Context())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, strategies)
+	}
+}
+
+func adminSamplingStrategyHandler(conf *Config, store samplingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.ReadOnly {
+			respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot change sampling strategies"), "operation_not_permitted")
+			return
+		}
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("changing sampling strategies requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		service := mux.Vars(r)["service"]
+
+		switch r.Method {
+		case http.MethodPut:
+			var st sampling.Strategy
+			if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid sampling strategy: %w", err), "bad_data")
+				return
+			}
+			if err := st.Validate(); err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid sampling strategy: %w", err), "bad_data")
+				return
+			}
+			if err := store.Put(r.Context(), service, st); err != nil {
+				log.Error("msg", "failed to store sampling strategy", "service", service, "err", err)
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			respond(w, http.StatusOK, st)
+		case http.MethodDelete:
+			err := store.Delete(r.Context(), service)
+			switch {
+			case err == nil:
+				respond(w, http.StatusOK, nil)
+			case errors.Is(err, sampling.ErrNotFound):
+				respondError(w, http.StatusNotFound, err, "not_found")
+			default:
+				log.Error("msg", "failed to delete sampling strategy", "service", service, "err", err)
+				respondError(w, http.StatusInternalServerError, err, "internal")
+			}
+		}
+	}
+}
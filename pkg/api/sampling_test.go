@@ -0,0 +1,137 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/jaeger/sampling"
+)
+
+type mockSamplingStore struct {
+	strategies map[string]sampling.Strategy
+}
+
+func (m *mockSamplingStore) Get(_ context.Context, service string) (sampling.Strategy, error) {
+	if st, ok := m.strategies[service]; ok {
+		return st, nil
+	}
+	return sampling.DefaultStrategy(), nil
+}
+
+func (m *mockSamplingStore) List(_ context.Context) (map[string]sampling.Strategy, error) {
+	return m.strategies, nil
+}
+
+func (m *mockSamplingStore) Put(_ context.Context, service string, st sampling.Strategy) error {
+	m.strategies[service] = st
+	return nil
+}
+
+func (m *mockSamplingStore) Delete(_ context.Context, service string) error {
+	if _, ok := m.strategies[service]; !ok {
+		return sampling.ErrNotFound
+	}
+	delete(m.strategies, service)
+	return nil
+}
+
+func TestSampling(t *testing.T) {
+	cases := []struct {
+		name         string
+		config       Config
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expected     string
+	}{
+		{
+			name:         "stored strategy",
+			method:       http.MethodGet,
+			path:         "/sampling?service=checkout",
+			expectedCode: http.StatusOK,
+			expected:     `{"strategyType":"RATE_LIMITING","rateLimitingSampling":{"maxTracesPerSecond":5}}`,
+		},
+		{
+			name:         "default strategy",
+			method:       http.MethodGet,
+			path:         "/sampling?service=frontend",
+			expectedCode: http.StatusOK,
+			expected:     `{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.001}}`,
+		},
+		{
+			name:         "list",
+			method:       http.MethodGet,
+			path:         "/api/v1/admin/sampling/strategies",
+			expectedCode: http.StatusOK,
+			expected:     `{"status":"success","data":{"checkout":{"strategyType":"RATE_LIMITING","rateLimitingSampling":{"maxTracesPerSecond":5}}}}`,
+		},
+		{
+			name:         "put",
+			config:       Config{AdminAPIEnabled: true},
+			method:       http.MethodPut,
+			path:         "/api/v1/admin/sampling/strategies/frontend",
+			body:         `{"probabilisticSampling":{"samplingRate":0.25}}`,
+			expectedCode: http.StatusOK,
+			expected:     `{"status":"success","data":{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.25}}}`,
+		},
+		{
+			name:         "put invalid",
+			config:       Config{AdminAPIEnabled: true},
+			method:       http.MethodPut,
+			path:         "/api/v1/admin/sampling/strategies/frontend",
+			body:         `{"probabilisticSampling":{"samplingRate":2}}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     `{"status":"error","errorType":"bad_data","error":"invalid sampling strategy: samplingRate must be within [0, 1], got 2"}`,
+		},
+		{
+			name:         "put without admin API",
+			method:       http.MethodPut,
+			path:         "/api/v1/admin/sampling/strategies/frontend",
+			body:         `{"probabilisticSampling":{"samplingRate":0.25}}`,
+			expectedCode: http.StatusForbidden,
+			expected:     `{"status":"error","errorType":"operation_not_permitted","error":"changing sampling strategies requires admin permissions. Use -web.enable-admin-api flag to allow it"}`,
+		},
+		{
+			name:         "delete missing",
+			config:       Config{AdminAPIEnabled: true},
+			method:       http.MethodDelete,
+			path:         "/api/v1/admin/sampling/strategies/frontend",
+			expectedCode: http.StatusNotFound,
+			expected:     `{"status":"error","errorType":"not_found","error":"sampling strategy not found"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := &mockSamplingStore{strategies: map[string]sampling.Strategy{
+				"checkout": {StrategyType: sampling.RateLimiting, RateLimitingSampling: &sampling.RateLimitingSampling{MaxTracesPerSecond: 5}},
+			}}
+			router := mux.NewRouter()
+			router.Path("/sampling").Methods(http.MethodGet).Handler(SamplingStrategy(&c.config, store))
+			router.Path("/api/v1/admin/sampling/strategies").Methods(http.MethodGet).Handler(SamplingStrategies(&c.config, store))
+			router.Path("/api/v1/admin/sampling/strategies/{service}").Methods(http.MethodPut, http.MethodDelete).Handler(AdminSamplingStrategy(&c.config, store))
+
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, c.expectedCode, w.Code)
+			require.JSONEq(t, c.expected, w.Body.String())
+		})
+	}
+}
+
+func TestSamplingMissingService(t *testing.T) {
+	w := httptest.NewRecorder()
+	SamplingStrategy(&Config{}, &mockSamplingStore{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sampling", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -77,8 +77,10 @@ func series(queryable promql.Queryable) http.HandlerFunc {
 
 		var sets []storage.SeriesSet
 		var warnings storage.Warnings
+		// Only the labels of the series are needed, not their samples.
+		hints := &storage.SelectHints{Start: timestamp.FromTime(start), End: timestamp.FromTime(end), Func: "series"}
 		for _, mset := range matcherSets {
-			s, _ := q.Select(false, nil, nil, nil, mset...)
+			s, _ := q.Select(false, hints, nil, nil, mset...)
 			warnings = append(warnings, s.Warnings()...)
 			if s.Err() != nil {
 				respondError(w, http.StatusUnprocessableEntity, s.Err(), "execution")
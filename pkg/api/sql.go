@@ -0,0 +1,138 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/sqlapi"
+)
+
+// SQL runs the read-only SQL query of the query parameter, and returns its
+// result as JSON, or as CSV with format=csv.
+func SQL(conf *Config, conn pgxconn.PgxConn) http.Handler {
+	hf := corsWrapper(conf, sqlHandler(conf, conn))
+	return gziphandler.GzipHandler(hf)
+}
+
+func sqlHandler(conf *Config, conn pgxconn.PgxConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conf.SQLCfg.Enabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("the SQL API is disabled. Use -web.enable-sql-api flag to enable it"), "operation_not_permitted")
+			return
+		}
+		if conf.MultiTenancy != nil {
+			// The SQL queries are not restricted to the series of the
+			// tenant of the request.
+			respondError(w, http.StatusForbidden, fmt.Errorf("the SQL API is not available with multi-tenancy"), "operation_not_permitted")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		query := r.FormValue("query")
+		if query == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("no query parameter provided"), "bad_data")
+			return
+		}
+		format := r.FormValue("format")
+		if format != "" && format != "json" && format != "csv" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, must be json or csv", format), "bad_data")
+			return
+		}
+		limit := 0
+		if v := r.FormValue("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q, must be a positive integer", v), "bad_data")
+				return
+			}
+		}
+
+		res, err := sqlapi.Run(r.Context(), conn, conf.SQLCfg, query, limit)
+		if err != nil {
+			status, errType := sqlErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				log.Error("msg", "SQL API query failed", "err", err)
+			}
+			respondError(w, status, err, errType)
+			return
+		}
+		if format == "csv" {
+			respondCSV(w, res)
+			return
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
+
+// sqlErrorStatus returns the status code and error type of the errors of the
+// SQL queries.
+func sqlErrorStatus(err error) (int, string) {
+	if errors.Is(err, sqlapi.ErrNotAllowed) {
+		return http.StatusBadRequest, "bad_data"
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return http.StatusInternalServerError, "internal"
+	}
+	switch {
+	case pgErr.Code == pgerrcode.QueryCanceled:
+		return http.StatusServiceUnavailable, "timeout"
+	case pgErr.Code == pgerrcode.InsufficientPrivilege:
+		return http.StatusForbidden, "operation_not_permitted"
+	case pgerrcode.IsSyntaxErrororAccessRuleViolation(pgErr.Code),
+		pgerrcode.IsDataException(pgErr.Code),
+		pgerrcode.IsInvalidTransactionState(pgErr.Code):
+		// Invalid transaction state holds the writes rejected by the
+		// read-only transaction.
+		return http.StatusBadRequest, "bad_data"
+	default:
+		return http.StatusUnprocessableEntity, "execution"
+	}
+}
+
+func respondCSV(w http.ResponseWriter, res *sqlapi.Result) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if res.Truncated {
+		w.Header().Set("X-Promscale-Truncated", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	record := make([]string, len(res.Columns))
+	for i, c := range res.Columns {
+		record[i] = c.Name
+	}
+	_ = out.Write(record)
+	for _, row := range res.Rows {
+		for i, v := range row {
+			record[i] = ""
+			if v != nil {
+				record[i] = *v
+			}
+		}
+		if err := out.Write(record); err != nil {
+			log.Error("msg", "error writing response", "err", err)
+			return
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Error("msg", "error writing response", "err", err)
+	}
+}
@@ -0,0 +1,99 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/sqlapi"
+)
+
+func TestSQLHandlerRejections(t *testing.T) {
+	enabled := sqlapi.Config{Enabled: true, AllowedSchemas: []string{"prom_metric"}, MaxRows: 10}
+	testCases := []struct {
+		name   string
+		cfg    sqlapi.Config
+		query  string
+		status int
+	}{
+		{
+			name:   "disabled",
+			query:  "query=SELECT+1",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no query",
+			cfg:    enabled,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid format",
+			cfg:    enabled,
+			query:  "query=SELECT+1&format=xml",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid limit",
+			cfg:    enabled,
+			query:  "query=SELECT+1&limit=-1",
+			status: http.StatusBadRequest,
+		},
+		{
+			// The query is rejected before reaching the database.
+			name:   "write",
+			cfg:    enabled,
+			query:  "query=DROP+TABLE+prom_data.up",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := sqlHandler(&Config{SQLCfg: c.cfg}, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sql?"+c.query, nil))
+			require.Equal(t, c.status, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestSQLErrorStatus(t *testing.T) {
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: multiple statements", sqlapi.ErrNotAllowed), http.StatusBadRequest},
+		{&pgconn.PgError{Code: pgerrcode.QueryCanceled}, http.StatusServiceUnavailable},
+		{&pgconn.PgError{Code: pgerrcode.InsufficientPrivilege}, http.StatusForbidden},
+		{&pgconn.PgError{Code: pgerrcode.UndefinedTable}, http.StatusBadRequest},
+		{&pgconn.PgError{Code: pgerrcode.ReadOnlySQLTransaction}, http.StatusBadRequest},
+		{&pgconn.PgError{Code: pgerrcode.DivisionByZero}, http.StatusBadRequest},
+		{&pgconn.PgError{Code: pgerrcode.DiskFull}, http.StatusUnprocessableEntity},
+		{fmt.Errorf("begin transaction: %w", fmt.Errorf("connection refused")), http.StatusInternalServerError},
+	}
+	for _, c := range testCases {
+		status, _ := sqlErrorStatus(c.err)
+		require.Equal(t, c.status, status, c.err.Error())
+	}
+}
+
+func TestRespondCSV(t *testing.T) {
+	value := "a,\"b\""
+	w := httptest.NewRecorder()
+	respondCSV(w, &sqlapi.Result{
+		Columns:   []sqlapi.Column{{Name: "name", Type: "text"}, {Name: "value", Type: "float8"}},
+		Rows:      [][]*string{{&value, nil}},
+		Truncated: true,
+	})
+	require.Equal(t, "name,value\n\"a,\"\"b\"\"\",\n", w.Body.String())
+	require.Equal(t, "true", w.Header().Get("X-Promscale-Truncated"))
+	require.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,155 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+)
+
+const (
+	defaultCardinalityLimit = 10
+	maxCardinalityLimit     = 1000
+
+	cardinalitySQL = `SELECT metric_name, coalesce(num_series_approx, 0)::bigint, coalesce(num_samples_approx, 0)::bigint
+FROM prom_info.metric_stats ORDER BY num_series_approx DESC NULLS LAST, metric_name LIMIT $1`
+	haLeasesSQL = "SELECT cluster_name, leader_name, lease_start, lease_until FROM _prom_catalog.ha_leases ORDER BY cluster_name"
+)
+
+type cacheStats struct {
+	Name     string `json:"name"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+}
+
+type metricCardinality struct {
+	Metric     string `json:"metric"`
+	NumSeries  int64  `json:"numSeries"`
+	NumSamples int64  `json:"numSamples"`
+}
+
+type haLease struct {
+	Cluster    string    `json:"cluster"`
+	Leader     string    `json:"leader"`
+	LeaseStart time.Time `json:"leaseStart"`
+	LeaseUntil time.Time `json:"leaseUntil"`
+}
+
+// checkAdminStatus responds with an error and returns false if neither the
+// admin API nor the admin UI is enabled. The status endpoints it guards show
+// the queries and metrics of all the tenants.
+func checkAdminStatus(w http.ResponseWriter, conf *Config) bool {
+	if conf.AdminAPIEnabled || conf.AdminUIEnabled {
+		return true
+	}
+	respondError(w, http.StatusForbidden, fmt.Errorf("this status endpoint requires admin permissions. Use -web.enable-admin-api or -web.enable-admin-ui flag to allow it"), "operation_not_permitted")
+	return false
+}
+
+// CacheStats returns the number of entries and the capacity of the metric,
+// label and series caches.
+func CacheStats(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, []cacheStats{
+			{Name: "metric", Size: client.NumCachedMetricNames(), Capacity: client.MetricNamesCacheCapacity()},
+			{Name: "label", Size: client.NumCachedLabels(), Capacity: client.LabelsCacheCapacity()},
+			{Name: "series", Size: client.NumCachedSeries(), Capacity: client.SeriesCacheCapacity()},
+		})
+	})
+	return gziphandler.GzipHandler(hf)
+}
+
+// Cardinality returns the metrics with the most series, estimated from the
+// table statistics of the database.
+func Cardinality(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, cardinalityHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func cardinalityHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminStatus(w, conf) {
+			return
+		}
+		limit, err := parseStatusLimit(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		rows, err := client.ReadOnlyConnection().Query(r.Context(), cardinalitySQL, limit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		defer rows.Close()
+		res := make([]metricCardinality, 0, limit)
+		for rows.Next() {
+			var m metricCardinality
+			if err = rows.Scan(&m.Metric, &m.NumSeries, &m.NumSamples); err != nil {
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			res = append(res, m)
+		}
+		if err = rows.Err(); err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
+
+// parseStatusLimit returns the number of entries requested by the 'limit'
+// parameter.
+func parseStatusLimit(r *http.Request) (int, error) {
+	v := r.FormValue("limit")
+	if v == "" {
+		return defaultCardinalityLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxCardinalityLimit {
+		return 0, fmt.Errorf("invalid limit %q, must be between 1 and %d", v, maxCardinalityLimit)
+	}
+	return limit, nil
+}
+
+// HALeases returns the current leader of each HA cluster and its lease.
+func HALeases(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, haLeasesHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func haLeasesHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminStatus(w, conf) {
+			return
+		}
+		rows, err := client.MetadataConnection().Query(r.Context(), haLeasesSQL)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		defer rows.Close()
+		res := make([]haLease, 0)
+		for rows.Next() {
+			var l haLease
+			if err = rows.Scan(&l.Cluster, &l.Leader, &l.LeaseStart, &l.LeaseUntil); err != nil {
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			res = append(res, l)
+		}
+		if err = rows.Err(); err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, res)
+	}
+}
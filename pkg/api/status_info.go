@@ -0,0 +1,103 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/prometheus/common/model"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/version"
+)
+
+var startTime = time.Now()
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	GoVersion string `json:"goVersion"`
+}
+
+type runtimeInfo struct {
+	StartTime           time.Time `json:"startTime"`
+	CWD                 string    `json:"CWD"`
+	ReloadConfigSuccess bool      `json:"reloadConfigSuccess"`
+	LastConfigTime      time.Time `json:"lastConfigTime"`
+	GoroutineCount      int       `json:"goroutineCount"`
+	GOMAXPROCS          int       `json:"GOMAXPROCS"`
+	GOGC                string    `json:"GOGC"`
+	GODEBUG             string    `json:"GODEBUG"`
+	StorageRetention    string    `json:"storageRetention"`
+}
+
+// BuildInfo returns the version of the connector like the Prometheus
+// /api/v1/status/buildinfo endpoint.
+func BuildInfo(conf *Config) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, buildInfo{
+			Version:   version.Promscale,
+			Revision:  version.CommitHash,
+			Branch:    version.Branch,
+			GoVersion: runtime.Version(),
+		})
+	})
+	return gziphandler.GzipHandler(hf)
+}
+
+// RuntimeInfo returns the runtime state of the connector like the Prometheus
+// /api/v1/status/runtimeinfo endpoint. The storage retention is the default
+// retention period of the metrics.
+func RuntimeInfo(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, runtimeInfoHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func runtimeInfoHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := runtimeInfo{
+			StartTime:           startTime.UTC(),
+			ReloadConfigSuccess: true,
+			LastConfigTime:      startTime.UTC(),
+			GoroutineCount:      runtime.NumGoroutine(),
+			GOMAXPROCS:          runtime.GOMAXPROCS(0),
+			GOGC:                os.Getenv("GOGC"),
+			GODEBUG:             os.Getenv("GODEBUG"),
+		}
+		if cwd, err := os.Getwd(); err == nil {
+			info.CWD = cwd
+		}
+		if conf.ConfigStatus != nil {
+			var last time.Time
+			info.ReloadConfigSuccess, last = conf.ConfigStatus()
+			info.LastConfigTime = last.UTC()
+		}
+		var retention float64
+		if err := client.MetadataConnection().QueryRow(r.Context(), defaultRetentionSQL).Scan(&retention); err != nil {
+			log.Warn("msg", "Reading the default retention period failed", "err", err)
+		} else {
+			info.StorageRetention = model.Duration(time.Duration(retention * float64(time.Second))).String()
+		}
+		respond(w, http.StatusOK, info)
+	}
+}
+
+// Flags returns the values of the flags of the connector like the
+// Prometheus /api/v1/status/flags endpoint. Secrets are redacted.
+func Flags(conf *Config) http.Handler {
+	hf := corsWrapper(conf, func(w http.ResponseWriter, r *http.Request) {
+		flags := conf.Flags
+		if flags == nil {
+			flags = map[string]string{}
+		}
+		respond(w, http.StatusOK, flags)
+	})
+	return gziphandler.GzipHandler(hf)
+}
@@ -0,0 +1,43 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/version"
+)
+
+func TestBuildInfo(t *testing.T) {
+	w := httptest.NewRecorder()
+	BuildInfo(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/buildinfo", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Status string    `json:"status"`
+		Data   buildInfo `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, "success", resp.Status)
+	require.Equal(t, version.Promscale, resp.Data.Version)
+}
+
+func TestFlags(t *testing.T) {
+	flags := map[string]string{"web.listen-address": ":9201", "db.password": "<secret>"}
+	w := httptest.NewRecorder()
+	Flags(&Config{Flags: flags}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/flags", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data map[string]string `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, flags, resp.Data)
+}
@@ -0,0 +1,121 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// The statistics of /api/v1/status/tsdb. The number of series of the metrics
+// is estimated from the table statistics, the other statistics are exact.
+const (
+	tsdbNumSeriesSQL      = "SELECT coalesce(sum(num_series_approx), 0)::bigint FROM prom_info.metric_stats"
+	tsdbNumLabelPairsSQL  = "SELECT count(*) FROM _prom_catalog.label"
+	tsdbSeriesByMetricSQL = `SELECT metric_name, coalesce(num_series_approx, 0)::bigint FROM prom_info.metric_stats
+ORDER BY 2 DESC, 1 LIMIT $1`
+	tsdbValuesByLabelSQL = `SELECT key, count(*) FROM _prom_catalog.label
+GROUP BY key ORDER BY 2 DESC, 1 LIMIT $1`
+	tsdbBytesByLabelSQL = `SELECT key, sum(octet_length(value))::bigint FROM _prom_catalog.label
+GROUP BY key ORDER BY 2 DESC, 1 LIMIT $1`
+	tsdbSeriesByLabelPairSQL = `SELECT l.key || '=' || l.value, count(*)
+FROM _prom_catalog.series s CROSS JOIN LATERAL unnest(s.labels) AS lid
+JOIN _prom_catalog.label l ON (l.id = lid)
+WHERE s.delete_epoch IS NULL
+GROUP BY l.key, l.value ORDER BY 2 DESC, 1 LIMIT $1`
+)
+
+type tsdbStat struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+type tsdbHeadStats struct {
+	NumSeries     int64 `json:"numSeries"`
+	NumLabelPairs int64 `json:"numLabelPairs"`
+}
+
+type tsdbStatus struct {
+	HeadStats                   tsdbHeadStats `json:"headStats"`
+	SeriesCountByMetricName     []tsdbStat    `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []tsdbStat    `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []tsdbStat    `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []tsdbStat    `json:"seriesCountByLabelValuePair"`
+}
+
+// TSDBStatus returns the cardinality statistics of the stored series in the
+// format of the Prometheus /api/v1/status/tsdb endpoint.
+func TSDBStatus(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, tsdbStatusHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func tsdbStatusHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseStatusLimit(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		status, err := loadTSDBStatus(r.Context(), client.ReadOnlyConnection(), limit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, status)
+	}
+}
+
+func loadTSDBStatus(ctx context.Context, conn pgxconn.PgxConn, limit int) (*tsdbStatus, error) {
+	var status tsdbStatus
+	if err := conn.QueryRow(ctx, tsdbNumSeriesSQL).Scan(&status.HeadStats.NumSeries); err != nil {
+		return nil, fmt.Errorf("counting series: %w", err)
+	}
+	if err := conn.QueryRow(ctx, tsdbNumLabelPairsSQL).Scan(&status.HeadStats.NumLabelPairs); err != nil {
+		return nil, fmt.Errorf("counting label pairs: %w", err)
+	}
+	stats := []struct {
+		sql string
+		res *[]tsdbStat
+	}{
+		{tsdbSeriesByMetricSQL, &status.SeriesCountByMetricName},
+		{tsdbValuesByLabelSQL, &status.LabelValueCountByLabelName},
+		{tsdbBytesByLabelSQL, &status.MemoryInBytesByLabelName},
+		{tsdbSeriesByLabelPairSQL, &status.SeriesCountByLabelValuePair},
+	}
+	for _, s := range stats {
+		res, err := loadTSDBStats(ctx, conn, s.sql, limit)
+		if err != nil {
+			return nil, err
+		}
+		*s.res = res
+	}
+	return &status, nil
+}
+
+func loadTSDBStats(ctx context.Context, conn pgxconn.PgxConn, sql string, limit int) ([]tsdbStat, error) {
+	rows, err := conn.Query(ctx, sql, limit)
+	if err != nil {
+		return nil, fmt.Errorf("reading tsdb statistics: %w", err)
+	}
+	defer rows.Close()
+	res := make([]tsdbStat, 0, limit)
+	for rows.Next() {
+		var s tsdbStat
+		if err = rows.Scan(&s.Name, &s.Value); err != nil {
+			return nil, fmt.Errorf("reading tsdb statistics: %w", err)
+		}
+		res = append(res, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading tsdb statistics: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,83 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/storagesim"
+)
+
+// StorageSimulation estimates the storage used over time with the proposed
+// retention, compression and rollup settings, compared to the current ones.
+// Nothing is changed in the database.
+func StorageSimulation(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, storageSimulationHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func storageSimulationHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		settings, err := parseSimulationSettings(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		stats, err := storagesim.LoadStats(r.Context(), client.ReadOnlyConnection())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, storagesim.Simulate(stats, settings, time.Now()))
+	}
+}
+
+func parseSimulationSettings(r *http.Request) (storagesim.Settings, error) {
+	var (
+		s   storagesim.Settings
+		err error
+	)
+	durations := []struct {
+		param string
+		value *time.Duration
+	}{
+		{"retention", &s.Retention},
+		{"compress_after", &s.CompressAfter},
+		{"rollup_resolution", &s.RollupResolution},
+		{"rollup_retention", &s.RollupRetention},
+		{"horizon", &s.Horizon},
+		{"step", &s.Step},
+	}
+	for _, d := range durations {
+		if v := r.FormValue(d.param); v != "" {
+			if *d.value, err = parseDuration(v); err != nil {
+				return s, fmt.Errorf("invalid %s: %w", d.param, err)
+			}
+		}
+	}
+	if v := r.FormValue("compression"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return s, fmt.Errorf("invalid compression: %w", err)
+		}
+		s.Compression = &enabled
+	}
+	if v := r.FormValue("compression_ratio"); v != "" {
+		if s.CompressionRatio, err = strconv.ParseFloat(v, 64); err != nil {
+			return s, fmt.Errorf("invalid compression_ratio: %w", err)
+		}
+	}
+	s.Metrics = r.Form["metric"]
+	return s, s.Validate()
+}
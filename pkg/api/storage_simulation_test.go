@@ -0,0 +1,76 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/storagesim"
+)
+
+func TestParseSimulationSettings(t *testing.T) {
+	disabled := false
+	testCases := []struct {
+		name     string
+		query    string
+		expected storagesim.Settings
+		err      bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			expected: storagesim.Settings{
+				Horizon: storagesim.DefaultHorizon,
+				Step:    storagesim.DefaultStep,
+			},
+		},
+		{
+			name:  "all settings",
+			query: "retention=30d&compression=false&compress_after=2h&compression_ratio=12.5&rollup_resolution=1h&rollup_retention=1y&metric=a&metric=b&horizon=10d&step=12h",
+			expected: storagesim.Settings{
+				Retention:        30 * 24 * time.Hour,
+				Compression:      &disabled,
+				CompressAfter:    2 * time.Hour,
+				CompressionRatio: 12.5,
+				RollupResolution: time.Hour,
+				RollupRetention:  365 * 24 * time.Hour,
+				Metrics:          []string{"a", "b"},
+				Horizon:          10 * 24 * time.Hour,
+				Step:             12 * time.Hour,
+			},
+		},
+		{
+			name:  "invalid duration",
+			query: "retention=forever",
+			err:   true,
+		},
+		{
+			name:  "invalid compression",
+			query: "compression=maybe",
+			err:   true,
+		},
+		{
+			name:  "rollup without retention",
+			query: "rollup_resolution=1h",
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/storage/simulate?"+c.query, nil)
+			require.NoError(t, r.ParseForm())
+			settings, err := parseSimulationSettings(r)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, settings)
+		})
+	}
+}
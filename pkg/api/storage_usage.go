@@ -0,0 +1,47 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics/database"
+)
+
+// AdminStorageUsage reports the storage used by every metric and, split by
+// the tenant_label parameter or the metrics.storage-usage.tenant-label flag,
+// by every tenant. Unlike the storage usage gauges, the report is not
+// limited to the top-K metrics and tenants.
+func AdminStorageUsage(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminStorageUsageHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminStorageUsageHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The report reveals the storage used by all the tenants.
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("the storage usage report requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		tenantLabel := conf.StorageUsageTenantLabel
+		if l, ok := r.Form["tenant_label"]; ok {
+			tenantLabel = l[0]
+		}
+		usage, err := database.LoadStorageUsage(r.Context(), client.ReadOnlyConnection(), tenantLabel)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, usage)
+	}
+}
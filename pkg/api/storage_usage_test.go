@@ -0,0 +1,19 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminStorageUsagePermissions(t *testing.T) {
+	w := httptest.NewRecorder()
+	adminStorageUsageHandler(&Config{StorageUsageTenantLabel: "team"}, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/storage/usage", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
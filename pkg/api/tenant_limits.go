@@ -0,0 +1,67 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+// withQueryLimits rejects the queries of the tenants that already run their
+// maximum number of concurrent queries.
+func withQueryLimits(limiter *ratelimit.Limiter, handler http.Handler) http.Handler {
+	if limiter == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := limiter.AcquireQuery(getLimitedTenant(r))
+		if err != nil {
+			var limitErr *ratelimit.Error
+			errors.As(err, &limitErr)
+			setRetryAfter(w, limitErr)
+			respondError(w, http.StatusTooManyRequests, err, "unavailable")
+			return
+		}
+		defer release()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// getLimitedTenant returns the tenant whose limits apply to a query: the one
+// set in the tenant header, or else the user of the request.
+func getLimitedTenant(r *http.Request) string {
+	if tenant := r.Header.Get(tenancy.TenantHeader); tenant != "" {
+		return tenant
+	}
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// respondTenantLimitError responds with 429 if err is caused by a tenant
+// exceeding one of its limits and returns false otherwise.
+func respondTenantLimitError(w http.ResponseWriter, err error) bool {
+	var limitErr *ratelimit.Error
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	log.Debug("msg", "Request rejected by tenant limits", "err", err)
+	setRetryAfter(w, limitErr)
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+func setRetryAfter(w http.ResponseWriter, err *ratelimit.Error) {
+	seconds := int64(math.Ceil(err.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
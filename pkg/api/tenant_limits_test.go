@@ -0,0 +1,56 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+func TestWithQueryLimits(t *testing.T) {
+	limitsFile := filepath.Join(t.TempDir(), "limits.yml")
+	require.NoError(t, os.WriteFile(limitsFile, []byte("default:\n  max_concurrent_queries: 1\n"), 0600))
+	limiter, err := ratelimit.NewLimiter(&ratelimit.Config{LimitsFile: limitsFile})
+	require.NoError(t, err)
+
+	var (
+		running = make(chan struct{})
+		done    = make(chan struct{})
+	)
+	handler := withQueryLimits(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tenancy.TenantHeader) == "blocked" {
+			running <- struct{}{}
+			<-done
+		}
+	}))
+
+	newRequest := func(tenant string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		req.Header.Set(tenancy.TenantHeader, tenant)
+		return req
+	}
+
+	go handler.ServeHTTP(httptest.NewRecorder(), newRequest("blocked"))
+	<-running
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest("blocked"))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest("other"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	close(done)
+}
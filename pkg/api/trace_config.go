@@ -0,0 +1,125 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/gorilla/mux"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/traceconfig"
+)
+
+// TraceServiceConfigs lists the retention period and compression schedule
+// of the services with settings of their own.
+func TraceServiceConfigs(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, traceServiceConfigsHandler(client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func traceServiceConfigsHandler(client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings, err := traceconfig.List(r.Context(), client.MetadataConnection())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusOK, settings)
+	}
+}
+
+// AdminTraceServiceConfig returns the retention period and compression
+// schedule of the traces of the service of the path with GET, and changes
+// them with PUT. Changing them requires the admin API to be enabled.
+func AdminTraceServiceConfig(conf *Config, client *pgclient.Client) http.Handler {
+	hf := corsWrapper(conf, adminTraceServiceConfigHandler(conf, client))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminTraceServiceConfigHandler(conf *Config, client *pgclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service := mux.Vars(r)["service"]
+		if r.Method == http.MethodGet {
+			settings, err := traceconfig.Get(r.Context(), client.MetadataConnection(), service)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err, "internal")
+				return
+			}
+			respond(w, http.StatusOK, settings)
+			return
+		}
+
+		if conf.ReadOnly {
+			respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot change the trace service configuration"), "operation_not_permitted")
+			return
+		}
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("changing the trace service configuration requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		update, err := parseTraceServiceConfigUpdate(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		conn := client.MaintenanceConnection()
+		previous, err := traceconfig.Get(r.Context(), conn, service)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		if err = traceconfig.Apply(r.Context(), conn, service, update); err != nil {
+			log.Error("msg", "Failed to change trace service configuration", "service", service, "changes", update.String(),
+				"remote_addr", r.RemoteAddr, "err", err)
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		settings, err := traceconfig.Get(r.Context(), conn, service)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		log.Info("msg", "Changed trace service configuration", "service", service, "changes", update.String(),
+			"previous", fmt.Sprintf("%+v", previous), "current", fmt.Sprintf("%+v", settings), "remote_addr", r.RemoteAddr)
+		respond(w, http.StatusOK, settings)
+	}
+}
+
+// parseTraceServiceConfigUpdate parses the retention and compress_after
+// parameters. A parameter set to "default" resets the setting: the default
+// retention period of the traces, and no compression.
+func parseTraceServiceConfigUpdate(r *http.Request) (traceconfig.Update, error) {
+	var u traceconfig.Update
+	durations := []struct {
+		name  string
+		d     **time.Duration
+		reset *bool
+	}{
+		{"retention", &u.Retention, &u.ResetRetention},
+		{"compress_after", &u.CompressAfter, &u.ResetCompressAfter},
+	}
+	for _, p := range durations {
+		switch s := r.FormValue(p.name); s {
+		case "":
+		case resetSetting:
+			*p.reset = true
+		default:
+			d, err := parseDuration(s)
+			if err != nil {
+				return u, fmt.Errorf("invalid %s %q: %w", p.name, s, err)
+			}
+			*p.d = &d
+		}
+	}
+	return u, traceconfig.Validate(u)
+}
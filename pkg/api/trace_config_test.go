@@ -0,0 +1,53 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/traceconfig"
+)
+
+func TestParseTraceServiceConfigUpdate(t *testing.T) {
+	hour := time.Hour
+	retention := 3 * 24 * time.Hour
+	testCases := []struct {
+		name     string
+		query    string
+		expected traceconfig.Update
+		err      bool
+	}{
+		{
+			name:     "set all",
+			query:    "retention=3d&compress_after=1h",
+			expected: traceconfig.Update{Retention: &retention, CompressAfter: &hour},
+		},
+		{
+			name:     "reset",
+			query:    "retention=default&compress_after=default",
+			expected: traceconfig.Update{ResetRetention: true, ResetCompressAfter: true},
+		},
+		{name: "nothing to change", query: "", err: true},
+		{name: "invalid retention", query: "retention=forever", err: true},
+		{name: "retention shorter than compress after", query: "retention=1h&compress_after=1d", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/trace/services/gateway?"+c.query, nil)
+			require.NoError(t, req.ParseForm())
+			u, err := parseTraceServiceConfigUpdate(req)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, u)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/timescale/promscale/pkg/vacuum"
+)
+
+// AdminVacuum triggers an immediate run of the vacuum engine, optionally
+// restricted to some hypertables and compressing their chunks first. The run
+// happens in the background, its progress is reported by the metrics of the
+// vacuum engine.
+func AdminVacuum(conf *Config) http.Handler {
+	hf := corsWrapper(conf, adminVacuumHandler(conf))
+	return gziphandler.GzipHandler(hf)
+}
+
+func adminVacuumHandler(conf *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.ReadOnly {
+			respondError(w, http.StatusForbidden, fmt.Errorf("read-only connector cannot run the vacuum engine"), "operation_not_permitted")
+			return
+		}
+		if !conf.AdminAPIEnabled {
+			respondError(w, http.StatusForbidden, fmt.Errorf("running the vacuum engine requires admin permissions. Use -web.enable-admin-api flag to allow it"), "operation_not_permitted")
+			return
+		}
+		if conf.Vacuum == nil {
+			respondError(w, http.StatusNotFound, fmt.Errorf("vacuum engine is disabled. To enable, start Promscale without '-vacuum.disable' flag"), "not_found")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		req, err := parseVacuumRequest(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err, "bad_data")
+			return
+		}
+		err = conf.Vacuum.Trigger(req)
+		if errors.Is(err, vacuum.ErrRunning) {
+			respondError(w, http.StatusConflict, err, "conflict")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err, "internal")
+			return
+		}
+		respond(w, http.StatusAccepted, nil)
+	}
+}
+
+func parseVacuumRequest(r *http.Request) (vacuum.Request, error) {
+	req := vacuum.Request{Hypertables: []string{}}
+	for _, h := range r.Form["hypertable[]"] {
+		if !strings.Contains(h, ".") {
+			return req, fmt.Errorf("invalid hypertable %q: must be qualified with its schema", h)
+		}
+		req.Hypertables = append(req.Hypertables, h)
+	}
+	if s := r.FormValue("compress"); s != "" {
+		compress, err := strconv.ParseBool(s)
+		if err != nil {
+			return req, fmt.Errorf("invalid compress %q: %w", s, err)
+		}
+		req.Compress = compress
+	}
+	if req.Compress && len(req.Hypertables) == 0 {
+		return req, fmt.Errorf("compress requires at least one hypertable[] parameter")
+	}
+	return req, nil
+}
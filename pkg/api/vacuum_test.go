@@ -0,0 +1,83 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/vacuum"
+)
+
+func TestAdminVacuum(t *testing.T) {
+	engine := vacuum.NewEngine(nil, vacuum.Config{})
+	defer engine.Stop()
+	cases := []struct {
+		name         string
+		config       Config
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "admin API disabled",
+			config:       Config{Vacuum: engine},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "read-only",
+			config:       Config{AdminAPIEnabled: true, ReadOnly: true, Vacuum: engine},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "vacuum engine disabled",
+			config:       Config{AdminAPIEnabled: true},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "compress without hypertable",
+			config:       Config{AdminAPIEnabled: true, Vacuum: engine},
+			body:         "compress=true",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unqualified hypertable",
+			config:       Config{AdminAPIEnabled: true, Vacuum: engine},
+			body:         "hypertable[]=up",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid compress",
+			config:       Config{AdminAPIEnabled: true, Vacuum: engine},
+			body:         "hypertable[]=prom_data.up&compress=maybe",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/vacuum", strings.NewReader(c.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			adminVacuumHandler(&c.config).ServeHTTP(w, req)
+			require.Equal(t, c.expectedCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestParseVacuumRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/vacuum?hypertable[]=prom_data.up&hypertable[]=prom_data.foo&compress=true", nil)
+	require.NoError(t, req.ParseForm())
+	parsed, err := parseVacuumRequest(req)
+	require.NoError(t, err)
+	require.Equal(t, vacuum.Request{Hypertables: []string{"prom_data.up", "prom_data.foo"}, Compress: true}, parsed)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/vacuum", nil)
+	require.NoError(t, req.ParseForm())
+	parsed, err = parseVacuumRequest(req)
+	require.NoError(t, err)
+	require.Equal(t, vacuum.Request{Hypertables: []string{}}, parsed)
+}
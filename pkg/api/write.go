@@ -6,19 +6,26 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/snappy"
 	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/api/parser/protobuf"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
 	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/relabel"
+	"github.com/timescale/promscale/pkg/tenancy"
 	"github.com/timescale/promscale/pkg/tracer"
 )
 
@@ -48,20 +55,44 @@ func (wh *writeHandler) handler() http.Handler {
 }
 
 // Write returns an http.Handler that is responsible for data ingest.
+// tenantAckModes maps tenants to the AckMode of their write requests.
 func Write(
 	inserter ingestor.DBInserter,
 	dataParser *parser.DefaultParser,
+	tenantAckModes map[string]ingestor.AckMode,
 	updateMetrics func(code string, duration, receivedSamples, receivedMetadata float64),
 ) http.Handler {
 	wh := writeHandler{}
 	wh.addStages(
 		validateWriteHeaders,
 		decodeSnappy,
-		ingest(inserter, dataParser, updateMetrics),
+		ingest(inserter, dataParser, tenantAckModes, updateMetrics),
 	)
 	return wh.handler()
 }
 
+// ackModeHeader lets the client choose if the request is acknowledged after
+// the data is committed (sync) or as soon as it is queued for insertion (async).
+const ackModeHeader = "ACK-MODE"
+
+// getAckMode returns the AckMode of the write request. The header set by the
+// client takes precedence over the mode configured for the tenant of the request.
+// Only the tenant header is considered since the tenant labels of a request
+// can differ from one series to another.
+func getAckMode(r *http.Request, tenantAckModes map[string]ingestor.AckMode) (ingestor.AckMode, error) {
+	if header := r.Header.Get(ackModeHeader); header != "" {
+		mode, err := ingestor.ParseAckMode(header)
+		if err != nil {
+			return ingestor.AckModeDefault, fmt.Errorf("%s header: %w", ackModeHeader, err)
+		}
+		return mode, nil
+	}
+	if tenant := r.Header.Get(tenancy.TenantHeader); tenant != "" {
+		return tenantAckModes[tenant], nil
+	}
+	return ingestor.AckModeDefault, nil
+}
+
 func validateWriteHeaders(w http.ResponseWriter, r *http.Request) bool {
 	// validate headers from https://github.com/prometheus/prometheus/blob/2bd077ed9724548b6a631b6ddba48928704b5c34/storage/remote/client.go
 	_, span := tracer.Default().Start(r.Context(), "validate-write-headers")
@@ -71,13 +102,27 @@ func validateWriteHeaders(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
-	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		validateError(w, "Error parsing media type from Content-Type header", metrics)
 		return false
 	}
 	switch mediaType {
 	case "application/x-protobuf":
+		// The remote write 2.0 spec requires 415 for the messages the
+		// receiver does not support, so that the sender can fall back to
+		// an older version.
+		expectedVersion := "0.1."
+		switch proto := params["proto"]; proto {
+		case "", protobuf.ProtoV1:
+		case protobuf.ProtoV2:
+			expectedVersion = "2."
+		default:
+			log.Error("msg", "Write header validation error", "err", "unsupported protobuf message "+proto)
+			http.Error(w, fmt.Sprintf("unsupported protobuf message %s, expected %s or %s", proto, protobuf.ProtoV1, protobuf.ProtoV2), http.StatusUnsupportedMediaType)
+			return false
+		}
+
 		if !strings.Contains(r.Header.Get("Content-Encoding"), "snappy") {
 			validateError(w, fmt.Sprintf("non-snappy compressed data got: %s", r.Header.Get("Content-Encoding")), metrics)
 			return false
@@ -89,8 +134,8 @@ func validateWriteHeaders(w http.ResponseWriter, r *http.Request) bool {
 			return false
 		}
 
-		if !strings.HasPrefix(remoteWriteVersion, "0.1.") {
-			validateError(w, fmt.Sprintf("unexpected Remote-Write-Version %s, expected 0.1.X", remoteWriteVersion), metrics)
+		if !strings.HasPrefix(remoteWriteVersion, expectedVersion) {
+			validateError(w, fmt.Sprintf("unexpected Remote-Write-Version %s, expected %sX", remoteWriteVersion, expectedVersion), metrics)
 			return false
 		}
 	case "application/json":
@@ -203,6 +248,7 @@ var decodedBufPool = sync.Pool{
 func ingest(
 	inserter ingestor.DBInserter,
 	dataParser *parser.DefaultParser,
+	tenantAckModes map[string]ingestor.AckMode,
 	updateMetrics func(code string, durationSeconds, receivedSamples, receivedMetadata float64),
 ) func(http.ResponseWriter, *http.Request) bool {
 	return func(w http.ResponseWriter, r *http.Request) bool {
@@ -220,8 +266,20 @@ func ingest(
 		ctx, span := tracer.Default().Start(r.Context(), "ingest")
 		defer span.End()
 
+		ackMode, err := getAckMode(r, tenantAckModes)
+		if err != nil {
+			invalidRequestError(w, "ack mode error", err.Error(), metrics)
+			return false
+		}
+		ctx = ingestor.WithAckMode(ctx, ackMode)
+
+		// The data of remote write 2.0 requests is counted by the parser,
+		// for the response headers.
+		stats := new(protobuf.Stats)
+		r = r.WithContext(protobuf.WithStats(r.Context(), stats))
+
 		req := ingestor.NewWriteRequest()
-		err := dataParser.ParseRequest(r, req)
+		err = dataParser.ParseRequest(r, req)
 		if err != nil {
 			ingestor.FinishWriteRequest(req)
 			invalidRequestError(w, "parser error", err.Error(), metrics)
@@ -233,23 +291,66 @@ func ingest(
 		// if samples in write request are empty then we do not need to
 		// proceed further
 		if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
+			setWrittenHeaders(w, r, stats)
 			statusCode = "2xx"
 			ingestor.FinishWriteRequest(req)
 			return false
 		}
 
 		numSamples, _, err := inserter.IngestMetrics(ctx, req)
+		if respondTenantLimitError(w, err) {
+			statusCode = "429"
+			return false
+		}
+		if respondBackpressureError(w, err) {
+			statusCode = "503"
+			return false
+		}
+		var conflictErr *relabel.ConflictError
+		if errors.As(err, &conflictErr) {
+			invalidRequestError(w, "external label conflict", err.Error(), metrics)
+			return false
+		}
 		if err != nil {
 			statusCode = "500"
 			log.Warn("msg", "Error sending samples to remote storage", "err", err, "num_samples", numSamples)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return false
 		}
+		setWrittenHeaders(w, r, stats)
 		statusCode = "2xx"
 		return true
 	}
 }
 
+// setWrittenHeaders sets the headers of the responses to remote write 2.0
+// requests that report the data written.
+func setWrittenHeaders(w http.ResponseWriter, r *http.Request, stats *protobuf.Stats) {
+	if protobuf.Proto(r) != protobuf.ProtoV2 {
+		return
+	}
+	w.Header().Set("X-Prometheus-Remote-Write-Samples-Written", strconv.Itoa(stats.Samples))
+	w.Header().Set("X-Prometheus-Remote-Write-Histograms-Written", strconv.Itoa(stats.Histograms))
+	w.Header().Set("X-Prometheus-Remote-Write-Exemplars-Written", strconv.Itoa(stats.Exemplars))
+}
+
+// respondBackpressureError responds with 503 if err is caused by the ingest
+// circuit breaker being open and returns false otherwise.
+func respondBackpressureError(w http.ResponseWriter, err error) bool {
+	var bpErr *backpressure.Error
+	if !errors.As(err, &bpErr) {
+		return false
+	}
+	log.Debug("msg", "Request rejected by the ingest circuit breaker", "err", err)
+	seconds := int64(math.Ceil(bpErr.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	return true
+}
+
 func invalidRequestError(w http.ResponseWriter, msg, err string, m *Metrics) {
 	log.Error("msg", msg, "err", err)
 	http.Error(w, err, http.StatusBadRequest)
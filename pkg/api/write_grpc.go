@@ -0,0 +1,336 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/relabel"
+)
+
+// The gRPC write service is the protobuf equivalent of the remote write
+// endpoint, without the snappy compression and the HTTP/1.1 round trips:
+//
+//	service WriteService {
+//	  rpc Write(prometheus.WriteRequest) returns (WriteResponse);
+//	  rpc WriteStream(stream prometheus.WriteRequest) returns (stream WriteResponse);
+//	}
+//
+// The requests of a stream are ingested one after the other, each one is
+// answered once it is ingested. The HTTP/2 flow control of the stream stops
+// the client when Promscale ingests slower than it writes.
+const writeServiceName = "promscale.v1.WriteService"
+
+// RetryAfterTrailer is the trailer holding the number of seconds to wait
+// before retrying a write rejected by the tenant limits or the ingest
+// circuit breaker, as the Retry-After header of the HTTP write endpoint.
+const RetryAfterTrailer = "retry-after"
+
+// WriteResponse is the response of the gRPC write service:
+//
+//	message WriteResponse {
+//	  int64 samples_written = 1;
+//	  int64 metadata_written = 2;
+//	}
+type WriteResponse struct {
+	SamplesWritten  int64 `protobuf:"varint,1,opt,name=samples_written,json=samplesWritten,proto3" json:"samples_written,omitempty"`
+	MetadataWritten int64 `protobuf:"varint,2,opt,name=metadata_written,json=metadataWritten,proto3" json:"metadata_written,omitempty"`
+}
+
+func (m *WriteResponse) Reset()      { *m = WriteResponse{} }
+func (*WriteResponse) ProtoMessage() {}
+func (m *WriteResponse) String() string {
+	return fmt.Sprintf("samples_written:%d metadata_written:%d", m.SamplesWritten, m.MetadataWritten)
+}
+
+// Marshal implements the marshaling of the gogo protobuf messages.
+func (m *WriteResponse) Marshal() ([]byte, error) {
+	var b []byte
+	if m.SamplesWritten != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.SamplesWritten))
+	}
+	if m.MetadataWritten != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.MetadataWritten))
+	}
+	return b, nil
+}
+
+// Unmarshal implements the unmarshaling of the gogo protobuf messages.
+func (m *WriteResponse) Unmarshal(b []byte) error {
+	m.Reset()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.VarintType && (num == 1 || num == 2) {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if num == 1 {
+				m.SamplesWritten = int64(v)
+			} else {
+				m.MetadataWritten = int64(v)
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// WriteServer is the server of the gRPC write service.
+type WriteServer interface {
+	Write(context.Context, *prompb.WriteRequest) (*WriteResponse, error)
+	WriteStream(grpc.ServerStream) error
+}
+
+// RegisterWriteServer registers the gRPC write service on s.
+func RegisterWriteServer(s *grpc.Server, srv WriteServer) {
+	s.RegisterService(&writeServiceDesc, srv)
+}
+
+var writeServiceDesc = grpc.ServiceDesc{
+	ServiceName: writeServiceName,
+	HandlerType: (*WriteServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Write",
+		Handler:    writeHandlerFunc,
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "WriteStream",
+		Handler:       writeStreamHandlerFunc,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+func writeHandlerFunc(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := ingestor.NewWriteRequest()
+	if err := dec(req); err != nil {
+		ingestor.FinishWriteRequest(req)
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WriteServer).Write(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + writeServiceName + "/Write",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WriteServer).Write(ctx, req.(*prompb.WriteRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func writeStreamHandlerFunc(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WriteServer).WriteStream(stream)
+}
+
+// NewWriteServer returns the server of the gRPC write service. It runs the
+// write requests through the same preprocessors as the HTTP write endpoint,
+// and authorizes them with authorize, which gets the metadata of the
+// requests as headers.
+func NewWriteServer(apiConf *Config, client *pgclient.Client, authorize func(*http.Request) error) WriteServer {
+	dataParser, _ := writeParser(apiConf, client)
+	return &writeServer{
+		inserter:       client,
+		parser:         dataParser,
+		tenantAckModes: apiConf.TenantAckModes,
+		authorize:      authorize,
+		updateMetrics:  updateIngestMetrics,
+	}
+}
+
+type writeServer struct {
+	inserter       ingestor.DBInserter
+	parser         *parser.DefaultParser
+	tenantAckModes map[string]ingestor.AckMode
+	authorize      func(*http.Request) error
+	updateMetrics  func(code string, durationSeconds, receivedSamples, receivedMetadata float64)
+}
+
+func (s *writeServer) Write(ctx context.Context, req *prompb.WriteRequest) (*WriteResponse, error) {
+	return s.write(ctx, "/"+writeServiceName+"/Write", req)
+}
+
+func (s *writeServer) WriteStream(stream grpc.ServerStream) error {
+	method := "/" + writeServiceName + "/WriteStream"
+	for {
+		req := ingestor.NewWriteRequest()
+		if err := stream.RecvMsg(req); err != nil {
+			ingestor.FinishWriteRequest(req)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		// A failed write ends the stream, the client retries it on a new
+		// stream once the cause is gone.
+		resp, err := s.write(stream.Context(), method, req)
+		if err != nil {
+			return err
+		}
+		if err = stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// write ingests req as the ingest stage of the HTTP write endpoint does,
+// the responses of the HTTP endpoint are mapped to their gRPC equivalent.
+func (s *writeServer) write(ctx context.Context, method string, req *prompb.WriteRequest) (*WriteResponse, error) {
+	begin := time.Now()
+	statusCode := "400"
+	numSamplesReceived := getTotalSamples(req)
+	numMetadataReceived := len(req.Metadata)
+	defer func() {
+		s.updateMetrics(statusCode, time.Since(begin).Seconds(), float64(numSamplesReceived), float64(numMetadataReceived))
+	}()
+
+	r, err := requestFromMetadata(ctx, method)
+	if err != nil {
+		ingestor.FinishWriteRequest(req)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.authorize != nil {
+		if err = s.authorize(r); err != nil {
+			statusCode = "401"
+			ingestor.FinishWriteRequest(req)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+	ackMode, err := getAckMode(r, s.tenantAckModes)
+	if err != nil {
+		ingestor.FinishWriteRequest(req)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("ack mode error: %s", err))
+	}
+	if err = s.parser.Preprocess(r, req); err != nil {
+		ingestor.FinishWriteRequest(req)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("parser error: %s", err))
+	}
+	if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
+		statusCode = "2xx"
+		ingestor.FinishWriteRequest(req)
+		return &WriteResponse{}, nil
+	}
+
+	numSamples, numMetadata, err := s.inserter.IngestMetrics(ingestor.WithAckMode(ctx, ackMode), req)
+	if err != nil {
+		var code codes.Code
+		statusCode, code = writeErrorCode(err)
+		if code == codes.Internal {
+			log.Warn("msg", "Error sending samples to remote storage", "err", err, "num_samples", numSamples)
+		}
+		if trailer := retryAfter(err); trailer != nil {
+			if err := grpc.SetTrailer(ctx, trailer); err != nil {
+				log.Debug("msg", "error setting retry-after trailer", "err", err)
+			}
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	statusCode = "2xx"
+	return &WriteResponse{SamplesWritten: int64(numSamples), MetadataWritten: int64(numMetadata)}, nil
+}
+
+// writeErrorCode returns the HTTP status code of the ingest metrics and the
+// gRPC code of an ingest error.
+func writeErrorCode(err error) (string, codes.Code) {
+	var (
+		limitErr    *ratelimit.Error
+		bpErr       *backpressure.Error
+		conflictErr *relabel.ConflictError
+	)
+	switch {
+	case errors.As(err, &limitErr):
+		log.Debug("msg", "Request rejected by tenant limits", "err", err)
+		return "429", codes.ResourceExhausted
+	case errors.As(err, &bpErr):
+		log.Debug("msg", "Request rejected by the ingest circuit breaker", "err", err)
+		return "503", codes.Unavailable
+	case errors.As(err, &conflictErr):
+		return "400", codes.InvalidArgument
+	default:
+		return "500", codes.Internal
+	}
+}
+
+// requestFromMetadata returns the request seen by the preprocessors and the
+// authorization of a gRPC write. Its headers are the metadata of the call and
+// its path the gRPC method.
+func requestFromMetadata(ctx context.Context, method string) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, method, nil)
+	if err != nil {
+		return nil, err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	// The TLS state holds the client certificate authenticating the client.
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r, nil
+}
+
+// retryAfter returns the retry-after trailer of err, nil if the client does
+// not have to wait before retrying.
+func retryAfter(err error) metadata.MD {
+	var (
+		limitErr *ratelimit.Error
+		bpErr    *backpressure.Error
+		after    time.Duration
+	)
+	switch {
+	case errors.As(err, &limitErr):
+		after = limitErr.RetryAfter
+	case errors.As(err, &bpErr):
+		after = bpErr.RetryAfter
+	default:
+		return nil
+	}
+	seconds := int64(math.Ceil(after.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return metadata.Pairs(RetryAfterTrailer, strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,157 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/timescale/promscale/pkg/api/parser"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/ratelimit"
+)
+
+func newTestWriteClient(t *testing.T, srv *writeServer) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterWriteServer(server, srv)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testWriteRequest(samples int) *prompb.WriteRequest {
+	ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}}
+	for i := 0; i < samples; i++ {
+		ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: int64(i), Value: 1})
+	}
+	return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{ts}}
+}
+
+func TestWriteServer(t *testing.T) {
+	const token = "secret"
+	authorize := func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			return fmt.Errorf("invalid bearer token")
+		}
+		return nil
+	}
+	authorized := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+
+	testCases := []struct {
+		name      string
+		ctx       context.Context
+		err       error
+		code      codes.Code
+		trailer   string
+		responses int64
+	}{
+		{
+			name:      "written",
+			ctx:       authorized,
+			responses: 3,
+		},
+		{
+			name: "unauthorized",
+			ctx:  context.Background(),
+			code: codes.Unauthenticated,
+		},
+		{
+			name:    "tenant limit",
+			ctx:     authorized,
+			err:     &ratelimit.Error{Tenant: "a", Limit: "ingest rate", RetryAfter: 1500 * time.Millisecond},
+			code:    codes.ResourceExhausted,
+			trailer: "2",
+		},
+		{
+			name: "ingest error",
+			ctx:  authorized,
+			err:  fmt.Errorf("some error"),
+			code: codes.Internal,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			inserter := &mockInserter{result: c.responses, err: c.err}
+			conn := newTestWriteClient(t, &writeServer{
+				inserter:      inserter,
+				parser:        parser.NewParser(),
+				authorize:     authorize,
+				updateMetrics: func(string, float64, float64, float64) {},
+			})
+
+			var (
+				resp    WriteResponse
+				trailer metadata.MD
+			)
+			err := conn.Invoke(c.ctx, "/"+writeServiceName+"/Write", testWriteRequest(3), &resp, grpc.Trailer(&trailer))
+			require.Equal(t, c.code, status.Code(err), "%v", err)
+			if c.code != codes.OK {
+				if c.trailer != "" {
+					require.Equal(t, []string{c.trailer}, trailer.Get(RetryAfterTrailer))
+				}
+				return
+			}
+			require.Equal(t, c.responses, resp.SamplesWritten)
+			require.Len(t, inserter.ts, 1)
+		})
+	}
+}
+
+func TestWriteServerStream(t *testing.T) {
+	inserter := &mockInserter{result: 2}
+	conn := newTestWriteClient(t, &writeServer{
+		inserter:      inserter,
+		parser:        parser.NewParser(),
+		updateMetrics: func(string, float64, float64, float64) {},
+	})
+
+	stream, err := conn.NewStream(context.Background(), &writeServiceDesc.Streams[0], "/"+writeServiceName+"/WriteStream")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, stream.SendMsg(testWriteRequest(2)))
+		var resp WriteResponse
+		require.NoError(t, stream.RecvMsg(&resp))
+		require.Equal(t, int64(2), resp.SamplesWritten)
+	}
+	require.NoError(t, stream.CloseSend())
+	require.Equal(t, io.EOF, stream.RecvMsg(&WriteResponse{}))
+
+	// A failed write ends the stream.
+	inserter.err = fmt.Errorf("some error")
+	stream, err = conn.NewStream(context.Background(), &writeServiceDesc.Streams[0], "/"+writeServiceName+"/WriteStream")
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(testWriteRequest(2)))
+	require.Equal(t, codes.Internal, status.Code(stream.RecvMsg(&WriteResponse{})))
+}
+
+func TestWriteResponseMarshal(t *testing.T) {
+	resp := &WriteResponse{SamplesWritten: 1000, MetadataWritten: 3}
+	b, err := resp.Marshal()
+	require.NoError(t, err)
+	var decoded WriteResponse
+	require.NoError(t, decoded.Unmarshal(b))
+	require.Equal(t, *resp, decoded)
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
@@ -23,7 +24,10 @@ import (
 
 	"github.com/timescale/promscale/pkg/api/parser"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
 	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/relabel"
 )
 
 func TestDetectSnappyStreamFormat(t *testing.T) {
@@ -164,6 +168,24 @@ func TestWrite(t *testing.T) {
 				"X-Prometheus-Remote-Write-Version": "0.0.0",
 			},
 		},
+		{
+			name:         "unsupported protobuf message",
+			responseCode: http.StatusUnsupportedMediaType,
+			customHeaders: map[string]string{
+				"Content-Type":                      "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+				"Content-Encoding":                  "snappy",
+				"X-Prometheus-Remote-Write-Version": "3.0.0",
+			},
+		},
+		{
+			name:         "remote write 1.0 version with 2.0 message",
+			responseCode: http.StatusBadRequest,
+			customHeaders: map[string]string{
+				"Content-Type":                      "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+				"Content-Encoding":                  "snappy",
+				"X-Prometheus-Remote-Write-Version": "0.1.0",
+			},
+		},
 		{
 			name:            "happy path",
 			responseCode:    http.StatusOK,
@@ -211,6 +233,40 @@ func TestWrite(t *testing.T) {
 				"Content-Encoding": "snappy",
 			},
 		},
+		{
+			name:            "circuit breaker open",
+			receivedSamples: 1,
+			responseCode:    http.StatusServiceUnavailable,
+			inserterErr:     fmt.Errorf("ingest: %w", &backpressure.Error{RetryAfter: time.Second}),
+			requestBody: writeRequestToString(
+				&prompb.WriteRequest{
+					Timeseries: []prompb.TimeSeries{
+						{
+							Samples: []prompb.Sample{
+								{},
+							},
+						},
+					},
+				},
+			),
+		},
+		{
+			name:            "external label conflict",
+			receivedSamples: 1,
+			responseCode:    http.StatusBadRequest,
+			inserterErr:     fmt.Errorf("ingest: %w", &relabel.ConflictError{Label: "cluster", Value: "us1", Expected: "eu1"}),
+			requestBody: writeRequestToString(
+				&prompb.WriteRequest{
+					Timeseries: []prompb.TimeSeries{
+						{
+							Samples: []prompb.Sample{
+								{},
+							},
+						},
+					},
+				},
+			),
+		},
 	}
 
 	for _, c := range testCases {
@@ -222,7 +278,7 @@ func TestWrite(t *testing.T) {
 			metrics = &Metrics{LastRequestUnixNano: 0}
 			dataParser := parser.NewParser()
 			numSamplesReceived := &mockMetric{}
-			handler := Write(mock, dataParser, mockUpdaterForIngest(&mockMetric{}, nil, numSamplesReceived, nil))
+			handler := Write(mock, dataParser, nil, mockUpdaterForIngest(&mockMetric{}, nil, numSamplesReceived, nil))
 
 			headers := protobufHeaders
 			if len(c.customHeaders) != 0 {
@@ -273,12 +329,14 @@ func GenerateWriteHandleTester(t *testing.T, handleFunc http.Handler, headers ma
 
 type mockInserter struct {
 	ts     []prompb.TimeSeries
+	traces ptrace.Traces
 	result int64
 	err    error
 }
 
-func (m *mockInserter) IngestTraces(_ context.Context, _ ptrace.Traces) error {
-	panic("not implemented") // TODO: Implement
+func (m *mockInserter) IngestTraces(_ context.Context, tr ptrace.Traces) error {
+	m.traces = tr
+	return m.err
 }
 func (m *mockInserter) IngestMetrics(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
 	m.ts = r.Timeseries
@@ -379,3 +437,62 @@ func applyValueIfMetricNotNil(metric *mockMetric, value float64) {
 	}
 	metric.value = value
 }
+
+func TestGetAckMode(t *testing.T) {
+	tenantAckModes, err := parseTenantAckModes("tenant-a=async, tenant-b=sync,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]ingestor.AckMode{"tenant-a": ingestor.AckModeAsync, "tenant-b": ingestor.AckModeSync}, tenantAckModes)
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		expMode ingestor.AckMode
+		expErr  bool
+	}{
+		{
+			name:    "no headers",
+			expMode: ingestor.AckModeDefault,
+		},
+		{
+			name:    "tenant with ack mode",
+			headers: map[string]string{"TENANT": "tenant-a"},
+			expMode: ingestor.AckModeAsync,
+		},
+		{
+			name:    "tenant without ack mode",
+			headers: map[string]string{"TENANT": "tenant-c"},
+			expMode: ingestor.AckModeDefault,
+		},
+		{
+			name:    "header overrides tenant",
+			headers: map[string]string{"TENANT": "tenant-a", "ACK-MODE": "sync"},
+			expMode: ingestor.AckModeSync,
+		},
+		{
+			name:    "invalid header",
+			headers: map[string]string{"ACK-MODE": "sometimes"},
+			expErr:  true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/write", nil)
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			mode, err := getAckMode(r, tenantAckModes)
+			if c.expErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expMode, mode)
+		})
+	}
+
+	_, err = parseTenantAckModes("tenant-a")
+	require.Error(t, err)
+	_, err = parseTenantAckModes("tenant-a=later")
+	require.Error(t, err)
+}
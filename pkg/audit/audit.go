@@ -0,0 +1,287 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package audit writes an audit record of every request to the query, delete
+// and admin APIs, telling who touched which data, to a file, to syslog or to
+// the database. Unlike the query log, the audit log is meant for compliance:
+// it records every request of the audited endpoints, failed and denied ones
+// included.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// Records waiting to be written to the database. Records logged while
+	// the buffer is full are dropped.
+	bufferSize = 1000
+	batchSize  = 100
+
+	insertSQL = `INSERT INTO _ps_catalog.audit_log (time, identity, remote_addr, tenant, endpoint, category, method,
+expr, matchers, metrics, rows, duration_seconds, status, outcome)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+)
+
+// The outcomes of the audited requests.
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+var (
+	recordsLogged = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "audit_log",
+			Name:      "records_total",
+			Help:      "Total number of audit log records written, by destination.",
+		}, []string{"destination"},
+	)
+	recordsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "audit_log",
+			Name:      "dropped_records_total",
+			Help:      "Total number of audit log records that could not be written, by destination.",
+		}, []string{"destination"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(recordsLogged, recordsDropped)
+}
+
+// Record describes one request to an audited endpoint.
+type Record struct {
+	Time            time.Time `json:"time"`
+	Identity        string    `json:"identity"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	Tenant          string    `json:"tenant,omitempty"`
+	Endpoint        string    `json:"endpoint"`
+	Category        string    `json:"category"`
+	Method          string    `json:"method"`
+	Expr            string    `json:"expr,omitempty"`
+	Matchers        []string  `json:"matchers"`
+	Metrics         []string  `json:"metrics"`
+	Rows            int64     `json:"rows"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Status          int       `json:"status"`
+	Outcome         string    `json:"outcome"`
+}
+
+// Outcome returns the outcome of a request answered with the status code.
+func Outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= http.StatusBadRequest:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// Logger writes the audit records. A nil Logger does not audit anything.
+type Logger struct {
+	categories map[string]bool
+	endpoints  map[string]bool
+	tenants    map[string]bool
+
+	mu     sync.Mutex
+	file   io.WriteCloser
+	enc    *json.Encoder
+	syslog io.WriteCloser
+
+	records chan Record
+}
+
+// New returns a Logger writing to the destinations of the config, or nil if
+// the audit log is disabled. The records are only written to the database
+// while Run is running.
+func New(cfg Config) (*Logger, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	l := &Logger{}
+	for _, e := range splitList(cfg.Endpoints) {
+		switch e {
+		case CategoryQuery, CategoryDelete, CategoryAdmin:
+			if l.categories == nil {
+				l.categories = make(map[string]bool)
+			}
+			l.categories[e] = true
+		default:
+			if l.endpoints == nil {
+				l.endpoints = make(map[string]bool)
+			}
+			l.endpoints[e] = true
+		}
+	}
+	for _, t := range splitList(cfg.Tenants) {
+		if l.tenants == nil {
+			l.tenants = make(map[string]bool)
+		}
+		l.tenants[t] = true
+	}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log file: %w", err)
+		}
+		l.file = f
+		l.enc = json.NewEncoder(f)
+	}
+	if cfg.Syslog != "" {
+		w, err := newSyslogWriter(cfg.Syslog)
+		if err != nil {
+			if l.file != nil {
+				_ = l.file.Close()
+			}
+			return nil, fmt.Errorf("connecting to syslog: %w", err)
+		}
+		l.syslog = w
+	}
+	if cfg.Database {
+		l.records = make(chan Record, bufferSize)
+	}
+	return l, nil
+}
+
+// Audits tells if the requests to the endpoint of the category are audited.
+func (l *Logger) Audits(endpoint, category string) bool {
+	if l == nil {
+		return false
+	}
+	if l.categories == nil && l.endpoints == nil {
+		return true
+	}
+	return l.categories[category] || l.endpoints[endpoint]
+}
+
+// Log writes the record of a request, unless the tenant of the request is
+// not audited.
+func (l *Logger) Log(r Record) {
+	if l == nil || (l.tenants != nil && !l.tenants[r.Tenant]) {
+		return
+	}
+	if r.Matchers == nil {
+		r.Matchers = []string{}
+	}
+	if r.Metrics == nil {
+		r.Metrics = []string{}
+	}
+	if l.enc != nil || l.syslog != nil {
+		l.write(r)
+	}
+	if l.records != nil {
+		select {
+		case l.records <- r:
+		default:
+			recordsDropped.WithLabelValues("database").Inc()
+		}
+	}
+}
+
+func (l *Logger) write(r Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc != nil {
+		if err := l.enc.Encode(r); err != nil {
+			recordsDropped.WithLabelValues("file").Inc()
+			log.Warn("msg", "Writing to the audit log file failed", "err", err)
+		} else {
+			recordsLogged.WithLabelValues("file").Inc()
+		}
+	}
+	if l.syslog != nil {
+		b, err := json.Marshal(r)
+		if err == nil {
+			_, err = l.syslog.Write(b)
+		}
+		if err != nil {
+			recordsDropped.WithLabelValues("syslog").Inc()
+			log.Warn("msg", "Sending the audit record to syslog failed", "err", err)
+		} else {
+			recordsLogged.WithLabelValues("syslog").Inc()
+		}
+	}
+}
+
+// Run writes the records to the database in batches until the context is
+// done.
+func (l *Logger) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if l == nil || l.records == nil {
+		return
+	}
+	batch := make([]Record, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-l.records:
+			batch = append(batch[:0], r)
+		}
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case r := <-l.records:
+				batch = append(batch, r)
+			default:
+				break fill
+			}
+		}
+		l.insert(ctx, conn, batch)
+	}
+}
+
+func (l *Logger) insert(ctx context.Context, conn pgxconn.PgxConn, records []Record) {
+	batch := conn.NewBatch()
+	for _, r := range records {
+		batch.Queue(insertSQL, r.Time, r.Identity, r.RemoteAddr, r.Tenant, r.Endpoint, r.Category, r.Method,
+			r.Expr, r.Matchers, r.Metrics, r.Rows, r.DurationSeconds, r.Status, r.Outcome)
+	}
+	results, err := conn.SendBatch(ctx, batch)
+	if err == nil {
+		err = results.Close()
+	}
+	if err != nil {
+		recordsDropped.WithLabelValues("database").Add(float64(len(records)))
+		log.Warn("msg", "Writing the audit records to the database failed", "err", err)
+		return
+	}
+	recordsLogged.WithLabelValues("database").Add(float64(len(records)))
+}
+
+// Close closes the audit log file and the syslog connection.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+	}
+	if l.syslog != nil {
+		if sErr := l.syslog.Close(); err == nil {
+			err = sErr
+		}
+	}
+	return err
+}
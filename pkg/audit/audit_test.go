@@ -0,0 +1,68 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{Syslog: "local", Endpoints: "query, /api/v1/series"}))
+	require.NoError(t, Validate(&Config{Syslog: "tcp://syslog:514"}))
+	require.Error(t, Validate(&Config{Syslog: "syslog:514"}))
+	require.Error(t, Validate(&Config{Syslog: "http://syslog:514"}))
+	require.Error(t, Validate(&Config{Endpoints: "queries"}))
+
+	l, err := New(Config{Endpoints: "query"})
+	require.NoError(t, err)
+	require.Nil(t, l)
+	// A nil Logger does not audit anything.
+	require.False(t, l.Audits("/api/v1/query", CategoryQuery))
+	l.Log(Record{})
+	require.NoError(t, l.Close())
+}
+
+func TestOutcome(t *testing.T) {
+	require.Equal(t, OutcomeSuccess, Outcome(http.StatusNoContent))
+	require.Equal(t, OutcomeDenied, Outcome(http.StatusUnauthorized))
+	require.Equal(t, OutcomeDenied, Outcome(http.StatusForbidden))
+	require.Equal(t, OutcomeFailure, Outcome(http.StatusUnprocessableEntity))
+}
+
+func TestLogFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{File: file, Endpoints: "delete,/api/v1/series", Tenants: "tenant-a"})
+	require.NoError(t, err)
+
+	require.True(t, l.Audits("/api/v1/admin/tsdb/delete_series", CategoryDelete))
+	require.True(t, l.Audits("/api/v1/series", CategoryQuery))
+	require.False(t, l.Audits("/api/v1/query", CategoryQuery))
+
+	l.Log(Record{Endpoint: "/api/v1/series", Tenant: "tenant-a", Identity: "grafana", Status: http.StatusOK, Outcome: OutcomeSuccess})
+	l.Log(Record{Endpoint: "/api/v1/series", Tenant: "tenant-b"})
+	require.NoError(t, l.Close())
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	// The audit records are only readable by the connector user.
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, "grafana", record.Identity)
+	require.Equal(t, []string{}, record.Matchers)
+	require.Equal(t, []string{}, record.Metrics)
+}
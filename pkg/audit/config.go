@@ -0,0 +1,78 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package audit
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The categories of the audited endpoints.
+const (
+	CategoryQuery  = "query"
+	CategoryDelete = "delete"
+	CategoryAdmin  = "admin"
+)
+
+// Config holds the audit log flags.
+type Config struct {
+	File      string
+	Syslog    string
+	Database  bool
+	Endpoints string
+	Tenants   string
+}
+
+// ParseFlags registers the audit log flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.File, "web.audit-log.file", "", "File to which a JSON audit record is appended for every request to the query, delete and admin APIs, "+
+		"with the identity of the client, the endpoint, the matchers and metrics touched, the rows returned, the duration and the outcome. "+
+		"Empty disables the file audit log.")
+	fs.StringVar(&cfg.Syslog, "web.audit-log.syslog", "", "Send the audit records to syslog: 'local' for the local syslog daemon, "+
+		"or 'udp://host:port' or 'tcp://host:port' for a remote one. Empty disables the syslog audit log.")
+	fs.BoolVar(&cfg.Database, "web.audit-log.database", false, "Store the audit records in the _ps_catalog.audit_log table. "+
+		"Records are written in batches in the background, and dropped if the database cannot keep up.")
+	fs.StringVar(&cfg.Endpoints, "web.audit-log.endpoints", "", "Comma-separated list of the endpoint categories (query, delete, admin) "+
+		"or endpoint paths (e.g. /api/v1/query) to audit. Empty audits all of them.")
+	fs.StringVar(&cfg.Tenants, "web.audit-log.tenants", "", "Comma-separated list of the tenants whose requests are audited. Empty audits all the requests.")
+	return cfg
+}
+
+// Validate checks the audit log flags.
+func Validate(cfg *Config) error {
+	if cfg.Syslog != "" && cfg.Syslog != "local" {
+		u, err := url.Parse(cfg.Syslog)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return fmt.Errorf("web.audit-log.syslog must be 'local', 'udp://host:port' or 'tcp://host:port', got %q", cfg.Syslog)
+		}
+	}
+	for _, e := range splitList(cfg.Endpoints) {
+		switch {
+		case e == CategoryQuery || e == CategoryDelete || e == CategoryAdmin:
+		case strings.HasPrefix(e, "/"):
+		default:
+			return fmt.Errorf("web.audit-log.endpoints: %q is neither an endpoint category (query, delete, admin) nor an endpoint path", e)
+		}
+	}
+	return nil
+}
+
+// Enabled returns true if the requests are audited anywhere.
+func (cfg Config) Enabled() bool {
+	return cfg.File != "" || cfg.Syslog != "" || cfg.Database
+}
+
+// splitList splits a comma-separated list, ignoring the empty elements.
+func splitList(s string) []string {
+	var res []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
@@ -0,0 +1,31 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package audit
+
+import (
+	"io"
+	"log/syslog"
+	"net/url"
+)
+
+const syslogTag = "promscale-audit"
+
+// newSyslogWriter connects to the local syslog daemon, or to the remote one
+// of the udp:// or tcp:// URL. The records are sent with the authpriv
+// facility, meant for security messages.
+func newSyslogWriter(target string) (io.WriteCloser, error) {
+	var network, addr string
+	if target != "local" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, syslogTag)
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package audit
+
+import (
+	"fmt"
+	"io"
+)
+
+func newSyslogWriter(string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
@@ -5,14 +5,19 @@
 package auth
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
 
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/tenancy"
 )
 
 var (
@@ -21,6 +26,10 @@ var (
 	noPasswordFlagsSetError       = fmt.Errorf("one of basic-auth-password & basic-auth-password-file must be configured")
 	multiplePasswordFlagsSetError = fmt.Errorf("at most one of basic-auth-password & basic-auth-password-file must be configured")
 	multipleTokenFlagsSetError    = fmt.Errorf("at most one of bearer-token & bearer-token-file must be set")
+	oidcAndTokenFlagsSetError     = fmt.Errorf("web.auth.oidc.issuer-url cannot be set with the basic auth and bearer token flags")
+
+	errInvalidCredentials = fmt.Errorf("Unauthorized access to endpoint, invalid username or password")
+	errInvalidBearerToken = fmt.Errorf("Unauthorized access to endpoint, invalid bearer token")
 )
 
 type arrayOfIgnorePaths []string
@@ -33,7 +42,20 @@ type Config struct {
 	BearerToken     string
 	BearerTokenFile string
 
+	OIDCIssuerURL           string
+	OIDCAudience            string
+	OIDCIdentityClaim       string
+	OIDCKeysRefreshInterval time.Duration
+
+	// IdentityTenantsFile maps the identities of the clients, the OIDC
+	// identity claim or the common name of the TLS client certificate, to
+	// the tenant their writes are ingested in.
+	IdentityTenantsFile string
+
 	IgnorePaths arrayOfIgnorePaths
+
+	oidc            *oidcVerifier
+	identityTenants map[string]string
 }
 
 func (p *arrayOfIgnorePaths) String() string {
@@ -58,6 +80,29 @@ func readFromFile(path string, defaultValue string) (string, error) {
 }
 
 func (a *Config) Validate() error {
+	if a.OIDCIssuerURL != "" {
+		if a.BasicAuthUsername != "" || a.BasicAuthPassword != "" || a.BasicAuthPasswordFile != "" || a.BearerToken != "" || a.BearerTokenFile != "" {
+			return oidcAndTokenFlagsSetError
+		}
+		if a.OIDCIdentityClaim == "" {
+			return fmt.Errorf("web.auth.oidc.identity-claim cannot be empty")
+		}
+		if a.OIDCKeysRefreshInterval <= 0 {
+			return fmt.Errorf("web.auth.oidc.keys-refresh-interval must be positive, got %s", a.OIDCKeysRefreshInterval)
+		}
+		a.oidc = newOIDCVerifier(a.OIDCIssuerURL, a.OIDCAudience, a.OIDCIdentityClaim, a.OIDCKeysRefreshInterval)
+	}
+	if a.IdentityTenantsFile != "" {
+		bs, err := os.ReadFile(a.IdentityTenantsFile)
+		if err != nil {
+			return fmt.Errorf("error reading identity tenants file: %w", err)
+		}
+		a.identityTenants = make(map[string]string)
+		if err := yaml.UnmarshalStrict(bs, &a.identityTenants); err != nil {
+			return fmt.Errorf("error parsing identity tenants file: %w", err)
+		}
+	}
+
 	switch {
 	case a.BasicAuthUsername != "":
 		if a.BearerToken != "" || a.BearerTokenFile != "" {
@@ -105,6 +150,16 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.StringVar(&cfg.BasicAuthPasswordFile, "web.auth.password-file", "", "Path for auth password file containing the actual password used for web endpoint authentication. This flag should be set together with auth-username. It is mutually exclusive with auth-password and bearer-token methods.")
 	fs.StringVar(&cfg.BearerToken, "web.auth.bearer-token", "", "Bearer token (JWT) used for web endpoint authentication. Disabled by default. Mutually exclusive with bearer-token-file and basic auth methods.")
 	fs.StringVar(&cfg.BearerTokenFile, "web.auth.bearer-token-file", "", "Path of the file containing the bearer token (JWT) used for web endpoint authentication. Disabled by default. Mutually exclusive with bearer-token and basic auth methods.")
+	fs.StringVar(&cfg.OIDCIssuerURL, "web.auth.oidc.issuer-url", "", "URL of the OpenID Connect issuer whose tokens authenticate the requests to the web endpoints, "+
+		"the OTLP gRPC endpoints and the gRPC write service. The tokens are sent as bearer tokens and verified with the signing keys discovered from the issuer. "+
+		"Disabled by default. Mutually exclusive with basic auth and bearer token methods.")
+	fs.StringVar(&cfg.OIDCAudience, "web.auth.oidc.audience", "", "Audience the OIDC tokens must be issued for. Any audience is accepted if empty.")
+	fs.StringVar(&cfg.OIDCIdentityClaim, "web.auth.oidc.identity-claim", "sub", "Claim of the OIDC tokens holding the identity of the client, used for the tenant mapping of web.auth.identity-tenants-file.")
+	fs.DurationVar(&cfg.OIDCKeysRefreshInterval, "web.auth.oidc.keys-refresh-interval", time.Hour, "How often the signing keys of the OIDC issuer are fetched again. "+
+		"They are also fetched again when a token is signed with an unknown key.")
+	fs.StringVar(&cfg.IdentityTenantsFile, "web.auth.identity-tenants-file", "", "Path of a YAML file mapping the identities of the clients to tenants, e.g. `prometheus-eu: eu`. "+
+		"The identity is the OIDC identity claim or the common name of the verified TLS client certificate. "+
+		"The writes of a mapped identity are ingested in its tenant, whatever tenant they carry.")
 	fs.Var(&cfg.IgnorePaths, "web.auth.ignore-path", "HTTP paths which has to be skipped from authentication. This flag shall be repeated and each one would be appended to the ignore list.")
 	return cfg
 }
@@ -123,38 +178,109 @@ func (cfg *Config) isIgnoredPath(r *http.Request) bool {
 	return false
 }
 
-func (cfg *Config) AuthHandler(handler http.Handler) http.Handler {
-	if cfg.BasicAuthUsername != "" {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cfg.isIgnoredPath(r) {
-				handler.ServeHTTP(w, r)
-				return
-			}
-			user, pass, ok := r.BasicAuth()
-			if !ok || cfg.BasicAuthUsername != user || cfg.BasicAuthPassword != pass {
-				log.Error("msg", "Unauthorized access to endpoint, invalid username or password")
-				http.Error(w, "Unauthorized access to endpoint, invalid username or password.", http.StatusUnauthorized)
-				return
-			}
-			handler.ServeHTTP(w, r)
-		})
+// Authorize returns an error if r does not carry the configured credentials.
+// The requests of the ignored paths and all the requests when authentication
+// is disabled are authorized. The tenant header of the requests of the
+// identities mapped to a tenant is set to their tenant.
+func (cfg *Config) Authorize(r *http.Request) error {
+	_, err := cfg.authenticate(r)
+	return err
+}
+
+// authenticate authorizes r like Authorize and returns the identity of the
+// client: the OIDC identity claim, the common name of the TLS client
+// certificate or the basic auth username, empty if it is unknown.
+func (cfg *Config) authenticate(r *http.Request) (string, error) {
+	if cfg.isIgnoredPath(r) {
+		return clientCertIdentity(r), nil
 	}
+	var identity, user string
+	switch {
+	case cfg.BasicAuthUsername != "":
+		var (
+			pass string
+			ok   bool
+		)
+		user, pass, ok = r.BasicAuth()
+		if !ok || cfg.BasicAuthUsername != user || cfg.BasicAuthPassword != pass {
+			return "", errInvalidCredentials
+		}
+	case cfg.BearerToken != "":
+		splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
+		if len(splitToken) < 2 || cfg.BearerToken != splitToken[1] {
+			return "", errInvalidBearerToken
+		}
+	case cfg.oidc != nil:
+		splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
+		if len(splitToken) < 2 || splitToken[1] == "" {
+			return "", errMissingToken
+		}
+		id, err := cfg.oidc.verify(splitToken[1], time.Now())
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", errInvalidToken, err)
+		}
+		identity = id
+	}
+	if identity == "" {
+		identity = clientCertIdentity(r)
+	}
+	if tenant, ok := cfg.identityTenants[identity]; ok && identity != "" {
+		r.Header.Set(tenancy.TenantHeader, tenant)
+	}
+	if identity == "" {
+		// The username shared by all the clients is not mapped to a tenant.
+		identity = user
+	}
+	return identity, nil
+}
 
-	if cfg.BearerToken != "" {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cfg.isIgnoredPath(r) {
-				handler.ServeHTTP(w, r)
-				return
-			}
-			splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
-			if len(splitToken) < 2 || cfg.BearerToken != splitToken[1] {
-				log.Error("msg", "Unauthorized access to endpoint, invalid bearer token")
-				http.Error(w, "Unauthorized access to endpoint, invalid bearer token", http.StatusUnauthorized)
-				return
-			}
-			handler.ServeHTTP(w, r)
-		})
+type identityKey struct{}
+
+// WithIdentity returns a context in which AuthHandler records the identity of
+// the client, so that the middlewares running before the authentication can
+// read it once the request is served.
+func WithIdentity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, identityKey{}, new(string))
+}
+
+// IdentityFromContext returns the identity of the client of the request
+// authenticated by AuthHandler, empty if it is unknown.
+func IdentityFromContext(ctx context.Context) string {
+	if identity, ok := ctx.Value(identityKey{}).(*string); ok {
+		return *identity
+	}
+	return ""
+}
+
+// clientCertIdentity returns the common name of the verified TLS client
+// certificate of r, empty if there is none.
+func clientCertIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
 	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
 
-	return handler
+// OIDCEnabled tells if the requests are authenticated with OIDC tokens.
+func (cfg *Config) OIDCEnabled() bool {
+	return cfg.oidc != nil
+}
+
+// AuthHandler authorizes the requests served by handler, and stores the
+// identity of their client in their context.
+func (cfg *Config) AuthHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := cfg.authenticate(r)
+		if err != nil {
+			log.Error("msg", err.Error())
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if p, ok := r.Context().Value(identityKey{}).(*string); ok {
+			*p = identity
+		} else if identity != "" {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, &identity))
+		}
+		handler.ServeHTTP(w, r)
+	})
 }
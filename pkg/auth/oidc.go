@@ -0,0 +1,334 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is the leeway of the expiry and not-before times of the tokens.
+	clockSkew = time.Minute
+	// minKeysRefreshInterval bounds how often the keys are fetched again for
+	// the tokens signed with an unknown key.
+	minKeysRefreshInterval = 10 * time.Second
+)
+
+var (
+	errMissingToken = fmt.Errorf("Unauthorized access to endpoint, missing bearer token")
+	errInvalidToken = fmt.Errorf("Unauthorized access to endpoint, invalid OIDC token")
+)
+
+// oidcVerifier verifies the ID and access tokens of an OpenID Connect
+// issuer. The signing keys are discovered from the issuer on first use and
+// fetched again every refresh interval, and when a token is signed with an
+// unknown key, so that the keys rotated by the issuer are picked up.
+type oidcVerifier struct {
+	issuer          string
+	audience        string
+	identityClaim   string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mux     sync.Mutex
+	jwksURI string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(issuer, audience, identityClaim string, refreshInterval time.Duration) *oidcVerifier {
+	return &oidcVerifier{
+		issuer:          strings.TrimSuffix(issuer, "/"),
+		audience:        audience,
+		identityClaim:   identityClaim,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify verifies the signature and the claims of the token at now, and
+// returns the identity of the token, the value of its identity claim.
+func (v *oidcVerifier) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("invalid token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid token signature: %w", err)
+	}
+	key, err := v.key(header.Kid, now)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return "", err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("invalid token claims: %w", err)
+	}
+	return v.checkClaims(claims, now)
+}
+
+func (v *oidcVerifier) checkClaims(claims map[string]interface{}, now time.Time) (string, error) {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return "", fmt.Errorf("token issued by %q, not %q", iss, v.issuer)
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return "", fmt.Errorf("token not issued for the audience %q", v.audience)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", fmt.Errorf("token without expiry time")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return "", fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return "", fmt.Errorf("token not valid yet")
+	}
+	identity, _ := claims[v.identityClaim].(string)
+	if identity == "" {
+		return "", fmt.Errorf("token without %s claim", v.identityClaim)
+	}
+	return identity, nil
+}
+
+// hasAudience tells if the aud claim, a string or an array of strings,
+// contains audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	if len(alg) == 5 {
+		switch alg[2:] {
+		case "256":
+			hash = crypto.SHA256
+		case "384":
+			hash = crypto.SHA384
+		case "512":
+			hash = crypto.SHA512
+		}
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported token signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(signature) != 2*size {
+				return fmt.Errorf("invalid token signature length")
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if !ecdsa.Verify(key, digest, r, s) {
+				return fmt.Errorf("invalid token signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("token signing algorithm %q does not match its key", alg)
+}
+
+// key returns the signing key with the key ID kid, the only key if kid is
+// empty.
+func (v *oidcVerifier) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	lookup := func() (crypto.PublicKey, bool) {
+		if kid == "" && len(v.keys) == 1 {
+			for _, k := range v.keys {
+				return k, true
+			}
+		}
+		k, ok := v.keys[kid]
+		return k, ok
+	}
+	stale := now.Sub(v.fetched) >= v.refreshInterval
+	if k, ok := lookup(); ok && !stale {
+		return k, nil
+	}
+	if !stale && now.Sub(v.fetched) < minKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown token signing key %q", kid)
+	}
+	if err := v.refreshKeys(now); err != nil {
+		// The previous keys stay valid while the issuer is unreachable.
+		if k, ok := lookup(); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("fetching the token signing keys: %w", err)
+	}
+	if k, ok := lookup(); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown token signing key %q", kid)
+}
+
+// refreshKeys fetches the keys of the issuer. The JWKS URI is discovered
+// from the issuer configuration the first time.
+func (v *oidcVerifier) refreshKeys(now time.Time) error {
+	// The keys are not fetched again before minKeysRefreshInterval, even if
+	// fetching them failed.
+	v.fetched = now
+	if v.jwksURI == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("discovering the OIDC issuer configuration: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("the OIDC issuer configuration has no jwks_uri")
+		}
+		v.jwksURI = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(v.jwksURI, &jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) getJSON(url string, res interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,235 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+// testIssuer is an OIDC issuer serving the public keys of its signing keys.
+type testIssuer struct {
+	*httptest.Server
+	mux  sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{keys: make(map[string]crypto.Signer)}
+	iss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+		case "/keys":
+			iss.mux.Lock()
+			defer iss.mux.Unlock()
+			var keys []jsonWebKey
+			for kid, k := range iss.keys {
+				switch pub := k.Public().(type) {
+				case *rsa.PublicKey:
+					keys = append(keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())})
+				case *ecdsa.PublicKey:
+					keys = append(keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256", X: b64(pub.X.Bytes()), Y: b64(pub.Y.Bytes())})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) addKey(t *testing.T, kid string, ec bool) {
+	var (
+		k   crypto.Signer
+		err error
+	)
+	if ec {
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		k, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	require.NoError(t, err)
+	iss.mux.Lock()
+	iss.keys[kid] = k
+	iss.mux.Unlock()
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// token returns the token of the claims signed with the key kid.
+func (iss *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	iss.mux.Lock()
+	key := iss.keys[kid]
+	iss.mux.Unlock()
+
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.addKey(t, "rsa", false)
+	iss.addKey(t, "ec", true)
+	v := newOIDCVerifier(iss.URL+"/", "promscale", "sub", time.Hour)
+
+	now := time.Now()
+	claims := func(mod func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": iss.URL,
+			"aud": []string{"other", "promscale"},
+			"sub": "prometheus-eu",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+
+	testCases := []struct {
+		name     string
+		token    string
+		identity string
+		err      string
+	}{
+		{name: "RSA key", token: iss.token(t, "rsa", claims(nil)), identity: "prometheus-eu"},
+		{name: "EC key", token: iss.token(t, "ec", claims(nil)), identity: "prometheus-eu"},
+		{name: "audience string", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["aud"] = "promscale" })), identity: "prometheus-eu"},
+		{name: "wrong audience", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["aud"] = "other" })), err: `token not issued for the audience "promscale"`},
+		{name: "wrong issuer", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["iss"] = "https://example.com" })), err: "token issued by"},
+		{name: "expired", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() })), err: "token expired"},
+		{name: "not valid yet", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })), err: "token not valid yet"},
+		{name: "no identity", token: iss.token(t, "rsa", claims(func(c map[string]interface{}) { delete(c, "sub") })), err: "token without sub claim"},
+		{name: "malformed", token: "foo.bar", err: "malformed token"},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			identity, err := v.verify(c.token, now)
+			if c.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.identity, identity)
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		// The claims of another token with the signature of the first.
+		parts := strings.Split(iss.token(t, "rsa", claims(nil)), ".")
+		other := strings.Split(iss.token(t, "rsa", claims(func(c map[string]interface{}) { c["sub"] = "admin" })), ".")
+		_, err := v.verify(parts[0]+"."+other[1]+"."+parts[2], now)
+		require.Error(t, err)
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		iss.addKey(t, "rotated", false)
+		token := iss.token(t, "rotated", claims(nil))
+		// The keys were fetched less than minKeysRefreshInterval ago.
+		_, err := v.verify(token, now)
+		require.Error(t, err)
+		identity, err := v.verify(token, now.Add(minKeysRefreshInterval))
+		require.NoError(t, err)
+		require.Equal(t, "prometheus-eu", identity)
+	})
+}
+
+func TestAuthorizeIdentityTenants(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.addKey(t, "rsa", false)
+
+	tenantsFile := filepath.Join(t.TempDir(), "tenants.yaml")
+	require.NoError(t, os.WriteFile(tenantsFile, []byte("prometheus-eu: eu\nclient.example.com: us\n"), 0600))
+
+	cfg := &Config{
+		OIDCIssuerURL:           iss.URL,
+		OIDCIdentityClaim:       "sub",
+		OIDCKeysRefreshInterval: time.Hour,
+		IdentityTenantsFile:     tenantsFile,
+	}
+	require.NoError(t, cfg.Validate())
+	require.True(t, cfg.OIDCEnabled())
+
+	token := func(sub string) string {
+		return iss.token(t, "rsa", map[string]interface{}{"iss": iss.URL, "sub": sub, "exp": time.Now().Add(time.Hour).Unix()})
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/write", nil)
+	require.ErrorIs(t, cfg.Authorize(r), errMissingToken)
+
+	r.Header.Set("Authorization", "Bearer "+token("prometheus-eu"))
+	r.Header.Set(tenancy.TenantHeader, "spoofed")
+	require.NoError(t, cfg.Authorize(r))
+	require.Equal(t, "eu", r.Header.Get(tenancy.TenantHeader))
+
+	r = httptest.NewRequest(http.MethodPost, "/write", nil)
+	r.Header.Set("Authorization", "Bearer "+token("unmapped"))
+	r.Header.Set(tenancy.TenantHeader, "own")
+	require.NoError(t, cfg.Authorize(r))
+	require.Equal(t, "own", r.Header.Get(tenancy.TenantHeader))
+
+	r.Header.Set("Authorization", "Bearer "+token("prometheus-eu")+"x")
+	require.ErrorIs(t, cfg.Authorize(r), errInvalidToken)
+
+	// Without OIDC, the identity is the common name of the client certificate.
+	cfg = &Config{IdentityTenantsFile: tenantsFile}
+	require.NoError(t, cfg.Validate())
+	r = httptest.NewRequest(http.MethodPost, "/write", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "client.example.com"}}}}}
+	require.NoError(t, cfg.Authorize(r))
+	require.Equal(t, "us", r.Header.Get(tenancy.TenantHeader))
+
+	require.ErrorIs(t, (&Config{OIDCIssuerURL: iss.URL, BearerToken: "foo"}).Validate(), oidcAndTokenFlagsSetError)
+}
@@ -0,0 +1,303 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package backfill bulk-loads OpenMetrics files and Prometheus TSDB blocks
+// into the database.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// Ingester writes the samples to the database. It is implemented by the
+// pgclient.Client.
+type Ingester interface {
+	IngestMetrics(ctx context.Context, r *prompb.WriteRequest) (uint64, uint64, error)
+}
+
+// Stats summarizes a backfill.
+type Stats struct {
+	Samples  uint64
+	Duration time.Duration
+}
+
+// Run backfills the inputs of the configuration. The inputs are read one
+// window at a time in time order, so that the chunks of each metric are
+// written one after the other instead of all at once. The samples of a
+// series are sorted by time within each batch, and duplicate timestamps keep
+// the last value read. Samples older than compressed chunks are handled by
+// the ingestor like any other late sample.
+func Run(ctx context.Context, cfg Config, ing Ingester) (Stats, error) {
+	var stats Stats
+	paths := cfg.Paths()
+	if len(paths) == 0 {
+		return stats, fmt.Errorf("no input to backfill, set -backfill.input")
+	}
+	sources, err := openSources(paths, cfg.Format)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		for _, s := range sources {
+			if err := s.close(); err != nil {
+				log.Warn("msg", "failed to close backfill input", "input", s.name(), "err", err)
+			}
+		}
+	}()
+
+	mint, maxt, ok := overallRange(sources)
+	if !ok {
+		log.Info("msg", "Nothing to backfill, the inputs hold no samples")
+		return stats, nil
+	}
+	log.Info("msg", "Starting backfill", "inputs", len(sources), "mint", timestamp.Time(mint), "maxt", timestamp.Time(maxt))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := newWriter(ctx, cancel, ing, cfg.Parallelism)
+	p := newProgress(mint, maxt)
+	stopProgress := p.report(cfg.ProgressInterval, w.samples)
+
+	b := newBatch(cfg.BatchSize)
+	add := func(lset labels.Labels, t int64, v float64) error {
+		if b.add(lset, t, v) {
+			return w.send(ctx, b.flush())
+		}
+		return nil
+	}
+	window := cfg.Window.Milliseconds()
+	for start := mint; start <= maxt; start += window {
+		end := start + window - 1
+		if end > maxt || end < start {
+			end = maxt
+		}
+		for _, s := range sources {
+			smin, smax := s.timeRange()
+			if smax < start || smin > end {
+				continue
+			}
+			if err = s.read(ctx, start, end, add); err != nil {
+				err = fmt.Errorf("reading %s: %w", s.name(), err)
+				break
+			}
+		}
+		if err == nil {
+			err = w.send(ctx, b.flush())
+		}
+		if err != nil || end == maxt {
+			break
+		}
+		p.windowDone(end)
+	}
+	werr := w.wait()
+	stopProgress()
+	if err == nil {
+		err = werr
+	}
+
+	stats = Stats{Samples: w.samples.Load(), Duration: time.Since(p.started)}
+	if err != nil {
+		return stats, fmt.Errorf("backfill failed after %d samples: %w", stats.Samples, err)
+	}
+	log.Info("msg", "Backfill done", "samples", stats.Samples, "duration", stats.Duration.Round(time.Second),
+		"samples/sec", int(float64(stats.Samples)/stats.Duration.Seconds()))
+	return stats, nil
+}
+
+// overallRange returns the time range of all the sources, and false if they
+// hold no samples.
+func overallRange(sources []source) (mint, maxt int64, ok bool) {
+	for _, s := range sources {
+		smin, smax := s.timeRange()
+		if smin > smax {
+			continue
+		}
+		if !ok || smin < mint {
+			mint = smin
+		}
+		if !ok || smax > maxt {
+			maxt = smax
+		}
+		ok = true
+	}
+	return mint, maxt, ok
+}
+
+// batch accumulates the samples of a write request.
+type batch struct {
+	size    int
+	samples int
+	series  map[string]*prompb.TimeSeries
+	buf     []byte
+}
+
+func newBatch(size int) *batch {
+	return &batch{size: size, series: make(map[string]*prompb.TimeSeries)}
+}
+
+// add adds a sample and returns true if the batch is full.
+func (b *batch) add(lset labels.Labels, t int64, v float64) bool {
+	b.buf = lset.Bytes(b.buf[:0])
+	ts, ok := b.series[string(b.buf)]
+	if !ok {
+		ts = &prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(lset))}
+		for _, l := range lset {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		b.series[string(b.buf)] = ts
+	}
+	ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+	b.samples++
+	return b.samples >= b.size
+}
+
+// flush returns the write request of the batch and empties it. The samples of
+// each series are sorted by time and deduplicated.
+func (b *batch) flush() *prompb.WriteRequest {
+	if b.samples == 0 {
+		return nil
+	}
+	wr := ingestor.NewWriteRequest()
+	for _, ts := range b.series {
+		ts.Samples = sortSamples(ts.Samples)
+		wr.Timeseries = append(wr.Timeseries, *ts)
+	}
+	b.series = make(map[string]*prompb.TimeSeries, len(b.series))
+	b.samples = 0
+	return wr
+}
+
+// sortSamples sorts the samples by time and keeps the last one read of the
+// samples with the same timestamp.
+func sortSamples(samples []prompb.Sample) []prompb.Sample {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+	deduped := samples[:0]
+	for _, s := range samples {
+		if n := len(deduped); n > 0 && deduped[n-1].Timestamp == s.Timestamp {
+			deduped[n-1] = s
+			continue
+		}
+		deduped = append(deduped, s)
+	}
+	return deduped
+}
+
+// writer ingests the write requests with a pool of workers.
+type writer struct {
+	requests chan *prompb.WriteRequest
+	wg       sync.WaitGroup
+	samples  *atomic.Uint64
+
+	errOnce sync.Once
+	err     error
+}
+
+func newWriter(ctx context.Context, cancel context.CancelFunc, ing Ingester, parallelism int) *writer {
+	w := &writer{
+		requests: make(chan *prompb.WriteRequest, parallelism),
+		samples:  atomic.NewUint64(0),
+	}
+	w.wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer w.wg.Done()
+			for wr := range w.requests {
+				n, _, err := ing.IngestMetrics(ctx, wr)
+				if err != nil {
+					w.errOnce.Do(func() {
+						w.err = err
+						cancel()
+					})
+					continue
+				}
+				w.samples.Add(n)
+			}
+		}()
+	}
+	return w
+}
+
+func (w *writer) send(ctx context.Context, wr *prompb.WriteRequest) error {
+	if wr == nil {
+		return nil
+	}
+	select {
+	case w.requests <- wr:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait waits for the requests sent to be ingested and returns the first
+// ingestion error.
+func (w *writer) wait() error {
+	close(w.requests)
+	w.wg.Wait()
+	return w.err
+}
+
+// progress logs the progress of the backfill.
+type progress struct {
+	started    time.Time
+	mint, maxt int64
+	done       *atomic.Int64
+}
+
+func newProgress(mint, maxt int64) *progress {
+	return &progress{started: time.Now(), mint: mint, maxt: maxt, done: atomic.NewInt64(mint)}
+}
+
+func (p *progress) windowDone(end int64) {
+	p.done.Store(end)
+}
+
+// percent returns the share of the time range that was backfilled.
+func (p *progress) percent() float64 {
+	if p.maxt == p.mint {
+		return 0
+	}
+	return 100 * float64(p.done.Load()-p.mint) / float64(p.maxt-p.mint)
+}
+
+// report logs the progress every interval until the returned function is
+// called.
+func (p *progress) report(interval time.Duration, samples *atomic.Uint64) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		last, lastTime := uint64(0), p.started
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n := samples.Load()
+				log.Info("msg", "Backfill progress", "samples", n,
+					"backfilled-until", timestamp.Time(p.done.Load()),
+					"percent", fmt.Sprintf("%.1f", p.percent()),
+					"samples/sec", int(float64(n-last)/now.Sub(lastTime).Seconds()))
+				last, lastTime = n, now
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,142 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+type mockIngester struct {
+	mu       sync.Mutex
+	requests []prompb.WriteRequest
+	err      error
+}
+
+func (m *mockIngester) IngestMetrics(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, 0, m.err
+	}
+	n := 0
+	wr := prompb.WriteRequest{}
+	for _, ts := range r.Timeseries {
+		n += len(ts.Samples)
+		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+			Labels:  append([]prompb.Label{}, ts.Labels...),
+			Samples: append([]prompb.Sample{}, ts.Samples...),
+		})
+	}
+	m.requests = append(m.requests, wr)
+	return uint64(n), 0, nil
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{Format: FormatAuto, BatchSize: 1, Parallelism: 1, Window: time.Hour, ProgressInterval: time.Second}
+	require.NoError(t, Validate(&cfg))
+
+	invalid := []func(c *Config){
+		func(c *Config) { c.Format = "csv" },
+		func(c *Config) { c.BatchSize = 0 },
+		func(c *Config) { c.Parallelism = 0 },
+		func(c *Config) { c.Window = 0 },
+		func(c *Config) { c.ProgressInterval = 0 },
+	}
+	for i, f := range invalid {
+		c := cfg
+		f(&c)
+		require.Error(t, Validate(&c), "case %d", i)
+	}
+
+	cfg.Input = " a.om, ,data "
+	require.Equal(t, []string{"a.om", "data"}, cfg.Paths())
+}
+
+func TestSortSamples(t *testing.T) {
+	samples := []prompb.Sample{{Timestamp: 3, Value: 3}, {Timestamp: 1, Value: 1}, {Timestamp: 3, Value: 4}, {Timestamp: 2, Value: 2}}
+	require.Equal(t, []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 4}}, sortSamples(samples))
+}
+
+func TestBatch(t *testing.T) {
+	b := newBatch(3)
+	require.Nil(t, b.flush())
+	require.False(t, b.add(labels.FromStrings("__name__", "a"), 2, 2))
+	require.False(t, b.add(labels.FromStrings("__name__", "b"), 1, 1))
+	require.True(t, b.add(labels.FromStrings("__name__", "a"), 1, 1))
+
+	wr := b.flush()
+	require.Len(t, wr.Timeseries, 2)
+	for _, ts := range wr.Timeseries {
+		if ts.Labels[0].Value == "a" {
+			require.Equal(t, []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}}, ts.Samples)
+		}
+	}
+	require.Nil(t, b.flush())
+}
+
+func TestRunOpenMetrics(t *testing.T) {
+	dir := t.TempDir()
+	// Samples out of time order, spanning three one hour windows.
+	content := `# TYPE up gauge
+up{job="a"} 1 7200
+up{job="a"} 0 0
+up{job="a"} 1 3600.5
+up{job="b"} 1 10
+# EOF
+`
+	path := filepath.Join(dir, "metrics.om")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	ing := &mockIngester{}
+	cfg := Config{Input: path, Format: FormatAuto, BatchSize: 100, Parallelism: 1, Window: time.Hour, ProgressInterval: time.Minute}
+	stats, err := Run(context.Background(), cfg, ing)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), stats.Samples)
+
+	// One request per window, in time order.
+	require.Len(t, ing.requests, 3)
+	var times []int64
+	for _, wr := range ing.requests {
+		for _, ts := range wr.Timeseries {
+			for _, s := range ts.Samples {
+				times = append(times, s.Timestamp)
+			}
+		}
+	}
+	require.Len(t, times, 4)
+	require.ElementsMatch(t, []int64{0, 10000}, times[:2])
+	require.Equal(t, []int64{3600500, 7200000}, times[2:])
+}
+
+func TestRunErrors(t *testing.T) {
+	cfg := Config{Format: FormatAuto, BatchSize: 1, Parallelism: 2, Window: time.Hour, ProgressInterval: time.Minute}
+	_, err := Run(context.Background(), cfg, &mockIngester{})
+	require.Error(t, err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.om")
+	require.NoError(t, os.WriteFile(path, []byte("up 1\n# EOF\n"), 0o600))
+	cfg.Input = path
+	_, err = Run(context.Background(), cfg, &mockIngester{})
+	require.Error(t, err, "samples without timestamp")
+
+	require.NoError(t, os.WriteFile(path, []byte("up 1 1\nup 2 2\n# EOF\n"), 0o600))
+	_, err = Run(context.Background(), cfg, &mockIngester{err: fmt.Errorf("db down")})
+	require.ErrorContains(t, err, "db down")
+
+	cfg.Format = FormatTSDB
+	_, err = Run(context.Background(), cfg, &mockIngester{})
+	require.Error(t, err, "file read as a TSDB block")
+}
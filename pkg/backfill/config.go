@@ -0,0 +1,84 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package backfill
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats of the backfilled data.
+const (
+	FormatAuto        = "auto"
+	FormatOpenMetrics = "openmetrics"
+	FormatTSDB        = "tsdb"
+)
+
+const (
+	defaultBatchSize        = 5000
+	defaultParallelism      = 4
+	defaultWindow           = 2 * time.Hour
+	defaultProgressInterval = 10 * time.Second
+)
+
+// Config holds the flags of the backfill mode.
+type Config struct {
+	Input            string
+	Format           string
+	BatchSize        int
+	Parallelism      int
+	Window           time.Duration
+	ProgressInterval time.Duration
+}
+
+// ParseFlags registers the backfill flags. They are only used by the
+// `promscale backfill` command.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Input, "backfill.input", "", "Comma-separated list of the OpenMetrics files and Prometheus TSDB block directories to backfill with `promscale backfill`. "+
+		"A directory holding blocks, like the Prometheus data directory, backfills all its blocks.")
+	fs.StringVar(&cfg.Format, "backfill.format", FormatAuto, "Format of the backfill inputs: openmetrics, tsdb or auto. "+
+		"With auto, directories are read as TSDB blocks and files as OpenMetrics.")
+	fs.IntVar(&cfg.BatchSize, "backfill.batch-size", defaultBatchSize, "Maximum number of samples sent to the database in a single batch while backfilling.")
+	fs.IntVar(&cfg.Parallelism, "backfill.parallelism", defaultParallelism, "Number of batches written to the database concurrently while backfilling.")
+	fs.DurationVar(&cfg.Window, "backfill.window", defaultWindow, "The data is backfilled in time order, one window of this duration at a time across all the inputs, "+
+		"so that the chunks of the metrics are written one after the other. OpenMetrics files are read once per window.")
+	fs.DurationVar(&cfg.ProgressInterval, "backfill.progress-interval", defaultProgressInterval, "How often the backfill progress and throughput are logged.")
+	return cfg
+}
+
+// Validate checks the backfill flags.
+func Validate(cfg *Config) error {
+	switch cfg.Format {
+	case FormatAuto, FormatOpenMetrics, FormatTSDB:
+	default:
+		return fmt.Errorf("backfill.format must be one of %s, %s or %s: %q", FormatAuto, FormatOpenMetrics, FormatTSDB, cfg.Format)
+	}
+	if cfg.BatchSize < 1 {
+		return fmt.Errorf("backfill.batch-size must be at least 1: %d", cfg.BatchSize)
+	}
+	if cfg.Parallelism < 1 {
+		return fmt.Errorf("backfill.parallelism must be at least 1: %d", cfg.Parallelism)
+	}
+	if cfg.Window <= 0 {
+		return fmt.Errorf("backfill.window must be positive: %s", cfg.Window)
+	}
+	if cfg.ProgressInterval <= 0 {
+		return fmt.Errorf("backfill.progress-interval must be positive: %s", cfg.ProgressInterval)
+	}
+	return nil
+}
+
+// Paths returns the paths of the backfill inputs.
+func (cfg *Config) Paths() []string {
+	var paths []string
+	for _, p := range strings.Split(cfg.Input, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,240 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// blockMetaFile is the file that identifies a TSDB block directory.
+const blockMetaFile = "meta.json"
+
+// sampleFunc receives the samples read from a source.
+type sampleFunc func(lset labels.Labels, t int64, v float64) error
+
+// source is a backfill input.
+type source interface {
+	name() string
+	// timeRange returns the inclusive time range of the samples.
+	timeRange() (mint, maxt int64)
+	// read calls fn with the samples between mint and maxt, inclusive.
+	read(ctx context.Context, mint, maxt int64, fn sampleFunc) error
+	close() error
+}
+
+// openSources opens the sources found at the paths, sorted by time range.
+func openSources(paths []string, format string) ([]source, error) {
+	var sources []source
+	closeAll := func() {
+		for _, s := range sources {
+			_ = s.close()
+		}
+	}
+	for _, path := range paths {
+		found, err := openPath(path, format)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("opening %s: %w", path, err)
+		}
+		sources = append(sources, found...)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		mi, _ := sources[i].timeRange()
+		mj, _ := sources[j].timeRange()
+		return mi < mj
+	})
+	return sources, nil
+}
+
+func openPath(path, format string) ([]source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if format == FormatTSDB {
+			return nil, fmt.Errorf("a TSDB block must be a directory")
+		}
+		s, err := openOpenMetrics(path)
+		if err != nil {
+			return nil, err
+		}
+		return []source{s}, nil
+	}
+	if format == FormatOpenMetrics {
+		return nil, fmt.Errorf("an OpenMetrics input must be a file")
+	}
+	if isBlockDir(path) {
+		s, err := openBlock(path)
+		if err != nil {
+			return nil, err
+		}
+		return []source{s}, nil
+	}
+
+	// A directory of blocks. Other directories, like the WAL of a Prometheus
+	// data directory, are skipped.
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var sources []source
+	for _, e := range entries {
+		dir := filepath.Join(path, e.Name())
+		if !e.IsDir() || !isBlockDir(dir) {
+			continue
+		}
+		s, err := openBlock(dir)
+		if err != nil {
+			for _, s := range sources {
+				_ = s.close()
+			}
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no TSDB block found")
+	}
+	return sources, nil
+}
+
+func isBlockDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, blockMetaFile))
+	return err == nil
+}
+
+type blockSource struct {
+	dir   string
+	block *tsdb.Block
+}
+
+func openBlock(dir string) (*blockSource, error) {
+	b, err := tsdb.OpenBlock(log.GetLogger(), dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &blockSource{dir: dir, block: b}, nil
+}
+
+func (s *blockSource) name() string { return s.dir }
+
+func (s *blockSource) timeRange() (int64, int64) {
+	meta := s.block.Meta()
+	// The block range is half-open.
+	return meta.MinTime, meta.MaxTime - 1
+}
+
+func (s *blockSource) read(ctx context.Context, mint, maxt int64, fn sampleFunc) error {
+	q, err := tsdb.NewBlockQuerier(s.block, mint, maxt)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, "", ".*"))
+	for set.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		series := set.At()
+		lset := series.Labels()
+		it := series.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < mint || t > maxt {
+				continue
+			}
+			if err := fn(lset, t, v); err != nil {
+				return err
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
+
+func (s *blockSource) close() error { return s.block.Close() }
+
+// openMetricsSource is an OpenMetrics file. The file is held in memory and
+// parsed once per window, since the samples are not ordered by time.
+type openMetricsSource struct {
+	path       string
+	data       []byte
+	mint, maxt int64
+}
+
+func openOpenMetrics(path string) (*openMetricsSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &openMetricsSource{path: path, data: data, mint: math.MaxInt64, maxt: math.MinInt64}
+	err = s.read(context.Background(), math.MinInt64, math.MaxInt64, func(_ labels.Labels, t int64, _ float64) error {
+		if t < s.mint {
+			s.mint = t
+		}
+		if t > s.maxt {
+			s.maxt = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *openMetricsSource) name() string { return s.path }
+
+func (s *openMetricsSource) timeRange() (int64, int64) { return s.mint, s.maxt }
+
+func (s *openMetricsSource) read(ctx context.Context, mint, maxt int64, fn sampleFunc) error {
+	p := textparse.NewOpenMetricsParser(s.data)
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parsing OpenMetrics: %w", err)
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+		_, ts, v := p.Series()
+		if ts == nil {
+			var lset labels.Labels
+			p.Metric(&lset)
+			return fmt.Errorf("sample of %s has no timestamp", lset)
+		}
+		if *ts < mint || *ts > maxt {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var lset labels.Labels
+		p.Metric(&lset)
+		if err := fn(lset, *ts, v); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *openMetricsSource) close() error { return nil }
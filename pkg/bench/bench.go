@@ -0,0 +1,258 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package bench generates a synthetic remote write load against a Promscale
+// connector and reports the ingest latency and the growth of the database, to
+// size the hardware of a deployment before running it in production.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+// maxErrors is the number of distinct errors kept in the report.
+const maxErrors = 10
+
+// Report is the result of a bench run.
+type Report struct {
+	DurationSeconds        float64        `json:"durationSeconds"`
+	Requests               int64          `json:"requests"`
+	FailedRequests         int64          `json:"failedRequests"`
+	Samples                int64          `json:"samples"`
+	TargetSamplesPerSecond float64        `json:"targetSamplesPerSecond"`
+	SamplesPerSecond       float64        `json:"samplesPerSecond"`
+	SeriesCreated          int64          `json:"seriesCreated"`
+	Latency                LatencyReport  `json:"latency"`
+	Errors                 map[string]int `json:"errors,omitempty"`
+	DB                     *DBReport      `json:"db,omitempty"`
+}
+
+// LatencyReport holds the percentiles of the latency of the write requests,
+// in seconds.
+type LatencyReport struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// result is the outcome of a write request.
+type result struct {
+	latency time.Duration
+	samples int
+	err     error
+}
+
+// Run sends the synthetic load of the config to the connector until the
+// duration of the config elapses or the context is done, and reports how the
+// connector and the database kept up.
+func Run(ctx context.Context, cfg Config, client *http.Client) (*Report, error) {
+	var (
+		conn     *pgx.Conn
+		dbBefore dbStats
+		err      error
+	)
+	if cfg.DBURI != "" {
+		conn, err = pgx.Connect(ctx, cfg.DBURI)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to the database: %w", err)
+		}
+		defer conn.Close(context.Background())
+		if dbBefore, err = readDBStats(ctx, conn); err != nil {
+			return nil, fmt.Errorf("reading the database statistics: %w", err)
+		}
+	}
+
+	start := time.Now()
+	gen := newGenerator(cfg, start)
+	requests := make(chan *prompb.WriteRequest, cfg.Concurrency)
+	results := make([][]result, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for req := range requests {
+				results[i] = append(results[i], send(ctx, client, cfg, req))
+			}
+		}(i)
+	}
+
+	// The requests are paced to send the target samples per second. When
+	// the connector is slower, sending blocks and the achieved rate drops.
+	interval := time.Duration(float64(time.Second) * float64(cfg.BatchSize) / float64(cfg.SamplesPerSecond))
+	end := start.Add(cfg.Duration)
+	next := start
+generate:
+	for {
+		now := time.Now()
+		if !now.Before(end) {
+			break
+		}
+		select {
+		case requests <- gen.request(now):
+		case <-ctx.Done():
+			break generate
+		}
+		next = next.Add(interval)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			break generate
+		}
+	}
+	close(requests)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := newReport(results, elapsed)
+	report.TargetSamplesPerSecond = float64(cfg.SamplesPerSecond)
+	report.SeriesCreated = gen.seriesCreated()
+	if conn != nil {
+		dbAfter, err := readDBStats(context.Background(), conn)
+		if err != nil {
+			return nil, fmt.Errorf("reading the database statistics: %w", err)
+		}
+		report.DB = newDBReport(dbBefore, dbAfter, report.Samples)
+	}
+	return report, nil
+}
+
+// send sends a write request like Prometheus remote write.
+func send(ctx context.Context, client *http.Client, cfg Config, wr *prompb.WriteRequest) result {
+	data, err := proto.Marshal(wr)
+	if err != nil {
+		return result{err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return result{err: err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if cfg.Tenant != "" {
+		req.Header.Set(tenancy.TenantHeader, cfg.Tenant)
+	}
+	if cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	res := result{latency: time.Since(start), samples: len(wr.Timeseries)}
+	if resp.StatusCode/100 != 2 {
+		res.err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return res
+}
+
+func newReport(results [][]result, elapsed time.Duration) *Report {
+	r := &Report{DurationSeconds: elapsed.Seconds()}
+	var latencies []float64
+	for _, rs := range results {
+		for _, res := range rs {
+			r.Requests++
+			latencies = append(latencies, res.latency.Seconds())
+			if res.err != nil {
+				r.FailedRequests++
+				if r.Errors == nil {
+					r.Errors = make(map[string]int)
+				}
+				if _, ok := r.Errors[res.err.Error()]; ok || len(r.Errors) < maxErrors {
+					r.Errors[res.err.Error()]++
+				}
+				continue
+			}
+			r.Samples += int64(res.samples)
+		}
+	}
+	if elapsed > 0 {
+		r.SamplesPerSecond = float64(r.Samples) / elapsed.Seconds()
+	}
+	r.Latency = latencyReport(latencies)
+	return r
+}
+
+func latencyReport(latencies []float64) LatencyReport {
+	if len(latencies) == 0 {
+		return LatencyReport{}
+	}
+	sort.Float64s(latencies)
+	sum := 0.0
+	for _, l := range latencies {
+		sum += l
+	}
+	return LatencyReport{
+		Mean: sum / float64(len(latencies)),
+		P50:  percentile(latencies, 0.5),
+		P90:  percentile(latencies, 0.9),
+		P99:  percentile(latencies, 0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// WriteText writes the report for a human reader.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Duration:          %.1fs\n", r.DurationSeconds)
+	fmt.Fprintf(w, "Requests:          %d (%d failed)\n", r.Requests, r.FailedRequests)
+	fmt.Fprintf(w, "Samples written:   %d\n", r.Samples)
+	fmt.Fprintf(w, "Samples/s:         %.0f (target %.0f)\n", r.SamplesPerSecond, r.TargetSamplesPerSecond)
+	fmt.Fprintf(w, "Series created:    %d\n", r.SeriesCreated)
+	fmt.Fprintf(w, "Latency:           mean %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		seconds(r.Latency.Mean), seconds(r.Latency.P50), seconds(r.Latency.P90), seconds(r.Latency.P99), seconds(r.Latency.Max))
+	if len(r.Errors) > 0 {
+		errs := make([]string, 0, len(r.Errors))
+		for err := range r.Errors {
+			errs = append(errs, err)
+		}
+		sort.Strings(errs)
+		fmt.Fprintln(w, "Errors:")
+		for _, err := range errs {
+			fmt.Fprintf(w, "  %dx %s\n", r.Errors[err], err)
+		}
+	}
+	if r.DB != nil {
+		fmt.Fprintln(w, "Database:")
+		fmt.Fprintf(w, "  Size growth:     %d bytes (%.1f bytes/sample)\n", r.DB.SizeGrowthBytes, r.DB.BytesPerSample)
+		fmt.Fprintf(w, "  Transactions:    %d\n", r.DB.TransactionsCommitted)
+		fmt.Fprintf(w, "  Tuples inserted: %d\n", r.DB.TuplesInserted)
+		fmt.Fprintf(w, "  Cache hit ratio: %.3f\n", r.DB.CacheHitRatio)
+		fmt.Fprintf(w, "  Bench series:    %d\n", r.DB.BenchSeries)
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
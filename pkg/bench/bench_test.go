@@ -0,0 +1,173 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package bench
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func testConfig(t *testing.T, args ...string) Config {
+	var cfg Config
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	ParseFlags(fs, &cfg)
+	require.NoError(t, fs.Parse(args))
+	require.NoError(t, Validate(&cfg))
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "defaults"},
+		{name: "bad url", args: []string{"-url", "localhost:9201"}, err: "url must be"},
+		{name: "more metrics than series", args: []string{"-series", "10", "-metrics", "20"}, err: "metrics must be"},
+		{name: "churn above 1", args: []string{"-churn-rate", "1.5"}, err: "churn-rate must be"},
+		{name: "unknown distribution", args: []string{"-label-distribution", "normal"}, err: "label-distribution must be"},
+		{name: "unknown output", args: []string{"-output", "yaml"}, err: "output must be"},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var cfg Config
+			fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+			ParseFlags(fs, &cfg)
+			require.NoError(t, fs.Parse(c.args))
+			err := Validate(&cfg)
+			if c.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.err)
+		})
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	cfg := testConfig(t, "-series", "10", "-metrics", "2", "-batch-size", "4", "-labels", "3", "-churn-rate", "0.5")
+	start := time.Unix(1000, 0)
+	g := newGenerator(cfg, start)
+
+	// The active series are sampled in turn.
+	var ids []string
+	for i := 0; i < 3; i++ {
+		for _, ts := range g.request(start).Timeseries {
+			require.Len(t, ts.Labels, 5)
+			require.True(t, sort.SliceIsSorted(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name }))
+			require.Equal(t, start.UnixMilli(), ts.Samples[0].Timestamp)
+			for _, l := range ts.Labels {
+				if l.Name == "series_id" {
+					ids = append(ids, l.Value)
+				}
+			}
+		}
+	}
+	require.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "1"}, ids)
+	require.Equal(t, int64(10), g.seriesCreated())
+
+	// The labels only depend on the series.
+	require.Equal(t, g.labels(7), newGenerator(cfg, start).labels(7))
+
+	// Half of the series are replaced every minute.
+	require.Equal(t, int64(5), g.firstSeries(start.Add(time.Minute)))
+	// After two minutes, the active series are 10 to 19 and the next
+	// request samples 12 to 15.
+	g.request(start.Add(2 * time.Minute))
+	require.Equal(t, int64(16), g.seriesCreated())
+}
+
+func TestLabelDistribution(t *testing.T) {
+	counts := func(distribution string) []int {
+		cfg := testConfig(t, "-label-cardinality", "50", "-label-distribution", distribution)
+		g := newGenerator(cfg, time.Now())
+		c := make([]int, cfg.LabelCardinality)
+		for id := int64(0); id < 10000; id++ {
+			c[g.labelValue(id, 0)]++
+		}
+		return c
+	}
+
+	uniform := counts(DistributionUniform)
+	for _, c := range uniform {
+		require.InDelta(t, 200, c, 80)
+	}
+	zipf := counts(DistributionZipf)
+	require.Greater(t, zipf[0], 5*zipf[10])
+	require.Greater(t, zipf[0], 2000)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	require.Equal(t, 5.0, percentile(values, 0.5))
+	require.Equal(t, 9.0, percentile(values, 0.9))
+	require.Equal(t, 10.0, percentile(values, 0.99))
+	require.Equal(t, 1.0, percentile(values, 0))
+}
+
+func TestRun(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		samples int
+		tenants = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		var wr prompb.WriteRequest
+		require.NoError(t, proto.Unmarshal(data, &wr))
+		mu.Lock()
+		samples += len(wr.Timeseries)
+		tenants[r.Header.Get("TENANT")] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := testConfig(t, "-url", server.URL, "-tenant", "bench", "-duration", "200ms",
+		"-series", "100", "-metrics", "5", "-samples-per-second", "5000", "-batch-size", "100")
+	report, err := Run(context.Background(), cfg, server.Client())
+	require.NoError(t, err)
+	require.Greater(t, report.Requests, int64(0))
+	require.Zero(t, report.FailedRequests)
+	require.Equal(t, int64(samples), report.Samples)
+	require.Equal(t, report.Requests*100, report.Samples)
+	require.Equal(t, int64(100), report.SeriesCreated)
+	require.Equal(t, map[string]bool{"bench": true}, tenants)
+	require.LessOrEqual(t, report.Latency.P50, report.Latency.P99)
+	require.LessOrEqual(t, report.Latency.P99, report.Latency.Max)
+	require.Nil(t, report.DB)
+}
+
+func TestRunFailedWrites(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of disk", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := testConfig(t, "-url", server.URL, "-duration", "50ms", "-series", "10", "-metrics", "1", "-batch-size", "10")
+	report, err := Run(context.Background(), cfg, server.Client())
+	require.NoError(t, err)
+	require.Equal(t, report.Requests, report.FailedRequests)
+	require.Zero(t, report.Samples)
+	require.Equal(t, map[string]int{"500 Internal Server Error: out of disk": int(report.Requests)}, report.Errors)
+}
@@ -0,0 +1,92 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package bench
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// The distributions of the label values over the series.
+const (
+	DistributionUniform = "uniform"
+	DistributionZipf    = "zipf"
+)
+
+// Config holds the bench flags.
+type Config struct {
+	URL               string
+	Tenant            string
+	BearerToken       string
+	Duration          time.Duration
+	Series            int
+	Metrics           int
+	SamplesPerSecond  int
+	BatchSize         int
+	Concurrency       int
+	ChurnRate         float64
+	Labels            int
+	LabelCardinality  int
+	LabelDistribution string
+	DBURI             string
+	Output            string
+}
+
+// ParseFlags registers the bench flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.URL, "url", "http://localhost:9201/write", "Remote write URL the load is sent to.")
+	fs.StringVar(&cfg.Tenant, "tenant", "", "Tenant of the series, sent in the TENANT header. Empty sends no tenant.")
+	fs.StringVar(&cfg.BearerToken, "bearer-token", "", "Bearer token authenticating the writes. Empty sends no token.")
+	fs.DurationVar(&cfg.Duration, "duration", time.Minute, "How long the load is generated.")
+	fs.IntVar(&cfg.Series, "series", 10000, "Number of active series.")
+	fs.IntVar(&cfg.Metrics, "metrics", 100, "Number of metric names the series are spread over.")
+	fs.IntVar(&cfg.SamplesPerSecond, "samples-per-second", 10000, "Target number of samples written per second.")
+	fs.IntVar(&cfg.BatchSize, "batch-size", 1000, "Number of samples, one per series, in each write request.")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 4, "Number of write requests sent concurrently.")
+	fs.Float64Var(&cfg.ChurnRate, "churn-rate", 0, "Fraction of the active series replaced by new series every minute, e.g. 0.1 replaces 10% of them every minute.")
+	fs.IntVar(&cfg.Labels, "labels", 5, "Number of labels of each series, besides the metric name and the series ID.")
+	fs.IntVar(&cfg.LabelCardinality, "label-cardinality", 100, "Number of distinct values of each label.")
+	fs.StringVar(&cfg.LabelDistribution, "label-distribution", DistributionUniform, "Distribution of the label values over the series: "+
+		"uniform, or zipf for a few values shared by most series and a long tail of rare values.")
+	fs.StringVar(&cfg.DBURI, "db-uri", "", "PostgreSQL URI of the database of the target connector, to report the database growth and activity during the run. "+
+		"Empty skips the database metrics.")
+	fs.StringVar(&cfg.Output, "output", "text", "Format of the report: text or json.")
+	return cfg
+}
+
+// Validate checks the bench flags.
+func Validate(cfg *Config) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", cfg.URL)
+	}
+	switch {
+	case cfg.Duration <= 0:
+		return fmt.Errorf("duration must be positive, got %s", cfg.Duration)
+	case cfg.Series < 1:
+		return fmt.Errorf("series must be positive, got %d", cfg.Series)
+	case cfg.Metrics < 1 || cfg.Metrics > cfg.Series:
+		return fmt.Errorf("metrics must be between 1 and the number of series, got %d", cfg.Metrics)
+	case cfg.SamplesPerSecond < 1:
+		return fmt.Errorf("samples-per-second must be positive, got %d", cfg.SamplesPerSecond)
+	case cfg.BatchSize < 1:
+		return fmt.Errorf("batch-size must be positive, got %d", cfg.BatchSize)
+	case cfg.Concurrency < 1:
+		return fmt.Errorf("concurrency must be positive, got %d", cfg.Concurrency)
+	case cfg.ChurnRate < 0 || cfg.ChurnRate > 1:
+		return fmt.Errorf("churn-rate must be between 0 and 1, got %g", cfg.ChurnRate)
+	case cfg.Labels < 0:
+		return fmt.Errorf("labels must not be negative, got %d", cfg.Labels)
+	case cfg.LabelCardinality < 1:
+		return fmt.Errorf("label-cardinality must be positive, got %d", cfg.LabelCardinality)
+	case cfg.LabelDistribution != DistributionUniform && cfg.LabelDistribution != DistributionZipf:
+		return fmt.Errorf("label-distribution must be uniform or zipf, got %q", cfg.LabelDistribution)
+	case cfg.Output != "text" && cfg.Output != "json":
+		return fmt.Errorf("output must be text or json, got %q", cfg.Output)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package bench
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	dbActivitySQL = `SELECT pg_database_size(current_database()), xact_commit, tup_inserted, blks_hit, blks_read
+FROM pg_stat_database WHERE datname = current_database()`
+	benchSeriesSQL = `SELECT count(*) FROM _prom_catalog.series s
+JOIN _prom_catalog.metric m ON m.id = s.metric_id
+WHERE m.metric_name LIKE 'promscale\_bench\_metric\_%'`
+)
+
+// dbStats are the statistics of the database read before and after a run.
+// The activity counters are cumulative.
+type dbStats struct {
+	sizeBytes   int64
+	commits     int64
+	tuples      int64
+	blocksHit   int64
+	blocksRead  int64
+	benchSeries int64
+}
+
+func readDBStats(ctx context.Context, conn *pgx.Conn) (dbStats, error) {
+	var s dbStats
+	err := conn.QueryRow(ctx, dbActivitySQL).Scan(&s.sizeBytes, &s.commits, &s.tuples, &s.blocksHit, &s.blocksRead)
+	if err != nil {
+		return s, err
+	}
+	// The series catalog does not exist before Promscale installed its
+	// schema, there are no series then.
+	if err = conn.QueryRow(ctx, benchSeriesSQL).Scan(&s.benchSeries); err != nil {
+		s.benchSeries = 0
+	}
+	return s, nil
+}
+
+// DBReport describes the growth and the activity of the database during a
+// run. The activity includes the work of the other clients of the database.
+type DBReport struct {
+	SizeGrowthBytes       int64   `json:"sizeGrowthBytes"`
+	BytesPerSample        float64 `json:"bytesPerSample"`
+	TransactionsCommitted int64   `json:"transactionsCommitted"`
+	TuplesInserted        int64   `json:"tuplesInserted"`
+	CacheHitRatio         float64 `json:"cacheHitRatio"`
+	BenchSeries           int64   `json:"benchSeries"`
+}
+
+func newDBReport(before, after dbStats, samples int64) *DBReport {
+	r := &DBReport{
+		SizeGrowthBytes:       after.sizeBytes - before.sizeBytes,
+		TransactionsCommitted: after.commits - before.commits,
+		TuplesInserted:        after.tuples - before.tuples,
+		BenchSeries:           after.benchSeries,
+	}
+	if samples > 0 {
+		r.BytesPerSample = float64(r.SizeGrowthBytes) / float64(samples)
+	}
+	if blocks := (after.blocksHit - before.blocksHit) + (after.blocksRead - before.blocksRead); blocks > 0 {
+		r.CacheHitRatio = float64(after.blocksHit-before.blocksHit) / float64(blocks)
+	}
+	return r
+}
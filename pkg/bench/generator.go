@@ -0,0 +1,131 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package bench
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const (
+	// MetricPrefix is the prefix of the names of the generated metrics.
+	MetricPrefix = "promscale_bench_metric_"
+	// zipfExponent is the exponent of the zipf distribution of the label
+	// values: the k-th most common value is in about 1/k^1.1 of the series.
+	zipfExponent = 1.1
+)
+
+// generator generates the write requests of the synthetic load. The series
+// are identified by a sequence number: the active series at any time are the
+// Series consecutive ones starting at the first active series, which the
+// churn moves forward. The labels of a series only depend on its sequence
+// number.
+type generator struct {
+	cfg   Config
+	start time.Time
+	// zipfCDF is the cumulative distribution of the label values of the
+	// zipf distribution.
+	zipfCDF []float64
+
+	// pos is the number of samples generated.
+	pos int64
+	// lastSeries is the highest sequence number of the generated series.
+	lastSeries int64
+}
+
+func newGenerator(cfg Config, start time.Time) *generator {
+	g := &generator{cfg: cfg, start: start, lastSeries: -1}
+	if cfg.LabelDistribution == DistributionZipf {
+		g.zipfCDF = make([]float64, cfg.LabelCardinality)
+		sum := 0.0
+		for k := range g.zipfCDF {
+			sum += 1 / math.Pow(float64(k+1), zipfExponent)
+			g.zipfCDF[k] = sum
+		}
+		for k := range g.zipfCDF {
+			g.zipfCDF[k] /= sum
+		}
+	}
+	return g
+}
+
+// firstSeries returns the sequence number of the first active series at t.
+func (g *generator) firstSeries(t time.Time) int64 {
+	return int64(g.cfg.ChurnRate * float64(g.cfg.Series) * t.Sub(g.start).Minutes())
+}
+
+// seriesCreated returns the number of distinct series generated.
+func (g *generator) seriesCreated() int64 {
+	return g.lastSeries + 1
+}
+
+// request returns the next write request, with one sample of BatchSize active
+// series at now. The active series are sampled in turn, the value of each
+// series counts its samples.
+func (g *generator) request(now time.Time) *prompb.WriteRequest {
+	first := g.firstSeries(now)
+	ts := now.UnixMilli()
+	series := int64(g.cfg.Series)
+	req := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, g.cfg.BatchSize)}
+	for i := 0; i < g.cfg.BatchSize; i++ {
+		id := first + g.pos%series
+		value := float64(g.pos / series)
+		g.pos++
+		if id > g.lastSeries {
+			g.lastSeries = id
+		}
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels:  g.labels(id),
+			Samples: []prompb.Sample{{Timestamp: ts, Value: value}},
+		})
+	}
+	return req
+}
+
+// labels returns the sorted labels of the series with the sequence number.
+func (g *generator) labels(id int64) []prompb.Label {
+	lbls := make([]prompb.Label, 0, g.cfg.Labels+2)
+	lbls = append(lbls,
+		prompb.Label{Name: "__name__", Value: MetricPrefix + strconv.FormatInt(id%int64(g.cfg.Metrics), 10)},
+		prompb.Label{Name: "series_id", Value: strconv.FormatInt(id, 10)},
+	)
+	for j := 0; j < g.cfg.Labels; j++ {
+		lbls = append(lbls, prompb.Label{
+			Name:  "label_" + strconv.Itoa(j),
+			Value: "value_" + strconv.Itoa(g.labelValue(id, j)),
+		})
+	}
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i].Name < lbls[j].Name })
+	return lbls
+}
+
+// labelValue returns the index of the value of the j-th label of the series
+// with the sequence number, drawn from the label distribution.
+func (g *generator) labelValue(id int64, j int) int {
+	h := splitMix64(uint64(id)*1000003 + uint64(j))
+	if g.zipfCDF == nil {
+		return int(h % uint64(g.cfg.LabelCardinality))
+	}
+	u := float64(h>>11) / (1 << 53)
+	k := sort.SearchFloat64s(g.zipfCDF, u)
+	if k == len(g.zipfCDF) {
+		// The last cumulative weight may round below 1.
+		k--
+	}
+	return k
+}
+
+// splitMix64 is a fast hash spreading consecutive inputs over all the 64-bit
+// values.
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
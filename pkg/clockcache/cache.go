@@ -15,6 +15,11 @@ import (
 // CLOCK based approximate LRU storing designed for concurrent usage.
 // Gets only require a read lock, while Inserts take at least one write lock.
 type Cache struct {
+	// number of lookups and hits, accessed atomically. They come
+	// first to be 64-bit aligned.
+	queries uint64
+	hits    uint64
+
 	metrics *perfMetrics
 	// guards elements and all fields except for `used` in Element, must have at
 	// least a read-lock to access, and a write-lock to insert/update/delete.
@@ -242,6 +247,7 @@ func (self *Cache) Get(key interface{}) (interface{}, bool) {
 
 func (self *Cache) get(key interface{}) (interface{}, bool) {
 	self.metrics.Inc(self.metrics.queriesTotal)
+	atomic.AddUint64(&self.queries, 1)
 	elem, present := self.elements[key]
 	if !present {
 		return 0, false
@@ -256,6 +262,7 @@ func (self *Cache) get(key interface{}) (interface{}, bool) {
 		atomic.StoreUint32(&elem.used, 1)
 	}
 	self.metrics.Inc(self.metrics.hitsTotal)
+	atomic.AddUint64(&self.hits, 1)
 
 	return elem.value, true
 }
@@ -299,6 +306,7 @@ func (self *Cache) ExpandTo(newMax int) {
 			key:   elem.key,
 			value: elem.value,
 			used:  atomic.LoadUint32(&elem.used),
+			size:  elem.size,
 		})
 	}
 
@@ -315,6 +323,66 @@ func (self *Cache) ExpandTo(newMax int) {
 	self.storage = newStorage
 }
 
+// ShrinkTo shrinks the cache to hold at most newMax elements. The elements
+// marked as recently used are kept over the others, the remaining ones are
+// counted as evictions.
+func (self *Cache) ShrinkTo(newMax int) {
+	self.insertLock.Lock()
+	defer self.insertLock.Unlock()
+
+	if newMax >= cap(self.storage) || newMax < 1 {
+		return
+	}
+
+	newStorage := make([]element, 0, newMax)
+	newDataSize := uint64(0)
+	keep := func(used bool) {
+		for i := range self.storage {
+			if len(newStorage) == newMax {
+				return
+			}
+			elem := &self.storage[i]
+			elemUsed := atomic.LoadUint32(&elem.used)
+			if (elemUsed != 0) != used {
+				continue
+			}
+			newStorage = append(newStorage, element{
+				key:   elem.key,
+				value: elem.value,
+				used:  elemUsed,
+				size:  elem.size,
+			})
+			newDataSize += elem.size
+		}
+	}
+	keep(true)
+	keep(false)
+
+	newElements := make(map[interface{}]*element, newMax)
+	for i := range newStorage {
+		elem := &newStorage[i]
+		newElements[elem.key] = elem
+	}
+
+	self.elementsLock.Lock()
+	defer self.elementsLock.Unlock()
+
+	self.evictions += uint64(len(self.storage) - len(newStorage))
+	self.elements = newElements
+	self.storage = newStorage
+	self.dataSize = newDataSize
+	self.next = 0
+}
+
+// Resize grows or shrinks the cache to hold up to newMax elements.
+func (self *Cache) Resize(newMax int) {
+	if newMax > self.Cap() {
+		self.ExpandTo(newMax)
+		return
+	}
+	self.ShrinkTo(newMax)
+}
+
 func (self *Cache) Reset() {
 	self.insertLock.Lock()
 	defer self.insertLock.Unlock()
@@ -356,6 +424,30 @@ func (self *Cache) Cap() int {
 	return cap(self.storage)
 }
 
+// Stats is a point in time view of the usage of a Cache.
+type Stats struct {
+	Len       int
+	Cap       int
+	SizeBytes uint64
+	Evictions uint64
+	// Queries and Hits are counted since the creation of the cache.
+	Queries uint64
+	Hits    uint64
+}
+
+func (self *Cache) Stats() Stats {
+	self.elementsLock.RLock()
+	defer self.elementsLock.RUnlock()
+	return Stats{
+		Len:       len(self.storage),
+		Cap:       cap(self.storage),
+		SizeBytes: uint64(cap(self.storage)*120) + self.dataSize,
+		Evictions: self.evictions,
+		Queries:   atomic.LoadUint64(&self.queries),
+		Hits:      atomic.LoadUint64(&self.hits),
+	}
+}
+
 func (self *Cache) debugString() string {
 	self.elementsLock.RLock()
 	defer self.elementsLock.RUnlock()
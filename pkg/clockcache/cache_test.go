@@ -257,6 +257,38 @@ func TestExpand(t *testing.T) {
 	}
 }
 
+func TestShrink(t *testing.T) {
+	cache := WithMax(4)
+	for i := 1; i <= 4; i++ {
+		cache.Insert(i, i, 16)
+	}
+	cache.Get(2)
+	cache.Get(4)
+
+	cache.ShrinkTo(3)
+	// Recently used elements are kept first.
+	expected := "[2: 2, 4: 4, 1: 1, ]"
+	if cache.debugString() != expected {
+		t.Errorf("unexpected cache\nexpected\n\t%s\nfound\n\t%s\n", expected, cache.debugString())
+	}
+	require.Equal(t, Stats{Len: 3, Cap: 3, SizeBytes: 3*120 + 3*16, Evictions: 1, Queries: 2, Hits: 2}, cache.Stats())
+
+	_, found := cache.Get(3)
+	require.False(t, found)
+
+	cache.Insert(5, 5, 16)
+	require.Equal(t, 3, cache.Len())
+	_, found = cache.Get(5)
+	require.True(t, found)
+
+	cache.Resize(5)
+	require.Equal(t, 5, cache.Cap())
+	require.Equal(t, uint64(5*120+3*16), cache.SizeBytes())
+	cache.Resize(2)
+	require.Equal(t, 2, cache.Cap())
+	require.Equal(t, 2, cache.Len())
+}
+
 func TestReset(t *testing.T) {
 	cache := WithMax(3)
 	cache.Insert(1, 1, 16)
@@ -0,0 +1,219 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package consistency verifies on startup that the series catalog, the label
+// table and the metric tables agree with each other. A crash or a manual
+// intervention can leave them inconsistent, which shows up later as missing
+// or wrongly labeled query results.
+package consistency
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// Names of the checks reported in the findings.
+const (
+	// CheckDanglingLabels finds series referencing label IDs missing from
+	// the label table.
+	CheckDanglingLabels = "dangling_labels"
+	// CheckOrphanedSeries finds series of metrics missing from the catalog.
+	CheckOrphanedSeries = "orphaned_series"
+	// CheckMissingMetricTables finds metrics whose table does not exist.
+	CheckMissingMetricTables = "missing_metric_tables"
+	// CheckOrphanedSamples finds recent samples of series missing from the
+	// series catalog.
+	CheckOrphanedSamples = "orphaned_samples"
+)
+
+const (
+	// maxExamples is the number of inconsistent objects logged by check.
+	maxExamples = 10
+
+	danglingLabelsSQL = `SELECT s.id::text
+FROM _prom_catalog.series s
+WHERE s.delete_epoch IS NULL
+AND EXISTS (
+	SELECT 1 FROM unnest(s.labels) l(id)
+	WHERE l.id <> 0 AND NOT EXISTS (SELECT 1 FROM _prom_catalog.label WHERE label.id = l.id)
+)
+LIMIT $1`
+	// Series referencing missing labels cannot be returned correctly by
+	// queries. They are marked for deletion like unused series, and are
+	// recreated with the right labels if they are written again.
+	repairDanglingLabelsSQL = `UPDATE _prom_catalog.series s
+SET delete_epoch = e.current_epoch + 1
+FROM _prom_catalog.ids_epoch e
+WHERE s.delete_epoch IS NULL AND s.id = ANY($1::text[]::bigint[])`
+
+	orphanedSeriesSQL = `SELECT s.id::text
+FROM _prom_catalog.series s
+WHERE NOT EXISTS (SELECT 1 FROM _prom_catalog.metric m WHERE m.id = s.metric_id)
+LIMIT $1`
+	repairOrphanedSeriesSQL = `DELETE FROM _prom_catalog.series WHERE id = ANY($1::text[]::bigint[])`
+
+	missingMetricTablesSQL = `SELECT m.metric_name
+FROM _prom_catalog.metric m
+WHERE NOT m.is_view AND to_regclass(format('%I.%I', m.table_schema, m.table_name)) IS NULL
+LIMIT $1`
+
+	metricTablesSQL = `SELECT m.table_schema, m.table_name
+FROM _prom_catalog.metric m
+WHERE NOT m.is_view AND to_regclass(format('%I.%I', m.table_schema, m.table_name)) IS NOT NULL
+ORDER BY m.id`
+	orphanedSamplesSQLFmt = `SELECT count(DISTINCT d.series_id)
+FROM %s d
+WHERE d.time > now() - make_interval(secs => $1)
+AND NOT EXISTS (SELECT 1 FROM _prom_catalog.series s WHERE s.id = d.series_id)`
+)
+
+// Finding is the result of a check.
+type Finding struct {
+	Check string `json:"check"`
+	// Count is the number of inconsistent objects found, at most the
+	// maximum number of findings for the series checks.
+	Count int64 `json:"count"`
+	// Repaired is the number of objects fixed in repair mode.
+	Repaired int64 `json:"repaired"`
+	// Examples are the IDs or names of some of the inconsistent objects.
+	Examples []string `json:"examples,omitempty"`
+}
+
+// Report are the findings of all the checks.
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Inconsistent returns true if any check found an inconsistency.
+func (r Report) Inconsistent() bool {
+	for _, f := range r.Findings {
+		if f.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs all the checks, and repairs the inconsistencies that can be
+// repaired in repair mode. It does nothing if the checker is disabled.
+//
+// Repairs are safe while the connector ingests: series are marked for
+// deletion the same way the maintenance jobs mark unused series.
+func Check(ctx context.Context, conn pgxconn.PgxConn, cfg Config) (Report, error) {
+	var report Report
+	if cfg.Mode == ModeDisabled {
+		return report, nil
+	}
+	start := time.Now()
+	log.Info("msg", "Checking the consistency of the database", "mode", cfg.Mode)
+
+	checks := []struct {
+		name      string
+		query     string
+		repairSQL string
+	}{
+		{CheckDanglingLabels, danglingLabelsSQL, repairDanglingLabelsSQL},
+		{CheckOrphanedSeries, orphanedSeriesSQL, repairOrphanedSeriesSQL},
+		{CheckMissingMetricTables, missingMetricTablesSQL, ""},
+	}
+	for _, c := range checks {
+		found, err := queryStrings(ctx, conn, c.query, cfg.MaxFindings)
+		if err != nil {
+			return report, fmt.Errorf("checking %s: %w", c.name, err)
+		}
+		f := Finding{Check: c.name, Count: int64(len(found)), Examples: examples(found)}
+		if cfg.Mode == ModeRepair && c.repairSQL != "" && len(found) > 0 {
+			tag, err := conn.Exec(ctx, c.repairSQL, found)
+			if err != nil {
+				return report, fmt.Errorf("repairing %s: %w", c.name, err)
+			}
+			f.Repaired = tag.RowsAffected()
+		}
+		report.Findings = append(report.Findings, f)
+	}
+
+	if cfg.SamplesLookback > 0 {
+		f, err := checkOrphanedSamples(ctx, conn, cfg.SamplesLookback)
+		if err != nil {
+			return report, fmt.Errorf("checking %s: %w", CheckOrphanedSamples, err)
+		}
+		report.Findings = append(report.Findings, f)
+	}
+
+	for _, f := range report.Findings {
+		if f.Count > 0 {
+			log.Warn("msg", "Database inconsistency found", "check", f.Check, "count", f.Count, "repaired", f.Repaired, "examples", fmt.Sprint(f.Examples))
+		}
+	}
+	log.Info("msg", "Database consistency check done", "inconsistent", report.Inconsistent(), "duration", time.Since(start))
+	return report, nil
+}
+
+// checkOrphanedSamples counts the series of recent samples missing from the
+// series catalog. They cannot be repaired since their labels are unknown.
+func checkOrphanedSamples(ctx context.Context, conn pgxconn.PgxConn, lookback time.Duration) (Finding, error) {
+	f := Finding{Check: CheckOrphanedSamples}
+	rows, err := conn.Query(ctx, metricTablesSQL)
+	if err != nil {
+		return f, err
+	}
+	var tables []string
+	for rows.Next() {
+		var schemaName, tableName string
+		if err := rows.Scan(&schemaName, &tableName); err != nil {
+			rows.Close()
+			return f, err
+		}
+		tables = append(tables, pgx.Identifier{schemaName, tableName}.Sanitize())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return f, err
+	}
+
+	var found []string
+	for _, table := range tables {
+		var count int64
+		err := conn.QueryRow(ctx, fmt.Sprintf(orphanedSamplesSQLFmt, table), lookback.Seconds()).Scan(&count)
+		if err != nil {
+			return f, fmt.Errorf("table %s: %w", table, err)
+		}
+		if count > 0 {
+			f.Count += count
+			found = append(found, table)
+		}
+	}
+	f.Examples = examples(found)
+	return f, nil
+}
+
+func queryStrings(ctx context.Context, conn pgxconn.PgxConn, sql string, args ...interface{}) ([]string, error) {
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func examples(found []string) []string {
+	if len(found) > maxExamples {
+		return found[:maxExamples]
+	}
+	return found
+}
@@ -0,0 +1,87 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package consistency
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{}))
+	require.NoError(t, Validate(&Config{Mode: ModeReport, MaxFindings: 1}))
+	require.NoError(t, Validate(&Config{Mode: ModeRepair, SamplesLookback: time.Hour, MaxFindings: 1}))
+	require.Error(t, Validate(&Config{Mode: "fix", MaxFindings: 1}))
+	require.Error(t, Validate(&Config{Mode: ModeReport}))
+	require.Error(t, Validate(&Config{Mode: ModeReport, SamplesLookback: -time.Second, MaxFindings: 1}))
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     string
+		expected []Finding
+	}{
+		{
+			name: "report",
+			mode: ModeReport,
+			expected: []Finding{
+				{Check: CheckDanglingLabels, Count: 2, Examples: []string{"1", "2"}},
+				{Check: CheckOrphanedSeries, Count: 1, Examples: []string{"3"}},
+				{Check: CheckMissingMetricTables, Count: 0},
+				{Check: CheckOrphanedSamples, Count: 5, Examples: []string{`"prom_data"."cpu"`}},
+			},
+		},
+		{
+			name: "repair",
+			mode: ModeRepair,
+			expected: []Finding{
+				{Check: CheckDanglingLabels, Count: 2, Repaired: 2, Examples: []string{"1", "2"}},
+				{Check: CheckOrphanedSeries, Count: 1, Repaired: 1, Examples: []string{"3"}},
+				{Check: CheckMissingMetricTables, Count: 0},
+				{Check: CheckOrphanedSamples, Count: 5, Examples: []string{`"prom_data"."cpu"`}},
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			queries := []model.SqlQuery{
+				{Sql: danglingLabelsSQL, Args: []interface{}{10}, Results: model.RowResults{{"1"}, {"2"}}},
+			}
+			if c.mode == ModeRepair {
+				queries = append(queries, model.SqlQuery{Sql: repairDanglingLabelsSQL, Args: []interface{}{[]string{"1", "2"}}, Results: model.RowResults{{pgconn.CommandTag("UPDATE 2")}}})
+			}
+			queries = append(queries, model.SqlQuery{Sql: orphanedSeriesSQL, Args: []interface{}{10}, Results: model.RowResults{{"3"}}})
+			if c.mode == ModeRepair {
+				queries = append(queries, model.SqlQuery{Sql: repairOrphanedSeriesSQL, Args: []interface{}{[]string{"3"}}, Results: model.RowResults{{pgconn.CommandTag("DELETE 1")}}})
+			}
+			queries = append(queries,
+				model.SqlQuery{Sql: missingMetricTablesSQL, Args: []interface{}{10}},
+				model.SqlQuery{Sql: metricTablesSQL, Results: model.RowResults{{"prom_data", "cpu"}, {"prom_data", "mem"}}},
+				model.SqlQuery{Sql: fmt.Sprintf(orphanedSamplesSQLFmt, `"prom_data"."cpu"`), Args: []interface{}{3600.0}, Results: model.RowResults{{int64(5)}}},
+				model.SqlQuery{Sql: fmt.Sprintf(orphanedSamplesSQLFmt, `"prom_data"."mem"`), Args: []interface{}{3600.0}, Results: model.RowResults{{int64(0)}}},
+			)
+			conn := model.NewSqlRecorder(queries, t)
+
+			report, err := Check(context.Background(), conn, Config{Mode: c.mode, SamplesLookback: time.Hour, MaxFindings: 10})
+			require.NoError(t, err)
+			require.Equal(t, c.expected, report.Findings)
+			require.True(t, report.Inconsistent())
+		})
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	report, err := Check(context.Background(), model.NewSqlRecorder(nil, t), Config{})
+	require.NoError(t, err)
+	require.False(t, report.Inconsistent())
+}
@@ -0,0 +1,58 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package consistency
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Modes of the consistency checker.
+const (
+	ModeDisabled = ""
+	ModeReport   = "report"
+	ModeRepair   = "repair"
+)
+
+const (
+	defaultSamplesLookback = time.Hour
+	defaultMaxFindings     = 10000
+)
+
+// Config holds the consistency checker flags.
+type Config struct {
+	Mode            string
+	SamplesLookback time.Duration
+	MaxFindings     int
+}
+
+// ParseFlags registers the consistency checker flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Mode, "startup.consistency-check", ModeDisabled, "Verify the consistency of the series catalog, the label table and the metric tables on startup, e.g. after a crash. "+
+		"Set to 'report' to log the inconsistencies, or to 'repair' to also fix the ones that can be fixed safely. Disabled if empty.")
+	fs.DurationVar(&cfg.SamplesLookback, "startup.consistency-check.samples-lookback", defaultSamplesLookback, "Time range of the most recent samples checked for a missing series. "+
+		"Checking older samples reads more data. Setting it to 0 skips the samples check.")
+	fs.IntVar(&cfg.MaxFindings, "startup.consistency-check.max-findings", defaultMaxFindings, "Maximum number of inconsistent series reported or repaired by each check.")
+	return cfg
+}
+
+// Validate checks the consistency checker flags.
+func Validate(cfg *Config) error {
+	switch cfg.Mode {
+	case ModeDisabled:
+		return nil
+	case ModeReport, ModeRepair:
+	default:
+		return fmt.Errorf("startup.consistency-check must be empty, %q or %q: %q", ModeReport, ModeRepair, cfg.Mode)
+	}
+	if cfg.SamplesLookback < 0 {
+		return fmt.Errorf("startup.consistency-check.samples-lookback must not be negative: %s", cfg.SamplesLookback)
+	}
+	if cfg.MaxFindings < 1 {
+		return fmt.Errorf("startup.consistency-check.max-findings must be at least 1: %d", cfg.MaxFindings)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package dedup drops the samples written more than once with the same
+// series, timestamp and value within a sliding window. The two Prometheus
+// servers of an HA pair that cannot be told apart by cluster and __replica__
+// external labels write the same samples when they keep the timestamps of
+// their sources, e.g. federated or pushed samples; the samples of the
+// second writer are dropped before they are inserted. The samples that fail to
+// be inserted are forgotten, so that they are not dropped when retried.
+package dedup
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// numShards is the number of locks the series are spread across, so that
+// concurrent writers rarely wait for each other.
+const numShards = 64
+
+var droppedSamples = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "dedup",
+		Name:      "dropped_samples_total",
+		Help:      "Total number of written samples dropped since a sample with the same series, timestamp and value was written within the deduplication window.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(droppedSamples)
+}
+
+// Config holds the deduplication flags.
+type Config struct {
+	Window time.Duration
+}
+
+// ParseFlags registers the deduplication flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.DurationVar(&cfg.Window, "metrics.dedup.window", 0, "How far back the samples of a series are remembered to drop the samples written again "+
+		"with the same series, timestamp and value, e.g. by HA Prometheus pairs without cluster and __replica__ labels. Disabled if 0.")
+	return cfg
+}
+
+// Validate checks the deduplication flags.
+func Validate(cfg *Config) error {
+	if cfg.Window < 0 {
+		return fmt.Errorf("metrics.dedup.window must not be negative")
+	}
+	return nil
+}
+
+// Deduplicator drops the written samples already written within its window.
+// A nil Deduplicator keeps all the samples.
+type Deduplicator struct {
+	window time.Duration
+	shards [numShards]shard
+}
+
+type shard struct {
+	mu     sync.Mutex
+	series map[uint64]*seriesSamples
+	// swept is when the idle series of the shard were last removed.
+	swept time.Time
+}
+
+// seriesSamples are the samples of a series written within the window.
+type seriesSamples struct {
+	samples []sample
+	// latest is the timestamp of the latest sample of the series.
+	latest int64
+	// written is when samples of the series were last written, the series
+	// is forgotten once it is older than the window.
+	written time.Time
+}
+
+type sample struct {
+	t int64
+	// v holds the bits of the value so that stale markers, which are NaN,
+	// are duplicates of each other.
+	v uint64
+}
+
+// New returns a Deduplicator with the window of cfg, or nil if deduplication
+// is disabled.
+func New(cfg *Config) *Deduplicator {
+	if cfg.Window == 0 {
+		return nil
+	}
+	d := &Deduplicator{window: cfg.Window}
+	now := time.Now()
+	for i := range d.shards {
+		d.shards[i].series = make(map[uint64]*seriesSamples)
+		d.shards[i].swept = now
+	}
+	return d
+}
+
+// Filter returns the samples of the series that were not written within the
+// window, reusing the samples slice. The series is its canonical string
+// representation, so that the same labels written by different writers are
+// the same series.
+func (d *Deduplicator) Filter(series string, samples []prompb.Sample) []prompb.Sample {
+	if d == nil || len(samples) == 0 {
+		return samples
+	}
+	key := xxhash.Sum64String(series)
+	sh := &d.shards[key%numShards]
+	now := time.Now()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if now.Sub(sh.swept) > d.window {
+		sh.sweep(now.Add(-d.window))
+		sh.swept = now
+	}
+	s, ok := sh.series[key]
+	if !ok {
+		s = &seriesSamples{latest: math.MinInt64}
+		sh.series[key] = s
+	}
+	s.written = now
+
+	var (
+		kept    = samples[:0]
+		dropped int
+	)
+	for _, smpl := range samples {
+		if s.add(smpl) {
+			kept = append(kept, smpl)
+		} else {
+			dropped++
+		}
+	}
+	s.expire(d.window.Milliseconds())
+
+	if dropped > 0 {
+		droppedSamples.Add(float64(dropped))
+	}
+	return kept
+}
+
+// Forget forgets the samples returned by Filter for the series, so that they
+// are not dropped when they are written again. The samples that fail to be
+// inserted are forgotten, since the writer retries them.
+func (d *Deduplicator) Forget(series string, samples []prompb.Sample) {
+	if d == nil || len(samples) == 0 {
+		return
+	}
+	key := xxhash.Sum64String(series)
+	sh := &d.shards[key%numShards]
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	s, ok := sh.series[key]
+	if !ok {
+		return
+	}
+	for _, smpl := range samples {
+		s.remove(smpl)
+	}
+	if len(s.samples) == 0 {
+		delete(sh.series, key)
+	}
+}
+
+// add remembers the sample, returning false if it is a duplicate. A sample
+// with the timestamp of a remembered sample but another value is not a
+// duplicate: it is inserted and resolved by the conflict policy.
+func (s *seriesSamples) add(smpl prompb.Sample) bool {
+	v := math.Float64bits(smpl.Value)
+	for i := len(s.samples) - 1; i >= 0; i-- {
+		if s.samples[i].t == smpl.Timestamp && s.samples[i].v == v {
+			return false
+		}
+	}
+	s.samples = append(s.samples, sample{t: smpl.Timestamp, v: v})
+	if smpl.Timestamp > s.latest {
+		s.latest = smpl.Timestamp
+	}
+	return true
+}
+
+// remove forgets the sample, if it is remembered.
+func (s *seriesSamples) remove(smpl prompb.Sample) {
+	v := math.Float64bits(smpl.Value)
+	for i := len(s.samples) - 1; i >= 0; i-- {
+		if s.samples[i].t == smpl.Timestamp && s.samples[i].v == v {
+			s.samples = append(s.samples[:i], s.samples[i+1:]...)
+			break
+		}
+	}
+	s.latest = math.MinInt64
+	for _, remembered := range s.samples {
+		if remembered.t > s.latest {
+			s.latest = remembered.t
+		}
+	}
+}
+
+// expire forgets the samples older than the window before the latest sample.
+func (s *seriesSamples) expire(window int64) {
+	cutoff := s.latest - window
+	kept := s.samples[:0]
+	for _, smpl := range s.samples {
+		if smpl.t >= cutoff {
+			kept = append(kept, smpl)
+		}
+	}
+	s.samples = kept
+}
+
+// sweep forgets the series not written since before.
+func (sh *shard) sweep(before time.Time) {
+	for key, s := range sh.series {
+		if s.written.Before(before) {
+			delete(sh.series, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package dedup
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func samples(minutes ...int64) []prompb.Sample {
+	s := make([]prompb.Sample, len(minutes))
+	for i, m := range minutes {
+		s[i] = prompb.Sample{Timestamp: m * time.Minute.Milliseconds(), Value: float64(m)}
+	}
+	return s
+}
+
+func TestFilter(t *testing.T) {
+	d := New(&Config{Window: 5 * time.Minute})
+	dropped := testutil.ToFloat64(droppedSamples)
+
+	require.Equal(t, samples(1, 2), d.Filter("a", samples(1, 2)))
+	// The second writer of the series only adds the new sample.
+	require.Equal(t, samples(3), d.Filter("a", samples(2, 3)))
+	// The same samples of another series are kept.
+	require.Equal(t, samples(2, 3), d.Filter("b", samples(2, 3)))
+	// A sample with another value is not a duplicate.
+	other := []prompb.Sample{{Timestamp: samples(3)[0].Timestamp, Value: 42}}
+	require.Equal(t, other, d.Filter("a", other))
+	// Stale markers are duplicates of each other.
+	stale := []prompb.Sample{{Timestamp: 10, Value: math.Float64frombits(value.StaleNaN)}}
+	require.Len(t, d.Filter("c", stale), 1)
+	require.Empty(t, d.Filter("c", stale))
+	require.Equal(t, dropped+2, testutil.ToFloat64(droppedSamples))
+
+	// The samples older than the window before the latest sample are
+	// forgotten.
+	require.Equal(t, samples(10), d.Filter("a", samples(10)))
+	require.Equal(t, samples(1), d.Filter("a", samples(1)))
+
+	var nilDedup *Deduplicator
+	require.Nil(t, New(&Config{}))
+	require.Equal(t, samples(1), nilDedup.Filter("a", samples(1)))
+}
+
+func TestForget(t *testing.T) {
+	d := New(&Config{Window: 5 * time.Minute})
+	require.Equal(t, samples(1, 2, 3), d.Filter("a", samples(1, 2, 3)))
+	// The samples that failed to be inserted are kept when written again.
+	d.Forget("a", samples(2, 3))
+	require.Equal(t, samples(2, 3), d.Filter("a", samples(1, 2, 3)))
+	require.Empty(t, d.Filter("a", samples(1, 2, 3)))
+
+	// A series without samples is forgotten.
+	d.Forget("a", samples(1, 2, 3))
+	for i := range d.shards {
+		require.Empty(t, d.shards[i].series)
+	}
+	d.Forget("b", samples(1))
+
+	var nilDedup *Deduplicator
+	nilDedup.Forget("a", samples(1))
+}
+
+func TestSweep(t *testing.T) {
+	d := New(&Config{Window: time.Minute})
+	d.Filter("a", samples(1))
+	sh := &d.shards[0]
+	for i := range d.shards {
+		if len(d.shards[i].series) > 0 {
+			sh = &d.shards[i]
+		}
+	}
+	sh.sweep(time.Now().Add(-time.Minute))
+	require.Len(t, sh.series, 1)
+	sh.sweep(time.Now().Add(time.Second))
+	require.Empty(t, sh.series)
+}
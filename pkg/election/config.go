@@ -0,0 +1,85 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package election
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	BackendNone       = "none"
+	BackendPostgres   = "postgres"
+	BackendKubernetes = "kubernetes"
+
+	// DefaultLockID is the advisory lock held by the leader with the
+	// postgres backend. Chosen randomly.
+	DefaultLockID = 0x2C0F6E4B91D7A35E
+
+	defaultLeaseName     = "promscale-leader"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRetryPeriod   = 5 * time.Second
+)
+
+// Config holds the leader election flags.
+type Config struct {
+	Backend       string
+	Identity      string
+	LeaseDuration time.Duration
+	RetryPeriod   time.Duration
+
+	LockID int64
+
+	LeaseName      string
+	LeaseNamespace string
+}
+
+// ParseFlags registers the leader election flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Backend, "election.backend", BackendNone, "Leader election backend used to run the rules evaluation and the maintenance "+
+		"tasks (vacuum engine, label compaction) on a single one of several Promscale instances. "+
+		"Valid values: none (every instance runs them), postgres (advisory lock), kubernetes (Lease object).")
+	fs.StringVar(&cfg.Identity, "election.identity", "", "Identity of this instance in the leader election. Defaults to the hostname.")
+	fs.DurationVar(&cfg.LeaseDuration, "election.lease-duration", defaultLeaseDuration, "Duration after which the leadership of an instance "+
+		"that stopped renewing it can be taken over, with the kubernetes backend.")
+	fs.DurationVar(&cfg.RetryPeriod, "election.retry-period", defaultRetryPeriod, "How often the leadership is acquired or renewed.")
+	fs.Int64Var(&cfg.LockID, "election.postgres.lock-id", DefaultLockID, "Advisory lock held by the leader with the postgres backend. "+
+		"Instances sharing a database but electing different leaders must use different values.")
+	fs.StringVar(&cfg.LeaseName, "election.kubernetes.lease-name", defaultLeaseName, "Name of the Lease object held by the leader with the kubernetes backend.")
+	fs.StringVar(&cfg.LeaseNamespace, "election.kubernetes.lease-namespace", "", "Namespace of the Lease object. Defaults to the namespace of the pod.")
+	return cfg
+}
+
+// Validate checks the leader election flags and sets the default identity.
+func Validate(cfg *Config) error {
+	switch cfg.Backend {
+	case BackendNone:
+		return nil
+	case BackendPostgres, BackendKubernetes:
+	default:
+		return fmt.Errorf("invalid election.backend %q: valid values are none, postgres and kubernetes", cfg.Backend)
+	}
+	if cfg.RetryPeriod <= 0 {
+		return fmt.Errorf("election.retry-period must be positive: %s", cfg.RetryPeriod)
+	}
+	if cfg.Backend == BackendKubernetes {
+		if cfg.LeaseDuration <= cfg.RetryPeriod {
+			return fmt.Errorf("election.lease-duration (%s) must be greater than election.retry-period (%s)", cfg.LeaseDuration, cfg.RetryPeriod)
+		}
+		if cfg.LeaseName == "" {
+			return fmt.Errorf("election.kubernetes.lease-name must not be empty")
+		}
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("election.identity is not set and the hostname is unknown: %w", err)
+		}
+		cfg.Identity = hostname
+	}
+	return nil
+}
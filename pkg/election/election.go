@@ -0,0 +1,151 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package election elects a leader among several Promscale instances, which
+// runs the tasks that must not run concurrently, like the rules evaluation.
+package election
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	isLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "election",
+			Name:      "leader",
+			Help:      "Whether this instance is the leader.",
+		},
+	)
+	transitions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "election",
+			Name:      "transitions_total",
+			Help:      "Number of times this instance became the leader or lost the leadership.",
+		},
+	)
+	failures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "election",
+			Name:      "errors_total",
+			Help:      "Number of failed attempts to acquire or renew the leadership.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(isLeader, transitions, failures)
+}
+
+// Backend acquires the leadership for an instance.
+type Backend interface {
+	// TryAcquire acquires the leadership, or renews it if it is already
+	// held, and returns whether it is held.
+	TryAcquire(ctx context.Context) (bool, error)
+	// Release gives up the leadership if it is held.
+	Release(ctx context.Context) error
+}
+
+// Elector tracks whether this instance is the leader. A nil Elector is
+// always the leader, so that a single instance runs everything.
+type Elector struct {
+	backend     Backend
+	retryPeriod time.Duration
+	leader      atomic.Bool
+}
+
+// New returns the Elector using the configured backend, or nil if the
+// backend is none. connStr is the database connection string of the
+// postgres backend.
+func New(cfg Config, connStr string) (*Elector, error) {
+	var (
+		backend Backend
+		err     error
+	)
+	switch cfg.Backend {
+	case BackendNone:
+		return nil, nil
+	case BackendPostgres:
+		backend, err = newPostgresBackend(cfg, connStr)
+	case BackendKubernetes:
+		backend, err = newKubernetesBackend(cfg)
+	default:
+		err = fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s leader election backend: %w", cfg.Backend, err)
+	}
+	return newElector(backend, cfg.RetryPeriod), nil
+}
+
+func newElector(backend Backend, retryPeriod time.Duration) *Elector {
+	return &Elector{backend: backend, retryPeriod: retryPeriod}
+}
+
+// IsLeader returns whether this instance is the leader.
+func (e *Elector) IsLeader() bool {
+	return e == nil || e.leader.Load()
+}
+
+// Run acquires or renews the leadership every retry period until ctx is
+// done, then releases it.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+	for {
+		e.update(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) update(ctx context.Context) {
+	leader, err := e.backend.TryAcquire(ctx)
+	if err != nil {
+		failures.Inc()
+		log.Warn("msg", "failed to acquire the leadership", "err", err)
+		leader = false
+	}
+	e.setLeader(leader)
+}
+
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	transitions.Inc()
+	if leader {
+		isLeader.Set(1)
+		log.Info("msg", "this instance became the leader")
+	} else {
+		isLeader.Set(0)
+		log.Info("msg", "this instance lost the leadership")
+	}
+}
+
+func (e *Elector) release() {
+	// The context of Run is done, the leadership is released within a
+	// retry period so that another instance can take over right away.
+	ctx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+	defer cancel()
+	e.setLeader(false)
+	if err := e.backend.Release(ctx); err != nil {
+		log.Warn("msg", "failed to release the leadership", "err", err)
+	}
+}
@@ -0,0 +1,70 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockBackend struct {
+	held     bool
+	err      error
+	released bool
+}
+
+func (b *mockBackend) TryAcquire(context.Context) (bool, error) {
+	return b.held, b.err
+}
+
+func (b *mockBackend) Release(context.Context) error {
+	b.released = true
+	return nil
+}
+
+func TestElector(t *testing.T) {
+	var nilElector *Elector
+	require.True(t, nilElector.IsLeader())
+
+	backend := &mockBackend{}
+	e := newElector(backend, time.Second)
+	ctx := context.Background()
+
+	e.update(ctx)
+	require.False(t, e.IsLeader())
+	backend.held = true
+	e.update(ctx)
+	require.True(t, e.IsLeader())
+	// The leadership is lost if it cannot be renewed.
+	backend.err = fmt.Errorf("connection refused")
+	e.update(ctx)
+	require.False(t, e.IsLeader())
+
+	backend.err = nil
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	e.Run(ctx)
+	require.False(t, e.IsLeader())
+	require.True(t, backend.released)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{Backend: BackendNone}
+	require.NoError(t, Validate(&cfg))
+
+	cfg = Config{Backend: "zookeeper"}
+	require.Error(t, Validate(&cfg))
+
+	cfg = Config{Backend: BackendKubernetes, LeaseName: "promscale-leader", LeaseDuration: time.Second, RetryPeriod: 2 * time.Second}
+	require.Error(t, Validate(&cfg))
+
+	cfg = Config{Backend: BackendPostgres, RetryPeriod: time.Second}
+	require.NoError(t, Validate(&cfg))
+	require.NotEmpty(t, cfg.Identity)
+}
@@ -0,0 +1,236 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package election
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// microTimeFormat is the format of the MicroTime fields of the Lease.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// lease is a coordination.k8s.io/v1 Lease object.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int32  `json:"leaseTransitions,omitempty"`
+}
+
+// kubernetesBackend elects the holder of a Lease object, with the same
+// protocol as the leader election of client-go. The API server is reached
+// with the service account of the pod.
+type kubernetesBackend struct {
+	client        *http.Client
+	leasesURL     string
+	namespace     string
+	name          string
+	identity      string
+	tokenFile     string
+	leaseDuration time.Duration
+	now           func() time.Time
+
+	// observed is the last seen spec of the Lease and observedAt the local
+	// time it was seen changing. A Lease expires a lease duration after
+	// observedAt, so that the clocks of the instances do not have to agree.
+	observed   leaseSpec
+	observedAt time.Time
+}
+
+func newKubernetesBackend(cfg Config) (*kubernetesBackend, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading the service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in the service account CA")
+	}
+	namespace := cfg.LeaseNamespace
+	if namespace == "" {
+		ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("election.kubernetes.lease-namespace is not set and the pod namespace is unknown: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		Timeout:   cfg.RetryPeriod,
+	}
+	return &kubernetesBackend{
+		client:        client,
+		leasesURL:     fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", net.JoinHostPort(host, port), namespace),
+		namespace:     namespace,
+		name:          cfg.LeaseName,
+		identity:      cfg.Identity,
+		tokenFile:     filepath.Join(serviceAccountDir, "token"),
+		leaseDuration: cfg.LeaseDuration,
+		now:           time.Now,
+	}, nil
+}
+
+func (b *kubernetesBackend) TryAcquire(ctx context.Context) (bool, error) {
+	l, found, err := b.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := b.now()
+	if !found {
+		l = &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: b.name, Namespace: b.namespace},
+		}
+		b.take(l, now)
+		return b.write(ctx, http.MethodPost, b.leasesURL, l)
+	}
+
+	if l.Spec != b.observed {
+		b.observed, b.observedAt = l.Spec, now
+	}
+	if l.Spec.HolderIdentity == b.identity {
+		l.Spec.RenewTime = now.Format(microTimeFormat)
+	} else {
+		duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+		if l.Spec.HolderIdentity != "" && now.Before(b.observedAt.Add(duration)) {
+			return false, nil
+		}
+		b.take(l, now)
+	}
+	return b.write(ctx, http.MethodPut, b.leasesURL+"/"+b.name, l)
+}
+
+func (b *kubernetesBackend) Release(ctx context.Context) error {
+	l, found, err := b.get(ctx)
+	if err != nil || !found || l.Spec.HolderIdentity != b.identity {
+		return err
+	}
+	// As client-go, the Lease is kept with no holder and a short duration.
+	l.Spec.HolderIdentity = ""
+	l.Spec.LeaseDurationSeconds = 1
+	l.Spec.RenewTime = b.now().Format(microTimeFormat)
+	_, err = b.write(ctx, http.MethodPut, b.leasesURL+"/"+b.name, l)
+	return err
+}
+
+// take makes this instance the holder of l.
+func (b *kubernetesBackend) take(l *lease, now time.Time) {
+	if l.Spec.HolderIdentity != "" {
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.HolderIdentity = b.identity
+	l.Spec.LeaseDurationSeconds = int32(b.leaseDuration / time.Second)
+	l.Spec.AcquireTime = now.Format(microTimeFormat)
+	l.Spec.RenewTime = l.Spec.AcquireTime
+}
+
+func (b *kubernetesBackend) get(ctx context.Context) (*lease, bool, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.leasesURL+"/"+b.name, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, statusError(resp)
+	}
+	l := new(lease)
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
+		return nil, false, fmt.Errorf("decoding the Lease: %w", err)
+	}
+	return l, true, nil
+}
+
+// write creates or updates l and returns whether it was written. The write
+// fails with a conflict if another instance updated the Lease since it was
+// read.
+func (b *kubernetesBackend) write(ctx context.Context, method, url string, l *lease) (bool, error) {
+	resp, err := b.do(ctx, method, url, l)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, statusError(resp)
+	}
+	written := new(lease)
+	if err := json.NewDecoder(resp.Body).Decode(written); err != nil {
+		return false, fmt.Errorf("decoding the Lease: %w", err)
+	}
+	b.observed, b.observedAt = written.Spec, b.now()
+	return written.Spec.HolderIdentity == b.identity, nil
+}
+
+func (b *kubernetesBackend) do(ctx context.Context, method, url string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	// The token is read on each request since it is rotated.
+	token, err := os.ReadFile(b.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return b.client.Do(req)
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected response from the Kubernetes API: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
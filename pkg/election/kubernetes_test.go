@@ -0,0 +1,112 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// leaseServer serves a single Lease with the optimistic concurrency of the
+// Kubernetes API.
+type leaseServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (s *leaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet {
+		if s.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.lease)
+		return
+	}
+
+	var l lease
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	exists := s.lease != nil
+	if (r.Method == http.MethodPost && exists) ||
+		(r.Method == http.MethodPut && (!exists || l.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion)) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.version++
+	l.Metadata.ResourceVersion = strconv.Itoa(s.version)
+	s.lease = &l
+	_ = json.NewEncoder(w).Encode(s.lease)
+}
+
+func TestKubernetesBackend(t *testing.T) {
+	server := &leaseServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0600))
+
+	now := time.Unix(1000, 0)
+	newBackend := func(identity string) *kubernetesBackend {
+		return &kubernetesBackend{
+			client:        ts.Client(),
+			leasesURL:     ts.URL + "/apis/coordination.k8s.io/v1/namespaces/default/leases",
+			namespace:     "default",
+			name:          "promscale-leader",
+			identity:      identity,
+			tokenFile:     tokenFile,
+			leaseDuration: 15 * time.Second,
+			now:           func() time.Time { return now },
+		}
+	}
+	a, b := newBackend("a"), newBackend("b")
+	ctx := context.Background()
+
+	acquire := func(backend *kubernetesBackend, expected bool) {
+		t.Helper()
+		held, err := backend.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, held)
+	}
+
+	acquire(a, true)
+	acquire(b, false)
+	now = now.Add(10 * time.Second)
+	acquire(a, true)
+	acquire(b, false)
+
+	// b takes over once a stopped renewing the Lease for a lease duration.
+	now = now.Add(10 * time.Second)
+	acquire(b, false)
+	now = now.Add(16 * time.Second)
+	acquire(b, true)
+	acquire(a, false)
+	require.Equal(t, int32(1), server.lease.Spec.LeaseTransitions)
+
+	// a takes over right away once b released the Lease.
+	require.NoError(t, b.Release(ctx))
+	acquire(a, true)
+	acquire(b, false)
+}
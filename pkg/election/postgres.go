@@ -0,0 +1,65 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package election
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// postgresBackend elects the instance holding a session advisory lock on a
+// dedicated connection. The lock is released by the database if the
+// connection of the leader breaks.
+type postgresBackend struct {
+	lock *util.PgAdvisoryLock
+	held bool
+}
+
+func newPostgresBackend(cfg Config, connStr string) (*postgresBackend, error) {
+	lock, err := util.NewPgAdvisoryLock(cfg.LockID, connStr)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresBackend{lock: lock}, nil
+}
+
+func (b *postgresBackend) TryAcquire(ctx context.Context) (bool, error) {
+	if b.held {
+		// Session advisory locks are reentrant, taking the lock again would
+		// require as many unlocks. The lock is held while the connection
+		// is alive.
+		conn, err := b.lock.Conn()
+		if err == nil {
+			err = conn.Ping(ctx)
+		}
+		if err != nil {
+			b.held = false
+			b.lock.Close()
+			return false, fmt.Errorf("lost the connection holding the lock: %w", err)
+		}
+		return true, nil
+	}
+	held, err := b.lock.GetAdvisoryLock()
+	if err != nil {
+		b.lock.Close()
+		return false, err
+	}
+	b.held = held
+	return held, nil
+}
+
+func (b *postgresBackend) Release(_ context.Context) error {
+	defer b.lock.Close()
+	if !b.held {
+		return nil
+	}
+	b.held = false
+	if _, err := b.lock.Unlock(); err != nil {
+		return err
+	}
+	return nil
+}
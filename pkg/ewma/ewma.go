@@ -42,11 +42,12 @@ func (r *Rate) Rate() float64 {
 // Tick assumes to be called every r.interval.
 func (r *Rate) Tick() {
 	newEvents := atomic.SwapInt64(&r.newEvents, 0)
-	instantRate := float64(newEvents) / r.interval.Seconds()
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	instantRate := float64(newEvents) / r.interval.Seconds()
+
 	if r.init {
 		r.lastRate += r.alpha * (instantRate - r.lastRate)
 	} else if newEvents > 0 {
@@ -55,6 +56,13 @@ func (r *Rate) Tick() {
 	}
 }
 
+// SetInterval changes the interval at which Tick is called.
+func (r *Rate) SetInterval(interval time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.interval = interval
+}
+
 // Incr counts incr events.
 func (r *Rate) Incr(incr int64) {
 	atomic.AddInt64(&r.newEvents, incr)
@@ -0,0 +1,44 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package export
+
+import (
+	"flag"
+	"fmt"
+)
+
+const defaultDir = "exports"
+
+// Config holds the flags of the exports.
+type Config struct {
+	Dir        string
+	S3Bucket   string
+	S3Prefix   string
+	S3Region   string
+	S3Endpoint string
+}
+
+// ParseFlags registers the export flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Dir, "metrics.export.dir", defaultDir, "Directory where the exports of the /api/v1/admin/tsdb/export endpoint are written. "+
+		"Exports uploaded to S3 are staged in a temporary directory instead.")
+	fs.StringVar(&cfg.S3Bucket, "metrics.export.s3.bucket", "", "S3 bucket the exports are uploaded to when requested with destination=s3. "+
+		"The credentials are read from the environment, the shared credentials file or the instance role.")
+	fs.StringVar(&cfg.S3Prefix, "metrics.export.s3.prefix", "", "Prefix of the keys of the exports uploaded to S3.")
+	fs.StringVar(&cfg.S3Region, "metrics.export.s3.region", "", "Region of the S3 bucket. Defaults to the region configured in the environment.")
+	fs.StringVar(&cfg.S3Endpoint, "metrics.export.s3.endpoint", "", "Endpoint of an S3 compatible object store, e.g. MinIO. Path-style addressing is used when set.")
+	return cfg
+}
+
+// Validate checks the export flags.
+func Validate(cfg *Config) error {
+	if cfg.Dir == "" {
+		return fmt.Errorf("metrics.export.dir must not be empty")
+	}
+	if cfg.S3Bucket == "" && (cfg.S3Prefix != "" || cfg.S3Region != "" || cfg.S3Endpoint != "") {
+		return fmt.Errorf("metrics.export.s3.bucket is required to configure exports to S3")
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package export extracts a time range of metrics from the database as
+// Prometheus TSDB blocks or OpenMetrics text, so that the data can be moved
+// back to Prometheus, Thanos or Mimir.
+package export
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// Format is the format of an export.
+type Format string
+
+const (
+	FormatTSDB        Format = "tsdb"
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+// Destination is where an export is written.
+type Destination string
+
+const (
+	DestinationLocal Destination = "local"
+	DestinationS3    Destination = "s3"
+)
+
+// openMetricsFile is the name of the file of OpenMetrics exports.
+const openMetricsFile = "metrics.om"
+
+// Request selects the data to export.
+type Request struct {
+	MatcherSets [][]*labels.Matcher
+	// Start and End are inclusive timestamps in milliseconds.
+	Start       int64
+	End         int64
+	Format      Format
+	Destination Destination
+}
+
+// Result describes a finished export.
+type Result struct {
+	Name        string      `json:"name"`
+	Format      Format      `json:"format"`
+	Destination Destination `json:"destination"`
+	// Location is the local directory or the S3 URL of the export.
+	Location string   `json:"location"`
+	Blocks   []string `json:"blocks,omitempty"`
+	Series   int      `json:"series"`
+	Samples  int      `json:"samples"`
+}
+
+// ParseFormat returns the format with the given name, tsdb by default.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return FormatTSDB, nil
+	case FormatTSDB, FormatOpenMetrics:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, must be %s or %s", s, FormatTSDB, FormatOpenMetrics)
+	}
+}
+
+// ParseDestination returns the destination with the given name, local by
+// default.
+func ParseDestination(s string) (Destination, error) {
+	switch d := Destination(s); d {
+	case "":
+		return DestinationLocal, nil
+	case DestinationLocal, DestinationS3:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown destination %q, must be %s or %s", s, DestinationLocal, DestinationS3)
+	}
+}
+
+// Exporter writes exports of the data read from a queryable.
+type Exporter struct {
+	cfg       Config
+	queryable promql.Queryable
+}
+
+// NewExporter returns an Exporter.
+func NewExporter(cfg Config, queryable promql.Queryable) *Exporter {
+	return &Exporter{cfg: cfg, queryable: queryable}
+}
+
+// Export writes the data selected by the request to a new export. The name
+// of the export is a timestamp followed by a random suffix, like the
+// Prometheus snapshots.
+func (e *Exporter) Export(ctx context.Context, req Request) (*Result, error) {
+	if req.Destination == DestinationS3 && e.cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("exports to S3 require the -metrics.export.s3.bucket flag")
+	}
+	res := &Result{
+		Name:        time.Now().UTC().Format("20060102T150405Z0700") + "-" + fmt.Sprintf("%x", rand.Int63()),
+		Format:      req.Format,
+		Destination: req.Destination,
+	}
+
+	var dir string
+	if req.Destination == DestinationS3 {
+		staging, err := os.MkdirTemp("", "promscale-export-")
+		if err != nil {
+			return nil, fmt.Errorf("creating staging directory: %w", err)
+		}
+		defer os.RemoveAll(staging)
+		dir = filepath.Join(staging, res.Name)
+	} else {
+		dir = filepath.Join(e.cfg.Dir, res.Name)
+	}
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("creating export directory: %w", err)
+	}
+
+	var err error
+	switch req.Format {
+	case FormatOpenMetrics:
+		err = e.exportOpenMetrics(ctx, req, dir, res)
+	default:
+		err = e.exportBlocks(ctx, req, dir, res)
+	}
+	if err != nil {
+		if req.Destination == DestinationLocal {
+			if rmErr := os.RemoveAll(dir); rmErr != nil {
+				log.Warn("msg", "failed to remove incomplete export", "dir", dir, "err", rmErr)
+			}
+		}
+		return nil, err
+	}
+
+	res.Location = dir
+	if req.Destination == DestinationS3 {
+		if res.Location, err = uploadDir(ctx, e.cfg, dir, res.Name); err != nil {
+			return nil, fmt.Errorf("uploading export: %w", err)
+		}
+	}
+	log.Info("msg", "Exported metrics", "name", res.Name, "format", res.Format, "location", res.Location, "series", res.Series, "samples", res.Samples)
+	return res, nil
+}
+
+func (e *Exporter) exportOpenMetrics(ctx context.Context, req Request, dir string, res *Result) error {
+	q, err := e.queryable.SamplesQuerier(ctx, req.Start, req.End)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	series, err := selectSeries(q, req.MatcherSets)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, openMetricsFile))
+	if err != nil {
+		return fmt.Errorf("creating export file: %w", err)
+	}
+	defer f.Close()
+	res.Series = len(series)
+	if res.Samples, err = writeOpenMetrics(f, series, req.Start, req.End); err != nil {
+		return fmt.Errorf("writing OpenMetrics: %w", err)
+	}
+	return f.Close()
+}
+
+// selectSeries returns the series matching any of the matcher sets, sorted by
+// labels. The querier does not sort the series it returns, so the series
+// matched by several matcher sets are merged here.
+func selectSeries(q promql.SamplesQuerier, matcherSets [][]*labels.Matcher) ([]storage.Series, error) {
+	sets := make([]storage.SeriesSet, 0, len(matcherSets))
+	for _, matchers := range matcherSets {
+		s, _ := q.Select(true, nil, nil, nil, matchers...)
+		if s.Err() != nil {
+			return nil, s.Err()
+		}
+		sets = append(sets, s)
+	}
+	set := storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
+	var series []storage.Series
+	for set.Next() {
+		series = append(series, set.At())
+	}
+	if set.Err() != nil {
+		return nil, set.Err()
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(series[i].Labels(), series[j].Labels()) < 0
+	})
+	merged := series[:0]
+	for _, s := range series {
+		if n := len(merged); n > 0 && labels.Equal(merged[n-1].Labels(), s.Labels()) {
+			merged[n-1] = storage.ChainedSeriesMerge(merged[n-1], s)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged, nil
+}
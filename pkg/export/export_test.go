@@ -0,0 +1,95 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package export
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	f, err := ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, FormatTSDB, f)
+	f, err = ParseFormat("openmetrics")
+	require.NoError(t, err)
+	require.Equal(t, FormatOpenMetrics, f)
+	_, err = ParseFormat("csv")
+	require.Error(t, err)
+
+	d, err := ParseDestination("")
+	require.NoError(t, err)
+	require.Equal(t, DestinationLocal, d)
+	d, err = ParseDestination("s3")
+	require.NoError(t, err)
+	require.Equal(t, DestinationS3, d)
+	_, err = ParseDestination("gcs")
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{Dir: defaultDir}))
+	require.NoError(t, Validate(&Config{Dir: defaultDir, S3Bucket: "b", S3Region: "eu-west-1"}))
+	require.Error(t, Validate(&Config{}))
+	require.Error(t, Validate(&Config{Dir: defaultDir, S3Prefix: "exports"}))
+}
+
+func TestBlockRanges(t *testing.T) {
+	testCases := []struct {
+		name       string
+		start, end int64
+		expected   []timeRange
+	}{
+		{name: "end before start", start: 10, end: 5},
+		{name: "single range", start: 1, end: 5, expected: []timeRange{{1, 5}}},
+		{name: "aligned", start: 0, end: 19, expected: []timeRange{{0, 9}, {10, 19}}},
+		{name: "unaligned", start: 5, end: 25, expected: []timeRange{{5, 9}, {10, 19}, {20, 25}}},
+		{name: "negative", start: -15, end: 3, expected: []timeRange{{-15, -11}, {-10, -1}, {0, 3}}},
+		{name: "largest timestamp", start: math.MaxInt64 - 3, end: math.MaxInt64, expected: []timeRange{{math.MaxInt64 - 3, math.MaxInt64}}},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.expected, blockRanges(c.start, c.end, 10))
+		})
+	}
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestWriteOpenMetrics(t *testing.T) {
+	series := []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "http_requests_total", "code", "200"),
+			[]tsdbutil.Sample{sample{1000, 1}, sample{2500, 2.5}, sample{4000, 3}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "http_requests_total", "code", "500"),
+			[]tsdbutil.Sample{sample{1000, math.Float64frombits(value.StaleNaN)}, sample{2000, math.Inf(1)}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "path", "a\"b\\c\nd"),
+			[]tsdbutil.Sample{sample{1000, 0}}),
+	}
+	var buf bytes.Buffer
+	samples, err := writeOpenMetrics(&buf, series, 0, 3000)
+	require.NoError(t, err)
+	require.Equal(t, 4, samples)
+	require.Equal(t, `# TYPE http_requests_total unknown
+http_requests_total{code="200"} 1 1
+http_requests_total{code="200"} 2.5 2.5
+http_requests_total{code="500"} +Inf 2
+# TYPE up unknown
+up{path="a\"b\\c\nd"} 0 1
+# EOF
+`, buf.String())
+}
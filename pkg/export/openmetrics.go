@@ -0,0 +1,104 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package export
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeOpenMetrics writes the samples of the series between start and end as
+// OpenMetrics text, with the timestamp of every sample, and returns the
+// number of samples written. The series must be sorted by labels so that the
+// series of a metric family are written together. The type of the metrics is
+// not stored, so every family is of unknown type. Stale markers are skipped.
+func writeOpenMetrics(w io.Writer, series []storage.Series, start, end int64) (int, error) {
+	bw := bufio.NewWriter(w)
+	samples := 0
+	family := ""
+	for i, s := range series {
+		lset := s.Labels()
+		name := lset.Get(labels.MetricName)
+		if i == 0 || name != family {
+			family = name
+			bw.WriteString("# TYPE ")
+			bw.WriteString(name)
+			bw.WriteString(" unknown\n")
+		}
+		prefix := seriesPrefix(lset)
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < start || t > end || value.IsStaleNaN(v) {
+				continue
+			}
+			bw.WriteString(prefix)
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(v))
+			bw.WriteByte(' ')
+			bw.WriteString(formatTimestamp(t))
+			bw.WriteByte('\n')
+			samples++
+		}
+		if err := it.Err(); err != nil {
+			return samples, err
+		}
+	}
+	bw.WriteString("# EOF\n")
+	return samples, bw.Flush()
+}
+
+// seriesPrefix returns the metric name and the labels of a sample line.
+func seriesPrefix(lset labels.Labels) string {
+	var b strings.Builder
+	b.WriteString(lset.Get(labels.MetricName))
+	first := true
+	for _, l := range lset {
+		if l.Name == labels.MetricName {
+			continue
+		}
+		if first {
+			b.WriteByte('{')
+			first = false
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	if !first {
+		b.WriteByte('}')
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// formatTimestamp formats a timestamp in milliseconds as seconds.
+func formatTimestamp(t int64) string {
+	return strconv.FormatFloat(float64(t)/1000, 'f', -1, 64)
+}
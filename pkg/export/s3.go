@@ -0,0 +1,66 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// uploadDir uploads the files of dir under <prefix>/<name>/ in the bucket and
+// returns the URL of the export.
+func uploadDir(ctx context.Context, cfg Config, dir, name string) (string, error) {
+	awsCfg := aws.NewConfig()
+	if cfg.S3Region != "" {
+		awsCfg = awsCfg.WithRegion(cfg.S3Region)
+	}
+	if cfg.S3Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.S3Endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsCfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating S3 session: %w", err)
+	}
+	uploader := s3manager.NewUploader(sess)
+
+	root := path.Join(cfg.S3Prefix, name)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		key := path.Join(root, filepath.ToSlash(rel))
+		if _, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(cfg.S3Bucket),
+			Key:    aws.String(key),
+			Body:   f,
+		}); err != nil {
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", cfg.S3Bucket, root), nil
+}
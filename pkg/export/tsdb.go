@@ -0,0 +1,147 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// commitEvery is the number of samples appended to a block before they are
+// committed to its head.
+const commitEvery = 5000
+
+// exportBlocks writes one TSDB block per block range with data, aligned like
+// the blocks of Prometheus so that they can be compacted with its own blocks.
+// Each range is queried separately to bound the memory used.
+func (e *Exporter) exportBlocks(ctx context.Context, req Request, dir string, res *Result) error {
+	seen := make(map[uint64]struct{})
+	for _, r := range blockRanges(req.Start, req.End, tsdb.DefaultBlockDuration) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, samples, err := e.writeBlock(ctx, req, dir, r, seen)
+		if err != nil {
+			return fmt.Errorf("writing block [%d, %d]: %w", r.mint, r.maxt, err)
+		}
+		if samples == 0 {
+			continue
+		}
+		res.Blocks = append(res.Blocks, id)
+		res.Samples += samples
+	}
+	res.Series = len(seen)
+	return nil
+}
+
+func (e *Exporter) writeBlock(ctx context.Context, req Request, dir string, r timeRange, seen map[uint64]struct{}) (string, int, error) {
+	q, err := e.queryable.SamplesQuerier(ctx, r.mint, r.maxt)
+	if err != nil {
+		return "", 0, err
+	}
+	defer q.Close()
+	series, err := selectSeries(q, req.MatcherSets)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(series) == 0 {
+		return "", 0, nil
+	}
+
+	w, err := tsdb.NewBlockWriter(log.GetLogger(), dir, tsdb.DefaultBlockDuration)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err := w.Close(); err != nil {
+			log.Warn("msg", "failed to close block writer", "err", err)
+		}
+	}()
+
+	samples := 0
+	app := w.Appender(ctx)
+	for _, s := range series {
+		lset := s.Labels()
+		var ref storage.SeriesRef
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < r.mint || t > r.maxt {
+				continue
+			}
+			if ref, err = app.Append(ref, lset, t, v); err != nil {
+				return "", 0, err
+			}
+			samples++
+			if samples%commitEvery == 0 {
+				if err = app.Commit(); err != nil {
+					return "", 0, err
+				}
+				app = w.Appender(ctx)
+			}
+		}
+		if err = it.Err(); err != nil {
+			return "", 0, err
+		}
+		if ref != 0 {
+			seen[lset.Hash()] = struct{}{}
+		}
+	}
+	if err = app.Commit(); err != nil {
+		return "", 0, err
+	}
+	if samples == 0 {
+		return "", 0, nil
+	}
+	id, err := w.Flush(ctx)
+	if errors.Is(err, tsdb.ErrNoSeriesAppended) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return id.String(), samples, nil
+}
+
+type timeRange struct {
+	mint, maxt int64
+}
+
+// blockRanges splits [start, end] into inclusive ranges aligned on multiples
+// of blockSize.
+func blockRanges(start, end, blockSize int64) []timeRange {
+	if end < start {
+		return nil
+	}
+	var ranges []timeRange
+	for mint := start; mint <= end; {
+		next := mint - mod(mint, blockSize) + blockSize
+		maxt := next - 1
+		if maxt > end || next < mint {
+			maxt = end
+		}
+		ranges = append(ranges, timeRange{mint: mint, maxt: maxt})
+		if next < mint {
+			// Overflow past the largest timestamp.
+			break
+		}
+		mint = next
+	}
+	return ranges
+}
+
+// mod returns the non-negative remainder of a divided by b.
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
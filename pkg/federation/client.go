@@ -0,0 +1,179 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// FederatedHeader marks the requests sent to the federated instances, so that
+// they answer from their own database only and federation cannot loop.
+const FederatedHeader = "X-Promscale-Federated"
+
+// maxErrorMessageLen is the length of the response body kept in the errors.
+const maxErrorMessageLen = 256
+
+// client reads the series and labels of a federated Promscale instance.
+type client struct {
+	base *url.URL
+	// name is the URL of the instance without credentials.
+	name string
+	http *http.Client
+}
+
+func newClient(endpoint string, httpClient *http.Client) (*client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &client{base: u, name: u.Redacted(), http: httpClient}, nil
+}
+
+// read returns the series matching the matchers between mint and maxt with the
+// remote read API.
+func (c *client) read(ctx context.Context, mint, maxt int64, matchers []*labels.Matcher) ([]*prompb.TimeSeries, error) {
+	q := &prompb.Query{StartTimestampMs: mint, EndTimestampMs: maxt}
+	for _, m := range matchers {
+		pm := &prompb.LabelMatcher{Name: m.Name, Value: m.Value}
+		switch m.Type {
+		case labels.MatchEqual:
+			pm.Type = prompb.LabelMatcher_EQ
+		case labels.MatchNotEqual:
+			pm.Type = prompb.LabelMatcher_NEQ
+		case labels.MatchRegexp:
+			pm.Type = prompb.LabelMatcher_RE
+		case labels.MatchNotRegexp:
+			pm.Type = prompb.LabelMatcher_NRE
+		default:
+			return nil, fmt.Errorf("unknown matcher type %v", m.Type)
+		}
+		q.Matchers = append(q.Matchers, pm)
+	}
+	data, err := proto.Marshal(&prompb.ReadRequest{Queries: []*prompb.Query{q}})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/read", nil), bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding read response: %w", err)
+	}
+	var resp prompb.ReadResponse
+	if err = proto.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decoding read response: %w", err)
+	}
+	if len(resp.Results) != 1 {
+		return nil, fmt.Errorf("read response has %d results, expected 1", len(resp.Results))
+	}
+	return resp.Results[0].Timeseries, nil
+}
+
+// labelNames returns the label names with the labels API.
+func (c *client) labelNames(ctx context.Context, mint, maxt int64) ([]string, []string, error) {
+	return c.getStrings(ctx, "/api/v1/labels", mint, maxt)
+}
+
+// labelValues returns the values of the label with the label values API.
+func (c *client) labelValues(ctx context.Context, name string, mint, maxt int64) ([]string, []string, error) {
+	return c.getStrings(ctx, "/api/v1/label/"+url.PathEscape(name)+"/values", mint, maxt)
+}
+
+// apiResponse is the envelope of the Prometheus API responses.
+type apiResponse struct {
+	Status   string   `json:"status"`
+	Data     []string `json:"data"`
+	Error    string   `json:"error"`
+	Warnings []string `json:"warnings"`
+}
+
+func (c *client) getStrings(ctx context.Context, path string, mint, maxt int64) ([]string, []string, error) {
+	params := url.Values{}
+	if mint != math.MinInt64 {
+		params.Set("start", formatTime(mint))
+	}
+	if maxt != math.MaxInt64 {
+		params.Set("end", formatTime(maxt))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, params), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The labels APIs answer with 204 and no body if there is no label.
+	if len(body) == 0 {
+		return nil, nil, nil
+	}
+	var resp apiResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if resp.Status != "success" {
+		return nil, nil, fmt.Errorf("request failed: %s", resp.Error)
+	}
+	return resp.Data, resp.Warnings, nil
+}
+
+func (c *client) url(path string, params url.Values) string {
+	u := *c.base
+	u.Path += path
+	if params != nil {
+		u.RawQuery = params.Encode()
+	}
+	return u.String()
+}
+
+func (c *client) do(req *http.Request) ([]byte, error) {
+	req.Header.Set(FederatedHeader, "true")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(body) > maxErrorMessageLen {
+			body = body[:maxErrorMessageLen]
+		}
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// formatTime formats a timestamp in milliseconds like the API parameters,
+// in seconds.
+func formatTime(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
@@ -0,0 +1,69 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package federation
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultTimeout = time.Minute
+
+// Config holds the query federation flags.
+type Config struct {
+	Endpoints       endpointList
+	Timeout         time.Duration
+	PartialResponse bool
+}
+
+// endpointList is a comma-separated list of endpoint URLs.
+type endpointList []string
+
+func (l *endpointList) String() string { return strings.Join(*l, ",") }
+
+func (l *endpointList) Set(s string) error {
+	*l = nil
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*l = append(*l, strings.TrimSuffix(e, "/"))
+		}
+	}
+	return nil
+}
+
+// ParseFlags registers the query federation flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var(&cfg.Endpoints, "metrics.federation.endpoints", "Comma-separated list of the base URLs of other Promscale instances, e.g. one per region, queried along with the local database. "+
+		"PromQL, series and label queries read the matching series of all the instances, and series with the same labels are merged. Credentials can be set in the URLs. Disabled if empty.")
+	fs.DurationVar(&cfg.Timeout, "metrics.federation.timeout", DefaultTimeout, "Timeout of the requests sent to the federated Promscale instances.")
+	fs.BoolVar(&cfg.PartialResponse, "metrics.federation.partial-response", true, "Answer queries with the data of the available instances when a federated Promscale instance fails, "+
+		"reporting the failure in the warnings of the response. If false, the query fails.")
+	return cfg
+}
+
+// Validate checks the query federation flags.
+func Validate(cfg *Config) error {
+	for _, e := range cfg.Endpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			return fmt.Errorf("invalid metrics.federation.endpoints URL %q: %w", e, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("metrics.federation.endpoints URL %q must use http or https", e)
+		}
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("metrics.federation.timeout must be positive")
+	}
+	return nil
+}
+
+// Enabled returns true if federated endpoints are configured.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.Endpoints) > 0
+}
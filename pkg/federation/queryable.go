@@ -0,0 +1,306 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package federation answers the queries of an instance with the series of
+// its own database and of other Promscale instances, e.g. one per region, so
+// that a single Grafana datasource can query all of them.
+//
+// The series are read from the other instances with the remote read API and
+// merged with the local ones before the PromQL evaluation, so that functions
+// and aggregations apply to the series of all the instances. Series with the
+// same labels are merged into one.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+
+	"github.com/timescale/promscale/pkg/log"
+	pgQuerier "github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/promql"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var requests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "federation",
+		Name:      "requests_total",
+		Help:      "Number of requests sent to the federated Promscale instances, by endpoint and result.",
+	}, []string{"endpoint", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(requests)
+}
+
+type federatedKey struct{}
+
+// Middleware marks the context of the requests sent by a federating
+// instance, which are answered from the local database only.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(FederatedHeader) != "" {
+			r = r.WithContext(context.WithValue(r.Context(), federatedKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isFederatedRequest(ctx context.Context) bool {
+	federated, _ := ctx.Value(federatedKey{}).(bool)
+	return federated
+}
+
+type queryable struct {
+	local   promql.Queryable
+	clients []*client
+	partial bool
+}
+
+// NewQueryable returns a queryable reading the series of the local queryable
+// and of the endpoints of the config. It returns the local queryable if no
+// endpoint is configured.
+func NewQueryable(local promql.Queryable, cfg Config) (promql.Queryable, error) {
+	if !cfg.Enabled() {
+		return local, nil
+	}
+	httpClient := &http.Client{Timeout: cfg.Timeout}
+	q := &queryable{local: local, partial: cfg.PartialResponse}
+	for _, e := range cfg.Endpoints {
+		c, err := newClient(e, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("federation endpoint %q: %w", e, err)
+		}
+		q.clients = append(q.clients, c)
+	}
+	log.Info("msg", "Query federation enabled", "endpoints", len(q.clients))
+	return q, nil
+}
+
+func (q *queryable) SamplesQuerier(ctx context.Context, mint, maxt int64) (promql.SamplesQuerier, error) {
+	local, err := q.local.SamplesQuerier(ctx, mint, maxt)
+	if err != nil || isFederatedRequest(ctx) {
+		return local, err
+	}
+	return &querier{ctx: ctx, mint: mint, maxt: maxt, local: local, clients: q.clients, partial: q.partial}, nil
+}
+
+// ExemplarsQuerier returns the exemplars of the local database only.
+func (q *queryable) ExemplarsQuerier(ctx context.Context) pgQuerier.ExemplarQuerier {
+	return q.local.ExemplarsQuerier(ctx)
+}
+
+type querier struct {
+	ctx        context.Context
+	mint, maxt int64
+	local      promql.SamplesQuerier
+	clients    []*client
+	partial    bool
+}
+
+// fanOut calls f with each client and its index concurrently, while local
+// runs. The errors of the clients are returned as warnings in partial response
+// mode.
+func (q *querier) fanOut(f func(i int, c *client) error, local func()) (storage.Warnings, error) {
+	errs := make([]error, len(q.clients))
+	var wg sync.WaitGroup
+	for i, c := range q.clients {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			errs[i] = f(i, c)
+		}(i, c)
+	}
+	local()
+	wg.Wait()
+
+	var warnings storage.Warnings
+	for i, err := range errs {
+		c := q.clients[i]
+		if err == nil {
+			requests.WithLabelValues(c.name, "success").Inc()
+			continue
+		}
+		requests.WithLabelValues(c.name, "failure").Inc()
+		err = fmt.Errorf("federated instance %s: %w", c.name, err)
+		if !q.partial {
+			return nil, err
+		}
+		log.Warn("msg", "Federated query failed, answering with partial data", "err", err)
+		warnings = append(warnings, err)
+	}
+	return warnings, nil
+}
+
+// Select merges the series of the local database and of the federated
+// instances. The query hints are not passed to the local database, so that
+// it does not push functions down: they are evaluated on the merged series.
+func (q *querier) Select(sortSeries bool, hints *storage.SelectHints, _ *pgQuerier.QueryHints, _ []parser.Node, matchers ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+	var localSet storage.SeriesSet
+	remote := make([][]*prompb.TimeSeries, len(q.clients))
+	warnings, err := q.fanOut(func(i int, c *client) (err error) {
+		remote[i], err = c.read(q.ctx, mint, maxt, matchers)
+		return err
+	}, func() {
+		localSet, _ = q.local.Select(sortSeries, hints, nil, nil, matchers...)
+	})
+	if err != nil {
+		return storage.ErrSeriesSet(err), nil
+	}
+
+	sets := []storage.SeriesSet{localSet}
+	for _, ts := range remote {
+		sets = append(sets, newRemoteSeriesSet(ts))
+	}
+	return mergeSeriesSets(sets, warnings), nil
+}
+
+func (q *querier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return q.mergeStrings(
+		func() ([]string, storage.Warnings, error) { return q.local.LabelNames(matchers...) },
+		func(c *client) ([]string, []string, error) { return c.labelNames(q.ctx, q.mint, q.maxt) },
+	)
+}
+
+func (q *querier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return q.mergeStrings(
+		func() ([]string, storage.Warnings, error) { return q.local.LabelValues(name, matchers...) },
+		func(c *client) ([]string, []string, error) { return c.labelValues(q.ctx, name, q.mint, q.maxt) },
+	)
+}
+
+// mergeStrings returns the sorted union of the local and remote strings.
+func (q *querier) mergeStrings(local func() ([]string, storage.Warnings, error), remote func(c *client) ([]string, []string, error)) ([]string, storage.Warnings, error) {
+	var (
+		localValues   []string
+		localWarnings storage.Warnings
+		localErr      error
+	)
+	remoteValues := make([][]string, len(q.clients))
+	remoteWarnings := make([][]string, len(q.clients))
+	warnings, err := q.fanOut(func(i int, c *client) (err error) {
+		remoteValues[i], remoteWarnings[i], err = remote(c)
+		return err
+	}, func() {
+		localValues, localWarnings, localErr = local()
+	})
+	if localErr != nil {
+		return nil, nil, localErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := make(map[string]struct{}, len(localValues))
+	for _, v := range localValues {
+		set[v] = struct{}{}
+	}
+	for i, values := range remoteValues {
+		for _, v := range values {
+			set[v] = struct{}{}
+		}
+		for _, w := range remoteWarnings[i] {
+			warnings = append(warnings, fmt.Errorf("federated instance %s: %s", q.clients[i].name, w))
+		}
+	}
+	res := make([]string, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res, append(localWarnings, warnings...), nil
+}
+
+func (q *querier) Close() {
+	q.local.Close()
+}
+
+// mergeSeriesSets merges the series with the same labels of the sets, which
+// do not need to be sorted. The merged series are sorted by labels.
+func mergeSeriesSets(sets []storage.SeriesSet, warnings storage.Warnings) storage.SeriesSet {
+	groups := make(map[string][]storage.Series)
+	for _, set := range sets {
+		for set.Next() {
+			s := set.At()
+			if s == nil {
+				continue
+			}
+			key := s.Labels().String()
+			groups[key] = append(groups[key], s)
+		}
+		if err := set.Err(); err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		warnings = append(warnings, set.Warnings()...)
+	}
+	series := make([]storage.Series, 0, len(groups))
+	for _, g := range groups {
+		if len(g) == 1 {
+			series = append(series, g[0])
+			continue
+		}
+		// Samples with the same timestamp are deduplicated.
+		series = append(series, storage.ChainedSeriesMerge(g...))
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(series[i].Labels(), series[j].Labels()) < 0
+	})
+	return &listSeriesSet{series: series, idx: -1, warnings: warnings}
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func newRemoteSeriesSet(timeseries []*prompb.TimeSeries) storage.SeriesSet {
+	series := make([]storage.Series, 0, len(timeseries))
+	for _, ts := range timeseries {
+		lset := make(labels.Labels, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		sort.Sort(lset)
+		samples := make([]tsdbutil.Sample, 0, len(ts.Samples))
+		for _, s := range ts.Samples {
+			samples = append(samples, sample{t: s.Timestamp, v: s.Value})
+		}
+		series = append(series, storage.NewListSeries(lset, samples))
+	}
+	return &listSeriesSet{series: series, idx: -1}
+}
+
+// listSeriesSet is a storage.SeriesSet of in-memory series.
+type listSeriesSet struct {
+	series   []storage.Series
+	idx      int
+	warnings storage.Warnings
+}
+
+func (s *listSeriesSet) Next() bool {
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *listSeriesSet) At() storage.Series         { return s.series[s.idx] }
+func (s *listSeriesSet) Err() error                 { return nil }
+func (s *listSeriesSet) Warnings() storage.Warnings { return s.warnings }
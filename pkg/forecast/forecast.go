@@ -0,0 +1,251 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package forecast extrapolates the series matching a selector past the end of
+// their data, for capacity alerts and dashboards. The samples are reduced in
+// the database, either to the coefficients of a linear regression or to
+// evenly spaced buckets smoothed with the Holt-Winters method.
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// Method is a forecasting method.
+type Method string
+
+const (
+	// Linear fits a least squares line to the samples.
+	Linear Method = "linear"
+	// HoltWinters applies additive triple exponential smoothing, or double
+	// exponential smoothing if there is no season.
+	HoltWinters Method = "holt_winters"
+)
+
+// maxPoints bounds the number of buckets and forecast points of a series, like
+// the resolution limit of the PromQL range queries.
+const maxPoints = 11000
+
+const (
+	metricTableSQL = "SELECT table_schema, table_name FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)"
+	// The time is relative to the end of the training range so that the
+	// intercept is the value at the end.
+	linearSQL = `SELECT (prom_api.key_value_array(s.labels)).*, f.slope, f.intercept
+FROM (
+	SELECT series_id,
+		regr_slope(value, extract(epoch FROM time - $3)) AS slope,
+		regr_intercept(value, extract(epoch FROM time - $3)) AS intercept
+	FROM %s
+	WHERE series_id = ANY($1) AND time >= $2 AND time <= $3 AND value <> 'NaN'::float8
+	GROUP BY series_id
+) f
+INNER JOIN _prom_catalog.series s ON (s.id = f.series_id)
+WHERE f.slope IS NOT NULL`
+	bucketsSQL = `SELECT (prom_api.key_value_array(s.labels)).*, b.times, b.vals
+FROM (
+	SELECT series_id, array_agg(bucket ORDER BY bucket) AS times, array_agg(value ORDER BY bucket) AS vals
+	FROM (
+		SELECT series_id, time_bucket($4::interval, time, $2) AS bucket, avg(value) AS value
+		FROM %s
+		WHERE series_id = ANY($1) AND time >= $2 AND time <= $3 AND value <> 'NaN'::float8
+		GROUP BY series_id, bucket
+	) buckets
+	GROUP BY series_id
+) b
+INNER JOIN _prom_catalog.series s ON (s.id = b.series_id)`
+)
+
+// Settings of a forecast.
+type Settings struct {
+	Method Method
+	// Start and End bound the samples the forecast is based on.
+	Start, End time.Time
+	// Step is the resolution of the forecast, and the width of the buckets
+	// smoothed by Holt-Winters.
+	Step time.Duration
+	// Horizon is how far past End the series are forecast.
+	Horizon time.Duration
+	// Season is the length of the seasonal cycle for Holt-Winters, e.g. one
+	// day. There is no seasonal component if it is zero.
+	Season time.Duration
+	// Alpha, Beta and Gamma are the Holt-Winters smoothing factors of the
+	// level, trend and season.
+	Alpha, Beta, Gamma float64
+}
+
+// DefaultSettings returns the settings used for the omitted parameters.
+func DefaultSettings() Settings {
+	return Settings{
+		Method: Linear,
+		Alpha:  0.5,
+		Beta:   0.1,
+		Gamma:  0.1,
+	}
+}
+
+// Validate checks the settings are consistent.
+func (s Settings) Validate() error {
+	switch {
+	case s.Method != Linear && s.Method != HoltWinters:
+		return fmt.Errorf("unknown forecast method %q, must be %q or %q", s.Method, Linear, HoltWinters)
+	case !s.End.After(s.Start):
+		return fmt.Errorf("end must be after start")
+	case s.Step <= 0:
+		return fmt.Errorf("step must be positive")
+	case s.Horizon < s.Step:
+		return fmt.Errorf("horizon must be at least one step")
+	case s.Horizon/s.Step > maxPoints:
+		return fmt.Errorf("exceeded maximum resolution of %d forecast points per series, increase the step or decrease the horizon", maxPoints)
+	}
+	if s.Method != HoltWinters {
+		return nil
+	}
+	switch {
+	case s.End.Sub(s.Start)/s.Step > maxPoints:
+		return fmt.Errorf("exceeded maximum resolution of %d buckets per series, increase the step or decrease the time range", maxPoints)
+	case s.Season < 0 || s.Season%s.Step != 0:
+		return fmt.Errorf("season must be a multiple of the step")
+	case s.Season > 0 && s.End.Sub(s.Start) < 2*s.Season:
+		return fmt.Errorf("the time range must cover at least two seasons")
+	}
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{{"alpha", s.Alpha}, {"beta", s.Beta}, {"gamma", s.Gamma}} {
+		if f.value <= 0 || f.value >= 1 {
+			return fmt.Errorf("%s must be between 0 and 1 exclusive", f.name)
+		}
+	}
+	return nil
+}
+
+// Forecast returns the forecast of the series matching the matchers, sorted
+// by labels. The series without enough samples for the method are left out.
+func Forecast(ctx context.Context, conn pgxconn.PgxConn, matchers []*labels.Matcher, s Settings) (promql.Matrix, error) {
+	cb, err := querier.BuildSubQueries(matchers)
+	if err != nil {
+		return nil, fmt.Errorf("forecast build subqueries: %w", err)
+	}
+	clauses, values, err := cb.Build(true)
+	if err != nil {
+		return nil, fmt.Errorf("forecast build clauses: %w", err)
+	}
+	metrics, schemas, seriesIDs, err := querier.GetMetricNameSeriesIds(ctx, conn, querier.GetMetadata(clauses, values))
+	if err != nil {
+		return nil, fmt.Errorf("get metric-name series-ids: %w", err)
+	}
+
+	var matrix promql.Matrix
+	for i, metric := range metrics {
+		var tableSchema, tableName string
+		if err := conn.QueryRow(ctx, metricTableSQL, schemas[i], metric).Scan(&tableSchema, &tableName); err != nil {
+			return nil, fmt.Errorf("get table of metric %s: %w", metric, err)
+		}
+		table := pgx.Identifier{tableSchema, tableName}.Sanitize()
+		ids := make([]int64, len(seriesIDs[i]))
+		for j, id := range seriesIDs[i] {
+			ids[j] = int64(id)
+		}
+
+		var series promql.Matrix
+		if s.Method == Linear {
+			series, err = forecastLinear(ctx, conn, table, ids, s)
+		} else {
+			series, err = forecastHoltWinters(ctx, conn, table, ids, s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("forecast metric %s: %w", metric, err)
+		}
+		matrix = append(matrix, series...)
+	}
+	sort.Slice(matrix, func(i, j int) bool {
+		return labels.Compare(matrix[i].Metric, matrix[j].Metric) < 0
+	})
+	return matrix, nil
+}
+
+func forecastLinear(ctx context.Context, conn pgxconn.PgxConn, table string, ids []int64, s Settings) (promql.Matrix, error) {
+	rows, err := conn.Query(ctx, fmt.Sprintf(linearSQL, table), ids, s.Start, s.End)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matrix promql.Matrix
+	for rows.Next() {
+		var (
+			keys, vals       []string
+			slope, intercept float64
+		)
+		if err := rows.Scan(&keys, &vals, &slope, &intercept); err != nil {
+			return nil, err
+		}
+		matrix = append(matrix, promql.Series{
+			Metric: labelsFromArrays(keys, vals),
+			Points: linear(slope, intercept, s),
+		})
+	}
+	return matrix, rows.Err()
+}
+
+func forecastHoltWinters(ctx context.Context, conn pgxconn.PgxConn, table string, ids []int64, s Settings) (promql.Matrix, error) {
+	rows, err := conn.Query(ctx, fmt.Sprintf(bucketsSQL, table), ids, s.Start, s.End, s.Step)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matrix promql.Matrix
+	for rows.Next() {
+		var (
+			keys, vals []string
+			times      []time.Time
+			values     []float64
+		)
+		if err := rows.Scan(&keys, &vals, &times, &values); err != nil {
+			return nil, err
+		}
+		buckets, lastBucket := fillBuckets(times, values, s)
+		points := holtWinters(buckets, lastBucket, s)
+		if len(points) == 0 {
+			continue
+		}
+		matrix = append(matrix, promql.Series{
+			Metric: labelsFromArrays(keys, vals),
+			Points: points,
+		})
+	}
+	return matrix, rows.Err()
+}
+
+func labelsFromArrays(keys, vals []string) labels.Labels {
+	lset := make(labels.Labels, 0, len(keys))
+	for i := range keys {
+		lset = append(lset, labels.Label{Name: keys[i], Value: vals[i]})
+	}
+	sort.Sort(lset)
+	return lset
+}
+
+// forecastTimes returns the timestamps of the forecast points, every step
+// after from up to the horizon.
+func forecastTimes(from time.Time, s Settings) []int64 {
+	var ts []int64
+	limit := s.End.Add(s.Horizon)
+	for t := from.Add(s.Step); !t.After(limit); t = t.Add(s.Step) {
+		ts = append(ts, timestamp.FromTime(t))
+	}
+	return ts
+}
@@ -0,0 +1,107 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package forecast
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+// linear evaluates the regression line at the forecast times. The time of the
+// line is in seconds relative to the end of the training range.
+func linear(slope, intercept float64, s Settings) []promql.Point {
+	end := timestamp.FromTime(s.End)
+	ts := forecastTimes(s.End, s)
+	points := make([]promql.Point, len(ts))
+	for i, t := range ts {
+		points[i] = promql.Point{T: t, V: intercept + slope*float64(t-end)/1000}
+	}
+	return points
+}
+
+// fillBuckets places the bucket values on a regular grid of steps aligned on
+// the start of the training range, from the first to the last bucket, which
+// is returned with them. Empty buckets take the value of the previous one.
+func fillBuckets(times []time.Time, values []float64, s Settings) ([]float64, time.Time) {
+	if len(times) == 0 {
+		return nil, time.Time{}
+	}
+	first := int(times[0].Sub(s.Start) / s.Step)
+	last := int(times[len(times)-1].Sub(s.Start) / s.Step)
+	filled := make([]float64, last-first+1)
+	filled[0] = values[0]
+	next := 1
+	for i := 1; i < len(filled); i++ {
+		if next < len(times) && int(times[next].Sub(s.Start)/s.Step)-first == i {
+			filled[i] = values[next]
+			next++
+			continue
+		}
+		filled[i] = filled[i-1]
+	}
+	return filled, s.Start.Add(time.Duration(last) * s.Step)
+}
+
+// holtWinters smooths the buckets and forecasts them from the last one, at
+// lastBucket. It returns nothing if there are fewer than two buckets, or two
+// seasons. The last bucket may be before the end of the training range if the
+// series stopped, the forecast starts from it anyway.
+func holtWinters(y []float64, lastBucket time.Time, s Settings) []promql.Point {
+	m := int(s.Season / s.Step)
+	if len(y) < 2 || len(y) < 2*m {
+		return nil
+	}
+
+	var (
+		level, trend float64
+		season       []float64
+		start        int
+	)
+	if m == 0 {
+		level, trend, start = y[0], y[1]-y[0], 1
+	} else {
+		first, second := mean(y[:m]), mean(y[m:2*m])
+		level, trend, start = first, (second-first)/float64(m), m
+		season = make([]float64, m)
+		for i := 0; i < m; i++ {
+			season[i] = y[i] - first
+		}
+	}
+
+	for t := start; t < len(y); t++ {
+		var seasonal float64
+		if m > 0 {
+			seasonal = season[t%m]
+		}
+		prevLevel := level
+		level = s.Alpha*(y[t]-seasonal) + (1-s.Alpha)*(level+trend)
+		trend = s.Beta*(level-prevLevel) + (1-s.Beta)*trend
+		if m > 0 {
+			season[t%m] = s.Gamma*(y[t]-level) + (1-s.Gamma)*seasonal
+		}
+	}
+
+	ts := forecastTimes(lastBucket, s)
+	points := make([]promql.Point, len(ts))
+	for h := range ts {
+		v := level + float64(h+1)*trend
+		if m > 0 {
+			v += season[(len(y)+h)%m]
+		}
+		points[h] = promql.Point{T: ts[h], V: v}
+	}
+	return points
+}
+
+func mean(vs []float64) float64 {
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
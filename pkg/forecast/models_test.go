@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package forecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+var start = time.Unix(1000000, 0).UTC()
+
+func TestLinear(t *testing.T) {
+	s := Settings{Method: Linear, Start: start, End: start.Add(time.Hour), Step: 10 * time.Minute, Horizon: 30 * time.Minute}
+	end := timestamp.FromTime(s.End)
+	require.Equal(t, []promql.Point{
+		{T: end + 600000, V: 16},
+		{T: end + 1200000, V: 22},
+		{T: end + 1800000, V: 28},
+	}, linear(0.01, 10, s))
+}
+
+func TestFillBuckets(t *testing.T) {
+	s := Settings{Start: start, Step: time.Minute}
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+
+	filled, last := fillBuckets([]time.Time{at(2), at(3), at(6)}, []float64{1, 2, 5}, s)
+	require.Equal(t, []float64{1, 2, 2, 2, 5}, filled)
+	require.Equal(t, at(6), last)
+
+	filled, _ = fillBuckets(nil, nil, s)
+	require.Empty(t, filled)
+}
+
+func TestHoltWinters(t *testing.T) {
+	s := DefaultSettings()
+	s.Method, s.Start, s.End, s.Step, s.Horizon = HoltWinters, start, start.Add(9*time.Minute), time.Minute, 3*time.Minute
+	last := start.Add(9 * time.Minute)
+
+	// A straight line is forecast exactly.
+	y := []float64{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}
+	points := holtWinters(y, last, s)
+	require.Len(t, points, 3)
+	for i, p := range points {
+		require.Equal(t, timestamp.FromTime(last.Add(time.Duration(i+1)*time.Minute)), p.T)
+		require.InDelta(t, 20+2*float64(i), p.V, 1e-9)
+	}
+
+	// A repeated season without trend is forecast exactly.
+	s.Season = 2 * time.Minute
+	y = []float64{1, 5, 1, 5, 1, 5, 1, 5, 1, 5}
+	points = holtWinters(y, last, s)
+	require.Len(t, points, 3)
+	require.InDelta(t, 1, points[0].V, 1e-9)
+	require.InDelta(t, 5, points[1].V, 1e-9)
+	require.InDelta(t, 1, points[2].V, 1e-9)
+
+	// Not enough buckets.
+	require.Nil(t, holtWinters([]float64{1, 5, 1}, last, s))
+}
+
+func TestSettingsValidate(t *testing.T) {
+	valid := DefaultSettings()
+	valid.Method, valid.Start, valid.End, valid.Step, valid.Horizon = HoltWinters, start, start.Add(48*time.Hour), time.Hour, 24*time.Hour
+	valid.Season = 24 * time.Hour
+	require.NoError(t, valid.Validate())
+
+	for name, change := range map[string]func(s *Settings){
+		"unknown method":   func(s *Settings) { s.Method = "arima" },
+		"end before start": func(s *Settings) { s.End = s.Start },
+		"no step":          func(s *Settings) { s.Step = 0 },
+		"short horizon":    func(s *Settings) { s.Horizon = time.Minute },
+		"too many points":  func(s *Settings) { s.Step = time.Millisecond },
+		"odd season":       func(s *Settings) { s.Season = 90 * time.Minute },
+		"one season":       func(s *Settings) { s.Season = 36 * time.Hour },
+		"alpha too large":  func(s *Settings) { s.Alpha = 1 },
+	} {
+		s := valid
+		change(&s)
+		require.Error(t, s.Validate(), name)
+	}
+}
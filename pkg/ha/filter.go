@@ -11,6 +11,7 @@ import (
 
 	"github.com/prometheus/common/model"
 	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/tenancy"
 )
 
 const ReplicaNameLabel = "__replica__"
@@ -20,37 +21,55 @@ const ClusterNameLabel = "cluster"
 // gets from the lease service.
 type Filter struct {
 	service *Service
+	stats   *dedupStats
 }
 
 // NewFilter creates a new Filter based on the provided Service.
 func NewFilter(service *Service) *Filter {
 	return &Filter{
 		service: service,
+		stats:   newDedupStats(),
 	}
 }
 
+// Stats returns the deduplication statistics of the clusters that sent data
+// since Promscale started.
+func (h *Filter) Stats() []ClusterStats {
+	return h.stats.snapshot(h.service.Leader)
+}
+
 // FilterData validates and filters timeseries based on lease info from the service.
 // When Prometheus & Promscale are running HA mode the below FilterData is used
 // to validate leader replica samples & ha_locks in TimescaleDB.
-func (h *Filter) Process(_ *http.Request, wr *prompb.WriteRequest) error {
+// Leases are scoped to the tenant of the write request, so the same cluster
+// name sent by different tenants elects a leader per tenant.
+// The samples received and dropped are counted per replica.
+func (h *Filter) Process(_ *http.Request, wr *prompb.WriteRequest) (err error) {
 	defer finalFiltering(wr)
 	tts := wr.Timeseries
 	if len(tts) == 0 {
 		return nil
 	}
 
-	clusterName, replicaName := haLabels(tts[0].Labels)
+	tenant, clusterName, replicaName := haLabels(tts[0].Labels)
 
 	if err := validateClusterLabels(clusterName, replicaName); err != nil {
 		return err
 	}
 
+	received := countSamples(tts)
+	defer func() {
+		if err == nil {
+			h.stats.record(tenant, clusterName, replicaName, received, countSamples(wr.Timeseries), time.Now())
+		}
+	}()
+
 	// find samples time range
 	minTUnix, maxTUnix := findDataTimeRange(tts)
 
 	minT := model.Time(minTUnix).Time()
 	maxT := model.Time(maxTUnix).Time()
-	allowInsert, leaseStart, err := h.service.CheckLease(minT, maxT, clusterName, replicaName)
+	allowInsert, leaseStart, err := h.service.CheckLease(minT, maxT, tenant, clusterName, replicaName)
 	if err != nil {
 		return fmt.Errorf("could not check ha lease: %#v", err)
 	}
@@ -63,7 +82,7 @@ func (h *Filter) Process(_ *http.Request, wr *prompb.WriteRequest) error {
 		return nil
 	}
 
-	hasBackfill, err := h.filterBackfill(wr, minT, leaseStart, clusterName, replicaName)
+	hasBackfill, err := h.filterBackfill(wr, minT, leaseStart, tenant, clusterName, replicaName)
 	if err != nil {
 		return fmt.Errorf("could not check backfill ha lease: %#v", err)
 	}
@@ -89,7 +108,7 @@ func toPromModelTime(t time.Time) int64 {
 	return int64(model.TimeFromUnixNano(t.UnixNano()))
 }
 
-func (h *Filter) filterBackfill(wr *prompb.WriteRequest, minTIncl, maxTExcl time.Time, cluster, replica string) (bool, error) {
+func (h *Filter) filterBackfill(wr *prompb.WriteRequest, minTIncl, maxTExcl time.Time, tenant, cluster, replica string) (bool, error) {
 	hasBackfill := false
 	backfillStart := minTIncl
 	for {
@@ -97,7 +116,7 @@ func (h *Filter) filterBackfill(wr *prompb.WriteRequest, minTIncl, maxTExcl time
 			break
 		}
 
-		keepRangeStart, keepRangeEnd, err := h.service.GetBackfillLeaseRange(backfillStart, maxTExcl, cluster, replica)
+		keepRangeStart, keepRangeEnd, err := h.service.GetBackfillLeaseRange(backfillStart, maxTExcl, tenant, cluster, replica)
 		if err != nil {
 			if err == ErrNoLeasesInRange {
 				// Nothing else to keep.
@@ -208,15 +227,18 @@ func findDataTimeRange(tts []prompb.TimeSeries) (minTUnix int64, maxTUnix int64)
 	return minTUnix, maxTUnix
 }
 
-func haLabels(labels []prompb.Label) (cluster, replica string) {
+func haLabels(labels []prompb.Label) (tenant, cluster, replica string) {
 	for _, label := range labels {
-		if label.Name == ClusterNameLabel {
+		switch label.Name {
+		case ClusterNameLabel:
 			cluster = label.Value
-		} else if label.Name == ReplicaNameLabel {
+		case ReplicaNameLabel:
 			replica = label.Value
+		case tenancy.TenantLabelKey:
+			tenant = label.Value
 		}
 	}
-	return cluster, replica
+	return tenant, cluster, replica
 }
 
 func validateClusterLabels(cluster, replica string) error {
@@ -10,9 +10,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/ha/client"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/tenancy"
 )
 
 func TestHaParserParseData(t *testing.T) {
@@ -439,6 +441,91 @@ func TestHaParserParseData(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "HA enabled parse samples from leader of a tenant sharing the cluster name with another tenant.",
+			args: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{
+							{Name: model.MetricNameLabelName, Value: "test"},
+							{Name: ReplicaNameLabel, Value: "replica2"},
+							{Name: ClusterNameLabel, Value: "cluster6"},
+							{Name: tenancy.TenantLabelKey, Value: "tenant-b"},
+						},
+						Samples: []prompb.Sample{
+							{Timestamp: inLeaseTimestamp, Value: 0.1},
+						},
+					},
+				},
+			},
+			wantErr: false,
+			wanted: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{
+							{Name: model.MetricNameLabelName, Value: "test"},
+							{Name: ClusterNameLabel, Value: "cluster6"},
+							{Name: tenancy.TenantLabelKey, Value: "tenant-b"},
+						},
+						Samples: []prompb.Sample{
+							{Timestamp: inLeaseTimestamp, Value: 0.1},
+						},
+					},
+				},
+			},
+			cluster: "cluster6",
+			setClusterStates: []client.LeaseDBState{
+				{
+					Cluster:    "tenant-a/cluster6",
+					Leader:     "replica1",
+					LeaseStart: leaseStart,
+					LeaseUntil: leaseUntil,
+				},
+				{
+					Cluster:    "tenant-b/cluster6",
+					Leader:     "replica2",
+					LeaseStart: leaseStart,
+					LeaseUntil: leaseUntil,
+				},
+			},
+		},
+		{
+			name: "HA enabled drop samples from standby of a tenant sharing the cluster name with another tenant.",
+			args: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{
+							{Name: model.MetricNameLabelName, Value: "test"},
+							{Name: ReplicaNameLabel, Value: "replica2"},
+							{Name: ClusterNameLabel, Value: "cluster7"},
+							{Name: tenancy.TenantLabelKey, Value: "tenant-a"},
+						},
+						Samples: []prompb.Sample{
+							{Timestamp: inLeaseTimestamp, Value: 0.1},
+						},
+					},
+				},
+			},
+			wantErr: false,
+			wanted: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{},
+			},
+			cluster: "cluster7",
+			setClusterStates: []client.LeaseDBState{
+				{
+					Cluster:    "tenant-a/cluster7",
+					Leader:     "replica1",
+					LeaseStart: leaseStart,
+					LeaseUntil: leaseUntil,
+				},
+				{
+					Cluster:    "tenant-b/cluster7",
+					Leader:     "replica2",
+					LeaseStart: leaseStart,
+					LeaseUntil: leaseUntil,
+				},
+			},
+		},
 	}
 	for _, c := range tests {
 		t.Run(c.name, func(t *testing.T) {
@@ -562,3 +649,57 @@ func TestFilterSamples(t *testing.T) {
 	}
 
 }
+
+func TestFilterStats(t *testing.T) {
+	leaseStart := time.Unix(1, 0)
+	inLease := leaseStart.Add(time.Second).UnixNano() / 1000000
+	request := func(replica string, samples int) *prompb.WriteRequest {
+		ts := prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: model.MetricNameLabelName, Value: "test"},
+				{Name: ReplicaNameLabel, Value: replica},
+				{Name: ClusterNameLabel, Value: "cluster"},
+			},
+		}
+		for i := 0; i < samples; i++ {
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: inLease + int64(i), Value: 0.1})
+		}
+		return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{ts}}
+	}
+
+	service := MockNewHAService()
+	SetLeaderInMockService(service, []client.LeaseDBState{
+		{Cluster: "cluster", Leader: "replica1", LeaseStart: leaseStart, LeaseUntil: leaseStart.Add(2 * time.Second)},
+	})
+	h := NewFilter(service)
+	require.Empty(t, h.Stats())
+
+	require.NoError(t, h.Process(nil, request("replica1", 3)))
+	require.NoError(t, h.Process(nil, request("replica2", 3)))
+	require.NoError(t, h.Process(nil, request("replica1", 1)))
+	// Requests failing validation are not counted.
+	require.Error(t, h.Process(nil, request("", 2)))
+
+	stats := h.Stats()
+	require.Len(t, stats, 1)
+	c := stats[0]
+	require.Equal(t, "", c.Tenant)
+	require.Equal(t, "cluster", c.Cluster)
+	require.Equal(t, "replica1", c.Leader)
+	require.Equal(t, uint64(7), c.Received)
+	require.Equal(t, uint64(4), c.Accepted)
+	require.Equal(t, uint64(3), c.Dropped)
+	require.InDelta(t, 3.0/7.0, c.DuplicateRatio, 1e-9)
+	require.Len(t, c.Replicas, 2)
+
+	r1, r2 := c.Replicas[0], c.Replicas[1]
+	require.Equal(t, "replica1", r1.Replica)
+	require.Equal(t, uint64(4), r1.Received)
+	require.Equal(t, uint64(4), r1.Accepted)
+	require.Equal(t, uint64(0), r1.Dropped)
+	require.False(t, r1.LastSeen.IsZero())
+	require.Equal(t, "replica2", r2.Replica)
+	require.Equal(t, uint64(3), r2.Received)
+	require.Equal(t, uint64(0), r2.Accepted)
+	require.Equal(t, uint64(3), r2.Dropped)
+}
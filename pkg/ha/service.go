@@ -25,8 +25,8 @@ const (
 var ErrNoLeasesInRange = fmt.Errorf("no valid leases in range found")
 
 // Service contains the lease state for all prometheus clusters
-// and logic for determining if a specific sample should
-// be allowed to be inserted. Also it keeps the lease state
+// (keyed by their tenant namespaced lease name) and logic for
+// determining if a specific sample should be allowed to be inserted. Also it keeps the lease state
 // up to date by periodically refreshing it from the database.
 type Service struct {
 	state               *sync.Map
@@ -97,12 +97,14 @@ func (s *Service) haStateSyncer() {
 //	An instance is selected a leader for a specific time range, which is expanded as
 //	newer samples come in from that leader, but samples before the granted lease
 //	are supposed to be dropped.
-func (s *Service) CheckLease(minT, maxT time.Time, clusterName, replicaName string) (
+//	Leases are namespaced by tenant, an empty tenant denotes a cluster
+//	that is not sent by a tenant.
+func (s *Service) CheckLease(minT, maxT time.Time, tenant, clusterName, replicaName string) (
 	allowInsert bool, acceptedMinT time.Time, err error,
 ) {
-	lease, err := s.getLocalClusterLease(clusterName, replicaName, minT, maxT)
+	lease, err := s.getLocalClusterLease(tenant, clusterName, replicaName, minT, maxT)
 	if err != nil {
-		errMsg := fmt.Sprintf("error trying to get lease for cluster %s", clusterName)
+		errMsg := fmt.Sprintf("error trying to get lease for cluster %s", state.LeaseName(tenant, clusterName))
 		log.Error("msg", errMsg, "err", err)
 		return false, time.Time{}, err
 	}
@@ -110,32 +112,43 @@ func (s *Service) CheckLease(minT, maxT time.Time, clusterName, replicaName stri
 	return lease.ValidateSamplesInfo(replicaName, minT, maxT, s.currentTimeProvider())
 }
 
+// Leader returns the current leader of the cluster of the tenant, or an empty
+// string if no lease is known for the cluster.
+func (s *Service) Leader(tenant, clusterName string) string {
+	l, ok := s.state.Load(state.LeaseName(tenant, clusterName))
+	if !ok {
+		return ""
+	}
+	return l.(*state.Lease).Leader()
+}
+
 func (s *Service) Close() {
 	close(s.doneChannel)
 	s.doneWG.Wait()
 }
 
-func (s *Service) getLocalClusterLease(clusterName, replicaName string, minT, maxT time.Time) (*state.Lease, error) {
+func (s *Service) getLocalClusterLease(tenant, clusterName, replicaName string, minT, maxT time.Time) (*state.Lease, error) {
 	currentTime := s.currentTimeProvider()
-	l, ok := s.state.Load(clusterName)
+	leaseName := state.LeaseName(tenant, clusterName)
+	l, ok := s.state.Load(leaseName)
 	if ok {
 		lease := l.(*state.Lease)
 		lease.UpdateMaxSeenTime(replicaName, maxT, currentTime)
 		return lease, nil
 	}
-	newLease, err := state.NewLease(s.leaseClient, clusterName, replicaName, minT, maxT, currentTime)
+	newLease, err := state.NewLease(s.leaseClient, tenant, clusterName, replicaName, minT, maxT, currentTime)
 	if err != nil {
 		return nil, err
 	}
-	l, _ = s.state.LoadOrStore(clusterName, newLease)
+	l, _ = s.state.LoadOrStore(leaseName, newLease)
 	newLease = l.(*state.Lease)
 	return newLease, nil
 }
 
-func (s *Service) GetBackfillLeaseRange(start, end time.Time, cluster string, replica string) (time.Time, time.Time, error) {
-	state, err := s.leaseClient.GetPastLeaseInfo(context.Background(), cluster, replica, start, end)
+func (s *Service) GetBackfillLeaseRange(start, end time.Time, tenant, cluster, replica string) (time.Time, time.Time, error) {
+	leaseState, err := s.leaseClient.GetPastLeaseInfo(context.Background(), state.LeaseName(tenant, cluster), replica, start, end)
 	if err == client.ErrNoPastLease {
 		err = ErrNoLeasesInRange
 	}
-	return state.LeaseStart, state.LeaseUntil, err
+	return leaseState.LeaseStart, leaseState.LeaseUntil, err
 }
@@ -3,17 +3,40 @@ package state
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/timescale/promscale/pkg/ha/client"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+	"github.com/timescale/promscale/pkg/webhook"
 )
 
 const (
 	haLastWriteInterval = 30 * time.Second
+	// tenantSeparator separates the tenant from the cluster name in
+	// the name of a tenant-scoped lease.
+	tenantSeparator = "/"
 )
 
+// leaseNameEscaper escapes the separator in the tenant and cluster names,
+// and the escape character itself.
+var leaseNameEscaper = strings.NewReplacer("%", "%25", tenantSeparator, "%2F")
+
+// LeaseName returns the name under which the lease of a cluster is stored.
+// Clusters sent by tenants are namespaced by the tenant name, so the same
+// cluster name used by different tenants results in different leases.
+// The names are escaped so that only tenant-scoped leases contain the
+// separator, and each lease name maps back to a single tenant and cluster.
+// Clusters without a tenant keep using the plain cluster name unless it
+// contains the separator or the escape character.
+func LeaseName(tenant, cluster string) string {
+	if tenant == "" {
+		return leaseNameEscaper.Replace(cluster)
+	}
+	return leaseNameEscaper.Replace(tenant) + tenantSeparator + leaseNameEscaper.Replace(cluster)
+}
+
 // Lease represents the state of a lease for a cluster
 // in a given time. It shows which instance is the leader,
 // and the data time range for which the leader holds the lease.
@@ -30,7 +53,9 @@ type Lease struct {
 	MaxTimeSeenLeader     time.Time // max data time seen by current leader
 	RecentLeaderWriteTime time.Time // real time when leader last wrote data
 
-	client client.LeaseClient
+	tenant  string
+	cluster string
+	client  client.LeaseClient
 }
 
 // Creates a new Lease and immediately synchronizes with the database, it either
@@ -38,16 +63,19 @@ type Lease struct {
 //	  for the requested minT and maxT
 //	- or the existing leader and lease details are returned and set in the
 //	  new Lease.
+// The lease is stored under the name returned by LeaseName for the tenant and cluster.
 // An error is returned if an error occurred querying the database.
-func NewLease(c client.LeaseClient, cluster, potentialLeader string, minT, maxT, currentTime time.Time) (*Lease, error) {
-	stateFromDB, err := c.UpdateLease(context.Background(), cluster, potentialLeader, minT, maxT)
+func NewLease(c client.LeaseClient, tenant, cluster, potentialLeader string, minT, maxT, currentTime time.Time) (*Lease, error) {
+	stateFromDB, err := c.UpdateLease(context.Background(), LeaseName(tenant, cluster), potentialLeader, minT, maxT)
 	if err != nil {
 		return nil, fmt.Errorf("could not create new lease: %#v", err)
 	}
 
-	exposeHAStateToMetrics(cluster, "", stateFromDB.Leader)
+	exposeHAStateToMetrics(tenant, cluster, "", stateFromDB.Leader)
 
 	return &Lease{
+		tenant:                tenant,
+		cluster:               cluster,
 		client:                c,
 		state:                 stateFromDB,
 		MaxTimeSeen:           maxT,
@@ -199,19 +227,50 @@ func (h *Lease) setUpdateFromDB(stateFromDB client.LeaseDBState) {
 		h.RecentLeaderWriteTime = time.Now()
 	}
 	h._mu.Unlock()
-	exposeHAStateToMetrics(stateFromDB.Cluster, oldLeader, stateFromDB.Leader)
+	exposeHAStateToMetrics(h.tenant, h.cluster, oldLeader, stateFromDB.Leader)
+	if oldLeader != "" && oldLeader != stateFromDB.Leader {
+		webhook.Emit(webhook.Event{
+			Type:    webhook.LeaderChange,
+			Subject: LeaseName(h.tenant, h.cluster),
+			Message: fmt.Sprintf("leader of cluster %s changed from %s to %s", LeaseName(h.tenant, h.cluster), oldLeader, stateFromDB.Leader),
+			Details: map[string]string{
+				"tenant":     h.tenant,
+				"cluster":    h.cluster,
+				"old_leader": oldLeader,
+				"new_leader": stateFromDB.Leader,
+			},
+		})
+	}
+}
+
+// Tenant returns the tenant the lease belongs to. It is empty for clusters
+// that are not sent by a tenant.
+func (h *Lease) Tenant() string {
+	return h.tenant
+}
+
+// Leader returns the replica currently holding the lease.
+func (h *Lease) Leader() string {
+	h._mu.RLock()
+	defer h._mu.RUnlock()
+	return h.state.Leader
+}
+
+// Cluster returns the cluster name of the lease, without the tenant namespace.
+func (h *Lease) Cluster() string {
+	return h.cluster
 }
 
-func exposeHAStateToMetrics(cluster, oldLeader, newLeader string) {
+func exposeHAStateToMetrics(tenant, cluster, oldLeader, newLeader string) {
 	if oldLeader == newLeader {
 		return
 	}
 	if oldLeader != "" {
-		metrics.HAClusterLeaderDetails.WithLabelValues(cluster, oldLeader).Set(0)
+		metrics.HAClusterLeaderDetails.WithLabelValues(tenant, cluster, oldLeader).Set(0)
 	}
-	metrics.HAClusterLeaderDetails.WithLabelValues(cluster, newLeader).Set(1)
+	metrics.HAClusterLeaderDetails.WithLabelValues(tenant, cluster, newLeader).Set(1)
 
-	counter, err := metrics.NumOfHAClusterLeaderChanges.GetMetricWithLabelValues(cluster)
+	counter, err := metrics.NumOfHAClusterLeaderChanges.GetMetricWithLabelValues(tenant, cluster)
 	if err != nil {
 		return
 	}
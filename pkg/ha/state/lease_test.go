@@ -0,0 +1,33 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseName(t *testing.T) {
+	testCases := []struct {
+		tenant, cluster string
+		expected        string
+	}{
+		{cluster: "prod", expected: "prod"},
+		{tenant: "acme", cluster: "prod", expected: "acme/prod"},
+		{tenant: "acme/eu", cluster: "prod", expected: "acme%2Feu/prod"},
+		{tenant: "acme", cluster: "eu/prod", expected: "acme/eu%2Fprod"},
+		{cluster: "acme/prod", expected: "acme%2Fprod"},
+		{cluster: "100%", expected: "100%25"},
+		{tenant: "a%2Fb", cluster: "c", expected: "a%252Fb/c"},
+	}
+	names := make(map[string]struct{}, len(testCases))
+	for _, c := range testCases {
+		name := LeaseName(c.tenant, c.cluster)
+		require.Equal(t, c.expected, name, "tenant %q cluster %q", c.tenant, c.cluster)
+		names[name] = struct{}{}
+	}
+	require.Len(t, names, len(testCases))
+}
@@ -0,0 +1,122 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ha
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/ha/state"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// ReplicaStats are the deduplication statistics of a replica since
+// Promscale started.
+type ReplicaStats struct {
+	Replica  string    `json:"replica"`
+	Received uint64    `json:"received"`
+	Accepted uint64    `json:"accepted"`
+	Dropped  uint64    `json:"dropped"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// ClusterStats are the deduplication statistics of a cluster since
+// Promscale started. DuplicateRatio is the share of the received samples
+// that were dropped. With n healthy replicas sending the same data, it is
+// close to (n-1)/n.
+type ClusterStats struct {
+	Tenant         string         `json:"tenant"`
+	Cluster        string         `json:"cluster"`
+	Leader         string         `json:"leader"`
+	Received       uint64         `json:"received"`
+	Accepted       uint64         `json:"accepted"`
+	Dropped        uint64         `json:"dropped"`
+	DuplicateRatio float64        `json:"duplicateRatio"`
+	Replicas       []ReplicaStats `json:"replicas"`
+}
+
+type clusterStats struct {
+	tenant   string
+	cluster  string
+	replicas map[string]*ReplicaStats
+}
+
+// dedupStats keeps the number of samples received and dropped per replica.
+type dedupStats struct {
+	mu       sync.Mutex
+	clusters map[string]*clusterStats
+}
+
+func newDedupStats() *dedupStats {
+	return &dedupStats{clusters: make(map[string]*clusterStats)}
+}
+
+func (s *dedupStats) record(tenant, cluster, replica string, received, accepted uint64, now time.Time) {
+	dropped := received - accepted
+	metrics.HASamplesReceived.WithLabelValues(tenant, cluster, replica).Add(float64(received))
+	metrics.HASamplesDropped.WithLabelValues(tenant, cluster, replica).Add(float64(dropped))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := state.LeaseName(tenant, cluster)
+	c, ok := s.clusters[name]
+	if !ok {
+		c = &clusterStats{tenant: tenant, cluster: cluster, replicas: make(map[string]*ReplicaStats)}
+		s.clusters[name] = c
+	}
+	r, ok := c.replicas[replica]
+	if !ok {
+		r = &ReplicaStats{Replica: replica}
+		c.replicas[replica] = r
+	}
+	r.Received += received
+	r.Accepted += accepted
+	r.Dropped += dropped
+	r.LastSeen = now
+}
+
+// snapshot returns the statistics of all the clusters sorted by tenant and
+// cluster, with the replicas sorted by name. leader returns the current
+// leader of a cluster.
+func (s *dedupStats) snapshot(leader func(tenant, cluster string) string) []ClusterStats {
+	s.mu.Lock()
+	res := make([]ClusterStats, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		cs := ClusterStats{Tenant: c.tenant, Cluster: c.cluster, Replicas: make([]ReplicaStats, 0, len(c.replicas))}
+		for _, r := range c.replicas {
+			cs.Received += r.Received
+			cs.Accepted += r.Accepted
+			cs.Dropped += r.Dropped
+			cs.Replicas = append(cs.Replicas, *r)
+		}
+		if cs.Received > 0 {
+			cs.DuplicateRatio = float64(cs.Dropped) / float64(cs.Received)
+		}
+		sort.Slice(cs.Replicas, func(i, j int) bool { return cs.Replicas[i].Replica < cs.Replicas[j].Replica })
+		res = append(res, cs)
+	}
+	s.mu.Unlock()
+
+	for i := range res {
+		res[i].Leader = leader(res[i].Tenant, res[i].Cluster)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Tenant != res[j].Tenant {
+			return res[i].Tenant < res[j].Tenant
+		}
+		return res[i].Cluster < res[j].Cluster
+	})
+	return res
+}
+
+func countSamples(tts []prompb.TimeSeries) uint64 {
+	n := 0
+	for i := range tts {
+		n += len(tts[i].Samples)
+	}
+	return uint64(n)
+}
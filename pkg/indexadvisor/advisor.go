@@ -0,0 +1,333 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package indexadvisor records the label matchers of slow metric queries and
+// suggests the indexes on the label table that would speed them up.
+package indexadvisor
+
+import (
+	"context"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/regexp"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/timescale/promscale/pkg/log"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	// slowQueryLogSize is the number of slow queries kept for the report.
+	slowQueryLogSize = 100
+
+	labelSchema      = "_prom_catalog"
+	labelTable       = labelSchema + ".label"
+	trigramIndexName = "label_value_trgm_idx"
+
+	createTrigramIndexSQL = "CREATE INDEX CONCURRENTLY IF NOT EXISTS " + trigramIndexName + " ON " + labelTable + " USING gin (value gin_trgm_ops)"
+	indexExistsSQL        = "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_indexes WHERE schemaname = $1 AND indexname = $2)"
+	trigramInstalledSQL   = "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_extension WHERE extname = 'pg_trgm')"
+)
+
+// re2Regex detects the regexes that the querier evaluates with RE2 instead of
+// the PostgreSQL regex operator. Those cannot use an index. It is the same
+// expression as the one of the querier.
+var re2Regex = regexp.MustCompile(`\(\?`)
+
+// SlowQuery is an entry of the slow query log.
+type SlowQuery struct {
+	Time     time.Time `json:"time"`
+	Matchers string    `json:"matchers"`
+	Duration float64   `json:"durationSeconds"`
+}
+
+// Pattern holds the statistics of a label matcher pattern in the slow queries.
+type Pattern struct {
+	LabelName     string  `json:"labelName"`
+	MatchType     string  `json:"matchType"`
+	SlowQueries   int     `json:"slowQueries"`
+	TotalDuration float64 `json:"totalDurationSeconds"`
+}
+
+// Suggestion is an index that would speed up the slow queries.
+type Suggestion struct {
+	Table     string `json:"table"`
+	Index     string `json:"index"`
+	Statement string `json:"statement"`
+	Reason    string `json:"reason"`
+	// LabelNames are the labels whose matchers would use the index.
+	LabelNames  []string `json:"labelNames"`
+	SlowQueries int      `json:"slowQueries"`
+	Exists      bool     `json:"exists"`
+	// MissingRequirement is set if the index cannot be created yet.
+	MissingRequirement string `json:"missingRequirement,omitempty"`
+}
+
+// Report is the analysis of the slow queries.
+type Report struct {
+	Suggestions []Suggestion `json:"suggestions"`
+	Patterns    []Pattern    `json:"patterns"`
+	SlowQueries []SlowQuery  `json:"slowQueries"`
+}
+
+type pattern struct {
+	labelName string
+	matchType labels.MatchType
+}
+
+type patternStats struct {
+	count int
+	total time.Duration
+	// trigramMatches is the number of matchers that a trigram index on the
+	// label values would speed up.
+	trigramMatches int
+}
+
+// Advisor analyzes the slow queries. A nil Advisor records nothing.
+type Advisor struct {
+	cfg Config
+
+	mu          sync.Mutex
+	patterns    map[pattern]*patternStats
+	slowQueries []SlowQuery
+	next        int
+}
+
+// NewAdvisor returns an Advisor, or nil if the index advisor is disabled.
+func NewAdvisor(cfg Config) *Advisor {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Advisor{
+		cfg:         cfg,
+		patterns:    make(map[pattern]*patternStats),
+		slowQueries: make([]SlowQuery, 0, slowQueryLogSize),
+	}
+}
+
+// ObserveQuery records the matchers of a query if it is slow.
+func (a *Advisor) ObserveQuery(matchers []*labels.Matcher, duration time.Duration) {
+	if a == nil || duration < a.cfg.SlowQueryThreshold {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry := SlowQuery{Time: time.Now(), Matchers: formatMatchers(matchers), Duration: duration.Seconds()}
+	if len(a.slowQueries) < slowQueryLogSize {
+		a.slowQueries = append(a.slowQueries, entry)
+	} else {
+		a.slowQueries[a.next] = entry
+	}
+	a.next = (a.next + 1) % slowQueryLogSize
+
+	for _, m := range matchers {
+		if !usesLabelTable(m) {
+			continue
+		}
+		key := pattern{m.Name, m.Type}
+		stats, ok := a.patterns[key]
+		if !ok {
+			stats = &patternStats{}
+			a.patterns[key] = stats
+		}
+		stats.count++
+		stats.total += duration
+		if trigramIndexable(m) {
+			stats.trigramMatches++
+		}
+	}
+}
+
+// Report returns the suggested indexes along with the slow queries they are based on.
+func (a *Advisor) Report(ctx context.Context, conn pgxconn.PgxConn) (*Report, error) {
+	if a == nil {
+		return nil, fmt.Errorf("index advisor is disabled")
+	}
+	report := a.analyze()
+	if len(report.Suggestions) == 0 {
+		return report, nil
+	}
+	var trigramInstalled bool
+	if err := conn.QueryRow(ctx, trigramInstalledSQL).Scan(&trigramInstalled); err != nil {
+		return nil, fmt.Errorf("checking if pg_trgm is installed: %w", err)
+	}
+	for i := range report.Suggestions {
+		s := &report.Suggestions[i]
+		if err := conn.QueryRow(ctx, indexExistsSQL, labelSchema, s.Index).Scan(&s.Exists); err != nil {
+			return nil, fmt.Errorf("checking if index %s exists: %w", s.Index, err)
+		}
+		if !trigramInstalled {
+			s.MissingRequirement = "the pg_trgm extension must be installed"
+		}
+	}
+	return report, nil
+}
+
+// analyze builds the report from the recorded slow queries, without
+// checking the state of the database.
+func (a *Advisor) analyze() *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report := &Report{
+		Suggestions: []Suggestion{},
+		Patterns:    make([]Pattern, 0, len(a.patterns)),
+		SlowQueries: make([]SlowQuery, 0, len(a.slowQueries)),
+	}
+
+	var (
+		trigramMatches    int
+		trigramLabelNames = make(map[string]struct{})
+	)
+	for p, stats := range a.patterns {
+		report.Patterns = append(report.Patterns, Pattern{
+			LabelName:     p.labelName,
+			MatchType:     p.matchType.String(),
+			SlowQueries:   stats.count,
+			TotalDuration: stats.total.Seconds(),
+		})
+		if stats.trigramMatches > 0 {
+			trigramMatches += stats.trigramMatches
+			trigramLabelNames[p.labelName] = struct{}{}
+		}
+	}
+	sort.Slice(report.Patterns, func(i, j int) bool {
+		if report.Patterns[i].TotalDuration != report.Patterns[j].TotalDuration {
+			return report.Patterns[i].TotalDuration > report.Patterns[j].TotalDuration
+		}
+		if report.Patterns[i].LabelName != report.Patterns[j].LabelName {
+			return report.Patterns[i].LabelName < report.Patterns[j].LabelName
+		}
+		return report.Patterns[i].MatchType < report.Patterns[j].MatchType
+	})
+
+	if trigramMatches >= a.cfg.MinSlowQueries {
+		names := make([]string, 0, len(trigramLabelNames))
+		for name := range trigramLabelNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		report.Suggestions = append(report.Suggestions, Suggestion{
+			Table:       labelTable,
+			Index:       trigramIndexName,
+			Statement:   createTrigramIndexSQL,
+			Reason:      "regex matchers on label values scan all the values of the label",
+			LabelNames:  names,
+			SlowQueries: trigramMatches,
+		})
+	}
+
+	// Newest slow queries first.
+	for i := 1; i <= len(a.slowQueries); i++ {
+		report.SlowQueries = append(report.SlowQueries, a.slowQueries[(a.next-i+slowQueryLogSize)%slowQueryLogSize])
+	}
+	return report
+}
+
+// Run periodically creates the suggested indexes if auto creation is enabled.
+// It blocks until ctx is done.
+func (a *Advisor) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if a == nil || !a.cfg.AutoCreate {
+		return
+	}
+	ticker := time.NewTicker(a.cfg.RunFrequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.createIndexes(ctx, conn); err != nil {
+				log.Error("msg", "index advisor failed to create indexes", "err", err)
+			}
+		}
+	}
+}
+
+func (a *Advisor) createIndexes(ctx context.Context, conn pgxconn.PgxConn) error {
+	report, err := a.Report(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, s := range report.Suggestions {
+		if s.Exists {
+			continue
+		}
+		if s.MissingRequirement != "" {
+			log.Warn("msg", "index advisor cannot create index", "index", s.Index, "reason", s.MissingRequirement)
+			continue
+		}
+		log.Info("msg", "index advisor creating index", "index", s.Index, "table", s.Table)
+		if _, err := conn.Exec(ctx, s.Statement); err != nil {
+			return fmt.Errorf("creating index %s: %w", s.Index, err)
+		}
+	}
+	return nil
+}
+
+// usesLabelTable returns true if the matcher is evaluated with a lookup in the
+// label table. Equality matchers on the metric name, schema and column select
+// the table to query instead.
+func usesLabelTable(m *labels.Matcher) bool {
+	if m.Type != labels.MatchEqual {
+		return true
+	}
+	switch m.Name {
+	case pgmodel.MetricNameLabelName, pgmodel.SchemaNameLabelName, pgmodel.ColumnNameLabelName:
+		return false
+	}
+	return true
+}
+
+// trigramIndexable returns true if the matcher is evaluated with the
+// PostgreSQL regex operator, and its regex has a literal of at least three
+// characters a trigram index can look up.
+func trigramIndexable(m *labels.Matcher) bool {
+	var usesRegexOperator bool
+	switch m.Type {
+	case labels.MatchRegexp:
+		usesRegexOperator = !m.Matches("")
+	case labels.MatchNotRegexp:
+		// Matchers of the form label!~"regex" that match the empty
+		// value are evaluated by excluding the matching values.
+		usesRegexOperator = m.Matches("")
+	}
+	if !usesRegexOperator || re2Regex.MatchString(m.Value) {
+		return false
+	}
+	re, err := syntax.Parse(m.Value, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return hasTrigram(re)
+}
+
+func hasTrigram(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral && len(re.Rune) >= 3 {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasTrigram(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatMatchers(matchers []*labels.Matcher) string {
+	s := "{"
+	for i, m := range matchers {
+		if i > 0 {
+			s += ", "
+		}
+		s += m.String()
+	}
+	return s + "}"
+}
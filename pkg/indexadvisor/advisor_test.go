@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package indexadvisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrigramIndexable(t *testing.T) {
+	testCases := []struct {
+		name      string
+		matcher   *labels.Matcher
+		indexable bool
+	}{
+		{"equal", labels.MustNewMatcher(labels.MatchEqual, "job", "prometheus"), false},
+		{"regex with literal", labels.MustNewMatcher(labels.MatchRegexp, "job", "prom.*"), true},
+		{"regex with short literals", labels.MustNewMatcher(labels.MatchRegexp, "job", "a|bc"), false},
+		{"regex matching empty", labels.MustNewMatcher(labels.MatchRegexp, "job", "prom.*|"), false},
+		{"RE2 regex", labels.MustNewMatcher(labels.MatchRegexp, "job", "(?i)prom.*"), false},
+		{"negated regex", labels.MustNewMatcher(labels.MatchNotRegexp, "job", "prom.*"), true},
+		{"negated regex matching empty", labels.MustNewMatcher(labels.MatchNotRegexp, "job", "prom.*|"), false},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.indexable, trigramIndexable(c.matcher))
+		})
+	}
+}
+
+func TestAdvisorAnalyze(t *testing.T) {
+	a := NewAdvisor(Config{Enabled: true, SlowQueryThreshold: time.Second, MinSlowQueries: 2})
+
+	var (
+		name  = labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")
+		job   = labels.MustNewMatcher(labels.MatchRegexp, "job", "prom.*")
+		env   = labels.MustNewMatcher(labels.MatchEqual, "env", "prod")
+		inst  = labels.MustNewMatcher(labels.MatchRegexp, "instance", "localhost:.*")
+		short = labels.MustNewMatcher(labels.MatchRegexp, "instance", ".+")
+	)
+
+	a.ObserveQuery([]*labels.Matcher{name, job}, 100*time.Millisecond)
+	report := a.analyze()
+	require.Empty(t, report.SlowQueries)
+	require.Empty(t, report.Suggestions)
+
+	a.ObserveQuery([]*labels.Matcher{name, job, env}, 2*time.Second)
+	report = a.analyze()
+	require.Len(t, report.SlowQueries, 1)
+	require.Empty(t, report.Suggestions, "not enough slow queries")
+
+	a.ObserveQuery([]*labels.Matcher{name, inst, short}, 3*time.Second)
+	report = a.analyze()
+	require.Len(t, report.Suggestions, 1)
+	require.Equal(t, trigramIndexName, report.Suggestions[0].Index)
+	require.Equal(t, []string{"instance", "job"}, report.Suggestions[0].LabelNames)
+	require.Equal(t, 2, report.Suggestions[0].SlowQueries)
+
+	require.Equal(t, []Pattern{
+		{LabelName: "instance", MatchType: "=~", SlowQueries: 2, TotalDuration: 6},
+		{LabelName: "env", MatchType: "=", SlowQueries: 1, TotalDuration: 2},
+		{LabelName: "job", MatchType: "=~", SlowQueries: 1, TotalDuration: 2},
+	}, report.Patterns)
+
+	require.Len(t, report.SlowQueries, 2)
+	require.Equal(t, 3.0, report.SlowQueries[0].Duration, "newest first")
+}
+
+func TestSlowQueryLogWraps(t *testing.T) {
+	a := NewAdvisor(Config{Enabled: true, SlowQueryThreshold: time.Second, MinSlowQueries: 1})
+	m := labels.MustNewMatcher(labels.MatchEqual, "job", "prometheus")
+	for i := 1; i <= slowQueryLogSize+10; i++ {
+		a.ObserveQuery([]*labels.Matcher{m}, time.Duration(i)*time.Second)
+	}
+	report := a.analyze()
+	require.Len(t, report.SlowQueries, slowQueryLogSize)
+	require.Equal(t, float64(slowQueryLogSize+10), report.SlowQueries[0].Duration)
+	require.Equal(t, 11.0, report.SlowQueries[slowQueryLogSize-1].Duration)
+
+	var disabled *Advisor
+	disabled.ObserveQuery([]*labels.Matcher{m}, time.Hour)
+	require.Nil(t, NewAdvisor(Config{}))
+}
@@ -0,0 +1,54 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package indexadvisor
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultSlowQueryThreshold = time.Second
+	defaultMinSlowQueries     = 10
+	defaultRunFrequency       = 15 * time.Minute
+)
+
+// Config holds the index advisor flags.
+type Config struct {
+	Enabled            bool
+	SlowQueryThreshold time.Duration
+	MinSlowQueries     int
+	AutoCreate         bool
+	RunFrequency       time.Duration
+}
+
+// ParseFlags registers the index advisor flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "metrics.index-advisor.enabled", false, "Record the label matchers of slow metric queries and suggest indexes on the label table "+
+		"that would speed them up. The suggestions are served by the /api/v1/index_advisor endpoint.")
+	fs.DurationVar(&cfg.SlowQueryThreshold, "metrics.index-advisor.slow-query-threshold", defaultSlowQueryThreshold, "Minimum duration of the metric queries recorded by the index advisor.")
+	fs.IntVar(&cfg.MinSlowQueries, "metrics.index-advisor.min-slow-queries", defaultMinSlowQueries, "Number of slow queries that would be helped by an index before the index advisor suggests it.")
+	fs.BoolVar(&cfg.AutoCreate, "metrics.index-advisor.auto-create", false, "Create the indexes suggested by the index advisor. The indexes are created concurrently, without blocking ingestion.")
+	fs.DurationVar(&cfg.RunFrequency, "metrics.index-advisor.run-frequency", defaultRunFrequency, "How often the index advisor creates the suggested indexes when -metrics.index-advisor.auto-create is set.")
+	return cfg
+}
+
+// Validate checks the index advisor flags.
+func Validate(cfg *Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.SlowQueryThreshold <= 0 {
+		return fmt.Errorf("metrics.index-advisor.slow-query-threshold must be positive: %s", cfg.SlowQueryThreshold)
+	}
+	if cfg.MinSlowQueries < 1 {
+		return fmt.Errorf("metrics.index-advisor.min-slow-queries must be at least 1: %d", cfg.MinSlowQueries)
+	}
+	if cfg.RunFrequency <= 0 {
+		return fmt.Errorf("metrics.index-advisor.run-frequency must be positive: %s", cfg.RunFrequency)
+	}
+	return nil
+}
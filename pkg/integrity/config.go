@@ -0,0 +1,48 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package integrity
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRunFrequency = time.Hour
+	defaultSampleSize   = 10
+)
+
+// Config holds the integrity verifier flags.
+type Config struct {
+	Enabled      bool
+	RunFrequency time.Duration
+	SampleSize   int
+}
+
+// ParseFlags registers the integrity verifier flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "integrity.enabled", false, "Periodically verify a sample of the compressed chunks of the metric tables: the row counts of the compressed data, "+
+		"of the decompressed data and of the TimescaleDB catalog must match, and the checksum of the decompressed data must not change between runs. "+
+		"Discrepancies are reported in metrics and by the /api/v1/integrity endpoint.")
+	fs.DurationVar(&cfg.RunFrequency, "integrity.run-frequency", defaultRunFrequency, "How often the integrity verifier runs.")
+	fs.IntVar(&cfg.SampleSize, "integrity.sample-size", defaultSampleSize, "Number of compressed chunks, picked at random, verified in each run. "+
+		"Every chunk is decompressed in memory to be verified.")
+	return cfg
+}
+
+// Validate checks the integrity verifier flags.
+func Validate(cfg *Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.RunFrequency <= 0 {
+		return fmt.Errorf("integrity.run-frequency must be positive: %s", cfg.RunFrequency)
+	}
+	if cfg.SampleSize < 1 {
+		return fmt.Errorf("integrity.sample-size must be at least 1: %d", cfg.SampleSize)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package integrity verifies a sample of the compressed chunks of the metric
+// tables to catch silent data corruption early.
+package integrity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// maxDiscrepancies is the number of discrepancies kept for the report.
+	maxDiscrepancies = 100
+
+	// Only the chunks that are fully compressed (status 1) are sampled. Rows
+	// inserted in a compressed chunk are stored uncompressed and would not be
+	// counted in the compressed data or in the catalog.
+	sampleChunksSQL = `SELECT
+	c.id,
+	format('%I.%I', c.schema_name, c.table_name),
+	cc.id,
+	format('%I.%I', cc.schema_name, cc.table_name),
+	s.numrows_pre_compression
+FROM _timescaledb_catalog.chunk c
+INNER JOIN _timescaledb_catalog.hypertable h ON (h.id = c.hypertable_id)
+INNER JOIN _timescaledb_catalog.chunk cc ON (cc.id = c.compressed_chunk_id)
+LEFT JOIN _timescaledb_catalog.compression_chunk_size s ON (s.chunk_id = c.id AND s.compressed_chunk_id = cc.id)
+WHERE h.schema_name = $1 AND NOT c.dropped AND c.status = 1
+ORDER BY random()
+LIMIT $2`
+	compressedRowsSQLFmt = "SELECT coalesce(sum(_ts_meta_count), 0)::bigint FROM %s"
+	// The checksum is a sum of the row hashes so that it does not depend on
+	// the order in which the rows are decompressed.
+	decompressedRowsSQLFmt = "SELECT count(*), coalesce(sum(hashtext(t::text)::bigint), 0)::bigint FROM %s t"
+)
+
+// Names of the checks reported in the discrepancies.
+const (
+	CheckCompressedRows   = "compressed_rows"
+	CheckDecompressedRows = "decompressed_rows"
+	CheckChecksum         = "checksum"
+)
+
+var (
+	chunksVerified = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "integrity",
+			Name:      "chunks_verified_total",
+			Help:      "Total number of compressed chunks verified by the integrity verifier.",
+		},
+	)
+	discrepancies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "integrity",
+			Name:      "discrepancies_total",
+			Help:      "Total number of discrepancies found by the integrity verifier, by check.",
+		}, []string{"check"},
+	)
+	verificationErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "integrity",
+			Name:      "errors_total",
+			Help:      "Total number of chunks the integrity verifier failed to read.",
+		},
+	)
+	lastRun = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "integrity",
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix timestamp of the last completed run of the integrity verifier.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(chunksVerified, discrepancies, verificationErrors, lastRun)
+}
+
+// Discrepancy is a mismatch found while verifying a chunk.
+type Discrepancy struct {
+	Time     time.Time `json:"time"`
+	Chunk    string    `json:"chunk"`
+	Check    string    `json:"check"`
+	Expected int64     `json:"expected"`
+	Actual   int64     `json:"actual"`
+}
+
+// Report is the state of the integrity verifier.
+type Report struct {
+	LastRun        *time.Time    `json:"lastRun,omitempty"`
+	ChunksVerified int64         `json:"chunksVerified"`
+	Errors         int64         `json:"errors"`
+	Discrepancies  []Discrepancy `json:"discrepancies"`
+}
+
+type chunk struct {
+	id             int64
+	name           string
+	compressedID   int64
+	compressedName string
+	// catalogRows is the number of rows recorded by TimescaleDB when the
+	// chunk was compressed. It is unknown for chunks compressed by old
+	// TimescaleDB versions.
+	catalogRows *int64
+}
+
+type observation struct {
+	compressedRows   int64
+	decompressedRows int64
+	checksum         int64
+}
+
+type checksum struct {
+	compressedID int64
+	value        int64
+}
+
+// Verifier periodically samples compressed chunks and checks that their row
+// counts and checksums match the expectations. A nil Verifier is disabled.
+type Verifier struct {
+	cfg Config
+
+	mu sync.Mutex
+	// checksums are the checksums of the chunks verified before, by chunk id.
+	checksums map[int64]checksum
+	report    Report
+}
+
+// NewVerifier returns a Verifier, or nil if it is disabled.
+func NewVerifier(cfg Config) *Verifier {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Verifier{
+		cfg:       cfg,
+		checksums: make(map[int64]checksum),
+		report:    Report{Discrepancies: []Discrepancy{}},
+	}
+}
+
+// Run verifies a sample of chunks every run frequency until ctx is done.
+func (v *Verifier) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if v == nil {
+		return
+	}
+	ticker := time.NewTicker(v.cfg.RunFrequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.Verify(ctx, conn); err != nil {
+				log.Error("msg", "integrity verifier failed", "err", err)
+			}
+		}
+	}
+}
+
+// Verify checks a random sample of compressed chunks.
+func (v *Verifier) Verify(ctx context.Context, conn pgxconn.PgxConn) error {
+	chunks, err := sampleChunks(ctx, conn, v.cfg.SampleSize)
+	if err != nil {
+		return fmt.Errorf("sampling chunks: %w", err)
+	}
+	found := 0
+	for _, c := range chunks {
+		o, err := observe(ctx, conn, c)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// The chunk may have been dropped or decompressed since it was sampled.
+			log.Warn("msg", "integrity verifier could not read chunk", "chunk", c.name, "err", err)
+			verificationErrors.Inc()
+			v.mu.Lock()
+			v.report.Errors++
+			v.mu.Unlock()
+			continue
+		}
+		found += len(v.check(c, o, time.Now()))
+	}
+	now := time.Now()
+	lastRun.Set(float64(now.Unix()))
+	v.mu.Lock()
+	v.report.LastRun = &now
+	v.mu.Unlock()
+	log.Info("msg", "integrity verifier run done", "chunks", len(chunks), "discrepancies", found)
+	return nil
+}
+
+// check compares the observation with the expectations of the chunk, records
+// the discrepancies and returns them.
+func (v *Verifier) check(c chunk, o observation, now time.Time) []Discrepancy {
+	var found []Discrepancy
+	add := func(check string, expected, actual int64) {
+		found = append(found, Discrepancy{Time: now, Chunk: c.name, Check: check, Expected: expected, Actual: actual})
+	}
+
+	if c.catalogRows != nil {
+		if o.compressedRows != *c.catalogRows {
+			add(CheckCompressedRows, *c.catalogRows, o.compressedRows)
+		}
+		if o.decompressedRows != *c.catalogRows {
+			add(CheckDecompressedRows, *c.catalogRows, o.decompressedRows)
+		}
+	} else if o.decompressedRows != o.compressedRows {
+		add(CheckDecompressedRows, o.compressedRows, o.decompressedRows)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// A chunk that was decompressed and compressed again gets a new
+	// compressed chunk, and its data may have legitimately changed.
+	if prev, ok := v.checksums[c.id]; ok && prev.compressedID == c.compressedID && prev.value != o.checksum {
+		add(CheckChecksum, prev.value, o.checksum)
+	}
+	v.checksums[c.id] = checksum{compressedID: c.compressedID, value: o.checksum}
+
+	v.report.ChunksVerified++
+	chunksVerified.Inc()
+	for _, d := range found {
+		log.Error("msg", "integrity verifier found a discrepancy", "chunk", d.Chunk, "check", d.Check, "expected", d.Expected, "actual", d.Actual)
+		discrepancies.WithLabelValues(d.Check).Inc()
+	}
+	v.report.Discrepancies = append(v.report.Discrepancies, found...)
+	if extra := len(v.report.Discrepancies) - maxDiscrepancies; extra > 0 {
+		v.report.Discrepancies = v.report.Discrepancies[extra:]
+	}
+	return found
+}
+
+// Report returns the state of the verifier with the most recent discrepancies.
+func (v *Verifier) Report() Report {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := v.report
+	r.Discrepancies = append([]Discrepancy{}, v.report.Discrepancies...)
+	return r
+}
+
+func sampleChunks(ctx context.Context, conn pgxconn.PgxConn, n int) ([]chunk, error) {
+	rows, err := conn.Query(ctx, sampleChunksSQL, schema.PromData, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []chunk
+	for rows.Next() {
+		var c chunk
+		if err := rows.Scan(&c.id, &c.name, &c.compressedID, &c.compressedName, &c.catalogRows); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func observe(ctx context.Context, conn pgxconn.PgxConn, c chunk) (o observation, err error) {
+	if err = conn.QueryRow(ctx, fmt.Sprintf(compressedRowsSQLFmt, c.compressedName)).Scan(&o.compressedRows); err != nil {
+		return o, fmt.Errorf("counting compressed rows: %w", err)
+	}
+	if err = conn.QueryRow(ctx, fmt.Sprintf(decompressedRowsSQLFmt, c.name)).Scan(&o.decompressedRows, &o.checksum); err != nil {
+		return o, fmt.Errorf("reading decompressed rows: %w", err)
+	}
+	return o, nil
+}
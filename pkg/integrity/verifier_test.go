@@ -0,0 +1,100 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package integrity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{}))
+	require.NoError(t, Validate(&Config{Enabled: true, RunFrequency: time.Hour, SampleSize: 1}))
+	require.Error(t, Validate(&Config{Enabled: true, SampleSize: 1}))
+	require.Error(t, Validate(&Config{Enabled: true, RunFrequency: time.Hour}))
+	require.Nil(t, NewVerifier(Config{}))
+}
+
+func TestCheck(t *testing.T) {
+	rows := int64(100)
+	testCases := []struct {
+		name     string
+		previous *observation
+		chunk    chunk
+		obs      observation
+		expected []string
+	}{
+		{
+			name:  "matching",
+			chunk: chunk{id: 1, compressedID: 2, catalogRows: &rows},
+			obs:   observation{compressedRows: 100, decompressedRows: 100, checksum: 42},
+		},
+		{
+			name:     "compressed rows mismatch",
+			chunk:    chunk{id: 1, compressedID: 2, catalogRows: &rows},
+			obs:      observation{compressedRows: 90, decompressedRows: 100, checksum: 42},
+			expected: []string{CheckCompressedRows},
+		},
+		{
+			name:     "decompressed rows mismatch",
+			chunk:    chunk{id: 1, compressedID: 2, catalogRows: &rows},
+			obs:      observation{compressedRows: 100, decompressedRows: 99, checksum: 42},
+			expected: []string{CheckDecompressedRows},
+		},
+		{
+			name:     "no catalog rows",
+			chunk:    chunk{id: 1, compressedID: 2},
+			obs:      observation{compressedRows: 100, decompressedRows: 99, checksum: 42},
+			expected: []string{CheckDecompressedRows},
+		},
+		{
+			name:     "checksum changed",
+			previous: &observation{compressedRows: 100, decompressedRows: 100, checksum: 41},
+			chunk:    chunk{id: 1, compressedID: 2, catalogRows: &rows},
+			obs:      observation{compressedRows: 100, decompressedRows: 100, checksum: 42},
+			expected: []string{CheckChecksum},
+		},
+		{
+			name:     "checksum changed after recompression",
+			previous: &observation{compressedRows: 100, decompressedRows: 100, checksum: 41},
+			chunk:    chunk{id: 1, compressedID: 3, catalogRows: &rows},
+			obs:      observation{compressedRows: 100, decompressedRows: 100, checksum: 42},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			v := NewVerifier(Config{Enabled: true, RunFrequency: time.Hour, SampleSize: 1})
+			if c.previous != nil {
+				require.Empty(t, v.check(chunk{id: 1, compressedID: 2, catalogRows: &rows}, *c.previous, time.Now()))
+			}
+			found := v.check(c.chunk, c.obs, time.Now())
+			checks := make([]string, 0, len(found))
+			for _, d := range found {
+				checks = append(checks, d.Check)
+			}
+			require.ElementsMatch(t, c.expected, checks)
+
+			report := v.Report()
+			require.Len(t, report.Discrepancies, len(c.expected))
+			if c.previous != nil {
+				require.Equal(t, int64(2), report.ChunksVerified)
+			} else {
+				require.Equal(t, int64(1), report.ChunksVerified)
+			}
+		})
+	}
+}
+
+func TestReportKeepsRecentDiscrepancies(t *testing.T) {
+	v := NewVerifier(Config{Enabled: true, RunFrequency: time.Hour, SampleSize: 1})
+	for i := 0; i < maxDiscrepancies+10; i++ {
+		v.check(chunk{id: int64(i), name: "chunk"}, observation{compressedRows: 1, decompressedRows: int64(i + 2)}, time.Now())
+	}
+	report := v.Report()
+	require.Len(t, report.Discrepancies, maxDiscrepancies)
+	require.Equal(t, int64(maxDiscrepancies+11), report.Discrepancies[maxDiscrepancies-1].Actual)
+}
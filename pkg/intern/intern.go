@@ -0,0 +1,124 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package intern provides a process wide pool of canonical label name and
+// value strings. Parsed write requests, the series builder and the label
+// caches all run their strings through the pool, so a label string that
+// appears in millions of series is kept in memory only once.
+package intern
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// DefaultPoolSize is the default number of strings kept in the pool.
+const DefaultPoolSize = 100000
+
+// stringOverhead is the size of a string header, which is stored once as the
+// key and once as the value of a pool entry.
+const stringOverhead = 32
+
+var (
+	initPool sync.Once
+	pool     atomic.Value // *Pool
+)
+
+// Pool interns strings in a CLOCK based cache. Strings that are evicted from the
+// pool stay valid for whoever holds them, they just stop being shared with
+// strings interned afterwards.
+type Pool struct {
+	cache *clockcache.Cache
+}
+
+// NewPool returns a pool that holds at most size strings. The pool does not
+// register any metrics, use Init to setup the pool shared by Promscale.
+func NewPool(size uint64) *Pool {
+	return &Pool{cache: clockcache.WithMax(size)}
+}
+
+// Init sets up the shared pool with the given capacity. Only the first call has
+// an effect. A size of 0 disables interning and the functions of this package
+// return their input unmodified.
+func Init(size uint64) {
+	initPool.Do(func() {
+		if size == 0 {
+			return
+		}
+		pool.Store(&Pool{cache: clockcache.WithMetrics("interned_strings", "metric", size)})
+	})
+}
+
+func defaultPool() *Pool {
+	p, _ := pool.Load().(*Pool)
+	return p
+}
+
+// String returns the canonical version of s from the pool.
+func (p *Pool) String(s string) string {
+	if p == nil || s == "" {
+		return s
+	}
+	if v, ok := p.cache.Get(s); ok {
+		return v.(string)
+	}
+	v, _ := p.cache.Insert(s, s, uint64(len(s)+stringOverhead))
+	return v.(string)
+}
+
+// Labels replaces the names and values of the given labels with their canonical versions.
+func (p *Pool) Labels(ls []prompb.Label) {
+	if p == nil {
+		return
+	}
+	for i := range ls {
+		ls[i].Name = p.String(ls[i].Name)
+		ls[i].Value = p.String(ls[i].Value)
+	}
+}
+
+// WriteRequest interns the labels of all the series in the write request.
+func (p *Pool) WriteRequest(wr *prompb.WriteRequest) {
+	if p == nil {
+		return
+	}
+	for i := range wr.Timeseries {
+		p.Labels(wr.Timeseries[i].Labels)
+	}
+}
+
+// Len returns the number of strings in the pool.
+func (p *Pool) Len() int {
+	if p == nil {
+		return 0
+	}
+	return p.cache.Len()
+}
+
+// String returns the canonical version of s from the shared pool.
+func String(s string) string {
+	return defaultPool().String(s)
+}
+
+// Label returns a labels.Label whose name and value come from the shared pool.
+func Label(name, value string) labels.Label {
+	p := defaultPool()
+	return labels.Label{Name: p.String(name), Value: p.String(value)}
+}
+
+// Labels replaces the names and values of the given labels with their
+// canonical versions from the shared pool.
+func Labels(ls []prompb.Label) {
+	defaultPool().Labels(ls)
+}
+
+// WriteRequest interns the labels of all the series in the write request
+// using the shared pool.
+func WriteRequest(wr *prompb.WriteRequest) {
+	defaultPool().WriteRequest(wr)
+}
@@ -0,0 +1,172 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package intern
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func stringData(s string) uintptr {
+	return (*[2]uintptr)(unsafe.Pointer(&s))[0]
+}
+
+// copyString returns a copy of s that does not share memory with it, like the
+// strings produced by unmarshalling two different requests.
+func copyString(s string) string {
+	return string([]byte(s))
+}
+
+func TestPoolString(t *testing.T) {
+	p := NewPool(2)
+
+	first := p.String(copyString("job"))
+	second := p.String(copyString("job"))
+	if first != "job" || second != "job" {
+		t.Fatalf("unexpected interned values: %q, %q", first, second)
+	}
+	if stringData(first) != stringData(second) {
+		t.Fatal("interned strings do not share memory")
+	}
+
+	if got := p.String(""); got != "" {
+		t.Fatalf("unexpected value for empty string: %q", got)
+	}
+	if p.Len() != 1 {
+		t.Fatalf("empty string should not be interned, pool has %d elements", p.Len())
+	}
+
+	// Evicted strings are still returned unmodified.
+	p.String("a")
+	p.String("b")
+	p.String("c")
+	if got := p.String(copyString("job")); got != "job" {
+		t.Fatalf("unexpected value after eviction: %q", got)
+	}
+}
+
+func TestNilPool(t *testing.T) {
+	var p *Pool
+	s := copyString("instance")
+	if got := p.String(s); stringData(got) != stringData(s) {
+		t.Fatal("nil pool must return the input string")
+	}
+	ls := []prompb.Label{{Name: "job", Value: "a"}}
+	p.Labels(ls)
+	if ls[0].Name != "job" || ls[0].Value != "a" {
+		t.Fatalf("nil pool modified labels: %v", ls)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("unexpected nil pool length: %d", p.Len())
+	}
+}
+
+func TestPoolWriteRequest(t *testing.T) {
+	p := NewPool(10)
+	wr := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: copyString("__name__"), Value: copyString("up")}, {Name: copyString("job"), Value: copyString("a")}}},
+			{Labels: []prompb.Label{{Name: copyString("__name__"), Value: copyString("up")}, {Name: copyString("job"), Value: copyString("b")}}},
+		},
+	}
+	p.WriteRequest(wr)
+
+	first, second := wr.Timeseries[0].Labels, wr.Timeseries[1].Labels
+	for i := range first {
+		if stringData(first[i].Name) != stringData(second[i].Name) {
+			t.Errorf("label name %q is not shared between series", first[i].Name)
+		}
+	}
+	if stringData(first[0].Value) != stringData(second[0].Value) {
+		t.Error("metric name is not shared between series")
+	}
+	if first[1].Value != "a" || second[1].Value != "b" {
+		t.Errorf("unexpected label values: %v, %v", first, second)
+	}
+	if p.Len() != 5 {
+		t.Errorf("unexpected number of interned strings: %d", p.Len())
+	}
+}
+
+// generateSeries returns the labels of numSeries series that share most of
+// their label names and values, as is common for series coming from the same
+// targets. Every string is a separate allocation, like in a parsed request.
+func generateSeries(numSeries int) [][]prompb.Label {
+	series := make([][]prompb.Label, numSeries)
+	for i := range series {
+		series[i] = []prompb.Label{
+			{Name: copyString("__name__"), Value: fmt.Sprintf("http_requests_total_%d", i%100)},
+			{Name: copyString("cluster"), Value: copyString("production-eu-west-1")},
+			{Name: copyString("container"), Value: copyString("api-server")},
+			{Name: copyString("endpoint"), Value: copyString("http-metrics")},
+			{Name: copyString("instance"), Value: fmt.Sprintf("10.0.%d.%d:9090", i%1000/250, i%250)},
+			{Name: copyString("job"), Value: copyString("kubernetes-service-endpoints")},
+			{Name: copyString("namespace"), Value: copyString("monitoring")},
+			{Name: copyString("node"), Value: fmt.Sprintf("ip-10-0-%d-1.eu-west-1.compute.internal", i%50)},
+			{Name: copyString("pod"), Value: fmt.Sprintf("api-server-%d", i)},
+			{Name: copyString("service"), Value: copyString("api-server-metrics")},
+		}
+	}
+	return series
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// BenchmarkRetainedHeap measures the heap retained by a set of series, with and
+// without interning their labels.
+func BenchmarkRetainedHeap(b *testing.B) {
+	const numSeries = 100000
+	for _, interned := range []bool{false, true} {
+		b.Run(fmt.Sprintf("interned=%v", interned), func(b *testing.B) {
+			b.ReportAllocs()
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				before := heapInUse()
+				b.StartTimer()
+
+				var p *Pool
+				if interned {
+					p = NewPool(DefaultPoolSize)
+				}
+				series := generateSeries(numSeries)
+				for _, ls := range series {
+					p.Labels(ls)
+				}
+
+				b.StopTimer()
+				retained += heapInUse() - before
+				runtime.KeepAlive(series)
+				runtime.KeepAlive(p)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/numSeries, "retained-B/series")
+		})
+	}
+}
+
+// BenchmarkInternLabels measures the cost of interning the labels of a series
+// whose strings are already in the pool, which is the common case at ingest.
+func BenchmarkInternLabels(b *testing.B) {
+	series := generateSeries(1000)
+	p := NewPool(DefaultPoolSize)
+	for _, ls := range series {
+		p.Labels(ls)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Labels(series[i%len(series)])
+	}
+}
@@ -1,6 +1,8 @@
 package jaeger
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	jaegerQueryApp "github.com/jaegertracing/jaeger/cmd/query/app"
 	jaegerQueryService "github.com/jaegertracing/jaeger/cmd/query/app/querysvc"
@@ -16,4 +18,6 @@ func ExtendQueryAPIs(r *mux.Router, conn pgxconn.PgxConn, reader *store.Store) {
 		tenancy.NewManager(&tenancy.Options{Enabled: false}),
 	)
 	handler.RegisterRoutes(r)
+	r.Path(serviceInventoryPath).Methods(http.MethodGet).HandlerFunc(serviceInventoryHandler(reader))
+	r.Path(serviceGraphPath).Methods(http.MethodGet).HandlerFunc(serviceGraphHandler(reader))
 }
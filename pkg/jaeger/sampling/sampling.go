@@ -0,0 +1,271 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package sampling stores the sampling strategies returned to the Jaeger
+// clients configured with a remote sampler, e.g. JAEGER_SAMPLER_TYPE=remote.
+// The strategies follow the JSON format of the Jaeger remote sampling
+// protocol, and are stored per service in the database so that all the
+// Promscale instances return the same strategies.
+package sampling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// DefaultService is the service name of the strategy returned to the
+// services without a strategy of their own.
+const DefaultService = "*"
+
+// Strategy types of the Jaeger remote sampling protocol.
+const (
+	Probabilistic = "PROBABILISTIC"
+	RateLimiting  = "RATE_LIMITING"
+)
+
+// defaultSamplingRate is the sampling rate of the Jaeger collector when no
+// strategy is configured.
+const defaultSamplingRate = 0.001
+
+// cacheTTL is how long the strategies are cached. Jaeger clients poll their
+// strategy every minute by default.
+const cacheTTL = 10 * time.Second
+
+const (
+	upsertSQL = `INSERT INTO _ps_trace.sampling_strategy (service_name, strategy) VALUES ($1, $2)
+ON CONFLICT (service_name) DO UPDATE SET strategy = excluded.strategy, updated = now()`
+	deleteSQL = "DELETE FROM _ps_trace.sampling_strategy WHERE service_name = $1"
+	selectSQL = "SELECT service_name, strategy FROM _ps_trace.sampling_strategy ORDER BY service_name"
+)
+
+// ErrNotFound is returned when the strategy to delete does not exist.
+var ErrNotFound = errors.New("sampling strategy not found")
+
+// Strategy is a sampling strategy in the format of the Jaeger remote sampling
+// protocol.
+type Strategy struct {
+	StrategyType          string                 `json:"strategyType"`
+	ProbabilisticSampling *ProbabilisticSampling `json:"probabilisticSampling,omitempty"`
+	RateLimitingSampling  *RateLimitingSampling  `json:"rateLimitingSampling,omitempty"`
+	OperationSampling     *PerOperationSampling  `json:"operationSampling,omitempty"`
+}
+
+type ProbabilisticSampling struct {
+	SamplingRate float64 `json:"samplingRate"`
+}
+
+type RateLimitingSampling struct {
+	MaxTracesPerSecond int32 `json:"maxTracesPerSecond"`
+}
+
+// PerOperationSampling sets the sampling rate of each operation of the
+// service. Jaeger clients supporting it use it instead of the strategy type.
+type PerOperationSampling struct {
+	DefaultSamplingProbability       float64                  `json:"defaultSamplingProbability"`
+	DefaultLowerBoundTracesPerSecond float64                  `json:"defaultLowerBoundTracesPerSecond"`
+	DefaultUpperBoundTracesPerSecond *float64                 `json:"defaultUpperBoundTracesPerSecond,omitempty"`
+	PerOperationStrategies           []OperationSamplingRates `json:"perOperationStrategies"`
+}
+
+type OperationSamplingRates struct {
+	Operation             string                `json:"operation"`
+	ProbabilisticSampling ProbabilisticSampling `json:"probabilisticSampling"`
+}
+
+// DefaultStrategy is returned when neither the service nor DefaultService
+// have a strategy.
+func DefaultStrategy() Strategy {
+	return Strategy{
+		StrategyType:          Probabilistic,
+		ProbabilisticSampling: &ProbabilisticSampling{SamplingRate: defaultSamplingRate},
+	}
+}
+
+// Validate checks the strategy, and sets the strategy type if it is empty.
+func (s *Strategy) Validate() error {
+	if s.StrategyType == "" {
+		switch {
+		case s.ProbabilisticSampling != nil && s.RateLimitingSampling == nil:
+			s.StrategyType = Probabilistic
+		case s.RateLimitingSampling != nil && s.ProbabilisticSampling == nil:
+			s.StrategyType = RateLimiting
+		}
+	}
+	switch s.StrategyType {
+	case Probabilistic:
+		if s.ProbabilisticSampling == nil {
+			return fmt.Errorf("probabilisticSampling must be set for the %s strategy", Probabilistic)
+		}
+		if s.RateLimitingSampling != nil {
+			return fmt.Errorf("rateLimitingSampling must not be set for the %s strategy", Probabilistic)
+		}
+		if err := validateRate(s.ProbabilisticSampling.SamplingRate); err != nil {
+			return err
+		}
+	case RateLimiting:
+		if s.RateLimitingSampling == nil {
+			return fmt.Errorf("rateLimitingSampling must be set for the %s strategy", RateLimiting)
+		}
+		if s.ProbabilisticSampling != nil {
+			return fmt.Errorf("probabilisticSampling must not be set for the %s strategy", RateLimiting)
+		}
+		if s.RateLimitingSampling.MaxTracesPerSecond < 0 {
+			return fmt.Errorf("maxTracesPerSecond must not be negative")
+		}
+	default:
+		return fmt.Errorf("strategyType must be %s or %s, got %q", Probabilistic, RateLimiting, s.StrategyType)
+	}
+	if o := s.OperationSampling; o != nil {
+		if err := validateRate(o.DefaultSamplingProbability); err != nil {
+			return fmt.Errorf("defaultSamplingProbability: %w", err)
+		}
+		if o.DefaultLowerBoundTracesPerSecond < 0 {
+			return fmt.Errorf("defaultLowerBoundTracesPerSecond must not be negative")
+		}
+		if o.DefaultUpperBoundTracesPerSecond != nil && *o.DefaultUpperBoundTracesPerSecond < o.DefaultLowerBoundTracesPerSecond {
+			return fmt.Errorf("defaultUpperBoundTracesPerSecond must not be lower than defaultLowerBoundTracesPerSecond")
+		}
+		seen := make(map[string]struct{}, len(o.PerOperationStrategies))
+		for _, op := range o.PerOperationStrategies {
+			if op.Operation == "" {
+				return fmt.Errorf("operation of a per-operation strategy must be set")
+			}
+			if _, ok := seen[op.Operation]; ok {
+				return fmt.Errorf("duplicate per-operation strategy for %q", op.Operation)
+			}
+			seen[op.Operation] = struct{}{}
+			if err := validateRate(op.ProbabilisticSampling.SamplingRate); err != nil {
+				return fmt.Errorf("operation %q: %w", op.Operation, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("samplingRate must be within [0, 1], got %v", rate)
+	}
+	return nil
+}
+
+// Store reads and writes the sampling strategies.
+type Store struct {
+	conn pgxconn.PgxConn
+
+	mu         sync.Mutex
+	strategies map[string]Strategy
+	loaded     time.Time
+}
+
+// NewStore returns a Store using the connection.
+func NewStore(conn pgxconn.PgxConn) *Store {
+	return &Store{conn: conn}
+}
+
+// Get returns the strategy of the service, or the default strategy.
+func (s *Store) Get(ctx context.Context, service string) (Strategy, error) {
+	strategies, err := s.cached(ctx)
+	if err != nil {
+		return Strategy{}, err
+	}
+	if st, ok := strategies[service]; ok {
+		return st, nil
+	}
+	if st, ok := strategies[DefaultService]; ok {
+		return st, nil
+	}
+	return DefaultStrategy(), nil
+}
+
+// List returns the strategies of all the services, by service.
+func (s *Store) List(ctx context.Context) (map[string]Strategy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	res := make(map[string]Strategy, len(s.strategies))
+	for k, v := range s.strategies {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// Put sets the strategy of the service. The strategy must be valid.
+func (s *Store) Put(ctx context.Context, service string, st Strategy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.Exec(ctx, upsertSQL, service, string(value)); err != nil {
+		return fmt.Errorf("storing sampling strategy: %w", err)
+	}
+	s.strategies = nil
+	return nil
+}
+
+// Delete removes the strategy of the service.
+func (s *Store) Delete(ctx context.Context, service string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.conn.Exec(ctx, deleteSQL, service)
+	if err != nil {
+		return fmt.Errorf("deleting sampling strategy: %w", err)
+	}
+	s.strategies = nil
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// cached returns the strategies loaded less than cacheTTL ago.
+func (s *Store) cached(ctx context.Context) (map[string]Strategy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.strategies == nil || time.Since(s.loaded) > cacheTTL {
+		if err := s.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return s.strategies, nil
+}
+
+// load reads all the strategies. It must be called with the lock held.
+func (s *Store) load(ctx context.Context) error {
+	strategies := make(map[string]Strategy)
+	rows, err := s.conn.Query(ctx, selectSQL)
+	if err != nil {
+		return fmt.Errorf("querying sampling strategies: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			service string
+			value   []byte
+			st      Strategy
+		)
+		if err := rows.Scan(&service, &value); err != nil {
+			return fmt.Errorf("reading sampling strategy: %w", err)
+		}
+		if err := json.Unmarshal(value, &st); err != nil {
+			return fmt.Errorf("decoding sampling strategy of %q: %w", service, err)
+		}
+		strategies[service] = st
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading sampling strategies: %w", err)
+	}
+	s.strategies, s.loaded = strategies, time.Now()
+	return nil
+}
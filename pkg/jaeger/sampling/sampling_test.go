@@ -0,0 +1,85 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package sampling
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name         string
+		strategy     string
+		expectedType string
+		expectError  bool
+	}{
+		{
+			name:         "probabilistic",
+			strategy:     `{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.5}}`,
+			expectedType: Probabilistic,
+		},
+		{
+			name:         "rate limiting without type",
+			strategy:     `{"rateLimitingSampling":{"maxTracesPerSecond":10}}`,
+			expectedType: RateLimiting,
+		},
+		{
+			name: "per operation",
+			strategy: `{"probabilisticSampling":{"samplingRate":0.1},"operationSampling":{"defaultSamplingProbability":0.1,"defaultLowerBoundTracesPerSecond":1,
+				"perOperationStrategies":[{"operation":"GET /","probabilisticSampling":{"samplingRate":1}}]}}`,
+			expectedType: Probabilistic,
+		},
+		{
+			name:        "missing sampling",
+			strategy:    `{"strategyType":"RATE_LIMITING"}`,
+			expectError: true,
+		},
+		{
+			name:        "both samplings",
+			strategy:    `{"probabilisticSampling":{"samplingRate":0.5},"rateLimitingSampling":{"maxTracesPerSecond":10}}`,
+			expectError: true,
+		},
+		{
+			name:        "rate above 1",
+			strategy:    `{"probabilisticSampling":{"samplingRate":1.5}}`,
+			expectError: true,
+		},
+		{
+			name:        "negative traces per second",
+			strategy:    `{"rateLimitingSampling":{"maxTracesPerSecond":-1}}`,
+			expectError: true,
+		},
+		{
+			name: "duplicate operation",
+			strategy: `{"probabilisticSampling":{"samplingRate":0.1},"operationSampling":{"defaultSamplingProbability":0.1,
+				"perOperationStrategies":[{"operation":"a","probabilisticSampling":{"samplingRate":1}},{"operation":"a","probabilisticSampling":{"samplingRate":1}}]}}`,
+			expectError: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var st Strategy
+			require.NoError(t, json.Unmarshal([]byte(c.strategy), &st))
+			err := st.Validate()
+			if c.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedType, st.StrategyType)
+		})
+	}
+}
+
+func TestDefaultStrategy(t *testing.T) {
+	st := DefaultStrategy()
+	require.NoError(t, st.Validate())
+	b, err := json.Marshal(st)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.001}}`, string(b))
+}
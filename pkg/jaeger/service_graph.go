@@ -0,0 +1,76 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package jaeger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/timescale/promscale/pkg/jaeger/store"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/servicegraph"
+)
+
+// serviceGraphPath is the endpoint returning the calls between services as
+// the nodes and edges of a Grafana node graph.
+const serviceGraphPath = "/api/service-graph"
+
+// defaultServiceGraphLookback is the default lookback of the Jaeger
+// dependencies endpoint.
+const defaultServiceGraphLookback = 24 * time.Hour
+
+type serviceGraphResponse struct {
+	Data   *servicegraph.NodeGraph `json:"data"`
+	Errors []inventoryError        `json:"errors"`
+}
+
+func serviceGraphHandler(reader *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := serviceGraphResponse{}
+		status := http.StatusOK
+
+		endTs, lookback, err := parseServiceGraphRange(r, time.Now())
+		if err != nil {
+			status = http.StatusBadRequest
+			resp.Errors = []inventoryError{{Code: status, Msg: err.Error()}}
+		} else if edges, err := reader.GetServiceGraph(r.Context(), endTs, lookback); err != nil {
+			status = http.StatusInternalServerError
+			resp.Errors = []inventoryError{{Code: status, Msg: err.Error()}}
+		} else {
+			graph := servicegraph.NewNodeGraph(edges)
+			resp.Data = &graph
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error("msg", "error writing service graph response", "err", err)
+		}
+	}
+}
+
+// parseServiceGraphRange parses the endTs and lookback parameters in
+// milliseconds, like the Jaeger dependencies endpoint.
+func parseServiceGraphRange(r *http.Request, now time.Time) (time.Time, time.Duration, error) {
+	endTs, lookback := now, defaultServiceGraphLookback
+	if s := r.FormValue("endTs"); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return endTs, lookback, fmt.Errorf("invalid endTs %q: %w", s, err)
+		}
+		endTs = time.UnixMilli(ms)
+	}
+	if s := r.FormValue("lookback"); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || ms <= 0 {
+			return endTs, lookback, fmt.Errorf("invalid lookback %q: must be a positive number of milliseconds", s)
+		}
+		lookback = time.Duration(ms) * time.Millisecond
+	}
+	return endTs, lookback, nil
+}
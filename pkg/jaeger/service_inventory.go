@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package jaeger
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/timescale/promscale/pkg/jaeger/store"
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// serviceInventoryPath is the endpoint returning the known services, their
+// operations and the last time they were seen.
+const serviceInventoryPath = "/api/inventory/services"
+
+// The responses follow the format of the Jaeger query APIs.
+type inventoryResponse struct {
+	Data   []store.ServiceInfo `json:"data"`
+	Total  int                 `json:"total"`
+	Errors []inventoryError    `json:"errors"`
+}
+
+type inventoryError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func serviceInventoryHandler(reader *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := inventoryResponse{}
+		status := http.StatusOK
+
+		services, err := reader.GetServiceInventory(r.Context(), r.URL.Query().Get("service"))
+		if err != nil {
+			status = http.StatusInternalServerError
+			resp.Errors = []inventoryError{{Code: status, Msg: err.Error()}}
+		} else {
+			resp.Data = services
+			resp.Total = len(services)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error("msg", "error writing service inventory response", "err", err)
+		}
+	}
+}
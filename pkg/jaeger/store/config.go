@@ -2,29 +2,43 @@ package store
 
 import (
 	"flag"
+	"fmt"
 	"time"
 )
 
 const (
 	DefaultMaxTraceDuration = time.Hour
+	// DefaultFindTracesSpanLimit leaves 500 matching spans per trace for
+	// the 20 traces the Jaeger UI searches by default.
+	DefaultFindTracesSpanLimit = 10000
 )
 
 type Config struct {
 	MaxTraceDuration    time.Duration
 	StreamingSpanWriter bool
+	// FindTracesSpanLimit is the number of the latest matching spans a
+	// trace search with a limit reads, 0 reads all of them.
+	FindTracesSpanLimit int
 }
 
 var DefaultConfig = Config{
 	MaxTraceDuration:    DefaultMaxTraceDuration,
 	StreamingSpanWriter: true,
+	FindTracesSpanLimit: DefaultFindTracesSpanLimit,
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.DurationVar(&cfg.MaxTraceDuration, "tracing.max-trace-duration", DefaultMaxTraceDuration, "Maximum duration of any trace in the system. This parameter is used to optimize queries.")
 	fs.BoolVar(&cfg.StreamingSpanWriter, "tracing.streaming-span-writer", true, "StreamingSpanWriter for remote Jaeger grpc store.")
+	fs.IntVar(&cfg.FindTracesSpanLimit, "tracing.find-traces.span-limit", DefaultFindTracesSpanLimit, "Number of the latest spans matching a Jaeger trace search read to find "+
+		"its traces, so that the search stops early instead of reading every matching span. Fewer traces than the limit of the search are returned if "+
+		"the spans belong to fewer traces. All the matching spans are read if 0.")
 	return cfg
 }
 
 func Validate(cfg *Config) error {
+	if cfg.FindTracesSpanLimit < 0 {
+		return fmt.Errorf("tracing.find-traces.span-limit must not be negative")
+	}
 	return nil
 }
@@ -0,0 +1,84 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// The operation table is maintained by the trace ingestor and holds every
+// known combination of service, span name and span kind.
+const getServiceInventorySQL = `
+SELECT
+	s.value#>>'{}',
+	o.span_name,
+	o.span_kind::text
+FROM
+	_ps_trace.operation o
+INNER JOIN
+	_ps_trace.tag s ON (s.id = o.service_name_id AND s.key = 'service.name' AND s.key_id = 1)
+WHERE
+	$1::text IS NULL OR _prom_ext.jsonb_digest(s.value) = _prom_ext.jsonb_digest(to_jsonb($1::text))
+ORDER BY 1, 2, 3`
+
+// ServiceInfo describes a service and the operations seen in its spans.
+type ServiceInfo struct {
+	Name string `json:"name"`
+	// LastSeen is the end time of the latest span of the service, or nil
+	// if no span of the service was ingested since Promscale started.
+	LastSeen   *time.Time      `json:"lastSeen"`
+	Operations []OperationInfo `json:"operations"`
+}
+
+// OperationInfo describes an operation of a service.
+type OperationInfo struct {
+	Name     string     `json:"name"`
+	SpanKind string     `json:"spanKind"`
+	LastSeen *time.Time `json:"lastSeen"`
+}
+
+// getServiceInventory returns the known services and their operations. All
+// services are returned if serviceName is empty.
+func getServiceInventory(ctx context.Context, conn pgxconn.PgxConn, serviceName string) ([]ServiceInfo, error) {
+	var serviceArg interface{}
+	if serviceName != "" {
+		serviceArg = serviceName
+	}
+	rows, err := conn.Query(ctx, getServiceInventorySQL, serviceArg)
+	if err != nil {
+		return nil, fmt.Errorf("fetching service inventory: %w", err)
+	}
+	defer rows.Close()
+
+	services := []ServiceInfo{}
+	for rows.Next() {
+		var service, spanName, spanKind string
+		if err := rows.Scan(&service, &spanName, &spanKind); err != nil {
+			return nil, fmt.Errorf("scanning service inventory: %w", err)
+		}
+		if len(services) == 0 || services[len(services)-1].Name != service {
+			services = append(services, ServiceInfo{Name: service, Operations: []OperationInfo{}})
+		}
+		current := &services[len(services)-1]
+
+		op := OperationInfo{Name: spanName, SpanKind: spanKind}
+		if lastSeen, ok := trace.OperationLastSeen(service, spanName, spanKind); ok {
+			op.LastSeen = &lastSeen
+			if current.LastSeen == nil || lastSeen.After(*current.LastSeen) {
+				current.LastSeen = op.LastSeen
+			}
+		}
+		current.Operations = append(current.Operations, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetching service inventory: %w", err)
+	}
+	return services, nil
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/servicegraph"
 )
 
 type Store struct {
@@ -114,6 +115,23 @@ func (p *Store) GetOperations(ctx context.Context, query spanstore.OperationQuer
 	return res, nil
 }
 
+// GetServiceInventory returns the known services with their operations and
+// the last time they were seen. All services are returned if serviceName is empty.
+func (p *Store) GetServiceInventory(ctx context.Context, serviceName string) ([]ServiceInfo, error) {
+	code := "5xx"
+	start := time.Now()
+	defer func() {
+		metrics.Query.With(prometheus.Labels{"type": "trace", "handler": "Get_Service_Inventory", "code": code}).Inc()
+		metrics.QueryDuration.With(prometheus.Labels{"type": "trace", "handler": "Get_Service_Inventory", "code": code}).Observe(time.Since(start).Seconds())
+	}()
+	res, err := getServiceInventory(ctx, p.conn, serviceName)
+	if err != nil {
+		return nil, logError(err)
+	}
+	code = "2xx"
+	return res, nil
+}
+
 func (p *Store) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
 	code := "5xx"
 	start := time.Now()
@@ -154,7 +172,26 @@ func (p *Store) GetDependencies(ctx context.Context, endTs time.Time, lookback t
 		metrics.QueryDuration.With(prometheus.Labels{"type": "trace", "handler": "Get_Dependencies", "code": code}).Observe(time.Since(start).Seconds())
 	}()
 
-	res, err := getDependencies(ctx, p.conn, endTs, lookback)
+	res, err := getDependencies(ctx, p.conn, endTs, lookback, p.builder.cfg.MaxTraceDuration)
+	if err != nil {
+		return nil, logError(err)
+	}
+	code = "2xx"
+	dependencyRequestsExec.Add(1)
+	return res, nil
+}
+
+// GetServiceGraph returns the calls between services of the spans starting
+// within the lookback before endTs, with their error counts and durations.
+func (p *Store) GetServiceGraph(ctx context.Context, endTs time.Time, lookback time.Duration) ([]servicegraph.Edge, error) {
+	code := "5xx"
+	start := time.Now()
+	defer func() {
+		metrics.Query.With(prometheus.Labels{"type": "trace", "handler": "Get_Service_Graph", "code": code}).Inc()
+		metrics.QueryDuration.With(prometheus.Labels{"type": "trace", "handler": "Get_Service_Graph", "code": code}).Observe(time.Since(start).Seconds())
+	}()
+
+	res, err := servicegraph.Edges(ctx, p.conn, endTs.Add(-lookback), endTs, p.builder.cfg.MaxTraceDuration)
 	if err != nil {
 		return nil, logError(err)
 	}
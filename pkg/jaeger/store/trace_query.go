@@ -83,14 +83,32 @@ const (
 		SELECT
 			s.trace_id,
 			max(start_time) as start_time_max
-		FROM _ps_trace.span s
+		FROM %[2]s s
 		WHERE
-			%[2]s
+			%[3]s
 		GROUP BY s.trace_id
 	) as trace_sub
 	ORDER BY trace_sub.start_time_max DESC
 	`
 
+	// The latest matching spans are read through the (operation_id,
+	// start_time DESC) index, which includes the trace_id and duration_ms,
+	// and the search stops once the span limit is reached instead of
+	// grouping every matching span by trace.
+	latestSpansFormat = `(
+		SELECT s.trace_id, s.start_time
+		FROM _ps_trace.span s
+		WHERE
+			%[1]s
+		ORDER BY s.start_time DESC
+		LIMIT %[2]d
+	)`
+
+	// maxTagContainments is the number of tag values matching a tag above
+	// which the tag is matched with @> ANY, which cannot use the GIN index
+	// on the tags, instead of an OR of @> using it.
+	maxTagContainments = 8
+
 	/* PostgreSQL badly overestimates the number of rows returned if the complete trace query
 	uses an IN clause on trace_id, but gives good estimates for equality conditions. So, leverage an INNER
 	JOIN LATERAL to provide an equality condition on the complete trace. */
@@ -230,16 +248,17 @@ func (b *Builder) buildTagClauses(q *spanstore.TraceQueryParameters, tInfo *tags
 	clauses := make([]string, 0, len(tInfo.generalTags))
 	for _, tag := range tInfo.generalTags {
 		tagClauses := make([]string, 0, 3)
-		params = append(params, tag.jsonbPairArray)
+		var contains func(column string) string
+		contains, params = containsTag(tag.jsonbPairArray, params)
 		if tag.isSpan {
-			tagClauses = append(tagClauses, fmt.Sprintf("(s.span_tags @> ANY($%d::jsonb[]))", len(params)))
+			tagClauses = append(tagClauses, contains("s.span_tags"))
 		}
 		if tag.isResource {
-			tagClauses = append(tagClauses, fmt.Sprintf("(s.resource_tags @> ANY($%d::jsonb[]))", len(params)))
+			tagClauses = append(tagClauses, contains("s.resource_tags"))
 		}
 		if tag.isEvent {
 			var subquery string
-			subquery, params = b.buildEventSubquery(q, []string{fmt.Sprintf("(e.tags @> ANY($%d::jsonb[]))", len(params))}, params)
+			subquery, params = b.buildEventSubquery(q, []string{contains("e.tags")}, params)
 			tagClauses = append(tagClauses, fmt.Sprintf("EXISTS(%s)", subquery))
 		}
 		clauses = append(clauses, "("+strings.Join(tagClauses, " OR ")+")")
@@ -248,6 +267,30 @@ func (b *Builder) buildTagClauses(q *spanstore.TraceQueryParameters, tInfo *tags
 
 }
 
+// containsTag returns the clause of a tag map column containing one of the
+// pairs of a tag. Each pair is a parameter of its own so that the GIN index
+// of the column is used, unless the tag has too many values.
+func containsTag(pairs [][]byte, params []interface{}) (func(column string) string, []interface{}) {
+	if len(pairs) > maxTagContainments {
+		params = append(params, pairs)
+		n := len(params)
+		return func(column string) string {
+			return fmt.Sprintf("(%s @> ANY($%d::jsonb[]))", column, n)
+		}, params
+	}
+	first := len(params) + 1
+	for _, p := range pairs {
+		params = append(params, p)
+	}
+	return func(column string) string {
+		ors := make([]string, len(pairs))
+		for i := range pairs {
+			ors[i] = fmt.Sprintf("%s @> $%d::jsonb", column, first+i)
+		}
+		return "(" + strings.Join(ors, " OR ") + ")"
+	}, params
+}
+
 func (b *Builder) BuildTraceIDSubquery(q *spanstore.TraceQueryParameters, tInfo *tagsInfo) (string, []interface{}) {
 	clauses := make([]string, 0, 15)
 	params := tInfo.params
@@ -285,25 +328,16 @@ func (b *Builder) BuildTraceIDSubquery(q *spanstore.TraceQueryParameters, tInfo
 		clauses = append(clauses, fmt.Sprintf(`s.start_time <= $%d`, len(params)))
 	}
 
+	// The durations are compared with the duration_ms column, included in
+	// the operation index, which holds the fractions of milliseconds.
 	var defaultDuration time.Duration
 	if q.DurationMin != defaultDuration {
-		if q.DurationMin%time.Millisecond == 0 {
-			params = append(params, q.DurationMin.Milliseconds())
-			clauses = append(clauses, fmt.Sprintf(`duration_ms >= $%d`, len(params)))
-		} else {
-			params = append(params, q.DurationMin)
-			clauses = append(clauses, fmt.Sprintf(`(end_time - start_time ) >= $%d`, len(params)))
-		}
+		params = append(params, durationMs(q.DurationMin))
+		clauses = append(clauses, fmt.Sprintf(`s.duration_ms >= $%d`, len(params)))
 	}
 	if q.DurationMax != defaultDuration {
-		if q.DurationMax%time.Millisecond == 0 {
-			params = append(params, q.DurationMax.Milliseconds())
-			clauses = append(clauses, fmt.Sprintf(`duration_ms <= $%d`, len(params)))
-		} else {
-			params = append(params, q.DurationMax)
-			clauses = append(clauses, fmt.Sprintf(`(end_time - start_time ) <= $%d`, len(params)))
-		}
-
+		params = append(params, durationMs(q.DurationMax))
+		clauses = append(clauses, fmt.Sprintf(`s.duration_ms <= $%d`, len(params)))
 	}
 
 	clauseString := ""
@@ -313,13 +347,22 @@ func (b *Builder) BuildTraceIDSubquery(q *spanstore.TraceQueryParameters, tInfo
 		clauseString = "TRUE"
 	}
 
+	spans := "_ps_trace.span"
+	if q.NumTraces != 0 && b.cfg.FindTracesSpanLimit > 0 {
+		spans = fmt.Sprintf(latestSpansFormat, clauseString, b.cfg.FindTracesSpanLimit)
+		clauseString = "TRUE"
+	}
 	params = append(params, b.cfg.MaxTraceDuration)
 	//Note: the parameter number for b.cfg.MaxTraceDuration is used in two places ($%[1]d in subqueryFormat)
 	//to both add and subtract from start_time_max.
-	query := fmt.Sprintf(subqueryFormat, len(params), clauseString)
+	query := fmt.Sprintf(subqueryFormat, len(params), spans, clauseString)
 
 	if q.NumTraces != 0 {
 		query += fmt.Sprintf(" LIMIT %d", q.NumTraces)
 	}
 	return query, params
 }
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
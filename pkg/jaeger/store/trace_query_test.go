@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package store
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/stretchr/testify/require"
+)
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func TestBuildTraceIDSubquery(t *testing.T) {
+	tInfo := &tagsInfo{generalTags: []*tag{{
+		k:              "http.status_code",
+		v:              "500",
+		jsonbPairArray: [][]byte{[]byte(`{"5": 10}`), []byte(`{"5": 11}`)},
+		isSpan:         true,
+	}}}
+	q := &spanstore.TraceQueryParameters{
+		ServiceName: "gateway",
+		DurationMin: 1500 * time.Microsecond,
+		NumTraces:   20,
+	}
+
+	b := NewBuilder(&Config{MaxTraceDuration: time.Hour, FindTracesSpanLimit: 1000})
+	query, params := b.BuildTraceIDSubquery(q, tInfo)
+	query = spaces.ReplaceAllString(query, " ")
+	// Each tag value is contained on its own, so that the GIN index is used.
+	require.Contains(t, query, "(s.span_tags @> $2::jsonb OR s.span_tags @> $3::jsonb)")
+	require.Contains(t, query, "s.duration_ms >= $4")
+	require.Contains(t, query, "ORDER BY s.start_time DESC LIMIT 1000")
+	require.True(t, strings.HasSuffix(query, "LIMIT 20"))
+	require.Equal(t, []interface{}{"gateway", []byte(`{"5": 10}`), []byte(`{"5": 11}`), 1.5, time.Hour}, params)
+
+	// Without a limit, all the matching spans are read.
+	b = NewBuilder(&Config{MaxTraceDuration: time.Hour})
+	query, _ = b.BuildTraceIDSubquery(q, tInfo)
+	require.NotContains(t, spaces.ReplaceAllString(query, " "), "ORDER BY s.start_time DESC")
+
+	// A tag with many values is matched with a single array parameter.
+	tInfo.generalTags[0].jsonbPairArray = make([][]byte, maxTagContainments+1)
+	query, _ = b.BuildTraceIDSubquery(q, tInfo)
+	require.Contains(t, query, "(s.span_tags @> ANY($2::jsonb[]))")
+}
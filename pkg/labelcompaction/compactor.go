@@ -0,0 +1,250 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package labelcompaction deletes the labels no longer referenced by any
+// series. The maintenance jobs only delete the labels of the series they
+// delete, so labels left behind by deleted metrics, dropped series tables or
+// failed writes stay in the label table and in the labels caches forever.
+package labelcompaction
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// deleteAfterEpochs is the number of ID epochs a label must stay unreferenced
+// before it is deleted. Connectors reset their caches when the epoch changes,
+// so after two epochs none of them can still hold the ID of the label from
+// before it was found unreferenced. Like the series IDs, the epoch advances
+// when the maintenance jobs delete expired series.
+const deleteAfterEpochs = 2
+
+const (
+	currentEpochSQL = "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1"
+	// The metric name labels are never deleted since there are check
+	// constraints relying on their IDs, see delete_expired_series.
+	unreferencedLabelsSQL = `WITH scanned AS (
+	SELECT id, key FROM _prom_catalog.label WHERE id > $1 ORDER BY id LIMIT $2
+)
+SELECT
+	(SELECT max(id) FROM scanned),
+	ARRAY(
+		SELECT s.id FROM scanned s
+		WHERE s.key <> '__name__'
+		AND NOT EXISTS (SELECT 1 FROM _prom_catalog.series series_exists WHERE series_exists.labels && ARRAY[s.id] LIMIT 1)
+	)`
+	lockSQL = "SELECT pg_try_advisory_xact_lock(hashtext('_prom_catalog.label_compaction'))"
+	// Labels are checked again since a series may have been created with
+	// them after they were found unreferenced.
+	deleteLabelsSQL = `DELETE FROM _prom_catalog.label l
+WHERE l.id = ANY($1::int[]) AND l.key <> '__name__'
+AND NOT EXISTS (SELECT 1 FROM _prom_catalog.series series_exists WHERE series_exists.labels && ARRAY[l.id] LIMIT 1)`
+	// Advancing the epoch makes the connectors reset their labels caches,
+	// like when the maintenance jobs delete labels. The time of the last
+	// update is kept so as not to delay the next deletion of expired series.
+	advanceEpochSQL = "UPDATE _prom_catalog.ids_epoch SET current_epoch = $1 + 1 WHERE current_epoch = $1"
+)
+
+var (
+	deletedLabels = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "label_compaction",
+			Name:      "deleted_labels_total",
+			Help:      "Total number of unreferenced labels deleted by the label compaction.",
+		},
+	)
+	candidateLabels = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "label_compaction",
+			Name:      "candidate_labels",
+			Help:      "Number of unreferenced labels waiting for the ID epoch to advance before being deleted.",
+		},
+	)
+	lastRun = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "label_compaction",
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix timestamp of the last completed run of the label compaction.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deletedLabels, candidateLabels, lastRun)
+}
+
+// Compactor periodically deletes the labels that are not referenced by any
+// series. A nil Compactor is disabled.
+type Compactor struct {
+	cfg Config
+	// isLeader returns whether this instance runs the compaction.
+	isLeader func() bool
+
+	mu sync.Mutex
+	// cursor is the last label ID scanned, the scan starts over from 0 once
+	// it reaches the end of the label table.
+	cursor int32
+	// candidates are the epochs in which the unreferenced labels were found,
+	// by label ID.
+	candidates map[int32]int64
+}
+
+// NewCompactor returns a Compactor, or nil if it is disabled.
+func NewCompactor(cfg Config) *Compactor {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Compactor{
+		cfg:        cfg,
+		candidates: make(map[int32]int64),
+	}
+}
+
+// WithLeaderElection restricts the compaction to the instance for which
+// isLeader returns true. It must be called before Run.
+func (c *Compactor) WithLeaderElection(isLeader func() bool) {
+	if c != nil {
+		c.isLeader = isLeader
+	}
+}
+
+// Run compacts the label table every run frequency until ctx is done.
+func (c *Compactor) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.RunFrequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.isLeader != nil && !c.isLeader() {
+				continue
+			}
+			if err := c.Compact(ctx, conn); err != nil {
+				log.Error("msg", "label compaction failed", "err", err)
+			}
+		}
+	}
+}
+
+// Compact deletes the candidates that stayed unreferenced for long enough,
+// then scans the next batch of labels for new candidates.
+func (c *Compactor) Compact(ctx context.Context, conn pgxconn.PgxConn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var epoch int64
+	if err := conn.QueryRow(ctx, currentEpochSQL).Scan(&epoch); err != nil {
+		return fmt.Errorf("reading current epoch: %w", err)
+	}
+
+	deleted := int64(0)
+	if ready := c.ready(epoch); len(ready) > 0 {
+		n, locked, err := deleteLabels(ctx, conn, ready, epoch)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			log.Info("msg", "label compaction skipped, another connector is compacting")
+			return nil
+		}
+		// The labels that were not deleted are referenced again, they are
+		// found again by a later scan if they become unreferenced.
+		for _, id := range ready {
+			delete(c.candidates, id)
+		}
+		deleted = n
+		deletedLabels.Add(float64(deleted))
+	}
+
+	var (
+		last   *int32
+		unused []int32
+	)
+	if err := conn.QueryRow(ctx, unreferencedLabelsSQL, c.cursor, c.cfg.BatchSize).Scan(&last, &unused); err != nil {
+		return fmt.Errorf("finding unreferenced labels: %w", err)
+	}
+	c.mark(epoch, last, unused)
+	candidateLabels.Set(float64(len(c.candidates)))
+	lastRun.Set(float64(time.Now().Unix()))
+	log.Info("msg", "label compaction run done", "deleted", deleted, "candidates", len(c.candidates))
+	return nil
+}
+
+// ready returns the candidates found at least deleteAfterEpochs epochs ago.
+func (c *Compactor) ready(epoch int64) []int32 {
+	var ids []int32
+	for id, found := range c.candidates {
+		if epoch-found >= deleteAfterEpochs {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// mark records the unreferenced labels found in epoch, and moves the cursor
+// to the last scanned label, or back to the start if there was none.
+func (c *Compactor) mark(epoch int64, last *int32, unused []int32) {
+	if last == nil {
+		c.cursor = 0
+	} else {
+		c.cursor = *last
+	}
+	for _, id := range unused {
+		if _, ok := c.candidates[id]; !ok {
+			c.candidates[id] = epoch
+		}
+	}
+}
+
+// deleteLabels deletes the labels still unreferenced, and advances the epoch
+// if any was deleted. Nothing is deleted if another connector is compacting,
+// in which case locked is false.
+func deleteLabels(ctx context.Context, conn pgxconn.PgxConn, ids []int32, epoch int64) (deleted int64, locked bool, err error) {
+	tx, err := conn.BeginTx(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("starting label compaction transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	if err := tx.QueryRow(ctx, lockSQL).Scan(&locked); err != nil {
+		return 0, false, fmt.Errorf("locking label compaction: %w", err)
+	}
+	if !locked {
+		return 0, false, nil
+	}
+	// jit interacts poorly with the checks across all the series tables.
+	if _, err := tx.Exec(ctx, "SET LOCAL jit = 'off'"); err != nil {
+		return 0, true, err
+	}
+	tag, err := tx.Exec(ctx, deleteLabelsSQL, ids)
+	if err != nil {
+		return 0, true, fmt.Errorf("deleting unreferenced labels: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, advanceEpochSQL, epoch); err != nil {
+			return 0, true, fmt.Errorf("advancing epoch: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, true, fmt.Errorf("committing label compaction: %w", err)
+	}
+	return tag.RowsAffected(), true, nil
+}
@@ -0,0 +1,43 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package labelcompaction
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{}))
+	require.NoError(t, Validate(&Config{Enabled: true, RunFrequency: time.Hour, BatchSize: 1}))
+	require.Error(t, Validate(&Config{Enabled: true, BatchSize: 1}))
+	require.Error(t, Validate(&Config{Enabled: true, RunFrequency: time.Hour}))
+	require.Nil(t, NewCompactor(Config{}))
+}
+
+func TestMarkAndReady(t *testing.T) {
+	c := NewCompactor(Config{Enabled: true, RunFrequency: time.Hour, BatchSize: 10})
+
+	last := int32(10)
+	c.mark(5, &last, []int32{2, 3})
+	require.Equal(t, int32(10), c.cursor)
+	require.Empty(t, c.ready(6))
+
+	// Labels found again keep the epoch they were first found in.
+	last = 20
+	c.mark(6, &last, []int32{3, 15})
+	require.Equal(t, int32(20), c.cursor)
+	ready := c.ready(7)
+	sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+	require.Equal(t, []int32{2, 3}, ready)
+	require.Len(t, c.ready(8), 3)
+
+	// The scan starts over once the end of the label table is reached.
+	c.mark(8, nil, nil)
+	require.Equal(t, int32(0), c.cursor)
+}
@@ -0,0 +1,47 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package labelcompaction
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRunFrequency = 10 * time.Minute
+	defaultBatchSize    = 10000
+)
+
+// Config holds the label compaction flags.
+type Config struct {
+	Enabled      bool
+	RunFrequency time.Duration
+	BatchSize    int
+}
+
+// ParseFlags registers the label compaction flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "label-compaction.enabled", false, "Periodically delete the labels that are no longer referenced by any series, "+
+		"e.g. after the series were dropped by retention, to keep the label table and the labels caches small.")
+	fs.DurationVar(&cfg.RunFrequency, "label-compaction.run-frequency", defaultRunFrequency, "How often the label compaction runs.")
+	fs.IntVar(&cfg.BatchSize, "label-compaction.batch-size", defaultBatchSize, "Number of labels checked for references in each run. "+
+		"The label table is scanned in batches, starting over once it has been scanned entirely.")
+	return cfg
+}
+
+// Validate checks the label compaction flags.
+func Validate(cfg *Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.RunFrequency <= 0 {
+		return fmt.Errorf("label-compaction.run-frequency must be positive: %s", cfg.RunFrequency)
+	}
+	if cfg.BatchSize < 1 {
+		return fmt.Errorf("label-compaction.batch-size must be at least 1: %d", cfg.BatchSize)
+	}
+	return nil
+}
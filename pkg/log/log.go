@@ -18,8 +18,10 @@ import (
 )
 
 var (
-	// Application wide logger
-	logger log.Logger = log.NewNopLogger()
+	// Application wide logger. It is guarded by loggerMux since Init
+	// can be called again on a configuration reload.
+	logger    log.Logger = log.NewNopLogger()
+	loggerMux sync.RWMutex
 
 	// logger timestamp format
 	timestampFormat = log.TimestampFormat(
@@ -59,21 +61,22 @@ func shouldLog() bool {
 // has been successfully parsed. Calling Init function later on overrides this.
 func InitDefault() {
 	if !shouldLog() {
-		logger = log.NewNopLogger()
+		setLogger(log.NewNopLogger())
 		return
 	}
 	l := level.NewFilter(
 		log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)),
 		level.AllowInfo(),
 	)
-	logger = log.With(l, "ts", timestampFormat, "caller", log.Caller(4))
+	setLogger(log.With(l, "ts", timestampFormat, "caller", log.Caller(4)))
 }
 
 // Init starts logging given the configuration. By default, it uses logfmt format
-// and minimum logging level.
+// and minimum logging level. It is safe to call Init while logging, e.g. to
+// apply a new configuration on reload.
 func Init(cfg Config) error {
 	if !shouldLog() {
-		logger = log.NewNopLogger()
+		setLogger(log.NewNopLogger())
 		return nil
 	}
 	var l log.Logger
@@ -94,37 +97,45 @@ func Init(cfg Config) error {
 	l = level.NewFilter(l, logLevelOption)
 	// NOTE: we add a level of indirection with our logging functions,
 	//       so we need additional caller depth
-	logger = log.With(l, "ts", timestampFormat, "caller", log.Caller(4))
+	setLogger(log.With(l, "ts", timestampFormat, "caller", log.Caller(4)))
 	return nil
 }
 
+func setLogger(l log.Logger) {
+	loggerMux.Lock()
+	defer loggerMux.Unlock()
+	logger = l
+}
+
 func GetLogger() log.Logger {
+	loggerMux.RLock()
+	defer loggerMux.RUnlock()
 	return logger
 }
 
 // Debug logs a DEBUG level message, ignoring logging errors
 func Debug(keyvals ...interface{}) {
-	_ = level.Debug(logger).Log(keyvals...)
+	_ = level.Debug(GetLogger()).Log(keyvals...)
 }
 
 // Info logs an INFO level message, ignoring logging errors
 func Info(keyvals ...interface{}) {
-	_ = level.Info(logger).Log(keyvals...)
+	_ = level.Info(GetLogger()).Log(keyvals...)
 }
 
 // Warn logs a WARN level message, ignoring logging errors
 func Warn(keyvals ...interface{}) {
-	_ = level.Warn(logger).Log(keyvals...)
+	_ = level.Warn(GetLogger()).Log(keyvals...)
 }
 
 // Error logs an ERROR level message, ignoring logging errors
 func Error(keyvals ...interface{}) {
-	_ = level.Error(logger).Log(keyvals...)
+	_ = level.Error(GetLogger()).Log(keyvals...)
 }
 
 // Fatal logs an ERROR level message and exits
 func Fatal(keyvals ...interface{}) {
-	_ = level.Error(logger).Log(keyvals...)
+	_ = level.Error(GetLogger()).Log(keyvals...)
 	os.Exit(1)
 }
 
@@ -0,0 +1,337 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package logs stores the OpenTelemetry log records in the _ps_log.log table
+// and finds them back by time range, resource attributes, severity and trace.
+// The trace and span IDs of the records are stored like those of the spans,
+// so that the logs of a trace can be joined with its spans.
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+var columns = []string{"time", "observed_time", "trace_id", "span_id", "flags", "severity_number", "severity_text", "body",
+	"attributes", "dropped_attributes_count", "resource_attributes", "scope_name", "scope_version"}
+
+// Store reads and writes log records.
+type Store struct {
+	conn pgxconn.PgxConn
+}
+
+// NewStore returns a Store using the connection.
+func NewStore(conn pgxconn.PgxConn) *Store {
+	return &Store{conn: conn}
+}
+
+// Insert writes the log records to the database. The records without a
+// timestamp are stored at the time they were observed by the collector, or
+// at the time they were received.
+func (s *Store) Insert(ctx context.Context, logs plog.Logs) error {
+	start := time.Now()
+	rows := make([][]interface{}, 0, logs.LogRecordCount())
+	resourceLogs := logs.ResourceLogs()
+	for i := 0; i < resourceLogs.Len(); i++ {
+		rl := resourceLogs.At(i)
+		resourceAttributes, err := json.Marshal(rl.Resource().Attributes().AsRaw())
+		if err != nil {
+			return fmt.Errorf("marshaling resource attributes: %w", err)
+		}
+		scopeLogs := rl.ScopeLogs()
+		for j := 0; j < scopeLogs.Len(); j++ {
+			sl := scopeLogs.At(j)
+			records := sl.LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				r := records.At(k)
+				row, err := recordRow(r, start)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, append(row, resourceAttributes, sl.Scope().Name(), sl.Scope().Version()))
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if _, err := s.conn.CopyFrom(ctx, pgx.Identifier{schema.PsLog, "log"}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("inserting log records: %w", err)
+	}
+	metrics.IngestorItems.With(prometheus.Labels{"type": "log", "kind": "log_record", "subsystem": ""}).Add(float64(len(rows)))
+	metrics.IngestorInsertDuration.With(prometheus.Labels{"type": "log", "subsystem": "", "kind": "log_record"}).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// recordRow returns the columns of a log record, up to its attributes.
+func recordRow(r plog.LogRecord, received time.Time) ([]interface{}, error) {
+	t := received
+	observed := pgtype.Timestamptz{Status: pgtype.Null}
+	if r.ObservedTimestamp() != 0 {
+		observed = pgtype.Timestamptz{Time: r.ObservedTimestamp().AsTime(), Status: pgtype.Present}
+		t = observed.Time
+	}
+	if r.Timestamp() != 0 {
+		t = r.Timestamp().AsTime()
+	}
+
+	traceID := pgtype.UUID{Status: pgtype.Null}
+	if !r.TraceID().IsEmpty() {
+		traceID = trace.TraceIDToUUID(r.TraceID().Bytes())
+	}
+	attributes, err := json.Marshal(r.Attributes().AsRaw())
+	if err != nil {
+		return nil, fmt.Errorf("marshaling log attributes: %w", err)
+	}
+	body, err := bodyJSON(r.Body())
+	if err != nil {
+		return nil, fmt.Errorf("marshaling log body: %w", err)
+	}
+	return []interface{}{t, observed, traceID, spanID(r.SpanID()), int32(r.Flags()), int16(r.SeverityNumber()), r.SeverityText(), body,
+		attributes, int32(r.DroppedAttributesCount())}, nil
+}
+
+// bodyJSON returns the body of a log record as JSON, or nil if it is empty.
+func bodyJSON(body pcommon.Value) (interface{}, error) {
+	if body.Type() == pcommon.ValueTypeEmpty {
+		return nil, nil
+	}
+	// The values are only converted in maps.
+	m := pcommon.NewMap()
+	m.Insert("body", body)
+	return json.Marshal(m.AsRaw()["body"])
+}
+
+func spanID(id pcommon.SpanID) pgtype.Int8 {
+	if id.IsEmpty() {
+		return pgtype.Int8{Status: pgtype.Null}
+	}
+	return pgtype.Int8{Int: trace.ByteArrayToInt64(id.Bytes()), Status: pgtype.Present}
+}
+
+// DefaultLimit is the number of records returned when the query sets no
+// limit.
+const DefaultLimit = 100
+
+// Query selects the log records of a time range.
+type Query struct {
+	Start, End time.Time
+	// Resource holds the values the resource attributes must have.
+	Resource map[string]string
+	// Matchers select the records by resource attributes, like the label
+	// matchers of a series selector. A missing attribute has an empty value.
+	Matchers []*labels.Matcher
+	// LineFilters select the records by the text of their body.
+	LineFilters []LineFilter
+	// MinSeverity is the lowest severity number of the records, 0 selects
+	// all of them.
+	MinSeverity int
+	// TraceID and SpanID select the records of a trace or span if set.
+	TraceID *[16]byte
+	SpanID  *[8]byte
+	// Limit is the maximum number of records returned, DefaultLimit if 0.
+	Limit int
+	// Forward returns the oldest records first.
+	Forward bool
+}
+
+// Record is a stored log record.
+type Record struct {
+	Time                   time.Time              `json:"time"`
+	ObservedTime           *time.Time             `json:"observed_time,omitempty"`
+	TraceID                string                 `json:"trace_id,omitempty"`
+	SpanID                 string                 `json:"span_id,omitempty"`
+	SeverityNumber         int16                  `json:"severity_number"`
+	SeverityText           string                 `json:"severity_text,omitempty"`
+	Body                   json.RawMessage        `json:"body,omitempty"`
+	Attributes             map[string]interface{} `json:"attributes"`
+	ResourceAttributes     map[string]interface{} `json:"resource_attributes"`
+	DroppedAttributesCount int32                  `json:"dropped_attributes_count,omitempty"`
+	ScopeName              string                 `json:"scope_name,omitempty"`
+	ScopeVersion           string                 `json:"scope_version,omitempty"`
+}
+
+var matchOperators = map[labels.MatchType]string{
+	labels.MatchEqual:     "=",
+	labels.MatchNotEqual:  "!=",
+	labels.MatchRegexp:    "~",
+	labels.MatchNotRegexp: "!~",
+}
+
+// lineFilterClauses match the text of the body of the records, which is the
+// string itself for string bodies and the JSON encoding of the others.
+var lineFilterClauses = map[FilterType]string{
+	LineContains:    "strpos(coalesce(body #>> '{}', ''), $%d) > 0",
+	LineNotContains: "strpos(coalesce(body #>> '{}', ''), $%d) = 0",
+	LineMatches:     "coalesce(body #>> '{}', '') ~ $%d",
+	LineNotMatches:  "coalesce(body #>> '{}', '') !~ $%d",
+}
+
+// Line returns the body of the record as a log line: the string itself for
+// string bodies and the JSON encoding of the others.
+func (r Record) Line() string {
+	var line string
+	if err := json.Unmarshal(r.Body, &line); err == nil {
+		return line
+	}
+	return string(r.Body)
+}
+
+// selectSQL returns the statement and arguments of a query.
+func selectSQL(q Query) (string, []interface{}, error) {
+	clauses := []string{"time >= $1", "time <= $2"}
+	args := []interface{}{q.Start, q.End}
+	if len(q.Resource) > 0 {
+		resource, err := json.Marshal(q.Resource)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, string(resource))
+		clauses = append(clauses, fmt.Sprintf("resource_attributes @> $%d::jsonb", len(args)))
+	}
+	if q.MinSeverity > 0 {
+		args = append(args, q.MinSeverity)
+		clauses = append(clauses, fmt.Sprintf("severity_number >= $%d", len(args)))
+	}
+	if q.TraceID != nil {
+		args = append(args, trace.TraceIDToUUID(*q.TraceID))
+		clauses = append(clauses, fmt.Sprintf("trace_id = $%d", len(args)))
+	}
+	if q.SpanID != nil {
+		args = append(args, trace.ByteArrayToInt64(*q.SpanID))
+		clauses = append(clauses, fmt.Sprintf("span_id = $%d", len(args)))
+	}
+	for _, m := range q.Matchers {
+		if m.Type == labels.MatchEqual && m.Value != "" {
+			attribute, err := json.Marshal(map[string]string{m.Name: m.Value})
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, string(attribute))
+			clauses = append(clauses, fmt.Sprintf("resource_attributes @> $%d::jsonb", len(args)))
+			continue
+		}
+		value := m.Value
+		if m.Type == labels.MatchRegexp || m.Type == labels.MatchNotRegexp {
+			value = "^(?:" + value + ")$"
+		}
+		args = append(args, m.Name, value)
+		clauses = append(clauses, fmt.Sprintf("coalesce(resource_attributes->>$%d, '') %s $%d", len(args)-1, matchOperators[m.Type], len(args)))
+	}
+	for _, f := range q.LineFilters {
+		args = append(args, f.Match)
+		clauses = append(clauses, fmt.Sprintf(lineFilterClauses[f.Type], len(args)))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	args = append(args, limit)
+	order := "DESC"
+	if q.Forward {
+		order = "ASC"
+	}
+	return fmt.Sprintf(`SELECT time, observed_time, trace_id, span_id, severity_number, severity_text, body, attributes,
+	dropped_attributes_count, resource_attributes, scope_name, scope_version
+FROM %s.log
+WHERE %s
+ORDER BY time %s
+LIMIT $%d`, schema.PsLog, strings.Join(clauses, " AND "), order, len(args)), args, nil
+}
+
+// Find returns the log records matching the query, most recent first.
+func (s *Store) Find(ctx context.Context, q Query) ([]Record, error) {
+	sql, args, err := selectSQL(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying log records: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]Record, 0)
+	for rows.Next() {
+		var (
+			r                              Record
+			observed                       pgtype.Timestamptz
+			traceID                        pgtype.UUID
+			span                           pgtype.Int8
+			body, attributes, resourceAttr []byte
+		)
+		if err = rows.Scan(&r.Time, &observed, &traceID, &span, &r.SeverityNumber, &r.SeverityText, &body, &attributes,
+			&r.DroppedAttributesCount, &resourceAttr, &r.ScopeName, &r.ScopeVersion); err != nil {
+			return nil, fmt.Errorf("reading log records: %w", err)
+		}
+		if observed.Status == pgtype.Present {
+			r.ObservedTime = &observed.Time
+		}
+		if traceID.Status == pgtype.Present {
+			r.TraceID = pcommon.NewTraceID(traceID.Bytes).HexString()
+		}
+		if span.Status == pgtype.Present {
+			r.SpanID = pcommon.NewSpanID(trace.Int64ToByteArray(span.Int)).HexString()
+		}
+		r.Body = body
+		if err = json.Unmarshal(attributes, &r.Attributes); err != nil {
+			return nil, fmt.Errorf("reading log attributes: %w", err)
+		}
+		if err = json.Unmarshal(resourceAttr, &r.ResourceAttributes); err != nil {
+			return nil, fmt.Errorf("reading log resource attributes: %w", err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Labels returns the names of the resource attributes of the records of a
+// time range.
+func (s *Store) Labels(ctx context.Context, start, end time.Time) ([]string, error) {
+	return s.strings(ctx, fmt.Sprintf(`SELECT DISTINCT k
+FROM %s.log, jsonb_object_keys(resource_attributes) k
+WHERE time >= $1 AND time <= $2
+ORDER BY k`, schema.PsLog), start, end)
+}
+
+// LabelValues returns the values of a resource attribute in the records of a
+// time range.
+func (s *Store) LabelValues(ctx context.Context, name string, start, end time.Time) ([]string, error) {
+	return s.strings(ctx, fmt.Sprintf(`SELECT DISTINCT resource_attributes->>$1 v
+FROM %s.log
+WHERE time >= $2 AND time <= $3 AND resource_attributes ? $1
+ORDER BY v`, schema.PsLog), name, start, end)
+}
+
+func (s *Store) strings(ctx context.Context, sql string, args ...interface{}) ([]string, error) {
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying log labels: %w", err)
+	}
+	defer rows.Close()
+	res := []string{}
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("reading log labels: %w", err)
+		}
+		res = append(res, v)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,94 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
+)
+
+func TestRecordRow(t *testing.T) {
+	received := time.Unix(100, 0).UTC()
+	r := plog.NewLogRecord()
+	r.SetSeverityNumber(plog.SeverityNumberERROR)
+	r.SetSeverityText("ERROR")
+	r.Body().SetStringVal("connection refused")
+	r.Attributes().InsertInt("retries", 3)
+
+	// Without timestamps the record is stored at the time it was received.
+	row, err := recordRow(r, received)
+	require.NoError(t, err)
+	require.Equal(t, received, row[0])
+	require.Equal(t, pgtype.Timestamptz{Status: pgtype.Null}, row[1])
+	require.Equal(t, pgtype.UUID{Status: pgtype.Null}, row[2])
+	require.Equal(t, pgtype.Int8{Status: pgtype.Null}, row[3])
+	require.Equal(t, int16(plog.SeverityNumberERROR), row[5])
+	require.Equal(t, []byte(`"connection refused"`), row[7])
+	require.Equal(t, []byte(`{"retries":3}`), row[8])
+
+	observed := time.Unix(90, 0).UTC()
+	r.SetObservedTimestamp(pcommon.NewTimestampFromTime(observed))
+	row, err = recordRow(r, received)
+	require.NoError(t, err)
+	require.Equal(t, observed, row[0])
+
+	traceID := [16]byte{1, 2, 3}
+	spanID := [8]byte{4, 5, 6}
+	logged := time.Unix(80, 0).UTC()
+	r.SetTimestamp(pcommon.NewTimestampFromTime(logged))
+	r.SetTraceID(pcommon.NewTraceID(traceID))
+	r.SetSpanID(pcommon.NewSpanID(spanID))
+	row, err = recordRow(r, received)
+	require.NoError(t, err)
+	require.Equal(t, logged, row[0])
+	require.Equal(t, trace.TraceIDToUUID(traceID), row[2])
+	require.Equal(t, pgtype.Int8{Int: trace.ByteArrayToInt64(spanID), Status: pgtype.Present}, row[3])
+}
+
+func TestBodyJSON(t *testing.T) {
+	body, err := bodyJSON(pcommon.NewValueEmpty())
+	require.NoError(t, err)
+	require.Nil(t, body)
+
+	m := pcommon.NewValueMap()
+	m.MapVal().InsertString("msg", "started")
+	m.MapVal().InsertBool("ok", true)
+	body, err = bodyJSON(m)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"msg":"started","ok":true}`), body)
+
+	body, err = bodyJSON(pcommon.NewValueDouble(1.5))
+	require.NoError(t, err)
+	require.Equal(t, []byte(`1.5`), body)
+}
+
+func TestSelectSQL(t *testing.T) {
+	start, end := time.Unix(0, 0), time.Unix(3600, 0)
+	sql, args, err := selectSQL(Query{Start: start, End: end, Limit: 100})
+	require.NoError(t, err)
+	require.Contains(t, sql, "WHERE time >= $1 AND time <= $2\nORDER BY time DESC\nLIMIT $3")
+	require.Equal(t, []interface{}{start, end, 100}, args)
+
+	traceID := [16]byte{1}
+	sql, args, err = selectSQL(Query{
+		Start:       start,
+		End:         end,
+		Resource:    map[string]string{"service.name": "checkout"},
+		MinSeverity: int(plog.SeverityNumberWARN),
+		TraceID:     &traceID,
+		Limit:       10,
+	})
+	require.NoError(t, err)
+	require.Contains(t, sql, "WHERE time >= $1 AND time <= $2 AND resource_attributes @> $3::jsonb AND severity_number >= $4 AND trace_id = $5\n")
+	require.Contains(t, sql, "LIMIT $6")
+	require.Equal(t, []interface{}{start, end, `{"service.name":"checkout"}`, int(plog.SeverityNumberWARN), trace.TraceIDToUUID(traceID), 10}, args)
+}
@@ -0,0 +1,341 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// FilterType is the operator of a LogQL line filter.
+type FilterType string
+
+// Line filter operators.
+const (
+	LineContains    FilterType = "|="
+	LineNotContains FilterType = "!="
+	LineMatches     FilterType = "|~"
+	LineNotMatches  FilterType = "!~"
+)
+
+// LineFilter selects the records by the text of their body.
+type LineFilter struct {
+	Type  FilterType
+	Match string
+}
+
+// ParseLogQL parses a LogQL log query: a stream selector followed by line
+// filters, e.g.
+//
+//	{service_name="checkout", level=~"error|warn"} |= "timeout" != "retry"
+//
+// The stream labels are the resource attributes of the records. The metric
+// queries and the other pipeline stages are not supported.
+func ParseLogQL(query string) ([]*labels.Matcher, []LineFilter, error) {
+	query = strings.TrimSpace(query)
+	if !strings.HasPrefix(query, "{") {
+		return nil, nil, fmt.Errorf("only log queries starting with a stream selector are supported")
+	}
+	end := selectorEnd(query)
+	if end < 0 {
+		return nil, nil, fmt.Errorf("unterminated stream selector")
+	}
+	matchers, err := parser.ParseMetricSelector(query[:end])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid stream selector: %w", err)
+	}
+	nonEmpty := false
+	for _, m := range matchers {
+		nonEmpty = nonEmpty || !m.Matches("")
+	}
+	if !nonEmpty {
+		return nil, nil, fmt.Errorf("stream selector must contain at least one matcher not matching an empty value")
+	}
+
+	var filters []LineFilter
+	rest := strings.TrimSpace(query[end:])
+	for rest != "" {
+		if len(rest) < 2 {
+			return nil, nil, fmt.Errorf("unsupported pipeline stage %q", rest)
+		}
+		f := LineFilter{Type: FilterType(rest[:2])}
+		switch f.Type {
+		case LineContains, LineNotContains, LineMatches, LineNotMatches:
+		default:
+			return nil, nil, fmt.Errorf("unsupported pipeline stage %q, only line filters are supported", rest)
+		}
+		var n int
+		f.Match, n, err = readString(strings.TrimSpace(rest[2:]))
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.Type == LineMatches || f.Type == LineNotMatches {
+			if _, err = regexp.Compile(f.Match); err != nil {
+				return nil, nil, fmt.Errorf("invalid line filter regex %q: %w", f.Match, err)
+			}
+		}
+		filters = append(filters, f)
+		rest = strings.TrimSpace(strings.TrimSpace(rest[2:])[n:])
+	}
+	return matchers, filters, nil
+}
+
+// selectorEnd returns the index following the closing brace of the stream
+// selector starting s, or -1 if it is not closed.
+func selectorEnd(s string) int {
+	var quote rune
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '}':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// readString reads the string literal starting s and returns its value and
+// length.
+func readString(s string) (string, int, error) {
+	if s == "" || (s[0] != '"' && s[0] != '`') {
+		return "", 0, fmt.Errorf("line filter must be followed by a string")
+	}
+	if s[0] == '`' {
+		end := strings.IndexByte(s[1:], '`')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid string %s: %w", s[:i+1], err)
+			}
+			return v, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %s", s)
+}
+
+// DecodeLokiPush decodes the body of a Loki push request, in JSON or as
+// snappy compressed protobuf like Promtail sends it. The labels of the
+// streams become the resource attributes of the records, and the structured
+// metadata of the entries their attributes.
+func DecodeLokiPush(body []byte, protobuf bool) (plog.Logs, error) {
+	if protobuf {
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			return plog.Logs{}, fmt.Errorf("snappy decode error: %w", err)
+		}
+		return decodeLokiProto(decoded)
+	}
+	return decodeLokiJSON(body)
+}
+
+type lokiPush struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+func decodeLokiJSON(body []byte) (plog.Logs, error) {
+	var req lokiPush
+	if err := json.Unmarshal(body, &req); err != nil {
+		return plog.Logs{}, fmt.Errorf("invalid push request: %w", err)
+	}
+	logs := plog.NewLogs()
+	for _, s := range req.Streams {
+		records := newStream(logs, s.Stream)
+		for _, v := range s.Values {
+			if len(v) != 2 && len(v) != 3 {
+				return plog.Logs{}, fmt.Errorf("invalid entry of %d values, must be a timestamp, a line and optional metadata", len(v))
+			}
+			var (
+				ts, line string
+				metadata map[string]string
+			)
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return plog.Logs{}, fmt.Errorf("invalid entry timestamp: %w", err)
+			}
+			ns, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return plog.Logs{}, fmt.Errorf("invalid entry timestamp %q: %w", ts, err)
+			}
+			if err = json.Unmarshal(v[1], &line); err != nil {
+				return plog.Logs{}, fmt.Errorf("invalid entry line: %w", err)
+			}
+			if len(v) == 3 {
+				if err = json.Unmarshal(v[2], &metadata); err != nil {
+					return plog.Logs{}, fmt.Errorf("invalid entry metadata: %w", err)
+				}
+			}
+			appendEntry(records, ns, line, metadata)
+		}
+	}
+	return logs, nil
+}
+
+// decodeLokiProto decodes a logproto.PushRequest:
+//
+//	PushRequest  { repeated Stream streams = 1; }
+//	Stream       { string labels = 1; repeated Entry entries = 2; }
+//	Entry        { Timestamp timestamp = 1; string line = 2; repeated LabelPair structuredMetadata = 3; }
+//	LabelPair    { string name = 1; string value = 2; }
+func decodeLokiProto(b []byte) (plog.Logs, error) {
+	logs := plog.NewLogs()
+	err := forEachField(b, func(num protowire.Number, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var (
+			lbls    labels.Labels
+			entries [][]byte
+		)
+		err := forEachField(v, func(num protowire.Number, v []byte) (err error) {
+			switch num {
+			case 1:
+				lbls, err = parser.ParseMetric(string(v))
+			case 2:
+				entries = append(entries, v)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		records := newStream(logs, lbls.Map())
+		for _, e := range entries {
+			if err = decodeLokiEntry(records, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return logs, err
+}
+
+func decodeLokiEntry(records plog.LogRecordSlice, b []byte) error {
+	var (
+		ns       int64
+		line     string
+		metadata map[string]string
+	)
+	err := forEachField(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			var seconds, nanos int64
+			err := forEachField(v, func(num protowire.Number, v []byte) error {
+				n, l := protowire.ConsumeVarint(v)
+				if l < 0 {
+					return protowire.ParseError(l)
+				}
+				if num == 1 {
+					seconds = int64(n)
+				} else if num == 2 {
+					nanos = int64(n)
+				}
+				return nil
+			})
+			ns = seconds*1e9 + nanos
+			return err
+		case 2:
+			line = string(v)
+		case 3:
+			var name, value string
+			err := forEachField(v, func(num protowire.Number, v []byte) error {
+				if num == 1 {
+					name = string(v)
+				} else if num == 2 {
+					value = string(v)
+				}
+				return nil
+			})
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[name] = value
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	appendEntry(records, ns, line, metadata)
+	return nil
+}
+
+// forEachField calls fn with the number and the value of the fields of a
+// protobuf message. The value of the varint fields is their encoding, the
+// value of the length-delimited fields their contents.
+func forEachField(b []byte, fn func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid push request: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid push request: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newStream(logs plog.Logs, stream map[string]string) plog.LogRecordSlice {
+	rl := logs.ResourceLogs().AppendEmpty()
+	for name, value := range stream {
+		rl.Resource().Attributes().InsertString(name, value)
+	}
+	return rl.ScopeLogs().AppendEmpty().LogRecords()
+}
+
+func appendEntry(records plog.LogRecordSlice, ns int64, line string, metadata map[string]string) {
+	r := records.AppendEmpty()
+	r.SetTimestamp(pcommon.Timestamp(ns))
+	r.Body().SetStringVal(line)
+	for name, value := range metadata {
+		r.Attributes().InsertString(name, value)
+	}
+}
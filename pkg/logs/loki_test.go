@@ -0,0 +1,133 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseLogQL(t *testing.T) {
+	matchers, filters, err := ParseLogQL(`{service_name="checkout", level=~"error|warn", msg!="a}b"} |= "time\"out" != ` + "`retry`" + ` |~ "conn.*refused"`)
+	require.NoError(t, err)
+	require.Equal(t, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "service_name", "checkout"),
+		labels.MustNewMatcher(labels.MatchRegexp, "level", "error|warn"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "msg", "a}b"),
+	}, matchers)
+	require.Equal(t, []LineFilter{
+		{Type: LineContains, Match: `time"out`},
+		{Type: LineNotContains, Match: "retry"},
+		{Type: LineMatches, Match: "conn.*refused"},
+	}, filters)
+
+	for _, invalid := range []string{
+		`count_over_time({job="a"}[5m])`,
+		`{job="a"`,
+		`{job=~".*"}`,
+		`{job="a"} | json`,
+		`{job="a"} |= error`,
+		`{job="a"} |= "error`,
+		`{job="a"} |~ "("`,
+	} {
+		_, _, err = ParseLogQL(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDecodeLokiPushJSON(t *testing.T) {
+	body := `{"streams":[{"stream":{"job":"api"},"values":[["1000000001","started"],["1000000002","stopped",{"trace_id":"abc"}]]}]}`
+	l, err := DecodeLokiPush([]byte(body), false)
+	require.NoError(t, err)
+	require.Equal(t, 2, l.LogRecordCount())
+	rl := l.ResourceLogs().At(0)
+	require.Equal(t, map[string]interface{}{"job": "api"}, rl.Resource().Attributes().AsRaw())
+	r := rl.ScopeLogs().At(0).LogRecords().At(1)
+	require.Equal(t, time.Unix(1, 2).UTC(), r.Timestamp().AsTime())
+	require.Equal(t, "stopped", r.Body().StringVal())
+	require.Equal(t, map[string]interface{}{"trace_id": "abc"}, r.Attributes().AsRaw())
+
+	for _, invalid := range []string{
+		`{"streams":[{"stream":{},"values":[["1"]]}]}`,
+		`{"streams":[{"stream":{},"values":[["now","line"]]}]}`,
+		`{"streams":[{"stream":{},"values":[["1",2]]}]}`,
+		`{"streams":`,
+	} {
+		_, err = DecodeLokiPush([]byte(invalid), false)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDecodeLokiPushProto(t *testing.T) {
+	var timestamp, metadata, entry, stream, req []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 5)
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 6)
+	metadata = protowire.AppendTag(metadata, 1, protowire.BytesType)
+	metadata = protowire.AppendString(metadata, "user")
+	metadata = protowire.AppendTag(metadata, 2, protowire.BytesType)
+	metadata = protowire.AppendString(metadata, "42")
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, timestamp)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, "GET /cart 200")
+	entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, metadata)
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, `{job="api", env="prod"}`)
+	stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+	stream = protowire.AppendBytes(stream, entry)
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 1234)
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, stream)
+
+	l, err := DecodeLokiPush(snappy.Encode(nil, req), true)
+	require.NoError(t, err)
+	require.Equal(t, 1, l.LogRecordCount())
+	rl := l.ResourceLogs().At(0)
+	require.Equal(t, map[string]interface{}{"job": "api", "env": "prod"}, rl.Resource().Attributes().AsRaw())
+	r := rl.ScopeLogs().At(0).LogRecords().At(0)
+	require.Equal(t, time.Unix(5, 6).UTC(), r.Timestamp().AsTime())
+	require.Equal(t, "GET /cart 200", r.Body().StringVal())
+	require.Equal(t, map[string]interface{}{"user": "42"}, r.Attributes().AsRaw())
+
+	_, err = DecodeLokiPush(req, true)
+	require.Error(t, err)
+	_, err = DecodeLokiPush(snappy.Encode(nil, req[:len(req)-3]), true)
+	require.Error(t, err)
+}
+
+func TestSelectSQLMatchers(t *testing.T) {
+	start, end := time.Unix(0, 0), time.Unix(3600, 0)
+	sql, args, err := selectSQL(Query{
+		Start: start,
+		End:   end,
+		Matchers: []*labels.Matcher{
+			labels.MustNewMatcher(labels.MatchEqual, "job", "api"),
+			labels.MustNewMatcher(labels.MatchEqual, "env", ""),
+			labels.MustNewMatcher(labels.MatchNotRegexp, "level", "debug|trace"),
+		},
+		LineFilters: []LineFilter{{Type: LineContains, Match: "timeout"}, {Type: LineNotMatches, Match: "retry.*"}},
+		Forward:     true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, sql, "WHERE time >= $1 AND time <= $2 AND resource_attributes @> $3::jsonb AND "+
+		"coalesce(resource_attributes->>$4, '') = $5 AND coalesce(resource_attributes->>$6, '') !~ $7 AND "+
+		"strpos(coalesce(body #>> '{}', ''), $8) > 0 AND coalesce(body #>> '{}', '') !~ $9\nORDER BY time ASC\nLIMIT $10")
+	require.Equal(t, []interface{}{start, end, `{"job":"api"}`, "env", "", "level", "^(?:debug|trace)$", "timeout", "retry.*", DefaultLimit}, args)
+}
+
+func TestRecordLine(t *testing.T) {
+	require.Equal(t, "started", Record{Body: []byte(`"started"`)}.Line())
+	require.Equal(t, `{"msg":"started"}`, Record{Body: []byte(`{"msg":"started"}`)}.Line())
+	require.Equal(t, "", Record{}.Line())
+}
@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package maintenance
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const defaultScheduleInterval = 30 * time.Minute
+
+// Config holds the maintenance jobs flags.
+type Config struct {
+	// Jobs is the number of maintenance jobs. The jobs are left as they
+	// are in the database if it is 0.
+	Jobs             int
+	ScheduleInterval time.Duration
+	Jitter           time.Duration
+	StatementTimeout time.Duration
+}
+
+// ParseFlags registers the maintenance jobs flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.IntVar(&cfg.Jobs, "maintenance.jobs", 0, "Number of TimescaleDB jobs running the Promscale maintenance (data retention and compression), set on startup. "+
+		"The jobs are left as they are configured in the database if 0.")
+	fs.DurationVar(&cfg.ScheduleInterval, "maintenance.schedule-interval", defaultScheduleInterval, "Interval between two runs of each maintenance job.")
+	fs.DurationVar(&cfg.Jitter, "maintenance.jitter", 0, "The next run of each maintenance job is delayed by a random duration up to this one, "+
+		"so that the jobs do not all start at once.")
+	fs.DurationVar(&cfg.StatementTimeout, "maintenance.statement-timeout", 0, "Statement timeout of the maintenance jobs. No timeout if 0.")
+	return cfg
+}
+
+// Validate checks the maintenance jobs flags.
+func Validate(cfg *Config) error {
+	if cfg.Jobs < 0 {
+		return fmt.Errorf("maintenance.jobs must not be negative: %d", cfg.Jobs)
+	}
+	if cfg.ScheduleInterval <= 0 {
+		return fmt.Errorf("maintenance.schedule-interval must be positive: %s", cfg.ScheduleInterval)
+	}
+	if cfg.Jitter < 0 {
+		return fmt.Errorf("maintenance.jitter must not be negative: %s", cfg.Jitter)
+	}
+	if cfg.StatementTimeout < 0 {
+		return fmt.Errorf("maintenance.statement-timeout must not be negative: %s", cfg.StatementTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	configJobsSQL   = "SELECT prom_api.config_maintenance_jobs($1, $2, $3::jsonb)"
+	jitterJobsSQL   = "SELECT prom_api.jitter_maintenance_jobs($1)"
+	scheduleJobsSQL = "SELECT prom_api.set_maintenance_jobs_scheduled($1, $2)"
+	listJobsSQL     = `SELECT j.job_id, extract(epoch FROM j.schedule_interval)::float8, j.scheduled, coalesce(j.config::text, ''),
+	coalesce(extract(epoch FROM s.next_start), 0)::float8, coalesce(s.last_run_status, ''),
+	coalesce(extract(epoch FROM s.last_run_duration), 0)::float8, coalesce(s.total_runs, 0), coalesce(s.total_failures, 0)
+FROM timescaledb_information.jobs j
+LEFT JOIN timescaledb_information.job_stats s USING (job_id)
+WHERE j.proc_schema = '_prom_catalog' AND j.proc_name = 'execute_maintenance_job'
+ORDER BY j.job_id`
+)
+
+// Job is a TimescaleDB job running the Promscale maintenance.
+type Job struct {
+	ID                      int64   `json:"id"`
+	ScheduleIntervalSeconds float64 `json:"scheduleIntervalSeconds"`
+	// Scheduled is false if the job is paused.
+	Scheduled bool   `json:"scheduled"`
+	Config    string `json:"config"`
+	// NextStart is the unix timestamp of the next run, 0 if unknown.
+	NextStart              float64 `json:"nextStart"`
+	LastRunStatus          string  `json:"lastRunStatus"`
+	LastRunDurationSeconds float64 `json:"lastRunDurationSeconds"`
+	TotalRuns              int64   `json:"totalRuns"`
+	TotalFailures          int64   `json:"totalFailures"`
+}
+
+// Configure sets the number, the schedule interval and the statement
+// timeout of the maintenance jobs, and delays their next runs by the jitter.
+// The jobs whose schedule interval or statement timeout change are
+// recreated, which resumes them.
+func Configure(ctx context.Context, conn pgxconn.PgxConn, cfg Config) error {
+	jobConfig, err := jobConfig(cfg.StatementTimeout)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, configJobsSQL, cfg.Jobs, cfg.ScheduleInterval, jobConfig); err != nil {
+		return fmt.Errorf("configure maintenance jobs: %w", err)
+	}
+	if cfg.Jitter > 0 {
+		if _, err := conn.Exec(ctx, jitterJobsSQL, cfg.Jitter); err != nil {
+			return fmt.Errorf("jitter maintenance jobs: %w", err)
+		}
+	}
+	log.Info("msg", "Configured maintenance jobs", "jobs", cfg.Jobs, "schedule-interval", cfg.ScheduleInterval,
+		"jitter", cfg.Jitter, "statement-timeout", cfg.StatementTimeout)
+	return nil
+}
+
+// jobConfig returns the config of the jobs as JSON, nil if there is none.
+func jobConfig(statementTimeout time.Duration) (*string, error) {
+	if statementTimeout == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(map[string]string{
+		"statement_timeout": fmt.Sprintf("%dms", statementTimeout.Milliseconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// SetScheduled pauses or resumes the maintenance job with the id, or all of
+// them if the id is 0. It returns the number of jobs changed.
+func SetScheduled(ctx context.Context, conn pgxconn.PgxConn, id int64, scheduled bool) (int, error) {
+	var jobID *int64
+	if id != 0 {
+		jobID = &id
+	}
+	var count int
+	if err := conn.QueryRow(ctx, scheduleJobsSQL, scheduled, jobID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("schedule maintenance jobs: %w", err)
+	}
+	return count, nil
+}
+
+// Jobs returns the maintenance jobs and their statistics.
+func Jobs(ctx context.Context, conn pgxconn.PgxConn) ([]Job, error) {
+	rows, err := conn.Query(ctx, listJobsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("list maintenance jobs: %w", err)
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.ScheduleIntervalSeconds, &j.Scheduled, &j.Config, &j.NextStart,
+			&j.LastRunStatus, &j.LastRunDurationSeconds, &j.TotalRuns, &j.TotalFailures); err != nil {
+			return nil, fmt.Errorf("list maintenance jobs: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
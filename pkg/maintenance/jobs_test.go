@@ -0,0 +1,68 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{ScheduleInterval: time.Minute}))
+	require.NoError(t, Validate(&Config{Jobs: 2, ScheduleInterval: time.Minute, Jitter: time.Minute, StatementTimeout: time.Hour}))
+	require.Error(t, Validate(&Config{Jobs: -1, ScheduleInterval: time.Minute}))
+	require.Error(t, Validate(&Config{Jobs: 2}))
+	require.Error(t, Validate(&Config{Jobs: 2, ScheduleInterval: time.Minute, Jitter: -time.Minute}))
+	require.Error(t, Validate(&Config{Jobs: 2, ScheduleInterval: time.Minute, StatementTimeout: -time.Minute}))
+}
+
+func TestConfigure(t *testing.T) {
+	timeoutConfig := `{"statement_timeout":"90000ms"}`
+	testCases := []struct {
+		name    string
+		cfg     Config
+		queries []model.SqlQuery
+	}{
+		{
+			name: "no config",
+			cfg:  Config{Jobs: 2, ScheduleInterval: time.Hour},
+			queries: []model.SqlQuery{
+				{Sql: configJobsSQL, Args: []interface{}{2, time.Hour, (*string)(nil)}},
+			},
+		},
+		{
+			name: "statement timeout and jitter",
+			cfg:  Config{Jobs: 3, ScheduleInterval: time.Hour, Jitter: time.Minute, StatementTimeout: 90 * time.Second},
+			queries: []model.SqlQuery{
+				{Sql: configJobsSQL, Args: []interface{}{3, time.Hour, &timeoutConfig}},
+				{Sql: jitterJobsSQL, Args: []interface{}{time.Minute}},
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder(c.queries, t)
+			require.NoError(t, Configure(context.Background(), conn, c.cfg))
+		})
+	}
+}
+
+func TestSetScheduled(t *testing.T) {
+	id := int64(1001)
+	conn := model.NewSqlRecorder([]model.SqlQuery{
+		{Sql: scheduleJobsSQL, Args: []interface{}{false, (*int64)(nil)}, Results: model.RowResults{{2}}},
+		{Sql: scheduleJobsSQL, Args: []interface{}{true, &id}, Results: model.RowResults{{1}}},
+	}, t)
+	count, err := SetScheduled(context.Background(), conn, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	count, err = SetScheduled(context.Background(), conn, id, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
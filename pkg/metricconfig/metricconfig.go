@@ -0,0 +1,164 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package metricconfig reads and changes the chunk interval, compression and
+// retention settings of a single metric, through the prom_api functions
+// storing them in _prom_catalog.
+package metricconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	// The chunk interval is NULL when TimescaleDB is not installed.
+	getSettingsSQL = `SELECT extract(epoch FROM coalesce(i.chunk_interval, interval '0'))::float8, NOT m.default_chunk_interval,
+	_prom_catalog.get_metric_compression_setting(m.metric_name), NOT m.default_compression,
+	extract(epoch FROM _prom_catalog.get_metric_retention_period(m.table_schema, m.metric_name))::float8, m.retention_period IS NOT NULL
+FROM _prom_catalog.metric m
+INNER JOIN prom_info.metric i ON (i.id = m.id)
+WHERE m.metric_name = $1 AND m.table_schema = 'prom_data'`
+	setChunkIntervalSQL   = "SELECT prom_api.set_metric_chunk_interval($1, $2)"
+	resetChunkIntervalSQL = "SELECT prom_api.reset_metric_chunk_interval($1)"
+	setCompressionSQL     = "SELECT prom_api.set_metric_compression_setting($1, $2)"
+	resetCompressionSQL   = "SELECT prom_api.reset_metric_compression_setting($1)"
+	setRetentionSQL       = "SELECT prom_api.set_metric_retention_period($1, $2)"
+	resetRetentionSQL     = "SELECT prom_api.reset_metric_retention_period($1)"
+)
+
+// ErrNotFound is returned for the metrics without a metric table.
+var ErrNotFound = errors.New("metric not found")
+
+// Settings are the storage settings of a metric. The Override fields are
+// true if the setting overrides the default one of all the metrics.
+type Settings struct {
+	Metric string `json:"metric"`
+	// ChunkIntervalSeconds is 0 if TimescaleDB is not installed.
+	ChunkIntervalSeconds  float64 `json:"chunkIntervalSeconds"`
+	ChunkIntervalOverride bool    `json:"chunkIntervalOverride"`
+	Compression           bool    `json:"compression"`
+	CompressionOverride   bool    `json:"compressionOverride"`
+	RetentionSeconds      float64 `json:"retentionSeconds"`
+	RetentionOverride     bool    `json:"retentionOverride"`
+}
+
+// Update is a change of the settings of a metric. The nil settings are left
+// as they are, the reset ones use the default settings again.
+type Update struct {
+	ChunkInterval      *time.Duration
+	ResetChunkInterval bool
+	Compression        *bool
+	ResetCompression   bool
+	Retention          *time.Duration
+	ResetRetention     bool
+}
+
+// Validate checks that the update changes at least one setting and that the
+// new settings are valid.
+func Validate(u Update) error {
+	switch {
+	case u.ChunkInterval == nil && !u.ResetChunkInterval && u.Compression == nil && !u.ResetCompression &&
+		u.Retention == nil && !u.ResetRetention:
+		return fmt.Errorf("no setting to change")
+	case u.ChunkInterval != nil && u.ResetChunkInterval:
+		return fmt.Errorf("chunk_interval cannot be both set and reset")
+	case u.Compression != nil && u.ResetCompression:
+		return fmt.Errorf("compression cannot be both set and reset")
+	case u.Retention != nil && u.ResetRetention:
+		return fmt.Errorf("retention cannot be both set and reset")
+	case u.ChunkInterval != nil && *u.ChunkInterval <= 0:
+		return fmt.Errorf("chunk_interval must be positive: %s", *u.ChunkInterval)
+	case u.Retention != nil && *u.Retention <= 0:
+		return fmt.Errorf("retention must be positive: %s", *u.Retention)
+	case u.ChunkInterval != nil && u.Retention != nil && *u.Retention < *u.ChunkInterval:
+		return fmt.Errorf("retention must not be shorter than chunk_interval")
+	}
+	return nil
+}
+
+// String describes the changed settings, for the logs.
+func (u Update) String() string {
+	var changes []string
+	switch {
+	case u.ChunkInterval != nil:
+		changes = append(changes, fmt.Sprintf("chunk_interval=%s", *u.ChunkInterval))
+	case u.ResetChunkInterval:
+		changes = append(changes, "chunk_interval=default")
+	}
+	switch {
+	case u.Compression != nil:
+		changes = append(changes, fmt.Sprintf("compression=%t", *u.Compression))
+	case u.ResetCompression:
+		changes = append(changes, "compression=default")
+	}
+	switch {
+	case u.Retention != nil:
+		changes = append(changes, fmt.Sprintf("retention=%s", *u.Retention))
+	case u.ResetRetention:
+		changes = append(changes, "retention=default")
+	}
+	return strings.Join(changes, " ")
+}
+
+// Get returns the settings of the metric, ErrNotFound if it has no metric
+// table.
+func Get(ctx context.Context, conn pgxconn.PgxConn, metric string) (Settings, error) {
+	s := Settings{Metric: metric}
+	err := conn.QueryRow(ctx, getSettingsSQL, metric).Scan(&s.ChunkIntervalSeconds, &s.ChunkIntervalOverride,
+		&s.Compression, &s.CompressionOverride, &s.RetentionSeconds, &s.RetentionOverride)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrNotFound
+	}
+	if err != nil {
+		return s, fmt.Errorf("get settings of %s: %w", metric, err)
+	}
+	return s, nil
+}
+
+// Apply changes the settings of the metric. The metric table is created if
+// it does not exist yet, so that the settings apply from the first chunk.
+// The settings are changed one after the other, the ones changed before an
+// error are kept.
+func Apply(ctx context.Context, conn pgxconn.PgxConn, metric string, u Update) error {
+	exec := func(setting, sql string, args ...interface{}) error {
+		if _, err := conn.Exec(ctx, sql, append([]interface{}{metric}, args...)...); err != nil {
+			return fmt.Errorf("setting %s of %s: %w", setting, metric, err)
+		}
+		return nil
+	}
+	var err error
+	switch {
+	case u.ChunkInterval != nil:
+		err = exec("chunk interval", setChunkIntervalSQL, *u.ChunkInterval)
+	case u.ResetChunkInterval:
+		err = exec("chunk interval", resetChunkIntervalSQL)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Compression != nil:
+		err = exec("compression", setCompressionSQL, *u.Compression)
+	case u.ResetCompression:
+		err = exec("compression", resetCompressionSQL)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Retention != nil:
+		err = exec("retention", setRetentionSQL, *u.Retention)
+	case u.ResetRetention:
+		err = exec("retention", resetRetentionSQL)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package metricconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestValidate(t *testing.T) {
+	hour, day, negative := time.Hour, 24*time.Hour, -time.Hour
+	enabled := true
+	require.NoError(t, Validate(Update{ChunkInterval: &hour}))
+	require.NoError(t, Validate(Update{ChunkInterval: &hour, Compression: &enabled, Retention: &day}))
+	require.NoError(t, Validate(Update{ResetChunkInterval: true, ResetCompression: true, ResetRetention: true}))
+
+	require.Error(t, Validate(Update{}))
+	require.Error(t, Validate(Update{ChunkInterval: &hour, ResetChunkInterval: true}))
+	require.Error(t, Validate(Update{Compression: &enabled, ResetCompression: true}))
+	require.Error(t, Validate(Update{Retention: &day, ResetRetention: true}))
+	require.Error(t, Validate(Update{ChunkInterval: &negative}))
+	require.Error(t, Validate(Update{Retention: &negative}))
+	require.Error(t, Validate(Update{ChunkInterval: &day, Retention: &hour}))
+}
+
+func TestGet(t *testing.T) {
+	conn := model.NewSqlRecorder([]model.SqlQuery{
+		{Sql: getSettingsSQL, Args: []interface{}{"up"}, Results: model.RowResults{{28800.0, true, false, false, 7776000.0, false}}},
+		{Sql: getSettingsSQL, Args: []interface{}{"missing"}, Err: pgx.ErrNoRows},
+	}, t)
+	settings, err := Get(context.Background(), conn, "up")
+	require.NoError(t, err)
+	require.Equal(t, Settings{Metric: "up", ChunkIntervalSeconds: 28800, ChunkIntervalOverride: true, RetentionSeconds: 7776000}, settings)
+
+	_, err = Get(context.Background(), conn, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestApply(t *testing.T) {
+	hour, day := time.Hour, 24*time.Hour
+	disabled := false
+	testCases := []struct {
+		name    string
+		update  Update
+		queries []model.SqlQuery
+		err     bool
+	}{
+		{
+			name:   "set all",
+			update: Update{ChunkInterval: &hour, Compression: &disabled, Retention: &day},
+			queries: []model.SqlQuery{
+				{Sql: setChunkIntervalSQL, Args: []interface{}{"up", hour}},
+				{Sql: setCompressionSQL, Args: []interface{}{"up", false}},
+				{Sql: setRetentionSQL, Args: []interface{}{"up", day}},
+			},
+		},
+		{
+			name:   "reset",
+			update: Update{ResetChunkInterval: true, ResetRetention: true},
+			queries: []model.SqlQuery{
+				{Sql: resetChunkIntervalSQL, Args: []interface{}{"up"}},
+				{Sql: resetRetentionSQL, Args: []interface{}{"up"}},
+			},
+		},
+		{
+			name:   "stops on error",
+			update: Update{ResetCompression: true, Retention: &day},
+			queries: []model.SqlQuery{
+				{Sql: resetCompressionSQL, Args: []interface{}{"up"}, Err: fmt.Errorf("some error")},
+			},
+			err: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder(c.queries, t)
+			err := Apply(context.Background(), conn, "up", c.update)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
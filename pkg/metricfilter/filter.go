@@ -0,0 +1,345 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package metricfilter drops the written series of the metrics matching deny
+// patterns or missing from an allow list, and caps the number of series of
+// the metrics matching a series limit, before they reach the database. It
+// protects the database from clients writing junk metrics or exploding
+// cardinality.
+package metricfilter
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+	"github.com/timescale/promscale/pkg/webhook"
+)
+
+// Reasons a series is dropped, as used in the metrics.
+const (
+	reasonDenied      = "denied"
+	reasonNotAllowed  = "not_allowed"
+	reasonSeriesLimit = "series_limit"
+)
+
+var (
+	droppedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "metric_filter",
+			Name:      "dropped_series_total",
+			Help:      "Total number of written series dropped by the metric filter, by pattern and reason. The series of a metric missing from the allow list have an empty pattern.",
+		}, []string{"pattern", "reason"},
+	)
+	droppedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "metric_filter",
+			Name:      "dropped_samples_total",
+			Help:      "Total number of written samples dropped by the metric filter, by pattern and reason. The samples of a metric missing from the allow list have an empty pattern.",
+		}, []string{"pattern", "reason"},
+	)
+	limitedSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "metric_filter",
+			Name:      "limited_series",
+			Help:      "Number of series accepted for the metrics matching each series limit pattern since the connector started.",
+		}, []string{"pattern"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(droppedSeries, droppedSamples, limitedSeries)
+}
+
+// Config holds the metric filter flags.
+type Config struct {
+	ConfigFile string
+}
+
+// ParseFlags registers the metric filter flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.ConfigFile, "metrics.filter.config-file", "", "Path to a YAML file with the allow and deny patterns of the metric names and the series limits of the metrics "+
+		"applied to the written series after relabeling. The file is reloaded on SIGHUP or a call to the /-/reload endpoint. No metric is filtered if empty.")
+	return cfg
+}
+
+// Validate checks that the metric filter file, if any, can be loaded.
+func Validate(cfg *Config) error {
+	if cfg.ConfigFile == "" {
+		return nil
+	}
+	_, err := loadConfigFile(cfg.ConfigFile)
+	return err
+}
+
+// configFile is the format of the metric filter file, e.g.
+//
+//	deny:
+//	  - junk_.*
+//	allow:
+//	  - node_.*
+//	  - up
+//	series_limits:
+//	  - regex: node_systemd_.*
+//	    max_series: 10000
+//
+// The patterns match the whole metric name. All the metrics are allowed if
+// the allow list is empty.
+type configFile struct {
+	Deny         []string            `yaml:"deny"`
+	Allow        []string            `yaml:"allow"`
+	SeriesLimits []seriesLimitConfig `yaml:"series_limits"`
+}
+
+type seriesLimitConfig struct {
+	Regex     string `yaml:"regex"`
+	MaxSeries int    `yaml:"max_series"`
+}
+
+type pattern struct {
+	source string
+	regex  relabel.Regexp
+}
+
+func newPattern(source string) (pattern, error) {
+	regex, err := relabel.NewRegexp(source)
+	if err != nil {
+		return pattern{}, fmt.Errorf("invalid pattern %q: %w", source, err)
+	}
+	return pattern{source: source, regex: regex}, nil
+}
+
+// rules are the parsed patterns of a metric filter file.
+type rules struct {
+	deny   []pattern
+	allow  []pattern
+	limits []*seriesLimit
+}
+
+func loadConfigFile(path string) (*rules, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metric filter file: %w", err)
+	}
+	return parseRules(contents)
+}
+
+func parseRules(contents []byte) (*rules, error) {
+	var f configFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return nil, fmt.Errorf("parsing metric filter file: %w", err)
+	}
+	r := &rules{}
+	for _, lists := range []struct {
+		sources  []string
+		patterns *[]pattern
+	}{
+		{f.Deny, &r.deny},
+		{f.Allow, &r.allow},
+	} {
+		for _, s := range lists.sources {
+			p, err := newPattern(s)
+			if err != nil {
+				return nil, err
+			}
+			*lists.patterns = append(*lists.patterns, p)
+		}
+	}
+	for _, l := range f.SeriesLimits {
+		p, err := newPattern(l.Regex)
+		if err != nil {
+			return nil, err
+		}
+		if l.MaxSeries <= 0 {
+			return nil, fmt.Errorf("max_series of pattern %q must be positive", l.Regex)
+		}
+		r.limits = append(r.limits, &seriesLimit{pattern: p, max: l.MaxSeries, set: &seriesSet{series: make(map[uint64]struct{})}})
+	}
+	return r, nil
+}
+
+// seriesLimit accepts the series of the metrics matching its pattern until
+// it saw max of them. The series it accepted keep being accepted.
+type seriesLimit struct {
+	pattern pattern
+	max     int
+	// set is kept across reloads while the pattern does not change.
+	set *seriesSet
+}
+
+type seriesSet struct {
+	mu       sync.Mutex
+	series   map[uint64]struct{}
+	breached bool
+}
+
+func (l *seriesLimit) accept(lbls []prompb.Label) bool {
+	hash := labelsHash(lbls)
+	set := l.set
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if _, ok := set.series[hash]; ok {
+		return true
+	}
+	if len(set.series) >= l.max {
+		if !set.breached {
+			set.breached = true
+			log.Warn("msg", "Metric filter series limit reached, new series are dropped", "pattern", l.pattern.source, "max_series", l.max)
+			webhook.Emit(webhook.Event{
+				Type:    webhook.CardinalityLimitBreached,
+				Subject: l.pattern.source,
+				Message: fmt.Sprintf("metrics matching %q reached their limit of %d series", l.pattern.source, l.max),
+				Details: map[string]string{"pattern": l.pattern.source, "max_series": strconv.Itoa(l.max)},
+			})
+		}
+		return false
+	}
+	set.series[hash] = struct{}{}
+	limitedSeries.WithLabelValues(l.pattern.source).Set(float64(len(set.series)))
+	return true
+}
+
+// labelsHash returns the hash of the labels in any order.
+func labelsHash(lbls []prompb.Label) uint64 {
+	ls := make(labels.Labels, len(lbls))
+	for i, l := range lbls {
+		ls[i] = labels.Label{Name: l.Name, Value: l.Value}
+	}
+	sort.Sort(ls)
+	return ls.Hash()
+}
+
+// Filter applies the rules of the metric filter file to the written series.
+// A nil Filter keeps all the series.
+type Filter struct {
+	path string
+
+	mu    sync.RWMutex
+	rules *rules
+}
+
+// NewFilter returns a Filter with the rules from the metric filter file of
+// cfg, or nil if no file is configured.
+func NewFilter(cfg *Config) (*Filter, error) {
+	if cfg.ConfigFile == "" {
+		return nil, nil
+	}
+	r, err := loadConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{path: cfg.ConfigFile, rules: r}, nil
+}
+
+// Reload reads the metric filter file again and applies the new rules to the
+// following writes. The series limits with an unchanged pattern keep the
+// series they accepted, even if their max_series is lowered.
+func (f *Filter) Reload() error {
+	if f == nil {
+		return nil
+	}
+	r, err := loadConfigFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.setRules(r)
+	log.Info("msg", "Metric filter reloaded", "file", f.path, "deny", len(r.deny), "allow", len(r.allow), "series_limits", len(r.limits))
+	return nil
+}
+
+func (f *Filter) setRules(r *rules) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := make(map[string]*seriesLimit, len(f.rules.limits))
+	for _, l := range f.rules.limits {
+		previous[l.pattern.source] = l
+	}
+	for _, l := range r.limits {
+		if p, ok := previous[l.pattern.source]; ok {
+			// The limit is reported again if it is reached with the
+			// new max_series.
+			p.set.mu.Lock()
+			p.set.breached = false
+			p.set.mu.Unlock()
+			l.set = p.set
+			delete(previous, l.pattern.source)
+		}
+	}
+	for source := range previous {
+		limitedSeries.DeleteLabelValues(source)
+	}
+	f.rules = r
+}
+
+// Keep returns false if the series is dropped by the metric filter. The
+// deny patterns are applied first, then the allow list and the first series
+// limit matching the metric name.
+func (f *Filter) Keep(ts *prompb.TimeSeries) bool {
+	if f == nil {
+		return true
+	}
+	f.mu.RLock()
+	r := f.rules
+	f.mu.RUnlock()
+
+	name := metricName(ts.Labels)
+	for _, p := range r.deny {
+		if p.regex.MatchString(name) {
+			drop(ts, p.source, reasonDenied)
+			return false
+		}
+	}
+	if len(r.allow) > 0 {
+		allowed := false
+		for _, p := range r.allow {
+			if p.regex.MatchString(name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			drop(ts, "", reasonNotAllowed)
+			return false
+		}
+	}
+	for _, l := range r.limits {
+		if !l.pattern.regex.MatchString(name) {
+			continue
+		}
+		if !l.accept(ts.Labels) {
+			drop(ts, l.pattern.source, reasonSeriesLimit)
+			return false
+		}
+		break
+	}
+	return true
+}
+
+func drop(ts *prompb.TimeSeries, pattern, reason string) {
+	droppedSeries.WithLabelValues(pattern, reason).Inc()
+	droppedSamples.WithLabelValues(pattern, reason).Add(float64(len(ts.Samples)))
+}
+
+func metricName(lbls []prompb.Label) string {
+	for _, l := range lbls {
+		if l.Name == labels.MetricName {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package metricfilter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const testConfig = `
+deny:
+  - junk_.*
+allow:
+  - node_.*
+  - up
+series_limits:
+  - regex: node_systemd_.*
+    max_series: 2
+`
+
+func series(name, unit string) *prompb.TimeSeries {
+	return &prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "unit", Value: unit}},
+		Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 1}},
+	}
+}
+
+func TestKeep(t *testing.T) {
+	r, err := parseRules([]byte(testConfig))
+	require.NoError(t, err)
+	f := &Filter{rules: r}
+
+	require.True(t, f.Keep(series("up", "")))
+	require.True(t, f.Keep(series("node_cpu_seconds_total", "")))
+	require.False(t, f.Keep(series("junk_metric", "")))
+	require.False(t, f.Keep(series("http_requests_total", "")))
+	// The patterns match the whole metric name.
+	require.False(t, f.Keep(series("upstream", "")))
+
+	require.True(t, f.Keep(series("node_systemd_unit_state", "a")))
+	require.True(t, f.Keep(series("node_systemd_unit_state", "b")))
+	require.False(t, f.Keep(series("node_systemd_unit_state", "c")))
+	// The accepted series are still accepted, in any label order.
+	require.True(t, f.Keep(&prompb.TimeSeries{Labels: []prompb.Label{{Name: "unit", Value: "a"}, {Name: "__name__", Value: "node_systemd_unit_state"}}}))
+
+	require.Equal(t, 1.0, testutil.ToFloat64(droppedSeries.WithLabelValues("junk_.*", reasonDenied)))
+	require.Equal(t, 4.0, testutil.ToFloat64(droppedSamples.WithLabelValues("", reasonNotAllowed)))
+	require.Equal(t, 2.0, testutil.ToFloat64(droppedSamples.WithLabelValues("node_systemd_.*", reasonSeriesLimit)))
+	require.Equal(t, 2.0, testutil.ToFloat64(limitedSeries.WithLabelValues("node_systemd_.*")))
+
+	var nilFilter *Filter
+	require.True(t, nilFilter.Keep(series("junk_metric", "")))
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0600))
+	f, err := NewFilter(&Config{ConfigFile: path})
+	require.NoError(t, err)
+	require.True(t, f.Keep(series("node_systemd_unit_state", "a")))
+	require.True(t, f.Keep(series("node_systemd_unit_state", "b")))
+
+	// The accepted series are kept with a higher limit.
+	require.NoError(t, os.WriteFile(path, []byte(`
+series_limits:
+  - regex: node_systemd_.*
+    max_series: 3
+`), 0600))
+	require.NoError(t, f.Reload())
+	require.True(t, f.Keep(series("http_requests_total", "")))
+	require.True(t, f.Keep(series("node_systemd_unit_state", "c")))
+	require.False(t, f.Keep(series("node_systemd_unit_state", "d")))
+
+	// An invalid file keeps the current rules.
+	require.NoError(t, os.WriteFile(path, []byte("deny: [\"(\"]"), 0600))
+	require.Error(t, f.Reload())
+	require.True(t, f.Keep(series("http_requests_total", "")))
+}
+
+func TestParseRules(t *testing.T) {
+	_, err := parseRules([]byte(testConfig))
+	require.NoError(t, err)
+
+	for _, invalid := range []string{
+		"deny: [\"(\"]",
+		"series_limits: [{regex: up}]",
+		"series_limits: [{regex: up, max_series: -1}]",
+		"unknown: true",
+	} {
+		_, err = parseRules([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+	require.NoError(t, Validate(&Config{}))
+	require.Error(t, Validate(&Config{ConfigFile: "missing.yml"}))
+}
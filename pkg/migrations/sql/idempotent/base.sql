@@ -1194,6 +1194,110 @@ $func$
 LANGUAGE PLPGSQL VOLATILE;
 GRANT EXECUTE ON FUNCTION _prom_catalog.get_or_create_series_id_for_label_array(INT, NAME, prom_api.label_array) TO prom_writer;
 
+-- Resolves the series ids of a batch of series in a single call, creating the
+-- missing labels, label key positions and series.
+-- The labels of the series are flattened: label i has the key label_keys[i] and
+-- the value label_values[i], and belongs to the series label_series[i] of the
+-- metric label_metrics[i], an index in metric_names, metric_tables and metric_ids.
+-- label_ids and label_pos hold the id and position of the labels already known
+-- by the caller, 0 for the ones to resolve.
+-- Returns the id of each series along with the ids and positions of its labels,
+-- in the order of the arguments.
+-- To avoid deadlocks, the labels, then the key positions, then the series are
+-- created in a canonical order: labels by key and value, positions and series
+-- by metric name, series by label array.
+CREATE OR REPLACE FUNCTION _prom_catalog.get_or_create_series_ids(
+        metric_names text[], metric_tables name[], metric_ids int[],
+        label_series int[], label_metrics int[], label_keys text[], label_values text[],
+        label_ids int[], label_pos int[])
+    RETURNS TABLE(series_nr int, series_id bigint, series_label_ids int[], series_label_pos int[])
+AS $func$
+DECLARE
+    metric_idx int;
+    new_keys text[];
+    resolved_ids int[];
+BEGIN
+    WITH missing AS (
+        SELECT DISTINCT l.key, l.value
+        FROM unnest(label_keys, label_values, label_ids) AS l(key, value, id)
+        WHERE l.id = 0
+    ), created AS (
+        SELECT
+            m.key,
+            m.value,
+            -- only call the function to create the label if it does not
+            -- exist (for performance reasons)
+            coalesce(lbl.id, _prom_catalog.get_or_create_label_id(m.key, m.value)) AS id
+        FROM missing m
+            LEFT JOIN _prom_catalog.label lbl ON (lbl.key = m.key AND lbl.value = m.value)
+        ORDER BY m.key, m.value
+    )
+    SELECT array_agg(CASE WHEN l.id <> 0 THEN l.id ELSE c.id END ORDER BY l.ord)
+    FROM unnest(label_keys, label_values, label_ids) WITH ORDINALITY AS l(key, value, id, ord)
+        LEFT JOIN created c ON (l.id = 0 AND c.key = l.key AND c.value = l.value)
+    INTO resolved_ids;
+
+    FOR metric_idx, new_keys IN
+        SELECT l.metric_idx, array_agg(DISTINCT l.key ORDER BY l.key)
+        FROM unnest(label_metrics, label_keys, label_pos) AS l(metric_idx, key, pos)
+        WHERE l.pos = 0
+        GROUP BY l.metric_idx
+        ORDER BY metric_names[l.metric_idx]
+    LOOP
+        PERFORM _prom_catalog.get_new_pos_for_key(metric_names[metric_idx], metric_tables[metric_idx], new_keys, false);
+    END LOOP;
+
+    RETURN QUERY
+    WITH labels AS (
+        SELECT
+            l.nr,
+            l.metric_idx,
+            l.ord,
+            resolved_ids[l.ord::int] AS id,
+            CASE WHEN l.pos <> 0 THEN l.pos ELSE p.pos END AS pos
+        FROM unnest(label_series, label_metrics, label_keys, label_pos) WITH ORDINALITY AS l(nr, metric_idx, key, pos, ord)
+            LEFT JOIN _prom_catalog.label_key_position p ON
+            (
+                l.pos = 0 AND
+                p.metric_name = metric_names[l.metric_idx] AND
+                p.key = l.key
+            )
+    ), series AS (
+        SELECT
+            l.nr,
+            min(l.metric_idx) AS metric_idx,
+            array_agg(l.id ORDER BY l.ord) AS ids,
+            array_agg(l.pos ORDER BY l.ord) AS poss
+        FROM labels l
+        GROUP BY l.nr
+    ), label_arrays AS (
+        SELECT
+            s.nr,
+            s.metric_idx,
+            s.ids,
+            s.poss,
+            ARRAY(
+                SELECT coalesce(a.id, 0)
+                FROM generate_series(1, (SELECT max(x) FROM unnest(s.poss) x)) g
+                    LEFT JOIN unnest(s.poss, s.ids) AS a(pos, id) ON (a.pos = g)
+                ORDER BY g
+            )::prom_api.label_array AS larray
+        FROM series s
+    )
+    SELECT
+        a.nr,
+        _prom_catalog.get_or_create_series_id_for_label_array(metric_ids[a.metric_idx], metric_tables[a.metric_idx], a.larray),
+        a.ids,
+        a.poss
+    FROM label_arrays a
+    ORDER BY metric_names[a.metric_idx], a.larray;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE;
+COMMENT ON FUNCTION _prom_catalog.get_or_create_series_ids(text[], name[], int[], int[], int[], text[], text[], int[], int[])
+IS 'returns the series ids of a batch of series, creating their labels, label key positions and series if needed';
+GRANT EXECUTE ON FUNCTION _prom_catalog.get_or_create_series_ids(text[], name[], int[], int[], int[], text[], text[], int[], int[]) TO prom_writer;
+
 --
 -- Parameter manipulation functions
 --
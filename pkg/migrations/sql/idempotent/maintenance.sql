@@ -306,10 +306,111 @@ COMMENT ON FUNCTION ps_trace.get_trace_retention_period()
 IS 'get the retention period for trace data';
 GRANT EXECUTE ON FUNCTION ps_trace.get_trace_retention_period() TO prom_reader;
 
+CREATE OR REPLACE FUNCTION ps_trace.set_service_retention_period(_service_name text, _retention_period INTERVAL)
+RETURNS BOOLEAN
+AS $$
+    INSERT INTO _ps_trace.service_config(service_name, retention_period) VALUES (_service_name, _retention_period)
+    ON CONFLICT (service_name) DO UPDATE SET retention_period = EXCLUDED.retention_period;
+    SELECT true;
+$$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION ps_trace.set_service_retention_period(text, INTERVAL)
+IS 'set the retention period for the trace data of a service, overriding the default one';
+GRANT EXECUTE ON FUNCTION ps_trace.set_service_retention_period(text, INTERVAL) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION ps_trace.reset_service_retention_period(_service_name text)
+RETURNS BOOLEAN
+AS $$
+    UPDATE _ps_trace.service_config SET retention_period = NULL WHERE service_name = _service_name;
+    DELETE FROM _ps_trace.service_config
+    WHERE service_name = _service_name AND retention_period IS NULL AND compress_after IS NULL;
+    SELECT true;
+$$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION ps_trace.reset_service_retention_period(text)
+IS 'reset the retention period for the trace data of a service to the default one';
+GRANT EXECUTE ON FUNCTION ps_trace.reset_service_retention_period(text) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION ps_trace.get_service_retention_period(_service_name text)
+RETURNS INTERVAL
+AS $$
+    SELECT coalesce(
+        (SELECT retention_period FROM _ps_trace.service_config WHERE service_name = _service_name),
+        ps_trace.get_trace_retention_period()
+    )
+$$
+LANGUAGE SQL STABLE;
+COMMENT ON FUNCTION ps_trace.get_service_retention_period(text)
+IS 'get the retention period for the trace data of a service';
+GRANT EXECUTE ON FUNCTION ps_trace.get_service_retention_period(text) TO prom_reader;
+
+CREATE OR REPLACE FUNCTION ps_trace.set_service_compress_after(_service_name text, _compress_after INTERVAL)
+RETURNS BOOLEAN
+AS $$
+    INSERT INTO _ps_trace.service_config(service_name, compress_after) VALUES (_service_name, _compress_after)
+    ON CONFLICT (service_name) DO UPDATE SET compress_after = EXCLUDED.compress_after;
+    SELECT true;
+$$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION ps_trace.set_service_compress_after(text, INTERVAL)
+IS 'set how old the trace data of a service is before it is compressed';
+GRANT EXECUTE ON FUNCTION ps_trace.set_service_compress_after(text, INTERVAL) TO prom_admin;
+
+CREATE OR REPLACE FUNCTION ps_trace.reset_service_compress_after(_service_name text)
+RETURNS BOOLEAN
+AS $$
+    UPDATE _ps_trace.service_config SET compress_after = NULL WHERE service_name = _service_name;
+    DELETE FROM _ps_trace.service_config
+    WHERE service_name = _service_name AND retention_period IS NULL AND compress_after IS NULL;
+    SELECT true;
+$$
+LANGUAGE SQL VOLATILE;
+COMMENT ON FUNCTION ps_trace.reset_service_compress_after(text)
+IS 'stop compressing the trace data of a service';
+GRANT EXECUTE ON FUNCTION ps_trace.reset_service_compress_after(text) TO prom_admin;
+
+--deletes the spans older than _older_than of the services, or of all the
+--other services if _other_services, with their events and links. The
+--chunks hold the spans of every service, so they cannot be dropped.
+CREATE OR REPLACE PROCEDURE _ps_trace.delete_service_data(_older_than timestamptz, _service_names text[], _other_services boolean)
+AS $func$
+BEGIN
+    WITH operation AS (
+        SELECT o.id
+        FROM _ps_trace.operation o
+        INNER JOIN _ps_trace.tag t ON (t.id = o.service_name_id AND t.key = 'service.name')
+        WHERE (t.value #>> '{}' = ANY(_service_names)) != _other_services
+    ), deleted_span AS (
+        DELETE FROM _ps_trace.span s
+        WHERE s.start_time < _older_than
+        AND s.operation_id IN (SELECT id FROM operation)
+        RETURNING s.trace_id, s.span_id, s.start_time
+    ), deleted_event AS (
+        DELETE FROM _ps_trace.event e
+        USING deleted_span d
+        WHERE e.trace_id = d.trace_id AND e.span_id = d.span_id
+    )
+    DELETE FROM _ps_trace.link l
+    USING deleted_span d
+    WHERE l.trace_id = d.trace_id AND l.span_id = d.span_id AND l.span_start_time = d.start_time;
+END
+$func$
+LANGUAGE PLPGSQL
+--security definer to delete as the owner of the tables
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON PROCEDURE _ps_trace.delete_service_data(timestamptz, text[], boolean) FROM PUBLIC;
+GRANT EXECUTE ON PROCEDURE _ps_trace.delete_service_data(timestamptz, text[], boolean) TO prom_maintenance;
+
 CREATE OR REPLACE PROCEDURE _ps_trace.execute_data_retention_policy(log_verbose boolean)
 AS $$
 DECLARE
     _trace_retention_period interval;
+    _chunk_retention_period interval;
+    _service_name text;
+    _service_retention_period interval;
     _older_than timestamptz;
     _last timestamptz;
     _start timestamptz;
@@ -329,7 +430,13 @@ BEGIN
         RAISE EXCEPTION 'promscale maintenance: data retention: tracing: trace_retention_period is null.';
     END IF;
 
-    _older_than = now() - _trace_retention_period;
+    --the chunks are dropped after the longest retention period of the
+    --services, the data of the services with a shorter one is deleted.
+    SELECT greatest(_trace_retention_period, max(retention_period))
+    INTO _chunk_retention_period
+    FROM _ps_trace.service_config;
+
+    _older_than = now() - _chunk_retention_period;
     IF _older_than >= now() THEN -- bail early. no need to continue
         RAISE WARNING 'promscale maintenance: data retention: tracing: aborting. trace_retention_period set to zero or negative interval';
         IF log_verbose THEN
@@ -393,6 +500,54 @@ BEGIN
     END;
     COMMIT;
 
+    FOR _service_name, _service_retention_period IN
+        SELECT service_name, retention_period
+        FROM _ps_trace.service_config
+        WHERE retention_period < _chunk_retention_period
+        ORDER BY service_name
+    LOOP
+        _last := clock_timestamp();
+        PERFORM _prom_catalog.set_app_name(format('promscale maintenance: data retention: tracing: deleting data of service %s', _service_name));
+        BEGIN
+            CALL _ps_trace.delete_service_data(now() - _service_retention_period, ARRAY[_service_name], false);
+            IF log_verbose THEN
+                RAISE LOG 'promscale maintenance: data retention: tracing: done deleting data of service % in %', _service_name, clock_timestamp()-_last;
+            END IF;
+        EXCEPTION WHEN OTHERS THEN
+            GET STACKED DIAGNOSTICS
+                _message_text = MESSAGE_TEXT,
+                _pg_exception_detail = PG_EXCEPTION_DETAIL,
+                _pg_exception_hint = PG_EXCEPTION_HINT;
+            RAISE WARNING 'promscale maintenance: data retention: tracing: failed to delete data of service %. % % % %',
+                _service_name, _message_text, _pg_exception_detail, _pg_exception_hint, clock_timestamp()-_last;
+        END;
+        COMMIT;
+    END LOOP;
+
+    --the services without a retention period of their own have the default one.
+    IF _trace_retention_period < _chunk_retention_period THEN
+        _last := clock_timestamp();
+        PERFORM _prom_catalog.set_app_name('promscale maintenance: data retention: tracing: deleting data of the other services');
+        BEGIN
+            CALL _ps_trace.delete_service_data(
+                now() - _trace_retention_period,
+                ARRAY(SELECT service_name FROM _ps_trace.service_config WHERE retention_period IS NOT NULL),
+                true
+            );
+            IF log_verbose THEN
+                RAISE LOG 'promscale maintenance: data retention: tracing: done deleting data of the other services in %', clock_timestamp()-_last;
+            END IF;
+        EXCEPTION WHEN OTHERS THEN
+            GET STACKED DIAGNOSTICS
+                _message_text = MESSAGE_TEXT,
+                _pg_exception_detail = PG_EXCEPTION_DETAIL,
+                _pg_exception_hint = PG_EXCEPTION_HINT;
+            RAISE WARNING 'promscale maintenance: data retention: tracing: failed to delete data of the other services. % % % %',
+                _message_text, _pg_exception_detail, _pg_exception_hint, clock_timestamp()-_last;
+        END;
+        COMMIT;
+    END IF;
+
     IF log_verbose THEN
         RAISE LOG 'promscale maintenance: data retention: tracing: finished in %', clock_timestamp()-_start;
     END IF;
@@ -402,6 +557,69 @@ COMMENT ON PROCEDURE _ps_trace.execute_data_retention_policy(boolean)
 IS 'drops old data according to the data retention policy. This procedure should be run regularly in a cron job';
 GRANT EXECUTE ON PROCEDURE _ps_trace.execute_data_retention_policy(boolean) TO prom_maintenance;
 
+CREATE OR REPLACE PROCEDURE _ps_trace.compress_chunks(_table_name name, _older_than timestamptz)
+AS $func$
+DECLARE
+    _chunk regclass;
+BEGIN
+    FOR _chunk IN
+        SELECT public.show_chunks(format('_ps_trace.%I', _table_name)::regclass, older_than=>_older_than)
+    LOOP
+        PERFORM public.compress_chunk(_chunk, if_not_compressed=>true);
+    END LOOP;
+END
+$func$
+LANGUAGE PLPGSQL
+--security definer to compress as the owner of the tables
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON PROCEDURE _ps_trace.compress_chunks(name, timestamptz) FROM PUBLIC;
+GRANT EXECUTE ON PROCEDURE _ps_trace.compress_chunks(name, timestamptz) TO prom_maintenance;
+
+CREATE OR REPLACE PROCEDURE _ps_trace.execute_compression_policy(log_verbose boolean)
+AS $$
+DECLARE
+    _compress_after interval;
+    _table_name name;
+    _last timestamptz;
+    _message_text text;
+    _pg_exception_detail text;
+    _pg_exception_hint text;
+BEGIN
+    --the chunks hold the data of every service, so they are compressed
+    --once they are older than the longest compress_after of the services.
+    SELECT max(compress_after) INTO _compress_after FROM _ps_trace.service_config;
+    IF _compress_after IS NULL THEN
+        RETURN;
+    END IF;
+
+    FOREACH _table_name IN ARRAY ARRAY['span', 'event', 'link']::name[]
+    LOOP
+        _last := clock_timestamp();
+        PERFORM _prom_catalog.set_app_name(format('promscale maintenance: compression: tracing: %s', _table_name));
+        BEGIN
+            CALL _ps_trace.compress_chunks(_table_name, now() - _compress_after);
+            IF log_verbose THEN
+                RAISE LOG 'promscale maintenance: compression: tracing: done compressing % data in %', _table_name, clock_timestamp()-_last;
+            END IF;
+        EXCEPTION WHEN OTHERS THEN
+            GET STACKED DIAGNOSTICS
+                _message_text = MESSAGE_TEXT,
+                _pg_exception_detail = PG_EXCEPTION_DETAIL,
+                _pg_exception_hint = PG_EXCEPTION_HINT;
+            RAISE WARNING 'promscale maintenance: compression: tracing: failed to compress % data. % % % %',
+                _table_name, _message_text, _pg_exception_detail, _pg_exception_hint, clock_timestamp()-_last;
+        END;
+        COMMIT;
+    END LOOP;
+END;
+$$ LANGUAGE PLPGSQL;
+COMMENT ON PROCEDURE _ps_trace.execute_compression_policy(boolean)
+IS 'compress trace data according to the compress_after of the services. This procedure should be run regularly in a cron job';
+GRANT EXECUTE ON PROCEDURE _ps_trace.execute_compression_policy(boolean) TO prom_maintenance;
+
 CREATE OR REPLACE PROCEDURE _prom_catalog.execute_data_retention_policy(log_verbose boolean)
 AS $$
 DECLARE
@@ -474,6 +692,7 @@ BEGIN
 
         PERFORM _prom_catalog.set_app_name( format('promscale maintenance: compression'));
         CALL _prom_catalog.execute_compression_policy(log_verbose=>log_verbose);
+        CALL _ps_trace.execute_compression_policy(log_verbose=>log_verbose);
     END IF;
 
     IF log_verbose THEN
@@ -500,6 +719,11 @@ DECLARE
 BEGIN
     log_verbose := coalesce(config->>'log_verbose', 'false')::boolean;
 
+    --the job runs in its own session, so the timeout applies to all its statements
+    IF config ? 'statement_timeout' THEN
+        PERFORM set_config('statement_timeout', config->>'statement_timeout', FALSE);
+    END IF;
+
     --if auto_explain enabled in config, turn it on in a best-effort way
     --i.e. if it fails (most likely due to lack of superuser priviliges) move on anyway.
     BEGIN
@@ -531,6 +755,9 @@ DECLARE
 BEGIN
     --check format of config
     log_verbose := coalesce(new_config->>'log_verbose', 'false')::boolean;
+    IF new_config ? 'statement_timeout' THEN
+        PERFORM (new_config->>'statement_timeout')::interval;
+    END IF;
 
     PERFORM public.delete_job(job_id)
     FROM timescaledb_information.jobs
@@ -566,3 +793,45 @@ REVOKE ALL ON FUNCTION prom_api.config_maintenance_jobs(int, interval, jsonb) FR
 GRANT EXECUTE ON FUNCTION prom_api.config_maintenance_jobs(int, interval, jsonb) TO prom_admin;
 COMMENT ON FUNCTION prom_api.config_maintenance_jobs(int, interval, jsonb)
 IS 'Configure the number of maintence jobs run by the job scheduler, as well as their scheduled interval';
+
+CREATE OR REPLACE FUNCTION prom_api.set_maintenance_jobs_scheduled(scheduled boolean, only_job_id int = NULL)
+RETURNS INT
+AS $func$
+DECLARE
+  cnt int;
+BEGIN
+    SELECT count(public.alter_job(job_id, scheduled => set_maintenance_jobs_scheduled.scheduled)) INTO cnt
+    FROM timescaledb_information.jobs
+    WHERE proc_schema = '_prom_catalog' AND proc_name = 'execute_maintenance_job'
+    AND (only_job_id IS NULL OR job_id = only_job_id);
+    RETURN cnt;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+SET search_path = pg_temp;
+REVOKE ALL ON FUNCTION prom_api.set_maintenance_jobs_scheduled(boolean, int) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION prom_api.set_maintenance_jobs_scheduled(boolean, int) TO prom_admin;
+COMMENT ON FUNCTION prom_api.set_maintenance_jobs_scheduled(boolean, int)
+IS 'Pause or resume the maintenance jobs, or only the one with only_job_id. Returns the number of jobs changed';
+
+CREATE OR REPLACE FUNCTION prom_api.jitter_maintenance_jobs(jitter interval)
+RETURNS INT
+AS $func$
+DECLARE
+  cnt int;
+BEGIN
+    --spread the next starts so that the jobs do not all run at once
+    SELECT count(public.alter_job(job_id, next_start => now() + random() * jitter)) INTO cnt
+    FROM timescaledb_information.jobs
+    WHERE proc_schema = '_prom_catalog' AND proc_name = 'execute_maintenance_job';
+    RETURN cnt;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+SET search_path = pg_temp;
+REVOKE ALL ON FUNCTION prom_api.jitter_maintenance_jobs(interval) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION prom_api.jitter_maintenance_jobs(interval) TO prom_admin;
+COMMENT ON FUNCTION prom_api.jitter_maintenance_jobs(interval)
+IS 'Reschedule the next start of each maintenance job to a random time within jitter from now';
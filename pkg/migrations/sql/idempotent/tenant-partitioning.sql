@@ -0,0 +1,78 @@
+--Partitions the samples of a raw metric by tenant, on top of time. A tenant
+--column holding the __tenant__ label of the series is added to the metric
+--table, and used as a space dimension of the hypertable. The queries
+--restricted to some tenants then only read the chunks of their partitions.
+--Only empty metric tables are converted, the function returns false if the
+--table has data.
+CREATE OR REPLACE FUNCTION _prom_catalog.set_metric_tenant_partitioning(metric_name TEXT, number_partitions INT)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    metric_table_name name;
+    metric_id int;
+    is_empty boolean;
+    compressed boolean;
+BEGIN
+    IF number_partitions < 1 THEN
+        RAISE EXCEPTION 'invalid number of tenant partitions % for metric "%"', number_partitions, set_metric_tenant_partitioning.metric_name;
+    END IF;
+
+    SELECT m.table_name, m.id
+    INTO STRICT metric_table_name, metric_id
+    FROM _prom_catalog.metric m
+    WHERE m.metric_name = set_metric_tenant_partitioning.metric_name
+    AND m.table_schema = 'prom_data';
+
+    EXECUTE format('LOCK TABLE prom_data.%I IN ACCESS EXCLUSIVE MODE', metric_table_name);
+    IF EXISTS (
+        SELECT 1 FROM pg_catalog.pg_attribute
+        WHERE attrelid = format('prom_data.%I', metric_table_name)::regclass
+        AND attname = 'tenant' AND NOT attisdropped
+    ) THEN
+        RETURN true;
+    END IF;
+    EXECUTE format('SELECT NOT EXISTS (SELECT 1 FROM prom_data.%I)', metric_table_name)
+    INTO STRICT is_empty;
+    IF NOT is_empty THEN
+        RETURN false;
+    END IF;
+
+    IF _prom_catalog.is_multinode() THEN
+        RAISE EXCEPTION 'cannot partition metric "%" by tenant in a multinode cluster', set_metric_tenant_partitioning.metric_name;
+    END IF;
+
+    --A dimension cannot be added to a hypertable with compression enabled,
+    --and the table is empty so compression can be turned off.
+    compressed = _prom_catalog.get_metric_compression_setting(set_metric_tenant_partitioning.metric_name);
+    IF compressed THEN
+        EXECUTE format('ALTER TABLE prom_data.%I SET (timescaledb.compress = false)', metric_table_name);
+    END IF;
+
+    --The samples of the series without tenant have an empty tenant. The
+    --column has no default so that the writes of a connector unaware of the
+    --partitioning fail instead of storing the samples in the wrong partition.
+    EXECUTE format('ALTER TABLE prom_data.%I ADD COLUMN tenant TEXT NOT NULL', metric_table_name);
+    --The unique indexes of a hypertable must include its partitioning
+    --columns. A series has a single tenant, so the samples are still unique
+    --by series and time.
+    EXECUTE format('DROP INDEX prom_data.%I', 'data_series_id_time_' || metric_id);
+    EXECUTE format('CREATE UNIQUE INDEX data_series_id_time_%s ON prom_data.%I (series_id, time, tenant) INCLUDE (value)',
+                    metric_id, metric_table_name);
+    IF _prom_catalog.is_timescaledb_installed() THEN
+        PERFORM public.add_dimension(format('prom_data.%I', metric_table_name)::regclass, 'tenant',
+                                     number_partitions => set_metric_tenant_partitioning.number_partitions);
+    END IF;
+
+    IF compressed THEN
+        PERFORM prom_api.set_compression_on_metric_table(metric_table_name, TRUE);
+    END IF;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION _prom_catalog.set_metric_tenant_partitioning(TEXT, INT) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION _prom_catalog.set_metric_tenant_partitioning(TEXT, INT) TO prom_writer;
@@ -536,6 +536,8 @@ AS $func$
     TRUNCATE _ps_trace.link;
     TRUNCATE _ps_trace.event;
     TRUNCATE _ps_trace.span;
+    TRUNCATE _ps_trace.service_dependency;
+    TRUNCATE _ps_trace.service_dependency_progress;
     TRUNCATE _ps_trace.instrumentation_lib RESTART IDENTITY;
     TRUNCATE _ps_trace.operation RESTART IDENTITY;
     TRUNCATE _ps_trace.schema_url RESTART IDENTITY CASCADE;
@@ -0,0 +1,64 @@
+--Changes the type of the value column of a raw metric, to store its samples
+--with an alternate encoding. Only empty metric tables are converted, the
+--function returns false if the table has data.
+--Values stored in a type other than double precision cannot represent the
+--staleness markers, they are stored as NULL instead.
+CREATE OR REPLACE FUNCTION _prom_catalog.set_metric_value_type(metric_name TEXT, value_type TEXT)
+RETURNS BOOLEAN
+AS $func$
+DECLARE
+    metric_table_name name;
+    is_empty boolean;
+    compressed boolean;
+BEGIN
+    IF value_type NOT IN ('double precision', 'real', 'integer', 'smallint') THEN
+        RAISE EXCEPTION 'unsupported value type "%" for metric "%"', value_type, set_metric_value_type.metric_name;
+    END IF;
+
+    SELECT m.table_name
+    INTO STRICT metric_table_name
+    FROM _prom_catalog.metric m
+    WHERE m.metric_name = set_metric_value_type.metric_name
+    AND m.table_schema = 'prom_data';
+
+    EXECUTE format('LOCK TABLE prom_data.%I IN ACCESS EXCLUSIVE MODE', metric_table_name);
+    EXECUTE format('SELECT NOT EXISTS (SELECT 1 FROM prom_data.%I)', metric_table_name)
+    INTO STRICT is_empty;
+    IF NOT is_empty THEN
+        RETURN false;
+    END IF;
+
+    IF _prom_catalog.is_multinode() THEN
+        RAISE EXCEPTION 'cannot change the value type of metric "%" in a multinode cluster', set_metric_value_type.metric_name;
+    END IF;
+
+    --The column type of a hypertable with compression enabled cannot change,
+    --and the table is empty so compression can be turned off.
+    compressed = _prom_catalog.get_metric_compression_setting(set_metric_value_type.metric_name);
+    IF compressed THEN
+        EXECUTE format('ALTER TABLE prom_data.%I SET (timescaledb.compress = false)', metric_table_name);
+    END IF;
+
+    --The metric view depends on the value column, so it is created again.
+    EXECUTE format('DROP VIEW IF EXISTS prom_metric.%I', metric_table_name);
+    EXECUTE format('ALTER TABLE prom_data.%I ALTER COLUMN value TYPE %s', metric_table_name, value_type);
+    IF value_type = 'double precision' THEN
+        EXECUTE format('ALTER TABLE prom_data.%I ALTER COLUMN value SET NOT NULL', metric_table_name);
+    ELSE
+        EXECUTE format('ALTER TABLE prom_data.%I ALTER COLUMN value DROP NOT NULL', metric_table_name);
+    END IF;
+    PERFORM _prom_catalog.create_metric_view(set_metric_value_type.metric_name);
+
+    IF compressed THEN
+        PERFORM prom_api.set_compression_on_metric_table(metric_table_name, TRUE);
+    END IF;
+    RETURN true;
+END
+$func$
+LANGUAGE PLPGSQL VOLATILE
+SECURITY DEFINER
+--search path must be set for security definer
+SET search_path = pg_temp;
+--redundant given schema settings but extra caution for security definers
+REVOKE ALL ON FUNCTION _prom_catalog.set_metric_value_type(TEXT, TEXT) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION _prom_catalog.set_metric_value_type(TEXT, TEXT) TO prom_writer;
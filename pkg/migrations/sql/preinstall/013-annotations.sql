@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS _ps_catalog.annotations (
+    id bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    time timestamptz NOT NULL,
+    time_end timestamptz NOT NULL,
+    dashboard_uid text NOT NULL DEFAULT '',
+    dashboard_id bigint NOT NULL DEFAULT 0,
+    panel_id bigint NOT NULL DEFAULT 0,
+    text text NOT NULL,
+    tags text[] NOT NULL DEFAULT '{}',
+    created timestamptz NOT NULL DEFAULT now(),
+    updated timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS annotations_time_idx ON _ps_catalog.annotations (time, time_end);
+CREATE INDEX IF NOT EXISTS annotations_tags_idx ON _ps_catalog.annotations USING gin (tags);
+GRANT SELECT ON TABLE _ps_catalog.annotations TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE _ps_catalog.annotations TO prom_writer;
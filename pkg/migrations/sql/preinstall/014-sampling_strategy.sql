@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS _ps_trace.sampling_strategy (
+    service_name text PRIMARY KEY,
+    strategy jsonb NOT NULL,
+    updated timestamptz NOT NULL DEFAULT now()
+);
+GRANT SELECT ON TABLE _ps_trace.sampling_strategy TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE _ps_trace.sampling_strategy TO prom_writer;
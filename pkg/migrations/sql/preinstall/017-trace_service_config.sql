@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS _ps_trace.service_config (
+    service_name text PRIMARY KEY,
+    -- NULL uses the default trace retention period.
+    retention_period interval CHECK (retention_period > interval '0'),
+    -- NULL leaves the chunks uncompressed for the service.
+    compress_after interval CHECK (compress_after >= interval '0')
+);
+GRANT SELECT ON TABLE _ps_trace.service_config TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE _ps_trace.service_config TO prom_admin;
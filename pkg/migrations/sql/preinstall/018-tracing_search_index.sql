@@ -0,0 +1,5 @@
+-- The trace search reads the latest spans of the searched operations, and
+-- filters them by duration, from this index alone. It replaces the index
+-- on operation_id.
+CREATE INDEX IF NOT EXISTS span_operation_id_start_time_idx ON _ps_trace.span USING BTREE (operation_id, start_time DESC) INCLUDE (trace_id, duration_ms);
+DROP INDEX IF EXISTS _ps_trace.span_operation_id_idx;
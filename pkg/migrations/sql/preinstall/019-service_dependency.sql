@@ -0,0 +1,25 @@
+-- The service dependencies aggregated from the spans by the connectors, by
+-- minute bucket of the start time of the child spans.
+CREATE TABLE IF NOT EXISTS _ps_trace.service_dependency (
+    bucket timestamptz NOT NULL,
+    parent_service text NOT NULL,
+    child_service text NOT NULL,
+    call_count bigint NOT NULL,
+    error_count bigint NOT NULL,
+    -- Number of child spans by duration bucket, the bucket bounds are set by
+    -- the connector.
+    duration_counts bigint[] NOT NULL,
+    PRIMARY KEY (bucket, parent_service, child_service)
+);
+GRANT SELECT ON TABLE _ps_trace.service_dependency TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE _ps_trace.service_dependency TO prom_writer;
+
+-- The time range the service dependencies were aggregated over, as a
+-- single row.
+CREATE TABLE IF NOT EXISTS _ps_trace.service_dependency_progress (
+    id bool PRIMARY KEY DEFAULT true CHECK (id),
+    computed_from timestamptz NOT NULL,
+    computed_until timestamptz NOT NULL
+);
+GRANT SELECT ON TABLE _ps_trace.service_dependency_progress TO prom_reader;
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE _ps_trace.service_dependency_progress TO prom_writer;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS _ps_catalog.audit_log (
+    time timestamptz NOT NULL,
+    identity text NOT NULL DEFAULT '',
+    remote_addr text NOT NULL DEFAULT '',
+    tenant text NOT NULL DEFAULT '',
+    endpoint text NOT NULL,
+    category text NOT NULL,
+    method text NOT NULL,
+    expr text NOT NULL DEFAULT '',
+    matchers text[] NOT NULL DEFAULT '{}',
+    metrics text[] NOT NULL DEFAULT '{}',
+    rows bigint NOT NULL,
+    duration_seconds double precision NOT NULL,
+    status int NOT NULL,
+    outcome text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_time_idx ON _ps_catalog.audit_log (time);
+GRANT SELECT ON TABLE _ps_catalog.audit_log TO prom_reader;
+GRANT SELECT, INSERT ON TABLE _ps_catalog.audit_log TO prom_writer;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS _ps_catalog.query_log (
+    time timestamptz NOT NULL,
+    endpoint text NOT NULL,
+    expr text NOT NULL DEFAULT '',
+    matchers text[] NOT NULL DEFAULT '{}',
+    start_time timestamptz,
+    end_time timestamptz,
+    step_seconds double precision NOT NULL DEFAULT 0,
+    tenant text NOT NULL DEFAULT '',
+    status int NOT NULL,
+    duration_seconds double precision NOT NULL,
+    sql_generation_seconds double precision NOT NULL,
+    db_execution_seconds double precision NOT NULL,
+    rows bigint NOT NULL,
+    samples bigint NOT NULL,
+    peak_memory_bytes bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS query_log_time_idx ON _ps_catalog.query_log (time);
+GRANT SELECT ON TABLE _ps_catalog.query_log TO prom_reader;
+GRANT SELECT, INSERT, DELETE ON TABLE _ps_catalog.query_log TO prom_writer;
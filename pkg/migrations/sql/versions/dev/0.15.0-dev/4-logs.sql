@@ -0,0 +1,67 @@
+CREATE SCHEMA IF NOT EXISTS _ps_log;
+GRANT USAGE ON SCHEMA _ps_log TO prom_reader;
+
+INSERT INTO public.prom_installation_info(key, value) VALUES
+    ('logs schema private', '_ps_log')
+ON CONFLICT (key) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS _ps_log.log
+(
+    time timestamptz NOT NULL,
+    observed_time timestamptz,
+    trace_id uuid CHECK (trace_id != '00000000-0000-0000-0000-000000000000'),
+    span_id bigint CHECK (span_id != 0),
+    flags integer NOT NULL DEFAULT 0,
+    severity_number smallint NOT NULL DEFAULT 0,
+    severity_text text NOT NULL DEFAULT '',
+    body jsonb,
+    attributes jsonb NOT NULL DEFAULT '{}'::jsonb,
+    dropped_attributes_count integer NOT NULL DEFAULT 0,
+    resource_attributes jsonb NOT NULL DEFAULT '{}'::jsonb,
+    scope_name text NOT NULL DEFAULT '',
+    scope_version text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS log_time_idx ON _ps_log.log USING BTREE (time DESC);
+CREATE INDEX IF NOT EXISTS log_trace_id_idx ON _ps_log.log USING BTREE (trace_id, span_id) WHERE trace_id IS NOT NULL; -- correlation with the spans
+CREATE INDEX IF NOT EXISTS log_resource_attributes_idx ON _ps_log.log USING GIN (resource_attributes jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS log_attributes_idx ON _ps_log.log USING GIN (attributes jsonb_path_ops);
+GRANT SELECT ON TABLE _ps_log.log TO prom_reader;
+GRANT SELECT, INSERT, DELETE ON TABLE _ps_log.log TO prom_writer;
+
+/*
+    If timescaledb 2 is installed, turn the log table into a hypertable. If
+    the community edition is installed, compress its chunks after an hour and
+    drop them after 30 days. Logs are not distributed in a multinode cluster.
+*/
+DO $block$
+DECLARE
+    _timescaledb_version_text text;
+    _timescaledb_major_version int;
+BEGIN
+    SELECT extversion INTO _timescaledb_version_text
+    FROM pg_catalog.pg_extension
+    WHERE extname='timescaledb';
+
+    IF _timescaledb_version_text IS NULL THEN
+        RETURN;
+    END IF;
+    _timescaledb_major_version = split_part(_timescaledb_version_text, '.', 1)::INT;
+    IF _timescaledb_major_version < 2 THEN
+        RETURN;
+    END IF;
+
+    PERFORM public.create_hypertable(
+        '_ps_log.log'::regclass,
+        'time'::name,
+        chunk_time_interval=>'07:58:41.513477'::interval,
+        create_default_indexes=>false,
+        if_not_exists=>true
+    );
+
+    IF current_setting('timescaledb.license') != 'apache' THEN
+        ALTER TABLE _ps_log.log SET (timescaledb.compress, timescaledb.compress_orderby='time DESC');
+        PERFORM public.add_compression_policy('_ps_log.log', INTERVAL '1 hour', if_not_exists=>true);
+        PERFORM public.add_retention_policy('_ps_log.log', INTERVAL '30 days', if_not_exists=>true);
+    END IF;
+END
+$block$;
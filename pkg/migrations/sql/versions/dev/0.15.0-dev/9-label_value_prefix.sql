@@ -0,0 +1,4 @@
+-- The label values are searched by prefix to autocomplete them. The prefix
+-- search compares the values byte by byte, which the index answers whatever
+-- the collation of the database.
+CREATE INDEX IF NOT EXISTS label_key_value_pattern ON _prom_catalog.label (key, value text_pattern_ops);
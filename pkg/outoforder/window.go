@@ -0,0 +1,276 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package outoforder limits how late the samples written to a metric can be.
+// The samples older than the latest sample of their metric are out of order:
+// they are inserted if they are within the out-of-order window of the metric,
+// and dropped as too late otherwise. Writers delivering data minutes late,
+// e.g. IoT devices, keep their samples while clients replaying old data are
+// kept from rewriting history.
+package outoforder
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// Policies resolving the samples written twice for the same series and time.
+const (
+	FirstWriteWins = "first-write-wins"
+	LastWriteWins  = "last-write-wins"
+)
+
+var (
+	acceptedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "out_of_order",
+			Name:      "accepted_samples_total",
+			Help:      "Total number of written samples older than the latest sample of their metric and inserted since they are within the out-of-order window.",
+		},
+	)
+	tooLateSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "out_of_order",
+			Name:      "too_late_samples_total",
+			Help:      "Total number of written samples dropped since they are older than the out-of-order window of their metric.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(acceptedSamples, tooLateSamples)
+}
+
+// Config holds the out-of-order flags.
+type Config struct {
+	Window         time.Duration
+	ConfigFile     string
+	ConflictPolicy string
+}
+
+// ParseFlags registers the out-of-order flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.DurationVar(&cfg.Window, "metrics.out-of-order.window", 0, "How much older than the latest sample of their metric the written samples can be. "+
+		"Older samples are dropped and counted as too late. Samples of any age are inserted if 0.")
+	fs.StringVar(&cfg.ConfigFile, "metrics.out-of-order.config-file", "", "Path to a YAML file with the out-of-order windows of the metrics matching a pattern, "+
+		"taking precedence over metrics.out-of-order.window. The file is reloaded on SIGHUP or a call to the /-/reload endpoint.")
+	fs.StringVar(&cfg.ConflictPolicy, "metrics.out-of-order.conflict-policy", FirstWriteWins, "Value kept when a sample is written again for the same series and time: "+
+		FirstWriteWins+" keeps the stored value, "+LastWriteWins+" replaces it.")
+	return cfg
+}
+
+// Validate checks the out-of-order flags and that the out-of-order file, if
+// any, can be loaded.
+func Validate(cfg *Config) error {
+	if cfg.Window < 0 {
+		return fmt.Errorf("metrics.out-of-order.window must not be negative")
+	}
+	if cfg.ConflictPolicy != FirstWriteWins && cfg.ConflictPolicy != LastWriteWins {
+		return fmt.Errorf("invalid metrics.out-of-order.conflict-policy %q, must be %s or %s", cfg.ConflictPolicy, FirstWriteWins, LastWriteWins)
+	}
+	if cfg.ConfigFile == "" {
+		return nil
+	}
+	_, err := loadConfigFile(cfg.ConfigFile)
+	return err
+}
+
+// configFile is the format of the out-of-order file, e.g.
+//
+//	windows:
+//	  - metrics: iot_.*
+//	    window: 30m
+//	  - metrics: node_.*
+//	    window: 0s
+//
+// The first pattern matching the whole metric name selects its window, and
+// a window of 0 inserts the samples of any age. The other metrics have the
+// window of the metrics.out-of-order.window flag.
+type configFile struct {
+	Windows []windowConfig `yaml:"windows"`
+}
+
+type windowConfig struct {
+	Metrics string         `yaml:"metrics"`
+	Window  model.Duration `yaml:"window"`
+}
+
+type rule struct {
+	metrics *regexp.Regexp
+	window  time.Duration
+}
+
+// rules are the parsed windows of an out-of-order file.
+type rules struct {
+	windows []rule
+	// resolved caches the window of each metric, as matching the patterns
+	// for every written series is expensive.
+	resolved sync.Map
+}
+
+func loadConfigFile(path string) (*rules, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading out-of-order file: %w", err)
+	}
+	return parseRules(contents)
+}
+
+func parseRules(contents []byte) (*rules, error) {
+	var f configFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return nil, fmt.Errorf("parsing out-of-order file: %w", err)
+	}
+	r := &rules{}
+	for i, c := range f.Windows {
+		if c.Metrics == "" {
+			return nil, fmt.Errorf("out-of-order window at position %d has no metrics pattern", i+1)
+		}
+		re, err := regexp.Compile("^(?:" + c.Metrics + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics pattern %q: %w", c.Metrics, err)
+		}
+		r.windows = append(r.windows, rule{metrics: re, window: time.Duration(c.Window)})
+	}
+	return r, nil
+}
+
+func (r *rules) window(metric string, fallback time.Duration) time.Duration {
+	if w, ok := r.resolved.Load(metric); ok {
+		return w.(time.Duration)
+	}
+	w := fallback
+	for _, rule := range r.windows {
+		if rule.metrics.MatchString(metric) {
+			w = rule.window
+			break
+		}
+	}
+	r.resolved.Store(metric, w)
+	return w
+}
+
+// Window drops the written samples that are older than the out-of-order
+// window of their metric. A nil Window keeps all the samples.
+type Window struct {
+	path   string
+	global time.Duration
+
+	mu    sync.RWMutex
+	rules *rules
+
+	// latest holds the timestamp of the latest sample written to each
+	// metric through this connector, as an *atomic.Int64.
+	latest sync.Map
+}
+
+// NewWindow returns a Window with the out-of-order windows of cfg, or nil if
+// no window is configured.
+func NewWindow(cfg *Config) (*Window, error) {
+	if cfg.Window == 0 && cfg.ConfigFile == "" {
+		return nil, nil
+	}
+	w := &Window{path: cfg.ConfigFile, global: cfg.Window, rules: &rules{}}
+	if cfg.ConfigFile == "" {
+		return w, nil
+	}
+	r, err := loadConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	w.rules = r
+	return w, nil
+}
+
+// Reload reads the out-of-order file again and applies the new windows to
+// the following writes. The latest samples of the metrics are kept.
+func (w *Window) Reload() error {
+	if w == nil || w.path == "" {
+		return nil
+	}
+	r, err := loadConfigFile(w.path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.rules = r
+	w.mu.Unlock()
+	log.Info("msg", "Out-of-order windows reloaded", "file", w.path, "windows", len(r.windows))
+	return nil
+}
+
+// Filter returns the samples of a series of the metric that are within the
+// out-of-order window of the metric, reusing the samples slice. The latest
+// sample of the metric is updated with the returned samples.
+func (w *Window) Filter(metric string, samples []prompb.Sample) []prompb.Sample {
+	if w == nil || len(samples) == 0 {
+		return samples
+	}
+	w.mu.RLock()
+	r := w.rules
+	w.mu.RUnlock()
+	window := r.window(metric, w.global)
+	if window == 0 {
+		return samples
+	}
+
+	l, ok := w.latest.Load(metric)
+	if !ok {
+		// The first samples of a metric are all accepted.
+		l, _ = w.latest.LoadOrStore(metric, atomic.NewInt64(math.MinInt64))
+	}
+	latest := l.(*atomic.Int64)
+	current := latest.Load()
+	cutoff := int64(math.MinInt64)
+	if current != math.MinInt64 {
+		cutoff = current - window.Milliseconds()
+	}
+
+	var (
+		kept    = samples[:0]
+		max     = current
+		late    int
+		tooLate int
+	)
+	for _, s := range samples {
+		switch {
+		case s.Timestamp < cutoff:
+			tooLate++
+			continue
+		case s.Timestamp < current:
+			late++
+		case s.Timestamp > max:
+			max = s.Timestamp
+		}
+		kept = append(kept, s)
+	}
+	for max > current && !latest.CAS(current, max) {
+		current = latest.Load()
+	}
+
+	if late > 0 {
+		acceptedSamples.Add(float64(late))
+	}
+	if tooLate > 0 {
+		tooLateSamples.Add(float64(tooLate))
+		log.WarnRateLimited("msg", "Dropping samples older than the out-of-order window of their metric", "metric", metric, "window", window)
+	}
+	return kept
+}
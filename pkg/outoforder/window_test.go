@@ -0,0 +1,99 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package outoforder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const testConfig = `
+windows:
+  - metrics: iot_.*
+    window: 10m
+  - metrics: node_.*
+    window: 0s
+`
+
+func samples(minutes ...int64) []prompb.Sample {
+	s := make([]prompb.Sample, len(minutes))
+	for i, m := range minutes {
+		s[i] = prompb.Sample{Timestamp: m * time.Minute.Milliseconds(), Value: float64(m)}
+	}
+	return s
+}
+
+func TestFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "windows.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0600))
+	w, err := NewWindow(&Config{Window: time.Minute, ConfigFile: path})
+	require.NoError(t, err)
+
+	accepted, tooLate := testutil.ToFloat64(acceptedSamples), testutil.ToFloat64(tooLateSamples)
+	// The first samples of a metric are all accepted.
+	require.Equal(t, samples(100, 60), w.Filter("iot_temperature", samples(100, 60)))
+	require.Equal(t, samples(95, 120), w.Filter("iot_temperature", samples(80, 95, 120)))
+	require.Equal(t, samples(111), w.Filter("iot_temperature", samples(111, 109)))
+	require.Equal(t, accepted+2, testutil.ToFloat64(acceptedSamples))
+	require.Equal(t, tooLate+2, testutil.ToFloat64(tooLateSamples))
+
+	// The global window applies to the other metrics.
+	require.Equal(t, samples(100), w.Filter("up", samples(100)))
+	require.Empty(t, w.Filter("up", samples(98)))
+	// A window of 0 keeps the samples of any age.
+	require.Equal(t, samples(100, 1), w.Filter("node_load1", samples(100, 1)))
+
+	var nilWindow *Window
+	require.Equal(t, samples(1), nilWindow.Filter("up", samples(1)))
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "windows.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0600))
+	w, err := NewWindow(&Config{ConfigFile: path})
+	require.NoError(t, err)
+	require.Equal(t, samples(100), w.Filter("iot_temperature", samples(100)))
+	require.Empty(t, w.Filter("iot_temperature", samples(80)))
+
+	// The latest samples are kept with the new windows.
+	require.NoError(t, os.WriteFile(path, []byte("windows: [{metrics: iot_.*, window: 30m}]"), 0600))
+	require.NoError(t, w.Reload())
+	require.Equal(t, samples(80), w.Filter("iot_temperature", samples(80)))
+	require.Empty(t, w.Filter("iot_temperature", samples(60)))
+
+	// An invalid file keeps the current windows.
+	require.NoError(t, os.WriteFile(path, []byte("windows: [{window: 1m}]"), 0600))
+	require.Error(t, w.Reload())
+	require.Equal(t, samples(80), w.Filter("iot_temperature", samples(80)))
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{ConflictPolicy: FirstWriteWins}))
+	require.NoError(t, Validate(&Config{Window: time.Minute, ConflictPolicy: LastWriteWins}))
+	require.Error(t, Validate(&Config{Window: -time.Minute, ConflictPolicy: FirstWriteWins}))
+	require.Error(t, Validate(&Config{ConflictPolicy: "newest"}))
+	require.Error(t, Validate(&Config{ConflictPolicy: FirstWriteWins, ConfigFile: "missing.yml"}))
+
+	for _, invalid := range []string{
+		"windows: [{window: 1m}]",
+		"windows: [{metrics: \"(\", window: 1m}]",
+		"windows: [{metrics: up, window: often}]",
+		"unknown: true",
+	} {
+		_, err := parseRules([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+
+	w, err := NewWindow(&Config{ConflictPolicy: LastWriteWins})
+	require.NoError(t, err)
+	require.Nil(t, w)
+}
@@ -12,13 +12,18 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 
 	"github.com/timescale/promscale/pkg/ha"
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/logs"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/cache/shared"
 	"github.com/timescale/promscale/pkg/pgmodel/health"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/memory"
 	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/querier"
 	"github.com/timescale/promscale/pkg/pgxconn"
@@ -45,19 +50,29 @@ type LockFunc = func(ctx context.Context, conn *pgx.Conn) error
 // Client sends Prometheus samples to TimescaleDB
 type Client struct {
 	readerPool   pgxconn.PgxConn
+	queryConn    pgxconn.PgxConn
+	replicaConn  *pgxconn.ReplicaConn
 	writerPool   pgxconn.PgxConn
 	maintPool    pgxconn.PgxConn
+	metadataConn pgxconn.PgxConn
 	ingestor     ingestor.DBInserter
 	querier      querier.Querier
+	labelsReader lreader.LabelsReader
 	promqlEngine *promql.Engine
 	healthCheck  health.HealthCheckerFn
+	health       *health.Engine
 	queryable    promql.Queryable
 	metricCache  cache.MetricCache
 	labelsCache  cache.LabelsCache
 	seriesCache  cache.SeriesCache
+	cacheSizer   *cache.AdaptiveSizer
+	ingestBudget *memory.Budget
+	planCache    *querier.PlanCache
+	sharedCache  *shared.Cache
 	closePool    bool
 	sigClose     chan struct{}
 	haService    *ha.Service
+	shards       []shardPools
 }
 
 // NewClient creates a new PostgreSQL client
@@ -71,6 +86,7 @@ func NewClient(r prometheus.Registerer, cfg *Config, mt tenancy.Authorizer, sche
 		numCopiers     int
 		writerPool     *pgxpool.Pool
 		maintPool      *pgxpool.Pool
+		metadataPool   *pgxpool.Pool
 	)
 
 	dbMaxConns, err = cfg.dbMaxConns()
@@ -83,10 +99,11 @@ func NewClient(r prometheus.Registerer, cfg *Config, mt tenancy.Authorizer, sche
 		return nil, err
 	}
 
-	maintPgConfig, err := cfg.getPgConfig(maintPoolSize)
+	maintPgConfig, err := cfg.getPgConfig(cfg.MaintPoolMinSize, maintPoolSize)
 	if err != nil {
 		return nil, fmt.Errorf("get maint pg-config: %w", err)
 	}
+	maintPgConfig.AfterConnect = withSessionParams(cfg.sessionParams("maint"), nil)
 	maintPool, err = pgxpool.ConnectConfig(context.Background(), maintPgConfig)
 	if err != nil {
 		return nil, fmt.Errorf("err creating maintenance connection pool: %w", err)
@@ -103,22 +120,35 @@ func NewClient(r prometheus.Registerer, cfg *Config, mt tenancy.Authorizer, sche
 			numCopiers /= 2
 		}
 
-		writerPgConfig, err := cfg.getPgConfig(writerPoolSize)
+		writerPgConfig, err := cfg.getPgConfig(cfg.WriterPoolMinSize, writerPoolSize)
 		if err != nil {
 			return nil, fmt.Errorf("get writer pg-config: %w", err)
 		}
-		SetWriterPoolAfterConnect(writerPgConfig, schemaLocker, cfg.WriterSynchronousCommit)
+		// Explicit session params are applied after synchronous_commit so they take precedence.
+		SetWriterPoolAfterConnect(writerPgConfig, withSessionParams(cfg.sessionParams("writer"), schemaLocker), cfg.WriterSynchronousCommit)
 		writerPool, err = pgxpool.ConnectConfig(context.Background(), writerPgConfig)
 		if err != nil {
 			return nil, fmt.Errorf("err creating writer connection pool: %w", err)
 		}
 	}
 
-	readerPgConfig, err := cfg.getPgConfig(readerPoolSize)
+	readerPgConfig, err := cfg.getPgConfig(cfg.ReaderPoolMinSize, readerPoolSize)
 	if err != nil {
 		return nil, fmt.Errorf("get reader pg-config: %w", err)
 	}
 
+	if cfg.MetadataPoolSize > 0 {
+		metadataPgConfig, err := cfg.getPgConfig(cfg.MetadataPoolMinSize, cfg.MetadataPoolSize)
+		if err != nil {
+			return nil, fmt.Errorf("get metadata pg-config: %w", err)
+		}
+		metadataPgConfig.AfterConnect = withSessionParams(cfg.sessionParams("metadata"), schemaLocker)
+		metadataPool, err = pgxpool.ConnectConfig(context.Background(), metadataPgConfig)
+		if err != nil {
+			return nil, fmt.Errorf("err creating metadata connection pool: %w", err)
+		}
+	}
+
 	statementCacheLog := "disabled"
 	if cfg.EnableStatementsCache {
 		statementCacheLog = "512" // Default pgx.
@@ -128,21 +158,44 @@ func NewClient(r prometheus.Registerer, cfg *Config, mt tenancy.Authorizer, sche
 		"writer-pool.size", writerPoolSize,
 		"reader-pool.size", readerPoolSize,
 		"maint-pool.size", maintPoolSize,
-		"min-pool.connections", MinPoolSize,
+		"metadata-pool.size", cfg.MetadataPoolSize,
 		"num-copiers", numCopiers,
 		"statement-cache", statementCacheLog)
 
-	readerPgConfig.AfterConnect = schemaLocker
+	readerPgConfig.AfterConnect = withSessionParams(cfg.sessionParams("reader"), schemaLocker)
 	readerPool, err := pgxpool.ConnectConfig(context.Background(), readerPgConfig)
 	if err != nil {
 		return nil, fmt.Errorf("err creating reader connection pool: %w", err)
 	}
-	client, err := NewClientWithPool(r, cfg, numCopiers, writerPool, readerPool, maintPool, mt, readOnly)
+	replicas, err := cfg.connectReplicas(readerPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := cfg.connectShards(readerPoolSize, writerPoolSize, schemaLocker, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	clientCfg := cfg
+	if len(shards) > 0 {
+		// The span metrics are generated by the sharded ingestor.
+		noSpanMetrics := *cfg
+		noSpanMetrics.SpanMetrics.Enabled = false
+		clientCfg = &noSpanMetrics
+	}
+	client, err := newClient(r, clientCfg, numCopiers, writerPool, readerPool, maintPool, metadataPool, replicas, mt, readOnly)
 	if err != nil {
+		closeShards(shards)
 		return client, err
 	}
 	client.closePool = true
-	return client, err
+	if len(shards) > 0 {
+		if err = client.initShards(r, cfg, shards, numCopiers, mt, readOnly); err != nil {
+			closeShards(shards)
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
 }
 
 func SetWriterPoolAfterConnect(writerPgConfig *pgxpool.Config, schemaLocker LockFunc, synchronousCommit bool) {
@@ -161,10 +214,17 @@ func SetWriterPoolAfterConnect(writerPgConfig *pgxpool.Config, schemaLocker Lock
 	}
 }
 
-func (cfg *Config) getPgConfig(poolSize int) (*pgxpool.Config, error) {
-	min := MinPoolSize
+func (cfg *Config) getPgConfig(minSize, poolSize int) (*pgxpool.Config, error) {
+	return cfg.getPgConfigFor(cfg.GetConnectionStr(), minSize, poolSize)
+}
+
+// getPgConfigFor returns the pool config of the database at connStr.
+func (cfg *Config) getPgConfigFor(connStr string, minSize, poolSize int) (*pgxpool.Config, error) {
+	min := minSize
 	max := poolSize
-	connStr := cfg.GetConnectionStr()
+	if min > max {
+		min = max
+	}
 
 	pgConfig, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
@@ -198,20 +258,28 @@ func getRedactedConnStr(s string) string {
 
 // NewClientWithPool creates a new PostgreSQL client with an existing connection pool.
 func NewClientWithPool(r prometheus.Registerer, cfg *Config, numCopiers int, writerPool, readerPool, maintPool *pgxpool.Pool, mt tenancy.Authorizer, readOnly bool) (*Client, error) {
+	return newClient(r, cfg, numCopiers, writerPool, readerPool, maintPool, nil, nil, mt, readOnly)
+}
+
+func newClient(r prometheus.Registerer, cfg *Config, numCopiers int, writerPool, readerPool, maintPool, metadataPool *pgxpool.Pool, replicas []pgxconn.Replica, mt tenancy.Authorizer, readOnly bool) (*Client, error) {
 	sigClose := make(chan struct{})
+	intern.Init(cfg.CacheConfig.InternedStringsSize)
 	metricsCache := cache.NewMetricCache(cfg.CacheConfig)
 	labelsCache := cache.NewLabelsCache(cfg.CacheConfig)
 	seriesCache := cache.NewSeriesCache(cfg.CacheConfig, sigClose)
-	c := ingestor.Cfg{
-		NumCopiers:              numCopiers,
-		IgnoreCompressedChunks:  cfg.IgnoreCompressedChunks,
-		MetricsAsyncAcks:        cfg.MetricsAsyncAcks,
-		TracesAsyncAcks:         cfg.TracesAsyncAcks,
-		InvertedLabelsCacheSize: cfg.CacheConfig.InvertedLabelsCacheSize,
-		TracesBatchTimeout:      cfg.TracesBatchTimeout,
-		TracesMaxBatchSize:      cfg.TracesMaxBatchSize,
-		TracesBatchWorkers:      cfg.TracesBatchWorkers,
+	cacheSizer := cache.NewAdaptiveSizer(cfg.CacheConfig)
+	cacheSizer.Manage("metric_name", metricsCache)
+	cacheSizer.Manage("label", labelsCache)
+	cacheSizer.Manage("series", seriesCache)
+	// The ingest memory budget is shared by the ingestors of all the shards.
+	ingestBudget := memory.New(cfg.IngestMemory)
+	ingestBudget.TrackCache("metric_name", metricsCache)
+	ingestBudget.TrackCache("label", labelsCache)
+	ingestBudget.TrackCache("series", seriesCache)
+	if ingestBudget != nil {
+		cacheSizer.LimitBy(ingestBudget.CacheAllowance)
 	}
+	c := ingestorCfg(cfg, numCopiers, cacheSizer, ingestBudget)
 
 	var (
 		writerConn pgxconn.PgxConn
@@ -219,20 +287,51 @@ func NewClientWithPool(r prometheus.Registerer, cfg *Config, numCopiers int, wri
 	)
 
 	readerConn := pgxconn.NewQueryLoggingPgxConn(readerPool)
+	// The metadata reads share the reader pool without a metadata pool.
+	metadataConn := readerConn
+	if metadataPool != nil {
+		metadataConn = pgxconn.NewPgxConn(metadataPool)
+	}
+
+	// Queries are served by the read replicas if there are any, everything
+	// else that reads keeps using the primary.
+	queryConn := readerConn
+	var replicaConn *pgxconn.ReplicaConn
+	if len(replicas) > 0 {
+		replicaConn = pgxconn.NewReplicaConn(readerConn, replicas, cfg.ReplicaHealthInterval)
+		queryConn = replicaConn
+	}
 
 	exemplarKeyPosCache := cache.NewExemplarLabelsPosCache(cfg.CacheConfig)
 
-	labelsReader := lreader.NewLabelsReader(readerConn, labelsCache, mt.ReadAuthorizer())
-	dbQuerier := querier.NewQuerier(readerConn, metricsCache, labelsReader, exemplarKeyPosCache, mt.ReadAuthorizer())
+	labelsReader := lreader.NewLabelsReader(queryConn, labelsCache, mt.ReadAuthorizer())
+	// The SQL of the selects only depends on their shape, the plans are
+	// shared by the queriers of all the shards.
+	planCache := querier.NewPlanCache(cfg.CacheConfig.QueryPlansCacheSize)
+	dbQuerier := querier.NewQuerier(queryConn, metricsCache, labelsReader, exemplarKeyPosCache, mt.ReadAuthorizer(), cfg.IndexAdvisor, planCache)
 	queryable := query.NewQueryable(dbQuerier, labelsReader)
 
 	dbIngestor := ingestor.DBInserter(ingestor.ReadOnlyIngestor{})
+	var sharedCache *shared.Cache
 	if !readOnly {
 		var err error
+		// The shared cache only serves the ingest.
+		sharedCache, err = shared.New(cfg.SharedCacheConfig)
+		if err != nil {
+			if replicaConn != nil {
+				replicaConn.Close()
+			}
+			return nil, err
+		}
+		c.SharedCache = sharedCache
 		writerConn = pgxconn.NewPgxConn(writerPool)
 		dbIngestor, err = ingestor.NewPgxIngestor(writerConn, metricsCache, seriesCache, exemplarKeyPosCache, &c)
 		if err != nil {
 			log.Error("msg", "err starting the ingestor", "err", err)
+			if replicaConn != nil {
+				replicaConn.Close()
+			}
+			sharedCache.Close()
 			return nil, err
 		}
 	}
@@ -240,32 +339,108 @@ func NewClientWithPool(r prometheus.Registerer, cfg *Config, numCopiers int, wri
 		maintConn = pgxconn.NewPgxConn(maintPool)
 	}
 
+	// The checks read the state of the primary, as the queries of the
+	// replicas would fail over to it.
+	healthEngine := health.NewEngine(cfg.Health.Timeout,
+		health.DatabaseCheck(metadataConn),
+		health.ExtensionCheck(metadataConn),
+		health.MaintenanceCheck(metadataConn, cfg.Health.MaintenanceMaxAge),
+		health.CacheCheck([]health.NamedCache{
+			{Name: "metric", Cache: metricsCache},
+			{Name: "label", Cache: labelsCache},
+			{Name: "series", Cache: seriesCache},
+		}, cfg.Health.CacheSaturation),
+	)
+	if len(replicas) > 0 {
+		healthEngine.Register(health.ReplicaLagCheck(replicas, cfg.Health.ReplicaMaxLag))
+	}
+
 	client := &Client{
-		readerPool:  readerConn,
-		writerPool:  writerConn,
-		maintPool:   maintConn,
-		ingestor:    dbIngestor,
-		querier:     dbQuerier,
-		healthCheck: health.NewHealthChecker(readerConn),
-		queryable:   queryable,
-		metricCache: metricsCache,
-		labelsCache: labelsCache,
-		seriesCache: seriesCache,
-		sigClose:    sigClose,
-	}
-
-	initMetrics(r, writerPool, readerPool, maintPool)
+		readerPool:   readerConn,
+		queryConn:    queryConn,
+		replicaConn:  replicaConn,
+		writerPool:   writerConn,
+		maintPool:    maintConn,
+		metadataConn: metadataConn,
+		ingestor:     dbIngestor,
+		querier:      dbQuerier,
+		labelsReader: labelsReader,
+		healthCheck:  health.NewHealthChecker(metadataConn),
+		health:       healthEngine,
+		queryable:    queryable,
+		metricCache:  metricsCache,
+		labelsCache:  labelsCache,
+		seriesCache:  seriesCache,
+		cacheSizer:   cacheSizer,
+		ingestBudget: ingestBudget,
+		planCache:    planCache,
+		sharedCache:  sharedCache,
+		sigClose:     sigClose,
+	}
+	go cacheSizer.Run(sigClose)
+	go ingestBudget.Run(sigClose)
+	if cfg.Health.Interval > 0 {
+		go healthEngine.RunEvery(cfg.Health.Interval, sigClose)
+	}
+
+	initMetrics(r, map[string]*pgxpool.Pool{"writer": writerPool, "reader": readerPool, "maint": maintPool, "metadata": metadataPool})
 	return client, nil
 }
 
+// ingestorCfg returns the ingestor config of the client config.
+func ingestorCfg(cfg *Config, numCopiers int, cacheSizer *cache.AdaptiveSizer, ingestBudget *memory.Budget) ingestor.Cfg {
+	return ingestor.Cfg{
+		NumCopiers:              numCopiers,
+		IgnoreCompressedChunks:  cfg.IgnoreCompressedChunks,
+		MetricsAsyncAcks:        cfg.MetricsAsyncAcks,
+		TracesAsyncAcks:         cfg.TracesAsyncAcks,
+		InvertedLabelsCacheSize: cfg.CacheConfig.InvertedLabelsCacheSize,
+		TracesBatchTimeout:      cfg.TracesBatchTimeout,
+		TracesMaxBatchSize:      cfg.TracesMaxBatchSize,
+		TracesBatchWorkers:      cfg.TracesBatchWorkers,
+		TenantLimiter:           cfg.TenantLimiter,
+		Relabeler:               cfg.Relabeler,
+		ExternalLabels:          cfg.ExternalLabels,
+		MetricFilter:            cfg.MetricFilter,
+		Dedup:                   cfg.Dedup,
+		OutOfOrder:              cfg.OutOfOrder,
+		LastWriteWins:           cfg.LastWriteWins,
+		ValueEncodings:          cfg.ValueEncodings,
+		TenantPartitioning:      cfg.TenantPartitioning,
+		TailSampling:            cfg.TailSampling,
+		SpanMetrics:             cfg.SpanMetrics,
+		TraceForwarder:          cfg.TraceForwarder,
+		CacheSizer:              cacheSizer,
+		WarmUpSeries:            cfg.CacheConfig.WarmUpSeries,
+		WarmUpByActivity:        cfg.CacheConfig.WarmUpByActivity,
+		WarmUpTimeout:           cfg.CacheConfig.WarmUpTimeout,
+		Backpressure:            cfg.Backpressure,
+		MemoryBudget:            ingestBudget,
+	}
+}
+
 func (c *Client) ReadOnlyConnection() pgxconn.PgxConn {
 	return c.readerPool
 }
 
+// QueryConnection returns the connection serving the queries, which is
+// balanced across the read replicas if there are any. It must only be used
+// for reads that can tolerate the replication lag.
+func (c *Client) QueryConnection() pgxconn.PgxConn {
+	return c.queryConn
+}
+
 func (c *Client) MaintenanceConnection() pgxconn.PgxConn {
 	return c.maintPool
 }
 
+// MetadataConnection returns the connection serving the HA leases, the
+// metric metadata and the status APIs, so that heavy queries do not take
+// the connections they need.
+func (c *Client) MetadataConnection() pgxconn.PgxConn {
+	return c.metadataConn
+}
+
 func (c *Client) InitPromQLEngine(cfg *query.Config) error {
 	engine, err := query.NewEngine(log.GetLogger(), cfg.MaxQueryTimeout, cfg.LookBackDelta, cfg.SubQueryStepInterval, cfg.MaxSamples, cfg.EnabledFeatureMap)
 	if err != nil {
@@ -285,6 +460,7 @@ func (c *Client) Close() {
 	if c.ingestor != nil {
 		c.ingestor.Close()
 	}
+	c.sharedCache.Close()
 	close(c.sigClose)
 	if c.closePool {
 		if c.maintPool != nil {
@@ -293,9 +469,16 @@ func (c *Client) Close() {
 		if c.writerPool != nil {
 			c.writerPool.Close()
 		}
+		if c.replicaConn != nil {
+			c.replicaConn.Close()
+		}
+		if c.metadataConn != nil && c.metadataConn != c.readerPool {
+			c.metadataConn.Close()
+		}
 		if c.readerPool != nil {
 			c.readerPool.Close()
 		}
+		closeShards(c.shards)
 	}
 	if c.haService != nil {
 		c.haService.Close()
@@ -316,6 +499,14 @@ func (c *Client) IngestTraces(ctx context.Context, tr ptrace.Traces) error {
 	return c.ingestor.IngestTraces(ctx, tr)
 }
 
+// IngestLogs writes the log records into the DB.
+func (c *Client) IngestLogs(ctx context.Context, l plog.Logs) error {
+	if c.writerPool == nil {
+		return fmt.Errorf("cannot write logs to a read-only connector")
+	}
+	return logs.NewStore(c.writerPool).Insert(ctx, l)
+}
+
 // Read returns the promQL query results
 func (c *Client) Read(ctx context.Context, req *prompb.ReadRequest) (*prompb.ReadResponse, error) {
 	if req == nil {
@@ -341,6 +532,30 @@ func (c *Client) Read(ctx context.Context, req *prompb.ReadRequest) (*prompb.Rea
 	return &resp, nil
 }
 
+// ReadChunks streams the results of the read request as XOR encoded chunks,
+// one query at a time.
+func (c *Client) ReadChunks(ctx context.Context, req *prompb.ReadRequest, streamFn func(queryIndex int, ss querier.ChunkSeriesSet) error) error {
+	if req == nil {
+		return nil
+	}
+
+	qr := c.querier.RemoteReadQuerier(ctx)
+
+	for i, q := range req.Queries {
+		ss, err := qr.QueryChunks(q)
+		if err != nil {
+			return err
+		}
+		err = streamFn(i, ss)
+		ss.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (c *Client) NumCachedMetricNames() int {
 	return c.metricCache.Len()
 }
@@ -357,11 +572,81 @@ func (c *Client) LabelsCacheCapacity() int {
 	return c.labelsCache.Cap()
 }
 
+func (c *Client) NumCachedSeries() int {
+	return c.seriesCache.Len()
+}
+
+func (c *Client) SeriesCacheCapacity() int {
+	return c.seriesCache.Cap()
+}
+
+// ExpandCaches grows the metric, label, series and query plan caches to the
+// sizes in cfg.
+// Caches are never shrunk while in use, smaller sizes only apply after a restart.
+// With adaptive sizing, only the memory budget of the caches is changed.
+func (c *Client) ExpandCaches(cfg cache.Config) {
+	// The query plans are not sized by the AdaptiveSizer.
+	if c.planCache != nil && int(cfg.QueryPlansCacheSize) > c.planCache.Cap() {
+		log.Info("msg", "Expanding cache", "cache", "query_plans", "capacity", c.planCache.Cap(), "size", cfg.QueryPlansCacheSize)
+		c.planCache.ExpandTo(int(cfg.QueryPlansCacheSize))
+	}
+	if c.cacheSizer != nil {
+		// The caches are sized by the AdaptiveSizer, only its budget is applied.
+		c.cacheSizer.SetBudget(cfg.MemoryBudgetBytes)
+		return
+	}
+	type expander interface {
+		Cap() int
+		ExpandTo(newMax int)
+	}
+	expand := func(name string, e interface{}, size uint64) {
+		ex, ok := e.(expander)
+		if !ok || int(size) == ex.Cap() {
+			return
+		}
+		if int(size) < ex.Cap() {
+			log.Warn("msg", "Cannot shrink a cache while running, restart Promscale to apply the new size", "cache", name, "capacity", ex.Cap(), "size", size)
+			return
+		}
+		log.Info("msg", "Expanding cache", "cache", name, "capacity", ex.Cap(), "size", size)
+		ex.ExpandTo(int(size))
+	}
+	expand("metric", c.metricCache, cfg.MetricsCacheSize)
+	expand("label", c.labelsCache, cfg.LabelsCacheSize)
+	// The series cache grows on its own up to its maximum size in bytes,
+	// so it may already be larger than the configured initial size.
+	if sc, ok := c.seriesCache.(*cache.SeriesCacheImpl); ok {
+		sc.SetMaxSizeBytes(cfg.SeriesCacheMemoryMaxBytes)
+		if int(cfg.SeriesCacheInitialSize) > sc.Cap() {
+			sc.ExpandTo(int(cfg.SeriesCacheInitialSize))
+		}
+	}
+}
+
 // HealthCheck checks that the client is properly connected
 func (c *Client) HealthCheck() error {
 	return c.healthCheck()
 }
 
+// HealthReport runs the health checks of the client: the database, the
+// versions of the extensions, the maintenance jobs, the saturation of the
+// caches and the lag of the read replicas.
+func (c *Client) HealthReport(ctx context.Context) health.Report {
+	return c.health.Run(ctx)
+}
+
+// Ready checks that the client is connected and that the series cache
+// warm-up, if any, has finished.
+func (c *Client) Ready() error {
+	if err := c.healthCheck(); err != nil {
+		return err
+	}
+	if ing, ok := c.ingestor.(interface{ WarmedUp() bool }); ok && !ing.WarmedUp() {
+		return fmt.Errorf("series cache warm-up in progress")
+	}
+	return nil
+}
+
 // Queryable returns the Prometheus promql.Queryable interface that's running
 // with the same underlying Querier as the Client.
 func (c *Client) Queryable() promql.Queryable {
@@ -52,6 +52,10 @@ func (q mockRemoteReadQuerier) Query(_ *prompb.Query) ([]*prompb.TimeSeries, err
 	return q.tts, q.err
 }
 
+func (q mockRemoteReadQuerier) QueryChunks(_ *prompb.Query) (querier.ChunkSeriesSet, error) {
+	return nil, q.err
+}
+
 func (q *mockQuerier) ExemplarsQuerier(_ context.Context) querier.ExemplarQuerier {
 	return nil
 }
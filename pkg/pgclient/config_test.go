@@ -315,11 +315,12 @@ func TestConfig_GetConnectionStr(t *testing.T) {
 
 func TestConfig_GetPoolSizes(t *testing.T) {
 	type config struct {
-		WriterPoolSize int
-		ReaderPoolSize int
-		MaintPoolSize  int
-		MaxConnections int
-		UsesHA         bool
+		WriterPoolSize   int
+		ReaderPoolSize   int
+		MaintPoolSize    int
+		MetadataPoolSize int
+		MaxConnections   int
+		UsesHA           bool
 	}
 	type args struct {
 		readOnly bool
@@ -946,15 +947,64 @@ func TestConfig_GetPoolSizes(t *testing.T) {
 			wantMaintPoolSize:  2,
 			wantErr:            false,
 		},
+		{
+			name: "defaults with metadata pool",
+			config: config{
+				WriterPoolSize:   -1,
+				ReaderPoolSize:   -1,
+				MaintPoolSize:    -1,
+				MetadataPoolSize: 5,
+				MaxConnections:   -1,
+			},
+			args: args{
+				readOnly: false,
+				maxConns: 100,
+			},
+			wantReaderPoolSize: 20,
+			wantWriterPoolSize: 50,
+			wantMaintPoolSize:  5,
+			wantErr:            false,
+		},
+		{
+			name: "metadata pool too small",
+			config: config{
+				WriterPoolSize:   -1,
+				ReaderPoolSize:   -1,
+				MaintPoolSize:    -1,
+				MetadataPoolSize: 1,
+				MaxConnections:   -1,
+			},
+			args: args{
+				readOnly: false,
+				maxConns: 100,
+			},
+			wantErr: true,
+		},
+		{
+			name: "metadata pool too large",
+			config: config{
+				WriterPoolSize:   -1,
+				ReaderPoolSize:   20,
+				MaintPoolSize:    -1,
+				MetadataPoolSize: 10,
+				MaxConnections:   -1,
+			},
+			args: args{
+				readOnly: false,
+				maxConns: 100,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
-				WriterPoolSize: tt.config.WriterPoolSize,
-				ReaderPoolSize: tt.config.ReaderPoolSize,
-				MaintPoolSize:  tt.config.MaintPoolSize,
-				UsesHA:         tt.config.UsesHA,
-				MaxConnections: tt.config.MaxConnections,
+				WriterPoolSize:   tt.config.WriterPoolSize,
+				ReaderPoolSize:   tt.config.ReaderPoolSize,
+				MaintPoolSize:    tt.config.MaintPoolSize,
+				MetadataPoolSize: tt.config.MetadataPoolSize,
+				UsesHA:           tt.config.UsesHA,
+				MaxConnections:   tt.config.MaxConnections,
 			}
 			gotReaderPoolSize, gotWriterPoolSize, gotMaintPoolSize, err := cfg.GetPoolSizes(tt.args.readOnly, tt.args.maxConns)
 			if (err != nil) != tt.wantErr {
@@ -973,3 +1023,38 @@ func TestConfig_GetPoolSizes(t *testing.T) {
 		})
 	}
 }
+
+func TestPoolSettings(t *testing.T) {
+	cfg := &Config{
+		ReaderStmtTimeout:   90 * time.Second,
+		ReaderSessionParams: SessionParams{{Name: "work_mem", Value: "64MB"}},
+		MaintSessionParams:  SessionParams{{Name: "statement_timeout", Value: "0"}},
+		MaintStmtTimeout:    time.Minute,
+	}
+	if err := cfg.validateSessionParams(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader := cfg.sessionParams("reader")
+	if got := reader.String(); got != "statement_timeout=90000,work_mem=64MB" {
+		t.Errorf("unexpected reader session params %q", got)
+	}
+	// An explicit statement_timeout is applied after the pool timeout.
+	maint := cfg.sessionParams("maint")
+	if got := maint.String(); got != "statement_timeout=60000,statement_timeout=0" {
+		t.Errorf("unexpected maint session params %q", got)
+	}
+	if got := cfg.sessionParams("writer"); len(got) != 0 {
+		t.Errorf("unexpected writer session params %v", got)
+	}
+
+	for _, invalid := range []Config{
+		{MetadataPoolSize: -1},
+		{WriterPoolMinSize: -1},
+		{MetadataStmtTimeout: -time.Second},
+	} {
+		invalid := invalid
+		if err := invalid.validateSessionParams(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
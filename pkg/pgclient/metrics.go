@@ -11,89 +11,78 @@ import (
 	"github.com/timescale/promscale/pkg/util"
 )
 
-func initMetrics(r prometheus.Registerer, writerPool, readerPool, maintPool *pgxpool.Pool) {
-	writerAcquired := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "active_connections",
-			Help:        "Number of connections currently acquired from the pool.",
-			ConstLabels: map[string]string{"pool": "writer"},
-		}, func() float64 {
-			if writerPool == nil {
-				// readonly mode.
-				return 0
-			}
-			return float64(writerPool.Stat().AcquiredConns())
-		},
-	)
-	writerActive := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "total_connections",
-			Help:        "Number of connections currently active in the pool.",
-			ConstLabels: map[string]string{"pool": "writer"},
-		}, func() float64 {
-			if writerPool == nil {
-				// readonly mode.
-				return 0
+// poolMetrics are the statistics reported for each connection pool.
+var poolMetrics = []struct {
+	name    string
+	help    string
+	counter bool
+	value   func(s *pgxpool.Stat) float64
+}{
+	{
+		name:  "active_connections",
+		help:  "Number of connections currently acquired from the pool.",
+		value: func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) },
+	},
+	{
+		name:  "total_connections",
+		help:  "Number of connections currently active in the pool.",
+		value: func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) },
+	},
+	{
+		name:  "idle_connections",
+		help:  "Number of idle connections in the pool.",
+		value: func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) },
+	},
+	{
+		name:  "max_connections",
+		help:  "Maximum number of connections of the pool.",
+		value: func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) },
+	},
+	{
+		name:    "acquires_total",
+		help:    "Total number of connections acquired from the pool.",
+		counter: true,
+		value:   func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) },
+	},
+	{
+		name:    "empty_acquires_total",
+		help:    "Total number of connections acquired from the pool after waiting for a connection to be released or created.",
+		counter: true,
+		value:   func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) },
+	},
+	{
+		name:    "acquire_wait_seconds_total",
+		help:    "Total time spent acquiring connections from the pool.",
+		counter: true,
+		value:   func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() },
+	},
+}
+
+// initMetrics registers the statistics of the pools by name. A nil pool, like
+// the writer pool in read-only mode, reports zeros.
+func initMetrics(r prometheus.Registerer, pools map[string]*pgxpool.Pool) {
+	for name, pool := range pools {
+		pool := pool
+		for _, m := range poolMetrics {
+			value := m.value
+			f := func() float64 {
+				if pool == nil {
+					return 0
+				}
+				return value(pool.Stat())
 			}
-			return float64(writerPool.Stat().TotalConns())
-		},
-	)
-	readerAcquired := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "active_connections",
-			Help:        "Number of connections currently acquired from the pool.",
-			ConstLabels: map[string]string{"pool": "reader"},
-		}, func() float64 {
-			return float64(readerPool.Stat().AcquiredConns())
-		},
-	)
-	readerActive := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "total_connections",
-			Help:        "Number of connections currently active in the pool.",
-			ConstLabels: map[string]string{"pool": "reader"},
-		}, func() float64 {
-			return float64(readerPool.Stat().TotalConns())
-		},
-	)
-	maintAcquired := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "active_connections",
-			Help:        "Number of connections currently acquired from the pool.",
-			ConstLabels: map[string]string{"pool": "maint"},
-		}, func() float64 {
-			if maintPool == nil {
-				// readonly mode.
-				return 0
+			opts := prometheus.Opts{
+				Namespace:   util.PromNamespace,
+				Subsystem:   "sql_database",
+				Name:        m.name,
+				Help:        m.help,
+				ConstLabels: map[string]string{"pool": name},
 			}
-			return float64(maintPool.Stat().AcquiredConns())
-		},
-	)
-	maintActive := prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace:   util.PromNamespace,
-			Subsystem:   "sql_database",
-			Name:        "total_connections",
-			Help:        "Number of connections currently active in the pool.",
-			ConstLabels: map[string]string{"pool": "maint"},
-		}, func() float64 {
-			if maintPool == nil {
-				// readonly mode.
-				return 0
+			if m.counter {
+				r.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts(opts), f))
+			} else {
+				r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts), f))
 			}
-			return float64(maintPool.Stat().TotalConns())
-		},
-	)
-
-	r.MustRegister(writerAcquired, writerActive, readerAcquired, readerActive, maintAcquired, maintActive)
+		}
+	}
 }
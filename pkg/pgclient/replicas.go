@@ -0,0 +1,103 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// ReplicaURIs is a CLI flag type holding the connection strings of the read
+// replicas as a comma-separated list.
+type ReplicaURIs []string
+
+func (u *ReplicaURIs) String() string {
+	if u == nil {
+		return ""
+	}
+	return redactedURIs(*u)
+}
+
+// Set implements the flag interface to set value from the CLI
+func (u *ReplicaURIs) Set(val string) error {
+	uris, err := parseURIs(val, "read replica")
+	if err != nil {
+		return err
+	}
+	*u = uris
+	return nil
+}
+
+func redactedURIs(uris []string) string {
+	redacted := make([]string, len(uris))
+	for i, uri := range uris {
+		redacted[i] = getRedactedConnStr(uri)
+	}
+	return strings.Join(redacted, ",")
+}
+
+// parseURIs parses a comma-separated list of connection strings of the kind
+// of database.
+func parseURIs(val, kind string) ([]string, error) {
+	uris := []string{}
+	for _, uri := range strings.Split(val, ",") {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		if _, err := pgxpool.ParseConfig(uri); err != nil {
+			return nil, fmt.Errorf("invalid %s URI %q: %w", kind, getRedactedConnStr(uri), err)
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+// connectReplicas creates a pool of poolSize connections to each read
+// replica. The pools connect lazily so that an unavailable replica does not
+// prevent startup, the health checks route around it until it is back.
+func (cfg *Config) connectReplicas(poolSize int) ([]pgxconn.Replica, error) {
+	replicas := make([]pgxconn.Replica, 0, len(cfg.ReadReplicaURIs))
+	for _, uri := range cfg.ReadReplicaURIs {
+		pgConfig, err := pgxpool.ParseConfig(uri)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, fmt.Errorf("get read replica pg-config: %w", err)
+		}
+		pgConfig.MaxConns = int32(poolSize)
+		pgConfig.MinConns = int32(cfg.ReaderPoolMinSize)
+		if cfg.ReaderPoolMinSize > poolSize {
+			pgConfig.MinConns = int32(poolSize)
+		}
+		pgConfig.LazyConnect = true
+		if !cfg.EnableStatementsCache {
+			pgConfig.ConnConfig.PreferSimpleProtocol = true
+		}
+		// Replicas have no Promscale schema lock to take.
+		pgConfig.AfterConnect = withSessionParams(cfg.sessionParams("reader"), nil)
+
+		pool, err := pgxpool.ConnectConfig(context.Background(), pgConfig)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, fmt.Errorf("err creating read replica connection pool: %w", err)
+		}
+		name := fmt.Sprintf("%s:%d/%s", pgConfig.ConnConfig.Host, pgConfig.ConnConfig.Port, pgConfig.ConnConfig.Database)
+		log.Info("msg", "read replica", "replica", name, "pool.size", poolSize)
+		replicas = append(replicas, pgxconn.Replica{Name: name, Conn: pgxconn.NewQueryLoggingPgxConn(pool)})
+	}
+	return replicas, nil
+}
+
+func closeReplicas(replicas []pgxconn.Replica) {
+	for _, r := range replicas {
+		r.Conn.Close()
+	}
+}
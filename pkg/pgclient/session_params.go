@@ -0,0 +1,113 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgclient
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var sessionParamNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// reservedSessionParams are managed by Promscale or change the privileges of
+// the connection, so they cannot be overridden per pool.
+var reservedSessionParams = map[string]struct{}{
+	"search_path":           {},
+	"role":                  {},
+	"session_authorization": {},
+	"application_name":      {},
+}
+
+// SessionParam is a Postgres configuration parameter (GUC) set on every
+// connection of a pool.
+type SessionParam struct {
+	Name  string
+	Value string
+}
+
+// SessionParams is a CLI flag type holding session parameters as a
+// comma-separated list of name=value pairs, e.g. work_mem=64MB,jit=off.
+type SessionParams []SessionParam
+
+func (p *SessionParams) String() string {
+	if p == nil {
+		return ""
+	}
+	pairs := make([]string, len(*p))
+	for i, param := range *p {
+		pairs[i] = param.Name + "=" + param.Value
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set implements the flag interface to set value from the CLI
+func (p *SessionParams) Set(val string) error {
+	params := SessionParams{}
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid session parameter %q: expected name=value", pair)
+		}
+		params = append(params, SessionParam{Name: strings.ToLower(strings.TrimSpace(kv[0])), Value: strings.TrimSpace(kv[1])})
+	}
+	*p = params
+	return params.Validate()
+}
+
+// Validate checks that the parameter names are valid and can be set per pool.
+func (p SessionParams) Validate() error {
+	seen := make(map[string]struct{}, len(p))
+	for _, param := range p {
+		if !sessionParamNameRegex.MatchString(param.Name) {
+			return fmt.Errorf("invalid session parameter name %q", param.Name)
+		}
+		if _, reserved := reservedSessionParams[param.Name]; reserved {
+			return fmt.Errorf("session parameter %q is managed by Promscale and cannot be set", param.Name)
+		}
+		if _, duplicate := seen[param.Name]; duplicate {
+			return fmt.Errorf("session parameter %q is set more than once", param.Name)
+		}
+		seen[param.Name] = struct{}{}
+	}
+	return nil
+}
+
+// apply sets the parameters for the session. Values are passed as arguments to
+// set_config so they never need to be quoted.
+func (p SessionParams) apply(ctx context.Context, conn *pgx.Conn) error {
+	for _, param := range p {
+		if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", param.Name, param.Value); err != nil {
+			return fmt.Errorf("setting session parameter %s: %w", param.Name, err)
+		}
+	}
+	return nil
+}
+
+// withSessionParams returns an AfterConnect hook that sets the session
+// parameters before calling next. pgxpool calls the hook for every new
+// connection, so the parameters are reapplied whenever a connection is
+// replaced.
+func withSessionParams(params SessionParams, next LockFunc) LockFunc {
+	if len(params) == 0 {
+		return next
+	}
+	return func(ctx context.Context, conn *pgx.Conn) error {
+		if err := params.apply(ctx, conn); err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		return next(ctx, conn)
+	}
+}
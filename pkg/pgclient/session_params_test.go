@@ -0,0 +1,81 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionParamsSet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected SessionParams
+		err      bool
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: SessionParams{},
+		},
+		{
+			name:  "multiple params",
+			value: "work_mem=64MB, jit=off,synchronous_commit=off",
+			expected: SessionParams{
+				{Name: "work_mem", Value: "64MB"},
+				{Name: "jit", Value: "off"},
+				{Name: "synchronous_commit", Value: "off"},
+			},
+		},
+		{
+			name:     "custom option with quotes in value",
+			value:    "Promscale.Tag='a b'",
+			expected: SessionParams{{Name: "promscale.tag", Value: "'a b'"}},
+		},
+		{
+			name:  "missing value",
+			value: "work_mem",
+			err:   true,
+		},
+		{
+			name:  "invalid name",
+			value: "work_mem;drop=1",
+			err:   true,
+		},
+		{
+			name:  "reserved name",
+			value: "search_path=public",
+			err:   true,
+		},
+		{
+			name:  "duplicate name",
+			value: "jit=off,JIT=on",
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var params SessionParams
+			err := params.Set(c.value)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, params)
+		})
+	}
+}
+
+func TestSessionParamsString(t *testing.T) {
+	params := SessionParams{{Name: "work_mem", Value: "64MB"}, {Name: "jit", Value: "off"}}
+	require.Equal(t, "work_mem=64MB,jit=off", params.String())
+
+	var parsed SessionParams
+	require.NoError(t, parsed.Set(params.String()))
+	require.Equal(t, params, parsed)
+}
@@ -0,0 +1,174 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/shard"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+// ShardURIs is a CLI flag type holding the connection strings of the
+// databases the metrics are sharded across, besides db.uri, as a
+// comma-separated list.
+type ShardURIs []string
+
+func (u *ShardURIs) String() string {
+	if u == nil {
+		return ""
+	}
+	return redactedURIs(*u)
+}
+
+// Set implements the flag interface to set value from the CLI
+func (u *ShardURIs) Set(val string) error {
+	uris, err := parseURIs(val, "shard")
+	if err != nil {
+		return err
+	}
+	*u = uris
+	return nil
+}
+
+// shardPools are the connection pools to a shard. The writer pool is nil in
+// read-only mode.
+type shardPools struct {
+	name   string
+	writer *pgxpool.Pool
+	reader *pgxpool.Pool
+}
+
+func (p shardPools) close() {
+	if p.writer != nil {
+		p.writer.Close()
+	}
+	p.reader.Close()
+}
+
+func closeShards(shards []shardPools) {
+	for _, p := range shards {
+		p.close()
+	}
+}
+
+// connectShards creates the writer and reader pools to each shard, with the
+// sizes and settings of the pools to db.uri. The schema locker checks that
+// the Promscale extension of the shard is supported.
+func (cfg *Config) connectShards(readerPoolSize, writerPoolSize int, schemaLocker LockFunc, readOnly bool) ([]shardPools, error) {
+	shards := make([]shardPools, 0, len(cfg.ShardURIs))
+	for i, uri := range cfg.ShardURIs {
+		p := shardPools{name: fmt.Sprintf("shard%d", i+1)}
+		if !readOnly {
+			writerPgConfig, err := cfg.getPgConfigFor(uri, cfg.WriterPoolMinSize, writerPoolSize)
+			if err != nil {
+				closeShards(shards)
+				return nil, fmt.Errorf("get %s writer pg-config: %w", p.name, err)
+			}
+			SetWriterPoolAfterConnect(writerPgConfig, withSessionParams(cfg.sessionParams("writer"), schemaLocker), cfg.WriterSynchronousCommit)
+			if p.writer, err = pgxpool.ConnectConfig(context.Background(), writerPgConfig); err != nil {
+				closeShards(shards)
+				return nil, fmt.Errorf("err creating %s writer connection pool: %w", p.name, err)
+			}
+		}
+		readerPgConfig, err := cfg.getPgConfigFor(uri, cfg.ReaderPoolMinSize, readerPoolSize)
+		if err != nil {
+			p.close()
+			closeShards(shards)
+			return nil, fmt.Errorf("get %s reader pg-config: %w", p.name, err)
+		}
+		readerPgConfig.AfterConnect = withSessionParams(cfg.sessionParams("reader"), schemaLocker)
+		if p.reader, err = pgxpool.ConnectConfig(context.Background(), readerPgConfig); err != nil {
+			if p.writer != nil {
+				p.writer.Close()
+			}
+			closeShards(shards)
+			return nil, fmt.Errorf("err creating %s reader connection pool: %w", p.name, err)
+		}
+		log.Info("msg", "shard", "shard", p.name, "uri", getRedactedConnStr(uri))
+		shards = append(shards, p)
+	}
+	return shards, nil
+}
+
+// initShards routes the metrics of the client across db.uri, the first
+// shard, and the shards of the pools. Each shard has its own caches,
+// ingestor and querier.
+func (c *Client) initShards(r prometheus.Registerer, cfg *Config, shards []shardPools, numCopiers int, mt tenancy.Authorizer, readOnly bool) error {
+	router, err := shard.NewRouter(len(shards)+1, cfg.ShardMapping)
+	if err != nil {
+		return err
+	}
+	var (
+		queriers  = []querier.Querier{c.querier}
+		readers   = []lreader.LabelsReader{c.labelsReader}
+		ingestors []*ingestor.DBIngestor
+	)
+	if !readOnly {
+		first, ok := c.ingestor.(*ingestor.DBIngestor)
+		if !ok {
+			return fmt.Errorf("unable to shard: DBIngestor not found. Received %T", c.ingestor)
+		}
+		ingestors = append(ingestors, first)
+	}
+	pools := make(map[string]*pgxpool.Pool)
+	for _, p := range shards {
+		cacheCfg := cfg.CacheConfig
+		cacheCfg.Shard = p.name
+		metricsCache := cache.NewMetricCache(cacheCfg)
+		labelsCache := cache.NewLabelsCache(cacheCfg)
+		seriesCache := cache.NewSeriesCache(cacheCfg, c.sigClose)
+		c.cacheSizer.Manage(cache.ShardName("metric_name", p.name), metricsCache)
+		c.cacheSizer.Manage(cache.ShardName("label", p.name), labelsCache)
+		c.cacheSizer.Manage(cache.ShardName("series", p.name), seriesCache)
+		c.ingestBudget.TrackCache(cache.ShardName("metric_name", p.name), metricsCache)
+		c.ingestBudget.TrackCache(cache.ShardName("label", p.name), labelsCache)
+		c.ingestBudget.TrackCache(cache.ShardName("series", p.name), seriesCache)
+		exemplarKeyPosCache := cache.NewExemplarLabelsPosCache(cacheCfg)
+
+		readerConn := pgxconn.NewQueryLoggingPgxConn(p.reader)
+		labelsReader := lreader.NewLabelsReader(readerConn, labelsCache, mt.ReadAuthorizer())
+		readers = append(readers, labelsReader)
+		queriers = append(queriers, querier.NewQuerier(readerConn, metricsCache, labelsReader, exemplarKeyPosCache, mt.ReadAuthorizer(), cfg.IndexAdvisor, c.planCache))
+		pools["reader_"+p.name] = p.reader
+
+		if !readOnly {
+			// The span metrics are generated by the sharded ingestor.
+			ingCfg := ingestorCfg(cfg, numCopiers, c.cacheSizer, c.ingestBudget)
+			ingCfg.Shard = p.name
+			ingCfg.SpanMetrics.Enabled = false
+			dbIngestor, err := ingestor.NewPgxIngestor(pgxconn.NewPgxConn(p.writer), metricsCache, seriesCache, exemplarKeyPosCache, &ingCfg)
+			if err != nil {
+				for _, ing := range ingestors[1:] {
+					ing.Close()
+				}
+				return fmt.Errorf("err starting the %s ingestor: %w", p.name, err)
+			}
+			ingestors = append(ingestors, dbIngestor)
+			pools["writer_"+p.name] = p.writer
+		}
+	}
+
+	if !readOnly {
+		c.ingestor = ingestor.NewShardedIngestor(ingestors, router, cfg.Relabeler, cfg.SpanMetrics)
+	}
+	c.querier = querier.NewShardedQuerier(queriers, router)
+	c.labelsReader = lreader.NewShardedLabelsReader(readers)
+	c.queryable = query.NewQueryable(c.querier, c.labelsReader)
+	c.shards = shards
+	initMetrics(r, pools)
+	return nil
+}
@@ -0,0 +1,259 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	DefaultAdaptiveSizingInterval = time.Minute
+
+	// shrinkFactor is the factor a cache is shrunk by when the caches use more than the memory budget.
+	shrinkFactor = 0.75
+	// minSizeFactor bounds how small a cache is shrunk to, relative to its initial size.
+	minSizeFactor = 0.1
+	// elementOverheadBytes is the size of an empty element in a clockcache.
+	elementOverheadBytes = 120
+)
+
+var (
+	memoryBudgetMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "memory_budget_bytes",
+			Help:      "The target for the total amount of memory used by the adaptively sized caches.",
+		})
+	hitRatioMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "hit_ratio",
+			Help:      "Ratio of the cache lookups that were hits during the last adaptive sizing interval.",
+		}, []string{"type", "name"})
+	resizesMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "resizes_total",
+			Help:      "Total number of times a cache was grown or shrunk by adaptive sizing.",
+		}, []string{"type", "name", "direction"})
+)
+
+func init() {
+	prometheus.MustRegister(memoryBudgetMetric, hitRatioMetric, resizesMetric)
+}
+
+// Resizable is a cache whose capacity can change while in use.
+type Resizable interface {
+	Stats() clockcache.Stats
+	Resize(newMax int)
+}
+
+type sizedCache struct {
+	name    string
+	cache   Resizable
+	minSize int
+	prev    clockcache.Stats
+
+	// Computed on every sizing interval.
+	stats        clockcache.Stats
+	evictions    uint64
+	elementBytes float64
+}
+
+func (c *sizedCache) sizeBytes(numElements int) uint64 {
+	return uint64(float64(numElements) * c.elementBytes)
+}
+
+// pressure is the share of the cache evicted during the last interval.
+func (c *sizedCache) pressure() float64 {
+	if c.stats.Cap == 0 {
+		return 0
+	}
+	return float64(c.evictions) / float64(c.stats.Cap)
+}
+
+// AdaptiveSizer periodically grows the caches that evict a lot of their
+// elements and shrinks the least used ones, keeping the total size of the
+// caches within a memory budget. A nil AdaptiveSizer does not resize caches.
+type AdaptiveSizer struct {
+	mux      sync.Mutex
+	budget   uint64
+	interval time.Duration
+	caches   []*sizedCache
+	// limit returns a lower budget the caches must fit in, nil if the
+	// budget is the only limit.
+	limit func() uint64
+}
+
+// NewAdaptiveSizer returns a new AdaptiveSizer, or nil if adaptive sizing is disabled.
+func NewAdaptiveSizer(cfg Config) *AdaptiveSizer {
+	if !cfg.AdaptiveSizing {
+		return nil
+	}
+	memoryBudgetMetric.Set(float64(cfg.MemoryBudgetBytes))
+	return &AdaptiveSizer{
+		budget:   cfg.MemoryBudgetBytes,
+		interval: cfg.AdaptiveSizingInterval,
+	}
+}
+
+// Manage adds a cache to the caches resized by the AdaptiveSizer. The cache
+// is never shrunk below a tenth of its current capacity.
+func (s *AdaptiveSizer) Manage(name string, c Resizable) {
+	if s == nil {
+		return
+	}
+	stats := c.Stats()
+	minSize := int(float64(stats.Cap) * minSizeFactor)
+	if minSize < 1 {
+		minSize = 1
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.caches = append(s.caches, &sizedCache{name: name, cache: c, minSize: minSize, prev: stats})
+}
+
+// SetBudget changes the memory budget of the caches.
+func (s *AdaptiveSizer) SetBudget(budget uint64) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.budget = budget
+	memoryBudgetMetric.Set(float64(budget))
+}
+
+// LimitBy lowers the memory budget of the caches to the value returned by
+// limit on every sizing interval, when it is lower. It lets the caches give
+// memory back to the rest of the ingest.
+func (s *AdaptiveSizer) LimitBy(limit func() uint64) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.limit = limit
+}
+
+// Run resizes the caches every sizing interval until sigClose is closed.
+func (s *AdaptiveSizer) Run(sigClose <-chan struct{}) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.resize()
+		case <-sigClose:
+			return
+		}
+	}
+}
+
+func (s *AdaptiveSizer) resize() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	budget := s.budget
+	if s.limit != nil {
+		if limit := s.limit(); limit < budget {
+			budget = limit
+		}
+	}
+
+	used := uint64(0)
+	for _, c := range s.caches {
+		c.stats = c.cache.Stats()
+		c.evictions = c.stats.Evictions - c.prev.Evictions
+		queries := c.stats.Queries - c.prev.Queries
+		if queries > 0 {
+			hitRatioMetric.WithLabelValues("metric", c.name).Set(float64(c.stats.Hits-c.prev.Hits) / float64(queries))
+		}
+		c.prev = c.stats
+
+		c.elementBytes = elementOverheadBytes
+		if c.stats.Len > 0 {
+			dataBytes := c.stats.SizeBytes - uint64(c.stats.Cap*elementOverheadBytes)
+			c.elementBytes += float64(dataBytes) / float64(c.stats.Len)
+		}
+		used += c.sizeBytes(c.stats.Cap)
+	}
+
+	// Caches under the least pressure are shrunk first, and the ones
+	// under the most pressure are grown first. On a tie, larger caches
+	// are shrunk first.
+	byPressure := make([]*sizedCache, len(s.caches))
+	copy(byPressure, s.caches)
+	sort.SliceStable(byPressure, func(i, j int) bool {
+		pi, pj := byPressure[i].pressure(), byPressure[j].pressure()
+		if pi != pj {
+			return pi < pj
+		}
+		return byPressure[i].stats.Cap > byPressure[j].stats.Cap
+	})
+
+	if used > budget {
+		for _, c := range byPressure {
+			if used <= budget {
+				break
+			}
+			newCap := int(float64(c.stats.Cap) * shrinkFactor)
+			if newCap < c.minSize {
+				newCap = c.minSize
+			}
+			if newCap >= c.stats.Cap {
+				continue
+			}
+			used -= c.sizeBytes(c.stats.Cap - newCap)
+			s.resizeCache(c, newCap)
+		}
+		return
+	}
+
+	for i := len(byPressure) - 1; i >= 0; i-- {
+		c := byPressure[i]
+		if c.pressure() <= GrowEvictionThreshold {
+			break
+		}
+		extra := int(float64(c.stats.Cap) * (GrowFactor - 1))
+		if available := budget - used; c.sizeBytes(extra) > available {
+			extra = int(float64(available) / c.elementBytes)
+		}
+		if extra <= 0 {
+			log.Warn("msg", "Cache is evicting often but the memory budget is used up", "cache", c.name,
+				"capacity", c.stats.Cap, "evictions", c.evictions, "memory_budget_bytes", budget)
+			continue
+		}
+		used += c.sizeBytes(extra)
+		s.resizeCache(c, c.stats.Cap+extra)
+	}
+}
+
+func (s *AdaptiveSizer) resizeCache(c *sizedCache, newCap int) {
+	direction := "grow"
+	if newCap < c.stats.Cap {
+		direction = "shrink"
+	}
+	log.Info("msg", "Resizing cache", "cache", c.name, "direction", direction,
+		"current_size_elements", c.stats.Cap, "new_size_elements", newCap,
+		"evictions", c.evictions, "memory_budget_bytes", s.budget)
+	c.cache.Resize(newCap)
+	resizesMetric.WithLabelValues("metric", c.name, direction).Inc()
+	// The shrunk elements are counted as evictions, do not take them as pressure.
+	c.prev = c.cache.Stats()
+}
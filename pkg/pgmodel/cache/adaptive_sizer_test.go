@@ -0,0 +1,105 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+)
+
+func fillCache(c *clockcache.Cache, from, to int) {
+	for i := from; i < to; i++ {
+		c.Insert(i, i, 8)
+	}
+}
+
+func TestAdaptiveSizerDisabled(t *testing.T) {
+	sizer := NewAdaptiveSizer(Config{})
+	require.Nil(t, sizer)
+	// A nil sizer does nothing.
+	sizer.Manage("label", clockcache.WithMax(10))
+	sizer.SetBudget(100)
+	sizer.Run(nil)
+}
+
+func TestAdaptiveSizerGrow(t *testing.T) {
+	sizer := NewAdaptiveSizer(Config{AdaptiveSizing: true, MemoryBudgetBytes: 1 << 20, AdaptiveSizingInterval: DefaultAdaptiveSizingInterval})
+	busy := clockcache.WithMax(100)
+	idle := clockcache.WithMax(100)
+	sizer.Manage("busy", busy)
+	sizer.Manage("idle", idle)
+
+	fillCache(busy, 0, 200)
+	fillCache(idle, 0, 50)
+	sizer.resize()
+	require.Equal(t, 200, busy.Cap())
+	require.Equal(t, 100, idle.Cap())
+
+	// No new evictions, the caches are kept as is.
+	sizer.resize()
+	require.Equal(t, 200, busy.Cap())
+	require.Equal(t, 100, idle.Cap())
+}
+
+func TestAdaptiveSizerBudget(t *testing.T) {
+	// Each element takes 128 bytes, the budget fits 150 of them.
+	sizer := NewAdaptiveSizer(Config{AdaptiveSizing: true, MemoryBudgetBytes: 150 * 128, AdaptiveSizingInterval: DefaultAdaptiveSizingInterval})
+	busy := clockcache.WithMax(100)
+	idle := clockcache.WithMax(20)
+	sizer.Manage("busy", busy)
+	sizer.Manage("idle", idle)
+
+	// The busy cache can only grow within the budget.
+	fillCache(busy, 0, 200)
+	fillCache(idle, 0, 20)
+	sizer.resize()
+	require.Equal(t, 130, busy.Cap())
+	require.Equal(t, 20, idle.Cap())
+
+	// Over budget, the caches are shrunk until they fit, larger ones first.
+	sizer.SetBudget(100 * 128)
+	sizer.resize()
+	require.Equal(t, 97, busy.Cap())
+	require.Equal(t, 15, idle.Cap())
+	sizer.resize()
+	require.Equal(t, 72, busy.Cap())
+	require.Equal(t, 15, idle.Cap())
+	require.LessOrEqual(t, busy.SizeBytes()+idle.SizeBytes(), uint64(100*128))
+
+	// Caches are not shrunk below a tenth of their initial size.
+	sizer.SetBudget(0)
+	for i := 0; i < 20; i++ {
+		sizer.resize()
+	}
+	require.Equal(t, 10, busy.Cap())
+	require.Equal(t, 2, idle.Cap())
+}
+
+func TestAdaptiveSizerLimit(t *testing.T) {
+	sizer := NewAdaptiveSizer(Config{AdaptiveSizing: true, MemoryBudgetBytes: 150 * 128, AdaptiveSizingInterval: DefaultAdaptiveSizingInterval})
+	busy := clockcache.WithMax(100)
+	idle := clockcache.WithMax(20)
+	sizer.Manage("busy", busy)
+	sizer.Manage("idle", idle)
+	limit := uint64(100 * 128)
+	sizer.LimitBy(func() uint64 { return limit })
+
+	// The caches are shrunk to the limit below the budget, the least
+	// pressured ones first.
+	fillCache(busy, 0, 200)
+	fillCache(idle, 0, 20)
+	sizer.resize()
+	require.Equal(t, 75, busy.Cap())
+	require.Equal(t, 15, idle.Cap())
+
+	// A limit above the budget does not apply.
+	limit = 1 << 30
+	fillCache(busy, 200, 400)
+	sizer.resize()
+	require.Equal(t, 135, busy.Cap())
+	require.Equal(t, 15, idle.Cap())
+}
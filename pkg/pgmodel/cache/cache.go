@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 )
@@ -15,6 +16,9 @@ import (
 const (
 	DefaultMetricCacheSize = 10000
 	DefaultLabelsCacheSize = 100000
+	// DefaultQueryPlansCacheSize is the number of shapes of selectors whose
+	// SQL is cached, enough for the panels of many dashboards.
+	DefaultQueryPlansCacheSize = 1000
 )
 
 type LabelsCache interface {
@@ -35,6 +39,7 @@ type LabelsCache interface {
 	// Cap returns the capacity of the labels cache.
 	Cap() int
 	Evictions() uint64
+	Resizable
 }
 
 type key struct {
@@ -62,8 +67,17 @@ type MetricNameCache struct {
 	Metrics *clockcache.Cache
 }
 
+// ShardName returns the name of a cache of the shard, which is the name of the
+// cache if the metrics are not sharded.
+func ShardName(name, shard string) string {
+	if shard == "" {
+		return name
+	}
+	return name + "_" + shard
+}
+
 func NewMetricCache(config Config) *MetricNameCache {
-	return &MetricNameCache{Metrics: clockcache.WithMetrics("metric_name", "metric", config.MetricsCacheSize)}
+	return &MetricNameCache{Metrics: clockcache.WithMetrics(ShardName("metric_name", config.Shard), "metric", config.MetricsCacheSize)}
 }
 
 // Get fetches the table name for specified metric.
@@ -87,6 +101,7 @@ func (m *MetricNameCache) Get(schema, metric string, isExemplar bool) (model.Met
 
 // Set stores metric info for specified metric with schema.
 func (m *MetricNameCache) Set(schema, metric string, val model.MetricInfo, isExemplar bool) error {
+	metric = intern.String(metric)
 	k := key{schema, metric, isExemplar}
 	//size includes an 8-byte overhead for each string
 	m.Metrics.Insert(k, val, uint64(k.len()+val.Len()+17))
@@ -113,6 +128,19 @@ func (m *MetricNameCache) Evictions() uint64 {
 	return m.Metrics.Evictions()
 }
 
+// ExpandTo grows the cache to hold up to newMax metrics.
+func (m *MetricNameCache) ExpandTo(newMax int) {
+	m.Metrics.ExpandTo(newMax)
+}
+
+func (m *MetricNameCache) Resize(newMax int) {
+	m.Metrics.Resize(newMax)
+}
+
+func (m *MetricNameCache) Stats() clockcache.Stats {
+	return m.Metrics.Stats()
+}
+
 func NewLabelsCache(config Config) LabelsCache {
-	return clockcache.WithMetrics("label", "metric", config.LabelsCacheSize)
+	return clockcache.WithMetrics(ShardName("label", config.Shard), "metric", config.LabelsCacheSize)
 }
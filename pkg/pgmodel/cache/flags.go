@@ -7,8 +7,10 @@ package cache
 import (
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/util"
 )
@@ -31,6 +33,21 @@ type Config struct {
 	LabelsCacheSize         uint64
 	ExemplarKeyPosCacheSize uint64
 	InvertedLabelsCacheSize uint64
+	InternedStringsSize     uint64
+	QueryPlansCacheSize     uint64
+
+	AdaptiveSizing         bool
+	AdaptiveSizingInterval time.Duration
+	memoryBudgetFlag       limits.PercentageAbsoluteBytesFlag
+	MemoryBudgetBytes      uint64
+
+	WarmUpSeries     uint64
+	WarmUpByActivity bool
+	WarmUpTimeout    time.Duration
+
+	// Shard is the shard of the caches if the metrics are sharded across
+	// databases, each shard having its own caches.
+	Shard string
 }
 
 var DefaultConfig = Config{
@@ -41,11 +58,17 @@ var DefaultConfig = Config{
 	LabelsCacheSize:         DefaultLabelsCacheSize,
 	ExemplarKeyPosCacheSize: DefaultExemplarKeyPosCacheSize,
 	InvertedLabelsCacheSize: DefaultInvertedLabelsCacheSize,
+	InternedStringsSize:     intern.DefaultPoolSize,
+	QueryPlansCacheSize:     DefaultQueryPlansCacheSize,
+
+	AdaptiveSizingInterval: DefaultAdaptiveSizingInterval,
+	WarmUpTimeout:          DefaultWarmUpTimeout,
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	/* set defaults */
 	cfg.seriesCacheMemoryMaxFlag.SetPercent(50)
+	cfg.memoryBudgetFlag.SetPercent(60)
 
 	fs.Uint64Var(&cfg.MetricsCacheSize, "metrics.cache.metrics.size", DefaultMetricCacheSize, "Maximum number of metric names to cache.")
 	fs.Uint64Var(&cfg.SeriesCacheInitialSize, "metrics.cache.series.initial-size", DefaultSeriesCacheSize, "Maximum number of series to cache.")
@@ -55,6 +78,21 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.Var(&cfg.seriesCacheMemoryMaxFlag, "metrics.cache.series.max-bytes", "Initial number of elements in the series cache. "+
 		"Specified in bytes or as a percentage of the memory-target (e.g. 50%).")
 	fs.Uint64Var(&cfg.InvertedLabelsCacheSize, "metrics.cache.inverted-labels.size", DefaultInvertedLabelsCacheSize, "Maximum number of label-ids to cache. This helps increase ingest performance.")
+	fs.Uint64Var(&cfg.InternedStringsSize, "metrics.cache.interned-strings.size", intern.DefaultPoolSize, "Maximum number of label names and values to intern. "+
+		"Interned strings are shared between the parsed requests and the caches instead of being copied for every series. Set to 0 to disable interning.")
+	fs.Uint64Var(&cfg.QueryPlansCacheSize, "metrics.cache.query-plans.size", DefaultQueryPlansCacheSize, "Maximum number of query plans to cache. The SQL generated for a selector is cached by the shape of the selector "+
+		"and reused, as a prepared statement, by the queries with the same shape. Set to 0 to disable the cache.")
+	fs.BoolVar(&cfg.AdaptiveSizing, "metrics.cache.adaptive-sizing", false, "Periodically grow and shrink the metric, label, inverted label and series caches based on their evictions and hit ratio, "+
+		"keeping their total size within -metrics.cache.memory-budget. The configured cache sizes are used as initial sizes.")
+	fs.DurationVar(&cfg.AdaptiveSizingInterval, "metrics.cache.adaptive-sizing.interval", DefaultAdaptiveSizingInterval, "How often the caches are resized when -metrics.cache.adaptive-sizing is set.")
+	fs.Var(&cfg.memoryBudgetFlag, "metrics.cache.memory-budget", "Target for the total amount of memory used by the caches resized by -metrics.cache.adaptive-sizing. "+
+		"Specified in bytes or as a percentage of the memory-target (e.g. 60%).")
+	fs.Uint64Var(&cfg.WarmUpSeries, "metrics.cache.warm-up.series", 0, "Number of the most recently created series to load into the series and inverted labels caches on startup, "+
+		"at most the series cache size. The table names of their metrics are loaded into the metric name cache. Promscale reports not ready on /-/ready until the warm-up finishes. Set to 0 to disable the warm-up.")
+	fs.BoolVar(&cfg.WarmUpByActivity, "metrics.cache.warm-up.by-activity", false, "Warm up the caches with the series that have the most samples in the latest chunk of each metric "+
+		"according to the database statistics, instead of the most recently created series. Requires TimescaleDB.")
+	fs.DurationVar(&cfg.WarmUpTimeout, "metrics.cache.warm-up.timeout", DefaultWarmUpTimeout, "Maximum duration of the cache warm-up. "+
+		"When it runs out, the series loaded so far are kept and Promscale reports ready.")
 	return cfg
 }
 
@@ -74,6 +112,25 @@ func Validate(cfg *Config, lcfg limits.Config) error {
 		return fmt.Errorf("The series-cache-max-bytes must be smaller than the memory-target")
 	}
 
+	kind, value = cfg.memoryBudgetFlag.Get()
+	switch kind {
+	case limits.Percentage:
+		cfg.MemoryBudgetBytes = uint64(float64(lcfg.TargetMemoryBytes) * (float64(value) / 100.0))
+	case limits.Absolute:
+		cfg.MemoryBudgetBytes = value
+	default:
+		return fmt.Errorf("metrics.cache.memory-budget flag has unknown kind")
+	}
+	if cfg.MemoryBudgetBytes > lcfg.TargetMemoryBytes {
+		return fmt.Errorf("metrics.cache.memory-budget must be smaller than the memory-target")
+	}
+	if cfg.AdaptiveSizing && cfg.AdaptiveSizingInterval <= 0 {
+		return fmt.Errorf("metrics.cache.adaptive-sizing.interval must be positive, got %s", cfg.AdaptiveSizingInterval)
+	}
+	if cfg.WarmUpSeries > 0 && cfg.WarmUpTimeout <= 0 {
+		return fmt.Errorf("metrics.cache.warm-up.timeout must be positive, got %s", cfg.WarmUpTimeout)
+	}
+
 	return nil
 }
 
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/peterbourgon/ff/v3"
 	"github.com/stretchr/testify/require"
@@ -43,3 +44,38 @@ func TestParse(t *testing.T) {
 	config = fullyParse(t, []string{"-metrics.cache.series.max-bytes", "60000"}, &limits.Config{TargetMemoryBytes: 200000}, false)
 	require.Equal(t, uint64(60000), config.SeriesCacheMemoryMaxBytes)
 }
+
+func TestParseMemoryBudget(t *testing.T) {
+	config := fullyParse(t, []string{}, &limits.Config{TargetMemoryBytes: 100000}, false)
+	require.False(t, config.AdaptiveSizing)
+	require.Equal(t, uint64(60000), config.MemoryBudgetBytes)
+
+	config = fullyParse(t, []string{"-metrics.cache.adaptive-sizing", "-metrics.cache.memory-budget", "40000"}, &limits.Config{TargetMemoryBytes: 100000}, false)
+	require.True(t, config.AdaptiveSizing)
+	require.Equal(t, uint64(40000), config.MemoryBudgetBytes)
+	require.Equal(t, DefaultAdaptiveSizingInterval, config.AdaptiveSizingInterval)
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	config = Config{}
+	ParseFlags(fs, &config)
+	require.NoError(t, ff.Parse(fs, []string{"-metrics.cache.memory-budget", "200000"}))
+	require.Error(t, Validate(&config, limits.Config{TargetMemoryBytes: 100000}))
+}
+
+func TestParseWarmUp(t *testing.T) {
+	config := fullyParse(t, []string{}, &limits.Config{TargetMemoryBytes: 100000}, false)
+	require.Equal(t, uint64(0), config.WarmUpSeries)
+	require.False(t, config.WarmUpByActivity)
+	require.Equal(t, DefaultWarmUpTimeout, config.WarmUpTimeout)
+
+	config = fullyParse(t, []string{"-metrics.cache.warm-up.series", "1000", "-metrics.cache.warm-up.by-activity", "-metrics.cache.warm-up.timeout", "30s"}, &limits.Config{TargetMemoryBytes: 100000}, false)
+	require.Equal(t, uint64(1000), config.WarmUpSeries)
+	require.True(t, config.WarmUpByActivity)
+	require.Equal(t, 30*time.Second, config.WarmUpTimeout)
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	config = Config{}
+	ParseFlags(fs, &config)
+	require.NoError(t, ff.Parse(fs, []string{"-metrics.cache.warm-up.series", "1000", "-metrics.cache.warm-up.timeout", "0s"}))
+	require.Error(t, Validate(&config, limits.Config{TargetMemoryBytes: 100000}))
+}
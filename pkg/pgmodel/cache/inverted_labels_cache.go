@@ -43,10 +43,15 @@ type InvertedLabelsCache struct {
 
 // Cache is thread-safe
 func NewInvertedLabelsCache(size uint64) (*InvertedLabelsCache, error) {
+	return NewShardInvertedLabelsCache(size, "")
+}
+
+// NewShardInvertedLabelsCache returns the inverted labels cache of the shard.
+func NewShardInvertedLabelsCache(size uint64, shard string) (*InvertedLabelsCache, error) {
 	if size <= 0 {
 		return nil, fmt.Errorf("labels cache size must be > 0")
 	}
-	cache := clockcache.WithMetrics("inverted_labels", "metric", size)
+	cache := clockcache.WithMetrics(ShardName("inverted_labels", shard), "metric", size)
 	return &InvertedLabelsCache{cache}, nil
 }
 
@@ -66,3 +71,11 @@ func (c *InvertedLabelsCache) Put(key LabelKey, val LabelInfo) bool {
 func (c *InvertedLabelsCache) Reset() {
 	c.cache.Reset()
 }
+
+func (c *InvertedLabelsCache) Resize(newMax int) {
+	c.cache.Resize(newMax)
+}
+
+func (c *InvertedLabelsCache) Stats() clockcache.Stats {
+	return c.cache.Stats()
+}
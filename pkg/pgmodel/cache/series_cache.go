@@ -11,6 +11,7 @@ import (
 	"math"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -28,27 +29,33 @@ const GrowCheckDuration = time.Minute // check whether to grow the series cache
 const GrowEvictionThreshold = 0.2     // grow when evictions more than 20% of cache size
 const GrowFactor = float64(2.0)       // multiply cache size by this factor when growing the cache
 
+const DefaultWarmUpTimeout = 5 * time.Minute // give up warming up the series cache after this long
+
 // SeriesCache is a cache of model.Series entries.
 type SeriesCache interface {
 	Reset()
 	GetSeriesFromProtos(labelPairs []prompb.Label) (series *model.Series, metricName string, err error)
+	PreloadSeries(labelPairs []prompb.Label, id model.SeriesID, epoch model.SeriesEpoch) error
 	Len() int
 	Cap() int
 	Evictions() uint64
 }
 
 type SeriesCacheImpl struct {
-	cache        *clockcache.Cache
+	// maxSizeBytes is accessed atomically, it comes first
+	// to be 64-bit aligned.
 	maxSizeBytes uint64
+	cache        *clockcache.Cache
 }
 
 func NewSeriesCache(config Config, sigClose <-chan struct{}) *SeriesCacheImpl {
 	cache := &SeriesCacheImpl{
-		clockcache.WithMetrics("series", "metric", config.SeriesCacheInitialSize),
-		config.SeriesCacheMemoryMaxBytes,
+		maxSizeBytes: config.SeriesCacheMemoryMaxBytes,
+		cache:        clockcache.WithMetrics(ShardName("series", config.Shard), "metric", config.SeriesCacheInitialSize),
 	}
 
-	if sigClose != nil {
+	// With adaptive sizing, the cache is grown by the AdaptiveSizer instead.
+	if sigClose != nil && !config.AdaptiveSizing {
 		go cache.runSizeCheck(sigClose)
 	}
 	return cache
@@ -76,17 +83,18 @@ func (t *SeriesCacheImpl) runSizeCheck(sigClose <-chan struct{}) {
 func (t *SeriesCacheImpl) grow(newEvictions uint64) {
 	sizeBytes := t.cache.SizeBytes()
 	oldSize := t.cache.Cap()
-	if float64(sizeBytes)*1.2 >= float64(t.maxSizeBytes) {
+	maxSizeBytes := atomic.LoadUint64(&t.maxSizeBytes)
+	if float64(sizeBytes)*1.2 >= float64(maxSizeBytes) {
 		log.Warn("msg", "Series cache is too small and cannot be grown",
-			"current_size_bytes", float64(sizeBytes), "max_size_bytes", float64(t.maxSizeBytes),
+			"current_size_bytes", float64(sizeBytes), "max_size_bytes", float64(maxSizeBytes),
 			"current_size_elements", oldSize, "check_interval", GrowCheckDuration,
 			"new_evictions", newEvictions, "new_evictions_percent", 100*(float64(newEvictions)/float64(oldSize)))
 		return
 	}
 
 	multiplier := GrowFactor
-	if float64(sizeBytes)*multiplier >= float64(t.maxSizeBytes) {
-		multiplier = float64(t.maxSizeBytes) / float64(sizeBytes)
+	if float64(sizeBytes)*multiplier >= float64(maxSizeBytes) {
+		multiplier = float64(maxSizeBytes) / float64(sizeBytes)
 	}
 	if multiplier < 1.0 {
 		return
@@ -95,12 +103,30 @@ func (t *SeriesCacheImpl) grow(newEvictions uint64) {
 	newNumElements := int(float64(oldSize) * multiplier)
 	log.Info("msg", "Growing the series cache",
 		"new_size_elements", newNumElements, "current_size_elements", oldSize,
-		"new_size_bytes", float64(sizeBytes)*multiplier, "max_size_bytes", float64(t.maxSizeBytes),
+		"new_size_bytes", float64(sizeBytes)*multiplier, "max_size_bytes", float64(maxSizeBytes),
 		"multiplier", multiplier,
 		"new_evictions", newEvictions, "new_evictions_percent", 100*(float64(newEvictions)/float64(oldSize)))
 	t.cache.ExpandTo(newNumElements)
 }
 
+// ExpandTo grows the cache to hold up to newMax series.
+func (t *SeriesCacheImpl) ExpandTo(newMax int) {
+	t.cache.ExpandTo(newMax)
+}
+
+func (t *SeriesCacheImpl) Resize(newMax int) {
+	t.cache.Resize(newMax)
+}
+
+func (t *SeriesCacheImpl) Stats() clockcache.Stats {
+	return t.cache.Stats()
+}
+
+// SetMaxSizeBytes changes the amount of memory up to which the cache is grown.
+func (t *SeriesCacheImpl) SetMaxSizeBytes(maxSizeBytes uint64) {
+	atomic.StoreUint64(&t.maxSizeBytes, maxSizeBytes)
+}
+
 func (t *SeriesCacheImpl) Len() int {
 	return t.cache.Len()
 }
@@ -276,3 +302,21 @@ func (t *SeriesCacheImpl) GetSeriesFromProtos(labelPairs []prompb.Label) (*model
 
 	return series, metricName, nil
 }
+
+// PreloadSeries puts a series whose ID is already known into the cache, it is
+// used to warm up the cache from the database. A series already in the cache is
+// kept, and gets the ID if it does not have one yet.
+func (t *SeriesCacheImpl) PreloadSeries(labelPairs []prompb.Label, id model.SeriesID, epoch model.SeriesEpoch) error {
+	builder := keyPool.Get().(*bytes.Buffer)
+	builder.Reset()
+	defer keyPool.Put(builder)
+	if _, err := generateKey(labelPairs, builder); err != nil {
+		return err
+	}
+	key := builder.String()
+	series := t.setSeries(key, model.NewSeries(key, labelPairs))
+	if !series.IsSeriesIDSet() {
+		series.SetSeriesID(id, epoch)
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ package cache
 import (
 	"bytes"
 	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/prompb"
 	"math"
 	"strings"
@@ -52,3 +54,66 @@ func TestGenerateKey(t *testing.T) {
 	require.Equal(t, "test", metricName)
 	require.Equal(t, []byte("\x08\x00__name__\x04\x00test\x04\x00hell\x06\x00oworld\x05\x00hello\x05\x00world"), keyBuffer.Bytes())
 }
+
+func TestSeriesLabelValue(t *testing.T) {
+	cache := &SeriesCacheImpl{
+		maxSizeBytes: DefaultConfig.SeriesCacheMemoryMaxBytes,
+		cache:        clockcache.WithMax(DefaultConfig.SeriesCacheInitialSize),
+	}
+	series, _, err := cache.GetSeriesFromProtos([]prompb.Label{
+		{Name: "__name__", Value: "test"},
+		{Name: "__tenant__", Value: "tenant-a"},
+		{Name: "empty", Value: ""},
+		{Name: "hello", Value: "world"},
+	})
+	require.NoError(t, err)
+	// The values are read from the key, once the names and values are
+	// released with the series ID set.
+	series.SetSeriesID(1, 1)
+	require.Equal(t, "tenant-a", series.LabelValue("__tenant__"))
+	require.Equal(t, "world", series.LabelValue("hello"))
+	require.Equal(t, "", series.LabelValue("empty"))
+	require.Equal(t, "", series.LabelValue("missing"))
+}
+
+func TestPreloadSeries(t *testing.T) {
+	// NewSeriesCache registers the cache metrics, which TestBigLabels already did.
+	cache := &SeriesCacheImpl{
+		maxSizeBytes: DefaultConfig.SeriesCacheMemoryMaxBytes,
+		cache:        clockcache.WithMax(DefaultConfig.SeriesCacheInitialSize),
+	}
+	labels := []prompb.Label{
+		{Name: "__name__", Value: "test"},
+		{Name: "hello", Value: "world"},
+	}
+	require.NoError(t, cache.PreloadSeries(labels, 42, 3))
+
+	series, metricName, err := cache.GetSeriesFromProtos(labels)
+	require.NoError(t, err)
+	require.Equal(t, "test", metricName)
+	id, epoch, err := series.GetSeriesID()
+	require.NoError(t, err)
+	require.Equal(t, model.SeriesID(42), id)
+	require.Equal(t, model.SeriesEpoch(3), epoch)
+
+	// A series that already has an ID keeps it.
+	require.NoError(t, cache.PreloadSeries(labels, 43, 4))
+	series, _, err = cache.GetSeriesFromProtos(labels)
+	require.NoError(t, err)
+	id, _, err = series.GetSeriesID()
+	require.NoError(t, err)
+	require.Equal(t, model.SeriesID(42), id)
+
+	// A placeholder series gets the ID.
+	other := []prompb.Label{
+		{Name: "__name__", Value: "test"},
+		{Name: "hello", Value: "there"},
+	}
+	placeholder, _, err := cache.GetSeriesFromProtos(other)
+	require.NoError(t, err)
+	require.False(t, placeholder.IsSeriesIDSet())
+	require.NoError(t, cache.PreloadSeries(other, 44, 3))
+	id, _, err = placeholder.GetSeriesID()
+	require.NoError(t, err)
+	require.Equal(t, model.SeriesID(44), id)
+}
@@ -0,0 +1,89 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	BackendRedis     = "redis"
+	BackendMemcached = "memcached"
+
+	defaultTTL           = time.Hour
+	defaultKeyPrefix     = "promscale:"
+	defaultTimeout       = 100 * time.Millisecond
+	defaultRetryInterval = 10 * time.Second
+
+	// maxMemcachedTTL is the longest relative expiration time of memcached,
+	// longer ones are taken as a unix timestamp.
+	maxMemcachedTTL = 30 * 24 * time.Hour
+	// maxKeyPrefixLength keeps the keys within the 250 bytes of memcached.
+	maxKeyPrefixLength = 128
+)
+
+// Config holds the flags of the shared cache.
+type Config struct {
+	// Backend is redis or memcached, the shared cache is disabled if empty.
+	Backend  string
+	Address  string
+	Password string
+	// TTL is how long the IDs are kept in the shared cache.
+	TTL       time.Duration
+	KeyPrefix string
+	// Timeout bounds every request to the shared cache.
+	Timeout time.Duration
+	// RetryInterval is how long the shared cache is skipped after an error.
+	RetryInterval time.Duration
+}
+
+// ParseFlags registers the shared cache flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.Backend, "metrics.cache.shared.backend", "", "Backend of the second-level cache of series and label IDs shared by the Promscale replicas, redis or memcached. "+
+		"Series and labels missing from the local caches are looked up in the shared cache before being resolved by the database. Disabled if empty.")
+	fs.StringVar(&cfg.Address, "metrics.cache.shared.address", "", "Address of the shared cache server, as host:port.")
+	fs.StringVar(&cfg.Password, "metrics.cache.shared.password", "", "Password of the Redis shared cache, sent with AUTH on every new connection.")
+	fs.DurationVar(&cfg.TTL, "metrics.cache.shared.ttl", defaultTTL, "How long the series and label IDs are kept in the shared cache.")
+	fs.StringVar(&cfg.KeyPrefix, "metrics.cache.shared.key-prefix", defaultKeyPrefix, "Prefix of the keys of the shared cache. "+
+		"Promscale deployments writing to different databases must use different prefixes when sharing a cache server.")
+	fs.DurationVar(&cfg.Timeout, "metrics.cache.shared.timeout", defaultTimeout, "Timeout of the requests to the shared cache. "+
+		"Failed requests fall back to the database.")
+	fs.DurationVar(&cfg.RetryInterval, "metrics.cache.shared.retry-interval", defaultRetryInterval, "How long the shared cache is skipped after a failed request, only the local caches are used meanwhile.")
+	return cfg
+}
+
+// Validate checks the shared cache flags.
+func Validate(cfg *Config) error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case BackendRedis, BackendMemcached:
+	default:
+		return fmt.Errorf("metrics.cache.shared.backend must be %s or %s, got %q", BackendRedis, BackendMemcached, cfg.Backend)
+	}
+	switch {
+	case cfg.Address == "":
+		return fmt.Errorf("metrics.cache.shared.address is required with metrics.cache.shared.backend")
+	case cfg.Password != "" && cfg.Backend != BackendRedis:
+		return fmt.Errorf("metrics.cache.shared.password is only supported by the %s backend", BackendRedis)
+	case cfg.TTL < time.Second:
+		return fmt.Errorf("metrics.cache.shared.ttl must be at least 1s, got %s", cfg.TTL)
+	case cfg.Backend == BackendMemcached && cfg.TTL > maxMemcachedTTL:
+		return fmt.Errorf("metrics.cache.shared.ttl must be at most %s with %s, got %s", maxMemcachedTTL, BackendMemcached, cfg.TTL)
+	case cfg.Timeout <= 0:
+		return fmt.Errorf("metrics.cache.shared.timeout must be positive, got %s", cfg.Timeout)
+	case cfg.RetryInterval < 0:
+		return fmt.Errorf("metrics.cache.shared.retry-interval must not be negative, got %s", cfg.RetryInterval)
+	case len(cfg.KeyPrefix) > maxKeyPrefixLength:
+		return fmt.Errorf("metrics.cache.shared.key-prefix must be at most %d bytes long", maxKeyPrefixLength)
+	case strings.IndexFunc(cfg.KeyPrefix, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0:
+		// Memcached keys cannot hold whitespaces or control characters.
+		return fmt.Errorf("metrics.cache.shared.key-prefix must not contain whitespaces or control characters")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// maxIdleConns is the number of connections kept open to the cache server.
+const maxIdleConns = 8
+
+// backend is the protocol of a cache server. The values of get are in the
+// order of the keys, nil for the missing ones.
+type backend interface {
+	get(ctx context.Context, keys []string) ([][]byte, error)
+	set(ctx context.Context, keys []string, values [][]byte, ttl time.Duration) error
+	close()
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// connPool reuses the connections to the cache server. A connection is only
+// returned to the pool after a successful request, so that a connection left
+// in the middle of a reply is never reused.
+type connPool struct {
+	address string
+	// init is run on the new connections, e.g. to authenticate.
+	init func(*conn) error
+	idle chan *conn
+}
+
+func newConnPool(address string, init func(*conn) error) *connPool {
+	return &connPool{address: address, init: init, idle: make(chan *conn, maxIdleConns)}
+}
+
+func (p *connPool) get(ctx context.Context) (*conn, error) {
+	var c *conn
+	select {
+	case c = <-p.idle:
+	default:
+		var d net.Dialer
+		nc, err := d.DialContext(ctx, "tcp", p.address)
+		if err != nil {
+			return nil, err
+		}
+		c = &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+		if p.init != nil {
+			if deadline, ok := ctx.Deadline(); ok {
+				_ = c.SetDeadline(deadline)
+			}
+			if err := p.init(c); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.SetDeadline(deadline); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *connPool) put(c *conn) {
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (p *connPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
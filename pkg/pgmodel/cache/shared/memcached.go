@@ -0,0 +1,120 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedBackend speaks the get and set commands of the memcached text
+// protocol.
+type memcachedBackend struct {
+	pool *connPool
+}
+
+func newMemcachedBackend(address string) *memcachedBackend {
+	return &memcachedBackend{pool: newConnPool(address, nil)}
+}
+
+func (b *memcachedBackend) get(ctx context.Context, keys []string) ([][]byte, error) {
+	c, err := b.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.w.WriteString("get " + strings.Join(keys, " ") + "\r\n"); err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	found := make(map[string][]byte, len(keys))
+	for {
+		line, err := readLine(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if line == "END" {
+			break
+		}
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "VALUE" {
+			c.Close()
+			return nil, memcachedError(line)
+		}
+		n, err := strconv.Atoi(fields[3])
+		if err != nil || n < 0 {
+			c.Close()
+			return nil, fmt.Errorf("invalid memcached value length %q", line)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.Close()
+			return nil, err
+		}
+		found[fields[1]] = buf[:n]
+	}
+	b.pool.put(c)
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = found[key]
+	}
+	return values, nil
+}
+
+func (b *memcachedBackend) set(ctx context.Context, keys []string, values [][]byte, ttl time.Duration) error {
+	c, err := b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	// The set commands are pipelined, their replies are read once they are
+	// all sent.
+	exptime := int64(ttl / time.Second)
+	for i, key := range keys {
+		if _, err = fmt.Fprintf(c.w, "set %s 0 %d %d\r\n", key, exptime, len(values[i])); err == nil {
+			if _, err = c.w.Write(values[i]); err == nil {
+				_, err = c.w.WriteString("\r\n")
+			}
+		}
+		if err != nil {
+			c.Close()
+			return err
+		}
+	}
+	if err = c.w.Flush(); err != nil {
+		c.Close()
+		return err
+	}
+	for range keys {
+		line, err := readLine(c)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if line != "STORED" {
+			c.Close()
+			return memcachedError(line)
+		}
+	}
+	b.pool.put(c)
+	return nil
+}
+
+func (b *memcachedBackend) close() {
+	b.pool.close()
+}
+
+func memcachedError(line string) error {
+	return fmt.Errorf("unexpected memcached reply %q", line)
+}
@@ -0,0 +1,39 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	lookupsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "shared_lookups_total",
+			Help:      "Total number of series and label IDs looked up in the shared cache.",
+		}, []string{"type", "result"})
+	errorsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "shared_errors_total",
+			Help:      "Total number of failed requests to the shared cache.",
+		}, []string{"op"})
+	droppedWritesMetric = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "cache",
+			Name:      "shared_dropped_writes_total",
+			Help:      "Total number of IDs not written to the shared cache because it was unavailable or the write queue was full.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(lookupsMetric, errorsMetric, droppedWritesMetric)
+}
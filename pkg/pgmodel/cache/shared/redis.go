@@ -0,0 +1,187 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// redisBackend speaks the subset of the Redis protocol (RESP) used by the
+// shared cache: AUTH, MGET and SET with an expiration.
+type redisBackend struct {
+	pool *connPool
+}
+
+func newRedisBackend(address, password string) *redisBackend {
+	var init func(*conn) error
+	if password != "" {
+		init = func(c *conn) error {
+			if err := writeRedisCommand(c, "AUTH", []byte(password)); err != nil {
+				return err
+			}
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+			_, err := readRedisReply(c)
+			return err
+		}
+	}
+	return &redisBackend{pool: newConnPool(address, init)}
+}
+
+func (b *redisBackend) get(ctx context.Context, keys []string) ([][]byte, error) {
+	c, err := b.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, len(keys))
+	for i, key := range keys {
+		args[i] = []byte(key)
+	}
+	if err = writeRedisCommand(c, "MGET", args...); err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	reply, err := readRedisReply(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	values, ok := reply.([][]byte)
+	if !ok || len(values) != len(keys) {
+		c.Close()
+		return nil, fmt.Errorf("unexpected redis reply to MGET")
+	}
+	b.pool.put(c)
+	return values, nil
+}
+
+func (b *redisBackend) set(ctx context.Context, keys []string, values [][]byte, ttl time.Duration) error {
+	c, err := b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	// The SET commands are pipelined, their replies are read once they are
+	// all sent.
+	px := []byte(strconv.FormatInt(ttl.Milliseconds(), 10))
+	for i, key := range keys {
+		if err = writeRedisCommand(c, "SET", []byte(key), values[i], []byte("PX"), px); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	if err = c.w.Flush(); err != nil {
+		c.Close()
+		return err
+	}
+	for range keys {
+		if _, err = readRedisReply(c); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	b.pool.put(c)
+	return nil
+}
+
+func (b *redisBackend) close() {
+	b.pool.close()
+}
+
+func writeRedisCommand(c *conn, name string, args ...[]byte) error {
+	if _, err := fmt.Fprintf(c.w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(name), name); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(c.w, "$%d\r\n", len(arg)); err != nil {
+			return err
+		}
+		if _, err := c.w.Write(arg); err != nil {
+			return err
+		}
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRedisReply reads a reply. Simple strings and integers are returned as
+// strings, bulk strings as []byte (nil if missing) and arrays as [][]byte.
+// Error replies are returned as errors.
+func readRedisReply(c *conn) (interface{}, error) {
+	line, err := readLine(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case '$':
+		return readRedisBulk(c, line)
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array length %q", line)
+		}
+		values := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			line, err := readLine(c)
+			if err != nil {
+				return nil, err
+			}
+			if len(line) == 0 || line[0] != '$' {
+				return nil, fmt.Errorf("unexpected redis array element %q", line)
+			}
+			v, err := readRedisBulk(c, line)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
+
+func readRedisBulk(c *conn, line string) ([]byte, error) {
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis bulk string length %q", line)
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readLine reads a line terminated by \r\n, without its terminator.
+func readLine(c *conn) (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
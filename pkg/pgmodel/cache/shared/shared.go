@@ -0,0 +1,323 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package shared is a second-level cache of the series and label IDs, shared
+// by the Promscale replicas writing to the same database through Redis or
+// memcached. The series and labels missing from the local caches of a replica
+// are looked up in the shared cache before being resolved by the database, so
+// that the replicas restarted by a deploy do not all create the same series
+// concurrently.
+//
+// The entries hold the series epoch at which they were resolved, and the ones
+// older than the latest epoch seen by the replica are ignored, like the local
+// caches are reset when the epoch changes. A failed request is retried after
+// the retry interval, only the local caches are used meanwhile.
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+const (
+	// maxKeysPerRequest bounds the number of keys of a single get or set.
+	maxKeysPerRequest = 500
+	// writeQueueSize is the number of pending writes, the writes are dropped
+	// when the queue is full.
+	writeQueueSize = 1024
+
+	seriesKind = "series"
+	labelKind  = "label"
+	entrySize  = 16
+)
+
+// SeriesEntry is the ID of a series resolved at Epoch.
+type SeriesEntry struct {
+	ID    model.SeriesID
+	Epoch model.SeriesEpoch
+}
+
+// LabelEntry is the ID and position of a label of a metric resolved at Epoch.
+type LabelEntry struct {
+	ID    int32
+	Pos   int32
+	Epoch model.SeriesEpoch
+}
+
+type write struct {
+	kind   string
+	keys   []string
+	values [][]byte
+}
+
+// Cache is the shared cache. A nil Cache is disabled, its lookups always miss.
+type Cache struct {
+	cfg     Config
+	backend backend
+
+	// minEpoch is the latest series epoch seen, older entries are ignored.
+	minEpoch *atomic.Int64
+	// retryAt is the time, in unix nanoseconds, until which the shared cache
+	// is skipped after a failed request.
+	retryAt *atomic.Int64
+
+	writes chan write
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns the shared cache of cfg, nil if it is disabled. The connections
+// are opened on the first request.
+func New(cfg Config) (*Cache, error) {
+	var b backend
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case BackendRedis:
+		b = newRedisBackend(cfg.Address, cfg.Password)
+	case BackendMemcached:
+		b = newMemcachedBackend(cfg.Address)
+	default:
+		return nil, fmt.Errorf("unknown shared cache backend %q", cfg.Backend)
+	}
+	c := newCache(cfg, b)
+	log.Info("msg", "Using a shared cache for the series and label IDs", "backend", cfg.Backend, "address", cfg.Address, "key-prefix", cfg.KeyPrefix)
+	return c, nil
+}
+
+func newCache(cfg Config, b backend) *Cache {
+	c := &Cache{
+		cfg:      cfg,
+		backend:  b,
+		minEpoch: atomic.NewInt64(model.InvalidSeriesEpoch),
+		retryAt:  atomic.NewInt64(0),
+		writes:   make(chan write, writeQueueSize),
+		done:     make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.runWriter()
+	return c
+}
+
+// SeriesKey returns the key of the series with the labels names and values.
+func (c *Cache) SeriesKey(names, values []string) string {
+	h := sha256.New()
+	for i := range names {
+		writeKeyPart(h, names[i])
+		writeKeyPart(h, values[i])
+	}
+	return c.cfg.KeyPrefix + "s:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// LabelKey returns the key of the label name=value of metricName.
+func (c *Cache) LabelKey(metricName, name, value string) string {
+	h := sha256.New()
+	writeKeyPart(h, metricName)
+	writeKeyPart(h, name)
+	writeKeyPart(h, value)
+	return c.cfg.KeyPrefix + "l:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeKeyPart writes s followed by a byte that never appears in UTF-8, so
+// that the keys of different labels never collide.
+func writeKeyPart(h interface{ Write([]byte) (int, error) }, s string) {
+	_, _ = h.Write([]byte(s))
+	_, _ = h.Write([]byte{0xff})
+}
+
+// SetEpoch records the current series epoch, the entries resolved at an older
+// epoch are ignored from now on.
+func (c *Cache) SetEpoch(epoch model.SeriesEpoch) {
+	if c == nil {
+		return
+	}
+	for {
+		current := c.minEpoch.Load()
+		if int64(epoch) <= current || c.minEpoch.CAS(current, int64(epoch)) {
+			return
+		}
+	}
+}
+
+// GetSeries returns the entries of the series keys found in the cache.
+func (c *Cache) GetSeries(ctx context.Context, keys []string) map[string]SeriesEntry {
+	if c == nil {
+		return nil
+	}
+	found := make(map[string]SeriesEntry)
+	for i, v := range c.get(ctx, seriesKind, keys) {
+		if len(v) != entrySize {
+			continue
+		}
+		e := SeriesEntry{
+			ID:    model.SeriesID(binary.BigEndian.Uint64(v)),
+			Epoch: model.SeriesEpoch(binary.BigEndian.Uint64(v[8:])),
+		}
+		if c.valid(e.Epoch) {
+			found[keys[i]] = e
+		}
+	}
+	lookupsMetric.WithLabelValues(seriesKind, "hit").Add(float64(len(found)))
+	lookupsMetric.WithLabelValues(seriesKind, "miss").Add(float64(len(keys) - len(found)))
+	return found
+}
+
+// PutSeries queues the write of the series entries.
+func (c *Cache) PutSeries(keys []string, entries []SeriesEntry) {
+	if c == nil || len(keys) == 0 {
+		return
+	}
+	values := make([][]byte, len(entries))
+	for i, e := range entries {
+		v := make([]byte, entrySize)
+		binary.BigEndian.PutUint64(v, uint64(e.ID))
+		binary.BigEndian.PutUint64(v[8:], uint64(e.Epoch))
+		values[i] = v
+	}
+	c.put(write{kind: seriesKind, keys: keys, values: values})
+}
+
+// GetLabels returns the entries of the label keys found in the cache.
+func (c *Cache) GetLabels(ctx context.Context, keys []string) map[string]LabelEntry {
+	if c == nil {
+		return nil
+	}
+	found := make(map[string]LabelEntry)
+	for i, v := range c.get(ctx, labelKind, keys) {
+		if len(v) != entrySize {
+			continue
+		}
+		e := LabelEntry{
+			ID:    int32(binary.BigEndian.Uint32(v)),
+			Pos:   int32(binary.BigEndian.Uint32(v[4:])),
+			Epoch: model.SeriesEpoch(binary.BigEndian.Uint64(v[8:])),
+		}
+		if c.valid(e.Epoch) {
+			found[keys[i]] = e
+		}
+	}
+	lookupsMetric.WithLabelValues(labelKind, "hit").Add(float64(len(found)))
+	lookupsMetric.WithLabelValues(labelKind, "miss").Add(float64(len(keys) - len(found)))
+	return found
+}
+
+// PutLabels queues the write of the label entries.
+func (c *Cache) PutLabels(keys []string, entries []LabelEntry) {
+	if c == nil || len(keys) == 0 {
+		return
+	}
+	values := make([][]byte, len(entries))
+	for i, e := range entries {
+		v := make([]byte, entrySize)
+		binary.BigEndian.PutUint32(v, uint32(e.ID))
+		binary.BigEndian.PutUint32(v[4:], uint32(e.Pos))
+		binary.BigEndian.PutUint64(v[8:], uint64(e.Epoch))
+		values[i] = v
+	}
+	c.put(write{kind: labelKind, keys: keys, values: values})
+}
+
+// Close stops the pending writes and closes the connections.
+func (c *Cache) Close() {
+	if c == nil {
+		return
+	}
+	close(c.done)
+	c.wg.Wait()
+	c.backend.close()
+}
+
+func (c *Cache) valid(epoch model.SeriesEpoch) bool {
+	return int64(epoch) >= c.minEpoch.Load()
+}
+
+func (c *Cache) available() bool {
+	return time.Now().UnixNano() >= c.retryAt.Load()
+}
+
+func (c *Cache) failed(op string, err error) {
+	errorsMetric.WithLabelValues(op).Inc()
+	// Only the first error of every retry interval is logged.
+	if c.available() {
+		log.Warn("msg", "Shared cache request failed, using the local caches only", "op", op, "retry-interval", c.cfg.RetryInterval, "err", err)
+	}
+	c.retryAt.Store(time.Now().Add(c.cfg.RetryInterval).UnixNano())
+}
+
+// get returns the values of keys, nil if the request failed.
+func (c *Cache) get(ctx context.Context, kind string, keys []string) [][]byte {
+	if len(keys) == 0 || !c.available() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	values := make([][]byte, 0, len(keys))
+	for start := 0; start < len(keys); start += maxKeysPerRequest {
+		end := start + maxKeysPerRequest
+		if end > len(keys) {
+			end = len(keys)
+		}
+		v, err := c.backend.get(ctx, keys[start:end])
+		if err != nil {
+			c.failed("get", fmt.Errorf("get %s: %w", kind, err))
+			return nil
+		}
+		values = append(values, v...)
+	}
+	return values
+}
+
+func (c *Cache) put(w write) {
+	select {
+	case <-c.done:
+	case c.writes <- w:
+	default:
+		droppedWritesMetric.Add(float64(len(w.keys)))
+	}
+}
+
+// runWriter writes the queued entries, so that the ingest never waits for the
+// shared cache.
+func (c *Cache) runWriter() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.done:
+			return
+		case w := <-c.writes:
+			if !c.available() {
+				droppedWritesMetric.Add(float64(len(w.keys)))
+				continue
+			}
+			if err := c.set(w); err != nil {
+				c.failed("set", fmt.Errorf("set %s: %w", w.kind, err))
+			}
+		}
+	}
+}
+
+func (c *Cache) set(w write) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	for start := 0; start < len(w.keys); start += maxKeysPerRequest {
+		end := start + maxKeysPerRequest
+		if end > len(w.keys) {
+			end = len(w.keys)
+		}
+		if err := c.backend.set(ctx, w.keys[start:end], w.values[start:end], c.cfg.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
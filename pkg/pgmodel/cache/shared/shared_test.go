@@ -0,0 +1,256 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shared
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+// fakeServer is an in-memory cache server speaking enough of the Redis or
+// memcached protocols for the tests.
+type fakeServer struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	password string
+	listener net.Listener
+}
+
+func newFakeServer(t *testing.T, backend, password string) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{values: make(map[string][]byte), password: password, listener: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if backend == BackendRedis {
+				go s.serveRedis(c)
+			} else {
+				go s.serveMemcached(c)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serveRedis(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authenticated := s.password == ""
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var l int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &l); err != nil {
+				return
+			}
+			buf := make([]byte, l+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:l])
+		}
+		s.mu.Lock()
+		switch {
+		case args[0] == "AUTH" && args[1] == s.password:
+			authenticated = true
+			fmt.Fprint(c, "+OK\r\n")
+		case !authenticated:
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "MGET":
+			fmt.Fprintf(c, "*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				if v, ok := s.values[key]; ok {
+					fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+				} else {
+					fmt.Fprint(c, "$-1\r\n")
+				}
+			}
+		case args[0] == "SET" && len(args) == 5 && args[3] == "PX":
+			s.values[args[1]] = []byte(args[2])
+			fmt.Fprint(c, "+OK\r\n")
+		default:
+			fmt.Fprint(c, "-ERR unknown command\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeServer) serveMemcached(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		s.mu.Lock()
+		switch {
+		case len(fields) > 1 && fields[0] == "get":
+			for _, key := range fields[1:] {
+				if v, ok := s.values[key]; ok {
+					fmt.Fprintf(c, "VALUE %s 0 %d\r\n%s\r\n", key, len(v), v)
+				}
+			}
+			fmt.Fprint(c, "END\r\n")
+		case len(fields) == 5 && fields[0] == "set":
+			n, _ := strconv.Atoi(fields[4])
+			buf := make([]byte, n+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			s.values[fields[1]] = buf[:n]
+			fmt.Fprint(c, "STORED\r\n")
+		default:
+			fmt.Fprint(c, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeServer) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values)
+}
+
+func testConfig(backend, address string) Config {
+	return Config{
+		Backend:       backend,
+		Address:       address,
+		TTL:           time.Minute,
+		KeyPrefix:     "test:",
+		Timeout:       time.Second,
+		RetryInterval: time.Minute,
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, backend := range []string{BackendRedis, BackendMemcached} {
+		t.Run(backend, func(t *testing.T) {
+			password := ""
+			if backend == BackendRedis {
+				password = "secret"
+			}
+			server := newFakeServer(t, backend, password)
+			cfg := testConfig(backend, server.listener.Addr().String())
+			cfg.Password = password
+			c, err := New(cfg)
+			require.NoError(t, err)
+			defer c.Close()
+			ctx := context.Background()
+
+			seriesKeys := []string{
+				c.SeriesKey([]string{"__name__", "job"}, []string{"up", "a"}),
+				c.SeriesKey([]string{"__name__", "job"}, []string{"up", "b"}),
+			}
+			labelKeys := []string{c.LabelKey("up", "job", "a"), c.LabelKey("up", "job", "b")}
+			require.Empty(t, c.GetSeries(ctx, seriesKeys))
+
+			c.SetEpoch(2)
+			c.PutSeries(seriesKeys[:1], []SeriesEntry{{ID: 10, Epoch: 2}})
+			c.PutLabels(labelKeys, []LabelEntry{{ID: 1, Pos: 2, Epoch: 2}, {ID: 3, Pos: 2, Epoch: 1}})
+			require.Eventually(t, func() bool { return server.len() == 3 }, 5*time.Second, 10*time.Millisecond)
+
+			require.Equal(t, map[string]SeriesEntry{seriesKeys[0]: {ID: 10, Epoch: 2}}, c.GetSeries(ctx, seriesKeys))
+			// The entries of older epochs are ignored.
+			require.Equal(t, map[string]LabelEntry{labelKeys[0]: {ID: 1, Pos: 2, Epoch: 2}}, c.GetLabels(ctx, labelKeys))
+			c.SetEpoch(3)
+			require.Empty(t, c.GetSeries(ctx, seriesKeys))
+			// The epoch never decreases.
+			c.SetEpoch(1)
+			require.Empty(t, c.GetLabels(ctx, labelKeys))
+		})
+	}
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	l.Close()
+
+	c, err := New(testConfig(BackendRedis, address))
+	require.NoError(t, err)
+	defer c.Close()
+
+	keys := []string{c.SeriesKey([]string{"__name__"}, []string{"up"})}
+	require.True(t, c.available())
+	require.Empty(t, c.GetSeries(context.Background(), keys))
+	// The shared cache is skipped until the retry interval passes.
+	require.False(t, c.available())
+	require.Empty(t, c.GetSeries(context.Background(), keys))
+	c.PutSeries(keys, []SeriesEntry{{ID: 1, Epoch: 1}})
+}
+
+func TestNilCache(t *testing.T) {
+	c, err := New(Config{})
+	require.NoError(t, err)
+	require.Nil(t, c)
+	c.SetEpoch(model.SeriesEpoch(1))
+	require.Empty(t, c.GetSeries(context.Background(), []string{"a"}))
+	require.Empty(t, c.GetLabels(context.Background(), []string{"a"}))
+	c.PutSeries([]string{"a"}, []SeriesEntry{{ID: 1}})
+	c.Close()
+}
+
+func TestKeys(t *testing.T) {
+	c := &Cache{cfg: Config{KeyPrefix: "p:"}}
+	a := c.SeriesKey([]string{"__name__", "job"}, []string{"up", "ab"})
+	require.True(t, strings.HasPrefix(a, "p:s:"))
+	require.Equal(t, a, c.SeriesKey([]string{"__name__", "job"}, []string{"up", "ab"}))
+	require.NotEqual(t, a, c.SeriesKey([]string{"__name__", "joba"}, []string{"up", "b"}))
+	require.NotEqual(t, c.LabelKey("up", "job", "a"), c.LabelKey("up", "joba", ""))
+	require.True(t, strings.HasPrefix(c.LabelKey("up", "job", "a"), "p:l:"))
+}
+
+func TestValidate(t *testing.T) {
+	valid := testConfig(BackendMemcached, "localhost:11211")
+	require.NoError(t, Validate(&valid))
+	require.NoError(t, Validate(&Config{}))
+
+	testCases := []struct {
+		name   string
+		update func(*Config)
+	}{
+		{name: "unknown backend", update: func(c *Config) { c.Backend = "etcd" }},
+		{name: "no address", update: func(c *Config) { c.Address = "" }},
+		{name: "memcached password", update: func(c *Config) { c.Password = "secret" }},
+		{name: "short ttl", update: func(c *Config) { c.TTL = time.Millisecond }},
+		{name: "long memcached ttl", update: func(c *Config) { c.TTL = 31 * 24 * time.Hour }},
+		{name: "no timeout", update: func(c *Config) { c.Timeout = 0 }},
+		{name: "space in prefix", update: func(c *Config) { c.KeyPrefix = "a b" }},
+		{name: "long prefix", update: func(c *Config) { c.KeyPrefix = strings.Repeat("a", maxKeyPrefixLength+1) }},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := valid
+			c.update(&cfg)
+			require.Error(t, Validate(&cfg))
+		})
+	}
+}
@@ -215,16 +215,9 @@ func MigrateExtension(conn *pgx.Conn, extName string, extSchemaName string, vali
 	}
 
 	if !isInstalled {
-		var query string
-		switch extName {
-		case "timescaledb":
-			query = fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s VERSION '%s'",
-				extName, extSchemaName, getSqlVersion(*newVersion, extName))
-		case "promscale":
-			query = fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s VERSION '%s'",
-				extName, getSqlVersion(*newVersion, extName))
-		default:
-			return fmt.Errorf("unknown extension: %s", extName)
+		query, err := createStatement(extName, extSchemaName, *newVersion)
+		if err != nil {
+			return err
 		}
 		_, extErr := conn.Exec(context.Background(), query)
 		if extErr != nil {
@@ -260,9 +253,7 @@ func MigrateExtension(conn *pgx.Conn, extName string, extSchemaName string, vali
 			}
 			defer func() { _ = connAlter.Close(context.Background()) }()
 		}
-		_, err := connAlter.Exec(context.Background(),
-			fmt.Sprintf("ALTER EXTENSION %s UPDATE TO '%s'", extName,
-				getSqlVersion(*newVersion, extName)))
+		_, err := connAlter.Exec(context.Background(), alterStatement(extName, *newVersion))
 		// if migration fails, Do not crash just log an error. As there is an extension already present.
 		if err != nil {
 			if !validRange(*currentVersion) {
@@ -279,6 +270,48 @@ func MigrateExtension(conn *pgx.Conn, extName string, extSchemaName string, vali
 	return nil
 }
 
+// PlanExtension returns the statement MigrateExtension would run to install
+// or update the extension, or an empty string if it would be left as it is.
+func PlanExtension(conn *pgx.Conn, extName string, extSchemaName string, validRange semver.Range, rangeString string, extOptions ExtensionMigrateOptions) (string, error) {
+	currentVersion, newVersion, err := extensionVersions(conn, extName, validRange, rangeString, extOptions)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case currentVersion == nil && extOptions.Install:
+		return createStatement(extName, extSchemaName, *newVersion)
+	case currentVersion != nil && extOptions.Upgrade && currentVersion.LT(*newVersion):
+		return alterStatement(extName, *newVersion), nil
+	}
+	return "", nil
+}
+
+// UpdateStatement returns the statement updating the installed extension to
+// the version MigrateExtension would update it to.
+func UpdateStatement(conn *pgx.Conn, extName string, validRange semver.Range, rangeString string, extOptions ExtensionMigrateOptions) (string, error) {
+	_, newVersion, err := extensionVersions(conn, extName, validRange, rangeString, extOptions)
+	if err != nil {
+		return "", err
+	}
+	return alterStatement(extName, *newVersion), nil
+}
+
+func createStatement(extName string, extSchemaName string, v semver.Version) (string, error) {
+	switch extName {
+	case "timescaledb":
+		return fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s VERSION '%s'",
+			extName, extSchemaName, getSqlVersion(v, extName)), nil
+	case "promscale":
+		return fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s VERSION '%s'",
+			extName, getSqlVersion(v, extName)), nil
+	}
+	return "", fmt.Errorf("unknown extension: %s", extName)
+}
+
+func alterStatement(extName string, v semver.Version) string {
+	return fmt.Sprintf("ALTER EXTENSION %s UPDATE TO '%s'", extName, getSqlVersion(v, extName))
+}
+
 func AreSupportedPromscaleExtensionVersionsAvailable(conn *pgx.Conn, extOptions ExtensionMigrateOptions) (bool, error) {
 	_, _, err := extensionVersions(conn, "promscale", version.ExtVersionRange, version.ExtVersionRangeString, extOptions)
 	return err == nil, err
@@ -15,9 +15,13 @@ const (
 
 	PromDataSeries = "prom_data_series"
 	PsTrace        = "_ps_trace"
+	PsLog          = "_ps_log"
 )
 
 var (
-	PromDataColumns     = []string{"time", "value", "series_id"}
-	PromExemplarColumns = []string{"time", "series_id", "exemplar_label_values", "value"}
+	PromDataColumns = []string{"time", "value", "series_id"}
+	// PromDataTenantColumns are the columns of the metrics partitioned by
+	// tenant.
+	PromDataTenantColumns = []string{"time", "value", "series_id", "tenant"}
+	PromExemplarColumns   = []string{"time", "series_id", "exemplar_label_values", "value"}
 )
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
@@ -16,15 +17,41 @@ import (
 	"github.com/timescale/promscale/pkg/pgxconn"
 )
 
-const queryDeleteSeries = "SELECT _prom_catalog.delete_series_from_metric($1, $2)"
+const (
+	queryDeleteSeries = "SELECT _prom_catalog.delete_series_from_metric($1, $2)"
+	queryMetricTable  = "SELECT table_name FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)"
+	// The compressed chunks holding data in the time range are decompressed
+	// before deleting it. They are compressed again by the compression job.
+	queryCompressedChunksInRange = `SELECT chunk_schema, chunk_name
+	FROM timescaledb_information.chunks
+	WHERE hypertable_schema = $1 AND hypertable_name = $2 AND is_compressed
+		AND range_start <= $4 AND range_end > $3`
+	queryDecompressChunk        = "SELECT _prom_catalog.decompress_chunk_for_metric($1, $2, $3)"
+	queryTimescaleDBInstalled   = "SELECT _prom_catalog.is_timescaledb_installed()"
+	queryDeleteSeriesInRangeFmt = "DELETE FROM %s WHERE series_id = ANY($1) AND time >= $2 AND time <= $3"
+
+	queryCountTombstones = "SELECT count(*) FROM _prom_catalog.series WHERE delete_epoch IS NOT NULL"
+	queryTombstoneTables = `SELECT m.table_schema, m.table_name, m.series_table
+	FROM _prom_catalog.metric m
+	WHERE NOT m.is_view AND EXISTS (
+		SELECT 1 FROM _prom_catalog.series s WHERE s.metric_id = m.id AND s.delete_epoch IS NOT NULL
+	)`
+	queryEpoch               = "SELECT current_epoch, last_update_time FROM _prom_catalog.ids_epoch LIMIT 1"
+	queryDeleteExpiredSeries = "SELECT _prom_catalog.delete_expired_series($1, $2, $3, now(), $4, $5)"
+)
 
 // PgDelete deletes the series based on matchers.
 type PgDelete struct {
 	Conn pgxconn.PgxConn
 }
 
-// DeleteSeries deletes the series that matches the provided label_matchers.
-func (pgDel *PgDelete) DeleteSeries(ctx context.Context, matchers []*labels.Matcher, _, _ time.Time) ([]string, []model.SeriesID, int, error) {
+// DeleteSeries deletes the data of the series that match the provided label_matchers
+// between start and end. The series are tombstoned if the time range is unbounded,
+// they are removed from the catalog by CleanTombstones or the maintenance jobs.
+func (pgDel *PgDelete) DeleteSeries(ctx context.Context, matchers []*labels.Matcher, start, end time.Time) ([]string, []model.SeriesID, int, error) {
+	if start != model.MinTime || end != model.MaxTime {
+		return pgDel.deleteSeriesInRange(ctx, matchers, start, end)
+	}
 	var (
 		deletedSeriesIDs []model.SeriesID
 		totalRowsDeleted int
@@ -55,6 +82,141 @@ func (pgDel *PgDelete) DeleteSeries(ctx context.Context, matchers []*labels.Matc
 	return getKeys(metricsTouched), deletedSeriesIDs, totalRowsDeleted, nil
 }
 
+// CountSeries returns the metrics and the series that match the provided
+// label_matchers, without deleting them.
+func (pgDel *PgDelete) CountSeries(ctx context.Context, matchers []*labels.Matcher) ([]string, []model.SeriesID, error) {
+	metricNames, seriesIDMatrix, err := getMetricNameSeriesIDFromMatchers(ctx, pgDel.Conn, matchers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("count-series: %w", err)
+	}
+	var seriesIDs []model.SeriesID
+	for _, ids := range seriesIDMatrix {
+		seriesIDs = append(seriesIDs, ids...)
+	}
+	return metricNames, seriesIDs, nil
+}
+
+func (pgDel *PgDelete) deleteSeriesInRange(ctx context.Context, matchers []*labels.Matcher, start, end time.Time) ([]string, []model.SeriesID, int, error) {
+	var (
+		deletedSeriesIDs []model.SeriesID
+		totalRowsDeleted int
+		metricsTouched   []string
+		timescaleDB      bool
+	)
+	metricNames, seriesIDMatrix, err := getMetricNameSeriesIDFromMatchers(ctx, pgDel.Conn, matchers)
+	if err != nil {
+		return nil, nil, -1, fmt.Errorf("delete-series: %w", err)
+	}
+	if err = pgDel.Conn.QueryRow(ctx, queryTimescaleDBInstalled).Scan(&timescaleDB); err != nil {
+		return nil, nil, -1, fmt.Errorf("delete-series: checking for timescaledb: %w", err)
+	}
+	for metricIndex, metricName := range metricNames {
+		seriesIDs := seriesIDMatrix[metricIndex]
+		rowsDeleted, err := pgDel.deleteMetricRange(ctx, metricName, seriesIDs, start, end, timescaleDB)
+		if err != nil {
+			return metricsTouched, deletedSeriesIDs, totalRowsDeleted, fmt.Errorf("deleting series with metric_name=%s and series_ids=%v between %s and %s: %w", metricName, seriesIDs, start, end, err)
+		}
+		metricsTouched = append(metricsTouched, metricName)
+		deletedSeriesIDs = append(deletedSeriesIDs, seriesIDs...)
+		totalRowsDeleted += rowsDeleted
+	}
+	return metricsTouched, deletedSeriesIDs, totalRowsDeleted, nil
+}
+
+func (pgDel *PgDelete) deleteMetricRange(ctx context.Context, metricName string, seriesIDs []model.SeriesID, start, end time.Time, timescaleDB bool) (int, error) {
+	var tableName string
+	if err := pgDel.Conn.QueryRow(ctx, queryMetricTable, schema.PromData, metricName).Scan(&tableName); err != nil {
+		return 0, fmt.Errorf("getting metric table: %w", err)
+	}
+	if timescaleDB {
+		if err := pgDel.decompressChunksInRange(ctx, tableName, start, end); err != nil {
+			return 0, err
+		}
+	}
+	table := pgx.Identifier{schema.PromData, tableName}.Sanitize()
+	tag, err := pgDel.Conn.Exec(ctx, fmt.Sprintf(queryDeleteSeriesInRangeFmt, table), convertSeriesIDsToInt64s(seriesIDs), start, end)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (pgDel *PgDelete) decompressChunksInRange(ctx context.Context, tableName string, start, end time.Time) error {
+	rows, err := pgDel.Conn.Query(ctx, queryCompressedChunksInRange, schema.PromData, tableName, start, end)
+	if err != nil {
+		return fmt.Errorf("getting compressed chunks: %w", err)
+	}
+	defer rows.Close()
+	var chunks [][2]string
+	for rows.Next() {
+		var chunkSchema, chunkName string
+		if err = rows.Scan(&chunkSchema, &chunkName); err != nil {
+			return fmt.Errorf("getting compressed chunks: %w", err)
+		}
+		chunks = append(chunks, [2]string{chunkSchema, chunkName})
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("getting compressed chunks: %w", err)
+	}
+	rows.Close()
+
+	for _, chunk := range chunks {
+		if _, err = pgDel.Conn.Exec(ctx, queryDecompressChunk, tableName, chunk[0], chunk[1]); err != nil {
+			return fmt.Errorf("decompressing chunk %s.%s: %w", chunk[0], chunk[1], err)
+		}
+	}
+	return nil
+}
+
+// CleanTombstones removes the tombstoned series from the catalog, along with
+// the labels that are not used anymore. It returns the number of tombstones
+// before and after cleaning. Like the maintenance jobs, a series is only
+// removed once every ingestor stopped using its ID, so recently tombstoned
+// series are kept until the series epoch advances.
+func (pgDel *PgDelete) CleanTombstones(ctx context.Context) (before, after int64, err error) {
+	if err = pgDel.Conn.QueryRow(ctx, queryCountTombstones).Scan(&before); err != nil {
+		return 0, 0, fmt.Errorf("clean-tombstones: counting tombstones: %w", err)
+	}
+	if before == 0 {
+		return 0, 0, nil
+	}
+	var (
+		presentEpoch int64
+		lastUpdated  time.Time
+	)
+	if err = pgDel.Conn.QueryRow(ctx, queryEpoch).Scan(&presentEpoch, &lastUpdated); err != nil {
+		return before, before, fmt.Errorf("clean-tombstones: reading epoch: %w", err)
+	}
+
+	rows, err := pgDel.Conn.Query(ctx, queryTombstoneTables)
+	if err != nil {
+		return before, before, fmt.Errorf("clean-tombstones: %w", err)
+	}
+	defer rows.Close()
+	var tables [][3]string
+	for rows.Next() {
+		var metricSchema, metricTable, seriesTable string
+		if err = rows.Scan(&metricSchema, &metricTable, &seriesTable); err != nil {
+			return before, before, fmt.Errorf("clean-tombstones: %w", err)
+		}
+		tables = append(tables, [3]string{metricSchema, metricTable, seriesTable})
+	}
+	if err = rows.Err(); err != nil {
+		return before, before, fmt.Errorf("clean-tombstones: %w", err)
+	}
+	rows.Close()
+
+	for _, t := range tables {
+		if _, err = pgDel.Conn.Exec(ctx, queryDeleteExpiredSeries, t[0], t[1], t[2], presentEpoch, lastUpdated); err != nil {
+			return before, before, fmt.Errorf("clean-tombstones: deleting expired series of %s.%s: %w", t[0], t[1], err)
+		}
+	}
+	if err = pgDel.Conn.QueryRow(ctx, queryCountTombstones).Scan(&after); err != nil {
+		return before, before, fmt.Errorf("clean-tombstones: counting tombstones: %w", err)
+	}
+	return before, after, nil
+}
+
 // getMetricNameSeriesIDFromMatchers returns the metric name list and the corresponding series ID array
 // as a matrix.
 func getMetricNameSeriesIDFromMatchers(ctx context.Context, conn pgxconn.PgxConn, matchers []*labels.Matcher) ([]string, [][]model.SeriesID, error) {
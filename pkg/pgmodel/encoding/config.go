@@ -0,0 +1,109 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package encoding
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the value encoding flags.
+type Config struct {
+	EncodingsFile string
+}
+
+// ParseFlags registers the value encoding flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.EncodingsFile, "metrics.value-encodings-file", "", "Path to a YAML file selecting the metrics whose samples are stored with an alternate encoding, "+
+		"e.g. boolean metrics as smallint. Encodings apply to the metric tables that are empty when the connector first writes to them. No encoding is applied if empty.")
+	return cfg
+}
+
+// Validate checks that the encodings file, if any, can be loaded.
+func Validate(cfg *Config) error {
+	if cfg.EncodingsFile == "" {
+		return nil
+	}
+	_, err := loadRules(cfg.EncodingsFile)
+	return err
+}
+
+// encodingsFile is the format of the encodings file, e.g.
+//
+//	encodings:
+//	  - metrics: kube_.*_status_.*
+//	    encoding: boolean
+//	  - type: stateset
+//	    encoding: boolean
+//
+// The first rule matching a metric selects its encoding.
+type encodingsFile struct {
+	Encodings []ruleConfig `yaml:"encodings"`
+}
+
+type ruleConfig struct {
+	// Metrics is a regular expression matching the whole metric name.
+	Metrics string `yaml:"metrics"`
+	// Type matches the type of the metric in the metadata sent by Prometheus.
+	Type     string `yaml:"type"`
+	Encoding string `yaml:"encoding"`
+}
+
+type rule struct {
+	metrics    *regexp.Regexp
+	metricType string
+	encoder    Encoder
+}
+
+func loadRules(path string) ([]rule, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading value encodings file: %w", err)
+	}
+	return parseRules(contents)
+}
+
+func parseRules(contents []byte) ([]rule, error) {
+	var f encodingsFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return nil, fmt.Errorf("parsing value encodings file: %w", err)
+	}
+	rules := make([]rule, 0, len(f.Encodings))
+	for i, c := range f.Encodings {
+		r, err := c.rule()
+		if err != nil {
+			return nil, fmt.Errorf("invalid value encoding rule at position %d: %w", i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (c ruleConfig) rule() (rule, error) {
+	var r rule
+	if (c.Metrics == "") == (c.Type == "") {
+		return r, fmt.Errorf("exactly one of metrics and type must be set")
+	}
+	encoder, ok := Lookup(c.Encoding)
+	if !ok {
+		return r, fmt.Errorf("unknown encoding %q", c.Encoding)
+	}
+	r.encoder = encoder
+	if c.Metrics != "" {
+		re, err := regexp.Compile("^(?:" + c.Metrics + ")$")
+		if err != nil {
+			return r, fmt.Errorf("invalid metrics regex: %w", err)
+		}
+		r.metrics = re
+	}
+	// The types are stored as the names of the remote write enum, e.g. STATESET.
+	r.metricType = strings.ToUpper(c.Type)
+	return r, nil
+}
@@ -0,0 +1,138 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package encoding stores the samples of selected metrics in a narrower
+// column type than double precision, e.g. boolean or state metrics as
+// smallint, to reduce the storage of well-known low-entropy metrics.
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/value"
+
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// defaultColumnType is the type of the value column of the metric tables
+// created by the database.
+const defaultColumnType = "double precision"
+
+// DroppedSamples counts the samples that cannot be represented by the
+// encoding of their metric.
+var DroppedSamples = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "value_encoding",
+		Name:      "dropped_samples_total",
+		Help:      "Total number of samples dropped because their value cannot be represented by the encoding of their metric.",
+	}, []string{"encoding"},
+)
+
+func init() {
+	prometheus.MustRegister(DroppedSamples)
+}
+
+// Encoder converts sample values to the column type a metric is stored with.
+type Encoder interface {
+	// Name identifies the encoder in the encodings file.
+	Name() string
+	// ColumnType is the SQL type of the value column of the metric tables,
+	// as returned by format_type().
+	ColumnType() string
+	// Encode returns the value to insert in the column, or false if the
+	// value cannot be represented, in which case the sample is dropped. It
+	// is not called with the staleness markers, see Value.
+	Encode(v float64) (interface{}, bool)
+}
+
+// Value returns the value to insert for a sample in the value column of an
+// encoder, or false if the sample is dropped. The staleness markers are
+// stored as NULL, since no encoding but double precision can represent them.
+func Value(e Encoder, v float64) (interface{}, bool) {
+	if value.IsStaleNaN(v) {
+		return nil, true
+	}
+	return e.Encode(v)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Encoder)
+)
+
+// Register makes an encoder available to the encodings file. It panics if an
+// encoder with the same name is already registered.
+func Register(e Encoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[e.Name()]; ok {
+		panic(fmt.Sprintf("encoding: encoder %q registered twice", e.Name()))
+	}
+	registry[e.Name()] = e
+}
+
+// Lookup returns the registered encoder with the given name.
+func Lookup(name string) (Encoder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	e, ok := registry[name]
+	return e, ok
+}
+
+// forColumnType returns an encoder for a column type. The encoder named as
+// the type is preferred, since other encoders may only accept some values.
+func forColumnType(columnType string) (Encoder, bool) {
+	if e, ok := Lookup(columnType); ok && e.ColumnType() == columnType {
+		return e, true
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, e := range registry {
+		if e.ColumnType() == columnType {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func init() {
+	Register(integerEncoder{name: "smallint", columnType: "smallint", min: math.MinInt16, max: math.MaxInt16})
+	Register(integerEncoder{name: "integer", columnType: "integer", min: math.MinInt32, max: math.MaxInt32})
+	Register(integerEncoder{name: "boolean", columnType: "smallint", min: 0, max: 1})
+	Register(realEncoder{})
+}
+
+// integerEncoder stores the integral values within [min, max].
+type integerEncoder struct {
+	name, columnType string
+	min, max         float64
+}
+
+func (e integerEncoder) Name() string       { return e.name }
+func (e integerEncoder) ColumnType() string { return e.columnType }
+
+func (e integerEncoder) Encode(v float64) (interface{}, bool) {
+	// NaN fails the comparisons.
+	if !(v >= e.min && v <= e.max) || v != math.Trunc(v) {
+		return nil, false
+	}
+	if e.columnType == "integer" {
+		return int32(v), true
+	}
+	return int16(v), true
+}
+
+// realEncoder stores the values with single precision.
+type realEncoder struct{}
+
+func (realEncoder) Name() string       { return "real" }
+func (realEncoder) ColumnType() string { return "real" }
+
+func (realEncoder) Encode(v float64) (interface{}, bool) {
+	return float32(v), true
+}
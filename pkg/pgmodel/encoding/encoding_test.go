@@ -0,0 +1,183 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package encoding
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		encoding string
+		value    float64
+		expected interface{}
+		ok       bool
+	}{
+		{encoding: "boolean", value: 1, expected: int16(1), ok: true},
+		{encoding: "boolean", value: 0, expected: int16(0), ok: true},
+		{encoding: "boolean", value: 2},
+		{encoding: "boolean", value: math.NaN()},
+		{encoding: "smallint", value: -3, expected: int16(-3), ok: true},
+		{encoding: "smallint", value: 1.5},
+		{encoding: "smallint", value: math.MaxInt16 + 1},
+		{encoding: "smallint", value: math.NaN()},
+		{encoding: "integer", value: math.MaxInt16 + 1, expected: int32(math.MaxInt16 + 1), ok: true},
+		{encoding: "integer", value: math.Inf(1)},
+		{encoding: "real", value: 1.5, expected: float32(1.5), ok: true},
+	}
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s %v", c.encoding, c.value), func(t *testing.T) {
+			e, ok := Lookup(c.encoding)
+			require.True(t, ok)
+			v, ok := e.Encode(c.value)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.expected, v)
+		})
+	}
+
+	// The staleness markers are stored as NULL with every encoding.
+	for _, name := range []string{"boolean", "smallint", "integer", "real"} {
+		e, ok := Lookup(name)
+		require.True(t, ok)
+		v, ok := Value(e, math.Float64frombits(value.StaleNaN))
+		require.True(t, ok, name)
+		require.Nil(t, v, name)
+		v, ok = Value(e, 1)
+		require.True(t, ok, name)
+		require.NotNil(t, v, name)
+	}
+
+	// The generic encoder of a type is preferred.
+	e, ok := forColumnType("smallint")
+	require.True(t, ok)
+	require.Equal(t, "smallint", e.Name())
+	_, ok = forColumnType("text")
+	require.False(t, ok)
+}
+
+func TestParseRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		rules    int
+		err      bool
+	}{
+		{name: "empty"},
+		{
+			name:     "rules",
+			contents: "encodings:\n  - metrics: kube_.*_status_.*\n    encoding: boolean\n  - type: stateset\n    encoding: boolean\n",
+			rules:    2,
+		},
+		{name: "unknown encoding", contents: "encodings:\n  - metrics: up\n    encoding: bit\n", err: true},
+		{name: "no selector", contents: "encodings:\n  - encoding: boolean\n", err: true},
+		{name: "two selectors", contents: "encodings:\n  - metrics: up\n    type: gauge\n    encoding: boolean\n", err: true},
+		{name: "invalid regex", contents: "encodings:\n  - metrics: (up\n    encoding: boolean\n", err: true},
+		{name: "unknown field", contents: "encodings:\n  - metric: up\n    encoding: boolean\n", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := parseRules([]byte(c.contents))
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, rules, c.rules)
+		})
+	}
+}
+
+func TestForMetric(t *testing.T) {
+	rules, err := parseRules([]byte("encodings:\n  - metrics: up\n    encoding: boolean\n  - type: stateset\n    encoding: smallint\n"))
+	require.NoError(t, err)
+	r := &Resolver{rules: rules}
+	info := model.MetricInfo{TableSchema: "prom_data", TableName: "up"}
+	columnType := model.SqlQuery{Sql: columnTypeSQL, Args: []interface{}{"prom_data", "up"}, Results: model.RowResults{{"double precision"}}}
+
+	testCases := []struct {
+		name     string
+		metric   string
+		queries  []model.SqlQuery
+		expected string
+	}{
+		{
+			name:   "empty table converted",
+			metric: "up",
+			queries: []model.SqlQuery{
+				columnType,
+				{Sql: setValueTypeSQL, Args: []interface{}{"up", "smallint"}, Results: model.RowResults{{true}}},
+			},
+			expected: "boolean",
+		},
+		{
+			name:   "table with data kept",
+			metric: "up",
+			queries: []model.SqlQuery{
+				columnType,
+				{Sql: setValueTypeSQL, Args: []interface{}{"up", "smallint"}, Results: model.RowResults{{false}}},
+			},
+		},
+		{
+			name:   "already converted",
+			metric: "up",
+			queries: []model.SqlQuery{
+				{Sql: columnTypeSQL, Args: []interface{}{"prom_data", "up"}, Results: model.RowResults{{"smallint"}}},
+			},
+			expected: "boolean",
+		},
+		{
+			name:   "encoding removed after conversion",
+			metric: "other",
+			queries: []model.SqlQuery{
+				{Sql: metricTypeSQL, Args: []interface{}{"other"}, Err: pgx.ErrNoRows},
+				{Sql: columnTypeSQL, Args: []interface{}{"prom_data", "up"}, Results: model.RowResults{{"smallint"}}},
+			},
+			expected: "smallint",
+		},
+		{
+			name:   "type from metadata",
+			metric: "state",
+			queries: []model.SqlQuery{
+				{Sql: metricTypeSQL, Args: []interface{}{"state"}, Results: model.RowResults{{"STATESET"}}},
+				{Sql: columnTypeSQL, Args: []interface{}{"prom_data", "up"}, Results: model.RowResults{{"smallint"}}},
+			},
+			expected: "smallint",
+		},
+		{
+			name:   "no encoding",
+			metric: "other",
+			queries: []model.SqlQuery{
+				{Sql: metricTypeSQL, Args: []interface{}{"other"}, Results: model.RowResults{{"GAUGE"}}},
+				columnType,
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder(c.queries, t)
+			e, err := r.ForMetric(context.Background(), conn, c.metric, info)
+			require.NoError(t, err)
+			if c.expected == "" {
+				require.Nil(t, e)
+				return
+			}
+			require.Equal(t, c.expected, e.Name())
+		})
+	}
+
+	var nilResolver *Resolver
+	e, err := nilResolver.ForMetric(context.Background(), nil, "up", info)
+	require.NoError(t, err)
+	require.Nil(t, e)
+}
@@ -0,0 +1,117 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package encoding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	metricTypeSQL = "SELECT type FROM _prom_catalog.metadata WHERE metric_family = $1 ORDER BY last_seen DESC LIMIT 1"
+	columnTypeSQL = `SELECT format_type(a.atttypid, a.atttypmod)
+FROM pg_attribute a
+WHERE a.attrelid = format('%I.%I', $1::text, $2::text)::regclass AND a.attname = 'value'`
+	// The value column is converted by the database, which locks the metric
+	// table and converts it only if it is empty.
+	setValueTypeSQL = "SELECT _prom_catalog.set_metric_value_type($1, $2)"
+)
+
+// Resolver selects the encoding of the metrics from the rules of the
+// encodings file. A nil Resolver does not encode anything.
+type Resolver struct {
+	rules []rule
+}
+
+// NewResolver returns a Resolver with the rules of the encodings file of
+// cfg, or nil if no file is configured.
+func NewResolver(cfg *Config) (*Resolver, error) {
+	if cfg.EncodingsFile == "" {
+		return nil, nil
+	}
+	rules, err := loadRules(cfg.EncodingsFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{rules: rules}, nil
+}
+
+// ForMetric returns the encoder of the samples of a metric, or nil if they
+// are stored as double precision. The value column of the metric table is
+// converted to the configured encoding if the table is still empty. Tables
+// with data keep their column type, and their samples are encoded for it.
+func (r *Resolver) ForMetric(ctx context.Context, conn pgxconn.PgxConn, metric string, info model.MetricInfo) (Encoder, error) {
+	if r == nil {
+		return nil, nil
+	}
+	encoder, err := r.configured(ctx, conn, metric)
+	if err != nil {
+		return nil, err
+	}
+	var columnType string
+	if err = conn.QueryRow(ctx, columnTypeSQL, info.TableSchema, info.TableName).Scan(&columnType); err != nil {
+		return nil, fmt.Errorf("reading value column type of %s: %w", metric, err)
+	}
+
+	if encoder != nil && columnType == defaultColumnType {
+		var converted bool
+		err = conn.QueryRow(ctx, setValueTypeSQL, metric, encoder.ColumnType()).Scan(&converted)
+		if err != nil {
+			return nil, fmt.Errorf("converting value column of %s to %s: %w", metric, encoder.ColumnType(), err)
+		}
+		if !converted {
+			log.Info("msg", "Metric table is not empty, keeping its value encoding", "metric", metric, "encoding", encoder.Name())
+			return nil, nil
+		}
+		log.Info("msg", "Metric value column converted", "metric", metric, "encoding", encoder.Name())
+		columnType = encoder.ColumnType()
+	}
+
+	switch {
+	case columnType == defaultColumnType:
+		return nil, nil
+	case encoder != nil && encoder.ColumnType() == columnType:
+		return encoder, nil
+	}
+	// The encoding of the metric changed after its table was converted.
+	e, ok := forColumnType(columnType)
+	if !ok {
+		return nil, fmt.Errorf("no encoder for the %s value column of %s", columnType, metric)
+	}
+	return e, nil
+}
+
+// configured returns the encoder of the first rule matching the metric.
+func (r *Resolver) configured(ctx context.Context, conn pgxconn.PgxConn, metric string) (Encoder, error) {
+	var metricType *string
+	for _, rule := range r.rules {
+		if rule.metrics != nil {
+			if rule.metrics.MatchString(metric) {
+				return rule.encoder, nil
+			}
+			continue
+		}
+		// The type is only read once, when a rule needs it.
+		if metricType == nil {
+			var t string
+			err := conn.QueryRow(ctx, metricTypeSQL, metric).Scan(&t)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("reading metadata of %s: %w", metric, err)
+			}
+			metricType = &t
+		}
+		if rule.metricType == *metricType {
+			return rule.encoder, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,185 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package exemplar
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// The label names holding the trace and span IDs of the exemplars, as set by
+// the OpenTelemetry and Prometheus client libraries.
+var (
+	traceIDLabels = []string{"trace_id", "traceID", "traceId"}
+	spanIDLabels  = []string{"span_id", "spanID", "spanId"}
+)
+
+// linkTracesSQL returns the span of each trace and span ID pair, or the root
+// span of the trace if the span ID is null. The lateral join keeps an
+// equality condition on the trace ID, see findTraceSQLFormat of the Jaeger
+// store.
+const linkTracesSQL = `
+SELECT
+	l.ord,
+	s.span_id,
+	st.value#>>'{}',
+	o.span_name,
+	s.start_time,
+	s.end_time,
+	s.status_code::text
+FROM
+	unnest($1::uuid[], $2::bigint[]) WITH ORDINALITY AS l(trace_id, span_id, ord)
+INNER JOIN LATERAL (
+	SELECT s.span_id, s.operation_id, s.start_time, s.end_time, s.status_code
+	FROM _ps_trace.span s
+	WHERE s.trace_id = l.trace_id AND (l.span_id IS NULL OR s.span_id = l.span_id)
+	ORDER BY s.parent_span_id IS NULL DESC, s.start_time
+	LIMIT 1
+) s ON (TRUE)
+INNER JOIN
+	_ps_trace.operation o ON (o.id = s.operation_id)
+INNER JOIN
+	_ps_trace.tag st ON (st.id = o.service_name_id AND st.key = 'service.name' AND st.key_id = 1)`
+
+// traceRef is the span referenced by an exemplar.
+type traceRef struct {
+	traceID [16]byte
+	spanID  int64
+	hasSpan bool
+}
+
+// LinkTraces sets the span referenced by the trace ID label of each exemplar
+// of results, resolved from the trace tables with a single query. The
+// exemplars whose span is not stored are left unchanged.
+func LinkTraces(ctx context.Context, conn pgxconn.PgxConn, results []model.ExemplarQueryResult) error {
+	var (
+		refs      []traceRef
+		exemplars [][]*model.ExemplarData
+		index     = make(map[traceRef]int)
+	)
+	for i := range results {
+		for j := range results[i].Exemplars {
+			e := &results[i].Exemplars[j]
+			ref, ok := exemplarTraceRef(e)
+			if !ok {
+				continue
+			}
+			k, found := index[ref]
+			if !found {
+				k = len(refs)
+				index[ref] = k
+				refs = append(refs, ref)
+				exemplars = append(exemplars, nil)
+			}
+			exemplars[k] = append(exemplars[k], e)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	traceIDs := pgtype.UUIDArray{
+		Elements:   make([]pgtype.UUID, len(refs)),
+		Dimensions: []pgtype.ArrayDimension{{Length: int32(len(refs)), LowerBound: 1}},
+		Status:     pgtype.Present,
+	}
+	spanIDs := pgtype.Int8Array{
+		Elements:   make([]pgtype.Int8, len(refs)),
+		Dimensions: []pgtype.ArrayDimension{{Length: int32(len(refs)), LowerBound: 1}},
+		Status:     pgtype.Present,
+	}
+	for i, ref := range refs {
+		traceIDs.Elements[i] = pgtype.UUID{Bytes: ref.traceID, Status: pgtype.Present}
+		spanIDs.Elements[i] = pgtype.Int8{Int: ref.spanID, Status: pgtype.Null}
+		if ref.hasSpan {
+			spanIDs.Elements[i].Status = pgtype.Present
+		}
+	}
+
+	rows, err := conn.Query(ctx, linkTracesSQL, traceIDs, spanIDs)
+	if err != nil {
+		return fmt.Errorf("fetching the spans of the exemplars: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			ord, spanID                   int64
+			serviceName, spanName, status string
+			startTime, endTime            time.Time
+		)
+		if err := rows.Scan(&ord, &spanID, &serviceName, &spanName, &startTime, &endTime, &status); err != nil {
+			return fmt.Errorf("scanning the spans of the exemplars: %w", err)
+		}
+		if ord < 1 || int(ord) > len(refs) {
+			return fmt.Errorf("unexpected span of exemplar %d", ord)
+		}
+		ref := refs[ord-1]
+		var spanBytes [8]byte
+		binary.BigEndian.PutUint64(spanBytes[:], uint64(spanID))
+		trace := &model.ExemplarTrace{
+			TraceID:     hex.EncodeToString(ref.traceID[:]),
+			SpanID:      hex.EncodeToString(spanBytes[:]),
+			ServiceName: serviceName,
+			SpanName:    spanName,
+			StartTime:   startTime,
+			EndTime:     endTime,
+			StatusCode:  status,
+		}
+		for _, e := range exemplars[ord-1] {
+			e.Trace = trace
+		}
+	}
+	return rows.Err()
+}
+
+// exemplarTraceRef returns the span referenced by the labels of e. The trace
+// IDs of 64 bits, like the ones of Jaeger, are padded to 128 bits.
+func exemplarTraceRef(e *model.ExemplarData) (traceRef, bool) {
+	var ref traceRef
+	traceID := firstLabel(e, traceIDLabels)
+	if traceID == "" || len(traceID) > 32 || !decodeHex(ref.traceID[:], traceID) {
+		return ref, false
+	}
+	if spanID := firstLabel(e, spanIDLabels); spanID != "" {
+		var buf [8]byte
+		if len(spanID) > 16 || !decodeHex(buf[:], spanID) {
+			return ref, false
+		}
+		// Span IDs are stored as big endian bigints.
+		ref.spanID = int64(binary.BigEndian.Uint64(buf[:]))
+		ref.hasSpan = true
+	}
+	return ref, true
+}
+
+func firstLabel(e *model.ExemplarData, names []string) string {
+	for _, name := range names {
+		if v := e.Labels.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// decodeHex decodes the hexadecimal s, right aligned in dst.
+func decodeHex(dst []byte, s string) bool {
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) > len(dst) {
+		return false
+	}
+	copy(dst[len(dst)-len(decoded):], decoded)
+	return true
+}
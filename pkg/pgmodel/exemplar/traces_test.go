@@ -0,0 +1,124 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package exemplar
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestExemplarTraceRef(t *testing.T) {
+	testCases := []struct {
+		name   string
+		labels labels.Labels
+		ref    traceRef
+		ok     bool
+	}{
+		{
+			name:   "trace and span",
+			labels: labels.FromStrings("trace_id", "0102030405060708090a0b0c0d0e0f10", "span_id", "ffffffffffffffff"),
+			ref: traceRef{
+				traceID: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+				spanID:  -1,
+				hasSpan: true,
+			},
+			ok: true,
+		},
+		{
+			name:   "64 bits trace ID",
+			labels: labels.FromStrings("traceID", "abc"),
+			ref:    traceRef{traceID: [16]byte{14: 0x0a, 15: 0xbc}},
+			ok:     true,
+		},
+		{
+			name:   "no trace ID",
+			labels: labels.FromStrings("span_id", "01"),
+		},
+		{
+			name:   "invalid trace ID",
+			labels: labels.FromStrings("trace_id", "not-hex"),
+		},
+		{
+			name:   "too long span ID",
+			labels: labels.FromStrings("trace_id", "01", "span_id", "0102030405060708090a"),
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			ref, ok := exemplarTraceRef(&model.ExemplarData{Labels: c.labels})
+			require.Equal(t, c.ok, ok)
+			if ok {
+				require.Equal(t, c.ref, ref)
+			}
+		})
+	}
+}
+
+func TestLinkTraces(t *testing.T) {
+	traceID := "0102030405060708090a0b0c0d0e0f10"
+	results := []model.ExemplarQueryResult{
+		{
+			SeriesLabels: labels.FromStrings("__name__", "latency"),
+			Exemplars: []model.ExemplarData{
+				{Labels: labels.FromStrings("trace_id", traceID, "span_id", "0000000000000002"), Ts: 1},
+				{Labels: labels.FromStrings("trace_id", traceID), Ts: 2},
+				{Labels: labels.FromStrings("user", "a"), Ts: 3},
+			},
+		},
+		{
+			SeriesLabels: labels.FromStrings("__name__", "requests"),
+			Exemplars: []model.ExemplarData{
+				{Labels: labels.FromStrings("trace_id", traceID, "span_id", "0000000000000002"), Ts: 4},
+			},
+		},
+	}
+
+	uuid := pgtype.UUID{Bytes: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, Status: pgtype.Present}
+	dims := []pgtype.ArrayDimension{{Length: 2, LowerBound: 1}}
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(150 * time.Millisecond)
+	conn := model.NewSqlRecorder([]model.SqlQuery{{
+		Sql: linkTracesSQL,
+		Args: []interface{}{
+			pgtype.UUIDArray{Elements: []pgtype.UUID{uuid, uuid}, Dimensions: dims, Status: pgtype.Present},
+			pgtype.Int8Array{Elements: []pgtype.Int8{{Int: 2, Status: pgtype.Present}, {Status: pgtype.Null}}, Dimensions: dims, Status: pgtype.Present},
+		},
+		Results: model.RowResults{
+			{int64(1), int64(2), "api", "GET /users", start, end, "ok"},
+			{int64(2), int64(1), "gateway", "GET", start, end, "unset"},
+		},
+	}}, t)
+
+	require.NoError(t, LinkTraces(context.Background(), conn, results))
+	span := &model.ExemplarTrace{
+		TraceID:     traceID,
+		SpanID:      "0000000000000002",
+		ServiceName: "api",
+		SpanName:    "GET /users",
+		StartTime:   start,
+		EndTime:     end,
+		StatusCode:  "ok",
+	}
+	root := &model.ExemplarTrace{
+		TraceID:     traceID,
+		SpanID:      "0000000000000001",
+		ServiceName: "gateway",
+		SpanName:    "GET",
+		StartTime:   start,
+		EndTime:     end,
+		StatusCode:  "unset",
+	}
+	require.Equal(t, span, results[0].Exemplars[0].Trace)
+	require.Equal(t, root, results[0].Exemplars[1].Trace)
+	require.Nil(t, results[0].Exemplars[2].Trace)
+	require.Equal(t, span, results[1].Exemplars[0].Trace)
+}
@@ -0,0 +1,279 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+	"github.com/timescale/promscale/pkg/version"
+)
+
+// The names of the checks, used as the check label of their metrics.
+const (
+	DatabaseCheckName    = "database"
+	ExtensionCheckName   = "extension_version"
+	ReplicaLagCheckName  = "replica_lag"
+	MaintenanceCheckName = "maintenance_jobs"
+	CacheCheckName       = "cache_saturation"
+)
+
+const (
+	extensionVersionsSQL = "SELECT extname::text, extversion FROM pg_extension WHERE extname IN ('promscale', 'timescaledb')"
+	// An idle primary sends no transactions to replay, the replica is only
+	// lagging if it has not replayed all the WAL it received.
+	replicaLagSQL = `SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE coalesce(extract(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+	jobStatsExistSQL = "SELECT to_regclass('timescaledb_information.job_stats') IS NOT NULL"
+	// The age is NULL for the jobs that never succeeded.
+	maintenanceJobsAgeSQL = `SELECT jobs.job_id,
+		extract(epoch FROM now() - stats.last_successful_finish)::float8,
+		coalesce(stats.total_failures, 0)
+	FROM timescaledb_information.jobs jobs
+	LEFT JOIN timescaledb_information.job_stats stats USING (job_id)
+	WHERE jobs.proc_name = 'execute_maintenance_job' AND jobs.scheduled`
+)
+
+var replicaLag = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "health",
+		Name:      "replica_lag_seconds",
+		Help:      "Replication lag of each read replica at the last replica_lag check.",
+	}, []string{"replica"})
+
+func init() {
+	prometheus.MustRegister(replicaLag)
+}
+
+// DatabaseCheck checks that the database answers. It is critical.
+func DatabaseCheck(conn pgxconn.PgxConn) Check {
+	return Check{
+		Name:     DatabaseCheckName,
+		Critical: true,
+		Run: func(ctx context.Context) (string, error) {
+			if _, err := conn.Exec(ctx, "SELECT 1"); err != nil {
+				return "", err
+			}
+			return "", nil
+		},
+	}
+}
+
+// ExtensionCheck checks that the installed promscale and timescaledb
+// extensions are within the versions supported by this Promscale, e.g. after
+// an extension was upgraded or downgraded without restarting Promscale.
+func ExtensionCheck(conn pgxconn.PgxConn) Check {
+	return Check{
+		Name: ExtensionCheckName,
+		Run: func(ctx context.Context) (string, error) {
+			rows, err := conn.Query(ctx, extensionVersionsSQL)
+			if err != nil {
+				return "", err
+			}
+			defer rows.Close()
+			installed := make(map[string]string)
+			for rows.Next() {
+				var name, v string
+				if err = rows.Scan(&name, &v); err != nil {
+					return "", err
+				}
+				installed[name] = v
+			}
+			if err = rows.Err(); err != nil {
+				return "", err
+			}
+			return checkExtensionVersions(installed)
+		},
+	}
+}
+
+// checkExtensionVersions checks the versions of the installed extensions. The
+// timescaledb extension is optional.
+func checkExtensionVersions(installed map[string]string) (string, error) {
+	promscale, ok := installed["promscale"]
+	if !ok {
+		return "", fmt.Errorf("the promscale extension is not installed")
+	}
+	v, err := semver.ParseTolerant(promscale)
+	if err != nil {
+		return "", fmt.Errorf("could not parse the promscale extension version %s: %w", promscale, err)
+	}
+	if !version.ExtVersionRange(v) {
+		return "", fmt.Errorf("the promscale extension version %s is not supported, supported versions: %s", promscale, version.ExtVersionRangeString)
+	}
+	detail := "promscale " + promscale
+	timescaledb, ok := installed["timescaledb"]
+	if !ok {
+		return detail, nil
+	}
+	if v, err = semver.ParseTolerant(timescaledb); err != nil {
+		return "", fmt.Errorf("could not parse the timescaledb extension version %s: %w", timescaledb, err)
+	}
+	if !version.VerifyTimescaleVersion(v) {
+		return "", fmt.Errorf("the timescaledb extension version %s is not supported, supported versions: %s", timescaledb, version.TimescaleVersionRangeString)
+	}
+	return detail + ", timescaledb " + timescaledb, nil
+}
+
+// ReplicaLagCheck checks that every read replica answers and replays the WAL
+// of the primary with a lag of at most maxLag, if it is positive.
+func ReplicaLagCheck(replicas []pgxconn.Replica, maxLag time.Duration) Check {
+	return Check{
+		Name: ReplicaLagCheckName,
+		Run: func(ctx context.Context) (string, error) {
+			lags := make([]float64, len(replicas))
+			errs := make([]error, len(replicas))
+			var wg sync.WaitGroup
+			for i := range replicas {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = replicas[i].Conn.QueryRow(ctx, replicaLagSQL).Scan(&lags[i])
+				}(i)
+			}
+			wg.Wait()
+
+			var details, failures []string
+			for i, r := range replicas {
+				if errs[i] != nil {
+					failures = append(failures, fmt.Sprintf("%s: %v", r.Name, errs[i]))
+					continue
+				}
+				replicaLag.WithLabelValues(r.Name).Set(lags[i])
+				lag := time.Duration(lags[i] * float64(time.Second)).Round(time.Millisecond)
+				details = append(details, fmt.Sprintf("%s: %s", r.Name, lag))
+				if maxLag > 0 && lag > maxLag {
+					failures = append(failures, fmt.Sprintf("%s lags by %s, more than %s", r.Name, lag, maxLag))
+				}
+			}
+			if len(failures) > 0 {
+				return "", fmt.Errorf("%s", strings.Join(failures, "; "))
+			}
+			return strings.Join(details, ", "), nil
+		},
+	}
+}
+
+// MaintenanceCheck checks that every scheduled maintenance job succeeded
+// within maxAge, or once if maxAge is 0. It passes without TimescaleDB, which
+// has no jobs.
+func MaintenanceCheck(conn pgxconn.PgxConn, maxAge time.Duration) Check {
+	return Check{
+		Name: MaintenanceCheckName,
+		Run: func(ctx context.Context) (string, error) {
+			var hasJobs bool
+			if err := conn.QueryRow(ctx, jobStatsExistSQL).Scan(&hasJobs); err != nil {
+				return "", err
+			}
+			if !hasJobs {
+				return "timescaledb is not installed", nil
+			}
+			rows, err := conn.Query(ctx, maintenanceJobsAgeSQL)
+			if err != nil {
+				return "", err
+			}
+			defer rows.Close()
+			var (
+				jobs     int
+				oldest   float64
+				failures []string
+			)
+			for rows.Next() {
+				var (
+					id     int32
+					age    *float64
+					failed int64
+				)
+				if err = rows.Scan(&id, &age, &failed); err != nil {
+					return "", err
+				}
+				jobs++
+				if age == nil {
+					// A job that did not run yet is not failing.
+					if failed > 0 {
+						failures = append(failures, fmt.Sprintf("job %d never succeeded", id))
+					}
+					continue
+				}
+				if *age > oldest {
+					oldest = *age
+				}
+				if d := time.Duration(*age * float64(time.Second)).Round(time.Second); maxAge > 0 && d > maxAge {
+					failures = append(failures, fmt.Sprintf("job %d last succeeded %s ago, more than %s", id, d, maxAge))
+				}
+			}
+			if err = rows.Err(); err != nil {
+				return "", err
+			}
+			if len(failures) > 0 {
+				return "", fmt.Errorf("%s", strings.Join(failures, "; "))
+			}
+			if jobs == 0 {
+				return "no maintenance job is scheduled", nil
+			}
+			return fmt.Sprintf("%d jobs, oldest success %s ago", jobs, time.Duration(oldest*float64(time.Second)).Round(time.Second)), nil
+		},
+	}
+}
+
+// Cache is a cache whose saturation is checked.
+type Cache interface {
+	Len() int
+	Cap() int
+	Evictions() uint64
+}
+
+// NamedCache is a cache with the name reported by the check.
+type NamedCache struct {
+	Name  string
+	Cache Cache
+}
+
+// CacheCheck checks that the caches are not full and evicting entries, which
+// means that they are too small for the working set. A cache holding more than
+// the saturation fraction of its capacity fails the check if it evicted
+// entries since the previous run. The caches are not checked if saturation is
+// 0.
+func CacheCheck(caches []NamedCache, saturation float64) Check {
+	var (
+		mu        sync.Mutex
+		evictions = make([]uint64, len(caches))
+	)
+	for i, c := range caches {
+		evictions[i] = c.Cache.Evictions()
+	}
+	return Check{
+		Name: CacheCheckName,
+		Run: func(ctx context.Context) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var details, failures []string
+			for i, c := range caches {
+				length, capacity, evicted := c.Cache.Len(), c.Cache.Cap(), c.Cache.Evictions()
+				newEvictions := evicted - evictions[i]
+				evictions[i] = evicted
+				details = append(details, fmt.Sprintf("%s: %d/%d", c.Name, length, capacity))
+				if saturation > 0 && capacity > 0 && float64(length) >= saturation*float64(capacity) && newEvictions > 0 {
+					failures = append(failures, fmt.Sprintf("%s holds %d of %d entries and evicted %d", c.Name, length, capacity, newEvictions))
+				}
+			}
+			if len(failures) > 0 {
+				return "", fmt.Errorf("%s", strings.Join(failures, "; "))
+			}
+			return strings.Join(details, ", "), nil
+		},
+	}
+}
@@ -0,0 +1,70 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package health
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultTimeout           = 5 * time.Second
+	defaultInterval          = time.Minute
+	defaultReplicaMaxLag     = 5 * time.Minute
+	defaultMaintenanceMaxAge = 2 * time.Hour
+	defaultCacheSaturation   = 0.95
+)
+
+// Config holds the flags of the health checks.
+type Config struct {
+	// Timeout is the timeout of each check, none if 0.
+	Timeout time.Duration
+	// Interval is how often the checks run in the background to update
+	// their metrics, on top of the requests to /healthz. Disabled if 0.
+	Interval time.Duration
+	// ReplicaMaxLag is the replication lag of a read replica above which
+	// its check fails. The lag is not checked if 0.
+	ReplicaMaxLag time.Duration
+	// MaintenanceMaxAge is how long ago the last successful run of a
+	// maintenance job can have finished before its check fails. The age of
+	// the runs is not checked if 0.
+	MaintenanceMaxAge time.Duration
+	// CacheSaturation is the fraction of the capacity of a cache above
+	// which the cache check fails if the cache evicts entries. The caches
+	// are not checked if 0.
+	CacheSaturation float64
+}
+
+// ParseFlags registers the health check flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.DurationVar(&cfg.Timeout, "health.timeout", defaultTimeout, "Timeout of each check run by /healthz. No timeout if 0.")
+	fs.DurationVar(&cfg.Interval, "health.check-interval", defaultInterval, "How often the health checks run in the background to update the promscale_health_* metrics, "+
+		"on top of the requests to /healthz. Disabled if 0.")
+	fs.DurationVar(&cfg.ReplicaMaxLag, "health.replica-max-lag", defaultReplicaMaxLag, "Replication lag of a read replica above which the replica_lag check fails. "+
+		"Only the connection to the replicas is checked if 0.")
+	fs.DurationVar(&cfg.MaintenanceMaxAge, "health.maintenance-max-age", defaultMaintenanceMaxAge, "How long ago the last successful run of each maintenance job may have finished before the maintenance_jobs check fails. "+
+		"Only the jobs that never succeeded fail the check if 0.")
+	fs.Float64Var(&cfg.CacheSaturation, "health.cache-saturation", defaultCacheSaturation, "Fraction of the capacity of the metric, label and series caches above which the cache_saturation check fails "+
+		"if the cache evicted entries since the previous check. The caches are not checked if 0.")
+	return cfg
+}
+
+// Validate checks the health check flags.
+func Validate(cfg *Config) error {
+	switch {
+	case cfg.Timeout < 0:
+		return fmt.Errorf("health.timeout must not be negative, got %s", cfg.Timeout)
+	case cfg.Interval < 0:
+		return fmt.Errorf("health.check-interval must not be negative, got %s", cfg.Interval)
+	case cfg.ReplicaMaxLag < 0:
+		return fmt.Errorf("health.replica-max-lag must not be negative, got %s", cfg.ReplicaMaxLag)
+	case cfg.MaintenanceMaxAge < 0:
+		return fmt.Errorf("health.maintenance-max-age must not be negative, got %s", cfg.MaintenanceMaxAge)
+	case cfg.CacheSaturation < 0 || cfg.CacheSaturation > 1:
+		return fmt.Errorf("health.cache-saturation must be between 0 and 1, got %g", cfg.CacheSaturation)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// Status is the health of Promscale or of one of its checks.
+type Status string
+
+const (
+	// Pass means that all the checks passed.
+	Pass Status = "pass"
+	// Degraded means that some non-critical checks failed. Promscale
+	// still serves its requests.
+	Degraded Status = "degraded"
+	// Fail means that a critical check failed.
+	Fail Status = "fail"
+)
+
+var (
+	checkPassing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "health",
+			Name:      "check_passing",
+			Help:      "Whether each health check passed its last run.",
+		}, []string{"check"})
+	checkDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "health",
+			Name:      "check_duration_seconds",
+			Help:      "Duration of the last run of each health check.",
+		}, []string{"check"})
+	checkFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "health",
+			Name:      "check_failures_total",
+			Help:      "Total number of failed runs of each health check.",
+		}, []string{"check"})
+)
+
+func init() {
+	prometheus.MustRegister(checkPassing, checkDuration, checkFailures)
+}
+
+// Check is a named health check. Run returns a detail of the checked state,
+// or an error if the check fails. A failing critical check fails Promscale,
+// the others only degrade it.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) (string, error)
+}
+
+// CheckResult is the result of the run of a check.
+type CheckResult struct {
+	Status   Status  `json:"status"`
+	Critical bool    `json:"critical"`
+	Detail   string  `json:"detail,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Report is the aggregated result of the checks.
+type Report struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Engine runs the registered checks concurrently, each with a timeout, and
+// reports their results as metrics labeled by check.
+type Engine struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []Check
+}
+
+// NewEngine returns an Engine running each check with the timeout, if it is
+// positive.
+func NewEngine(timeout time.Duration, checks ...Check) *Engine {
+	return &Engine{timeout: timeout, checks: checks}
+}
+
+// Register adds a check to the engine.
+func (e *Engine) Register(c Check) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checks = append(e.checks, c)
+}
+
+// Run runs all the checks and aggregates their results.
+func (e *Engine) Run(ctx context.Context) Report {
+	e.mu.RLock()
+	checks := e.checks
+	e.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = e.run(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: Pass, Checks: make(map[string]CheckResult, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.Name] = results[i]
+		if results[i].Status != Fail {
+			continue
+		}
+		if c.Critical {
+			report.Status = Fail
+		} else if report.Status == Pass {
+			report.Status = Degraded
+		}
+	}
+	return report
+}
+
+func (e *Engine) run(ctx context.Context, c Check) CheckResult {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	detail, err := c.Run(ctx)
+	result := CheckResult{Status: Pass, Critical: c.Critical, Detail: detail, Duration: time.Since(start).Seconds()}
+
+	checkDuration.WithLabelValues(c.Name).Set(result.Duration)
+	if err != nil {
+		result.Status = Fail
+		result.Error = err.Error()
+		checkFailures.WithLabelValues(c.Name).Inc()
+		checkPassing.WithLabelValues(c.Name).Set(0)
+		log.Debug("msg", "Health check failed", "check", c.Name, "err", err)
+		return result
+	}
+	checkPassing.WithLabelValues(c.Name).Set(1)
+	return result
+}
+
+// RunEvery runs the checks every interval to keep their metrics up to date,
+// until stop is closed.
+func (e *Engine) RunEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e.Run(context.Background())
+		}
+	}
+}
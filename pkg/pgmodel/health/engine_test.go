@@ -0,0 +1,142 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func check(name string, critical bool, err error) Check {
+	return Check{Name: name, Critical: critical, Run: func(context.Context) (string, error) { return name + " detail", err }}
+}
+
+func TestEngine(t *testing.T) {
+	failing := fmt.Errorf("broken")
+	testCases := []struct {
+		name   string
+		checks []Check
+		status Status
+	}{
+		{
+			name:   "all pass",
+			checks: []Check{check("a", true, nil), check("b", false, nil)},
+			status: Pass,
+		},
+		{
+			name:   "non-critical fails",
+			checks: []Check{check("a", true, nil), check("b", false, failing)},
+			status: Degraded,
+		},
+		{
+			name:   "critical fails",
+			checks: []Check{check("a", true, failing), check("b", false, failing)},
+			status: Fail,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			report := NewEngine(time.Second, c.checks...).Run(context.Background())
+			require.Equal(t, c.status, report.Status)
+			require.Len(t, report.Checks, len(c.checks))
+			for _, ch := range c.checks {
+				_, err := ch.Run(context.Background())
+				result := report.Checks[ch.Name]
+				require.Equal(t, ch.Critical, result.Critical)
+				if err != nil {
+					require.Equal(t, Fail, result.Status)
+					require.Equal(t, err.Error(), result.Error)
+					require.Equal(t, 0.0, testutil.ToFloat64(checkPassing.WithLabelValues(ch.Name)))
+				} else {
+					require.Equal(t, Pass, result.Status)
+					require.Equal(t, ch.Name+" detail", result.Detail)
+					require.Equal(t, 1.0, testutil.ToFloat64(checkPassing.WithLabelValues(ch.Name)))
+				}
+			}
+		})
+	}
+}
+
+func TestEngineTimeout(t *testing.T) {
+	slow := Check{Name: "slow", Run: func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	e := NewEngine(10 * time.Millisecond)
+	e.Register(slow)
+	report := e.Run(context.Background())
+	require.Equal(t, Degraded, report.Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
+
+type fakeCache struct {
+	len, cap  int
+	evictions uint64
+}
+
+func (c *fakeCache) Len() int          { return c.len }
+func (c *fakeCache) Cap() int          { return c.cap }
+func (c *fakeCache) Evictions() uint64 { return c.evictions }
+
+func TestCacheCheck(t *testing.T) {
+	metric := &fakeCache{len: 10, cap: 100}
+	series := &fakeCache{len: 99, cap: 100, evictions: 5}
+	c := CacheCheck([]NamedCache{{Name: "metric", Cache: metric}, {Name: "series", Cache: series}}, 0.95)
+
+	// A full cache without new evictions passes.
+	detail, err := c.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "metric: 10/100, series: 99/100", detail)
+
+	// It fails once it evicts entries.
+	series.evictions = 8
+	_, err = c.Run(context.Background())
+	require.EqualError(t, err, "series holds 99 of 100 entries and evicted 3")
+
+	// Evictions below the saturation are not a problem.
+	metric.evictions, series.len = 20, 50
+	_, err = c.Run(context.Background())
+	require.NoError(t, err)
+
+	// A saturation of 0 does not check the caches.
+	series.len, series.evictions = 100, 100
+	_, err = CacheCheck([]NamedCache{{Name: "series", Cache: series}}, 0).Run(context.Background())
+	require.NoError(t, err)
+}
+
+func TestCheckExtensionVersions(t *testing.T) {
+	detail, err := checkExtensionVersions(map[string]string{"promscale": "0.6.0"})
+	require.NoError(t, err)
+	require.Equal(t, "promscale 0.6.0", detail)
+
+	detail, err = checkExtensionVersions(map[string]string{"promscale": "0.6.0", "timescaledb": "2.8.1"})
+	require.NoError(t, err)
+	require.Equal(t, "promscale 0.6.0, timescaledb 2.8.1", detail)
+
+	_, err = checkExtensionVersions(map[string]string{})
+	require.EqualError(t, err, "the promscale extension is not installed")
+	_, err = checkExtensionVersions(map[string]string{"promscale": "0.5.0"})
+	require.Error(t, err)
+	_, err = checkExtensionVersions(map[string]string{"promscale": "0.6.0", "timescaledb": "1.7.0"})
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{Timeout: time.Second, Interval: time.Minute, ReplicaMaxLag: time.Minute, MaintenanceMaxAge: time.Hour, CacheSaturation: 0.9}
+	require.NoError(t, Validate(&cfg))
+	require.NoError(t, Validate(&Config{}))
+
+	invalid := cfg
+	invalid.CacheSaturation = 1.5
+	require.Error(t, Validate(&invalid))
+	invalid = cfg
+	invalid.ReplicaMaxLag = -time.Second
+	require.Error(t, Validate(&invalid))
+}
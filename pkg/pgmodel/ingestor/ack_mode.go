@@ -0,0 +1,75 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AckMode defines when a metric write request is acknowledged.
+type AckMode int8
+
+const (
+	// AckModeDefault uses the mode set by -metrics.async-acks.
+	AckModeDefault AckMode = iota
+	// AckModeSync acknowledges the request after the data is committed to the database.
+	AckModeSync
+	// AckModeAsync acknowledges the request as soon as the data is queued for
+	// insertion. Data still in the queue is lost if Promscale stops or the insert fails.
+	AckModeAsync
+)
+
+type ackModeCtxKey struct{}
+
+// ParseAckMode parses "sync" or "async" into an AckMode. An empty string
+// returns AckModeDefault.
+func ParseAckMode(s string) (AckMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return AckModeDefault, nil
+	case "sync":
+		return AckModeSync, nil
+	case "async":
+		return AckModeAsync, nil
+	default:
+		return AckModeDefault, fmt.Errorf("invalid ack mode %q: must be either sync or async", s)
+	}
+}
+
+func (m AckMode) String() string {
+	switch m {
+	case AckModeSync:
+		return "sync"
+	case AckModeAsync:
+		return "async"
+	default:
+		return "default"
+	}
+}
+
+// WithAckMode returns a context that makes the metric inserts done with it
+// use the given AckMode.
+func WithAckMode(ctx context.Context, mode AckMode) context.Context {
+	if mode == AckModeDefault {
+		return ctx
+	}
+	return context.WithValue(ctx, ackModeCtxKey{}, mode)
+}
+
+// useAsyncAcks returns if inserts done with ctx should be acknowledged
+// asynchronously, falling back to defaultAsync if ctx has no AckMode.
+func useAsyncAcks(ctx context.Context, defaultAsync bool) bool {
+	mode, _ := ctx.Value(ackModeCtxKey{}).(AckMode)
+	switch mode {
+	case AckModeSync:
+		return false
+	case AckModeAsync:
+		return true
+	default:
+		return defaultAsync
+	}
+}
@@ -0,0 +1,45 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAckMode(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         string
+		defaultAsync bool
+		expAsync     bool
+		expErr       bool
+	}{
+		{name: "default sync", mode: "", defaultAsync: false, expAsync: false},
+		{name: "default async", mode: "", defaultAsync: true, expAsync: true},
+		{name: "sync overrides async default", mode: "sync", defaultAsync: true, expAsync: false},
+		{name: "async overrides sync default", mode: "ASYNC", defaultAsync: false, expAsync: true},
+		{name: "invalid mode", mode: "eventually", expErr: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			mode, err := ParseAckMode(c.mode)
+			if c.expErr {
+				if err == nil {
+					t.Fatalf("expected error for mode %q", c.mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ctx := WithAckMode(context.Background(), mode)
+			if got := useAsyncAcks(ctx, c.defaultAsync); got != c.expAsync {
+				t.Fatalf("unexpected async acks for mode %s: got %v, wanted %v", mode, got, c.expAsync)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package backpressure
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultTargetLatency  = 2 * time.Second
+	defaultErrorThreshold = 0.5
+	defaultOpenDuration   = 30 * time.Second
+	defaultAdjustInterval = 5 * time.Second
+)
+
+// Config holds the flags of the ingest backpressure.
+type Config struct {
+	Enabled bool
+	// TargetLatency is the insert latency above which the copier parallelism
+	// and batch sizes are reduced.
+	TargetLatency time.Duration
+	// ErrorThreshold is the fraction of inserts failing because the database
+	// is overloaded that opens the circuit breaker.
+	ErrorThreshold float64
+	// OpenDuration is how long the circuit breaker rejects writes once open.
+	OpenDuration time.Duration
+	// AdjustInterval is how often the parallelism is adjusted.
+	AdjustInterval time.Duration
+}
+
+// ParseFlags registers the backpressure flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "metrics.backpressure.enabled", false, "Adapt the number of concurrent copiers and the size of their batches to the insert latency and error rate of the database, "+
+		"and reject writes with 503 while the database is overloaded.")
+	fs.DurationVar(&cfg.TargetLatency, "metrics.backpressure.target-latency", defaultTargetLatency, "Average insert latency above which the copier parallelism and batch sizes are halved. "+
+		"They are increased again while the latency stays below.")
+	fs.Float64Var(&cfg.ErrorThreshold, "metrics.backpressure.error-threshold", defaultErrorThreshold, "Fraction of the inserts failing because the database is overloaded, e.g. timeouts or too many connections, "+
+		"above which the circuit breaker opens and writes are rejected.")
+	fs.DurationVar(&cfg.OpenDuration, "metrics.backpressure.open-duration", defaultOpenDuration, "How long writes are rejected once the circuit breaker opens, sent to the clients in the Retry-After header.")
+	fs.DurationVar(&cfg.AdjustInterval, "metrics.backpressure.adjust-interval", defaultAdjustInterval, "How often the copier parallelism is adjusted from the latency and errors of the inserts.")
+	return cfg
+}
+
+// Validate checks the backpressure flags.
+func Validate(cfg *Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch {
+	case cfg.TargetLatency <= 0:
+		return fmt.Errorf("metrics.backpressure.target-latency must be positive")
+	case cfg.ErrorThreshold <= 0 || cfg.ErrorThreshold > 1:
+		return fmt.Errorf("metrics.backpressure.error-threshold must be greater than 0 and at most 1")
+	case cfg.OpenDuration <= 0:
+		return fmt.Errorf("metrics.backpressure.open-duration must be positive")
+	case cfg.AdjustInterval <= 0:
+		return fmt.Errorf("metrics.backpressure.adjust-interval must be positive")
+	}
+	return nil
+}
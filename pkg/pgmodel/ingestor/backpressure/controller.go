@@ -0,0 +1,300 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package backpressure adapts the ingest to the load of the database. The
+// number of copiers inserting concurrently and the size of their batches are
+// increased additively while the inserts are fast, and halved when they get
+// slow. When too many inserts fail because the database is overloaded, a
+// circuit breaker opens and writes are rejected until the database recovers.
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+type state int
+
+const (
+	closed state = iota
+	halfOpen
+	open
+)
+
+func (s state) String() string {
+	switch s {
+	case halfOpen:
+		return "half-open"
+	case open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+var (
+	copierLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_backpressure",
+			Name:      "copier_limit",
+			Help:      "Number of copiers allowed to insert concurrently.",
+		},
+	)
+	batchSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_backpressure",
+			Name:      "batch_size",
+			Help:      "Maximum number of metrics inserted by a copier in one transaction.",
+		},
+	)
+	breakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_backpressure",
+			Name:      "breaker_state",
+			Help:      "State of the ingest circuit breaker: 0 closed, 1 half-open, 2 open.",
+		},
+	)
+	rejectedRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_backpressure",
+			Name:      "rejected_requests_total",
+			Help:      "Total number of write requests rejected while the ingest circuit breaker was open.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(copierLimit, batchSize, breakerState, rejectedRequests)
+}
+
+// Error is returned for the writes rejected while the circuit breaker is open,
+// or while the ingest is otherwise paused.
+type Error struct {
+	RetryAfter time.Duration
+	// Reason is why the ingest is paused, the database being overloaded if
+	// empty.
+	Reason string
+}
+
+func (e *Error) Error() string {
+	reason := e.Reason
+	if reason == "" {
+		reason = "database overloaded"
+	}
+	return fmt.Sprintf("%s, ingest paused, retry after %s", reason, e.RetryAfter)
+}
+
+// Controller limits the concurrent copiers. A nil Controller does not limit
+// anything.
+type Controller struct {
+	cfg        Config
+	maxCopiers int
+	maxBatch   int
+	now        func() time.Time
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+
+	state     state
+	openUntil time.Time
+
+	windowStart time.Time
+	inserts     int
+	failures    int
+	latency     time.Duration
+}
+
+// NewController returns a Controller for maxCopiers copiers inserting up to
+// maxBatch metrics per transaction, or nil if backpressure is disabled.
+func NewController(cfg Config, maxCopiers, maxBatch int) *Controller {
+	if !cfg.Enabled {
+		return nil
+	}
+	c := &Controller{
+		cfg:        cfg,
+		maxCopiers: maxCopiers,
+		maxBatch:   maxBatch,
+		now:        time.Now,
+		limit:      maxCopiers,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.windowStart = c.now()
+	c.report()
+	return c
+}
+
+// Allow returns an *Error while the circuit breaker is open.
+func (c *Controller) Allow() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != open {
+		return nil
+	}
+	if now := c.now(); now.Before(c.openUntil) {
+		rejectedRequests.Inc()
+		return &Error{RetryAfter: c.openUntil.Sub(now)}
+	}
+	// Let writes through to probe the database, the next adjustment
+	// closes the breaker or opens it again.
+	c.state = halfOpen
+	c.windowStart, c.inserts, c.failures, c.latency = c.now(), 0, 0, 0
+	c.report()
+	log.Info("msg", "Ingest circuit breaker half-open, probing the database")
+	return nil
+}
+
+// Acquire blocks until the copier is allowed to insert. Every Acquire must be
+// followed by a Release.
+func (c *Controller) Acquire() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.active >= c.limit {
+		c.cond.Wait()
+	}
+	c.active++
+}
+
+// Release lets another copier insert.
+func (c *Controller) Release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.cond.Broadcast()
+}
+
+// BatchSize returns the maximum number of metrics a copier inserts in one
+// transaction, reduced in proportion to the copier limit.
+func (c *Controller) BatchSize() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batchSize()
+}
+
+func (c *Controller) batchSize() int {
+	size := c.maxBatch * c.limit / c.maxCopiers
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// Observe records the latency and the error of an insert, and adjusts the
+// limits once per adjust interval.
+func (c *Controller) Observe(latency time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inserts++
+	c.latency += latency
+	if IsOverload(err) {
+		c.failures++
+	}
+	if now := c.now(); now.Sub(c.windowStart) >= c.cfg.AdjustInterval {
+		c.adjust(now)
+	}
+}
+
+func (c *Controller) adjust(now time.Time) {
+	inserts, failures, latency := c.inserts, c.failures, c.latency
+	c.windowStart, c.inserts, c.failures, c.latency = now, 0, 0, 0
+	if inserts == 0 {
+		return
+	}
+	defer c.report()
+
+	if float64(failures)/float64(inserts) >= c.cfg.ErrorThreshold {
+		c.limit = 1
+		if c.state != open {
+			log.Warn("msg", "Ingest circuit breaker open, rejecting writes", "failed_inserts", failures, "inserts", inserts, "retry_after", c.cfg.OpenDuration)
+		}
+		c.state = open
+		c.openUntil = now.Add(c.cfg.OpenDuration)
+		return
+	}
+	if c.state == open {
+		// Only the writes accepted before the breaker opened are inserted,
+		// it stays open until it is probed.
+		return
+	}
+	if c.state == halfOpen {
+		log.Info("msg", "Ingest circuit breaker closed, the database recovered")
+		c.state = closed
+	}
+
+	avg := latency / time.Duration(inserts)
+	switch {
+	case avg > c.cfg.TargetLatency && c.limit > 1:
+		c.limit /= 2
+		log.Info("msg", "Insert latency above target, reducing copier parallelism", "latency", avg, "copiers", c.limit)
+	case avg <= c.cfg.TargetLatency && c.limit < c.maxCopiers:
+		c.limit++
+		c.cond.Broadcast()
+	}
+}
+
+func (c *Controller) report() {
+	copierLimit.Set(float64(c.limit))
+	batchSize.Set(float64(c.batchSize()))
+	breakerState.Set(float64(c.state))
+}
+
+// IsOverload returns true if err means that the database is overloaded or
+// unreachable, as opposed to a problem with the inserted data.
+func IsOverload(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch {
+	// insufficient_resources, e.g. too_many_connections or disk_full, and
+	// connection_exception.
+	case len(pgErr.Code) == 5 && (pgErr.Code[:2] == "53" || pgErr.Code[:2] == "08"):
+		return true
+	// query_canceled by statement_timeout, admin_shutdown, crash_shutdown,
+	// cannot_connect_now and lock_not_available.
+	case pgErr.Code == "57014" || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03" || pgErr.Code == "55P03":
+		return true
+	}
+	return false
+}
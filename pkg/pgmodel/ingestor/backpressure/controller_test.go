@@ -0,0 +1,127 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestController(now *time.Time) *Controller {
+	c := NewController(Config{
+		Enabled:        true,
+		TargetLatency:  time.Second,
+		ErrorThreshold: 0.5,
+		OpenDuration:   30 * time.Second,
+		AdjustInterval: 5 * time.Second,
+	}, 8, 100)
+	c.now = func() time.Time { return *now }
+	c.windowStart = *now
+	return c
+}
+
+func TestControllerAdjustsParallelism(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTestController(&now)
+	require.Equal(t, 100, c.BatchSize())
+
+	// Slow inserts halve the limit once per interval.
+	c.Observe(3*time.Second, nil)
+	require.Equal(t, 8, c.limit)
+	now = now.Add(5 * time.Second)
+	c.Observe(3*time.Second, nil)
+	require.Equal(t, 4, c.limit)
+	require.Equal(t, 50, c.BatchSize())
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(5 * time.Second)
+		c.Observe(3*time.Second, nil)
+	}
+	require.Equal(t, 1, c.limit)
+	require.Equal(t, 12, c.BatchSize())
+
+	// Fast inserts increase it again.
+	now = now.Add(5 * time.Second)
+	c.Observe(100*time.Millisecond, nil)
+	require.Equal(t, 2, c.limit)
+}
+
+func TestControllerBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTestController(&now)
+	require.NoError(t, c.Allow())
+
+	timeout := &pgconn.PgError{Code: "57014"}
+	c.Observe(time.Second, timeout)
+	now = now.Add(5 * time.Second)
+	c.Observe(time.Second, timeout)
+	require.Equal(t, open, c.state)
+	require.Equal(t, 1, c.limit)
+
+	var bpErr *Error
+	require.True(t, errors.As(c.Allow(), &bpErr))
+	require.Equal(t, 30*time.Second, bpErr.RetryAfter)
+
+	// Probe after the open duration, failing opens it again.
+	now = now.Add(30 * time.Second)
+	require.NoError(t, c.Allow())
+	require.Equal(t, halfOpen, c.state)
+	now = now.Add(5 * time.Second)
+	c.Observe(time.Second, context.DeadlineExceeded)
+	require.Equal(t, open, c.state)
+
+	// Succeeding closes it.
+	now = now.Add(30 * time.Second)
+	require.NoError(t, c.Allow())
+	now = now.Add(5 * time.Second)
+	c.Observe(100*time.Millisecond, nil)
+	require.Equal(t, closed, c.state)
+	require.Equal(t, 2, c.limit)
+}
+
+func TestControllerAcquire(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTestController(&now)
+	c.limit = 1
+
+	c.Acquire()
+	acquired := make(chan struct{})
+	go func() {
+		c.Acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Release()
+	<-acquired
+	c.Release()
+}
+
+func TestIsOverload(t *testing.T) {
+	require.False(t, IsOverload(nil))
+	require.False(t, IsOverload(errors.New("bad data")))
+	require.False(t, IsOverload(&pgconn.PgError{Code: "23505"}))
+	require.True(t, IsOverload(&pgconn.PgError{Code: "53300"}))
+	require.True(t, IsOverload(&pgconn.PgError{Code: "55P03"}))
+	require.True(t, IsOverload(context.DeadlineExceeded))
+}
+
+func TestNilController(t *testing.T) {
+	var c *Controller
+	require.Nil(t, NewController(Config{}, 8, 100))
+	require.NoError(t, c.Allow())
+	c.Acquire()
+	c.Observe(time.Second, nil)
+	c.Release()
+	require.Equal(t, 0, c.BatchSize())
+}
@@ -22,24 +22,42 @@ import (
 
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/tenancy"
 	"github.com/timescale/promscale/pkg/tracer"
 )
 
 const (
 	sqlInsertIntoFrom = "INSERT INTO %[1]s.%[2]s(%[3]s) SELECT %[3]s FROM %[4]s ON CONFLICT DO NOTHING"
+	// sqlUpsertIntoFrom replaces the stored values of the duplicate samples.
+	// A row can only be updated once per statement, so the sample copied
+	// last wins among the duplicates of the batch.
+	// The conflict target is the unique index of the metric table, which
+	// includes the tenant of the metrics partitioned by tenant.
+	sqlUpsertIntoFrom = "INSERT INTO %[1]s.%[2]s(%[3]s) SELECT DISTINCT ON (series_id, time) %[3]s FROM %[4]s ORDER BY series_id, time, ctid DESC " +
+		"ON CONFLICT (%[5]s) DO UPDATE SET value = EXCLUDED.value"
+	conflictColumns       = "series_id, time"
+	tenantConflictColumns = "series_id, time, tenant"
 )
 
 type copyRequest struct {
 	data *pendingBuffer
 	info *pgmodel.MetricInfo
+	// encoder converts the sample values for the value column of the
+	// metric table, nil if it is double precision.
+	encoder encoding.Encoder
 }
 
 var (
 	getBatchMutex       = &sync.Mutex{}
 	handleDecompression = retryAfterDecompression
+	// samplesInsertIntoFrom resolves the duplicate samples, exemplars are
+	// always kept from the first write.
+	samplesInsertIntoFrom = sqlInsertIntoFrom
 )
 
 type copyBatch []copyRequest
@@ -72,8 +90,9 @@ func (reqs copyBatch) VisitExemplar(callBack func(info *pgmodel.MetricInfo, s *p
 }
 
 // Handles actual insertion into the DB.
-// We have one of these per connection reserved for insertion.
-func runCopier(conn pgxconn.PgxConn, in chan readRequest, sw *seriesWriter, elf *ExemplarLabelFormatter) {
+// We have one of these per connection reserved for insertion, bp limits how
+// many of them insert concurrently.
+func runCopier(conn pgxconn.PgxConn, in chan readRequest, sw *seriesWriter, elf *ExemplarLabelFormatter, bp *backpressure.Controller) {
 	requestBatch := make([]readRequest, 0, metrics.MaxInsertStmtPerTxn)
 	insertBatch := make([]copyRequest, 0, cap(requestBatch))
 	for {
@@ -89,8 +108,10 @@ func runCopier(conn pgxconn.PgxConn, in chan readRequest, sw *seriesWriter, elf
 		// the fact that we fetch the entire batch before executing any of the
 		// reads. This guarantees that we never need to batch the same metrics
 		// together in the copier.
-		requestBatch, ok = copierGetBatch(ctx, requestBatch, in)
+		bp.Acquire()
+		requestBatch, ok = copierGetBatch(ctx, requestBatch, bp.BatchSize(), in)
 		if !ok {
+			bp.Release()
 			span.End()
 			return
 		}
@@ -120,7 +141,13 @@ func runCopier(conn pgxconn.PgxConn, in chan readRequest, sw *seriesWriter, elf
 			return insertBatch[i].info.TableName < insertBatch[j].info.TableName
 		})
 
-		err := persistBatch(ctx, conn, sw, elf, insertBatch)
+		start := time.Now()
+		insertErr, err := persistBatch(ctx, conn, sw, elf, insertBatch)
+		if err != nil {
+			insertErr = err
+		}
+		bp.Observe(time.Since(start), insertErr)
+		bp.Release()
 		if err != nil {
 			for i := range insertBatch {
 				insertBatch[i].data.reportResults(err)
@@ -139,24 +166,28 @@ func runCopier(conn pgxconn.PgxConn, in chan readRequest, sw *seriesWriter, elf
 	}
 }
 
-func persistBatch(ctx context.Context, conn pgxconn.PgxConn, sw *seriesWriter, elf *ExemplarLabelFormatter, insertBatch []copyRequest) error {
+// persistBatch inserts the batch and reports the results to the requests,
+// unless err is returned. insertErr is the error of the batch insert, even if
+// the requests were then inserted one by one.
+func persistBatch(ctx context.Context, conn pgxconn.PgxConn, sw *seriesWriter, elf *ExemplarLabelFormatter, insertBatch []copyRequest) (insertErr error, err error) {
 	ctx, span := tracer.Default().Start(ctx, "persist-batch")
 	defer span.End()
 	batch := copyBatch(insertBatch)
-	err := sw.PopulateOrCreateSeries(ctx, batch)
+	err = sw.PopulateOrCreateSeries(ctx, batch)
 	if err != nil {
-		return fmt.Errorf("copier: writing series: %w", err)
+		return nil, fmt.Errorf("copier: writing series: %w", err)
 	}
 	err = elf.orderExemplarLabelValues(batch)
 	if err != nil {
-		return fmt.Errorf("copier: formatting exemplar label values: %w", err)
+		return nil, fmt.Errorf("copier: formatting exemplar label values: %w", err)
 	}
 
-	doInsertOrFallback(ctx, conn, insertBatch...)
-	return nil
+	return doInsertOrFallback(ctx, conn, insertBatch...), nil
 }
 
-func copierGetBatch(ctx context.Context, batch []readRequest, in <-chan readRequest) ([]readRequest, bool) {
+// copierGetBatch fetches up to maxBatch read requests, or up to the capacity
+// of batch if maxBatch is 0.
+func copierGetBatch(ctx context.Context, batch []readRequest, maxBatch int, in <-chan readRequest) ([]readRequest, bool) {
 	_, span := tracer.Default().Start(ctx, "get-batch")
 	defer span.End()
 	//This mutex is not for safety, but rather for better batching.
@@ -181,11 +212,18 @@ func copierGetBatch(ctx context.Context, batch []readRequest, in <-chan readRequ
 
 	//we use a small timeout to prevent low-pressure systems from using up too many
 	//txns and putting pressure on system
+	if maxBatch <= 0 || maxBatch > cap(batch) {
+		maxBatch = cap(batch)
+	}
 	timeout := time.After(20 * time.Millisecond)
 hot_gather:
-	for len(batch) < cap(batch) {
+	for len(batch) < maxBatch {
 		select {
-		case r2 := <-in:
+		case r2, ok := <-in:
+			if !ok {
+				// The dispatcher is closing, the batch is written first.
+				break hot_gather
+			}
 			span.AddEvent("Appending batch")
 			batch = append(batch, r2)
 		case <-timeout:
@@ -193,14 +231,16 @@ hot_gather:
 			break hot_gather
 		}
 	}
-	if len(batch) == cap(batch) {
+	if len(batch) == maxBatch {
 		span.AddEvent("Batch is full")
 	}
 	span.SetAttributes(attribute.Int("num_batches", len(batch)))
 	return batch, true
 }
 
-func doInsertOrFallback(ctx context.Context, conn pgxconn.PgxConn, reqs ...copyRequest) {
+// doInsertOrFallback inserts the requests in one transaction, or one by one
+// if it fails, and returns the error of the transaction.
+func doInsertOrFallback(ctx context.Context, conn pgxconn.PgxConn, reqs ...copyRequest) error {
 	ctx, span := tracer.Default().Start(ctx, "do-insert-or-fallback")
 	defer span.End()
 	err, _ := insertSeries(ctx, conn, false, reqs...)
@@ -211,7 +251,7 @@ func doInsertOrFallback(ctx context.Context, conn pgxconn.PgxConn, reqs ...copyR
 		if err != nil {
 			log.Error("msg", err)
 			insertBatchErrorFallback(ctx, conn, reqs...)
-			return
+			return err
 		}
 	}
 
@@ -219,6 +259,7 @@ func doInsertOrFallback(ctx context.Context, conn pgxconn.PgxConn, reqs ...copyR
 		reqs[i].data.reportResults(nil)
 		reqs[i].data.release()
 	}
+	return nil
 }
 
 // check if we got error for duplicates
@@ -384,8 +425,9 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 		// multiple data, and brings INSERT nearly on par with CopyFrom. In the
 		// future we may wish to send compressed data instead.
 		var (
-			hasSamples   bool
-			hasExemplars bool
+			hasSamples     bool
+			hasExemplars   bool
+			droppedSamples int
 		)
 
 		if numSamples > 0 {
@@ -395,11 +437,32 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 			exemplarRows = make([][]interface{}, 0, numExemplars)
 		}
 
+		var tenants map[int64]string
+		if req.info.TenantPartitioned {
+			tenants = seriesTenants(req.data.batch.Data())
+		}
+		sampleRow := func(t time.Time, v interface{}, seriesId int64) []interface{} {
+			if tenants == nil {
+				return []interface{}{t, v, seriesId}
+			}
+			return []interface{}{t, v, seriesId, tenants[seriesId]}
+		}
+
 		visitor := req.data.batch.Visitor()
 		err = visitor.Visit(
 			func(t time.Time, v float64, seriesId int64) {
+				if req.encoder == nil {
+					hasSamples = true
+					sampleRows = append(sampleRows, sampleRow(t, v, seriesId))
+					return
+				}
+				ev, ok := encoding.Value(req.encoder, v)
+				if !ok {
+					droppedSamples++
+					return
+				}
 				hasSamples = true
-				sampleRows = append(sampleRows, []interface{}{t, v, seriesId})
+				sampleRows = append(sampleRows, sampleRow(t, ev, seriesId))
 			},
 			func(t time.Time, v float64, seriesId int64, lvalues []string) {
 				hasExemplars = true
@@ -409,6 +472,10 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 		if err != nil {
 			return err, lowestMinTime
 		}
+		if droppedSamples > 0 {
+			encoding.DroppedSamples.WithLabelValues(req.encoder.Name()).Add(float64(droppedSamples))
+			numSamples -= droppedSamples
+		}
 		epoch := visitor.LowestEpoch()
 		if epoch < lowestEpoch {
 			lowestEpoch = epoch
@@ -424,6 +491,10 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 
 		copyFromFunc := func(tableName, schemaName string, isExemplar bool) error {
 			columns := schema.PromDataColumns
+			conflict := conflictColumns
+			if req.info.TenantPartitioned {
+				columns, conflict = schema.PromDataTenantColumns, tenantConflictColumns
+			}
 			tempTablePrefix := fmt.Sprintf("s%d_", r)
 			rows := sampleRows
 			if isExemplar {
@@ -444,9 +515,13 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 				return err
 			}
 			if onConflict {
+				insertIntoFrom := samplesInsertIntoFrom
+				if isExemplar {
+					insertIntoFrom = sqlInsertIntoFrom
+				}
 				res, err := tx.Exec(ctx,
-					fmt.Sprintf(sqlInsertIntoFrom, schemaName, pgx.Identifier{tableName}.Sanitize(),
-						strings.Join(columns[:], ","), table.Sanitize()))
+					fmt.Sprintf(insertIntoFrom, schemaName, pgx.Identifier{tableName}.Sanitize(),
+						strings.Join(columns[:], ","), table.Sanitize(), conflict))
 				if err != nil {
 					return err
 				}
@@ -502,6 +577,21 @@ func insertSeries(ctx context.Context, conn pgxconn.PgxConn, onConflict bool, re
 	return nil, lowestMinTime
 }
 
+// seriesTenants returns the tenant of each series of the insertables, by
+// series ID.
+func seriesTenants(data []pgmodel.Insertable) map[int64]string {
+	tenants := make(map[int64]string, len(data))
+	for _, ins := range data {
+		s := ins.Series()
+		id, _, err := s.GetSeriesID()
+		if err != nil {
+			continue
+		}
+		tenants[int64(id)] = s.LabelValue(tenancy.TenantLabelKey)
+	}
+	return tenants
+}
+
 func createTempIngestTable(ctx context.Context, tx pgx.Tx, table, schema, prefix string) (pgx.Identifier, error) {
 	var tempTableNameRawString string
 	row := tx.QueryRow(ctx, "SELECT _prom_catalog.create_ingest_temp_table($1, $2, $3)", table, schema, prefix)
@@ -18,8 +18,13 @@ import (
 
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/cache/shared"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/memory"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/partitioning"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/tracer"
 	tput "github.com/timescale/promscale/pkg/util/throughput"
@@ -28,6 +33,12 @@ import (
 const (
 	finalizeMetricCreation = "CALL _prom_catalog.finalize_metric_creation()"
 	getEpochSQL            = "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1"
+
+	// sampleBytes and insertableOverheadBytes estimate the memory used by
+	// the data of a write in the ingest, accounted against the memory
+	// budget. The series are shared with the series cache and not counted.
+	sampleBytes             = 16
+	insertableOverheadBytes = 64
 )
 
 var ErrDispatcherClosed = fmt.Errorf("dispatcher is closed")
@@ -39,15 +50,25 @@ type pgxDispatcher struct {
 	metricTableNames       cache.MetricCache
 	scache                 cache.SeriesCache
 	invertedLabelsCache    *cache.InvertedLabelsCache
+	sharedCache            *shared.Cache
 	exemplarKeyPosCache    cache.PositionCache
+	encodings              *encoding.Resolver
+	partitioning           *partitioning.Layout
 	batchers               sync.Map
 	completeMetricCreation chan struct{}
 	asyncAcks              bool
 	copierReadRequestCh    chan<- readRequest
+	backpressure           *backpressure.Controller
+	memoryBudget           *memory.Budget
 	seriesEpochRefresh     *time.Ticker
 	doneChannel            chan struct{}
 	closed                 *uber_atomic.Bool
+	warmedUp               *uber_atomic.Bool
 	doneWG                 sync.WaitGroup
+	// batchersWG and copiersWG track the metric batchers and the copiers,
+	// which Close waits for so that the pending batches are written.
+	batchersWG sync.WaitGroup
+	copiersWG  sync.WaitGroup
 }
 
 var _ model.Dispatcher = &pgxDispatcher{}
@@ -64,44 +85,63 @@ func newPgxDispatcher(conn pgxconn.PgxConn, mCache cache.MetricCache, scache cac
 	copierReadRequestCh := make(chan readRequest, maxMetrics)
 
 	metrics.IngestorChannelCap.With(prometheus.Labels{"type": "metric", "subsystem": "copier", "kind": "sample"}).Set(float64(cap(copierReadRequestCh)))
-	metrics.RegisterCopierChannelLenMetric(func() float64 { return float64(len(copierReadRequestCh)) })
+	if cfg.Shard == "" {
+		// The channel length metric reports the first shard only.
+		metrics.RegisterCopierChannelLenMetric(func() float64 { return float64(len(copierReadRequestCh)) })
+	}
 
 	if cfg.IgnoreCompressedChunks {
 		// Handle decompression to not decompress anything.
 		handleDecompression = skipDecompression
 	}
+	if cfg.LastWriteWins {
+		samplesInsertIntoFrom = sqlUpsertIntoFrom
+	}
 
 	if err := model.RegisterCustomPgTypes(conn); err != nil {
 		return nil, fmt.Errorf("registering custom pg types: %w", err)
 	}
 
-	labelArrayOID := model.GetCustomTypeOID(model.LabelArray)
-	labelsCache, err := cache.NewInvertedLabelsCache(cfg.InvertedLabelsCacheSize)
+	labelsCache, err := cache.NewShardInvertedLabelsCache(cfg.InvertedLabelsCacheSize, cfg.Shard)
 	if err != nil {
 		return nil, err
 	}
-	sw := NewSeriesWriter(conn, labelArrayOID, labelsCache)
+	cfg.CacheSizer.Manage(cache.ShardName("inverted_labels", cfg.Shard), labelsCache)
+	cfg.MemoryBudget.TrackCache(cache.ShardName("inverted_labels", cfg.Shard), labelsCache)
+	sw := NewSeriesWriter(conn, labelsCache, cfg.SharedCache)
+	sw.memoryBudget = cfg.MemoryBudget
 	elf := NewExamplarLabelFormatter(conn, eCache)
 
-	for i := 0; i < numCopiers; i++ {
-		go runCopier(conn, copierReadRequestCh, sw, elf)
-	}
+	bp := backpressure.NewController(cfg.Backpressure, numCopiers, metrics.MaxInsertStmtPerTxn)
 
 	inserter := &pgxDispatcher{
 		conn:                   conn,
 		metricTableNames:       mCache,
 		scache:                 scache,
 		invertedLabelsCache:    labelsCache,
+		sharedCache:            cfg.SharedCache,
 		exemplarKeyPosCache:    eCache,
+		encodings:              cfg.ValueEncodings,
+		partitioning:           cfg.TenantPartitioning,
 		completeMetricCreation: make(chan struct{}, 1),
 		asyncAcks:              cfg.MetricsAsyncAcks,
 		copierReadRequestCh:    copierReadRequestCh,
+		backpressure:           bp,
+		memoryBudget:           cfg.MemoryBudget,
 		// set to run at half our deletion interval
 		seriesEpochRefresh: time.NewTicker(30 * time.Minute),
 		doneChannel:        make(chan struct{}),
 		closed:             uber_atomic.NewBool(false),
+		warmedUp:           uber_atomic.NewBool(cfg.WarmUpSeries == 0),
 	}
 	inserter.closed.Store(false)
+	for i := 0; i < numCopiers; i++ {
+		inserter.copiersWG.Add(1)
+		go func() {
+			defer inserter.copiersWG.Done()
+			runCopier(conn, copierReadRequestCh, sw, elf, bp)
+		}()
+	}
 	runBatchWatcher(inserter.doneChannel)
 
 	//on startup run a completeMetricCreation to recover any potentially
@@ -113,10 +153,26 @@ func newPgxDispatcher(conn pgxconn.PgxConn, mCache cache.MetricCache, scache cac
 	go inserter.runCompleteMetricCreationWorker()
 
 	if !cfg.DisableEpochSync {
+		// The first refresh resets the caches, so it runs before they are warmed up.
+		epoch, err := inserter.refreshSeriesEpoch(model.InvalidSeriesEpoch)
+		// we don't have any great place to report errors, and if the
+		// connection recovers we can still make progress, so we'll just log it
+		// and continue execution
+		if err != nil {
+			log.Error("msg", "error refreshing the series cache", "err", err)
+		}
 		inserter.doneWG.Add(1)
 		go func() {
 			defer inserter.doneWG.Done()
-			inserter.runSeriesEpochSync()
+			inserter.runSeriesEpochSync(epoch)
+		}()
+	}
+
+	if cfg.WarmUpSeries > 0 {
+		inserter.doneWG.Add(1)
+		go func() {
+			defer inserter.doneWG.Done()
+			inserter.runWarmUp(cfg.WarmUpSeries, cfg.WarmUpByActivity, cfg.WarmUpTimeout)
 		}()
 	}
 	return inserter, nil
@@ -131,14 +187,8 @@ func (p *pgxDispatcher) runCompleteMetricCreationWorker() {
 	}
 }
 
-func (p *pgxDispatcher) runSeriesEpochSync() {
-	epoch, err := p.refreshSeriesEpoch(model.InvalidSeriesEpoch)
-	// we don't have any great place to report errors, and if the
-	// connection recovers we can still make progress, so we'll just log it
-	// and continue execution
-	if err != nil {
-		log.Error("msg", "error refreshing the series cache", "err", err)
-	}
+func (p *pgxDispatcher) runSeriesEpochSync(epoch model.SeriesEpoch) {
+	var err error
 	for {
 		select {
 		case <-p.seriesEpochRefresh.C:
@@ -166,6 +216,9 @@ func (p *pgxDispatcher) refreshSeriesEpoch(existingEpoch model.SeriesEpoch) (mod
 		// If the series cache needs to be invalidated, so does the inverted labels cache
 		p.invertedLabelsCache.Reset()
 	}
+	// The shared cache entries of older epochs are ignored, as they would
+	// have been reset from the local caches.
+	p.sharedCache.SetEpoch(dbEpoch)
 	return dbEpoch, nil
 }
 
@@ -198,13 +251,17 @@ func (p *pgxDispatcher) Close() {
 		return
 	}
 	p.closed.Store(true)
-	close(p.completeMetricCreation)
+	// The batchers send their pending batches to the copiers before
+	// exiting, and the copiers write all the batches queued before exiting.
 	p.batchers.Range(func(key, value interface{}) bool {
 		close(value.(chan *insertDataRequest))
 		return true
 	})
-
+	p.batchersWG.Wait()
 	close(p.copierReadRequestCh)
+	p.copiersWG.Wait()
+
+	close(p.completeMetricCreation)
 	close(p.doneChannel)
 	p.doneWG.Wait()
 }
@@ -215,10 +272,18 @@ func (p *pgxDispatcher) Close() {
 // actually inserted) and any error.
 // Though we may insert data to multiple tables concurrently, if asyncAcks is
 // unset this function will wait until _all_ the insert attempts have completed.
+// The AckMode set on ctx with WithAckMode takes precedence over asyncAcks.
 func (p *pgxDispatcher) InsertTs(ctx context.Context, dataTS model.Data) (uint64, error) {
 	if p.closed.Load() {
 		return 0, ErrDispatcherClosed
 	}
+	if err := p.backpressure.Allow(); err != nil {
+		return 0, err
+	}
+	sizeBytes := dataBytes(dataTS.Rows)
+	if err := p.memoryBudget.ReserveBatch(sizeBytes); err != nil {
+		return 0, err
+	}
 	_, span := tracer.Default().Start(ctx, "dispatcher-insert-ts")
 	defer span.End()
 	var (
@@ -251,8 +316,9 @@ func (p *pgxDispatcher) InsertTs(ctx context.Context, dataTS model.Data) (uint64
 	}
 
 	var err error
-	if !p.asyncAcks {
+	if !useAsyncAcks(ctx, p.asyncAcks) {
 		workFinished.Wait()
+		p.memoryBudget.ReleaseBatch(sizeBytes)
 		reportOutgoing()
 		select {
 		case err = <-errChan:
@@ -263,6 +329,7 @@ func (p *pgxDispatcher) InsertTs(ctx context.Context, dataTS model.Data) (uint64
 	} else {
 		go func() {
 			workFinished.Wait()
+			p.memoryBudget.ReleaseBatch(sizeBytes)
 			reportOutgoing()
 			select {
 			case err = <-errChan:
@@ -279,6 +346,17 @@ func (p *pgxDispatcher) InsertTs(ctx context.Context, dataTS model.Data) (uint64
 	return numRows, err
 }
 
+// dataBytes estimates the memory used by the rows in the ingest.
+func dataBytes(rows map[string][]model.Insertable) uint64 {
+	size := uint64(0)
+	for _, data := range rows {
+		for _, insertable := range data {
+			size += insertableOverheadBytes + uint64(insertable.Count())*sampleBytes
+		}
+	}
+	return size
+}
+
 func (p *pgxDispatcher) InsertMetadata(ctx context.Context, metadata []model.Metadata) (uint64, error) {
 	if p.closed.Load() {
 		return 0, ErrDispatcherClosed
@@ -321,7 +399,11 @@ func (p *pgxDispatcher) getMetricBatcher(metric string) chan<- *insertDataReques
 		actual, old := p.batchers.LoadOrStore(metric, c)
 		batcher = actual
 		if !old {
-			go runMetricBatcher(p.conn, c, metric, p.completeMetricCreation, p.metricTableNames, p.copierReadRequestCh)
+			p.batchersWG.Add(1)
+			go func() {
+				defer p.batchersWG.Done()
+				runMetricBatcher(p.conn, c, metric, p.completeMetricCreation, p.metricTableNames, p.encodings, p.partitioning, p.copierReadRequestCh)
+			}()
 		}
 	}
 	ch := batcher.(chan *insertDataRequest)
@@ -19,74 +19,58 @@ func getSeries(t *testing.T, scache *cache.SeriesCacheImpl, labels labels.Labels
 	return series
 }
 
-func makeLabelKey(l labels.Label) cache.LabelKey {
-	return cache.LabelKey{MetricName: "metric", Name: l.Name, Value: l.Value}
-}
-
-func TestLabelArrayCreator(t *testing.T) {
+func TestSeriesBatchBuilder(t *testing.T) {
 	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+	lcache, err := cache.NewInvertedLabelsCache(10)
+	require.NoError(t, err)
+	sw := NewSeriesWriter(nil, lcache, nil)
+
 	metricNameLabel := labels.Label{Name: "__name__", Value: "metric"}
 	valOne := labels.Label{Name: "key", Value: "one"}
 	valTwo := labels.Label{Name: "key", Value: "two"}
-	seriesSet := []*model.Series{
-		getSeries(t, scache, labels.Labels{metricNameLabel, valOne}),
+	otherMetric := labels.Label{Name: "__name__", Value: "another"}
+	require.True(t, lcache.Put(cache.NewLabelKey("metric", metricNameLabel.Name, metricNameLabel.Value), cache.NewLabelInfo(100, 1)))
+	require.True(t, lcache.Put(cache.NewLabelKey("metric", valOne.Name, valOne.Value), cache.NewLabelInfo(1, 5)))
+
+	setSeries := getSeries(t, scache, labels.Labels{metricNameLabel, valTwo})
+	setSeries.SetSeriesID(5, 4)
+	infos := map[string]*model.MetricInfo{
+		"metric":  {MetricID: 1, TableName: "metric"},
+		"another": {MetricID: 2, TableName: "another_table"},
 	}
-	labelMap := map[cache.LabelKey]cache.LabelInfo{
-		makeLabelKey(metricNameLabel): cache.NewLabelInfo(2, 1),
-		makeLabelKey(valOne):          cache.NewLabelInfo(3, 2),
+	seriesByMetric := map[string][]*model.Series{
+		"metric": {
+			getSeries(t, scache, labels.Labels{metricNameLabel, valOne}),
+			setSeries,
+		},
+		"another": {
+			getSeries(t, scache, labels.Labels{otherMetric, valOne}),
+		},
 	}
 
-	res, _, err := createLabelArrays(seriesSet, labelMap, 2)
+	batch, err := sw.buildSeriesBatch(infos, seriesByMetric)
 	require.NoError(t, err)
-	expected := [][]int32{{2, 3}}
-	require.Equal(t, res, expected)
 
-	res, _, err = createLabelArrays(seriesSet, labelMap, 3)
-	require.NoError(t, err)
-	expected = [][]int32{{2, 3}}
-	require.Equal(t, res, expected)
-
-	labelMap[makeLabelKey(valOne)] = cache.NewLabelInfo(3, 3)
-	res, _, err = createLabelArrays(seriesSet, labelMap, 3)
-	require.NoError(t, err)
-	expected = [][]int32{{2, 0, 3}}
-	require.Equal(t, res, expected)
+	// The metrics are sorted by name, the series already set are skipped.
+	require.Equal(t, []string{"another", "metric"}, batch.metricNames)
+	require.Equal(t, []string{"another_table", "metric"}, batch.metricTables)
+	require.Equal(t, []int32{2, 1}, batch.metricIDs)
+	require.Len(t, batch.series, 2)
 
-	/* test two series */
-	seriesSet = []*model.Series{
-		getSeries(t, scache, labels.Labels{metricNameLabel, valOne}),
-		getSeries(t, scache, labels.Labels{metricNameLabel, valTwo}),
-	}
-	labelMap = map[cache.LabelKey]cache.LabelInfo{
-		makeLabelKey(metricNameLabel): cache.NewLabelInfo(100, 1),
-		makeLabelKey(valOne):          cache.NewLabelInfo(1, 5),
-		makeLabelKey(valTwo):          cache.NewLabelInfo(2, 5),
+	expectedLabels := model.NewLabelList(4)
+	for _, l := range []labels.Label{otherMetric, valOne, metricNameLabel, valOne} {
+		require.NoError(t, expectedLabels.Add(l.Name, l.Value))
 	}
+	expectedNames, expectedValues := expectedLabels.Get()
+	names, values := batch.labels.Get()
+	require.Equal(t, expectedNames, names)
+	require.Equal(t, expectedValues, values)
+	require.Equal(t, []int32{1, 1, 2, 2}, batch.labelSeries)
+	require.Equal(t, []int32{1, 1, 2, 2}, batch.labelMetrics)
 
-	res, ser, err := createLabelArrays(seriesSet, labelMap, 5)
-	require.NoError(t, err)
-	require.Equal(t, len(ser), 2)
-	expected = [][]int32{
-		{100, 0, 0, 0, 1},
-		{100, 0, 0, 0, 2},
-	}
-	require.Equal(t, res, expected)
-
-	/* test one series already set */
-	setSeries := getSeries(t, scache, labels.Labels{metricNameLabel, valTwo})
-	setSeries.SetSeriesID(5, 4)
-	seriesSet = []*model.Series{
-		getSeries(t, scache, labels.Labels{metricNameLabel, valOne}),
-		setSeries,
-	}
-	labelMap = map[cache.LabelKey]cache.LabelInfo{
-		makeLabelKey(metricNameLabel): cache.NewLabelInfo(100, 1),
-		makeLabelKey(valOne):          cache.NewLabelInfo(1, 5),
-		makeLabelKey(valTwo):          cache.NewLabelInfo(2, 5),
-	}
-	res, ser, err = createLabelArrays(seriesSet, labelMap, 5)
-	require.NoError(t, err)
-	require.Equal(t, len(ser), 1)
-	expected = [][]int32{{100, 0, 0, 0, 1}}
-	require.Equal(t, res, expected)
+	// Only the labels of metric are cached.
+	require.Equal(t, []int32{0, 0, 100, 1}, batch.labelIDs)
+	require.Equal(t, []int32{0, 0, 1, 5}, batch.labelPos)
+	require.Equal(t, []bool{false, false}, batch.series[0].cached)
+	require.Equal(t, []bool{true, true}, batch.series[1].cached)
 }
@@ -13,13 +13,26 @@ import (
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.uber.org/atomic"
 
+	"github.com/timescale/promscale/pkg/dedup"
+	"github.com/timescale/promscale/pkg/metricfilter"
+	"github.com/timescale/promscale/pkg/outoforder"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/cache/shared"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/memory"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/partitioning"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/relabel"
+	"github.com/timescale/promscale/pkg/spanmetrics"
+	"github.com/timescale/promscale/pkg/tenancy"
+	"github.com/timescale/promscale/pkg/traceforward"
 	"github.com/timescale/promscale/pkg/tracer"
 )
 
@@ -33,6 +46,31 @@ type Cfg struct {
 	TracesBatchTimeout      time.Duration
 	TracesMaxBatchSize      int
 	TracesBatchWorkers      int
+	TenantLimiter           *ratelimit.Limiter
+	Relabeler               *relabel.Relabeler
+	ExternalLabels          *relabel.ExternalLabels
+	MetricFilter            *metricfilter.Filter
+	Dedup                   *dedup.Deduplicator
+	OutOfOrder              *outoforder.Window
+	LastWriteWins           bool
+	ValueEncodings          *encoding.Resolver
+	TenantPartitioning      *partitioning.Layout
+	TailSampling            *trace.TailSamplingPolicies
+	SpanMetrics             spanmetrics.Config
+	TraceForwarder          *traceforward.Forwarder
+	CacheSizer              *cache.AdaptiveSizer
+	WarmUpSeries            uint64
+	WarmUpByActivity        bool
+	WarmUpTimeout           time.Duration
+	Backpressure            backpressure.Config
+	// MemoryBudget is shared by the ingestors of all the shards, nil if
+	// the ingest memory is not bounded.
+	MemoryBudget *memory.Budget
+	SharedCache  *shared.Cache
+	// Shard is the shard written to if the metrics are sharded across
+	// databases and it is not the first one. Such an ingestor only writes
+	// metrics, the traces and metadata being written to the first shard.
+	Shard string
 }
 
 // DBIngestor ingest the TimeSeries data into Timescale database.
@@ -40,7 +78,21 @@ type DBIngestor struct {
 	sCache     cache.SeriesCache
 	dispatcher model.Dispatcher
 	tWriter    trace.Writer
-	closed     *atomic.Bool
+	limiter    *ratelimit.Limiter
+	relabeler  *relabel.Relabeler
+	// externalLabels is nil if no external labels are enforced.
+	externalLabels *relabel.ExternalLabels
+	// metricFilter is nil if no metric is filtered.
+	metricFilter *metricfilter.Filter
+	// dedup is nil if the samples written twice are all inserted.
+	dedup *dedup.Deduplicator
+	// outOfOrder is nil if the samples of any age are inserted.
+	outOfOrder *outoforder.Window
+	// spanMetrics is nil if span metrics are disabled.
+	spanMetrics *spanmetrics.Generator
+	// forwarder is nil if the spans are not forwarded.
+	forwarder *traceforward.Forwarder
+	closed    *atomic.Bool
 }
 
 // NewPgxIngestor returns a new Ingestor that uses connection pool and a metrics cache
@@ -56,13 +108,31 @@ func NewPgxIngestor(conn pgxconn.PgxConn, cache cache.MetricCache, sCache cache.
 		BatchTimeout: cfg.TracesBatchTimeout,
 		Writers:      cfg.NumCopiers,
 	}
-	traceWriter := trace.NewWriter(conn)
-	return &DBIngestor{
-		sCache:     sCache,
-		dispatcher: dispatcher,
-		tWriter:    trace.NewDispatcher(traceWriter, cfg.TracesAsyncAcks, batcherConfg),
-		closed:     atomic.NewBool(false),
-	}, nil
+	var (
+		tWriter   trace.Writer = noTraceWriter{}
+		forwarder *traceforward.Forwarder
+	)
+	if cfg.Shard == "" {
+		tWriter = trace.NewTailSampler(trace.NewDispatcher(trace.NewWriter(conn), cfg.TracesAsyncAcks, batcherConfg), cfg.TailSampling)
+		forwarder = cfg.TraceForwarder
+	}
+	ingestor := &DBIngestor{
+		sCache:         sCache,
+		dispatcher:     dispatcher,
+		tWriter:        tWriter,
+		limiter:        cfg.TenantLimiter,
+		relabeler:      cfg.Relabeler,
+		externalLabels: cfg.ExternalLabels,
+		metricFilter:   cfg.MetricFilter,
+		dedup:          cfg.Dedup,
+		outOfOrder:     cfg.OutOfOrder,
+		forwarder:      forwarder,
+		closed:         atomic.NewBool(false),
+	}
+	if ingestor.spanMetrics = spanmetrics.NewGenerator(cfg.SpanMetrics, ingestor); ingestor.spanMetrics != nil {
+		ingestor.spanMetrics.Run()
+	}
+	return ingestor, nil
 }
 
 // NewPgxIngestorForTests returns a new Ingestor that write to PostgreSQL using PGX
@@ -96,13 +166,21 @@ func (ingestor *DBIngestor) IngestTraces(ctx context.Context, traces ptrace.Trac
 	}
 	_, span := tracer.Default().Start(ctx, "ingest-traces")
 	defer span.End()
+	// Span metrics count all the spans, including those dropped by tail sampling.
+	if ingestor.spanMetrics != nil {
+		ingestor.spanMetrics.Add(traces)
+	}
+	// The spans are forwarded before tail sampling, the receivers sample them
+	// on their own.
+	ingestor.forwarder.Forward(traces)
 	return ingestor.tWriter.InsertTraces(ctx, traces)
 }
 
 // IngestMetrics transforms and ingests the timeseries data into Timescale database.
 // input:
-//     req the WriteRequest backing tts. It will be added to our WriteRequest
-//         pool when it is no longer needed.
+//
+//	req the WriteRequest backing tts. It will be added to our WriteRequest
+//	    pool when it is no longer needed.
 func (ingestor *DBIngestor) IngestMetrics(ctx context.Context, r *prompb.WriteRequest) (numInsertablesIngested uint64, numMetadataIngested uint64, err error) {
 	if ingestor.closed.Load() {
 		return 0, 0, fmt.Errorf("ingestor is closed and can't ingest metrics")
@@ -157,6 +235,9 @@ func (ingestor *DBIngestor) IngestMetrics(ctx context.Context, r *prompb.WriteRe
 	}()
 
 	mergeErr := func(prevErr, err error, message string) error {
+		if err == nil {
+			return prevErr
+		}
 		if prevErr != nil {
 			err = fmt.Errorf("%s: %s: %w", prevErr.Error(), message, err)
 		}
@@ -184,14 +265,28 @@ func (ingestor *DBIngestor) IngestMetrics(ctx context.Context, r *prompb.WriteRe
 	return numInsertablesIngested, numMetadataIngested, err
 }
 
-func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []prompb.TimeSeries, releaseMem func()) (uint64, error) {
+func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []prompb.TimeSeries, releaseMem func()) (_ uint64, ingestErr error) {
 	ctx, span := tracer.Default().Start(ctx, "ingest-timeseries")
 	defer span.End()
 	var (
 		totalRowsExpected uint64
 
 		insertables = make(map[string][]model.Insertable)
+		// writes holds the number of samples and new series of each
+		// tenant, used to enforce the tenant limits.
+		writes = make(tenantWrites)
+		// deduped holds the samples remembered by the deduplicator, which
+		// are forgotten if the request fails so that its retry is not
+		// dropped.
+		deduped []dedupedSamples
 	)
+	defer func() {
+		if ingestErr != nil {
+			for _, d := range deduped {
+				ingestor.dedup.Forget(d.series, d.samples)
+			}
+		}
+	}()
 
 	for i := range timeseries {
 		var (
@@ -204,6 +299,33 @@ func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []p
 		if len(ts.Labels) == 0 {
 			continue
 		}
+		// Relabel before the series is created, so that the dropped series
+		// and labels are never stored.
+		if ingestor.relabeler != nil {
+			var keep bool
+			if ts.Labels, keep = ingestor.relabeler.Process(ts.Labels); !keep {
+				continue
+			}
+		}
+		// The external labels are enforced after relabeling, so that the
+		// rules cannot change them.
+		if ingestor.externalLabels != nil {
+			var keep bool
+			if ts.Labels, keep, err = ingestor.externalLabels.Process(ts.Labels); err != nil {
+				return 0, err
+			} else if !keep {
+				continue
+			}
+		}
+		// The metrics are filtered on their final labels, before the
+		// series of the dropped ones are cached.
+		if !ingestor.metricFilter.Keep(ts) {
+			continue
+		}
+		var tw *tenantWrite
+		if ingestor.limiter != nil {
+			tw = writes.get(getTenant(ts.Labels))
+		}
 		// Normalize and canonicalize ts.Labels.
 		// After this point ts.Labels should never be used again.
 		series, metricName, err = ingestor.sCache.GetSeriesFromProtos(ts.Labels)
@@ -213,6 +335,25 @@ func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []p
 		if metricName == "" {
 			return 0, errors.ErrNoMetricName
 		}
+		// The duplicates are dropped before they are counted as out of
+		// order, and both before they count towards the tenant limits.
+		var seriesKey string
+		if ingestor.dedup != nil {
+			seriesKey = series.String()
+			ts.Samples = ingestor.dedup.Filter(seriesKey, ts.Samples)
+		}
+		ts.Samples = ingestor.outOfOrder.Filter(metricName, ts.Samples)
+		if ingestor.dedup != nil {
+			// The samples dropped as out of order are dropped again when
+			// retried, only the samples to insert are to be forgotten.
+			deduped = append(deduped, dedupedSamples{series: seriesKey, samples: ts.Samples})
+		}
+		if tw != nil {
+			tw.samples += len(ts.Samples)
+			if !series.IsSeriesIDSet() {
+				tw.newSeries++
+			}
+		}
 
 		if len(ts.Samples) > 0 {
 			samples, count, err := ingestor.samples(series, ts)
@@ -237,6 +378,12 @@ func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []p
 	}
 	releaseMem()
 
+	for tenant, tw := range writes {
+		if err := ingestor.limiter.AllowWrite(tenant, tw.samples, tw.newSeries); err != nil {
+			return 0, err
+		}
+	}
+
 	numInsertablesIngested, errSamples := ingestor.dispatcher.InsertTs(ctx, model.Data{Rows: insertables, ReceivedTime: time.Now()})
 	if errSamples == nil && numInsertablesIngested != totalRowsExpected {
 		return numInsertablesIngested, fmt.Errorf("failed to insert all the data! Expected: %d, Got: %d", totalRowsExpected, numInsertablesIngested)
@@ -244,6 +391,39 @@ func (ingestor *DBIngestor) ingestTimeseries(ctx context.Context, timeseries []p
 	return numInsertablesIngested, errSamples
 }
 
+// dedupedSamples are the samples of a series kept by the deduplicator.
+type dedupedSamples struct {
+	series  string
+	samples []prompb.Sample
+}
+
+type tenantWrite struct {
+	samples   int
+	newSeries int
+}
+
+type tenantWrites map[string]*tenantWrite
+
+func (t tenantWrites) get(tenant string) *tenantWrite {
+	tw, ok := t[tenant]
+	if !ok {
+		tw = &tenantWrite{}
+		t[tenant] = tw
+	}
+	return tw
+}
+
+// getTenant returns the tenant of a series, or an empty string for the
+// series that do not belong to any tenant.
+func getTenant(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == tenancy.TenantLabelKey {
+			return l.Value
+		}
+	}
+	return ""
+}
+
 func (ingestor *DBIngestor) samples(l *model.Series, ts *prompb.TimeSeries) (model.Insertable, int, error) {
 	return model.NewPromSamples(l, ts.Samples), len(ts.Samples), nil
 }
@@ -258,15 +438,24 @@ func (ingestor *DBIngestor) ingestMetadata(ctx context.Context, metadata []promp
 	_, span := tracer.Default().Start(ctx, "ingest-metadata")
 	defer span.End()
 	num := len(metadata)
-	data := make([]model.Metadata, num)
+	data := make([]model.Metadata, 0, num)
+	// A request can hold the same metadata more than once, e.g. when it was
+	// scraped from several targets. It must be inserted once, since the rows
+	// of a single insert can't conflict with each other.
+	seen := make(map[model.Metadata]struct{}, num)
 	for i := 0; i < num; i++ {
 		tmp := metadata[i]
-		data[i] = model.Metadata{
+		md := model.Metadata{
 			MetricFamily: tmp.MetricFamilyName,
 			Unit:         tmp.Unit,
 			Type:         tmp.Type.String(),
 			Help:         tmp.Help,
 		}
+		if _, ok := seen[md]; ok {
+			continue
+		}
+		seen[md] = struct{}{}
+		data = append(data, md)
 	}
 	releaseMem()
 	numMetadataIngested, errMetadata := ingestor.dispatcher.InsertMetadata(ctx, data)
@@ -280,6 +469,14 @@ func (ingestor *DBIngestor) Dispatcher() model.Dispatcher {
 	return ingestor.dispatcher
 }
 
+// WarmedUp returns false while the caches are being warmed up on startup.
+func (ingestor *DBIngestor) WarmedUp() bool {
+	if d, ok := ingestor.dispatcher.(*pgxDispatcher); ok {
+		return d.WarmedUp()
+	}
+	return true
+}
+
 func (ingestor *DBIngestor) SeriesCache() cache.SeriesCache {
 	return ingestor.sCache
 }
@@ -294,7 +491,11 @@ func (ingestor *DBIngestor) Close() {
 	if ingestor.closed.Load() {
 		return
 	}
+	if ingestor.spanMetrics != nil {
+		ingestor.spanMetrics.Stop()
+	}
 	ingestor.tWriter.Close()
+	ingestor.forwarder.Close()
 	ingestor.closed.Store(true)
 	ingestor.dispatcher.Close()
 }
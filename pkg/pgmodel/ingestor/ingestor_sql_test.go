@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/jackc/pgconn"
-	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/labels"
@@ -31,14 +30,6 @@ const (
 	tableName string = "table name"
 )
 
-func getTestLabelArray(t *testing.T, l [][]int32) *pgtype.ArrayType {
-	model.SetLabelArrayOIDForTest(0)
-	labelArrayArray := model.GetCustomType(model.LabelArray)
-	err := labelArrayArray.Set(l)
-	require.NoError(t, err)
-	return labelArrayArray
-}
-
 func init() {
 	tput.InitWatcher(time.Second)
 }
@@ -59,9 +50,61 @@ func (c sVisitor) VisitSeries(cb func(info *pgmodel.MetricInfo, s *pgmodel.Serie
 	return nil
 }
 
+// seriesIDsQuery is the get_or_create_series_ids call of the labels of
+// series, all of metric_1 and not cached. results are the series IDs
+// returned for each series.
+func seriesIDsQuery(series [][]labels.Label, labelIDs map[string]int32, positions map[string]int32, results []int64) model.SqlQuery {
+	var (
+		labelSeries, labelMetrics, zeros []int32
+		names, values                    []string
+		rows                             model.RowResults
+	)
+	for i, ls := range series {
+		var ids, pos []int32
+		for _, l := range ls {
+			labelSeries = append(labelSeries, int32(i+1))
+			labelMetrics = append(labelMetrics, 1)
+			zeros = append(zeros, 0)
+			names = append(names, l.Name)
+			values = append(values, l.Value)
+			ids = append(ids, labelIDs[l.Name+"="+l.Value])
+			pos = append(pos, positions[l.Name])
+		}
+		rows = append(rows, []interface{}{int32(i + 1), results[i], ids, pos})
+	}
+	return model.SqlQuery{
+		Sql: seriesIDsSQL,
+		Args: []interface{}{
+			[]string{"metric_1"},
+			[]string{tableName},
+			[]int32{int32(metricID)},
+			labelSeries,
+			labelMetrics,
+			names,
+			values,
+			zeros,
+			zeros,
+		},
+		Results: rows,
+	}
+}
+
 func TestPGXInserterInsertSeries(t *testing.T) {
 	// Set test env so that cache metrics uses a new registry and avoid panic on duplicate register.
 	require.NoError(t, os.Setenv("IS_TEST", "true"))
+	epochQuery := model.SqlQuery{
+		Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
+		Args:    []interface{}(nil),
+		Results: model.RowResults{{int64(1)}},
+		Err:     error(nil),
+	}
+	labelIDs := map[string]int32{"__name__=metric_1": 1, "name_1=value_1": 2, "name_2=value_2": 3}
+	positions := map[string]int32{"__name__": 1, "name_1": 2, "name_2": 3}
+	seriesOne := []labels.Label{{Name: "__name__", Value: "metric_1"}, {Name: "name_1", Value: "value_1"}}
+	seriesTwo := []labels.Label{{Name: "__name__", Value: "metric_1"}, {Name: "name_2", Value: "value_2"}}
+	queryErr := seriesIDsQuery([][]labels.Label{seriesOne, seriesTwo}, labelIDs, positions, []int64{1, 2})
+	queryErr.Err = fmt.Errorf("some query error")
+
 	testCases := []struct {
 		name       string
 		series     []labels.Labels
@@ -80,41 +123,8 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 			},
 
 			sqlQueries: []model.SqlQuery{
-				{Sql: "BEGIN;"},
-				{
-					Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
-					Args:    []interface{}(nil),
-					Results: model.RowResults{{int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-					Args: []interface{}{
-						"metric_1",
-						tableName,
-						[]string{"__name__", "name_1"},
-						[]string{"metric_1", "value_1"},
-					},
-					Results: model.RowResults{
-						{[]int32{1, 2}, []int32{1, 2}, []string{"__name__", "name_1"}, []string{"metric_1", "value_1"}},
-					},
-					Err: error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: seriesInsertSQL,
-					Args: []interface{}{
-						metricID,
-						tableName,
-						getTestLabelArray(t, [][]int32{{1, 2}}),
-					},
-					Results: model.RowResults{{int64(1), int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
+				epochQuery,
+				seriesIDsQuery([][]labels.Label{seriesOne}, labelIDs, positions, []int64{1}),
 			},
 		},
 		{
@@ -130,44 +140,12 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 				},
 			},
 			sqlQueries: []model.SqlQuery{
-				{Sql: "BEGIN;"},
-				{
-					Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
-					Args:    []interface{}(nil),
-					Results: model.RowResults{{int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-					Args: []interface{}{
-						"metric_1",
-						tableName,
-						[]string{"__name__", "name_1", "name_2"},
-						[]string{"metric_1", "value_1", "value_2"},
-					},
-					Results: model.RowResults{
-						{[]int32{1, 2, 3}, []int32{1, 2, 3}, []string{"__name__", "name_1", "name_2"}, []string{"metric_1", "value_1", "value_2"}},
-					},
-					Err: error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: seriesInsertSQL,
-					Args: []interface{}{
-						metricID,
-						tableName,
-						getTestLabelArray(t, [][]int32{{1, 2}, {1, 0, 3}}),
-					},
-					Results: model.RowResults{{int64(1), int64(1)}, {int64(2), int64(2)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
+				epochQuery,
+				seriesIDsQuery([][]labels.Label{seriesOne, seriesTwo}, labelIDs, positions, []int64{1, 2}),
 			},
 		},
 		{
+			// The duplicate series is sent once.
 			name: "Double series",
 			series: []labels.Labels{
 				{
@@ -182,41 +160,8 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 				},
 			},
 			sqlQueries: []model.SqlQuery{
-				{Sql: "BEGIN;"},
-				{
-					Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
-					Args:    []interface{}(nil),
-					Results: model.RowResults{{int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-					Args: []interface{}{
-						"metric_1",
-						tableName,
-						[]string{"__name__", "name_1", "name_2"},
-						[]string{"metric_1", "value_1", "value_2"},
-					},
-					Results: model.RowResults{
-						{[]int32{1, 2, 3}, []int32{1, 2, 3}, []string{"__name__", "name_1", "name_2"}, []string{"metric_1", "value_1", "value_2"}},
-					},
-					Err: error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: seriesInsertSQL,
-					Args: []interface{}{
-						metricID,
-						tableName,
-						getTestLabelArray(t, [][]int32{{1, 2}, {1, 0, 3}, {1, 2}}),
-					},
-					Results: model.RowResults{{int64(1), int64(1)}, {int64(2), int64(2)}, {int64(1), int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
+				epochQuery,
+				seriesIDsQuery([][]labels.Label{seriesOne, seriesTwo}, labelIDs, positions, []int64{1, 2}),
 			},
 		},
 		{
@@ -231,32 +176,8 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 				},
 			},
 			sqlQueries: []model.SqlQuery{
-				{Sql: "BEGIN;"},
-				{
-					Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
-					Args:    []interface{}(nil),
-					Results: model.RowResults{{int64(1)}},
-					Err:     error(nil),
-				},
-				{Sql: "COMMIT;"},
-				{Sql: "BEGIN;"},
-				{
-					Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-					Args: []interface{}{
-						"metric_1",
-						tableName,
-						[]string{"__name__", "name_1", "name_2"},
-						[]string{"metric_1", "value_1", "value_2"},
-					},
-					Results: model.RowResults{
-						{int32(1), int32(1), "__name__", "metric_1"},
-						{int32(1), int32(2), "__name__", "metric_2"},
-						{int32(2), int32(3), "name_1", "value_1"},
-						{int32(3), int32(4), "name_2", "value_2"},
-					},
-					Err: fmt.Errorf("some query error"),
-				},
-				{Sql: "COMMIT;"},
+				epochQuery,
+				queryErr,
 			},
 		},
 	}
@@ -265,7 +186,7 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 		t.Run(c.name, func(t *testing.T) {
 			for i := range c.sqlQueries {
 				for j := range c.sqlQueries[i].Args {
-					if _, ok := c.sqlQueries[i].Args[j].([]string); ok {
+					if _, ok := c.sqlQueries[i].Args[j].([]string); ok && j >= 5 {
 						tmp := &pgutf8str.TextArray{}
 						err := tmp.Set(c.sqlQueries[i].Args[j])
 						require.NoError(t, err)
@@ -277,7 +198,7 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 			scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
 			scache.Reset()
 			lCache, _ := cache.NewInvertedLabelsCache(10)
-			sw := NewSeriesWriter(mock, 0, lCache)
+			sw := NewSeriesWriter(mock, lCache, nil)
 
 			lsi := make([]model.Insertable, 0)
 			for _, ser := range c.series {
@@ -311,11 +232,66 @@ func TestPGXInserterInsertSeries(t *testing.T) {
 					require.True(t, si > 0, "series id not set")
 					require.True(t, se > 0, "epoch not set")
 				}
+				// The labels resolved by the database are cached.
+				for _, ls := range c.series {
+					for _, l := range ls {
+						info, found := lCache.GetLabelsId(cache.NewLabelKey("metric_1", l.Name, l.Value))
+						require.True(t, found, "label %v not cached", l)
+						require.Equal(t, cache.NewLabelInfo(labelIDs[l.Name+"="+l.Value], positions[l.Name]), info)
+					}
+				}
 			}
 		})
 	}
 }
 
+func TestPGXInserterCachedLabels(t *testing.T) {
+	require.NoError(t, os.Setenv("IS_TEST", "true"))
+	lCache, _ := cache.NewInvertedLabelsCache(10)
+	lCache.Put(cache.NewLabelKey("metric_1", "__name__", "metric_1"), cache.NewLabelInfo(1, 1))
+
+	names := &pgutf8str.TextArray{}
+	require.NoError(t, names.Set([]string{"__name__", "name_1"}))
+	values := &pgutf8str.TextArray{}
+	require.NoError(t, values.Set([]string{"metric_1", "value_1"}))
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{
+			Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
+			Results: model.RowResults{{int64(1)}},
+		},
+		{
+			Sql: seriesIDsSQL,
+			Args: []interface{}{
+				[]string{"metric_1"},
+				[]string{tableName},
+				[]int32{int32(metricID)},
+				[]int32{1, 1},
+				[]int32{1, 1},
+				names,
+				values,
+				// The cached label is sent with its ID and position.
+				[]int32{1, 0},
+				[]int32{1, 0},
+			},
+			Results: model.RowResults{{int32(1), int64(7), []int32{1, 2}, []int32{1, 2}}},
+		},
+	}, t)
+
+	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+	series, err := scache.GetSeriesFromLabels(labels.Labels{{Name: "__name__", Value: "metric_1"}, {Name: "name_1", Value: "value_1"}})
+	require.NoError(t, err)
+	sw := NewSeriesWriter(mock, lCache, nil)
+	require.NoError(t, sw.PopulateOrCreateSeries(context.Background(), sVisitor{model.NewPromSamples(series, nil)}))
+
+	id, epoch, err := series.GetSeriesID()
+	require.NoError(t, err)
+	require.Equal(t, model.SeriesID(7), id)
+	require.Equal(t, model.SeriesEpoch(1), epoch)
+	info, found := lCache.GetLabelsId(cache.NewLabelKey("metric_1", "name_1", "value_1"))
+	require.True(t, found)
+	require.Equal(t, cache.NewLabelInfo(2, 2), info)
+}
+
 func TestPGXInserterCacheReset(t *testing.T) {
 	series := []labels.Labels{
 		{
@@ -328,44 +304,22 @@ func TestPGXInserterCacheReset(t *testing.T) {
 		},
 	}
 
+	labelIDs := map[string]int32{"__name__=metric_1": 1, "name_1=value_1": 2, "name_1=value_2": 3}
+	positions := map[string]int32{"__name__": 1, "name_1": 2}
+	sortedSeries := [][]labels.Label{
+		{{Name: "__name__", Value: "metric_1"}, {Name: "name_1", Value: "value_1"}},
+		{{Name: "__name__", Value: "metric_1"}, {Name: "name_1", Value: "value_2"}},
+	}
 	sqlQueries := []model.SqlQuery{
 
 		// first series cache fetch
-		{Sql: "BEGIN;"},
 		{
 			Sql:     "SELECT current_epoch FROM _prom_catalog.ids_epoch LIMIT 1",
 			Args:    []interface{}(nil),
 			Results: model.RowResults{{int64(1)}},
 			Err:     error(nil),
 		},
-		{Sql: "COMMIT;"},
-		{Sql: "BEGIN;"},
-		{
-			Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-			Args: []interface{}{
-				"metric_1",
-				tableName,
-				[]string{"__name__", "name_1", "name_1"},
-				[]string{"metric_1", "value_1", "value_2"},
-			},
-			Results: model.RowResults{
-				{[]int32{1, 2, 2}, []int32{1, 2, 3}, []string{"__name__", "name_1", "name_1"}, []string{"metric_1", "value_1", "value_2"}},
-			},
-			Err: error(nil),
-		},
-		{Sql: "COMMIT;"},
-		{Sql: "BEGIN;"},
-		{
-			Sql: seriesInsertSQL,
-			Args: []interface{}{
-				metricID,
-				tableName,
-				getTestLabelArray(t, [][]int32{{1, 2}, {1, 3}}),
-			},
-			Results: model.RowResults{{int64(1), int64(1)}, {int64(2), int64(2)}},
-			Err:     error(nil),
-		},
-		{Sql: "COMMIT;"},
+		seriesIDsQuery(sortedSeries, labelIDs, positions, []int64{1, 2}),
 
 		// first labels cache refresh, does not trash
 		{
@@ -382,7 +336,6 @@ func TestPGXInserterCacheReset(t *testing.T) {
 			Results: model.RowResults{{int64(2)}},
 			Err:     error(nil),
 		},
-		{Sql: "BEGIN;"},
 
 		// repopulate the cache
 		{
@@ -391,39 +344,12 @@ func TestPGXInserterCacheReset(t *testing.T) {
 			Results: model.RowResults{{int64(2)}},
 			Err:     error(nil),
 		},
-		{Sql: "COMMIT;"},
-		{Sql: "BEGIN;"},
-		{
-			Sql: "SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)",
-			Args: []interface{}{
-				"metric_1",
-				tableName,
-				[]string{"__name__", "name_1", "name_1"},
-				[]string{"metric_1", "value_1", "value_2"},
-			},
-			Results: model.RowResults{
-				{[]int32{1, 2, 2}, []int32{1, 2, 3}, []string{"__name__", "name_1", "name_1"}, []string{"metric_1", "value_1", "value_2"}},
-			},
-			Err: error(nil),
-		},
-		{Sql: "COMMIT;"},
-		{Sql: "BEGIN;"},
-		{
-			Sql: seriesInsertSQL,
-			Args: []interface{}{
-				metricID,
-				tableName,
-				getTestLabelArray(t, [][]int32{{1, 2}, {1, 3}}),
-			},
-			Results: model.RowResults{{int64(3), int64(1)}, {int64(4), int64(2)}},
-			Err:     error(nil),
-		},
-		{Sql: "COMMIT;"},
+		seriesIDsQuery(sortedSeries, labelIDs, positions, []int64{3, 4}),
 	}
 
 	for i := range sqlQueries {
 		for j := range sqlQueries[i].Args {
-			if _, ok := sqlQueries[i].Args[j].([]string); ok {
+			if _, ok := sqlQueries[i].Args[j].([]string); ok && j >= 5 {
 				tmp := &pgutf8str.TextArray{}
 				err := tmp.Set(sqlQueries[i].Args[j])
 				require.NoError(t, err)
@@ -435,7 +361,7 @@ func TestPGXInserterCacheReset(t *testing.T) {
 	mock := model.NewSqlRecorder(sqlQueries, t)
 	scache := cache.NewSeriesCache(cache.DefaultConfig, nil)
 	lcache, _ := cache.NewInvertedLabelsCache(10)
-	sw := NewSeriesWriter(mock, 0, lcache)
+	sw := NewSeriesWriter(mock, lcache, nil)
 	inserter := pgxDispatcher{
 		conn:                mock,
 		scache:              scache,
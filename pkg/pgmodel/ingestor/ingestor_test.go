@@ -8,9 +8,12 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 
+	"github.com/timescale/promscale/pkg/dedup"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
@@ -63,6 +66,25 @@ func TestDBIngestorIngest(t *testing.T) {
 			},
 			countMetadata: 1,
 		},
+		{
+			name:    "Duplicate metadata",
+			metrics: []prompb.TimeSeries{},
+			metadata: []prompb.MetricMetadata{
+				{
+					MetricFamilyName: "random_metric",
+					Unit:             "units",
+					Type:             1,
+					Help:             "random test metric",
+				},
+				{
+					MetricFamilyName: "random_metric",
+					Unit:             "units",
+					Type:             1,
+					Help:             "random test metric",
+				},
+			},
+			countMetadata: 1,
+		},
 		{
 			name: "One metric & one metadata",
 			metrics: []prompb.TimeSeries{
@@ -316,3 +338,38 @@ func TestDBIngestorIngest(t *testing.T) {
 		})
 	}
 }
+
+func TestDBIngestorDedupRetry(t *testing.T) {
+	inserter := model.MockInserter{
+		InsertDataErr:  fmt.Errorf("insert failed"),
+		InsertedSeries: make(map[string]model.SeriesID),
+	}
+	i := DBIngestor{
+		dispatcher: &inserter,
+		sCache:     cache.NewSeriesCache(cache.DefaultConfig, nil),
+		dedup:      dedup.New(&dedup.Config{Window: time.Minute}),
+		closed:     atomic.NewBool(false),
+	}
+	write := func() (uint64, error) {
+		wr := NewWriteRequest()
+		wr.Timeseries = []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: model.MetricNameLabelName, Value: "up"}},
+			Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 1}},
+		}}
+		n, _, err := i.IngestMetrics(context.Background(), wr)
+		return n, err
+	}
+
+	// The samples of the failed write are retried, they are not duplicates.
+	_, err := write()
+	require.Error(t, err)
+	inserter.InsertDataErr = nil
+	n, err := write()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+
+	// Once inserted, the samples written again are dropped.
+	n, err = write()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), n)
+}
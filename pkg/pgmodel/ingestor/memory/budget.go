@@ -0,0 +1,282 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package memory accounts the memory used by the metric ingest against a
+// single budget. The batches waiting to be inserted, the series whose IDs are
+// being created and the caches each take a share of the budget. When the
+// memory used reaches the high watermark, the writes are rejected until it
+// falls below the low watermark, and the adaptively sized caches are kept
+// below the low watermark so that they give memory back to the batches.
+package memory
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// retryAfter is how long the clients are asked to wait before retrying
+	// a rejected write.
+	retryAfter = 5 * time.Second
+	// pollInterval is how often the size of the caches is read.
+	pollInterval = time.Second
+)
+
+// The components accounted against the budget.
+const (
+	Batches       = "batches"
+	PendingSeries = "pending_series"
+	Caches        = "caches"
+)
+
+var (
+	budgetMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_memory",
+			Name:      "budget_bytes",
+			Help:      "Memory budget of the metric ingest.",
+		})
+	usedMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_memory",
+			Name:      "used_bytes",
+			Help:      "Estimated memory used by each component of the metric ingest: batches, pending_series and caches.",
+		}, []string{"component"})
+	throttledMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_memory",
+			Name:      "throttled",
+			Help:      "1 while writes are rejected because the ingest memory is above the high watermark, 0 otherwise.",
+		})
+	spilledRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_memory",
+			Name:      "spilled_requests_total",
+			Help:      "Total number of writes rejected because they did not fit in the ingest memory budget.",
+		})
+	spilledBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest_memory",
+			Name:      "spilled_bytes_total",
+			Help:      "Total estimated size of the writes rejected because they did not fit in the ingest memory budget.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(budgetMetric, usedMetric, throttledMetric, spilledRequests, spilledBytes)
+}
+
+// Cache is a cache whose size is accounted against the budget.
+type Cache interface {
+	Stats() clockcache.Stats
+}
+
+type trackedCache struct {
+	name  string
+	cache Cache
+}
+
+// Budget accounts the memory of the metric ingest. A nil Budget accounts
+// nothing and accepts every write.
+type Budget struct {
+	limit, high, low uint64
+
+	mu        sync.Mutex
+	batches   uint64
+	pending   uint64
+	caches    uint64
+	throttled bool
+	tracked   []trackedCache
+}
+
+// New returns a Budget, or nil if the budget is disabled.
+func New(cfg Config) *Budget {
+	if cfg.BudgetBytes == 0 {
+		return nil
+	}
+	budgetMetric.Set(float64(cfg.BudgetBytes))
+	b := &Budget{
+		limit: cfg.BudgetBytes,
+		high:  uint64(float64(cfg.BudgetBytes) * cfg.HighWatermark),
+		low:   uint64(float64(cfg.BudgetBytes) * cfg.LowWatermark),
+	}
+	b.report()
+	return b
+}
+
+// TrackCache accounts the size of the cache against the budget.
+func (b *Budget) TrackCache(name string, c Cache) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tracked = append(b.tracked, trackedCache{name: name, cache: c})
+}
+
+// ReserveBatch accounts a write of the given estimated size entering the
+// ingest, until ReleaseBatch. It returns a *backpressure.Error and accounts
+// nothing if the writes are throttled or the write does not fit in the
+// budget. A write larger than the budget is accepted when no other batch is
+// in flight, so that it is not rejected forever.
+func (b *Budget) ReserveBatch(bytes uint64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.throttled || (b.used()+bytes > b.limit && b.batches > 0) {
+		spilledRequests.Inc()
+		spilledBytes.Add(float64(bytes))
+		return &backpressure.Error{RetryAfter: retryAfter, Reason: "ingest memory budget used up"}
+	}
+	b.batches += bytes
+	b.update()
+	return nil
+}
+
+// ReleaseBatch releases a write accounted by ReserveBatch once it is inserted
+// or failed.
+func (b *Budget) ReleaseBatch(bytes uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches -= bytes
+	b.update()
+}
+
+// ReservePendingSeries accounts the series whose IDs are being created, until
+// ReleasePendingSeries. They are never rejected, as they belong to batches
+// already accepted.
+func (b *Budget) ReservePendingSeries(bytes uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending += bytes
+	b.update()
+}
+
+// ReleasePendingSeries releases series accounted by ReservePendingSeries.
+func (b *Budget) ReleasePendingSeries(bytes uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending -= bytes
+	b.update()
+}
+
+// CacheAllowance returns the memory the caches can use, which keeps the total
+// below the low watermark with the batches and series currently in flight.
+func (b *Budget) CacheAllowance() uint64 {
+	if b == nil {
+		return math.MaxUint64
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if inFlight := b.batches + b.pending; inFlight < b.low {
+		return b.low - inFlight
+	}
+	return 0
+}
+
+// Throttled returns true while the writes are rejected.
+func (b *Budget) Throttled() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.throttled
+}
+
+// Run reads the size of the caches until sigClose is closed.
+func (b *Budget) Run(sigClose <-chan struct{}) {
+	if b == nil {
+		return
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.pollCaches()
+		case <-sigClose:
+			return
+		}
+	}
+}
+
+func (b *Budget) pollCaches() {
+	b.mu.Lock()
+	tracked := b.tracked
+	b.mu.Unlock()
+
+	// The caches are read without holding the lock, so that the writes
+	// are not blocked by the locks of the caches.
+	size := uint64(0)
+	for _, c := range tracked {
+		size += c.cache.Stats().SizeBytes
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.caches = size
+	b.update()
+}
+
+func (b *Budget) used() uint64 {
+	return b.batches + b.pending + b.caches
+}
+
+// update throttles the writes once the memory used reaches the high
+// watermark, until it falls below the low watermark. The writes are also let
+// through when no batch is in flight, as rejecting them cannot free any more
+// memory: the caches then use most of the budget.
+func (b *Budget) update() {
+	used := b.used()
+	switch {
+	case !b.throttled && used >= b.high:
+		b.throttled = true
+		log.WarnRateLimited("msg", "Ingest memory above the high watermark, rejecting writes", "used_bytes", used,
+			"batches_bytes", b.batches, "pending_series_bytes", b.pending, "caches_bytes", b.caches, "budget_bytes", b.limit)
+	case b.throttled && used < b.low:
+		b.throttled = false
+		log.Info("msg", "Ingest memory below the low watermark, accepting writes", "used_bytes", used, "budget_bytes", b.limit)
+	case b.throttled && b.batches+b.pending == 0:
+		b.throttled = false
+		log.WarnRateLimited("msg", "Caches use the ingest memory budget above the low watermark, accepting writes", "caches_bytes", b.caches,
+			"budget_bytes", b.limit)
+	}
+	b.report()
+}
+
+func (b *Budget) report() {
+	usedMetric.WithLabelValues(Batches).Set(float64(b.batches))
+	usedMetric.WithLabelValues(PendingSeries).Set(float64(b.pending))
+	usedMetric.WithLabelValues(Caches).Set(float64(b.caches))
+	if b.throttled {
+		throttledMetric.Set(1)
+	} else {
+		throttledMetric.Set(0)
+	}
+}
@@ -0,0 +1,164 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package memory
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/limits"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/backpressure"
+)
+
+type fakeCache uint64
+
+func (c *fakeCache) Stats() clockcache.Stats {
+	return clockcache.Stats{SizeBytes: uint64(*c)}
+}
+
+func testBudget(t *testing.T) *Budget {
+	b := New(Config{BudgetBytes: 1000, HighWatermark: 0.9, LowWatermark: 0.5})
+	require.NotNil(t, b)
+	return b
+}
+
+func TestBudgetDisabled(t *testing.T) {
+	b := New(Config{})
+	require.Nil(t, b)
+	// A nil budget accounts nothing.
+	b.TrackCache("series", new(fakeCache))
+	require.NoError(t, b.ReserveBatch(1<<40))
+	b.ReleaseBatch(1 << 40)
+	b.ReservePendingSeries(10)
+	b.ReleasePendingSeries(10)
+	require.False(t, b.Throttled())
+	require.Equal(t, uint64(math.MaxUint64), b.CacheAllowance())
+	b.Run(nil)
+}
+
+func TestBudgetWatermarks(t *testing.T) {
+	b := testBudget(t)
+
+	require.NoError(t, b.ReserveBatch(600))
+	require.NoError(t, b.ReserveBatch(250))
+	require.False(t, b.Throttled())
+
+	// A write that does not fit in the budget is spilled.
+	err := b.ReserveBatch(200)
+	var bpErr *backpressure.Error
+	require.True(t, errors.As(err, &bpErr))
+	require.Equal(t, retryAfter, bpErr.RetryAfter)
+	require.Contains(t, err.Error(), "ingest memory budget used up")
+
+	// Series being created are accounted past the high watermark, which
+	// rejects all the writes.
+	b.ReservePendingSeries(100)
+	require.True(t, b.Throttled())
+	require.Error(t, b.ReserveBatch(1))
+
+	// The writes are rejected until the memory used falls below the low
+	// watermark.
+	b.ReleasePendingSeries(100)
+	b.ReleaseBatch(250)
+	require.True(t, b.Throttled())
+	require.Error(t, b.ReserveBatch(1))
+	b.ReleaseBatch(600)
+	require.False(t, b.Throttled())
+	require.NoError(t, b.ReserveBatch(1))
+}
+
+func TestBudgetOversizedWrite(t *testing.T) {
+	b := testBudget(t)
+
+	// A write larger than the budget is accepted alone.
+	require.NoError(t, b.ReserveBatch(5000))
+	require.True(t, b.Throttled())
+	require.Error(t, b.ReserveBatch(1))
+	b.ReleaseBatch(5000)
+	require.False(t, b.Throttled())
+}
+
+func TestBudgetCaches(t *testing.T) {
+	b := testBudget(t)
+	series, labels := fakeCache(300), fakeCache(100)
+	b.TrackCache("series", &series)
+	b.TrackCache("label", &labels)
+	b.pollCaches()
+	require.Equal(t, uint64(400), b.caches)
+
+	// The caches leave the low watermark minus the batches in flight.
+	require.Equal(t, uint64(500), b.CacheAllowance())
+	require.NoError(t, b.ReserveBatch(450))
+	require.Equal(t, uint64(50), b.CacheAllowance())
+	require.NoError(t, b.ReserveBatch(100))
+	require.Equal(t, uint64(0), b.CacheAllowance())
+	require.True(t, b.Throttled())
+
+	// Once the batches are written, the writes are accepted again even
+	// if the caches alone are above the low watermark.
+	series = 700
+	b.pollCaches()
+	b.ReleaseBatch(450)
+	require.True(t, b.Throttled())
+	b.ReleaseBatch(100)
+	require.False(t, b.Throttled())
+}
+
+func TestBudgetRun(t *testing.T) {
+	b := testBudget(t)
+	series := fakeCache(950)
+	b.TrackCache("series", &series)
+	sigClose := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		b.Run(sigClose)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.caches == 950
+	}, 5*time.Second, 10*time.Millisecond)
+	close(sigClose)
+	<-done
+}
+
+func TestValidate(t *testing.T) {
+	lcfg := limits.Config{TargetMemoryBytes: 10000}
+	parse := func(args ...string) Config {
+		var cfg Config
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		ParseFlags(fs, &cfg)
+		require.NoError(t, fs.Parse(args))
+		return cfg
+	}
+
+	cfg := parse()
+	require.NoError(t, Validate(&cfg, lcfg))
+	require.Zero(t, cfg.BudgetBytes)
+
+	cfg = parse("-metrics.ingest.memory-budget", "50%")
+	require.NoError(t, Validate(&cfg, lcfg))
+	require.Equal(t, uint64(5000), cfg.BudgetBytes)
+
+	cfg = parse("-metrics.ingest.memory-budget", "2000")
+	require.NoError(t, Validate(&cfg, lcfg))
+	require.Equal(t, uint64(2000), cfg.BudgetBytes)
+
+	cfg = parse("-metrics.ingest.memory-budget", "20000")
+	require.EqualError(t, Validate(&cfg, lcfg), "metrics.ingest.memory-budget must be smaller than the memory-target")
+
+	cfg = parse("-metrics.ingest.memory-budget", "50%", "-metrics.ingest.memory-budget.low-watermark", "0.95")
+	require.Error(t, Validate(&cfg, lcfg))
+
+	cfg = parse("-metrics.ingest.memory-budget", "50%", "-metrics.ingest.memory-budget.high-watermark", "1.5")
+	require.Error(t, Validate(&cfg, lcfg))
+}
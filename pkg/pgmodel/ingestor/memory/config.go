@@ -0,0 +1,66 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package memory
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/timescale/promscale/pkg/limits"
+)
+
+const (
+	defaultHighWatermark = 0.9
+	defaultLowWatermark  = 0.75
+)
+
+// Config holds the flags of the ingest memory budget.
+type Config struct {
+	budgetFlag limits.PercentageAbsoluteBytesFlag
+	// BudgetBytes is the memory budget of the ingest, 0 if disabled.
+	BudgetBytes uint64
+	// HighWatermark is the fraction of the budget used above which the
+	// writes are rejected.
+	HighWatermark float64
+	// LowWatermark is the fraction of the budget used below which the
+	// writes are accepted again.
+	LowWatermark float64
+}
+
+// ParseFlags registers the ingest memory budget flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var(&cfg.budgetFlag, "metrics.ingest.memory-budget", "Memory budget shared by the batches waiting to be inserted, the series being created and the caches of the metric ingest. "+
+		"Specified in bytes or as a percentage of the memory-target (e.g. 70%). Disabled if 0.")
+	fs.Float64Var(&cfg.HighWatermark, "metrics.ingest.memory-budget.high-watermark", defaultHighWatermark, "Fraction of -metrics.ingest.memory-budget used above which writes are rejected with 503.")
+	fs.Float64Var(&cfg.LowWatermark, "metrics.ingest.memory-budget.low-watermark", defaultLowWatermark, "Fraction of -metrics.ingest.memory-budget used below which writes are accepted again once rejected. "+
+		"The adaptively sized caches are kept below it.")
+	return cfg
+}
+
+// Validate checks the ingest memory budget flags and resolves the budget from
+// the memory target.
+func Validate(cfg *Config, lcfg limits.Config) error {
+	kind, value := cfg.budgetFlag.Get()
+	switch kind {
+	case limits.Percentage:
+		cfg.BudgetBytes = uint64(float64(lcfg.TargetMemoryBytes) * (float64(value) / 100.0))
+	case limits.Absolute:
+		cfg.BudgetBytes = value
+	default:
+		return fmt.Errorf("metrics.ingest.memory-budget flag has unknown kind")
+	}
+	if cfg.BudgetBytes == 0 {
+		return nil
+	}
+	switch {
+	case cfg.BudgetBytes > lcfg.TargetMemoryBytes:
+		return fmt.Errorf("metrics.ingest.memory-budget must be smaller than the memory-target")
+	case cfg.HighWatermark <= 0 || cfg.HighWatermark > 1:
+		return fmt.Errorf("metrics.ingest.memory-budget.high-watermark must be greater than 0 and at most 1, got %g", cfg.HighWatermark)
+	case cfg.LowWatermark <= 0 || cfg.LowWatermark >= cfg.HighWatermark:
+		return fmt.Errorf("metrics.ingest.memory-budget.low-watermark must be greater than 0 and smaller than the high watermark, got %g", cfg.LowWatermark)
+	}
+	return nil
+}
@@ -17,10 +17,13 @@ import (
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	pgErrors "github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
 	"github.com/timescale/promscale/pkg/pgmodel/metrics"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/partitioning"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/tracer"
+	"github.com/timescale/promscale/pkg/webhook"
 )
 
 const getCreateMetricsTableWithNewSQL = "SELECT id, table_name, possibly_new FROM _prom_catalog.get_or_create_metric_table_name($1)"
@@ -117,10 +120,30 @@ func initializeMetricBatcher(conn pgxconn.PgxConn, metricName string, completeMe
 		case completeMetricCreationSignal <- struct{}{}:
 		default:
 		}
+		webhook.Emit(webhook.Event{
+			Type:    webhook.MetricCreated,
+			Subject: metricName,
+			Message: fmt.Sprintf("metric %s created", metricName),
+			Details: map[string]string{"metric": metricName, "table": mInfo.TableName},
+		})
 	}
 	return mInfo, err
 }
 
+// initializePartitioning reads whether the samples of the metric are
+// partitioned by tenant, partitioning its table if configured. The cached
+// metric info is updated so that the queries prune the tenant partitions.
+func initializePartitioning(conn pgxconn.PgxConn, metricName string, info *model.MetricInfo, layout *partitioning.Layout, metricTableNames cache.MetricCache) error {
+	partitioned, err := layout.ForMetric(context.Background(), conn, metricName, *info)
+	if err != nil || partitioned == info.TenantPartitioned {
+		return err
+	}
+	info.TenantPartitioned = partitioned
+	// We ignore error here since this is just an optimization.
+	_ = metricTableNames.Set(schema.PromData, metricName, *info, false)
+	return nil
+}
+
 // initilizeExemplars creates the necessary tables for exemplars. Called lazily only if exemplars are found
 func initializeExemplars(conn pgxconn.PgxConn, metricName string) error {
 	// We are seeing the exemplar belonging to this metric first time. It may be the
@@ -139,10 +162,13 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 	metricName string,
 	completeMetricCreationSignal chan struct{},
 	metricTableNames cache.MetricCache,
+	encodings *encoding.Resolver,
+	layout *partitioning.Layout,
 	copierReadRequestCh chan<- readRequest,
 ) {
 	var (
 		info        model.MetricInfo
+		encoder     encoding.Encoder
 		firstReq    *insertDataRequest
 		firstReqSet = false
 	)
@@ -150,6 +176,12 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 	for firstReq = range input {
 		var err error
 		info, err = initializeMetricBatcher(conn, metricName, completeMetricCreationSignal, metricTableNames)
+		if err == nil {
+			encoder, err = encodings.ForMetric(context.Background(), conn, metricName, info)
+		}
+		if err == nil {
+			err = initializePartitioning(conn, metricName, &info, layout, metricTableNames)
+		}
 		if err != nil {
 			err := fmt.Errorf("initializing the insert routine for metric %v has failed with %w", metricName, err)
 			log.Error("msg", err)
@@ -164,7 +196,7 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 	if !firstReqSet {
 		return
 	}
-	sendBatches(firstReq, input, conn, &info, copierReadRequestCh)
+	sendBatches(firstReq, input, conn, &info, encoder, copierReadRequestCh)
 }
 
 //the basic structure of communication from the batcher to the copier is as follows:
@@ -181,7 +213,7 @@ func runMetricBatcher(conn pgxconn.PgxConn,
 //     of requests consecutively to minimize processing delays. That's what the mutex in the copier does.
 // 2. There is an auto-adjusting adaptation loop in step 3. The longer the copier takes to catch up to the readRequest in the queue, the more things will be batched
 // 3. The batcher has only a single read request out at a time.
-func sendBatches(firstReq *insertDataRequest, input chan *insertDataRequest, conn pgxconn.PgxConn, info *model.MetricInfo, copierReadRequestCh chan<- readRequest) {
+func sendBatches(firstReq *insertDataRequest, input chan *insertDataRequest, conn pgxconn.PgxConn, info *model.MetricInfo, encoder encoding.Encoder, copierReadRequestCh chan<- readRequest) {
 	var (
 		exemplarsInitialized = false
 		span                 trace.Span
@@ -242,7 +274,7 @@ func sendBatches(firstReq *insertDataRequest, input chan *insertDataRequest, con
 
 		select {
 		//try to send first, if not then keep batching
-		case copySender <- copyRequest{pending, info}:
+		case copySender <- copyRequest{pending, info, encoder}:
 			metrics.IngestorFlushSeries.With(prometheus.Labels{"type": "metric", "subsystem": "metric_batcher"}).Observe(float64(numSeries))
 			span.SetAttributes(attribute.Int("num_series", numSeries))
 			span.End()
@@ -253,7 +285,7 @@ func sendBatches(firstReq *insertDataRequest, input chan *insertDataRequest, con
 			if !ok {
 				if !pending.IsEmpty() {
 					span.AddEvent("Sending last non-empty batch")
-					copySender <- copyRequest{pending, info}
+					copySender <- copyRequest{pending, info, encoder}
 					metrics.IngestorFlushSeries.With(prometheus.Labels{"type": "metric", "subsystem": "metric_batcher"}).Observe(float64(numSeries))
 				}
 				span.AddEvent("Exiting metric batcher batch loop")
@@ -148,7 +148,7 @@ func TestSendBatches(t *testing.T) {
 	}
 	firstReq := &insertDataRequest{metric: "test", data: data, finished: &workFinished, errChan: errChan}
 	copierCh := make(chan readRequest)
-	go sendBatches(firstReq, nil, nil, &pgmodel.MetricInfo{MetricID: 1, TableName: "test"}, copierCh)
+	go sendBatches(firstReq, nil, nil, &pgmodel.MetricInfo{MetricID: 1, TableName: "test"}, nil, copierCh)
 	copierReq := <-copierCh
 	batch := <-copierReq.copySender
 
@@ -7,74 +7,110 @@ package ingestor
 import (
 	"context"
 	"fmt"
+	"sort"
 
-	"github.com/jackc/pgtype"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/cache/shared"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/memory"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
-	"github.com/timescale/promscale/pkg/pgmodel/model/pgutf8str"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/tracer"
 	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
-	seriesInsertSQL = "SELECT _prom_catalog.get_or_create_series_id_for_label_array($1, $2, l.elem), l.nr FROM unnest($3::prom_api.label_array[]) WITH ORDINALITY l(elem, nr) ORDER BY l.elem"
+	// pendingMetricBytes, pendingSeriesBytes and pendingLabelBytes estimate
+	// the memory used by a metric, a series and a label of a seriesBatch.
+	pendingMetricBytes = 48
+	pendingSeriesBytes = 96
+	pendingLabelBytes  = 49
+
+	seriesIDsSQL = "SELECT series_nr, series_id, series_label_ids, series_label_pos FROM _prom_catalog.get_or_create_series_ids($1, $2, $3, $4, $5, $6, $7, $8, $9)"
 )
 
 type seriesWriter struct {
-	conn          pgxconn.PgxConn
-	labelArrayOID uint32
-	labelsCache   *cache.InvertedLabelsCache
+	conn        pgxconn.PgxConn
+	labelsCache *cache.InvertedLabelsCache
+	// sharedCache is nil if the shared cache is disabled.
+	sharedCache *shared.Cache
+	// memoryBudget is nil if the ingest memory is not bounded.
+	memoryBudget *memory.Budget
 }
 
 type SeriesVisitor interface {
 	VisitSeries(func(info *pgmodel.MetricInfo, s *model.Series) error) error
 }
 
-func labelArrayTranscoder() pgtype.ValueTranscoder { return &pgtype.Int4Array{} }
+func NewSeriesWriter(conn pgxconn.PgxConn, labelsCache *cache.InvertedLabelsCache, sharedCache *shared.Cache) *seriesWriter {
+	return &seriesWriter{conn: conn, labelsCache: labelsCache, sharedCache: sharedCache}
+}
+
+// pendingSeries is a series whose ID is resolved by the database.
+type pendingSeries struct {
+	series     *model.Series
+	metricName string
+	// names and values are the labels of the series, in the order they
+	// are sent to the database.
+	names, values []string
+	// cached marks the labels found in the inverted labels cache.
+	cached []bool
+	// sharedKey is the key of the series in the shared cache.
+	sharedKey string
+}
 
-func NewSeriesWriter(conn pgxconn.PgxConn, labelArrayOID uint32, labelsCache *cache.InvertedLabelsCache) *seriesWriter {
-	return &seriesWriter{conn, labelArrayOID, labelsCache}
+// seriesBatch holds the arguments of get_or_create_series_ids for a batch of
+// series. Metrics and series are 1-indexed in the arguments.
+type seriesBatch struct {
+	metricNames  []string
+	metricTables []string
+	metricIDs    []int32
+	series       []pendingSeries
+
+	labelSeries  []int32
+	labelMetrics []int32
+	labels       *model.LabelList
+	labelIDs     []int32
+	labelPos     []int32
 }
 
-type perMetricInfo struct {
-	metricName             string
-	series                 []*model.Series
-	labelsToFetch          *model.LabelList // labels we haven't found in our cache and need to fetch from DB
-	cachedLabels           []cache.LabelKey
-	maxPos                 int
-	labelArraySet          *pgtype.ArrayType
-	labelArraySetNumLabels int
-	metricInfo             *pgmodel.MetricInfo
+// sizeBytes estimates the memory used by the batch: the labels are shared
+// with the series, only the slices referencing them are counted.
+func (b *seriesBatch) sizeBytes() uint64 {
+	size := uint64(len(b.metricNames)) * pendingMetricBytes
+	for i := range b.series {
+		size += pendingSeriesBytes + uint64(len(b.series[i].names))*pendingLabelBytes
+	}
+	return size
 }
 
-// PopulateOrCreateSeries examines all series in SeriesVisitor, checking if the labels or
-// series are present in the inverted label or series caches. If not present,
-// it creates them in the database (if they have not been created), or fetches
-// their IDs if already created populating the respective caches.
+// PopulateOrCreateSeries examines all series in SeriesVisitor, and resolves the
+// IDs of the ones not in the series cache in a single call to the database,
+// which creates the missing labels, label key positions and series. The labels
+// found in the inverted labels cache are sent with their IDs and positions, and
+// the cache is populated with the ones resolved by the database. The series
+// and labels are looked up in the shared cache first, if there is one.
 func (h *seriesWriter) PopulateOrCreateSeries(ctx context.Context, sv SeriesVisitor) error {
 	ctx, span := tracer.Default().Start(ctx, "write-series")
 	defer span.End()
-	infos := make(map[string]*perMetricInfo)
-	seriesCount := 0
+	infos := make(map[string]*pgmodel.MetricInfo)
+	seriesByMetric := make(map[string][]*model.Series)
+	seen := make(map[*model.Series]struct{})
 	err := sv.VisitSeries(func(metricInfo *pgmodel.MetricInfo, series *model.Series) error {
-		if !series.IsSeriesIDSet() {
-			metricName := series.MetricName()
-			info, ok := infos[metricName]
-			if !ok {
-				info = &perMetricInfo{
-					metricInfo:    metricInfo,
-					metricName:    metricName,
-					labelsToFetch: model.NewLabelList(10),
-					cachedLabels:  make([]cache.LabelKey, 0),
-				}
-				infos[metricName] = info
-			}
-			info.series = append(info.series, series)
-			seriesCount++
+		if series.IsSeriesIDSet() {
+			return nil
+		}
+		// The same series can be visited several times, it is resolved once.
+		if _, found := seen[series]; found {
+			return nil
 		}
+		seen[series] = struct{}{}
+		metricName := series.MetricName()
+		if _, ok := infos[metricName]; !ok {
+			infos[metricName] = metricInfo
+		}
+		seriesByMetric[metricName] = append(seriesByMetric[metricName], series)
 		return nil
 	})
 	if err != nil {
@@ -83,299 +119,220 @@ func (h *seriesWriter) PopulateOrCreateSeries(ctx context.Context, sv SeriesVisi
 	if len(infos) == 0 {
 		return nil
 	}
+	h.lookupSharedCache(ctx, seriesByMetric)
 
-	span.SetAttributes(attribute.Int("series_count", seriesCount))
-
-	labelMap := make(map[cache.LabelKey]cache.LabelInfo, seriesCount)
-	//logically should be a separate function but we want
-	//to prevent labelMap from escaping, so keeping inline.
-	{
-		for _, info := range infos {
-			for _, series := range info.series {
-				names, values, ok := series.NameValues()
-				if !ok {
-					//was already set
-					continue
-				}
-				for i := range names {
-					key := cache.LabelKey{MetricName: series.MetricName(), Name: names[i], Value: values[i]}
-					_, added := labelMap[key]
-					if !added {
-						labelInfo, cached := h.labelsCache.GetLabelsId(cache.NewLabelKey(series.MetricName(), names[i], values[i]))
-						labelMap[key] = labelInfo
-						if cached {
-							info.cachedLabels = append(info.cachedLabels, key)
-							continue
-						}
-						if err := info.labelsToFetch.Add(names[i], values[i]); err != nil {
-							return fmt.Errorf("failed to add label to labelList: %w", err)
-						}
-					}
-				}
-			}
-		}
-	}
-	if len(labelMap) == 0 {
-		return nil
-	}
-
-	//labels have to be created before series are since we need a canonical
-	//ordering for label creation to avoid deadlocks. Otherwise, if we create
-	//the labels for multiple series in same txn as we are creating the series,
-	//the ordering of label creation can only be canonical within a series and
-	//not across series.
-	dbEpoch, err := h.fillLabelIDs(ctx, infos, labelMap)
+	batch, err := h.buildSeriesBatch(infos, seriesByMetric)
 	if err != nil {
 		return fmt.Errorf("error setting series ids: %w", err)
 	}
-
-	//create the label arrays
-	err = h.buildLabelArrays(ctx, infos, labelMap)
-	if err != nil {
-		return fmt.Errorf("error setting series ids: %w", err)
+	if len(batch.series) == 0 {
+		return nil
 	}
+	span.SetAttributes(attribute.Int("series_count", len(batch.series)))
+	sizeBytes := batch.sizeBytes()
+	h.memoryBudget.ReservePendingSeries(sizeBytes)
+	defer h.memoryBudget.ReleasePendingSeries(sizeBytes)
+	return h.resolveSeriesIDs(ctx, batch)
+}
 
-	batch := h.conn.NewBatch()
-	batchInfos := make([]*perMetricInfo, 0, len(infos))
-	for _, info := range infos {
-		if info.labelArraySetNumLabels == 0 {
-			continue
-		}
-
-		//transaction per metric to avoid cross-metric locks
-		batch.Queue("BEGIN;")
-		batch.Queue(seriesInsertSQL, info.metricInfo.MetricID, info.metricInfo.TableName, info.labelArraySet)
-		batch.Queue("COMMIT;")
-		batchInfos = append(batchInfos, info)
-	}
-	br, err := h.conn.SendBatch(context.Background(), batch)
-	if err != nil {
-		return fmt.Errorf("error inserting series: %w", err)
+// buildSeriesBatch flattens the labels of the series of every metric, looking
+// up their IDs and positions in the inverted labels cache.
+func (h *seriesWriter) buildSeriesBatch(infos map[string]*pgmodel.MetricInfo, seriesByMetric map[string][]*model.Series) (*seriesBatch, error) {
+	metricNames := make([]string, 0, len(infos))
+	for metricName := range infos {
+		metricNames = append(metricNames, metricName)
 	}
-	defer br.Close()
-
-	for _, info := range batchInfos {
-		//begin
-		if _, err := br.Exec(); err != nil {
-			return fmt.Errorf("error setting series_id begin: %w", err)
-		}
-
-		res, err := br.Query()
-		if err != nil {
-			return fmt.Errorf("error setting series_id: cannot query for series_id: %w", err)
-		}
-		defer res.Close()
-
-		count := 0
-		for res.Next() {
-			var (
-				id         model.SeriesID
-				ordinality int64
-			)
-			err := res.Scan(&id, &ordinality)
-			if err != nil {
-				return fmt.Errorf("error setting series_id: cannot scan series_id: %w", err)
+	sort.Strings(metricNames)
+
+	batch := &seriesBatch{labels: model.NewLabelList(10)}
+	for _, metricName := range metricNames {
+		info := infos[metricName]
+		metricAdded := false
+		for _, series := range seriesByMetric[metricName] {
+			names, values, ok := series.NameValues()
+			if !ok {
+				//was already set
+				continue
 			}
-			info.series[int(ordinality)-1].SetSeriesID(id, dbEpoch)
-			count++
-		}
-		if err := res.Err(); err != nil {
-			return fmt.Errorf("error setting series_id: reading series id rows: %w", err)
-		}
-		if count != len(info.series) {
-			//This should never happen according to the logic. This is purely defensive.
-			//panic since we may have set the seriesID incorrectly above and may
-			//get data corruption if we continue.
-			panic(fmt.Sprintf("number series returned %d doesn't match expected series %d", count, len(info.series)))
-		}
-		//commit
-		if _, err := br.Exec(); err != nil {
-			return fmt.Errorf("error setting series_id commit: %w", err)
-		}
-	}
-	return nil
-}
-
-func (h *seriesWriter) fillLabelIDs(ctx context.Context, infos map[string]*perMetricInfo, labelMap map[cache.LabelKey]cache.LabelInfo) (model.SeriesEpoch, error) {
-	_, span := tracer.Default().Start(ctx, "fill-label-ids")
-	defer span.End()
-	//we cannot use the label cache here because that maps label ids => name, value.
-	//what we need here is name, value => id.
-	//we may want a new cache for that, at a later time.
-
-	batch := h.conn.NewBatch()
-	var dbEpoch model.SeriesEpoch
-
-	// The epoch will never decrease, so we can check it once at the beginning,
-	// at worst we'll store too small an epoch, which is always safe
-	batch.Queue("BEGIN;")
-	batch.Queue(getEpochSQL)
-	batch.Queue("COMMIT;")
-
-	infoBatches := make([]*perMetricInfo, 0)
-	items := 0
-	for metricName, info := range infos {
-		names, values := info.labelsToFetch.Get()
-		//getLabels in batches of 1000 to prevent locks on label creation
-		//from being taken for too long.
-		itemsPerBatch := 1000
-		for i := 0; i < len(names.Elements); i += itemsPerBatch {
-			high := i + itemsPerBatch
-			if len(names.Elements) < high {
-				high = len(names.Elements)
+			// The metrics whose series are all set, e.g. from the shared
+			// cache, are left out.
+			if !metricAdded {
+				batch.metricNames = append(batch.metricNames, metricName)
+				batch.metricTables = append(batch.metricTables, info.TableName)
+				batch.metricIDs = append(batch.metricIDs, int32(info.MetricID))
+				metricAdded = true
 			}
-			namesSlice, err := names.Slice(i, high)
-			if err != nil {
-				return dbEpoch, fmt.Errorf("error filling labels: slicing names: %w", err)
+			metricIdx := int32(len(batch.metricNames))
+			pending := pendingSeries{
+				series:     series,
+				metricName: metricName,
+				names:      names,
+				values:     values,
+				cached:     make([]bool, len(names)),
 			}
-			valuesSlice, err := values.Slice(i, high)
-			if err != nil {
-				return dbEpoch, fmt.Errorf("error filling labels: slicing values: %w", err)
+			if h.sharedCache != nil {
+				pending.sharedKey = h.sharedCache.SeriesKey(names, values)
 			}
-			batch.Queue("BEGIN;")
-			batch.Queue("SELECT * FROM _prom_catalog.get_or_create_label_ids($1, $2, $3, $4)", metricName, info.metricInfo.TableName, namesSlice, valuesSlice)
-			batch.Queue("COMMIT;")
-			infoBatches = append(infoBatches, info)
-			items += len(namesSlice.Elements)
-		}
-		// since info.maxPos is only updated with fetched labels we need to iterate through cached as well
-		for _, cachedLabel := range info.cachedLabels {
-			if val, ok := labelMap[cachedLabel]; ok {
-				if int(val.Pos) > info.maxPos {
-					info.maxPos = int(val.Pos)
+			batch.series = append(batch.series, pending)
+			seriesNr := int32(len(batch.series))
+			for i := range names {
+				labelInfo, cached := h.labelsCache.GetLabelsId(cache.NewLabelKey(metricName, names[i], values[i]))
+				pending.cached[i] = cached
+				if err := batch.labels.Add(names[i], values[i]); err != nil {
+					return nil, fmt.Errorf("failed to add label to labelList: %w", err)
 				}
+				batch.labelSeries = append(batch.labelSeries, seriesNr)
+				batch.labelMetrics = append(batch.labelMetrics, metricIdx)
+				batch.labelIDs = append(batch.labelIDs, labelInfo.LabelID)
+				batch.labelPos = append(batch.labelPos, labelInfo.Pos)
 			}
 		}
 	}
+	return batch, nil
+}
+
+// resolveSeriesIDs sets the IDs of the series of batch, with the current
+// epoch and the series IDs fetched in a single round trip.
+func (h *seriesWriter) resolveSeriesIDs(ctx context.Context, batch *seriesBatch) error {
+	_, span := tracer.Default().Start(ctx, "get-or-create-series-ids")
+	defer span.End()
 
-	br, err := h.conn.SendBatch(context.Background(), batch)
+	names, values := batch.labels.Get()
+	dbBatch := h.conn.NewBatch()
+	// The epoch will never decrease, so we can check it once before creating
+	// the series, at worst we'll store too small an epoch, which is always safe
+	dbBatch.Queue(getEpochSQL)
+	dbBatch.Queue(seriesIDsSQL, batch.metricNames, batch.metricTables, batch.metricIDs,
+		batch.labelSeries, batch.labelMetrics, names, values, batch.labelIDs, batch.labelPos)
+	br, err := h.conn.SendBatch(context.Background(), dbBatch)
 	if err != nil {
-		return dbEpoch, fmt.Errorf("error filling labels: %w", err)
+		return fmt.Errorf("error setting series ids: %w", err)
 	}
 	defer br.Close()
 
-	if _, err := br.Exec(); err != nil {
-		return dbEpoch, fmt.Errorf("error filling labels on begin: %w", err)
+	var dbEpoch model.SeriesEpoch
+	if err = br.QueryRow().Scan(&dbEpoch); err != nil {
+		return fmt.Errorf("error setting series ids: cannot get epoch: %w", err)
 	}
-	err = br.QueryRow().Scan(&dbEpoch)
+	res, err := br.Query()
 	if err != nil {
-		return dbEpoch, fmt.Errorf("error filling labels: %w", err)
+		return fmt.Errorf("error setting series_id: cannot query for series_id: %w", err)
 	}
-	if _, err := br.Exec(); err != nil {
-		return dbEpoch, fmt.Errorf("error filling labels on commit: %w", err)
-	}
-
-	var count int
-	for _, info := range infoBatches {
-		if _, err := br.Exec(); err != nil {
-			return dbEpoch, fmt.Errorf("error filling labels on begin label batch: %w", err)
+	defer res.Close()
+
+	var (
+		count        = 0
+		seriesKeys   []string
+		seriesShared []shared.SeriesEntry
+		labelKeys    []string
+		labelShared  []shared.LabelEntry
+	)
+	for res.Next() {
+		var (
+			seriesNr int32
+			id       model.SeriesID
+			labelIDs []int32
+			labelPos []int32
+		)
+		if err := res.Scan(&seriesNr, &id, &labelIDs, &labelPos); err != nil {
+			return fmt.Errorf("error setting series_id: cannot scan series_id: %w", err)
 		}
-
-		err := func() error {
-			var (
-				pos         []int32
-				labelIDs    []int32
-				labelNames  pgutf8str.TextArray
-				labelValues pgutf8str.TextArray
-				names       []string
-				values      []string
-			)
-			err := br.QueryRow().Scan(&pos, &labelIDs, &labelNames, &labelValues)
-			if err != nil {
-				return fmt.Errorf("error filling labels: %w", err)
+		if seriesNr < 1 || int(seriesNr) > len(batch.series) {
+			return fmt.Errorf("error setting series_id: unknown series number %d", seriesNr)
+		}
+		pending := batch.series[seriesNr-1]
+		if len(labelIDs) != len(pending.names) || len(labelPos) != len(pending.names) {
+			return fmt.Errorf("error setting series_id: got %d label ids and %d positions for %d labels", len(labelIDs), len(labelPos), len(pending.names))
+		}
+		for i := range pending.names {
+			if pending.cached[i] {
+				continue
 			}
-			names = labelNames.Get().([]string)
-			values = labelValues.Get().([]string)
-
-			for i := range pos {
-				res := cache.NewLabelInfo(labelIDs[i], pos[i])
-				key := cache.NewLabelKey(info.metricName, names[i], values[i])
-				if !h.labelsCache.Put(key, res) {
-					log.Warn("failed to add label ID to inverted cache")
-				}
-				_, ok := labelMap[key]
-				if !ok {
-					return fmt.Errorf("error filling labels: getting a key never sent to the db")
-				}
-				labelMap[key] = res
-				if int(res.Pos) > info.maxPos {
-					info.maxPos = int(res.Pos)
-				}
-				count++
+			key := cache.NewLabelKey(pending.metricName, pending.names[i], pending.values[i])
+			if !h.labelsCache.Put(key, cache.NewLabelInfo(labelIDs[i], labelPos[i])) {
+				log.Warn("failed to add label ID to inverted cache")
+			}
+			if h.sharedCache != nil {
+				labelKeys = append(labelKeys, h.sharedCache.LabelKey(pending.metricName, pending.names[i], pending.values[i]))
+				labelShared = append(labelShared, shared.LabelEntry{ID: labelIDs[i], Pos: labelPos[i], Epoch: dbEpoch})
 			}
-			return nil
-		}()
-		if err != nil {
-			return dbEpoch, err
 		}
-		if _, err := br.Exec(); err != nil {
-			return dbEpoch, fmt.Errorf("error filling labels on commit label batch: %w", err)
+		pending.series.SetSeriesID(id, dbEpoch)
+		if h.sharedCache != nil {
+			seriesKeys = append(seriesKeys, pending.sharedKey)
+			seriesShared = append(seriesShared, shared.SeriesEntry{ID: id, Epoch: dbEpoch})
 		}
+		count++
 	}
-	if count != items {
-		return dbEpoch, fmt.Errorf("error filling labels: not filling as many items as expected: %v vs %v", count, items)
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("error setting series_id: reading series id rows: %w", err)
 	}
-	return dbEpoch, nil
-}
-
-func (h *seriesWriter) buildLabelArrays(ctx context.Context, infos map[string]*perMetricInfo, labelMap map[cache.LabelKey]cache.LabelInfo) error {
-	_, span := tracer.Default().Start(ctx, "build-label-arrays")
-	defer span.End()
-	for _, info := range infos {
-		labelArraySet, newSeries, err := createLabelArrays(info.series, labelMap, info.maxPos)
-		if err != nil {
-			return fmt.Errorf("error building label array: cannot create label_array: %w", err)
-		}
-		info.series = newSeries
-		info.labelArraySet = pgtype.NewArrayType("prom_api.label_array[]", h.labelArrayOID, labelArrayTranscoder)
-		err = info.labelArraySet.Set(labelArraySet)
-		if err != nil {
-			return fmt.Errorf("error setting series id: cannot set label_array: %w", err)
-		}
-		info.labelArraySetNumLabels = len(labelArraySet)
-
+	if count != len(batch.series) {
+		//This should never happen according to the logic. This is purely defensive.
+		//panic since we may have set the seriesID incorrectly above and may
+		//get data corruption if we continue.
+		panic(fmt.Sprintf("number series returned %d doesn't match expected series %d", count, len(batch.series)))
 	}
-
+	h.sharedCache.SetEpoch(dbEpoch)
+	h.sharedCache.PutSeries(seriesKeys, seriesShared)
+	h.sharedCache.PutLabels(labelKeys, labelShared)
 	return nil
 }
 
-func createLabelArrays(series []*model.Series, labelMap map[cache.LabelKey]cache.LabelInfo, maxPos int) ([][]int32, []*model.Series, error) {
-	labelArraySet := make([][]int32, 0, len(series))
-	dest := 0
-	for src := 0; src < len(series); src++ {
-		names, values, ok := series[src].NameValues()
+// lookupSharedCache sets the IDs of the series found in the shared cache, and
+// adds the labels of the other series found there to the inverted labels
+// cache.
+func (h *seriesWriter) lookupSharedCache(ctx context.Context, seriesByMetric map[string][]*model.Series) {
+	if h.sharedCache == nil {
+		return
+	}
+	var (
+		keys   []string
+		series []*model.Series
+	)
+	for _, metricSeries := range seriesByMetric {
+		for _, s := range metricSeries {
+			names, values, ok := s.NameValues()
+			if !ok {
+				continue
+			}
+			keys = append(keys, h.sharedCache.SeriesKey(names, values))
+			series = append(series, s)
+		}
+	}
+	found := h.sharedCache.GetSeries(ctx, keys)
+
+	var (
+		labelKeys  []string
+		cacheKeys  []cache.LabelKey
+		labelAdded = make(map[cache.LabelKey]struct{})
+	)
+	for i, s := range series {
+		if e, ok := found[keys[i]]; ok {
+			s.SetSeriesID(e.ID, e.Epoch)
+			continue
+		}
+		names, values, ok := s.NameValues()
 		if !ok {
 			continue
 		}
-		lArray := make([]int32, maxPos)
-		maxIndex := 0
-		for i := range names {
-			key := cache.LabelKey{MetricName: series[src].MetricName(), Name: names[i], Value: values[i]}
-			res, ok := labelMap[key]
-			if !ok {
-				return nil, nil, fmt.Errorf("error generating label array: missing key in map: %v", key)
-			}
-			if res.LabelID == 0 {
-				return nil, nil, fmt.Errorf("error generating label array: missing id for label %v=>%v", names[i], values[i])
+		for j := range names {
+			key := cache.NewLabelKey(s.MetricName(), names[j], values[j])
+			if _, added := labelAdded[key]; added {
+				continue
 			}
-			//Pos is 1-indexed, slices are 0-indexed
-			sliceIndex := int(res.Pos) - 1
-			lArray[sliceIndex] = int32(res.LabelID)
-			if sliceIndex > maxIndex {
-				maxIndex = sliceIndex
+			if _, cached := h.labelsCache.GetLabelsId(key); cached {
+				continue
 			}
+			labelAdded[key] = struct{}{}
+			labelKeys = append(labelKeys, h.sharedCache.LabelKey(key.MetricName, key.Name, key.Value))
+			cacheKeys = append(cacheKeys, key)
 		}
-		lArray = lArray[:maxIndex+1]
-		labelArraySet = append(labelArraySet, lArray)
-		//this logic is needed for when continue is hit above
-		if src != dest {
-			series[dest] = series[src]
-		}
-		dest++
 	}
-	series = series[:dest]
-	if len(labelArraySet) != len(series) {
-		return nil, nil, fmt.Errorf("error generating label array: lengths not equal")
+	foundLabels := h.sharedCache.GetLabels(ctx, labelKeys)
+	for i, key := range labelKeys {
+		if e, ok := foundLabels[key]; ok {
+			h.labelsCache.Put(cacheKeys[i], cache.NewLabelInfo(e.ID, e.Pos))
+		}
 	}
-	return labelArraySet, series, nil
 }
@@ -0,0 +1,156 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/relabel"
+	"github.com/timescale/promscale/pkg/shard"
+	"github.com/timescale/promscale/pkg/spanmetrics"
+)
+
+// ShardedIngestor writes the metrics to the databases they are sharded
+// across, each through its own DBIngestor. The traces and the metric metadata
+// are written to the first shard.
+type ShardedIngestor struct {
+	shards []*DBIngestor
+	router *shard.Router
+	// relabeler applies the relabeling rules before the series are routed,
+	// so that they are routed by the metric name they are stored, and
+	// queried, with.
+	relabeler *relabel.Relabeler
+	// spanMetrics is nil if span metrics are disabled. The span metrics are
+	// generated here rather than by the first shard, so that they are routed
+	// like the other metrics.
+	spanMetrics *spanmetrics.Generator
+}
+
+// NewShardedIngestor returns an ingestor routing the metrics to the
+// ingestors of their shard. The ingestors of the shards must not generate
+// span metrics themselves. The relabeling rules are applied here, the
+// relabelers of the shards are removed so that the rules are applied once.
+func NewShardedIngestor(shards []*DBIngestor, router *shard.Router, relabeler *relabel.Relabeler, spanMetrics spanmetrics.Config) *ShardedIngestor {
+	for _, ing := range shards {
+		ing.relabeler = nil
+	}
+	s := &ShardedIngestor{shards: shards, router: router, relabeler: relabeler}
+	if s.spanMetrics = spanmetrics.NewGenerator(spanMetrics, s); s.spanMetrics != nil {
+		s.spanMetrics.Run()
+	}
+	return s
+}
+
+// Shard returns the ingestor of the shard of the metric.
+func (s *ShardedIngestor) Shard(metric string) *DBIngestor {
+	return s.shards[s.router.Shard(metric)]
+}
+
+// IngestMetrics relabels the series, splits the write request by shard and
+// writes the parts concurrently.
+func (s *ShardedIngestor) IngestMetrics(ctx context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	requests := make([]*prompb.WriteRequest, len(s.shards))
+	for _, ts := range r.Timeseries {
+		if len(ts.Labels) == 0 {
+			continue
+		}
+		var keep bool
+		if ts.Labels, keep = s.relabeler.Process(ts.Labels); !keep {
+			continue
+		}
+		i := s.router.Shard(metricName(ts.Labels))
+		if requests[i] == nil {
+			requests[i] = NewWriteRequest()
+		}
+		requests[i].Timeseries = append(requests[i].Timeseries, ts)
+	}
+	if len(r.Metadata) > 0 {
+		if requests[0] == nil {
+			requests[0] = NewWriteRequest()
+		}
+		requests[0].Metadata = append(requests[0].Metadata, r.Metadata...)
+	}
+
+	var (
+		wg                          sync.WaitGroup
+		mu                          sync.Mutex
+		numInsertables, numMetadata uint64
+		err                         error
+	)
+	for i, req := range requests {
+		if req == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, req *prompb.WriteRequest) {
+			defer wg.Done()
+			n, m, shardErr := s.shards[i].IngestMetrics(ctx, req)
+			mu.Lock()
+			defer mu.Unlock()
+			numInsertables += n
+			numMetadata += m
+			if shardErr != nil && err == nil {
+				err = fmt.Errorf("shard %d: %w", i, shardErr)
+			}
+		}(i, req)
+	}
+	wg.Wait()
+	// The requests of the shards share the labels and samples of r, which is
+	// left to the garbage collector rather than reused while the shards may
+	// still read them.
+	return numInsertables, numMetadata, err
+}
+
+// IngestTraces writes the traces to the first shard.
+func (s *ShardedIngestor) IngestTraces(ctx context.Context, traces ptrace.Traces) error {
+	if s.spanMetrics != nil {
+		s.spanMetrics.Add(traces)
+	}
+	return s.shards[0].IngestTraces(ctx, traces)
+}
+
+// WarmedUp returns false while the caches of a shard are being warmed up.
+func (s *ShardedIngestor) WarmedUp() bool {
+	for _, ing := range s.shards {
+		if !ing.WarmedUp() {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the ingestors of the shards.
+func (s *ShardedIngestor) Close() {
+	if s.spanMetrics != nil {
+		s.spanMetrics.Stop()
+	}
+	for _, ing := range s.shards {
+		ing.Close()
+	}
+}
+
+// noTraceWriter is the trace writer of the shards but the first one.
+type noTraceWriter struct{}
+
+func (noTraceWriter) InsertTraces(context.Context, ptrace.Traces) error {
+	return fmt.Errorf("traces are written to the first shard")
+}
+
+func (noTraceWriter) Close() {}
+
+func metricName(lbls []prompb.Label) string {
+	for _, l := range lbls {
+		if l.Name == model.MetricNameLabelName {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/relabel"
+	"github.com/timescale/promscale/pkg/shard"
+	"github.com/timescale/promscale/pkg/spanmetrics"
+)
+
+// newTestShards returns n shards with mock inserters. The caches register
+// their metrics by shard name, which must be unique across the tests.
+func newTestShards(name string, n int, relabeler *relabel.Relabeler) ([]*DBIngestor, []*model.MockInserter) {
+	inserters := make([]*model.MockInserter, n)
+	shards := make([]*DBIngestor, n)
+	for i := range shards {
+		cacheCfg := cache.DefaultConfig
+		if i > 0 {
+			cacheCfg.Shard = fmt.Sprintf("%s%d", name, i)
+		}
+		inserters[i] = &model.MockInserter{InsertedSeries: make(map[string]model.SeriesID)}
+		shards[i] = &DBIngestor{
+			dispatcher: inserters[i],
+			sCache:     cache.NewSeriesCache(cacheCfg, nil),
+			relabeler:  relabeler,
+			closed:     atomic.NewBool(false),
+		}
+	}
+	return shards, inserters
+}
+
+func TestShardedIngestorIngest(t *testing.T) {
+	router, err := shard.NewRouter(2, shard.Mapping{"up": 1})
+	require.NoError(t, err)
+
+	shards, inserters := newTestShards("shard", 2, nil)
+	s := NewShardedIngestor(shards, router, nil, spanmetrics.Config{})
+
+	wr := NewWriteRequest()
+	for i := 0; i < 10; i++ {
+		metric := fmt.Sprintf("metric_%d", i)
+		if i == 0 {
+			metric = "up"
+		}
+		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: model.MetricNameLabelName, Value: metric}},
+			Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+		})
+	}
+	wr.Metadata = []prompb.MetricMetadata{{MetricFamilyName: "up", Type: 1}}
+
+	numSamples, numMetadata, err := s.IngestMetrics(context.Background(), wr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), numSamples)
+	require.Equal(t, uint64(1), numMetadata)
+
+	require.Equal(t, 10, len(inserters[0].InsertedSeries)+len(inserters[1].InsertedSeries))
+	for i, inserter := range inserters {
+		for _, data := range inserter.InsertedData {
+			for metric := range data {
+				require.Equal(t, i, router.Shard(metric), "metric %s inserted in the wrong shard", metric)
+			}
+		}
+	}
+	require.Contains(t, inserters[1].InsertedData[0], "up")
+}
+
+func TestShardedIngestorRelabel(t *testing.T) {
+	router, err := shard.NewRouter(2, shard.Mapping{"up": 1})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+write_relabel_configs:
+  - source_labels: [__name__]
+    regex: legacy_up
+    target_label: __name__
+    replacement: up
+  - source_labels: [job]
+    regex: (.*)
+    target_label: job
+    replacement: ${1}_relabeled
+`), 0600))
+	relabeler, err := relabel.NewRelabeler(&relabel.Config{ConfigFile: file})
+	require.NoError(t, err)
+
+	// The shards are built with the relabeler, as by the client.
+	shards, inserters := newTestShards("relabeled", 2, relabeler)
+	s := NewShardedIngestor(shards, router, relabeler, spanmetrics.Config{})
+
+	wr := NewWriteRequest()
+	wr.Timeseries = []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: model.MetricNameLabelName, Value: "legacy_up"}, {Name: "job", Value: "api"}},
+		Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+	}}
+	numSamples, _, err := s.IngestMetrics(context.Background(), wr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), numSamples)
+
+	// The series is routed by its relabeled name, to the shard its queries
+	// are routed to, and relabeled once.
+	require.Empty(t, inserters[0].InsertedSeries)
+	require.Len(t, inserters[1].InsertedSeries, 1)
+	for series := range inserters[1].InsertedSeries {
+		require.Contains(t, series, "up")
+		require.NotContains(t, series, "legacy_up")
+		require.Contains(t, series, "api_relabeled")
+		require.NotContains(t, series, "api_relabeled_relabeled")
+	}
+}
@@ -0,0 +1,61 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// inventory keeps the last time each operation was seen in the ingested spans,
+// so that the service inventory can report it without scanning the span table.
+// It only knows about the spans ingested by this Promscale instance since it started.
+type inventory struct {
+	mu       sync.RWMutex
+	lastSeen map[operation]time.Time
+}
+
+var defaultInventory = newInventory()
+
+func newInventory() *inventory {
+	return &inventory{lastSeen: make(map[operation]time.Time)}
+}
+
+// observedOperations holds the latest end time of the spans of each operation
+// in a single batch of traces.
+type observedOperations map[operation]time.Time
+
+func (o observedOperations) observe(serviceName, spanName, spanKind string, end time.Time) {
+	op := operation{serviceName, spanName, spanKind}
+	if end.After(o[op]) {
+		o[op] = end
+	}
+}
+
+func (i *inventory) update(ops observedOperations) {
+	if len(ops) == 0 {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for op, end := range ops {
+		if end.After(i.lastSeen[op]) {
+			i.lastSeen[op] = end
+		}
+	}
+}
+
+func (i *inventory) get(serviceName, spanName, spanKind string) (time.Time, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	t, ok := i.lastSeen[operation{serviceName, spanName, spanKind}]
+	return t, ok
+}
+
+// OperationLastSeen returns the end time of the latest span of an operation
+// ingested by this instance, if any.
+func OperationLastSeen(serviceName, spanName, spanKind string) (time.Time, bool) {
+	return defaultInventory.get(serviceName, spanName, spanKind)
+}
@@ -0,0 +1,46 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventory(t *testing.T) {
+	var (
+		inv  = newInventory()
+		base = time.Unix(1000, 0)
+	)
+
+	batch := make(observedOperations)
+	batch.observe("svc", "op", "server", base.Add(time.Second))
+	batch.observe("svc", "op", "server", base)
+	batch.observe("svc", "op", "client", base)
+	inv.update(batch)
+
+	lastSeen, ok := inv.get("svc", "op", "server")
+	require.True(t, ok)
+	require.Equal(t, base.Add(time.Second), lastSeen)
+
+	// Spans older than the ones already seen do not move the time back.
+	batch = make(observedOperations)
+	batch.observe("svc", "op", "server", base)
+	batch.observe("svc", "op", "client", base.Add(time.Minute))
+	inv.update(batch)
+
+	lastSeen, ok = inv.get("svc", "op", "server")
+	require.True(t, ok)
+	require.Equal(t, base.Add(time.Second), lastSeen)
+
+	lastSeen, ok = inv.get("svc", "op", "client")
+	require.True(t, ok)
+	require.Equal(t, base.Add(time.Minute), lastSeen)
+
+	_, ok = inv.get("other", "op", "server")
+	require.False(t, ok)
+}
@@ -0,0 +1,362 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+)
+
+const (
+	decisionSampled = "sampled"
+	decisionDropped = "dropped"
+
+	// minDecisionTick bounds how often the buffered traces are checked for
+	// an expired decision window.
+	minDecisionTick = 100 * time.Millisecond
+)
+
+var (
+	sampledLabel = prometheus.Labels{"decision": decisionSampled}
+	droppedLabel = prometheus.Labels{"decision": decisionDropped}
+)
+
+type samplingPolicy struct {
+	name    string
+	matcher traceMatcher
+}
+
+// traceMatcher decides if a buffered trace matches a policy.
+type traceMatcher interface {
+	match(traceID pcommon.TraceID, spans ptrace.Traces) bool
+}
+
+type latencyMatcher struct {
+	threshold time.Duration
+}
+
+func (m latencyMatcher) match(_ pcommon.TraceID, spans ptrace.Traces) bool {
+	var start, end pcommon.Timestamp
+	forEachSpan(spans, func(_ ptrace.ResourceSpans, span ptrace.Span) bool {
+		if start == 0 || span.StartTimestamp() < start {
+			start = span.StartTimestamp()
+		}
+		if span.EndTimestamp() > end {
+			end = span.EndTimestamp()
+		}
+		return true
+	})
+	return end > start && time.Duration(end-start) >= m.threshold
+}
+
+type statusCodeMatcher struct {
+	code ptrace.StatusCode
+}
+
+func (m statusCodeMatcher) match(_ pcommon.TraceID, spans ptrace.Traces) bool {
+	matched := false
+	forEachSpan(spans, func(_ ptrace.ResourceSpans, span ptrace.Span) bool {
+		matched = span.Status().Code() == m.code
+		return !matched
+	})
+	return matched
+}
+
+type probabilisticMatcher struct {
+	// threshold is compared to the hash of the trace ID.
+	threshold uint64
+}
+
+func newProbabilisticMatcher(percentage float64) probabilisticMatcher {
+	if percentage >= 100 {
+		return probabilisticMatcher{threshold: math.MaxUint64}
+	}
+	return probabilisticMatcher{threshold: uint64(percentage / 100 * math.MaxUint64)}
+}
+
+func (m probabilisticMatcher) match(traceID pcommon.TraceID, _ ptrace.Traces) bool {
+	if m.threshold == math.MaxUint64 {
+		return true
+	}
+	id := traceID.Bytes()
+	return xxhash.Sum64(id[:]) < m.threshold
+}
+
+type attributeMatcher struct {
+	key    string
+	values map[string]struct{}
+	regex  *regexp.Regexp
+}
+
+func (m attributeMatcher) match(_ pcommon.TraceID, spans ptrace.Traces) bool {
+	matched := false
+	forEachSpan(spans, func(rSpans ptrace.ResourceSpans, span ptrace.Span) bool {
+		matched = m.matchAttributes(span.Attributes()) || m.matchAttributes(rSpans.Resource().Attributes())
+		return !matched
+	})
+	return matched
+}
+
+func (m attributeMatcher) matchAttributes(attrs pcommon.Map) bool {
+	v, ok := attrs.Get(m.key)
+	if !ok {
+		return false
+	}
+	if m.regex != nil {
+		return m.regex.MatchString(v.AsString())
+	}
+	_, ok = m.values[v.AsString()]
+	return ok
+}
+
+// forEachSpan calls f on every span of traces until f returns false.
+func forEachSpan(traces ptrace.Traces, f func(ptrace.ResourceSpans, ptrace.Span) bool) {
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		rSpan := rSpans.At(i)
+		scopeSpans := rSpan.ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			spans := scopeSpans.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				if !f(rSpan, spans.At(k)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// bufferedTrace holds the spans of a trace until the end of its decision window.
+type bufferedTrace struct {
+	traceID  pcommon.TraceID
+	spans    ptrace.Traces
+	deadline time.Time
+}
+
+// TailSampler buffers the spans of each trace for the decision window of the
+// policies, and writes the traces matching any policy to the next writer.
+// The spans received after the decision on their trace follow that decision.
+//
+// Buffered spans are acknowledged before they are written, so spans still
+// buffered when Promscale stops abruptly are lost.
+type TailSampler struct {
+	next     Writer
+	policies *TailSamplingPolicies
+
+	lock    sync.Mutex
+	traces  map[pcommon.TraceID]*bufferedTrace
+	queue   []*bufferedTrace // ordered by deadline
+	decided *clockcache.Cache
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTailSampler returns a writer sampling the traces written to next. next
+// is returned as is if policies are nil.
+func NewTailSampler(next Writer, policies *TailSamplingPolicies) Writer {
+	if policies == nil {
+		return next
+	}
+	s := &TailSampler{
+		next:     next,
+		policies: policies,
+		traces:   make(map[pcommon.TraceID]*bufferedTrace),
+		// Decisions are kept longer than the traces, to apply them to late spans.
+		decided: clockcache.WithMax(uint64(policies.maxTraces) * 4),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *TailSampler) InsertTraces(ctx context.Context, traces ptrace.Traces) error {
+	now := time.Now()
+	late := ptrace.NewTraces()
+	var expired []*bufferedTrace
+
+	s.lock.Lock()
+	for traceID, spans := range groupByTraceID(traces) {
+		if sampled, ok := s.decided.Get(traceID); ok {
+			s.countLate(spans.SpanCount(), sampled.(bool))
+			if sampled.(bool) {
+				spans.ResourceSpans().MoveAndAppendTo(late.ResourceSpans())
+			}
+			continue
+		}
+		if t, ok := s.traces[traceID]; ok {
+			spans.ResourceSpans().MoveAndAppendTo(t.spans.ResourceSpans())
+			continue
+		}
+		if len(s.traces) >= s.policies.maxTraces {
+			// The buffer is full, decide the oldest trace ahead of time.
+			expired = append(expired, s.popOldest())
+		}
+		t := &bufferedTrace{traceID: traceID, spans: spans, deadline: now.Add(s.policies.decisionWait)}
+		s.traces[traceID] = t
+		s.queue = append(s.queue, t)
+	}
+	metrics.TraceSamplingBufferedTraces.Set(float64(len(s.traces)))
+	s.lock.Unlock()
+
+	if len(expired) > 0 {
+		if err := s.decide(ctx, expired); err != nil {
+			return err
+		}
+	}
+	if late.SpanCount() == 0 {
+		return nil
+	}
+	return s.next.InsertTraces(ctx, late)
+}
+
+func (s *TailSampler) countLate(spans int, sampled bool) {
+	label := droppedLabel
+	if sampled {
+		label = sampledLabel
+	}
+	metrics.TraceSamplingLateSpans.With(label).Add(float64(spans))
+	metrics.TraceSamplingSpans.With(label).Add(float64(spans))
+}
+
+// popOldest removes the trace with the earliest deadline from the buffer.
+// It must be called with the lock held.
+func (s *TailSampler) popOldest() *bufferedTrace {
+	t := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	delete(s.traces, t.traceID)
+	return t
+}
+
+// popExpired removes the traces whose decision window ended before now, or
+// all the traces if now is zero.
+func (s *TailSampler) popExpired(now time.Time) []*bufferedTrace {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var expired []*bufferedTrace
+	for len(s.queue) > 0 && (now.IsZero() || !s.queue[0].deadline.After(now)) {
+		expired = append(expired, s.popOldest())
+	}
+	metrics.TraceSamplingBufferedTraces.Set(float64(len(s.traces)))
+	return expired
+}
+
+// decide applies the policies to the traces, and writes the sampled ones.
+func (s *TailSampler) decide(ctx context.Context, traces []*bufferedTrace) error {
+	sampled := ptrace.NewTraces()
+	for _, t := range traces {
+		keep := false
+		for _, p := range s.policies.policies {
+			if p.matcher.match(t.traceID, t.spans) {
+				metrics.TraceSamplingPolicyMatches.With(prometheus.Labels{"policy": p.name}).Inc()
+				keep = true
+			}
+		}
+		s.lock.Lock()
+		s.decided.Insert(t.traceID, keep, 1)
+		s.lock.Unlock()
+
+		label := droppedLabel
+		if keep {
+			label = sampledLabel
+		}
+		metrics.TraceSamplingDecisions.With(label).Inc()
+		metrics.TraceSamplingSpans.With(label).Add(float64(t.spans.SpanCount()))
+		if keep {
+			t.spans.ResourceSpans().MoveAndAppendTo(sampled.ResourceSpans())
+		}
+	}
+	if sampled.SpanCount() == 0 {
+		return nil
+	}
+	return s.next.InsertTraces(ctx, sampled)
+}
+
+func (s *TailSampler) run() {
+	defer close(s.done)
+	tick := s.policies.decisionWait / 10
+	if tick < minDecisionTick {
+		tick = minDecisionTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			if expired := s.popExpired(now); len(expired) > 0 {
+				if err := s.decide(context.Background(), expired); err != nil {
+					log.Error("msg", "error writing tail sampled traces", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// Close decides on all the buffered traces before closing the next writer.
+func (s *TailSampler) Close() {
+	close(s.stop)
+	<-s.done
+	if expired := s.popExpired(time.Time{}); len(expired) > 0 {
+		if err := s.decide(context.Background(), expired); err != nil {
+			log.Error("msg", "error writing tail sampled traces on close", "err", err)
+		}
+	}
+	s.next.Close()
+}
+
+// groupByTraceID splits traces into one ptrace.Traces per trace ID, keeping
+// the resource and scope of every span.
+func groupByTraceID(traces ptrace.Traces) map[pcommon.TraceID]ptrace.Traces {
+	groups := make(map[pcommon.TraceID]ptrace.Traces)
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		rSpan := rSpans.At(i)
+		scopeSpans := rSpan.ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			scopeSpan := scopeSpans.At(j)
+			// Destination of the spans of each trace for the current scope.
+			dest := make(map[pcommon.TraceID]ptrace.SpanSlice)
+			spans := scopeSpan.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				traceID := span.TraceID()
+				slice, ok := dest[traceID]
+				if !ok {
+					group, ok := groups[traceID]
+					if !ok {
+						group = ptrace.NewTraces()
+						groups[traceID] = group
+					}
+					newRSpan := group.ResourceSpans().AppendEmpty()
+					rSpan.Resource().CopyTo(newRSpan.Resource())
+					newRSpan.SetSchemaUrl(rSpan.SchemaUrl())
+					newScopeSpan := newRSpan.ScopeSpans().AppendEmpty()
+					scopeSpan.Scope().CopyTo(newScopeSpan.Scope())
+					newScopeSpan.SetSchemaUrl(scopeSpan.SchemaUrl())
+					slice = newScopeSpan.Spans()
+					dest[traceID] = slice
+				}
+				span.CopyTo(slice.AppendEmpty())
+			}
+		}
+	}
+	return groups
+}
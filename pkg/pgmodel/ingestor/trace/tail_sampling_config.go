@@ -0,0 +1,222 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultDecisionWait = 10 * time.Second
+	DefaultMaxTraces    = 50000
+)
+
+// TailSamplingConfig holds the tail sampling flags.
+type TailSamplingConfig struct {
+	ConfigFile string
+}
+
+// ParseTailSamplingFlags registers the tail sampling flags.
+func ParseTailSamplingFlags(fs *flag.FlagSet, cfg *TailSamplingConfig) *TailSamplingConfig {
+	fs.StringVar(&cfg.ConfigFile, "tracing.tail-sampling.config-file", "", "Path to a YAML file with the tail sampling policies of ingested traces. "+
+		"Spans are buffered per trace for a decision window, and only the traces matching a policy are written to the database. All traces are written if empty.")
+	return cfg
+}
+
+// ValidateTailSampling checks that the tail sampling file, if any, can be loaded.
+func ValidateTailSampling(cfg *TailSamplingConfig) error {
+	_, err := NewTailSamplingPolicies(cfg)
+	return err
+}
+
+// tailSamplingFile is the format of the tail sampling file, e.g.
+//
+//	decision_wait: 10s
+//	max_traces: 50000
+//	policies:
+//	  - name: slow
+//	    latency:
+//	      threshold: 2s
+//	  - name: errors
+//	    status_code: error
+//	  - name: checkout
+//	    attribute:
+//	      key: service.name
+//	      values: [checkout]
+//	  - name: baseline
+//	    probabilistic:
+//	      percentage: 5
+//
+// A trace is sampled if it matches any of the policies.
+type tailSamplingFile struct {
+	DecisionWait model.Duration `yaml:"decision_wait"`
+	MaxTraces    int            `yaml:"max_traces"`
+	Policies     []policyConfig `yaml:"policies"`
+}
+
+type policyConfig struct {
+	Name          string               `yaml:"name"`
+	Latency       *latencyConfig       `yaml:"latency"`
+	StatusCode    string               `yaml:"status_code"`
+	Probabilistic *probabilisticConfig `yaml:"probabilistic"`
+	Attribute     *attributeConfig     `yaml:"attribute"`
+}
+
+type latencyConfig struct {
+	// Threshold is the minimum duration between the earliest start and the
+	// latest end of the spans of the trace.
+	Threshold model.Duration `yaml:"threshold"`
+}
+
+type probabilisticConfig struct {
+	// Percentage of the traces sampled, selected by their trace ID so all
+	// the Promscale instances make the same decision.
+	Percentage float64 `yaml:"percentage"`
+}
+
+type attributeConfig struct {
+	// Key of a span or resource attribute.
+	Key    string   `yaml:"key"`
+	Values []string `yaml:"values"`
+	// Regex matches the whole value of the attribute.
+	Regex string `yaml:"regex"`
+}
+
+// TailSamplingPolicies are the loaded contents of the tail sampling file.
+// Nil policies disable tail sampling.
+type TailSamplingPolicies struct {
+	decisionWait time.Duration
+	maxTraces    int
+	policies     []samplingPolicy
+}
+
+// NewTailSamplingPolicies loads the tail sampling file of cfg, and returns
+// nil if no file is configured.
+func NewTailSamplingPolicies(cfg *TailSamplingConfig) (*TailSamplingPolicies, error) {
+	if cfg.ConfigFile == "" {
+		return nil, nil
+	}
+	contents, err := os.ReadFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading tail sampling file: %w", err)
+	}
+	return parseTailSamplingPolicies(contents)
+}
+
+func parseTailSamplingPolicies(contents []byte) (*TailSamplingPolicies, error) {
+	var f tailSamplingFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return nil, fmt.Errorf("parsing tail sampling file: %w", err)
+	}
+	p := &TailSamplingPolicies{
+		decisionWait: time.Duration(f.DecisionWait),
+		maxTraces:    f.MaxTraces,
+	}
+	if p.decisionWait == 0 {
+		p.decisionWait = DefaultDecisionWait
+	}
+	if p.maxTraces == 0 {
+		p.maxTraces = DefaultMaxTraces
+	}
+	if p.decisionWait < 0 || p.maxTraces < 0 {
+		return nil, fmt.Errorf("decision_wait and max_traces must be positive")
+	}
+	if len(f.Policies) == 0 {
+		return nil, fmt.Errorf("tail sampling file has no policies")
+	}
+	names := make(map[string]struct{}, len(f.Policies))
+	for i, c := range f.Policies {
+		policy, err := c.policy()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tail sampling policy at position %d: %w", i+1, err)
+		}
+		if _, ok := names[policy.name]; ok {
+			return nil, fmt.Errorf("duplicate tail sampling policy %q", policy.name)
+		}
+		names[policy.name] = struct{}{}
+		p.policies = append(p.policies, policy)
+	}
+	return p, nil
+}
+
+func (c policyConfig) policy() (samplingPolicy, error) {
+	p := samplingPolicy{name: c.Name}
+	if c.Name == "" {
+		return p, fmt.Errorf("name must be set")
+	}
+	kinds := 0
+	if c.Latency != nil {
+		kinds++
+		if c.Latency.Threshold <= 0 {
+			return p, fmt.Errorf("latency threshold must be positive")
+		}
+		p.matcher = latencyMatcher{threshold: time.Duration(c.Latency.Threshold)}
+	}
+	if c.StatusCode != "" {
+		kinds++
+		code, ok := statusCodes[strings.ToLower(c.StatusCode)]
+		if !ok {
+			return p, fmt.Errorf("unknown status code %q, must be one of unset, ok and error", c.StatusCode)
+		}
+		p.matcher = statusCodeMatcher{code: code}
+	}
+	if c.Probabilistic != nil {
+		kinds++
+		if c.Probabilistic.Percentage < 0 || c.Probabilistic.Percentage > 100 {
+			return p, fmt.Errorf("probabilistic percentage must be within [0, 100]")
+		}
+		p.matcher = newProbabilisticMatcher(c.Probabilistic.Percentage)
+	}
+	if c.Attribute != nil {
+		kinds++
+		m, err := c.Attribute.matcher()
+		if err != nil {
+			return p, err
+		}
+		p.matcher = m
+	}
+	if kinds != 1 {
+		return p, fmt.Errorf("exactly one of latency, status_code, probabilistic and attribute must be set")
+	}
+	return p, nil
+}
+
+func (c attributeConfig) matcher() (attributeMatcher, error) {
+	m := attributeMatcher{key: c.Key}
+	if c.Key == "" {
+		return m, fmt.Errorf("attribute key must be set")
+	}
+	if (len(c.Values) == 0) == (c.Regex == "") {
+		return m, fmt.Errorf("exactly one of attribute values and regex must be set")
+	}
+	if c.Regex != "" {
+		re, err := regexp.Compile("^(?:" + c.Regex + ")$")
+		if err != nil {
+			return m, fmt.Errorf("invalid attribute regex: %w", err)
+		}
+		m.regex = re
+		return m, nil
+	}
+	m.values = make(map[string]struct{}, len(c.Values))
+	for _, v := range c.Values {
+		m.values[v] = struct{}{}
+	}
+	return m, nil
+}
+
+var statusCodes = map[string]ptrace.StatusCode{
+	"unset": ptrace.StatusCodeUnset,
+	"ok":    ptrace.StatusCodeOk,
+	"error": ptrace.StatusCodeError,
+}
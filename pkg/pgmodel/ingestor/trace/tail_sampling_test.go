@@ -0,0 +1,169 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestParseTailSamplingPolicies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		err      bool
+	}{
+		{
+			name: "all policies",
+			contents: `
+decision_wait: 5s
+policies:
+  - name: slow
+    latency:
+      threshold: 2s
+  - name: errors
+    status_code: error
+  - name: checkout
+    attribute:
+      key: service.name
+      values: [checkout]
+  - name: baseline
+    probabilistic:
+      percentage: 5
+`,
+		},
+		{name: "no policies", contents: "decision_wait: 5s", err: true},
+		{name: "no name", contents: "policies: [{status_code: error}]", err: true},
+		{name: "duplicate name", contents: "policies: [{name: a, status_code: error}, {name: a, status_code: ok}]", err: true},
+		{name: "two kinds", contents: "policies: [{name: a, status_code: error, latency: {threshold: 1s}}]", err: true},
+		{name: "unknown status code", contents: "policies: [{name: a, status_code: failed}]", err: true},
+		{name: "percentage out of range", contents: "policies: [{name: a, probabilistic: {percentage: 101}}]", err: true},
+		{name: "values and regex", contents: "policies: [{name: a, attribute: {key: k, values: [v], regex: v.*}}]", err: true},
+		{name: "invalid regex", contents: "policies: [{name: a, attribute: {key: k, regex: '('}}]", err: true},
+		{name: "unknown field", contents: "policies: [{name: a, status: error}]", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parseTailSamplingPolicies([]byte(c.contents))
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 5*time.Second, p.decisionWait)
+			require.Equal(t, DefaultMaxTraces, p.maxTraces)
+			require.Len(t, p.policies, 4)
+		})
+	}
+}
+
+func newSamplingTestSpan(traces ptrace.Traces, traceID byte, service string, duration time.Duration, code ptrace.StatusCode) {
+	rSpan := traces.ResourceSpans().AppendEmpty()
+	rSpan.Resource().Attributes().InsertString(serviceNameTagKey, service)
+	span := rSpan.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(pcommon.NewTraceID([16]byte{traceID}))
+	span.SetSpanID(pcommon.NewSpanID([8]byte{byte(traces.SpanCount())}))
+	start := time.Unix(1000, 0)
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(duration)))
+	span.Status().SetCode(code)
+}
+
+func TestTailSampler(t *testing.T) {
+	policies, err := parseTailSamplingPolicies([]byte(`
+decision_wait: 1h
+policies:
+  - name: slow
+    latency:
+      threshold: 2s
+  - name: errors
+    status_code: error
+  - name: checkout
+    attribute:
+      key: service.name
+      regex: check.*
+`))
+	require.NoError(t, err)
+
+	written := make(map[byte]int)
+	writer := &noopWriter{callBack: func(traces ptrace.Traces) {
+		forEachSpan(traces, func(_ ptrace.ResourceSpans, span ptrace.Span) bool {
+			written[span.TraceID().Bytes()[0]]++
+			return true
+		})
+	}}
+	sampler := NewTailSampler(writer, policies).(*TailSampler)
+
+	traces := ptrace.NewTraces()
+	newSamplingTestSpan(traces, 1, "frontend", time.Second, ptrace.StatusCodeOk)
+	newSamplingTestSpan(traces, 1, "backend", 3*time.Second, ptrace.StatusCodeOk) // slow
+	newSamplingTestSpan(traces, 2, "frontend", time.Second, ptrace.StatusCodeError)
+	newSamplingTestSpan(traces, 3, "checkout", time.Second, ptrace.StatusCodeOk)
+	newSamplingTestSpan(traces, 4, "frontend", time.Second, ptrace.StatusCodeOk)
+	require.NoError(t, sampler.InsertTraces(context.Background(), traces))
+	require.Empty(t, written, "spans are written after the decision window")
+
+	expired := sampler.popExpired(time.Now().Add(2 * time.Hour))
+	require.Len(t, expired, 4)
+	require.NoError(t, sampler.decide(context.Background(), expired))
+	require.Equal(t, map[byte]int{1: 2, 2: 1, 3: 1}, written)
+
+	// Late spans follow the decision on their trace.
+	late := ptrace.NewTraces()
+	newSamplingTestSpan(late, 1, "frontend", time.Second, ptrace.StatusCodeOk)
+	newSamplingTestSpan(late, 4, "frontend", time.Second, ptrace.StatusCodeError)
+	require.NoError(t, sampler.InsertTraces(context.Background(), late))
+	require.Equal(t, map[byte]int{1: 3, 2: 1, 3: 1}, written)
+
+	// Buffered traces are decided on close.
+	pending := ptrace.NewTraces()
+	newSamplingTestSpan(pending, 5, "frontend", time.Second, ptrace.StatusCodeError)
+	require.NoError(t, sampler.InsertTraces(context.Background(), pending))
+	sampler.Close()
+	require.Equal(t, 1, written[5])
+}
+
+func TestTailSamplerMaxTraces(t *testing.T) {
+	policies, err := parseTailSamplingPolicies([]byte(`
+decision_wait: 1h
+max_traces: 2
+policies:
+  - name: all
+    probabilistic:
+      percentage: 100
+`))
+	require.NoError(t, err)
+
+	spans := 0
+	writer := &noopWriter{callBack: func(traces ptrace.Traces) { spans += traces.SpanCount() }}
+	sampler := NewTailSampler(writer, policies).(*TailSampler)
+	defer sampler.Close()
+
+	traces := ptrace.NewTraces()
+	for i := byte(1); i <= 3; i++ {
+		newSamplingTestSpan(traces, i, "frontend", time.Second, ptrace.StatusCodeOk)
+	}
+	require.NoError(t, sampler.InsertTraces(context.Background(), traces))
+	require.Equal(t, 1, spans, "the oldest trace is decided when the buffer is full")
+	require.Len(t, sampler.traces, 2)
+}
+
+func TestProbabilisticMatcher(t *testing.T) {
+	m := newProbabilisticMatcher(25)
+	matched := 0
+	for i := 0; i < 10000; i++ {
+		id := [16]byte{byte(i), byte(i >> 8)}
+		if m.match(pcommon.NewTraceID(id), ptrace.NewTraces()) {
+			matched++
+		}
+	}
+	require.InDelta(t, 2500, matched, 250)
+	require.False(t, newProbabilisticMatcher(0).match(pcommon.NewTraceID([16]byte{1}), ptrace.NewTraces()))
+}
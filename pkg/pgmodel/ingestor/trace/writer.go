@@ -59,6 +59,7 @@ type traceWriterImpl struct {
 	instLibCache *clockcache.Cache
 	opCache      *clockcache.Cache
 	tagCache     *clockcache.Cache
+	inventory    *inventory
 }
 
 func NewWriter(conn pgxconn.PgxConn) *traceWriterImpl {
@@ -68,6 +69,7 @@ func NewWriter(conn pgxconn.PgxConn) *traceWriterImpl {
 		instLibCache: newInstrumentationLibraryCache(),
 		opCache:      newOperationCache(),
 		tagCache:     newTagCache(),
+		inventory:    defaultInventory,
 	}
 }
 
@@ -239,6 +241,8 @@ func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces ptrace.Traces
 		spanRows  [][]interface{}
 		linkRows  [][]interface{}
 		eventRows [][]interface{}
+
+		observedOps = make(observedOperations)
 	)
 	for i := 0; i < rSpans.Len(); i++ {
 		rSpan := rSpans.At(i)
@@ -312,6 +316,7 @@ func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces ptrace.Traces
 				if maxEndTime.Before(end) {
 					maxEndTime = end
 				}
+				observedOps.observe(serviceName, spanName, spanKind, end)
 
 				statusCode, err := getPGStatusCode(span.Status().Code())
 				if err != nil {
@@ -347,6 +352,7 @@ func (t *traceWriterImpl) InsertTraces(ctx context.Context, traces ptrace.Traces
 		return fmt.Errorf("error inserting spans: %w", err)
 	}
 	metrics.IngestorItems.With(prometheus.Labels{"type": "trace", "kind": "span", "subsystem": ""}).Add(float64(traces.SpanCount()))
+	t.inventory.update(observedOps)
 	metrics.IngestorInsertDuration.With(prometheus.Labels{"type": "trace", "subsystem": "", "kind": "span"}).Observe(time.Since(start).Seconds())
 
 	code = "2xx"
@@ -0,0 +1,227 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/timescale/promscale/pkg/intern"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/model/pgutf8str"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+// warmUpSeriesSQL loads the labels of the series selected by one of the
+// queries below.
+const warmUpSeriesSQL = `SELECT s.id, m.metric_name,
+	array_agg(l.key ORDER BY l.key), array_agg(l.value ORDER BY l.key),
+	array_agg(l.id ORDER BY l.key), array_agg(p.pos ORDER BY l.key)
+FROM (%s) s
+INNER JOIN _prom_catalog.metric m ON (m.id = s.metric_id)
+INNER JOIN LATERAL unnest(s.labels) AS lid(id) ON (true)
+INNER JOIN _prom_catalog.label l ON (l.id = lid.id)
+INNER JOIN _prom_catalog.label_key_position p ON (p.metric_name = m.metric_name AND p.key = l.key)
+GROUP BY s.id, m.metric_name`
+
+// The series table has no last write time, series IDs are increasing, so
+// the most recently created series are used instead of the most recently
+// written ones. Series marked for deletion are skipped.
+const recentSeriesSQL = `SELECT id, metric_id, labels
+	FROM _prom_catalog.series
+	WHERE delete_epoch IS NULL
+	ORDER BY id DESC
+	LIMIT $1`
+
+// activeSeriesSQL ranks the series by their estimated number of samples in
+// the latest chunk of each metric, taken from the most common values of the
+// series_id column gathered by ANALYZE. Nothing is read from the chunks. The
+// most recently created series follow the ones in the statistics. Only the
+// top ranked and the most recently created series are candidates, so that
+// the series table is not scanned.
+const activeSeriesSQL = `WITH latest_chunk AS (
+		SELECT DISTINCT ON (hypertable_name) chunk_schema, chunk_name
+		FROM timescaledb_information.chunks
+		WHERE hypertable_schema = '` + schema.PromData + `'
+		ORDER BY hypertable_name, range_end DESC
+	), active AS (
+		SELECT mcv.id, max(mcv.freq * greatest(c.reltuples, 0)) AS samples
+		FROM latest_chunk lc
+		INNER JOIN pg_stats st ON (st.schemaname = lc.chunk_schema AND st.tablename = lc.chunk_name AND st.attname = 'series_id')
+		INNER JOIN pg_namespace n ON (n.nspname = lc.chunk_schema)
+		INNER JOIN pg_class c ON (c.relnamespace = n.oid AND c.relname = lc.chunk_name)
+		INNER JOIN LATERAL unnest(st.most_common_vals::text::bigint[], st.most_common_freqs) AS mcv(id, freq) ON (true)
+		GROUP BY mcv.id
+		ORDER BY samples DESC
+		LIMIT $1
+	), recent AS (
+		SELECT id, NULL::real AS samples
+		FROM _prom_catalog.series
+		WHERE delete_epoch IS NULL
+		ORDER BY id DESC
+		LIMIT $1
+	), candidate AS (
+		SELECT id, max(samples) AS samples
+		FROM (SELECT id, samples FROM active UNION ALL SELECT id, samples FROM recent) c
+		GROUP BY id
+	)
+	SELECT s.id, s.metric_id, s.labels
+	FROM candidate c
+	INNER JOIN _prom_catalog.series s ON (s.id = c.id)
+	WHERE s.delete_epoch IS NULL
+	ORDER BY c.samples DESC NULLS LAST, s.id DESC
+	LIMIT $1`
+
+// warmUpMetricsSQL loads the table names of the metrics of the warmed up
+// series.
+const warmUpMetricsSQL = `SELECT id, metric_name, table_schema, table_name, series_table
+FROM _prom_catalog.metric
+WHERE metric_name = ANY($1::text[])
+LIMIT $2`
+
+// runWarmUp warms up the caches and marks the dispatcher as warmed up when
+// done, even if the warm-up failed or timed out.
+func (p *pgxDispatcher) runWarmUp(numSeries uint64, byActivity bool, timeout time.Duration) {
+	defer p.warmedUp.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.doneChannel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if limit := uint64(p.scache.Cap()); numSeries > limit {
+		numSeries = limit
+	}
+	log.Info("msg", "Warming up the series cache", "series", numSeries, "by_activity", byActivity)
+	start := time.Now()
+	loaded, metricNames, err := p.warmUpCaches(ctx, numSeries, byActivity)
+	metrics.IngestorCacheWarmUpSeries.Set(float64(loaded))
+	if err != nil {
+		metrics.IngestorCacheWarmUpDuration.Set(time.Since(start).Seconds())
+		log.Warn("msg", "Series cache warm-up did not complete, keeping the series loaded so far", "series", loaded, "err", err)
+		return
+	}
+	loadedMetrics, err := p.warmUpMetricCache(ctx, metricNames)
+	metrics.IngestorCacheWarmUpMetrics.Set(float64(loadedMetrics))
+	metrics.IngestorCacheWarmUpDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		log.Warn("msg", "Metric name cache warm-up did not complete", "series", loaded, "metrics", loadedMetrics, "err", err)
+		return
+	}
+	log.Info("msg", "Caches warmed up", "series", loaded, "metrics", loadedMetrics, "duration", time.Since(start))
+}
+
+// warmUpCaches loads numSeries series into the series cache, and the IDs of
+// their labels into the inverted labels cache. The series are the most
+// recently created ones, or the most active ones if byActivity is set. It
+// returns the number of series loaded and the names of their metrics.
+func (p *pgxDispatcher) warmUpCaches(ctx context.Context, numSeries uint64, byActivity bool) (int, []string, error) {
+	// The epoch is read before the series, at worst the series get too small
+	// an epoch, which is always safe.
+	epoch, err := p.getServerEpoch()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error warming up caches: reading epoch: %w", err)
+	}
+	selectSeries := recentSeriesSQL
+	if byActivity {
+		selectSeries = activeSeriesSQL
+	}
+	rows, err := p.conn.Query(ctx, fmt.Sprintf(warmUpSeriesSQL, selectSeries), numSeries)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error warming up caches: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := 0
+	seen := make(map[string]struct{})
+	metricNames := make([]string, 0)
+	for rows.Next() {
+		var (
+			id          model.SeriesID
+			metricName  string
+			labelNames  pgutf8str.TextArray
+			labelValues pgutf8str.TextArray
+			labelIDs    []int32
+			pos         []int32
+		)
+		if err = rows.Scan(&id, &metricName, &labelNames, &labelValues, &labelIDs, &pos); err != nil {
+			return loaded, metricNames, fmt.Errorf("error warming up caches: %w", err)
+		}
+		names := labelNames.Get().([]string)
+		values := labelValues.Get().([]string)
+		if len(names) != len(values) || len(names) != len(labelIDs) || len(names) != len(pos) {
+			return loaded, metricNames, fmt.Errorf("error warming up caches: series %d has mismatched label arrays", id)
+		}
+
+		metricName = intern.String(metricName)
+		if _, ok := seen[metricName]; !ok {
+			seen[metricName] = struct{}{}
+			metricNames = append(metricNames, metricName)
+		}
+		labelPairs := make([]prompb.Label, len(names))
+		for i := range names {
+			labelPairs[i] = prompb.Label{Name: intern.String(names[i]), Value: intern.String(values[i])}
+			key := cache.NewLabelKey(metricName, labelPairs[i].Name, labelPairs[i].Value)
+			p.invertedLabelsCache.Put(key, cache.NewLabelInfo(labelIDs[i], pos[i]))
+		}
+		if err = p.scache.PreloadSeries(labelPairs, id, epoch); err != nil {
+			return loaded, metricNames, fmt.Errorf("error warming up caches: series %d: %w", id, err)
+		}
+		loaded++
+	}
+	if err = rows.Err(); err != nil {
+		return loaded, metricNames, fmt.Errorf("error warming up caches: %w", err)
+	}
+	return loaded, metricNames, nil
+}
+
+// warmUpMetricCache loads the table names of the given metrics into the
+// metric name cache, at most its capacity, so that the metric batchers of the
+// first requests don't have to look them up. It returns the number of metrics
+// loaded.
+func (p *pgxDispatcher) warmUpMetricCache(ctx context.Context, metricNames []string) (int, error) {
+	if len(metricNames) == 0 {
+		return 0, nil
+	}
+	rows, err := p.conn.Query(ctx, warmUpMetricsSQL, metricNames, p.metricTableNames.Cap())
+	if err != nil {
+		return 0, fmt.Errorf("error warming up metric name cache: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := 0
+	for rows.Next() {
+		var (
+			name string
+			info model.MetricInfo
+		)
+		if err = rows.Scan(&info.MetricID, &name, &info.TableSchema, &info.TableName, &info.SeriesTable); err != nil {
+			return loaded, fmt.Errorf("error warming up metric name cache: %w", err)
+		}
+		if err = p.metricTableNames.Set(schema.PromData, name, info, false); err != nil {
+			return loaded, fmt.Errorf("error warming up metric name cache: metric %s: %w", name, err)
+		}
+		loaded++
+	}
+	if err = rows.Err(); err != nil {
+		return loaded, fmt.Errorf("error warming up metric name cache: %w", err)
+	}
+	return loaded, nil
+}
+
+// WarmedUp returns false while the caches are being warmed up on startup.
+func (p *pgxDispatcher) WarmedUp() bool {
+	return p.warmedUp.Load()
+}
@@ -0,0 +1,92 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ingestor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestRunWarmUp(t *testing.T) {
+	testCases := []struct {
+		name         string
+		byActivity   bool
+		selectSeries string
+	}{
+		{name: "recent", selectSeries: recentSeriesSQL},
+		{name: "by_activity", byActivity: true, selectSeries: activeSeriesSQL},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			// The metric name cache registers its metrics by shard name.
+			cacheCfg := cache.DefaultConfig
+			cacheCfg.Shard = "warm_up_" + c.name
+			metricCache := cache.NewMetricCache(cacheCfg)
+			invertedLabelsCache, err := cache.NewInvertedLabelsCache(cache.DefaultConfig.InvertedLabelsCacheSize)
+			require.NoError(t, err)
+			conn := model.NewSqlRecorder([]model.SqlQuery{
+				{Sql: getEpochSQL, Results: model.RowResults{{int64(1)}}},
+				{
+					Sql:  fmt.Sprintf(warmUpSeriesSQL, c.selectSeries),
+					Args: []interface{}{uint64(2)},
+					// The series in the order they are ranked by the
+					// database, the busiest first.
+					Results: model.RowResults{
+						{int64(7), "busy", []string{"__name__", "job"}, []string{"busy", "api"}, []int32{1, 2}, []int32{1, 2}},
+						{int64(9), "quiet", []string{"__name__", "job"}, []string{"quiet", "api"}, []int32{3, 2}, []int32{1, 2}},
+					},
+				},
+				{
+					Sql:     warmUpMetricsSQL,
+					Args:    []interface{}{[]string{"busy", "quiet"}, metricCache.Cap()},
+					Results: model.RowResults{{int64(1), "busy", "prom_data", "busy", "busy"}, {int64(2), "quiet", "prom_data", "quiet_2", "quiet_2"}},
+				},
+			}, t)
+			p := &pgxDispatcher{
+				conn:                conn,
+				metricTableNames:    metricCache,
+				scache:              cache.NewSeriesCache(cache.DefaultConfig, nil),
+				invertedLabelsCache: invertedLabelsCache,
+				doneChannel:         make(chan struct{}),
+				warmedUp:            atomic.NewBool(false),
+			}
+			p.runWarmUp(2, c.byActivity, time.Minute)
+			require.True(t, p.WarmedUp())
+
+			for name, id := range map[string]model.SeriesID{"busy": 7, "quiet": 9} {
+				series, _, err := p.scache.GetSeriesFromProtos([]prompb.Label{{Name: "__name__", Value: name}, {Name: "job", Value: "api"}})
+				require.NoError(t, err)
+				require.True(t, series.IsSeriesIDSet(), name)
+				seriesID, _, err := series.GetSeriesID()
+				require.NoError(t, err)
+				require.Equal(t, id, seriesID)
+			}
+			info, ok := invertedLabelsCache.GetLabelsId(cache.NewLabelKey("quiet", "__name__", "quiet"))
+			require.True(t, ok)
+			require.Equal(t, cache.NewLabelInfo(3, 1), info)
+
+			// The metrics of the warmed up series are in the metric name
+			// cache, with their table names.
+			require.Equal(t, 2, metricCache.Len())
+			busy, err := metricCache.Get(schema.PromData, "busy", false)
+			require.NoError(t, err)
+			require.Equal(t, model.MetricInfo{MetricID: 1, TableSchema: "prom_data", TableName: "busy", SeriesTable: "busy"}, busy)
+			quiet, err := metricCache.Get(schema.PromData, "quiet", false)
+			require.NoError(t, err)
+			require.Equal(t, "quiet_2", quiet.TableName)
+			_, err = metricCache.Get(schema.PromData, "other", false)
+			require.Error(t, err)
+		})
+	}
+}
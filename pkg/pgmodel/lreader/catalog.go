@@ -0,0 +1,137 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package lreader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// The label ids come from a sequence, so a new label always raises the
+	// maximum id. The lookup is answered by the primary key index.
+	getMaxLabelIDSQL = "SELECT coalesce(max(id), 0) FROM _prom_catalog.label"
+
+	// catalogMaxAge bounds how long deleted labels can still be returned.
+	catalogMaxAge = 5 * time.Minute
+)
+
+var catalogLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "cache",
+		Name:      "label_catalog_lookups_total",
+		Help:      "Total number of label names and values queries, by whether they were answered from the label catalog cache.",
+	}, []string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(catalogLookups)
+}
+
+// labelsCatalog caches the label names and the values of the label names
+// queried without matchers, so that they are answered from memory while no
+// label is added. The values of a label name are only cached once they
+// are queried.
+type labelsCatalog struct {
+	mu     sync.Mutex
+	maxID  int64
+	loaded time.Time
+	names  []string
+	values map[string][]string
+}
+
+func newLabelsCatalog() *labelsCatalog {
+	return &labelsCatalog{values: make(map[string][]string)}
+}
+
+// lookup returns the strings of key, the label names if key is nil, from
+// the cache if no label was added since they were cached, or from load.
+// The returned slice is a copy that the caller owns.
+func (c *labelsCatalog) lookup(ctx context.Context, conn pgxconn.PgxConn, key *string, load func() ([]string, error)) ([]string, error) {
+	maxID, err := c.validate(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if res, found := c.get(key); found {
+		return append(make([]string, 0, len(res)), res...), nil
+	}
+	res, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.set(maxID, key, append(make([]string, 0, len(res)), res...))
+	return res, nil
+}
+
+// cached returns the strings of key if they are cached and no label was
+// added since they were cached. The returned slice must not be modified.
+func (c *labelsCatalog) cached(ctx context.Context, conn pgxconn.PgxConn, key string) ([]string, bool, error) {
+	if _, err := c.validate(ctx, conn); err != nil {
+		return nil, false, err
+	}
+	res, found := c.get(&key)
+	return res, found, nil
+}
+
+// validate drops the cached labels if a label was added since they were
+// loaded, or if they were loaded more than catalogMaxAge ago. It returns
+// the maximum label id.
+func (c *labelsCatalog) validate(ctx context.Context, conn pgxconn.PgxConn) (int64, error) {
+	var maxID int64
+	if err := conn.QueryRow(ctx, getMaxLabelIDSQL).Scan(&maxID); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maxID != c.maxID || time.Since(c.loaded) > catalogMaxAge {
+		c.maxID = maxID
+		c.loaded = time.Now()
+		c.names = nil
+		c.values = make(map[string][]string)
+	}
+	return maxID, nil
+}
+
+// get returns the cached strings of key, the label names if key is nil.
+func (c *labelsCatalog) get(key *string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		res   []string
+		found bool
+	)
+	if key == nil {
+		res, found = c.names, c.names != nil
+	} else {
+		res, found = c.values[*key]
+	}
+	if found {
+		catalogLookups.WithLabelValues("hit").Inc()
+	} else {
+		catalogLookups.WithLabelValues("miss").Inc()
+	}
+	return res, found
+}
+
+// set caches the strings of key, the label names if key is nil. The
+// strings are not cached if a label was added since maxID was read.
+func (c *labelsCatalog) set(maxID int64, key *string, res []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maxID != c.maxID {
+		return
+	}
+	if key == nil {
+		c.names = res
+	} else {
+		c.values[*key] = res
+	}
+}
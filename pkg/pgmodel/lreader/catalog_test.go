@@ -0,0 +1,36 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package lreader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestLabelsCatalog(t *testing.T) {
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+		{Sql: getLabelNamesSQL, Results: model.RowResults{{"job"}, {"__name__"}}},
+		// The label names are cached while no label is added.
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(3)}}},
+		{Sql: getLabelNamesSQL, Results: model.RowResults{{"job"}, {"instance"}, {"__name__"}}},
+	}, t)
+	reader := labelsReader{conn: mock, catalog: newLabelsCatalog()}
+
+	for _, expected := range [][]string{
+		{"__name__", "job"},
+		{"__name__", "job"},
+		{"__name__", "instance", "job"},
+	} {
+		res, err := reader.LabelNames()
+		require.NoError(t, err)
+		require.Equal(t, expected, res)
+		// The cached names must not be modified by the callers.
+		res[0] = "modified"
+	}
+}
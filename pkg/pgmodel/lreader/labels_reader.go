@@ -13,6 +13,7 @@ import (
 
 	"github.com/prometheus/prometheus/model/labels"
 
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/model/pgutf8str"
@@ -24,6 +25,22 @@ const (
 	getLabelNamesSQL  = "SELECT distinct key from _prom_catalog.label"
 	getLabelValuesSQL = "SELECT value from _prom_catalog.label WHERE key = $1"
 	getLabelsSQL      = "SELECT (prom_api.labels_info($1::int[])).*"
+
+	// The values with a prefix are searched with the byte-wise operators of
+	// the label_key_value_pattern index, the values of the range starting
+	// with the prefix. A NULL limit returns all the values.
+	searchLabelValuesSQL = `SELECT value FROM _prom_catalog.label
+	WHERE key = $1 AND value ~>=~ $2 AND value ~<~ $3
+	ORDER BY value USING ~<~
+	LIMIT $4`
+	searchAllLabelValuesSQL = `SELECT value FROM _prom_catalog.label
+	WHERE key = $1
+	ORDER BY value USING ~<~
+	LIMIT $2`
+
+	// maxRune follows the values starting with a prefix in byte order,
+	// unless they continue with it. It is a noncharacter of Unicode.
+	maxRune = "\U0010FFFF"
 )
 
 // LabelsReader defines the methods for accessing labels data
@@ -32,6 +49,9 @@ type LabelsReader interface {
 	LabelNames() ([]string, error)
 	// LabelValues returns all the distinct values for a given label name.
 	LabelValues(labelName string) ([]string, error)
+	// SearchLabelValues returns the first values of a label name starting
+	// with prefix in byte order, at most limit if it is not 0.
+	SearchLabelValues(labelName, prefix string, limit int) ([]string, error)
 	// LabelsForIdMap fills in the label.Label values in a map of label id => labels.Label.
 	LabelsForIdMap(idMap map[int64]labels.Label) (err error)
 }
@@ -41,7 +61,7 @@ func NewLabelsReader(conn pgxconn.PgxConn, labels cache.LabelsCache, mt tenancy.
 	if mt != nil {
 		authConfig = mt.(tenancy.AuthConfig)
 	}
-	return &labelsReader{conn: conn, labels: labels, authConfig: authConfig}
+	return &labelsReader{conn: conn, labels: labels, authConfig: authConfig, catalog: newLabelsCatalog()}
 }
 
 const (
@@ -98,6 +118,9 @@ type labelsReader struct {
 	conn       pgxconn.PgxConn
 	labels     cache.LabelsCache
 	authConfig tenancy.AuthConfig
+	// catalog answers the label names and values queries from memory while
+	// no label is added. The queries go to the database if it is nil.
+	catalog *labelsCatalog
 }
 
 // LabelValues implements the LabelsReader interface. It returns all distinct values
@@ -125,31 +148,74 @@ func (lr *labelsReader) LabelValues(labelName string) ([]string, error) {
 		}
 		return labelValues, nil
 	}
-	rows, err := lr.conn.Query(context.Background(), getLabelValuesSQL, labelName)
+	var (
+		values []string
+		err    error
+	)
+	if lr.catalog != nil {
+		values, err = lr.catalog.lookup(context.Background(), lr.conn, &labelName, func() ([]string, error) {
+			return lr.queryStrings(getLabelValuesSQL, labelName)
+		})
+	} else {
+		values, err = lr.queryStrings(getLabelValuesSQL, labelName)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if labelName != tenancy.TenantLabelKey || lr.authConfig == nil {
+		return values, nil
+	}
+	labelValues := make([]string, 0, len(values))
+	for _, value := range values {
+		if lr.authConfig.IsTenantAllowed(value) {
+			labelValues = append(labelValues, value)
+		}
+	}
+	return labelValues, nil
+}
 
-	defer rows.Close()
-
-	labelValues := make([]string, 0)
-
-	for rows.Next() {
-		var value string
-		if err := rows.Scan(&value); err != nil {
+// SearchLabelValues implements the LabelsReader interface. The values are
+// answered from the label catalog if all the values of the label name are
+// cached, and searched in the database otherwise.
+func (lr *labelsReader) SearchLabelValues(labelName, prefix string, limit int) ([]string, error) {
+	if lr.authConfig != nil && (lr.authConfig.AllowAuthorizedTenantsOnly() || labelName == tenancy.TenantLabelKey) {
+		// The values readable by the tenants are not indexed.
+		values, err := lr.LabelValues(labelName)
+		if err != nil {
 			return nil, err
 		}
-		if labelName == tenancy.TenantLabelKey && lr.authConfig != nil {
-			if lr.authConfig.IsTenantAllowed(value) {
-				labelValues = append(labelValues, value)
-			}
-		} else {
-			labelValues = append(labelValues, value)
+		return SearchSorted(values, prefix, limit), nil
+	}
+	if lr.catalog != nil {
+		values, found, err := lr.catalog.cached(context.Background(), lr.conn, labelName)
+		if err != nil {
+			return nil, err
 		}
+		if found {
+			return SearchSorted(values, prefix, limit), nil
+		}
+	}
+	var sqlLimit interface{}
+	if limit > 0 {
+		sqlLimit = limit
+	}
+	if prefix == "" {
+		return lr.queryStrings(searchAllLabelValuesSQL, labelName, sqlLimit)
 	}
+	return lr.queryStrings(searchLabelValuesSQL, labelName, prefix, prefix+maxRune, sqlLimit)
+}
 
-	sort.Strings(labelValues)
-	return labelValues, nil
+// SearchSorted returns the first strings of the sorted values starting with
+// prefix, at most limit if it is not 0.
+func SearchSorted(values []string, prefix string, limit int) []string {
+	res := make([]string, 0)
+	for i := sort.SearchStrings(values, prefix); i < len(values) && strings.HasPrefix(values[i], prefix); i++ {
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		res = append(res, values[i])
+	}
+	return res
 }
 
 // LabelNames implements the LabelReader interface. It returns all distinct
@@ -181,26 +247,37 @@ func (lr *labelsReader) LabelNames() ([]string, error) {
 		return labelNames, nil
 	}
 
-	rows, err := lr.conn.Query(context.Background(), getLabelNamesSQL)
+	if lr.catalog != nil {
+		return lr.catalog.lookup(context.Background(), lr.conn, nil, func() ([]string, error) {
+			return lr.queryStrings(getLabelNamesSQL)
+		})
+	}
+	return lr.queryStrings(getLabelNamesSQL)
+}
+
+// queryStrings returns the sorted strings of the single column returned by
+// the query.
+func (lr *labelsReader) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := lr.conn.Query(context.Background(), query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	labelNames := make([]string, 0)
+	res := make([]string, 0)
 
 	for rows.Next() {
-		var labelName string
-		if err := rows.Scan(&labelName); err != nil {
+		var s string
+		if err := rows.Scan(&s); err != nil {
 			return nil, err
 		}
 
-		labelNames = append(labelNames, labelName)
+		res = append(res, s)
 	}
 
-	sort.Strings(labelNames)
-	return labelNames, nil
+	sort.Strings(res)
+	return res, nil
 }
 
 // LabelsForIdMap fills in the label.Label values in a map of label id => labels.Label.
@@ -290,7 +367,7 @@ func (lr *labelsReader) fetchMissingLabels(misses []interface{}, missedIds []int
 		sizes := make([]uint64, numNewLabels)
 		for i := range newLabels {
 			misses[i] = ids[i]
-			newLabels[i] = labels.Label{Name: keyStrArr[i], Value: valStrArr[i]}
+			newLabels[i] = intern.Label(keyStrArr[i], valStrArr[i])
 			sizes[i] = uint64(8 + int(unsafe.Sizeof(labels.Label{})) + len(keyStrArr[i]) + len(valStrArr[i])) // #nosec
 		}
 
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/stretchr/testify/require"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/tenancy"
 )
@@ -221,3 +222,46 @@ func TestLabelsReaderLabelsValues(t *testing.T) {
 		})
 	}
 }
+
+func TestLabelsReaderSearchLabelValues(t *testing.T) {
+	mock := model.NewSqlRecorder([]model.SqlQuery{
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+		{
+			Sql:     searchLabelValuesSQL,
+			Args:    []interface{}{"job", "api", "api" + maxRune, 2},
+			Results: model.RowResults{{"api-2"}, {"api-1"}},
+		},
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+		{
+			Sql:     searchAllLabelValuesSQL,
+			Args:    []interface{}{"job", nil},
+			Results: model.RowResults{{"web"}, {"api-1"}},
+		},
+		// Once all the values are cached, they are searched in memory.
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+		{Sql: getLabelValuesSQL, Args: []interface{}{"job"}, Results: model.RowResults{{"web"}, {"api-1"}, {"api-2"}}},
+		{Sql: getMaxLabelIDSQL, Results: model.RowResults{{int64(2)}}},
+	}, t)
+	reader := labelsReader{conn: mock, catalog: newLabelsCatalog()}
+
+	res, err := reader.SearchLabelValues("job", "api", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"api-1", "api-2"}, res)
+	res, err = reader.SearchLabelValues("job", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"api-1", "web"}, res)
+
+	_, err = reader.LabelValues("job")
+	require.NoError(t, err)
+	res, err = reader.SearchLabelValues("job", "api-", 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"api-1"}, res)
+}
+
+func TestSearchSorted(t *testing.T) {
+	values := []string{"a", "ab", "abc", "b", "ba"}
+	require.Equal(t, []string{"ab", "abc"}, SearchSorted(values, "ab", 0))
+	require.Equal(t, []string{"a"}, SearchSorted(values, "a", 1))
+	require.Equal(t, values, SearchSorted(values, "", 0))
+	require.Equal(t, []string{}, SearchSorted(values, "c", 0))
+}
@@ -0,0 +1,73 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package lreader
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+type shardedLabelsReader struct {
+	shards []LabelsReader
+}
+
+// NewShardedLabelsReader returns a labels reader merging the label names and
+// values of the databases the metrics are sharded across.
+func NewShardedLabelsReader(shards []LabelsReader) LabelsReader {
+	return &shardedLabelsReader{shards: shards}
+}
+
+func (r *shardedLabelsReader) LabelNames() ([]string, error) {
+	return r.union(LabelsReader.LabelNames)
+}
+
+func (r *shardedLabelsReader) LabelValues(labelName string) ([]string, error) {
+	return r.union(func(lr LabelsReader) ([]string, error) {
+		return lr.LabelValues(labelName)
+	})
+}
+
+func (r *shardedLabelsReader) SearchLabelValues(labelName, prefix string, limit int) ([]string, error) {
+	values, err := r.union(func(lr LabelsReader) ([]string, error) {
+		return lr.SearchLabelValues(labelName, prefix, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Each shard returns its first values, the first values of the union
+	// are among them.
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	return values, nil
+}
+
+// LabelsForIdMap is not supported as the label ids are specific to each
+// shard, the queriers of the shards use the labels reader of their shard.
+func (r *shardedLabelsReader) LabelsForIdMap(map[int64]labels.Label) error {
+	return fmt.Errorf("label ids are not unique across shards")
+}
+
+// union returns the sorted union of the strings of the shards.
+func (r *shardedLabelsReader) union(get func(LabelsReader) ([]string, error)) ([]string, error) {
+	set := make(map[string]struct{})
+	for _, lr := range r.shards {
+		values, err := get(lr)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			set[v] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res, nil
+}
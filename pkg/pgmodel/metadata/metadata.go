@@ -7,21 +7,33 @@ package metadata
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/timescale/promscale/pkg/pgmodel/model"
 	"github.com/timescale/promscale/pkg/pgxconn"
 )
 
+const (
+	metricMetadataSQL = "SELECT * from prom_api.get_metric_metadata($1)"
+	allMetadataSQL    = "SELECT metric_family, type, unit, help from _prom_catalog.metadata ORDER BY metric_family, last_seen DESC"
+
+	unknownType = "unknown"
+)
+
 // MetricQuery returns metadata corresponding to metric or metric_family.
-func MetricQuery(ctx context.Context, conn pgxconn.PgxConn, metric string, limit int) (map[string][]model.Metadata, error) {
+// The metadata sent by different writers for the same metric family is
+// merged, see merge. limit caps the number of metric families and
+// limitPerMetric the number of metadata entries of each family, 0 meaning
+// no limit.
+func MetricQuery(ctx context.Context, conn pgxconn.PgxConn, metric string, limit, limitPerMetric int) (map[string][]model.Metadata, error) {
 	var (
 		rows pgxconn.PgxRows
 		err  error
 	)
 	if metric != "" {
-		rows, err = conn.Query(ctx, "SELECT * from prom_api.get_metric_metadata($1)", metric)
+		rows, err = conn.Query(ctx, metricMetadataSQL, metric)
 	} else {
-		rows, err = conn.Query(ctx, "SELECT metric_family, type, unit, help from _prom_catalog.metadata ORDER BY metric_family, last_seen DESC")
+		rows, err = conn.Query(ctx, allMetadataSQL)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("query metric metadata: %w", err)
@@ -29,19 +41,77 @@ func MetricQuery(ctx context.Context, conn pgxconn.PgxConn, metric string, limit
 	defer rows.Close()
 	metricFamilies := make(map[string][]model.Metadata)
 	for rows.Next() {
-		if limit != 0 && len(metricFamilies) >= limit {
-			// Limit is applied on number of metric_families and not on number of metadata.
-			break
-		}
 		var metricFamily, typ, unit, help string
 		if err := rows.Scan(&metricFamily, &typ, &unit, &help); err != nil {
 			return nil, fmt.Errorf("query result: %w", err)
 		}
-		metricFamilies[metricFamily] = append(metricFamilies[metricFamily], model.Metadata{
+		entries, found := metricFamilies[metricFamily]
+		if !found && limit != 0 && len(metricFamilies) >= limit {
+			// Limit is applied on number of metric_families and not on number of metadata.
+			break
+		}
+		metricFamilies[metricFamily] = append(entries, model.Metadata{
 			Unit: unit,
-			Type: typ,
+			// The types are stored as the names of the remote write enum,
+			// the Prometheus API returns them in lower case.
+			Type: strings.ToLower(typ),
 			Help: help,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query result: %w", err)
+	}
+	for metricFamily, entries := range metricFamilies {
+		entries = merge(entries)
+		if limitPerMetric != 0 && len(entries) > limitPerMetric {
+			entries = entries[:limitPerMetric]
+		}
+		metricFamilies[metricFamily] = entries
+	}
 	return metricFamilies, nil
 }
+
+// merge resolves the conflicts between the metadata of a metric family sent
+// by different writers. The entries are ordered from the most recently seen.
+// An entry that only lacks fields of a more recent entry, e.g. because its
+// writer does not send units, is merged into it. Entries that disagree on
+// a field are all kept, most recent first.
+func merge(entries []model.Metadata) []model.Metadata {
+	merged := entries[:0]
+	for _, e := range entries {
+		i := 0
+		for ; i < len(merged); i++ {
+			if compatible(merged[i], e) {
+				break
+			}
+		}
+		if i == len(merged) {
+			merged = append(merged, e)
+			continue
+		}
+		m := &merged[i]
+		if m.Type == "" || m.Type == unknownType {
+			m.Type = e.Type
+		}
+		if m.Unit == "" {
+			m.Unit = e.Unit
+		}
+		if m.Help == "" {
+			m.Help = e.Help
+		}
+	}
+	return merged
+}
+
+// compatible returns whether the fields of a and b are either equal or
+// missing in one of them.
+func compatible(a, b model.Metadata) bool {
+	sameType := a.Type == b.Type ||
+		a.Type == "" || a.Type == unknownType ||
+		b.Type == "" || b.Type == unknownType
+	return sameType && compatibleField(a.Unit, b.Unit) && compatibleField(a.Help, b.Help)
+}
+
+func compatibleField(a, b string) bool {
+	return a == b || a == "" || b == ""
+}
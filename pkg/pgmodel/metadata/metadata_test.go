@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package metadata
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestMetricQuery(t *testing.T) {
+	rows := model.RowResults{
+		{"http_requests_total", "COUNTER", "", "Total requests."},
+		{"http_requests_total", "COUNTER", "requests", ""},
+		{"http_requests_total", "GAUGE", "", "Requests in flight."},
+		{"http_requests_total", "UNKNOWN", "", "Total requests."},
+		{"up", "GAUGE", "", "Whether the target is up."},
+	}
+
+	testCases := []struct {
+		name           string
+		metric         string
+		limit          int
+		limitPerMetric int
+		query          model.SqlQuery
+		expected       map[string][]model.Metadata
+	}{
+		{
+			name:  "all metrics",
+			query: model.SqlQuery{Sql: allMetadataSQL, Results: rows},
+			expected: map[string][]model.Metadata{
+				"http_requests_total": {
+					{Type: "counter", Unit: "requests", Help: "Total requests."},
+					{Type: "gauge", Help: "Requests in flight."},
+				},
+				"up": {
+					{Type: "gauge", Help: "Whether the target is up."},
+				},
+			},
+		},
+		{
+			name:   "single metric",
+			metric: "up",
+			query:  model.SqlQuery{Sql: metricMetadataSQL, Args: []interface{}{"up"}, Results: rows[4:]},
+			expected: map[string][]model.Metadata{
+				"up": {
+					{Type: "gauge", Help: "Whether the target is up."},
+				},
+			},
+		},
+		{
+			name:  "limit",
+			limit: 1,
+			query: model.SqlQuery{Sql: allMetadataSQL, Results: rows},
+			expected: map[string][]model.Metadata{
+				"http_requests_total": {
+					{Type: "counter", Unit: "requests", Help: "Total requests."},
+					{Type: "gauge", Help: "Requests in flight."},
+				},
+			},
+		},
+		{
+			name:           "limit per metric",
+			limitPerMetric: 1,
+			query:          model.SqlQuery{Sql: allMetadataSQL, Results: rows},
+			expected: map[string][]model.Metadata{
+				"http_requests_total": {
+					{Type: "counter", Unit: "requests", Help: "Total requests."},
+				},
+				"up": {
+					{Type: "gauge", Help: "Whether the target is up."},
+				},
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder([]model.SqlQuery{c.query}, t)
+			result, err := MetricQuery(context.Background(), conn, c.metric, c.limit, c.limitPerMetric)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, result)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package database
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultStorageUsageInterval = 15 * time.Minute
+	defaultStorageUsageTopK     = 20
+)
+
+// Config holds the flags of the database metrics.
+type Config struct {
+	// StorageUsageInterval is how often the storage usage gauges are
+	// updated. They are disabled if 0.
+	StorageUsageInterval time.Duration
+	// StorageUsageTopK is the number of metrics, and of tenants, with their
+	// own series in the storage usage gauges. The others are summed up.
+	StorageUsageTopK int
+	// StorageUsageTenantLabel is the label the storage usage is also
+	// reported by. Not reported by tenant if empty.
+	StorageUsageTenantLabel string
+}
+
+// ParseFlags registers the database metrics flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.DurationVar(&cfg.StorageUsageInterval, "metrics.storage-usage.interval", defaultStorageUsageInterval, "How often the promscale_sql_database_metric_*_bytes "+
+		"and promscale_sql_database_tenant_*_bytes gauges of the storage used by each metric and tenant are updated. Disabled if 0.")
+	fs.IntVar(&cfg.StorageUsageTopK, "metrics.storage-usage.top-k", defaultStorageUsageTopK, "Number of the largest metrics, and of the largest tenants, "+
+		"with their own series in the storage usage gauges. The storage of the others is summed up in the series of the __other__ metric or tenant.")
+	fs.StringVar(&cfg.StorageUsageTenantLabel, "metrics.storage-usage.tenant-label", "", "Label the storage usage is also reported by, e.g. __tenant__ or team. "+
+		"The storage of each metric is split between the values of the label by their share of the series of the metric. Not reported by tenant if empty.")
+	return cfg
+}
+
+// Validate checks the database metrics flags.
+func Validate(cfg *Config) error {
+	if cfg.StorageUsageInterval < 0 {
+		return fmt.Errorf("metrics.storage-usage.interval must not be negative, got %s", cfg.StorageUsageInterval)
+	}
+	if cfg.StorageUsageTopK < 1 {
+		return fmt.Errorf("metrics.storage-usage.top-k must be positive, got %d", cfg.StorageUsageTopK)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -23,6 +24,10 @@ type metricsEngineImpl struct {
 	ctx       context.Context
 	isRunning atomic.Value
 	metrics   []metricQueryWrap
+	cfg       Config
+	// storageUsageUpdated is when the storage usage gauges were last
+	// updated. They are updated less often than the other metrics.
+	storageUsageUpdated time.Time
 }
 
 // NewEngine creates an engine that performs database metrics evaluation every evalInterval.
@@ -31,11 +36,12 @@ type metricsEngineImpl struct {
 //
 // Note: Make sure to call this only when the database is TimescaleDB. Plain Postgres
 // will cause evaluation errors.
-func NewEngine(ctx context.Context, conn pgxconn.PgxConn) *metricsEngineImpl {
+func NewEngine(ctx context.Context, conn pgxconn.PgxConn, cfg Config) *metricsEngineImpl {
 	engine := &metricsEngineImpl{
 		conn:    conn,
 		ctx:     ctx,
 		metrics: metrics,
+		cfg:     cfg,
 	}
 	engine.isRunning.Store(false)
 	return engine
@@ -43,6 +49,10 @@ func NewEngine(ctx context.Context, conn pgxconn.PgxConn) *metricsEngineImpl {
 
 func (e *metricsEngineImpl) register() {
 	prometheus.MustRegister(getMetrics(e.metrics)...)
+	prometheus.MustRegister(maintenanceJobDuration, maintenanceJobScheduled)
+	if e.cfg.StorageUsageInterval > 0 {
+		prometheus.MustRegister(storageUsageGauges...)
+	}
 }
 
 func (e *metricsEngineImpl) unregister() {
@@ -50,6 +60,11 @@ func (e *metricsEngineImpl) unregister() {
 	for i := range m {
 		prometheus.Unregister(m[i])
 	}
+	prometheus.Unregister(maintenanceJobDuration)
+	prometheus.Unregister(maintenanceJobScheduled)
+	for _, g := range storageUsageGauges {
+		prometheus.Unregister(g)
+	}
 }
 
 func getMetrics(m []metricQueryWrap) []prometheus.Collector {
@@ -136,7 +151,62 @@ func (e *metricsEngineImpl) Update() error {
 		return err
 	}
 	handleResults(results, batchMetrics)
-	return results.Close()
+	if err = results.Close(); err != nil {
+		return err
+	}
+	if err = e.updateMaintenanceJobs(batchCtx); err != nil {
+		return err
+	}
+	return e.updateStorageUsage(batchCtx)
+}
+
+// updateStorageUsage sets the storage usage gauges of the top-K metrics and
+// tenants, if they were last updated more than the storage usage interval
+// ago.
+func (e *metricsEngineImpl) updateStorageUsage(ctx context.Context) error {
+	if e.cfg.StorageUsageInterval <= 0 || time.Since(e.storageUsageUpdated) < e.cfg.StorageUsageInterval {
+		return nil
+	}
+	usage, err := LoadStorageUsage(ctx, e.conn, e.cfg.StorageUsageTenantLabel)
+	if err != nil {
+		log.Warn("msg", "error evaluating the storage usage metrics", "err", err.Error())
+		return err
+	}
+	setStorageUsageGauges(usage, e.cfg.StorageUsageTopK)
+	e.storageUsageUpdated = time.Now()
+	return nil
+}
+
+// updateMaintenanceJobs sets the metrics of each maintenance job. The jobs
+// that no longer exist are removed.
+func (e *metricsEngineImpl) updateMaintenanceJobs(ctx context.Context) error {
+	rows, err := e.conn.Query(ctx, maintenanceJobsQuery)
+	if err != nil {
+		log.Warn("msg", "error evaluating the maintenance job metrics", "err", err.Error())
+		return err
+	}
+	defer rows.Close()
+	maintenanceJobDuration.Reset()
+	maintenanceJobScheduled.Reset()
+	for rows.Next() {
+		var (
+			id        int32
+			scheduled bool
+			duration  float64
+		)
+		if err = rows.Scan(&id, &scheduled, &duration); err != nil {
+			log.Warn("msg", "error evaluating the maintenance job metrics", "err", err.Error())
+			return err
+		}
+		jobID := strconv.Itoa(int(id))
+		maintenanceJobDuration.WithLabelValues(jobID).Set(duration)
+		if scheduled {
+			maintenanceJobScheduled.WithLabelValues(jobID).Set(1)
+		} else {
+			maintenanceJobScheduled.WithLabelValues(jobID).Set(0)
+		}
+	}
+	return rows.Err()
 }
 
 func healthCheck(conn pgxconn.PgxConn, healthMetric metricQueryWrap) {
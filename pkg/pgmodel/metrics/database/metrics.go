@@ -33,8 +33,32 @@ var (
 			ConstLabels: map[string]string{"type": "promscale_sql"},
 		},
 	)
+	// The maintenance job metrics have a series per job, so they are
+	// updated by their own query instead of a metricQueryWrap.
+	maintenanceJobDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "sql_database",
+			Name:      "worker_maintenance_job_last_run_duration_seconds",
+			Help:      "Duration of the last run of each Promscale maintenance job.",
+		}, []string{"job_id"},
+	)
+	maintenanceJobScheduled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "sql_database",
+			Name:      "worker_maintenance_job_scheduled",
+			Help:      "Whether each Promscale maintenance job is scheduled (1) or paused (0).",
+		}, []string{"job_id"},
+	)
 )
 
+const maintenanceJobsQuery = `select jobs.job_id, jobs.scheduled, coalesce(extract(epoch from stats.last_run_duration), 0)::float8
+	from timescaledb_information.jobs jobs
+	left join timescaledb_information.job_stats stats
+		on jobs.job_id = stats.job_id
+	where jobs.proc_name = 'execute_maintenance_job'`
+
 func init() {
 	prometheus.MustRegister(dbHealthErrors, upMetric, dbNetworkLatency)
 }
@@ -0,0 +1,246 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// otherLabelValue is the metric or tenant the storage outside of the top-K is
+// reported under.
+const otherLabelValue = "__other__"
+
+// The storage usage metrics have a series per metric and per tenant, so they
+// are updated by their own queries instead of a metricQueryWrap.
+var (
+	metricStorageBytes           = storageUsageGauge("metric_storage_bytes", "Size on disk of the samples of each metric, compressed or not.", "metric")
+	metricBeforeCompressionBytes = storageUsageGauge("metric_before_compression_bytes", "Size of the compressed chunks of each metric before their compression.", "metric")
+	metricAfterCompressionBytes  = storageUsageGauge("metric_after_compression_bytes", "Size of the compressed chunks of each metric after their compression.", "metric")
+	tenantStorageBytes           = storageUsageGauge("tenant_storage_bytes", "Estimated size on disk of the samples of each tenant, compressed or not.", "tenant")
+	tenantBeforeCompressionBytes = storageUsageGauge("tenant_before_compression_bytes", "Estimated size of the compressed chunks of each tenant before their compression.", "tenant")
+	tenantAfterCompressionBytes  = storageUsageGauge("tenant_after_compression_bytes", "Estimated size of the compressed chunks of each tenant after their compression.", "tenant")
+
+	storageUsageGauges = []prometheus.Collector{
+		metricStorageBytes, metricBeforeCompressionBytes, metricAfterCompressionBytes,
+		tenantStorageBytes, tenantBeforeCompressionBytes, tenantAfterCompressionBytes,
+	}
+)
+
+func storageUsageGauge(name, help, label string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "sql_database",
+			Name:      name,
+			Help:      help,
+		}, []string{label},
+	)
+}
+
+// The size columns are NULL when TimescaleDB is not installed or the metric
+// has no compressed chunk.
+const metricStorageUsageSQL = `SELECT m.metric_name,
+	coalesce(m.total_size_bytes, 0),
+	coalesce(m.before_compression_bytes, 0),
+	coalesce(m.after_compression_bytes, 0)
+FROM prom_info.metric m`
+
+// The position of a label key in the label arrays of the series is fixed per
+// metric, so the value of the tenant label of a series is a lookup instead of
+// a search of its labels. The series without the label have an empty tenant.
+const tenantSeriesSQL = `SELECT m.metric_name, coalesce(l.value, ''), count(*)
+FROM _prom_catalog.metric m
+INNER JOIN _prom_catalog.series s ON (s.metric_id = m.id AND s.delete_epoch IS NULL)
+LEFT JOIN _prom_catalog.label_key_position lkp ON (lkp.metric_name = m.metric_name AND lkp.key = $1)
+LEFT JOIN _prom_catalog.label l ON (l.id = s.labels[lkp.pos])
+GROUP BY 1, 2`
+
+// StorageUsage is the storage used by each metric and, if a tenant label is
+// set, by each value of the label. Both are sorted by decreasing size.
+type StorageUsage struct {
+	Metrics     []MetricStorageUsage `json:"metrics"`
+	TenantLabel string               `json:"tenantLabel,omitempty"`
+	Tenants     []TenantStorageUsage `json:"tenants,omitempty"`
+}
+
+// StorageBytes are the sizes of the samples of a metric or a tenant. The
+// sizes before and after compression are those of the compressed chunks.
+type StorageBytes struct {
+	TotalBytes             int64 `json:"totalBytes"`
+	BeforeCompressionBytes int64 `json:"beforeCompressionBytes"`
+	AfterCompressionBytes  int64 `json:"afterCompressionBytes"`
+}
+
+func (b *StorageBytes) add(o StorageBytes) {
+	b.TotalBytes += o.TotalBytes
+	b.BeforeCompressionBytes += o.BeforeCompressionBytes
+	b.AfterCompressionBytes += o.AfterCompressionBytes
+}
+
+// MetricStorageUsage is the storage used by a metric.
+type MetricStorageUsage struct {
+	Metric string `json:"metric"`
+	StorageBytes
+}
+
+// TenantStorageUsage is the storage used by a value of the tenant label. The
+// tables are not split by tenant, so the storage of each metric is split
+// between its tenants by their share of its series.
+type TenantStorageUsage struct {
+	Tenant string `json:"tenant"`
+	Series int64  `json:"series"`
+	StorageBytes
+}
+
+// tenantSeries is the number of series of a metric with a tenant.
+type tenantSeries struct {
+	metric string
+	tenant string
+	series int64
+}
+
+// LoadStorageUsage reads the storage used by each metric and, if tenantLabel
+// is not empty, estimates the storage used by each value of the label.
+func LoadStorageUsage(ctx context.Context, conn pgxconn.PgxConn, tenantLabel string) (*StorageUsage, error) {
+	rows, err := conn.Query(ctx, metricStorageUsageSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the storage usage of the metrics: %w", err)
+	}
+	defer rows.Close()
+	var metrics []MetricStorageUsage
+	for rows.Next() {
+		var m MetricStorageUsage
+		if err = rows.Scan(&m.Metric, &m.TotalBytes, &m.BeforeCompressionBytes, &m.AfterCompressionBytes); err != nil {
+			return nil, fmt.Errorf("error reading the storage usage of the metrics: %w", err)
+		}
+		metrics = append(metrics, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the storage usage of the metrics: %w", err)
+	}
+	if tenantLabel == "" {
+		return newStorageUsage(metrics, "", nil), nil
+	}
+
+	rows, err = conn.Query(ctx, tenantSeriesSQL, tenantLabel)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the series of the tenants: %w", err)
+	}
+	defer rows.Close()
+	var series []tenantSeries
+	for rows.Next() {
+		var s tenantSeries
+		if err = rows.Scan(&s.metric, &s.tenant, &s.series); err != nil {
+			return nil, fmt.Errorf("error reading the series of the tenants: %w", err)
+		}
+		series = append(series, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the series of the tenants: %w", err)
+	}
+	return newStorageUsage(metrics, tenantLabel, series), nil
+}
+
+// newStorageUsage splits the storage of each metric between its tenants by
+// their share of its series.
+func newStorageUsage(metrics []MetricStorageUsage, tenantLabel string, series []tenantSeries) *StorageUsage {
+	usage := &StorageUsage{Metrics: metrics, TenantLabel: tenantLabel}
+	sort.Slice(usage.Metrics, func(i, j int) bool {
+		if usage.Metrics[i].TotalBytes != usage.Metrics[j].TotalBytes {
+			return usage.Metrics[i].TotalBytes > usage.Metrics[j].TotalBytes
+		}
+		return usage.Metrics[i].Metric < usage.Metrics[j].Metric
+	})
+	if tenantLabel == "" {
+		return usage
+	}
+
+	bytes := make(map[string]StorageBytes, len(metrics))
+	for _, m := range metrics {
+		bytes[m.Metric] = m.StorageBytes
+	}
+	metricSeries := make(map[string]int64)
+	for _, s := range series {
+		metricSeries[s.metric] += s.series
+	}
+	tenants := make(map[string]*TenantStorageUsage)
+	for _, s := range series {
+		t, ok := tenants[s.tenant]
+		if !ok {
+			t = &TenantStorageUsage{Tenant: s.tenant}
+			tenants[s.tenant] = t
+		}
+		t.Series += s.series
+		share := float64(s.series) / float64(metricSeries[s.metric])
+		b := bytes[s.metric]
+		t.add(StorageBytes{
+			TotalBytes:             int64(float64(b.TotalBytes) * share),
+			BeforeCompressionBytes: int64(float64(b.BeforeCompressionBytes) * share),
+			AfterCompressionBytes:  int64(float64(b.AfterCompressionBytes) * share),
+		})
+	}
+	usage.Tenants = make([]TenantStorageUsage, 0, len(tenants))
+	for _, t := range tenants {
+		usage.Tenants = append(usage.Tenants, *t)
+	}
+	sort.Slice(usage.Tenants, func(i, j int) bool {
+		if usage.Tenants[i].TotalBytes != usage.Tenants[j].TotalBytes {
+			return usage.Tenants[i].TotalBytes > usage.Tenants[j].TotalBytes
+		}
+		return usage.Tenants[i].Tenant < usage.Tenants[j].Tenant
+	})
+	return usage
+}
+
+// topK returns the k first entries of the sorted storage usage, followed by
+// the sum of the others under otherLabelValue.
+func topK(names []string, bytes []StorageBytes, k int) map[string]StorageBytes {
+	top := make(map[string]StorageBytes, k+1)
+	for i, name := range names {
+		if i < k {
+			top[name] = bytes[i]
+			continue
+		}
+		other := top[otherLabelValue]
+		other.add(bytes[i])
+		top[otherLabelValue] = other
+	}
+	return top
+}
+
+func setStorageUsageGauges(usage *StorageUsage, k int) {
+	names := make([]string, len(usage.Metrics))
+	bytes := make([]StorageBytes, len(usage.Metrics))
+	for i, m := range usage.Metrics {
+		names[i], bytes[i] = m.Metric, m.StorageBytes
+	}
+	setStorageBytes(topK(names, bytes, k), metricStorageBytes, metricBeforeCompressionBytes, metricAfterCompressionBytes)
+
+	names = make([]string, len(usage.Tenants))
+	bytes = make([]StorageBytes, len(usage.Tenants))
+	for i, t := range usage.Tenants {
+		names[i], bytes[i] = t.Tenant, t.StorageBytes
+	}
+	setStorageBytes(topK(names, bytes, k), tenantStorageBytes, tenantBeforeCompressionBytes, tenantAfterCompressionBytes)
+}
+
+// setStorageBytes replaces the series of the gauges, so that the metrics and
+// tenants no longer in the top-K are removed.
+func setStorageBytes(top map[string]StorageBytes, total, before, after *prometheus.GaugeVec) {
+	total.Reset()
+	before.Reset()
+	after.Reset()
+	for name, b := range top {
+		total.WithLabelValues(name).Set(float64(b.TotalBytes))
+		before.WithLabelValues(name).Set(float64(b.BeforeCompressionBytes))
+		after.WithLabelValues(name).Set(float64(b.AfterCompressionBytes))
+	}
+}
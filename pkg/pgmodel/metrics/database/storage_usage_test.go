@@ -0,0 +1,64 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package database
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageUsage(t *testing.T) {
+	metrics := []MetricStorageUsage{
+		{Metric: "small", StorageBytes: StorageBytes{TotalBytes: 100}},
+		{Metric: "large", StorageBytes: StorageBytes{TotalBytes: 1000, BeforeCompressionBytes: 4000, AfterCompressionBytes: 400}},
+		{Metric: "medium", StorageBytes: StorageBytes{TotalBytes: 500}},
+	}
+	series := []tenantSeries{
+		{metric: "large", tenant: "team-a", series: 3},
+		{metric: "large", tenant: "team-b", series: 1},
+		{metric: "medium", tenant: "team-b", series: 5},
+		{metric: "small", tenant: "", series: 2},
+	}
+	usage := newStorageUsage(metrics, "team", series)
+
+	require.Equal(t, []MetricStorageUsage{
+		{Metric: "large", StorageBytes: StorageBytes{TotalBytes: 1000, BeforeCompressionBytes: 4000, AfterCompressionBytes: 400}},
+		{Metric: "medium", StorageBytes: StorageBytes{TotalBytes: 500}},
+		{Metric: "small", StorageBytes: StorageBytes{TotalBytes: 100}},
+	}, usage.Metrics)
+	// The storage of a metric is split by the share of its series.
+	require.Equal(t, []TenantStorageUsage{
+		{Tenant: "team-a", Series: 3, StorageBytes: StorageBytes{TotalBytes: 750, BeforeCompressionBytes: 3000, AfterCompressionBytes: 300}},
+		{Tenant: "team-b", Series: 6, StorageBytes: StorageBytes{TotalBytes: 750, BeforeCompressionBytes: 1000, AfterCompressionBytes: 100}},
+		{Tenant: "", Series: 2, StorageBytes: StorageBytes{TotalBytes: 100}},
+	}, usage.Tenants)
+
+	// Without tenant label, only the metrics are reported.
+	require.Empty(t, newStorageUsage(metrics, "", nil).Tenants)
+}
+
+func TestStorageUsageGauges(t *testing.T) {
+	usage := newStorageUsage([]MetricStorageUsage{
+		{Metric: "a", StorageBytes: StorageBytes{TotalBytes: 300, AfterCompressionBytes: 30}},
+		{Metric: "b", StorageBytes: StorageBytes{TotalBytes: 200, AfterCompressionBytes: 20}},
+		{Metric: "c", StorageBytes: StorageBytes{TotalBytes: 100, AfterCompressionBytes: 10}},
+		{Metric: "d", StorageBytes: StorageBytes{TotalBytes: 50, AfterCompressionBytes: 5}},
+	}, "", nil)
+
+	setStorageUsageGauges(usage, 2)
+	require.Equal(t, 3, testutil.CollectAndCount(metricStorageBytes))
+	require.Equal(t, 300.0, testutil.ToFloat64(metricStorageBytes.WithLabelValues("a")))
+	require.Equal(t, 200.0, testutil.ToFloat64(metricStorageBytes.WithLabelValues("b")))
+	require.Equal(t, 150.0, testutil.ToFloat64(metricStorageBytes.WithLabelValues(otherLabelValue)))
+	require.Equal(t, 15.0, testutil.ToFloat64(metricAfterCompressionBytes.WithLabelValues(otherLabelValue)))
+	require.Equal(t, 0, testutil.CollectAndCount(tenantStorageBytes))
+
+	// The metrics no longer in the top-K are removed.
+	setStorageUsageGauges(usage, 10)
+	require.Equal(t, 4, testutil.CollectAndCount(metricStorageBytes))
+	require.Equal(t, 50.0, testutil.ToFloat64(metricStorageBytes.WithLabelValues("d")))
+}
@@ -11,19 +11,35 @@ var (
 			Namespace: util.PromNamespace,
 			Subsystem: "ha",
 			Name:      "cluster_leader_info",
-			Help:      "Info on HA clusters and respective leaders.",
+			Help:      "Info on HA clusters and respective leaders. Clusters that are not sent by a tenant have an empty tenant label.",
 		},
-		[]string{"cluster", "replica"})
+		[]string{"tenant", "cluster", "replica"})
 	NumOfHAClusterLeaderChanges = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: util.PromNamespace,
 			Subsystem: "ha",
 			Name:      "cluster_leader_changes_total",
-			Help:      "Total number of times leader changed per cluster.",
+			Help:      "Total number of times leader changed per cluster and tenant.",
 		},
-		[]string{"cluster"})
+		[]string{"tenant", "cluster"})
+	HASamplesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ha",
+			Name:      "samples_received_total",
+			Help:      "Total number of samples received from HA replicas, per tenant, cluster and replica.",
+		},
+		[]string{"tenant", "cluster", "replica"})
+	HASamplesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ha",
+			Name:      "samples_dropped_total",
+			Help:      "Total number of samples dropped because they were sent by a non-leader replica or outside of its lease, per tenant, cluster and replica.",
+		},
+		[]string{"tenant", "cluster", "replica"})
 )
 
 func init() {
-	prometheus.MustRegister(HAClusterLeaderDetails, NumOfHAClusterLeaderChanges)
+	prometheus.MustRegister(HAClusterLeaderDetails, NumOfHAClusterLeaderChanges, HASamplesReceived, HASamplesDropped)
 }
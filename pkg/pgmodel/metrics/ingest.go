@@ -199,6 +199,30 @@ var (
 			Help:      "Number of active user requests in queue.",
 		}, []string{"type", "queue_idx"},
 	)
+	IngestorCacheWarmUpSeries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest",
+			Name:      "cache_warm_up_series",
+			Help:      "Number of series loaded into the series cache by the startup warm-up.",
+		},
+	)
+	IngestorCacheWarmUpMetrics = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest",
+			Name:      "cache_warm_up_metrics",
+			Help:      "Number of metrics loaded into the metric name cache by the startup warm-up.",
+		},
+	)
+	IngestorCacheWarmUpDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "ingest",
+			Name:      "cache_warm_up_duration_seconds",
+			Help:      "Duration of the startup warm-up of the series cache.",
+		},
+	)
 )
 
 func init() {
@@ -225,6 +249,9 @@ func init() {
 		IngestorBatchFlushTotal,
 		IngestorPendingBatches,
 		IngestorRequestsQueued,
+		IngestorCacheWarmUpSeries,
+		IngestorCacheWarmUpMetrics,
+		IngestorCacheWarmUpDuration,
 	)
 }
 
@@ -23,11 +23,48 @@ var (
 			Help:      "Number of query requests to Promscale.",
 		}, []string{"type", "handler", "code"},
 	)
+	QuerySpillovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query",
+			Name:      "spillovers_total",
+			Help:      "Number of range queries that timed out and were retried against rollups, by result.",
+		}, []string{"result"},
+	)
+	QueryLimitsExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query",
+			Name:      "limits_exceeded_total",
+			Help:      "Number of queries aborted because they exceeded one of the query resource limits, by limit.",
+		}, []string{"limit"},
+	)
+	QueryMemoryInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query",
+			Name:      "memory_in_flight_bytes",
+			Help:      "Approximate memory used by the queries in flight, the decoded samples and the intermediate results of the PromQL engine.",
+		},
+	)
+	QueryMemoryPeak = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query",
+			Name:      "memory_peak_bytes",
+			Help:      "Approximate peak memory used by a query.",
+			Buckets:   prometheus.ExponentialBuckets(1<<16, 4, 10),
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(
 		Query,
 		QueryDuration,
+		QuerySpillovers,
+		QueryLimitsExceeded,
+		QueryMemoryInFlight,
+		QueryMemoryPeak,
 	)
 }
@@ -0,0 +1,62 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var (
+	TraceSamplingDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "tail_sampling_traces_total",
+			Help:      "Total number of traces decided by tail sampling, by decision (sampled or dropped).",
+		},
+		[]string{"decision"})
+	TraceSamplingSpans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "tail_sampling_spans_total",
+			Help:      "Total number of spans decided by tail sampling, by decision (sampled or dropped). Late spans are included.",
+		},
+		[]string{"decision"})
+	TraceSamplingPolicyMatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "tail_sampling_policy_matches_total",
+			Help:      "Total number of traces matched by each tail sampling policy. A trace can match several policies.",
+		},
+		[]string{"policy"})
+	TraceSamplingLateSpans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "tail_sampling_late_spans_total",
+			Help:      "Total number of spans received after the decision on their trace, by decision (sampled or dropped).",
+		},
+		[]string{"decision"})
+	TraceSamplingBufferedTraces = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace",
+			Name:      "tail_sampling_buffered_traces",
+			Help:      "Number of traces buffered by tail sampling and waiting for a decision.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(
+		TraceSamplingDecisions,
+		TraceSamplingSpans,
+		TraceSamplingPolicyMatches,
+		TraceSamplingLateSpans,
+		TraceSamplingBufferedTraces,
+	)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/webhook"
 )
 
 const (
@@ -46,6 +47,8 @@ var (
 			"tracing-views.sql",
 			"telemetry.sql",
 			"maintenance.sql",
+			"value-encodings.sql",
+			"tenant-partitioning.sql",
 			"remote-commands.sql",   // should be just above apply_permissions.sql
 			"apply_permissions.sql", //	should be last
 		},
@@ -98,6 +101,18 @@ func NewMigrator(db *pgx.Conn, sqlFiles http.FileSystem, toc map[string][]string
 }
 
 func (t *Migrator) Migrate(appVersion semver.Version) error {
+	return t.migrate(appVersion, true)
+}
+
+// MigrateUntil applies the version migration files up to and including the
+// target version, for staged upgrades, and sets the schema version to target.
+// The idempotent files are only applied by Migrate, once the schema reaches
+// the application version.
+func (t *Migrator) MigrateUntil(target semver.Version) error {
+	return t.migrate(target, false)
+}
+
+func (t *Migrator) migrate(appVersion semver.Version, idempotent bool) error {
 	if err := ensureVersionTable(t.db); err != nil {
 		return fmt.Errorf("error ensuring version table: %w", err)
 	}
@@ -106,39 +121,16 @@ func (t *Migrator) Migrate(appVersion semver.Version) error {
 	if err != nil {
 		return fmt.Errorf("failed to get the version from database: %w", err)
 	}
-
-	// If already at correct version, nothing to migrate on proper release.
-	// On dev versions, idempotent files need to be reapplied.
-	if dbVersion.Compare(appVersion) == 0 {
-		devRelease := false
-		for _, pre := range appVersion.Pre {
-			if pre.String() == "dev" {
-				devRelease = true
-			}
-		}
-
-		if devRelease {
-			tx, err := t.db.Begin(context.Background())
-			if err != nil {
-				return fmt.Errorf("unable to start transaction: %w", err)
-			}
-			defer func() {
-				_ = tx.Rollback(context.Background())
-			}()
-			if err = t.execMigrationDir(tx, idempotentScripts); err != nil {
-				return err
-			}
-			if err = tx.Commit(context.Background()); err != nil {
-				return fmt.Errorf("unable to commit migration transaction: %w", err)
-			}
-			return nil
-		}
-		return nil
+	if !idempotent && dbVersion.Compare(semver.Version{}) == 0 {
+		return fmt.Errorf("the schema is not installed, it can only be installed at the application version")
 	}
 
-	// Error if at a greater version.
-	if dbVersion.Compare(appVersion) > 0 {
-		return fmt.Errorf("schema version (%v) is above the application version (%v), cannot migrate", dbVersion, appVersion)
+	files, err := t.pendingFiles(dbVersion, appVersion, idempotent)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 && dbVersion.Compare(appVersion) == 0 {
+		return nil
 	}
 
 	tx, err := t.db.Begin(context.Background())
@@ -149,28 +141,85 @@ func (t *Migrator) Migrate(appVersion semver.Version) error {
 		_ = tx.Rollback(context.Background())
 	}()
 
-	// No version in DB.
-	if dbVersion.Compare(semver.Version{}) == 0 {
-		if err = t.execMigrationDir(tx, preinstallScripts); err != nil {
+	for _, fileName := range files {
+		if err = t.execMigrationFile(tx, fileName); err != nil {
 			return err
 		}
-	} else if err = t.upgradeVersion(tx, dbVersion, appVersion); err != nil {
-		return err
-	}
-	if err = t.execMigrationDir(tx, idempotentScripts); err != nil {
-		return err
 	}
-	if err = setDBVersion(tx, &appVersion); err != nil {
-		return fmt.Errorf("error setting clean app version to DB: %w", err)
+	// Reapplying the idempotent files on dev releases keeps the version.
+	if dbVersion.Compare(appVersion) != 0 {
+		if err = setDBVersion(tx, &appVersion); err != nil {
+			return fmt.Errorf("error setting clean app version to DB: %w", err)
+		}
 	}
 
 	if err = tx.Commit(context.Background()); err != nil {
 		return fmt.Errorf("unable to commit migration transaction: %w", err)
 	}
+	if dbVersion.Compare(appVersion) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("schema migrated from version %v to %v", dbVersion, appVersion)
+	if dbVersion.Compare(semver.Version{}) == 0 {
+		message = fmt.Sprintf("schema installed at version %v", appVersion)
+	}
+	webhook.Emit(webhook.Event{
+		Type:    webhook.MigrationApplied,
+		Subject: appVersion.String(),
+		Message: message,
+		Details: map[string]string{"from_version": dbVersion.String(), "to_version": appVersion.String()},
+	})
 
 	return nil
 }
 
+// pendingFiles returns the migration files, in order, taking the schema from
+// the from version to the to version, followed by the idempotent files if
+// idempotent is set.
+func (t *Migrator) pendingFiles(from, to semver.Version, idempotent bool) ([]string, error) {
+	// If already at correct version, nothing to migrate on proper release.
+	// On dev versions, idempotent files need to be reapplied.
+	if from.Compare(to) == 0 {
+		devRelease := false
+		for _, pre := range to.Pre {
+			if pre.String() == "dev" {
+				devRelease = true
+			}
+		}
+		if !devRelease || !idempotent {
+			return nil, nil
+		}
+		return t.migrationDirFiles(idempotentScripts)
+	}
+
+	// Error if at a greater version.
+	if from.Compare(to) > 0 {
+		return nil, fmt.Errorf("schema version (%v) is above the application version (%v), cannot migrate", from, to)
+	}
+
+	var (
+		files []string
+		err   error
+	)
+	// No version in DB.
+	if from.Compare(semver.Version{}) == 0 {
+		files, err = t.migrationDirFiles(preinstallScripts)
+	} else {
+		files, err = t.versionFiles(from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !idempotent {
+		return files, nil
+	}
+	idempotentFiles, err := t.migrationDirFiles(idempotentScripts)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, idempotentFiles...), nil
+}
+
 func ensureVersionTable(db *pgx.Conn) error {
 	_, err := db.Exec(context.Background(), createMigrationsTable)
 	if err != nil {
@@ -235,12 +284,12 @@ func (t *Migrator) execMigrationFile(tx pgx.Tx, fileName string) error {
 	return nil
 }
 
-// execMigrationDir finds all the migration files in a directory, orders them
-// (either by ToC or by their numerical prefix) and executes them in a transaction.
-func (t *Migrator) execMigrationDir(tx pgx.Tx, dirName string) error {
+// migrationDirFiles finds all the migration files in a directory and orders
+// them, either by ToC or by their numerical prefix.
+func (t *Migrator) migrationDirFiles(dirName string) ([]string, error) {
 	f, err := t.sqlFiles.Open(dirName)
 	if err != nil {
-		return fmt.Errorf("unable to get migration scripts: name %s, err %w", dirName, err)
+		return nil, fmt.Errorf("unable to get migration scripts: name %s, err %w", dirName, err)
 	}
 
 	var (
@@ -256,11 +305,11 @@ func (t *Migrator) execMigrationDir(tx pgx.Tx, dirName string) error {
 			fullName := filepath.Join(dirName, fileName)
 			file, err = t.sqlFiles.Open(fullName)
 			if err != nil {
-				return fmt.Errorf("unable to get migration script from toc: name %s, err %w", fullName, err)
+				return nil, fmt.Errorf("unable to get migration script from toc: name %s, err %w", fullName, err)
 			}
 
 			if stat, err = file.Stat(); err != nil {
-				return fmt.Errorf("unable to stat migration script from toc: name %s, err %w", fullName, err)
+				return nil, fmt.Errorf("unable to stat migration script from toc: name %s, err %w", fullName, err)
 			}
 
 			// Ignoring directories.
@@ -275,21 +324,17 @@ func (t *Migrator) execMigrationDir(tx pgx.Tx, dirName string) error {
 		// Otherwise, order the files by their numeric prefix, delimited by `-` (if one exists).
 		fileEntries, err := f.Readdir(-1)
 		if err != nil {
-			return fmt.Errorf("unable to read migration scripts directory: name %s, err %w", dirName, err)
+			return nil, fmt.Errorf("unable to read migration scripts directory: name %s, err %w", dirName, err)
 		}
 
 		entries = orderFilesNaturally(fileEntries)
 	}
 
-	for _, e := range entries {
-		fileName := filepath.Join(dirName, e)
-		err := t.execMigrationFile(tx, fileName)
-		if err != nil {
-			return err
-		}
+	files := make([]string, len(entries))
+	for i, e := range entries {
+		files[i] = filepath.Join(dirName, e)
 	}
-
-	return nil
+	return files, nil
 }
 
 // orderFilesNaturally orders the file names by their numberic prefix, ignoring
@@ -364,17 +409,17 @@ func (t *Migrator) getMigrationFileVersion(dirName string, fileName string) (*se
 	return &migrationFileVersion, nil
 }
 
-// upgradeVersion finds all the versions between `from` and `to`, sorts them
-// using semantic version ordering and applies them sequentially in the supplied transaction.
-func (t *Migrator) upgradeVersion(tx pgx.Tx, from, to semver.Version) error {
+// versionFiles finds all the versions between `from` and `to`, sorts them
+// using semantic version ordering and returns their files.
+func (t *Migrator) versionFiles(from, to semver.Version) ([]string, error) {
 	devDirFile, err := t.sqlFiles.Open(versionScripts)
 	if err != nil {
-		return fmt.Errorf("unable to open %v directory: %w", versionScripts, err)
+		return nil, fmt.Errorf("unable to open %v directory: %w", versionScripts, err)
 	}
 
 	versionDirInfoEntries, err := devDirFile.Readdir(-1)
 	if err != nil {
-		return fmt.Errorf("unable to get %v directory entries: %w", versionScripts, err)
+		return nil, fmt.Errorf("unable to get %v directory entries: %w", versionScripts, err)
 	}
 
 	versions := make(semver.Versions, 0)
@@ -385,30 +430,30 @@ func (t *Migrator) upgradeVersion(tx pgx.Tx, from, to semver.Version) error {
 			if versionDirInfo.Name() == ".gitignore" {
 				continue
 			}
-			return fmt.Errorf("Not a directory inside %v: %v", versionScripts, versionDirInfo.Name())
+			return nil, fmt.Errorf("Not a directory inside %v: %v", versionScripts, versionDirInfo.Name())
 		}
 
 		versionDirPath := versionScripts + "/" + versionDirInfo.Name()
 		versionDirFile, err := t.sqlFiles.Open(versionDirPath)
 		if err != nil {
-			return fmt.Errorf("unable to open migration scripts inside %v: %w", versionDirPath, err)
+			return nil, fmt.Errorf("unable to open migration scripts inside %v: %w", versionDirPath, err)
 		}
 
 		migrationFileInfoEntries, err := versionDirFile.Readdir(-1)
 		if err != nil {
-			return fmt.Errorf("unable to get %v directory entries: %w", versionDirPath, err)
+			return nil, fmt.Errorf("unable to get %v directory entries: %w", versionDirPath, err)
 		}
 
 		for _, migrationFileInfo := range migrationFileInfoEntries {
 			migrationFileVersion, err := t.getMigrationFileVersion(versionDirInfo.Name(), migrationFileInfo.Name())
 			if err != nil {
-				return err
+				return nil, err
 			}
 			migrationFilePath := versionDirPath + "/" + migrationFileInfo.Name()
 
 			_, existing := versionMap[migrationFileVersion.String()]
 			if existing {
-				return fmt.Errorf("Found two migration files with the same version: %v", migrationFileVersion.String())
+				return nil, fmt.Errorf("Found two migration files with the same version: %v", migrationFileVersion.String())
 			}
 			versionMap[migrationFileVersion.String()] = migrationFilePath
 			versions = append(versions, *migrationFileVersion)
@@ -417,17 +462,15 @@ func (t *Migrator) upgradeVersion(tx pgx.Tx, from, to semver.Version) error {
 
 	sort.Sort(versions)
 
+	var files []string
 	for _, v := range versions {
 		//When comparing to the latest version use >= (INCLUSIVE). A migration file
 		//that's marked as version X is part of that version
 		if from.Compare(v) < 0 && to.Compare(v) >= 0 {
-			filename := versionMap[v.String()]
-			if err = t.execMigrationFile(tx, filename); err != nil {
-				return err
-			}
+			files = append(files, versionMap[v.String()])
 		}
 	}
-	return nil
+	return files, nil
 }
 
 func setDBVersion(tx pgx.Tx, version *semver.Version) error {
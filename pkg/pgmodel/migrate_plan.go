@@ -0,0 +1,243 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgmodel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blang/semver/v4"
+)
+
+// scanRate is the rate, in bytes per second, assumed to estimate how long the
+// statements reading or rewriting a table hold their lock.
+const scanRate = 100 << 20
+
+const tableSizeSQL = `SELECT coalesce(sum(pg_total_relation_size(rel)), 0)::bigint
+FROM (
+	SELECT to_regclass($1) AS rel
+	UNION ALL
+	SELECT inhrelid::regclass FROM pg_inherits WHERE inhparent = to_regclass($1)
+) AS t`
+
+// MigrationPlan lists the changes a migration would apply, without applying
+// them.
+type MigrationPlan struct {
+	From semver.Version
+	To   semver.Version
+	// Extensions are the statements installing or updating the extensions.
+	Extensions []string
+	Steps      []MigrationStep
+}
+
+// MigrationStep is a migration file of a plan.
+type MigrationStep struct {
+	File  string
+	SQL   string
+	Locks []TableLock
+}
+
+// TableLock is a lock taken by a migration file. The locks are held until the
+// migration transaction commits.
+type TableLock struct {
+	Table string
+	Mode  string
+	// Scan is set if the statement reads or rewrites the table while holding
+	// the lock.
+	Scan bool
+	// Size is the size of the table and of its chunks in bytes, 0 if it does
+	// not exist yet.
+	Size int64
+}
+
+// EstimatedDuration estimates how long the statement holds the lock before
+// the next statement runs.
+func (l TableLock) EstimatedDuration() time.Duration {
+	if !l.Scan {
+		return 0
+	}
+	return time.Duration(float64(l.Size) / scanRate * float64(time.Second))
+}
+
+func (l TableLock) String() string {
+	s := fmt.Sprintf("%s lock on %s", l.Mode, l.Table)
+	switch {
+	case l.Size == 0:
+		return s + " (new or empty table)"
+	case l.Scan:
+		return s + fmt.Sprintf(" (%.1f MB, scanned in ~%v)", float64(l.Size)/1e6, l.EstimatedDuration().Round(time.Second))
+	}
+	return s + fmt.Sprintf(" (%.1f MB)", float64(l.Size)/1e6)
+}
+
+// Write writes the plan as a SQL script, with the locks of each file in
+// comments.
+func (p *MigrationPlan) Write(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Migration plan from schema version %v to %v.\n", p.From, p.To)
+	if len(p.Extensions) == 0 && len(p.Steps) == 0 {
+		b.WriteString("-- Nothing to migrate.\n")
+	}
+	if len(p.Extensions) > 0 {
+		b.WriteString("\n-- Extensions\n")
+		for _, stmt := range p.Extensions {
+			b.WriteString(stmt + ";\n")
+		}
+	}
+	if len(p.Steps) > 0 {
+		b.WriteString("\n-- The schema files run in a single transaction, holding every lock until it commits.\n")
+		fmt.Fprintf(&b, "-- Scan durations are estimated at %d MB/s.\n", scanRate>>20)
+	}
+	for _, step := range p.Steps {
+		fmt.Fprintf(&b, "\n-- File: %s\n", step.File)
+		for _, l := range step.Locks {
+			fmt.Fprintf(&b, "--   %v\n", l)
+		}
+		b.WriteString(strings.TrimRight(step.SQL, "\n") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Plan returns the migration files Migrate or MigrateUntil would apply to
+// reach the target version, with the locks they take.
+func (t *Migrator) Plan(target semver.Version, idempotent bool) (*MigrationPlan, error) {
+	exists, err := doesSchemaMigrationTableExist(t.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine whether the prom_schema_migrations table existed: %w", err)
+	}
+	var dbVersion semver.Version
+	if exists {
+		if dbVersion, err = getSchemaVersion(t.db); err != nil {
+			return nil, fmt.Errorf("failed to get the version from database: %w", err)
+		}
+	}
+	files, err := t.pendingFiles(dbVersion, target, idempotent)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &MigrationPlan{From: dbVersion, To: target}
+	sizes := make(map[string]int64)
+	for _, fileName := range files {
+		f, err := t.sqlFiles.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get migration script: name %s, err %w", fileName, err)
+		}
+		contents, err := readMigrationFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read migration script: name %s, err %w", fileName, err)
+		}
+		step := MigrationStep{File: fileName, SQL: contents, Locks: statementLocks(contents)}
+		for i, l := range step.Locks {
+			size, ok := sizes[l.Table]
+			if !ok {
+				if err = t.db.QueryRow(context.Background(), tableSizeSQL, l.Table).Scan(&size); err != nil {
+					return nil, fmt.Errorf("error getting the size of %s: %w", l.Table, err)
+				}
+				sizes[l.Table] = size
+			}
+			step.Locks[i].Size = size
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+const ident = `((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)`
+
+var (
+	// statementBoundary splits the SQL in statements, including the
+	// statements of the PL/pgSQL blocks.
+	statementBoundary = regexp.MustCompile(`(?i);|\b(?:BEGIN|THEN|ELSE|LOOP)\b`)
+	lineComment       = regexp.MustCompile(`--[^\n]*`)
+	blockComment      = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	dollarQuote       = regexp.MustCompile(`\$\w*\$`)
+	doKeyword         = regexp.MustCompile(`(?i)\bDO\s*$`)
+
+	tableLocks = []struct {
+		re   *regexp.Regexp
+		mode string
+		scan bool
+	}{
+		{re: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + ident + `.*\b(?:TYPE|SET\s+NOT\s+NULL|PRIMARY\s+KEY|UNIQUE|CHECK|FOREIGN\s+KEY|REFERENCES)\b`), mode: "ACCESS EXCLUSIVE", scan: true},
+		{re: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + ident), mode: "ACCESS EXCLUSIVE"},
+		{re: regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?` + ident), mode: "ACCESS EXCLUSIVE"},
+		{re: regexp.MustCompile(`(?is)^TRUNCATE\s+(?:TABLE\s+)?(?:ONLY\s+)?` + ident), mode: "ACCESS EXCLUSIVE"},
+		{re: regexp.MustCompile(`(?is)^(?:VACUUM\s+FULL|CLUSTER|REINDEX\s+TABLE)\s+` + ident), mode: "ACCESS EXCLUSIVE", scan: true},
+		{re: regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY\b.*?\bON\s+(?:ONLY\s+)?` + ident), mode: "SHARE UPDATE EXCLUSIVE", scan: true},
+		{re: regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\b.*?\bON\s+(?:ONLY\s+)?` + ident), mode: "SHARE", scan: true},
+		{re: regexp.MustCompile(`(?is)^CREATE\s+(?:OR\s+REPLACE\s+)?(?:CONSTRAINT\s+)?TRIGGER\b.*?\bON\s+` + ident), mode: "SHARE ROW EXCLUSIVE"},
+		{re: regexp.MustCompile(`(?is)^(?:UPDATE|DELETE\s+FROM)\s+(?:ONLY\s+)?` + ident), mode: "ROW EXCLUSIVE", scan: true},
+		{re: regexp.MustCompile(`(?is)^INSERT\s+INTO\s+` + ident), mode: "ROW EXCLUSIVE"},
+	}
+	explicitLock = regexp.MustCompile(`(?is)^LOCK\s+(?:TABLE\s+)?(?:ONLY\s+)?` + ident + `(?:\s+IN\s+(.+?)\s+MODE)?`)
+)
+
+// statementLocks estimates the table locks taken by the statements of a
+// migration file, from the statements executed directly or in DO blocks. The
+// statements of the functions, only executed when they are called, and the
+// dynamic SQL are ignored.
+func statementLocks(sql string) []TableLock {
+	var locks []TableLock
+	seen := make(map[TableLock]bool)
+	for _, stmt := range statementBoundary.Split(executedSQL(sql), -1) {
+		stmt = strings.TrimSpace(stmt)
+		l, ok := statementLock(stmt)
+		if ok && !seen[l] {
+			seen[l] = true
+			locks = append(locks, l)
+		}
+	}
+	return locks
+}
+
+func statementLock(stmt string) (TableLock, bool) {
+	if m := explicitLock.FindStringSubmatch(stmt); m != nil {
+		mode := "ACCESS EXCLUSIVE"
+		if m[2] != "" {
+			mode = strings.ToUpper(strings.Join(strings.Fields(m[2]), " "))
+		}
+		return TableLock{Table: m[1], Mode: mode}, true
+	}
+	for _, tl := range tableLocks {
+		if m := tl.re.FindStringSubmatch(stmt); m != nil {
+			return TableLock{Table: m[1], Mode: tl.mode, Scan: tl.scan}, true
+		}
+	}
+	return TableLock{}, false
+}
+
+// executedSQL removes the comments and the bodies of the functions from sql,
+// keeping the bodies of the DO blocks.
+func executedSQL(sql string) string {
+	sql = blockComment.ReplaceAllString(lineComment.ReplaceAllString(sql, ""), "")
+	var b strings.Builder
+	for {
+		loc := dollarQuote.FindStringIndex(sql)
+		if loc == nil {
+			b.WriteString(sql)
+			return b.String()
+		}
+		tag := sql[loc[0]:loc[1]]
+		end := strings.Index(sql[loc[1]:], tag)
+		if end < 0 {
+			b.WriteString(sql)
+			return b.String()
+		}
+		body := sql[loc[1] : loc[1]+end]
+		b.WriteString(sql[:loc[0]])
+		if doKeyword.MatchString(sql[:loc[0]]) {
+			b.WriteString(";" + body + ";")
+		} else {
+			b.WriteString("''")
+		}
+		sql = sql[loc[1]+end+len(tag):]
+	}
+}
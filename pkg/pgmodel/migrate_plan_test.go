@@ -0,0 +1,84 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgmodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/tests/test_migrations"
+)
+
+func TestStatementLocks(t *testing.T) {
+	sql := `
+-- ALTER TABLE commented.out ADD COLUMN x int;
+CREATE TABLE _prom_catalog.new_table (id int);
+ALTER TABLE _prom_catalog.series ADD COLUMN delete_epoch bigint;
+ALTER TABLE ONLY _prom_catalog.label ALTER COLUMN value TYPE text;
+CREATE INDEX IF NOT EXISTS series_labels ON _prom_catalog.series USING GIN (labels);
+CREATE OR REPLACE FUNCTION _prom_catalog.f() RETURNS void AS $func$
+BEGIN
+	UPDATE _prom_catalog.not_run SET x = 1;
+END
+$func$ LANGUAGE plpgsql;
+DO $$
+BEGIN
+	IF true THEN
+		UPDATE _prom_catalog.metric SET table_name = table_name;
+	END IF;
+END
+$$;
+LOCK TABLE _prom_catalog.label_key IN SHARE ROW EXCLUSIVE MODE;
+INSERT INTO _prom_catalog.default (key, value) VALUES ('a', 'b') ON CONFLICT (key) DO UPDATE SET value = excluded.value;
+`
+	require.Equal(t, []TableLock{
+		{Table: "_prom_catalog.series", Mode: "ACCESS EXCLUSIVE"},
+		{Table: "_prom_catalog.label", Mode: "ACCESS EXCLUSIVE", Scan: true},
+		{Table: "_prom_catalog.series", Mode: "SHARE", Scan: true},
+		{Table: "_prom_catalog.metric", Mode: "ROW EXCLUSIVE", Scan: true},
+		{Table: "_prom_catalog.label_key", Mode: "SHARE ROW EXCLUSIVE"},
+		{Table: "_prom_catalog.default", Mode: "ROW EXCLUSIVE"},
+	}, statementLocks(sql))
+}
+
+func TestTableLockEstimatedDuration(t *testing.T) {
+	l := TableLock{Table: "t", Mode: "SHARE", Size: 10 * scanRate}
+	require.Equal(t, time.Duration(0), l.EstimatedDuration())
+	l.Scan = true
+	require.Equal(t, 10*time.Second, l.EstimatedDuration())
+	require.Equal(t, "SHARE lock on t (1048.6 MB, scanned in ~10s)", l.String())
+}
+
+func TestPendingFiles(t *testing.T) {
+	mig := NewMigrator(nil, test_migrations.MigrationFiles, map[string][]string{})
+	idempotent := []string{"idempotent/1-toc-run_second.sql", "idempotent/2-toc-run_first.sql"}
+
+	files, err := mig.pendingFiles(semver.Version{}, semver.MustParse("0.1.1"), true)
+	require.NoError(t, err)
+	require.Equal(t, append([]string{"preinstall/001-setup.sql"}, idempotent...), files)
+
+	// Staged migrations stop at the target version, without the idempotent files.
+	files, err = mig.pendingFiles(semver.MustParse("0.2.0"), semver.MustParse("0.10.0"), false)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"versions/dev/0.9.0-dev/1-migration.sql",
+		"versions/dev/0.10.0-dev/1-migr_98_at.sql",
+		"versions/dev/0.10.0-dev/2-1_mig.sql",
+	}, files)
+
+	files, err = mig.pendingFiles(semver.MustParse("0.10.0"), semver.MustParse("0.10.0"), true)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	files, err = mig.pendingFiles(semver.MustParse("0.10.0-dev"), semver.MustParse("0.10.0-dev"), true)
+	require.NoError(t, err)
+	require.Equal(t, idempotent, files)
+
+	_, err = mig.pendingFiles(semver.MustParse("0.11.0"), semver.MustParse("0.10.0"), false)
+	require.Error(t, err)
+}
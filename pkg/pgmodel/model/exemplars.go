@@ -5,6 +5,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/timescale/promscale/pkg/prompb"
 )
@@ -155,6 +157,21 @@ type ExemplarData struct {
 	Labels labels.Labels `json:"labels"`
 	Value  float64       `json:"value"`
 	Ts     int64         `json:"timestamp"` // This is int64 in Prometheus, but we do this to avoid later conversions to decimal.
+	// Trace is the span the exemplar links to, set only if the exemplars
+	// are queried with their traces and the span is stored.
+	Trace *ExemplarTrace `json:"trace,omitempty"`
+}
+
+// ExemplarTrace describes the span referenced by the trace and span ID
+// labels of an exemplar.
+type ExemplarTrace struct {
+	TraceID     string    `json:"traceID"`
+	SpanID      string    `json:"spanID"`
+	ServiceName string    `json:"serviceName"`
+	SpanName    string    `json:"spanName"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	StatusCode  string    `json:"statusCode"`
 }
 
 type ExemplarQueryResult struct {
@@ -4,9 +4,12 @@ package model
 type MetricInfo struct {
 	MetricID                            int64
 	TableSchema, TableName, SeriesTable string
+	// TenantPartitioned is true if the samples of the metric are partitioned
+	// by tenant.
+	TenantPartitioned bool
 }
 
 // Len returns the memory size of MetricInfo in bytes.
 func (v MetricInfo) Len() int {
-	return 8 + len(v.TableSchema) + len(v.TableName) + len(v.SeriesTable)
+	return 9 + len(v.TableSchema) + len(v.TableName) + len(v.SeriesTable)
 }
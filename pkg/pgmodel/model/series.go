@@ -11,6 +11,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/timescale/promscale/pkg/intern"
 	"github.com/timescale/promscale/pkg/prompb"
 )
 
@@ -56,7 +57,9 @@ func NewSeries(key string, labelPairs []prompb.Label) *Series {
 		series.names[i] = l.Name
 		series.values[i] = l.Value
 		if l.Name == MetricNameLabelName {
-			series.metricName = l.Value
+			// The metric name outlives the names and values, so make sure
+			// it shares its memory with every other series of the metric.
+			series.metricName = intern.String(l.Value)
 		}
 	}
 	return series
@@ -80,6 +83,31 @@ func (l *Series) String() string {
 	return l.str
 }
 
+// LabelValue returns the value of a label of the series, empty if it has
+// none. It is read from the string representation, which outlives the names
+// and values: each name and value is prefixed by its length as a little-endian
+// uint16, as generated by the series cache.
+func (l *Series) LabelValue(name string) string {
+	s := l.str
+	for len(s) >= 2 {
+		n := int(s[0]) | int(s[1])<<8
+		if len(s) < 4+n {
+			return ""
+		}
+		key := s[2 : 2+n]
+		s = s[2+n:]
+		n = int(s[0]) | int(s[1])<<8
+		if len(s) < 2+n {
+			return ""
+		}
+		if key == name {
+			return s[2 : 2+n]
+		}
+		s = s[2+n:]
+	}
+	return ""
+}
+
 // Compare returns a comparison int between two Labels
 func (l *Series) Compare(b *Series) int {
 	return strings.Compare(l.str, b.str)
@@ -414,7 +414,7 @@ func (m *MockRows) Scan(dest ...interface{}) error {
 				*d = s
 			}
 		case float64:
-			if _, ok := dest[i].(float64); !ok {
+			if _, ok := dest[i].(*float64); !ok {
 				return fmt.Errorf("wrong value type float64")
 			}
 			dv := reflect.ValueOf(dest[i])
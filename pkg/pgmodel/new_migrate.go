@@ -7,6 +7,7 @@ package pgmodel
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/blang/semver/v4"
@@ -14,7 +15,14 @@ import (
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/migrations"
 	"github.com/timescale/promscale/pkg/pgmodel/common/extension"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/util"
+	"github.com/timescale/promscale/pkg/version"
+)
+
+const (
+	dropOldExtension = "DROP EXTENSION IF EXISTS promscale"
+	installAllBalls  = "CREATE EXTENSION promscale SCHEMA public VERSION '0.0.0'"
 )
 
 var (
@@ -51,23 +59,16 @@ func doesSchemaMigrationTableExist(db *pgx.Conn) (exists bool, err error) {
 }
 
 func removeOldExtensionIfExists(db *pgx.Conn) error {
-	const (
-		// transition is the first version of the extension that does the
-		// migrations the new way (i.e. in the extension rather than from promscale connector)
-		transition = "0.5.0"
-		stmt       = "DROP EXTENSION IF EXISTS promscale"
-	)
-
-	installedVersion, installed, err := extension.FetchInstalledExtensionVersion(db, "promscale")
+	installedVersion, old, err := oldExtensionInstalled(db)
 	if err != nil {
-		return fmt.Errorf("error fetching extension version while dropping old extension: %w", err)
+		return err
 	}
 
-	if installed && installedVersion.GT(semver.MustParse("0.0.1")) && installedVersion.LT(semver.MustParse(transition)) {
+	if old {
 		log.Info("msg", "Dropping extension at version '"+installedVersion.String()+"'")
 		_, err := db.Exec(
 			context.Background(),
-			stmt,
+			dropOldExtension,
 		)
 		if err != nil {
 			return fmt.Errorf("error dropping old extension: %w", err)
@@ -77,12 +78,25 @@ func removeOldExtensionIfExists(db *pgx.Conn) error {
 	return nil
 }
 
+// oldExtensionInstalled returns whether a version of the extension from
+// before the migrations were done by the extension is installed.
+func oldExtensionInstalled(db *pgx.Conn) (semver.Version, bool, error) {
+	// transition is the first version of the extension that does the
+	// migrations the new way (i.e. in the extension rather than from promscale connector)
+	const transition = "0.5.0"
+
+	installedVersion, installed, err := extension.FetchInstalledExtensionVersion(db, "promscale")
+	if err != nil {
+		return installedVersion, false, fmt.Errorf("error fetching extension version while dropping old extension: %w", err)
+	}
+	return installedVersion, installed && installedVersion.GT(semver.MustParse("0.0.1")) && installedVersion.LT(semver.MustParse(transition)), nil
+}
+
 func installExtensionAllBalls(db *pgx.Conn) error {
 	log.Info("msg", "Installing extension at version '0.0.0'")
-	const stmt = "CREATE EXTENSION promscale SCHEMA public VERSION '0.0.0'"
 	_, err := db.Exec(
 		context.Background(),
-		stmt,
+		installAllBalls,
 	)
 	if err != nil {
 		return fmt.Errorf("error installing Promscale extension at version 0.0.0: %w", err)
@@ -96,31 +110,11 @@ func Migrate(conn *pgx.Conn, appVersion VersionInfo, leaseLock *util.PgAdvisoryL
 		return err
 	}
 
-	// At startup migrators attempt to grab the schema-version lock. If this
-	// fails that means some other connector is running. All is not lost: some
-	// other connector may have migrated the DB to the correct version. We warn,
-	// then start the connector as normal. If we are on the wrong version, the
-	// normal version-check code will prevent us from running.
-	if leaseLock != nil {
-		locked, err := leaseLock.GetAdvisoryLock()
-		if err != nil {
-			return fmt.Errorf("error while acquiring migration lock %w", err)
-		}
-		if !locked {
-			return MigrationLockError
-		}
-		defer func() {
-			_, err := leaseLock.Unlock()
-			if err != nil {
-				log.Error("msg", "error while releasing migration lock", "err", err)
-			}
-		}()
-	} else {
-		log.Warn("msg", "skipping migration lock")
+	unlock, err := lockMigration(leaseLock)
+	if err != nil {
+		return err
 	}
-
-	migrateMutex.Lock()
-	defer migrateMutex.Unlock()
+	defer unlock()
 
 	// make sure a supported version of the extension is available before making any database changes
 	available, err := extension.AreSupportedPromscaleExtensionVersionsAvailable(conn, extOptions)
@@ -152,6 +146,137 @@ func Migrate(conn *pgx.Conn, appVersion VersionInfo, leaseLock *util.PgAdvisoryL
 	return nil
 }
 
+// lockMigration grabs the schema-version lock, and returns the function
+// releasing it.
+func lockMigration(leaseLock *util.PgAdvisoryLock) (func(), error) {
+	// At startup migrators attempt to grab the schema-version lock. If this
+	// fails that means some other connector is running. All is not lost: some
+	// other connector may have migrated the DB to the correct version. We warn,
+	// then start the connector as normal. If we are on the wrong version, the
+	// normal version-check code will prevent us from running.
+	if leaseLock != nil {
+		locked, err := leaseLock.GetAdvisoryLock()
+		if err != nil {
+			return nil, fmt.Errorf("error while acquiring migration lock %w", err)
+		}
+		if !locked {
+			return nil, MigrationLockError
+		}
+	} else {
+		log.Warn("msg", "skipping migration lock")
+	}
+
+	migrateMutex.Lock()
+	return func() {
+		migrateMutex.Unlock()
+		if leaseLock == nil {
+			return
+		}
+		if _, err := leaseLock.Unlock(); err != nil {
+			log.Error("msg", "error while releasing migration lock", "err", err)
+		}
+	}, nil
+}
+
+// MigrateUntil applies the schema migrations up to and including the target
+// version, below the application version, for staged upgrades. The schema is
+// left at the target version and the connector cannot run on it until it is
+// migrated to the application version. Only the schemas still migrated by
+// the connector can be migrated in stages, the Promscale extension migrates
+// its schema in one step.
+func MigrateUntil(conn *pgx.Conn, appVersion VersionInfo, target semver.Version, leaseLock *util.PgAdvisoryLock) error {
+	appSemver, err := semver.Make(appVersion.Version)
+	if err != nil {
+		return err
+	}
+	if !target.LT(appSemver) {
+		return fmt.Errorf("target version %v must be below the application version %v", target, appSemver)
+	}
+
+	unlock, err := lockMigration(leaseLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	schemaMigrationTableExists, err := doesSchemaMigrationTableExist(conn)
+	if err != nil {
+		return fmt.Errorf("failed to determine whether the prom_schema_migrations table existed: %w", err)
+	}
+	if !schemaMigrationTableExists {
+		return fmt.Errorf("the schema is migrated by the Promscale extension, which cannot be migrated in stages")
+	}
+	mig := NewMigrator(conn, migrations.MigrationFiles, TableOfContents)
+	if err = mig.MigrateUntil(target); err != nil {
+		return fmt.Errorf("Error encountered during migration: %w", err)
+	}
+	return nil
+}
+
+// PlanMigration returns the changes Migrate would apply, without applying
+// them.
+func PlanMigration(conn *pgx.Conn, appVersion VersionInfo, extOptions extension.ExtensionMigrateOptions) (*MigrationPlan, error) {
+	appSemver, err := semver.Make(appVersion.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []string
+	if extOptions.Install {
+		stmt, err := extension.PlanExtension(conn, "timescaledb", schema.Public, version.TimescaleVersionRange, version.TimescaleVersionRangeString, extOptions)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan the timescaledb migration: %w", err)
+		}
+		if stmt != "" {
+			extensions = append(extensions, stmt)
+		}
+	}
+
+	schemaMigrationTableExists, err := doesSchemaMigrationTableExist(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine whether the prom_schema_migrations table existed: %w", err)
+	}
+	if !schemaMigrationTableExists {
+		stmt, err := extension.PlanExtension(conn, "promscale", schema.Public, version.ExtVersionRange, version.ExtVersionRangeString, extOptions)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan the promscale extension migration: %w", err)
+		}
+		if stmt != "" {
+			extensions = append(extensions, stmt)
+		}
+		current, _, err := extension.FetchInstalledExtensionVersion(conn, "promscale")
+		if err != nil {
+			return nil, err
+		}
+		return &MigrationPlan{From: current, To: appSemver, Extensions: extensions}, nil
+	}
+
+	mig := NewMigrator(conn, migrations.MigrationFiles, TableOfContents)
+	plan, err := mig.Plan(appSemver, true)
+	if err != nil {
+		return nil, err
+	}
+	plan.Extensions = extensions
+
+	// The transition to the migrations done by the extension, as in
+	// upgradeThroughAllBalls.
+	transition := []string{installAllBalls}
+	if _, old, err := oldExtensionInstalled(conn); err != nil {
+		return nil, err
+	} else if old {
+		transition = append([]string{dropOldExtension}, transition...)
+	}
+	update, err := extension.UpdateStatement(conn, "promscale", version.ExtVersionRange, version.ExtVersionRangeString, extOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not plan the promscale extension migration: %w", err)
+	}
+	plan.Steps = append(plan.Steps, MigrationStep{
+		File: "promscale extension",
+		SQL:  strings.Join(append(transition, update), ";\n") + ";",
+	})
+	return plan, nil
+}
+
 func upgradeThroughAllBalls(conn *pgx.Conn, appSemver semver.Version, extOptions extension.ExtensionMigrateOptions) error {
 	var err error
 	ctx := context.Background()
@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package partitioning selects the metrics whose samples are partitioned by
+// tenant. Their tables hold the tenant of each sample in a tenant column,
+// which is a space dimension of the hypertable on top of time.
+package partitioning
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+const (
+	// TenantColumn is the column of the tenant of the samples.
+	TenantColumn = "tenant"
+
+	partitionedSQL = `SELECT EXISTS (
+	SELECT 1 FROM pg_attribute a
+	WHERE a.attrelid = format('%I.%I', $1::text, $2::text)::regclass AND a.attname = 'tenant' AND NOT a.attisdropped)`
+	// The metric table is partitioned by the database, which locks it and
+	// partitions it only if it is empty.
+	setPartitioningSQL = "SELECT _prom_catalog.set_metric_tenant_partitioning($1, $2)"
+)
+
+// Layout selects the metrics partitioned by tenant. A nil Layout does not
+// partition any metric nor read the layout of their tables.
+type Layout struct {
+	metrics    *regexp.Regexp
+	partitions int
+}
+
+// New returns the Layout of the tenancy configuration, or nil if multi-tenancy
+// is disabled.
+func New(cfg *tenancy.Config) (*Layout, error) {
+	if !cfg.EnableMultiTenancy {
+		return nil, nil
+	}
+	l := &Layout{partitions: cfg.TenantPartitions}
+	if cfg.PartitionedMetrics != "" {
+		re, err := regexp.Compile("^(?:" + cfg.PartitionedMetrics + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid partitioned metrics regex: %w", err)
+		}
+		l.metrics = re
+	}
+	return l, nil
+}
+
+// ForMetric returns true if the samples of a metric are partitioned by tenant.
+// The metric table is partitioned if the metric is configured and the table
+// is still empty. Tables with data keep their layout, even if the metric is
+// no longer configured.
+func (l *Layout) ForMetric(ctx context.Context, conn pgxconn.PgxConn, metric string, info model.MetricInfo) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	partitioned, err := IsPartitioned(ctx, conn, info.TableSchema, info.TableName)
+	if err != nil || partitioned || l.metrics == nil || !l.metrics.MatchString(metric) {
+		return partitioned, err
+	}
+	if err = conn.QueryRow(ctx, setPartitioningSQL, metric, l.partitions).Scan(&partitioned); err != nil {
+		return false, fmt.Errorf("partitioning %s by tenant: %w", metric, err)
+	}
+	if !partitioned {
+		log.Info("msg", "Metric table is not empty, keeping it unpartitioned by tenant", "metric", metric)
+		return false, nil
+	}
+	log.Info("msg", "Metric table partitioned by tenant", "metric", metric, "partitions", l.partitions)
+	return true, nil
+}
+
+// IsPartitioned returns true if the table of a metric holds the tenant of its
+// samples.
+func IsPartitioned(ctx context.Context, conn pgxconn.PgxConn, schema, table string) (bool, error) {
+	var partitioned bool
+	if err := conn.QueryRow(ctx, partitionedSQL, schema, table).Scan(&partitioned); err != nil {
+		return false, fmt.Errorf("reading tenant partitioning of %s.%s: %w", schema, table, err)
+	}
+	return partitioned, nil
+}
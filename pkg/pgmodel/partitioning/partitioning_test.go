@@ -0,0 +1,82 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package partitioning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/tenancy"
+)
+
+func TestForMetric(t *testing.T) {
+	l, err := New(&tenancy.Config{EnableMultiTenancy: true, PartitionedMetrics: "container_.*", TenantPartitions: 4})
+	require.NoError(t, err)
+	partitioned := func(metric string, result bool) model.SqlQuery {
+		return model.SqlQuery{Sql: partitionedSQL, Args: []interface{}{"prom_data", metric}, Results: model.RowResults{{result}}}
+	}
+
+	testCases := []struct {
+		name     string
+		metric   string
+		queries  []model.SqlQuery
+		expected bool
+	}{
+		{
+			name:   "empty table partitioned",
+			metric: "container_cpu",
+			queries: []model.SqlQuery{
+				partitioned("container_cpu", false),
+				{Sql: setPartitioningSQL, Args: []interface{}{"container_cpu", 4}, Results: model.RowResults{{true}}},
+			},
+			expected: true,
+		},
+		{
+			name:   "table with data kept",
+			metric: "container_cpu",
+			queries: []model.SqlQuery{
+				partitioned("container_cpu", false),
+				{Sql: setPartitioningSQL, Args: []interface{}{"container_cpu", 4}, Results: model.RowResults{{false}}},
+			},
+		},
+		{
+			name:     "already partitioned",
+			metric:   "container_cpu",
+			queries:  []model.SqlQuery{partitioned("container_cpu", true)},
+			expected: true,
+		},
+		{
+			name:     "partitioned before the metric was unconfigured",
+			metric:   "up",
+			queries:  []model.SqlQuery{partitioned("up", true)},
+			expected: true,
+		},
+		{
+			name:    "not configured",
+			metric:  "up",
+			queries: []model.SqlQuery{partitioned("up", false)},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder(c.queries, t)
+			info := model.MetricInfo{TableSchema: "prom_data", TableName: c.metric}
+			p, err := l.ForMetric(context.Background(), conn, c.metric, info)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, p)
+		})
+	}
+
+	// Without multi-tenancy, the layout of the tables is not read.
+	l, err = New(&tenancy.Config{PartitionedMetrics: "container_.*"})
+	require.NoError(t, err)
+	require.Nil(t, l)
+	p, err := l.ForMetric(context.Background(), nil, "container_cpu", model.MetricInfo{})
+	require.NoError(t, err)
+	require.False(t, p)
+}
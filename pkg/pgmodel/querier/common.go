@@ -47,6 +47,22 @@ func fromLabelMatchers(matchers []*prompb.LabelMatcher) ([]*labels.Matcher, erro
 type QueryHints struct {
 	CurrentNode parser.Node
 	Lookback    time.Duration
+	// EvalWindow is nil when the engine can't tell ahead of evaluation
+	// at which steps CurrentNode is evaluated.
+	EvalWindow *EvalWindow
+	// OverTimePushdown enables computing the *_over_time functions of
+	// instant queries with SQL aggregates.
+	OverTimePushdown bool
+}
+
+// EvalWindow describes the steps at which the engine evaluates a selector,
+// after subqueries and the @ modifier have been applied. All values are in
+// milliseconds. The samples for a step at time t are read at t - Offset.
+type EvalWindow struct {
+	Start  int64
+	End    int64
+	Step   int64
+	Offset int64
 }
 
 func GetMetricNameSeriesIds(ctx context.Context, conn pgxconn.PgxConn, metadata *evalMetadata) (metrics, schemas []string, correspondingSeriesIDs [][]model.SeriesID, err error) {
@@ -14,6 +14,10 @@ import (
 // Reader reads the data based on the provided read request.
 type Reader interface {
 	Read(context.Context, *prompb.ReadRequest) (*prompb.ReadResponse, error)
+	// ReadChunks calls streamFn, one query at a time, with the series matching
+	// each query of the read request encoded as XOR chunks. The series set is
+	// only valid until streamFn returns.
+	ReadChunks(ctx context.Context, req *prompb.ReadRequest, streamFn func(queryIndex int, ss ChunkSeriesSet) error) error
 }
 
 // SeriesSet adds a Close method to storage.SeriesSet to provide a way to free memory/
@@ -22,6 +26,12 @@ type SeriesSet interface {
 	Close()
 }
 
+// ChunkSeriesSet adds a Close method to storage.ChunkSeriesSet to provide a way to free memory.
+type ChunkSeriesSet interface {
+	storage.ChunkSeriesSet
+	Close()
+}
+
 // Querier provides access to the three query methods: remote read, samples,
 // and exemplars.
 type Querier interface {
@@ -38,6 +48,9 @@ type Querier interface {
 type RemoteReadQuerier interface {
 	// Query returns resulting timeseries for a query.
 	Query(*prompb.Query) ([]*prompb.TimeSeries, error)
+	// QueryChunks returns the resulting timeseries for a query as XOR encoded
+	// chunks, sorted by their labels.
+	QueryChunks(*prompb.Query) (ChunkSeriesSet, error)
 }
 
 // SamplesQuerier queries data using the provided query data and returns the
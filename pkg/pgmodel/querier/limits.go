@@ -0,0 +1,135 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+)
+
+// Limits bound the resources used by one query. Zero means unlimited.
+type Limits struct {
+	// MaxSamples is the maximum number of samples read from the database.
+	MaxSamples int64
+	// MaxSeries is the maximum number of series read from the database.
+	MaxSeries int64
+	// MaxBytes is the maximum estimated size of the series and samples
+	// read from the database.
+	MaxBytes int64
+}
+
+// The estimated size of a sample, a timestamp and a float, and of a label ID.
+const (
+	sampleBytes  = 16
+	labelIDBytes = 8
+)
+
+// rowBytes estimates the size of a series read from the database.
+func rowBytes(row *sampleRow) int64 {
+	return int64(len(row.values.Elements))*sampleBytes + int64(len(row.labelIds))*labelIDBytes
+}
+
+// LimitError is returned when a query exceeds one of its limits.
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("query exceeded the limit of %d %s, narrow down the selectors or the time range", e.Max, e.Limit)
+}
+
+type limitsKey struct{}
+
+// WithLimits returns a context limiting the resources of the queries run
+// with it. All the selects of a query share the limits.
+func WithLimits(ctx context.Context, limits Limits) context.Context {
+	if limits == (Limits{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, limitsKey{}, &limitsTracker{limits: limits})
+}
+
+// limitsTracker counts the resources read by a query. A nil limitsTracker
+// does not limit anything.
+type limitsTracker struct {
+	limits Limits
+
+	mu      sync.Mutex
+	samples int64
+	series  int64
+	bytes   int64
+}
+
+func limitsFromContext(ctx context.Context) *limitsTracker {
+	t, _ := ctx.Value(limitsKey{}).(*limitsTracker)
+	return t
+}
+
+// checkSeries returns a *LimitError if reading n more series would exceed the
+// series limit of the query. It is called with the number of series matching
+// a selector before their samples are fetched, the series are counted by add
+// as they are read.
+func (t *limitsTracker) checkSeries(n int64) error {
+	if t == nil || t.limits.MaxSeries == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.series+n > t.limits.MaxSeries {
+		return exceeded("series", t.limits.MaxSeries)
+	}
+	return nil
+}
+
+// add counts a series read from the database with its samples and returns a
+// *LimitError if the query exceeds one of its limits.
+func (t *limitsTracker) add(row *sampleRow) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.series++
+	t.samples += int64(len(row.values.Elements))
+	t.bytes += rowBytes(row)
+
+	switch {
+	case t.limits.MaxSeries > 0 && t.series > t.limits.MaxSeries:
+		return exceeded("series", t.limits.MaxSeries)
+	case t.limits.MaxSamples > 0 && t.samples > t.limits.MaxSamples:
+		return exceeded("samples", t.limits.MaxSamples)
+	case t.limits.MaxBytes > 0 && t.bytes > t.limits.MaxBytes:
+		return exceeded("bytes", t.limits.MaxBytes)
+	}
+	return nil
+}
+
+// AddLabelValues counts the label names or values read by a query of the
+// labels API against its bytes limit and returns a *LimitError if the query
+// exceeds it.
+func AddLabelValues(ctx context.Context, values []string) error {
+	t := limitsFromContext(ctx)
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, v := range values {
+		t.bytes += int64(len(v))
+	}
+	if t.limits.MaxBytes > 0 && t.bytes > t.limits.MaxBytes {
+		return exceeded("bytes", t.limits.MaxBytes)
+	}
+	return nil
+}
+
+func exceeded(limit string, max int64) *LimitError {
+	metrics.QueryLimitsExceeded.WithLabelValues(limit).Inc()
+	return &LimitError{Limit: limit, Max: max}
+}
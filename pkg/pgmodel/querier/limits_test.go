@@ -0,0 +1,102 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsTracker(t *testing.T) {
+	row := func(samples, labels int) *sampleRow {
+		return &sampleRow{
+			labelIds: make([]int64, labels),
+			values:   &pgtype.Float8Array{Elements: make([]pgtype.Float8, samples)},
+		}
+	}
+	testCases := []struct {
+		name     string
+		limits   Limits
+		rows     []*sampleRow
+		exceeded string
+	}{
+		{
+			name:   "within limits",
+			limits: Limits{MaxSamples: 10, MaxSeries: 2, MaxBytes: 1000},
+			rows:   []*sampleRow{row(5, 2), row(5, 2)},
+		},
+		{
+			name:     "series",
+			limits:   Limits{MaxSeries: 1},
+			rows:     []*sampleRow{row(1, 1), row(1, 1)},
+			exceeded: "series",
+		},
+		{
+			name:     "samples",
+			limits:   Limits{MaxSamples: 10},
+			rows:     []*sampleRow{row(6, 1), row(6, 1)},
+			exceeded: "samples",
+		},
+		{
+			name:     "bytes",
+			limits:   Limits{MaxBytes: 100},
+			rows:     []*sampleRow{row(6, 2)},
+			exceeded: "bytes",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			tracker := limitsFromContext(WithLimits(context.Background(), c.limits))
+			require.NotNil(t, tracker)
+			var err error
+			for _, r := range c.rows {
+				if err = tracker.add(r); err != nil {
+					break
+				}
+			}
+			if c.exceeded == "" {
+				require.NoError(t, err)
+				return
+			}
+			var limitErr *LimitError
+			require.True(t, errors.As(err, &limitErr))
+			require.Equal(t, c.exceeded, limitErr.Limit)
+		})
+	}
+
+	require.Nil(t, limitsFromContext(WithLimits(context.Background(), Limits{})))
+	var tracker *limitsTracker
+	require.NoError(t, tracker.add(row(100, 1)))
+}
+
+func TestLimitsTrackerCheckSeries(t *testing.T) {
+	tracker := limitsFromContext(WithLimits(context.Background(), Limits{MaxSeries: 3}))
+	require.NoError(t, tracker.checkSeries(3))
+	require.NoError(t, tracker.add(&sampleRow{values: &pgtype.Float8Array{}}))
+	require.NoError(t, tracker.checkSeries(2))
+
+	var limitErr *LimitError
+	require.True(t, errors.As(tracker.checkSeries(3), &limitErr))
+	require.Equal(t, "series", limitErr.Limit)
+
+	unlimited := limitsFromContext(WithLimits(context.Background(), Limits{MaxSamples: 1}))
+	require.NoError(t, unlimited.checkSeries(1000))
+	var nilTracker *limitsTracker
+	require.NoError(t, nilTracker.checkSeries(1000))
+}
+
+func TestAddLabelValues(t *testing.T) {
+	require.NoError(t, AddLabelValues(context.Background(), []string{"job", "instance"}))
+
+	ctx := WithLimits(context.Background(), Limits{MaxBytes: 12})
+	require.NoError(t, AddLabelValues(ctx, []string{"job", "instance"}))
+	var limitErr *LimitError
+	require.True(t, errors.As(AddLabelValues(ctx, []string{"le"}), &limitErr))
+	require.Equal(t, "bytes", limitErr.Limit)
+}
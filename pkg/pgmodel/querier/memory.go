@@ -0,0 +1,118 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+)
+
+// pointBytes is the size of a point of the PromQL engine, a timestamp and a
+// float.
+const pointBytes = 16
+
+// MemoryLimitError is returned when a query uses more memory than its limit.
+type MemoryLimitError struct {
+	Limit int64
+	Used  int64
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("query exceeded the memory limit of %d bytes, using about %d bytes", e.Limit, e.Used)
+}
+
+// QueryMemory accounts the approximate memory used by a query: the samples
+// decoded from the database, which are held until the query ends, and the
+// points of the intermediate results of the PromQL engine. A nil QueryMemory
+// does not account anything.
+type QueryMemory struct {
+	limit int64
+
+	mu      sync.Mutex
+	decoded int64
+	points  int64
+	peak    int64
+}
+
+type memoryKey struct{}
+
+// WithQueryMemory returns a context accounting the memory of the query run
+// with it, which fails with a *MemoryLimitError once it uses more than limit
+// bytes. A limit of 0 only accounts the memory. Release must be called on the
+// returned QueryMemory when the query is done.
+func WithQueryMemory(ctx context.Context, limit int64) (context.Context, *QueryMemory) {
+	m := &QueryMemory{limit: limit}
+	return context.WithValue(ctx, memoryKey{}, m), m
+}
+
+// QueryMemoryFromContext returns the QueryMemory of a query, or nil if its
+// memory is not accounted.
+func QueryMemoryFromContext(ctx context.Context) *QueryMemory {
+	m, _ := ctx.Value(memoryKey{}).(*QueryMemory)
+	return m
+}
+
+// AddDecoded accounts bytes of samples decoded from the database.
+func (m *QueryMemory) AddDecoded(bytes int64) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decoded += bytes
+	metrics.QueryMemoryInFlight.Add(float64(bytes))
+	return m.check()
+}
+
+// SetPoints accounts the number of points currently held by the PromQL
+// engine.
+func (m *QueryMemory) SetPoints(points int) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delta := int64(points)*pointBytes - m.points
+	m.points += delta
+	metrics.QueryMemoryInFlight.Add(float64(delta))
+	return m.check()
+}
+
+func (m *QueryMemory) check() error {
+	used := m.decoded + m.points
+	if used > m.peak {
+		m.peak = used
+	}
+	if m.limit > 0 && used > m.limit {
+		metrics.QueryLimitsExceeded.WithLabelValues("memory").Inc()
+		return &MemoryLimitError{Limit: m.limit, Used: used}
+	}
+	return nil
+}
+
+// Peak returns the most memory the query used so far.
+func (m *QueryMemory) Peak() int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peak
+}
+
+// Release stops accounting the memory of the query when it is done.
+func (m *QueryMemory) Release() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics.QueryMemoryInFlight.Sub(float64(m.decoded + m.points))
+	metrics.QueryMemoryPeak.Observe(float64(m.peak))
+	m.decoded, m.points = 0, 0
+}
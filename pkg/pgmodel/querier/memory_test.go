@@ -0,0 +1,44 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryMemory(t *testing.T) {
+	ctx, m := WithQueryMemory(context.Background(), 1000)
+	require.Same(t, m, QueryMemoryFromContext(ctx))
+
+	require.NoError(t, m.AddDecoded(400))
+	require.NoError(t, m.SetPoints(30))
+	require.Equal(t, int64(880), m.Peak())
+
+	// The points are replaced, not added.
+	require.NoError(t, m.SetPoints(10))
+	require.Equal(t, int64(880), m.Peak())
+
+	err := m.SetPoints(40)
+	var memErr *MemoryLimitError
+	require.True(t, errors.As(err, &memErr))
+	require.Equal(t, int64(1000), memErr.Limit)
+	require.Equal(t, int64(1040), memErr.Used)
+	m.Release()
+
+	_, unlimited := WithQueryMemory(context.Background(), 0)
+	require.NoError(t, unlimited.AddDecoded(1<<40))
+	unlimited.Release()
+
+	var none *QueryMemory
+	require.Nil(t, QueryMemoryFromContext(context.Background()))
+	require.NoError(t, none.AddDecoded(100))
+	require.NoError(t, none.SetPoints(100))
+	require.Zero(t, none.Peak())
+	none.Release()
+}
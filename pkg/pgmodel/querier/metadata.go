@@ -6,6 +6,8 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
+
+	"github.com/timescale/promscale/pkg/tenancy"
 )
 
 // promqlMetadata is metadata received directly from our native PromQL engine.
@@ -30,6 +32,9 @@ type timeFilter struct {
 	seriesTable string
 	start       string
 	end         string
+	// tenantPartitioned is true if the samples of the metric are
+	// partitioned by tenant.
+	tenantPartitioned bool
 }
 
 type evalMetadata struct {
@@ -39,6 +44,9 @@ type evalMetadata struct {
 	timeFilter      timeFilter
 	clauses         []string
 	values          []interface{}
+	// tenants are the tenants the matchers restrict the series to, nil if
+	// they are not restricted to a list of tenants.
+	tenants []string
 	*promqlMetadata
 }
 
@@ -49,8 +57,10 @@ func GetMetadata(clauses []string, values []interface{}) *evalMetadata {
 // getEvaluationMetadata gives the metadata that will be required in evaluating a query.
 func getEvaluationMetadata(tools *queryTools, start, end int64, promMetadata *promqlMetadata) (*evalMetadata, error) {
 	matchers := promMetadata.matchers
+	var tenants []string
 	if tools.rAuth != nil {
 		matchers = tools.rAuth.AppendTenantMatcher(matchers)
+		tenants, _ = tenancy.MatchedTenants(matchers)
 	}
 	// Build a subquery per metric matcher.
 	builder, err := BuildSubQueries(matchers)
@@ -81,6 +91,7 @@ func getEvaluationMetadata(tools *queryTools, start, end int64, promMetadata *pr
 			timeFilter:     timeFilter,
 			clauses:        clauses,
 			values:         values,
+			tenants:        tenants,
 			promqlMetadata: promMetadata,
 		}, nil
 	}
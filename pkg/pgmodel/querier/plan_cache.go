@@ -0,0 +1,72 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+)
+
+// PlanCache caches the SQL generated for the selects of a single metric by
+// their shape. Dashboards run the same selectors at every refresh, only their
+// time range changes, which is a parameter of the SQL. The cached SQL is
+// therefore also the same statement for the database driver, which prepares
+// it once per connection and reuses it. A nil PlanCache caches nothing.
+type PlanCache struct {
+	cache *clockcache.Cache
+}
+
+// NewPlanCache returns a PlanCache of the given number of plans, or nil if
+// size is 0. Its hits are reported by the promscale_cache_query_hits_total
+// and promscale_cache_queries_total metrics of the query_plans cache.
+func NewPlanCache(size uint64) *PlanCache {
+	if size == 0 {
+		return nil
+	}
+	return &PlanCache{cache: clockcache.WithMetrics("query_plans", "metric", size)}
+}
+
+// sql returns the SQL of the plan with the key, generating it with build if it
+// is not cached.
+func (c *PlanCache) sql(key string, build func() string) string {
+	if c == nil {
+		return build()
+	}
+	if sql, ok := c.cache.Get(key); ok {
+		return sql.(string)
+	}
+	sql := build()
+	// The size includes an 8-byte overhead for each string.
+	c.cache.Insert(key, sql, uint64(len(key)+len(sql)+16))
+	return sql
+}
+
+// Cap returns the number of plans the cache holds.
+func (c *PlanCache) Cap() int {
+	return c.cache.Cap()
+}
+
+// ExpandTo grows the cache to hold up to newMax plans.
+func (c *PlanCache) ExpandTo(newMax int) {
+	c.cache.ExpandTo(newMax)
+}
+
+// planKey returns the shape of a select of a single metric, which determines
+// its SQL: what the select reads, the tables and the column of the metric, the
+// pruning of its tenant partitions, the aggregates of its samples and the
+// clauses of its matchers. The clauses hold
+// parameters in place of the values of the matchers.
+func planKey(kind selectKind, metadata *evalMetadata, qf *aggregators) string {
+	filter := metadata.timeFilter
+	parts := make([]string, 0, 9+len(metadata.clauses))
+	parts = append(parts, strconv.Itoa(int(kind)), filter.schema, filter.metric, filter.seriesTable, filter.column, strconv.FormatBool(prunesTenants(metadata)))
+	if qf != nil {
+		parts = append(parts, qf.timeClause, qf.valueClause, strconv.FormatBool(qf.unOrdered))
+	}
+	parts = append(parts, metadata.clauses...)
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,61 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/clockcache"
+)
+
+func TestPlanCache(t *testing.T) {
+	plans := &PlanCache{cache: clockcache.WithMax(10)}
+	metadataFor := func(start, end int64, ms ...*labels.Matcher) *evalMetadata {
+		ms = append(ms, labels.MustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+		builder, err := BuildSubQueries(ms)
+		require.NoError(t, err)
+		clauses, values, err := builder.Build(false)
+		require.NoError(t, err)
+		return &evalMetadata{
+			timeFilter: timeFilter{metric: "foo", schema: "prom_data", seriesTable: "foo", column: "value"},
+			clauses:    clauses,
+			values:     values,
+			promqlMetadata: &promqlMetadata{
+				selectHints: &storage.SelectHints{Start: start, End: end},
+			},
+		}
+	}
+
+	// The same selector over another time range is a hit with the same SQL.
+	sql, values, _, _, err := buildSingleMetricSamplesQuery(metadataFor(1000, 2000, labels.MustNewMatcher(labels.MatchEqual, "job", "a")), plans)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"job", "a", toRFC3339Nano(1000), toRFC3339Nano(2000)}, values)
+	require.Contains(t, sql, "AND time >= $3\n\t\t\tAND time <= $4")
+	require.Equal(t, 1, plans.cache.Len())
+
+	other, values, _, _, err := buildSingleMetricSamplesQuery(metadataFor(5000, 9000, labels.MustNewMatcher(labels.MatchEqual, "job", "b")), plans)
+	require.NoError(t, err)
+	require.Equal(t, sql, other)
+	require.Equal(t, []interface{}{"job", "b", toRFC3339Nano(5000), toRFC3339Nano(9000)}, values)
+	require.Equal(t, uint64(1), plans.cache.Stats().Hits)
+
+	// Another shape of matchers or another kind of select is another plan.
+	other, _, _, _, err = buildSingleMetricSamplesQuery(metadataFor(1000, 2000, labels.MustNewMatcher(labels.MatchNotEqual, "job", "a")), plans)
+	require.NoError(t, err)
+	require.NotEqual(t, sql, other)
+	series, _ := buildSingleMetricSeriesQuery(metadataFor(1000, 2000, labels.MustNewMatcher(labels.MatchEqual, "job", "a")), plans)
+	require.NotEqual(t, sql, series)
+	require.Equal(t, 3, plans.cache.Len())
+
+	// A nil cache generates the same SQL.
+	uncached, _, _, _, err := buildSingleMetricSamplesQuery(metadataFor(1000, 2000, labels.MustNewMatcher(labels.MatchEqual, "job", "a")), nil)
+	require.NoError(t, err)
+	require.Equal(t, sql, uncached)
+	require.Nil(t, NewPlanCache(0))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/timescale/promscale/pkg/indexadvisor"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgxconn"
@@ -22,13 +23,16 @@ type pgxQuerier struct {
 var _ Querier = (*pgxQuerier)(nil)
 
 // NewQuerier returns a new pgxQuerier that reads from PostgreSQL using PGX
-// and caches metric table names and label sets using the supplied caches.
+// and caches metric table names, label sets and the SQL of the selects using
+// the supplied caches.
 func NewQuerier(
 	conn pgxconn.PgxConn,
 	metricCache cache.MetricCache,
 	labelsReader lreader.LabelsReader,
 	exemplarCache cache.PositionCache,
 	rAuth tenancy.ReadAuthorizer,
+	indexAdvisor *indexadvisor.Advisor,
+	planCache *PlanCache,
 ) Querier {
 	querier := &pgxQuerier{
 		tools: &queryTools{
@@ -37,6 +41,8 @@ func NewQuerier(
 			metricTableNames: metricCache,
 			exemplarPosCache: exemplarCache,
 			rAuth:            rAuth,
+			indexAdvisor:     indexAdvisor,
+			planCache:        planCache,
 		},
 	}
 	return querier
@@ -11,7 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/timescale/promscale/pkg/clockcache"
@@ -306,11 +308,11 @@ func TestPGXQuerierQuery(t *testing.T) {
 					INNER JOIN (
 						SELECT series_id, array_agg(time) as time_array, array_agg(value) as value_array
 						FROM ( SELECT series_id, time, "value" as value FROM "prom_data"."bar" metric
-						WHERE time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z'
+						WHERE time >= $1 AND time <= $2
 						ORDER BY series_id, time ) as time_ordered_rows
 						GROUP BY series_id
 						) as result ON (result.value_array is not null AND result.series_id = series.id)`,
-					Args:    nil,
+					Args:    []interface{}{"1970-01-01T00:00:01Z", "1970-01-01T00:00:02Z"},
 					Results: model.RowResults{{[]int64{2}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
@@ -353,11 +355,11 @@ func TestPGXQuerierQuery(t *testing.T) {
 					INNER JOIN (
 						SELECT series_id, array_agg(time) as time_array, array_agg(value) as value_array
 						FROM ( SELECT series_id, time, "value" as value FROM "custom_schema"."custom" metric
-						WHERE time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z'
+						WHERE time >= $1 AND time <= $2
 						ORDER BY series_id, time ) as time_ordered_rows
 						GROUP BY series_id
 						) as result ON (result.value_array is not null AND result.series_id = series.id)`,
-					Args:    nil,
+					Args:    []interface{}{"1970-01-01T00:00:01Z", "1970-01-01T00:00:02Z"},
 					Results: model.RowResults{{[]int64{2}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
@@ -401,11 +403,11 @@ func TestPGXQuerierQuery(t *testing.T) {
 					INNER JOIN (
 						SELECT series_id, array_agg(time) as time_array, array_agg(value) as value_array
 						FROM ( SELECT series_id, time, "max" as value FROM "prom_data"."bar" metric
-						WHERE time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z'
+						WHERE time >= $1 AND time <= $2
 						ORDER BY series_id, time ) as time_ordered_rows
 						GROUP BY series_id
 						) as result ON (result.value_array is not null AND result.series_id = series.id)`,
-					Args:    nil,
+					Args:    []interface{}{"1970-01-01T00:00:01Z", "1970-01-01T00:00:02Z"},
 					Results: model.RowResults{{[]int64{2}, []time.Time{time.Unix(0, 0)}, []float64{1}}},
 					Err:     error(nil),
 				},
@@ -517,9 +519,9 @@ func TestPGXQuerierQuery(t *testing.T) {
 				{
 					Sql: `SELECT series.labels, result.time_array, result.value_array FROM "prom_data_series"."foo" series
 					INNER JOIN LATERAL
-					( SELECT array_agg(time) as time_array, array_agg(value) as value_array FROM ( SELECT time, "value" as value FROM "prom_data"."foo" metric WHERE metric.series_id = series.id AND time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z' ORDER BY time ) as time_ordered_rows ) as result ON (result.value_array is not null)
+					( SELECT array_agg(time) as time_array, array_agg(value) as value_array FROM ( SELECT time, "value" as value FROM "prom_data"."foo" metric WHERE metric.series_id = series.id AND time >= $1 AND time <= $2 ORDER BY time ) as time_ordered_rows ) as result ON (result.value_array is not null)
 					WHERE FALSE`,
-					Args:    []interface{}(nil),
+					Args:    []interface{}{"1970-01-01T00:00:01Z", "1970-01-01T00:00:02Z"},
 					Results: model.RowResults{},
 					Err:     error(nil),
 				},
@@ -753,3 +755,199 @@ func TestPGXQuerierQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestPGXQuerierQueryChunks(t *testing.T) {
+	// 130 samples do not fit into a single chunk.
+	times := make([]time.Time, 130)
+	values := make([]float64, 130)
+	for i := range times {
+		times[i] = time.Unix(int64(i), 0)
+		values[i] = float64(i)
+	}
+
+	type series struct {
+		labels  labels.Labels
+		chunks  int
+		samples int
+	}
+	testCases := []struct {
+		name       string
+		query      *prompb.Query
+		result     []series
+		sqlQueries []model.SqlQuery // XXX whitespace in these is significant
+	}{
+		{
+			name: "Single metric, custom schema",
+			query: &prompb.Query{
+				StartTimestampMs: 1000,
+				EndTimestampMs:   2000,
+				Matchers: []*prompb.LabelMatcher{
+					{Type: prompb.LabelMatcher_EQ, Name: model.MetricNameLabelName, Value: "custom"},
+				},
+			},
+			result: []series{
+				{labels: labels.FromStrings(model.MetricNameLabelName, "custom", model.SchemaNameLabelName, "custom_schema", "a", "1"), chunks: 2, samples: 130},
+				{labels: labels.FromStrings(model.MetricNameLabelName, "custom", model.SchemaNameLabelName, "custom_schema", "a", "2"), chunks: 1, samples: 1},
+			},
+			sqlQueries: []model.SqlQuery{
+				{
+					Sql:     "SELECT id, table_schema, table_name, series_table FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)",
+					Args:    []interface{}{"", "custom"},
+					Results: model.RowResults{{int64(1), "custom_schema", "custom", "bar"}},
+					Err:     error(nil),
+				},
+				{
+					Sql: `SELECT series.* FROM (SELECT series.labels, result.time_array, result.value_array
+					FROM "prom_data_series"."bar" series
+					INNER JOIN (
+						SELECT series_id, array_agg(time) as time_array, array_agg(value) as value_array
+						FROM ( SELECT series_id, time, "value" as value FROM "custom_schema"."custom" metric
+						WHERE time >= $1 AND time <= $2
+						ORDER BY series_id, time ) as time_ordered_rows
+						GROUP BY series_id
+						) as result ON (result.value_array is not null AND result.series_id = series.id))
+					AS series ORDER BY (
+						SELECT array_agg(kv.v ORDER BY lbl.key COLLATE "C", kv.n)
+						FROM ( SELECT l.key, l.value FROM _prom_catalog.label l WHERE l.id = ANY(series.labels)
+						UNION ALL SELECT * FROM unnest($3::text[], $4::text[]) ) AS lbl(key, value),
+						unnest(ARRAY[lbl.key, lbl.value]) WITH ORDINALITY AS kv(v, n)
+					) COLLATE "C"`,
+					Args: []interface{}{"1970-01-01T00:00:01Z", "1970-01-01T00:00:02Z", []string{model.SchemaNameLabelName}, []string{"custom_schema"}},
+					Results: model.RowResults{
+						{[]int64{2, 3}, times, values},
+						{[]int64{2, 4}, []time.Time{time.Unix(0, 0)}, []float64{1}},
+					},
+					Err: error(nil),
+				},
+				{
+					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
+					Args:          []interface{}{[]int64{2, 3}},
+					ArgsUnordered: true,
+					Results:       model.RowResults{{[]int64{2, 3}, []string{"__name__", "a"}, []string{"bar", "1"}}},
+					Err:           error(nil),
+				},
+				{
+					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
+					Args:          []interface{}{[]int64{4}},
+					ArgsUnordered: true,
+					Results:       model.RowResults{{[]int64{4}, []string{"a"}, []string{"2"}}},
+					Err:           error(nil),
+				},
+			},
+		},
+		{
+			name: "Multiple metrics",
+			query: &prompb.Query{
+				StartTimestampMs: 1000,
+				EndTimestampMs:   2000,
+				Matchers: []*prompb.LabelMatcher{
+					{Type: prompb.LabelMatcher_EQ, Name: "foo", Value: "bar"},
+				},
+			},
+			result: []series{
+				{labels: labels.FromStrings(model.MetricNameLabelName, "a", "foo", "bar"), chunks: 1, samples: 1},
+				{labels: labels.FromStrings(model.MetricNameLabelName, "b", "foo", "bar"), chunks: 1, samples: 1},
+			},
+			sqlQueries: []model.SqlQuery{
+				{
+					Sql: "SELECT m.table_schema, m.metric_name, array_agg(s.id)\n\t" +
+						"FROM _prom_catalog.series s\n\t" +
+						"INNER JOIN _prom_catalog.metric m\n\t" +
+						"ON (m.id = s.metric_id)\n\t" +
+						"WHERE labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = $1 and l.value = $2)\n\t" +
+						"GROUP BY m.metric_name, m.table_schema\n\t" +
+						"ORDER BY m.metric_name, m.table_schema",
+					Args:    []interface{}{"foo", "bar"},
+					Results: model.RowResults{{"prom_data", "a", []int64{1}}, {"prom_data", "b", []int64{2}}},
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT id, table_schema, table_name, series_table FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)",
+					Args:    []interface{}{"prom_data", "a"},
+					Results: model.RowResults{{int64(1), "prom_data", "a", "a"}},
+					Err:     error(nil),
+				},
+				{
+					Sql:     "SELECT id, table_schema, table_name, series_table FROM _prom_catalog.get_metric_table_name_if_exists($1, $2)",
+					Args:    []interface{}{"prom_data", "b"},
+					Results: model.RowResults{{int64(2), "prom_data", "b", "b"}},
+					Err:     error(nil),
+				},
+				{
+					Sql: `SELECT series.* FROM ((SELECT s.labels, array_agg(m.time ORDER BY time), array_agg(m.value ORDER BY time)
+					FROM "prom_data"."a" m INNER JOIN "prom_data_series"."a" s ON m.series_id = s.id
+					WHERE m.series_id IN (1) AND time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z'
+					GROUP BY s.id) UNION ALL (SELECT s.labels, array_agg(m.time ORDER BY time), array_agg(m.value ORDER BY time)
+					FROM "prom_data"."b" m INNER JOIN "prom_data_series"."b" s ON m.series_id = s.id
+					WHERE m.series_id IN (2) AND time >= '1970-01-01T00:00:01Z' AND time <= '1970-01-01T00:00:02Z'
+					GROUP BY s.id))
+					AS series ORDER BY (
+						SELECT array_agg(kv.v ORDER BY lbl.key COLLATE "C", kv.n)
+						FROM ( SELECT l.key, l.value FROM _prom_catalog.label l WHERE l.id = ANY(series.labels)
+						UNION ALL SELECT * FROM unnest($1::text[], $2::text[]) ) AS lbl(key, value),
+						unnest(ARRAY[lbl.key, lbl.value]) WITH ORDINALITY AS kv(v, n)
+					) COLLATE "C"`,
+					Args: []interface{}{[]string{}, []string{}},
+					Results: model.RowResults{
+						{[]int64{5, 7}, []time.Time{time.Unix(0, 0)}, []float64{1}},
+						{[]int64{6, 7}, []time.Time{time.Unix(0, 0)}, []float64{1}},
+					},
+					Err: error(nil),
+				},
+				{
+					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
+					Args:          []interface{}{[]int64{5, 7}},
+					ArgsUnordered: true,
+					Results:       model.RowResults{{[]int64{5, 7}, []string{"__name__", "foo"}, []string{"a", "bar"}}},
+					Err:           error(nil),
+				},
+				{
+					Sql:           "SELECT (prom_api.labels_info($1::int[])).*",
+					Args:          []interface{}{[]int64{6}},
+					ArgsUnordered: true,
+					Results:       model.RowResults{{[]int64{6}, []string{"__name__"}, []string{"b"}}},
+					Err:           error(nil),
+				},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			mock := model.NewSqlRecorder(c.sqlQueries, t)
+			mockMetrics := &model.MockMetricCache{
+				MetricCache: make(map[string]model.MetricInfo),
+			}
+			querier := pgxQuerier{&queryTools{conn: mock, metricTableNames: mockMetrics, labelsReader: lreader.NewLabelsReader(mock, clockcache.WithMax(100), tenancy.NewNoopAuthorizer().ReadAuthorizer())}}
+
+			ss, err := querier.RemoteReadQuerier(context.Background()).QueryChunks(c.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer ss.Close()
+
+			var result []series
+			for ss.Next() {
+				s := series{labels: ss.At().Labels()}
+				it := ss.At().Iterator()
+				for it.Next() {
+					s.chunks++
+					if it.At().Chunk.Encoding() != chunkenc.EncXOR {
+						t.Errorf("unexpected chunk encoding: %s", it.At().Chunk.Encoding())
+					}
+					s.samples += it.At().Chunk.NumSamples()
+				}
+				if it.Err() != nil {
+					t.Fatalf("unexpected iterator error: %s", it.Err())
+				}
+				result = append(result, s)
+			}
+			if ss.Err() != nil {
+				t.Fatalf("unexpected error: %s", ss.Err())
+			}
+			if !reflect.DeepEqual(result, c.result) {
+				t.Errorf("unexpected result:\ngot\n%+v\nwanted\n%+v", result, c.result)
+			}
+		})
+	}
+}
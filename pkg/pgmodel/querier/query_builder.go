@@ -5,6 +5,7 @@
 package querier
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"sort"
@@ -12,9 +13,12 @@ import (
 
 	"github.com/blang/semver/v4"
 	"github.com/grafana/regexp"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/log"
@@ -206,16 +210,6 @@ func buildTimeSeries(rows []sampleRow, lr lreader.LabelsReader) ([]*prompb.TimeS
 	return results, nil
 }
 
-func hasSubquery(path []parser.Node) bool {
-	for _, node := range path {
-		switch node.(type) {
-		case *parser.SubqueryExpr:
-			return true
-		}
-	}
-	return false
-}
-
 // calledByTimestamp returns whether the immediate parent node is the
 // `timestamp` function call.
 func calledByTimestamp(path []parser.Node) bool {
@@ -243,6 +237,10 @@ type aggregators struct {
 	valueParams []interface{}
 	unOrdered   bool
 	tsSeries    TimestampSeries //can be NULL and only present if timeClause == ""
+	// scanStart and scanEnd narrow the scanned time range to the samples a
+	// pushdown reads. They are empty if the select hints are used as is.
+	scanStart string
+	scanEnd   string
 }
 
 // getAggregators returns the aggregator which should be used to fetch data for
@@ -295,33 +293,33 @@ func tryPushDown(metadata *promqlMetadata) (*aggregators, parser.Node, error) {
 	// We can't push down without hints.
 	case queryHints == nil || selectHints == nil:
 		return nil, nil, nil
-	// We can't handle subqueries in pushdowns.
-	case hasSubquery(path):
+	// We can't push down without knowing the steps the node is evaluated at.
+	case queryHints.EvalWindow == nil:
 		return nil, nil, nil
 	}
 
-	vs, isVectorSelector := queryHints.CurrentNode.(*parser.VectorSelector)
-
-	switch {
 	// We can't push down something that isn't a VectorSelector.
-	case !isVectorSelector:
-		return nil, nil, nil
-	// We can't handle offsets in VectorSelector pushdowns.
-	case vs.OriginalOffset != 0 || vs.Offset != 0:
+	if _, isVectorSelector := queryHints.CurrentNode.(*parser.VectorSelector); !isVectorSelector {
 		return nil, nil, nil
 	}
 
+	window := queryHints.EvalWindow
+	if queryHints.OverTimePushdown {
+		if agg, node := buildOverTimeAggregator(selectHints, window, path); agg != nil {
+			return agg, node, nil
+		}
+	}
 	if len(path) >= 2 {
 		grandparent := path[len(path)-2]
 		funcName, canPushDown := tryExtractPushdownableFunctionName(grandparent)
 		if canPushDown {
-			agg, err := buildPromQlFunctionCallAggregator(selectHints, funcName)
+			agg, err := buildPromQlFunctionCallAggregator(selectHints, window, funcName)
 			return agg, grandparent, err
 		}
 	}
 
 	lookback := queryHints.Lookback.Milliseconds()
-	agg := buildVectorSelectorFunctionCallAggregator(lookback, selectHints, path)
+	agg := buildVectorSelectorFunctionCallAggregator(lookback, selectHints, window, path)
 	if agg != nil {
 		return agg, queryHints.CurrentNode, nil
 	}
@@ -343,20 +341,21 @@ func tryExtractPushdownableFunctionName(node parser.Node) (string, bool) {
 	return "", false
 }
 
-func buildPromQlFunctionCallAggregator(selectHints *storage.SelectHints, funcName string) (*aggregators, error) {
-	// Note: selectHints.Start = results.start - lookback, i.e. it has been
-	// adjusted to account for the time from which we start _scanning_ for
-	// results. The time range that results will lie in is:
-	// [resultStart, selectHints.end].
-	resultStart := selectHints.Start + selectHints.Range
+func buildPromQlFunctionCallAggregator(selectHints *storage.SelectHints, window *EvalWindow, funcName string) (*aggregators, error) {
+	// The evaluation window holds the steps the engine evaluates the function
+	// at. The samples for those steps are read window.Offset earlier, which
+	// gives the time range that results will lie in: [resultStart, resultEnd].
+	resultStart := window.Start - window.Offset
+	resultEnd := window.End - window.Offset
+	scanStart := resultStart - selectHints.Range
 
 	stepDuration := time.Second
 	rangeDuration := time.Duration(selectHints.Range) * time.Millisecond
 
 	switch {
-	case selectHints.Step > 0:
-		stepDuration = time.Duration(selectHints.Step) * time.Millisecond
-	case selectHints.Step == 0 && resultStart != selectHints.End:
+	case window.Step > 0:
+		stepDuration = time.Duration(window.Step) * time.Millisecond
+	case window.Step == 0 && window.Start != window.End:
 		return nil, fmt.Errorf("query start should equal query end")
 	}
 
@@ -368,19 +367,73 @@ func buildPromQlFunctionCallAggregator(selectHints *storage.SelectHints, funcNam
 	// FROM ...
 	// WHERE t >= scan_start AND t <= result_end
 	//
-	// Note: The actual WHERE clause parameters are set in
-	// buildSingleMetricSamplesQuery
+	// The results are returned at the evaluation steps rather than at the
+	// sample times, so that offsets and the @ modifier are applied.
+	//
+	// As in a Prometheus range selection, the staleness markers are not
+	// samples of the range. The markers of the metrics stored with an
+	// alternate encoding are NULL and are skipped by the aggregate.
 
 	qf := aggregators{
-		valueClause: "_prom_ext.prom_" + funcName + "($%d, $%d, $%d, $%d, time, value)",
-		valueParams: []interface{}{model.Time(selectHints.Start).Time(), model.Time(selectHints.End).Time(), stepDuration.Milliseconds(), rangeDuration.Milliseconds()},
+		valueClause: "_prom_ext.prom_" + funcName + "($%d, $%d, $%d, $%d, time, value) FILTER (WHERE NOT prom_api.is_stale_marker(value))",
+		valueParams: []interface{}{model.Time(scanStart).Time(), model.Time(resultEnd).Time(), stepDuration.Milliseconds(), rangeDuration.Milliseconds()},
 		unOrdered:   false,
-		tsSeries:    newRegularTimestampSeries(model.Time(resultStart).Time(), model.Time(selectHints.End).Time(), stepDuration),
+		tsSeries:    newRegularTimestampSeries(model.Time(window.Start).Time(), model.Time(window.End).Time(), stepDuration),
+		scanStart:   toRFC3339Nano(scanStart),
+		scanEnd:     toRFC3339Nano(resultEnd),
 	}
 	return &qf, nil
 }
 
-func buildVectorSelectorFunctionCallAggregator(lookback int64, selectHints *storage.SelectHints, path []parser.Node) *aggregators {
+// overTimeAggregates are the SQL aggregates computing the *_over_time
+// functions over the samples of a range. %[1]s is the filter skipping the
+// staleness markers. As in PromQL, an empty range has no result, NaNs are
+// ignored by min and max unless all the samples are NaN, and they make the
+// sum and the average NaN.
+var overTimeAggregates = map[string]string{
+	"avg_over_time":   "avg(value) %[1]s",
+	"count_over_time": "NULLIF(count(value) %[1]s, 0)::double precision",
+	"max_over_time":   "coalesce(max(value) FILTER (WHERE value <> 'NaN' AND NOT prom_api.is_stale_marker(value)), max(value) %[1]s)",
+	"min_over_time":   "min(value) %[1]s",
+	"sum_over_time":   "sum(value) %[1]s",
+}
+
+// buildOverTimeAggregator pushes a *_over_time function of an instant query
+// down to a plain SQL aggregate, which only returns one value per series
+// instead of all the samples of the range. Range queries evaluate the
+// function over overlapping ranges and are not pushed down.
+func buildOverTimeAggregator(selectHints *storage.SelectHints, window *EvalWindow, path []parser.Node) (*aggregators, parser.Node) {
+	if len(path) < 2 || window.Step != 0 || window.Start != window.End {
+		return nil, nil
+	}
+	if _, isMatrix := path[len(path)-1].(*parser.MatrixSelector); !isMatrix {
+		return nil, nil
+	}
+	call, isCall := path[len(path)-2].(*parser.Call)
+	if !isCall {
+		return nil, nil
+	}
+	aggregate, ok := overTimeAggregates[call.Func.Name]
+	if !ok {
+		return nil, nil
+	}
+
+	// As in a Prometheus range selection, the range is [t - range, t] where
+	// t is the evaluation time shifted by the offset, and the result is
+	// returned at the evaluation time.
+	resultEnd := window.End - window.Offset
+	scanStart := resultEnd - selectHints.Range
+	qf := aggregators{
+		valueClause: "ARRAY[" + fmt.Sprintf(aggregate, "FILTER (WHERE NOT prom_api.is_stale_marker(value))") + "]",
+		unOrdered:   true,
+		tsSeries:    newRegularTimestampSeries(model.Time(window.Start).Time(), model.Time(window.End).Time(), time.Second),
+		scanStart:   toRFC3339Nano(scanStart),
+		scanEnd:     toRFC3339Nano(resultEnd),
+	}
+	return &qf, call
+}
+
+func buildVectorSelectorFunctionCallAggregator(lookback int64, selectHints *storage.SelectHints, window *EvalWindow, path []parser.Node) *aggregators {
 	// vector selector pushdown improves performance by selecting from the
 	// database only the last point in a vector selector window (step).
 	// This decreases the number of samples transferred from the DB to
@@ -392,7 +445,7 @@ func buildVectorSelectorFunctionCallAggregator(lookback int64, selectHints *stor
 	switch {
 	// We can't handle a zero-sized step, so skip pushdown optimization.
 	// TODO: handle the instant query (hints.Step==0) case too.
-	case selectHints.Step == 0:
+	case window.Step == 0:
 		return nil
 	// The `vector_selector` can only be applied to non-aggregates (i.e. when range is zero).
 	case selectHints.Range != 0:
@@ -414,20 +467,54 @@ func buildVectorSelectorFunctionCallAggregator(lookback int64, selectHints *stor
 	// FROM ...
 	// WHERE t >= scan_start AND t <= result_end
 	//
-	// Note: The actual WHERE clause parameters are set in
-	// buildSingleMetricSamplesQuery
-
-	resultStart := selectHints.Start + lookback
-	resultEnd := selectHints.End
+	// As with function pushdowns, result_start and result_end are the
+	// evaluation steps shifted by the offset, and the results are returned
+	// at the evaluation steps.
+	//
+	// A staleness marker must be the last sample of a step for the engine
+	// to drop the series at that step instead of returning the previous
+	// value for the whole lookback. The markers of the metrics stored with
+	// an alternate encoding are NULL, which the aggregate would skip, so
+	// they are turned back into markers. SQL has no literal for a marker,
+	// it is a parameter, see staleMarker.
+
+	resultStart := window.Start - window.Offset
+	resultEnd := window.End - window.Offset
 	qf := aggregators{
-		valueClause: "_prom_ext.vector_selector($%d, $%d, $%d, $%d, time, value)",
-		valueParams: []interface{}{model.Time(resultStart).Time(), model.Time(resultEnd).Time(), selectHints.Step, lookback},
+		valueClause: "_prom_ext.vector_selector($%d, $%d, $%d, $%d, time, coalesce(value::double precision, $%d))",
+		valueParams: []interface{}{model.Time(resultStart).Time(), model.Time(resultEnd).Time(), window.Step, lookback, staleMarker{}},
 		unOrdered:   true,
-		tsSeries:    newRegularTimestampSeries(model.Time(resultStart).Time(), model.Time(resultEnd).Time(), time.Duration(selectHints.Step)*time.Millisecond),
+		tsSeries:    newRegularTimestampSeries(model.Time(window.Start).Time(), model.Time(window.End).Time(), time.Duration(window.Step)*time.Millisecond),
+		scanStart:   toRFC3339Nano(resultStart - lookback),
+		scanEnd:     toRFC3339Nano(resultEnd),
 	}
 	return &qf
 }
 
+// staleMarker is the staleness marker as a query parameter. It is equal to
+// itself, unlike the NaN of the marker, and is sent as the bits of the marker
+// since the text format of a NaN has no payload.
+type staleMarker struct{}
+
+// EncodeBinary implements pgtype.BinaryEncoder.
+func (staleMarker) EncodeBinary(_ *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value.StaleNaN)
+	return append(buf, b[:]...), nil
+}
+
+// queryArgs returns the arguments of a query with the given parameters. The
+// simple protocol sends the parameters as text, the queries with a staleness
+// marker are sent with the extended protocol instead.
+func queryArgs(values []interface{}) []interface{} {
+	for _, v := range values {
+		if _, ok := v.(staleMarker); ok {
+			return append([]interface{}{pgx.QuerySimpleProtocol(false)}, values...)
+		}
+	}
+	return values
+}
+
 // anchorValue adds anchors to values in regexps since PromQL docs
 // states that "Regex-matches are fully anchored."
 func anchorValue(str string) string {
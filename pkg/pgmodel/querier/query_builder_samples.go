@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
@@ -62,6 +63,9 @@ const (
 			) as result  ON (result.time_array is not null)
 			WHERE
 				labels && (SELECT COALESCE(array_agg(l.id), array[]::int[]) FROM _prom_catalog.label l WHERE l.key = 'job' and l.value = 'demo');
+
+	The bounds of the time range are parameters of the single metric queries, like the values of the matchers. The SQL of
+	a selector is then the same at every refresh of a dashboard, which reuses its prepared statement.
 	*/
 	timeseriesByMetricSQLFormat = `SELECT series.labels, %[7]s
 	FROM %[2]s series
@@ -72,8 +76,8 @@ const (
 			SELECT time, %[9]s as value
 			FROM %[1]s metric
 			WHERE metric.series_id = series.id
-			AND time >= '%[4]s'
-			AND time <= '%[5]s'
+			AND time >= $%[4]d
+			AND time <= $%[5]d%[10]s
 			%[8]s
 		) as time_ordered_rows
 	) as result ON (result.value_array is not null)
@@ -91,19 +95,150 @@ const (
 			SELECT series_id, time, %[9]s as value
 			FROM %[1]s metric
 			WHERE
-			time >= '%[4]s'
-			AND time <= '%[5]s'
+			time >= $%[4]d
+			AND time <= $%[5]d%[10]s
 			%[8]s
 		) as time_ordered_rows
 		GROUP BY series_id
 	) as result ON (result.value_array is not null AND result.series_id = series.id)`
 
+	/* SERIES ONLY PATH */
+	/* The series endpoint and the label queries with matchers only need the labels of the series with samples in the
+	* time range. The samples are not fetched: the EXISTS is answered by the (series_id, time) index of the metric table
+	* without reading the values, and the empty arrays keep the shape of the samples rows. */
+	seriesByMetricSQLFormat = `SELECT series.labels, '{}'::timestamptz[], '{}'::double precision[]
+	FROM %[2]s series
+	WHERE %[3]s
+	AND EXISTS (
+		SELECT 1
+		FROM %[1]s metric
+		WHERE metric.series_id = series.id
+		AND time >= $%[4]d
+		AND time <= $%[5]d%[6]s
+	)`
+
+	seriesBySeriesIDsSQLFormat = `SELECT s.labels, '{}'::timestamptz[], '{}'::double precision[]
+	FROM %[2]s s
+	WHERE s.id IN (%[3]s)
+	AND EXISTS (
+		SELECT 1
+		FROM %[1]s m
+		WHERE m.series_id = s.id
+		AND time >= '%[4]s'
+		AND time <= '%[5]s'
+	)`
+
+	/* LATEST SAMPLE PATH */
+	/* Federation only needs the latest sample of each series in the time range. It is read backwards from the
+	* (series_id, time) index of the metric table, one row per series, instead of aggregating all the samples. */
+	latestByMetricSQLFormat = `SELECT series.labels, ARRAY[result.time], ARRAY[result.value]
+	FROM %[2]s series
+	INNER JOIN LATERAL (
+		SELECT time, %[6]s as value
+		FROM %[1]s metric
+		WHERE metric.series_id = series.id
+		AND time >= $%[4]d
+		AND time <= $%[5]d%[7]s
+		ORDER BY time DESC
+		LIMIT 1
+	) as result ON TRUE
+	WHERE %[3]s`
+
+	latestBySeriesIDsSQLFormat = `SELECT s.labels, ARRAY[result.time], ARRAY[result.value]
+	FROM %[2]s s
+	INNER JOIN LATERAL (
+		SELECT time, value
+		FROM %[1]s m
+		WHERE m.series_id = s.id
+		AND time >= '%[4]s'
+		AND time <= '%[5]s'
+		ORDER BY time DESC
+		LIMIT 1
+	) as result ON TRUE
+	WHERE s.id IN (%[3]s)`
+
 	defaultColumnName = "value"
+
+	// seriesFunc is the function of the select hints of the selects that
+	// only need the labels of the series, as in Prometheus.
+	seriesFunc = "series"
+)
+
+// LatestFunc is the function of the select hints of the selects that only
+// need the latest sample of each series in the time range.
+const LatestFunc = "latest"
+
+// selectKind is what a select reads of the series.
+type selectKind int
+
+const (
+	selectSamples selectKind = iota
+	selectSeries
+	selectLatest
 )
 
+// selectKindOf returns what the select of the metadata reads of the series.
+func selectKindOf(metadata *evalMetadata) selectKind {
+	if metadata.promqlMetadata == nil || metadata.selectHints == nil {
+		return selectSamples
+	}
+	switch metadata.selectHints.Func {
+	case seriesFunc:
+		return selectSeries
+	case LatestFunc:
+		return selectLatest
+	}
+	return selectSamples
+}
+
+// buildSingleMetricSeriesQuery builds a SQL query which fetches the labels of
+// the series of one metric with samples in the time range, without their
+// samples.
+func buildSingleMetricSeriesQuery(metadata *evalMetadata, plans *PlanCache) (string, []interface{}) {
+	filter := metadata.timeFilter
+	start, end := filter.start, filter.end
+	if sh := metadata.selectHints; sh != nil {
+		start, end = toRFC3339Nano(sh.Start), toRFC3339Nano(sh.End)
+	}
+	values, startParam, endParam := appendTimeRange(metadata.values, start, end)
+	values, tenantClause := appendTenants(values, metadata)
+	sql := plans.sql(planKey(selectSeries, metadata, nil), func() string {
+		return fmt.Sprintf(seriesByMetricSQLFormat,
+			pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
+			pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
+			strings.Join(metadata.clauses, " AND "),
+			startParam,
+			endParam,
+			tenantClause,
+		)
+	})
+	return sql, values
+}
+
+// buildSingleMetricLatestQuery builds a SQL query which fetches the latest
+// sample in the time range of the series of one metric.
+func buildSingleMetricLatestQuery(metadata *evalMetadata, plans *PlanCache) (string, []interface{}) {
+	filter := metadata.timeFilter
+	sh := metadata.selectHints
+	values, startParam, endParam := appendTimeRange(metadata.values, toRFC3339Nano(sh.Start), toRFC3339Nano(sh.End))
+	values, tenantClause := appendTenants(values, metadata)
+	sql := plans.sql(planKey(selectLatest, metadata, nil), func() string {
+		return fmt.Sprintf(latestByMetricSQLFormat,
+			pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
+			pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
+			strings.Join(metadata.clauses, " AND "),
+			startParam,
+			endParam,
+			pgx.Identifier{filter.column}.Sanitize(),
+			tenantClause,
+		)
+	})
+	return sql, values
+}
+
 // buildSingleMetricSamplesQuery builds a SQL query which fetches the data for
 // one metric.
-func buildSingleMetricSamplesQuery(metadata *evalMetadata) (string, []interface{}, parser.Node, TimestampSeries, error) {
+func buildSingleMetricSamplesQuery(metadata *evalMetadata, plans *PlanCache) (string, []interface{}, parser.Node, TimestampSeries, error) {
 	// The basic structure of the SQL query which this function produces is:
 	//		SELECT
 	//		  series.labels
@@ -212,29 +347,71 @@ func buildSingleMetricSamplesQuery(metadata *evalMetadata) (string, []interface{
 	} else {
 		start, end = metadata.timeFilter.start, metadata.timeFilter.end
 	}
+	// Pushdowns inside subqueries only read samples for the subquery steps,
+	// which can be a narrower range than the selectHints.
+	if qf.scanStart != "" {
+		start, end = qf.scanStart, qf.scanEnd
+	}
 
-	finalSQL := fmt.Sprintf(template,
-		pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
-		pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
-		strings.Join(cases, " AND "),
-		start,
-		end,
-		strings.Join(selectorClauses, ", "),
-		strings.Join(selectors, ", "),
-		orderByClause,
-		pgx.Identifier{filter.column}.Sanitize(),
-	)
+	values, startParam, endParam := appendTimeRange(values, start, end)
+	values, tenantClause := appendTenants(values, metadata)
+	finalSQL := plans.sql(planKey(selectSamples, metadata, qf), func() string {
+		return fmt.Sprintf(template,
+			pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
+			pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
+			strings.Join(cases, " AND "),
+			startParam,
+			endParam,
+			strings.Join(selectorClauses, ", "),
+			strings.Join(selectors, ", "),
+			orderByClause,
+			pgx.Identifier{filter.column}.Sanitize(),
+			tenantClause,
+		)
+	})
 
 	return finalSQL, values, node, qf.tsSeries, nil
 }
 
-func buildMultipleMetricSamplesQuery(filter timeFilter, series []pgmodel.SeriesID) (string, error) {
+// appendTimeRange appends the bounds of the time range of a single metric
+// query to its values and returns their parameter numbers.
+func appendTimeRange(values []interface{}, start, end string) ([]interface{}, int, int) {
+	values = append(values[:len(values):len(values)], start, end)
+	return values, len(values) - 1, len(values)
+}
+
+// appendTenants appends the tenants of a single metric query partitioned by
+// tenant to its values, and returns the clause restricting its samples to
+// their partitions. The clause is empty if the query is not restricted to a
+// list of tenants.
+func appendTenants(values []interface{}, metadata *evalMetadata) ([]interface{}, string) {
+	if !prunesTenants(metadata) {
+		return values, ""
+	}
+	values = append(values[:len(values):len(values)], metadata.tenants)
+	return values, fmt.Sprintf(" AND metric.tenant = ANY($%d::text[])", len(values))
+}
+
+// prunesTenants returns true if the samples of a single metric query are
+// restricted to the partitions of its tenants.
+func prunesTenants(metadata *evalMetadata) bool {
+	return metadata.timeFilter.tenantPartitioned && metadata.tenants != nil
+}
+
+func buildMultipleMetricSamplesQuery(filter timeFilter, series []pgmodel.SeriesID, kind selectKind) (string, error) {
 	s := make([]string, len(series))
 	for i, sID := range series {
 		s[i] = fmt.Sprintf("%d", sID)
 	}
+	template := timeseriesBySeriesIDsSQLFormat
+	switch kind {
+	case selectSeries:
+		template = seriesBySeriesIDsSQLFormat
+	case selectLatest:
+		template = latestBySeriesIDsSQLFormat
+	}
 	return fmt.Sprintf(
-		timeseriesBySeriesIDsSQLFormat,
+		template,
 		pgx.Identifier{filter.schema, filter.metric}.Sanitize(),
 		pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
 		strings.Join(s, ","),
@@ -257,3 +434,50 @@ ORDER BY m.metric_name, m.table_schema`
 func buildMetricNameSeriesIDQuery(cases []string) string {
 	return fmt.Sprintf(metricNameSeriesIDSQLFormat, strings.Join(cases, " AND "))
 }
+
+/* SERIES LIMIT */
+/* A query limited in series counts the series matching a single metric selector before fetching their samples. The
+* count stops one past the limit, which is enough to know it is exceeded. */
+const seriesCountSQLFormat = `SELECT count(*) FROM (
+		SELECT 1 FROM %[1]s
+		WHERE %[2]s
+		LIMIT %[3]d
+	) AS matched`
+
+func buildSeriesCountQuery(filter timeFilter, cases []string, limit int64) string {
+	return fmt.Sprintf(seriesCountSQLFormat,
+		pgx.Identifier{schema.PromDataSeries, filter.seriesTable}.Sanitize(),
+		strings.Join(cases, " AND "),
+		limit+1,
+	)
+}
+
+/* STREAMED REMOTE READ PATH */
+/* The streamed remote read protocol requires the series of a query to be sorted by labels. Ordering them in the
+* database lets the connector send each series as soon as it is read instead of holding all of them. The labels are
+* flattened into an array of names and values sorted by name and compared in byte order, which is the order of
+* labels.Compare. The labels added by the connector, like __schema__, are the same for all the series of a query but
+* still change their order, so they are passed as parameters and ordered with the labels of the series. */
+const streamedSamplesSQLFormat = `SELECT series.* FROM (%[1]s) AS series
+	ORDER BY (
+		SELECT array_agg(kv.v ORDER BY lbl.key COLLATE "C", kv.n)
+		FROM (
+			SELECT l.key, l.value FROM _prom_catalog.label l WHERE l.id = ANY(series.labels)
+			UNION ALL
+			SELECT * FROM unnest($%[2]d::text[], $%[3]d::text[])
+		) AS lbl(key, value), unnest(ARRAY[lbl.key, lbl.value]) WITH ORDINALITY AS kv(v, n)
+	) COLLATE "C"`
+
+// buildStreamedSamplesQuery wraps a samples query returning one row per series
+// to order the series by their labels and the additional labels of the query.
+// The additional labels are passed after the values of the query.
+func buildStreamedSamplesQuery(sqlQuery string, values []interface{}, additional labels.Labels) (string, []interface{}) {
+	names := make([]string, 0, len(additional))
+	labelValues := make([]string, 0, len(additional))
+	for _, l := range additional {
+		names = append(names, l.Name)
+		labelValues = append(labelValues, l.Value)
+	}
+	values = append(values[:len(values):len(values)], names, labelValues)
+	return fmt.Sprintf(streamedSamplesSQLFormat, sqlQuery, len(values)-1, len(values)), values
+}
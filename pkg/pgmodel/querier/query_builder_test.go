@@ -0,0 +1,299 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/common/extension"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestTryPushDownEvalWindow(t *testing.T) {
+	oldVersion := extension.PromscaleExtensionVersion
+	extension.PromscaleExtensionVersion = semver.MustParse("0.5.0")
+	defer func() { extension.PromscaleExtensionVersion = oldVersion }()
+
+	testCases := []struct {
+		name        string
+		query       string
+		selectHints *storage.SelectHints
+		window      *EvalWindow
+		valueParams []interface{}
+		times       []int64
+		scanStart   int64
+	}{
+		{
+			name:        "negative offset",
+			query:       "foo offset -5s",
+			selectHints: &storage.SelectHints{Start: 10000, End: 25000, Step: 1000},
+			window:      &EvalWindow{Start: 10000, End: 12000, Step: 1000, Offset: -5000},
+			valueParams: []interface{}{model.Time(15000).Time(), model.Time(17000).Time(), int64(1000), int64(5000), staleMarker{}},
+			times:       []int64{10000, 11000, 12000},
+			scanStart:   10000,
+		},
+		{
+			name:        "@ modifier",
+			query:       "rate(foo[2s] @ 50)",
+			selectHints: &storage.SelectHints{Start: 48000, End: 50000, Step: 1000, Range: 2000},
+			window:      &EvalWindow{Start: 10000, End: 10000, Step: 1000, Offset: -40000},
+			valueParams: []interface{}{model.Time(48000).Time(), model.Time(50000).Time(), int64(1000), int64(2000)},
+			times:       []int64{10000},
+			scanStart:   48000,
+		},
+		{
+			name:        "subquery",
+			query:       "max_over_time(rate(foo[2s])[10s:3s])",
+			selectHints: &storage.SelectHints{Start: 88000, End: 110000, Step: 1000, Range: 2000},
+			window:      &EvalWindow{Start: 90000, End: 96000, Step: 3000},
+			valueParams: []interface{}{model.Time(88000).Time(), model.Time(96000).Time(), int64(3000), int64(2000)},
+			times:       []int64{90000, 93000, 96000},
+			scanStart:   88000,
+		},
+		{
+			name:        "unknown window",
+			query:       "max_over_time(foo[10s:3s] @ 50)",
+			selectHints: &storage.SelectHints{Start: 35000, End: 50000, Step: 1000},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(c.query)
+			require.NoError(t, err)
+
+			var (
+				vs   *parser.VectorSelector
+				path []parser.Node
+			)
+			parser.Inspect(expr, func(node parser.Node, p []parser.Node) error {
+				if n, ok := node.(*parser.VectorSelector); ok {
+					vs, path = n, append([]parser.Node{}, p...)
+				}
+				return nil
+			})
+
+			agg, _, err := tryPushDown(&promqlMetadata{
+				selectHints: c.selectHints,
+				queryHints:  &QueryHints{CurrentNode: vs, Lookback: 5 * time.Second, EvalWindow: c.window},
+				path:        path,
+			})
+			require.NoError(t, err)
+			if c.window == nil {
+				require.Nil(t, agg)
+				return
+			}
+
+			require.NotNil(t, agg)
+			require.Equal(t, c.valueParams, agg.valueParams)
+			require.Equal(t, toRFC3339Nano(c.scanStart), agg.scanStart)
+			require.Equal(t, len(c.times), agg.tsSeries.Len())
+			for i, ts := range c.times {
+				at, ok := agg.tsSeries.At(i)
+				require.True(t, ok)
+				require.Equal(t, ts, at)
+			}
+		})
+	}
+}
+
+func TestStaleMarkerParam(t *testing.T) {
+	buf, err := staleMarker{}.EncodeBinary(nil, nil)
+	require.NoError(t, err)
+	var f pgtype.Float8
+	require.NoError(t, f.DecodeBinary(nil, buf))
+	require.True(t, value.IsStaleNaN(f.Float))
+
+	// Only the queries with a marker are sent with the extended protocol.
+	values := []interface{}{int64(1000), staleMarker{}}
+	require.Equal(t, append([]interface{}{pgx.QuerySimpleProtocol(false)}, values...), queryArgs(values))
+	values = []interface{}{int64(1000), int64(2000)}
+	require.Equal(t, values, queryArgs(values))
+}
+
+func TestOverTimePushdown(t *testing.T) {
+	testCases := []struct {
+		name      string
+		query     string
+		window    *EvalWindow
+		disabled  bool
+		aggregate string
+		scanStart int64
+		scanEnd   int64
+	}{
+		{
+			name:      "instant",
+			query:     "sum_over_time(foo[1m])",
+			window:    &EvalWindow{Start: 100000, End: 100000},
+			aggregate: "ARRAY[sum(value) FILTER (WHERE NOT prom_api.is_stale_marker(value))]",
+			scanStart: 40000,
+			scanEnd:   100000,
+		},
+		{
+			name:      "offset",
+			query:     "count_over_time(foo[1m] offset 10s)",
+			window:    &EvalWindow{Start: 100000, End: 100000, Offset: 10000},
+			aggregate: "ARRAY[NULLIF(count(value) FILTER (WHERE NOT prom_api.is_stale_marker(value)), 0)::double precision]",
+			scanStart: 30000,
+			scanEnd:   90000,
+		},
+		{
+			name:     "disabled",
+			query:    "sum_over_time(foo[1m])",
+			window:   &EvalWindow{Start: 100000, End: 100000},
+			disabled: true,
+		},
+		{
+			name:   "range query",
+			query:  "sum_over_time(foo[1m])",
+			window: &EvalWindow{Start: 100000, End: 200000, Step: 10000},
+		},
+		{
+			name:   "subquery",
+			query:  "sum_over_time(foo[1m:10s])",
+			window: &EvalWindow{Start: 40000, End: 100000, Step: 10000},
+		},
+		{
+			name:   "not pushed down",
+			query:  "quantile_over_time(0.5, foo[1m])",
+			window: &EvalWindow{Start: 100000, End: 100000},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(c.query)
+			require.NoError(t, err)
+			var (
+				vs   *parser.VectorSelector
+				path []parser.Node
+			)
+			parser.Inspect(expr, func(node parser.Node, p []parser.Node) error {
+				if n, ok := node.(*parser.VectorSelector); ok {
+					vs, path = n, append([]parser.Node{}, p...)
+				}
+				return nil
+			})
+
+			agg, node, err := tryPushDown(&promqlMetadata{
+				selectHints: &storage.SelectHints{Start: c.window.Start - c.window.Offset - 60000, End: c.window.End - c.window.Offset, Range: 60000},
+				queryHints:  &QueryHints{CurrentNode: vs, EvalWindow: c.window, OverTimePushdown: !c.disabled},
+				path:        path,
+			})
+			require.NoError(t, err)
+			if c.aggregate == "" {
+				require.Nil(t, agg)
+				return
+			}
+
+			require.NotNil(t, agg)
+			require.Equal(t, path[len(path)-2], node)
+			require.Equal(t, c.aggregate, agg.valueClause)
+			require.True(t, agg.unOrdered)
+			require.Equal(t, toRFC3339Nano(c.scanStart), agg.scanStart)
+			require.Equal(t, toRFC3339Nano(c.scanEnd), agg.scanEnd)
+			require.Equal(t, 1, agg.tsSeries.Len())
+			at, _ := agg.tsSeries.At(0)
+			require.Equal(t, c.window.Start, at)
+		})
+	}
+}
+
+func TestBuildSeriesOnlyQueries(t *testing.T) {
+	metadata := &evalMetadata{
+		timeFilter: timeFilter{metric: "foo", schema: "prom_data", seriesTable: "foo", start: toRFC3339Nano(1000), end: toRFC3339Nano(2000)},
+		clauses:    []string{"labels @> $1"},
+		values:     []interface{}{[]int32{1}},
+		promqlMetadata: &promqlMetadata{
+			selectHints: &storage.SelectHints{Start: 1000, End: 2000, Func: seriesFunc},
+		},
+	}
+	require.Equal(t, selectSeries, selectKindOf(metadata))
+	require.Equal(t, selectSamples, selectKindOf(&evalMetadata{promqlMetadata: &promqlMetadata{selectHints: &storage.SelectHints{Func: "rate"}}}))
+
+	sql, values := buildSingleMetricSeriesQuery(metadata, nil)
+	require.Contains(t, sql, `FROM "prom_data_series"."foo" series`)
+	require.Contains(t, sql, "WHERE labels @> $1")
+	require.Contains(t, sql, `FROM "prom_data"."foo" metric`)
+	require.Contains(t, sql, "AND time >= $2\n\t\tAND time <= $3")
+	require.NotContains(t, sql, "value")
+	require.Equal(t, []interface{}{[]int32{1}, toRFC3339Nano(1000), toRFC3339Nano(2000)}, values)
+
+	sql, err := buildMultipleMetricSamplesQuery(metadata.timeFilter, []pgmodel.SeriesID{1, 2}, selectSeries)
+	require.NoError(t, err)
+	require.Contains(t, sql, "WHERE s.id IN (1,2)")
+	require.NotContains(t, sql, "value")
+}
+
+func TestBuildLatestQueries(t *testing.T) {
+	metadata := &evalMetadata{
+		timeFilter: timeFilter{metric: "foo", schema: "prom_data", seriesTable: "foo", column: "value", start: toRFC3339Nano(1000), end: toRFC3339Nano(2000)},
+		clauses:    []string{"labels @> $1"},
+		values:     []interface{}{[]int32{1}},
+		promqlMetadata: &promqlMetadata{
+			selectHints: &storage.SelectHints{Start: 1000, End: 2000, Func: LatestFunc},
+		},
+	}
+	require.Equal(t, selectLatest, selectKindOf(metadata))
+
+	sql, values := buildSingleMetricLatestQuery(metadata, nil)
+	require.Contains(t, sql, `FROM "prom_data_series"."foo" series`)
+	require.Contains(t, sql, "WHERE labels @> $1")
+	require.Contains(t, sql, "ORDER BY time DESC\n\t\tLIMIT 1")
+	require.Equal(t, []interface{}{[]int32{1}, toRFC3339Nano(1000), toRFC3339Nano(2000)}, values)
+
+	sql, err := buildMultipleMetricSamplesQuery(metadata.timeFilter, []pgmodel.SeriesID{1, 2}, selectLatest)
+	require.NoError(t, err)
+	require.Contains(t, sql, "WHERE s.id IN (1,2)")
+	require.Contains(t, sql, "LIMIT 1")
+}
+
+func TestBuildTenantPartitionedQueries(t *testing.T) {
+	metadata := &evalMetadata{
+		timeFilter: timeFilter{metric: "foo", schema: "prom_data", seriesTable: "foo", column: "value", tenantPartitioned: true},
+		clauses:    []string{"labels @> $1"},
+		values:     []interface{}{[]int32{1}},
+		tenants:    []string{"tenant-a", "tenant-b"},
+		promqlMetadata: &promqlMetadata{
+			selectHints: &storage.SelectHints{Start: 1000, End: 2000},
+		},
+	}
+	expected := []interface{}{[]int32{1}, toRFC3339Nano(1000), toRFC3339Nano(2000), []string{"tenant-a", "tenant-b"}}
+
+	sql, values, _, _, err := buildSingleMetricSamplesQuery(metadata, nil)
+	require.NoError(t, err)
+	require.Contains(t, sql, "AND time <= $3 AND metric.tenant = ANY($4::text[])")
+	require.Equal(t, expected, values)
+
+	metadata.selectHints.Func = seriesFunc
+	sql, values = buildSingleMetricSeriesQuery(metadata, nil)
+	require.Contains(t, sql, "AND time <= $3 AND metric.tenant = ANY($4::text[])")
+	require.Equal(t, expected, values)
+
+	metadata.selectHints.Func = LatestFunc
+	sql, values = buildSingleMetricLatestQuery(metadata, nil)
+	require.Contains(t, sql, "AND time <= $3 AND metric.tenant = ANY($4::text[])")
+	require.Equal(t, expected, values)
+
+	// The partitions are not pruned without a list of tenants, or if the
+	// metric is not partitioned.
+	metadata.tenants = nil
+	sql, values = buildSingleMetricLatestQuery(metadata, nil)
+	require.NotContains(t, sql, "tenant")
+	require.Len(t, values, 3)
+	metadata.tenants = []string{"tenant-a"}
+	metadata.timeFilter.tenantPartitioned = false
+	sql, _ = buildSingleMetricLatestQuery(metadata, nil)
+	require.NotContains(t, sql, "tenant")
+}
@@ -39,3 +39,21 @@ func (q *queryRemoteRead) Query(query *prompb.Query) ([]*prompb.TimeSeries, erro
 	}
 	return results, nil
 }
+
+// QueryChunks implements the RemoteReadQuerier interface. It is the entrypoint
+// for streamed remote read queries. Unlike Query, the series are read from the
+// database one at a time, ordered by labels, and each series is encoded into
+// chunks only when it is iterated over.
+func (q *queryRemoteRead) QueryChunks(query *prompb.Query) (ChunkSeriesSet, error) {
+	if query == nil {
+		return &streamedChunkSeriesSet{}, nil
+	}
+
+	matchers, err := fromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	qrySamples := newQuerySamples(q.ctx, q.pgxQuerier)
+	return qrySamples.streamSamples(query.StartTimestampMs, query.EndTimestampMs, matchers)
+}
@@ -3,6 +3,8 @@ package querier
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
@@ -11,6 +13,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/querylog"
 )
 
 type querySamples struct {
@@ -34,6 +37,24 @@ func (q *querySamples) Select(mint, maxt int64, _ bool, hints *storage.SelectHin
 }
 
 func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms []*labels.Matcher) ([]sampleRow, parser.Node, error) {
+	defer func(start time.Time) {
+		q.tools.indexAdvisor.ObserveQuery(ms, time.Since(start))
+	}(time.Now())
+
+	stats := querylog.FromContext(q.ctx)
+	stats.AddSelect(mint, maxt, ms)
+	sampleRows, topNode, err := q.fetchSamplesRowsWithStats(mint, maxt, hints, qh, path, ms, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats.AddRows(int64(len(sampleRows)), countSamples(sampleRows))
+	return sampleRows, topNode, nil
+}
+
+// fetchSamplesRowsWithStats fetches the rows of a select, recording the time
+// spent generating the SQL and running it in the stats.
+func (q *querySamples) fetchSamplesRowsWithStats(mint, maxt int64, hints *storage.SelectHints, qh *QueryHints, path []parser.Node, ms []*labels.Matcher, stats *querylog.Stats) ([]sampleRow, parser.Node, error) {
+	generationStart := time.Now()
 	metadata, err := getEvaluationMetadata(q.tools, mint, maxt, GetPromQLMetadata(ms, hints, qh, path))
 	if err != nil {
 		return nil, nil, fmt.Errorf("get evaluation metadata: %w", err)
@@ -52,6 +73,8 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 		metadata.timeFilter.metric = mInfo.TableName
 		metadata.timeFilter.schema = mInfo.TableSchema
 		metadata.timeFilter.seriesTable = mInfo.SeriesTable
+		metadata.timeFilter.tenantPartitioned = mInfo.TenantPartitioned
+		stats.AddSQLGeneration(time.Since(generationStart))
 
 		sampleRows, topNode, err := fetchSingleMetricSamples(q.ctx, q.tools, metadata)
 		if err != nil {
@@ -60,6 +83,7 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 
 		return sampleRows, topNode, nil
 	}
+	stats.AddSQLGeneration(time.Since(generationStart))
 	// Multiple vector selector case.
 	sampleRows, err := fetchMultipleMetricsSamples(q.ctx, q.tools, metadata)
 	if err != nil {
@@ -74,27 +98,37 @@ func (q *querySamples) fetchSamplesRows(mint, maxt int64, hints *storage.SelectH
 // successfully applied, the new top node is returned together with the metric
 // rows. For more information about top nodes, see `engine.populateSeries`.
 func fetchSingleMetricSamples(ctx context.Context, tools *queryTools, metadata *evalMetadata) ([]sampleRow, parser.Node, error) {
-	sqlQuery, values, topNode, tsSeries, err := buildSingleMetricSamplesQuery(metadata)
-	if err != nil {
+	stats := querylog.FromContext(ctx)
+	if err := checkSingleMetricSeries(ctx, tools, metadata); err != nil {
 		return nil, nil, err
 	}
+	generationStart := time.Now()
+	var (
+		sqlQuery string
+		values   []interface{}
+		topNode  parser.Node
+		tsSeries TimestampSeries
+		err      error
+	)
+	switch selectKindOf(metadata) {
+	case selectSeries:
+		sqlQuery, values = buildSingleMetricSeriesQuery(metadata, tools.planCache)
+	case selectLatest:
+		sqlQuery, values = buildSingleMetricLatestQuery(metadata, tools.planCache)
+	default:
+		sqlQuery, values, topNode, tsSeries, err = buildSingleMetricSamplesQuery(metadata, tools.planCache)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	stats.AddSQLGeneration(time.Since(generationStart))
 
-	rows, err := tools.conn.Query(ctx, sqlQuery, values...)
+	defer func(start time.Time) {
+		stats.AddDBExecution(time.Since(start))
+	}(time.Now())
+	rows, err := tools.conn.Query(ctx, sqlQuery, queryArgs(values)...)
 	if err != nil {
-		if e, ok := err.(*pgconn.PgError); ok {
-			switch e.Code {
-			case pgerrcode.UndefinedTable:
-				// If we are getting undefined table error, it means the metric we are trying to query
-				// existed at some point but the underlying relation was removed from outside of the system.
-				return nil, nil, fmt.Errorf(errors.ErrTmplMissingUnderlyingRelation, metadata.timeFilter.schema, metadata.timeFilter.metric)
-			case pgerrcode.UndefinedColumn:
-				// If we are getting undefined column error, it means the column we are trying to query
-				// does not exist in the metric table so we return empty results.
-				// Empty result is more consistent and in-line with PromQL assumption of a missing series based on matchers.
-				return nil, nil, nil
-			}
-		}
-		return nil, nil, err
+		return nil, nil, singleMetricQueryError(err, metadata)
 	}
 	defer rows.Close()
 
@@ -106,28 +140,121 @@ func fetchSingleMetricSamples(ctx context.Context, tools *queryTools, metadata *
 	}
 
 	filter := metadata.timeFilter
-	samplesRows, err := appendSampleRows(make([]sampleRow, 0, 1), rows, tsSeries, updatedMetricName, filter.schema, filter.column)
+	samplesRows, err := appendSampleRows(make([]sampleRow, 0, 1), rows, tsSeries, updatedMetricName, filter.schema, filter.column, limitsFromContext(ctx), QueryMemoryFromContext(ctx))
 	if err != nil {
 		return nil, topNode, fmt.Errorf("appending sample rows: %w", err)
 	}
 	return samplesRows, topNode, nil
 }
 
+// checkSingleMetricSeries counts the series matching a single metric selector
+// if the query is limited in series, so that a query exceeding the limit fails
+// before fetching any sample.
+func checkSingleMetricSeries(ctx context.Context, tools *queryTools, metadata *evalMetadata) error {
+	limits := limitsFromContext(ctx)
+	if limits == nil || limits.limits.MaxSeries == 0 {
+		return nil
+	}
+	var count int64
+	sqlQuery := buildSeriesCountQuery(metadata.timeFilter, metadata.clauses, limits.limits.MaxSeries)
+	if err := tools.conn.QueryRow(ctx, sqlQuery, metadata.values...).Scan(&count); err != nil {
+		return singleMetricQueryError(err, metadata)
+	}
+	return limits.checkSeries(count)
+}
+
+// singleMetricQueryError converts the error of a single metric samples query.
+// It returns nil if the query has no results.
+func singleMetricQueryError(err error, metadata *evalMetadata) error {
+	if e, ok := err.(*pgconn.PgError); ok {
+		switch e.Code {
+		case pgerrcode.UndefinedTable:
+			// If we are getting undefined table error, it means the metric we are trying to query
+			// existed at some point but the underlying relation was removed from outside of the system.
+			return fmt.Errorf(errors.ErrTmplMissingUnderlyingRelation, metadata.timeFilter.schema, metadata.timeFilter.metric)
+		case pgerrcode.UndefinedColumn:
+			// If we are getting undefined column error, it means the column we are trying to query
+			// does not exist in the metric table so we return empty results.
+			// Empty result is more consistent and in-line with PromQL assumption of a missing series based on matchers.
+			return nil
+		}
+	}
+	return err
+}
+
 // fetchMultipleMetricsSamples returns all the result rows for across multiple
 // metrics using the supplied query parameters.
 func fetchMultipleMetricsSamples(ctx context.Context, tools *queryTools, metadata *evalMetadata) ([]sampleRow, error) {
-	// First fetch series IDs per metric.
-	metrics, schemas, series, err := GetMetricNameSeriesIds(ctx, tools.conn, metadata)
+	stats := querylog.FromContext(ctx)
+	queries, err := buildMultipleMetricsSamplesQueries(ctx, tools, metadata)
 	if err != nil {
 		return nil, err
 	}
 
 	// TODO this assume on average on row per-metric. Is this right?
-	results := make([]sampleRow, 0, len(metrics))
-	numQueries := 0
+	results := make([]sampleRow, 0, len(queries))
+	if len(queries) == 0 {
+		return results, nil
+	}
+	limits, memory := limitsFromContext(ctx), QueryMemoryFromContext(ctx)
 	batch := tools.conn.NewBatch()
+	// Send the queries of all the metrics in a single batch.
+	for _, sqlQuery := range queries {
+		batch.Queue(sqlQuery)
+	}
+
+	defer func(start time.Time) {
+		stats.AddDBExecution(time.Since(start))
+	}(time.Now())
+	batchResults, err := tools.conn.SendBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer batchResults.Close()
+
+	for range queries {
+		rows, err := batchResults.Query()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// Append all rows into results.
+		results, err = appendSampleRows(results, rows, nil, "", "", "", limits, memory)
+		rows.Close()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// buildMultipleMetricsSamplesQueries fetches the series IDs of each metric
+// matching the query and returns a samples query per metric.
+func buildMultipleMetricsSamplesQueries(ctx context.Context, tools *queryTools, metadata *evalMetadata) ([]string, error) {
+	stats := querylog.FromContext(ctx)
+	// First fetch series IDs per metric.
+	dbStart := time.Now()
+	metrics, schemas, series, err := GetMetricNameSeriesIds(ctx, tools.conn, metadata)
+	stats.AddDBExecution(time.Since(dbStart))
+	if err != nil {
+		return nil, err
+	}
+	numSeries := 0
+	for _, ids := range series {
+		numSeries += len(ids)
+	}
+	if err = limitsFromContext(ctx).checkSeries(int64(numSeries)); err != nil {
+		return nil, err
+	}
+	generationStart := time.Now()
+	defer func() {
+		stats.AddSQLGeneration(time.Since(generationStart))
+	}()
 
-	// Generate queries for each metric and send them in a single batch.
+	queries := make([]string, 0, len(metrics))
+	// Generate queries for each metric.
 	for i := range metrics {
 		//TODO batch getMetricTableName
 		metricInfo, err := tools.getMetricTableName(ctx, schemas[i], metrics[i], false)
@@ -153,34 +280,108 @@ func fetchMultipleMetricsSamples(ctx context.Context, tools *queryTools, metadat
 			start:       metadata.timeFilter.start,
 			end:         metadata.timeFilter.end,
 		}
-		sqlQuery, err := buildMultipleMetricSamplesQuery(filter, series[i])
+		sqlQuery, err := buildMultipleMetricSamplesQuery(filter, series[i], selectKindOf(metadata))
 		if err != nil {
 			return nil, fmt.Errorf("build timeseries by series-id: %w", err)
 		}
-		batch.Queue(sqlQuery)
-		numQueries += 1
+		queries = append(queries, sqlQuery)
 	}
+	return queries, nil
+}
 
-	batchResults, err := tools.conn.SendBatch(ctx, batch)
+// streamSamples runs the samples query of a streamed remote read. The series
+// are ordered by labels and read from the database one at a time as the
+// returned set is iterated over, so that the result is never held in memory.
+func (q *querySamples) streamSamples(mint, maxt int64, ms []*labels.Matcher) (ChunkSeriesSet, error) {
+	start := time.Now()
+	stats := querylog.FromContext(q.ctx)
+	stats.AddSelect(mint, maxt, ms)
+	cs := &streamedChunkSeriesSet{
+		querier: q.tools.labelsReader,
+		limits:  limitsFromContext(q.ctx),
+		onClose: func(series, samples int64) {
+			stats.AddRows(series, samples)
+			q.tools.indexAdvisor.ObserveQuery(ms, time.Since(start))
+		},
+	}
+
+	generationStart := time.Now()
+	metadata, err := getEvaluationMetadata(q.tools, mint, maxt, GetPromQLMetadata(ms, nil, nil, nil))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get evaluation metadata: %w", err)
 	}
-	defer batchResults.Close()
 
-	for i := 0; i < numQueries; i++ {
-		rows, err := batchResults.Query()
+	var (
+		sqlQuery   string
+		values     []interface{}
+		additional labels.Labels
+	)
+	if metadata.isSingleMetric {
+		filter := metadata.timeFilter
+		mInfo, err := q.tools.getMetricTableName(q.ctx, filter.schema, filter.metric, false)
 		if err != nil {
-			rows.Close()
+			if err == errors.ErrMissingTableName {
+				return cs, nil
+			}
+			return nil, fmt.Errorf("get metric table name: %w", err)
+		}
+		metadata.timeFilter.metric = mInfo.TableName
+		metadata.timeFilter.schema = mInfo.TableSchema
+		metadata.timeFilter.seriesTable = mInfo.SeriesTable
+		metadata.timeFilter.tenantPartitioned = mInfo.TenantPartitioned
+
+		if err = checkSingleMetricSeries(q.ctx, q.tools, metadata); err != nil {
 			return nil, err
 		}
-		// Append all rows into results.
-		results, err = appendSampleRows(results, rows, nil, "", "", "")
-		rows.Close()
+		sqlQuery, values, _, cs.tsSeries, err = buildSingleMetricSamplesQuery(metadata, q.tools.planCache)
+		if err != nil {
+			return nil, err
+		}
+		// A custom metric view shares the series table with the raw metric,
+		// so its series are renamed.
+		if mInfo.TableName != mInfo.SeriesTable {
+			cs.metric = mInfo.TableName
+		}
+		cs.schema, cs.column = mInfo.TableSchema, filter.column
+		additional = (&sampleRow{schema: cs.schema, column: cs.column}).GetAdditionalLabels()
+	} else {
+		stats.AddSQLGeneration(time.Since(generationStart))
+		queries, err := buildMultipleMetricsSamplesQueries(q.ctx, q.tools, metadata)
 		if err != nil {
-			rows.Close()
 			return nil, err
 		}
+		if len(queries) == 0 {
+			return cs, nil
+		}
+		generationStart = time.Now()
+		sqlQuery = "(" + strings.Join(queries, ") UNION ALL (") + ")"
 	}
+	sqlQuery, values = buildStreamedSamplesQuery(sqlQuery, values, additional)
+	stats.AddSQLGeneration(time.Since(generationStart))
 
-	return results, nil
+	dbStart := time.Now()
+	rows, err := q.tools.conn.Query(q.ctx, sqlQuery, queryArgs(values)...)
+	stats.AddDBExecution(time.Since(dbStart))
+	if err != nil {
+		if metadata.isSingleMetric {
+			err = singleMetricQueryError(err, metadata)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return cs, nil
+	}
+	cs.rows = rows
+	return cs, nil
+}
+
+// countSamples returns the number of samples held by the rows.
+func countSamples(rows []sampleRow) int64 {
+	var samples int64
+	for i := range rows {
+		if rows[i].values != nil {
+			samples += int64(len(rows[i].values.Elements))
+		}
+	}
+	return samples
 }
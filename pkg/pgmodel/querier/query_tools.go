@@ -5,11 +5,13 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/timescale/promscale/pkg/indexadvisor"
 	"github.com/timescale/promscale/pkg/pgmodel/cache"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/pgmodel/lreader"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/partitioning"
 	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/tenancy"
 )
@@ -20,6 +22,8 @@ type queryTools struct {
 	exemplarPosCache cache.PositionCache
 	labelsReader     lreader.LabelsReader
 	rAuth            tenancy.ReadAuthorizer
+	indexAdvisor     *indexadvisor.Advisor
+	planCache        *PlanCache
 }
 
 // getMetricTableName gets the table name for a specific metric from internal
@@ -47,6 +51,14 @@ func (tools *queryTools) getMetricTableName(ctx context.Context, metricSchema, m
 		if err != nil {
 			return model.MetricInfo{}, err
 		}
+		// The tenant partitions are only pruned with multi-tenancy, which
+		// restricts the queries to the authorized tenants.
+		if tools.rAuth != nil {
+			metricInfo.TenantPartitioned, err = partitioning.IsPartitioned(ctx, tools.conn, metricInfo.TableSchema, metricInfo.TableName)
+			if err != nil {
+				return model.MetricInfo{}, err
+			}
+		}
 	}
 
 	err = tools.metricTableNames.Set(metricSchema, metricName, metricInfo, isExemplarQuery)
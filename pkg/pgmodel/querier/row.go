@@ -2,10 +2,12 @@ package querier
 
 import (
 	"encoding/binary"
+	"math"
 	"sync"
 
 	"github.com/jackc/pgtype"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
@@ -114,6 +116,7 @@ func (dstwrapper *float8ArrayWrapper) DecodeBinary(ci *pgtype.ConnInfo, src []by
 		elements = elements[:elementCount]
 	}
 
+	decode := elementDecoder(uint32(arrayHeader.ElementOID))
 	for i := range elements {
 		elemLen := int(int32(binary.BigEndian.Uint32(src[rp:])))
 		rp += 4
@@ -122,7 +125,7 @@ func (dstwrapper *float8ArrayWrapper) DecodeBinary(ci *pgtype.ConnInfo, src []by
 			elemSrc = src[rp : rp+elemLen]
 			rp += elemLen
 		}
-		err = elements[i].DecodeBinary(ci, elemSrc)
+		err = decode(ci, elemSrc, &elements[i])
 		if err != nil {
 			return err
 		}
@@ -132,6 +135,54 @@ func (dstwrapper *float8ArrayWrapper) DecodeBinary(ci *pgtype.ConnInfo, src []by
 	return nil
 }
 
+// elementDecoder returns the decoder of the values of a metric. The values
+// are double precision unless the metric is stored with an alternate
+// encoding, see the encoding package.
+func elementDecoder(oid uint32) func(ci *pgtype.ConnInfo, src []byte, dst *pgtype.Float8) error {
+	switch oid {
+	case pgtype.Int2OID:
+		return func(ci *pgtype.ConnInfo, src []byte, dst *pgtype.Float8) error {
+			var v pgtype.Int2
+			if err := v.DecodeBinary(ci, src); err != nil {
+				return err
+			}
+			*dst = encodedValue(float64(v.Int), v.Status)
+			return nil
+		}
+	case pgtype.Int4OID:
+		return func(ci *pgtype.ConnInfo, src []byte, dst *pgtype.Float8) error {
+			var v pgtype.Int4
+			if err := v.DecodeBinary(ci, src); err != nil {
+				return err
+			}
+			*dst = encodedValue(float64(v.Int), v.Status)
+			return nil
+		}
+	case pgtype.Float4OID:
+		return func(ci *pgtype.ConnInfo, src []byte, dst *pgtype.Float8) error {
+			var v pgtype.Float4
+			if err := v.DecodeBinary(ci, src); err != nil {
+				return err
+			}
+			*dst = encodedValue(float64(v.Float), v.Status)
+			return nil
+		}
+	default:
+		return func(ci *pgtype.ConnInfo, src []byte, dst *pgtype.Float8) error {
+			return dst.DecodeBinary(ci, src)
+		}
+	}
+}
+
+// encodedValue converts a value of an alternate encoding, which stores the
+// staleness markers as NULL.
+func encodedValue(v float64, status pgtype.Status) pgtype.Float8 {
+	if status == pgtype.Null {
+		return pgtype.Float8{Float: math.Float64frombits(value.StaleNaN), Status: pgtype.Present}
+	}
+	return pgtype.Float8{Float: v, Status: status}
+}
+
 type sampleRow struct {
 	labelIds       []int64
 	times          TimestampSeries
@@ -164,39 +215,51 @@ func (r *sampleRow) GetAdditionalLabels() (ll labels.Labels) {
 
 // appendSampleRows adds new results rows to already existing result rows and
 // returns the as a result.
-func appendSampleRows(out []sampleRow, in pgxconn.PgxRows, tsSeries TimestampSeries, metric, schema, column string) ([]sampleRow, error) {
+func appendSampleRows(out []sampleRow, in pgxconn.PgxRows, tsSeries TimestampSeries, metric, schema, column string, limits *limitsTracker, memory *QueryMemory) ([]sampleRow, error) {
 	if in.Err() != nil {
 		return out, in.Err()
 	}
 	for in.Next() {
-		var row sampleRow
-		values := fPool.Get().(*pgtype.Float8Array)
-		values.Elements = values.Elements[:0]
-		valuesWrapper := float8ArrayWrapper{values}
-
-		//if a timeseries isn't provided it will be fetched from the database
-		if tsSeries == nil {
-			times := tPool.Get().(*pgtype.TimestamptzArray)
-			times.Elements = times.Elements[:0]
-			timesWrapper := timestamptzArrayWrapper{times}
-			row.err = in.Scan(&row.labelIds, &timesWrapper, &valuesWrapper)
-			row.timeArrayOwnership = times
-			row.times = newRowTimestampSeries(times)
-		} else {
-			row.err = in.Scan(&row.labelIds, &valuesWrapper)
-			row.times = tsSeries
-		}
-
-		row.values = values
-		row.metricOverride = metric
-		row.schema = schema
-		row.column = column
-
+		row := scanSampleRow(in, tsSeries, metric, schema, column)
 		out = append(out, row)
 		if row.err != nil {
 			log.Error("err", row.err)
 			return out, row.err
 		}
+		if err := limits.add(&row); err != nil {
+			return out, err
+		}
+		if err := memory.AddDecoded(rowBytes(&row)); err != nil {
+			return out, err
+		}
 	}
 	return out, in.Err()
 }
+
+// scanSampleRow scans the current row of in. The arrays of the returned row
+// come from pools and must be released with Close.
+func scanSampleRow(in pgxconn.PgxRows, tsSeries TimestampSeries, metric, schema, column string) sampleRow {
+	var row sampleRow
+	values := fPool.Get().(*pgtype.Float8Array)
+	values.Elements = values.Elements[:0]
+	valuesWrapper := float8ArrayWrapper{values}
+
+	//if a timeseries isn't provided it will be fetched from the database
+	if tsSeries == nil {
+		times := tPool.Get().(*pgtype.TimestamptzArray)
+		times.Elements = times.Elements[:0]
+		timesWrapper := timestamptzArrayWrapper{times}
+		row.err = in.Scan(&row.labelIds, &timesWrapper, &valuesWrapper)
+		row.timeArrayOwnership = times
+		row.times = newRowTimestampSeries(times)
+	} else {
+		row.err = in.Scan(&row.labelIds, &valuesWrapper)
+		row.times = tsSeries
+	}
+
+	row.values = values
+	row.metricOverride = metric
+	row.schema = schema
+	row.column = column
+	return row
+}
@@ -87,6 +87,10 @@ func (m mockLabelsReader) LabelValues(_ string) ([]string, error) {
 	return nil, nil
 }
 
+func (m mockLabelsReader) SearchLabelValues(string, string, int) ([]string, error) {
+	return nil, nil
+}
+
 func (m mockLabelsReader) LabelsForIdMap(index map[int64]labels.Label) error {
 	for seriesId := range index {
 		if lbls, present := m.items[seriesId]; present {
@@ -14,6 +14,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
 )
 
 const (
@@ -93,10 +94,19 @@ func (p *pgxSamplesSeriesSet) At() storage.Series {
 		return ps
 	}
 
-	lls, err := getLabelsFromLabelIds(row.labelIds, p.labelIDMap)
+	lls, err := rowLabels(row, p.labelIDMap)
 	if err != nil {
 		p.err = err
 	}
+	ps.labels = lls
+
+	return ps
+}
+
+// rowLabels returns the sorted labels of a row, including the labels added by
+// the query.
+func rowLabels(row *sampleRow, index map[int64]labels.Label) (labels.Labels, error) {
+	lls, err := getLabelsFromLabelIds(row.labelIds, index)
 
 	if row.metricOverride != "" {
 		for i := range lls {
@@ -109,9 +119,7 @@ func (p *pgxSamplesSeriesSet) At() storage.Series {
 	lls = append(lls, row.GetAdditionalLabels()...)
 
 	sort.Sort(lls)
-	ps.labels = lls
-
-	return ps
+	return lls, err
 }
 
 func getLabelsFromLabelIds(labelIds []int64, index map[int64]labels.Label) (labels.Labels, error) {
@@ -232,3 +240,108 @@ func (p *pgxSeriesIterator) Next() bool {
 func (p *pgxSeriesIterator) Err() error {
 	return nil
 }
+
+// streamedChunkSeriesSet implements ChunkSeriesSet on top of the rows of a
+// samples query, reading one series at a time so that only the current series
+// is held in memory. The streamed remote read protocol requires the series to
+// be sorted by labels, so the rows must be ordered by the query, see
+// buildStreamedSamplesQuery.
+type streamedChunkSeriesSet struct {
+	rows     pgxconn.PgxRows
+	tsSeries TimestampSeries
+	metric   string
+	schema   string
+	column   string
+	querier  labelQuerier
+	limits   *limitsTracker
+	// onClose is called with the number of series and samples read when
+	// the set is closed.
+	onClose func(series, samples int64)
+
+	row     *sampleRow
+	series  storage.Series
+	err     error
+	numRows int64
+	samples int64
+}
+
+var _ ChunkSeriesSet = (*streamedChunkSeriesSet)(nil)
+
+// Next reads the next series from the database.
+func (c *streamedChunkSeriesSet) Next() bool {
+	c.releaseRow()
+	if c.rows == nil || c.err != nil || !c.rows.Next() {
+		return false
+	}
+	row := scanSampleRow(c.rows, c.tsSeries, c.metric, c.schema, c.column)
+	c.row = &row
+	if c.err = row.err; c.err != nil {
+		return false
+	}
+	if row.times.Len() != len(row.values.Elements) {
+		c.err = errors.ErrInvalidRowData
+		return false
+	}
+	if c.err = c.limits.add(&row); c.err != nil {
+		return false
+	}
+	c.numRows++
+	c.samples += int64(len(row.values.Elements))
+
+	labelIDMap := make(map[int64]labels.Label, len(row.labelIds))
+	for _, id := range row.labelIds {
+		if id != 0 {
+			labelIDMap[id] = labels.Label{}
+		}
+	}
+	if c.err = c.querier.LabelsForIdMap(labelIDMap); c.err != nil {
+		return false
+	}
+	lls, err := rowLabels(&row, labelIDMap)
+	if c.err = err; c.err != nil {
+		return false
+	}
+	c.series = &pgxSeries{labels: lls, times: row.times, values: row.values}
+	return true
+}
+
+// At returns the current series encoded as XOR chunks. It is only valid until
+// the next call to Next.
+func (c *streamedChunkSeriesSet) At() storage.ChunkSeries {
+	if c.series == nil {
+		return nil
+	}
+	return storage.NewSeriesToChunkEncoder(c.series)
+}
+
+func (c *streamedChunkSeriesSet) Err() error {
+	if c.err == nil && c.rows != nil {
+		c.err = c.rows.Err()
+	}
+	if c.err != nil {
+		return fmt.Errorf("error retrieving series set: %w", c.err)
+	}
+	return nil
+}
+
+func (c *streamedChunkSeriesSet) Warnings() storage.Warnings { return nil }
+
+func (c *streamedChunkSeriesSet) releaseRow() {
+	if c.row != nil {
+		c.row.Close()
+		c.row = nil
+	}
+	c.series = nil
+}
+
+func (c *streamedChunkSeriesSet) Close() {
+	c.releaseRow()
+	if c.rows != nil {
+		c.rows.Close()
+		c.rows = nil
+	}
+	if c.onClose != nil {
+		c.onClose(c.numRows, c.samples)
+		c.onClose = nil
+	}
+}
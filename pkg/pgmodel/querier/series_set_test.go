@@ -18,6 +18,7 @@ import (
 	"github.com/jackc/pgproto3/v2"
 	"github.com/jackc/pgtype"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
 	pgmodelErrs "github.com/timescale/promscale/pkg/pgmodel/common/errors"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
 	"github.com/timescale/promscale/pkg/pgmodel/model"
@@ -502,6 +503,33 @@ func toTimestampTzArray(times []pgtype.Timestamptz) *pgtype.TimestamptzArray {
 	}
 }
 
+func TestFloat8ArrayWrapperEncodedValues(t *testing.T) {
+	ci := pgtype.NewConnInfo()
+	src, err := (&pgtype.Int2Array{
+		Elements:   []pgtype.Int2{{Int: 1, Status: pgtype.Present}, {Status: pgtype.Null}, {Int: 0, Status: pgtype.Present}},
+		Dimensions: []pgtype.ArrayDimension{{Length: 3, LowerBound: 1}},
+		Status:     pgtype.Present,
+	}).EncodeBinary(ci, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values pgtype.Float8Array
+	if err = (&float8ArrayWrapper{&values}).DecodeBinary(ci, src); err != nil {
+		t.Fatal(err)
+	}
+	if len(values.Elements) != 3 {
+		t.Fatalf("unexpected number of values: got %d, wanted 3", len(values.Elements))
+	}
+	if values.Elements[0].Float != 1 || values.Elements[2].Float != 0 {
+		t.Errorf("unexpected values: %v", values.Elements)
+	}
+	// The staleness markers are stored as NULL.
+	if stale := values.Elements[1]; stale.Status != pgtype.Present || !value.IsStaleNaN(stale.Float) {
+		t.Errorf("NULL not decoded as a staleness marker: %v", stale)
+	}
+}
+
 func toFloat8Array(values []pgtype.Float8) *pgtype.Float8Array {
 	return &pgtype.Float8Array{
 		Elements:   values,
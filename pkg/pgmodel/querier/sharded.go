@@ -0,0 +1,233 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/shard"
+)
+
+type shardedQuerier struct {
+	shards []Querier
+	router *shard.Router
+}
+
+var _ Querier = (*shardedQuerier)(nil)
+
+// NewShardedQuerier returns a querier over the databases the metrics are
+// sharded across. The queries selecting a metric name are sent to its shard,
+// the other queries to all the shards, and their results merged.
+func NewShardedQuerier(shards []Querier, router *shard.Router) Querier {
+	return &shardedQuerier{shards: shards, router: router}
+}
+
+// targets returns the shards that can have the series selected by the
+// matchers.
+func (q *shardedQuerier) targets(matchers []*labels.Matcher) []int {
+	if i := q.router.ShardOf(matchers); i >= 0 {
+		return []int{i}
+	}
+	all := make([]int, len(q.shards))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// fanOut calls fn concurrently for each target shard and returns the first
+// error.
+func fanOut(targets []int, fn func(n, shard int) error) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(targets))
+	)
+	for n, i := range targets {
+		wg.Add(1)
+		go func(n, i int) {
+			defer wg.Done()
+			errs[n] = fn(n, i)
+		}(n, i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *shardedQuerier) RemoteReadQuerier(ctx context.Context) RemoteReadQuerier {
+	return &shardedRemoteRead{ctx: ctx, q: q}
+}
+
+func (q *shardedQuerier) SamplesQuerier(ctx context.Context) SamplesQuerier {
+	return &shardedSamples{ctx: ctx, q: q}
+}
+
+func (q *shardedQuerier) ExemplarsQuerier(ctx context.Context) ExemplarQuerier {
+	return &shardedExemplars{ctx: ctx, q: q}
+}
+
+type shardedRemoteRead struct {
+	ctx context.Context
+	q   *shardedQuerier
+}
+
+func (r *shardedRemoteRead) targets(query *prompb.Query) ([]int, error) {
+	matchers, err := fromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	return r.q.targets(matchers), nil
+}
+
+// Query returns the series of the shards one after the other, as the series
+// of a metric are all in the same shard.
+func (r *shardedRemoteRead) Query(query *prompb.Query) ([]*prompb.TimeSeries, error) {
+	targets, err := r.targets(query)
+	if err != nil {
+		return nil, err
+	}
+	results := make([][]*prompb.TimeSeries, len(targets))
+	err = fanOut(targets, func(n, i int) (err error) {
+		results[n], err = r.q.shards[i].RemoteReadQuerier(r.ctx).Query(query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var series []*prompb.TimeSeries
+	for _, res := range results {
+		series = append(series, res...)
+	}
+	return series, nil
+}
+
+// QueryChunks merges the chunk series of the shards, keeping them sorted by
+// labels.
+func (r *shardedRemoteRead) QueryChunks(query *prompb.Query) (ChunkSeriesSet, error) {
+	targets, err := r.targets(query)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]ChunkSeriesSet, len(targets))
+	err = fanOut(targets, func(n, i int) (err error) {
+		sets[n], err = r.q.shards[i].RemoteReadQuerier(r.ctx).QueryChunks(query)
+		return err
+	})
+	if err != nil {
+		for _, s := range sets {
+			if s != nil {
+				s.Close()
+			}
+		}
+		return nil, err
+	}
+	if len(sets) == 1 {
+		return sets[0], nil
+	}
+	merged := make([]storage.ChunkSeriesSet, len(sets))
+	for n, s := range sets {
+		merged[n] = s
+	}
+	return &mergedChunkSeriesSet{
+		ChunkSeriesSet: storage.NewMergeChunkSeriesSet(merged, storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge)),
+		sets:           sets,
+	}, nil
+}
+
+type mergedChunkSeriesSet struct {
+	storage.ChunkSeriesSet
+	sets []ChunkSeriesSet
+}
+
+func (m *mergedChunkSeriesSet) Close() {
+	for _, s := range m.sets {
+		s.Close()
+	}
+}
+
+type shardedSamples struct {
+	ctx context.Context
+	q   *shardedQuerier
+}
+
+// Select sends the selection of a metric name to its shard. The other
+// selections are sent to all the shards without pushdown, as the pushed down
+// nodes would be computed on each shard, and their sorted series merged.
+func (s *shardedSamples) Select(mint, maxt int64, sortSeries bool, hints *storage.SelectHints, queryHints *QueryHints, path []parser.Node, ms ...*labels.Matcher) (SeriesSet, parser.Node) {
+	targets := s.q.targets(ms)
+	if len(targets) == 1 {
+		return s.q.shards[targets[0]].SamplesQuerier(s.ctx).Select(mint, maxt, sortSeries, hints, queryHints, path, ms...)
+	}
+	sets := make([]SeriesSet, len(targets))
+	_ = fanOut(targets, func(n, i int) error {
+		sets[n], _ = s.q.shards[i].SamplesQuerier(s.ctx).Select(mint, maxt, true, hints, queryHints, nil, ms...)
+		return nil
+	})
+	merged := make([]storage.SeriesSet, len(sets))
+	for n, set := range sets {
+		merged[n] = set
+	}
+	return &mergedSeriesSet{
+		SeriesSet: storage.NewMergeSeriesSet(merged, storage.ChainedSeriesMerge),
+		sets:      sets,
+	}, nil
+}
+
+type mergedSeriesSet struct {
+	storage.SeriesSet
+	sets []SeriesSet
+}
+
+func (m *mergedSeriesSet) Close() {
+	for _, s := range m.sets {
+		s.Close()
+	}
+}
+
+type shardedExemplars struct {
+	ctx context.Context
+	q   *shardedQuerier
+}
+
+// Select sends each selection to the shards that can have its series.
+func (e *shardedExemplars) Select(start, end time.Time, ms ...[]*labels.Matcher) ([]model.ExemplarQueryResult, error) {
+	perShard := make([][][]*labels.Matcher, len(e.q.shards))
+	for _, matchers := range ms {
+		for _, i := range e.q.targets(matchers) {
+			perShard[i] = append(perShard[i], matchers)
+		}
+	}
+	var targets []int
+	for i, matchers := range perShard {
+		if len(matchers) > 0 {
+			targets = append(targets, i)
+		}
+	}
+	results := make([][]model.ExemplarQueryResult, len(targets))
+	err := fanOut(targets, func(n, i int) (err error) {
+		results[n], err = e.q.shards[i].ExemplarsQuerier(e.ctx).Select(start, end, perShard[i]...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []model.ExemplarQueryResult
+	for _, r := range results {
+		res = append(res, r...)
+	}
+	return res, nil
+}
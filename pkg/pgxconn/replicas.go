@@ -0,0 +1,217 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgxconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+
+	"github.com/timescale/promscale/pkg/log"
+)
+
+var replicaUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "replica_up",
+		Help:      "Whether the read replica passed its last health check, by replica.",
+	}, []string{"replica"},
+)
+
+func init() {
+	prometheus.MustRegister(replicaUp)
+}
+
+// Replica is a read replica with a name identifying it in logs and metrics,
+// which must not contain credentials.
+type Replica struct {
+	Name string
+	Conn PgxConn
+}
+
+type replica struct {
+	Replica
+	healthy atomic.Bool
+}
+
+// ReplicaConn sends the requests to the healthy read replicas in turn, and to
+// the primary when none is healthy. Replicas are health checked periodically,
+// and used again as soon as they pass a check. It must only be used to read.
+type ReplicaConn struct {
+	primary  PgxConn
+	replicas []*replica
+	next     atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewReplicaConn checks the health of the replicas, and keeps checking them
+// every interval until Close is called.
+func NewReplicaConn(primary PgxConn, replicas []Replica, interval time.Duration) *ReplicaConn {
+	c := &ReplicaConn{primary: primary, stop: make(chan struct{})}
+	for _, r := range replicas {
+		c.replicas = append(c.replicas, &replica{Replica: r})
+	}
+	c.checkHealth(interval)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.checkHealth(interval)
+			}
+		}
+	}()
+	return c
+}
+
+func (c *ReplicaConn) checkHealth(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := r.Conn.Exec(ctx, "SELECT 1")
+			c.setHealthy(r, err == nil, err)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (c *ReplicaConn) setHealthy(r *replica, healthy bool, err error) {
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			log.Info("msg", "Read replica is healthy, sending queries to it", "replica", r.Name)
+		} else {
+			log.Warn("msg", "Read replica is unhealthy, sending its queries to the other replicas or the primary", "replica", r.Name, "err", err)
+		}
+	}
+	if healthy {
+		replicaUp.WithLabelValues(r.Name).Set(1)
+	} else {
+		replicaUp.WithLabelValues(r.Name).Set(0)
+	}
+}
+
+// pick returns the next healthy replica, or nil if none is healthy.
+func (c *ReplicaConn) pick() *replica {
+	n := uint64(len(c.replicas))
+	start := c.next.Inc()
+	for i := uint64(0); i < n; i++ {
+		if r := c.replicas[(start+i)%n]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// failed marks the replica unhealthy if err means that it could not be
+// reached, in which case the request can be sent to the primary instead.
+func (c *ReplicaConn) failed(r *replica, err error) bool {
+	var netErr net.Error
+	if err == nil || !(pgconn.SafeToRetry(err) || errors.As(err, &netErr)) {
+		return false
+	}
+	c.setHealthy(r, false, err)
+	return true
+}
+
+// Close stops the health checks and closes the replicas. The primary is
+// not closed.
+func (c *ReplicaConn) Close() {
+	close(c.stop)
+	c.wg.Wait()
+	for _, r := range c.replicas {
+		r.Conn.Close()
+	}
+}
+
+func (c *ReplicaConn) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	if r := c.pick(); r != nil {
+		tag, err := r.Conn.Exec(ctx, sql, arguments...)
+		if !c.failed(r, err) {
+			return tag, err
+		}
+	}
+	return c.primary.Exec(ctx, sql, arguments...)
+}
+
+func (c *ReplicaConn) Query(ctx context.Context, sql string, args ...interface{}) (PgxRows, error) {
+	if r := c.pick(); r != nil {
+		rows, err := r.Conn.Query(ctx, sql, args...)
+		if !c.failed(r, err) {
+			return rows, err
+		}
+	}
+	return c.primary.Query(ctx, sql, args...)
+}
+
+func (c *ReplicaConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if r := c.pick(); r != nil {
+		return r.Conn.QueryRow(ctx, sql, args...)
+	}
+	return c.primary.QueryRow(ctx, sql, args...)
+}
+
+func (c *ReplicaConn) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	// Replicas cannot be written to.
+	return c.primary.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+func (c *ReplicaConn) CopyFromRows(rows [][]interface{}) pgx.CopyFromSource {
+	return c.primary.CopyFromRows(rows)
+}
+
+func (c *ReplicaConn) NewBatch() PgxBatch {
+	return c.primary.NewBatch()
+}
+
+func (c *ReplicaConn) SendBatch(ctx context.Context, b PgxBatch) (pgx.BatchResults, error) {
+	if r := c.pick(); r != nil {
+		results, err := r.Conn.SendBatch(ctx, b)
+		if !c.failed(r, err) {
+			return results, err
+		}
+	}
+	return c.primary.SendBatch(ctx, b)
+}
+
+func (c *ReplicaConn) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	if r := c.pick(); r != nil {
+		conn, err := r.Conn.Acquire(ctx)
+		if !c.failed(r, err) {
+			return conn, err
+		}
+	}
+	return c.primary.Acquire(ctx)
+}
+
+func (c *ReplicaConn) BeginTx(ctx context.Context) (pgx.Tx, error) {
+	if r := c.pick(); r != nil {
+		tx, err := r.Conn.BeginTx(ctx)
+		if !c.failed(r, err) {
+			return tx, err
+		}
+	}
+	return c.primary.BeginTx(ctx)
+}
@@ -0,0 +1,106 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package pgxconn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+type unreachableErr struct{}
+
+func (unreachableErr) Error() string     { return "connection refused" }
+func (unreachableErr) SafeToRetry() bool { return true }
+
+// fakeConn counts the Exec calls other than the health checks.
+type fakeConn struct {
+	PgxConn
+	mu     sync.Mutex
+	down   bool
+	execs  int
+	closed bool
+}
+
+func (c *fakeConn) Exec(_ context.Context, sql string, _ ...interface{}) (pgconn.CommandTag, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.down {
+		return nil, unreachableErr{}
+	}
+	if sql != "SELECT 1" {
+		c.execs++
+	}
+	return pgconn.CommandTag("SELECT 1"), nil
+}
+
+func (c *fakeConn) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *fakeConn) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.execs
+}
+
+func (c *fakeConn) Close() { c.closed = true }
+
+func TestReplicaConn(t *testing.T) {
+	primary, r1, r2 := &fakeConn{}, &fakeConn{}, &fakeConn{down: true}
+	conn := NewReplicaConn(primary, []Replica{{Name: "r1", Conn: r1}, {Name: "r2", Conn: r2}}, time.Hour)
+
+	// r2 failed the initial health check.
+	for i := 0; i < 4; i++ {
+		_, err := conn.Exec(context.Background(), "SELECT x")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, r1.count())
+	require.Equal(t, 0, r2.count())
+	require.Equal(t, 0, primary.count())
+
+	// Failback once r2 passes a health check.
+	r2.setDown(false)
+	conn.checkHealth(time.Second)
+	for i := 0; i < 4; i++ {
+		_, err := conn.Exec(context.Background(), "SELECT x")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, r1.count())
+	require.Equal(t, 2, r2.count())
+
+	// Unreachable replicas are skipped right away, and the primary is used
+	// when none is left.
+	r1.setDown(true)
+	r2.setDown(true)
+	_, err := conn.Exec(context.Background(), "SELECT x")
+	require.NoError(t, err)
+	_, err = conn.Exec(context.Background(), "SELECT x")
+	require.NoError(t, err)
+	require.Equal(t, 2, primary.count())
+	require.Nil(t, conn.pick())
+
+	conn.Close()
+	require.True(t, r1.closed)
+	require.True(t, r2.closed)
+	require.False(t, primary.closed)
+}
+
+func TestReplicaConnQueryErrorsAreNotRetried(t *testing.T) {
+	r := &replica{}
+	r.healthy.Store(true)
+	conn := &ReplicaConn{replicas: []*replica{r}}
+	require.False(t, conn.failed(r, errors.New("syntax error")))
+	require.True(t, r.healthy.Load())
+	require.True(t, conn.failed(r, unreachableErr{}))
+	require.False(t, r.healthy.Load())
+}
@@ -60,11 +60,13 @@ type Queryable interface {
 
 // SamplesQuerier provides querying access over time series data of a fixed time range.
 type SamplesQuerier interface {
-	// LabelValues returns all potential values for a label name.
+	// LabelValues returns all potential values for a label name, of the
+	// series matching the matchers if any.
 	// It is not safe to use the strings beyond the lifefime of the querier.
-	LabelValues(name string) ([]string, storage.Warnings, error)
+	LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error)
 
-	// LabelNames returns all the unique label names present in the block in sorted order.
+	// LabelNames returns all the unique label names present in the block in sorted order,
+	// of the series matching the matchers if any.
 	LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error)
 
 	// Close releases the resources of the Querier.
@@ -297,6 +299,15 @@ type EngineOpts struct {
 	// EnablePerStepStats if true allows for per-step stats to be computed on request.
 	// Disabled otherwise.
 	EnablePerStepStats bool
+
+	// EnableExperimentalFunctions if true makes the experimental PromQL
+	// functions, e.g. limitk, available to the parser. The functions are
+	// then available to every engine of the process.
+	EnableExperimentalFunctions bool
+
+	// EnableOverTimePushdown if true lets the querier compute the
+	// *_over_time functions of instant queries with SQL aggregates.
+	EnableOverTimePushdown bool
 }
 
 // Engine handles the lifetime of queries from beginning to end.
@@ -314,6 +325,7 @@ type Engine struct {
 	enableAtModifier         bool
 	enableNegativeOffset     bool
 	enablePerStepStats       bool
+	enableOverTimePushdown   bool
 }
 
 // NewEngine returns a new engine.
@@ -376,6 +388,10 @@ func NewEngine(opts EngineOpts) *Engine {
 		}
 	}
 
+	if opts.EnableExperimentalFunctions {
+		EnableExperimentalFunctions()
+	}
+
 	if opts.Reg != nil {
 		opts.Reg.MustRegister(
 			metrics.currentQueries,
@@ -397,6 +413,7 @@ func NewEngine(opts EngineOpts) *Engine {
 		enableAtModifier:         opts.EnableAtModifier,
 		enableNegativeOffset:     opts.EnableNegativeOffset,
 		enablePerStepStats:       opts.EnablePerStepStats,
+		enableOverTimePushdown:   opts.EnableOverTimePushdown,
 	}
 }
 
@@ -669,6 +686,7 @@ func (ng *Engine) execEvalStmt(ctx context.Context, query *query, s *parser.Eval
 			topNodes:                 topNodes,
 			samplesStats:             query.sampleStats,
 			noStepSubqueryIntervalFn: ng.noStepSubqueryIntervalFn,
+			memory:                   pgquerier.QueryMemoryFromContext(ctx),
 		}
 		query.sampleStats.InitStepTracking(start, start, 1)
 
@@ -722,6 +740,7 @@ func (ng *Engine) execEvalStmt(ctx context.Context, query *query, s *parser.Eval
 		samplesStats:             query.sampleStats,
 		noStepSubqueryIntervalFn: ng.noStepSubqueryIntervalFn,
 		topNodes:                 topNodes,
+		memory:                   pgquerier.QueryMemoryFromContext(ctx),
 	}
 	query.sampleStats.InitStepTracking(evaluator.startTimestamp, evaluator.endTimestamp, evaluator.interval)
 	val, warnings, err := evaluator.Eval(s.Expr)
@@ -881,8 +900,10 @@ func (ng *Engine) populateSeries(querier SamplesQuerier, evalStmt *parser.EvalSt
 			}
 
 			qh = &pgquerier.QueryHints{
-				CurrentNode: n,
-				Lookback:    ng.lookbackDelta,
+				CurrentNode:      n,
+				Lookback:         ng.lookbackDelta,
+				EvalWindow:       ng.getEvalWindow(evalStmt, n, path),
+				OverTimePushdown: ng.enableOverTimePushdown,
 			}
 			evalRange = 0
 			hints.By, hints.Grouping = extractGroupsFromPath(path)
@@ -899,6 +920,54 @@ func (ng *Engine) populateSeries(querier SamplesQuerier, evalStmt *parser.EvalSt
 	return topNodes
 }
 
+// getEvalWindow returns the steps at which the evaluator evaluates the
+// selector n, following the subqueries and step invariant expressions in
+// path the same way eval does. It returns nil when the steps depend on
+// offsets that are only set during evaluation, i.e. for subqueries with
+// the @ modifier.
+func (ng *Engine) getEvalWindow(s *parser.EvalStmt, n *parser.VectorSelector, path []parser.Node) *pgquerier.EvalWindow {
+	w := &pgquerier.EvalWindow{
+		Start: timeMilliseconds(s.Start),
+		End:   timeMilliseconds(s.End),
+		Step:  durationMilliseconds(s.Interval),
+	}
+	for _, node := range path {
+		switch e := node.(type) {
+		case *parser.StepInvariantExpr:
+			w.End = w.Start
+		case *parser.SubqueryExpr:
+			if e.Timestamp != nil || e.StartOrEnd == parser.START || e.StartOrEnd == parser.END {
+				return nil
+			}
+			offsetMillis := durationMilliseconds(e.OriginalOffset)
+			rangeMillis := durationMilliseconds(e.Range)
+			step := durationMilliseconds(e.Step)
+			if step == 0 {
+				if ng.noStepSubqueryIntervalFn == nil {
+					return nil
+				}
+				step = ng.noStepSubqueryIntervalFn(rangeMillis)
+			}
+			start := step * ((w.Start - offsetMillis - rangeMillis) / step)
+			if start < w.Start-offsetMillis-rangeMillis {
+				start += step
+			}
+			w.Start, w.End, w.Step = start, w.End-offsetMillis, step
+		}
+	}
+
+	w.Offset = durationMilliseconds(n.OriginalOffset)
+	if n.Timestamp != nil {
+		// A selector with the @ modifier is step invariant, so it is
+		// evaluated once and its samples are read relative to the timestamp.
+		if w.Start != w.End {
+			return nil
+		}
+		w.Offset += w.Start - *n.Timestamp
+	}
+	return w
+}
+
 // extractFuncFromPath walks up the path and searches for the first instance of
 // a function or aggregation.
 func extractFuncFromPath(p []parser.Node) string {
@@ -981,6 +1050,17 @@ type evaluator struct {
 	topNodes                 map[parser.Node]struct{}
 	samplesStats             *stats.QuerySamples
 	noStepSubqueryIntervalFn func(rangeMillis int64) int64
+	// memory is nil if the memory of the query is not accounted.
+	memory *pgquerier.QueryMemory
+}
+
+// updatePeak records the number of samples currently loaded, and aborts the
+// evaluation if the query uses more memory than its limit.
+func (ev *evaluator) updatePeak() {
+	ev.samplesStats.UpdatePeak(ev.currentSamples)
+	if err := ev.memory.SetPoints(ev.currentSamples); err != nil {
+		ev.error(err)
+	}
 }
 
 // errorf causes a panic with the input formatted into an error.
@@ -1172,7 +1252,7 @@ func (ev *evaluator) rangeEval(prepSeries func(labels.Labels, *EvalSeriesHelper)
 				}
 			}
 			args[i] = vectors[i]
-			ev.samplesStats.UpdatePeak(ev.currentSamples)
+			ev.updatePeak()
 		}
 
 		// Make the function call.
@@ -1188,12 +1268,12 @@ func (ev *evaluator) rangeEval(prepSeries func(labels.Labels, *EvalSeriesHelper)
 		// When we reset currentSamples to tempNumSamples during the next iteration of the loop it also
 		// needs to include the samples from the result here, as they're still in memory.
 		tempNumSamples += len(result)
-		ev.samplesStats.UpdatePeak(ev.currentSamples)
+		ev.updatePeak()
 
 		if ev.currentSamples > ev.maxSamples {
 			ev.error(ErrTooManySamples(env))
 		}
-		ev.samplesStats.UpdatePeak(ev.currentSamples)
+		ev.updatePeak()
 
 		// If this could be an instant query, shortcut so as not to change sort order.
 		if ev.endTimestamp == ev.startTimestamp {
@@ -1203,7 +1283,7 @@ func (ev *evaluator) rangeEval(prepSeries func(labels.Labels, *EvalSeriesHelper)
 				mat[i] = Series{Metric: s.Metric, Points: []Point{s.Point}}
 			}
 			ev.currentSamples = originalNumSamples + mat.TotalSamples()
-			ev.samplesStats.UpdatePeak(ev.currentSamples)
+			ev.updatePeak()
 			return mat, warnings
 		}
 
@@ -1236,7 +1316,7 @@ func (ev *evaluator) rangeEval(prepSeries func(labels.Labels, *EvalSeriesHelper)
 		mat = append(mat, ss)
 	}
 	ev.currentSamples = originalNumSamples + mat.TotalSamples()
-	ev.samplesStats.UpdatePeak(ev.currentSamples)
+	ev.updatePeak()
 	return mat, warnings
 }
 
@@ -1538,9 +1618,9 @@ func (ev *evaluator) eval(expr parser.Expr) (parser.Value, storage.Warnings) {
 			} else {
 				putPointSlice(ss.Points)
 			}
-			ev.samplesStats.UpdatePeak(ev.currentSamples)
+			ev.updatePeak()
 		}
-		ev.samplesStats.UpdatePeak(ev.currentSamples)
+		ev.updatePeak()
 
 		ev.currentSamples -= len(points)
 		putPointSlice(points)
@@ -1694,7 +1774,7 @@ func (ev *evaluator) eval(expr parser.Expr) (parser.Value, storage.Warnings) {
 				putPointSlice(ss.Points)
 			}
 		}
-		ev.samplesStats.UpdatePeak(ev.currentSamples)
+		ev.updatePeak()
 		return mat, ws
 
 	case *parser.MatrixSelector:
@@ -1715,6 +1795,7 @@ func (ev *evaluator) eval(expr parser.Expr) (parser.Value, storage.Warnings) {
 			lookbackDelta:            ev.lookbackDelta,
 			samplesStats:             ev.samplesStats.NewChild(),
 			noStepSubqueryIntervalFn: ev.noStepSubqueryIntervalFn,
+			memory:                   ev.memory,
 		}
 
 		if e.Step != 0 {
@@ -1760,6 +1841,7 @@ func (ev *evaluator) eval(expr parser.Expr) (parser.Value, storage.Warnings) {
 			lookbackDelta:            ev.lookbackDelta,
 			samplesStats:             ev.samplesStats.NewChild(),
 			noStepSubqueryIntervalFn: ev.noStepSubqueryIntervalFn,
+			memory:                   ev.memory,
 		}
 		res, ws := newEv.eval(e.Expr)
 		ev.currentSamples = newEv.currentSamples
@@ -1797,7 +1879,7 @@ func (ev *evaluator) eval(expr parser.Expr) (parser.Value, storage.Warnings) {
 				}
 			}
 		}
-		ev.samplesStats.UpdatePeak(ev.currentSamples)
+		ev.updatePeak()
 		return res, ws
 	}
 
@@ -1829,7 +1911,7 @@ func (ev *evaluator) vectorSelector(node *parser.VectorSelector, ts int64) (Vect
 			}
 		}
 	}
-	ev.samplesStats.UpdatePeak(ev.currentSamples)
+	ev.updatePeak()
 	return vec, ws
 }
 
@@ -1977,7 +2059,7 @@ func (ev *evaluator) matrixIterSlice(it *storage.BufferedSeriesIterator, mint, m
 			ev.currentSamples++
 		}
 	}
-	ev.samplesStats.UpdatePeak(ev.currentSamples)
+	ev.updatePeak()
 	return out
 }
 
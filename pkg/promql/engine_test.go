@@ -208,7 +208,7 @@ type errQuerier struct {
 func (q *errQuerier) Select(bool, *storage.SelectHints, *querier.QueryHints, []parser.Node, ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
 	return errSeriesSet{err: q.err}, nil
 }
-func (*errQuerier) LabelValues(string) ([]string, storage.Warnings, error) {
+func (*errQuerier) LabelValues(string, ...*labels.Matcher) ([]string, storage.Warnings, error) {
 	return nil, nil, nil
 }
 func (*errQuerier) LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error) {
@@ -255,7 +255,8 @@ func TestQueryError(t *testing.T) {
 }
 
 type noopHintRecordingQueryable struct {
-	hints []*storage.SelectHints
+	hints   []*storage.SelectHints
+	windows []*querier.EvalWindow
 }
 
 func (h *noopHintRecordingQueryable) SamplesQuerier(context.Context, int64, int64) (SamplesQuerier, error) {
@@ -274,6 +275,7 @@ type hintRecordingQuerier struct {
 
 func (h *hintRecordingQuerier) Select(sortSeries bool, hints *storage.SelectHints, qh *querier.QueryHints, p []parser.Node, matchers ...*labels.Matcher) (storage.SeriesSet, parser.Node) {
 	h.h.hints = append(h.h.hints, hints)
+	h.h.windows = append(h.h.windows, qh.EvalWindow)
 	return h.SamplesQuerier.Select(sortSeries, hints, qh, nil, matchers...)
 }
 
@@ -592,6 +594,89 @@ func TestSelectHintsSetCorrectly(t *testing.T) {
 	}
 }
 
+func TestEvalWindowSetCorrectly(t *testing.T) {
+	opts := EngineOpts{
+		Logger:               nil,
+		Reg:                  nil,
+		MaxSamples:           10,
+		Timeout:              10 * time.Second,
+		LookbackDelta:        5 * time.Second,
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	}
+
+	for _, tc := range []struct {
+		query string
+
+		// All times are in milliseconds.
+		start int64
+		end   int64
+
+		expected []*querier.EvalWindow
+	}{
+		{
+			query: "foo", start: 10000,
+			expected: []*querier.EvalWindow{
+				{Start: 10000, End: 10000},
+			},
+		}, {
+			query: "foo offset -5s", start: 10000, end: 20000,
+			expected: []*querier.EvalWindow{
+				{Start: 10000, End: 20000, Step: 1000, Offset: -5000},
+			},
+		}, {
+			query: "foo @ 15", start: 10000, end: 20000,
+			expected: []*querier.EvalWindow{
+				{Start: 10000, End: 10000, Step: 1000, Offset: -5000},
+			},
+		}, {
+			query: "rate(foo[2m] offset 1m)", start: 200000,
+			expected: []*querier.EvalWindow{
+				{Start: 200000, End: 200000, Offset: 60000},
+			},
+		}, {
+			query: "max_over_time(rate(foo[2s])[10s:3s])", start: 100000, end: 110000,
+			expected: []*querier.EvalWindow{
+				{Start: 90000, End: 110000, Step: 3000},
+			},
+		}, {
+			query: "max_over_time(foo[10s:3s] offset 2s)", start: 100000,
+			expected: []*querier.EvalWindow{
+				{Start: 90000, End: 98000, Step: 3000},
+			},
+		}, {
+			query: "max_over_time((foo @ 50)[10s:3s])", start: 100000,
+			expected: []*querier.EvalWindow{
+				{Start: 90000, End: 90000, Step: 3000, Offset: 40000},
+			},
+		}, {
+			query: "max_over_time(foo[10s:3s] @ 50)", start: 100000,
+			expected: []*querier.EvalWindow{nil},
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			engine := NewEngine(opts)
+			hintsRecorder := &noopHintRecordingQueryable{}
+
+			var (
+				query Query
+				err   error
+			)
+			if tc.end == 0 {
+				query, err = engine.NewInstantQuery(hintsRecorder, nil, tc.query, timestamp.Time(tc.start))
+			} else {
+				query, err = engine.NewRangeQuery(hintsRecorder, nil, tc.query, timestamp.Time(tc.start), timestamp.Time(tc.end), time.Second)
+			}
+			require.NoError(t, err)
+
+			res := query.Exec(context.Background())
+			require.NoError(t, res.Err)
+
+			require.Equal(t, tc.expected, hintsRecorder.windows)
+		})
+	}
+}
+
 func TestEngineShutdown(t *testing.T) {
 	opts := EngineOpts{
 		Logger:     nil,
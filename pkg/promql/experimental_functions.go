@@ -0,0 +1,103 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package promql
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// experimentalFunction is a PromQL function only known to the parser once
+// the experimental functions are enabled.
+type experimentalFunction struct {
+	parser.Function
+	call FunctionCall
+}
+
+// experimentalFunctions are the functions of newer Prometheus versions that
+// this parser does not know about, in a function call form. limitk and
+// limit_ratio are aggregations in Prometheus: the function form has the same
+// syntax, without the by and without clauses.
+var experimentalFunctions = []experimentalFunction{
+	{
+		Function: parser.Function{
+			Name:       "limitk",
+			ArgTypes:   []parser.ValueType{parser.ValueTypeScalar, parser.ValueTypeVector},
+			ReturnType: parser.ValueTypeVector,
+		},
+		call: funcLimitK,
+	},
+	{
+		Function: parser.Function{
+			Name:       "limit_ratio",
+			ArgTypes:   []parser.ValueType{parser.ValueTypeScalar, parser.ValueTypeVector},
+			ReturnType: parser.ValueTypeVector,
+		},
+		call: funcLimitRatio,
+	},
+}
+
+var enableExperimentalFunctions sync.Once
+
+// EnableExperimentalFunctions registers the experimental functions with the
+// parser, which is shared by the whole process. It must be called before
+// the queries using them are parsed.
+func EnableExperimentalFunctions() {
+	enableExperimentalFunctions.Do(func() {
+		for i := range experimentalFunctions {
+			f := &experimentalFunctions[i]
+			parser.Functions[f.Name] = &f.Function
+			FunctionCalls[f.Name] = f.call
+		}
+	})
+}
+
+// === limitk(k scalar, v Vector) Vector ===
+// limitk keeps k series of the vector. The series are picked by the hash of
+// their labels, so that the same series are kept at every step.
+func funcLimitK(vals []parser.Value, args parser.Expressions, enh *EvalNodeHelper) Vector {
+	k := vals[0].(Vector)[0].V
+	vec := vals[1].(Vector)
+	if math.IsNaN(k) || k < 1 {
+		return enh.Out
+	}
+	if k >= float64(len(vec)) {
+		return append(enh.Out, vec...)
+	}
+	hashes := make([]uint64, len(vec))
+	order := make([]int, len(vec))
+	for i, s := range vec {
+		hashes[i] = s.Metric.Hash()
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return hashes[order[i]] < hashes[order[j]] })
+	for _, i := range order[:int(k)] {
+		enh.Out = append(enh.Out, vec[i])
+	}
+	return enh.Out
+}
+
+// === limit_ratio(r scalar, v Vector) Vector ===
+// limit_ratio keeps about the ratio r of the series of the vector, picked by
+// the hash of their labels as in Prometheus. A negative ratio keeps the
+// complement of the series kept by the same positive ratio, so that
+// limit_ratio(r, v) and limit_ratio(-(1-r), v) split the vector. The ratio is
+// clamped to [-1, 1].
+func funcLimitRatio(vals []parser.Value, args parser.Expressions, enh *EvalNodeHelper) Vector {
+	r := vals[0].(Vector)[0].V
+	if math.IsNaN(r) {
+		return enh.Out
+	}
+	r = math.Max(-1, math.Min(1, r))
+	for _, s := range vals[1].(Vector) {
+		if sampleRatio := float64(s.Metric.Hash()) / float64(math.MaxUint64); (r >= 0 && sampleRatio < r) || (r < 0 && sampleRatio >= 1+r) {
+			enh.Out = append(enh.Out, s)
+		}
+	}
+	return enh.Out
+}
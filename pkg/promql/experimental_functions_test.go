@@ -0,0 +1,63 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+func TestExperimentalFunctions(t *testing.T) {
+	EnableExperimentalFunctions()
+	test, err := NewTest(t, `
+load 1m
+	requests{pod="a"} 0+1x10
+	requests{pod="b"} 0+2x10
+	requests{pod="c"} 0+3x10
+	requests{pod="d"} 0+4x10
+	requests{pod="e"} 0+5x10
+`)
+	require.NoError(t, err)
+	defer test.Close()
+	require.NoError(t, test.Run())
+
+	rangeQuery := func(q string) Matrix {
+		qry, err := test.QueryEngine().NewRangeQuery(test.Queryable(), nil, q, timestamp.Time(0), timestamp.Time(0).Add(10*time.Minute), time.Minute)
+		require.NoError(t, err)
+		res := qry.Exec(test.Context())
+		require.NoError(t, res.Err)
+		mat, err := res.Matrix()
+		require.NoError(t, err)
+		return mat
+	}
+	pods := func(mat Matrix) []string {
+		var pods []string
+		for _, s := range mat {
+			// The same series are kept at every step.
+			require.Len(t, s.Points, 11)
+			pods = append(pods, s.Metric.Get("pod"))
+		}
+		return pods
+	}
+
+	limited := rangeQuery("limitk(2, requests)")
+	require.Len(t, limited, 2)
+	require.Equal(t, "requests", limited[0].Metric.Get(labels.MetricName))
+	require.Equal(t, pods(limited), pods(rangeQuery("limitk(2, requests)")))
+	require.Len(t, rangeQuery("limitk(10, requests)"), 5)
+	require.Empty(t, rangeQuery("limitk(0, requests)"))
+
+	// Opposite ratios split the series.
+	kept, rest := pods(rangeQuery("limit_ratio(0.4, requests)")), pods(rangeQuery("limit_ratio(-0.6, requests)"))
+	require.Len(t, append(kept, rest...), 5)
+	require.ElementsMatch(t, []string{"a", "b", "c", "d", "e"}, append(kept, rest...))
+	require.Len(t, rangeQuery("limit_ratio(2, requests)"), 5)
+	require.Empty(t, rangeQuery("limit_ratio(0, requests)"))
+}
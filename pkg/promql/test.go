@@ -114,7 +114,7 @@ func (t *QuerierWrapper) Select(b bool, sh *storage.SelectHints, _ *querier.Quer
 	return ss, nil
 }
 
-func (t *QuerierWrapper) LabelValues(n string) ([]string, storage.Warnings, error) {
+func (t *QuerierWrapper) LabelValues(n string, _ ...*labels.Matcher) ([]string, storage.Warnings, error) {
 	return nil, nil, nil
 }
 
@@ -39,10 +39,21 @@ type Config struct {
 	LookBackDelta        time.Duration
 	MaxSamples           int
 	MaxPointsPerTs       int64
+	// MaxScannedSamples, MaxSeries and MaxBytes limit the data read from the
+	// database by one query. Zero means unlimited.
+	MaxScannedSamples int64
+	MaxSeries         int64
+	MaxBytes          int64
+	// MaxQueryMemory limits the approximate memory used by one query. Zero
+	// means unlimited.
+	MaxQueryMemory int64
+	// SpilloverToRollups retries the range queries timing out against the
+	// series recorded by the rules with a storage class.
+	SpilloverToRollups bool
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
-	fs.Var(&cfg.PromscaleEnabledFeatureList, "enable-feature", "Enable beta/experimental features as a comma-separated list. Currently the following values can be passed: promql-at-modifier, promql-negative-offset, promql-per-step-stats")
+	fs.Var(&cfg.PromscaleEnabledFeatureList, "enable-feature", "Enable beta/experimental features as a comma-separated list. Currently the following values can be passed: "+featureList())
 
 	fs.DurationVar(&cfg.MaxQueryTimeout, "metrics.promql.query-timeout", DefaultQueryTimeout, "Maximum time a query may take before being aborted. This option sets both the default and maximum value of the 'timeout' parameter in "+
 		"'/api/v1/query.*' endpoints.")
@@ -54,16 +65,30 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 		"so this also limits the number of samples a query can return.")
 	fs.Int64Var(&cfg.MaxPointsPerTs, "metrics.promql.max-points-per-ts", 11000, "Maximum number of points per time-series in a query-range request. "+
 		"This calculation is an estimation, that happens as (start - end)/step where start and end are the 'start' and 'end' timestamps of the query_range.")
+	fs.Int64Var(&cfg.MaxScannedSamples, "metrics.promql.max-scanned-samples", 0, "Maximum number of samples a single query can read from the database. "+
+		"Unlike metrics.promql.max-samples, all the samples selected count, even those not loaded into memory at once. Set to 0 for no limit.")
+	fs.Int64Var(&cfg.MaxSeries, "metrics.promql.max-series", 0, "Maximum number of series a single query can read from the database. Set to 0 for no limit.")
+	fs.Int64Var(&cfg.MaxBytes, "metrics.promql.max-bytes", 0, "Maximum estimated size in bytes of the series and samples a single query can read from the database. Set to 0 for no limit.")
+	fs.Int64Var(&cfg.MaxQueryMemory, "metrics.promql.max-query-memory", 0, "Maximum approximate memory in bytes a single query can use, counting the samples decoded from the database and the intermediate results of the PromQL engine. "+
+		"Queries using more are aborted. Set to 0 for no limit.")
+	fs.BoolVar(&cfg.SpilloverToRollups, "metrics.promql.spillover-to-rollups", false, "Retry the range queries that time out against the downsampled series recorded by the rule groups with a storage class. "+
+		"The subexpressions of the query recorded by such a rule are replaced by the recorded metric, and the response carries a warning and the 'X-Promscale-Downsampled' header.")
 	return cfg
 }
 
 func Validate(cfg *Config) error {
+	if cfg.MaxScannedSamples < 0 || cfg.MaxSeries < 0 || cfg.MaxBytes < 0 {
+		return fmt.Errorf("metrics.promql.max-scanned-samples, metrics.promql.max-series and metrics.promql.max-bytes must not be negative")
+	}
+	if cfg.MaxQueryMemory < 0 {
+		return fmt.Errorf("metrics.promql.max-query-memory must not be negative")
+	}
 	cfg.EnabledFeatureMap = make(map[string]struct{})
 	for _, f := range cfg.PromscaleEnabledFeatureList {
-		switch f {
-		case "promql-at-modifier", "promql-negative-offset", "promql-per-step-stats":
+		switch {
+		case knownFeature(f):
 			cfg.EnabledFeatureMap[f] = struct{}{}
-		case "tracing":
+		case f == "tracing":
 			log.Error("msg", "tracing feature is now on by default, no need to use it with --enable-feature flag")
 		default:
 			return fmt.Errorf("invalid feature: %s", f)
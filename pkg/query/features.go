@@ -0,0 +1,39 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package query
+
+import "strings"
+
+// The beta and experimental features enabled with -enable-feature.
+const (
+	FeatureAtModifier            = "promql-at-modifier"
+	FeatureNegativeOffset        = "promql-negative-offset"
+	FeaturePerStepStats          = "promql-per-step-stats"
+	FeatureExperimentalFunctions = "promql-experimental-functions"
+	FeatureOverTimePushdown      = "promscale-over-time-pushdown"
+)
+
+// features lists the features that can be enabled, in the order of the help
+// of -enable-feature.
+var features = []string{
+	FeatureAtModifier,
+	FeatureNegativeOffset,
+	FeaturePerStepStats,
+	FeatureExperimentalFunctions,
+	FeatureOverTimePushdown,
+}
+
+func knownFeature(name string) bool {
+	for _, f := range features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func featureList() string {
+	return strings.Join(features, ", ")
+}
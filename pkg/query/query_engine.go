@@ -22,9 +22,11 @@ func NewEngine(logger log.Logger, queryTimeout, lookBackDelta, subqueryDefaultSt
 		NoStepSubqueryIntervalFn: func(int64) int64 { return durationMilliseconds(subqueryDefaultStepInterval) },
 	}
 
-	_, engineOpts.EnableAtModifier = enabledFeaturesMap["promql-at-modifier"]
-	_, engineOpts.EnableNegativeOffset = enabledFeaturesMap["promql-negative-offset"]
-	_, engineOpts.EnablePerStepStats = enabledFeaturesMap["promql-per-step-stats"]
+	_, engineOpts.EnableAtModifier = enabledFeaturesMap[FeatureAtModifier]
+	_, engineOpts.EnableNegativeOffset = enabledFeaturesMap[FeatureNegativeOffset]
+	_, engineOpts.EnablePerStepStats = enabledFeaturesMap[FeaturePerStepStats]
+	_, engineOpts.EnableExperimentalFunctions = enabledFeaturesMap[FeatureExperimentalFunctions]
+	_, engineOpts.EnableOverTimePushdown = enabledFeaturesMap[FeatureOverTimePushdown]
 	return promql.NewEngine(engineOpts), nil
 }
 
@@ -6,6 +6,8 @@ package query
 
 import (
 	"context"
+	"regexp"
+	"sort"
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
@@ -48,15 +50,84 @@ func (q queryable) newSamplesQuerier(ctx context.Context, mint, maxt int64) *sam
 	}
 }
 
-func (q samplesQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
+func (q samplesQuerier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	if len(matchers) > 0 {
+		return q.seriesLabels(matchers, func(lbls labels.Labels, values map[string]struct{}) {
+			if v := lbls.Get(name); v != "" {
+				values[v] = struct{}{}
+			}
+		})
+	}
 	lVals, err := q.labelsReader.LabelValues(name)
-	return lVals, nil, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return lVals, nil, pgQuerier.AddLabelValues(q.ctx, lVals)
 }
 
-func (q samplesQuerier) LabelNames(_ ...*labels.Matcher) ([]string, storage.Warnings, error) {
-	// todo: implement labels matcher
+// SearchLabelValues returns the first values of a label name starting with
+// prefix in byte order, at most limit if it is not 0. With matchers, the
+// values are those of the matching series with samples in the time range of
+// the querier, otherwise they are searched in the label index regardless of
+// the time range.
+func (q samplesQuerier) SearchLabelValues(name, prefix string, limit int, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	if len(matchers) > 0 {
+		if prefix != "" {
+			// The series are filtered on the prefix in the database.
+			m, err := labels.NewMatcher(labels.MatchRegexp, name, regexp.QuoteMeta(prefix)+".*")
+			if err != nil {
+				return nil, nil, err
+			}
+			matchers = append(matchers[:len(matchers):len(matchers)], m)
+		}
+		values, warnings, err := q.LabelValues(name, matchers...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lreader.SearchSorted(values, prefix, limit), warnings, nil
+	}
+	values, err := q.labelsReader.SearchLabelValues(name, prefix, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, nil, pgQuerier.AddLabelValues(q.ctx, values)
+}
+
+func (q samplesQuerier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	if len(matchers) > 0 {
+		return q.seriesLabels(matchers, func(lbls labels.Labels, names map[string]struct{}) {
+			for _, l := range lbls {
+				names[l.Name] = struct{}{}
+			}
+		})
+	}
 	lNames, err := q.labelsReader.LabelNames()
-	return lNames, nil, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return lNames, nil, pgQuerier.AddLabelValues(q.ctx, lNames)
+}
+
+// seriesLabels returns the sorted strings collected from the labels of the
+// series matching the matchers with samples in the time range of the
+// querier. The samples of the series are not fetched.
+func (q samplesQuerier) seriesLabels(matchers []*labels.Matcher, collect func(labels.Labels, map[string]struct{})) ([]string, storage.Warnings, error) {
+	hints := &storage.SelectHints{Start: q.mint, End: q.maxt, Func: "series"}
+	ss, _ := q.metricsReader.SamplesQuerier(q.ctx).Select(q.mint, q.maxt, false, hints, nil, nil, matchers...)
+	defer ss.Close()
+	set := make(map[string]struct{})
+	for ss.Next() {
+		collect(ss.At().Labels(), set)
+	}
+	if err := ss.Err(); err != nil {
+		return nil, nil, err
+	}
+	res := make([]string, 0, len(set))
+	for s := range set {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res, ss.Warnings(), pgQuerier.AddLabelValues(q.ctx, res)
 }
 
 func (q *samplesQuerier) Close() {
@@ -0,0 +1,41 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querylog
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Config holds the query log flags.
+type Config struct {
+	File        string
+	Database    bool
+	MinDuration time.Duration
+}
+
+// ParseFlags registers the query log flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.File, "metrics.query-log.file", "", "File to which a JSON record is appended for every PromQL and remote read query, "+
+		"with the time range, matchers, per-stage timings, rows and samples fetched and peak memory of the query. Empty disables the file query log.")
+	fs.BoolVar(&cfg.Database, "metrics.query-log.database", false, "Store the query log records in the _ps_catalog.query_log table. "+
+		"Records are written in batches in the background, and dropped if the database cannot keep up.")
+	fs.DurationVar(&cfg.MinDuration, "metrics.query-log.min-duration", 0, "Only log the queries taking at least this long. 0 logs every query.")
+	return cfg
+}
+
+// Validate checks the query log flags.
+func Validate(cfg *Config) error {
+	if cfg.MinDuration < 0 {
+		return fmt.Errorf("metrics.query-log.min-duration must not be negative: %s", cfg.MinDuration)
+	}
+	return nil
+}
+
+// Enabled returns true if the queries are logged anywhere.
+func (cfg Config) Enabled() bool {
+	return cfg.File != "" || cfg.Database
+}
@@ -0,0 +1,205 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package querylog writes a structured record of every PromQL and remote read
+// query, with the time spent in each stage of the query, to a file or to the
+// database. The records are meant for capacity planning and for finding the
+// slow queries.
+package querylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// Records waiting to be written to the database. Records logged while
+	// the buffer is full are dropped.
+	bufferSize = 1000
+	batchSize  = 100
+
+	insertSQL = `INSERT INTO _ps_catalog.query_log (time, endpoint, expr, matchers, start_time, end_time, step_seconds,
+tenant, status, duration_seconds, sql_generation_seconds, db_execution_seconds, rows, samples, peak_memory_bytes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+)
+
+var (
+	recordsLogged = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query_log",
+			Name:      "records_total",
+			Help:      "Total number of query log records written, by destination.",
+		}, []string{"destination"},
+	)
+	recordsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "query_log",
+			Name:      "dropped_records_total",
+			Help:      "Total number of query log records that could not be written, by destination.",
+		}, []string{"destination"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(recordsLogged, recordsDropped)
+}
+
+// Record describes one query. Times are in seconds.
+type Record struct {
+	Time                 time.Time `json:"time"`
+	Endpoint             string    `json:"endpoint"`
+	Expr                 string    `json:"expr,omitempty"`
+	Matchers             []string  `json:"matchers"`
+	Start                time.Time `json:"start"`
+	End                  time.Time `json:"end"`
+	StepSeconds          float64   `json:"stepSeconds,omitempty"`
+	Tenant               string    `json:"tenant,omitempty"`
+	Status               int       `json:"status"`
+	DurationSeconds      float64   `json:"durationSeconds"`
+	SQLGenerationSeconds float64   `json:"sqlGenerationSeconds"`
+	DBExecutionSeconds   float64   `json:"dbExecutionSeconds"`
+	Rows                 int64     `json:"rows"`
+	Samples              int64     `json:"samples"`
+	PeakMemoryBytes      int64     `json:"peakMemoryBytes"`
+}
+
+// Logger writes the query log records. A nil Logger does not log anything.
+type Logger struct {
+	minDuration time.Duration
+
+	mu   sync.Mutex
+	file io.WriteCloser
+	enc  *json.Encoder
+
+	records chan Record
+}
+
+// New returns a Logger writing to the destinations of the config, or nil if
+// the query log is disabled. The records are only written to the database
+// while Run is running.
+func New(cfg Config) (*Logger, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	l := &Logger{minDuration: cfg.MinDuration}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening query log file: %w", err)
+		}
+		l.file = f
+		l.enc = json.NewEncoder(f)
+	}
+	if cfg.Database {
+		l.records = make(chan Record, bufferSize)
+	}
+	return l, nil
+}
+
+// Log writes the record of a query, completed with its stats, unless the
+// query was faster than the minimum duration.
+func (l *Logger) Log(r Record, stats *Stats) {
+	if l == nil || time.Duration(r.DurationSeconds*float64(time.Second)) < l.minDuration {
+		return
+	}
+	stats.fill(&r)
+	if r.Matchers == nil {
+		r.Matchers = []string{}
+	}
+	if l.enc != nil {
+		l.writeFile(r)
+	}
+	if l.records != nil {
+		select {
+		case l.records <- r:
+		default:
+			recordsDropped.WithLabelValues("database").Inc()
+		}
+	}
+}
+
+func (l *Logger) writeFile(r Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(r); err != nil {
+		recordsDropped.WithLabelValues("file").Inc()
+		log.Warn("msg", "Writing to the query log file failed", "err", err)
+		return
+	}
+	recordsLogged.WithLabelValues("file").Inc()
+}
+
+// Run writes the records to the database in batches until the context is
+// done.
+func (l *Logger) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if l == nil || l.records == nil {
+		return
+	}
+	batch := make([]Record, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-l.records:
+			batch = append(batch[:0], r)
+		}
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case r := <-l.records:
+				batch = append(batch, r)
+			default:
+				break fill
+			}
+		}
+		l.insert(ctx, conn, batch)
+	}
+}
+
+func (l *Logger) insert(ctx context.Context, conn pgxconn.PgxConn, records []Record) {
+	batch := conn.NewBatch()
+	for _, r := range records {
+		batch.Queue(insertSQL, r.Time, r.Endpoint, r.Expr, r.Matchers, nullTime(r.Start), nullTime(r.End), r.StepSeconds,
+			r.Tenant, r.Status, r.DurationSeconds, r.SQLGenerationSeconds, r.DBExecutionSeconds, r.Rows, r.Samples, r.PeakMemoryBytes)
+	}
+	results, err := conn.SendBatch(ctx, batch)
+	if err == nil {
+		err = results.Close()
+	}
+	if err != nil {
+		recordsDropped.WithLabelValues("database").Add(float64(len(records)))
+		log.Warn("msg", "Writing the query log records to the database failed", "err", err)
+		return
+	}
+	recordsLogged.WithLabelValues("database").Add(float64(len(records)))
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Close closes the query log file.
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
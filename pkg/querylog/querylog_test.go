@@ -0,0 +1,72 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{}))
+	require.Error(t, Validate(&Config{MinDuration: -time.Second}))
+
+	l, err := New(Config{})
+	require.NoError(t, err)
+	require.Nil(t, l)
+	// A nil Logger does not log anything.
+	l.Log(Record{}, nil)
+	require.NoError(t, l.Close())
+}
+
+func TestLogFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query.log")
+	l, err := New(Config{File: file, MinDuration: time.Second})
+	require.NoError(t, err)
+
+	ctx, stats := WithStats(context.Background())
+	require.Equal(t, stats, FromContext(ctx))
+	stats.AddSelect(1000, 61000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")})
+	stats.AddSQLGeneration(time.Millisecond)
+	stats.AddDBExecution(2 * time.Millisecond)
+	stats.AddRows(2, 120)
+
+	l.Log(Record{Endpoint: "read", Status: 200, DurationSeconds: 2, PeakMemoryBytes: 1920}, stats)
+	// Faster than the minimum duration.
+	l.Log(Record{Endpoint: "query", Expr: "up", Status: 200, DurationSeconds: 0.5}, nil)
+	require.NoError(t, l.Close())
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 1)
+
+	r := records[0]
+	require.Equal(t, "read", r.Endpoint)
+	require.Equal(t, []string{`{__name__="up"}`}, r.Matchers)
+	require.Equal(t, time.UnixMilli(1000).UTC(), r.Start)
+	require.Equal(t, time.UnixMilli(61000).UTC(), r.End)
+	require.Equal(t, time.Millisecond.Seconds(), r.SQLGenerationSeconds)
+	require.Equal(t, (2 * time.Millisecond).Seconds(), r.DBExecutionSeconds)
+	require.Equal(t, int64(2), r.Rows)
+	require.Equal(t, int64(120), r.Samples)
+	require.Equal(t, int64(1920), r.PeakMemoryBytes)
+}
@@ -0,0 +1,163 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package querylog
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Stats collects what the selects of a query did in the database. All the
+// selects of a query, which may run concurrently, share the Stats. A nil
+// Stats does not collect anything.
+type Stats struct {
+	mu            sync.Mutex
+	matchers      []string
+	metrics       []string
+	mint, maxt    int64
+	sqlGeneration time.Duration
+	dbExecution   time.Duration
+	rows          int64
+	samples       int64
+}
+
+type statsKey struct{}
+
+// WithStats returns a context collecting the stats of the query run with it.
+func WithStats(ctx context.Context) (context.Context, *Stats) {
+	s := &Stats{mint: math.MaxInt64, maxt: math.MinInt64}
+	return context.WithValue(ctx, statsKey{}, s), s
+}
+
+// FromContext returns the Stats of a query, or nil if they are not collected.
+func FromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// AddSelect records the matchers and the time range, in milliseconds, of a
+// select.
+func (s *Stats) AddSelect(mint, maxt int64, ms []*labels.Matcher) {
+	if s == nil {
+		return
+	}
+	parts := make([]string, 0, len(ms))
+	metric := ""
+	for _, m := range ms {
+		parts = append(parts, m.String())
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			metric = m.Value
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.matchers = append(s.matchers, "{"+strings.Join(parts, ",")+"}")
+	if metric != "" && !contains(s.metrics, metric) {
+		s.metrics = append(s.metrics, metric)
+	}
+	if mint < s.mint {
+		s.mint = mint
+	}
+	if maxt > s.maxt {
+		s.maxt = maxt
+	}
+}
+
+// AddSQLGeneration records time spent finding the metrics and building the
+// SQL queries.
+func (s *Stats) AddSQLGeneration(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sqlGeneration += d
+}
+
+// AddDBExecution records time spent running the SQL queries and reading
+// their results.
+func (s *Stats) AddDBExecution(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbExecution += d
+}
+
+// AddRows records rows, one per series, and the samples they hold fetched
+// from the database.
+func (s *Stats) AddRows(rows, samples int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows += rows
+	s.samples += samples
+}
+
+// Matchers returns the matchers of the selects, one string per select.
+func (s *Stats) Matchers() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.matchers...)
+}
+
+// Metrics returns the names of the metrics selected by name.
+func (s *Stats) Metrics() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.metrics...)
+}
+
+// Rows returns the number of rows fetched from the database.
+func (s *Stats) Rows() int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fill copies the stats to the record. The time range of the selects is only
+// used when the record has none.
+func (s *Stats) fill(r *Record) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Matchers = append([]string(nil), s.matchers...)
+	if r.Start.IsZero() && s.mint <= s.maxt {
+		r.Start = time.UnixMilli(s.mint).UTC()
+		r.End = time.UnixMilli(s.maxt).UTC()
+	}
+	r.SQLGenerationSeconds = s.sqlGeneration.Seconds()
+	r.DBExecutionSeconds = s.dbExecution.Seconds()
+	r.Rows = s.rows
+	r.Samples = s.samples
+}
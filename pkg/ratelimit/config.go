@@ -0,0 +1,156 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the rate limiting flags.
+type Config struct {
+	LimitsFile     string
+	SpanLimitsFile string
+}
+
+// ParseFlags registers the rate limiting flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.LimitsFile, "metrics.tenant-limits.file", "", "Path to a YAML file with the ingest and query limits of each tenant. "+
+		"The file is reloaded on SIGHUP or a call to the /-/reload endpoint. No limits are applied if empty.")
+	fs.StringVar(&cfg.SpanLimitsFile, "tracing.span-limits.file", "", "Path to a YAML file with the number of spans per second each service can send to the OTLP receiver. "+
+		"The file is reloaded on SIGHUP or a call to the /-/reload endpoint. No limits are applied if empty.")
+	return cfg
+}
+
+// Validate checks that the limits files, if any, can be loaded.
+func Validate(cfg *Config) error {
+	if cfg.LimitsFile != "" {
+		if _, err := loadLimitsFile(cfg.LimitsFile); err != nil {
+			return err
+		}
+	}
+	if cfg.SpanLimitsFile != "" {
+		if _, err := loadSpanLimitsFile(cfg.SpanLimitsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TenantLimits are the limits applied to a single tenant. A value of 0 means
+// that the corresponding limit is disabled.
+type TenantLimits struct {
+	// IngestionRate is the number of samples per second the tenant can write.
+	IngestionRate float64 `yaml:"ingestion_rate"`
+	// IngestionBurst is the number of samples the tenant can write at once.
+	// Defaults to IngestionRate.
+	IngestionBurst int `yaml:"ingestion_burst"`
+	// SeriesCreationRate is the number of new series per second the tenant can write.
+	SeriesCreationRate float64 `yaml:"series_creation_rate"`
+	// SeriesCreationBurst is the number of new series the tenant can write at once.
+	// Defaults to SeriesCreationRate.
+	SeriesCreationBurst int `yaml:"series_creation_burst"`
+	// MaxConcurrentQueries is the number of PromQL queries of the tenant that
+	// can be evaluated at the same time.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+}
+
+// tenantOverrides holds the limits set for a single tenant. Using pointers to
+// tell apart the limits that are not set, which are taken from the defaults.
+type tenantOverrides struct {
+	IngestionRate        *float64 `yaml:"ingestion_rate"`
+	IngestionBurst       *int     `yaml:"ingestion_burst"`
+	SeriesCreationRate   *float64 `yaml:"series_creation_rate"`
+	SeriesCreationBurst  *int     `yaml:"series_creation_burst"`
+	MaxConcurrentQueries *int     `yaml:"max_concurrent_queries"`
+}
+
+// limitsFile is the format of the limits file, e.g.
+//
+//	default:
+//	  ingestion_rate: 100000
+//	  max_concurrent_queries: 20
+//	tenants:
+//	  tenant-a:
+//	    ingestion_rate: 500000
+//
+// Requests without a tenant are limited as the tenant with an empty name.
+type limitsFile struct {
+	Default TenantLimits                `yaml:"default"`
+	Tenants map[string]*tenantOverrides `yaml:"tenants"`
+}
+
+// Limits are the resolved limits of all the tenants.
+type Limits struct {
+	Default TenantLimits
+	Tenants map[string]TenantLimits
+}
+
+// ForTenant returns the limits of the given tenant.
+func (l Limits) ForTenant(tenant string) TenantLimits {
+	if tl, ok := l.Tenants[tenant]; ok {
+		return tl
+	}
+	return l.Default
+}
+
+func loadLimitsFile(path string) (Limits, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Limits{}, fmt.Errorf("reading tenant limits file: %w", err)
+	}
+	return parseLimits(contents)
+}
+
+func parseLimits(contents []byte) (Limits, error) {
+	var f limitsFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return Limits{}, fmt.Errorf("parsing tenant limits: %w", err)
+	}
+	if err := f.Default.validate(); err != nil {
+		return Limits{}, fmt.Errorf("invalid default limits: %w", err)
+	}
+
+	limits := Limits{Default: f.Default, Tenants: make(map[string]TenantLimits, len(f.Tenants))}
+	for tenant, o := range f.Tenants {
+		tl := f.Default
+		if o != nil {
+			o.applyTo(&tl)
+		}
+		if err := tl.validate(); err != nil {
+			return Limits{}, fmt.Errorf("invalid limits for tenant %q: %w", tenant, err)
+		}
+		limits.Tenants[tenant] = tl
+	}
+	return limits, nil
+}
+
+func (o *tenantOverrides) applyTo(tl *TenantLimits) {
+	if o.IngestionRate != nil {
+		tl.IngestionRate = *o.IngestionRate
+	}
+	if o.IngestionBurst != nil {
+		tl.IngestionBurst = *o.IngestionBurst
+	}
+	if o.SeriesCreationRate != nil {
+		tl.SeriesCreationRate = *o.SeriesCreationRate
+	}
+	if o.SeriesCreationBurst != nil {
+		tl.SeriesCreationBurst = *o.SeriesCreationBurst
+	}
+	if o.MaxConcurrentQueries != nil {
+		tl.MaxConcurrentQueries = *o.MaxConcurrentQueries
+	}
+}
+
+func (tl TenantLimits) validate() error {
+	if tl.IngestionRate < 0 || tl.IngestionBurst < 0 || tl.SeriesCreationRate < 0 || tl.SeriesCreationBurst < 0 || tl.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	return nil
+}
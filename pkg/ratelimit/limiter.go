@@ -0,0 +1,239 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+	"github.com/timescale/promscale/pkg/webhook"
+)
+
+// Names of the limits, as used in errors and metrics.
+const (
+	LimitIngestionRate        = "ingestion_rate"
+	LimitSeriesCreationRate   = "series_creation_rate"
+	LimitMaxConcurrentQueries = "max_concurrent_queries"
+)
+
+// queryRetryAfter is the retry delay suggested to the clients of a tenant
+// that has too many running queries, since we cannot know when one completes.
+const queryRetryAfter = time.Second
+
+var rejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "tenant_limits",
+		Name:      "rejected_total",
+		Help:      "Total number of requests rejected because a tenant exceeded one of its limits.",
+	}, []string{"tenant", "limit"},
+)
+
+func init() {
+	prometheus.MustRegister(rejected)
+}
+
+// Error is returned when a tenant exceeds one of its limits.
+type Error struct {
+	Tenant     string
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tenant %q exceeded its %s limit, retry after %s", e.Tenant, e.Limit, e.RetryAfter)
+}
+
+func newError(tenant, limit string, retryAfter time.Duration) *Error {
+	rejected.WithLabelValues(tenant, limit).Inc()
+	return &Error{Tenant: tenant, Limit: limit, RetryAfter: retryAfter}
+}
+
+// Limiter enforces the limits of each tenant. A nil Limiter does not limit anything.
+type Limiter struct {
+	path string
+
+	mu      sync.RWMutex
+	limits  Limits
+	tenants map[string]*tenantState
+}
+
+type tenantState struct {
+	ingestion      *rate.Limiter
+	seriesCreation *rate.Limiter
+	maxQueries     atomic.Int64
+	runningQueries atomic.Int64
+
+	// The usage and rejections of the tenant, for the limits status.
+	ingested          meter
+	seriesCreated     meter
+	rejectedIngestion atomic.Uint64
+	rejectedSeries    atomic.Uint64
+	rejectedQueries   atomic.Uint64
+}
+
+// NewLimiter returns a Limiter with the limits from the limits file of cfg,
+// or nil if no file is configured.
+func NewLimiter(cfg *Config) (*Limiter, error) {
+	if cfg.LimitsFile == "" {
+		return nil, nil
+	}
+	limits, err := loadLimitsFile(cfg.LimitsFile)
+	if err != nil {
+		return nil, err
+	}
+	l := newLimiter(limits)
+	l.path = cfg.LimitsFile
+	return l, nil
+}
+
+func newLimiter(limits Limits) *Limiter {
+	return &Limiter{limits: limits, tenants: make(map[string]*tenantState)}
+}
+
+// Reload reads the limits file again and applies the new limits to all the tenants.
+func (l *Limiter) Reload() error {
+	if l == nil {
+		return nil
+	}
+	limits, err := loadLimitsFile(l.path)
+	if err != nil {
+		return err
+	}
+	l.setLimits(limits)
+	log.Info("msg", "Tenant limits reloaded", "file", l.path, "tenants", len(limits.Tenants))
+	return nil
+}
+
+func (l *Limiter) setLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	for tenant, state := range l.tenants {
+		state.apply(limits.ForTenant(tenant))
+	}
+}
+
+func (l *Limiter) tenant(name string) *tenantState {
+	l.mu.RLock()
+	state, ok := l.tenants[name]
+	l.mu.RUnlock()
+	if ok {
+		return state
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state, ok = l.tenants[name]; ok {
+		return state
+	}
+	tl := l.limits.ForTenant(name)
+	state = &tenantState{
+		ingestion:      rate.NewLimiter(rateAndBurst(tl.IngestionRate, tl.IngestionBurst)),
+		seriesCreation: rate.NewLimiter(rateAndBurst(tl.SeriesCreationRate, tl.SeriesCreationBurst)),
+	}
+	state.maxQueries.Store(int64(tl.MaxConcurrentQueries))
+	l.tenants[name] = state
+	return state
+}
+
+func (s *tenantState) apply(tl TenantLimits) {
+	setRate(s.ingestion, tl.IngestionRate, tl.IngestionBurst)
+	setRate(s.seriesCreation, tl.SeriesCreationRate, tl.SeriesCreationBurst)
+	s.maxQueries.Store(int64(tl.MaxConcurrentQueries))
+}
+
+func setRate(limiter *rate.Limiter, r float64, burst int) {
+	limit, burst := rateAndBurst(r, burst)
+	limiter.SetBurst(burst)
+	limiter.SetLimit(limit)
+}
+
+// rateAndBurst converts a configured rate and burst to the ones of a
+// rate.Limiter, where a rate of 0 means no limit.
+func rateAndBurst(r float64, burst int) (rate.Limit, int) {
+	if r == 0 {
+		return rate.Inf, 0
+	}
+	if burst == 0 {
+		burst = int(math.Max(1, math.Ceil(r)))
+	}
+	return rate.Limit(r), burst
+}
+
+// AllowWrite checks if the tenant can write the given number of samples that
+// create newSeries new series. Nothing is consumed from the limits of the tenant
+// if the write is not allowed, in which case an *Error is returned.
+func (l *Limiter) AllowWrite(tenant string, samples, newSeries int) error {
+	if l == nil {
+		return nil
+	}
+	state := l.tenant(tenant)
+	now := time.Now()
+
+	ingestion := reserve(state.ingestion, now, samples)
+	if delay := ingestion.DelayFrom(now); delay > 0 {
+		ingestion.CancelAt(now)
+		state.rejectedIngestion.Inc()
+		return newError(tenant, LimitIngestionRate, delay)
+	}
+	seriesCreation := reserve(state.seriesCreation, now, newSeries)
+	if delay := seriesCreation.DelayFrom(now); delay > 0 {
+		seriesCreation.CancelAt(now)
+		ingestion.CancelAt(now)
+		state.rejectedSeries.Inc()
+		webhook.Emit(webhook.Event{
+			Type:    webhook.CardinalityLimitBreached,
+			Subject: tenant,
+			Message: fmt.Sprintf("tenant %q exceeded its %s limit", tenant, LimitSeriesCreationRate),
+			Details: map[string]string{
+				"tenant": tenant,
+				"limit":  LimitSeriesCreationRate,
+				"rate":   fmt.Sprintf("%g", float64(state.seriesCreation.Limit())),
+			},
+		})
+		return newError(tenant, LimitSeriesCreationRate, delay)
+	}
+	state.ingested.add(now, samples)
+	state.seriesCreated.add(now, newSeries)
+	return nil
+}
+
+// reserve reserves n tokens from the limiter. Requests bigger than the burst
+// would never be allowed, so they are only charged for the burst and
+// allowed once the bucket is full.
+func reserve(limiter *rate.Limiter, now time.Time, n int) *rate.Reservation {
+	if burst := limiter.Burst(); limiter.Limit() != rate.Inf && n > burst {
+		n = burst
+	}
+	return limiter.ReserveN(now, n)
+}
+
+// AcquireQuery checks if the tenant can run one more query. If so, the returned
+// function must be called once the query completes, otherwise an *Error is returned.
+func (l *Limiter) AcquireQuery(tenant string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	state := l.tenant(tenant)
+	max := state.maxQueries.Load()
+	if max == 0 {
+		return func() {}, nil
+	}
+	if state.runningQueries.Inc() > max {
+		state.runningQueries.Dec()
+		state.rejectedQueries.Inc()
+		return nil, newError(tenant, LimitMaxConcurrentQueries, queryRetryAfter)
+	}
+	return func() { state.runningQueries.Dec() }, nil
+}
@@ -0,0 +1,169 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		expected Limits
+		err      bool
+	}{
+		{
+			name:     "empty",
+			expected: Limits{Tenants: map[string]TenantLimits{}},
+		},
+		{
+			name: "defaults and overrides",
+			contents: `
+default:
+  ingestion_rate: 100
+  max_concurrent_queries: 2
+tenants:
+  a:
+    ingestion_rate: 0
+    series_creation_rate: 10
+  b:
+`,
+			expected: Limits{
+				Default: TenantLimits{IngestionRate: 100, MaxConcurrentQueries: 2},
+				Tenants: map[string]TenantLimits{
+					"a": {SeriesCreationRate: 10, MaxConcurrentQueries: 2},
+					"b": {IngestionRate: 100, MaxConcurrentQueries: 2},
+				},
+			},
+		},
+		{
+			name:     "negative limit",
+			contents: "tenants:\n  a:\n    ingestion_burst: -1\n",
+			err:      true,
+		},
+		{
+			name:     "unknown field",
+			contents: "default:\n  ingestion_rat: 1\n",
+			err:      true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			limits, err := parseLimits([]byte(c.contents))
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, limits)
+		})
+	}
+}
+
+func TestAllowWrite(t *testing.T) {
+	l := newLimiter(Limits{
+		Default: TenantLimits{IngestionRate: 10, SeriesCreationRate: 1, SeriesCreationBurst: 2},
+		Tenants: map[string]TenantLimits{"unlimited": {}},
+	})
+
+	require.NoError(t, l.AllowWrite("unlimited", 1e6, 1e6))
+
+	require.NoError(t, l.AllowWrite("a", 5, 2))
+	err := l.AllowWrite("a", 5, 1)
+	var limitErr *Error
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitSeriesCreationRate, limitErr.Limit)
+	require.Greater(t, limitErr.RetryAfter.Nanoseconds(), int64(0))
+
+	// The samples of the rejected write were not consumed.
+	require.NoError(t, l.AllowWrite("a", 5, 0))
+	err = l.AllowWrite("a", 1, 0)
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitIngestionRate, limitErr.Limit)
+
+	// Writes bigger than the burst are allowed when the bucket is full.
+	require.NoError(t, l.AllowWrite("b", 100, 0))
+
+	l.setLimits(Limits{})
+	require.NoError(t, l.AllowWrite("a", 1e6, 1e6))
+
+	var nilLimiter *Limiter
+	require.NoError(t, nilLimiter.AllowWrite("a", 1e6, 1e6))
+}
+
+func TestAcquireQuery(t *testing.T) {
+	l := newLimiter(Limits{Default: TenantLimits{MaxConcurrentQueries: 1}})
+
+	release, err := l.AcquireQuery("a")
+	require.NoError(t, err)
+	_, err = l.AcquireQuery("a")
+	var limitErr *Error
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitMaxConcurrentQueries, limitErr.Limit)
+
+	releaseB, err := l.AcquireQuery("b")
+	require.NoError(t, err)
+	releaseB()
+
+	release()
+	release, err = l.AcquireQuery("a")
+	require.NoError(t, err)
+	release()
+}
+
+func TestStatus(t *testing.T) {
+	l := newLimiter(Limits{
+		Default: TenantLimits{IngestionRate: 100, MaxConcurrentQueries: 2},
+		Tenants: map[string]TenantLimits{"b": {SeriesCreationRate: 10, SeriesCreationBurst: 5}},
+	})
+
+	// Tenants without requests have no utilization yet.
+	require.Equal(t, &TenantStatus{
+		Tenant: "a",
+		Limits: []LimitStatus{
+			{Name: LimitIngestionRate, Limit: 100, Burst: 100},
+			{Name: LimitSeriesCreationRate},
+			{Name: LimitMaxConcurrentQueries, Limit: 2},
+		},
+	}, l.Status("a"))
+	require.Empty(t, l.tenants)
+
+	require.NoError(t, l.AllowWrite("a", 50, 0))
+	require.Error(t, l.AllowWrite("a", 80, 0))
+	release, err := l.AcquireQuery("a")
+	require.NoError(t, err)
+	defer release()
+
+	status := l.Status("a")
+	require.False(t, status.Override)
+	require.Equal(t, LimitStatus{Name: LimitIngestionRate, Limit: 100, Burst: 100, Current: 5, Utilization: 0.05, Rejected: 1}, status.Limits[0])
+	require.Equal(t, LimitStatus{Name: LimitMaxConcurrentQueries, Limit: 2, Current: 1, Utilization: 0.5}, status.Limits[2])
+
+	status = l.Status("b")
+	require.True(t, status.Override)
+	require.Equal(t, LimitStatus{Name: LimitSeriesCreationRate, Limit: 10, Burst: 5}, status.Limits[1])
+
+	var nilLimiter *Limiter
+	require.Nil(t, nilLimiter.Status("a"))
+}
+
+func TestMeter(t *testing.T) {
+	var m meter
+	now := time.Unix(1000, 0)
+	m.add(now, 10)
+	m.add(now.Add(time.Second), 20)
+	require.Equal(t, 3.0, m.rate(now.Add(time.Second)))
+	require.Equal(t, 2.0, m.rate(now.Add(meterWindow*time.Second)))
+	require.Equal(t, 0.0, m.rate(now.Add(2*meterWindow*time.Second)))
+
+	// Old buckets are reset when reused.
+	m.add(now.Add(meterWindow*time.Second), 5)
+	require.Equal(t, 2.5, m.rate(now.Add(meterWindow*time.Second)))
+}
@@ -0,0 +1,238 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var rejectedSpans = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "span_limits",
+		Name:      "rejected_spans_total",
+		Help:      "Total number of spans rejected because a service exceeded its span rate limit.",
+	}, []string{"service"},
+)
+
+func init() {
+	prometheus.MustRegister(rejectedSpans)
+}
+
+// ServiceLimits are the limits applied to the spans of a single service. A
+// value of 0 means that the corresponding limit is disabled.
+type ServiceLimits struct {
+	// SpanRate is the number of spans per second the service can send.
+	SpanRate float64 `yaml:"span_rate"`
+	// SpanBurst is the number of spans the service can send at once.
+	// Defaults to SpanRate.
+	SpanBurst int `yaml:"span_burst"`
+}
+
+// serviceOverrides holds the limits set for a single service, see tenantOverrides.
+type serviceOverrides struct {
+	SpanRate  *float64 `yaml:"span_rate"`
+	SpanBurst *int     `yaml:"span_burst"`
+}
+
+// spanLimitsFile is the format of the span limits file, e.g.
+//
+//	default:
+//	  span_rate: 10000
+//	services:
+//	  checkout:
+//	    span_rate: 50000
+//	    span_burst: 100000
+//
+// Spans without a service.name resource attribute are limited as the
+// service with an empty name.
+type spanLimitsFile struct {
+	Default  ServiceLimits                `yaml:"default"`
+	Services map[string]*serviceOverrides `yaml:"services"`
+}
+
+// SpanLimits are the resolved limits of all the services.
+type SpanLimits struct {
+	Default  ServiceLimits
+	Services map[string]ServiceLimits
+}
+
+// ForService returns the limits of the given service.
+func (l SpanLimits) ForService(service string) ServiceLimits {
+	if sl, ok := l.Services[service]; ok {
+		return sl
+	}
+	return l.Default
+}
+
+func loadSpanLimitsFile(path string) (SpanLimits, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return SpanLimits{}, fmt.Errorf("reading span limits file: %w", err)
+	}
+	return parseSpanLimits(contents)
+}
+
+func parseSpanLimits(contents []byte) (SpanLimits, error) {
+	var f spanLimitsFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return SpanLimits{}, fmt.Errorf("parsing span limits: %w", err)
+	}
+	if err := f.Default.validate(); err != nil {
+		return SpanLimits{}, fmt.Errorf("invalid default span limits: %w", err)
+	}
+
+	limits := SpanLimits{Default: f.Default, Services: make(map[string]ServiceLimits, len(f.Services))}
+	for service, o := range f.Services {
+		sl := f.Default
+		if o != nil {
+			if o.SpanRate != nil {
+				sl.SpanRate = *o.SpanRate
+			}
+			if o.SpanBurst != nil {
+				sl.SpanBurst = *o.SpanBurst
+			}
+		}
+		if err := sl.validate(); err != nil {
+			return SpanLimits{}, fmt.Errorf("invalid span limits for service %q: %w", service, err)
+		}
+		limits.Services[service] = sl
+	}
+	return limits, nil
+}
+
+func (sl ServiceLimits) validate() error {
+	if sl.SpanRate < 0 || sl.SpanBurst < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	return nil
+}
+
+// SpanLimiter enforces the span rate limit of each service. A nil
+// SpanLimiter does not limit anything.
+type SpanLimiter struct {
+	path string
+
+	mu       sync.RWMutex
+	limits   SpanLimits
+	services map[string]*rate.Limiter
+}
+
+// NewSpanLimiter returns a SpanLimiter with the limits from the span limits
+// file of cfg, or nil if no file is configured.
+func NewSpanLimiter(cfg *Config) (*SpanLimiter, error) {
+	if cfg.SpanLimitsFile == "" {
+		return nil, nil
+	}
+	limits, err := loadSpanLimitsFile(cfg.SpanLimitsFile)
+	if err != nil {
+		return nil, err
+	}
+	l := newSpanLimiter(limits)
+	l.path = cfg.SpanLimitsFile
+	return l, nil
+}
+
+func newSpanLimiter(limits SpanLimits) *SpanLimiter {
+	return &SpanLimiter{limits: limits, services: make(map[string]*rate.Limiter)}
+}
+
+// Reload reads the span limits file again and applies the new limits to all the services.
+func (l *SpanLimiter) Reload() error {
+	if l == nil {
+		return nil
+	}
+	limits, err := loadSpanLimitsFile(l.path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.limits = limits
+	for service, limiter := range l.services {
+		sl := limits.ForService(service)
+		setRate(limiter, sl.SpanRate, sl.SpanBurst)
+	}
+	l.mu.Unlock()
+	log.Info("msg", "Span limits reloaded", "file", l.path, "services", len(limits.Services))
+	return nil
+}
+
+// Limit returns the span rate limit of the service, or 0 if it is not limited.
+func (l *SpanLimiter) Limit(service string) float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.limits.ForService(service).SpanRate
+}
+
+func (l *SpanLimiter) service(name string) *rate.Limiter {
+	l.mu.RLock()
+	limiter, ok := l.services[name]
+	l.mu.RUnlock()
+	if ok {
+		return limiter
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter, ok = l.services[name]; ok {
+		return limiter
+	}
+	sl := l.limits.ForService(name)
+	limiter = rate.NewLimiter(rateAndBurst(sl.SpanRate, sl.SpanBurst))
+	l.services[name] = limiter
+	return limiter
+}
+
+// AllowSpans checks how many of the n spans the service can send now. It
+// returns the number of allowed spans, which are consumed from the limit of
+// the service, and if some are rejected, how long until the service can send
+// the rejected spans.
+func (l *SpanLimiter) AllowSpans(service string, n int) (allowed int, retryAfter time.Duration) {
+	if l == nil || n <= 0 {
+		return n, 0
+	}
+	limiter := l.service(service)
+	if limiter.Limit() == rate.Inf {
+		return n, 0
+	}
+	now := time.Now()
+
+	// Unlike AllowWrite, batches bigger than the burst are not allowed at
+	// once, only the spans that fit in the bucket are.
+	wanted := n
+	if burst := limiter.Burst(); wanted > burst {
+		wanted = burst
+	}
+	r := limiter.ReserveN(now, wanted)
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		// The missing tokens are the ones that would be refilled during the delay.
+		r.CancelAt(now)
+		missing := int(math.Ceil(delay.Seconds() * float64(limiter.Limit())))
+		wanted -= missing
+		if wanted <= 0 || !limiter.AllowN(now, wanted) {
+			wanted = 0
+		}
+	}
+	if rejected := n - wanted; rejected > 0 {
+		rejectedSpans.WithLabelValues(service).Add(float64(rejected))
+		retryAfter = time.Duration(float64(rejected) / float64(limiter.Limit()) * float64(time.Second))
+	}
+	return wanted, retryAfter
+}
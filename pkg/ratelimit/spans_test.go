@@ -0,0 +1,71 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpanLimits(t *testing.T) {
+	limits, err := parseSpanLimits([]byte(`
+default:
+  span_rate: 100
+services:
+  checkout:
+    span_burst: 500
+  unlimited:
+    span_rate: 0
+  other:
+`))
+	require.NoError(t, err)
+	require.Equal(t, SpanLimits{
+		Default: ServiceLimits{SpanRate: 100},
+		Services: map[string]ServiceLimits{
+			"checkout":  {SpanRate: 100, SpanBurst: 500},
+			"unlimited": {},
+			"other":     {SpanRate: 100},
+		},
+	}, limits)
+	require.Equal(t, ServiceLimits{SpanRate: 100}, limits.ForService("unknown"))
+
+	_, err = parseSpanLimits([]byte("default:\n  span_rate: -1\n"))
+	require.Error(t, err)
+	_, err = parseSpanLimits([]byte("tenants: {}\n"))
+	require.Error(t, err)
+}
+
+func TestAllowSpans(t *testing.T) {
+	l := newSpanLimiter(SpanLimits{
+		Default:  ServiceLimits{SpanRate: 10, SpanBurst: 20},
+		Services: map[string]ServiceLimits{"unlimited": {}},
+	})
+
+	allowed, retryAfter := l.AllowSpans("unlimited", 1e6)
+	require.Equal(t, 1000000, allowed)
+	require.Zero(t, retryAfter)
+
+	allowed, _ = l.AllowSpans("a", 15)
+	require.Equal(t, 15, allowed)
+
+	// Only the spans left in the bucket are allowed.
+	allowed, retryAfter = l.AllowSpans("a", 10)
+	require.Equal(t, 5, allowed)
+	require.Greater(t, retryAfter.Nanoseconds(), int64(0))
+
+	// Batches bigger than the burst are cut to the burst.
+	allowed, _ = l.AllowSpans("b", 30)
+	require.Equal(t, 20, allowed)
+
+	allowed, _ = l.AllowSpans("b", 10)
+	require.Equal(t, 0, allowed)
+	require.Equal(t, 10.0, l.Limit("b"))
+	require.Equal(t, 0.0, l.Limit("unlimited"))
+
+	var nilLimiter *SpanLimiter
+	allowed, _ = nilLimiter.AllowSpans("a", 100)
+	require.Equal(t, 100, allowed)
+}
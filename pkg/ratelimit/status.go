@@ -0,0 +1,112 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// meterWindow is the number of seconds over which the current rates of a
+// tenant are averaged.
+const meterWindow = 10
+
+// meter measures a rate per second over the last meterWindow seconds.
+type meter struct {
+	mu      sync.Mutex
+	seconds [meterWindow]int64
+	counts  [meterWindow]int64
+}
+
+func (m *meter) add(now time.Time, n int) {
+	if n <= 0 {
+		return
+	}
+	sec := now.Unix()
+	i := sec % meterWindow
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seconds[i] != sec {
+		m.seconds[i], m.counts[i] = sec, 0
+	}
+	m.counts[i] += int64(n)
+}
+
+// rate returns the average rate per second of the last meterWindow seconds.
+func (m *meter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for i := range m.seconds {
+		if sec-m.seconds[i] < meterWindow {
+			total += m.counts[i]
+		}
+	}
+	return float64(total) / meterWindow
+}
+
+// LimitStatus is a limit of a tenant and its current utilization.
+type LimitStatus struct {
+	Name string `json:"name"`
+	// Limit is the value of the limit, 0 if it is disabled.
+	Limit float64 `json:"limit"`
+	Burst int     `json:"burst,omitempty"`
+	// Current is the rate per second averaged over the last 10 seconds for
+	// the rate limits and the running queries for the concurrency limit.
+	Current float64 `json:"current"`
+	// Utilization is Current over Limit, 0 if the limit is disabled.
+	Utilization float64 `json:"utilization"`
+	// Rejected is the number of requests rejected by the limit since the
+	// start of the connector.
+	Rejected uint64 `json:"rejected"`
+}
+
+// TenantStatus holds the limits applied to a tenant.
+type TenantStatus struct {
+	Tenant string `json:"tenant"`
+	// Override is true if the tenant has its own limits in the limits file,
+	// instead of the default ones.
+	Override bool          `json:"override"`
+	Limits   []LimitStatus `json:"limits"`
+}
+
+// Status returns the limits of the tenant and its current utilization. A nil
+// Limiter returns nil.
+func (l *Limiter) Status(tenant string) *TenantStatus {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	_, override := l.limits.Tenants[tenant]
+	tl := l.limits.ForTenant(tenant)
+	state := l.tenants[tenant]
+	l.mu.RUnlock()
+
+	ingestion := LimitStatus{Name: LimitIngestionRate, Limit: tl.IngestionRate}
+	seriesCreation := LimitStatus{Name: LimitSeriesCreationRate, Limit: tl.SeriesCreationRate}
+	queries := LimitStatus{Name: LimitMaxConcurrentQueries, Limit: float64(tl.MaxConcurrentQueries)}
+	if tl.IngestionRate > 0 {
+		_, ingestion.Burst = rateAndBurst(tl.IngestionRate, tl.IngestionBurst)
+	}
+	if tl.SeriesCreationRate > 0 {
+		_, seriesCreation.Burst = rateAndBurst(tl.SeriesCreationRate, tl.SeriesCreationBurst)
+	}
+	// Tenants that did not send any request yet have no state.
+	if state != nil {
+		now := time.Now()
+		ingestion.Current, ingestion.Rejected = state.ingested.rate(now), state.rejectedIngestion.Load()
+		seriesCreation.Current, seriesCreation.Rejected = state.seriesCreated.rate(now), state.rejectedSeries.Load()
+		queries.Current, queries.Rejected = float64(state.runningQueries.Load()), state.rejectedQueries.Load()
+	}
+
+	status := &TenantStatus{Tenant: tenant, Override: override, Limits: []LimitStatus{ingestion, seriesCreation, queries}}
+	for i := range status.Limits {
+		if s := &status.Limits[i]; s.Limit > 0 {
+			s.Utilization = s.Current / s.Limit
+		}
+	}
+	return status
+}
@@ -0,0 +1,129 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package relabel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// The actions taken on a written series with a different value for one of
+// the external labels.
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictDrop      = "drop"
+	ConflictReject    = "reject"
+)
+
+var externalLabelConflicts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "relabel",
+		Name:      "external_label_conflicts_total",
+		Help:      "Total number of written series with a value conflicting with one of the enforced external labels.",
+	},
+	[]string{"label", "action"},
+)
+
+func init() {
+	prometheus.MustRegister(externalLabelConflicts)
+}
+
+// ParseLabelSet parses a comma separated list of name=value labels, e.g.
+// 'cluster=eu1,region=eu'.
+func ParseLabelSet(s string) (labels.Labels, error) {
+	b := labels.NewBuilder(nil)
+	seen := make(map[string]struct{})
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not of the form name=value", pair)
+		}
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !model.LabelName(name).IsValid() || name == labels.MetricName {
+			return nil, fmt.Errorf("%q is not a valid external label name", name)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("external label %q has an empty value", name)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate external label %q", name)
+		}
+		seen[name] = struct{}{}
+		b.Set(name, value)
+	}
+	return b.Labels(), nil
+}
+
+// ConflictError is returned for the writes rejected because a series has a
+// different value for one of the external labels.
+type ConflictError struct {
+	Label, Value, Expected string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("series has %s=%q, expected the external label %s=%q", e.Label, e.Value, e.Label, e.Expected)
+}
+
+// ExternalLabels adds the external labels to the written series, so that the
+// series of all the senders writing to one Promscale are labeled consistently.
+// A nil ExternalLabels does not change anything.
+type ExternalLabels struct {
+	labels     labels.Labels
+	onConflict string
+}
+
+// NewExternalLabels returns the ExternalLabels of cfg, or nil if none is
+// configured.
+func NewExternalLabels(cfg *Config) *ExternalLabels {
+	if len(cfg.ExternalLabels) == 0 {
+		return nil
+	}
+	return &ExternalLabels{labels: cfg.ExternalLabels, onConflict: cfg.ExternalLabelsOnConflict}
+}
+
+// Process adds the external labels missing from the labels of a series. A
+// label with a different value is overwritten, or the series is dropped, or
+// a *ConflictError is returned, depending on the conflict action. It returns
+// the new labels, or false if the series is dropped.
+func (e *ExternalLabels) Process(lbls []prompb.Label) ([]prompb.Label, bool, error) {
+	if e == nil {
+		return lbls, true, nil
+	}
+	res := lbls
+	for _, ext := range e.labels {
+		i := 0
+		for i < len(lbls) && lbls[i].Name != ext.Name {
+			i++
+		}
+		if i == len(lbls) {
+			res = append(res, prompb.Label{Name: ext.Name, Value: ext.Value})
+			continue
+		}
+		if lbls[i].Value == ext.Value {
+			continue
+		}
+		externalLabelConflicts.WithLabelValues(ext.Name, e.onConflict).Inc()
+		switch e.onConflict {
+		case ConflictDrop:
+			return nil, false, nil
+		case ConflictReject:
+			return nil, false, &ConflictError{Label: ext.Name, Value: lbls[i].Value, Expected: ext.Value}
+		default:
+			res[i].Value = ext.Value
+		}
+	}
+	return res, true, nil
+}
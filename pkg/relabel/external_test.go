@@ -0,0 +1,92 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package relabel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestParseLabelSet(t *testing.T) {
+	lset, err := ParseLabelSet("replica=a, cluster=eu1")
+	require.NoError(t, err)
+	require.Equal(t, labels.FromStrings("cluster", "eu1", "replica", "a"), lset)
+
+	lset, err = ParseLabelSet("")
+	require.NoError(t, err)
+	require.Empty(t, lset)
+
+	for _, s := range []string{"cluster", "cluster=", "__name__=up", "1a=b", "a=b,a=c"} {
+		_, err = ParseLabelSet(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestExternalLabels(t *testing.T) {
+	external := labels.FromStrings("cluster", "eu1", "region", "eu")
+	testCases := []struct {
+		name       string
+		onConflict string
+		labels     []prompb.Label
+		expected   []prompb.Label
+		dropped    bool
+		conflict   bool
+	}{
+		{
+			name:       "add missing",
+			onConflict: ConflictOverwrite,
+			labels:     []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+			expected:   []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}, {Name: "cluster", Value: "eu1"}, {Name: "region", Value: "eu"}},
+		},
+		{
+			name:       "same value",
+			onConflict: ConflictReject,
+			labels:     []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "eu1"}},
+			expected:   []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "eu1"}, {Name: "region", Value: "eu"}},
+		},
+		{
+			name:       "overwrite",
+			onConflict: ConflictOverwrite,
+			labels:     []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "us1"}, {Name: "region", Value: "eu"}},
+			expected:   []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "eu1"}, {Name: "region", Value: "eu"}},
+		},
+		{
+			name:       "drop",
+			onConflict: ConflictDrop,
+			labels:     []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "region", Value: "us"}},
+			dropped:    true,
+		},
+		{
+			name:       "reject",
+			onConflict: ConflictReject,
+			labels:     []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "region", Value: "us"}},
+			dropped:    true,
+			conflict:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			e := NewExternalLabels(&Config{ExternalLabels: external, ExternalLabelsOnConflict: c.onConflict})
+			lbls, keep, err := e.Process(c.labels)
+			var conflictErr *ConflictError
+			require.Equal(t, c.conflict, errors.As(err, &conflictErr))
+			require.Equal(t, !c.dropped, keep)
+			require.Equal(t, c.expected, lbls)
+		})
+	}
+
+	require.Nil(t, NewExternalLabels(&Config{}))
+	var e *ExternalLabels
+	lbls := []prompb.Label{{Name: "__name__", Value: "up"}}
+	res, keep, err := e.Process(lbls)
+	require.NoError(t, err)
+	require.True(t, keep)
+	require.Equal(t, lbls, res)
+}
@@ -0,0 +1,182 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package relabel applies Prometheus write relabeling rules to the series
+// written to Promscale, e.g. to drop high-cardinality labels for all the
+// Prometheus instances writing to the connector.
+package relabel
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var droppedSeries = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "relabel",
+		Name:      "dropped_series_total",
+		Help:      "Total number of written series dropped by the write relabeling rules.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(droppedSeries)
+}
+
+// Config holds the relabeling flags.
+type Config struct {
+	ConfigFile string
+
+	externalLabelsStr string
+	// ExternalLabels are added to all the written series.
+	ExternalLabels labels.Labels
+	// ExternalLabelsOnConflict is the action taken on the series with a
+	// different value for one of the external labels.
+	ExternalLabelsOnConflict string
+}
+
+// ParseFlags registers the relabeling flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.ConfigFile, "metrics.relabel-configs-file", "", "Path to a YAML file with Prometheus write_relabel_configs applied to the written series before they are stored. "+
+		"The file is reloaded on SIGHUP or a call to the /-/reload endpoint. No relabeling is applied if empty.")
+	fs.StringVar(&cfg.externalLabelsStr, "metrics.external-labels", "", "Comma separated list of name=value labels added to all the written series after relabeling, e.g. 'cluster=eu1,region=eu'. "+
+		"Use it to label the series of all the senders writing to this Promscale consistently.")
+	fs.StringVar(&cfg.ExternalLabelsOnConflict, "metrics.external-labels.on-conflict", ConflictOverwrite, "Action taken on a written series with a different value for one of -metrics.external-labels: "+
+		"'overwrite' replaces the value, 'drop' drops the series and 'reject' rejects the whole write request with 400.")
+	return cfg
+}
+
+// Validate checks that the relabeling file, if any, can be loaded, and parses
+// the external labels.
+func Validate(cfg *Config) error {
+	lset, err := ParseLabelSet(cfg.externalLabelsStr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics.external-labels: %w", err)
+	}
+	cfg.ExternalLabels = lset
+	switch cfg.ExternalLabelsOnConflict {
+	case ConflictOverwrite, ConflictDrop, ConflictReject:
+	default:
+		return fmt.Errorf("invalid metrics.external-labels.on-conflict %q, must be one of %s, %s or %s", cfg.ExternalLabelsOnConflict, ConflictOverwrite, ConflictDrop, ConflictReject)
+	}
+	if cfg.ConfigFile == "" {
+		return nil
+	}
+	_, err = loadConfigFile(cfg.ConfigFile)
+	return err
+}
+
+// configFile is the format of the relabeling file, e.g.
+//
+//	write_relabel_configs:
+//	  - action: labeldrop
+//	    regex: pod_uid|container_id
+//	  - source_labels: [__name__]
+//	    regex: go_gc_.*
+//	    action: drop
+type configFile struct {
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs"`
+}
+
+func loadConfigFile(path string) ([]*relabel.Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading relabeling file: %w", err)
+	}
+	return parseConfigs(contents)
+}
+
+func parseConfigs(contents []byte) ([]*relabel.Config, error) {
+	var f configFile
+	if err := yaml.UnmarshalStrict(contents, &f); err != nil {
+		return nil, fmt.Errorf("parsing relabeling file: %w", err)
+	}
+	for i, c := range f.WriteRelabelConfigs {
+		if c == nil {
+			return nil, fmt.Errorf("empty relabeling rule at position %d", i+1)
+		}
+	}
+	return f.WriteRelabelConfigs, nil
+}
+
+// Relabeler applies the write relabeling rules to the written series. A nil
+// Relabeler does not change anything.
+type Relabeler struct {
+	path string
+
+	mu      sync.RWMutex
+	configs []*relabel.Config
+}
+
+// NewRelabeler returns a Relabeler with the rules from the relabeling file of
+// cfg, or nil if no file is configured.
+func NewRelabeler(cfg *Config) (*Relabeler, error) {
+	if cfg.ConfigFile == "" {
+		return nil, nil
+	}
+	configs, err := loadConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Relabeler{path: cfg.ConfigFile, configs: configs}, nil
+}
+
+// Reload reads the relabeling file again and applies the new rules to the
+// following writes.
+func (r *Relabeler) Reload() error {
+	if r == nil {
+		return nil
+	}
+	configs, err := loadConfigFile(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.configs = configs
+	r.mu.Unlock()
+	log.Info("msg", "Relabeling rules reloaded", "file", r.path, "rules", len(configs))
+	return nil
+}
+
+// Process applies the relabeling rules to the labels of a series. It returns
+// the new labels, or false if the series is dropped.
+func (r *Relabeler) Process(lbls []prompb.Label) ([]prompb.Label, bool) {
+	if r == nil {
+		return lbls, true
+	}
+	r.mu.RLock()
+	configs := r.configs
+	r.mu.RUnlock()
+	if len(configs) == 0 {
+		return lbls, true
+	}
+
+	ls := make(labels.Labels, len(lbls))
+	for i, l := range lbls {
+		ls[i] = labels.Label{Name: l.Name, Value: l.Value}
+	}
+	ls = relabel.Process(ls, configs...)
+	if len(ls) == 0 {
+		droppedSeries.Inc()
+		return nil, false
+	}
+
+	res := make([]prompb.Label, len(ls))
+	for i, l := range ls {
+		res[i] = prompb.Label{Name: l.Name, Value: l.Value}
+	}
+	return res, true
+}
@@ -0,0 +1,136 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package relabel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+const testConfig = `
+write_relabel_configs:
+  - action: labeldrop
+    regex: pod_uid
+  - source_labels: [__name__]
+    regex: go_gc_.*
+    action: drop
+  - source_labels: [job]
+    regex: (.*)-canary
+    target_label: track
+    replacement: canary
+  - source_labels: [instance]
+    target_label: __tmp_hash
+    modulus: 2
+    action: hashmod
+  - source_labels: [__name__, __tmp_hash]
+    regex: sampled;0
+    action: drop
+  - action: labeldrop
+    regex: __tmp_hash
+`
+
+func TestProcess(t *testing.T) {
+	configs, err := parseConfigs([]byte(testConfig))
+	require.NoError(t, err)
+	r := &Relabeler{configs: configs}
+
+	testCases := []struct {
+		name     string
+		labels   []prompb.Label
+		expected []prompb.Label
+		dropped  bool
+	}{
+		{
+			name:     "drop label",
+			labels:   []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "pod_uid", Value: "1234"}, {Name: "job", Value: "api"}},
+			expected: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+		},
+		{
+			name:    "drop series",
+			labels:  []prompb.Label{{Name: "__name__", Value: "go_gc_duration_seconds"}, {Name: "job", Value: "api"}},
+			dropped: true,
+		},
+		{
+			name:     "replace",
+			labels:   []prompb.Label{{Name: "job", Value: "api-canary"}, {Name: "__name__", Value: "up"}},
+			expected: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api-canary"}, {Name: "track", Value: "canary"}},
+		},
+		{
+			name:     "hashmod kept",
+			labels:   []prompb.Label{{Name: "__name__", Value: "sampled"}, {Name: "instance", Value: "host-2:9100"}},
+			expected: []prompb.Label{{Name: "__name__", Value: "sampled"}, {Name: "instance", Value: "host-2:9100"}},
+		},
+		{
+			name:    "hashmod dropped",
+			labels:  []prompb.Label{{Name: "__name__", Value: "sampled"}, {Name: "instance", Value: "host-1:9100"}},
+			dropped: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			labels, keep := r.Process(c.labels)
+			require.Equal(t, !c.dropped, keep)
+			require.Equal(t, c.expected, labels)
+		})
+	}
+
+	var nilRelabeler *Relabeler
+	labels := []prompb.Label{{Name: "__name__", Value: "go_gc_duration_seconds"}}
+	res, keep := nilRelabeler.Process(labels)
+	require.True(t, keep)
+	require.Equal(t, labels, res)
+}
+
+func TestParseConfigs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		rules    int
+		err      bool
+	}{
+		{name: "empty"},
+		{name: "rules", contents: testConfig, rules: 6},
+		{name: "unknown action", contents: "write_relabel_configs:\n  - action: dropp\n", err: true},
+		{name: "unknown field", contents: "relabel_configs:\n  - action: drop\n", err: true},
+		{name: "empty rule", contents: "write_relabel_configs:\n  -\n", err: true},
+		{name: "hashmod without modulus", contents: "write_relabel_configs:\n  - action: hashmod\n    target_label: a\n", err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			configs, err := parseConfigs([]byte(c.contents))
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, configs, c.rules)
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0600))
+
+	r, err := NewRelabeler(&Config{ConfigFile: path})
+	require.NoError(t, err)
+	_, keep := r.Process([]prompb.Label{{Name: "__name__", Value: "go_gc_duration_seconds"}})
+	require.False(t, keep)
+
+	require.NoError(t, os.WriteFile(path, []byte("write_relabel_configs: []\n"), 0600))
+	require.NoError(t, r.Reload())
+	_, keep = r.Process([]prompb.Label{{Name: "__name__", Value: "go_gc_duration_seconds"}})
+	require.True(t, keep)
+
+	r, err = NewRelabeler(&Config{})
+	require.NoError(t, err)
+	require.Nil(t, r)
+	require.NoError(t, r.Reload())
+}
@@ -56,7 +56,7 @@ func (app *appenderAdapter) Append(_ storage.SeriesRef, l labels.Labels, t int64
 	if err := app.shouldAppend(); err != nil {
 		return 0, err
 	}
-	dbIngestor, err := getIngestor(app.inserter)
+	dbIngestor, err := getIngestor(app.inserter, l.Get(labels.MetricName))
 	if err != nil {
 		return 0, fmt.Errorf("get ingestor: %w", err)
 	}
@@ -92,15 +92,28 @@ func (app *appenderAdapter) Commit() error {
 	//
 	// An error might occur while ingesting samples, so Prometheus will call the app.Rollback(). Do note that we cannot
 	// rollback the ingested series, rather only ingested samples since they were the last step that created the error.
-	dbIngestor, err := getIngestor(app.inserter)
-	if err != nil {
-		return fmt.Errorf("get ingestor: %w", err)
+	//
+	// With sharding, the metrics of each shard are inserted by its ingestor.
+	rows := make(map[*ingestor.DBIngestor]map[string][]model.Insertable)
+	for metricName, insertables := range app.data {
+		dbIngestor, err := getIngestor(app.inserter, metricName)
+		if err != nil {
+			return fmt.Errorf("get ingestor: %w", err)
+		}
+		if rows[dbIngestor] == nil {
+			rows[dbIngestor] = make(map[string][]model.Insertable)
+		}
+		rows[dbIngestor][metricName] = insertables
 	}
-	numInsertablesIngested, err := dbIngestor.Dispatcher().InsertTs(context.Background(), model.Data{Rows: app.data, ReceivedTime: time.Now()})
-	if err == nil {
+	receivedTime := time.Now()
+	for dbIngestor, data := range rows {
+		numInsertablesIngested, err := dbIngestor.Dispatcher().InsertTs(context.Background(), model.Data{Rows: data, ReceivedTime: receivedTime})
+		if err != nil {
+			return errors.WithMessage(err, "rules: error ingesting data into db-ingestor")
+		}
 		samplesIngested.Add(float64(numInsertablesIngested))
 	}
-	return errors.WithMessage(err, "rules: error ingesting data into db-ingestor")
+	return nil
 }
 
 func (app *appenderAdapter) shouldAppend() error {
@@ -117,10 +130,14 @@ func (app *appenderAdapter) Rollback() error {
 	return nil
 }
 
-func getIngestor(inserter ingestor.DBInserter) (*ingestor.DBIngestor, error) {
-	dbIngestor, ok := inserter.(*ingestor.DBIngestor)
-	if !ok {
-		return nil, fmt.Errorf("unable to ingest: DBIngestor not found. Received %T", inserter)
+// getIngestor returns the ingestor of the metric, which is the ingestor of its
+// shard if the metrics are sharded.
+func getIngestor(inserter ingestor.DBInserter, metricName string) (*ingestor.DBIngestor, error) {
+	switch ing := inserter.(type) {
+	case *ingestor.DBIngestor:
+		return ing, nil
+	case *ingestor.ShardedIngestor:
+		return ing.Shard(metricName), nil
 	}
-	return dbIngestor, nil
+	return nil, fmt.Errorf("unable to ingest: DBIngestor not found. Received %T", inserter)
 }
@@ -28,6 +28,10 @@ type Config struct {
 	ResendDelay               time.Duration
 	PrometheusConfigAddress   string
 	PrometheusConfig          *prometheus_config.Config
+	AnnotationLookupsFile     string
+	AnnotationLookups         map[string]Lookup
+	StorageClassesFile        string
+	StorageClasses            map[string]StorageClass
 }
 
 func (cfg *Config) ContainsRules() bool {
@@ -46,10 +50,30 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.StringVar(&cfg.PrometheusConfigAddress, "metrics.rules.config-file", "", "Path to configuration file in Prometheus-format, containing `rule_files` and optional `alerting`, `global` fields. "+
 		"For more details, see https://prometheus.io/docs/prometheus/latest/configuration/configuration/. "+
 		"Note: If this is flag empty or `rule_files` is empty, Promscale rule-manager will not start. If `alertmanagers` is empty, alerting will not be initialized.")
+	fs.StringVar(&cfg.AnnotationLookupsFile, "metrics.rules.annotation-lookups-file", "", "Path to a YAML file with named, parameterized SQL queries that alerting rule annotations can run "+
+		"by passing `@lookup:<name>(<args>)` to the `query` template function. The arguments are sent as query parameters and the queries run in read-only transactions.")
+	fs.StringVar(&cfg.StorageClassesFile, "metrics.rules.storage-classes-file", "", "Path to a YAML file with named storage classes setting the chunk interval, compression and retention "+
+		"of the metrics recorded by the rule groups that select them with the `storage_class` field.")
 	return cfg
 }
 
 func Validate(cfg *Config) error {
+	cfg.AnnotationLookups = nil
+	if cfg.AnnotationLookupsFile != "" {
+		lookups, err := loadLookups(cfg.AnnotationLookupsFile)
+		if err != nil {
+			return err
+		}
+		cfg.AnnotationLookups = lookups
+	}
+	cfg.StorageClasses = nil
+	if cfg.StorageClassesFile != "" {
+		classes, err := loadStorageClasses(cfg.StorageClassesFile)
+		if err != nil {
+			return err
+		}
+		cfg.StorageClasses = classes
+	}
 	if cfg.PrometheusConfigAddress == "" {
 		cfg.PrometheusConfig = &prometheus_config.DefaultConfig
 		return nil
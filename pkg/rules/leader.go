@@ -0,0 +1,141 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	prom_rules "github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// leaderGate skips the rules evaluation while this instance is not the
+// leader, so that a single one of several Promscale instances evaluates the
+// rules. The rule groups keep running on every instance, so that the
+// leadership can change between two evaluations.
+type leaderGate struct {
+	isLeader func() bool
+
+	mu sync.Mutex
+	// groups is the leadership state of each rule group, by group key.
+	groups map[string]groupLeadership
+}
+
+type groupLeadership int
+
+const (
+	// groupFollower is the state of a group evaluated by a follower.
+	groupFollower groupLeadership = iota + 1
+	// groupNewLeader is the state of a group about to be evaluated for the
+	// first time by a new leader.
+	groupNewLeader
+	// groupLeader is the state of a group evaluated by the leader.
+	groupLeader
+)
+
+func (g *leaderGate) leads() bool {
+	return g.isLeader == nil || g.isLeader()
+}
+
+// queryFunc returns no result while this instance is not the leader. The
+// alerts are thus pending again on a new leader.
+func (g *leaderGate) queryFunc(qf prom_rules.QueryFunc) prom_rules.QueryFunc {
+	return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		if !g.leads() {
+			return promql.Vector{}, nil
+		}
+		return qf(ctx, q, t)
+	}
+}
+
+// notifyFunc drops the alerts while this instance is not the leader, the
+// alerts resolved when the leadership was lost in particular.
+func (g *leaderGate) notifyFunc(nf prom_rules.NotifyFunc) prom_rules.NotifyFunc {
+	return func(ctx context.Context, expr string, alerts ...*prom_rules.Alert) {
+		if g.leads() {
+			nf(ctx, expr, alerts...)
+		}
+	}
+}
+
+// appendable drops the samples while this instance is not the leader, the
+// stale markers of the series that are no longer returned in particular.
+func (g *leaderGate) appendable(a storage.Appendable) storage.Appendable {
+	return leaderAppendable{gate: g, Appendable: a}
+}
+
+type leaderAppendable struct {
+	gate *leaderGate
+	storage.Appendable
+}
+
+func (a leaderAppendable) Appender(ctx context.Context) storage.Appender {
+	if !a.gate.leads() {
+		return discardAppender{}
+	}
+	return a.Appendable.Appender(ctx)
+}
+
+type discardAppender struct{}
+
+func (discardAppender) Append(storage.SeriesRef, labels.Labels, int64, float64) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (discardAppender) AppendExemplar(storage.SeriesRef, labels.Labels, exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (discardAppender) Commit() error   { return nil }
+func (discardAppender) Rollback() error { return nil }
+
+// postProcess restores the 'for' state of the alerts of a group from the
+// ALERTS_FOR_STATE series written by the previous leader, once the group was
+// evaluated for the first time by a new leader, so that the alerts are not
+// pending again for their 'for' duration after each leader change. The rules
+// manager restores the state on startup, the restoration is thus skipped if
+// this instance leads from the start.
+func (g *leaderGate) postProcess(next prom_rules.RuleGroupPostProcessFunc) prom_rules.RuleGroupPostProcessFunc {
+	return func(group *prom_rules.Group, lastEvalTimestamp time.Time, logger log.Logger) error {
+		if g.isLeader != nil && g.transition(prom_rules.GroupKey(group.File(), group.Name())) {
+			group.RestoreForState(time.Now())
+		}
+		if next == nil {
+			return nil
+		}
+		return next(group, lastEvalTimestamp, logger)
+	}
+}
+
+// transition updates the leadership state of a group before its evaluation,
+// and returns whether its state must be restored.
+func (g *leaderGate) transition(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.groups == nil {
+		g.groups = make(map[string]groupLeadership)
+	}
+	prev := g.groups[key]
+	if !g.leads() {
+		g.groups[key] = groupFollower
+		return false
+	}
+	switch prev {
+	case groupFollower:
+		g.groups[key] = groupNewLeader
+	case groupNewLeader:
+		g.groups[key] = groupLeader
+		return true
+	default:
+		g.groups[key] = groupLeader
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	prom_rules "github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderGate(t *testing.T) {
+	leader := false
+	gate := &leaderGate{isLeader: func() bool { return leader }}
+
+	queries, notifications := 0, 0
+	qf := gate.queryFunc(func(context.Context, string, time.Time) (promql.Vector, error) {
+		queries++
+		return promql.Vector{{Point: promql.Point{V: 1}}}, nil
+	})
+	nf := gate.notifyFunc(func(context.Context, string, ...*prom_rules.Alert) {
+		notifications++
+	})
+
+	res, err := qf(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	require.Empty(t, res)
+	nf(context.Background(), "up", &prom_rules.Alert{})
+	require.Equal(t, 0, queries)
+	require.Equal(t, 0, notifications)
+	require.IsType(t, discardAppender{}, gate.appendable(nil).Appender(context.Background()))
+
+	leader = true
+	res, err = qf(context.Background(), "up", time.Now())
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	nf(context.Background(), "up", &prom_rules.Alert{})
+	require.Equal(t, 1, queries)
+	require.Equal(t, 1, notifications)
+
+	// Without leader election, the rules are always evaluated.
+	require.True(t, (&leaderGate{}).leads())
+}
+
+func TestLeaderGateTransition(t *testing.T) {
+	leader := true
+	gate := &leaderGate{isLeader: func() bool { return leader }}
+
+	// The rules manager restores the state of the initial leader.
+	require.False(t, gate.transition("a"))
+	require.False(t, gate.transition("a"))
+
+	leader = false
+	require.False(t, gate.transition("a"))
+	leader = true
+	// The state is restored after the first evaluation as the new leader.
+	require.False(t, gate.transition("a"))
+	require.True(t, gate.transition("a"))
+	require.False(t, gate.transition("a"))
+}
@@ -0,0 +1,244 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	prometheus_promql "github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"gopkg.in/yaml.v2"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// lookupPrefix marks the template queries that run an annotation lookup
+// instead of a PromQL query, e.g. in an alerting rule annotation:
+//
+//	{{ with query (printf "@lookup:service_owner(%q)" $labels.service) }}{{ . | first | label "team" }}{{ end }}
+//
+// A PromQL expression cannot start with '@', so no PromQL query is taken for
+// a lookup, e.g. a recording rule named lookup:requests:rate5m. The arguments
+// of a lookup are sent as query parameters, they never become part of the SQL
+// text.
+const lookupPrefix = "@lookup:"
+
+const defaultLookupTimeout = 5 * time.Second
+
+var (
+	lookupNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	lookupParamRegex = regexp.MustCompile(`\$([0-9]+)`)
+)
+
+// Lookup is a named, parameterized SQL query that alerting rule annotations
+// can run when an alert fires.
+type Lookup struct {
+	Query   string         `yaml:"query"`
+	Timeout model.Duration `yaml:"timeout,omitempty"`
+
+	numParams int
+}
+
+type lookupsFile struct {
+	Lookups map[string]Lookup `yaml:"lookups"`
+}
+
+func loadLookups(path string) (map[string]Lookup, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotation lookups file: %w", err)
+	}
+	var f lookupsFile
+	if err = yaml.UnmarshalStrict(content, &f); err != nil {
+		return nil, fmt.Errorf("error parsing annotation lookups file: %w", err)
+	}
+	for name, lookup := range f.Lookups {
+		if err = validateLookup(name, &lookup); err != nil {
+			return nil, fmt.Errorf("invalid lookup %q: %w", name, err)
+		}
+		f.Lookups[name] = lookup
+	}
+	return f.Lookups, nil
+}
+
+func validateLookup(name string, lookup *Lookup) error {
+	if !lookupNameRegex.MatchString(name) {
+		return fmt.Errorf("name must match %s", lookupNameRegex.String())
+	}
+	query := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(lookup.Query), ";"))
+	if query == "" {
+		return fmt.Errorf("query is empty")
+	}
+	if strings.Contains(query, ";") {
+		return fmt.Errorf("query must be a single statement")
+	}
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+	if keyword != "SELECT" && keyword != "WITH" {
+		return fmt.Errorf("query must be a SELECT statement")
+	}
+	lookup.Query = query
+	if lookup.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if lookup.Timeout == 0 {
+		lookup.Timeout = model.Duration(defaultLookupTimeout)
+	}
+	lookup.numParams = 0
+	for _, match := range lookupParamRegex.FindAllStringSubmatch(query, -1) {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return fmt.Errorf("invalid parameter %s: %w", match[0], err)
+		}
+		if n > lookup.numParams {
+			lookup.numParams = n
+		}
+	}
+	return nil
+}
+
+// parseLookupCall parses a lookup call of the form `@lookup:name("arg1", "arg2")`.
+// The arguments are double-quoted strings, as written by printf "%q".
+func parseLookupCall(qs string) (name string, args []string, err error) {
+	call := strings.TrimSpace(strings.TrimPrefix(qs, lookupPrefix))
+	open := strings.IndexByte(call, '(')
+	if open < 0 || !strings.HasSuffix(call, ")") {
+		return "", nil, fmt.Errorf("lookup must be of the form @lookup:name(\"arg\", ...), got %q", qs)
+	}
+	name = strings.TrimSpace(call[:open])
+	rest := strings.TrimSpace(call[open+1 : len(call)-1])
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", nil, fmt.Errorf("lookup arguments must be quoted strings, got %q", rest)
+		}
+		arg, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", nil, fmt.Errorf("lookup arguments must be quoted strings, got %q", quoted)
+		}
+		args = append(args, arg)
+		rest = strings.TrimSpace(rest[len(quoted):])
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return "", nil, fmt.Errorf("lookup arguments must be separated by commas, got %q", rest)
+		}
+		rest = strings.TrimSpace(rest[1:])
+		if rest == "" {
+			return "", nil, fmt.Errorf("missing lookup argument after comma in %q", qs)
+		}
+	}
+	return name, args, nil
+}
+
+// lookupRunner runs the annotation lookups in read-only transactions.
+type lookupRunner struct {
+	conn pgxconn.PgxConn
+
+	mux     sync.RWMutex
+	lookups map[string]Lookup
+}
+
+func newLookupRunner(conn pgxconn.PgxConn, lookups map[string]Lookup) *lookupRunner {
+	return &lookupRunner{conn: conn, lookups: lookups}
+}
+
+func (l *lookupRunner) setLookups(lookups map[string]Lookup) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.lookups = lookups
+}
+
+func (l *lookupRunner) get(name string) (Lookup, bool) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	lookup, ok := l.lookups[name]
+	return lookup, ok
+}
+
+// run runs a lookup call and returns its first row as a single sample,
+// with a label for each column and a value of 1. No rows result in an
+// empty vector.
+func (l *lookupRunner) run(ctx context.Context, qs string, t time.Time) (prometheus_promql.Vector, error) {
+	name, args, err := parseLookupCall(qs)
+	if err != nil {
+		return nil, err
+	}
+	lookup, ok := l.get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown annotation lookup %q", name)
+	}
+	if len(args) != lookup.numParams {
+		return nil, fmt.Errorf("lookup %q takes %d arguments, got %d", name, lookup.numParams, len(args))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(lookup.Timeout))
+	defer cancel()
+	tx, err := l.conn.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %q: begin transaction: %w", name, err)
+	}
+	// The transaction is read-only, rolling it back is all that is needed.
+	defer func() { _ = tx.Rollback(context.Background()) }()
+	if _, err = tx.Exec(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		return nil, fmt.Errorf("lookup %q: set read only: %w", name, err)
+	}
+
+	params := make([]interface{}, len(args))
+	for i := range args {
+		params[i] = args[i]
+	}
+	rows, err := tx.Query(ctx, lookup.Query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %q: %w", name, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("lookup %q: %w", name, err)
+		}
+		return prometheus_promql.Vector{}, nil
+	}
+	values, err := rows.Values()
+	if err != nil {
+		return nil, fmt.Errorf("lookup %q: %w", name, err)
+	}
+	return prometheus_promql.Vector{lookupSample(rows.FieldDescriptions(), values, t)}, nil
+}
+
+func lookupSample(fields []pgproto3.FieldDescription, values []interface{}, t time.Time) prometheus_promql.Sample {
+	lbls := make(labels.Labels, 0, len(fields))
+	for i, field := range fields {
+		value := ""
+		if values[i] != nil {
+			value = fmt.Sprint(values[i])
+		}
+		lbls = append(lbls, labels.Label{Name: string(field.Name), Value: value})
+	}
+	return prometheus_promql.Sample{
+		Point:  prometheus_promql.Point{T: t.UnixMilli(), V: 1},
+		Metric: labels.New(lbls...),
+	}
+}
+
+// lookupQueryFunc runs the annotation lookups, and passes all other queries to next.
+func lookupQueryFunc(lookups *lookupRunner, next rules.QueryFunc) rules.QueryFunc {
+	return func(ctx context.Context, qs string, t time.Time) (prometheus_promql.Vector, error) {
+		if strings.HasPrefix(qs, lookupPrefix) {
+			return lookups.run(ctx, qs, t)
+		}
+		return next(ctx, qs, t)
+	}
+}
@@ -0,0 +1,114 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	prometheus_promql "github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLookups(t *testing.T) {
+	lookups, err := loadLookups("testdata/lookups.yaml")
+	require.NoError(t, err)
+	require.Len(t, lookups, 2)
+
+	owner := lookups["service_owner"]
+	require.Equal(t, "SELECT team, slack_channel FROM metadata.service_owner WHERE service = $1", owner.Query)
+	require.Equal(t, model.Duration(defaultLookupTimeout), owner.Timeout)
+	require.Equal(t, 1, owner.numParams)
+
+	location := lookups["host_location"]
+	require.Equal(t, model.Duration(time.Second), location.Timeout)
+	require.Equal(t, 2, location.numParams)
+}
+
+func TestValidateLookup(t *testing.T) {
+	cases := []struct {
+		name        string
+		lookupName  string
+		query       string
+		shouldError bool
+	}{
+		{name: "select", lookupName: "owner", query: "select team from owners where service = $1"},
+		{name: "with", lookupName: "owner", query: "WITH o AS (SELECT * FROM owners) SELECT team FROM o WHERE service = $1"},
+		{name: "invalid name", lookupName: "owner-team", query: "SELECT 1", shouldError: true},
+		{name: "empty query", lookupName: "owner", query: " ; ", shouldError: true},
+		{name: "multiple statements", lookupName: "owner", query: "SELECT 1; DROP TABLE owners", shouldError: true},
+		{name: "not a select", lookupName: "owner", query: "DELETE FROM owners WHERE service = $1", shouldError: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lookup := Lookup{Query: c.query}
+			err := validateLookup(c.lookupName, &lookup)
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParseLookupCall(t *testing.T) {
+	cases := []struct {
+		name         string
+		call         string
+		expectedName string
+		expectedArgs []string
+		shouldError  bool
+	}{
+		{name: "no args", call: "@lookup:owners()", expectedName: "owners"},
+		{name: "one arg", call: `@lookup:service_owner("checkout")`, expectedName: "service_owner", expectedArgs: []string{"checkout"}},
+		{name: "many args", call: `@lookup:host_location( "db-1" , "prod")`, expectedName: "host_location", expectedArgs: []string{"db-1", "prod"}},
+		{name: "quotes are kept in args", call: `@lookup:service_owner("x'); DROP TABLE owners; --\"")`, expectedName: "service_owner", expectedArgs: []string{`x'); DROP TABLE owners; --"`}},
+		{name: "unquoted arg", call: "@lookup:service_owner(checkout)", shouldError: true},
+		{name: "missing parenthesis", call: `@lookup:service_owner("checkout"`, shouldError: true},
+		{name: "trailing comma", call: `@lookup:service_owner("checkout",)`, shouldError: true},
+		{name: "missing comma", call: `@lookup:service_owner("a" "b")`, shouldError: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			name, args, err := parseLookupCall(c.call)
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedName, name)
+			require.Equal(t, c.expectedArgs, args)
+		})
+	}
+}
+
+func TestLookupQueryFunc(t *testing.T) {
+	promqlQueries := 0
+	next := func(ctx context.Context, qs string, t time.Time) (prometheus_promql.Vector, error) {
+		promqlQueries++
+		return prometheus_promql.Vector{}, nil
+	}
+	lookups, err := loadLookups("testdata/lookups.yaml")
+	require.NoError(t, err)
+	queryFunc := lookupQueryFunc(newLookupRunner(nil, lookups), next)
+
+	_, err = queryFunc(context.Background(), `up{job="checkout"}`, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, promqlQueries)
+
+	// Recording rules can be named with a lookup: prefix.
+	_, err = queryFunc(context.Background(), `lookup:requests:rate5m{job="checkout"}`, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, promqlQueries)
+
+	_, err = queryFunc(context.Background(), `@lookup:unknown("checkout")`, time.Now())
+	require.Error(t, err)
+	_, err = queryFunc(context.Background(), `@lookup:host_location("db-1")`, time.Now())
+	require.Error(t, err)
+	require.Equal(t, 2, promqlQueries)
+}
@@ -0,0 +1,123 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// rollup is a recording rule of a group with a storage class, i.e. a
+// downsampled copy of its expression.
+type rollup struct {
+	metric   string
+	interval time.Duration
+}
+
+// Rollups maps the expressions of the recording rules of the groups with a
+// storage class to the metrics recording them, so that queries can be
+// rewritten to read the downsampled series instead of the raw ones.
+type Rollups struct {
+	mu sync.RWMutex
+	// byExpr is keyed by the canonical form of the rule expressions.
+	byExpr map[string]rollup
+}
+
+// addRollup records the rule as a rollup. Rules adding labels or not
+// returning a vector are skipped since their series cannot replace their
+// expression in a query.
+func (r *Rollups) addRollup(rule rulefmt.RuleNode, interval time.Duration) {
+	if len(rule.Labels) > 0 {
+		return
+	}
+	expr, err := parser.ParseExpr(rule.Expr.Value)
+	if err != nil || expr.Type() != parser.ValueTypeVector {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byExpr == nil {
+		r.byExpr = make(map[string]rollup)
+	}
+	r.byExpr[expr.String()] = rollup{metric: rule.Record.Value, interval: interval}
+}
+
+// Len returns the number of rollups.
+func (r *Rollups) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byExpr)
+}
+
+// Rewrite replaces the subexpressions of the query recorded by a rollup with
+// the recorded metric. It returns the rewritten query, the metrics it reads
+// and the largest evaluation interval of these rollups, the resolution of the
+// rewritten query. No metric is returned if the query reads no rollup.
+func (r *Rollups) Rewrite(query string) (string, []string, time.Duration, error) {
+	if r.Len() == 0 {
+		return query, nil, 0, nil
+	}
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return query, nil, 0, err
+	}
+	var (
+		metrics  []string
+		interval time.Duration
+	)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var rewrite func(node parser.Expr) parser.Expr
+	rewrite = func(node parser.Expr) parser.Expr {
+		if ru, ok := r.byExpr[node.String()]; ok {
+			metrics = append(metrics, ru.metric)
+			if ru.interval > interval {
+				interval = ru.interval
+			}
+			return rollupSelector(ru.metric)
+		}
+		switch n := node.(type) {
+		case *parser.AggregateExpr:
+			n.Expr = rewrite(n.Expr)
+		case *parser.BinaryExpr:
+			n.LHS = rewrite(n.LHS)
+			n.RHS = rewrite(n.RHS)
+		case *parser.Call:
+			for i := range n.Args {
+				n.Args[i] = rewrite(n.Args[i])
+			}
+		case *parser.ParenExpr:
+			n.Expr = rewrite(n.Expr)
+		case *parser.SubqueryExpr:
+			n.Expr = rewrite(n.Expr)
+		case *parser.UnaryExpr:
+			n.Expr = rewrite(n.Expr)
+		}
+		return node
+	}
+	expr = rewrite(expr)
+	if len(metrics) == 0 {
+		return query, nil, 0, nil
+	}
+	return expr.String(), metrics, interval, nil
+}
+
+// rollupSelector selects the series of a recorded metric. The metric name is
+// dropped, like the functions and aggregations of most rule expressions do.
+func rollupSelector(metric string) parser.Expr {
+	expr, err := parser.ParseExpr(fmt.Sprintf(`label_replace(%s, "__name__", "", "", "")`, metric))
+	if err != nil {
+		// Recorded metric names are validated when the rules are loaded.
+		panic(err)
+	}
+	return expr
+}
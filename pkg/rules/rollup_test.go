@@ -0,0 +1,68 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollupsRewrite(t *testing.T) {
+	classes, err := loadStorageClasses("testdata/storage_classes.yaml")
+	require.NoError(t, err)
+	loader := newGroupLoader(classes)
+	_, errs := loader.Load("testdata/rules.storage_class.yaml")
+	require.Empty(t, errs)
+	rollups := loader.loadedRollups()
+	require.Equal(t, 1, rollups.Len())
+
+	cases := []struct {
+		name             string
+		query            string
+		expectedQuery    string
+		expectedMetrics  []string
+		expectedInterval time.Duration
+	}{
+		{
+			name:             "whole query",
+			query:            "avg_over_time(up[5m])",
+			expectedQuery:    `label_replace(job:up:avg_over_time5m, "__name__", "", "", "")`,
+			expectedMetrics:  []string{"job:up:avg_over_time5m"},
+			expectedInterval: 5 * time.Minute,
+		},
+		{
+			name:             "subexpression",
+			query:            "sum by (job) (avg_over_time( up [5m] )) > 0",
+			expectedQuery:    `sum by(job) (label_replace(job:up:avg_over_time5m, "__name__", "", "", "")) > 0`,
+			expectedMetrics:  []string{"job:up:avg_over_time5m"},
+			expectedInterval: 5 * time.Minute,
+		},
+		{
+			name:          "no rollup",
+			query:         "sum by (job) (up)",
+			expectedQuery: "sum by (job) (up)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, metrics, interval, err := rollups.Rewrite(c.query)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedQuery, query)
+			require.Equal(t, c.expectedMetrics, metrics)
+			require.Equal(t, c.expectedInterval, interval)
+		})
+	}
+
+	_, _, _, err = rollups.Rewrite("sum(")
+	require.Error(t, err)
+
+	var empty *Rollups
+	query, metrics, _, err := empty.Rewrite("avg_over_time(up[5m])")
+	require.NoError(t, err)
+	require.Equal(t, "avg_over_time(up[5m])", query)
+	require.Empty(t, metrics)
+}
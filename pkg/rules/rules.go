@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/url"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/oklog/run"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/rules/adapters"
 	"github.com/timescale/promscale/pkg/telemetry"
 	"github.com/timescale/promscale/pkg/util"
@@ -58,6 +60,11 @@ type Manager struct {
 	notifierManager     *notifier.Manager
 	discoveryManager    *discovery.Manager
 	postRulesProcessing prom_rules.RuleGroupPostProcessFunc
+	lookups             *lookupRunner
+	groupLoader         *groupLoader
+	leaderGate          *leaderGate
+	conn                pgxconn.PgxConn
+	stopOnce            sync.Once
 }
 
 func NewManager(ctx context.Context, r prometheus.Registerer, client *pgclient.Client, cfg *Config) (*Manager, func() error, error) {
@@ -75,18 +82,22 @@ func NewManager(ctx context.Context, r prometheus.Registerer, client *pgclient.C
 		return nil, nil, fmt.Errorf("parsing UI-URL: %w", err)
 	}
 
+	lookups := newLookupRunner(client.ReadOnlyConnection(), cfg.AnnotationLookups)
+	loader := newGroupLoader(cfg.StorageClasses)
+	gate := &leaderGate{}
 	rulesManager := prom_rules.NewManager(&prom_rules.ManagerOptions{
-		Appendable:      adapters.NewIngestAdapter(client.Inserter()),
+		Appendable:      gate.appendable(adapters.NewIngestAdapter(client.Inserter())),
 		Queryable:       adapters.NewQueryAdapter(client.Queryable()),
 		Context:         ctx,
 		ExternalURL:     parsedUrl,
 		Logger:          log.GetLogger(),
-		NotifyFunc:      sendAlerts(notifierManager, parsedUrl.String()),
-		QueryFunc:       engineQueryFunc(client.QueryEngine(), client.Queryable()),
+		NotifyFunc:      gate.notifyFunc(sendAlerts(notifierManager, parsedUrl.String())),
+		QueryFunc:       gate.queryFunc(lookupQueryFunc(lookups, engineQueryFunc(client.QueryEngine(), client.Queryable()))),
 		Registerer:      r,
 		OutageTolerance: cfg.OutageTolerance,
 		ForGracePeriod:  cfg.ForGracePeriod,
 		ResendDelay:     cfg.ResendDelay,
+		GroupLoader:     loader,
 	})
 
 	manager := &Manager{
@@ -94,6 +105,10 @@ func NewManager(ctx context.Context, r prometheus.Registerer, client *pgclient.C
 		rulesManager:     rulesManager,
 		notifierManager:  notifierManager,
 		discoveryManager: discoveryManagerNotify,
+		lookups:          lookups,
+		groupLoader:      loader,
+		leaderGate:       gate,
+		conn:             client.MaintenanceConnection(),
 	}
 	return manager, manager.getReloader(cfg), nil
 }
@@ -114,6 +129,8 @@ func (m *Manager) getReloader(cfg *Config) func() error {
 		if err != nil {
 			return fmt.Errorf("error validating rules-config: %w", err)
 		}
+		m.lookups.setLookups(cfg.AnnotationLookups)
+		m.groupLoader.reset(cfg.StorageClasses)
 		if err = m.ApplyConfig(cfg.PrometheusConfig); err != nil {
 			return fmt.Errorf("error applying config: %w", err)
 		}
@@ -141,6 +158,12 @@ func (m *Manager) WithPostRulesProcess(f prom_rules.RuleGroupPostProcessFunc) {
 	m.postRulesProcessing = f
 }
 
+// WithLeaderElection evaluates the rules only while isLeader returns true.
+// It must be called before Run.
+func (m *Manager) WithLeaderElection(isLeader func() bool) {
+	m.leaderGate.isLeader = isLeader
+}
+
 func (m *Manager) ApplyConfig(cfg *prometheus_config.Config) error {
 	if err := m.applyDiscoveryManagerConfig(cfg); err != nil {
 		return err
@@ -158,9 +181,12 @@ func (m *Manager) ApplyConfig(cfg *prometheus_config.Config) error {
 		}
 		files = append(files, fs...)
 	}
-	if err := m.rulesManager.Update(time.Duration(cfg.GlobalConfig.EvaluationInterval), files, cfg.GlobalConfig.ExternalLabels, "", m.postRulesProcessing); err != nil {
+	if err := m.rulesManager.Update(time.Duration(cfg.GlobalConfig.EvaluationInterval), files, cfg.GlobalConfig.ExternalLabels, "", m.leaderGate.postProcess(m.postRulesProcessing)); err != nil {
 		return fmt.Errorf("error updating rule-manager: %w", err)
 	}
+	if err := applyStorageClasses(m.ctx, m.conn, m.groupLoader.storageClassMetrics()); err != nil {
+		return fmt.Errorf("error applying storage classes: %w", err)
+	}
 	return nil
 }
 
@@ -180,10 +206,21 @@ func (m *Manager) RuleGroups() []*prom_rules.Group {
 	return m.rulesManager.RuleGroups()
 }
 
+// Rollups returns the rollups of the recording rules with a storage class.
+func (m *Manager) Rollups() *Rollups {
+	return m.groupLoader.loadedRollups()
+}
+
 func (m *Manager) AlertingRules() []*prom_rules.AlertingRule {
 	return m.rulesManager.AlertingRules()
 }
 
+// StopEvaluation stops evaluating the rules. It returns once the evaluations
+// in progress are complete, and their samples sent to the ingestor.
+func (m *Manager) StopEvaluation() {
+	m.stopOnce.Do(m.rulesManager.Stop)
+}
+
 // Run runs the managers and blocks on either a graceful exit or on error.
 func (m *Manager) Run() error {
 	var g run.Group
@@ -210,7 +247,7 @@ func (m *Manager) Run() error {
 		return nil
 	}, func(error) {
 		log.Debug("msg", "Stopping internal rule-manager")
-		m.rulesManager.Stop()
+		m.StopEvaluation()
 	})
 
 	g.Add(func() error {
@@ -0,0 +1,283 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// storageClassKey is the rule group field selecting the storage class of the
+// series recorded by the group. It is not part of the Prometheus rules
+// format, so it is removed before the group is parsed by Prometheus.
+const storageClassKey = "storage_class"
+
+const (
+	setChunkIntervalSQL = "SELECT prom_api.set_metric_chunk_interval($1, $2)"
+	setCompressionSQL   = "SELECT prom_api.set_metric_compression_setting($1, $2)"
+	setRetentionSQL     = "SELECT prom_api.set_metric_retention_period($1, $2)"
+)
+
+// StorageClass is a named set of storage settings for the hypertables of
+// recorded series, e.g. a coarse "rollup" class keeping the series of
+// downsampling rules for a year in large chunks. The settings that are not set
+// keep the defaults of the database.
+type StorageClass struct {
+	ChunkInterval model.Duration `yaml:"chunk_interval,omitempty"`
+	Compression   *bool          `yaml:"compression,omitempty"`
+	Retention     model.Duration `yaml:"retention,omitempty"`
+	// Interval is the evaluation interval of the groups of the class that do
+	// not set one, i.e. the resolution of the recorded series.
+	Interval model.Duration `yaml:"interval,omitempty"`
+}
+
+type storageClassesFile struct {
+	StorageClasses map[string]StorageClass `yaml:"storage_classes"`
+}
+
+func loadStorageClasses(path string) (map[string]StorageClass, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading storage classes file: %w", err)
+	}
+	var f storageClassesFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("error parsing storage classes file: %w", err)
+	}
+	for name, class := range f.StorageClasses {
+		if err = validateStorageClass(name, class); err != nil {
+			return nil, fmt.Errorf("invalid storage class %q: %w", name, err)
+		}
+	}
+	return f.StorageClasses, nil
+}
+
+func validateStorageClass(name string, class StorageClass) error {
+	if !lookupNameRegex.MatchString(name) {
+		return fmt.Errorf("name must match %s", lookupNameRegex.String())
+	}
+	switch {
+	case class.ChunkInterval < 0:
+		return fmt.Errorf("chunk_interval must not be negative")
+	case class.Retention < 0:
+		return fmt.Errorf("retention must not be negative")
+	case class.Interval < 0:
+		return fmt.Errorf("interval must not be negative")
+	case class.Retention > 0 && class.Retention < class.ChunkInterval:
+		return fmt.Errorf("retention must not be shorter than chunk_interval")
+	}
+	return nil
+}
+
+// groupLoader loads rule files that may set a storage class per rule group.
+// It records the storage class of the metrics recorded by the groups loaded
+// since the last reset.
+type groupLoader struct {
+	mu      sync.Mutex
+	classes map[string]StorageClass
+	// metrics maps the recorded metrics to the name of their storage class.
+	metrics map[string]string
+	rollups *Rollups
+}
+
+func newGroupLoader(classes map[string]StorageClass) *groupLoader {
+	return &groupLoader{classes: classes, metrics: make(map[string]string), rollups: &Rollups{}}
+}
+
+// reset sets the storage classes and forgets the metrics of the groups loaded
+// before.
+func (l *groupLoader) reset(classes map[string]StorageClass) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.classes = classes
+	l.metrics = make(map[string]string)
+	l.rollups = &Rollups{}
+}
+
+// Load implements the prom_rules.GroupLoader interface.
+func (l *groupLoader) Load(identifier string) (*rulefmt.RuleGroups, []error) {
+	content, err := os.ReadFile(identifier)
+	if err != nil {
+		return nil, []error{errors.Wrap(err, identifier)}
+	}
+	rgs, errs := l.parse(content)
+	for i := range errs {
+		errs[i] = errors.Wrap(errs[i], identifier)
+	}
+	return rgs, errs
+}
+
+// Parse implements the prom_rules.GroupLoader interface.
+func (l *groupLoader) Parse(query string) (parser.Expr, error) { return parser.ParseExpr(query) }
+
+func (l *groupLoader) parse(content []byte) (*rulefmt.RuleGroups, []error) {
+	content, groupClasses, err := extractStorageClasses(content)
+	if err != nil {
+		return nil, []error{err}
+	}
+	rgs, errs := rulefmt.Parse(content)
+	if len(errs) > 0 || len(groupClasses) == 0 {
+		return rgs, errs
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range rgs.Groups {
+		g := &rgs.Groups[i]
+		className, ok := groupClasses[g.Name]
+		if !ok {
+			continue
+		}
+		class, ok := l.classes[className]
+		if !ok {
+			errs = append(errs, fmt.Errorf("group %q: unknown storage class %q", g.Name, className))
+			continue
+		}
+		if g.Interval == 0 {
+			g.Interval = class.Interval
+		}
+		for _, r := range g.Rules {
+			metric := r.Record.Value
+			if metric == "" {
+				continue
+			}
+			if other, ok := l.metrics[metric]; ok && other != className {
+				errs = append(errs, fmt.Errorf("group %q: metric %q is recorded with storage classes %q and %q", g.Name, metric, other, className))
+				continue
+			}
+			l.metrics[metric] = className
+			l.rollups.addRollup(r, time.Duration(g.Interval))
+		}
+	}
+	return rgs, errs
+}
+
+// extractStorageClasses removes the storage class field of the rule groups
+// and returns the content without it along with the storage class of each
+// group.
+func extractStorageClasses(content []byte) ([]byte, map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return content, nil, nil
+	}
+	var groups *yaml.Node
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "groups" {
+			groups = root.Content[i+1]
+		}
+	}
+	if groups == nil || groups.Kind != yaml.SequenceNode {
+		return content, nil, nil
+	}
+
+	classes := make(map[string]string)
+	for _, g := range groups.Content {
+		if g.Kind != yaml.MappingNode {
+			continue
+		}
+		var name, class string
+		found := false
+		fields := g.Content[:0]
+		for i := 0; i+1 < len(g.Content); i += 2 {
+			key, value := g.Content[i], g.Content[i+1]
+			switch key.Value {
+			case storageClassKey:
+				class, found = value.Value, true
+				continue
+			case "name":
+				name = value.Value
+			}
+			fields = append(fields, key, value)
+		}
+		g.Content = fields
+		if found {
+			if class == "" {
+				return nil, nil, fmt.Errorf("group %q: %s must not be empty", name, storageClassKey)
+			}
+			classes[name] = class
+		}
+	}
+	if len(classes) == 0 {
+		return content, nil, nil
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, classes, nil
+}
+
+// storageClassMetrics returns the recorded metrics of the loaded groups with
+// the storage class they are stored with, sorted by metric name.
+func (l *groupLoader) storageClassMetrics() []metricStorageClass {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]metricStorageClass, 0, len(l.metrics))
+	for metric, name := range l.metrics {
+		res = append(res, metricStorageClass{metric: metric, name: name, class: l.classes[name]})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].metric < res[j].metric })
+	return res
+}
+
+// loadedRollups returns the rollups of the loaded groups.
+func (l *groupLoader) loadedRollups() *Rollups {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rollups
+}
+
+type metricStorageClass struct {
+	metric string
+	name   string
+	class  StorageClass
+}
+
+// applyStorageClasses sets the storage settings of the metric tables of the
+// recorded metrics. The metric tables are created if the rules did not record
+// any sample yet, so the settings apply from the first chunk.
+func applyStorageClasses(ctx context.Context, conn pgxconn.PgxConn, metrics []metricStorageClass) error {
+	for _, m := range metrics {
+		if m.class.ChunkInterval > 0 {
+			if _, err := conn.Exec(ctx, setChunkIntervalSQL, m.metric, time.Duration(m.class.ChunkInterval)); err != nil {
+				return fmt.Errorf("setting chunk interval of %s: %w", m.metric, err)
+			}
+		}
+		if m.class.Compression != nil {
+			if _, err := conn.Exec(ctx, setCompressionSQL, m.metric, *m.class.Compression); err != nil {
+				return fmt.Errorf("setting compression of %s: %w", m.metric, err)
+			}
+		}
+		if m.class.Retention > 0 {
+			if _, err := conn.Exec(ctx, setRetentionSQL, m.metric, time.Duration(m.class.Retention)); err != nil {
+				return fmt.Errorf("setting retention of %s: %w", m.metric, err)
+			}
+		}
+	}
+	if len(metrics) > 0 {
+		log.Info("msg", "Applied storage classes to recorded metrics", "metrics", len(metrics))
+	}
+	return nil
+}
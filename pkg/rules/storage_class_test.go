@@ -0,0 +1,133 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadStorageClasses(t *testing.T) {
+	classes, err := loadStorageClasses("testdata/storage_classes.yaml")
+	require.NoError(t, err)
+	require.Len(t, classes, 2)
+
+	rollup := classes["rollup"]
+	require.Equal(t, model.Duration(24*time.Hour), rollup.ChunkInterval)
+	require.NotNil(t, rollup.Compression)
+	require.True(t, *rollup.Compression)
+	require.Equal(t, model.Duration(365*24*time.Hour), rollup.Retention)
+	require.Equal(t, model.Duration(5*time.Minute), rollup.Interval)
+
+	short := classes["short"]
+	require.Nil(t, short.Compression)
+	require.Zero(t, short.ChunkInterval)
+}
+
+func TestValidateStorageClass(t *testing.T) {
+	cases := []struct {
+		name        string
+		className   string
+		class       StorageClass
+		shouldError bool
+	}{
+		{name: "empty", className: "rollup"},
+		{name: "invalid name", className: "roll-up", shouldError: true},
+		{name: "negative chunk interval", className: "rollup", class: StorageClass{ChunkInterval: -1}, shouldError: true},
+		{name: "retention shorter than chunks", className: "rollup", class: StorageClass{ChunkInterval: 2, Retention: 1}, shouldError: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateStorageClass(c.className, c.class)
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestGroupLoaderStorageClass(t *testing.T) {
+	classes, err := loadStorageClasses("testdata/storage_classes.yaml")
+	require.NoError(t, err)
+	loader := newGroupLoader(classes)
+
+	rgs, errs := loader.Load("testdata/rules.storage_class.yaml")
+	require.Empty(t, errs)
+	require.Len(t, rgs.Groups, 2)
+	require.Equal(t, "rollup-5m", rgs.Groups[0].Name)
+	require.Equal(t, model.Duration(5*time.Minute), rgs.Groups[0].Interval)
+	require.Len(t, rgs.Groups[0].Rules, 2)
+	require.Zero(t, rgs.Groups[1].Interval)
+
+	metrics := loader.storageClassMetrics()
+	require.Len(t, metrics, 1)
+	require.Equal(t, "job:up:avg_over_time5m", metrics[0].metric)
+	require.Equal(t, "rollup", metrics[0].name)
+	require.Equal(t, classes["rollup"], metrics[0].class)
+
+	loader.reset(classes)
+	require.Empty(t, loader.storageClassMetrics())
+}
+
+func TestGroupLoaderStorageClassErrors(t *testing.T) {
+	classes := map[string]StorageClass{"rollup": {}, "short": {}}
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "unknown class",
+			content: `groups:
+- name: g
+  storage_class: unknown
+  rules:
+  - record: r
+    expr: up`,
+		},
+		{
+			name: "empty class",
+			content: `groups:
+- name: g
+  storage_class: ""
+  rules:
+  - record: r
+    expr: up`,
+		},
+		{
+			name: "metric with two classes",
+			content: `groups:
+- name: g1
+  storage_class: rollup
+  rules:
+  - record: r
+    expr: up
+- name: g2
+  storage_class: short
+  rules:
+  - record: r
+    expr: up`,
+		},
+		{
+			name: "unknown group field",
+			content: `groups:
+- name: g
+  storage: rollup
+  rules:
+  - record: r
+    expr: up`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, errs := newGroupLoader(classes).parse([]byte(c.content))
+			require.NotEmpty(t, errs)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package rules
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/promql"
+)
+
+func TestUnitTest(t *testing.T) {
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples:               10000,
+		Timeout:                  time.Minute,
+		NoStepSubqueryIntervalFn: func(int64) int64 { return time.Minute.Milliseconds() },
+	})
+	classes, err := loadStorageClasses("testdata/storage_classes.yaml")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.True(t, UnitTest(&out, engine, classes, "testdata/unittest/pass.test.yaml"), out.String())
+	require.Contains(t, out.String(), "SUCCESS")
+
+	out.Reset()
+	require.False(t, UnitTest(&out, engine, classes, "testdata/unittest/fail.test.yaml"))
+	require.Contains(t, out.String(), `exp: {__name__="job:up:sum", job="api"} 2E+00`)
+
+	// The storage classes of the groups must be known.
+	out.Reset()
+	require.False(t, UnitTest(&out, engine, nil, "testdata/unittest/pass.test.yaml"))
+	require.Contains(t, out.String(), `unknown storage class "rollup"`)
+}
@@ -0,0 +1,65 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/backfill"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/version"
+)
+
+// BackfillCommand is the first argument that runs Promscale in backfill mode
+// instead of starting the connector.
+const BackfillCommand = "backfill"
+
+// Backfill loads the OpenMetrics files and TSDB blocks of the backfill
+// configuration into the database and exits. The schema is migrated first,
+// like on a regular start.
+func Backfill(cfg *Config) error {
+	log.Info("msg", version.Info())
+	if cfg.APICfg.ReadOnly {
+		return fmt.Errorf("cannot backfill in read-only mode")
+	}
+	// Backfilled batches are only counted once they are written.
+	cfg.PgmodelCfg.MetricsAsyncAcks = false
+	cfg.PgmodelCfg.CacheConfig.WarmUpSeries = 0
+	// Historical data is older than the out-of-order windows.
+	cfg.OutOfOrderCfg.Window = 0
+	cfg.OutOfOrderCfg.ConfigFile = ""
+
+	client, err := CreateClient(prometheus.NewRegistry(), cfg)
+	if err != nil {
+		return fmt.Errorf("creating client: %w", err)
+	}
+	if client == nil {
+		return nil
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(c)
+	go func() {
+		select {
+		case <-c:
+			log.Info("msg", "Stopping backfill")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	_, err = backfill.Run(ctx, cfg.BackfillCfg, client)
+	return err
+}
@@ -0,0 +1,83 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/timescale/promscale/pkg/bench"
+)
+
+// BenchCommand is the first argument that runs the load generator, e.g.
+// `promscale bench -series 100000`.
+const BenchCommand = "bench"
+
+// benchCommand sends the synthetic load of the flags in args and writes the
+// report to out. It returns the exit code: 0 if the run completed, 1 if it
+// failed or no write succeeded and 2 if the arguments are invalid.
+func benchCommand(ctx context.Context, args []string, out io.Writer) int {
+	var (
+		fs  = flag.NewFlagSet(BenchCommand, flag.ContinueOnError)
+		cfg bench.Config
+	)
+	bench.ParseFlags(fs, &cfg)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage: promscale %s [flags]\n", BenchCommand)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := bench.Validate(&cfg); err != nil {
+		fmt.Fprintln(out, "invalid bench configuration:", err)
+		return 2
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: cfg.Concurrency,
+	}}
+	report, err := bench.Run(ctx, cfg, client)
+	if err != nil {
+		fmt.Fprintln(out, "bench failed:", err)
+		return 1
+	}
+	if cfg.Output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(out, "writing the report:", err)
+			return 1
+		}
+	} else {
+		report.WriteText(out)
+	}
+	if report.Requests > 0 && report.FailedRequests == report.Requests {
+		return 1
+	}
+	return 0
+}
+
+// RunBenchCommand runs the load generator with args, the arguments after
+// BenchCommand, until it completes or is interrupted, and returns the exit
+// code.
+func RunBenchCommand(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return benchCommand(ctx, args, os.Stdout)
+}
@@ -7,19 +7,34 @@ package runner
 import (
 	"context"
 	"fmt"
+	"os"
 	"strconv"
 
 	"github.com/grafana/regexp"
 	"github.com/jackc/pgx/v4"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/timescale/promscale/pkg/audit"
+	"github.com/timescale/promscale/pkg/consistency"
 	"github.com/timescale/promscale/pkg/dataset"
+	"github.com/timescale/promscale/pkg/dedup"
+	"github.com/timescale/promscale/pkg/indexadvisor"
+	"github.com/timescale/promscale/pkg/integrity"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/metricfilter"
+	"github.com/timescale/promscale/pkg/outoforder"
 	"github.com/timescale/promscale/pkg/pgclient"
 	"github.com/timescale/promscale/pkg/pgmodel"
 	"github.com/timescale/promscale/pkg/pgmodel/common/extension"
 	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
+	"github.com/timescale/promscale/pkg/pgmodel/partitioning"
+	"github.com/timescale/promscale/pkg/querylog"
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/relabel"
 	"github.com/timescale/promscale/pkg/tenancy"
+	"github.com/timescale/promscale/pkg/traceforward"
 	"github.com/timescale/promscale/pkg/util"
 	"github.com/timescale/promscale/pkg/version"
 )
@@ -42,7 +57,8 @@ func CreateClient(r prometheus.Registerer, cfg *Config) (*pgclient.Client, error
 		UpgradePreRelease: cfg.UpgradePrereleaseExtensions,
 	}
 
-	if cfg.InstallExtensions {
+	// The plan and the staged migrations leave the extensions as they are.
+	if cfg.InstallExtensions && !cfg.MigratePlan && cfg.MigrateUntil == nil {
 		err := extension.InstallUpgradeTimescaleDBExtensions(connStr, extOptions)
 		if err != nil {
 			return nil, err
@@ -74,7 +90,18 @@ func CreateClient(r prometheus.Registerer, cfg *Config) (*pgclient.Client, error
 		if !cfg.UseVersionLease {
 			lease = nil
 		}
-		err = pgmodel.Migrate(conn, appVersion, lease, extOptions)
+		switch {
+		case cfg.MigratePlan:
+			plan, err := pgmodel.PlanMigration(conn, appVersion, extOptions)
+			if err != nil {
+				return nil, fmt.Errorf("migration plan error: %w", err)
+			}
+			return nil, plan.Write(os.Stdout)
+		case cfg.MigrateUntil != nil:
+			err = pgmodel.MigrateUntil(conn, appVersion, *cfg.MigrateUntil, lease)
+		default:
+			err = pgmodel.Migrate(conn, appVersion, lease, extOptions)
+		}
 		migrationFailedDueToLockError = err == pgmodel.MigrationLockError
 		if err != nil && err != pgmodel.MigrationLockError {
 			return nil, fmt.Errorf("migration error: %w", err)
@@ -172,6 +199,82 @@ func CreateClient(r prometheus.Registerer, cfg *Config) (*pgclient.Client, error
 		}
 	}
 
+	tenantLimiter, err := ratelimit.NewLimiter(&cfg.TenantLimitsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("tenant limits: %w", err)
+	}
+	cfg.PgmodelCfg.TenantLimiter = tenantLimiter
+	cfg.APICfg.TenantLimiter = tenantLimiter
+
+	spanLimiter, err := ratelimit.NewSpanLimiter(&cfg.TenantLimitsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("span limits: %w", err)
+	}
+	cfg.APICfg.SpanLimiter = spanLimiter
+
+	relabeler, err := relabel.NewRelabeler(&cfg.RelabelCfg)
+	if err != nil {
+		return nil, fmt.Errorf("relabeling: %w", err)
+	}
+	cfg.PgmodelCfg.Relabeler = relabeler
+	cfg.PgmodelCfg.ExternalLabels = relabel.NewExternalLabels(&cfg.RelabelCfg)
+
+	metricFilter, err := metricfilter.NewFilter(&cfg.MetricFilterCfg)
+	if err != nil {
+		return nil, fmt.Errorf("metric filter: %w", err)
+	}
+	cfg.PgmodelCfg.MetricFilter = metricFilter
+
+	cfg.PgmodelCfg.Dedup = dedup.New(&cfg.DedupCfg)
+
+	outOfOrder, err := outoforder.NewWindow(&cfg.OutOfOrderCfg)
+	if err != nil {
+		return nil, fmt.Errorf("out-of-order windows: %w", err)
+	}
+	cfg.PgmodelCfg.OutOfOrder = outOfOrder
+	cfg.PgmodelCfg.LastWriteWins = cfg.OutOfOrderCfg.ConflictPolicy == outoforder.LastWriteWins
+
+	valueEncodings, err := encoding.NewResolver(&cfg.ValueEncodingsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("value encodings: %w", err)
+	}
+	cfg.PgmodelCfg.ValueEncodings = valueEncodings
+
+	tenantPartitioning, err := partitioning.New(&cfg.TenancyCfg)
+	if err != nil {
+		return nil, fmt.Errorf("tenant partitioning: %w", err)
+	}
+	cfg.PgmodelCfg.TenantPartitioning = tenantPartitioning
+
+	tailSampling, err := trace.NewTailSamplingPolicies(&cfg.TailSamplingCfg)
+	if err != nil {
+		return nil, fmt.Errorf("tail sampling: %w", err)
+	}
+	cfg.PgmodelCfg.TailSampling = tailSampling
+	cfg.PgmodelCfg.SpanMetrics = cfg.SpanMetricsCfg
+
+	traceForwarder, err := traceforward.New(&cfg.TraceForwardCfg)
+	if err != nil {
+		return nil, fmt.Errorf("trace forwarding: %w", err)
+	}
+	cfg.PgmodelCfg.TraceForwarder = traceForwarder
+
+	indexAdvisor := indexadvisor.NewAdvisor(cfg.IndexAdvisorCfg)
+	cfg.PgmodelCfg.IndexAdvisor = indexAdvisor
+	cfg.APICfg.IndexAdvisor = indexAdvisor
+	cfg.APICfg.IntegrityVerifier = integrity.NewVerifier(cfg.IntegrityCfg)
+	queryLog, err := querylog.New(cfg.QueryLogCfg)
+	if err != nil {
+		return nil, fmt.Errorf("query log: %w", err)
+	}
+	cfg.APICfg.QueryLog = queryLog
+	auditLog, err := audit.New(cfg.AuditLogCfg)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %w", err)
+	}
+	cfg.APICfg.AuditLog = auditLog
+	cfg.APICfg.StorageUsageTenantLabel = cfg.DatabaseMetricsCfg.StorageUsageTenantLabel
+
 	// client has to be initiated after migrate since migrate
 	// can change database GUC settings
 	client, err := pgclient.NewClient(r, &cfg.PgmodelCfg, multiTenancy, leasingFunction, cfg.APICfg.ReadOnly)
@@ -181,6 +284,10 @@ func CreateClient(r prometheus.Registerer, cfg *Config) (*pgclient.Client, error
 	if err = client.InitPromQLEngine(&cfg.PromQLCfg); err != nil {
 		return nil, fmt.Errorf("initializing PromQL Engine: %w", err)
 	}
+	if _, err = consistency.Check(context.Background(), client.MaintenanceConnection(), cfg.ConsistencyCfg); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("consistency check: %w", err)
+	}
 
 	return client, nil
 }
@@ -0,0 +1,359 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/limits/mem"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// ConfigCommand is the first argument of the configuration subcommands, e.g.
+// `promscale config check`.
+const ConfigCommand = "config"
+
+const (
+	// ConfigCheckCommand is the config subcommand validating the configuration.
+	ConfigCheckCommand = "check"
+	// ConfigSchemaCommand is the config subcommand printing the JSON schema
+	// of the configuration.
+	ConfigSchemaCommand = "schema"
+)
+
+// checkFlagPrefix is the prefix of the flags of the config check command,
+// which are separated from the connector flags it checks.
+const checkFlagPrefix = "check."
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// configProblem is a problem found by the config check. The setting is the
+// flag the problem is about, empty if it is not about a single flag.
+type configProblem struct {
+	Severity string `json:"severity"`
+	Setting  string `json:"setting,omitempty"`
+	Message  string `json:"message"`
+}
+
+type configCheckResult struct {
+	Valid    bool            `json:"valid"`
+	Problems []configProblem `json:"problems"`
+}
+
+// configCheck validates the configuration given by args, the environment
+// variables and the configuration file like the connector, and returns the
+// exit code: 0 if the configuration is valid, 1 if it is not and 2 if the
+// arguments of the check are invalid. Unlike the connector, it reports all
+// the problems it finds, including the warnings about settings that have no
+// effect.
+func configCheck(args []string, out io.Writer) int {
+	var (
+		fs             = flag.NewFlagSet(ConfigCommand+" "+ConfigCheckCommand, flag.ContinueOnError)
+		format         string
+		connectDB      bool
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&format, checkFlagPrefix+"output", "text", "Format of the problems found: `text` or `json`.")
+	fs.BoolVar(&connectDB, checkFlagPrefix+"connect-db", false, "Also check that the database is reachable with the db flags.")
+	fs.DurationVar(&connectTimeout, checkFlagPrefix+"connect-timeout", 10*time.Second, "Timeout of the database connection of -check.connect-db.")
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage: promscale %s %s [check flags] [promscale flags]\n", ConfigCommand, ConfigCheckCommand)
+		fs.PrintDefaults()
+	}
+	checkArgs, args := splitCheckArgs(fs, args)
+	if err := fs.Parse(checkArgs); err != nil {
+		return 2
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(out, "invalid %soutput %q, must be text or json\n", checkFlagPrefix, format)
+		return 2
+	}
+
+	res := configCheckResult{Valid: true, Problems: []configProblem{}}
+	res.Problems = append(res.Problems, checkConfig(args, connectDB, connectTimeout)...)
+	for _, p := range res.Problems {
+		if p.Severity == severityError {
+			res.Valid = false
+		}
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return 2
+		}
+	} else {
+		writeConfigProblems(out, res)
+	}
+	if !res.Valid {
+		return 1
+	}
+	return 0
+}
+
+// splitCheckArgs separates the flags of fs, all starting with
+// checkFlagPrefix, and their values from the other arguments.
+func splitCheckArgs(fs *flag.FlagSet, args []string) (checkArgs, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || !strings.HasPrefix(name, checkFlagPrefix) {
+			rest = append(rest, arg)
+			continue
+		}
+		checkArgs = append(checkArgs, arg)
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if i+1 < len(args) {
+			i++
+			checkArgs = append(checkArgs, args[i])
+		}
+	}
+	return checkArgs, rest
+}
+
+// checkConfig returns the problems of the configuration given by args.
+func checkConfig(args []string, connectDB bool, connectTimeout time.Duration) []configProblem {
+	cfg := &Config{}
+	fs, sf, err := parseFlagSet(cfg, args, io.Discard)
+	if err != nil {
+		// The other checks need the parsed flags.
+		return []configProblem{{Severity: severityError, Message: err.Error()}}
+	}
+
+	var problems []configProblem
+	for _, v := range validations(cfg) {
+		if err := v.check(); err != nil {
+			problems = append(problems, configProblem{
+				Severity: severityError,
+				Message:  fmt.Sprintf("invalid %s configuration: %s", v.name, err),
+			})
+		}
+	}
+	if err := applyStartupFlags(cfg, fs, sf); err != nil {
+		problems = append(problems, configProblem{Severity: severityError, Message: err.Error()})
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	problems = append(problems, crossCheck(cfg, set)...)
+
+	if connectDB {
+		if err := checkDBConnection(cfg, connectTimeout); err != nil {
+			problems = append(problems, configProblem{
+				Severity: severityError,
+				Message:  fmt.Sprintf("cannot connect to the database: %s", err),
+			})
+		}
+	}
+	return problems
+}
+
+// crossCheck checks the settings that are valid on their own against each
+// other and against the environment. set are the flags set explicitly.
+func crossCheck(cfg *Config, set map[string]bool) []configProblem {
+	var problems []configProblem
+	add := func(severity, setting, format string, args ...interface{}) {
+		problems = append(problems, configProblem{Severity: severity, Setting: setting, Message: fmt.Sprintf(format, args...)})
+	}
+
+	tlsFiles := []struct{ setting, file string }{
+		{"auth.tls-cert-file", cfg.TLSCertFile},
+		{"auth.tls-key-file", cfg.TLSKeyFile},
+		{"auth.tls-client-ca-file", cfg.TLSClientCAFile},
+	}
+	for _, f := range tlsFiles {
+		if f.file == "" {
+			continue
+		}
+		if _, err := os.Stat(f.file); err != nil {
+			add(severityError, f.setting, "cannot read the TLS file: %s", err)
+		}
+	}
+	if len(problems) == 0 {
+		if _, err := serverTLSConfig(cfg); err != nil {
+			add(severityError, "auth.tls-cert-file", "invalid TLS configuration: %s", err)
+		}
+	}
+
+	targetMemory := cfg.LimitsCfg.TargetMemoryBytes
+	if sysMemory := mem.SystemMemory(); sysMemory > 0 && targetMemory > sysMemory {
+		add(severityWarning, "cache.memory-target", "the memory target (%d bytes) is larger than the system memory (%d bytes)", targetMemory, sysMemory)
+	}
+	cacheCfg := cfg.PgmodelCfg.CacheConfig
+	if cacheCfg.AdaptiveSizing && cacheCfg.SeriesCacheMemoryMaxBytes > cacheCfg.MemoryBudgetBytes {
+		add(severityWarning, "metrics.cache.series.max-bytes", "the series cache maximum (%d bytes) is larger than metrics.cache.memory-budget (%d bytes), "+
+			"adaptive sizing keeps all the caches within the budget", cacheCfg.SeriesCacheMemoryMaxBytes, cacheCfg.MemoryBudgetBytes)
+	}
+	if !cacheCfg.AdaptiveSizing && set["metrics.cache.memory-budget"] {
+		add(severityWarning, "metrics.cache.memory-budget", "has no effect without metrics.cache.adaptive-sizing")
+	}
+	if ingestMemory := cfg.PgmodelCfg.IngestMemory; ingestMemory.BudgetBytes > 0 {
+		if !cacheCfg.AdaptiveSizing {
+			add(severityWarning, "metrics.ingest.memory-budget", "the caches are accounted against the budget but only shrunk to fit with metrics.cache.adaptive-sizing")
+		} else if cacheCfg.MemoryBudgetBytes > ingestMemory.BudgetBytes {
+			add(severityWarning, "metrics.cache.memory-budget", "the cache budget (%d bytes) is larger than metrics.ingest.memory-budget (%d bytes), "+
+				"the caches are kept below the low watermark of the ingest budget", cacheCfg.MemoryBudgetBytes, ingestMemory.BudgetBytes)
+		}
+	}
+	if cacheCfg.WarmUpSeries > cacheCfg.SeriesCacheInitialSize {
+		add(severityWarning, "metrics.cache.warm-up.series", "only the %d series of metrics.cache.series.initial-size are warmed up, not %d",
+			cacheCfg.SeriesCacheInitialSize, cacheCfg.WarmUpSeries)
+	}
+
+	if !cfg.TenancyCfg.EnableMultiTenancy {
+		for _, setting := range []string{"metrics.multi-tenancy.allow-non-tenants", "metrics.multi-tenancy.valid-tenants",
+			"metrics.multi-tenancy.partitioned-metrics", "metrics.multi-tenancy.partitions"} {
+			if set[setting] {
+				add(severityWarning, setting, "has no effect without metrics.multi-tenancy")
+			}
+		}
+	}
+	if cfg.APICfg.HighAvailability && cfg.TenancyCfg.EnableMultiTenancy {
+		// The HA filter reads the tenant of a write request from its first series.
+		add(severityWarning, "metrics.high-availability", "the HA leases are taken per tenant, each write request must contain the series of a single tenant, "+
+			"e.g. with the tenant header or a tenant external label per Prometheus")
+	}
+	if !cfg.AuditLogCfg.Enabled() {
+		for _, setting := range []string{"web.audit-log.endpoints", "web.audit-log.tenants"} {
+			if set[setting] {
+				add(severityWarning, setting, "has no effect without web.audit-log.file, web.audit-log.syslog or web.audit-log.database")
+			}
+		}
+	}
+	return problems
+}
+
+func checkDBConnection(cfg *Config, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, cfg.PgmodelCfg.GetConnectionStr())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close(context.Background()) }()
+	return conn.Ping(ctx)
+}
+
+func writeConfigProblems(out io.Writer, res configCheckResult) {
+	var errs, warnings int
+	for _, p := range res.Problems {
+		if p.Severity == severityError {
+			errs++
+		} else {
+			warnings++
+		}
+		if p.Setting != "" {
+			fmt.Fprintf(out, "%s: %s: %s\n", p.Severity, p.Setting, p.Message)
+		} else {
+			fmt.Fprintf(out, "%s: %s\n", p.Severity, p.Message)
+		}
+	}
+	if res.Valid {
+		fmt.Fprintf(out, "configuration is valid (%d warnings)\n", warnings)
+	} else {
+		fmt.Fprintf(out, "configuration is invalid (%d errors, %d warnings)\n", errs, warnings)
+	}
+}
+
+// schemaProperty is the JSON schema of a setting.
+type schemaProperty struct {
+	Type        string      `json:"type"`
+	Format      string      `json:"format,omitempty"`
+	Default     interface{} `json:"default"`
+	Description string      `json:"description"`
+	Deprecated  bool        `json:"deprecated,omitempty"`
+	// EnvVar is the environment variable setting the flag.
+	EnvVar string `json:"x-env-var"`
+}
+
+type configSchemaDoc struct {
+	Schema               string                    `json:"$schema"`
+	Title                string                    `json:"title"`
+	Type                 string                    `json:"type"`
+	Properties           map[string]schemaProperty `json:"properties"`
+	AdditionalProperties bool                      `json:"additionalProperties"`
+}
+
+// configSchema returns the JSON schema of the configuration file, whose keys
+// are the names of the flags.
+func configSchema() configSchemaDoc {
+	doc := configSchemaDoc{
+		Schema:     "https://json-schema.org/draft/2020-12/schema",
+		Title:      "Promscale configuration",
+		Type:       "object",
+		Properties: make(map[string]schemaProperty),
+	}
+	fs := newFlagSet(&Config{}, &startupFlags{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			// The configuration file is not read from itself.
+			return
+		}
+		p := schemaProperty{
+			Type:        "string",
+			Default:     f.DefValue,
+			Description: f.Usage,
+			Deprecated:  strings.Contains(f.Usage, "DEPRECATED"),
+			EnvVar:      util.GetEnvVarName(envVarPrefix, f.Name),
+		}
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch v := getter.Get().(type) {
+			case bool:
+				p.Type, p.Default = "boolean", v
+			case int, int64, uint, uint64:
+				p.Type, p.Default = "integer", v
+			case float64:
+				p.Type, p.Default = "number", v
+			case time.Duration:
+				p.Format = "duration"
+			}
+		}
+		doc.Properties[f.Name] = p
+	})
+	return doc
+}
+
+// RunConfigCommand runs the config subcommand of args, the arguments after
+// ConfigCommand, and returns the exit code.
+func RunConfigCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case ConfigCheckCommand:
+			return configCheck(args[1:], os.Stdout)
+		case ConfigSchemaCommand:
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(configSchema()); err != nil {
+				fmt.Fprintln(os.Stderr, "writing the configuration schema:", err)
+				return 1
+			}
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: promscale %s %s|%s [flags]\n", ConfigCommand, ConfigCheckCommand, ConfigSchemaCommand)
+	return 2
+}
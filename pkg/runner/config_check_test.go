@@ -0,0 +1,119 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCheckArgs(t *testing.T) {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.String("check.output", "text", "")
+	fs.Bool("check.connect-db", false, "")
+
+	checkArgs, rest := splitCheckArgs(fs, []string{
+		"-check.output", "json", "-db.host", "localhost", "--check.connect-db", "-metrics.multi-tenancy", "-check.output=text",
+	})
+	require.Equal(t, []string{"-check.output", "json", "--check.connect-db", "-check.output=text"}, checkArgs)
+	require.Equal(t, []string{"-db.host", "localhost", "-metrics.multi-tenancy"}, rest)
+}
+
+func TestConfigCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		code     int
+		problems []configProblem
+	}{
+		{
+			name: "valid",
+			args: []string{},
+			code: 0,
+		},
+		{
+			name: "invalid flag",
+			args: []string{"-foo"},
+			code: 1,
+			problems: []configProblem{
+				{Severity: severityError, Message: "configuration error when parsing flags: error parsing commandline args: flag provided but not defined: -foo"},
+			},
+		},
+		{
+			name: "missing TLS files",
+			args: []string{"-auth.tls-cert-file", "missing.crt", "-auth.tls-key-file", "missing.key"},
+			code: 1,
+			problems: []configProblem{
+				{Severity: severityError, Setting: "auth.tls-cert-file", Message: "cannot read the TLS file: stat missing.crt: no such file or directory"},
+				{Severity: severityError, Setting: "auth.tls-key-file", Message: "cannot read the TLS file: stat missing.key: no such file or directory"},
+			},
+		},
+		{
+			name: "all problems reported",
+			args: []string{"-metrics.multi-tenancy.allow-non-tenants", "-startup.migrate", "bogus", "-metrics.cache.adaptive-sizing.interval", "-1s", "-metrics.cache.adaptive-sizing"},
+			code: 1,
+			problems: []configProblem{
+				{Severity: severityError, Message: "invalid client configuration: metrics.cache.adaptive-sizing.interval must be positive, got -1s"},
+				{Severity: severityError, Message: `invalid startup.migrate "bogus", must be apply, plan or apply-until=<version>`},
+				{Severity: severityWarning, Setting: "metrics.multi-tenancy.allow-non-tenants", Message: "has no effect without metrics.multi-tenancy"},
+			},
+		},
+		{
+			name: "warnings only",
+			args: []string{"-metrics.cache.warm-up.series", "1000", "-metrics.cache.series.initial-size", "10"},
+			code: 0,
+			problems: []configProblem{
+				{Severity: severityWarning, Setting: "metrics.cache.warm-up.series", Message: "only the 10 series of metrics.cache.series.initial-size are warmed up, not 1000"},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := configCheck(append([]string{"-check.output", "json"}, c.args...), &out)
+			require.Equal(t, c.code, code, out.String())
+
+			var res configCheckResult
+			require.NoError(t, json.Unmarshal(out.Bytes(), &res))
+			require.Equal(t, c.code == 0, res.Valid)
+			if c.problems == nil {
+				c.problems = []configProblem{}
+			}
+			require.Equal(t, c.problems, res.Problems)
+		})
+	}
+
+	var out bytes.Buffer
+	require.Equal(t, 2, configCheck([]string{"-check.output", "yaml"}, &out))
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := configSchema()
+	require.NotContains(t, schema.Properties, "config")
+
+	p := schema.Properties["metrics.multi-tenancy"]
+	require.Equal(t, "boolean", p.Type)
+	require.Equal(t, false, p.Default)
+	require.Equal(t, "PROMSCALE_METRICS_MULTI_TENANCY", p.EnvVar)
+
+	p = schema.Properties["metrics.cache.series.initial-size"]
+	require.Equal(t, "integer", p.Type)
+
+	p = schema.Properties["tracing.service-graph.delay"]
+	require.Equal(t, "string", p.Type)
+	require.Equal(t, "duration", p.Format)
+	require.Equal(t, "2m0s", p.Default)
+
+	require.True(t, schema.Properties["tracing.otlp.server-address"].Deprecated)
+
+	// The schema is valid JSON.
+	_, err := json.Marshal(schema)
+	require.NoError(t, err)
+}
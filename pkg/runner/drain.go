@@ -0,0 +1,44 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"context"
+	"time"
+
+	"github.com/timescale/promscale/pkg/api"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/rules"
+)
+
+// drainRetryAfter is how long the clients of a draining connector wait
+// before sending the rejected writes again, to another connector.
+const drainRetryAfter = 5 * time.Second
+
+// drain waits, for at most timeout, for the writes in flight to complete and
+// for the rule evaluations in progress to send their samples. The batches
+// pending in the ingestor are flushed when the client is closed, once the
+// servers are shut down.
+func drain(timeout time.Duration, drainer *api.Drainer, rulesManager *rules.Manager) {
+	log.Info("msg", "Draining: rejecting the new writes and waiting for the writes in flight", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := drainer.Wait(ctx); err != nil {
+		log.Warn("msg", "Timed out waiting for the writes in flight", "err", err)
+	}
+	if rulesManager != nil {
+		stopped := make(chan struct{})
+		go func() {
+			rulesManager.StopEvaluation()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Warn("msg", "Timed out waiting for the rule evaluations in progress")
+		}
+	}
+	log.Info("msg", "Drained, flushing the pending batches and shutting down")
+}
@@ -7,30 +7,56 @@ package runner
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/blang/semver/v4"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffyaml"
 	"github.com/timescale/promscale/pkg/api"
+	"github.com/timescale/promscale/pkg/audit"
 	"github.com/timescale/promscale/pkg/auth"
+	"github.com/timescale/promscale/pkg/backfill"
+	"github.com/timescale/promscale/pkg/consistency"
+	"github.com/timescale/promscale/pkg/dedup"
+	"github.com/timescale/promscale/pkg/election"
+	"github.com/timescale/promscale/pkg/indexadvisor"
+	"github.com/timescale/promscale/pkg/integrity"
 	jaegerStore "github.com/timescale/promscale/pkg/jaeger/store"
+	"github.com/timescale/promscale/pkg/labelcompaction"
 	"github.com/timescale/promscale/pkg/limits"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/maintenance"
+	"github.com/timescale/promscale/pkg/metricfilter"
+	"github.com/timescale/promscale/pkg/outoforder"
 	"github.com/timescale/promscale/pkg/pgclient"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
+	dbMetrics "github.com/timescale/promscale/pkg/pgmodel/metrics/database"
 	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/querylog"
+	"github.com/timescale/promscale/pkg/ratelimit"
+	"github.com/timescale/promscale/pkg/relabel"
 	"github.com/timescale/promscale/pkg/rules"
+	"github.com/timescale/promscale/pkg/scrape"
+	"github.com/timescale/promscale/pkg/servicegraph"
+	"github.com/timescale/promscale/pkg/spanmetrics"
 	"github.com/timescale/promscale/pkg/tenancy"
+	"github.com/timescale/promscale/pkg/thanos"
+	"github.com/timescale/promscale/pkg/traceforward"
 	"github.com/timescale/promscale/pkg/tracer"
 	"github.com/timescale/promscale/pkg/util"
 	"github.com/timescale/promscale/pkg/vacuum"
+	"github.com/timescale/promscale/pkg/webhook"
 )
 
 type Config struct {
 	ListenAddr                  string
 	ThanosStoreAPIListenAddr    string
 	TracingGRPCListenAddr       string
+	DrainTimeout                time.Duration
 	PgmodelCfg                  pgclient.Config
 	LogCfg                      log.Config
 	TracerCfg                   tracer.Config
@@ -38,16 +64,43 @@ type Config struct {
 	AuthConfig                  auth.Config
 	LimitsCfg                   limits.Config
 	TenancyCfg                  tenancy.Config
+	TenantLimitsCfg             ratelimit.Config
+	RelabelCfg                  relabel.Config
+	MetricFilterCfg             metricfilter.Config
+	DedupCfg                    dedup.Config
+	OutOfOrderCfg               outoforder.Config
+	ValueEncodingsCfg           encoding.Config
+	TailSamplingCfg             trace.TailSamplingConfig
+	SpanMetricsCfg              spanmetrics.Config
+	TraceForwardCfg             traceforward.Config
+	ServiceGraphCfg             servicegraph.Config
+	IndexAdvisorCfg             indexadvisor.Config
+	IntegrityCfg                integrity.Config
+	MaintenanceCfg              maintenance.Config
+	DatabaseMetricsCfg          dbMetrics.Config
+	QueryLogCfg                 querylog.Config
+	AuditLogCfg                 audit.Config
+	WebhookCfg                  webhook.Config
+	ConsistencyCfg              consistency.Config
+	ElectionCfg                 election.Config
+	LabelCompactionCfg          labelcompaction.Config
+	ThanosCfg                   thanos.Config
 	PromQLCfg                   query.Config
 	RulesCfg                    rules.Config
+	ScrapeCfg                   scrape.Config
 	TracingCfg                  jaegerStore.Config
 	VacuumCfg                   vacuum.Config
+	BackfillCfg                 backfill.Config
 	ConfigFile                  string
 	DatasetConfig               string
 	TLSCertFile                 string
 	TLSKeyFile                  string
+	TLSClientCAFile             string
+	TLSClientAuth               string
 	ThroughputInterval          time.Duration
 	Migrate                     bool
+	MigratePlan                 bool
+	MigrateUntil                *semver.Version
 	StopAfterMigrate            bool
 	UseVersionLease             bool
 	InstallExtensions           bool
@@ -119,13 +172,17 @@ var (
 	}
 )
 
-func ParseFlags(cfg *Config, args []string) (*Config, error) {
-	var (
-		fs = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+// startupFlags are the flags that are not stored in the Config as they are.
+type startupFlags struct {
+	corsOrigin  string
+	skipMigrate bool
+	migrateMode string
+}
 
-		corsOriginFlag string
-		skipMigrate    bool
-	)
+// newFlagSet registers all the flags of the connector, storing their values
+// in cfg and sf.
+func newFlagSet(cfg *Config, sf *startupFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
 	pgclient.ParseFlags(fs, &cfg.PgmodelCfg)
 	log.ParseFlags(fs, &cfg.LogCfg)
@@ -134,21 +191,49 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 	auth.ParseFlags(fs, &cfg.AuthConfig)
 	limits.ParseFlags(fs, &cfg.LimitsCfg)
 	tenancy.ParseFlags(fs, &cfg.TenancyCfg)
+	ratelimit.ParseFlags(fs, &cfg.TenantLimitsCfg)
+	relabel.ParseFlags(fs, &cfg.RelabelCfg)
+	metricfilter.ParseFlags(fs, &cfg.MetricFilterCfg)
+	dedup.ParseFlags(fs, &cfg.DedupCfg)
+	outoforder.ParseFlags(fs, &cfg.OutOfOrderCfg)
+	encoding.ParseFlags(fs, &cfg.ValueEncodingsCfg)
+	trace.ParseTailSamplingFlags(fs, &cfg.TailSamplingCfg)
+	spanmetrics.ParseFlags(fs, &cfg.SpanMetricsCfg)
+	traceforward.ParseFlags(fs, &cfg.TraceForwardCfg)
+	servicegraph.ParseFlags(fs, &cfg.ServiceGraphCfg)
+	indexadvisor.ParseFlags(fs, &cfg.IndexAdvisorCfg)
+	integrity.ParseFlags(fs, &cfg.IntegrityCfg)
+	maintenance.ParseFlags(fs, &cfg.MaintenanceCfg)
+	dbMetrics.ParseFlags(fs, &cfg.DatabaseMetricsCfg)
+	querylog.ParseFlags(fs, &cfg.QueryLogCfg)
+	audit.ParseFlags(fs, &cfg.AuditLogCfg)
+	webhook.ParseFlags(fs, &cfg.WebhookCfg)
+	consistency.ParseFlags(fs, &cfg.ConsistencyCfg)
+	election.ParseFlags(fs, &cfg.ElectionCfg)
+	labelcompaction.ParseFlags(fs, &cfg.LabelCompactionCfg)
+	thanos.ParseFlags(fs, &cfg.ThanosCfg)
 	query.ParseFlags(fs, &cfg.PromQLCfg)
 	jaegerStore.ParseFlags(fs, &cfg.TracingCfg)
 	rules.ParseFlags(fs, &cfg.RulesCfg)
+	scrape.ParseFlags(fs, &cfg.ScrapeCfg)
 	vacuum.ParseFlags(fs, &cfg.VacuumCfg)
+	backfill.ParseFlags(fs, &cfg.BackfillCfg)
 
 	fs.StringVar(&cfg.ConfigFile, "config", "config.yml", "YAML configuration file path for Promscale.")
 	fs.StringVar(&cfg.ListenAddr, "web.listen-address", ":9201", "Address to listen on for web endpoints.")
+	fs.DurationVar(&cfg.DrainTimeout, "web.drain-timeout", 20*time.Second, "How long the connector waits for the writes in flight to complete when draining, "+
+		"on SIGTERM or on a POST to /-/drain, before flushing the pending batches and exiting.")
 	fs.StringVar(&cfg.ThanosStoreAPIListenAddr, "thanos.store-api.server-address", "", "Address to listen on for Thanos Store API endpoints.")
 	fs.StringVar(&cfg.TracingGRPCListenAddr, "tracing.otlp.server-address", ":9202", "GRPC server address to listen on for Jaeger and OTEL traces(DEPRECATED: use `tracing.grpc.server-address` instead).") //TODO: remove this flag at some point
-	fs.StringVar(&cfg.TracingGRPCListenAddr, "tracing.grpc.server-address", ":9202", "GRPC server address to listen on for Jaeger and OTEL traces.")
-	fs.StringVar(&corsOriginFlag, "web.cors-origin", ".*", `Regex for CORS origin. It is fully anchored. Example: 'https?://(domain1|domain2)\.com'`)
+	fs.StringVar(&cfg.TracingGRPCListenAddr, "tracing.grpc.server-address", ":9202", "GRPC server address to listen on for Jaeger and OTEL traces, and for the metric write service.")
+	fs.StringVar(&sf.corsOrigin, "web.cors-origin", ".*", `Regex for CORS origin. It is fully anchored. Example: 'https?://(domain1|domain2)\.com'`)
 	fs.DurationVar(&cfg.ThroughputInterval, "telemetry.log.throughput-report-interval", time.Second, "Duration interval at which throughput should be reported. Setting duration to `0` will disable reporting throughput, otherwise, an interval with unit must be provided, e.g. `10s` or `3m`.")
 	fs.StringVar(&cfg.DatasetConfig, "startup.dataset.config", "", "Dataset configuration in YAML format for Promscale. It is used for setting various dataset configuration like default metric chunk interval")
 	fs.BoolVar(&cfg.StartupOnly, "startup.only", false, "Only run startup configuration with Promscale (i.e. migrate) and exit. Can be used to run promscale as an init container for HA setups.")
-	fs.BoolVar(&skipMigrate, "startup.skip-migrate", false, "Skip migrating Promscale SQL schema to latest version on startup.")
+	fs.BoolVar(&sf.skipMigrate, "startup.skip-migrate", false, "Skip migrating Promscale SQL schema to latest version on startup.")
+	fs.StringVar(&sf.migrateMode, "startup.migrate", "apply", "How the Promscale SQL schema is migrated on startup. `apply` migrates it to the latest version. "+
+		"`plan` prints the SQL and extension changes the migration would apply, with the locks they take, and exits. "+
+		"`apply-until=<version>` migrates it up to the given version, for staged upgrades, and exits.")
 
 	fs.BoolVar(&cfg.UseVersionLease, "startup.use-schema-version-lease", true, "Use schema version lease to prevent race conditions during migration.")
 	fs.BoolVar(&cfg.InstallExtensions, "startup.install-extensions", true, "Install TimescaleDB, Promscale extension.")
@@ -156,13 +241,43 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 	fs.BoolVar(&cfg.UpgradePrereleaseExtensions, "startup.upgrade-prerelease-extensions", false, "Upgrades to pre-release TimescaleDB, Promscale extensions.")
 	fs.StringVar(&cfg.TLSCertFile, "auth.tls-cert-file", "", "TLS Certificate file used for server authentication, leave blank to disable TLS. NOTE: this option is used for all servers that Promscale runs (web and GRPC).")
 	fs.StringVar(&cfg.TLSKeyFile, "auth.tls-key-file", "", "TLS Key file for server authentication, leave blank to disable TLS. NOTE: this option is used for all servers that Promscale runs (web and GRPC).")
+	fs.StringVar(&cfg.TLSClientCAFile, "auth.tls-client-ca-file", "", "CA certificates file verifying the TLS client certificates, for mutual TLS. Requires auth.tls-cert-file. "+
+		"The common name of a verified client certificate is the identity of the client. NOTE: this option is used for all servers that Promscale runs (web and GRPC).")
+	fs.StringVar(&cfg.TLSClientAuth, "auth.tls-client-auth", tlsClientAuthRequire, "How the TLS client certificates are verified with auth.tls-client-ca-file: "+
+		"`require` rejects the clients without a valid certificate, `verify-if-given` only rejects the clients with an invalid certificate.")
+	return fs
+}
 
-	if err := checkForRemovedEnvVarUsage(); err != nil {
+func ParseFlags(cfg *Config, args []string) (*Config, error) {
+	fs, sf, err := parseFlagSet(cfg, args, nil)
+	if err != nil {
 		return nil, err
 	}
 
+	if err := validate(cfg); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	if err := applyStartupFlags(cfg, fs, sf); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFlagSet parses the flags of args, the environment variables and the
+// configuration file into cfg, without validating them. The usage is written
+// to output on parsing errors, to stderr if output is nil.
+func parseFlagSet(cfg *Config, args []string, output io.Writer) (*flag.FlagSet, *startupFlags, error) {
+	sf := &startupFlags{}
+	fs := newFlagSet(cfg, sf)
+	fs.SetOutput(output)
+
+	if err := checkForRemovedEnvVarUsage(); err != nil {
+		return nil, nil, err
+	}
+
 	if err := util.ParseEnv(envVarPrefix, fs); err != nil {
-		return nil, fmt.Errorf("error parsing env variables: %w", err)
+		return nil, nil, fmt.Errorf("error parsing env variables: %w", err)
 	}
 
 	if err := ff.Parse(fs, args,
@@ -173,31 +288,39 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 		// We might be dealing with old flags whose usage needs to be logged.
 		// TODO: remove handling of old flags in a future version
 		if oldFlagErr := checkForRemovedConfigFlags(fs, args); oldFlagErr != nil {
-			return nil, oldFlagErr
+			return nil, nil, oldFlagErr
 		}
 
-		return nil, fmt.Errorf("configuration error when parsing flags: %w", err)
+		return nil, nil, fmt.Errorf("configuration error when parsing flags: %w", err)
 	}
 
 	// Checking if TLS files are not both set or both empty.
 	if (cfg.TLSCertFile != "") != (cfg.TLSKeyFile != "") {
-		return nil, fmt.Errorf("both TLS Ceriticate File and TLS Key File need to be provided for a valid TLS configuration")
+		return nil, nil, fmt.Errorf("both TLS Ceriticate File and TLS Key File need to be provided for a valid TLS configuration")
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return nil, nil, fmt.Errorf("auth.tls-client-ca-file requires auth.tls-cert-file and auth.tls-key-file")
+	}
+	if cfg.TLSClientAuth != tlsClientAuthRequire && cfg.TLSClientAuth != tlsClientAuthVerifyIfGiven {
+		return nil, nil, fmt.Errorf("invalid auth.tls-client-auth %q, must be %s or %s", cfg.TLSClientAuth, tlsClientAuthRequire, tlsClientAuthVerifyIfGiven)
 	}
 
-	corsOriginRegex, err := compileAnchoredRegexString(corsOriginFlag)
+	corsOriginRegex, err := compileAnchoredRegexString(sf.corsOrigin)
 	if err != nil {
-		return nil, fmt.Errorf("could not compile CORS regex string %v: %w", corsOriginFlag, err)
+		return nil, nil, fmt.Errorf("could not compile CORS regex string %v: %w", sf.corsOrigin, err)
 	}
 	cfg.APICfg.AllowedOrigin = corsOriginRegex
+	cfg.APICfg.Flags = flagValues(fs)
+	return fs, sf, nil
+}
 
-	if err := validate(cfg); err != nil {
-		return nil, fmt.Errorf("validate config: %w", err)
-	}
-
+// applyStartupFlags sets the startup mode of cfg from the startup flags and
+// checks it against the read-only mode.
+func applyStartupFlags(cfg *Config, fs *flag.FlagSet, sf *startupFlags) error {
 	cfg.StopAfterMigrate = false
 	cfg.Migrate = true
 
-	if skipMigrate {
+	if sf.skipMigrate {
 		cfg.Migrate = false
 	}
 
@@ -205,17 +328,30 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 		cfg.StopAfterMigrate = true
 	}
 
+	if err := parseMigrateMode(cfg, sf.migrateMode); err != nil {
+		return err
+	}
+	if cfg.MigratePlan || cfg.MigrateUntil != nil {
+		if sf.skipMigrate {
+			return fmt.Errorf("startup.migrate=%s cannot be used with startup.skip-migrate", sf.migrateMode)
+		}
+		cfg.StopAfterMigrate = true
+	}
+
 	if cfg.APICfg.ReadOnly {
 		flagset := make(map[string]bool)
 		fs.Visit(func(f *flag.Flag) { flagset[f.Name] = true })
 		if (flagset["migrate"] && cfg.Migrate) || (flagset["use-schema-version-lease"] && cfg.UseVersionLease) {
-			return nil, fmt.Errorf("Migration flags not supported in read-only mode")
+			return fmt.Errorf("Migration flags not supported in read-only mode")
 		}
 		if flagset["install-extensions"] && cfg.InstallExtensions {
-			return nil, fmt.Errorf("Cannot install or update TimescaleDB extension in read-only mode")
+			return fmt.Errorf("Cannot install or update TimescaleDB extension in read-only mode")
 		}
 		if flagset["metrics.high-availability"] && cfg.APICfg.HighAvailability {
-			return nil, fmt.Errorf("cannot run Promscale in both HA and read-only mode")
+			return fmt.Errorf("cannot run Promscale in both HA and read-only mode")
+		}
+		if cfg.MigratePlan || cfg.MigrateUntil != nil {
+			return fmt.Errorf("startup.migrate=%s is not supported in read-only mode", sf.migrateMode)
 		}
 		cfg.Migrate = false
 		cfg.StopAfterMigrate = false
@@ -227,36 +363,106 @@ func ParseFlags(cfg *Config, args []string) (*Config, error) {
 	if cfg.APICfg.HighAvailability {
 		cfg.PgmodelCfg.UsesHA = true
 	}
-	return cfg, nil
+	return nil
 }
 
-func validate(cfg *Config) error {
-	if err := api.Validate(&cfg.APICfg); err != nil {
-		return fmt.Errorf("error validating API configuration: %w", err)
-	}
-	if err := auth.Validate(&cfg.AuthConfig); err != nil {
-		return fmt.Errorf("error validating Auth configuration: %w", err)
-	}
-	if err := limits.Validate(&cfg.LimitsCfg); err != nil {
-		return fmt.Errorf("error validating limits configuration: %w", err)
-	}
-	if err := pgclient.Validate(&cfg.PgmodelCfg, cfg.LimitsCfg); err != nil {
-		return fmt.Errorf("error validating client configuration: %w", err)
-	}
-	if err := query.Validate(&cfg.PromQLCfg); err != nil {
-		return fmt.Errorf("error validating PromQL configuration: %w", err)
-	}
-	if err := jaegerStore.Validate(&cfg.TracingCfg); err != nil {
-		return fmt.Errorf("error validating Tracing query configuration: %w", err)
-	}
-	if err := tenancy.Validate(&cfg.TenancyCfg); err != nil {
-		return fmt.Errorf("error validating multi-tenancy configuration: %w", err)
+// parseMigrateMode sets the migration mode of the startup.migrate flag.
+func parseMigrateMode(cfg *Config, mode string) error {
+	switch {
+	case mode == "apply":
+	case mode == "plan":
+		cfg.MigratePlan = true
+	case strings.HasPrefix(mode, "apply-until="):
+		v, err := semver.Parse(strings.TrimPrefix(mode, "apply-until="))
+		if err != nil {
+			return fmt.Errorf("invalid startup.migrate version: %w", err)
+		}
+		cfg.MigrateUntil = &v
+	default:
+		return fmt.Errorf("invalid startup.migrate %q, must be apply, plan or apply-until=<version>", mode)
 	}
-	if err := rules.Validate(&cfg.RulesCfg); err != nil {
-		return fmt.Errorf("error validating rules configuration: %w", err)
+	return nil
+}
+
+// secretFlags are the flags whose values are redacted from the flags
+// endpoint.
+var secretFlags = map[string]bool{
+	"db.password":           true,
+	"db.uri":                true,
+	"db.read-replica-uris":  true,
+	"web.auth.password":     true,
+	"web.auth.bearer-token": true,
+}
+
+// flagValues returns the values of all the flags, with the secrets redacted.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "<secret>"
+		}
+		values[f.Name] = value
+	})
+	return values
+}
+
+// validation is a validation of a part of the configuration.
+type validation struct {
+	name  string
+	check func() error
+}
+
+// validations returns the validations of the parts of cfg. The validations
+// may complete cfg, e.g. with the values computed from the flags.
+func validations(cfg *Config) []validation {
+	return []validation{
+		{"API", func() error { return api.Validate(&cfg.APICfg) }},
+		{"Auth", func() error { return auth.Validate(&cfg.AuthConfig) }},
+		{"limits", func() error { return limits.Validate(&cfg.LimitsCfg) }},
+		{"client", func() error { return pgclient.Validate(&cfg.PgmodelCfg, cfg.LimitsCfg) }},
+		{"PromQL", func() error { return query.Validate(&cfg.PromQLCfg) }},
+		{"Tracing query", func() error { return jaegerStore.Validate(&cfg.TracingCfg) }},
+		{"multi-tenancy", func() error { return tenancy.Validate(&cfg.TenancyCfg) }},
+		{"tenant limits", func() error { return ratelimit.Validate(&cfg.TenantLimitsCfg) }},
+		{"relabeling", func() error { return relabel.Validate(&cfg.RelabelCfg) }},
+		{"metric filter", func() error { return metricfilter.Validate(&cfg.MetricFilterCfg) }},
+		{"deduplication", func() error { return dedup.Validate(&cfg.DedupCfg) }},
+		{"out-of-order", func() error { return outoforder.Validate(&cfg.OutOfOrderCfg) }},
+		{"value encodings", func() error { return encoding.Validate(&cfg.ValueEncodingsCfg) }},
+		{"tail sampling", func() error { return trace.ValidateTailSampling(&cfg.TailSamplingCfg) }},
+		{"span metrics", func() error { return spanmetrics.Validate(&cfg.SpanMetricsCfg) }},
+		{"trace forwarding", func() error { return traceforward.Validate(&cfg.TraceForwardCfg) }},
+		{"service graph", func() error { return servicegraph.Validate(&cfg.ServiceGraphCfg) }},
+		{"index advisor", func() error { return indexadvisor.Validate(&cfg.IndexAdvisorCfg) }},
+		{"integrity verifier", func() error { return integrity.Validate(&cfg.IntegrityCfg) }},
+		{"maintenance jobs", func() error { return maintenance.Validate(&cfg.MaintenanceCfg) }},
+		{"database metrics", func() error { return dbMetrics.Validate(&cfg.DatabaseMetricsCfg) }},
+		{"query log", func() error { return querylog.Validate(&cfg.QueryLogCfg) }},
+		{"audit log", func() error { return audit.Validate(&cfg.AuditLogCfg) }},
+		{"webhook", func() error { return webhook.Validate(&cfg.WebhookCfg) }},
+		{"consistency check", func() error { return consistency.Validate(&cfg.ConsistencyCfg) }},
+		{"leader election", func() error { return election.Validate(&cfg.ElectionCfg) }},
+		{"label compaction", func() error { return labelcompaction.Validate(&cfg.LabelCompactionCfg) }},
+		{"Thanos StoreAPI", func() error { return thanos.Validate(&cfg.ThanosCfg) }},
+		{"rules", func() error { return rules.Validate(&cfg.RulesCfg) }},
+		{"scrape", func() error { return scrape.Validate(&cfg.ScrapeCfg) }},
+		{"vacuum", func() error { return vacuum.Validate(&cfg.VacuumCfg) }},
+		{"backfill", func() error { return backfill.Validate(&cfg.BackfillCfg) }},
+		{"drain", func() error {
+			if cfg.DrainTimeout <= 0 {
+				return fmt.Errorf("web.drain-timeout must be positive, got %s", cfg.DrainTimeout)
+			}
+			return nil
+		}},
 	}
-	if err := vacuum.Validate(&cfg.VacuumCfg); err != nil {
-		return fmt.Errorf("error validating vacuum configuration: %w", err)
+}
+
+func validate(cfg *Config) error {
+	for _, v := range validations(cfg) {
+		if err := v.check(); err != nil {
+			return fmt.Errorf("error validating %s configuration: %w", v.name, err)
+		}
 	}
 	return nil
 }
@@ -278,6 +484,7 @@ func checkForRemovedConfigFlags(fs *flag.FlagSet, args []string) error {
 
 	// Check config file for old names.
 	aliasFS := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	aliasFS.SetOutput(io.Discard)
 	// Need to set config and migrate flags which are special cases.
 	// Config is for detecting config file and migrate for detecting that special configuration setting.
 	aliasFS.String("config", "config.yml", "")
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/blang/semver/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,6 +57,40 @@ func TestParseFlags(t *testing.T) {
 				return c
 			},
 		},
+		{
+			name: "Migration plan",
+			args: []string{"-startup.migrate", "plan"},
+			result: func(c Config) Config {
+				c.MigratePlan = true
+				c.StopAfterMigrate = true
+				return c
+			},
+		},
+		{
+			name: "Staged migration",
+			args: []string{"-startup.migrate", "apply-until=0.14.0"},
+			result: func(c Config) Config {
+				v := semver.MustParse("0.14.0")
+				c.MigrateUntil = &v
+				c.StopAfterMigrate = true
+				return c
+			},
+		},
+		{
+			name:        "Invalid staged migration version",
+			args:        []string{"-startup.migrate", "apply-until=latest"},
+			shouldError: true,
+		},
+		{
+			name:        "Migration plan and skip migrate error",
+			args:        []string{"-startup.migrate", "plan", "-startup.skip-migrate"},
+			shouldError: true,
+		},
+		{
+			name:        "Migration plan and read-only error",
+			args:        []string{"-startup.migrate", "plan", "-db.read-only"},
+			shouldError: true,
+		},
 		{
 			name:        "test removed promql flags",
 			args:        []string{"-promql-enable-feature", "promql-at-modifier"},
@@ -155,15 +190,17 @@ func TestParseFlags(t *testing.T) {
 		},
 		{
 			name: "enable feature should populate map of enabled features",
-			args: []string{"-enable-feature", "tracing,promql-at-modifier,promql-negative-offset,promql-per-step-stats", "-tracing.otlp.server-address", "someaddress"},
+			args: []string{"-enable-feature", "tracing,promql-at-modifier,promql-negative-offset,promql-per-step-stats,promql-experimental-functions,promscale-over-time-pushdown", "-tracing.otlp.server-address", "someaddress"},
 			result: func(c Config) Config {
 				c.TracingGRPCListenAddr = "someaddress"
 				c.PromQLCfg.EnabledFeatureMap = map[string]struct{}{
-					"promql-at-modifier":     {},
-					"promql-negative-offset": {},
-					"promql-per-step-stats":  {},
+					"promql-at-modifier":            {},
+					"promql-negative-offset":        {},
+					"promql-per-step-stats":         {},
+					"promql-experimental-functions": {},
+					"promscale-over-time-pushdown":  {},
 				}
-				c.PromQLCfg.PromscaleEnabledFeatureList = []string{"tracing", "promql-at-modifier", "promql-negative-offset", "promql-per-step-stats"}
+				c.PromQLCfg.PromscaleEnabledFeatureList = []string{"tracing", "promql-at-modifier", "promql-negative-offset", "promql-per-step-stats", "promql-experimental-functions", "promscale-over-time-pushdown"}
 				return c
 			},
 		},
@@ -215,12 +252,34 @@ func TestParseFlags(t *testing.T) {
 			}
 
 			expected := c.result(*defaultConfig)
+			// The flag values are tested in TestFlagValues.
+			expected.APICfg.Flags = config.APICfg.Flags
 			if !reflect.DeepEqual(*config, expected) {
 				t.Fatalf("Unexpected config returned\nwanted:\n%+v\ngot:\n%+v\n", expected, *config)
 			}
 		})
 	}
 }
+func TestFlagValues(t *testing.T) {
+	os.Clearenv()
+	config, err := ParseFlags(&Config{}, []string{"-db.password", "hunter2", "-web.auth.username", "u", "-web.auth.password", "p", "-vacuum.parallelism", "5"})
+	if err != nil {
+		t.Fatalf("Unexpected returned error: %s", err.Error())
+	}
+	flags := config.APICfg.Flags
+	for name, expected := range map[string]string{
+		"db.password":        "<secret>",
+		"web.auth.password":  "<secret>",
+		"web.auth.username":  "u",
+		"vacuum.parallelism": "5",
+		"web.listen-address": ":9201",
+	} {
+		if flags[name] != expected {
+			t.Fatalf("Unexpected value of flag %s: wanted %q, got %q", name, expected, flags[name])
+		}
+	}
+}
+
 func TestParseFlagsConfigPrecedence(t *testing.T) {
 	// Clearing environment variables so they don't interfere with the test.
 	os.Clearenv()
@@ -329,6 +388,7 @@ func TestParseFlagsConfigPrecedence(t *testing.T) {
 			if configFilePath != "" {
 				expected.ConfigFile = configFilePath
 			}
+			expected.APICfg.Flags = config.APICfg.Flags
 
 			if !reflect.DeepEqual(*config, expected) {
 				t.Fatalf("Unexpected config returned\nwanted:\n%+v\ngot:\n%+v\n", expected, *config)
@@ -0,0 +1,187 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgclient"
+	tput "github.com/timescale/promscale/pkg/util/throughput"
+)
+
+// configReloader re-reads the configuration from the arguments, environment
+// and configuration file Promscale was started with, and applies the settings
+// that can change without a restart: log level and format, cache sizes,
+// rules files, scrape configuration, throughput report interval, tenant and
+// span limits, relabeling rules, metric filter and out-of-order windows.
+//
+// Other settings are kept until the next restart. In-flight requests are not
+// affected since the components are updated in place.
+type configReloader struct {
+	mux  sync.Mutex
+	args []string
+	cfg  *Config
+
+	client         *pgclient.Client
+	rulesReloader  func() error
+	scrapeReloader func() error
+
+	lastSuccess bool
+	lastTime    time.Time
+}
+
+func newConfigReloader(args []string, cfg *Config, client *pgclient.Client, rulesReloader, scrapeReloader func() error) *configReloader {
+	return &configReloader{
+		args:           args,
+		cfg:            cfg,
+		client:         client,
+		rulesReloader:  rulesReloader,
+		scrapeReloader: scrapeReloader,
+		lastSuccess:    true,
+		lastTime:       time.Now(),
+	}
+}
+
+func (r *configReloader) reload() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	newCfg, err := ParseFlags(&Config{}, r.args)
+	if err == nil {
+		err = r.apply(newCfg)
+	} else {
+		err = fmt.Errorf("error parsing configuration: %w", err)
+	}
+	r.lastSuccess = err == nil
+	if r.lastSuccess {
+		r.lastTime = time.Now()
+	}
+	return err
+}
+
+// status returns whether the last reload succeeded and when the
+// configuration was last loaded.
+func (r *configReloader) status() (bool, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.lastSuccess, r.lastTime
+}
+
+func (r *configReloader) apply(newCfg *Config) error {
+	cfg := r.cfg
+	warnRestartRequired(cfg, newCfg)
+
+	if newCfg.LogCfg != cfg.LogCfg {
+		if err := log.Init(newCfg.LogCfg); err != nil {
+			return fmt.Errorf("error reloading logger: %w", err)
+		}
+		cfg.LogCfg = newCfg.LogCfg
+		log.Info("msg", "Logger reloaded", "level", cfg.LogCfg.Level, "format", cfg.LogCfg.Format)
+	}
+
+	if r.client != nil {
+		r.client.ExpandCaches(newCfg.PgmodelCfg.CacheConfig)
+	}
+	cfg.PgmodelCfg.CacheConfig = newCfg.PgmodelCfg.CacheConfig
+
+	if newCfg.ThroughputInterval != cfg.ThroughputInterval {
+		if !cfg.APICfg.ReadOnly {
+			tput.SetInterval(newCfg.ThroughputInterval)
+		}
+		cfg.ThroughputInterval = newCfg.ThroughputInterval
+	}
+
+	if r.rulesReloader != nil {
+		// The rules manager reads the rules and lookups files from the paths in cfg.RulesCfg.
+		cfg.RulesCfg.PrometheusConfigAddress = newCfg.RulesCfg.PrometheusConfigAddress
+		cfg.RulesCfg.AnnotationLookupsFile = newCfg.RulesCfg.AnnotationLookupsFile
+		if err := r.rulesReloader(); err != nil {
+			return fmt.Errorf("error reloading rules: %w", err)
+		}
+	}
+
+	if r.scrapeReloader != nil {
+		// The scrape manager reads the scrape configuration from the path in
+		// cfg.ScrapeCfg. Scraping is only started on restart.
+		cfg.ScrapeCfg.ConfigFile = newCfg.ScrapeCfg.ConfigFile
+		if err := r.scrapeReloader(); err != nil {
+			return fmt.Errorf("error reloading scrape configuration: %w", err)
+		}
+	}
+
+	if err := cfg.APICfg.TenantLimiter.Reload(); err != nil {
+		return fmt.Errorf("error reloading tenant limits: %w", err)
+	}
+	if err := cfg.APICfg.SpanLimiter.Reload(); err != nil {
+		return fmt.Errorf("error reloading span limits: %w", err)
+	}
+	if err := cfg.PgmodelCfg.Relabeler.Reload(); err != nil {
+		return fmt.Errorf("error reloading relabeling rules: %w", err)
+	}
+	if err := cfg.PgmodelCfg.MetricFilter.Reload(); err != nil {
+		return fmt.Errorf("error reloading metric filter: %w", err)
+	}
+	if err := cfg.PgmodelCfg.OutOfOrder.Reload(); err != nil {
+		return fmt.Errorf("error reloading out-of-order windows: %w", err)
+	}
+	return nil
+}
+
+// warnRestartRequired logs the changed settings that are only applied on restart.
+func warnRestartRequired(cfg, newCfg *Config) {
+	changed := func(setting string, current, updated interface{}) {
+		if current != updated {
+			log.Warn("msg", "Setting changed, restart Promscale to apply it", "setting", setting)
+		}
+	}
+	changed("web.listen-address", cfg.ListenAddr, newCfg.ListenAddr)
+	changed("web.drain-timeout", cfg.DrainTimeout, newCfg.DrainTimeout)
+	changed("auth.tls-client-ca-file", cfg.TLSClientCAFile, newCfg.TLSClientCAFile)
+	changed("auth.tls-client-auth", cfg.TLSClientAuth, newCfg.TLSClientAuth)
+	changed("web.auth.oidc.issuer-url", cfg.AuthConfig.OIDCIssuerURL, newCfg.AuthConfig.OIDCIssuerURL)
+	changed("web.auth.identity-tenants-file", cfg.AuthConfig.IdentityTenantsFile, newCfg.AuthConfig.IdentityTenantsFile)
+	changed("tracing.grpc.server-address", cfg.TracingGRPCListenAddr, newCfg.TracingGRPCListenAddr)
+	changed("thanos.store-api.server-address", cfg.ThanosStoreAPIListenAddr, newCfg.ThanosStoreAPIListenAddr)
+	changed("thanos.store-api.external-labels", cfg.ThanosCfg.ExternalLabels.String(), newCfg.ThanosCfg.ExternalLabels.String())
+	changed("db.read-only", cfg.APICfg.ReadOnly, newCfg.APICfg.ReadOnly)
+	changed("db.read-replica-uris", cfg.PgmodelCfg.ReadReplicaURIs.String(), newCfg.PgmodelCfg.ReadReplicaURIs.String())
+	changed("db.read-replica.health-check-interval", cfg.PgmodelCfg.ReplicaHealthInterval, newCfg.PgmodelCfg.ReplicaHealthInterval)
+	changed("db.shard-uris", cfg.PgmodelCfg.ShardURIs.String(), newCfg.PgmodelCfg.ShardURIs.String())
+	changed("db.shard-mapping", cfg.PgmodelCfg.ShardMapping.String(), newCfg.PgmodelCfg.ShardMapping.String())
+	changed("metrics.backpressure", cfg.PgmodelCfg.Backpressure, newCfg.PgmodelCfg.Backpressure)
+	changed("metrics.ingest.memory-budget", cfg.PgmodelCfg.IngestMemory, newCfg.PgmodelCfg.IngestMemory)
+	changed("metrics.tenant-limits.file", cfg.TenantLimitsCfg.LimitsFile, newCfg.TenantLimitsCfg.LimitsFile)
+	changed("tracing.span-limits.file", cfg.TenantLimitsCfg.SpanLimitsFile, newCfg.TenantLimitsCfg.SpanLimitsFile)
+	changed("metrics.relabel-configs-file", cfg.RelabelCfg.ConfigFile, newCfg.RelabelCfg.ConfigFile)
+	changed("metrics.external-labels", cfg.RelabelCfg.ExternalLabels.String(), newCfg.RelabelCfg.ExternalLabels.String())
+	changed("metrics.external-labels.on-conflict", cfg.RelabelCfg.ExternalLabelsOnConflict, newCfg.RelabelCfg.ExternalLabelsOnConflict)
+	changed("metrics.filter.config-file", cfg.MetricFilterCfg.ConfigFile, newCfg.MetricFilterCfg.ConfigFile)
+	changed("metrics.dedup.window", cfg.DedupCfg.Window, newCfg.DedupCfg.Window)
+	changed("metrics.out-of-order.window", cfg.OutOfOrderCfg.Window, newCfg.OutOfOrderCfg.Window)
+	changed("metrics.out-of-order.config-file", cfg.OutOfOrderCfg.ConfigFile, newCfg.OutOfOrderCfg.ConfigFile)
+	changed("metrics.out-of-order.conflict-policy", cfg.OutOfOrderCfg.ConflictPolicy, newCfg.OutOfOrderCfg.ConflictPolicy)
+	changed("tracing.tail-sampling.config-file", cfg.TailSamplingCfg.ConfigFile, newCfg.TailSamplingCfg.ConfigFile)
+	changed("tracing.forward.endpoints", cfg.TraceForwardCfg.Endpoints.String(), newCfg.TraceForwardCfg.Endpoints.String())
+	changed("metrics.federation.endpoints", cfg.APICfg.FederationCfg.Endpoints.String(), newCfg.APICfg.FederationCfg.Endpoints.String())
+	changed("metrics.query-log.file", cfg.QueryLogCfg.File, newCfg.QueryLogCfg.File)
+	changed("metrics.query-log.database", cfg.QueryLogCfg.Database, newCfg.QueryLogCfg.Database)
+	changed("metrics.query-log.min-duration", cfg.QueryLogCfg.MinDuration, newCfg.QueryLogCfg.MinDuration)
+	changed("web.audit-log.file", cfg.AuditLogCfg.File, newCfg.AuditLogCfg.File)
+	changed("web.audit-log.syslog", cfg.AuditLogCfg.Syslog, newCfg.AuditLogCfg.Syslog)
+	changed("web.audit-log.database", cfg.AuditLogCfg.Database, newCfg.AuditLogCfg.Database)
+	changed("web.audit-log.endpoints", cfg.AuditLogCfg.Endpoints, newCfg.AuditLogCfg.Endpoints)
+	changed("web.audit-log.tenants", cfg.AuditLogCfg.Tenants, newCfg.AuditLogCfg.Tenants)
+	changed("metrics.storage-usage.interval", cfg.DatabaseMetricsCfg.StorageUsageInterval, newCfg.DatabaseMetricsCfg.StorageUsageInterval)
+	changed("metrics.storage-usage.top-k", cfg.DatabaseMetricsCfg.StorageUsageTopK, newCfg.DatabaseMetricsCfg.StorageUsageTopK)
+	changed("metrics.storage-usage.tenant-label", cfg.DatabaseMetricsCfg.StorageUsageTenantLabel, newCfg.DatabaseMetricsCfg.StorageUsageTenantLabel)
+	changed("webhooks.urls", cfg.WebhookCfg.URLs.String(), newCfg.WebhookCfg.URLs.String())
+	changed("webhooks.events", cfg.WebhookCfg.Events.String(), newCfg.WebhookCfg.Events.String())
+	changed("webhooks.timeout", cfg.WebhookCfg.Timeout, newCfg.WebhookCfg.Timeout)
+	changed("webhooks.min-interval", cfg.WebhookCfg.MinInterval, newCfg.WebhookCfg.MinInterval)
+	changed("webhooks.maintenance-check-interval", cfg.WebhookCfg.MaintenanceInterval, newCfg.WebhookCfg.MaintenanceInterval)
+}
@@ -0,0 +1,53 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigReloader(t *testing.T) {
+	// Clearing environment variables so they don't interfere with the test.
+	os.Clearenv()
+
+	configFile := filepath.Join(t.TempDir(), "promscale.yml")
+	writeConfig := func(contents string) {
+		require.NoError(t, os.WriteFile(configFile, []byte(contents), 0600))
+	}
+	writeConfig("telemetry.log.level: info")
+
+	args := []string{"-config=" + configFile}
+	cfg, err := ParseFlags(&Config{}, args)
+	require.NoError(t, err)
+
+	rulesReloads := 0
+	reloader := newConfigReloader(args, cfg, nil, func() error {
+		rulesReloads++
+		return nil
+	}, nil)
+
+	writeConfig("telemetry.log.level: debug\nmetrics.cache.metrics.size: 20000\nweb.listen-address: localhost:9201")
+	require.NoError(t, reloader.reload())
+	require.Equal(t, "debug", cfg.LogCfg.Level)
+	require.Equal(t, uint64(20000), cfg.PgmodelCfg.CacheConfig.MetricsCacheSize)
+	require.Equal(t, 1, rulesReloads)
+	// Listen address is only applied on restart.
+	require.Equal(t, ":9201", cfg.ListenAddr)
+	success, loaded := reloader.status()
+	require.True(t, success)
+
+	// An invalid configuration leaves the running one untouched.
+	writeConfig("telemetry.log.level: verbose")
+	require.Error(t, reloader.reload())
+	require.Equal(t, "debug", cfg.LogCfg.Level)
+	require.Equal(t, 1, rulesReloads)
+	success, lastLoaded := reloader.status()
+	require.False(t, success)
+	require.Equal(t, loaded, lastLoaded)
+}
@@ -0,0 +1,78 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/query"
+	"github.com/timescale/promscale/pkg/rules"
+)
+
+// RulesCommand is the first argument of the rules subcommands, e.g.
+// `promscale rules test`.
+const RulesCommand = "rules"
+
+// RulesTestCommand is the rules subcommand running the rule unit tests.
+const RulesTestCommand = "test"
+
+// rulesTest runs the promtool rule unit test files given as arguments after
+// the flags, and returns the exit code: 0 if all the tests passed, 1 if any
+// failed and 2 if the arguments are invalid. The rules are evaluated by the
+// PromQL engine of Promscale, set up with the same PromQL and rules flags as
+// the connector, over an in-memory storage.
+func rulesTest(args []string, out io.Writer) int {
+	var (
+		fs       = flag.NewFlagSet(RulesCommand+" "+RulesTestCommand, flag.ContinueOnError)
+		queryCfg query.Config
+		rulesCfg rules.Config
+	)
+	query.ParseFlags(fs, &queryCfg)
+	rules.ParseFlags(fs, &rulesCfg)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage: promscale %s %s [flags] <test-file>...\n", RulesCommand, RulesTestCommand)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := query.Validate(&queryCfg); err != nil {
+		fmt.Fprintln(out, "invalid PromQL configuration:", err)
+		return 2
+	}
+	if err := rules.Validate(&rulesCfg); err != nil {
+		fmt.Fprintln(out, "invalid rules configuration:", err)
+		return 2
+	}
+
+	engine, err := query.NewEngine(log.GetLogger(), queryCfg.MaxQueryTimeout, queryCfg.LookBackDelta, queryCfg.SubQueryStepInterval, queryCfg.MaxSamples, queryCfg.EnabledFeatureMap)
+	if err != nil {
+		fmt.Fprintln(out, "creating PromQL engine:", err)
+		return 2
+	}
+	if !rules.UnitTest(out, engine, rulesCfg.StorageClasses, fs.Args()...) {
+		return 1
+	}
+	return 0
+}
+
+// RunRulesCommand runs the rules subcommand of args, the arguments after
+// RulesCommand, and returns the exit code.
+func RunRulesCommand(args []string) int {
+	if len(args) == 0 || args[0] != RulesTestCommand {
+		fmt.Fprintf(os.Stderr, "Usage: promscale %s %s [flags] <test-file>...\n", RulesCommand, RulesTestCommand)
+		return 2
+	}
+	return rulesTest(args[1:], os.Stdout)
+}
@@ -21,10 +21,13 @@ import (
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/oklog/run"
 	"github.com/timescale/promscale/pkg/vacuum"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
 	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	// Lets the gRPC clients compress their requests with gzip.
+	_ "google.golang.org/grpc/encoding/gzip"
 
 	"github.com/google/uuid"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
@@ -33,18 +36,24 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 
 	"github.com/timescale/promscale/pkg/api"
+	"github.com/timescale/promscale/pkg/election"
 	jaegerStore "github.com/timescale/promscale/pkg/jaeger/store"
+	"github.com/timescale/promscale/pkg/labelcompaction"
 	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/maintenance"
 	"github.com/timescale/promscale/pkg/pgclient"
 	"github.com/timescale/promscale/pkg/pgmodel/ingestor/trace"
 	dbMetrics "github.com/timescale/promscale/pkg/pgmodel/metrics/database"
 	"github.com/timescale/promscale/pkg/rules"
+	"github.com/timescale/promscale/pkg/scrape"
+	"github.com/timescale/promscale/pkg/servicegraph"
 	"github.com/timescale/promscale/pkg/telemetry"
 	"github.com/timescale/promscale/pkg/thanos"
 	"github.com/timescale/promscale/pkg/tracer"
 	"github.com/timescale/promscale/pkg/util"
 	tput "github.com/timescale/promscale/pkg/util/throughput"
 	"github.com/timescale/promscale/pkg/version"
+	"github.com/timescale/promscale/pkg/webhook"
 )
 
 var (
@@ -112,6 +121,11 @@ func Run(cfg *Config) error {
 		}(ctx)
 	}
 
+	// Set up the webhooks before creating the client, to send the events of
+	// the migrations it runs.
+	webhook.Init(&cfg.WebhookCfg)
+	defer webhook.Close()
+
 	api.InitMetrics()
 	client, err := CreateClient(prometheus.DefaultRegisterer, cfg)
 	if err != nil {
@@ -129,20 +143,51 @@ func Run(cfg *Config) error {
 		return nil
 	}
 
-	if util.IsTimescaleDBInstalled(client.ReadOnlyConnection()) {
+	elector, err := election.New(cfg.ElectionCfg, cfg.PgmodelCfg.GetConnectionStr())
+	if err != nil {
+		log.Error("msg", "aborting startup due to error", "err", err.Error())
+		return fmt.Errorf("error creating leader election: %w", err)
+	}
+
+	if util.IsTimescaleDBInstalled(client.MetadataConnection()) {
+		if cfg.MaintenanceCfg.Jobs > 0 && !cfg.APICfg.ReadOnly {
+			if err = maintenance.Configure(context.Background(), client.MaintenanceConnection(), cfg.MaintenanceCfg); err != nil {
+				log.Error("msg", "error configuring the maintenance jobs", "err", err.Error())
+				return fmt.Errorf("error configuring the maintenance jobs: %w", err)
+			}
+		}
 		dbMetricsCtx, stopDBMetrics := context.WithCancel(context.Background())
 		defer stopDBMetrics()
-		engine := dbMetrics.NewEngine(dbMetricsCtx, client.ReadOnlyConnection())
+		engine := dbMetrics.NewEngine(dbMetricsCtx, client.MetadataConnection(), cfg.DatabaseMetricsCfg)
 		if err = engine.Run(); err != nil {
 			log.Error("msg", "error running database metrics", "err", err.Error())
 			return fmt.Errorf("error running database metrics: %w", err)
 		}
+		if cfg.WebhookCfg.Enabled() {
+			go webhook.WatchMaintenanceJobs(dbMetricsCtx, client.MetadataConnection(), cfg.WebhookCfg.MaintenanceInterval)
+		}
 	}
 
 	var (
-		group         run.Group
-		rulesReloader func() error
+		group          run.Group
+		rulesReloader  func() error
+		scrapeReloader func() error
+		rulesManager   *rules.Manager
 	)
+	if elector != nil {
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		group.Add(
+			func() error {
+				log.Info("msg", "Starting leader election", "backend", cfg.ElectionCfg.Backend, "identity", cfg.ElectionCfg.Identity)
+				elector.Run(electionCtx)
+				return nil
+			}, func(error) {
+				log.Info("msg", "Stopping leader election")
+				stopElection()
+			},
+		)
+	}
+
 	if !cfg.APICfg.ReadOnly {
 		rulesCtx, stopRuler := context.WithCancel(context.Background())
 		defer stopRuler()
@@ -151,8 +196,10 @@ func Run(cfg *Config) error {
 			log.Error("msg", "error creating rules manager", "err", err.Error())
 			return fmt.Errorf("error creating rules manager: %w", err)
 		}
+		manager.WithLeaderElection(elector.IsLeader)
 		cfg.APICfg.Rules = manager
 		rulesReloader = reloadRules
+		rulesManager = manager
 
 		group.Add(
 			func() error {
@@ -170,13 +217,46 @@ func Run(cfg *Config) error {
 		)
 	}
 
-	jaegerStore := jaegerStore.New(client.ReadOnlyConnection(), client.Inserter(), &cfg.TracingCfg)
+	if !cfg.APICfg.ReadOnly && cfg.ScrapeCfg.Enabled() {
+		scrapeCtx, stopScraper := context.WithCancel(context.Background())
+		defer stopScraper()
+		scraper, reloadScrape := scrape.NewManager(scrapeCtx, client.Inserter(), &cfg.ScrapeCfg)
+		scraper.WithLeaderElection(elector.IsLeader)
+		scrapeReloader = reloadScrape
+
+		group.Add(
+			func() error {
+				if err := reloadScrape(); err != nil {
+					return fmt.Errorf("error loading scrape configuration: %w", err)
+				}
+				log.Info("msg", "Started Scrape-Manager", "config-file", cfg.ScrapeCfg.ConfigFile)
+				return scraper.Run()
+			}, func(error) {
+				log.Info("msg", "Stopping Scrape-Manager")
+				stopScraper()
+			},
+		)
+	}
+
+	jaegerStore := jaegerStore.New(client.QueryConnection(), client.Inserter(), &cfg.TracingCfg)
 
 	authWrapper := func(h http.Handler) http.Handler {
 		return cfg.AuthConfig.AuthHandler(h)
 	}
 
-	router, err := api.GenerateRouter(&cfg.APICfg, &cfg.PromQLCfg, client, jaegerStore, authWrapper, rulesReloader)
+	reloader := newConfigReloader(os.Args[1:], cfg, client, rulesReloader, scrapeReloader)
+	cfg.APICfg.ConfigStatus = reloader.status
+	reload := reloader.reload
+
+	if !cfg.VacuumCfg.Disable {
+		cfg.APICfg.Vacuum = vacuum.NewEngine(client.MaintenanceConnection(), cfg.VacuumCfg)
+		cfg.APICfg.Vacuum.WithLeaderElection(elector.IsLeader)
+	}
+
+	drainer := api.NewDrainer(drainRetryAfter)
+	cfg.APICfg.Drainer = drainer
+
+	router, err := api.GenerateRouter(&cfg.APICfg, &cfg.PromQLCfg, client, jaegerStore, authWrapper, reload)
 	if err != nil {
 		log.Error("msg", "aborting startup due to error", "err", fmt.Sprintf("generate router: %s", err.Error()))
 		return fmt.Errorf("generate router: %w", err)
@@ -190,16 +270,17 @@ func Run(cfg *Config) error {
 		defer telemetryEngine.Stop()
 	}
 
+	tlsCfg, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Error("msg", "Setting up TLS credentials failed", "err", err)
+		return err
+	}
+
 	if len(cfg.ThanosStoreAPIListenAddr) > 0 {
-		srv := thanos.NewStorage(client.Queryable())
+		srv := thanos.NewStorage(client.Queryable(), client.ReadOnlyConnection(), cfg.ThanosCfg)
 		options := make([]grpc.ServerOption, 0)
-		if cfg.TLSCertFile != "" {
-			creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
-			if err != nil {
-				log.Error("msg", "Setting up TLS credentials for Thanos StoreAPI failed", "err", err)
-				return err
-			}
-			options = append(options, grpc.Creds(creds))
+		if tlsCfg != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(tlsCfg)))
 		}
 		grpcServer := grpc.NewServer(options...)
 		storepb.RegisterStoreServer(grpcServer, srv)
@@ -220,20 +301,27 @@ func Run(cfg *Config) error {
 		)
 	}
 
+	unaryInterceptors := []grpc.UnaryServerInterceptor{loggingUnaryInterceptor, grpc_prometheus.UnaryServerInterceptor}
+	if cfg.AuthConfig.OIDCEnabled() {
+		// The OTLP receivers are authenticated like the web endpoints. The
+		// write service authenticates its calls itself.
+		unaryInterceptors = append(unaryInterceptors, api.AuthUnaryInterceptor(cfg.AuthConfig.Authorize, api.OTLPTraceService, api.OTLPLogsService))
+	}
+	// The writes are rejected once the connector starts draining.
+	unaryInterceptors = append(unaryInterceptors, api.DrainUnaryInterceptor(drainer, api.OTLPTraceService, api.OTLPLogsService, api.WriteService))
 	options := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(loggingUnaryInterceptor, grpc_prometheus.UnaryServerInterceptor),
-		grpc.ChainStreamInterceptor(loggingStreamInterceptor, grpc_prometheus.StreamServerInterceptor),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, grpc_prometheus.StreamServerInterceptor, api.DrainStreamInterceptor(drainer, api.WriteService)),
 	}
-	if cfg.TLSCertFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
-		if err != nil {
-			log.Error("msg", "Setting up TLS credentials for OpenTelemetry GRPC server failed", "err", err)
-			return err
-		}
-		options = append(options, grpc.Creds(creds))
+	if tlsCfg != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsCfg)))
 	}
 	grpcServer := grpc.NewServer(options...)
-	ptraceotlp.RegisterServer(grpcServer, api.NewTraceServer(client))
+	ptraceotlp.RegisterServer(grpcServer, api.NewTraceServer(client, cfg.APICfg.SpanLimiter))
+	if !cfg.APICfg.ReadOnly {
+		plogotlp.RegisterServer(grpcServer, api.NewLogsServer(client))
+		api.RegisterWriteServer(grpcServer, api.NewWriteServer(&cfg.APICfg, client, cfg.AuthConfig.Authorize))
+	}
 
 	queryPlugin := shared.StorageGRPCPlugin{
 		Impl: jaegerStore,
@@ -258,19 +346,18 @@ func Run(cfg *Config) error {
 		func() error {
 			listener, err := net.Listen("tcp", cfg.TracingGRPCListenAddr)
 			if err != nil {
-				log.Error("msg", "Failed creating server listener for Jaeger and OTEL traces", "err", err)
+				log.Error("msg", "Failed creating server listener for Jaeger and OTEL traces and metric writes", "err", err)
 				return err
 			}
-			log.Info("msg", "Started GRPC server for Jaeger and OTEL traces", "listening-port", cfg.TracingGRPCListenAddr)
+			log.Info("msg", "Started GRPC server for Jaeger and OTEL traces and metric writes", "listening-port", cfg.TracingGRPCListenAddr)
 			return grpcServer.Serve(listener)
 		}, func(error) {
-			log.Info("msg", "Stopping GRPC server for Jaeger and OTEL traces")
+			log.Info("msg", "Stopping GRPC server for Jaeger and OTEL traces and metric writes")
 			grpcServer.Stop()
 		},
 	)
 
-	if !cfg.VacuumCfg.Disable {
-		ve := vacuum.NewEngine(client.MaintenanceConnection(), cfg.VacuumCfg.RunFrequency, cfg.VacuumCfg.Parallelism)
+	if ve := cfg.APICfg.Vacuum; ve != nil {
 		group.Add(
 			func() error {
 				log.Info("msg", "Starting vacuum engine")
@@ -283,6 +370,100 @@ func Run(cfg *Config) error {
 		)
 	}
 
+	if cfg.APICfg.IntegrityVerifier != nil {
+		verifierCtx, stopVerifier := context.WithCancel(context.Background())
+		group.Add(
+			func() error {
+				log.Info("msg", "Starting integrity verifier")
+				cfg.APICfg.IntegrityVerifier.Run(verifierCtx, client.MaintenanceConnection())
+				return nil
+			}, func(error) {
+				log.Info("msg", "Stopping integrity verifier")
+				stopVerifier()
+			},
+		)
+	}
+
+	if cfg.APICfg.QueryLog != nil {
+		defer cfg.APICfg.QueryLog.Close()
+		if cfg.QueryLogCfg.Database {
+			queryLogCtx, stopQueryLog := context.WithCancel(context.Background())
+			group.Add(
+				func() error {
+					log.Info("msg", "Starting query log writer")
+					cfg.APICfg.QueryLog.Run(queryLogCtx, client.ReadOnlyConnection())
+					return nil
+				}, func(error) {
+					log.Info("msg", "Stopping query log writer")
+					stopQueryLog()
+				},
+			)
+		}
+	}
+
+	if cfg.APICfg.AuditLog != nil {
+		defer cfg.APICfg.AuditLog.Close()
+		if cfg.AuditLogCfg.Database {
+			auditLogCtx, stopAuditLog := context.WithCancel(context.Background())
+			group.Add(
+				func() error {
+					log.Info("msg", "Starting audit log writer")
+					cfg.APICfg.AuditLog.Run(auditLogCtx, client.ReadOnlyConnection())
+					return nil
+				}, func(error) {
+					log.Info("msg", "Stopping audit log writer")
+					stopAuditLog()
+				},
+			)
+		}
+	}
+
+	if cfg.IndexAdvisorCfg.Enabled && cfg.IndexAdvisorCfg.AutoCreate && !cfg.APICfg.ReadOnly {
+		advisorCtx, stopAdvisor := context.WithCancel(context.Background())
+		group.Add(
+			func() error {
+				log.Info("msg", "Starting index advisor")
+				cfg.APICfg.IndexAdvisor.Run(advisorCtx, client.MaintenanceConnection())
+				return nil
+			}, func(error) {
+				log.Info("msg", "Stopping index advisor")
+				stopAdvisor()
+			},
+		)
+	}
+
+	if cfg.LabelCompactionCfg.Enabled && !cfg.APICfg.ReadOnly {
+		compactor := labelcompaction.NewCompactor(cfg.LabelCompactionCfg)
+		compactor.WithLeaderElection(elector.IsLeader)
+		compactorCtx, stopCompactor := context.WithCancel(context.Background())
+		group.Add(
+			func() error {
+				log.Info("msg", "Starting label compaction")
+				compactor.Run(compactorCtx, client.MaintenanceConnection())
+				return nil
+			}, func(error) {
+				log.Info("msg", "Stopping label compaction")
+				stopCompactor()
+			},
+		)
+	}
+
+	if cfg.ServiceGraphCfg.Enabled && !cfg.APICfg.ReadOnly {
+		aggregator := servicegraph.NewAggregator(cfg.ServiceGraphCfg, cfg.TracingCfg.MaxTraceDuration)
+		aggregator.WithLeaderElection(elector.IsLeader)
+		aggregatorCtx, stopAggregator := context.WithCancel(context.Background())
+		group.Add(
+			func() error {
+				log.Info("msg", "Starting service graph aggregation")
+				aggregator.Run(aggregatorCtx, client.MaintenanceConnection())
+				return nil
+			}, func(error) {
+				log.Info("msg", "Stopping service graph aggregation")
+				stopAggregator()
+			},
+		)
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/", router)
 
@@ -290,13 +471,15 @@ func Run(cfg *Config) error {
 		Addr:              cfg.ListenAddr,
 		Handler:           mux,
 		ReadHeaderTimeout: time.Second * 30, // To mitigate Slowloris DDoS attack. Value is arbitrary picked
+		TLSConfig:         tlsCfg,
 	}
 	group.Add(
 		func() error {
 			var err error
 			log.Info("msg", "Started Prometheus remote-storage HTTP server", "listening-port", cfg.ListenAddr)
-			if cfg.TLSCertFile != "" {
-				err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			if tlsCfg != nil {
+				// The certificate is loaded in the TLS configuration.
+				err = server.ListenAndServeTLS("", "")
 			} else {
 				err = server.ListenAndServe()
 			}
@@ -312,11 +495,20 @@ func Run(cfg *Config) error {
 
 	// Listen to OS interrupt signals.
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGHUP)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
 	group.Add(
 		func() error {
 			for {
-				sig, open := <-c
+				var (
+					sig  os.Signal
+					open bool
+				)
+				select {
+				case sig, open = <-c:
+				case <-drainer.Started():
+					drain(cfg.DrainTimeout, drainer, rulesManager)
+					return nil
+				}
 				if !open {
 					// Channel closed from error function. Let's shutdown.
 					return nil
@@ -324,12 +516,15 @@ func Run(cfg *Config) error {
 				switch sig {
 				case syscall.SIGINT:
 					return nil
+				case syscall.SIGTERM:
+					log.Info("msg", "Received SIGTERM, draining")
+					drainer.Start()
 				case syscall.SIGHUP:
-					if err := rulesReloader(); err != nil {
-						log.Error("msg", "error reloading rules", "err", err.Error())
+					if err := reload(); err != nil {
+						log.Error("msg", "error reloading configuration", "err", err.Error())
 						continue
 					}
-					log.Debug("msg", "success reloading rules")
+					log.Debug("msg", "success reloading configuration")
 				}
 			}
 		}, func(err error) {
@@ -0,0 +1,50 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package runner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+const (
+	tlsClientAuthRequire       = "require"
+	tlsClientAuthVerifyIfGiven = "verify-if-given"
+)
+
+// serverTLSConfig returns the TLS configuration of the servers, nil if TLS
+// is disabled. With a client CA file, the client certificates are verified
+// with its CA certificates.
+func serverTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSClientCAFile == "" {
+		return tlsCfg, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS client CA file: %w", err)
+	}
+	tlsCfg.ClientCAs = x509.NewCertPool()
+	if !tlsCfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in TLS client CA file %s", cfg.TLSClientCAFile)
+	}
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if cfg.TLSClientAuth == tlsClientAuthVerifyIfGiven {
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
@@ -0,0 +1,89 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package scrape
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/metrics"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+var samplesIngested = metrics.IngestorItems.With(map[string]string{"type": "metric", "kind": "sample", "subsystem": "scrape"})
+
+// appendable ingests the samples of every scrape as a write request, so that
+// they go through the relabeling, external labels and limits of the remote
+// write samples.
+type appendable struct {
+	inserter ingestor.DBInserter
+	// isLeader is nil if there is no leader election.
+	isLeader func() bool
+}
+
+func (a *appendable) leads() bool {
+	return a.isLeader == nil || a.isLeader()
+}
+
+// Appender returns the appender of a single scrape.
+func (a *appendable) Appender(ctx context.Context) storage.Appender {
+	return &appender{ctx: ctx, appendable: a}
+}
+
+type appender struct {
+	ctx        context.Context
+	appendable *appendable
+	timeseries []prompb.TimeSeries
+}
+
+func (app *appender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	app.timeseries = append(app.timeseries, prompb.TimeSeries{
+		Labels:  util.LabelToPrompbLabels(l),
+		Samples: []prompb.Sample{{Timestamp: t, Value: v}},
+	})
+	return 0, nil
+}
+
+func (app *appender) AppendExemplar(_ storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	app.timeseries = append(app.timeseries, prompb.TimeSeries{
+		Labels: util.LabelToPrompbLabels(l),
+		Exemplars: []prompb.Exemplar{{
+			Labels:    util.LabelToPrompbLabels(e.Labels),
+			Value:     e.Value,
+			Timestamp: e.Ts,
+		}},
+	})
+	return 0, nil
+}
+
+// Commit ingests the scraped samples. They are dropped while this instance
+// is not the leader, so that a single one of several Promscale instances with
+// the same scrape configuration writes them.
+func (app *appender) Commit() error {
+	timeseries := app.timeseries
+	app.timeseries = nil
+	if len(timeseries) == 0 || !app.appendable.leads() {
+		return nil
+	}
+	req := ingestor.NewWriteRequest()
+	req.Timeseries = append(req.Timeseries, timeseries...)
+	numSamples, _, err := app.appendable.inserter.IngestMetrics(app.ctx, req)
+	if err != nil {
+		return fmt.Errorf("scrape: error ingesting samples: %w", err)
+	}
+	samplesIngested.Add(float64(numSamples))
+	return nil
+}
+
+func (app *appender) Rollback() error {
+	app.timeseries = nil
+	return nil
+}
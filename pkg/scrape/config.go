@@ -0,0 +1,51 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package scrape
+
+import (
+	"flag"
+	"fmt"
+
+	prometheus_config "github.com/prometheus/prometheus/config"
+
+	"github.com/timescale/promscale/pkg/log"
+)
+
+// Config holds the flags of the scrape manager.
+type Config struct {
+	ConfigFile string
+	// PrometheusConfig is loaded from ConfigFile by Validate, nil if
+	// scraping is disabled.
+	PrometheusConfig *prometheus_config.Config
+}
+
+// Enabled returns whether Promscale scrapes targets itself.
+func (cfg *Config) Enabled() bool {
+	return cfg.ConfigFile != ""
+}
+
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.ConfigFile, "metrics.scrape.config-file", "", "Path to a configuration file in Prometheus format, whose `scrape_configs` are scraped by Promscale directly into the database "+
+		"with the `global` scrape settings. Static, file and HTTP service discovery are supported. If empty, Promscale does not scrape any target.")
+	return cfg
+}
+
+func Validate(cfg *Config) error {
+	cfg.PrometheusConfig = nil
+	if !cfg.Enabled() {
+		return nil
+	}
+	promCfg, err := prometheus_config.LoadFile(cfg.ConfigFile, false, false, log.GetLogger())
+	if err != nil {
+		return fmt.Errorf("error loading scrape configuration file: %w", err)
+	}
+	// The rules and alerting would be silently ignored, they belong to
+	// metrics.rules.config-file.
+	if len(promCfg.RuleFiles) > 0 || len(promCfg.AlertingConfig.AlertmanagerConfigs) > 0 {
+		return fmt.Errorf("scrape configuration file %s must not contain rule_files or alerting, use -metrics.rules.config-file instead", cfg.ConfigFile)
+	}
+	cfg.PrometheusConfig = promCfg
+	return nil
+}
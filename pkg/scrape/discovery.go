@@ -0,0 +1,12 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package scrape
+
+// The service discovery mechanisms of the scrape configurations, static
+// configurations are always supported.
+import (
+	_ "github.com/prometheus/prometheus/discovery/file" // register the file service discovery
+	_ "github.com/prometheus/prometheus/discovery/http" // register the HTTP service discovery
+)
@@ -0,0 +1,14 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+//go:build kubernetes
+// +build kubernetes
+
+package scrape
+
+// The Kubernetes service discovery pulls the Kubernetes client, it is only
+// built with the kubernetes build tag.
+import (
+	_ "github.com/prometheus/prometheus/discovery/kubernetes" // register the Kubernetes service discovery
+)
@@ -0,0 +1,117 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package scrape scrapes the targets of a Prometheus scrape configuration
+// directly into the database, so that small deployments do not need a
+// Prometheus server or agent to remote write to Promscale. It runs the
+// Prometheus scrape and discovery managers, the scraped samples are ingested
+// like remote write requests.
+package scrape
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	prometheus_config "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	prom_scrape "github.com/prometheus/prometheus/scrape"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/ingestor"
+)
+
+type Manager struct {
+	ctx              context.Context
+	scrapeManager    *prom_scrape.Manager
+	discoveryManager *discovery.Manager
+	appendable       *appendable
+}
+
+// NewManager returns the scrape manager ingesting into inserter, and the
+// function applying the scrape configuration file of cfg, which must be
+// called before Run.
+func NewManager(ctx context.Context, inserter ingestor.DBInserter, cfg *Config) (*Manager, func() error) {
+	return newManager(ctx, inserter, &prom_scrape.Options{}, cfg)
+}
+
+func newManager(ctx context.Context, inserter ingestor.DBInserter, opts *prom_scrape.Options, cfg *Config) (*Manager, func() error) {
+	a := &appendable{inserter: inserter}
+	manager := &Manager{
+		ctx:              ctx,
+		scrapeManager:    prom_scrape.NewManager(opts, log.GetLogger(), a),
+		discoveryManager: discovery.NewManager(ctx, log.GetLogger(), discovery.Name("scrape")),
+		appendable:       a,
+	}
+	return manager, manager.getReloader(cfg)
+}
+
+// WithLeaderElection ingests the scraped samples only while isLeader returns
+// true. The targets are scraped by every instance, so that the leadership can
+// change between two scrapes. It must be called before Run.
+func (m *Manager) WithLeaderElection(isLeader func() bool) {
+	m.appendable.isLeader = isLeader
+}
+
+func (m *Manager) getReloader(cfg *Config) func() error {
+	return func() error {
+		// This refreshes cfg.PrometheusConfig from cfg.ConfigFile.
+		if err := Validate(cfg); err != nil {
+			return fmt.Errorf("error validating scrape config: %w", err)
+		}
+		promCfg := cfg.PrometheusConfig
+		if promCfg == nil {
+			// Scraping was disabled, all the targets are dropped.
+			defaultCfg := prometheus_config.DefaultConfig
+			promCfg = &defaultCfg
+		}
+		return m.ApplyConfig(promCfg)
+	}
+}
+
+// ApplyConfig updates the scraped targets and their discovery.
+func (m *Manager) ApplyConfig(cfg *prometheus_config.Config) error {
+	c := make(map[string]discovery.Configs)
+	for _, v := range cfg.ScrapeConfigs {
+		c[v.JobName] = v.ServiceDiscoveryConfigs
+	}
+	if err := m.discoveryManager.ApplyConfig(c); err != nil {
+		return errors.WithMessage(err, "error applying config to discovery manager")
+	}
+	return errors.WithMessage(m.scrapeManager.ApplyConfig(cfg), "error applying config to scrape manager")
+}
+
+// TargetsActive returns the targets currently scraped, by job.
+func (m *Manager) TargetsActive() map[string][]*prom_scrape.Target {
+	return m.scrapeManager.TargetsActive()
+}
+
+// Run runs the managers and blocks on either a graceful exit or on error.
+func (m *Manager) Run() error {
+	var g run.Group
+
+	g.Add(func() error {
+		log.Debug("msg", "Starting scrape discovery manager...")
+		return errors.WithMessage(m.discoveryManager.Run(), "error running discovery manager")
+	}, func(err error) {
+		log.Debug("msg", "Stopping scrape discovery manager")
+	})
+
+	g.Add(func() error {
+		log.Debug("msg", "Starting scrape manager...")
+		return errors.WithMessage(m.scrapeManager.Run(m.discoveryManager.SyncCh()), "error running scrape manager")
+	}, func(error) {
+		log.Debug("msg", "Stopping scrape manager")
+		m.scrapeManager.Stop()
+	})
+
+	g.Add(func() error {
+		// This stops all actors in the group on context done.
+		<-m.ctx.Done()
+		return nil
+	}, func(err error) {})
+
+	return errors.WithMessage(g.Run(), "error running the scrape manager groups")
+}
@@ -0,0 +1,176 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	prom_scrape "github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+type mockInserter struct {
+	mu         sync.Mutex
+	timeseries []prompb.TimeSeries
+	err        error
+}
+
+func (m *mockInserter) IngestMetrics(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, 0, m.err
+	}
+	m.timeseries = append(m.timeseries, r.Timeseries...)
+	return uint64(len(r.Timeseries)), 0, nil
+}
+
+func (m *mockInserter) IngestTraces(context.Context, ptrace.Traces) error {
+	return nil
+}
+
+func (m *mockInserter) Close() {}
+
+func (m *mockInserter) metricNames() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make(map[string]string)
+	for _, ts := range m.timeseries {
+		var name, job string
+		for _, l := range ts.Labels {
+			switch l.Name {
+			case labels.MetricName:
+				name = l.Value
+			case "job":
+				job = l.Value
+			}
+		}
+		names[name] = job
+	}
+	return names
+}
+
+func TestValidate(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, Validate(cfg))
+	require.False(t, cfg.Enabled())
+	require.Nil(t, cfg.PrometheusConfig)
+
+	cfg.ConfigFile = "testdata/scrape.yml"
+	require.NoError(t, Validate(cfg))
+	require.True(t, cfg.Enabled())
+	require.Len(t, cfg.PrometheusConfig.ScrapeConfigs, 2)
+	require.Equal(t, "node", cfg.PrometheusConfig.ScrapeConfigs[0].JobName)
+	require.Equal(t, model.Duration(15*time.Second), cfg.PrometheusConfig.ScrapeConfigs[0].ScrapeInterval)
+
+	// Rule files belong to the rules configuration.
+	cfg.ConfigFile = "testdata/rules.yml"
+	require.Error(t, Validate(cfg))
+	cfg.ConfigFile = "testdata/missing.yml"
+	require.Error(t, Validate(cfg))
+}
+
+func TestAppender(t *testing.T) {
+	inserter := &mockInserter{}
+	leader := true
+	a := &appendable{inserter: inserter, isLeader: func() bool { return leader }}
+	l := labels.FromStrings(labels.MetricName, "up", "job", "node")
+
+	app := a.Appender(context.Background())
+	_, err := app.Append(0, l, 1000, 1)
+	require.NoError(t, err)
+	_, err = app.AppendExemplar(0, l, exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: 1000, HasTs: true})
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.Equal(t, []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+		},
+		{
+			Labels:    []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000}},
+		},
+	}, inserter.timeseries)
+
+	// The rolled back samples are not ingested.
+	inserter.timeseries = nil
+	app = a.Appender(context.Background())
+	_, err = app.Append(0, l, 2000, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Rollback())
+	require.NoError(t, app.Commit())
+	require.Empty(t, inserter.timeseries)
+
+	// The samples are dropped while this instance is not the leader.
+	leader = false
+	app = a.Appender(context.Background())
+	_, err = app.Append(0, l, 3000, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.Empty(t, inserter.timeseries)
+
+	leader = true
+	inserter.err = fmt.Errorf("some error")
+	app = a.Appender(context.Background())
+	_, err = app.Append(0, l, 4000, 1)
+	require.NoError(t, err)
+	require.Error(t, app.Commit())
+}
+
+func TestScrape(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# TYPE test_metric gauge\ntest_metric{label=\"value\"} 42\n")
+	}))
+	defer target.Close()
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+
+	configFile := filepath.Join(t.TempDir(), "scrape.yml")
+	require.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(`
+global:
+  scrape_interval: 100ms
+  scrape_timeout: 100ms
+scrape_configs:
+  - job_name: test
+    static_configs:
+      - targets: ["%s"]
+`, targetURL.Host)), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inserter := &mockInserter{}
+	manager, reload := newManager(ctx, inserter, &prom_scrape.Options{DiscoveryReloadInterval: model.Duration(10 * time.Millisecond)}, &Config{ConfigFile: configFile})
+	require.NoError(t, reload())
+
+	done := make(chan error)
+	go func() { done <- manager.Run() }()
+	require.Eventually(t, func() bool {
+		names := inserter.metricNames()
+		return names["test_metric"] == "test" && names["up"] == "test"
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Len(t, manager.TargetsActive()["test"], 1)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scrape manager did not stop")
+	}
+}
@@ -0,0 +1,221 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package servicegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// maxBucketsPerRun is the number of minutes aggregated by a run at most, so
+// that the aggregator catches up gradually after a pause.
+const maxBucketsPerRun = 60
+
+const (
+	lockSQL              = "SELECT pg_try_advisory_xact_lock(hashtext('_ps_trace.service_dependency'))"
+	progressForUpdateSQL = progressSQL + " FOR UPDATE"
+	insertProgressSQL    = "INSERT INTO _ps_trace.service_dependency_progress(computed_from, computed_until) VALUES ($1, $1)"
+	insertDependencySQL  = `INSERT INTO _ps_trace.service_dependency(bucket, parent_service, child_service, call_count, error_count, duration_counts)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (bucket, parent_service, child_service) DO UPDATE SET
+	call_count = excluded.call_count, error_count = excluded.error_count, duration_counts = excluded.duration_counts`
+	// The aggregated minutes are kept as long as the spans.
+	updateProgressSQL = `WITH cutoff AS (
+	SELECT date_trunc('minute', now() - ps_trace.get_trace_retention_period()) AS t
+), expired AS (
+	DELETE FROM _ps_trace.service_dependency d USING cutoff WHERE d.bucket < cutoff.t
+)
+UPDATE _ps_trace.service_dependency_progress p
+SET computed_from = greatest(p.computed_from, cutoff.t), computed_until = $1
+FROM cutoff`
+)
+
+var computedUntil = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: util.PromNamespace,
+		Subsystem: "service_graph",
+		Name:      "computed_until_timestamp_seconds",
+		Help:      "Unix timestamp up to which the calls between services were aggregated.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(computedUntil)
+}
+
+// Aggregator periodically stores the calls between services of the minutes
+// of spans that ended the delay ago. A nil Aggregator is disabled.
+type Aggregator struct {
+	cfg              Config
+	maxTraceDuration time.Duration
+	// isLeader returns whether this instance runs the aggregation.
+	isLeader func() bool
+}
+
+// NewAggregator returns an Aggregator looking for the parent spans up to
+// maxTraceDuration before their child spans, or nil if it is disabled.
+func NewAggregator(cfg Config, maxTraceDuration time.Duration) *Aggregator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Aggregator{cfg: cfg, maxTraceDuration: maxTraceDuration}
+}
+
+// WithLeaderElection restricts the aggregation to the instance for which
+// isLeader returns true. It must be called before Run.
+func (a *Aggregator) WithLeaderElection(isLeader func() bool) {
+	if a != nil {
+		a.isLeader = isLeader
+	}
+}
+
+// Run aggregates the new minutes of spans every minute until ctx is done.
+func (a *Aggregator) Run(ctx context.Context, conn pgxconn.PgxConn) {
+	if a == nil {
+		return
+	}
+	ticker := time.NewTicker(BucketWidth)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if a.isLeader != nil && !a.isLeader() {
+				continue
+			}
+			if err := a.Aggregate(ctx, conn, time.Now()); err != nil {
+				log.Error("msg", "service graph aggregation failed", "err", err)
+			}
+		}
+	}
+}
+
+// Aggregate stores the calls of the minutes not aggregated yet that ended
+// the delay before now, and deletes the minutes older than the retention
+// period of the traces. The first run starts aggregating from now on. Only
+// one connector aggregates at a time.
+func (a *Aggregator) Aggregate(ctx context.Context, conn pgxconn.PgxConn, now time.Time) error {
+	tx, err := conn.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("starting service graph transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, lockSQL).Scan(&locked); err != nil {
+		return fmt.Errorf("locking service graph: %w", err)
+	}
+	if !locked {
+		return nil
+	}
+
+	target := now.Add(-a.cfg.Delay).Truncate(BucketWidth)
+	var from, until time.Time
+	err = tx.QueryRow(ctx, progressForUpdateSQL).Scan(&from, &until)
+	if errors.Is(err, pgx.ErrNoRows) {
+		until = target
+		_, err = tx.Exec(ctx, insertProgressSQL, until)
+	}
+	if err != nil {
+		return fmt.Errorf("reading service dependency progress: %w", err)
+	}
+
+	end := nextEnd(until, target)
+	if until.Before(end) {
+		if err := a.store(ctx, tx, until, end); err != nil {
+			return err
+		}
+		until = end
+	}
+	if _, err := tx.Exec(ctx, updateProgressSQL, until); err != nil {
+		return fmt.Errorf("updating service dependency progress: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing service dependencies: %w", err)
+	}
+	computedUntil.Set(float64(until.Unix()))
+	return nil
+}
+
+// nextEnd returns the end of the minutes aggregated by a run that starts at
+// until, at most maxBucketsPerRun minutes before target.
+func nextEnd(until, target time.Time) time.Time {
+	if limit := until.Add(maxBucketsPerRun * BucketWidth); limit.Before(target) {
+		return limit
+	}
+	return target
+}
+
+// store aggregates the calls of the spans starting within [start, end) by
+// minute and stores them.
+func (a *Aggregator) store(ctx context.Context, tx pgx.Tx, start, end time.Time) error {
+	// Always prefer a merge join, like ps_trace.operation_calls, since this
+	// is a rollup over a lot of spans.
+	if _, err := tx.Exec(ctx, "SET LOCAL enable_nestloop = off"); err != nil {
+		return err
+	}
+	rows, err := tx.Query(ctx, bucketCallsSQL, start, end, start.Add(-a.maxTraceDuration), DurationBounds)
+	if err != nil {
+		return fmt.Errorf("aggregating service dependencies: %w", err)
+	}
+	type key struct {
+		bucket time.Time
+		edgeKey
+	}
+	var (
+		edges          = make(map[key]*Edge)
+		k              key
+		durationBucket int32
+		calls, errs    int64
+	)
+	for rows.Next() {
+		if err := rows.Scan(&k.bucket, &k.parent, &k.child, &durationBucket, &calls, &errs); err != nil {
+			rows.Close()
+			return fmt.Errorf("aggregating service dependencies: %w", err)
+		}
+		e, ok := edges[k]
+		if !ok {
+			e = newEdge(k.parent, k.child)
+			edges[k] = e
+		}
+		e.add(int(durationBucket), uint64(calls), uint64(errs))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("aggregating service dependencies: %w", err)
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for k, e := range edges {
+		counts := make([]int64, len(e.DurationCounts))
+		for i, c := range e.DurationCounts {
+			counts[i] = int64(c)
+		}
+		batch.Queue(insertDependencySQL, k.bucket, e.Parent, e.Child, int64(e.CallCount), int64(e.ErrorCount), counts)
+	}
+	results := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			_ = results.Close()
+			return fmt.Errorf("storing service dependencies: %w", err)
+		}
+	}
+	return results.Close()
+}
@@ -0,0 +1,39 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package servicegraph
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const defaultDelay = 2 * time.Minute
+
+// Config holds the service graph flags.
+type Config struct {
+	Enabled bool
+	Delay   time.Duration
+}
+
+// ParseFlags registers the service graph flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "tracing.service-graph.enabled", false, "Aggregate the calls between services from the stored spans every minute, "+
+		"so that the Jaeger dependencies and the service graph API read them instead of joining all the spans of their time range.")
+	fs.DurationVar(&cfg.Delay, "tracing.service-graph.delay", defaultDelay, "How long after its end a minute of spans is aggregated, "+
+		"so that the spans written late are counted.")
+	return cfg
+}
+
+// Validate checks the service graph flags.
+func Validate(cfg *Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Delay < 0 {
+		return fmt.Errorf("tracing.service-graph.delay must not be negative: %s", cfg.Delay)
+	}
+	return nil
+}
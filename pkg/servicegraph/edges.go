@@ -0,0 +1,236 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package servicegraph computes the calls between services from the stored
+// spans: a call is a span whose parent span belongs to another service. The
+// aggregator incrementally stores the calls of every minute, with their error
+// counts and a histogram of their durations, in _ps_trace.service_dependency,
+// and the readers combine the stored minutes with the calls of the spans not
+// aggregated yet.
+package servicegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// BucketWidth is the width of the time buckets the calls are aggregated in.
+const BucketWidth = time.Minute
+
+// DurationBounds are the upper bounds of the duration buckets of the calls,
+// in milliseconds. The aggregated durations are counted with these bounds,
+// changing them requires deleting the aggregated calls.
+var DurationBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+
+// The calls are the child spans of another service. The bucket is the first
+// column so that the aggregator stores the calls by minute. The duration
+// bucket is the index in the durations counts: width_bucket returns 0 below
+// the first bound and the number of bounds from the last one.
+const callsSQLFormat = `
+SELECT
+	%s,
+	parent_svc.value #>> '{}',
+	child_svc.value #>> '{}',
+	width_bucket(child.duration_ms, $4::float8[]),
+	count(*),
+	count(*) FILTER (WHERE child.status_code = 'STATUS_CODE_ERROR')
+FROM _ps_trace.span child
+INNER JOIN _ps_trace.span parent ON (parent.span_id = child.parent_span_id AND parent.trace_id = child.trace_id)
+INNER JOIN _ps_trace.operation child_op ON (child_op.id = child.operation_id)
+INNER JOIN _ps_trace.operation parent_op ON (parent_op.id = parent.operation_id)
+INNER JOIN _ps_trace.tag child_svc ON (child_svc.id = child_op.service_name_id AND child_svc.key = 'service.name' AND child_svc.key_id = 1)
+INNER JOIN _ps_trace.tag parent_svc ON (parent_svc.id = parent_op.service_name_id AND parent_svc.key = 'service.name' AND parent_svc.key_id = 1)
+WHERE child.start_time >= $1 AND child.start_time < $2
+AND parent.start_time >= $3 AND parent.start_time < $2
+AND parent_op.service_name_id != child_op.service_name_id
+GROUP BY 1, 2, 3, 4`
+
+var (
+	// bucketCallsSQL returns the calls by minute, for the aggregator.
+	bucketCallsSQL = fmt.Sprintf(callsSQLFormat, "date_trunc('minute', child.start_time)")
+	// liveCallsSQL returns the calls of the whole time range.
+	liveCallsSQL = fmt.Sprintf(callsSQLFormat, "$1::timestamptz")
+)
+
+const (
+	progressSQL = "SELECT computed_from, computed_until FROM _ps_trace.service_dependency_progress"
+	// The error count of each stored row is returned once, with its first
+	// duration bucket, so that the stored rows have the shape of the live
+	// calls.
+	storedCallsSQL = `
+SELECT
+	d.parent_service,
+	d.child_service,
+	u.i - 1,
+	sum(u.cnt)::bigint,
+	sum(CASE WHEN u.i = 1 THEN d.error_count ELSE 0 END)::bigint
+FROM _ps_trace.service_dependency d, unnest(d.duration_counts) WITH ORDINALITY AS u(cnt, i)
+WHERE d.bucket >= $1 AND d.bucket < $2
+GROUP BY 1, 2, 3`
+)
+
+// Edge is the calls from a parent service to a child service.
+type Edge struct {
+	Parent     string
+	Child      string
+	CallCount  uint64
+	ErrorCount uint64
+	// DurationCounts are the number of calls by duration bucket: the calls
+	// shorter than DurationBounds[i] and not shorter than the previous bound
+	// are counted in DurationCounts[i], the calls not shorter than the last
+	// bound in the last count.
+	DurationCounts []uint64
+}
+
+func newEdge(parent, child string) *Edge {
+	return &Edge{Parent: parent, Child: child, DurationCounts: make([]uint64, len(DurationBounds)+1)}
+}
+
+// add counts calls of the duration bucket.
+func (e *Edge) add(durationBucket int, calls, errs uint64) {
+	e.CallCount += calls
+	e.ErrorCount += errs
+	if durationBucket >= 0 && durationBucket < len(e.DurationCounts) {
+		e.DurationCounts[durationBucket] += calls
+	}
+}
+
+// Quantile estimates the q-quantile of the durations of the calls in
+// milliseconds, interpolating linearly within the duration bucket like
+// histogram_quantile. The quantiles in the last bucket are its lower bound.
+func (e *Edge) Quantile(q float64) float64 {
+	if e.CallCount == 0 {
+		return 0
+	}
+	rank := q * float64(e.CallCount)
+	var seen float64
+	for i, cnt := range e.DurationCounts {
+		if cnt == 0 || seen+float64(cnt) < rank {
+			seen += float64(cnt)
+			continue
+		}
+		if i == len(DurationBounds) {
+			return DurationBounds[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = DurationBounds[i-1]
+		}
+		return lower + (DurationBounds[i]-lower)*(rank-seen)/float64(cnt)
+	}
+	return DurationBounds[len(DurationBounds)-1]
+}
+
+type edgeKey struct {
+	parent, child string
+}
+
+// Edges returns the calls between services of the spans starting within
+// [start, end), sorted by parent and child service. The minutes aggregated
+// by the aggregator are read from the service dependency table, the calls
+// of the other spans are computed from the spans. The parent spans are
+// looked for up to maxTraceDuration before their child spans.
+func Edges(ctx context.Context, conn pgxconn.PgxConn, start, end time.Time, maxTraceDuration time.Duration) ([]Edge, error) {
+	var from, until time.Time
+	err := conn.QueryRow(ctx, progressSQL).Scan(&from, &until)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("reading service dependency progress: %w", err)
+	}
+	stored, live := split(start, end, from, until)
+
+	edges := make(map[edgeKey]*Edge)
+	if stored.start.Before(stored.end) {
+		if err := queryCalls(ctx, conn, edges, false, storedCallsSQL, stored.start, stored.end); err != nil {
+			return nil, fmt.Errorf("reading service dependencies: %w", err)
+		}
+	}
+	for _, r := range live {
+		if err := queryCalls(ctx, conn, edges, true, liveCallsSQL, r.start, r.end, r.start.Add(-maxTraceDuration), DurationBounds); err != nil {
+			return nil, fmt.Errorf("computing service dependencies: %w", err)
+		}
+	}
+
+	res := make([]Edge, 0, len(edges))
+	for _, e := range edges {
+		res = append(res, *e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Parent != res[j].Parent {
+			return res[i].Parent < res[j].Parent
+		}
+		return res[i].Child < res[j].Child
+	})
+	return res, nil
+}
+
+// queryCalls adds the calls returned by the query to the edges. The live
+// queries return the time bucket of the calls first.
+func queryCalls(ctx context.Context, conn pgxconn.PgxConn, edges map[edgeKey]*Edge, withBucket bool, sql string, args ...interface{}) error {
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var (
+		bucket         time.Time
+		key            edgeKey
+		durationBucket int32
+		calls, errs    int64
+	)
+	for rows.Next() {
+		dest := []interface{}{&key.parent, &key.child, &durationBucket, &calls, &errs}
+		if withBucket {
+			dest = append([]interface{}{&bucket}, dest...)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		e, ok := edges[key]
+		if !ok {
+			e = newEdge(key.parent, key.child)
+			edges[key] = e
+		}
+		e.add(int(durationBucket), uint64(calls), uint64(errs))
+	}
+	return rows.Err()
+}
+
+type timeRange struct {
+	start, end time.Time
+}
+
+// split splits [start, end) into the whole minutes aggregated within
+// [from, until), read from the service dependency table, and the ranges
+// before and after them, computed from the spans.
+func split(start, end, from, until time.Time) (stored timeRange, live []timeRange) {
+	storedStart := start.Truncate(BucketWidth)
+	if storedStart.Before(start) {
+		storedStart = storedStart.Add(BucketWidth)
+	}
+	if storedStart.Before(from) {
+		storedStart = from
+	}
+	storedEnd := end.Truncate(BucketWidth)
+	if storedEnd.After(until) {
+		storedEnd = until
+	}
+	if !storedStart.Before(storedEnd) {
+		return timeRange{}, []timeRange{{start, end}}
+	}
+	stored = timeRange{storedStart, storedEnd}
+	if start.Before(storedStart) {
+		live = append(live, timeRange{start, storedStart})
+	}
+	if storedEnd.Before(end) {
+		live = append(live, timeRange{storedEnd, end})
+	}
+	return stored, live
+}
@@ -0,0 +1,86 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package servicegraph
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NodeGraph is the service graph in the format of the Grafana node graph
+// panel: the fields of the nodes and edges are named after the fields the
+// panel reads, so that they can be used as data frames as they are.
+type NodeGraph struct {
+	Nodes []Node     `json:"nodes"`
+	Edges []NodeEdge `json:"edges"`
+}
+
+// Node is a service. Its stats are the calls it received.
+type Node struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// MainStat is the number of calls, SecondaryStat the number of failed
+	// calls.
+	MainStat      uint64 `json:"mainstat"`
+	SecondaryStat uint64 `json:"secondarystat"`
+	// The arcs around the node are the shares of successful and failed
+	// calls.
+	ArcSuccess float64 `json:"arc__success"`
+	ArcErrors  float64 `json:"arc__errors"`
+}
+
+// NodeEdge is the calls from a service to another.
+type NodeEdge struct {
+	ID            string  `json:"id"`
+	Source        string  `json:"source"`
+	Target        string  `json:"target"`
+	MainStat      uint64  `json:"mainstat"`
+	SecondaryStat uint64  `json:"secondarystat"`
+	P50           float64 `json:"detail__p50_ms"`
+	P90           float64 `json:"detail__p90_ms"`
+	P99           float64 `json:"detail__p99_ms"`
+}
+
+// NewNodeGraph returns the node graph of the edges, with the services
+// sorted by name.
+func NewNodeGraph(edges []Edge) NodeGraph {
+	received := make(map[string]*Node)
+	node := func(service string) *Node {
+		n, ok := received[service]
+		if !ok {
+			n = &Node{ID: service, Title: service}
+			received[service] = n
+		}
+		return n
+	}
+
+	g := NodeGraph{Nodes: []Node{}, Edges: make([]NodeEdge, 0, len(edges))}
+	for _, e := range edges {
+		node(e.Parent)
+		child := node(e.Child)
+		child.MainStat += e.CallCount
+		child.SecondaryStat += e.ErrorCount
+		g.Edges = append(g.Edges, NodeEdge{
+			ID:            fmt.Sprintf("%s->%s", e.Parent, e.Child),
+			Source:        e.Parent,
+			Target:        e.Child,
+			MainStat:      e.CallCount,
+			SecondaryStat: e.ErrorCount,
+			P50:           e.Quantile(0.5),
+			P90:           e.Quantile(0.9),
+			P99:           e.Quantile(0.99),
+		})
+	}
+	for _, n := range received {
+		n.ArcSuccess = 1
+		if n.MainStat > 0 {
+			n.ArcErrors = float64(n.SecondaryStat) / float64(n.MainStat)
+			n.ArcSuccess = 1 - n.ArcErrors
+		}
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	return g
+}
@@ -0,0 +1,84 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package servicegraph
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(&Config{Delay: -time.Minute}))
+	require.NoError(t, Validate(&Config{Enabled: true, Delay: time.Minute}))
+	require.Error(t, Validate(&Config{Enabled: true, Delay: -time.Minute}))
+	require.Nil(t, NewAggregator(Config{}, time.Hour))
+}
+
+func TestSplit(t *testing.T) {
+	at := func(minutes, seconds int) time.Time {
+		return time.Date(2022, 1, 1, 0, minutes, seconds, 0, time.UTC)
+	}
+
+	// The whole minutes aggregated are read from the table.
+	stored, live := split(at(10, 30), at(20, 30), at(0, 0), at(30, 0))
+	require.Equal(t, timeRange{at(11, 0), at(20, 0)}, stored)
+	require.Equal(t, []timeRange{{at(10, 30), at(11, 0)}, {at(20, 0), at(20, 30)}}, live)
+
+	// The minutes not aggregated yet are computed from the spans.
+	stored, live = split(at(10, 0), at(40, 0), at(15, 0), at(30, 0))
+	require.Equal(t, timeRange{at(15, 0), at(30, 0)}, stored)
+	require.Equal(t, []timeRange{{at(10, 0), at(15, 0)}, {at(30, 0), at(40, 0)}}, live)
+
+	// Nothing aggregated.
+	stored, live = split(at(10, 0), at(10, 30), time.Time{}, time.Time{})
+	require.Equal(t, timeRange{}, stored)
+	require.Equal(t, []timeRange{{at(10, 0), at(10, 30)}}, live)
+	stored, live = split(at(10, 0), at(20, 0), at(25, 0), at(30, 0))
+	require.Equal(t, timeRange{}, stored)
+	require.Equal(t, []timeRange{{at(10, 0), at(20, 0)}}, live)
+}
+
+func TestNextEnd(t *testing.T) {
+	until := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, until.Add(5*time.Minute), nextEnd(until, until.Add(5*time.Minute)))
+	require.Equal(t, until.Add(maxBucketsPerRun*BucketWidth), nextEnd(until, until.Add(24*time.Hour)))
+}
+
+func TestQuantile(t *testing.T) {
+	e := newEdge("a", "b")
+	require.Equal(t, 0.0, e.Quantile(0.5))
+
+	// 10 calls between 10ms and 20ms, 10 calls over the last bound.
+	e.add(4, 10, 1)
+	e.add(len(DurationBounds), 10, 2)
+	require.Equal(t, uint64(20), e.CallCount)
+	require.Equal(t, uint64(3), e.ErrorCount)
+	require.InDelta(t, 15, e.Quantile(0.25), 1e-9)
+	require.InDelta(t, 20, e.Quantile(0.5), 1e-9)
+	require.Equal(t, 60000.0, e.Quantile(0.99))
+}
+
+func TestNewNodeGraph(t *testing.T) {
+	front := newEdge("frontend", "backend")
+	front.add(6, 8, 2)
+	db := newEdge("backend", "db")
+	db.add(2, 4, 0)
+
+	g := NewNodeGraph([]Edge{*db, *front})
+	require.Equal(t, []Node{
+		{ID: "backend", Title: "backend", MainStat: 8, SecondaryStat: 2, ArcSuccess: 0.75, ArcErrors: 0.25},
+		{ID: "db", Title: "db", MainStat: 4, ArcSuccess: 1},
+		{ID: "frontend", Title: "frontend", ArcSuccess: 1},
+	}, g.Nodes)
+	require.Len(t, g.Edges, 2)
+	require.Equal(t, "frontend->backend", g.Edges[1].ID)
+	require.Equal(t, uint64(8), g.Edges[1].MainStat)
+	require.Equal(t, uint64(2), g.Edges[1].SecondaryStat)
+	require.InDelta(t, 75, g.Edges[1].P50, 1e-9)
+
+	require.Empty(t, NewNodeGraph(nil).Nodes)
+}
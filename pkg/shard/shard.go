@@ -0,0 +1,112 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package shard assigns the metrics to the databases they are sharded across,
+// by a consistent hash of their name or by a configured mapping. All the
+// series of a metric are stored in the same shard.
+package shard
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Mapping is a CLI flag type assigning metrics to shards, as a
+// comma-separated list of metric=shard pairs.
+type Mapping map[string]int
+
+func (m *Mapping) String() string {
+	if m == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*m))
+	for metric, shard := range *m {
+		pairs = append(pairs, metric+"="+strconv.Itoa(shard))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements the flag interface to set value from the CLI
+func (m *Mapping) Set(val string) error {
+	mapping := Mapping{}
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid shard mapping %q, must be metric=shard", pair)
+		}
+		shard, err := strconv.Atoi(kv[1])
+		if err != nil || shard < 0 {
+			return fmt.Errorf("invalid shard mapping %q, the shard must be a non-negative integer", pair)
+		}
+		mapping[kv[0]] = shard
+	}
+	*m = mapping
+	return nil
+}
+
+// Router assigns the metrics to shards.
+type Router struct {
+	shards  int
+	mapping Mapping
+}
+
+// NewRouter returns a router over the number of shards, assigning the metrics
+// of the mapping to their shard and the other metrics by a hash of their name.
+func NewRouter(shards int, mapping Mapping) (*Router, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("the number of shards must be at least 1")
+	}
+	for metric, shard := range mapping {
+		if shard >= shards {
+			return nil, fmt.Errorf("metric %s is mapped to shard %d, but there are only %d shards", metric, shard, shards)
+		}
+	}
+	return &Router{shards: shards, mapping: mapping}, nil
+}
+
+// Shards returns the number of shards.
+func (r *Router) Shards() int {
+	return r.shards
+}
+
+// Shard returns the shard of the metric.
+func (r *Router) Shard(metric string) int {
+	if shard, ok := r.mapping[metric]; ok {
+		return shard
+	}
+	return jumpHash(xxhash.Sum64String(metric), r.shards)
+}
+
+// ShardOf returns the shard of the series selected by the matchers, or -1 if
+// they do not select a single metric name and can be in any shard.
+func (r *Router) ShardOf(matchers []*labels.Matcher) int {
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			return r.Shard(m.Value)
+		}
+	}
+	return -1
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach: when a shard is
+// added, only 1/shards of the keys move, all to the new shard.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,64 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping(t *testing.T) {
+	var m Mapping
+	require.NoError(t, m.Set("up=1, node_cpu_seconds_total=0"))
+	require.Equal(t, Mapping{"up": 1, "node_cpu_seconds_total": 0}, m)
+	require.Equal(t, "node_cpu_seconds_total=0,up=1", m.String())
+
+	require.Error(t, m.Set("up"))
+	require.Error(t, m.Set("up=-1"))
+	require.Error(t, m.Set("=1"))
+}
+
+func TestRouter(t *testing.T) {
+	_, err := NewRouter(0, nil)
+	require.Error(t, err)
+	_, err = NewRouter(2, Mapping{"up": 2})
+	require.Error(t, err)
+
+	r, err := NewRouter(3, Mapping{"up": 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, r.Shard("up"))
+
+	counts := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		counts[r.Shard(fmt.Sprintf("metric_%d", i))]++
+	}
+	for _, c := range counts {
+		require.InDelta(t, 1000, c, 150)
+	}
+
+	require.Equal(t, r.Shard("cpu"), r.ShardOf([]*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "job", "node"),
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu"),
+	}))
+	require.Equal(t, -1, r.ShardOf([]*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, "cpu|mem"),
+	}))
+}
+
+func TestJumpHashConsistency(t *testing.T) {
+	// Adding a shard only moves keys to the new shard.
+	moved := 0
+	for key := uint64(0); key < 10000; key++ {
+		before, after := jumpHash(key*7919, 4), jumpHash(key*7919, 5)
+		if before != after {
+			require.Equal(t, 4, after)
+			moved++
+		}
+	}
+	require.InDelta(t, 2000, moved, 300)
+}
@@ -0,0 +1,126 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package spanmetrics
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultFlushInterval = 15 * time.Second
+	DefaultMaxSeries     = 10000
+)
+
+// DefaultBuckets are the latency buckets of the OpenTelemetry Collector
+// spanmetrics processor.
+var DefaultBuckets = []time.Duration{
+	2 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond, 8 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	400 * time.Millisecond, 800 * time.Millisecond, time.Second, 1400 * time.Millisecond,
+	2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second,
+}
+
+// Config holds the span metrics flags.
+type Config struct {
+	Enabled       bool
+	Buckets       durationList
+	Dimensions    stringList
+	FlushInterval time.Duration
+	MaxSeries     int
+}
+
+// ParseFlags registers the span metrics flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	cfg.Buckets = append(durationList(nil), DefaultBuckets...)
+	fs.BoolVar(&cfg.Enabled, "tracing.span-metrics.enabled", false, "Generate request, error and duration metrics from the ingested spans, by service, operation, span kind and status code. "+
+		"The metrics are written like any other metric.")
+	fs.Var(&cfg.Buckets, "tracing.span-metrics.buckets", "Comma-separated list of the upper bounds of the span duration histogram buckets. Example: 10ms,100ms,1s")
+	fs.Var(&cfg.Dimensions, "tracing.span-metrics.dimensions", "Comma-separated list of span or resource attributes added as labels to the span metrics. Example: http.method,deployment.environment")
+	fs.DurationVar(&cfg.FlushInterval, "tracing.span-metrics.flush-interval", DefaultFlushInterval, "Interval at which the span metrics are written.")
+	fs.IntVar(&cfg.MaxSeries, "tracing.span-metrics.max-series", DefaultMaxSeries, "Maximum number of label combinations of the span metrics. Spans of new combinations over the limit are not counted.")
+	return cfg
+}
+
+// Validate checks the span metrics flags.
+func Validate(cfg *Config) error {
+	if cfg.FlushInterval <= 0 {
+		return fmt.Errorf("tracing.span-metrics.flush-interval must be positive")
+	}
+	if cfg.MaxSeries <= 0 {
+		return fmt.Errorf("tracing.span-metrics.max-series must be positive")
+	}
+	if len(cfg.Buckets) == 0 {
+		return fmt.Errorf("tracing.span-metrics.buckets must not be empty")
+	}
+	for i, b := range cfg.Buckets {
+		if b <= 0 {
+			return fmt.Errorf("span metrics bucket %s must be positive", b)
+		}
+		if i > 0 && b <= cfg.Buckets[i-1] {
+			return fmt.Errorf("span metrics buckets must be in increasing order")
+		}
+	}
+	seen := make(map[string]string, len(cfg.Dimensions))
+	for _, d := range cfg.Dimensions {
+		name := labelName(d)
+		if _, reserved := reservedLabels[name]; reserved {
+			return fmt.Errorf("span metrics dimension %q conflicts with the %s label", d, name)
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("span metrics dimensions %q and %q map to the same label %s", other, d, name)
+		}
+		seen[name] = d
+	}
+	return nil
+}
+
+type durationList []time.Duration
+
+func (l *durationList) String() string {
+	if l == nil {
+		return ""
+	}
+	s := make([]string, len(*l))
+	for i, d := range *l {
+		s[i] = d.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *durationList) Set(s string) error {
+	*l = (*l)[:0]
+	for _, v := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, d)
+	}
+	sort.Slice(*l, func(i, j int) bool { return (*l)[i] < (*l)[j] })
+	return nil
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = (*l)[:0]
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
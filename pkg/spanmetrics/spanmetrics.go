@@ -0,0 +1,312 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package spanmetrics generates request, error and duration (RED) metrics
+// from the ingested spans, like the spanmetrics processor of the
+// OpenTelemetry Collector, so that service dashboards do not need an extra
+// component in front of Promscale.
+package spanmetrics
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	callsMetric   = "calls_total"
+	latencyMetric = "latency"
+
+	serviceLabel    = "service_name"
+	operationLabel  = "operation"
+	spanKindLabel   = "span_kind"
+	statusCodeLabel = "status_code"
+
+	missingServiceName = "OTLPResourceNoServiceName"
+	serviceNameKey     = "service.name"
+)
+
+var reservedLabels = map[string]struct{}{
+	labels.MetricName:    {},
+	labels.BucketLabel:   {},
+	serviceLabel:         {},
+	operationLabel:       {},
+	spanKindLabel:        {},
+	statusCodeLabel:      {},
+	"__tenant__":         {},
+	"__promscale_tag__":  {},
+	"__promscale_tag2__": {},
+}
+
+var (
+	seriesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "span_metrics",
+			Name:      "series",
+			Help:      "Number of label combinations of the metrics generated from spans.",
+		},
+	)
+	droppedSpans = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "span_metrics",
+			Name:      "dropped_spans_total",
+			Help:      "Total number of spans not counted in the span metrics because the maximum number of series was reached.",
+		},
+	)
+	flushErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "span_metrics",
+			Name:      "flush_errors_total",
+			Help:      "Total number of failed writes of the span metrics.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(seriesGauge, droppedSpans, flushErrors)
+}
+
+// Ingestor is where the generated metrics are written.
+type Ingestor interface {
+	IngestMetrics(ctx context.Context, r *prompb.WriteRequest) (uint64, uint64, error)
+}
+
+// series holds the cumulative values of one label combination.
+type series struct {
+	labels []prompb.Label
+	calls  uint64
+	// buckets are not cumulative, the last one is +Inf.
+	buckets []uint64
+	sumMs   float64
+}
+
+// Generator aggregates the spans passed to Add, and periodically writes the
+// aggregates to the ingestor as cumulative counters and histograms.
+type Generator struct {
+	cfg        Config
+	ingestor   Ingestor
+	dimensions []string // label names of cfg.Dimensions
+
+	lock   sync.Mutex
+	series map[string]*series
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewGenerator returns a generator writing to ingestor, or nil if span
+// metrics are disabled. Run must be called to write the metrics.
+func NewGenerator(cfg Config, ingestor Ingestor) *Generator {
+	if !cfg.Enabled {
+		return nil
+	}
+	g := &Generator{
+		cfg:        cfg,
+		ingestor:   ingestor,
+		dimensions: make([]string, len(cfg.Dimensions)),
+		series:     make(map[string]*series),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i, d := range cfg.Dimensions {
+		g.dimensions[i] = labelName(d)
+	}
+	return g
+}
+
+// Add counts the spans of traces.
+func (g *Generator) Add(traces ptrace.Traces) {
+	var key strings.Builder
+	values := make([]string, 4+len(g.dimensions))
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	rSpans := traces.ResourceSpans()
+	for i := 0; i < rSpans.Len(); i++ {
+		resource := rSpans.At(i).Resource().Attributes()
+		service := missingServiceName
+		if v, ok := resource.Get(serviceNameKey); ok {
+			service = v.AsString()
+		}
+		scopeSpans := rSpans.At(i).ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			spans := scopeSpans.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				values[0] = service
+				values[1] = span.Name()
+				values[2] = span.Kind().String()
+				values[3] = span.Status().Code().String()
+				for d, attr := range g.cfg.Dimensions {
+					values[4+d] = attributeValue(attr, span.Attributes(), resource)
+				}
+
+				key.Reset()
+				for _, v := range values {
+					key.WriteString(v)
+					key.WriteByte(0xff)
+				}
+				s, ok := g.series[key.String()]
+				if !ok {
+					if len(g.series) >= g.cfg.MaxSeries {
+						droppedSpans.Inc()
+						continue
+					}
+					s = g.newSeries(values)
+					g.series[key.String()] = s
+					seriesGauge.Set(float64(len(g.series)))
+				}
+				g.observe(s, span)
+			}
+		}
+	}
+}
+
+func attributeValue(key string, attrs ...pcommon.Map) string {
+	for _, a := range attrs {
+		if v, ok := a.Get(key); ok {
+			return v.AsString()
+		}
+	}
+	return ""
+}
+
+func (g *Generator) newSeries(values []string) *series {
+	s := &series{
+		labels: []prompb.Label{
+			{Name: serviceLabel, Value: values[0]},
+			{Name: operationLabel, Value: values[1]},
+			{Name: spanKindLabel, Value: values[2]},
+			{Name: statusCodeLabel, Value: values[3]},
+		},
+		buckets: make([]uint64, len(g.cfg.Buckets)+1),
+	}
+	for i, name := range g.dimensions {
+		// Like Prometheus, an empty label is the same as a missing one.
+		if values[4+i] != "" {
+			s.labels = append(s.labels, prompb.Label{Name: name, Value: values[4+i]})
+		}
+	}
+	return s
+}
+
+func (g *Generator) observe(s *series, span ptrace.Span) {
+	var duration time.Duration
+	if span.EndTimestamp() > span.StartTimestamp() {
+		duration = time.Duration(span.EndTimestamp() - span.StartTimestamp())
+	}
+	s.calls++
+	s.sumMs += float64(duration) / float64(time.Millisecond)
+	i := 0
+	for i < len(g.cfg.Buckets) && duration > g.cfg.Buckets[i] {
+		i++
+	}
+	s.buckets[i]++
+}
+
+// writeRequest returns the current values of all the series at ts.
+func (g *Generator) writeRequest(ts time.Time) *prompb.WriteRequest {
+	ms := timestamp.FromTime(ts)
+	sample := func(v float64) []prompb.Sample {
+		return []prompb.Sample{{Timestamp: ms, Value: v}}
+	}
+	withName := func(lbls []prompb.Label, name string, extra ...prompb.Label) []prompb.Label {
+		l := make([]prompb.Label, 0, len(lbls)+1+len(extra))
+		l = append(l, prompb.Label{Name: labels.MetricName, Value: name})
+		l = append(l, lbls...)
+		return append(l, extra...)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	wr := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(g.series)*(len(g.cfg.Buckets)+4)),
+	}
+	for _, s := range g.series {
+		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+			Labels:  withName(s.labels, callsMetric),
+			Samples: sample(float64(s.calls)),
+		})
+		cumulative := uint64(0)
+		for i, count := range s.buckets {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(g.cfg.Buckets) {
+				le = float64(g.cfg.Buckets[i]) / float64(time.Millisecond)
+			}
+			wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+				Labels:  withName(s.labels, latencyMetric+"_bucket", prompb.Label{Name: labels.BucketLabel, Value: strconv.FormatFloat(le, 'g', -1, 64)}),
+				Samples: sample(float64(cumulative)),
+			})
+		}
+		wr.Timeseries = append(wr.Timeseries,
+			prompb.TimeSeries{Labels: withName(s.labels, latencyMetric+"_sum"), Samples: sample(s.sumMs)},
+			prompb.TimeSeries{Labels: withName(s.labels, latencyMetric+"_count"), Samples: sample(float64(s.calls))},
+		)
+	}
+	return wr
+}
+
+func (g *Generator) flush(ts time.Time) {
+	wr := g.writeRequest(ts)
+	if len(wr.Timeseries) == 0 {
+		return
+	}
+	if _, _, err := g.ingestor.IngestMetrics(context.Background(), wr); err != nil {
+		flushErrors.Inc()
+		log.Warn("msg", "error writing span metrics", "err", err)
+	}
+}
+
+// Run writes the metrics every flush interval until Stop is called.
+func (g *Generator) Run() {
+	go func() {
+		defer close(g.done)
+		ticker := time.NewTicker(g.cfg.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case now := <-ticker.C:
+				g.flush(now)
+			}
+		}
+	}()
+}
+
+// Stop writes the metrics a last time, and stops the generator.
+func (g *Generator) Stop() {
+	close(g.stop)
+	<-g.done
+	g.flush(time.Now())
+}
+
+// labelName turns an attribute key, e.g. http.method, into a valid label
+// name, e.g. http_method.
+func labelName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
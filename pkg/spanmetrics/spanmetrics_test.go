@@ -0,0 +1,129 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package spanmetrics
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+type fakeIngestor struct {
+	requests []*prompb.WriteRequest
+}
+
+func (f *fakeIngestor) IngestMetrics(_ context.Context, r *prompb.WriteRequest) (uint64, uint64, error) {
+	f.requests = append(f.requests, r)
+	return uint64(len(r.Timeseries)), 0, nil
+}
+
+func addSpan(traces ptrace.Traces, service, name string, duration time.Duration, code ptrace.StatusCode, attrs map[string]string) {
+	rSpan := traces.ResourceSpans().AppendEmpty()
+	rSpan.Resource().Attributes().InsertString("service.name", service)
+	span := rSpan.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName(name)
+	span.SetKind(ptrace.SpanKindServer)
+	start := time.Unix(1000, 0)
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(duration)))
+	span.Status().SetCode(code)
+	for k, v := range attrs {
+		span.Attributes().InsertString(k, v)
+	}
+}
+
+// seriesString formats the labels of ts like name{a="b", ...}.
+func seriesString(ts prompb.TimeSeries) string {
+	name := ""
+	var lbls []string
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		lbls = append(lbls, l.Name+`="`+l.Value+`"`)
+	}
+	sort.Strings(lbls)
+	return name + "{" + strings.Join(lbls, ", ") + "}"
+}
+
+func TestGenerator(t *testing.T) {
+	cfg := Config{
+		Enabled:       true,
+		Buckets:       []time.Duration{10 * time.Millisecond, time.Second},
+		Dimensions:    []string{"http.method"},
+		FlushInterval: time.Hour,
+		MaxSeries:     2,
+	}
+	require.NoError(t, Validate(&cfg))
+	ingestor := &fakeIngestor{}
+	g := NewGenerator(cfg, ingestor)
+
+	traces := ptrace.NewTraces()
+	addSpan(traces, "checkout", "GET /cart", 5*time.Millisecond, ptrace.StatusCodeOk, map[string]string{"http.method": "GET"})
+	addSpan(traces, "checkout", "GET /cart", 500*time.Millisecond, ptrace.StatusCodeOk, map[string]string{"http.method": "GET"})
+	addSpan(traces, "checkout", "GET /cart", 2*time.Second, ptrace.StatusCodeError, nil)
+	// Over the maximum number of series.
+	addSpan(traces, "frontend", "GET /", time.Millisecond, ptrace.StatusCodeOk, nil)
+	g.Add(traces)
+
+	g.flush(time.Unix(2000, 0))
+	require.Len(t, ingestor.requests, 1)
+	values := make(map[string]float64)
+	for _, ts := range ingestor.requests[0].Timeseries {
+		require.Len(t, ts.Samples, 1)
+		require.Equal(t, int64(2000000), ts.Samples[0].Timestamp)
+		values[seriesString(ts)] = ts.Samples[0].Value
+	}
+	ok := `operation="GET /cart", service_name="checkout", span_kind="SPAN_KIND_SERVER", status_code="STATUS_CODE_OK"`
+	failed := `operation="GET /cart", service_name="checkout", span_kind="SPAN_KIND_SERVER", status_code="STATUS_CODE_ERROR"`
+	require.Equal(t, map[string]float64{
+		`calls_total{http_method="GET", ` + ok + `}`:               2,
+		`latency_bucket{http_method="GET", le="10", ` + ok + `}`:   1,
+		`latency_bucket{http_method="GET", le="1000", ` + ok + `}`: 2,
+		`latency_bucket{http_method="GET", le="+Inf", ` + ok + `}`: 2,
+		`latency_sum{http_method="GET", ` + ok + `}`:               505,
+		`latency_count{http_method="GET", ` + ok + `}`:             2,
+		`calls_total{` + failed + `}`:                              1,
+		`latency_bucket{le="10", ` + failed + `}`:                  0,
+		`latency_bucket{le="1000", ` + failed + `}`:                0,
+		`latency_bucket{le="+Inf", ` + failed + `}`:                1,
+		`latency_sum{` + failed + `}`:                              2000,
+		`latency_count{` + failed + `}`:                            1,
+	}, values)
+}
+
+func TestDisabledGenerator(t *testing.T) {
+	require.Nil(t, NewGenerator(Config{}, &fakeIngestor{}))
+}
+
+func TestValidate(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := ParseFlags(fs, &Config{})
+	require.NoError(t, fs.Parse(nil))
+	require.NoError(t, Validate(cfg))
+	require.Equal(t, DefaultBuckets, []time.Duration(cfg.Buckets))
+
+	require.NoError(t, fs.Parse([]string{"-tracing.span-metrics.buckets", "1s,100ms", "-tracing.span-metrics.dimensions", "http.method, k8s.pod.name"}))
+	require.NoError(t, Validate(cfg))
+	require.Equal(t, []time.Duration{100 * time.Millisecond, time.Second}, []time.Duration(cfg.Buckets))
+	require.Equal(t, []string{"http.method", "k8s.pod.name"}, []string(cfg.Dimensions))
+
+	require.NoError(t, fs.Parse([]string{"-tracing.span-metrics.dimensions", "operation"}))
+	require.Error(t, Validate(cfg))
+	require.NoError(t, fs.Parse([]string{"-tracing.span-metrics.dimensions", "http.method,http_method"}))
+	require.Error(t, Validate(cfg))
+	require.NoError(t, fs.Parse([]string{"-tracing.span-metrics.dimensions", "", "-tracing.span-metrics.buckets", "1s,1s"}))
+	require.Error(t, Validate(cfg))
+}
@@ -0,0 +1,308 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package sqlapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotAllowed is the error of the queries rejected by the SQL API.
+var ErrNotAllowed = errors.New("query not allowed")
+
+// The queries are checked with a lexer rather than a SQL parser: it is enough
+// to find the statement separators, the first keyword and the qualified names,
+// the database parses the query itself. Strings, quoted identifiers and
+// comments are skipped so that their content is never mistaken for SQL.
+
+type tokenKind int
+
+const (
+	identToken tokenKind = iota
+	stringToken
+	otherToken
+)
+
+type token struct {
+	kind tokenKind
+	// text is the identifier, folded to lower case unless quoted, or the
+	// punctuation of the other tokens.
+	text   string
+	quoted bool
+	// end is the offset of the end of the token in the query.
+	end int
+}
+
+// deniedFunctions are the functions that would get around the checks of the
+// query: set_config can reset the role the query runs as, the others run the
+// SQL, or read the tables, named in their string arguments. They are rejected
+// wherever their name appears, qualified or not, called or not.
+var deniedFunctions = map[string]struct{}{
+	"set_config":                    {},
+	"query_to_xml":                  {},
+	"query_to_xmlschema":            {},
+	"query_to_xml_and_xmlschema":    {},
+	"cursor_to_xml":                 {},
+	"cursor_to_xmlschema":           {},
+	"table_to_xml":                  {},
+	"table_to_xmlschema":            {},
+	"table_to_xml_and_xmlschema":    {},
+	"schema_to_xml":                 {},
+	"schema_to_xmlschema":           {},
+	"schema_to_xml_and_xmlschema":   {},
+	"database_to_xml":               {},
+	"database_to_xmlschema":         {},
+	"database_to_xml_and_xmlschema": {},
+	"ts_stat":                       {},
+	"dblink":                        {},
+	"dblink_exec":                   {},
+	"dblink_open":                   {},
+	"dblink_fetch":                  {},
+	"dblink_send_query":             {},
+}
+
+// checkedQuery is a single read-only statement and the qualifiers of the
+// names it refers to.
+type checkedQuery struct {
+	statement  string
+	qualifiers []string
+}
+
+// check lexes query, and checks that it is a single SELECT, WITH, VALUES or
+// TABLE statement that does not refer to the denied functions. The trailing semicolons and comments are dropped from the
+// statement so that it can be embedded as a subquery.
+func check(query string) (checkedQuery, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return checkedQuery{}, err
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" && tokens[len(tokens)-1].kind == otherToken {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return checkedQuery{}, fmt.Errorf("%w: empty query", ErrNotAllowed)
+	}
+
+	first := ""
+	for _, t := range tokens {
+		if t.kind != otherToken || t.text != "(" {
+			if t.kind == identToken && !t.quoted {
+				first = t.text
+			}
+			break
+		}
+	}
+	switch first {
+	case "select", "with", "values", "table":
+	default:
+		return checkedQuery{}, fmt.Errorf("%w: only SELECT, WITH, VALUES and TABLE queries are allowed", ErrNotAllowed)
+	}
+
+	var (
+		qualifiers []string
+		seen       = make(map[string]struct{})
+	)
+	for i, t := range tokens {
+		if t.kind == otherToken && t.text == ";" {
+			return checkedQuery{}, fmt.Errorf("%w: multiple statements", ErrNotAllowed)
+		}
+		if t.kind != identToken {
+			continue
+		}
+		if _, denied := deniedFunctions[t.text]; denied {
+			return checkedQuery{}, fmt.Errorf("%w: function %s", ErrNotAllowed, t.text)
+		}
+		if i+1 >= len(tokens) {
+			continue
+		}
+		if next := tokens[i+1]; next.kind == otherToken && next.text == "." {
+			if _, found := seen[t.text]; !found {
+				seen[t.text] = struct{}{}
+				qualifiers = append(qualifiers, t.text)
+			}
+		}
+	}
+	return checkedQuery{
+		statement:  query[:tokens[len(tokens)-1].end],
+		qualifiers: qualifiers,
+	}, nil
+}
+
+// lex returns the tokens of query, without the whitespaces and comments.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSpace(c):
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end + 1
+		case strings.HasPrefix(query[i:], "/*"):
+			end, err := skipBlockComment(query, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '\'':
+			end, err := skipString(query, i, false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: stringToken, end: end})
+			i = end
+		case c == '"':
+			ident, end, err := quotedIdent(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: identToken, text: ident, quoted: true, end: end})
+			i = end
+		case c == '$' && i+1 < len(query) && !isDigit(query[i+1]):
+			end, err := skipDollarString(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: stringToken, end: end})
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(query) && isIdentPart(query[end]) {
+				end++
+			}
+			ident := strings.ToLower(query[i:end])
+			if end < len(query) && query[end] == '\'' && (ident == "e" || ident == "b" || ident == "x" || ident == "n") {
+				// A string constant with a prefix, only E strings have
+				// backslash escapes.
+				strEnd, err := skipString(query, end, ident == "e")
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, token{kind: stringToken, end: strEnd})
+				i = strEnd
+				continue
+			}
+			if ident == "u" && strings.HasPrefix(query[end:], "&\"") {
+				// The unicode escapes of U& identifiers could hide the
+				// name of a schema.
+				return nil, fmt.Errorf("%w: unicode escaped identifiers", ErrNotAllowed)
+			}
+			tokens = append(tokens, token{kind: identToken, text: ident, end: end})
+			i = end
+		case isDigit(c):
+			end := i + 1
+			for end < len(query) && (isDigit(query[end]) || query[end] == '.' || isIdentPart(query[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: otherToken, text: query[i:end], end: end})
+			i = end
+		default:
+			tokens = append(tokens, token{kind: otherToken, text: query[i : i+1], end: i + 1})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// skipBlockComment returns the end of the block comment starting at i. Block
+// comments nest in PostgreSQL.
+func skipBlockComment(query string, i int) (int, error) {
+	depth := 0
+	for i < len(query) {
+		switch {
+		case strings.HasPrefix(query[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(query[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i, nil
+			}
+		default:
+			i++
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated comment", ErrNotAllowed)
+}
+
+// skipString returns the end of the string constant whose opening quote is
+// at i.
+func skipString(query string, i int, backslashEscapes bool) (int, error) {
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case '\'':
+			if i+1 < len(query) && query[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated string", ErrNotAllowed)
+}
+
+// quotedIdent returns the quoted identifier starting at i and its end.
+func quotedIdent(query string, i int) (string, int, error) {
+	var ident strings.Builder
+	for i++; i < len(query); i++ {
+		if query[i] == '"' {
+			if i+1 < len(query) && query[i+1] == '"' {
+				ident.WriteByte('"')
+				i++
+				continue
+			}
+			return ident.String(), i + 1, nil
+		}
+		ident.WriteByte(query[i])
+	}
+	return "", 0, fmt.Errorf("%w: unterminated quoted identifier", ErrNotAllowed)
+}
+
+// skipDollarString returns the end of the dollar-quoted string constant
+// starting at i.
+func skipDollarString(query string, i int) (int, error) {
+	end := i + 1
+	for end < len(query) && query[end] != '$' {
+		if !isIdentPart(query[end]) {
+			return 0, fmt.Errorf("%w: invalid dollar quote", ErrNotAllowed)
+		}
+		end++
+	}
+	if end >= len(query) {
+		return 0, fmt.Errorf("%w: invalid dollar quote", ErrNotAllowed)
+	}
+	tag := query[i : end+1]
+	closing := strings.Index(query[end+1:], tag)
+	if closing < 0 {
+		return 0, fmt.Errorf("%w: unterminated string", ErrNotAllowed)
+	}
+	return end + 1 + closing + len(tag), nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
@@ -0,0 +1,156 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package sqlapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		statement  string
+		qualifiers []string
+		err        bool
+	}{
+		{
+			name:       "select",
+			query:      "SELECT time, value FROM prom_metric.up WHERE value > 0",
+			statement:  "SELECT time, value FROM prom_metric.up WHERE value > 0",
+			qualifiers: []string{"prom_metric"},
+		},
+		{
+			name:       "trailing semicolons and comment",
+			query:      "select * from up u where u.value = 1; ; -- the end",
+			statement:  "select * from up u where u.value = 1",
+			qualifiers: []string{"u"},
+		},
+		{
+			name:       "with and quoted names",
+			query:      `WITH s AS (SELECT * FROM "PROM_METRIC"."Up") SELECT count(*) FROM s`,
+			statement:  `WITH s AS (SELECT * FROM "PROM_METRIC"."Up") SELECT count(*) FROM s`,
+			qualifiers: []string{"PROM_METRIC"},
+		},
+		{
+			name:      "parenthesized",
+			query:     "(VALUES (1.5)) UNION (TABLE t)",
+			statement: "(VALUES (1.5)) UNION (TABLE t)",
+		},
+		{
+			name:      "strings and comments are skipped",
+			query:     "SELECT 'a; _prom_catalog.x', E'\\'; b.c', $$; d.e$$, $tag$ $$; f.g $tag$ /* ; h.i /* nested */ */",
+			statement: "SELECT 'a; _prom_catalog.x', E'\\'; b.c', $$; d.e$$, $tag$ $$; f.g $tag$",
+		},
+		{
+			name:       "qualified function",
+			query:      "SELECT _prom_catalog.get_default_retention_period()",
+			statement:  "SELECT _prom_catalog.get_default_retention_period()",
+			qualifiers: []string{"_prom_catalog"},
+		},
+		{
+			name:  "multiple statements",
+			query: "SELECT 1; DROP TABLE prom_data.up",
+			err:   true,
+		},
+		{
+			name:  "write",
+			query: "DELETE FROM prom_data.up",
+			err:   true,
+		},
+		{
+			name:  "set",
+			query: "SET search_path = _prom_catalog",
+			err:   true,
+		},
+		{
+			name:  "empty",
+			query: " ; -- nothing",
+			err:   true,
+		},
+		{
+			name:  "unterminated string",
+			query: "SELECT 'a",
+			err:   true,
+		},
+		{
+			name:  "unterminated comment",
+			query: "SELECT 1 /* /* */",
+			err:   true,
+		},
+		{
+			name:  "sql in a string",
+			query: "SELECT query_to_xml('SELECT * FROM _ps_catalog.audit_log', true, false, '')",
+			err:   true,
+		},
+		{
+			name:  "qualified sql in a string",
+			query: `SELECT * FROM pg_catalog."query_to_xml"('TABLE _prom_catalog.series', true, false, '') x`,
+			err:   true,
+		},
+		{
+			name:  "table in a string",
+			query: "SELECT TABLE_TO_XML('_prom_catalog.series', true, false, '')",
+			err:   true,
+		},
+		{
+			name:  "role reset",
+			query: "SELECT set_config('role', 'none', true), * FROM prom_metric.up",
+			err:   true,
+		},
+		{
+			name:  "role reset in a subquery",
+			query: "WITH r AS (SELECT Set_Config('role', 'none', true)) SELECT * FROM r, prom_metric.up",
+			err:   true,
+		},
+		{
+			name:  "unicode escaped identifier",
+			query: `SELECT * FROM U&"\005fprom_catalog".series`,
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			checked, err := check(c.query)
+			if c.err {
+				require.Error(t, err)
+				require.True(t, errors.Is(err, ErrNotAllowed))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.statement, checked.statement)
+			require.Equal(t, c.qualifiers, checked.qualifiers)
+		})
+	}
+}
+
+func TestRunRejectsBypasses(t *testing.T) {
+	cfg := Config{AllowedSchemas: []string{"prom_metric"}, MaxRows: 10, Role: defaultRole}
+	for _, query := range []string{
+		"SELECT query_to_xml('SELECT * FROM _ps_catalog.audit_log', true, false, '')",
+		"SELECT set_config('role', 'none', true), * FROM prom_metric.up",
+	} {
+		// The query is rejected before a connection is used.
+		_, err := Run(context.Background(), nil, cfg, query, 0)
+		require.True(t, errors.Is(err, ErrNotAllowed), query)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{allowedSchemasStr: " prom_metric, ,ps_trace", StatementTimeout: defaultStatementTimeout, MaxRows: 10}
+	require.NoError(t, Validate(&cfg))
+	require.Equal(t, []string{"prom_metric", "ps_trace"}, cfg.AllowedSchemas)
+
+	cfg = Config{Enabled: true, allowedSchemasStr: ",", StatementTimeout: defaultStatementTimeout, MaxRows: 10}
+	require.Error(t, Validate(&cfg))
+	cfg = Config{allowedSchemasStr: defaultAllowedSchemas, MaxRows: 10}
+	require.Error(t, Validate(&cfg))
+	cfg = Config{allowedSchemasStr: defaultAllowedSchemas, StatementTimeout: defaultStatementTimeout}
+	require.Error(t, Validate(&cfg))
+}
@@ -0,0 +1,62 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package sqlapi
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAllowedSchemas   = "prom_metric,prom_data,ps_trace"
+	defaultStatementTimeout = 30 * time.Second
+	defaultMaxRows          = 10000
+	defaultRole             = "prom_reader"
+)
+
+// Config holds the flags of the SQL API.
+type Config struct {
+	Enabled          bool
+	AllowedSchemas   []string
+	StatementTimeout time.Duration
+	MaxRows          int
+	Role             string
+
+	allowedSchemasStr string
+}
+
+// ParseFlags registers the SQL API flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.BoolVar(&cfg.Enabled, "web.enable-sql-api", false, "Serve read-only SQL queries on /api/v1/sql. The queries can only refer to the schemas of -web.sql-api.allowed-schemas.")
+	fs.StringVar(&cfg.allowedSchemasStr, "web.sql-api.allowed-schemas", defaultAllowedSchemas, "Comma separated list of the schemas the queries of the SQL API can refer to. "+
+		"They are also the search path of the queries.")
+	fs.DurationVar(&cfg.StatementTimeout, "web.sql-api.statement-timeout", defaultStatementTimeout, "Statement timeout of the queries of the SQL API.")
+	fs.IntVar(&cfg.MaxRows, "web.sql-api.max-rows", defaultMaxRows, "Maximum number of rows returned by a query of the SQL API, the rows beyond it are dropped.")
+	fs.StringVar(&cfg.Role, "web.sql-api.role", defaultRole, "Database role the queries of the SQL API run as. The database user of Promscale must be a member of it. "+
+		"Set it to an empty string to run the queries as the database user of Promscale.")
+	return cfg
+}
+
+// Validate checks the SQL API flags.
+func Validate(cfg *Config) error {
+	cfg.AllowedSchemas = cfg.AllowedSchemas[:0]
+	for _, schema := range strings.Split(cfg.allowedSchemasStr, ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			cfg.AllowedSchemas = append(cfg.AllowedSchemas, schema)
+		}
+	}
+	if cfg.Enabled && len(cfg.AllowedSchemas) == 0 {
+		return fmt.Errorf("web.sql-api.allowed-schemas must not be empty")
+	}
+	if cfg.StatementTimeout <= 0 {
+		return fmt.Errorf("web.sql-api.statement-timeout must be positive, got %s", cfg.StatementTimeout)
+	}
+	if cfg.MaxRows <= 0 {
+		return fmt.Errorf("web.sql-api.max-rows must be positive, got %d", cfg.MaxRows)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package sqlapi runs the read-only SQL queries of the /api/v1/sql endpoint.
+//
+// A query is accepted if it is a single SELECT, WITH, VALUES or TABLE
+// statement whose qualified names only refer to the allowed schemas, and that
+// does not call the functions running the SQL of their arguments or resetting
+// the role, which would get around both the check and the role. It runs
+// in a read-only transaction, as the configured role, with the allowed
+// schemas as search path and the configured statement timeout. The query is
+// embedded as a subquery limited to the maximum number of rows, which also
+// rejects the data-modifying statements the lexer would have missed.
+package sqlapi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// Column is a column of the result of a query.
+type Column struct {
+	Name string `json:"name"`
+	// Type is the name of the PostgreSQL type of the column.
+	Type string `json:"type"`
+}
+
+// Result is the result of a query. The values are in the text format of
+// PostgreSQL, nil for NULL.
+type Result struct {
+	Columns []Column    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+	// Truncated is set if the query returned more rows than the limit.
+	Truncated bool `json:"truncated"`
+}
+
+// Run runs query and returns at most limit rows of its result. The limit is
+// capped to the maximum number of rows of cfg.
+func Run(ctx context.Context, conn pgxconn.PgxConn, cfg Config, query string, limit int) (*Result, error) {
+	checked, err := check(query)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > cfg.MaxRows {
+		limit = cfg.MaxRows
+	}
+
+	tx, err := conn.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	// The transaction is read-only, rolling it back is all that is needed.
+	defer func() { _ = tx.Rollback(context.Background()) }()
+	if _, err = tx.Exec(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		return nil, fmt.Errorf("set read only: %w", err)
+	}
+	if err = checkQualifiers(ctx, tx, cfg.AllowedSchemas, checked.qualifiers); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true), set_config('search_path', $2, true)",
+		strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10), searchPath(cfg.AllowedSchemas)); err != nil {
+		return nil, fmt.Errorf("set query settings: %w", err)
+	}
+	if cfg.Role != "" {
+		if _, err = tx.Exec(ctx, "SET LOCAL ROLE "+pgx.Identifier{cfg.Role}.Sanitize()); err != nil {
+			return nil, fmt.Errorf("set role: %w", err)
+		}
+	}
+
+	// The statement starts on a new line so that a comment on its first
+	// line does not hide the start of the subquery.
+	sql := "SELECT * FROM (\n" + checked.statement + "\n) AS query LIMIT $1"
+	rows, err := tx.Query(ctx, sql, pgx.QueryResultFormats{pgx.TextFormatCode}, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connInfo := tx.Conn().ConnInfo()
+	res := &Result{Rows: [][]*string{}}
+	for _, field := range rows.FieldDescriptions() {
+		typ := strconv.FormatUint(uint64(field.DataTypeOID), 10)
+		if dt, ok := connInfo.DataTypeForOID(field.DataTypeOID); ok {
+			typ = dt.Name
+		}
+		res.Columns = append(res.Columns, Column{Name: string(field.Name), Type: typ})
+	}
+	for rows.Next() {
+		if len(res.Rows) == limit {
+			res.Truncated = true
+			break
+		}
+		raw := rows.RawValues()
+		row := make([]*string, len(raw))
+		for i, v := range raw {
+			if v != nil {
+				s := string(v)
+				row[i] = &s
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, rows.Err()
+}
+
+// checkQualifiers rejects the qualifiers naming a schema that is not allowed.
+// The qualifiers of columns, like table aliases, are not schemas.
+func checkQualifiers(ctx context.Context, tx pgx.Tx, allowed, qualifiers []string) error {
+	var candidates []string
+	for _, q := range qualifiers {
+		if !contains(allowed, q) {
+			candidates = append(candidates, q)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var schemas []string
+	err := tx.QueryRow(ctx, "SELECT coalesce(array_agg(nspname::text ORDER BY nspname), '{}') FROM pg_catalog.pg_namespace WHERE nspname = ANY($1::text[])", candidates).Scan(&schemas)
+	if err != nil {
+		return fmt.Errorf("check schemas: %w", err)
+	}
+	if len(schemas) > 0 {
+		return fmt.Errorf("%w: schema %q is not one of the allowed schemas %s", ErrNotAllowed, schemas[0], strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+func searchPath(schemas []string) string {
+	quoted := make([]string, len(schemas))
+	for i, s := range schemas {
+		quoted[i] = pgx.Identifier{s}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package storagesim estimates how much storage the metrics will use over
+// time under proposed retention, compression and rollup settings, based on
+// the data already stored.
+package storagesim
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultHorizon = 90 * 24 * time.Hour
+	DefaultStep    = 24 * time.Hour
+
+	// maxPoints bounds the number of points of a simulation.
+	maxPoints = 1000
+	// rollupColumns is the number of values a rollup keeps per series and
+	// resolution step, e.g. the sum, count, min and max of the samples.
+	rollupColumns = 4
+)
+
+// Settings are the proposed storage settings. A zero value keeps the
+// current setting of each metric.
+type Settings struct {
+	Retention     time.Duration
+	CompressAfter time.Duration
+	// Compression enables or disables compression, nil keeps the current setting.
+	Compression *bool
+	// CompressionRatio replaces the compression ratio observed for each metric.
+	CompressionRatio float64
+
+	// A rollup keeps the data at RollupResolution for RollupRetention.
+	RollupResolution time.Duration
+	RollupRetention  time.Duration
+
+	// Metrics limits the simulation to these metrics, all metrics are used if empty.
+	Metrics []string
+
+	Horizon time.Duration
+	Step    time.Duration
+}
+
+// Validate checks the settings and sets the defaults of the horizon and step.
+func (s *Settings) Validate() error {
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"retention", s.Retention},
+		{"compress_after", s.CompressAfter},
+		{"rollup_resolution", s.RollupResolution},
+		{"rollup_retention", s.RollupRetention},
+		{"horizon", s.Horizon},
+		{"step", s.Step},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+	if s.CompressionRatio != 0 && s.CompressionRatio < 1 {
+		return fmt.Errorf("compression_ratio must be at least 1, got %v", s.CompressionRatio)
+	}
+	if (s.RollupResolution == 0) != (s.RollupRetention == 0) {
+		return fmt.Errorf("rollup_resolution and rollup_retention must be set together")
+	}
+	if s.Horizon == 0 {
+		s.Horizon = DefaultHorizon
+	}
+	if s.Step == 0 {
+		s.Step = DefaultStep
+	}
+	if s.Horizon/s.Step >= maxPoints {
+		return fmt.Errorf("horizon/step must be less than %d points, got %d", maxPoints, s.Horizon/s.Step)
+	}
+	return nil
+}
+
+// MetricStats are the current settings and storage statistics of a metric.
+type MetricStats struct {
+	Name          string
+	Retention     time.Duration
+	ChunkInterval time.Duration
+	Compression   bool
+	// DataInterval is the time range covered by the stored data.
+	DataInterval time.Duration
+
+	// BeforeCompressionBytes and AfterCompressionBytes are the sizes of the
+	// compressed chunks, TotalSizeBytes the size of all chunks.
+	BeforeCompressionBytes int64
+	AfterCompressionBytes  int64
+	TotalSizeBytes         int64
+
+	NumSeries  int64
+	NumSamples int64
+}
+
+// rawBytesPerSecond is the uncompressed size of the data written per second.
+func (m MetricStats) rawBytesPerSecond() float64 {
+	if m.DataInterval <= 0 {
+		return 0
+	}
+	uncompressed := m.TotalSizeBytes - m.AfterCompressionBytes
+	if uncompressed < 0 {
+		uncompressed = 0
+	}
+	return float64(m.BeforeCompressionBytes+uncompressed) / m.DataInterval.Seconds()
+}
+
+// compressionRatio is the observed compression ratio, or 0 if no chunk is compressed.
+func (m MetricStats) compressionRatio() float64 {
+	if m.AfterCompressionBytes <= 0 || m.BeforeCompressionBytes <= 0 {
+		return 0
+	}
+	return float64(m.BeforeCompressionBytes) / float64(m.AfterCompressionBytes)
+}
+
+// policy is the storage settings applied to a metric.
+type policy struct {
+	retention        time.Duration
+	compression      bool
+	compressAfter    time.Duration
+	compressionRatio float64
+	rollupResolution time.Duration
+	rollupRetention  time.Duration
+}
+
+// model is the storage growth of a metric.
+type model struct {
+	rawBytesPerSecond float64
+	// bytesPerSample is the compressed size of a sample.
+	bytesPerSample float64
+	numSeries      float64
+	dataInterval   time.Duration
+}
+
+// sizeAt returns the size in bytes of the metric after elapsed time with the policy p.
+// The data that is already stored counts towards the retention.
+func (m model) sizeAt(p policy, elapsed time.Duration) float64 {
+	window := minDuration(m.dataInterval+elapsed, p.retention)
+	size := m.rawBytesPerSecond * window.Seconds()
+	if p.compression {
+		compressed := window - minDuration(window, p.compressAfter)
+		size -= m.rawBytesPerSecond * compressed.Seconds() * (1 - 1/p.compressionRatio)
+	}
+	if p.rollupResolution > 0 {
+		rollupWindow := minDuration(m.dataInterval+elapsed, p.rollupRetention)
+		points := m.numSeries * rollupWindow.Seconds() / p.rollupResolution.Seconds()
+		size += points * m.bytesPerSample * rollupColumns
+	}
+	return size
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Point is the estimated storage at a time.
+type Point struct {
+	Time          time.Time `json:"time"`
+	CurrentBytes  int64     `json:"currentBytes"`
+	ProposedBytes int64     `json:"proposedBytes"`
+}
+
+// MetricEstimate is the estimated storage of a metric once the data is
+// kept for its whole retention.
+type MetricEstimate struct {
+	Metric              string  `json:"metric"`
+	CurrentSizeBytes    int64   `json:"currentSizeBytes"`
+	RawBytesPerDay      int64   `json:"rawBytesPerDay"`
+	CompressionRatio    float64 `json:"compressionRatio"`
+	CurrentSteadyBytes  int64   `json:"currentSteadyStateBytes"`
+	ProposedSteadyBytes int64   `json:"proposedSteadyStateBytes"`
+}
+
+// Result is the outcome of a simulation.
+type Result struct {
+	CurrentSizeBytes    int64            `json:"currentSizeBytes"`
+	CurrentSteadyBytes  int64            `json:"currentSteadyStateBytes"`
+	ProposedSteadyBytes int64            `json:"proposedSteadyStateBytes"`
+	Points              []Point          `json:"points"`
+	Metrics             []MetricEstimate `json:"metrics"`
+}
+
+// Simulate estimates the storage used from now until the horizon of the
+// settings, with the current settings of each metric and with the proposed
+// ones. The data is assumed to keep growing at the rate at which the stored
+// data grew. Metrics without any compressed chunk use the average compression
+// ratio of the other metrics, unless the settings set one.
+func Simulate(stats []MetricStats, s Settings, now time.Time) Result {
+	defaultRatio := averageCompressionRatio(stats)
+	only := make(map[string]bool, len(s.Metrics))
+	for _, name := range s.Metrics {
+		only[name] = true
+	}
+
+	res := Result{Points: make([]Point, 0, int(s.Horizon/s.Step)+1)}
+	for elapsed := time.Duration(0); elapsed <= s.Horizon; elapsed += s.Step {
+		res.Points = append(res.Points, Point{Time: now.Add(elapsed)})
+	}
+	for _, m := range stats {
+		if len(only) > 0 && !only[m.Name] {
+			continue
+		}
+		ratio := m.compressionRatio()
+		if ratio == 0 {
+			ratio = defaultRatio
+		}
+		current := policy{
+			retention:        m.Retention,
+			compression:      m.Compression,
+			compressAfter:    m.ChunkInterval,
+			compressionRatio: ratio,
+		}
+		proposed := proposedPolicy(current, s)
+
+		mdl := model{
+			rawBytesPerSecond: m.rawBytesPerSecond(),
+			numSeries:         float64(m.NumSeries),
+			dataInterval:      m.DataInterval,
+		}
+		if m.NumSamples > 0 && m.DataInterval > 0 {
+			samplesPerSecond := float64(m.NumSamples) / m.DataInterval.Seconds()
+			mdl.bytesPerSample = mdl.rawBytesPerSecond / samplesPerSecond / proposed.compressionRatio
+		}
+
+		for i := range res.Points {
+			elapsed := time.Duration(i) * s.Step
+			res.Points[i].CurrentBytes += int64(mdl.sizeAt(current, elapsed))
+			res.Points[i].ProposedBytes += int64(mdl.sizeAt(proposed, elapsed))
+		}
+		estimate := MetricEstimate{
+			Metric:              m.Name,
+			CurrentSizeBytes:    m.TotalSizeBytes,
+			RawBytesPerDay:      int64(mdl.rawBytesPerSecond * (24 * time.Hour).Seconds()),
+			CompressionRatio:    ratio,
+			CurrentSteadyBytes:  int64(mdl.sizeAt(current, current.retention)),
+			ProposedSteadyBytes: int64(mdl.sizeAt(proposed, maxDuration(proposed.retention, proposed.rollupRetention))),
+		}
+		res.CurrentSizeBytes += estimate.CurrentSizeBytes
+		res.CurrentSteadyBytes += estimate.CurrentSteadyBytes
+		res.ProposedSteadyBytes += estimate.ProposedSteadyBytes
+		res.Metrics = append(res.Metrics, estimate)
+	}
+	// The metrics that would use the most storage come first.
+	sort.SliceStable(res.Metrics, func(i, j int) bool {
+		return res.Metrics[i].ProposedSteadyBytes > res.Metrics[j].ProposedSteadyBytes
+	})
+	return res
+}
+
+func proposedPolicy(current policy, s Settings) policy {
+	p := current
+	if s.Retention > 0 {
+		p.retention = s.Retention
+	}
+	if s.Compression != nil {
+		p.compression = *s.Compression
+	}
+	if s.CompressAfter > 0 {
+		p.compressAfter = s.CompressAfter
+	}
+	if s.CompressionRatio > 0 {
+		p.compressionRatio = s.CompressionRatio
+	}
+	p.rollupResolution = s.RollupResolution
+	p.rollupRetention = s.RollupRetention
+	return p
+}
+
+// averageCompressionRatio is the compression ratio of all the compressed
+// chunks, or 1 if there are none.
+func averageCompressionRatio(stats []MetricStats) float64 {
+	var before, after int64
+	for _, m := range stats {
+		if m.compressionRatio() > 0 {
+			before += m.BeforeCompressionBytes
+			after += m.AfterCompressionBytes
+		}
+	}
+	if after == 0 {
+		return 1
+	}
+	return float64(before) / float64(after)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,128 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package storagesim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings Settings
+		err      bool
+	}{
+		{name: "defaults", settings: Settings{}},
+		{name: "negative retention", settings: Settings{Retention: -day}, err: true},
+		{name: "compression ratio below 1", settings: Settings{CompressionRatio: 0.5}, err: true},
+		{name: "rollup without retention", settings: Settings{RollupResolution: time.Hour}, err: true},
+		{name: "rollup", settings: Settings{RollupResolution: time.Hour, RollupRetention: 365 * day}},
+		{name: "too many points", settings: Settings{Horizon: 365 * day, Step: time.Minute}, err: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.settings.Validate()
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotZero(t, c.settings.Horizon)
+			require.NotZero(t, c.settings.Step)
+		})
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	// 100 bytes per second for a day, 10 series with a sample every 10 seconds.
+	metric := MetricStats{
+		Name:           "cpu",
+		Retention:      10 * day,
+		ChunkInterval:  8 * time.Hour,
+		Compression:    true,
+		DataInterval:   day,
+		TotalSizeBytes: 100 * 86400,
+		NumSeries:      10,
+		NumSamples:     86400,
+	}
+	enabled := true
+
+	testCases := []struct {
+		name           string
+		settings       Settings
+		proposedSteady int64
+	}{
+		{
+			name:           "current settings",
+			settings:       Settings{},
+			proposedSteady: 100 * 86400 * 10,
+		},
+		{
+			name:           "shorter retention",
+			settings:       Settings{Retention: 5 * day},
+			proposedSteady: 100 * 86400 * 5,
+		},
+		{
+			name:           "compression",
+			settings:       Settings{Compression: &enabled, CompressAfter: day, CompressionRatio: 10},
+			proposedSteady: 100*86400 + 100*86400*9/10,
+		},
+		{
+			name:     "rollup",
+			settings: Settings{RollupResolution: time.Hour, RollupRetention: 30 * day},
+			// 10 series * 720 hours * 100 bytes * 4 columns
+			proposedSteady: 100*86400*10 + 10*720*100*rollupColumns,
+		},
+	}
+	now := time.Unix(0, 0)
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.settings.Horizon = 2 * day
+			require.NoError(t, c.settings.Validate())
+			res := Simulate([]MetricStats{metric}, c.settings, now)
+
+			require.Equal(t, int64(100*86400*10), res.CurrentSteadyBytes)
+			require.InDelta(t, c.proposedSteady, res.ProposedSteadyBytes, 1)
+			require.Len(t, res.Points, 3)
+			require.Equal(t, now.Add(2*day), res.Points[2].Time)
+			require.Equal(t, int64(100*86400), res.Points[0].CurrentBytes)
+			require.Equal(t, int64(100*86400*3), res.Points[2].CurrentBytes)
+			require.Len(t, res.Metrics, 1)
+			require.Equal(t, int64(100*86400), res.Metrics[0].RawBytesPerDay)
+		})
+	}
+}
+
+func TestSimulateObservedCompressionRatio(t *testing.T) {
+	stats := []MetricStats{
+		{
+			Name:                   "compressed",
+			Retention:              10 * day,
+			Compression:            true,
+			DataInterval:           10 * day,
+			BeforeCompressionBytes: 1000,
+			AfterCompressionBytes:  100,
+			TotalSizeBytes:         150,
+		},
+		{
+			Name:           "uncompressed",
+			Retention:      10 * day,
+			Compression:    true,
+			DataInterval:   day,
+			TotalSizeBytes: 100,
+		},
+	}
+	settings := Settings{Metrics: []string{"uncompressed"}}
+	require.NoError(t, settings.Validate())
+	res := Simulate(stats, settings, time.Now())
+	require.Len(t, res.Metrics, 1)
+	// The ratio of the compressed chunks of the other metrics is used.
+	require.Equal(t, 10.0, res.Metrics[0].CompressionRatio)
+}
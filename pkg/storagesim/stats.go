@@ -0,0 +1,62 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package storagesim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+// The interval and size columns are NULL when TimescaleDB is not installed,
+// the metric then has no data interval and is not simulated.
+const metricStatsSQL = `SELECT m.metric_name,
+	extract(epoch FROM coalesce(m.retention_period, interval '0'))::float8,
+	extract(epoch FROM coalesce(m.chunk_interval, interval '0'))::float8,
+	_prom_catalog.get_metric_compression_setting(m.metric_name),
+	extract(epoch FROM coalesce(m.total_interval, interval '0'))::float8,
+	coalesce(m.before_compression_bytes, 0),
+	coalesce(m.after_compression_bytes, 0),
+	coalesce(m.total_size_bytes, 0),
+	coalesce(s.num_series_approx, 0)::bigint,
+	coalesce(s.num_samples_approx, 0)::bigint
+FROM prom_info.metric m
+LEFT JOIN prom_info.metric_stats s ON (s.metric_name = m.metric_name)`
+
+// LoadStats reads the current settings and storage statistics of the metrics.
+func LoadStats(ctx context.Context, conn pgxconn.PgxConn) ([]MetricStats, error) {
+	rows, err := conn.Query(ctx, metricStatsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metric storage statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []MetricStats
+	for rows.Next() {
+		var (
+			m                                  MetricStats
+			retention, chunkInterval, interval float64
+		)
+		err = rows.Scan(&m.Name, &retention, &chunkInterval, &m.Compression, &interval,
+			&m.BeforeCompressionBytes, &m.AfterCompressionBytes, &m.TotalSizeBytes, &m.NumSeries, &m.NumSamples)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metric storage statistics: %w", err)
+		}
+		m.Retention = seconds(retention)
+		m.ChunkInterval = seconds(chunkInterval)
+		m.DataInterval = seconds(interval)
+		stats = append(stats, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading metric storage statistics: %w", err)
+	}
+	return stats, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
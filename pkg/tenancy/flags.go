@@ -3,10 +3,15 @@ package tenancy
 import (
 	"flag"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
-const AllowAllTenants = "allow-all"
+const (
+	AllowAllTenants = "allow-all"
+
+	defaultTenantPartitions = 8
+)
 
 type Config struct {
 	SkipTenantValidation        bool
@@ -15,6 +20,11 @@ type Config struct {
 	UseExperimentalLabelQueries bool
 	ValidTenantsStr             string
 	ValidTenantsList            []string
+	// PartitionedMetrics is a regular expression matching the whole name of
+	// the metrics whose samples are partitioned by tenant, empty if none.
+	PartitionedMetrics string
+	// TenantPartitions is the number of tenant partitions of these metrics.
+	TenantPartitions int
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) {
@@ -28,12 +38,25 @@ func ParseFlags(fs *flag.FlagSet, cfg *Config) {
 	fs.BoolVar(&cfg.UseExperimentalLabelQueries, "metrics.multi-tenancy.experimental.label-queries", true, "[EXPERIMENTAL] Use label queries "+
 		"that returns labels of authorized tenants only. This may affect system performance while running PromQL queries. "+
 		"By default this is enabled in -metrics.multi-tenancy mode.")
+	fs.StringVar(&cfg.PartitionedMetrics, "metrics.multi-tenancy.partitioned-metrics", "", "Regular expression matching the whole name of the metrics whose samples are "+
+		"partitioned by tenant on top of time, so that the queries of a tenant only read its partitions. Applies to the metric tables that are empty when the connector "+
+		"first writes to them. No metric is partitioned if empty.")
+	fs.IntVar(&cfg.TenantPartitions, "metrics.multi-tenancy.partitions", defaultTenantPartitions, "Number of tenant partitions of the metrics matched by "+
+		"-metrics.multi-tenancy.partitioned-metrics.")
 }
 
 func Validate(cfg *Config) error {
 	if !cfg.EnableMultiTenancy {
 		return nil
 	}
+	if cfg.PartitionedMetrics != "" {
+		if _, err := regexp.Compile(cfg.PartitionedMetrics); err != nil {
+			return fmt.Errorf("invalid 'metrics.multi-tenancy.partitioned-metrics' regex: %w", err)
+		}
+		if cfg.TenantPartitions < 1 {
+			return fmt.Errorf("'metrics.multi-tenancy.partitions' must be at least 1, got %d", cfg.TenantPartitions)
+		}
+	}
 	if cfg.ValidTenantsStr == AllowAllTenants {
 		cfg.SkipTenantValidation = true
 		return nil
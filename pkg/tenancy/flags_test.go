@@ -13,16 +13,31 @@ import (
 
 func TestParseFlags(t *testing.T) {
 	config := fullyParse(t, []string{"-metrics.multi-tenancy", fmt.Sprintf("-metrics.multi-tenancy.valid-tenants=%s", AllowAllTenants)})
-	require.Equal(t, Config{EnableMultiTenancy: true, ValidTenantsStr: AllowAllTenants, SkipTenantValidation: true, UseExperimentalLabelQueries: true}, config)
+	require.Equal(t, Config{EnableMultiTenancy: true, ValidTenantsStr: AllowAllTenants, SkipTenantValidation: true, UseExperimentalLabelQueries: true, TenantPartitions: defaultTenantPartitions}, config)
 
 	config = fullyParse(t, []string{"-metrics.multi-tenancy", "-metrics.multi-tenancy.valid-tenants=tenant-a,tenant-b,tenant-c"})
-	require.Equal(t, Config{EnableMultiTenancy: true, ValidTenantsStr: "tenant-a,tenant-b,tenant-c", ValidTenantsList: []string{"tenant-a", "tenant-b", "tenant-c"}, UseExperimentalLabelQueries: true}, config)
+	require.Equal(t, Config{EnableMultiTenancy: true, ValidTenantsStr: "tenant-a,tenant-b,tenant-c", ValidTenantsList: []string{"tenant-a", "tenant-b", "tenant-c"}, UseExperimentalLabelQueries: true, TenantPartitions: defaultTenantPartitions}, config)
 
 	config = fullyParse(t, []string{fmt.Sprintf("-metrics.multi-tenancy.valid-tenants=%s", AllowAllTenants)})
-	require.Equal(t, Config{ValidTenantsStr: AllowAllTenants, SkipTenantValidation: false, UseExperimentalLabelQueries: true}, config)
+	require.Equal(t, Config{ValidTenantsStr: AllowAllTenants, SkipTenantValidation: false, UseExperimentalLabelQueries: true, TenantPartitions: defaultTenantPartitions}, config)
 
 	config = fullyParse(t, []string{fmt.Sprintf("-metrics.multi-tenancy.valid-tenants=%s", AllowAllTenants), "-metrics.multi-tenancy.experimental.label-queries=false"})
-	require.Equal(t, Config{ValidTenantsStr: AllowAllTenants, SkipTenantValidation: false, UseExperimentalLabelQueries: false}, config)
+	require.Equal(t, Config{ValidTenantsStr: AllowAllTenants, SkipTenantValidation: false, UseExperimentalLabelQueries: false, TenantPartitions: defaultTenantPartitions}, config)
+
+	config = fullyParse(t, []string{"-metrics.multi-tenancy", "-metrics.multi-tenancy.partitioned-metrics=container_.*", "-metrics.multi-tenancy.partitions=16"})
+	require.Equal(t, "container_.*", config.PartitionedMetrics)
+	require.Equal(t, 16, config.TenantPartitions)
+
+	for _, args := range [][]string{
+		{"-metrics.multi-tenancy", "-metrics.multi-tenancy.partitioned-metrics=container_(.*"},
+		{"-metrics.multi-tenancy", "-metrics.multi-tenancy.partitioned-metrics=container_.*", "-metrics.multi-tenancy.partitions=0"},
+	} {
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		cfg := &Config{}
+		ParseFlags(fs, cfg)
+		require.NoError(t, ff.Parse(fs, args))
+		require.Error(t, Validate(cfg), args)
+	}
 }
 
 func fullyParse(t *testing.T, args []string) Config {
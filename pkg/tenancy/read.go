@@ -6,6 +6,9 @@ package tenancy
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/prometheus/prometheus/model/labels"
 )
@@ -36,3 +39,63 @@ func (a *readAuthorizer) AppendTenantMatcher(ms []*labels.Matcher) []*labels.Mat
 	ms = append(ms, a.mtSafetyLabelMatcher)
 	return ms
 }
+
+// MatchedTenants returns the sorted tenants the __tenant__ matchers restrict
+// the series to, and false if they do not restrict them to a list of tenants.
+// The series without tenant have the empty tenant.
+func MatchedTenants(ms []*labels.Matcher) ([]string, bool) {
+	var (
+		tenants    map[string]struct{}
+		restricted bool
+	)
+	for _, m := range ms {
+		if m.Name != TenantLabelKey {
+			continue
+		}
+		var values []string
+		switch m.Type {
+		case labels.MatchEqual:
+			values = []string{m.Value}
+		case labels.MatchRegexp:
+			var ok bool
+			if values, ok = regexAlternatives(m.Value); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+		matched := make(map[string]struct{}, len(values))
+		for _, v := range values {
+			if _, ok := tenants[v]; ok || !restricted {
+				matched[v] = struct{}{}
+			}
+		}
+		tenants, restricted = matched, true
+	}
+	if !restricted {
+		return nil, false
+	}
+	result := make([]string, 0, len(tenants))
+	for t := range tenants {
+		result = append(result, t)
+	}
+	sort.Strings(result)
+	return result, true
+}
+
+// regexAlternatives returns the values matched by a regex made of literal
+// alternatives, like the safety matcher of the authorized tenants, and false
+// for any other regex. The ^$ alternative matches the empty value.
+func regexAlternatives(re string) ([]string, bool) {
+	values := strings.Split(re, regexOR)
+	for i, v := range values {
+		if v == "^$" {
+			values[i] = ""
+			continue
+		}
+		if regexp.QuoteMeta(v) != v {
+			return nil, false
+		}
+	}
+	return values, true
+}
@@ -65,3 +65,61 @@ func getSafetyMatcher(ms []*labels.Matcher) (string, bool) {
 	}
 	return "", false
 }
+
+func TestMatchedTenants(t *testing.T) {
+	tcs := []struct {
+		name       string
+		matchers   []*labels.Matcher
+		tenants    []string
+		restricted bool
+	}{
+		{
+			name:     "no tenant matcher",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")},
+		},
+		{
+			name:       "equal",
+			matchers:   []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, TenantLabelKey, "tenant-a")},
+			tenants:    []string{"tenant-a"},
+			restricted: true,
+		},
+		{
+			name:       "safety matcher with non-tenants",
+			matchers:   []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, TenantLabelKey, "tenant-b|tenant-a|^$")},
+			tenants:    []string{"", "tenant-a", "tenant-b"},
+			restricted: true,
+		},
+		{
+			name: "intersection",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, TenantLabelKey, "tenant-a|tenant-b"),
+				labels.MustNewMatcher(labels.MatchEqual, TenantLabelKey, "tenant-b"),
+			},
+			tenants:    []string{"tenant-b"},
+			restricted: true,
+		},
+		{
+			name: "no common tenant",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, TenantLabelKey, "tenant-a|tenant-b"),
+				labels.MustNewMatcher(labels.MatchEqual, TenantLabelKey, "tenant-c"),
+			},
+			tenants:    []string{},
+			restricted: true,
+		},
+		{
+			name: "other regexes and negative matchers do not restrict",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, TenantLabelKey, "tenant-.*"),
+				labels.MustNewMatcher(labels.MatchNotEqual, TenantLabelKey, ""),
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tenants, restricted := MatchedTenants(tc.matchers)
+			require.Equal(t, tc.restricted, restricted)
+			require.Equal(t, tc.tenants, tenants)
+		})
+	}
+}
@@ -60,9 +60,12 @@ func (a *writeAuthorizer) Process(r *http.Request, wr *prompb.WriteRequest) erro
 	return nil
 }
 
+// TenantHeader is the header holding the tenant of a write request.
+// We do not look for `X-` since it has been deprecated as mentioned in https://datatracker.ietf.org/doc/html/rfc6648.
+const TenantHeader = "TENANT"
+
 func getTenant(r *http.Request) string {
-	// We do not look for `X-` since it has been deprecated as mentioned in https://datatracker.ietf.org/doc/html/rfc6648.
-	return r.Header.Get("TENANT")
+	return r.Header.Get(TenantHeader)
 }
 
 func (a *writeAuthorizer) getTenantLabelMatchingHeader(tenantNameFromHeader string, labels []prompb.Label) ([]prompb.Label, error) {
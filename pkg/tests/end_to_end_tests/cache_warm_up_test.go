@@ -0,0 +1,95 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/common/schema"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+)
+
+func TestCacheWarmUpByActivity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	if !*useTimescaleDB {
+		t.Skip("the activity of the series is read from the chunks of TimescaleDB")
+	}
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ctx := context.Background()
+		series := func(metric string, numSamples int) prompb.TimeSeries {
+			ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: model.MetricNameLabelName, Value: metric}, {Name: "job", Value: "api"}}}
+			for i := 0; i < numSamples; i++ {
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: int64(i) * 1000, Value: float64(i)})
+			}
+			return ts
+		}
+
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), nil)
+		require.NoError(t, err)
+		// The busy and quiet series are created before the idle ones, which
+		// are the most recently created series.
+		for _, ts := range []prompb.TimeSeries{
+			series("warm_up_busy", 1000),
+			series("warm_up_quiet", 10),
+			series("warm_up_idle_1", 1),
+			series("warm_up_idle_2", 1),
+		} {
+			_, _, err = ingestor.IngestMetrics(ctx, newWriteRequestWithTs([]prompb.TimeSeries{ts}))
+			require.NoError(t, err)
+		}
+		require.NoError(t, ingestor.CompleteMetricCreation(ctx))
+		ingestor.Close()
+		_, err = db.Exec(ctx, "ANALYZE")
+		require.NoError(t, err)
+
+		warmUp := func(byActivity bool) (*cache.MetricNameCache, cache.SeriesCache) {
+			metricCache := cache.NewMetricCache(cache.DefaultConfig)
+			seriesCache := cache.NewSeriesCache(cache.DefaultConfig, nil)
+			ing, err := ingstr.NewPgxIngestor(pgxconn.NewPgxConn(db), metricCache, seriesCache, nil, &ingstr.Cfg{
+				InvertedLabelsCacheSize: cache.DefaultConfig.InvertedLabelsCacheSize,
+				NumCopiers:              1,
+				WarmUpSeries:            2,
+				WarmUpByActivity:        byActivity,
+				WarmUpTimeout:           time.Minute,
+			})
+			require.NoError(t, err)
+			defer ing.Close()
+			require.Eventually(t, ing.WarmedUp, time.Minute, 100*time.Millisecond)
+			require.Equal(t, 2, seriesCache.Len())
+			return metricCache, seriesCache
+		}
+		requireWarmedUp := func(metricCache *cache.MetricNameCache, seriesCache cache.SeriesCache, warm, cold []string) {
+			for _, metric := range warm {
+				_, err := metricCache.Get(schema.PromData, metric, false)
+				require.NoError(t, err, metric)
+				s, _, err := seriesCache.GetSeriesFromProtos(series(metric, 0).Labels)
+				require.NoError(t, err)
+				require.True(t, s.IsSeriesIDSet(), metric)
+			}
+			for _, metric := range cold {
+				_, err := metricCache.Get(schema.PromData, metric, false)
+				require.Error(t, err, metric)
+			}
+		}
+
+		// The busiest series are ranked first, ahead of the newer idle ones.
+		metricCache, seriesCache := warmUp(true)
+		requireWarmedUp(metricCache, seriesCache, []string{"warm_up_busy", "warm_up_quiet"}, []string{"warm_up_idle_1", "warm_up_idle_2"})
+		// Without the activity, the most recently created series are loaded.
+		metricCache, seriesCache = warmUp(false)
+		requireWarmedUp(metricCache, seriesCache, []string{"warm_up_idle_1", "warm_up_idle_2"}, []string{"warm_up_busy", "warm_up_quiet"})
+	})
+}
@@ -212,7 +212,7 @@ WITH (timescaledb.continuous) AS
 		lCache := clockcache.WithMax(100)
 		dbConn := pgxconn.NewPgxConn(readOnly)
 		labelsReader := lreader.NewLabelsReader(dbConn, lCache, noopReadAuthorizer)
-		r := querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil)
+		r := querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil, nil, nil)
 		queryable := query.NewQueryable(r, labelsReader)
 		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, nil)
 		if err != nil {
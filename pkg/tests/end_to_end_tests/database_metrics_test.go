@@ -26,7 +26,7 @@ func TestDatabaseMetrics(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		dbMetrics := database.NewEngine(ctx, pgxconn.NewPgxConn(db))
+		dbMetrics := database.NewEngine(ctx, pgxconn.NewPgxConn(db), database.Config{})
 
 		// Before updating the metrics.
 		compressionStatus := getMetricValue(t, "compression_status")
@@ -111,7 +111,7 @@ func TestDatabaseMetricsAfterCompression(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		dbMetrics := database.NewEngine(ctx, pgxconn.NewPgxConn(db))
+		dbMetrics := database.NewEngine(ctx, pgxconn.NewPgxConn(db), database.Config{})
 
 		// Update the metrics.
 		require.NoError(t, dbMetrics.Update())
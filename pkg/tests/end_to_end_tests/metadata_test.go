@@ -6,6 +6,7 @@ package end_to_end_tests
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -87,7 +88,7 @@ func TestFetchMetricMetadataAPI(t *testing.T) {
 		db = testhelpers.PgxPoolWithRole(t, *testDatabase, "prom_reader")
 		defer db.Close()
 
-		result, err := metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), "", 0)
+		result, err := metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), "", 0, 0)
 		require.NoError(t, err)
 		expected := getExpectedMap(metadata)
 		for metric, md := range result {
@@ -97,7 +98,7 @@ func TestFetchMetricMetadataAPI(t *testing.T) {
 		}
 
 		// -- fetch metadata with metric_name --
-		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), metadata[0].MetricFamilyName, 0)
+		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), metadata[0].MetricFamilyName, 0, 0)
 		require.NoError(t, err)
 		expected = getExpectedMap(metadata[:1])
 		for metric, md := range result {
@@ -107,12 +108,12 @@ func TestFetchMetricMetadataAPI(t *testing.T) {
 		}
 
 		// -- fetch metadata with limit --
-		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), "", 5)
+		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), "", 5, 0)
 		require.NoError(t, err)
 		require.Equal(t, 5, len(result))
 
 		// -- fetch metadata with both limit and metric_name --
-		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), metadata[0].MetricFamilyName, 1)
+		result, err = metadataAPI.MetricQuery(ctx, pgxconn.NewPgxConn(db), metadata[0].MetricFamilyName, 1, 0)
 		require.NoError(t, err)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(result))
@@ -126,7 +127,7 @@ func getExpectedMap(m []prompb.MetricMetadata) map[string][]model.Metadata {
 	for i := range m {
 		result[m[i].MetricFamilyName] = append(result[m[i].MetricFamilyName], model.Metadata{
 			Unit: m[i].Unit,
-			Type: m[i].Type.String(),
+			Type: strings.ToLower(m[i].Type.String()),
 			Help: m[i].Help,
 		})
 	}
@@ -12,6 +12,7 @@ import (
 	"github.com/blang/semver/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
 
 	"github.com/timescale/promscale/pkg/internal/testhelpers"
 	"github.com/timescale/promscale/pkg/pgclient"
@@ -372,3 +373,45 @@ func TestMigrationLib(t *testing.T) {
 		verifyLogs(t, db, expected)
 	})
 }
+
+func TestMigrationLibStaged(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	testhelpers.WithDB(t, *testDatabase, testhelpers.NoSuperuser, false, testOptions, func(db *pgxpool.Pool, t testing.TB, connectURL string) {
+		testTOC := map[string][]string{
+			"idempotent": {
+				"2-toc-run_first.sql",
+				"1-toc-run_second.sql",
+			},
+		}
+
+		c, err := db.Acquire(context.Background())
+		require.NoError(t, err)
+		defer c.Release()
+		mig := pgmodel.NewMigrator(c.Conn(), test_migrations.MigrationFiles, testTOC)
+
+		// The schema cannot be installed in stages.
+		require.Error(t, mig.MigrateUntil(semver.MustParse("0.2.0")))
+
+		require.NoError(t, mig.Migrate(semver.MustParse("0.1.1")))
+		expected := []string{"setup", "idempotent 1", "idempotent 2"}
+		verifyLogs(t, db, expected)
+
+		plan, err := mig.Plan(semver.MustParse("0.9.0"), false)
+		require.NoError(t, err)
+		require.Equal(t, semver.MustParse("0.1.1"), plan.From)
+		require.Len(t, plan.Steps, 2)
+		require.Equal(t, "log", plan.Steps[0].Locks[0].Table)
+		verifyLogs(t, db, expected)
+
+		// Staged migrations skip the idempotent files.
+		require.NoError(t, mig.MigrateUntil(semver.MustParse("0.9.0")))
+		expected = append(expected, "migration 0.2.0", "migration 0.9.0")
+		verifyLogs(t, db, expected)
+
+		require.NoError(t, mig.Migrate(semver.MustParse("0.10.0")))
+		expected = append(expected, "migration 0.10.0=1", "migration 0.10.0=2", "idempotent 1", "idempotent 2")
+		verifyLogs(t, db, expected)
+	})
+}
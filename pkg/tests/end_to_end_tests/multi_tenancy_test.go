@@ -56,7 +56,7 @@ func TestMultiTenancyWithoutValidTenants(t *testing.T) {
 		lCache := clockcache.WithMax(100)
 		dbConn := pgxconn.NewPgxConn(db)
 		labelsReader := lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		// ----- query-test: querying a single tenant (tenant-a) -----
 		expectedResult := []prompb.TimeSeries{
@@ -256,7 +256,7 @@ func TestMultiTenancyWithValidTenants(t *testing.T) {
 		lCache := clockcache.WithMax(100)
 		dbConn := pgxconn.NewPgxConn(db)
 		labelsReader := lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		// ----- query-test: querying a valid tenant (tenant-a) -----
 		expectedResult := []prompb.TimeSeries{
@@ -382,7 +382,7 @@ func TestMultiTenancyWithValidTenants(t *testing.T) {
 		require.NoError(t, err)
 
 		labelsReader = lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr = querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr = querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		expectedResult = []prompb.TimeSeries{}
 
@@ -461,7 +461,7 @@ func TestMultiTenancyWithValidTenantsAndNonTenantOps(t *testing.T) {
 		lCache := clockcache.WithMax(100)
 		dbConn := pgxconn.NewPgxConn(db)
 		labelsReader := lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		// ----- query-test: querying a non-tenant -----
 		expectedResult := []prompb.TimeSeries{
@@ -550,7 +550,7 @@ func TestMultiTenancyWithValidTenantsAndNonTenantOps(t *testing.T) {
 		require.NoError(t, err)
 
 		labelsReader = lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr = querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr = querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		expectedResult = []prompb.TimeSeries{
 			{
@@ -659,7 +659,7 @@ func TestMultiTenancyWithValidTenantsAsLabels(t *testing.T) {
 		lCache := clockcache.WithMax(100)
 		dbConn := pgxconn.NewPgxConn(db)
 		labelsReader := lreader.NewLabelsReader(dbConn, lCache, mt.ReadAuthorizer())
-		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer())
+		qr := querier.NewQuerier(dbConn, mCache, labelsReader, nil, mt.ReadAuthorizer(), nil, nil)
 
 		// ----- query-test: querying a single tenant (tenant-b) -----
 		expectedResult := []prompb.TimeSeries{
@@ -0,0 +1,114 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package end_to_end_tests
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/clockcache"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgmodel/cache"
+	"github.com/timescale/promscale/pkg/pgmodel/encoding"
+	ingstr "github.com/timescale/promscale/pkg/pgmodel/ingestor"
+	"github.com/timescale/promscale/pkg/pgmodel/lreader"
+	pgmodel "github.com/timescale/promscale/pkg/pgmodel/model"
+	"github.com/timescale/promscale/pkg/pgmodel/querier"
+	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/prompb"
+	"github.com/timescale/promscale/pkg/query"
+)
+
+// TestStaleSeries checks that a series ends at its staleness marker and that
+// the range functions skip the marker, both for the metrics stored as double
+// precision, where the marker is a NaN, and for those stored with an
+// alternate encoding, where it is NULL.
+func TestStaleSeries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	encodingsFile := filepath.Join(t.TempDir(), "encodings.yaml")
+	require.NoError(t, os.WriteFile(encodingsFile, []byte("encodings:\n  - metrics: stale_real\n    encoding: real\n"), 0600))
+	encodings, err := encoding.NewResolver(&encoding.Config{EncodingsFile: encodingsFile})
+	require.NoError(t, err)
+
+	withDB(t, *testDatabase, func(db *pgxpool.Pool, t testing.TB) {
+		ctx := context.Background()
+		ingestor, err := ingstr.NewPgxIngestorForTests(pgxconn.NewPgxConn(db), &ingstr.Cfg{
+			InvertedLabelsCacheSize: cache.DefaultConfig.InvertedLabelsCacheSize,
+			NumCopiers:              1,
+			ValueEncodings:          encodings,
+		})
+		require.NoError(t, err)
+		defer ingestor.Close()
+
+		metrics := []string{"stale_double", "stale_real"}
+		for _, metric := range metrics {
+			ts := prompb.TimeSeries{
+				Labels: []prompb.Label{{Name: pgmodel.MetricNameLabelName, Value: metric}, {Name: "job", Value: "api"}},
+				Samples: []prompb.Sample{
+					{Timestamp: startTime + 10000, Value: 1},
+					{Timestamp: startTime + 20000, Value: 2},
+					{Timestamp: startTime + 30000, Value: math.Float64frombits(value.StaleNaN)},
+					{Timestamp: startTime + 60000, Value: 4},
+				},
+			}
+			_, _, err = ingestor.IngestMetrics(ctx, newWriteRequestWithTs([]prompb.TimeSeries{ts}))
+			require.NoError(t, err)
+		}
+
+		// The simple protocol, used without the statements cache, sends the
+		// parameters as text, which has no staleness marker.
+		simpleConfig := db.Config()
+		simpleConfig.ConnConfig.PreferSimpleProtocol = true
+		simple, err := pgxpool.ConnectConfig(ctx, simpleConfig)
+		require.NoError(t, err)
+		defer simple.Close()
+
+		queryEngine, err := query.NewEngine(log.GetLogger(), time.Minute, time.Minute*5, time.Minute, 50000000, nil)
+		require.NoError(t, err)
+		for _, pool := range []*pgxpool.Pool{db, simple} {
+			dbConn := pgxconn.NewPgxConn(pool)
+			labelsReader := lreader.NewLabelsReader(dbConn, clockcache.WithMax(100), noopReadAuthorizer)
+			mCache := &cache.MetricNameCache{Metrics: clockcache.WithMax(cache.DefaultMetricCacheSize)}
+			queryable := query.NewQueryable(querier.NewQuerier(dbConn, mCache, labelsReader, nil, nil, nil, nil), labelsReader)
+
+			for _, metric := range metrics {
+				// The series has no value from its marker until its next sample.
+				qry, err := queryEngine.NewRangeQuery(queryable, nil, metric, model.Time(startTime+10000).Time(), model.Time(startTime+60000).Time(), 10*time.Second)
+				require.NoError(t, err)
+				res := qry.Exec(ctx)
+				require.NoError(t, res.Err, metric)
+				mat, err := res.Matrix()
+				require.NoError(t, err)
+				require.Len(t, mat, 1, metric)
+				var times []int64
+				for _, p := range mat[0].Points {
+					times = append(times, p.T)
+				}
+				require.Equal(t, []int64{startTime + 10000, startTime + 20000, startTime + 60000}, times, metric)
+
+				// The delta is extrapolated from the samples 1, 2 and 4, a
+				// marker would make it NaN.
+				qry, err = queryEngine.NewInstantQuery(queryable, nil, "delta("+metric+"[1m])", model.Time(startTime+60000).Time())
+				require.NoError(t, err)
+				res = qry.Exec(ctx)
+				require.NoError(t, res.Err, metric)
+				vec, err := res.Vector()
+				require.NoError(t, err)
+				require.Len(t, vec, 1, metric)
+				require.InDelta(t, 3.6, vec[0].V, 0.001, metric)
+			}
+		}
+	})
+}
@@ -83,7 +83,7 @@ func testVacuum(t *testing.T, do func(e *vacuum.Engine, db *pgxpool.Pool)) {
 		compressChunks(t, ctx, db, uncompressed)
 		compressed := view(t, ctx, db)
 		require.Equal(t, count, len(compressed), "Expected to find %d compressed chunks but got %d", count, len(compressed))
-		engine := vacuum.NewEngine(pgxconn.NewPgxConn(db), time.Second, count)
+		engine := vacuum.NewEngine(pgxconn.NewPgxConn(db), vacuum.Config{RunFrequency: time.Second, Parallelism: count})
 		do(engine, db)
 		viewEmpty(t, ctx, db)
 	})
@@ -35,6 +35,7 @@ var schemas = []string{
 	"_prom_catalog",
 	"_prom_ext",
 	"_ps_catalog",
+	"_ps_log",
 	"_ps_trace",
 	"_timescaledb_cache",
 	"_timescaledb_catalog",
@@ -60,6 +61,7 @@ var schemasWOTimescaleDB = []string{
 	"_prom_catalog",
 	"_prom_ext",
 	"_ps_catalog",
+	"_ps_log",
 	"_ps_trace",
 	"information_schema",
 	"pg_catalog",
@@ -81,6 +83,7 @@ var ourSchemas = []string{
 	"_prom_catalog",
 	"_prom_ext",
 	"_ps_catalog",
+	"_ps_log",
 	"_ps_trace",
 	"prom_api",
 	"prom_data",
@@ -0,0 +1,39 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package thanos
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/timescale/promscale/pkg/relabel"
+)
+
+// Config holds the Thanos StoreAPI flags.
+type Config struct {
+	externalLabelsStr string
+	// ExternalLabels are advertised to Thanos Query and added to all the
+	// series returned by the StoreAPI.
+	ExternalLabels labels.Labels
+}
+
+// ParseFlags registers the Thanos StoreAPI flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.StringVar(&cfg.externalLabelsStr, "thanos.store-api.external-labels", "", "Comma separated list of name=value labels identifying this Promscale in Thanos, e.g. 'cluster=eu1,replica=a'. "+
+		"They are advertised to Thanos Query and added to all the series returned by the Thanos StoreAPI.")
+	return cfg
+}
+
+// Validate parses the external labels.
+func Validate(cfg *Config) error {
+	lset, err := relabel.ParseLabelSet(cfg.externalLabelsStr)
+	if err != nil {
+		return fmt.Errorf("invalid thanos.store-api.external-labels: %w", err)
+	}
+	cfg.ExternalLabels = lset
+	return nil
+}
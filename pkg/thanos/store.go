@@ -1,38 +1,116 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package thanos implements the Thanos StoreAPI, so that Promscale can be
+// added as a store to Thanos Query.
 package thanos
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
 	"github.com/timescale/promscale/pkg/promql"
 )
 
+const (
+	// maxSamplesPerChunk is the number of samples of the chunks sent to
+	// Thanos, like the chunks of the Prometheus TSDB.
+	maxSamplesPerChunk = 120
+
+	// retentionRefresh is how often the advertised time range is refreshed.
+	// Thanos Query requests it every few seconds.
+	retentionRefresh = time.Minute
+
+	// The longest retention of the metrics bounds the time range of the data.
+	maxRetentionSQL = `SELECT extract(epoch FROM greatest(
+	_prom_catalog.get_default_retention_period(),
+	(SELECT max(retention_period) FROM _prom_catalog.metric)
+))::float8`
+)
+
 type Storage struct {
-	queryable promql.Queryable
+	queryable      promql.Queryable
+	conn           pgxconn.PgxConn
+	externalLabels labels.Labels
+
+	mu              sync.Mutex
+	retention       time.Duration
+	retentionLoaded time.Time
 }
 
-func NewStorage(queryable promql.Queryable) *Storage {
+// NewStorage returns the StoreAPI server reading through queryable. The
+// time range is advertised based on the retention read from conn.
+func NewStorage(queryable promql.Queryable, conn pgxconn.PgxConn, cfg Config) *Storage {
 	return &Storage{
-		queryable: queryable,
+		queryable:      queryable,
+		conn:           conn,
+		externalLabels: cfg.ExternalLabels,
 	}
 }
 
 func (fc *Storage) Info(ctx context.Context, req *storepb.InfoRequest) (*storepb.InfoResponse, error) {
-	return &storepb.InfoResponse{
-		MinTime:   0,
-		MaxTime:   time.Now().UnixNano() / 1000,
+	// The data older than the retention is dropped, while recent data keeps
+	// being written.
+	minTime := int64(math.MinInt64)
+	retention, err := fc.maxRetention(ctx)
+	if err != nil {
+		log.Warn("msg", "Could not read the retention for the Thanos StoreAPI, advertising all times", "err", err)
+	} else {
+		minTime = timestamp.FromTime(time.Now().Add(-retention))
+	}
+
+	resp := &storepb.InfoResponse{
+		Labels:    labelpb.ZLabelsFromPromLabels(fc.externalLabels),
+		MinTime:   minTime,
+		MaxTime:   math.MaxInt64,
 		StoreType: storepb.StoreType_STORE,
-	}, nil
+	}
+	if len(fc.externalLabels) > 0 {
+		resp.LabelSets = []labelpb.ZLabelSet{{Labels: labelpb.ZLabelsFromPromLabels(fc.externalLabels)}}
+	}
+	return resp, nil
+}
+
+// maxRetention returns the longest retention of the metrics, read at most
+// once every retentionRefresh.
+func (fc *Storage) maxRetention(ctx context.Context) (time.Duration, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if !fc.retentionLoaded.IsZero() && time.Since(fc.retentionLoaded) < retentionRefresh {
+		return fc.retention, nil
+	}
+	var seconds float64
+	if err := fc.conn.QueryRow(ctx, maxRetentionSQL).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	fc.retention = time.Duration(seconds * float64(time.Second))
+	fc.retentionLoaded = time.Now()
+	return fc.retention, nil
 }
 
 func (fc *Storage) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
-	matchers, err := getMatchers(req.Matchers)
+	matchers, ok, err := fc.matchers(req.Matchers)
 	if err != nil {
-		return err
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !ok {
+		return nil
 	}
 
 	q, err := fc.queryable.SamplesQuerier(srv.Context(), req.MinTime, req.MaxTime)
@@ -41,70 +119,116 @@ func (fc *Storage) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 	}
 	defer q.Close()
 
-	ss, _ := q.Select(false, nil, nil, nil, matchers...)
+	hints := &storage.SelectHints{Start: req.MinTime, End: req.MaxTime}
+	ss, _ := q.Select(true, hints, nil, nil, matchers...)
 
+	// Thanos expects the series sorted by labels, and the external labels
+	// can change the order.
+	var buffered []storepb.Series
 	for ss.Next() {
 		series := ss.At()
-		lbls := series.Labels()
-
-		s := storepb.Series{Labels: make([]labelpb.ZLabel, 0, len(lbls))}
-		for _, lbl := range lbls {
-			s.Labels = append(s.Labels, labelpb.ZLabel(lbl))
+		lset := fc.withExternalLabels(series.Labels())
+		s := storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lset)}
+		if !req.SkipChunks {
+			chunks, err := encodeChunks(series.Iterator())
+			if err != nil {
+				return err
+			}
+			if len(chunks) == 0 {
+				continue
+			}
+			s.Chunks = chunks
 		}
-
-		chunk := chunkenc.NewXORChunk()
-		appender, err := chunk.Appender()
-		if err != nil {
+		if len(fc.externalLabels) > 0 {
+			buffered = append(buffered, s)
+			continue
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(&s)); err != nil {
 			return err
 		}
+	}
+	if err := ss.Err(); err != nil {
+		return err
+	}
 
-		var minTime, maxTime int64
-
-		si := series.Iterator()
-
-		for si.Next() {
-			t, v := si.At()
-			if minTime == 0 {
-				minTime = t
-			}
-			maxTime = t
-
-			appender.Append(t, v)
+	sort.Slice(buffered, func(i, j int) bool {
+		return labels.Compare(labelpb.ZLabelsToPromLabels(buffered[i].Labels), labelpb.ZLabelsToPromLabels(buffered[j].Labels)) < 0
+	})
+	for i := range buffered {
+		if err := srv.Send(storepb.NewSeriesResponse(&buffered[i])); err != nil {
+			return err
 		}
-
-		s.Chunks = append(
-			s.Chunks,
-			storepb.AggrChunk{
-				MinTime: minTime,
-				MaxTime: maxTime,
-				Raw: &storepb.Chunk{
-					Type: storepb.Chunk_Encoding(chunk.Encoding() - 1),
-					Data: chunk.Bytes(),
-				},
-			},
-		)
-
-		seriesResponse := storepb.NewSeriesResponse(&s)
-
-		if err := srv.Send(seriesResponse); err != nil {
+	}
+	for _, w := range ss.Warnings() {
+		if err := srv.Send(storepb.NewWarnSeriesResponse(w)); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// encodeChunks encodes the samples of the iterator as XOR chunks.
+func encodeChunks(it chunkenc.Iterator) ([]storepb.AggrChunk, error) {
+	var (
+		chunks     []storepb.AggrChunk
+		chunk      *chunkenc.XORChunk
+		appender   chunkenc.Appender
+		minT, maxT int64
+	)
+	flush := func() {
+		chunks = append(chunks, storepb.AggrChunk{
+			MinTime: minT,
+			MaxTime: maxT,
+			Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: chunk.Bytes()},
+		})
+	}
+	for it.Next() {
+		t, v := it.At()
+		if chunk == nil || chunk.NumSamples() >= maxSamplesPerChunk {
+			if chunk != nil {
+				flush()
+			}
+			chunk = chunkenc.NewXORChunk()
+			var err error
+			if appender, err = chunk.Appender(); err != nil {
+				return nil, err
+			}
+			minT = t
+		}
+		appender.Append(t, v)
+		maxT = t
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if chunk != nil {
+		flush()
+	}
+	return chunks, nil
+}
+
 func (fc *Storage) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	matchers, ok, err := fc.matchers(req.Matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !ok {
+		return &storepb.LabelNamesResponse{}, nil
+	}
+
 	q, err := fc.queryable.SamplesQuerier(ctx, req.Start, req.End)
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
-	names, warnings, err := q.LabelNames()
+	names, warnings, err := q.LabelNames(matchers...)
 	if err != nil {
 		return nil, err
 	}
+	if len(fc.externalLabels) > 0 {
+		names = mergeNames(names, fc.externalLabels)
+	}
 
 	resp := &storepb.LabelNamesResponse{
 		Names: names,
@@ -118,13 +242,24 @@ func (fc *Storage) LabelNames(ctx context.Context, req *storepb.LabelNamesReques
 }
 
 func (fc *Storage) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	matchers, ok, err := fc.matchers(req.Matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !ok {
+		return &storepb.LabelValuesResponse{}, nil
+	}
+	if v := fc.externalLabels.Get(req.Label); v != "" {
+		return &storepb.LabelValuesResponse{Values: []string{v}}, nil
+	}
+
 	q, err := fc.queryable.SamplesQuerier(ctx, req.Start, req.End)
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
-	values, warnings, err := q.LabelValues(req.Label)
+	values, warnings, err := q.LabelValues(req.Label, matchers...)
 	if err != nil {
 		return nil, err
 	}
@@ -140,12 +275,63 @@ func (fc *Storage) LabelValues(ctx context.Context, req *storepb.LabelValuesRequ
 	return resp, nil
 }
 
+// matchers converts the matchers of a request. The matchers of external
+// labels are checked against the external labels instead of being sent to
+// the database, ok is false if one of them does not match.
+func (fc *Storage) matchers(labelMatchers []storepb.LabelMatcher) (matchers []*labels.Matcher, ok bool, err error) {
+	all, err := getMatchers(labelMatchers)
+	if err != nil {
+		return nil, false, err
+	}
+	matchers = make([]*labels.Matcher, 0, len(all))
+	for _, m := range all {
+		if v := fc.externalLabels.Get(m.Name); v != "" {
+			if !m.Matches(v) {
+				return nil, false, nil
+			}
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, true, nil
+}
+
+// withExternalLabels adds the external labels to lset. They take precedence
+// over the labels of the series with the same names.
+func (fc *Storage) withExternalLabels(lset labels.Labels) labels.Labels {
+	if len(fc.externalLabels) == 0 {
+		return lset
+	}
+	b := labels.NewBuilder(lset)
+	for _, l := range fc.externalLabels {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
+
+// mergeNames returns the sorted union of names and the external label names.
+func mergeNames(names []string, external labels.Labels) []string {
+	seen := make(map[string]struct{}, len(names)+len(external))
+	merged := make([]string, 0, len(names)+len(external))
+	for _, n := range names {
+		seen[n] = struct{}{}
+		merged = append(merged, n)
+	}
+	for _, l := range external {
+		if _, ok := seen[l.Name]; !ok {
+			merged = append(merged, l.Name)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
 func getMatchers(labelMatchers []storepb.LabelMatcher) ([]*labels.Matcher, error) {
 	matchers := make([]*labels.Matcher, 0, len(labelMatchers))
 	for _, labelMatcher := range labelMatchers {
 		m, err := labels.NewMatcher(labels.MatchType(labelMatcher.Type), labelMatcher.Name, labelMatcher.Value)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid matcher: %w", err)
 		}
 
 		matchers = append(matchers, m)
@@ -0,0 +1,69 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package thanos
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestMatchers(t *testing.T) {
+	fc := &Storage{externalLabels: labels.FromStrings("cluster", "eu1")}
+
+	matchers, ok, err := fc.matchers([]storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_RE, Name: "cluster", Value: "eu.*"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, matchers, 1)
+	require.Equal(t, "__name__", matchers[0].Name)
+
+	_, ok, err = fc.matchers([]storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "cluster", Value: "us1"}})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = fc.matchers([]storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "job", Value: "("}})
+	require.Error(t, err)
+
+	require.Equal(t, labels.FromStrings("cluster", "eu1", "job", "a"), fc.withExternalLabels(labels.FromStrings("cluster", "local", "job", "a")))
+	require.Equal(t, []string{"cluster", "job"}, mergeNames([]string{"job"}, fc.externalLabels))
+}
+
+func TestEncodeChunks(t *testing.T) {
+	samples := make([]tsdbutil.Sample, 0, 250)
+	for i := 0; i < 250; i++ {
+		samples = append(samples, sample{t: int64(i * 1000), v: float64(i)})
+	}
+	chunks, err := encodeChunks(storage.NewListSeries(labels.FromStrings("job", "a"), samples).Iterator())
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	require.Equal(t, int64(0), chunks[0].MinTime)
+	require.Equal(t, int64(119000), chunks[0].MaxTime)
+	require.Equal(t, int64(240000), chunks[2].MinTime)
+	require.Equal(t, int64(249000), chunks[2].MaxTime)
+
+	chunk, err := chunkenc.FromData(chunkenc.EncXOR, chunks[2].Raw.Data)
+	require.NoError(t, err)
+	require.Equal(t, 10, chunk.NumSamples())
+
+	chunks, err = encodeChunks(storage.NewListSeries(labels.FromStrings("job", "a"), nil).Iterator())
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+}
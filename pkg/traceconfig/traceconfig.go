@@ -0,0 +1,149 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package traceconfig reads and changes the retention period and the
+// compression schedule of the traces of a service, through the ps_trace
+// functions storing them in _ps_trace.service_config. The maintenance jobs
+// apply them.
+package traceconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const (
+	getSettingsSQL = `SELECT extract(epoch FROM ps_trace.get_service_retention_period($1))::float8,
+	coalesce((SELECT c.retention_period IS NOT NULL FROM _ps_trace.service_config c WHERE c.service_name = $1), false),
+	(SELECT extract(epoch FROM c.compress_after)::float8 FROM _ps_trace.service_config c WHERE c.service_name = $1)`
+	listSettingsSQL = `SELECT c.service_name, extract(epoch FROM coalesce(c.retention_period, ps_trace.get_trace_retention_period()))::float8,
+	c.retention_period IS NOT NULL, extract(epoch FROM c.compress_after)::float8
+FROM _ps_trace.service_config c
+ORDER BY c.service_name`
+	setRetentionSQL       = "SELECT ps_trace.set_service_retention_period($1, $2)"
+	resetRetentionSQL     = "SELECT ps_trace.reset_service_retention_period($1)"
+	setCompressAfterSQL   = "SELECT ps_trace.set_service_compress_after($1, $2)"
+	resetCompressAfterSQL = "SELECT ps_trace.reset_service_compress_after($1)"
+)
+
+// Settings are the storage settings of the traces of a service.
+type Settings struct {
+	Service          string  `json:"service"`
+	RetentionSeconds float64 `json:"retentionSeconds"`
+	// RetentionOverride is true if the retention period overrides the
+	// default one of the traces.
+	RetentionOverride bool `json:"retentionOverride"`
+	// CompressAfterSeconds is nil if the traces of the service are not
+	// compressed.
+	CompressAfterSeconds *float64 `json:"compressAfterSeconds"`
+}
+
+// Update is a change of the settings of a service. The nil settings are left
+// as they are, the reset ones use the default settings again.
+type Update struct {
+	Retention          *time.Duration
+	ResetRetention     bool
+	CompressAfter      *time.Duration
+	ResetCompressAfter bool
+}
+
+// Validate checks that the update changes at least one setting and that the
+// new settings are valid.
+func Validate(u Update) error {
+	switch {
+	case u.Retention == nil && !u.ResetRetention && u.CompressAfter == nil && !u.ResetCompressAfter:
+		return fmt.Errorf("no setting to change")
+	case u.Retention != nil && u.ResetRetention:
+		return fmt.Errorf("retention cannot be both set and reset")
+	case u.CompressAfter != nil && u.ResetCompressAfter:
+		return fmt.Errorf("compress_after cannot be both set and reset")
+	case u.Retention != nil && *u.Retention <= 0:
+		return fmt.Errorf("retention must be positive: %s", *u.Retention)
+	case u.CompressAfter != nil && *u.CompressAfter < 0:
+		return fmt.Errorf("compress_after must not be negative: %s", *u.CompressAfter)
+	case u.Retention != nil && u.CompressAfter != nil && *u.Retention <= *u.CompressAfter:
+		return fmt.Errorf("retention must be longer than compress_after")
+	}
+	return nil
+}
+
+// String describes the changed settings, for the logs.
+func (u Update) String() string {
+	var changes []string
+	switch {
+	case u.Retention != nil:
+		changes = append(changes, fmt.Sprintf("retention=%s", *u.Retention))
+	case u.ResetRetention:
+		changes = append(changes, "retention=default")
+	}
+	switch {
+	case u.CompressAfter != nil:
+		changes = append(changes, fmt.Sprintf("compress_after=%s", *u.CompressAfter))
+	case u.ResetCompressAfter:
+		changes = append(changes, "compress_after=default")
+	}
+	return strings.Join(changes, " ")
+}
+
+// Get returns the settings of the service. A service without settings of
+// its own has the default retention period and is not compressed.
+func Get(ctx context.Context, conn pgxconn.PgxConn, service string) (Settings, error) {
+	s := Settings{Service: service}
+	if err := conn.QueryRow(ctx, getSettingsSQL, service).Scan(&s.RetentionSeconds, &s.RetentionOverride, &s.CompressAfterSeconds); err != nil {
+		return s, fmt.Errorf("get settings of service %s: %w", service, err)
+	}
+	return s, nil
+}
+
+// List returns the settings of the services with settings of their own,
+// sorted by service.
+func List(ctx context.Context, conn pgxconn.PgxConn) ([]Settings, error) {
+	rows, err := conn.Query(ctx, listSettingsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("list service settings: %w", err)
+	}
+	defer rows.Close()
+	res := []Settings{}
+	for rows.Next() {
+		var s Settings
+		if err := rows.Scan(&s.Service, &s.RetentionSeconds, &s.RetentionOverride, &s.CompressAfterSeconds); err != nil {
+			return nil, fmt.Errorf("list service settings: %w", err)
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// Apply changes the settings of the service. The settings can be set before
+// the service writes traces. They are changed one after the other, the ones
+// changed before an error are kept.
+func Apply(ctx context.Context, conn pgxconn.PgxConn, service string, u Update) error {
+	exec := func(setting, sql string, args ...interface{}) error {
+		if _, err := conn.Exec(ctx, sql, append([]interface{}{service}, args...)...); err != nil {
+			return fmt.Errorf("setting %s of service %s: %w", setting, service, err)
+		}
+		return nil
+	}
+	var err error
+	switch {
+	case u.Retention != nil:
+		err = exec("retention", setRetentionSQL, *u.Retention)
+	case u.ResetRetention:
+		err = exec("retention", resetRetentionSQL)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.CompressAfter != nil:
+		err = exec("compress_after", setCompressAfterSQL, *u.CompressAfter)
+	case u.ResetCompressAfter:
+		err = exec("compress_after", resetCompressAfterSQL)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package traceconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timescale/promscale/pkg/pgmodel/model"
+)
+
+func TestValidate(t *testing.T) {
+	hour, day, negative := time.Hour, 24*time.Hour, -time.Hour
+	zero := time.Duration(0)
+	require.NoError(t, Validate(Update{Retention: &day}))
+	require.NoError(t, Validate(Update{Retention: &day, CompressAfter: &hour}))
+	require.NoError(t, Validate(Update{CompressAfter: &zero}))
+	require.NoError(t, Validate(Update{ResetRetention: true, ResetCompressAfter: true}))
+
+	require.Error(t, Validate(Update{}))
+	require.Error(t, Validate(Update{Retention: &day, ResetRetention: true}))
+	require.Error(t, Validate(Update{CompressAfter: &hour, ResetCompressAfter: true}))
+	require.Error(t, Validate(Update{Retention: &negative}))
+	require.Error(t, Validate(Update{Retention: &zero}))
+	require.Error(t, Validate(Update{CompressAfter: &negative}))
+	require.Error(t, Validate(Update{Retention: &hour, CompressAfter: &day}))
+}
+
+func TestApply(t *testing.T) {
+	hour, day := time.Hour, 24*time.Hour
+	testCases := []struct {
+		name    string
+		update  Update
+		queries []model.SqlQuery
+		err     bool
+	}{
+		{
+			name:   "set all",
+			update: Update{Retention: &day, CompressAfter: &hour},
+			queries: []model.SqlQuery{
+				{Sql: setRetentionSQL, Args: []interface{}{"gateway", day}},
+				{Sql: setCompressAfterSQL, Args: []interface{}{"gateway", hour}},
+			},
+		},
+		{
+			name:   "reset",
+			update: Update{ResetRetention: true, ResetCompressAfter: true},
+			queries: []model.SqlQuery{
+				{Sql: resetRetentionSQL, Args: []interface{}{"gateway"}},
+				{Sql: resetCompressAfterSQL, Args: []interface{}{"gateway"}},
+			},
+		},
+		{
+			name:   "stops on error",
+			update: Update{Retention: &day, ResetCompressAfter: true},
+			queries: []model.SqlQuery{
+				{Sql: setRetentionSQL, Args: []interface{}{"gateway", day}, Err: fmt.Errorf("some error")},
+			},
+			err: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := model.NewSqlRecorder(c.queries, t)
+			err := Apply(context.Background(), conn, "gateway", c.update)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
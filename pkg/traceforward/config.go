@@ -0,0 +1,99 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package traceforward
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueueSize  = 1000
+	DefaultMaxRetries = 5
+	DefaultTimeout    = 10 * time.Second
+)
+
+// Config holds the trace forwarding flags.
+type Config struct {
+	Endpoints  stringList
+	Insecure   bool
+	Headers    stringList
+	QueueSize  int
+	MaxRetries int
+	Timeout    time.Duration
+}
+
+// stringList is a comma-separated list of values.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// ParseFlags registers the trace forwarding flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var(&cfg.Endpoints, "tracing.forward.endpoints", "Comma-separated list of the host:port addresses of OTLP gRPC receivers the ingested spans are forwarded to, "+
+		"on top of being written to the database. Example: otel-collector:4317. Disabled if empty.")
+	fs.BoolVar(&cfg.Insecure, "tracing.forward.insecure", false, "Forward the spans without TLS.")
+	fs.Var(&cfg.Headers, "tracing.forward.headers", "Comma-separated list of name=value headers sent with the forwarded spans, e.g. the API key of a tracing backend.")
+	fs.IntVar(&cfg.QueueSize, "tracing.forward.queue-size", DefaultQueueSize, "Number of ingest requests queued for each endpoint. The spans of the requests received "+
+		"while the queue is full are not forwarded.")
+	fs.IntVar(&cfg.MaxRetries, "tracing.forward.max-retries", DefaultMaxRetries, "Number of times the spans failing with a retryable error are sent again to an endpoint, "+
+		"with an exponential backoff, before they are dropped.")
+	fs.DurationVar(&cfg.Timeout, "tracing.forward.timeout", DefaultTimeout, "Timeout of each request to an endpoint.")
+	return cfg
+}
+
+// Validate checks the trace forwarding flags.
+func Validate(cfg *Config) error {
+	for _, e := range cfg.Endpoints {
+		if _, _, err := net.SplitHostPort(e); err != nil {
+			return fmt.Errorf("invalid tracing.forward.endpoints address %q, must be host:port: %w", e, err)
+		}
+	}
+	if _, err := parseHeaders(cfg.Headers); err != nil {
+		return err
+	}
+	if cfg.QueueSize <= 0 {
+		return fmt.Errorf("tracing.forward.queue-size must be positive")
+	}
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("tracing.forward.max-retries must not be negative")
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("tracing.forward.timeout must be positive")
+	}
+	return nil
+}
+
+// Enabled returns true if the spans are forwarded.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.Endpoints) > 0
+}
+
+func parseHeaders(headers []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(headers))
+	for _, h := range headers {
+		parts := strings.SplitN(h, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid tracing.forward.headers header %q, must be name=value", h)
+		}
+		// gRPC metadata keys are lowercase.
+		parsed[strings.ToLower(name)] = strings.TrimSpace(parts[1])
+	}
+	return parsed, nil
+}
@@ -0,0 +1,300 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package traceforward tees the ingested spans to other OTLP receivers, e.g.
+// the collector of a tracing backend running alongside Promscale during a
+// migration. Each endpoint has its own queue, so that a slow or unavailable
+// endpoint neither delays the ingest nor the other endpoints.
+package traceforward
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+const (
+	// maxBackoff caps the delay between two retries.
+	maxBackoff = 30 * time.Second
+	// closeTimeout is how long Close waits for the queued spans to be
+	// forwarded before dropping them.
+	closeTimeout = 10 * time.Second
+)
+
+// retryBackoff is the delay before the first retry, doubled on each retry.
+var retryBackoff = time.Second
+
+var (
+	spansForwarded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_forward",
+			Name:      "spans_total",
+			Help:      "Total number of spans forwarded to each endpoint.",
+		}, []string{"endpoint"},
+	)
+	spansFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_forward",
+			Name:      "failed_spans_total",
+			Help:      "Total number of spans that could not be forwarded to each endpoint after all the retries.",
+		}, []string{"endpoint"},
+	)
+	spansDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_forward",
+			Name:      "dropped_spans_total",
+			Help:      "Total number of spans not forwarded to each endpoint because its queue was full.",
+		}, []string{"endpoint"},
+	)
+	retries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_forward",
+			Name:      "retries_total",
+			Help:      "Total number of requests sent again to each endpoint after a retryable error.",
+		}, []string{"endpoint"},
+	)
+	queueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "trace_forward",
+			Name:      "queue_length",
+			Help:      "Number of ingest requests waiting to be forwarded to each endpoint.",
+		}, []string{"endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(spansForwarded, spansFailed, spansDropped, retries, queueLength)
+}
+
+// endpoint is an OTLP receiver the spans are forwarded to.
+type endpoint struct {
+	address string
+	client  ptraceotlp.Client
+	conn    *grpc.ClientConn
+	queue   chan ptrace.Traces
+}
+
+// Forwarder forwards the ingested spans to the endpoints in the background. A
+// nil Forwarder forwards nothing.
+type Forwarder struct {
+	endpoints  []*endpoint
+	headers    metadata.MD
+	maxRetries int
+	timeout    time.Duration
+
+	stop      chan struct{}
+	abort     chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New returns a Forwarder to the configured endpoints, or nil if no endpoint
+// is configured. The connections to the endpoints are established in the
+// background.
+func New(cfg *Config) (*Forwarder, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	creds := credentials.NewTLS(&tls.Config{})
+	if cfg.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	clients := make(map[string]ptraceotlp.Client, len(cfg.Endpoints))
+	conns := make(map[string]*grpc.ClientConn, len(cfg.Endpoints))
+	for _, address := range cfg.Endpoints {
+		conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, fmt.Errorf("connecting to the trace forwarding endpoint %s: %w", address, err)
+		}
+		conns[address] = conn
+		clients[address] = ptraceotlp.NewClient(conn)
+	}
+	f, err := newForwarder(cfg, clients)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range f.endpoints {
+		e.conn = conns[e.address]
+	}
+	return f, nil
+}
+
+func newForwarder(cfg *Config, clients map[string]ptraceotlp.Client) (*Forwarder, error) {
+	headers, err := parseHeaders(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{
+		headers:    metadata.New(headers),
+		maxRetries: cfg.MaxRetries,
+		timeout:    cfg.Timeout,
+		stop:       make(chan struct{}),
+		abort:      make(chan struct{}),
+	}
+	for _, address := range cfg.Endpoints {
+		e := &endpoint{address: address, client: clients[address], queue: make(chan ptrace.Traces, cfg.QueueSize)}
+		f.endpoints = append(f.endpoints, e)
+		f.wg.Add(1)
+		go f.run(e)
+	}
+	return f, nil
+}
+
+// Forward queues the spans to be forwarded to each endpoint. It does not
+// block: the spans are not forwarded to the endpoints whose queue is full.
+// The spans are copied, so that the caller can keep using them.
+func (f *Forwarder) Forward(traces ptrace.Traces) {
+	if f == nil || traces.SpanCount() == 0 {
+		return
+	}
+	select {
+	case <-f.stop:
+		return
+	default:
+	}
+	// The endpoints only read the copy, so they share it.
+	traces = traces.Clone()
+	for _, e := range f.endpoints {
+		select {
+		case <-f.stop:
+			return
+		case e.queue <- traces:
+			queueLength.WithLabelValues(e.address).Inc()
+		default:
+			spansDropped.WithLabelValues(e.address).Add(float64(traces.SpanCount()))
+			log.Warn("msg", "dropping forwarded spans, too many requests waiting to be forwarded", "endpoint", e.address, "spans", traces.SpanCount())
+		}
+	}
+}
+
+func (f *Forwarder) run(e *endpoint) {
+	defer f.wg.Done()
+	for {
+		select {
+		case traces := <-e.queue:
+			f.forward(e, traces)
+		case <-f.stop:
+			// Forward the spans queued before stopping, until Close gives up.
+			for {
+				select {
+				case <-f.abort:
+					return
+				default:
+				}
+				select {
+				case traces := <-e.queue:
+					f.forward(e, traces)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (f *Forwarder) forward(e *endpoint, traces ptrace.Traces) {
+	queueLength.WithLabelValues(e.address).Dec()
+	spans := float64(traces.SpanCount())
+	if err := f.export(e, ptraceotlp.NewRequestFromTraces(traces)); err != nil {
+		spansFailed.WithLabelValues(e.address).Add(spans)
+		log.Warn("msg", "error forwarding spans", "endpoint", e.address, "spans", spans, "err", err)
+		return
+	}
+	spansForwarded.WithLabelValues(e.address).Add(spans)
+}
+
+// export sends the request to the endpoint, retrying on retryable errors.
+func (f *Forwarder) export(e *endpoint, req ptraceotlp.Request) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := f.exportOnce(e, req)
+		if err == nil || attempt == f.maxRetries || !retryable(err) {
+			return err
+		}
+		retries.WithLabelValues(e.address).Inc()
+		select {
+		case <-time.After(backoff):
+		case <-f.abort:
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (f *Forwarder) exportOnce(e *endpoint, req ptraceotlp.Request) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	if len(f.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, f.headers)
+	}
+	_, err := e.client.Export(ctx, req)
+	return err
+}
+
+// retryable returns true if the request can be sent again after the error,
+// following the OTLP specification.
+func retryable(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return true
+	}
+	switch s.Code() {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+		codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// Close forwards the queued spans and stops the Forwarder. The spans not
+// forwarded within closeTimeout are dropped.
+func (f *Forwarder) Close() {
+	if f == nil {
+		return
+	}
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		done := make(chan struct{})
+		go func() {
+			f.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(closeTimeout):
+			close(f.abort)
+			<-done
+		}
+		for _, e := range f.endpoints {
+			if e.conn != nil {
+				_ = e.conn.Close()
+			}
+		}
+	})
+}
@@ -0,0 +1,147 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package traceforward
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClient records the spans it receives, failing with the queued errors
+// first.
+type fakeClient struct {
+	mu      sync.Mutex
+	errs    []error
+	calls   int
+	spans   int
+	headers metadata.MD
+	block   chan struct{}
+}
+
+func (c *fakeClient) Export(ctx context.Context, req ptraceotlp.Request, _ ...grpc.CallOption) (ptraceotlp.Response, error) {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	c.headers, _ = metadata.FromOutgoingContext(ctx)
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return ptraceotlp.NewResponse(), err
+	}
+	c.spans += req.Traces().SpanCount()
+	return ptraceotlp.NewResponse(), nil
+}
+
+func (c *fakeClient) stats() (calls, spans int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls, c.spans
+}
+
+func traces(spans int) ptrace.Traces {
+	t := ptrace.NewTraces()
+	ss := t.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < spans; i++ {
+		ss.AppendEmpty().SetName("op")
+	}
+	return t
+}
+
+func testConfig(endpoints ...string) *Config {
+	return &Config{Endpoints: endpoints, QueueSize: 10, MaxRetries: 2, Timeout: time.Second}
+}
+
+func TestForward(t *testing.T) {
+	retryBackoff = time.Millisecond
+	a := &fakeClient{errs: []error{status.Error(codes.Unavailable, "down")}}
+	b := &fakeClient{errs: []error{status.Error(codes.InvalidArgument, "bad")}}
+	cfg := testConfig("a:4317", "b:4317")
+	cfg.Headers = stringList{"X-API-Key=secret"}
+	f, err := newForwarder(cfg, map[string]ptraceotlp.Client{"a:4317": a, "b:4317": b})
+	require.NoError(t, err)
+
+	f.Forward(traces(3))
+	f.Forward(traces(2))
+	f.Close()
+
+	// The unavailable endpoint is retried, the invalid request is not.
+	calls, spans := a.stats()
+	require.Equal(t, 3, calls)
+	require.Equal(t, 5, spans)
+	calls, spans = b.stats()
+	require.Equal(t, 2, calls)
+	require.Equal(t, 2, spans)
+	require.Equal(t, []string{"secret"}, a.headers.Get("x-api-key"))
+
+	// A closed or nil Forwarder forwards nothing.
+	f.Forward(traces(1))
+	var nilForwarder *Forwarder
+	nilForwarder.Forward(traces(1))
+	nilForwarder.Close()
+}
+
+func TestForwardGivesUp(t *testing.T) {
+	retryBackoff = time.Millisecond
+	unavailable := status.Error(codes.Unavailable, "down")
+	c := &fakeClient{errs: []error{unavailable, unavailable, unavailable, unavailable}}
+	f, err := newForwarder(testConfig("c:4317"), map[string]ptraceotlp.Client{"c:4317": c})
+	require.NoError(t, err)
+	f.Forward(traces(1))
+	f.Close()
+
+	calls, spans := c.stats()
+	require.Equal(t, 3, calls)
+	require.Equal(t, 0, spans)
+}
+
+func TestForwardQueueFull(t *testing.T) {
+	c := &fakeClient{block: make(chan struct{})}
+	cfg := testConfig("c:4317")
+	cfg.QueueSize = 1
+	f, err := newForwarder(cfg, map[string]ptraceotlp.Client{"c:4317": c})
+	require.NoError(t, err)
+
+	// The first request blocks the endpoint, the second is queued and the
+	// others are dropped.
+	f.Forward(traces(1))
+	require.Eventually(t, func() bool { return len(f.endpoints[0].queue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		f.Forward(traces(1))
+	}
+	close(c.block)
+	f.Close()
+
+	_, spans := c.stats()
+	require.Equal(t, 2, spans)
+}
+
+func TestValidate(t *testing.T) {
+	valid := testConfig("collector:4317")
+	require.NoError(t, Validate(valid))
+	require.False(t, (&Config{}).Enabled())
+
+	for _, cfg := range []Config{
+		{Endpoints: stringList{"collector"}, QueueSize: 1, Timeout: time.Second},
+		{Endpoints: stringList{"collector:4317"}, Headers: stringList{"no-value"}, QueueSize: 1, Timeout: time.Second},
+		{Endpoints: stringList{"collector:4317"}, Timeout: time.Second},
+		{Endpoints: stringList{"collector:4317"}, QueueSize: 1},
+	} {
+		cfg := cfg
+		require.Error(t, Validate(&cfg))
+	}
+}
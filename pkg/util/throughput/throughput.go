@@ -31,8 +31,20 @@ func InitWatcher(calculateEvery time.Duration) {
 	})
 }
 
+// SetInterval changes the interval at which the throughput is reported.
+// A zero interval stops reporting until a non-zero interval is set again.
+func SetInterval(every time.Duration) {
+	if throughputWatcher == nil {
+		InitWatcher(every)
+		return
+	}
+	throughputWatcher.setInterval(every)
+}
+
 type throughputCalc struct {
-	every time.Duration
+	mux    sync.Mutex
+	every  time.Duration
+	ticker *time.Ticker
 
 	// Metrics telemetry.
 	samples          *ewma.Rate
@@ -47,6 +59,7 @@ type throughputCalc struct {
 func newThroughputCal(every time.Duration) *throughputCalc {
 	return &throughputCalc{
 		every:            every,
+		ticker:           time.NewTicker(every),
 		metricsMaxSentTs: 0,
 		samples:          ewma.NewEWMARate(1, every),
 		metadata:         ewma.NewEWMARate(1, every),
@@ -55,9 +68,31 @@ func newThroughputCal(every time.Duration) *throughputCalc {
 	}
 }
 
+func (tc *throughputCalc) setInterval(every time.Duration) {
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	if every == tc.every {
+		return
+	}
+	tc.every = every
+	if every == 0 {
+		tc.ticker.Stop()
+		return
+	}
+	tc.samples.SetInterval(every)
+	tc.metadata.SetInterval(every)
+	tc.spans.SetInterval(every)
+	tc.ticker.Reset(every)
+}
+
+func (tc *throughputCalc) interval() time.Duration {
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	return tc.every
+}
+
 func (tc *throughputCalc) run() {
-	t := time.NewTicker(tc.every)
-	for range t.C {
+	for range tc.ticker.C {
 		tc.samples.Tick()
 		tc.metadata.Tick()
 		tc.spans.Tick()
@@ -18,5 +18,19 @@ func TestThroughputWatcher(t *testing.T) {
 
 	// Test report throughput.
 	InitWatcher(time.Second)
-	require.True(t, throughputWatcher.every == time.Second)
+	require.True(t, throughputWatcher.interval() == time.Second)
+}
+
+func TestSetInterval(t *testing.T) {
+	InitWatcher(time.Second)
+	require.Equal(t, time.Second, throughputWatcher.interval())
+
+	SetInterval(time.Minute)
+	require.Equal(t, time.Minute, throughputWatcher.interval())
+
+	// Reporting is paused, but the watcher is kept to be resumed later on.
+	SetInterval(0)
+	require.Equal(t, time.Duration(0), throughputWatcher.interval())
+	SetInterval(time.Second)
+	require.Equal(t, time.Second, throughputWatcher.interval())
 }
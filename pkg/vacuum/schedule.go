@@ -0,0 +1,150 @@
+package vacuum
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Window is a daily time window in UTC. It wraps around midnight if End is
+// before Start.
+type Window struct {
+	// Start and End are durations since midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w Window) String() string {
+	return formatTimeOfDay(w.Start) + "-" + formatTimeOfDay(w.End)
+}
+
+// contains returns whether the time of day of t is within the window.
+func (w Window) contains(t time.Time) bool {
+	t = t.UTC()
+	tod := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.Start <= w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// Windows is a comma-separated list of daily time windows, e.g.
+// 01:00-05:00,22:00-23:30. An empty list allows every time of the day.
+type Windows []Window
+
+func (w *Windows) String() string {
+	s := make([]string, len(*w))
+	for i, window := range *w {
+		s[i] = window.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (w *Windows) Set(s string) error {
+	windows := Windows{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		bounds := strings.Split(v, "-")
+		if len(bounds) != 2 {
+			return fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", v)
+		}
+		start, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return fmt.Errorf("invalid window %q: %w", v, err)
+		}
+		end, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return fmt.Errorf("invalid window %q: %w", v, err)
+		}
+		if start == end {
+			return fmt.Errorf("invalid window %q: start and end must differ", v)
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	*w = windows
+	return nil
+}
+
+// contains returns whether t is within one of the windows.
+func (w Windows) contains(t time.Time) bool {
+	if len(w) == 0 {
+		return true
+	}
+	for _, window := range w {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return day, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// Priorities is a comma-separated list of hypertable=priority pairs, e.g.
+// prom_data.up=10. The chunks of the hypertables with the highest priority
+// are vacuumed first, the priority of the other hypertables is 0.
+type Priorities map[string]int
+
+func (p *Priorities) String() string {
+	pairs := make([]string, 0, len(*p))
+	for hypertable, priority := range *p {
+		pairs = append(pairs, hypertable+"="+strconv.Itoa(priority))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p *Priorities) Set(s string) error {
+	priorities := Priorities{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid priority %q: expected hypertable=priority", pair)
+		}
+		hypertable := strings.TrimSpace(kv[0])
+		if !strings.Contains(hypertable, ".") {
+			return fmt.Errorf("invalid priority %q: the hypertable must be qualified with its schema", pair)
+		}
+		priority, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid priority %q: %w", pair, err)
+		}
+		priorities[hypertable] = int(priority)
+	}
+	*p = priorities
+	return nil
+}
+
+// arrays returns the hypertables and their priorities as SQL parameters.
+func (p Priorities) arrays() ([]string, []int32) {
+	hypertables := make([]string, 0, len(p))
+	priorities := make([]int32, 0, len(p))
+	for hypertable, priority := range p {
+		hypertables = append(hypertables, hypertable)
+		priorities = append(priorities, int32(priority))
+	}
+	return hypertables, priorities
+}
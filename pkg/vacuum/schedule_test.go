@@ -0,0 +1,55 @@
+package vacuum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindows(t *testing.T) {
+	var w Windows
+	require.NoError(t, w.Set("01:00-05:00, 22:30-00:30"))
+	require.Equal(t, "01:00-05:00,22:30-00:30", w.String())
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 3, 4, hour, min, 0, 0, time.UTC)
+	}
+	require.True(t, w.contains(at(1, 0)))
+	require.True(t, w.contains(at(4, 59)))
+	require.False(t, w.contains(at(5, 0)))
+	require.False(t, w.contains(at(12, 0)))
+	require.True(t, w.contains(at(23, 0)))
+	require.True(t, w.contains(at(0, 15)))
+	require.False(t, w.contains(at(0, 30)))
+	// The windows are in UTC.
+	require.True(t, w.contains(at(2, 0).In(time.FixedZone("UTC+8", 8*3600))))
+
+	require.NoError(t, w.Set("20:00-24:00"))
+	require.True(t, w.contains(at(23, 59)))
+	require.False(t, w.contains(at(0, 0)))
+
+	require.NoError(t, w.Set(""))
+	require.True(t, w.contains(at(12, 0)), "no window allows every time")
+
+	for _, invalid := range []string{"01:00", "01:00-01:00", "1-5", "01:00-25:00", "01:00-02:00-03:00"} {
+		require.Error(t, w.Set(invalid), invalid)
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	var p Priorities
+	require.NoError(t, p.Set("prom_data.up=10, prom_data.http_requests_total=-1"))
+	require.Equal(t, Priorities{"prom_data.up": 10, "prom_data.http_requests_total": -1}, p)
+	require.Equal(t, "prom_data.http_requests_total=-1,prom_data.up=10", p.String())
+
+	hypertables, priorities := p.arrays()
+	require.Len(t, hypertables, 2)
+	for i, hypertable := range hypertables {
+		require.Equal(t, p[hypertable], int(priorities[i]))
+	}
+
+	for _, invalid := range []string{"prom_data.up", "up=10", "prom_data.up=high", "prom_data.up=99999999999"} {
+		require.Error(t, p.Set(invalid), invalid)
+	}
+}
@@ -2,19 +2,42 @@ package vacuum
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/timescale/promscale/pkg/log"
 	"github.com/timescale/promscale/pkg/pgxconn"
+	"github.com/timescale/promscale/pkg/util"
+	"go.uber.org/atomic"
 )
 
 const (
-	sqlAcquireLock      = "SELECT _prom_catalog.lock_for_vacuum_engine()"
-	sqlListChunks       = "SELECT format('%I.%I', schema_name, table_name) FROM _ps_catalog.chunks_to_freeze WHERE coalesce(last_vacuum, '-infinity'::timestamptz) < now() - interval '15 minutes' LIMIT 1000"
+	sqlAcquireLock = "SELECT _prom_catalog.lock_for_vacuum_engine()"
+	// The chunks to freeze are compressed chunks, their hypertable is the
+	// hypertable of the chunk they hold the compressed data of.
+	sqlListChunks = `SELECT format('%I.%I', f.schema_name, f.table_name)
+FROM _ps_catalog.chunks_to_freeze f
+LEFT JOIN _timescaledb_catalog.chunk c ON (c.compressed_chunk_id = f.id)
+LEFT JOIN _timescaledb_catalog.hypertable h ON (h.id = c.hypertable_id)
+LEFT JOIN unnest($1::text[], $2::int[]) AS p(hypertable, priority) ON (p.hypertable = h.schema_name || '.' || h.table_name)
+WHERE coalesce(f.last_vacuum, '-infinity'::timestamptz) < now() - interval '15 minutes'
+AND (cardinality($3::text[]) = 0 OR h.schema_name || '.' || h.table_name = ANY($3::text[]))
+ORDER BY coalesce(p.priority, 0) DESC
+LIMIT 1000`
+	// Only the chunks that ended more than an hour ago are compressed, like
+	// the compression policy of the metrics.
+	sqlListUncompressedChunks = `SELECT format('%I.%I', c.chunk_schema, c.chunk_name)
+FROM timescaledb_information.chunks c
+INNER JOIN timescaledb_information.hypertables h USING (hypertable_schema, hypertable_name)
+WHERE h.compression_enabled AND NOT c.is_compressed AND c.range_end < now() - interval '1 hour'
+AND c.hypertable_schema || '.' || c.hypertable_name = ANY($1::text[])
+ORDER BY c.range_end`
+	sqlCompress         = "SELECT public.compress_chunk($1::regclass, if_not_compressed => true)"
 	sqlVacuumFmt        = "VACUUM (FREEZE, ANALYZE) %s"
 	sqlReleaseLock      = "SELECT _prom_catalog.unlock_for_vacuum_engine()"
 	delay               = 10 * time.Second
@@ -24,16 +47,65 @@ const (
 	minParallelism      = 1
 )
 
+// ErrRunning is returned when a manual run is requested while the engine
+// is already running.
+var ErrRunning = errors.New("the vacuum engine is already running")
+
+var (
+	chunksPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "vacuum",
+			Name:      "chunks_pending",
+			Help:      "Number of chunks left to compress or vacuum in the current batch of the vacuum engine.",
+		},
+	)
+	chunksProcessed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "vacuum",
+			Name:      "chunks_total",
+			Help:      "Total number of chunks processed by the vacuum engine, by operation (compress or vacuum) and result (success or error).",
+		}, []string{"operation", "result"},
+	)
+	running = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "vacuum",
+			Name:      "running",
+			Help:      "Whether the vacuum engine is running, by trigger (schedule or manual).",
+		}, []string{"trigger"},
+	)
+	lastRun = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "vacuum",
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix timestamp of the last completed run of the vacuum engine.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(chunksPending, chunksProcessed, running, lastRun)
+}
+
 type Config struct {
 	Disable      bool
 	RunFrequency time.Duration
 	Parallelism  int
+	Windows      Windows
+	Priorities   Priorities
 }
 
 func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
 	fs.BoolVar(&cfg.Disable, "vacuum.disable", defaultDisable, "disables the vacuum engine")
 	fs.DurationVar(&cfg.RunFrequency, "vacuum.run-frequency", defaultRunFrequency, "how often should the vacuum engine run")
 	fs.IntVar(&cfg.Parallelism, "vacuum.parallelism", defaultParallelism, "how many goroutines/connections should be used to vacuum")
+	fs.Var(&cfg.Windows, "vacuum.windows", "comma-separated list of daily time windows in UTC during which the scheduled runs of the vacuum engine happen, e.g. 01:00-05:00. "+
+		"A run stops after the batch in progress when its window ends. Runs at any time if empty")
+	fs.Var(&cfg.Priorities, "vacuum.priorities", "comma-separated list of hypertable=priority pairs, e.g. prom_data.up=10. "+
+		"The chunks of the hypertables with the highest priority are vacuumed first, the priority of the other hypertables is 0")
 	return cfg
 }
 
@@ -50,24 +122,54 @@ func Validate(cfg *Config) error {
 	return nil
 }
 
+// Request selects the work of a manual run of the vacuum engine.
+type Request struct {
+	// Hypertables restricts the run to the chunks of these hypertables,
+	// qualified with their schema. All the chunks are vacuumed if empty.
+	Hypertables []string
+	// Compress compresses the chunks of the hypertables that ended more
+	// than an hour ago before vacuuming them.
+	Compress bool
+}
+
 // Engine periodically vacuums compressed chunks
 type Engine struct {
 	runFreq     time.Duration
 	pool        pgxconn.PgxConn
 	parallelism int
-	mu          sync.Mutex
-	kill        func()
+	windows     Windows
+	priorities  Priorities
+	// isLeader returns whether this instance runs the scheduled runs.
+	isLeader func() bool
+	// running is set while the engine runs in this process.
+	running atomic.Bool
+	// ctx is cancelled by Stop, to stop the manual runs.
+	ctx    context.Context
+	cancel func()
+	mu     sync.Mutex
+	kill   func()
 }
 
 // NewEngine creates a new Engine
-func NewEngine(pool pgxconn.PgxConn, runFreq time.Duration, parallelism int) *Engine {
+func NewEngine(pool pgxconn.PgxConn, cfg Config) *Engine {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Engine{
-		runFreq:     runFreq,
+		runFreq:     cfg.RunFrequency,
 		pool:        pool,
-		parallelism: parallelism,
+		parallelism: cfg.Parallelism,
+		windows:     cfg.Windows,
+		priorities:  cfg.Priorities,
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
+// WithLeaderElection restricts the scheduled runs to the instance for which
+// isLeader returns true. It must be called before Start.
+func (e *Engine) WithLeaderElection(isLeader func() bool) {
+	e.isLeader = isLeader
+}
+
 // Start starts the Engine
 // Blocks forever unless Stop is called
 func (e *Engine) Start() {
@@ -84,11 +186,27 @@ func (e *Engine) Start() {
 func (e *Engine) Stop() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	e.cancel()
 	if e.kill != nil {
 		e.kill()
 	}
 }
 
+// Trigger starts a manual run of the engine in the background, regardless
+// of the time windows. It returns ErrRunning if the engine is already
+// running in this process. A run still skips its work if another Promscale
+// instance holds the vacuum lock.
+func (e *Engine) Trigger(req Request) error {
+	if !e.running.CAS(false, true) {
+		return ErrRunning
+	}
+	go func() {
+		defer e.running.Store(false)
+		e.run(e.ctx, "manual", req, nil)
+	}()
+	return nil
+}
+
 // every executes a task periodically
 // returns an execute function which when called will block
 // and execute task periodically, and a kill function which will
@@ -122,6 +240,25 @@ func every(every time.Duration, task func(ctx context.Context)) (execute, kill f
 
 // Run attempts vacuum a batch of compressed chunks
 func (e *Engine) Run(ctx context.Context) {
+	if e.isLeader != nil && !e.isLeader() {
+		log.Debug("msg", "vacuum engine is not running on this instance since it is not the leader")
+		return
+	}
+	if !e.windows.contains(time.Now()) {
+		log.Debug("msg", "vacuum engine is outside of its time windows")
+		return
+	}
+	if !e.running.CAS(false, true) {
+		log.Info("msg", "vacuum engine is already running")
+		return
+	}
+	defer e.running.Store(false)
+	e.run(ctx, "schedule", Request{}, e.windows)
+}
+
+// run vacuums the chunks selected by req. It stops between two batches when
+// the current time leaves the windows.
+func (e *Engine) run(ctx context.Context, trigger string, req Request, windows Windows) {
 	con, err := e.pool.Acquire(ctx)
 	if err != nil {
 		log.Error("msg", "failed to acquire a db connection", "error", err)
@@ -147,10 +284,33 @@ func (e *Engine) Run(ctx context.Context) {
 			log.Error("msg", "vacuum engine failed to release advisory lock", "error", err)
 		}
 	}()
+	running.WithLabelValues(trigger).Set(1)
+	defer running.WithLabelValues(trigger).Set(0)
+	defer func() { lastRun.SetToCurrentTime() }()
+
+	hypertables := req.Hypertables
+	if hypertables == nil {
+		hypertables = []string{}
+	}
+	if req.Compress && len(hypertables) > 0 {
+		chunks, err := listChunks(ctx, con, sqlListUncompressedChunks, hypertables)
+		if err != nil {
+			log.Error("msg", "failed to list chunks for compression", "error", err)
+			return
+		}
+		log.Info("msg", "chunks need to be compressed", "count", len(chunks))
+		runWorkers(ctx, e.workers(len(chunks)), chunks, e.compressWorker)
+	}
+
 	// we limit ourselves to batches of 1000 chunks
 	// since we already have the advisory lock, continue to vacuum batches as needed until none left
+	names, priorities := e.priorities.arrays()
 	for {
-		chunks, err := e.listChunks(ctx, con)
+		if !windows.contains(time.Now()) {
+			log.Info("msg", "vacuum engine stopped at the end of its time window")
+			return
+		}
+		chunks, err := listChunks(ctx, con, sqlListChunks, names, priorities, hypertables)
 		if err != nil {
 			log.Error("msg", "failed to list chunks for vacuuming", "error", err)
 			return
@@ -160,21 +320,29 @@ func (e *Engine) Run(ctx context.Context) {
 			return
 		}
 		log.Info("msg", "compressed chunks need to be vacuumed", "count", len(chunks))
-		p := e.parallelism
-		if len(chunks) < p {
-			// don't spin up more workers than we could possibly use
-			// if parallelism is 6, but we only have 5 chunks to work on, use 5 workers
-			p = len(chunks)
-		}
-		runWorkers(ctx, p, chunks, e.worker)
+		runWorkers(ctx, e.workers(len(chunks)), chunks, e.worker)
 		// in some cases, have seen it take up to 10 seconds for the stats to be updated post vacuum
-		time.Sleep(delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
 	}
 }
 
-// listChunks identifies chunks which need to be vacuumed
-func (e *Engine) listChunks(ctx context.Context, con *pgxpool.Conn) ([]string, error) {
-	rows, err := con.Query(ctx, sqlListChunks)
+// workers returns the number of workers to use for n chunks.
+func (e *Engine) workers(n int) int {
+	// don't spin up more workers than we could possibly use
+	// if parallelism is 6, but we only have 5 chunks to work on, use 5 workers
+	if n < e.parallelism {
+		return n
+	}
+	return e.parallelism
+}
+
+// listChunks lists the chunks returned by sql
+func listChunks(ctx context.Context, con *pgxpool.Conn, sql string, args ...interface{}) ([]string, error) {
+	rows, err := con.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -188,12 +356,14 @@ func (e *Engine) listChunks(ctx context.Context, con *pgxpool.Conn) ([]string, e
 		}
 		tables = append(tables, table)
 	}
-	return tables, nil
+	return tables, rows.Err()
 }
 
 // runWorkers kicks off a number of goroutines to work on the chunks in parallel
 // blocks until the workers complete
 func runWorkers(ctx context.Context, parallelism int, chunks []string, worker func(context.Context, int, <-chan string)) {
+	chunksPending.Set(float64(len(chunks)))
+	defer chunksPending.Set(0)
 	todo := make(chan string, len(chunks))
 	var wg sync.WaitGroup
 	wg.Add(parallelism)
@@ -216,9 +386,28 @@ func (e *Engine) worker(ctx context.Context, id int, todo <-chan string) {
 		log.Info("msg", "vacuuming a chunk", "worker", id, "chunk", chunk)
 		sql := fmt.Sprintf(sqlVacuumFmt, chunk)
 		_, err := e.pool.Exec(ctx, sql)
+		chunksPending.Dec()
 		if err != nil {
 			log.Error("msg", "failed to vacuum chunk", "chunk", chunk, "worker", id, "error", err)
+			chunksProcessed.WithLabelValues("vacuum", "error").Inc()
 			// don't return error here. attempt to vacuum other chunks. keep working
+			continue
+		}
+		chunksProcessed.WithLabelValues("vacuum", "success").Inc()
+	}
+}
+
+// compressWorker pulls chunks from a channel and compresses them
+func (e *Engine) compressWorker(ctx context.Context, id int, todo <-chan string) {
+	for chunk := range todo {
+		log.Info("msg", "compressing a chunk", "worker", id, "chunk", chunk)
+		_, err := e.pool.Exec(ctx, sqlCompress, chunk)
+		chunksPending.Dec()
+		if err != nil {
+			log.Error("msg", "failed to compress chunk", "chunk", chunk, "worker", id, "error", err)
+			chunksProcessed.WithLabelValues("compress", "error").Inc()
+			continue
 		}
+		chunksProcessed.WithLabelValues("compress", "success").Inc()
 	}
 }
@@ -0,0 +1,88 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout             = 10 * time.Second
+	DefaultMinInterval         = 5 * time.Minute
+	DefaultMaintenanceInterval = time.Minute
+)
+
+// Config holds the webhook flags.
+type Config struct {
+	URLs                stringList
+	Events              stringList
+	Timeout             time.Duration
+	MinInterval         time.Duration
+	MaintenanceInterval time.Duration
+}
+
+// stringList is a comma-separated list of values.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// ParseFlags registers the webhook flags.
+func ParseFlags(fs *flag.FlagSet, cfg *Config) *Config {
+	fs.Var(&cfg.URLs, "webhooks.urls", "Comma-separated list of the URLs the lifecycle events of Promscale are POSTed to as JSON. Credentials can be set in the URLs. Disabled if empty.")
+	fs.Var(&cfg.Events, "webhooks.events", "Comma-separated list of the event types sent to the webhooks, out of "+strings.Join(eventTypes, ", ")+". All the events are sent if empty.")
+	fs.DurationVar(&cfg.Timeout, "webhooks.timeout", DefaultTimeout, "Timeout of each request sent to a webhook.")
+	fs.DurationVar(&cfg.MinInterval, "webhooks.min-interval", DefaultMinInterval, "Minimum interval between two events of the same type about the same subject, e.g. the same tenant "+
+		"breaching its cardinality limit. Repeated events within the interval are not sent. 0 sends all the events.")
+	fs.DurationVar(&cfg.MaintenanceInterval, "webhooks.maintenance-check-interval", DefaultMaintenanceInterval, "Interval at which the maintenance jobs are checked for failures to send "+
+		"the "+string(MaintenanceFailure)+" events.")
+	return cfg
+}
+
+// Validate checks the webhook flags.
+func Validate(cfg *Config) error {
+	for _, u := range cfg.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid webhooks.urls URL %q: %w", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("webhooks.urls URL %q must use http or https", u)
+		}
+	}
+	for _, e := range cfg.Events {
+		if !validEventType(EventType(e)) {
+			return fmt.Errorf("invalid webhooks.events event %q, must be one of %s", e, strings.Join(eventTypes, ", "))
+		}
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("webhooks.timeout must be positive")
+	}
+	if cfg.MinInterval < 0 {
+		return fmt.Errorf("webhooks.min-interval must not be negative")
+	}
+	if cfg.MaintenanceInterval <= 0 {
+		return fmt.Errorf("webhooks.maintenance-check-interval must be positive")
+	}
+	return nil
+}
+
+// Enabled returns true if events are sent to webhooks.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.URLs) > 0
+}
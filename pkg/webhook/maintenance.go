@@ -0,0 +1,90 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/pgxconn"
+)
+
+const failedMaintenanceJobsSQL = `SELECT stats.job_id, stats.last_run_started_at, coalesce(stats.total_failures, 0)
+	FROM timescaledb_information.job_stats stats
+	INNER JOIN timescaledb_information.jobs jobs USING (job_id)
+	WHERE jobs.proc_name = 'execute_maintenance_job' AND stats.last_run_status = 'Failed'`
+
+// failedJob is a maintenance job whose last run failed.
+type failedJob struct {
+	id            int32
+	lastRunStart  time.Time
+	totalFailures int64
+}
+
+// WatchMaintenanceJobs checks the maintenance jobs every interval and emits a
+// MaintenanceFailure event for each failed run, until ctx is done.
+func WatchMaintenanceJobs(ctx context.Context, conn pgxconn.PgxConn, interval time.Duration) {
+	reported := make(map[int32]time.Time)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		jobs, err := failedMaintenanceJobs(ctx, conn)
+		if err != nil {
+			log.Warn("msg", "error checking the maintenance jobs for failures", "err", err)
+		}
+		for _, e := range newFailures(reported, jobs) {
+			Emit(e)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func failedMaintenanceJobs(ctx context.Context, conn pgxconn.PgxConn) ([]failedJob, error) {
+	rows, err := conn.Query(ctx, failedMaintenanceJobsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []failedJob
+	for rows.Next() {
+		var j failedJob
+		if err = rows.Scan(&j.id, &j.lastRunStart, &j.totalFailures); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// newFailures returns the events of the failed runs not reported yet, and
+// records them as reported.
+func newFailures(reported map[int32]time.Time, jobs []failedJob) []Event {
+	var events []Event
+	for _, j := range jobs {
+		if last, ok := reported[j.id]; ok && !j.lastRunStart.After(last) {
+			continue
+		}
+		reported[j.id] = j.lastRunStart
+		id := strconv.Itoa(int(j.id))
+		events = append(events, Event{
+			Type:    MaintenanceFailure,
+			Subject: id,
+			Message: fmt.Sprintf("maintenance job %s failed", id),
+			Details: map[string]string{
+				"job_id":              id,
+				"last_run_started_at": j.lastRunStart.UTC().Format(time.RFC3339),
+				"total_failures":      strconv.FormatInt(j.totalFailures, 10),
+			},
+		})
+	}
+	return events
+}
@@ -0,0 +1,334 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+// Package webhook sends the lifecycle events of Promscale, e.g. a new metric
+// or a failed maintenance job, to HTTP endpoints, so that they can be acted
+// upon without polling the metrics.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timescale/promscale/pkg/log"
+	"github.com/timescale/promscale/pkg/util"
+)
+
+// EventType is the type of a lifecycle event.
+type EventType string
+
+const (
+	MetricCreated            EventType = "metric_created"
+	CardinalityLimitBreached EventType = "cardinality_limit_breached"
+	MaintenanceFailure       EventType = "maintenance_failure"
+	LeaderChange             EventType = "leader_change"
+	MigrationApplied         EventType = "migration_applied"
+)
+
+var eventTypes = []string{
+	string(MetricCreated),
+	string(CardinalityLimitBreached),
+	string(MaintenanceFailure),
+	string(LeaderChange),
+	string(MigrationApplied),
+}
+
+func validEventType(t EventType) bool {
+	for _, e := range eventTypes {
+		if string(t) == e {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// queueSize is the number of events waiting to be sent before new
+	// events are dropped.
+	queueSize = 1000
+	// maxAttempts is the number of times an event is sent to a webhook
+	// before giving up.
+	maxAttempts = 3
+	// maxTrackedSubjects is the number of subjects remembered to skip
+	// repeated events before the expired ones are forgotten.
+	maxTrackedSubjects = 10000
+	// closeTimeout is how long Close waits for the queued events to be
+	// sent before dropping them.
+	closeTimeout = 10 * time.Second
+)
+
+// retryBackoff is the delay before the first retry, doubled on each retry.
+var retryBackoff = time.Second
+
+var (
+	eventsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "webhook",
+			Name:      "events_total",
+			Help:      "Total number of lifecycle events sent to the webhooks.",
+		}, []string{"type"},
+	)
+	deliveryFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "webhook",
+			Name:      "delivery_failures_total",
+			Help:      "Total number of lifecycle events that could not be delivered to a webhook after all the retries.",
+		}, []string{"type"},
+	)
+	eventsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: util.PromNamespace,
+			Subsystem: "webhook",
+			Name:      "dropped_events_total",
+			Help:      "Total number of lifecycle events dropped because too many events were waiting to be sent.",
+		}, []string{"type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventsSent, deliveryFailures, eventsDropped)
+}
+
+// Event is a lifecycle event, sent as the JSON body of the webhook requests.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Subject is what the event is about, e.g. the metric or the tenant.
+	// Repeated events of the same type about the same subject are skipped.
+	Subject string            `json:"subject"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Dispatcher sends the events to the webhooks in the background. A nil
+// Dispatcher drops all the events.
+type Dispatcher struct {
+	urls        []string
+	types       map[EventType]bool
+	minInterval time.Duration
+	client      *http.Client
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+
+	events    chan Event
+	stop      chan struct{}
+	abort     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Dispatcher sending events to the configured webhooks, or nil
+// if no webhook is configured.
+func New(cfg *Config) *Dispatcher {
+	if !cfg.Enabled() {
+		return nil
+	}
+	d := &Dispatcher{
+		urls:        cfg.URLs,
+		minInterval: cfg.MinInterval,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		lastSent:    make(map[string]time.Time),
+		events:      make(chan Event, queueSize),
+		stop:        make(chan struct{}),
+		abort:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if len(cfg.Events) > 0 {
+		d.types = make(map[EventType]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			d.types[EventType(e)] = true
+		}
+	}
+	go d.run()
+	return d
+}
+
+// Emit queues an event to be sent to the webhooks. It does not block: the
+// event is dropped if too many events are waiting to be sent.
+func (d *Dispatcher) Emit(e Event) {
+	if d == nil || (d.types != nil && !d.types[e.Type]) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if !d.shouldSend(e) {
+		return
+	}
+	select {
+	case <-d.stop:
+	case d.events <- e:
+	default:
+		eventsDropped.WithLabelValues(string(e.Type)).Inc()
+		log.Warn("msg", "dropping webhook event, too many events waiting to be sent", "type", e.Type, "subject", e.Subject)
+	}
+}
+
+// shouldSend returns false if an event of the same type about the same
+// subject was sent less than the minimum interval ago.
+func (d *Dispatcher) shouldSend(e Event) bool {
+	if d.minInterval == 0 {
+		return true
+	}
+	key := string(e.Type) + "/" + e.Subject
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.lastSent[key]; ok && e.Time.Sub(last) < d.minInterval {
+		return false
+	}
+	if len(d.lastSent) >= maxTrackedSubjects {
+		for k, last := range d.lastSent {
+			if e.Time.Sub(last) >= d.minInterval {
+				delete(d.lastSent, k)
+			}
+		}
+	}
+	d.lastSent[key] = e.Time
+	return true
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for {
+		select {
+		case e := <-d.events:
+			d.send(e)
+		case <-d.stop:
+			// Send the events queued before stopping, until Close gives up.
+			for {
+				select {
+				case <-d.abort:
+					return
+				default:
+				}
+				select {
+				case e := <-d.events:
+					d.send(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) send(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Error("msg", "error encoding webhook event", "type", e.Type, "err", err)
+		return
+	}
+	eventsSent.WithLabelValues(string(e.Type)).Inc()
+	for _, u := range d.urls {
+		if err = d.post(u, body); err != nil {
+			deliveryFailures.WithLabelValues(string(e.Type)).Inc()
+			log.Warn("msg", "error sending webhook event", "type", e.Type, "subject", e.Subject, "url", redact(u), "err", err)
+		}
+	}
+}
+
+// post sends the body to the url, retrying on errors.
+func (d *Dispatcher) post(u string, body []byte) error {
+	var err error
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		if err = d.postOnce(u, body); err == nil || attempt == maxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-d.abort:
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) postOnce(u string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// redact hides the password of a webhook URL in the logs.
+func redact(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// Close sends the queued events and stops the Dispatcher. The events not
+// sent within closeTimeout are dropped.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.closeOnce.Do(func() {
+		close(d.stop)
+		select {
+		case <-d.done:
+		case <-time.After(closeTimeout):
+			close(d.abort)
+			<-d.done
+		}
+	})
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Dispatcher
+)
+
+// Init sets up the Dispatcher used by Emit. Events emitted before Init are
+// dropped.
+func Init(cfg *Config) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = New(cfg)
+}
+
+// Emit queues an event to be sent to the webhooks configured with Init.
+func Emit(e Event) {
+	globalMu.RLock()
+	d := global
+	globalMu.RUnlock()
+	d.Emit(e)
+}
+
+// Close stops the Dispatcher set up by Init.
+func Close() {
+	globalMu.Lock()
+	d := global
+	global = nil
+	globalMu.Unlock()
+	d.Close()
+}
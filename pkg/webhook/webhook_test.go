@@ -0,0 +1,120 @@
+// This file and its contents are licensed under the Apache License 2.0.
+// Please see the included NOTICE for copyright information and
+// LICENSE for a copy of the license.
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the events POSTed to it, failing the first failures
+// requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	events   []Event
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var e Event
+	if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, e)
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{Timeout: DefaultTimeout, MaintenanceInterval: DefaultMaintenanceInterval}
+	require.NoError(t, Validate(&valid))
+
+	cfg := valid
+	cfg.URLs = stringList{"ftp://example.com"}
+	require.Error(t, Validate(&cfg))
+
+	cfg = valid
+	cfg.Events = stringList{string(MetricCreated), "unknown"}
+	require.Error(t, Validate(&cfg))
+
+	cfg = valid
+	cfg.MinInterval = -time.Second
+	require.Error(t, Validate(&cfg))
+
+	// A nil Dispatcher drops the events.
+	d := New(&valid)
+	require.Nil(t, d)
+	d.Emit(Event{Type: MetricCreated})
+	d.Close()
+}
+
+func TestDispatcher(t *testing.T) {
+	oldBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = oldBackoff }()
+
+	r := &receiver{failures: 1}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := New(&Config{
+		URLs:        stringList{srv.URL},
+		Events:      stringList{string(MetricCreated), string(LeaderChange)},
+		Timeout:     time.Second,
+		MinInterval: time.Minute,
+	})
+	require.NotNil(t, d)
+
+	now := time.Now()
+	d.Emit(Event{Type: MetricCreated, Time: now, Subject: "up", Details: map[string]string{"metric": "up"}})
+	// Repeated within the minimum interval.
+	d.Emit(Event{Type: MetricCreated, Time: now.Add(time.Second), Subject: "up"})
+	d.Emit(Event{Type: MetricCreated, Time: now, Subject: "go_goroutines"})
+	// Not one of the configured events.
+	d.Emit(Event{Type: MigrationApplied, Time: now, Subject: "0.1.0"})
+	d.Emit(Event{Type: LeaderChange, Time: now.Add(2 * time.Minute), Subject: "up"})
+	d.Close()
+
+	require.Len(t, r.events, 3)
+	require.Equal(t, MetricCreated, r.events[0].Type)
+	require.Equal(t, "up", r.events[0].Subject)
+	require.Equal(t, map[string]string{"metric": "up"}, r.events[0].Details)
+	require.True(t, now.Equal(r.events[0].Time))
+	require.Equal(t, "go_goroutines", r.events[1].Subject)
+	require.Equal(t, LeaderChange, r.events[2].Type)
+}
+
+func TestNewFailures(t *testing.T) {
+	reported := make(map[int32]time.Time)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []failedJob{{id: 1000, lastRunStart: start, totalFailures: 1}}
+
+	events := newFailures(reported, jobs)
+	require.Len(t, events, 1)
+	require.Equal(t, MaintenanceFailure, events[0].Type)
+	require.Equal(t, "1000", events[0].Subject)
+	require.Equal(t, "1", events[0].Details["total_failures"])
+
+	// The same run is reported once.
+	require.Empty(t, newFailures(reported, jobs))
+
+	jobs[0].lastRunStart = start.Add(time.Hour)
+	jobs[0].totalFailures = 2
+	events = newFailures(reported, jobs)
+	require.Len(t, events, 1)
+	require.Equal(t, "2", events[0].Details["total_failures"])
+}